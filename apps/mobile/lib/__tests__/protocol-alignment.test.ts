@@ -2,58 +2,139 @@
  * Protocol Alignment Tests
  *
  * These tests verify that TypeScript protocol definitions match Go definitions.
- * The expected values here are copied from Go constants and must match exactly.
+ * The expected values here are copied from Go constants and json tags in
+ * services/bridge/internal/protocol/ and must match exactly; the Go side is
+ * checked by alignment_test.go, which lists the same values.
  *
  * Run with: npm run test:alignment
  */
 
-// ============================================================================
-// Message Types (must match Go constants in services/bridge/internal/protocol/messages.go)
-// ============================================================================
-
-const MessageTypes = {
-  // Authentication
-  AUTH: 'auth',
-  AUTH_RESULT: 'auth_result',
-
-  // Host Management
-  HOST_CONNECT: 'host_connect',
-  HOST_DISCONNECT: 'host_disconnect',
-  HOST_STATUS: 'host_status',
-
-  // Process Management
-  PROCESS_LIST: 'process_list',
-  PROCESS_LIST_RESULT: 'process_list_result',
-  PROCESS_CREATE: 'process_create',
-  PROCESS_CREATED: 'process_created',
-  PROCESS_SELECT: 'process_select',
-  PROCESS_KILL: 'process_kill',
-  PROCESS_KILLED: 'process_killed',
-  PROCESS_UPDATED: 'process_updated',
-
-  // Claude Conversion
-  CLAUDE_START: 'claude_start',
-  CLAUDE_KILL: 'claude_kill',
-
-  // PTY (Terminal)
-  PTY_INPUT: 'pty_input',
-  PTY_OUTPUT: 'pty_output',
-  PTY_RESIZE: 'pty_resize',
-
-  // Chat (AgentAPI)
-  CHAT_SUBSCRIBE: 'chat_subscribe',
-  CHAT_UNSUBSCRIBE: 'chat_unsubscribe',
-  CHAT_SEND: 'chat_send',
-  CHAT_RAW: 'chat_raw',
-  CHAT_EVENT: 'chat_event',
-  CHAT_STATUS: 'chat_status',
-  CHAT_STATUS_RESULT: 'chat_status_result',
-  CHAT_HISTORY: 'chat_history',
-  CHAT_MESSAGES: 'chat_messages',
-
-  // Error
-  ERROR: 'error',
-} as const;
+import {
+  MessageTypes,
+  ErrorCode,
+  ProcessType,
+  ArchivedProcess,
+  ArchivedProcessDeletePayload,
+  ArchivedProcessDeleteResultPayload,
+  ArchivedProcessGetPayload,
+  ArchivedProcessGetResultPayload,
+  ArchivedProcessListPayload,
+  ArchivedProcessListResultPayload,
+  AuthPayload,
+  AuthResultPayload,
+  BridgeInfoResultPayload,
+  BridgeUpdateStatusPayload,
+  ChatDraft,
+  ChatDraftGetPayload,
+  ChatDraftResultPayload,
+  ChatDraftSetPayload,
+  ChatEventPayload,
+  ChatMessage,
+  ChatModelUsage,
+  ChatRawPayload,
+  ChatSearchMatch,
+  ChatSearchPayload,
+  ChatSearchProcessResult,
+  ChatSendPayload,
+  ChatSendResultPayload,
+  ChatSubscribeResultPayload,
+  ChatUsage,
+  ChatUsagePayload,
+  ChatUsageResultPayload,
+  ClaudeReattachTerminalPayload,
+  ConfirmationChallengePayload,
+  ConnectedSessionInfo,
+  EnvConflict,
+  EnvRevealResultPayload,
+  EnvUpdatePayload,
+  EnvVar,
+  EnvVarChange,
+  FileDownloadCancelPayload,
+  FileDownloadChunkPayload,
+  FileDownloadCompletePayload,
+  FileDownloadPayload,
+  FileUploadBeginPayload,
+  FileUploadChunkPayload,
+  FileUploadCommitPayload,
+  FileUploadResultPayload,
+  HostConfigImportSSHConfigPayload,
+  HostConfigImportSSHConfigResultPayload,
+  HostConnectPayload,
+  HostConnectProgressPayload,
+  HostDiagnosticsPayload,
+  HostDiagnosticsResultPayload,
+  HostDiagnosticsSample,
+  HostExecPayload,
+  HostExecResultPayload,
+  HostKeepaliveStatus,
+  HostPlatform,
+  HostStatusPayload,
+  HostStatusRequestPayload,
+  HostWarning,
+  ProcessAddedPayload,
+  ProcessAlertPayload,
+  ProcessCWDChangedPayload,
+  ProcessClearErrorPayload,
+  ProcessClonePayload,
+  ProcessCreatePayload,
+  ProcessCreatedPayload,
+  ProcessEnableTimelinePayload,
+  ProcessEnvListPayload,
+  ProcessEnvResultPayload,
+  ProcessError,
+  ProcessInfo,
+  ProcessKillPayload,
+  ProcessKilledPayload,
+  ProcessListPayload,
+  ProcessPinPayload,
+  ProcessRemovedPayload,
+  ProcessSetAppearancePayload,
+  ProcessSetOrderPayload,
+  ProcessStats,
+  ProcessTermOptionsPayload,
+  ProcessTimelineListPayload,
+  ProcessTimelineListResultPayload,
+  ProcessUpdatedPayload,
+  ProfileListResultPayload,
+  PtyFailureDetails,
+  PtyHistoryChunkPayload,
+  PtyHistoryCompletePayload,
+  PtyHistoryRequestPayload,
+  PtyHistoryResponsePayload,
+  PtyHistoryTransferStats,
+  PtyInputPayload,
+  PtyOutputOverride,
+  PtyOutputPayload,
+  PtyOutputSettings,
+  PtySnapshotPayload,
+  ReattachReport,
+  SSHChannelUsage,
+  SSHConfigEntry,
+  SSHHostConfig,
+  SessionInfoResultPayload,
+  SessionInvite,
+  SessionInviteCreatePayload,
+  SessionInviteCreateResultPayload,
+  SessionInviteRevokeResultPayload,
+  SessionLatency,
+  SessionRefreshTokenPayload,
+  SessionRefreshTokenResultPayload,
+  SnippetExecutePayload,
+  SnippetExecuteResultPayload,
+  SnippetTargetResult,
+  StaleProcessesChangedPayload,
+  StorageEncryptProgressPayload,
+  StorageEncryptResultPayload,
+  StorageFlushPayload,
+  StorageFlushResultPayload,
+  TextRange,
+  TimelineCommand,
+  UnmanagedSession,
+} from '@remote-claude/shared-types';
+
+// fields lists a payload's JSON field names; each must be a field of T, so a
+// name Go uses that the TypeScript interface lacks fails to compile
+const fields = <T>(...names: Array<keyof T & string>): string[] => names;
 
 // ============================================================================
 // Tests
@@ -63,11 +144,44 @@ describe('Protocol Alignment Tests', () => {
   describe('Message Type Constants', () => {
     // These are the expected values from Go - they must match TypeScript constants exactly
     const expectedGoTypes: Record<string, string> = {
+      // Authentication
       AUTH: 'auth',
       AUTH_RESULT: 'auth_result',
+      SESSION_REFRESH_TOKEN: 'session_refresh_token',
+      SESSION_REFRESH_TOKEN_RESULT: 'session_refresh_token_result',
+      SESSION_INVITE_CREATE: 'session_invite_create',
+      SESSION_INVITE_CREATE_RESULT: 'session_invite_create_result',
+      SESSION_INVITE_REVOKE: 'session_invite_revoke',
+      SESSION_INVITE_REVOKE_RESULT: 'session_invite_revoke_result',
+      SESSION_INFO: 'session_info',
+      SESSION_INFO_RESULT: 'session_info_result',
+
+      // Host Configuration (CRUD - stored in bridge)
+      HOST_CONFIG_LIST: 'host_config_list',
+      HOST_CONFIG_LIST_RESULT: 'host_config_list_result',
+      HOST_CONFIG_CREATE: 'host_config_create',
+      HOST_CONFIG_CREATE_RESULT: 'host_config_create_result',
+      HOST_CONFIG_UPDATE: 'host_config_update',
+      HOST_CONFIG_UPDATE_RESULT: 'host_config_update_result',
+      HOST_CONFIG_DELETE: 'host_config_delete',
+      HOST_CONFIG_DELETE_RESULT: 'host_config_delete_result',
+      HOST_CONFIG_IMPORT_SSHCONFIG: 'host_config_import_sshconfig',
+      HOST_CONFIG_IMPORT_SSHCONFIG_RESULT: 'host_config_import_sshconfig_result',
+
+      // Host Connection (runtime)
       HOST_CONNECT: 'host_connect',
       HOST_DISCONNECT: 'host_disconnect',
       HOST_STATUS: 'host_status',
+      HOST_STATUS_REQUEST: 'host_status_request',
+      HOST_CHECK_REQUIREMENTS: 'host_check_requirements',
+      HOST_REQUIREMENTS_RESULT: 'host_requirements_result',
+      HOST_CONNECT_PROGRESS: 'host_connect_progress',
+      HOST_EXEC: 'host_exec',
+      HOST_EXEC_RESULT: 'host_exec_result',
+      HOST_DIAGNOSTICS: 'host_diagnostics',
+      HOST_DIAGNOSTICS_RESULT: 'host_diagnostics_result',
+
+      // Process Management
       PROCESS_LIST: 'process_list',
       PROCESS_LIST_RESULT: 'process_list_result',
       PROCESS_CREATE: 'process_create',
@@ -76,20 +190,172 @@ describe('Protocol Alignment Tests', () => {
       PROCESS_KILL: 'process_kill',
       PROCESS_KILLED: 'process_killed',
       PROCESS_UPDATED: 'process_updated',
+      PROCESS_REATTACH: 'process_reattach',
+      PROCESS_RENAME: 'process_rename',
+      PROCESS_CLONE: 'process_clone',
+      PROCESS_PIN: 'process_pin',
+      PROCESS_SET_ORDER: 'process_set_order',
+      PROCESS_TERM_OPTIONS: 'process_term_options',
+      PROCESS_CLEAR_ERROR: 'process_clear_error',
+      PROCESS_SET_APPEARANCE: 'process_set_appearance',
+
+      // Changes to a host's process list between host_status snapshots
+      PROCESS_ADDED: 'process_added',
+      PROCESS_REMOVED: 'process_removed',
+      STALE_PROCESSES_CHANGED: 'stale_processes_changed',
+      PROCESS_CWD_CHANGED: 'process_cwd_changed',
+
+      // Command timeline
+      PROCESS_ENABLE_TIMELINE: 'process_enable_timeline',
+      PROCESS_TIMELINE_LIST: 'process_timeline_list',
+      PROCESS_TIMELINE_LIST_RESULT: 'process_timeline_list_result',
+
+      // Archived processes, killed with their history kept
+      ARCHIVED_PROCESS_LIST: 'archived_process_list',
+      ARCHIVED_PROCESS_LIST_RESULT: 'archived_process_list_result',
+      ARCHIVED_PROCESS_GET: 'archived_process_get',
+      ARCHIVED_PROCESS_GET_RESULT: 'archived_process_get_result',
+      ARCHIVED_PROCESS_DELETE: 'archived_process_delete',
+      ARCHIVED_PROCESS_DELETE_RESULT: 'archived_process_delete_result',
+
+      // Process state pushes
+      PROCESSES_SUBSCRIBE: 'processes_subscribe',
+      PROCESSES_UNSUBSCRIBE: 'processes_unsubscribe',
+      PROCESS_ALERT: 'process_alert',
+
+      // Claude Conversion
       CLAUDE_START: 'claude_start',
       CLAUDE_KILL: 'claude_kill',
+      CLAUDE_REATTACH_TERMINAL: 'claude_reattach_terminal',
+
+      // PTY (Terminal)
       PTY_INPUT: 'pty_input',
       PTY_OUTPUT: 'pty_output',
       PTY_RESIZE: 'pty_resize',
+      PTY_SNAPSHOT: 'pty_snapshot',
+
+      // PTY Scrollback (tmux copy mode)
+      PTY_SCROLL: 'pty_scroll',
+      PTY_SCROLL_STATE: 'pty_scroll_state',
+
+      // PTY History
+      PTY_HISTORY_REQUEST: 'pty_history_request',
+      PTY_HISTORY_RESPONSE: 'pty_history_response',
+      PTY_HISTORY_CHUNK: 'pty_history_chunk',
+      PTY_HISTORY_COMPLETE: 'pty_history_complete',
+
+      // Chat (AgentAPI)
       CHAT_SUBSCRIBE: 'chat_subscribe',
+      CHAT_SUBSCRIBE_RESULT: 'chat_subscribe_result',
       CHAT_UNSUBSCRIBE: 'chat_unsubscribe',
       CHAT_SEND: 'chat_send',
+      CHAT_SEND_RESULT: 'chat_send_result',
       CHAT_RAW: 'chat_raw',
       CHAT_EVENT: 'chat_event',
       CHAT_STATUS: 'chat_status',
       CHAT_STATUS_RESULT: 'chat_status_result',
       CHAT_HISTORY: 'chat_history',
       CHAT_MESSAGES: 'chat_messages',
+      CHAT_SEARCH: 'chat_search',
+      CHAT_SEARCH_RESULT: 'chat_search_result',
+      CHAT_USAGE: 'chat_usage',
+      CHAT_USAGE_RESULT: 'chat_usage_result',
+      CHAT_DRAFT_SET: 'chat_draft_set',
+      CHAT_DRAFT_GET: 'chat_draft_get',
+      CHAT_DRAFT_RESULT: 'chat_draft_result',
+
+      // Environment Variables - Host Level
+      ENV_LIST: 'env_list',
+      ENV_UPDATE: 'env_update',
+      ENV_RESULT: 'env_result',
+      ENV_SET_RC_FILE: 'env_set_rc_file',
+      ENV_REVEAL: 'env_reveal',
+      ENV_REVEAL_RESULT: 'env_reveal_result',
+
+      // Environment Variables - Process Level
+      PROCESS_ENV_LIST: 'process_env_list',
+      PROCESS_ENV_RESULT: 'process_env_result',
+
+      // Ports Scanning
+      PORTS_SCAN: 'ports_scan',
+      PORTS_RESULT: 'ports_result',
+
+      // File downloads from hosts
+      FILE_DOWNLOAD: 'file_download',
+      FILE_DOWNLOAD_CHUNK: 'file_download_chunk',
+      FILE_DOWNLOAD_COMPLETE: 'file_download_complete',
+      FILE_DOWNLOAD_CANCEL: 'file_download_cancel',
+
+      // File uploads to hosts
+      FILE_UPLOAD_BEGIN: 'file_upload_begin',
+      FILE_UPLOAD_CHUNK: 'file_upload_chunk',
+      FILE_UPLOAD_COMMIT: 'file_upload_commit',
+      FILE_UPLOAD_RESULT: 'file_upload_result',
+
+      // Snippets (global, unrelated to hosts/processes)
+      SNIPPET_LIST: 'snippet_list',
+      SNIPPET_LIST_RESULT: 'snippet_list_result',
+      SNIPPET_CREATE: 'snippet_create',
+      SNIPPET_CREATE_RESULT: 'snippet_create_result',
+      SNIPPET_UPDATE: 'snippet_update',
+      SNIPPET_UPDATE_RESULT: 'snippet_update_result',
+      SNIPPET_DELETE: 'snippet_delete',
+      SNIPPET_DELETE_RESULT: 'snippet_delete_result',
+
+      // Typing a snippet into processes' terminals
+      SNIPPET_EXECUTE: 'snippet_execute',
+      SNIPPET_EXECUTE_RESULT: 'snippet_execute_result',
+
+      // Workspaces (process groups that may span hosts)
+      WORKSPACE_LIST: 'workspace_list',
+      WORKSPACE_LIST_RESULT: 'workspace_list_result',
+      WORKSPACE_CREATE: 'workspace_create',
+      WORKSPACE_CREATE_RESULT: 'workspace_create_result',
+      WORKSPACE_UPDATE: 'workspace_update',
+      WORKSPACE_UPDATE_RESULT: 'workspace_update_result',
+      WORKSPACE_DELETE: 'workspace_delete',
+      WORKSPACE_DELETE_RESULT: 'workspace_delete_result',
+      WORKSPACE_ASSIGN: 'workspace_assign',
+      WORKSPACE_ASSIGN_RESULT: 'workspace_assign_result',
+
+      // Process templates (saved recipes for creating a process)
+      PROCESS_TEMPLATE_LIST: 'process_template_list',
+      PROCESS_TEMPLATE_LIST_RESULT: 'process_template_list_result',
+      PROCESS_TEMPLATE_CREATE: 'process_template_create',
+      PROCESS_TEMPLATE_CREATE_RESULT: 'process_template_create_result',
+      PROCESS_TEMPLATE_UPDATE: 'process_template_update',
+      PROCESS_TEMPLATE_UPDATE_RESULT: 'process_template_update_result',
+      PROCESS_TEMPLATE_DELETE: 'process_template_delete',
+      PROCESS_TEMPLATE_DELETE_RESULT: 'process_template_delete_result',
+      PROCESS_CREATE_FROM_TEMPLATE: 'process_create_from_template',
+      PROCESS_CREATE_FROM_TEMPLATE_RESULT: 'process_create_from_template_result',
+
+      // Bridge diagnostics
+      BRIDGE_INFO: 'bridge_info',
+      BRIDGE_INFO_RESULT: 'bridge_info_result',
+
+      // Bridge update check (see --update-check)
+      BRIDGE_UPDATE_CHECK: 'bridge_update_check',
+      BRIDGE_UPDATE_CHECK_RESULT: 'bridge_update_check_result',
+      BRIDGE_UPDATE_AVAILABLE: 'bridge_update_available',
+
+      // Data profiles (read-only; the profile is chosen at bridge startup)
+      PROFILE_LIST: 'profile_list',
+      PROFILE_LIST_RESULT: 'profile_list_result',
+
+      // History encryption at rest (see --encrypt-history)
+      STORAGE_ENCRYPT_NOW: 'storage_encrypt_now',
+      STORAGE_ENCRYPT_PROGRESS: 'storage_encrypt_progress',
+      STORAGE_ENCRYPT_RESULT: 'storage_encrypt_result',
+
+      // Saving buffered history now rather than at the next persist
+      STORAGE_FLUSH: 'storage_flush',
+      STORAGE_FLUSH_RESULT: 'storage_flush_result',
+
+      // Confirmation of destructive requests
+      CONFIRMATION_CHALLENGE: 'confirmation_challenge',
+
+      // Error
       ERROR: 'error',
     };
 
@@ -109,123 +375,308 @@ describe('Protocol Alignment Tests', () => {
   });
 
   describe('Payload JSON Field Names', () => {
-    // These tests verify that when TypeScript payloads are serialized to JSON,
-    // they use the same field names as Go struct json tags
-
-    test('AuthPayload should have correct JSON field names', () => {
-      const payload = {
-        reconnectToken: 'test-token',
-      };
-
-      const json = JSON.stringify(payload);
-      const parsed = JSON.parse(json);
-
-      expect(parsed).toHaveProperty('reconnectToken');
-    });
-
-    test('AuthResultPayload should have correct JSON field names', () => {
-      const payload = {
-        success: true,
-        sessionId: 'session-123',
-        reconnectToken: 'test-token',
-        reconnected: false,
-      };
-
-      const json = JSON.stringify(payload);
-      const parsed = JSON.parse(json);
-
-      expect(parsed).toHaveProperty('success');
-      expect(parsed).toHaveProperty('sessionId');
-      expect(parsed).toHaveProperty('reconnectToken');
-      expect(parsed).toHaveProperty('reconnected');
-    });
-
-    test('ProcessInfo should have correct JSON field names', () => {
-      const payload = {
-        id: 'test-id',
-        type: 'shell' as const,
-        hostId: 'host-id',
-        cwd: '/home',
-        ptyReady: true,
-        agentApiReady: false,
-        startedAt: '2024-01-01T00:00:00Z',
-      };
-
-      const json = JSON.stringify(payload);
-      const parsed = JSON.parse(json);
-
-      // These field names must match Go struct json tags
-      expect(parsed).toHaveProperty('id');
-      expect(parsed).toHaveProperty('type');
-      expect(parsed).toHaveProperty('hostId');
-      expect(parsed).toHaveProperty('cwd');
-      expect(parsed).toHaveProperty('ptyReady');
-      expect(parsed).toHaveProperty('agentApiReady');
-      expect(parsed).toHaveProperty('startedAt');
+    // Field names of Go struct json tags, as listed in alignment_test.go's
+    // TestPayloadJSONFieldAlignment; fields<T> checks each against the
+    // TypeScript interface of the same name
+    const payloadFields: Array<[string, string[]]> = [
+    ['AuthPayload', fields<AuthPayload>(
+      'protocolVersion', 'reconnectToken', 'compression', 'clientTimestamp', 'locale',
+      'capabilities'
+    )],
+    ['AuthResultPayload', fields<AuthResultPayload>(
+      'success', 'sessionId', 'reconnectToken', 'tokenExpiresAt', 'reconnected', 'serverVersion',
+      'protocolVersion', 'profile', 'serverTimestamp', 'clockSkewMs', 'locale', 'role', 'scope',
+      'capabilities'
+    )],
+    ['SessionRefreshTokenPayload', fields<SessionRefreshTokenPayload>('reconnectToken')],
+    ['SessionRefreshTokenResultPayload', fields<SessionRefreshTokenResultPayload>(
+      'success', 'reconnectToken', 'tokenExpiresAt'
+    )],
+    ['SessionInviteCreatePayload', fields<SessionInviteCreatePayload>(
+      'role', 'hostId', 'processId', 'expiresInSeconds', 'label'
+    )],
+    ['SessionInvite', fields<SessionInvite>(
+      'id', 'role', 'hostId', 'processId', 'label', 'createdAt', 'expiresAt'
+    )],
+    ['SessionInviteCreateResultPayload', fields<SessionInviteCreateResultPayload>(
+      'success', 'invite', 'token'
+    )],
+    ['SessionInviteRevokeResultPayload', fields<SessionInviteRevokeResultPayload>(
+      'success', 'id', 'disconnected'
+    )],
+    ['SessionInfoResultPayload', fields<SessionInfoResultPayload>('session')],
+    ['ConnectedSessionInfo', fields<ConnectedSessionInfo>(
+      'id', 'deviceLabel', 'role', 'createdAt', 'latency'
+    )],
+    ['SessionLatency', fields<SessionLatency>(
+      'rttMs', 'lastRttMs', 'minRttMs', 'samples', 'measuredAt'
+    )],
+    ['PtyOutputPayload', fields<PtyOutputPayload>('processId', 'data')],
+    ['ChatEventPayload', fields<ChatEventPayload>('hostId', 'processId', 'event', 'data')],
+    ['ProcessInfo', fields<ProcessInfo>(
+      'id', 'type', 'hostId', 'cwd', 'ptyReady', 'agentApiReady', 'startedAt', 'pinned',
+      'sortWeight', 'termOptions', 'timeline', 'exited', 'lastError', 'color', 'icon', 'stats',
+      'startupHooks'
+    )],
+    ['ProcessError', fields<ProcessError>('operation', 'code', 'message', 'at')],
+    ['ProcessStats', fields<ProcessStats>(
+      'ptyOutputBytes', 'ptyInputBytes', 'chatEventBytes', 'historyBytes'
+    )],
+    ['ProcessListPayload', fields<ProcessListPayload>('hostId', 'listLight', 'includeStats')],
+    ['HostConfigImportSSHConfigPayload', fields<HostConfigImportSSHConfigPayload>(
+      'configText', 'select', 'readIdentityFiles'
+    )],
+    ['HostConfigImportSSHConfigResultPayload', fields<HostConfigImportSSHConfigResultPayload>(
+      'success', 'entries', 'created', 'failed'
+    )],
+    ['SSHHostConfig', fields<SSHHostConfig>(
+      'id', 'name', 'host', 'port', 'username', 'authType', 'credentialBackend', 'autoConnect',
+      'tmuxSocketPath', 'tmuxCommand', 'maxConcurrentOps', 'color', 'icon', 'createdAt',
+      'updatedAt', 'startupHooks'
+    )],
+    ['SSHConfigEntry', fields<SSHConfigEntry>(
+      'alias', 'hostName', 'user', 'port', 'authType', 'identityFile', 'proxyJump', 'exists'
+    )],
+    ['HostWarning', fields<HostWarning>('code', 'message', 'duplicateOf')],
+    ['ChatSearchPayload', fields<ChatSearchPayload>('query', 'hostId', 'role', 'limit')],
+    ['ChatSearchProcessResult', fields<ChatSearchProcessResult>(
+      'processId', 'hostId', 'processName', 'matchCount', 'matches'
+    )],
+    ['ChatSearchMatch', fields<ChatSearchMatch>(
+      'messageId', 'role', 'time', 'snippet', 'highlights'
+    )],
+    ['HostExecPayload', fields<HostExecPayload>(
+      'requestId', 'hostId', 'command', 'timeoutMs', 'cwd'
+    )],
+    ['HostExecResultPayload', fields<HostExecResultPayload>(
+      'requestId', 'hostId', 'command', 'exitCode', 'timedOut', 'durationMs', 'stdout', 'stderr',
+      'stdoutTruncated', 'stderrTruncated'
+    )],
+    ['SnippetExecutePayload', fields<SnippetExecutePayload>(
+      'requestId', 'snippetId', 'processId', 'processIds', 'workspaceId', 'variables', 'mode'
+    )],
+    ['SnippetExecuteResultPayload', fields<SnippetExecuteResultPayload>(
+      'snippetId', 'mode', 'results'
+    )],
+    ['SnippetTargetResult', fields<SnippetTargetResult>(
+      'processId', 'hostId', 'success', 'code', 'error', 'suggestedAction'
+    )],
+    ['FileDownloadPayload', fields<FileDownloadPayload>(
+      'downloadId', 'hostId', 'path', 'processId'
+    )],
+    ['FileDownloadChunkPayload', fields<FileDownloadChunkPayload>(
+      'downloadId', 'seq', 'data', 'totalSize'
+    )],
+    ['FileDownloadCompletePayload', fields<FileDownloadCompletePayload>(
+      'downloadId', 'hostId', 'path', 'resolvedPath', 'symlink', 'success', 'cancelled', 'code', 'error',
+      'size', 'chunks', 'sha256'
+    )],
+    ['FileDownloadCancelPayload', fields<FileDownloadCancelPayload>('downloadId')],
+    ['FileUploadBeginPayload', fields<FileUploadBeginPayload>(
+      'uploadId', 'hostId', 'path', 'processId', 'size', 'sha256', 'mode', 'overwrite'
+    )],
+    ['FileUploadChunkPayload', fields<FileUploadChunkPayload>('uploadId', 'seq', 'data')],
+    ['FileUploadCommitPayload', fields<FileUploadCommitPayload>('uploadId')],
+    ['FileUploadResultPayload', fields<FileUploadResultPayload>(
+      'uploadId', 'hostId', 'path', 'resolvedPath', 'size', 'mode', 'replaced'
+    )],
+    ['HostDiagnosticsPayload', fields<HostDiagnosticsPayload>('hostId', 'record')],
+    ['HostDiagnosticsSample', fields<HostDiagnosticsSample>(
+      'at', 'rttMs', 'rttMinMs', 'rttAvgMs', 'rttMaxMs', 'throughputBytes', 'throughputMs',
+      'throughputBytesPerSec'
+    )],
+    ['HostKeepaliveStatus', fields<HostKeepaliveStatus>(
+      'intervalSeconds', 'lastOkAt', 'lastFailure', 'lastFailureAt'
+    )],
+    ['HostDiagnosticsResultPayload', fields<HostDiagnosticsResultPayload>(
+      'hostId', 'success', 'connectedAt', 'connectionAgeSeconds', 'keepalive', 'channels', 'ops',
+      'history'
+    )],
+    ['HostStatusPayload', fields<HostStatusPayload>(
+      'hostId', 'connected', 'processes', 'rebootDetected', 'bootTime', 'previousBootTime',
+      'platform'
+    )],
+    ['HostPlatform', fields<HostPlatform>(
+      'os', 'arch', 'release', 'shell', 'tmuxVersion', 'hasProc'
+    )],
+    ['ReattachReport', fields<ReattachReport>('reattached', 'retried', 'failed')],
+    ['ProcessEnvListPayload', fields<ProcessEnvListPayload>('processId', 'mode')],
+    ['EnvUpdatePayload', fields<EnvUpdatePayload>(
+      'hostId', 'customVars', 'dryRun', 'allowOverride', 'force'
+    )],
+    ['EnvConflict', fields<EnvConflict>('key', 'systemValue', 'isMasked', 'critical', 'allowed')],
+    ['ProcessEnvResultPayload', fields<ProcessEnvResultPayload>(
+      'processId', 'mode', 'vars', 'diff', 'code', 'error', 'retryAfterMs'
+    )],
+    ['EnvVarChange', fields<EnvVarChange>('key', 'old', 'new', 'isMasked')],
+    ['ChatUsagePayload', fields<ChatUsagePayload>('processId')],
+    ['ChatUsageResultPayload', fields<ChatUsageResultPayload>(
+      'processId', 'usageAvailable', 'source', 'updatedAt', 'usage'
+    )],
+    ['ChatUsage', fields<ChatUsage>(
+      'inputTokens', 'outputTokens', 'cacheCreationInputTokens', 'cacheReadInputTokens',
+      'messageCount', 'estimatedCostUsd', 'models'
+    )],
+    ['ChatModelUsage', fields<ChatModelUsage>(
+      'model', 'inputTokens', 'outputTokens', 'cacheCreationInputTokens', 'cacheReadInputTokens',
+      'messageCount', 'estimatedCostUsd'
+    )],
+    ['ProcessKillPayload', fields<ProcessKillPayload>(
+      'processId', 'keepHistory', 'confirmRequired', 'confirmToken'
+    )],
+    ['ProcessKilledPayload', fields<ProcessKilledPayload>('processId', 'archived')],
+    ['ArchivedProcess', fields<ArchivedProcess>(
+      'id', 'hostId', 'type', 'name', 'cwd', 'startedAt', 'archivedAt', 'ptyHistorySize',
+      'chatMessageCount'
+    )],
+    ['ArchivedProcessListPayload', fields<ArchivedProcessListPayload>('hostId')],
+    ['ArchivedProcessListResultPayload', fields<ArchivedProcessListResultPayload>(
+      'processes', 'code', 'error'
+    )],
+    ['HostStatusRequestPayload', fields<HostStatusRequestPayload>('hostId')],
+    ['ProcessAddedPayload', fields<ProcessAddedPayload>('hostId', 'process', 'metadataDiscarded')],
+    ['ProcessRemovedPayload', fields<ProcessRemovedPayload>('hostId', 'processId', 'reason')],
+    ['StaleProcessesChangedPayload', fields<StaleProcessesChangedPayload>(
+      'hostId', 'staleProcesses'
+    )],
+    ['ProcessCWDChangedPayload', fields<ProcessCWDChangedPayload>('processId', 'cwd')],
+    ['ArchivedProcessGetPayload', fields<ArchivedProcessGetPayload>('processId')],
+    ['ArchivedProcessGetResultPayload', fields<ArchivedProcessGetResultPayload>('process')],
+    ['ArchivedProcessDeletePayload', fields<ArchivedProcessDeletePayload>('processId')],
+    ['ArchivedProcessDeleteResultPayload', fields<ArchivedProcessDeleteResultPayload>(
+      'success', 'id', 'code', 'error'
+    )],
+    ['ConfirmationChallengePayload', fields<ConfirmationChallengePayload>(
+      'action', 'target', 'token', 'expiresInSeconds', 'summary', 'processName', 'processType',
+      'uptimeSeconds', 'chatMessages'
+    )],
+    ['ChatDraftSetPayload', fields<ChatDraftSetPayload>('processId', 'text', 'clear')],
+    ['ChatDraftGetPayload', fields<ChatDraftGetPayload>('processId')],
+    ['ChatDraftResultPayload', fields<ChatDraftResultPayload>('processId', 'draft')],
+    ['ChatDraft', fields<ChatDraft>('text', 'updatedAt')],
+    ['TextRange', fields<TextRange>('start', 'length')],
+    ['SSHChannelUsage', fields<SSHChannelUsage>(
+      'open', 'limit', 'tunnelConnections', 'tunnelOpen'
+    )],
+    ['UnmanagedSession', fields<UnmanagedSession>('tmuxSession')],
+    ['HostConnectPayload', fields<HostConnectPayload>('hostId', 'wantProgress')],
+    ['HostConnectProgressPayload', fields<HostConnectProgressPayload>(
+      'hostId', 'stage', 'found', 'current', 'total'
+    )],
+    ['ProcessCreatePayload', fields<ProcessCreatePayload>(
+      'hostId', 'timeline', 'skipHooks', 'ptyOutput'
+    )],
+    ['PtyOutputOverride', fields<PtyOutputOverride>(
+      'readBufferSize', 'coalesceWindowMs', 'maxCoalescedBytes'
+    )],
+    ['PtyOutputSettings', fields<PtyOutputSettings>(
+      'readBufferSize', 'coalesceWindowMs', 'maxCoalescedBytes'
+    )],
+    ['PtyFailureDetails', fields<PtyFailureDetails>('processId', 'hostId', 'suggestedAction')],
+    ['ProcessClonePayload', fields<ProcessClonePayload>('sourceProcessId', 'cols')],
+    ['ProcessCreatedPayload', fields<ProcessCreatedPayload>('process', 'clonedFrom')],
+    ['PtyInputPayload', fields<PtyInputPayload>('processId', 'data', 'mode')],
+    ['ChatRawPayload', fields<ChatRawPayload>('hostId', 'processId', 'content', 'mode')],
+    ['ChatSendPayload', fields<ChatSendPayload>(
+      'hostId', 'processId', 'content', 'clientMessageId'
+    )],
+    ['ChatSendResultPayload', fields<ChatSendResultPayload>(
+      'hostId', 'processId', 'clientMessageId', 'success', 'message'
+    )],
+    ['ChatMessage', fields<ChatMessage>('id', 'role', 'message', 'time', 'kind', 'metadata')],
+    ['ProcessUpdatedPayload', fields<ProcessUpdatedPayload>(
+      'id', 'type', 'ptyReady', 'agentApiReady', 'claudeCwd', 'pinned', 'sortWeight', 'timeline',
+      'exited', 'lastError', 'agentStatus', 'color', 'icon', 'retainFullHistory',
+      'terminalAttached'
+    )],
+    ['ClaudeReattachTerminalPayload', fields<ClaudeReattachTerminalPayload>('processId')],
+    ['ProcessPinPayload', fields<ProcessPinPayload>('processId', 'pinned')],
+    ['ProcessSetOrderPayload', fields<ProcessSetOrderPayload>('hostId', 'processIds')],
+    ['ProcessTermOptionsPayload', fields<ProcessTermOptionsPayload>('processId', 'options')],
+    ['ProcessClearErrorPayload', fields<ProcessClearErrorPayload>('processId')],
+    ['ProcessSetAppearancePayload', fields<ProcessSetAppearancePayload>(
+      'processId', 'color', 'icon', 'retainFullHistory'
+    )],
+    ['ProcessEnableTimelinePayload', fields<ProcessEnableTimelinePayload>('processId', 'disable')],
+    ['ProcessTimelineListPayload', fields<ProcessTimelineListPayload>(
+      'processId', 'before', 'limit'
+    )],
+    ['ProcessTimelineListResultPayload', fields<ProcessTimelineListResultPayload>(
+      'processId', 'commands', 'hasMore'
+    )],
+    ['TimelineCommand', fields<TimelineCommand>(
+      'id', 'command', 'startedAt', 'endedAt', 'exitCode'
+    )],
+    ['ProcessAlertPayload', fields<ProcessAlertPayload>(
+      'processId', 'hostId', 'kind', 'timestamp'
+    )],
+    ['PtySnapshotPayload', fields<PtySnapshotPayload>('processId', 'cols', 'rows', 'data')],
+    ['PtyHistoryRequestPayload', fields<PtyHistoryRequestPayload>('processId', 'chunkSize')],
+    ['PtyHistoryResponsePayload', fields<PtyHistoryResponsePayload>(
+      'processId', 'totalSize', 'compressed'
+    )],
+    ['PtyHistoryChunkPayload', fields<PtyHistoryChunkPayload>(
+      'processId', 'data', 'chunkIndex', 'totalChunks', 'isLast'
+    )],
+    ['PtyHistoryCompletePayload', fields<PtyHistoryCompletePayload>(
+      'processId', 'success', 'stats'
+    )],
+    ['PtyHistoryTransferStats', fields<PtyHistoryTransferStats>(
+      'bytes', 'chunks', 'chunkSize', 'durationMs', 'bytesPerSecond'
+    )],
+    ['ChatSubscribeResultPayload', fields<ChatSubscribeResultPayload>(
+      'hostId', 'processId', 'success', 'status', 'latestMessageId'
+    )],
+    ['EnvVar', fields<EnvVar>('key', 'value', 'isMasked')],
+    ['EnvRevealResultPayload', fields<EnvRevealResultPayload>('hostId', 'key', 'success', 'value')],
+    ['ProfileListResultPayload', fields<ProfileListResultPayload>('profiles', 'current')],
+    ['StorageEncryptProgressPayload', fields<StorageEncryptProgressPayload>(
+      'table', 'done', 'total'
+    )],
+    ['StorageEncryptResultPayload', fields<StorageEncryptResultPayload>('success', 'encrypted')],
+    ['StorageFlushPayload', fields<StorageFlushPayload>('requestId', 'processId')],
+    ['StorageFlushResultPayload', fields<StorageFlushResultPayload>(
+      'requestId', 'processId', 'success', 'durationMs'
+    )],
+    ['BridgeInfoResultPayload', fields<BridgeInfoResultPayload>(
+      'version', 'commit', 'buildDate', 'protocolVersion', 'startedAt', 'uptimeSeconds', 'profile',
+      'dataDir', 'listenAddresses', 'hostCount', 'connectedHostCount', 'processCount',
+      'sessionCount', 'connectedSessions', 'portRange', 'sshChannels', 'ptyOutput'
+    )],
+    ['BridgeUpdateStatusPayload', fields<BridgeUpdateStatusPayload>(
+      'currentVersion', 'available', 'latestVersion', 'releaseUrl', 'notes',
+      'latestProtocolVersion', 'checkedAt', 'error'
+    )],
+    ];
+
+    test.each(payloadFields)('%s should have the Go JSON field names', (_name, names) => {
+      expect(names.length).toBeGreaterThan(0);
+      expect(new Set(names).size).toBe(names.length);
     });
+  });
 
-    test('HostConnectPayload should have correct JSON field names', () => {
-      const payload = {
-        hostId: 'host-id',
-        host: '192.168.1.1',
-        port: 22,
-        username: 'user',
-        authType: 'password' as const,
-      };
-
-      const json = JSON.stringify(payload);
-      const parsed = JSON.parse(json);
-
-      expect(parsed).toHaveProperty('hostId');
-      expect(parsed).toHaveProperty('host');
-      expect(parsed).toHaveProperty('port');
-      expect(parsed).toHaveProperty('username');
-      expect(parsed).toHaveProperty('authType');
-    });
-
-    test('PtyInputPayload should have correct JSON field names', () => {
-      const payload = {
-        processId: 'proc-id',
-        data: 'ls -la\n',
-      };
-
-      const json = JSON.stringify(payload);
-      const parsed = JSON.parse(json);
-
-      expect(parsed).toHaveProperty('processId');
-      expect(parsed).toHaveProperty('data');
-    });
-
-    test('ChatSendPayload should have correct JSON field names', () => {
-      const payload = {
-        hostId: 'host-id',
-        processId: 'proc-id',
-        content: 'Hello',
-      };
-
-      const json = JSON.stringify(payload);
-      const parsed = JSON.parse(json);
-
-      expect(parsed).toHaveProperty('hostId');
-      expect(parsed).toHaveProperty('processId');
-      expect(parsed).toHaveProperty('content');
-    });
-
-    test('ProcessUpdatedPayload should have correct JSON field names', () => {
-      const payload = {
-        id: 'proc-id',
-        type: 'claude' as const,
-        ptyReady: true,
-        agentApiReady: true,
-      };
-
-      const json = JSON.stringify(payload);
-      const parsed = JSON.parse(json);
-
-      expect(parsed).toHaveProperty('id');
-      expect(parsed).toHaveProperty('type');
-      expect(parsed).toHaveProperty('ptyReady');
-      expect(parsed).toHaveProperty('agentApiReady');
+  describe('Error Codes', () => {
+    // Go's ErrorCodes(), in order; every entry must be an ErrorCode, and
+    // missingCodes below fails to compile if the union has one not listed
+    const goErrorCodes = [
+      'INVALID_MESSAGE', 'UNKNOWN_MESSAGE_TYPE', 'HANDLER_ERROR', 'INVALID_ARGS', 'VALIDATION_ERROR',
+      'STORAGE_ERROR', 'UNAUTHORIZED', 'FORBIDDEN', 'NOT_AUTHENTICATED', 'PROTOCOL_MISMATCH',
+      'NOT_CONNECTED', 'SSH_DOWN',
+      'NOT_FOUND', 'ALREADY_EXISTS', 'ATTACH_FAILED', 'INVALID_STATE', 'NOT_CLAUDE', 'NO_PORTS',
+      'NO_PTY', 'PTY_NOT_READY', 'PTY_ERROR', 'PTY_DETACHED', 'PTY_CLOSED', 'SEND_FAILED',
+      'AGENT_BUSY', 'AGENTAPI_DOWN',
+      'UNSUPPORTED_SHELL',
+      'EXEC_LIMIT', 'EXEC_FAILED',
+      'FILE_NOT_FOUND', 'PERMISSION_DENIED', 'NOT_A_FILE', 'FILE_TOO_LARGE',
+      'UPLOAD_INCOMPLETE', 'CHECKSUM_MISMATCH',
+      'SNIPPET_RENDER_ERROR',
+      'CONFIRMATION_INVALID',
+    ] as const satisfies readonly ErrorCode[];
+    const missingCodes: Exclude<ErrorCode, (typeof goErrorCodes)[number]>[] = [];
+
+    test('TypeScript and Go should have the same error codes', () => {
+      expect(missingCodes).toEqual([]);
+      expect(new Set(goErrorCodes).size).toBe(goErrorCodes.length);
     });
   });
 
@@ -250,9 +701,10 @@ describe('Protocol Alignment Tests', () => {
 
     test('Go should be able to parse TypeScript message format', () => {
       // This is what we would send to Go
+      const payload: ProcessCreatePayload = { hostId: 'host-123', cwd: '/home/user' };
       const tsMessage = {
         type: MessageTypes.PROCESS_CREATE,
-        payload: { hostId: 'host-123', cwd: '/home/user' },
+        payload,
         timestamp: Date.now(),
       };
 
@@ -271,11 +723,13 @@ describe('Protocol Alignment Tests', () => {
 
   describe('Process Type Values', () => {
     test('shell process type should match Go constant', () => {
-      expect('shell').toBe('shell'); // Go: ProcessTypeShell = "shell"
+      const shell: ProcessType = 'shell'; // Go: ProcessTypeShell = "shell"
+      expect(shell).toBe('shell');
     });
 
     test('claude process type should match Go constant', () => {
-      expect('claude').toBe('claude'); // Go: ProcessTypeClaude = "claude"
+      const claude: ProcessType = 'claude'; // Go: ProcessTypeClaude = "claude"
+      expect(claude).toBe('claude');
     });
   });
 });
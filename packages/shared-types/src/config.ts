@@ -16,5 +16,7 @@ export interface BridgeConfig {
 
 export const DEFAULT_SSH_PORT = 22;
 export const DEFAULT_BRIDGE_PORT = 8080;
+// Default AgentAPI port range; a bridge may be configured with another, which
+// it reports in BridgeInfoResultPayload.portRange
 export const AGENTAPI_PORT_MIN = 3284;
 export const AGENTAPI_PORT_MAX = 3299;
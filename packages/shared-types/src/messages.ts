@@ -5,6 +5,11 @@
  * Protocol alignment tests verify this.
  */
 
+// Protocol version this package describes; must match Go's
+// protocol.ProtocolVersion. The bridge turns away clients that speak an
+// older one at auth.
+export const PROTOCOL_VERSION = 2;
+
 // ============================================================================
 // Message Type Constants
 // ============================================================================
@@ -13,6 +18,14 @@ export const MessageTypes = {
   // Authentication
   AUTH: 'auth',
   AUTH_RESULT: 'auth_result',
+  SESSION_REFRESH_TOKEN: 'session_refresh_token',
+  SESSION_REFRESH_TOKEN_RESULT: 'session_refresh_token_result',
+  SESSION_INVITE_CREATE: 'session_invite_create',
+  SESSION_INVITE_CREATE_RESULT: 'session_invite_create_result',
+  SESSION_INVITE_REVOKE: 'session_invite_revoke',
+  SESSION_INVITE_REVOKE_RESULT: 'session_invite_revoke_result',
+  SESSION_INFO: 'session_info',
+  SESSION_INFO_RESULT: 'session_info_result',
 
   // Host Configuration (CRUD - stored in bridge)
   HOST_CONFIG_LIST: 'host_config_list',
@@ -23,13 +36,21 @@ export const MessageTypes = {
   HOST_CONFIG_UPDATE_RESULT: 'host_config_update_result',
   HOST_CONFIG_DELETE: 'host_config_delete',
   HOST_CONFIG_DELETE_RESULT: 'host_config_delete_result',
+  HOST_CONFIG_IMPORT_SSHCONFIG: 'host_config_import_sshconfig',
+  HOST_CONFIG_IMPORT_SSHCONFIG_RESULT: 'host_config_import_sshconfig_result',
 
   // Host Connection (runtime)
   HOST_CONNECT: 'host_connect',
   HOST_DISCONNECT: 'host_disconnect',
   HOST_STATUS: 'host_status',
+  HOST_STATUS_REQUEST: 'host_status_request',
   HOST_CHECK_REQUIREMENTS: 'host_check_requirements',
   HOST_REQUIREMENTS_RESULT: 'host_requirements_result',
+  HOST_CONNECT_PROGRESS: 'host_connect_progress',
+  HOST_EXEC: 'host_exec',
+  HOST_EXEC_RESULT: 'host_exec_result',
+  HOST_DIAGNOSTICS: 'host_diagnostics',
+  HOST_DIAGNOSTICS_RESULT: 'host_diagnostics_result',
 
   // Process Management
   PROCESS_LIST: 'process_list',
@@ -42,15 +63,51 @@ export const MessageTypes = {
   PROCESS_UPDATED: 'process_updated',
   PROCESS_REATTACH: 'process_reattach',
   PROCESS_RENAME: 'process_rename',
+  PROCESS_CLONE: 'process_clone',
+  PROCESS_PIN: 'process_pin',
+  PROCESS_SET_ORDER: 'process_set_order',
+  PROCESS_TERM_OPTIONS: 'process_term_options',
+  PROCESS_CLEAR_ERROR: 'process_clear_error',
+  PROCESS_SET_APPEARANCE: 'process_set_appearance',
+
+  // Changes to a host's process list between host_status snapshots
+  PROCESS_ADDED: 'process_added',
+  PROCESS_REMOVED: 'process_removed',
+  STALE_PROCESSES_CHANGED: 'stale_processes_changed',
+  PROCESS_CWD_CHANGED: 'process_cwd_changed',
+
+  // Command timeline
+  PROCESS_ENABLE_TIMELINE: 'process_enable_timeline',
+  PROCESS_TIMELINE_LIST: 'process_timeline_list',
+  PROCESS_TIMELINE_LIST_RESULT: 'process_timeline_list_result',
+
+  // Archived processes, killed with their history kept
+  ARCHIVED_PROCESS_LIST: 'archived_process_list',
+  ARCHIVED_PROCESS_LIST_RESULT: 'archived_process_list_result',
+  ARCHIVED_PROCESS_GET: 'archived_process_get',
+  ARCHIVED_PROCESS_GET_RESULT: 'archived_process_get_result',
+  ARCHIVED_PROCESS_DELETE: 'archived_process_delete',
+  ARCHIVED_PROCESS_DELETE_RESULT: 'archived_process_delete_result',
+
+  // Process state pushes
+  PROCESSES_SUBSCRIBE: 'processes_subscribe',
+  PROCESSES_UNSUBSCRIBE: 'processes_unsubscribe',
+  PROCESS_ALERT: 'process_alert',
 
   // Claude Conversion
   CLAUDE_START: 'claude_start',
   CLAUDE_KILL: 'claude_kill',
+  CLAUDE_REATTACH_TERMINAL: 'claude_reattach_terminal',
 
   // PTY (Terminal)
   PTY_INPUT: 'pty_input',
   PTY_OUTPUT: 'pty_output',
   PTY_RESIZE: 'pty_resize',
+  PTY_SNAPSHOT: 'pty_snapshot',
+
+  // PTY Scrollback (tmux copy mode)
+  PTY_SCROLL: 'pty_scroll',
+  PTY_SCROLL_STATE: 'pty_scroll_state',
 
   // PTY History
   PTY_HISTORY_REQUEST: 'pty_history_request',
@@ -60,20 +117,31 @@ export const MessageTypes = {
 
   // Chat (AgentAPI)
   CHAT_SUBSCRIBE: 'chat_subscribe',
+  CHAT_SUBSCRIBE_RESULT: 'chat_subscribe_result',
   CHAT_UNSUBSCRIBE: 'chat_unsubscribe',
   CHAT_SEND: 'chat_send',
+  CHAT_SEND_RESULT: 'chat_send_result',
   CHAT_RAW: 'chat_raw',
   CHAT_EVENT: 'chat_event',
   CHAT_STATUS: 'chat_status',
   CHAT_STATUS_RESULT: 'chat_status_result',
   CHAT_HISTORY: 'chat_history',
   CHAT_MESSAGES: 'chat_messages',
+  CHAT_SEARCH: 'chat_search',
+  CHAT_SEARCH_RESULT: 'chat_search_result',
+  CHAT_USAGE: 'chat_usage',
+  CHAT_USAGE_RESULT: 'chat_usage_result',
+  CHAT_DRAFT_SET: 'chat_draft_set',
+  CHAT_DRAFT_GET: 'chat_draft_get',
+  CHAT_DRAFT_RESULT: 'chat_draft_result',
 
   // Environment Variables - Host Level
   ENV_LIST: 'env_list',
   ENV_UPDATE: 'env_update',
   ENV_RESULT: 'env_result',
   ENV_SET_RC_FILE: 'env_set_rc_file',
+  ENV_REVEAL: 'env_reveal',
+  ENV_REVEAL_RESULT: 'env_reveal_result',
 
   // Environment Variables - Process Level
   PROCESS_ENV_LIST: 'process_env_list',
@@ -83,6 +151,18 @@ export const MessageTypes = {
   PORTS_SCAN: 'ports_scan',
   PORTS_RESULT: 'ports_result',
 
+  // File downloads from hosts
+  FILE_DOWNLOAD: 'file_download',
+  FILE_DOWNLOAD_CHUNK: 'file_download_chunk',
+  FILE_DOWNLOAD_COMPLETE: 'file_download_complete',
+  FILE_DOWNLOAD_CANCEL: 'file_download_cancel',
+
+  // File uploads to hosts
+  FILE_UPLOAD_BEGIN: 'file_upload_begin',
+  FILE_UPLOAD_CHUNK: 'file_upload_chunk',
+  FILE_UPLOAD_COMMIT: 'file_upload_commit',
+  FILE_UPLOAD_RESULT: 'file_upload_result',
+
   // Snippets (global, unrelated to hosts/processes)
   SNIPPET_LIST: 'snippet_list',
   SNIPPET_LIST_RESULT: 'snippet_list_result',
@@ -93,6 +173,59 @@ export const MessageTypes = {
   SNIPPET_DELETE: 'snippet_delete',
   SNIPPET_DELETE_RESULT: 'snippet_delete_result',
 
+  // Typing a snippet into processes' terminals
+  SNIPPET_EXECUTE: 'snippet_execute',
+  SNIPPET_EXECUTE_RESULT: 'snippet_execute_result',
+
+  // Workspaces (process groups that may span hosts)
+  WORKSPACE_LIST: 'workspace_list',
+  WORKSPACE_LIST_RESULT: 'workspace_list_result',
+  WORKSPACE_CREATE: 'workspace_create',
+  WORKSPACE_CREATE_RESULT: 'workspace_create_result',
+  WORKSPACE_UPDATE: 'workspace_update',
+  WORKSPACE_UPDATE_RESULT: 'workspace_update_result',
+  WORKSPACE_DELETE: 'workspace_delete',
+  WORKSPACE_DELETE_RESULT: 'workspace_delete_result',
+  WORKSPACE_ASSIGN: 'workspace_assign',
+  WORKSPACE_ASSIGN_RESULT: 'workspace_assign_result',
+
+  // Process templates (saved recipes for creating a process)
+  PROCESS_TEMPLATE_LIST: 'process_template_list',
+  PROCESS_TEMPLATE_LIST_RESULT: 'process_template_list_result',
+  PROCESS_TEMPLATE_CREATE: 'process_template_create',
+  PROCESS_TEMPLATE_CREATE_RESULT: 'process_template_create_result',
+  PROCESS_TEMPLATE_UPDATE: 'process_template_update',
+  PROCESS_TEMPLATE_UPDATE_RESULT: 'process_template_update_result',
+  PROCESS_TEMPLATE_DELETE: 'process_template_delete',
+  PROCESS_TEMPLATE_DELETE_RESULT: 'process_template_delete_result',
+  PROCESS_CREATE_FROM_TEMPLATE: 'process_create_from_template',
+  PROCESS_CREATE_FROM_TEMPLATE_RESULT: 'process_create_from_template_result',
+
+  // Bridge diagnostics
+  BRIDGE_INFO: 'bridge_info',
+  BRIDGE_INFO_RESULT: 'bridge_info_result',
+
+  // Bridge update check (see --update-check)
+  BRIDGE_UPDATE_CHECK: 'bridge_update_check',
+  BRIDGE_UPDATE_CHECK_RESULT: 'bridge_update_check_result',
+  BRIDGE_UPDATE_AVAILABLE: 'bridge_update_available',
+
+  // Data profiles (read-only; the profile is chosen at bridge startup)
+  PROFILE_LIST: 'profile_list',
+  PROFILE_LIST_RESULT: 'profile_list_result',
+
+  // History encryption at rest (see --encrypt-history)
+  STORAGE_ENCRYPT_NOW: 'storage_encrypt_now',
+  STORAGE_ENCRYPT_PROGRESS: 'storage_encrypt_progress',
+  STORAGE_ENCRYPT_RESULT: 'storage_encrypt_result',
+
+  // Saving buffered history now rather than at the next persist
+  STORAGE_FLUSH: 'storage_flush',
+  STORAGE_FLUSH_RESULT: 'storage_flush_result',
+
+  // Confirmation of destructive requests
+  CONFIRMATION_CHALLENGE: 'confirmation_challenge',
+
   // Error
   ERROR: 'error',
 } as const;
@@ -121,12 +254,55 @@ export interface ProcessInfo {
   hostId: string;
   port?: number;
   cwd: string;
+  claudeCwd?: string; // CWD when Claude was started; unlike cwd it does not follow cd
   name?: string; // Custom user-defined name
   ptyReady: boolean;
   agentApiReady: boolean;
   startedAt: string; // ISO timestamp
   shellPid?: number;
   agentApiPid?: number;
+  workspaceId?: string;
+  pinned: boolean; // Listed before unpinned processes
+  sortWeight: number; // Position among the host's processes, from 0
+  termOptions?: TermOptions; // tmux options of the session; absent ones follow the host's tmux config
+  timeline: boolean; // Commands run in the shell are recorded (see process_enable_timeline)
+  exited?: boolean; // The tmux session is gone (it exited or the tmux server died); only process_kill applies
+  lastError?: ProcessError; // The last failure on the process, until it is fixed or dismissed
+  agentStatus?: 'running' | 'stable'; // Claude's AgentAPI status, from its stream and polls; absent when unknown
+  color?: AppearanceColor; // Set by process_set_appearance
+  icon?: AppearanceIcon; // Set by process_set_appearance
+  stats?: ProcessStats; // Only in process_list results asked for with includeStats
+  retainFullHistory?: boolean; // PTY history is exempt from the bridge's per-process cap; set by process_set_appearance
+  historySize?: number; // Bytes of PTY history stored; only in process_list results asked for with includeStats
+  terminalAttached?: boolean; // Claude only: the pane shows Claude's UI (agentapi attach); false once it dropped back to the shell
+  startupHooks?: string[]; // Commands typed into the shell when it was created: its host's, then its template's
+}
+
+export type ProcessErrorOperation =
+  | 'pty_write'
+  | 'pty_resize'
+  | 'agent_events'
+  | 'agent_status'
+  | 'claude_start'
+  | 'claude_kill';
+
+/**
+ * A failure on a process, kept until the operation that failed next succeeds
+ * or the user dismisses it with process_clear_error
+ */
+export interface ProcessError {
+  operation: ProcessErrorOperation;
+  code: ErrorCode;
+  message: string;
+  at: string; // ISO timestamp
+}
+
+// Bytes a process moved through the bridge since the bridge started
+export interface ProcessStats {
+  ptyOutputBytes: number; // pty_output and pty_snapshot sent to clients
+  ptyInputBytes: number; // pty_input received from clients
+  chatEventBytes: number; // chat_event data forwarded to clients
+  historyBytes: number; // PTY history served to clients
 }
 
 export interface StaleProcess {
@@ -137,27 +313,162 @@ export interface StaleProcess {
   startedAt?: string; // When the session was created
 }
 
+// A tmux session with the bridge's rc- prefix whose name doesn't embed a
+// valid process ID. It is reported but can't be reattached.
+export interface UnmanagedSession {
+  tmuxSession: string;
+  startedAt?: string; // When the session was created
+}
+
 // ============================================================================
 // Authentication Payloads
 // ============================================================================
 
 export interface AuthPayload {
+  protocolVersion?: number; // Protocol the client speaks (PROTOCOL_VERSION); a client without one speaks 1
   reconnectToken?: string; // Optional token for reconnection
-}
+  token?: string; // Bridge auth token (required if the bridge has one)
+  inviteToken?: string; // From session_invite_create_result, instead of token
+  role?: SessionRole; // Ask for less than the credential grants (i.e. 'observer')
+  compression?: boolean; // Opt into permessage-deflate (if negotiated)
+  clientTimestamp?: number; // Client's clock (ms since epoch), to measure skew
+  locale?: string; // BCP 47 tag (e.g. "pt-BR") for error messages
+  // Terminal size for processes this device creates or reattaches without
+  // cols/rows, and restores its selected processes to on reconnect
+  defaultCols?: number;
+  defaultRows?: number;
+  deviceLabel?: string; // Names the device in the bridge's logs (e.g. "phone")
+  capabilities?: Capability[]; // Optional behaviors the client understands; unknown ones are ignored
+}
+
+// 'server_timestamps' adds the bridge's clock to pty_output and chat_event
+// as serverTs, to measure one-way delay along with clockSkewMs
+export type Capability = 'server_timestamps';
 
 export interface AuthResultPayload {
   success: boolean;
   sessionId?: string;
   reconnectToken?: string; // Token to use for reconnection
+  tokenExpiresAt?: string; // ISO timestamp; see SessionRefreshTokenResultPayload
   reconnected: boolean; // Whether this was a reconnection
+  compression?: boolean; // Whether outgoing frames may be compressed
+  serverVersion: string; // Bridge build version
+  protocolVersion: number;
+  profile: string; // Data profile the bridge is serving
+  serverTimestamp?: number; // Bridge clock (ms since epoch) when auth was handled
+  clockSkewMs?: number; // Bridge clock minus the client's, transit time included; when clientTimestamp was sent
+  locale: string; // Catalog locale error messages are sent in
+  role?: SessionRole; // What the session may do
+  scope?: SessionScope; // Set when the session is limited to one host or process
+  capabilities?: Capability[]; // The requested capabilities the bridge enabled
+  code?: ErrorCode;
+  error?: string;
+}
+
+// Observers may watch output, chat and state and request history; every
+// request that changes something is refused with FORBIDDEN
+export type SessionRole = 'observer' | 'owner';
+
+// Limits a session to one host, or one process on it
+export interface SessionScope {
+  hostId?: string;
+  processId?: string;
+}
+
+// Asks for a new reconnect token in place of the current one, which stops working
+export interface SessionRefreshTokenPayload {
+  reconnectToken: string; // The session's current token
+}
+
+// Tokens work for as long as the bridge keeps running; tokenExpiresAt is when
+// the token stops surviving bridge restarts, so refresh before then. Fails
+// when the token is no longer current.
+export interface SessionRefreshTokenResultPayload {
+  success: boolean;
+  reconnectToken?: string;
+  tokenExpiresAt?: string; // ISO timestamp
+  code?: ErrorCode;
+  error?: string;
+}
+
+// Mints an invite token another client can authenticate with instead of the
+// bridge's auth token, e.g. to let a coworker watch a session
+export interface SessionInviteCreatePayload {
+  role?: SessionRole; // Default 'observer'
+  hostId?: string; // Limit the invite to one host
+  processId?: string; // Limit the invite to one process
+  expiresInSeconds?: number; // At least 60; the invite lasts until revoked without one
+  label?: string; // Who the invite is for, up to 64 characters
+}
+
+// The token itself is only ever sent in the create result; the bridge keeps a hash of it
+export interface SessionInvite {
+  id: string;
+  role: SessionRole;
+  hostId?: string;
+  processId?: string;
+  label?: string;
+  createdAt: string; // ISO timestamp
+  expiresAt?: string; // ISO timestamp
+}
+
+export interface SessionInviteCreateResultPayload {
+  success: boolean;
+  invite?: SessionInvite;
+  token?: string; // For the invited client's AuthPayload.inviteToken
+  code?: ErrorCode;
+  error?: string;
+}
+
+// Revokes an invite; sessions authenticated with it are disconnected
+export interface SessionInviteRevokePayload {
+  id: string;
+}
+
+export interface SessionInviteRevokeResultPayload {
+  success: boolean;
+  id: string;
+  disconnected: number; // Sessions that were using the invite
+  code?: ErrorCode;
   error?: string;
 }
 
+export interface SessionInfoPayload {
+  // empty - no params needed
+}
+
+/** The requesting session, and for an owner every connected one */
+export interface SessionInfoResultPayload {
+  session: ConnectedSessionInfo;
+  sessions?: ConnectedSessionInfo[]; // Every connected session; owners only
+}
+
+export interface ConnectedSessionInfo {
+  id: string;
+  deviceLabel?: string;
+  role: SessionRole;
+  createdAt: string; // ISO timestamp
+  latency?: SessionLatency; // Absent until a ping was answered
+}
+
+/** Round-trip time to a client, measured by the bridge's WebSocket pings */
+export interface SessionLatency {
+  rttMs: number; // Moving average of the round trips
+  lastRttMs: number;
+  minRttMs: number;
+  samples: number;
+  measuredAt: string; // ISO timestamp of the last pong
+}
+
 // ============================================================================
 // Host Configuration Payloads (CRUD - stored in bridge)
 // ============================================================================
 
-export type AuthType = 'password' | 'key';
+export type AuthType = 'password' | 'key' | 'agent';
+
+// Where a host's credential lives: encrypted in the bridge database, printed
+// by the bridge's credential command, or in the macOS keychain
+export type CredentialBackend = 'sqlite' | 'exec' | 'keychain';
 
 /** SSH host configuration stored in bridge */
 export interface SSHHostConfig {
@@ -167,9 +478,18 @@ export interface SSHHostConfig {
   port: number;
   username: string;
   authType: AuthType;
+  credentialBackend: CredentialBackend;
   autoConnect: boolean;
+  tmuxSocketPath?: string; // tmux server socket (tmux -S); the user's default server when unset
+  tmuxCommand?: string; // Program run instead of tmux; tmux from the PATH when unset
+  maxConcurrentOps?: number; // Remote operations run at once on the host; the bridge's limit when unset
+  color?: AppearanceColor;
+  icon?: AppearanceIcon;
   createdAt: string; // ISO timestamp
   updatedAt: string; // ISO timestamp
+  // Commands typed into every new shell on the host, in order, before its
+  // env is captured; see ProcessCreatePayload.skipHooks
+  startupHooks?: string[];
   // Note: credentials are NOT included in list results for security
 }
 
@@ -189,13 +509,16 @@ export interface HostConfigCreatePayload {
   port: number;
   username: string;
   authType: AuthType;
-  credential: string; // password or private key
+  credential: string; // password or private key; empty for agent
   autoConnect?: boolean;
+  color?: AppearanceColor;
+  icon?: AppearanceIcon;
 }
 
 export interface HostConfigCreateResultPayload {
   success: boolean;
   host?: SSHHostConfig;
+  code?: ErrorCode;
   error?: string;
 }
 
@@ -209,22 +532,81 @@ export interface HostConfigUpdatePayload {
   authType?: AuthType;
   credential?: string; // only set if changing credential
   autoConnect?: boolean;
+  // The tmux server the bridge uses on the host, for an account shared by
+  // several users; "" goes back to the default. Sessions already running
+  // keep theirs.
+  tmuxSocketPath?: string;
+  tmuxCommand?: string;
+  // How many remote operations (commands and tunnel dials) the bridge runs
+  // at once on the host, for small devices; 0 goes back to the bridge's
+  // limit. Applies to a connected host right away.
+  maxConcurrentOps?: number; // 0-64
+  // Replaces the host's startup hooks, one command per entry (at most 20);
+  // an empty list clears them. Applies to shells created after it.
+  startupHooks?: string[];
+  // How the host is shown; "" clears it
+  color?: AppearanceColor | '';
+  icon?: AppearanceIcon | '';
 }
 
 export interface HostConfigUpdateResultPayload {
   success: boolean;
   host?: SSHHostConfig;
+  code?: ErrorCode;
   error?: string;
 }
 
 // Delete a host
-export interface HostConfigDeletePayload {
+export interface HostConfigDeletePayload extends Confirmation {
   id: string;
 }
 
 export interface HostConfigDeleteResultPayload {
   success: boolean;
   id?: string;
+  code?: ErrorCode;
+  error?: string;
+}
+
+/**
+ * Read an OpenSSH client config: the bridge machine's ~/.ssh/config, or
+ * configText when set. Without select it only lists the config's hosts;
+ * with it, host configs are created for the selected ones.
+ */
+export interface HostConfigImportSSHConfigPayload {
+  configText?: string; // Config uploaded by the client; its Include directives aren't followed
+  select?: string[]; // Aliases to create host configs for
+  // The user agreed to the bridge reading the selected hosts' identity
+  // files into their host configs. Hosts that need one fail without it.
+  readIdentityFiles?: boolean;
+  autoConnect?: boolean; // For the created host configs
+}
+
+// A host of an ssh_config, as it would be imported
+export interface SSHConfigEntry {
+  alias: string; // Becomes the host config's name
+  hostName: string;
+  user: string; // The bridge's user when the config sets none
+  port: number;
+  authType: 'key' | 'agent'; // key with an identity file, else agent
+  identityFile?: string; // The first one found on the bridge machine
+  proxyJump?: string; // Host configs can't jump, so they connect directly
+  exists: boolean; // A host config with this name exists and isn't imported again
+}
+
+// A selected host that couldn't be imported
+export interface SSHConfigImportFailure {
+  alias: string;
+  error: string;
+}
+
+export interface HostConfigImportSSHConfigResultPayload {
+  success: boolean;
+  entries: SSHConfigEntry[];
+  created?: SSHHostConfig[];
+  failed?: SSHConfigImportFailure[];
+  warnings?: string[]; // Config lines that were skipped, and why
+  code?: ErrorCode;
   error?: string;
 }
 
@@ -235,6 +617,24 @@ export interface HostConfigDeleteResultPayload {
 export interface HostConnectPayload {
   hostId: string;
   // No credentials needed - bridge has them stored
+  wantProgress?: boolean; // Stream host_connect_progress before HOST_STATUS
+}
+
+export type HostConnectStage =
+  | 'ssh_handshake'
+  | 'scanning_tmux'
+  | 'reattaching'
+  | 'scanning_ports'
+  | 'checking_requirements';
+
+// Reports that a host connect reached a stage.
+// Sent before the final HOST_STATUS, only when the connect asked for it.
+export interface HostConnectProgressPayload {
+  hostId: string;
+  stage: HostConnectStage;
+  found?: number; // scanning_tmux: tmux sessions found
+  current?: number; // reattaching: processes finished, reattached or not (1-based)
+  total?: number; // reattaching: processes to reattach
 }
 
 export interface HostDisconnectPayload {
@@ -246,6 +646,7 @@ export interface HostRequirements {
   claudePath?: string;
   agentApiInstalled: boolean;
   agentApiPath?: string;
+  tmuxTmpDir?: string; // TMUX_TMPDIR, when it moves tmux's sockets out of /tmp
   checkedAt: string; // ISO timestamp
 }
 
@@ -254,8 +655,88 @@ export interface HostStatusPayload {
   connected: boolean;
   processes: ProcessInfo[];
   staleProcesses?: StaleProcess[];
+  code?: ErrorCode;
   error?: string;
-  requirements?: HostRequirements;
+  requirements?: HostRequirements; // Last found; fresh ones follow as host_requirements_result
+  reason?: HostDisconnectReason; // Set when a connected host became disconnected
+  // rc-* tmux sessions found by the host_connect scan that the bridge
+  // didn't create
+  unmanagedSessions?: UnmanagedSession[];
+  warnings?: HostWarning[];
+  channels?: SSHChannelUsage; // Set while connected
+  // Set by host_connect when the host rebooted since the bridge last
+  // connected to it: its tmux sessions are gone, and the processes the
+  // bridge knew of were dropped instead of reattached
+  rebootDetected?: boolean;
+  bootTime?: string; // ISO timestamp; set by host_connect when readable
+  previousBootTime?: string; // ISO timestamp; set with rebootDetected
+  // Set by host_connect when scans were still running at its deadline:
+  // scanning_tmux, scanning_ports and/or checking_requirements. What they
+  // would have found is missing from the other fields.
+  timedOut?: HostConnectStage[];
+  // Set when processes were reattached for this status: by host_connect,
+  // and after an auth that found PTYs detached
+  reattach?: ReattachReport;
+  // Set while connected, once read; from the last connect before then
+  platform?: HostPlatform;
+}
+
+// What a host runs, read once per connection and recorded with the host
+export interface HostPlatform {
+  os: string; // uname -s: "Linux", "Darwin", ...
+  arch: string; // uname -m: "x86_64", "arm64", ...
+  release?: string; // e.g. "Ubuntu 22.04.4 LTS" or "macOS 14.5"
+  shell?: string; // The login shell
+  tmuxVersion?: string; // Unset when tmux isn't installed
+  hasProc: boolean; // Whether /proc is mounted
+}
+
+// Retried processes were refused for the host's session limits at first;
+// they are listed again under reattached or failed
+export interface ReattachReport {
+  reattached: string[];
+  retried?: string[];
+  failed?: ReattachFailure[];
+}
+
+export interface ReattachFailure {
+  processId: string;
+  error: string;
+}
+
+export type HostDisconnectReason =
+  | 'user_disconnect'
+  | 'keepalive_failed'
+  | 'auth_failed_on_reconnect';
+
+// HOST_DUPLICATE: the host reaches the same machine as other connected hosts
+// (duplicateOf). Its scan skips tmux sessions they already own.
+// TMUX_SERVER_GONE: the host's tmux server is gone, and with it every
+// process on the host, which are all reported exited.
+export type HostWarningCode = 'HOST_DUPLICATE' | 'TMUX_SERVER_GONE';
+
+// A problem with a host connection that didn't stop it from connecting
+export interface HostWarning {
+  code: HostWarningCode;
+  message: string;
+  duplicateOf?: string[]; // Host IDs, for HOST_DUPLICATE
+}
+
+// Channels (PTY attaches, exec sessions and AgentAPI tunnels) open on a
+// host's SSH connections. Once the primary connection nears the limit,
+// tunnels move to secondary connections.
+export interface SSHChannelUsage {
+  open: number; // On the primary connection
+  limit: number; // Allowed per connection, lowered when the server rejects a channel
+  tunnelConnections: number; // Secondary connections carrying tunnels
+  tunnelOpen: number; // Channels open on the secondary connections
+}
+
+// Asks for a full host_status of a connected host. The bridge sends one on
+// host_connect and on reconnect; in between, changes arrive as
+// process_added, process_removed, process_updated and stale_processes_changed.
+export interface HostStatusRequestPayload {
+  hostId: string;
 }
 
 export interface HostCheckRequirementsPayload {
@@ -265,7 +746,92 @@ export interface HostCheckRequirementsPayload {
 export interface HostRequirementsResultPayload {
   hostId: string;
   requirements: HostRequirements;
+  code?: ErrorCode;
+  error?: string;
+}
+
+// Runs a one-off command on a connected host, outside any process, under sh
+// with stdin closed
+export interface HostExecPayload {
+  requestId?: string; // Echoed in the result, to match concurrent commands
+  hostId: string;
+  command: string;
+  timeoutMs?: number; // Default 30s, capped by the bridge's maximum
+  cwd?: string; // Default: the login directory
+}
+
+// Each stream is cut off at the bridge's output cap, with its truncated flag set
+export interface HostExecResultPayload {
+  requestId?: string;
+  hostId: string;
+  command: string;
+  exitCode: number; // -1 when killed or timed out
+  timedOut: boolean;
+  durationMs: number;
+  stdout: string;
+  stderr: string;
+  stdoutTruncated: boolean;
+  stderrTruncated: boolean;
+}
+
+// Probes the SSH link to a host: the round-trip time of a trivial command and
+// the throughput of a short transfer. A host can be probed once per 10 seconds.
+export interface HostDiagnosticsPayload {
+  hostId: string;
+  record?: boolean; // Add the sample to the host's history
+}
+
+// The outcome of one probe. Times are in milliseconds, with fractions.
+export interface HostDiagnosticsSample {
+  at: string; // ISO timestamp
+  rttMs: number[]; // Each trivial command
+  rttMinMs: number;
+  rttAvgMs: number;
+  rttMaxMs: number;
+  throughputBytes: number;
+  throughputMs: number;
+  throughputBytesPerSec: number; // Less the fixed cost of a command (rttMinMs)
+}
+
+export interface HostKeepaliveStatus {
+  intervalSeconds: number;
+  lastOkAt?: string; // ISO timestamp; absent before the first keepalive
+  lastRttMs?: number;
+  lastFailure?: string; // Why a connection to the host was last lost
+  lastFailureAt?: string; // ISO timestamp
+}
+
+export interface HostChannelStatus {
+  open: number; // On the primary connection
+  limit: number; // Allowed per connection
+  tunnelConnections: number;
+  tunnelOpen: number;
+}
+
+// Remote operations (commands and tunnel dials) running on a host, and those
+// waiting for its limit
+export interface HostOpsStatus {
+  limit: number;
+  running: number;
+  queued: number;
+}
+
+// The connection status and history are sent even when the probe fails or is
+// refused for running too soon after the last one
+export interface HostDiagnosticsResultPayload {
+  hostId: string;
+  success: boolean;
+  code?: ErrorCode;
   error?: string;
+  retryAfterMs?: number; // When the probe was refused by the rate limit
+  sample?: HostDiagnosticsSample;
+  connectedAt: string; // ISO timestamp
+  connectionAgeSeconds: number;
+  keepalive: HostKeepaliveStatus;
+  channels: HostChannelStatus;
+  ops: HostOpsStatus;
+  history: HostDiagnosticsSample[]; // Recorded samples, oldest first, at most 20
+  platform?: HostPlatform;
 }
 
 // ============================================================================
@@ -274,6 +840,8 @@ export interface HostRequirementsResultPayload {
 
 export interface ProcessListPayload {
   hostId: string;
+  listLight?: boolean; // Return cached state without querying the host
+  includeStats?: boolean; // Include each process's traffic stats
 }
 
 export interface ProcessListResultPayload {
@@ -286,28 +854,258 @@ export interface ProcessCreatePayload {
   cwd?: string;
   cols?: number;
   rows?: number;
+  timeline?: boolean; // Record the command timeline; turned on once the shell is up, with a process_updated
+  skipHooks?: boolean; // Don't type the host's startup hooks into the new shell
+  ptyOutput?: PtyOutputOverride; // How this process's output is read and delivered, e.g. for streaming logs
+}
+
+/**
+ * Changes how one process's PTY output is read and delivered; unset fields
+ * keep the bridge's settings (see PtyOutputSettings)
+ */
+export interface PtyOutputOverride {
+  readBufferSize?: number; // 512-1048576 bytes
+  coalesceWindowMs?: number; // 0-500
+  maxCoalescedBytes?: number; // 1024-1048576
 }
 
 export interface ProcessCreatedPayload {
   process: ProcessInfo;
+  clonedFrom?: string; // Source process, for process_clone
+  cols?: number; // Terminal size the PTY was given
+  rows?: number;
+}
+
+/**
+ * Creates a shell process that starts in the source process's working
+ * directory with its captured env vars. The source may be live or detached.
+ * Answered with process_created carrying clonedFrom.
+ */
+export interface ProcessClonePayload {
+  sourceProcessId: string;
+  cols?: number; // Defaults to the source's size
+  rows?: number;
+}
+
+/**
+ * Pins a process to the top of its host's list, or unpins it. Answered with
+ * process_updated.
+ */
+export interface ProcessPinPayload {
+  processId: string;
+  pinned: boolean;
+}
+
+/**
+ * Reorders a host's processes: the listed ones come first, in order, followed
+ * by the rest in their current order. Answered with process_list_result.
+ */
+export interface ProcessSetOrderPayload {
+  hostId: string;
+  processIds: string[];
+}
+
+/**
+ * tmux options of a process's session. history-limit is a number of lines,
+ * as a string, and only applies to panes created after it is set.
+ */
+export interface TermOptions {
+  status?: 'on' | 'off';
+  mouse?: 'on' | 'off';
+  'history-limit'?: string;
+}
+
+/**
+ * Sets tmux options of a process's session. An empty value resets an option
+ * to its default; options not listed keep their value. Answered with
+ * process_updated.
+ */
+export interface ProcessTermOptionsPayload {
+  processId: string;
+  options: { [K in keyof TermOptions]?: TermOptions[K] | '' };
+}
+
+/** Dismisses a process's last error. Answered with process_updated. */
+export interface ProcessClearErrorPayload {
+  processId: string;
+}
+
+// The named colors a host or process can be shown with
+export const AppearanceColors = [
+  'red', 'orange', 'yellow', 'green', 'teal', 'blue', 'indigo', 'purple', 'pink', 'brown', 'gray',
+] as const;
+
+// A named color, or a hex one: "#rgb" or "#rrggbb"
+export type AppearanceColor = (typeof AppearanceColors)[number] | `#${string}`;
+
+// The icons a host or process can be shown with
+export const AppearanceIcons = [
+  'server', 'laptop', 'desktop', 'cloud', 'database', 'container', 'terminal', 'code',
+  'bug', 'flask', 'rocket', 'globe', 'lock', 'key', 'star', 'heart', 'bolt', 'fire',
+] as const;
+
+export type AppearanceIcon = (typeof AppearanceIcons)[number];
+
+/**
+ * Sets the color and icon a process is shown with, and whether its PTY
+ * history is exempt from the bridge's history cap. They are stored with the
+ * process metadata, so every client sees them and they survive reattach and
+ * restarts. An empty value clears one; one that is omitted is left alone.
+ * Answered with process_updated.
+ */
+export interface ProcessSetAppearancePayload {
+  processId: string;
+  color?: AppearanceColor | '';
+  icon?: AppearanceIcon | '';
+  retainFullHistory?: boolean;
+}
+
+/**
+ * Turns a process's command timeline on or off. Turning it on loads hooks
+ * into the shell that mark where each command starts and ends; turning it
+ * off removes them. Only bash and zsh are supported, and the shell must be at
+ * its prompt. Answered with process_updated.
+ */
+export interface ProcessEnableTimelinePayload {
+  processId: string;
+  disable?: boolean;
+}
+
+/**
+ * Asks for a page of the commands run in a process, newest first
+ */
+export interface ProcessTimelineListPayload {
+  processId: string;
+  before?: number; // Only commands older than this one (a TimelineCommand id), for the next page
+  limit?: number; // Maximum commands, default 50, at most 500
+}
+
+export interface TimelineCommand {
+  id: number;
+  command: string; // Empty when the shell didn't report it
+  startedAt: string; // ISO timestamp
+  endedAt?: string; // Absent while running, or if the end was missed
+  exitCode?: number;
+}
+
+export interface ProcessTimelineListResultPayload {
+  processId: string;
+  commands: TimelineCommand[];
+  hasMore: boolean; // Older commands remain; ask with before set to the last id
+  code?: ErrorCode;
+  error?: string;
 }
 
 export interface ProcessSelectPayload {
   processId: string;
 }
 
-export interface ProcessKillPayload {
+/**
+ * Kills a process. Its history is kept, archived under its ID (see
+ * ArchivedProcess), unless keepHistory is false.
+ */
+export interface ProcessKillPayload extends Confirmation {
   processId: string;
+  keepHistory?: boolean; // true when omitted
 }
 
 export interface ProcessKilledPayload {
   processId: string;
+  archived?: boolean; // Its history was kept
+}
+
+// A process that joined a host's list without a process_create, i.e. a
+// reattached one
+export interface ProcessAddedPayload {
+  hostId: string;
+  process: ProcessInfo;
+  // Set when the reattach found the tmux session had been recreated and
+  // dropped the stored port, type and env vars of the original process
+  metadataDiscarded?: boolean;
+}
+
+// detached: its PTY couldn't be reattached; it is listed as stale instead
+export type ProcessRemovedReason = 'detached';
+
+// A process that left a host's list without a process_kill
+export interface ProcessRemovedPayload {
+  hostId: string;
+  processId: string;
+  reason: ProcessRemovedReason;
+}
+
+// A host's stale processes, all of them, after the list changed
+export interface StaleProcessesChangedPayload {
+  hostId: string;
+  staleProcesses: StaleProcess[];
+}
+
+// A process's new working directory, found by the periodic pane refresh
+export interface ProcessCWDChangedPayload {
+  processId: string;
+  cwd: string;
+}
+
+/**
+ * A process killed with its history kept. chat_history, pty_history_request
+ * and process_timeline_list work on its ID until it is deleted, by
+ * archived_process_delete or once it is older than the bridge's archive
+ * max age.
+ */
+export interface ArchivedProcess {
+  id: string;
+  hostId: string;
+  type: ProcessType;
+  name?: string;
+  cwd?: string;
+  startedAt: string; // ISO timestamp
+  archivedAt: string; // ISO timestamp
+  ptyHistorySize: number;
+  chatMessageCount: number;
+}
+
+/**
+ * Lists the archived processes of a host, or of every host when hostId is
+ * omitted
+ */
+export interface ArchivedProcessListPayload {
+  hostId?: string;
+}
+
+export interface ArchivedProcessListResultPayload {
+  processes: ArchivedProcess[]; // Most recently archived first
+  code?: ErrorCode;
+  error?: string;
+}
+
+export interface ArchivedProcessGetPayload {
+  processId: string;
+}
+
+export interface ArchivedProcessGetResultPayload {
+  process: ArchivedProcess;
+}
+
+/**
+ * Deletes an archived process with its history
+ */
+export interface ArchivedProcessDeletePayload {
+  processId: string;
+}
+
+export interface ArchivedProcessDeleteResultPayload {
+  success: boolean;
+  id?: string;
+  code?: ErrorCode;
+  error?: string;
 }
 
 export interface ProcessReattachPayload {
   hostId: string;
   tmuxSession: string;
   processId: string; // Original process ID from tmux session name
+  cols?: number;
+  rows?: number;
 }
 
 export interface ProcessRenamePayload {
@@ -317,6 +1115,7 @@ export interface ProcessRenamePayload {
 
 export interface ProcessUpdatedPayload {
   id: string;
+  hostId?: string;
   type: ProcessType;
   port?: number;
   name?: string;
@@ -324,6 +1123,46 @@ export interface ProcessUpdatedPayload {
   agentApiReady: boolean;
   shellPid?: number;
   agentApiPid?: number;
+  workspaceId?: string;
+  cwd?: string;
+  claudeCwd?: string;
+  pinned: boolean;
+  sortWeight: number;
+  termOptions?: TermOptions;
+  timeline: boolean;
+  exited?: boolean;
+  lastError?: ProcessError;
+  agentStatus?: 'running' | 'stable';
+  color?: AppearanceColor;
+  icon?: AppearanceIcon;
+  retainFullHistory?: boolean;
+  terminalAttached?: boolean;
+}
+
+/**
+ * Subscribes to pushed process state for a host: process_created,
+ * process_updated and process_killed. The bridge replies with a
+ * process_list_result snapshot.
+ */
+export interface ProcessesSubscribePayload {
+  hostId: string;
+}
+
+export interface ProcessesUnsubscribePayload {
+  hostId: string;
+}
+
+export type ProcessAlertKind = 'bell' | 'activity';
+
+/**
+ * Pushed to a host's process subscribers when a process the bridge isn't
+ * attached to rings the bell or has output
+ */
+export interface ProcessAlertPayload {
+  processId: string;
+  hostId: string;
+  kind: ProcessAlertKind;
+  timestamp: string; // ISO timestamp
 }
 
 // ============================================================================
@@ -335,7 +1174,13 @@ export interface ClaudeStartPayload {
   claudeArgs?: string; // Optional extra arguments for claude command (e.g., "--continue", "-s")
 }
 
-export interface ClaudeKillPayload {
+export interface ClaudeKillPayload extends Confirmation {
+  processId: string;
+}
+
+// Types agentapi attach into the pane again; only while it shows an idle shell,
+// else INVALID_STATE. Answered with process_updated.
+export interface ClaudeReattachTerminalPayload {
   processId: string;
 }
 
@@ -343,14 +1188,21 @@ export interface ClaudeKillPayload {
 // PTY (Terminal) Payloads
 // ============================================================================
 
+// How input bound for a terminal or Claude is sanitized: 'text' keeps text,
+// newlines and tabs; 'keys' also keeps the sequences of named keys (Enter,
+// arrows, Ctrl+letter, ...); 'raw' sends it as is
+export type InputMode = 'text' | 'keys' | 'raw';
+
 export interface PtyInputPayload {
   processId: string;
   data: string;
+  mode?: InputMode; // Default 'raw', as terminal emulators send anything
 }
 
 export interface PtyOutputPayload {
   processId: string;
   data: string;
+  serverTs?: number; // Bridge clock (ms since epoch) when sent; with the server_timestamps capability
 }
 
 export interface PtyResizePayload {
@@ -359,12 +1211,42 @@ export interface PtyResizePayload {
   rows: number;
 }
 
+/** The screen as it is now, sent on process_select before live output, and after a new shell's env capture */
+export interface PtySnapshotPayload {
+  processId: string;
+  cols: number;
+  rows: number;
+  data: string; // Visible screen plus some scrollback, with escape sequences and \r\n line endings
+}
+
+// ============================================================================
+// PTY Scrollback Payloads
+// ============================================================================
+
+export type PtyScrollAction = 'up' | 'down' | 'pageUp' | 'pageDown' | 'top' | 'bottom' | 'exit';
+
+/** Scrolls the pane in tmux copy mode; pty_input leaves copy mode first */
+export interface PtyScrollPayload {
+  processId: string;
+  action: PtyScrollAction; // Back enters copy mode; forward to the bottom, bottom and exit leave it
+  count?: number; // Lines or pages (1-10000, default 1)
+}
+
+/** Pushed after pty_scroll, and after pty_input left copy mode */
+export interface PtyScrollStatePayload {
+  processId: string;
+  inCopyMode: boolean; // False once live output resumes
+  scrollPosition: number; // Lines above the live screen; 0 at the bottom
+  historySize: number; // Lines of scrollback tmux holds
+}
+
 // ============================================================================
 // PTY History Payloads
 // ============================================================================
 
 export interface PtyHistoryRequestPayload {
   processId: string;
+  chunkSize?: number; // Bytes per chunk, clamped to 8 KB-512 KB (default 64 KB)
 }
 
 export interface PtyHistoryResponsePayload {
@@ -384,18 +1266,39 @@ export interface PtyHistoryChunkPayload {
 export interface PtyHistoryCompletePayload {
   processId: string;
   success: boolean;
+  code?: ErrorCode;
   error?: string;
+  stats?: PtyHistoryTransferStats; // Set when the transfer succeeded
+}
+
+export interface PtyHistoryTransferStats {
+  bytes: number; // History bytes sent, before base64
+  chunks: number;
+  chunkSize: number; // Chunk size used after clamping
+  durationMs: number;
+  bytesPerSecond: number;
 }
 
 // ============================================================================
 // Chat (AgentAPI) Payloads
 // ============================================================================
 
+// processId '*' subscribes to chat events from every process on the host
 export interface ChatSubscribePayload {
   hostId: string;
   processId: string;
 }
 
+export interface ChatSubscribeResultPayload {
+  hostId: string;
+  processId: string;
+  success: boolean;
+  status?: string; // Current agent status; omitted for host-wide subscriptions
+  latestMessageId?: number; // Latest cached chat message, if any
+  code?: ErrorCode;
+  error?: string;
+}
+
 export interface ChatUnsubscribePayload {
   hostId: string;
   processId: string;
@@ -405,12 +1308,28 @@ export interface ChatSendPayload {
   hostId: string;
   processId: string;
   content: string;
+  clientMessageId?: string; // Up to 64 characters; echoed in the result, the bridge assigns one without it
 }
 
-export interface ChatRawPayload {
+// Answers every chat_send. On success, message is the sent message as
+// cached, pending until AgentAPI echoes it: the chat_event with that
+// message_update carries the same clientMessageId. On failure an error with
+// code was sent first.
+export interface ChatSendResultPayload {
+  hostId: string;
+  processId: string;
+  clientMessageId: string;
+  success: boolean;
+  message?: ChatMessage;
+  code?: ErrorCode;
+  error?: string;
+}
+
+export interface ChatRawPayload {
   hostId: string;
   processId: string;
   content: string; // Raw keystroke, e.g., "\x03" for Ctrl+C
+  mode?: InputMode; // Default 'keys'
 }
 
 export type ChatEventType = 'message_update' | 'status_change';
@@ -432,11 +1351,14 @@ export interface ChatEventPayload {
   processId: string;
   event: ChatEventType;
   data: MessageUpdateData | StatusChangeData;
+  clientMessageId?: string; // On a message_update of a message sent with chat_send
+  serverTs?: number; // Bridge clock (ms since epoch) when sent; with the server_timestamps capability
 }
 
 export interface ChatStatusPayload {
   hostId: string;
   processId: string;
+  force?: boolean; // Ask AgentAPI instead of answering with the status the bridge tracks
 }
 
 export interface ChatStatusResultPayload {
@@ -452,25 +1374,128 @@ export interface ChatHistoryPayload {
 }
 
 export interface ChatMessage {
-  id: number;
+  id: number; // Negative while pending
   role: 'user' | 'assistant';
   message: string;
   time: string;
+  pending?: boolean; // Sent with chat_send, not echoed by AgentAPI yet
+  clientMessageId?: string; // Set on messages sent with chat_send
+  // Derived from what AgentAPI sent; unset on messages cached before they were recorded
+  kind?: 'message' | 'tool_call' | 'tool_result' | 'system';
+  metadata?: Record<string, unknown>; // AgentAPI's message fields beyond the ones above, as sent
 }
 
 export interface ChatMessagesPayload {
   hostId: string;
   processId: string;
   messages: ChatMessage[];
+  draft?: ChatDraft; // In answer to chat_history, when the process has one
+}
+
+// Words must all appear in a message; "quoted text" must appear as a phrase
+export interface ChatSearchPayload {
+  query: string;
+  hostId?: string; // Only this host's processes
+  role?: 'user' | 'assistant';
+  limit?: number; // Maximum matches, default 50, at most 500
+}
+
+// Span of a string, in JavaScript (UTF-16) string indexes
+export interface TextRange {
+  start: number;
+  length: number;
+}
+
+export interface ChatSearchMatch {
+  messageId: number;
+  role: 'user' | 'assistant';
+  time: string;
+  snippet: string; // Excerpt of the message around the matches
+  highlights: TextRange[]; // Matched text within snippet
+}
+
+export interface ChatSearchProcessResult {
+  processId: string;
+  hostId: string;
+  processName?: string;
+  matchCount: number; // Every match in the process, including ones past the limit
+  matches: ChatSearchMatch[];
+}
+
+export interface ChatSearchResultPayload {
+  query: string;
+  results: ChatSearchProcessResult[]; // In order of each process's best match
+  code?: ErrorCode;
+  error?: string;
+}
+
+export interface ChatUsagePayload {
+  processId: string;
+}
+
+// Input tokens exclude those written to and read from the prompt cache
+export interface ChatModelUsage {
+  model: string;
+  inputTokens: number;
+  outputTokens: number;
+  cacheCreationInputTokens: number;
+  cacheReadInputTokens: number;
+  messageCount: number; // Assistant responses
+  estimatedCostUsd?: number; // At list prices, absent for unknown models
+}
+
+export interface ChatUsage {
+  inputTokens: number;
+  outputTokens: number;
+  cacheCreationInputTokens: number;
+  cacheReadInputTokens: number;
+  messageCount: number;
+  estimatedCostUsd?: number; // Absent if any model is unknown
+  models: ChatModelUsage[];
+}
+
+// usageAvailable is false when the process has no usage to report yet
+export interface ChatUsageResultPayload {
+  processId: string;
+  usageAvailable: boolean;
+  source?: 'agentapi' | 'transcript' | 'cache';
+  updatedAt?: string; // ISO timestamp, when source is 'cache'
+  usage?: ChatUsage;
+}
+
+// Empty text or clear deletes the draft
+export interface ChatDraftSetPayload {
+  processId: string;
+  text: string; // At most 32 KB
+  clear?: boolean;
+}
+
+export interface ChatDraftGetPayload {
+  processId: string;
+}
+
+// Deleted once a chat_send starting with its text succeeds
+export interface ChatDraft {
+  text: string;
+  updatedAt: string; // ISO timestamp
+}
+
+export interface ChatDraftResultPayload {
+  processId: string;
+  draft?: ChatDraft; // Absent when the process has none
 }
 
 // ============================================================================
 // Environment Variables Payloads
 // ============================================================================
 
+// Secret-looking values arrive masked with isMasked set; use env_reveal to
+// fetch the real value. Masked vars can be sent back in env_update unchanged
+// to keep their current value.
 export interface EnvVar {
   key: string;
   value: string;
+  isMasked?: boolean;
 }
 
 // Host-level env management
@@ -478,9 +1503,24 @@ export interface EnvListPayload {
   hostId: string;
 }
 
+// A var that would shadow a system var of the same name is refused unless
+// its key is in allowOverride; PATH, HOME, SHELL and USER need force. A key
+// the managed section already holds is only a conflict if it is one of those.
 export interface EnvUpdatePayload {
   hostId: string;
   customVars: EnvVar[];
+  dryRun?: boolean; // Preview the change without writing the RC file
+  allowOverride?: string[]; // Keys that may shadow a system var
+  force?: boolean; // Allows PATH, HOME, SHELL and USER
+}
+
+// A custom var that shadows a system var
+export interface EnvConflict {
+  key: string;
+  systemValue?: string; // The value shadowed, masked for secrets; unset when the system has none
+  isMasked: boolean;
+  critical: boolean; // PATH, HOME, SHELL or USER, which need force
+  allowed: boolean; // By allowOverride, or force for a critical key
 }
 
 export interface EnvResultPayload {
@@ -489,6 +1529,13 @@ export interface EnvResultPayload {
   customVars: EnvVar[];
   rcFile: string;
   detectedRcFile: string;
+  dryRun?: boolean;
+  diff?: string; // Dry run: unified diff of the RC file, "" if unchanged; secrets masked
+  managedSection?: string; // Dry run: the section that would be written, "" if none
+  // env_update: the requested vars that shadow system ones. The update is
+  // refused, with error set, while any isn't allowed; a dry run previews it anyway.
+  conflicts?: EnvConflict[];
+  code?: ErrorCode;
   error?: string;
 }
 
@@ -497,15 +1544,54 @@ export interface EnvSetRcFilePayload {
   rcFile: string;
 }
 
+export interface EnvRevealPayload {
+  hostId: string;
+  key: string;
+}
+
+export interface EnvRevealResultPayload {
+  hostId: string;
+  key: string;
+  success: boolean;
+  value?: string;
+  code?: ErrorCode;
+  error?: string;
+}
+
 // Process-level env viewer (read-only)
+// 'current' and 'diff' capture the env again from the live pane: only while
+// it runs a shell, and at most once per process every 10 seconds
+export type ProcessEnvMode = 'spawn' | 'current' | 'diff';
+
 export interface ProcessEnvListPayload {
   processId: string;
+  mode?: ProcessEnvMode; // Default 'spawn'
 }
 
 export interface ProcessEnvResultPayload {
   processId: string;
-  vars: EnvVar[];
+  mode: ProcessEnvMode;
+  vars: EnvVar[]; // Current vars in 'current' and 'diff' modes
+  diff?: ProcessEnvDiff; // 'diff' mode only
+  code?: ErrorCode;
   error?: string;
+  retryAfterMs?: number; // When the capture was refused by the rate limit
+}
+
+// How the current env differs from the spawn snapshot, sorted by key;
+// secret values are masked
+export interface ProcessEnvDiff {
+  added: EnvVar[];
+  removed: EnvVar[];
+  changed: EnvVarChange[];
+}
+
+// A secret's old and new values are both masked; only the change shows
+export interface EnvVarChange {
+  key: string;
+  old: string;
+  new: string;
+  isMasked: boolean;
 }
 
 // ============================================================================
@@ -524,6 +1610,7 @@ export interface PortInfo {
   processType?: ProcessType; // From DB mapping
   // Enriched from netstat/ss/lsof
   netPid?: number;          // PID from network tool
+  netPidMethod?: 'ss' | 'netstat' | 'lsof' | 'proc'; // How netPid was found; proc needs no tools
   netProcess?: string;      // Process name from network tool
   netUser?: string;         // User from network tool
 }
@@ -533,9 +1620,93 @@ export interface PortsResultPayload {
   ports: PortInfo[];
   netTool?: string;         // Which tool was used: 'ss', 'netstat', 'lsof', or undefined if none
   netToolError?: string;    // Error message if no tool available
+  code?: ErrorCode;
   error?: string;
 }
 
+// ============================================================================
+// File Download Payloads
+// ============================================================================
+
+// Streams a file from a host, following symbolic links. The file arrives in
+// file_download_chunk messages, followed by file_download_complete; a file
+// that can't be read is refused with an error naming the downloadId.
+export interface FileDownloadPayload {
+  downloadId: string; // Chosen by the client; names the download's messages and file_download_cancel
+  hostId: string;
+  path: string;
+  processId?: string; // Relative paths resolve against its CWD; default: the login directory
+}
+
+export interface FileDownloadChunkPayload {
+  downloadId: string;
+  seq: number; // 0 for the first chunk
+  data: string; // Base64 encoded
+  totalSize: number; // Size of the whole file, before base64
+}
+
+// On success size and sha256 describe the bytes sent, to check the reassembled file
+export interface FileDownloadCompletePayload {
+  downloadId: string;
+  hostId: string;
+  path: string; // As requested
+  resolvedPath: string; // Absolute, with symbolic links followed
+  symlink: boolean; // path is a symbolic link to resolvedPath
+  success: boolean;
+  cancelled: boolean; // Stopped by file_download_cancel
+  code?: ErrorCode;
+  error?: string;
+  size: number; // Bytes sent
+  chunks: number;
+  sha256?: string; // Hex, set on success
+}
+
+// Stops a download the session started
+export interface FileDownloadCancelPayload {
+  downloadId: string;
+}
+
+// ============================================================================
+// File Upload Payloads
+// ============================================================================
+
+// Starts an upload to a host. The file follows in file_upload_chunk messages
+// and is written by file_upload_commit; nothing is answered unless the upload
+// is refused, with an error naming the uploadId. An upload left without
+// messages for the bridge's idle timeout (5 minutes by default) is dropped.
+export interface FileUploadBeginPayload {
+  uploadId: string; // Chosen by the client; names the upload's messages
+  hostId: string;
+  path: string;
+  processId?: string; // Relative paths resolve against its CWD; default: the login directory
+  size: number;
+  sha256: string; // Hex, of the whole file
+  mode?: string; // Octal permissions, e.g. "0755"; default: "0644"
+  overwrite?: boolean; // Replace a file already at path
+}
+
+// Chunks may arrive in any order; one sent again replaces the first
+export interface FileUploadChunkPayload {
+  uploadId: string;
+  seq: number; // 0 for the first chunk
+  data: string; // Base64 encoded
+}
+
+// Checks the upload's size and checksum and writes it, answered by file_upload_result
+export interface FileUploadCommitPayload {
+  uploadId: string;
+}
+
+export interface FileUploadResultPayload {
+  uploadId: string;
+  hostId: string;
+  path: string; // As requested
+  resolvedPath: string; // Absolute
+  size: number;
+  mode: string; // Octal, e.g. "0644"
+  replaced: boolean; // A file was overwritten
+}
+
 // ============================================================================
 // Snippets Payloads
 // ============================================================================
@@ -567,6 +1738,7 @@ export interface SnippetCreatePayload {
 export interface SnippetCreateResultPayload {
   success: boolean;
   snippet?: Snippet;
+  code?: ErrorCode;
   error?: string;
 }
 
@@ -580,6 +1752,7 @@ export interface SnippetUpdatePayload {
 export interface SnippetUpdateResultPayload {
   success: boolean;
   snippet?: Snippet;
+  code?: ErrorCode;
   error?: string;
 }
 
@@ -591,17 +1764,467 @@ export interface SnippetDeletePayload {
 export interface SnippetDeleteResultPayload {
   success: boolean;
   id?: string;
+  code?: ErrorCode;
   error?: string;
 }
 
+// Type a snippet into one process (processId), several on any hosts
+// (processIds) or a workspace's (workspaceId); exactly one is given.
+// {{name}} is rendered per process from variables, or else the process's
+// processId, processName, hostId, cwd or claudeCwd.
+export interface SnippetExecutePayload {
+  requestId?: string; // Echoed in the result
+  snippetId: string;
+  processId?: string;
+  processIds?: string[];
+  workspaceId?: string;
+  variables?: Record<string, string>;
+  mode?: InputMode; // How the rendered content is sanitized; default 'text'
+}
+
+// Outcome per target, in the order named; a failed target doesn't stop the others
+export interface SnippetExecuteResultPayload {
+  requestId?: string;
+  snippetId: string;
+  mode: InputMode; // Applied
+  results: SnippetTargetResult[];
+}
+
+export interface SnippetTargetResult {
+  processId: string;
+  hostId?: string; // Absent when the process isn't known
+  success: boolean;
+  code?: ErrorCode; // SNIPPET_RENDER_ERROR, or what a pty_input would have failed with
+  error?: string; // Untranslated particulars
+  suggestedAction?: SuggestedAction;
+}
+
+// ============================================================================
+// Workspace Payloads
+// ============================================================================
+
+/** A group of processes (possibly on different hosts) shown as one view */
+export interface Workspace {
+  id: string;
+  name: string;
+  processIds: string[];
+  createdAt: string; // ISO timestamp
+  updatedAt: string; // ISO timestamp
+}
+
+// List all workspaces
+export interface WorkspaceListPayload {
+  // empty - no params needed
+}
+
+export interface WorkspaceListResultPayload {
+  workspaces: Workspace[];
+}
+
+// Create a new workspace
+export interface WorkspaceCreatePayload {
+  name: string;
+}
+
+export interface WorkspaceCreateResultPayload {
+  success: boolean;
+  workspace?: Workspace;
+  code?: ErrorCode;
+  error?: string;
+}
+
+// Rename a workspace
+export interface WorkspaceUpdatePayload {
+  id: string;
+  name?: string;
+}
+
+export interface WorkspaceUpdateResultPayload {
+  success: boolean;
+  workspace?: Workspace;
+  code?: ErrorCode;
+  error?: string;
+}
+
+// Delete a workspace (its processes are unassigned, not killed)
+export interface WorkspaceDeletePayload {
+  id: string;
+}
+
+export interface WorkspaceDeleteResultPayload {
+  success: boolean;
+  id?: string;
+  code?: ErrorCode;
+  error?: string;
+}
+
+// Assign a process to a workspace (null removes the assignment)
+export interface WorkspaceAssignPayload {
+  processId: string;
+  workspaceId: string | null;
+}
+
+export interface WorkspaceAssignResultPayload {
+  success: boolean;
+  processId: string;
+  workspaceId?: string;
+  code?: ErrorCode;
+  error?: string;
+}
+
+// ============================================================================
+// Process Template Payloads
+// ============================================================================
+
+/** A saved recipe for a process: a shell set up in a directory with env
+ * vars, optionally with Claude started in it. Secret env values are masked. */
+export interface ProcessTemplate {
+  id: string;
+  name: string;
+  hostId?: string; // Host the template is for; any host when unset
+  cwd?: string;
+  env: EnvVar[];
+  shell?: string; // Command the pane runs instead of the login shell
+  claudeArgs?: string;
+  autoStartClaude: boolean;
+  startupHooks?: string[]; // Typed into the shell after the host's, in order
+  createdAt: string; // ISO timestamp
+  updatedAt: string; // ISO timestamp
+}
+
+// List all process templates
+export interface ProcessTemplateListPayload {
+  // empty - no params needed
+}
+
+export interface ProcessTemplateListResultPayload {
+  templates: ProcessTemplate[];
+}
+
+// Save a new process template
+export interface ProcessTemplateCreatePayload {
+  name: string;
+  hostId?: string;
+  cwd?: string;
+  env?: EnvVar[];
+  shell?: string;
+  claudeArgs?: string;
+  autoStartClaude?: boolean;
+  startupHooks?: string[]; // At most 20
+}
+
+export interface ProcessTemplateCreateResultPayload {
+  success: boolean;
+  template?: ProcessTemplate;
+  code?: ErrorCode;
+  error?: string;
+}
+
+// Change the fields that are set; an empty string clears one. Masked env
+// vars sent back keep their stored values.
+export interface ProcessTemplateUpdatePayload {
+  id: string;
+  name?: string;
+  hostId?: string;
+  cwd?: string;
+  env?: EnvVar[];
+  shell?: string;
+  claudeArgs?: string;
+  autoStartClaude?: boolean;
+  startupHooks?: string[]; // An empty list clears them
+}
+
+export interface ProcessTemplateUpdateResultPayload {
+  success: boolean;
+  template?: ProcessTemplate;
+  code?: ErrorCode;
+  error?: string;
+}
+
+// Delete a process template (processes created from it are untouched)
+export interface ProcessTemplateDeletePayload {
+  id: string;
+}
+
+export interface ProcessTemplateDeleteResultPayload {
+  success: boolean;
+  id?: string;
+  code?: ErrorCode;
+  error?: string;
+}
+
+/** The stages of creating a process from a template, in order */
+export type ProcessTemplateStage = 'resolve' | 'create' | 'claude';
+
+// Create a process from a template. The usual process_created (and
+// process_updated when Claude starts) are sent as stages complete, then
+// the result. Env overrides replace the template's vars by key.
+export interface ProcessCreateFromTemplatePayload {
+  templateId: string;
+  hostId?: string; // Required when the template has no host
+  cwd?: string;
+  env?: EnvVar[];
+  claudeArgs?: string;
+  autoStartClaude?: boolean;
+  cols?: number;
+  rows?: number;
+  skipHooks?: boolean; // Type neither the host's nor the template's startup hooks
+}
+
+// A failed stage leaves the stages before it in place: a shell created
+// before Claude failed to start stays open
+export interface ProcessCreateFromTemplateResultPayload {
+  success: boolean;
+  templateId: string;
+  stages: ProcessTemplateStage[]; // Stages that completed
+  process?: ProcessInfo;
+  failedStage?: ProcessTemplateStage;
+  errorCode?: ErrorCode;
+  error?: string;
+}
+
+// ============================================================================
+// Bridge Info Payloads
+// ============================================================================
+
+export interface BridgeInfoPayload {
+  // empty - no params needed
+}
+
+/** Describes the running bridge for the settings diagnostics view */
+export interface BridgeInfoResultPayload {
+  version: string;
+  commit: string;
+  buildDate: string;
+  protocolVersion: number;
+  startedAt: string; // ISO timestamp
+  uptimeSeconds: number;
+  profile: string;
+  dataDir: string; // Directory holding the active profile's data
+  listenAddresses: string[];
+  hostCount: number; // Configured hosts
+  connectedHostCount: number; // Hosts with a live SSH connection
+  processCount: number; // Attached processes
+  sessionCount: number; // Client sessions, including ones awaiting reconnect
+  connectedSessions: number;
+  portRange: PortRange; // AgentAPI ports this bridge allocates and scans
+  sshChannels: number; // Channels open on all SSH connections
+  update?: BridgeUpdateStatusPayload; // Unset while update checks are off
+  ptyOutput: PtyOutputSettings; // How new processes' output is read and delivered
+}
+
+/**
+ * How PTY output is read and delivered: reads of at most readBufferSize
+ * bytes, and output read within coalesceWindowMs of a chunk merged into one
+ * pty_output of at most maxCoalescedBytes (a window of 0 sends every read as
+ * it is)
+ */
+export interface PtyOutputSettings {
+  readBufferSize: number;
+  coalesceWindowMs: number;
+  maxCoalescedBytes: number;
+}
+
+/**
+ * What the bridge's update check last found. Answers bridge_update_check, and
+ * is pushed as bridge_update_available to every session when a check finds a
+ * newer release than the last one pushed. The bridge never installs anything.
+ */
+export interface BridgeUpdateStatusPayload {
+  currentVersion: string;
+  available: boolean; // latestVersion is newer than currentVersion
+  latestVersion?: string; // Unset until a check succeeds
+  releaseUrl?: string; // Where to get the latest release
+  notes?: string; // Release notes
+  latestProtocolVersion?: number; // Protocol the latest release speaks, when the manifest says
+  checkedAt?: string; // ISO timestamp of the last check
+  error?: string; // Why the last check failed, or that checks are off
+}
+
+// Inclusive range of ports
+export interface PortRange {
+  min: number;
+  max: number;
+}
+
+// ============================================================================
+// Profile Payloads
+// ============================================================================
+
+export interface ProfileListPayload {
+  // empty - no params needed
+}
+
+export interface ProfileListResultPayload {
+  profiles: string[]; // 'default' first, then named profiles
+  current: string;
+  code?: ErrorCode;
+  error?: string;
+}
+
+// ============================================================================
+// Storage Encryption Payloads
+// ============================================================================
+
+export interface StorageEncryptNowPayload {
+  // empty - no params needed
+}
+
+/**
+ * Reports storage_encrypt_now working through a table: once as it starts,
+ * then after every batch of rows
+ */
+export interface StorageEncryptProgressPayload {
+  table: string; // 'pty_history', 'chat_history' or 'process_metadata'
+  done: number; // Rows encrypted so far
+  total: number; // Rows not yet encrypted when the table was started
+}
+
+export interface StorageEncryptResultPayload {
+  success: boolean;
+  encrypted: number; // Rows encrypted, in every table
+  code?: ErrorCode;
+  error?: string;
+}
+
+// ============================================================================
+// Storage Flush Payloads
+// ============================================================================
+
+/**
+ * Saves buffered PTY history and chat to the database now, e.g. before an
+ * operation that might take the bridge down
+ */
+export interface StorageFlushPayload {
+  requestId?: string; // Echoed in the result
+  processId?: string; // Only this process's buffers; every process's when absent
+}
+
+export interface StorageFlushResultPayload {
+  requestId?: string;
+  processId?: string;
+  success: boolean;
+  durationMs: number;
+  code?: ErrorCode;
+  error?: string;
+}
+
+// ============================================================================
+// Confirmation Payloads
+// ============================================================================
+
+/**
+ * Makes a destructive request two-phase: with confirmRequired (or when the
+ * bridge requires it) the bridge answers with a confirmation_challenge, and
+ * the request is sent again with its token
+ */
+export interface Confirmation {
+  confirmRequired?: boolean;
+  confirmToken?: string;
+}
+
+/** Single-use, valid on this session for the same request until it expires */
+export interface ConfirmationChallengePayload {
+  action: MessageType; // Message type to send again, with confirmToken
+  target: string; // processId, or host config id
+  token: string;
+  expiresInSeconds: number;
+  summary: string; // What will be destroyed, for showing the user
+  // Set when the target is a process
+  processName?: string;
+  processType?: ProcessType;
+  uptimeSeconds?: number;
+  chatMessages?: number; // Cached chat messages of a Claude process
+}
+
 // ============================================================================
 // Error Payload
 // ============================================================================
 
+export type ErrorCode =
+  // Message handling
+  | 'INVALID_MESSAGE'
+  | 'UNKNOWN_MESSAGE_TYPE'
+  | 'HANDLER_ERROR'
+  | 'INVALID_ARGS'
+  | 'VALIDATION_ERROR' // Payload failed validation
+  | 'STORAGE_ERROR'
+  | 'UNAUTHORIZED' // Auth token, invite or REST API token missing or wrong
+  | 'FORBIDDEN' // Request not allowed, e.g. a write on the read-only admin socket
+  | 'NOT_AUTHENTICATED' // Request sent before a successful auth
+  | 'PROTOCOL_MISMATCH' // App speaks an older protocol than the bridge's PROTOCOL_VERSION
+  // Hosts
+  | 'NOT_CONNECTED'
+  | 'SSH_DOWN' // Host connection died under a PTY operation
+  // Processes
+  | 'NOT_FOUND'
+  | 'ALREADY_EXISTS'
+  | 'ATTACH_FAILED'
+  | 'INVALID_STATE'
+  | 'NOT_CLAUDE'
+  | 'NO_PORTS'
+  // PTY and chat
+  | 'NO_PTY'
+  | 'PTY_NOT_READY'
+  | 'PTY_ERROR'
+  | 'PTY_DETACHED' // PTY has no live attachment
+  | 'PTY_CLOSED' // PTY session was closed
+  | 'SEND_FAILED' // AgentAPI refused the message
+  | 'AGENT_BUSY' // Agent is running and takes no message until it is stable
+  | 'AGENTAPI_DOWN' // AgentAPI unreachable or failing
+  // Command timeline
+  | 'UNSUPPORTED_SHELL' // Shell has no timeline hooks
+  // Host commands
+  | 'EXEC_LIMIT' // Too many host_exec commands running for the session
+  | 'EXEC_FAILED'
+  // File downloads and uploads
+  | 'FILE_NOT_FOUND' // File, or an upload's directory, doesn't exist
+  | 'PERMISSION_DENIED' // File can't be read, or written, by the host user
+  | 'NOT_A_FILE' // Path is a directory or special file
+  | 'FILE_TOO_LARGE'
+  | 'UPLOAD_INCOMPLETE' // Committed with chunks missing or the wrong size; the upload is kept
+  | 'CHECKSUM_MISMATCH' // Uploaded bytes don't match the declared sha256; the upload is dropped
+  // Snippets
+  | 'SNIPPET_RENDER_ERROR' // Snippet uses a variable with no value for the process
+  // Confirmation of destructive requests
+  | 'CONFIRMATION_INVALID'; // Token unknown, used, expired or for another request
+
+// message describes code in the session's locale; details.reason, where
+// present, is the untranslated particulars for logs
 export interface ErrorPayload {
-  code: string;
+  code: ErrorCode;
   message: string;
-  details?: unknown;
+  details?: InvalidArgsDetails | ValidationErrorDetails | PtyFailureDetails | Record<string, unknown>; // e.g. { processId, port }
+}
+
+/** Details of an INVALID_ARGS error */
+export interface InvalidArgsDetails {
+  tokens: string[]; // Offending tokens, as written by the user
+  reason: string;
+}
+
+/** A payload field that failed validation */
+export interface FieldError {
+  field: string; // JSON path, e.g. "customVars[1].key"
+  rule: 'required' | 'min' | 'max' | 'oneof' | 'type';
+  message: string;
+}
+
+/** Details of a VALIDATION_ERROR */
+export interface ValidationErrorDetails {
+  type: MessageType; // Message type of the rejected request
+  fields: FieldError[];
+}
+
+/** How to recover from an error: send process_reattach or host_connect */
+export type SuggestedAction = 'reattach_process' | 'reconnect_host';
+
+/** Details of PTY_DETACHED, PTY_CLOSED and SSH_DOWN from pty_input or pty_resize */
+export interface PtyFailureDetails {
+  processId: string;
+  hostId: string;
+  suggestedAction?: SuggestedAction;
+  reason?: string;
 }
 
 // ============================================================================
@@ -620,11 +2243,35 @@ export function createMessage<T>(type: MessageType, payload: T): Message<T> {
 export const Messages = {
   // Auth
   auth: (payload: AuthPayload = {}) =>
-    createMessage(MessageTypes.AUTH, payload),
+    createMessage(MessageTypes.AUTH, { protocolVersion: PROTOCOL_VERSION, ...payload }),
 
   authResult: (payload: AuthResultPayload) =>
     createMessage(MessageTypes.AUTH_RESULT, payload),
 
+  sessionRefreshToken: (payload: SessionRefreshTokenPayload) =>
+    createMessage(MessageTypes.SESSION_REFRESH_TOKEN, payload),
+
+  sessionRefreshTokenResult: (payload: SessionRefreshTokenResultPayload) =>
+    createMessage(MessageTypes.SESSION_REFRESH_TOKEN_RESULT, payload),
+
+  sessionInviteCreate: (payload: SessionInviteCreatePayload = {}) =>
+    createMessage(MessageTypes.SESSION_INVITE_CREATE, payload),
+
+  sessionInviteCreateResult: (payload: SessionInviteCreateResultPayload) =>
+    createMessage(MessageTypes.SESSION_INVITE_CREATE_RESULT, payload),
+
+  sessionInviteRevoke: (payload: SessionInviteRevokePayload) =>
+    createMessage(MessageTypes.SESSION_INVITE_REVOKE, payload),
+
+  sessionInviteRevokeResult: (payload: SessionInviteRevokeResultPayload) =>
+    createMessage(MessageTypes.SESSION_INVITE_REVOKE_RESULT, payload),
+
+  sessionInfo: () =>
+    createMessage(MessageTypes.SESSION_INFO, {}),
+
+  sessionInfoResult: (payload: SessionInfoResultPayload) =>
+    createMessage(MessageTypes.SESSION_INFO_RESULT, payload),
+
   // Host Config (CRUD)
   hostConfigList: () =>
     createMessage(MessageTypes.HOST_CONFIG_LIST, {}),
@@ -650,6 +2297,12 @@ export const Messages = {
   hostConfigDeleteResult: (payload: HostConfigDeleteResultPayload) =>
     createMessage(MessageTypes.HOST_CONFIG_DELETE_RESULT, payload),
 
+  hostConfigImportSSHConfig: (payload: HostConfigImportSSHConfigPayload) =>
+    createMessage(MessageTypes.HOST_CONFIG_IMPORT_SSHCONFIG, payload),
+
+  hostConfigImportSSHConfigResult: (payload: HostConfigImportSSHConfigResultPayload) =>
+    createMessage(MessageTypes.HOST_CONFIG_IMPORT_SSHCONFIG_RESULT, payload),
+
   // Host Connection (runtime)
   hostConnect: (payload: HostConnectPayload) =>
     createMessage(MessageTypes.HOST_CONNECT, payload),
@@ -660,12 +2313,30 @@ export const Messages = {
   hostStatus: (payload: HostStatusPayload) =>
     createMessage(MessageTypes.HOST_STATUS, payload),
 
+  hostStatusRequest: (payload: HostStatusRequestPayload) =>
+    createMessage(MessageTypes.HOST_STATUS_REQUEST, payload),
+
   hostCheckRequirements: (payload: HostCheckRequirementsPayload) =>
     createMessage(MessageTypes.HOST_CHECK_REQUIREMENTS, payload),
 
   hostRequirementsResult: (payload: HostRequirementsResultPayload) =>
     createMessage(MessageTypes.HOST_REQUIREMENTS_RESULT, payload),
 
+  hostConnectProgress: (payload: HostConnectProgressPayload) =>
+    createMessage(MessageTypes.HOST_CONNECT_PROGRESS, payload),
+
+  hostExec: (payload: HostExecPayload) =>
+    createMessage(MessageTypes.HOST_EXEC, payload),
+
+  hostExecResult: (payload: HostExecResultPayload) =>
+    createMessage(MessageTypes.HOST_EXEC_RESULT, payload),
+
+  hostDiagnostics: (payload: HostDiagnosticsPayload) =>
+    createMessage(MessageTypes.HOST_DIAGNOSTICS, payload),
+
+  hostDiagnosticsResult: (payload: HostDiagnosticsResultPayload) =>
+    createMessage(MessageTypes.HOST_DIAGNOSTICS_RESULT, payload),
+
   // Process
   processList: (payload: ProcessListPayload) =>
     createMessage(MessageTypes.PROCESS_LIST, payload),
@@ -697,6 +2368,47 @@ export const Messages = {
   processRename: (payload: ProcessRenamePayload) =>
     createMessage(MessageTypes.PROCESS_RENAME, payload),
 
+  processClone: (payload: ProcessClonePayload) =>
+    createMessage(MessageTypes.PROCESS_CLONE, payload),
+
+  processPin: (payload: ProcessPinPayload) =>
+    createMessage(MessageTypes.PROCESS_PIN, payload),
+
+  processSetOrder: (payload: ProcessSetOrderPayload) =>
+    createMessage(MessageTypes.PROCESS_SET_ORDER, payload),
+
+  processTermOptions: (payload: ProcessTermOptionsPayload) =>
+    createMessage(MessageTypes.PROCESS_TERM_OPTIONS, payload),
+  processClearError: (payload: ProcessClearErrorPayload) =>
+    createMessage(MessageTypes.PROCESS_CLEAR_ERROR, payload),
+
+  processSetAppearance: (payload: ProcessSetAppearancePayload) =>
+    createMessage(MessageTypes.PROCESS_SET_APPEARANCE, payload),
+
+  processEnableTimeline: (payload: ProcessEnableTimelinePayload) =>
+    createMessage(MessageTypes.PROCESS_ENABLE_TIMELINE, payload),
+
+  processTimelineList: (payload: ProcessTimelineListPayload) =>
+    createMessage(MessageTypes.PROCESS_TIMELINE_LIST, payload),
+
+  archivedProcessList: (payload: ArchivedProcessListPayload) =>
+    createMessage(MessageTypes.ARCHIVED_PROCESS_LIST, payload),
+
+  archivedProcessGet: (payload: ArchivedProcessGetPayload) =>
+    createMessage(MessageTypes.ARCHIVED_PROCESS_GET, payload),
+
+  archivedProcessDelete: (payload: ArchivedProcessDeletePayload) =>
+    createMessage(MessageTypes.ARCHIVED_PROCESS_DELETE, payload),
+
+  processesSubscribe: (payload: ProcessesSubscribePayload) =>
+    createMessage(MessageTypes.PROCESSES_SUBSCRIBE, payload),
+
+  processesUnsubscribe: (payload: ProcessesUnsubscribePayload) =>
+    createMessage(MessageTypes.PROCESSES_UNSUBSCRIBE, payload),
+
+  processAlert: (payload: ProcessAlertPayload) =>
+    createMessage(MessageTypes.PROCESS_ALERT, payload),
+
   // Claude conversion
   claudeStart: (payload: ClaudeStartPayload) =>
     createMessage(MessageTypes.CLAUDE_START, payload),
@@ -704,6 +2416,9 @@ export const Messages = {
   claudeKill: (payload: ClaudeKillPayload) =>
     createMessage(MessageTypes.CLAUDE_KILL, payload),
 
+  claudeReattachTerminal: (payload: ClaudeReattachTerminalPayload) =>
+    createMessage(MessageTypes.CLAUDE_REATTACH_TERMINAL, payload),
+
   // PTY
   ptyInput: (payload: PtyInputPayload) =>
     createMessage(MessageTypes.PTY_INPUT, payload),
@@ -714,6 +2429,15 @@ export const Messages = {
   ptyResize: (payload: PtyResizePayload) =>
     createMessage(MessageTypes.PTY_RESIZE, payload),
 
+  ptySnapshot: (payload: PtySnapshotPayload) =>
+    createMessage(MessageTypes.PTY_SNAPSHOT, payload),
+
+  ptyScroll: (payload: PtyScrollPayload) =>
+    createMessage(MessageTypes.PTY_SCROLL, payload),
+
+  ptyScrollState: (payload: PtyScrollStatePayload) =>
+    createMessage(MessageTypes.PTY_SCROLL_STATE, payload),
+
   // PTY History
   ptyHistoryRequest: (payload: PtyHistoryRequestPayload) =>
     createMessage(MessageTypes.PTY_HISTORY_REQUEST, payload),
@@ -731,12 +2455,18 @@ export const Messages = {
   chatSubscribe: (payload: ChatSubscribePayload) =>
     createMessage(MessageTypes.CHAT_SUBSCRIBE, payload),
 
+  chatSubscribeResult: (payload: ChatSubscribeResultPayload) =>
+    createMessage(MessageTypes.CHAT_SUBSCRIBE_RESULT, payload),
+
   chatUnsubscribe: (payload: ChatUnsubscribePayload) =>
     createMessage(MessageTypes.CHAT_UNSUBSCRIBE, payload),
 
   chatSend: (payload: ChatSendPayload) =>
     createMessage(MessageTypes.CHAT_SEND, payload),
 
+  chatSendResult: (payload: ChatSendResultPayload) =>
+    createMessage(MessageTypes.CHAT_SEND_RESULT, payload),
+
   chatRaw: (payload: ChatRawPayload) =>
     createMessage(MessageTypes.CHAT_RAW, payload),
 
@@ -755,6 +2485,27 @@ export const Messages = {
   chatMessages: (payload: ChatMessagesPayload) =>
     createMessage(MessageTypes.CHAT_MESSAGES, payload),
 
+  chatSearch: (payload: ChatSearchPayload) =>
+    createMessage(MessageTypes.CHAT_SEARCH, payload),
+
+  chatSearchResult: (payload: ChatSearchResultPayload) =>
+    createMessage(MessageTypes.CHAT_SEARCH_RESULT, payload),
+
+  chatUsage: (payload: ChatUsagePayload) =>
+    createMessage(MessageTypes.CHAT_USAGE, payload),
+
+  chatUsageResult: (payload: ChatUsageResultPayload) =>
+    createMessage(MessageTypes.CHAT_USAGE_RESULT, payload),
+
+  chatDraftSet: (payload: ChatDraftSetPayload) =>
+    createMessage(MessageTypes.CHAT_DRAFT_SET, payload),
+
+  chatDraftGet: (payload: ChatDraftGetPayload) =>
+    createMessage(MessageTypes.CHAT_DRAFT_GET, payload),
+
+  chatDraftResult: (payload: ChatDraftResultPayload) =>
+    createMessage(MessageTypes.CHAT_DRAFT_RESULT, payload),
+
   // Environment Variables - Host Level
   envList: (payload: EnvListPayload) =>
     createMessage(MessageTypes.ENV_LIST, payload),
@@ -768,6 +2519,12 @@ export const Messages = {
   envSetRcFile: (payload: EnvSetRcFilePayload) =>
     createMessage(MessageTypes.ENV_SET_RC_FILE, payload),
 
+  envReveal: (payload: EnvRevealPayload) =>
+    createMessage(MessageTypes.ENV_REVEAL, payload),
+
+  envRevealResult: (payload: EnvRevealResultPayload) =>
+    createMessage(MessageTypes.ENV_REVEAL_RESULT, payload),
+
   // Environment Variables - Process Level
   processEnvList: (payload: ProcessEnvListPayload) =>
     createMessage(MessageTypes.PROCESS_ENV_LIST, payload),
@@ -782,6 +2539,32 @@ export const Messages = {
   portsResult: (payload: PortsResultPayload) =>
     createMessage(MessageTypes.PORTS_RESULT, payload),
 
+  // File downloads
+  fileDownload: (payload: FileDownloadPayload) =>
+    createMessage(MessageTypes.FILE_DOWNLOAD, payload),
+
+  fileDownloadChunk: (payload: FileDownloadChunkPayload) =>
+    createMessage(MessageTypes.FILE_DOWNLOAD_CHUNK, payload),
+
+  fileDownloadComplete: (payload: FileDownloadCompletePayload) =>
+    createMessage(MessageTypes.FILE_DOWNLOAD_COMPLETE, payload),
+
+  fileDownloadCancel: (payload: FileDownloadCancelPayload) =>
+    createMessage(MessageTypes.FILE_DOWNLOAD_CANCEL, payload),
+
+  // File uploads
+  fileUploadBegin: (payload: FileUploadBeginPayload) =>
+    createMessage(MessageTypes.FILE_UPLOAD_BEGIN, payload),
+
+  fileUploadChunk: (payload: FileUploadChunkPayload) =>
+    createMessage(MessageTypes.FILE_UPLOAD_CHUNK, payload),
+
+  fileUploadCommit: (payload: FileUploadCommitPayload) =>
+    createMessage(MessageTypes.FILE_UPLOAD_COMMIT, payload),
+
+  fileUploadResult: (payload: FileUploadResultPayload) =>
+    createMessage(MessageTypes.FILE_UPLOAD_RESULT, payload),
+
   // Snippets
   snippetList: () =>
     createMessage(MessageTypes.SNIPPET_LIST, {}),
@@ -807,6 +2590,68 @@ export const Messages = {
   snippetDeleteResult: (payload: SnippetDeleteResultPayload) =>
     createMessage(MessageTypes.SNIPPET_DELETE_RESULT, payload),
 
+  snippetExecute: (payload: SnippetExecutePayload) =>
+    createMessage(MessageTypes.SNIPPET_EXECUTE, payload),
+
+  snippetExecuteResult: (payload: SnippetExecuteResultPayload) =>
+    createMessage(MessageTypes.SNIPPET_EXECUTE_RESULT, payload),
+
+  // Workspaces
+  workspaceList: () =>
+    createMessage(MessageTypes.WORKSPACE_LIST, {}),
+
+  workspaceCreate: (payload: WorkspaceCreatePayload) =>
+    createMessage(MessageTypes.WORKSPACE_CREATE, payload),
+
+  workspaceUpdate: (payload: WorkspaceUpdatePayload) =>
+    createMessage(MessageTypes.WORKSPACE_UPDATE, payload),
+
+  workspaceDelete: (payload: WorkspaceDeletePayload) =>
+    createMessage(MessageTypes.WORKSPACE_DELETE, payload),
+
+  workspaceAssign: (payload: WorkspaceAssignPayload) =>
+    createMessage(MessageTypes.WORKSPACE_ASSIGN, payload),
+
+  // Process templates
+  processTemplateList: () =>
+    createMessage(MessageTypes.PROCESS_TEMPLATE_LIST, {}),
+
+  processTemplateCreate: (payload: ProcessTemplateCreatePayload) =>
+    createMessage(MessageTypes.PROCESS_TEMPLATE_CREATE, payload),
+
+  processTemplateUpdate: (payload: ProcessTemplateUpdatePayload) =>
+    createMessage(MessageTypes.PROCESS_TEMPLATE_UPDATE, payload),
+
+  processTemplateDelete: (payload: ProcessTemplateDeletePayload) =>
+    createMessage(MessageTypes.PROCESS_TEMPLATE_DELETE, payload),
+
+  processCreateFromTemplate: (payload: ProcessCreateFromTemplatePayload) =>
+    createMessage(MessageTypes.PROCESS_CREATE_FROM_TEMPLATE, payload),
+
+  // Bridge diagnostics
+  bridgeInfo: () =>
+    createMessage(MessageTypes.BRIDGE_INFO, {}),
+
+  // Bridge update check
+  bridgeUpdateCheck: () =>
+    createMessage(MessageTypes.BRIDGE_UPDATE_CHECK, {}),
+
+  // Data profiles
+  profileList: () =>
+    createMessage(MessageTypes.PROFILE_LIST, {}),
+
+  // History encryption at rest
+  storageEncryptNow: () =>
+    createMessage(MessageTypes.STORAGE_ENCRYPT_NOW, {}),
+
+  // Storage flush
+  storageFlush: (payload: StorageFlushPayload = {}) =>
+    createMessage(MessageTypes.STORAGE_FLUSH, payload),
+
+  // Confirmation of destructive requests
+  confirmationChallenge: (payload: ConfirmationChallengePayload) =>
+    createMessage(MessageTypes.CONFIRMATION_CHALLENGE, payload),
+
   // Error
   error: (payload: ErrorPayload) =>
     createMessage(MessageTypes.ERROR, payload),
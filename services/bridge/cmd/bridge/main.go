@@ -2,35 +2,116 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/datadir"
 	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/server"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/usage"
+)
+
+// Build metadata, set at link time:
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/bridge
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
 )
 
 func main() {
+	showVersion := flag.Bool("version", false, "Print version information and exit")
 	addr := flag.String("addr", ":8080", "HTTP server address")
-	dataDir := flag.String("data-dir", getDefaultDataDir(), "Data directory for SQLite database")
+	dataDir := flag.String("data-dir", datadir.Default(), "Data directory for SQLite database")
 	logLevel := flag.String("log-level", getEnvOrDefault("LOG_LEVEL", "info"), "Log level (debug, info, warn, error)")
+
+	config := server.DefaultConfig()
+	config.Build = server.BuildInfo{Version: version, Commit: commit, Date: date}
+	flag.StringVar(&config.Profile, "profile", getEnvOrDefault("BRIDGE_PROFILE", server.DefaultProfile), "Data profile to use; named profiles are kept in <data-dir>/profiles/<name>")
+	flag.StringVar(&config.BasePath, "base-path", os.Getenv("BRIDGE_BASE_PATH"), "Path prefix for all HTTP routes when served behind a reverse proxy (e.g. /bridge)")
+	flag.StringVar(&config.ExternalURL, "external-url", os.Getenv("BRIDGE_EXTERNAL_URL"), "URL clients reach the bridge at, including any proxy prefix (default: from the request, and X-Forwarded-* headers from -trusted-proxies)")
+	trustedProxies := flag.String("trusted-proxies", os.Getenv("BRIDGE_TRUSTED_PROXIES"), "Comma-separated IP addresses and CIDR ranges of reverse proxies whose X-Forwarded-Proto/X-Forwarded-Host headers are honored (default: none)")
+	flag.StringVar(&config.AuthToken, "auth-token", os.Getenv("BRIDGE_AUTH_TOKEN"), "Token clients must present to use the WebSocket and REST endpoints")
+	flag.DurationVar(&config.HandshakeTimeout, "handshake-timeout", config.HandshakeTimeout, "Longest a WebSocket client may take to authenticate before it is disconnected (0 waits forever)")
+	flag.DurationVar(&config.PingInterval, "ping-interval", config.PingInterval, "How often WebSocket clients are pinged to measure their latency (0 disables)")
+	flag.BoolVar(&config.AdminSocket, "admin-socket", config.AdminSocket, "Serve local tools such as rcctl on a Unix socket in the data directory, usable only by this user")
+	flag.BoolVar(&config.AdminWrites, "allow-admin-writes", config.AdminWrites, "Let the admin socket kill and rename processes; without it the socket is read-only")
+	flag.BoolVar(&config.WSCompression, "ws-compression", config.WSCompression, "Offer permessage-deflate to clients that opt in")
+	flag.IntVar(&config.WSCompressionLevel, "ws-compression-level", config.WSCompressionLevel, "Deflate level for compressed WebSocket frames (1-9)")
+	flag.IntVar(&config.WSCompressionThreshold, "ws-compression-threshold", config.WSCompressionThreshold, "Minimum frame size in bytes before compression is applied")
+	flag.DurationVar(&config.SlowHandlerThreshold, "slow-handler-threshold", config.SlowHandlerThreshold, "Log a warning for message handlers slower than this (0 disables)")
+	flag.DurationVar(&config.CWDRefreshInterval, "cwd-refresh-interval", config.CWDRefreshInterval, "How often process working directories and tmux alerts are refreshed for hosts with clients (0 disables)")
+	flag.DurationVar(&config.TmuxProbeInterval, "tmux-probe-interval", config.TmuxProbeInterval, "How often hosts with clients are checked for tmux sessions that vanished, e.g. after a tmux server restart (0 disables)")
+	flag.DurationVar(&config.AlertInterval, "alert-interval", config.AlertInterval, "Minimum time between bell/activity alerts pushed for one process")
+	flag.DurationVar(&config.HostConnectTimeout, "host-connect-timeout", config.HostConnectTimeout, "Longest host_connect waits for its tmux, port and requirements scans before answering with what finished (0 waits for all)")
+	flag.IntVar(&config.ReattachConcurrency, "reattach-concurrency", config.ReattachConcurrency, "How many of a host's processes are reattached at once when it connects or a client reconnects")
+	flag.IntVar(&config.MaxConcurrentOps, "host-max-ops", config.MaxConcurrentOps, "How many remote commands and tunnel dials run at once on a host; hosts may override it (1-64)")
+	flag.IntVar(&config.PortRange.Min, "claude-port-min", getEnvIntOrDefault("BRIDGE_CLAUDE_PORT_MIN", config.PortRange.Min), "First port of the AgentAPI range for Claude processes")
+	flag.IntVar(&config.PortRange.Max, "claude-port-max", getEnvIntOrDefault("BRIDGE_CLAUDE_PORT_MAX", config.PortRange.Max), "Last port of the AgentAPI range for Claude processes (at most 512 ports)")
+	flag.DurationVar(&config.StalePortMaxAge, "stale-port-max-age", config.StalePortMaxAge, "Age after which a port held for a stale AgentAPI or detached session on a host that can't be probed is reclaimed when the port range runs out (0 never)")
+	flag.IntVar(&config.PtyOutput.ReadBufferSize, "pty-read-buffer", config.PtyOutput.ReadBufferSize, "Largest read of a process's PTY output in bytes (512-1048576)")
+	flag.DurationVar(&config.PtyOutput.CoalesceWindow, "pty-coalesce-window", config.PtyOutput.CoalesceWindow, "How long PTY output is held to merge what follows into one message, trading latency for fewer messages (0-500ms; 0 sends every read)")
+	flag.IntVar(&config.PtyOutput.MaxCoalescedBytes, "pty-coalesce-max-bytes", config.PtyOutput.MaxCoalescedBytes, "Largest merged PTY output message in bytes (1024-1048576)")
+	flag.IntVar(&config.PtyHistoryMaxChunkSize, "pty-history-max-chunk", config.PtyHistoryMaxChunkSize, "Largest pty history chunk in bytes clients may request (8192-524288)")
+	flag.DurationVar(&config.HostExecMaxTimeout, "exec-max-timeout", config.HostExecMaxTimeout, "Longest timeout a host_exec command may run for")
+	flag.IntVar(&config.HostExecMaxOutput, "exec-max-output", config.HostExecMaxOutput, "Bytes of stdout and of stderr kept per host_exec command")
+	flag.IntVar(&config.HostExecMaxConcurrent, "exec-max-concurrent", config.HostExecMaxConcurrent, "host_exec commands one client may run at once")
+	flag.Int64Var(&config.FileDownloadMaxSize, "download-max-size", config.FileDownloadMaxSize, "Largest file in bytes clients may download from a host with file_download")
+	flag.Int64Var(&config.FileUploadMaxSize, "upload-max-size", config.FileUploadMaxSize, "Largest file in bytes clients may upload to a host with file_upload_begin; uploads are held in memory until committed")
+	flag.DurationVar(&config.FileUploadIdleTimeout, "upload-idle-timeout", config.FileUploadIdleTimeout, "How long an upload may go without chunks or a commit before it is dropped (0 keeps it until its session commits it)")
+	flag.DurationVar(&config.ArchiveMaxAge, "archive-max-age", config.ArchiveMaxAge, "Age after which a process killed with its history kept is deleted with its history (0 never)")
+	flag.DurationVar(&config.PersistInterval, "persist-interval", config.PersistInterval, "How often PTY history and chat are saved to the database; a crash loses at most this much (at least 1s)")
+	flag.Int64Var(&config.FlushMaxBytes, "flush-max-bytes", config.FlushMaxBytes, "Bytes of a process's unsaved PTY output or chat after which it is saved before the next persist (0 disables)")
+	flag.DurationVar(&config.FlushMaxAge, "flush-max-age", config.FlushMaxAge, "Age of a process's oldest unsaved PTY output or chat after which it is saved before the next persist (0 disables)")
+	flag.Int64Var(&config.PtyHistoryMaxBytes, "pty-history-max-bytes", config.PtyHistoryMaxBytes, "PTY history kept per process in bytes; older output is dropped unless the process retains its full history (0 keeps it all)")
+	flag.Int64Var(&config.MinFreeSpace, "min-free-space", config.MinFreeSpace, "Bytes of free space the data directory's volume must have at startup (0 skips the check)")
+	flag.BoolVar(&config.ConfirmKills, "confirm-kills", config.ConfirmKills, "Require clients to confirm process_kill and claude_kill with a confirmation_challenge token")
+	flag.StringVar(&config.CredentialBackend, "credential-backend", getEnvOrDefault("BRIDGE_CREDENTIAL_BACKEND", config.CredentialBackend), "Where host credentials are kept: sqlite, exec (printed by -credential-command) or keychain (macOS builds with -tags keychain); existing hosts are moved at startup")
+	flag.StringVar(&config.CredentialCommand, "credential-command", os.Getenv("BRIDGE_CREDENTIAL_COMMAND"), "Command printing a host's secret for the exec credential backend, run with the host ID as its last argument (e.g. a script running pass show bridge/$1)")
+	flag.DurationVar(&config.CredentialTimeout, "credential-timeout", config.CredentialTimeout, "Longest the credential command may run")
+	flag.BoolVar(&config.EncryptHistory, "encrypt-history", config.EncryptHistory, "Encrypt chat messages, PTY history and process env vars in the database with a key derived from the credentials key (disables the chat search index)")
+	flag.BoolVar(&config.UpdateCheck, "update-check", config.UpdateCheck, "Check -update-manifest-url for a newer bridge release at startup and daily, and tell clients; nothing is installed")
+	flag.StringVar(&config.UpdateManifestURL, "update-manifest-url", os.Getenv("BRIDGE_UPDATE_MANIFEST_URL"), "https URL of the JSON release manifest -update-check reads")
+	secretPatterns := flag.String("env-secret-patterns", strings.Join(config.EnvSecretPatterns, ","), "Comma-separated env var key patterns whose values are masked (empty masks nothing)")
+	modelPrices := flag.String("model-prices", os.Getenv("BRIDGE_MODEL_PRICES"), `JSON file of model prices in USD per million tokens by model ID fragment, e.g. {"opus-4-5": {"input": 5, "output": 25}}, used ahead of the built-in list prices for usage cost estimates`)
 	flag.Parse()
+	config.EnvSecretPatterns = strings.Split(*secretPatterns, ",")
+	config.TrustedProxies = strings.Split(*trustedProxies, ",")
+
+	if *showVersion {
+		fmt.Printf("remote-claude-bridge %s (commit %s, built %s)\n", version, commit, date)
+		os.Exit(0)
+	}
 
 	// Configure logging based on log level
 	configureLogging(*logLevel)
 
 	// Ensure data directory exists
 	if err := os.MkdirAll(*dataDir, 0755); err != nil {
-		log.Fatalf("[ERROR] Failed to create data directory: %v", err)
+		log.Fatalf("[ERROR] Failed to create data directory: %v; create it writable for this user, mount a writable volume there, or choose another with --data-dir", err)
 	}
 
-	log.Printf("[INFO] Remote Claude V2 Bridge starting...")
+	log.Printf("[INFO] Remote Claude V2 Bridge %s starting (commit %s, built %s)...", version, commit, date)
 	log.Printf("[INFO] Log level: %s", *logLevel)
 	log.Printf("[INFO] Server address: %s", *addr)
 	log.Printf("[INFO] Data directory: %s", *dataDir)
+	log.Printf("[INFO] Profile: %s", config.Profile)
+	if config.WSCompression {
+		log.Printf("[INFO] WebSocket compression: level=%d threshold=%d", config.WSCompressionLevel, config.WSCompressionThreshold)
+	}
 
-	srv, err := server.New(*addr, *dataDir)
+	if *modelPrices != "" {
+		if err := usage.LoadPrices(*modelPrices); err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+	}
+
+	srv, err := server.New(*addr, *dataDir, config)
 	if err != nil {
 		log.Fatalf("[ERROR] Failed to create server: %v", err)
 	}
@@ -48,18 +129,8 @@ func main() {
 	if err := srv.Start(); err != nil {
 		log.Fatalf("[ERROR] Server failed: %v", err)
 	}
-}
-
-func getDefaultDataDir() string {
-	// Try XDG_DATA_HOME first, then fall back to ~/.local/share
-	if xdgDataHome := os.Getenv("XDG_DATA_HOME"); xdgDataHome != "" {
-		return filepath.Join(xdgDataHome, "remote-claude-bridge")
-	}
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return "./data"
-	}
-	return filepath.Join(homeDir, ".local", "share", "remote-claude-bridge")
+	// Start returns once shutdown closes the listener; Stop exits when done
+	select {}
 }
 
 func getEnvOrDefault(key, defaultValue string) string {
@@ -69,6 +140,18 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("[ERROR] Invalid %s %q: must be a number", key, value)
+	}
+	return n
+}
+
 func configureLogging(level string) {
 	// Set log flags for timestamp and file/line info
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
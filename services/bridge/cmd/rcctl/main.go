@@ -0,0 +1,275 @@
+// Command rcctl scripts a bridge running on the same machine through its
+// admin socket: list hosts, processes and client sessions, dump a chat, kill
+// a process.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/admin"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/datadir"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+const usage = `Usage: rcctl [flags] <command> [args]
+
+Commands:
+  list-hosts                 List configured hosts
+  list-processes [host-id]   List processes, on one host or all of them
+  list-sessions              List connected clients and their latency
+  dump-chat <process-id>     Print a Claude process's chat history
+  kill-process <process-id>  Kill a process (needs a bridge run with -allow-admin-writes)
+
+Flags:
+`
+
+func main() {
+	dataDir := flag.String("data-dir", datadir.Default(), "Data directory of the bridge")
+	profile := flag.String("profile", getEnvOrDefault("BRIDGE_PROFILE", datadir.DefaultProfile), "Data profile of the bridge")
+	socket := flag.String("socket", "", "Admin socket path (default: from -data-dir and -profile)")
+	asJSON := flag.Bool("json", false, "Print the bridge's result payloads as JSON")
+	yes := flag.Bool("yes", false, "Confirm kills the bridge asks to confirm without prompting")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	path := *socket
+	if path == "" {
+		path = admin.SocketPath(datadir.ProfileDir(*dataDir, *profile))
+	}
+	client, err := admin.Dial(path)
+	if err != nil {
+		fatalf("cannot reach the bridge: %v", err)
+	}
+	defer client.Close()
+
+	c := &cli{client: client, json: *asJSON, yes: *yes}
+	args := flag.Args()[1:]
+	switch cmd := flag.Arg(0); cmd {
+	case "list-hosts":
+		err = c.listHosts()
+	case "list-processes":
+		err = c.listProcesses(args)
+	case "list-sessions":
+		err = c.listSessions()
+	case "dump-chat":
+		if len(args) != 1 {
+			fatalf("usage: rcctl dump-chat <process-id>")
+		}
+		err = c.dumpChat(args[0])
+	case "kill-process":
+		if len(args) != 1 {
+			fatalf("usage: rcctl kill-process <process-id>")
+		}
+		err = c.killProcess(args[0])
+	default:
+		fatalf("unknown command %q (run rcctl -h for the list)", cmd)
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// cli runs commands against one admin connection
+type cli struct {
+	client *admin.Client
+	json   bool
+	yes    bool
+}
+
+// request sends a request and decodes its result message into out
+func (c *cli) request(msgType string, payload interface{}, resultType string, out interface{}) error {
+	resp, err := c.client.Request(msgType, payload)
+	if err != nil {
+		return err
+	}
+	if err := resp.Err(); err != nil {
+		return err
+	}
+	found, err := resp.Find(resultType, out)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no %s in the bridge's response", resultType)
+	}
+	return nil
+}
+
+func (c *cli) hosts() ([]protocol.SSHHostConfig, error) {
+	var result protocol.HostConfigListResultPayload
+	if err := c.request(protocol.TypeHostConfigList, struct{}{}, protocol.TypeHostConfigListResult, &result); err != nil {
+		return nil, err
+	}
+	return result.Hosts, nil
+}
+
+func (c *cli) listHosts() error {
+	hosts, err := c.hosts()
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return printJSON(hosts)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tADDRESS\tAUTH")
+	for _, h := range hosts {
+		fmt.Fprintf(w, "%s\t%s\t%s@%s:%d\t%s\n", h.ID, h.Name, h.Username, h.Host, h.Port, h.AuthType)
+	}
+	return w.Flush()
+}
+
+func (c *cli) listProcesses(hostIDs []string) error {
+	if len(hostIDs) == 0 {
+		hosts, err := c.hosts()
+		if err != nil {
+			return err
+		}
+		for _, h := range hosts {
+			hostIDs = append(hostIDs, h.ID)
+		}
+	}
+
+	processes := []protocol.ProcessInfo{}
+	for _, hostID := range hostIDs {
+		var result protocol.ProcessListResultPayload
+		payload := protocol.ProcessListPayload{HostID: hostID, ListLight: true}
+		if err := c.request(protocol.TypeProcessList, payload, protocol.TypeProcessListResult, &result); err != nil {
+			return err
+		}
+		processes = append(processes, result.Processes...)
+	}
+	if c.json {
+		return printJSON(processes)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tHOST\tTYPE\tNAME\tCWD\tSTARTED")
+	for _, p := range processes {
+		name := "-"
+		if p.Name != nil && *p.Name != "" {
+			name = *p.Name
+		}
+		kind := string(p.Type)
+		if p.Exited {
+			kind += " (exited)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", p.ID, p.HostID, kind, name, p.CWD, p.StartedAt)
+	}
+	return w.Flush()
+}
+
+func (c *cli) listSessions() error {
+	var result protocol.SessionInfoResultPayload
+	if err := c.request(protocol.TypeSessionInfo, struct{}{}, protocol.TypeSessionInfoResult, &result); err != nil {
+		return err
+	}
+	sessions := result.Sessions
+	if sessions == nil {
+		sessions = []protocol.ConnectedSessionInfo{}
+	}
+	if c.json {
+		return printJSON(sessions)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tDEVICE\tROLE\tRTT\tCONNECTED")
+	for _, sess := range sessions {
+		device, rtt := "-", "-"
+		if sess.DeviceLabel != "" {
+			device = sess.DeviceLabel
+		}
+		if sess.Latency != nil {
+			rtt = fmt.Sprintf("%.1fms", sess.Latency.RTTMs)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", sess.ID, device, sess.Role, rtt, sess.CreatedAt)
+	}
+	return w.Flush()
+}
+
+func (c *cli) dumpChat(processID string) error {
+	var result protocol.ChatMessagesPayload
+	payload := protocol.ChatHistoryPayload{ProcessID: processID}
+	if err := c.request(protocol.TypeChatHistory, payload, protocol.TypeChatMessages, &result); err != nil {
+		return err
+	}
+	if c.json {
+		return printJSON(result.Messages)
+	}
+	for i, m := range result.Messages {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("[%s] %s:\n%s\n", m.Time, m.Role, strings.TrimRight(m.Message, "\n"))
+	}
+	return nil
+}
+
+// killProcess kills a process, confirming it when the bridge asks
+func (c *cli) killProcess(processID string) error {
+	payload := protocol.ProcessKillPayload{ProcessID: processID}
+	for {
+		resp, err := c.client.Request(protocol.TypeProcessKill, payload)
+		if err != nil {
+			return err
+		}
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		var challenge protocol.ConfirmationChallengePayload
+		if found, err := resp.Find(protocol.TypeConfirmationChallenge, &challenge); err != nil {
+			return err
+		} else if found {
+			if payload.ConfirmToken != "" || !c.confirm(challenge.Summary) {
+				return fmt.Errorf("kill of %s not confirmed", processID)
+			}
+			payload.ConfirmToken = challenge.Token
+			continue
+		}
+		if found, err := resp.Find(protocol.TypeProcessKilled, nil); err != nil || !found {
+			return fmt.Errorf("no %s in the bridge's response", protocol.TypeProcessKilled)
+		}
+		fmt.Printf("killed %s\n", processID)
+		return nil
+	}
+}
+
+// confirm asks the user whether to go ahead, unless -yes was given
+func (c *cli) confirm(summary string) bool {
+	if c.yes {
+		return true
+	}
+	fmt.Fprintf(os.Stderr, "%s\nKill it? [y/N] ", summary)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "rcctl: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,125 @@
+// Package admin is the client side of the bridge's admin socket: a Unix
+// socket in the profile's data directory that serves protocol requests to
+// tools on the same machine without a WebSocket session. Each request is a
+// protocol.Message on one line, answered by a Response on one line.
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// SocketName is the admin socket's file name in the profile's data directory
+const SocketName = "admin.sock"
+
+// SocketPath returns the admin socket of the bridge using a profile
+// directory (see datadir.ProfileDir)
+func SocketPath(profileDir string) string {
+	return filepath.Join(profileDir, SocketName)
+}
+
+// MaxRequestSize is the longest request line the bridge reads
+const MaxRequestSize = 1 << 20
+
+// Response holds the messages the bridge sent while handling a request, in
+// order: its result, any lifecycle messages, or an error
+type Response struct {
+	Messages []protocol.Message `json:"messages"`
+}
+
+// Find decodes the payload of the first message of msgType into out, and
+// reports whether there was one
+func (r *Response) Find(msgType string, out interface{}) (bool, error) {
+	for _, msg := range r.Messages {
+		if msg.Type != msgType {
+			continue
+		}
+		if out != nil {
+			if err := json.Unmarshal(msg.Payload, out); err != nil {
+				return true, fmt.Errorf("invalid %s payload: %w", msgType, err)
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// Err returns the first error the request was answered with, or nil
+func (r *Response) Err() error {
+	var payload protocol.ErrorPayload
+	found, err := r.Find(protocol.TypeError, &payload)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+	return &Error{payload}
+}
+
+// Error is an error message sent in answer to a request
+type Error struct {
+	protocol.ErrorPayload
+}
+
+func (e *Error) Error() string {
+	if details, ok := e.Details.(map[string]interface{}); ok {
+		if reason, ok := details["reason"].(string); ok && reason != "" {
+			return fmt.Sprintf("%s: %s (%s)", e.Code, e.Message, reason)
+		}
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Client is a connection to a bridge's admin socket. Requests on one client
+// share a session, so a confirmation token from one request is good for the
+// next. A Client is not safe for concurrent use.
+type Client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Dial connects to the admin socket at path
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// Request sends a request and waits for its response. Errors the bridge
+// answers with are in the response, not returned; see Response.Err.
+func (c *Client) Request(msgType string, payload interface{}) (*Response, error) {
+	msg, err := protocol.NewMessage(msgType, payload)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return &resp, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
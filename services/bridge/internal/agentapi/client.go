@@ -3,15 +3,14 @@ package agentapi
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"time"
 
 	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
-	gossh "golang.org/x/crypto/ssh"
 )
 
 // Client provides access to AgentAPI endpoints through SSH tunnel
@@ -34,6 +33,11 @@ type Message struct {
 	Role    string `json:"role"` // "user" or "assistant"
 	Message string `json:"message"`
 	Time    string `json:"time"` // ISO timestamp
+
+	// Kind and Metadata are derived from the other fields AgentAPI sends,
+	// see UnmarshalJSON
+	Kind     string          `json:"-"`
+	Metadata json.RawMessage `json:"-"`
 }
 
 // MessagesResponse represents the /messages endpoint response
@@ -54,9 +58,28 @@ type UploadResponse struct {
 	Error    string `json:"error,omitempty"`
 }
 
+// ModelUsage is one model's entry in the /usage endpoint response
+type ModelUsage struct {
+	Model                    string `json:"model"`
+	InputTokens              int64  `json:"input_tokens"`
+	OutputTokens             int64  `json:"output_tokens"`
+	CacheCreationInputTokens int64  `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int64  `json:"cache_read_input_tokens"`
+	Messages                 int    `json:"messages"`
+}
+
+// UsageResponse represents the /usage endpoint response
+type UsageResponse struct {
+	Models []ModelUsage `json:"models"`
+}
+
+// ErrUsageUnsupported is returned by GetUsage when the AgentAPI version
+// running has no /usage endpoint
+var ErrUsageUnsupported = errors.New("agentapi does not report usage")
+
 // NewClient creates a new AgentAPI client that communicates through SSH tunnel
-func NewClient(sshClient *gossh.Client, port int) *Client {
-	httpClient := ssh.TunnelHTTPClient(sshClient)
+func NewClient(dialer ssh.Dialer, port int) *Client {
+	httpClient := ssh.TunnelHTTPClient(dialer)
 	httpClient.Timeout = 30 * time.Second
 
 	return &Client{
@@ -73,13 +96,12 @@ func (c *Client) GetStatus() (*StatusResponse, error) {
 
 	resp, err := c.httpClient.Get(url)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get status: %w", err)
+		return nil, fmt.Errorf("failed to get status: %w: %w", ErrUnreachable, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("status request failed: %d %s", resp.StatusCode, string(body))
+		return nil, parseErrorResponse(resp)
 	}
 
 	var status StatusResponse
@@ -97,13 +119,12 @@ func (c *Client) GetMessages() ([]Message, error) {
 
 	resp, err := c.httpClient.Get(url)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get messages: %w", err)
+		return nil, fmt.Errorf("failed to get messages: %w: %w", ErrUnreachable, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("messages request failed: %d %s", resp.StatusCode, string(body))
+		return nil, parseErrorResponse(resp)
 	}
 
 	var messagesResp MessagesResponse
@@ -114,7 +135,34 @@ func (c *Client) GetMessages() ([]Message, error) {
 	return messagesResp.Messages, nil
 }
 
-// SendMessage sends a user message (only when agent is stable)
+// GetUsage retrieves the token usage of the conversation
+func (c *Client) GetUsage() (*UsageResponse, error) {
+	url := c.baseURL + "/usage"
+	log.Printf("[DEBUG] [AGENTAPI] GET %s", url)
+
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage: %w: %w", ErrUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrUsageUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, parseErrorResponse(resp)
+	}
+
+	var usage UsageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+		return nil, fmt.Errorf("failed to parse usage response: %w", err)
+	}
+
+	return &usage, nil
+}
+
+// SendMessage sends a user message (only when agent is stable). While the
+// agent is running the error matches ErrAgentBusy.
 func (c *Client) SendMessage(content string) error {
 	return c.postMessage(MessageRequest{
 		Type:    "user",
@@ -148,13 +196,12 @@ func (c *Client) postMessage(req MessageRequest) error {
 
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
-		return fmt.Errorf("failed to send message: %w", err)
+		return fmt.Errorf("failed to send message: %w: %w", ErrUnreachable, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("message request failed: %d %s", resp.StatusCode, string(respBody))
+		return parseErrorResponse(resp)
 	}
 
 	return nil
@@ -0,0 +1,102 @@
+package agentapi
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testClient returns a client for an AgentAPI that answers every request
+// with status and body
+func testClient(t *testing.T, status int, body string) *Client {
+	t.Helper()
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(agent.Close)
+	dial := dialerFunc(func(network, _ string) (net.Conn, error) {
+		return net.Dial(network, agent.Listener.Addr().String())
+	})
+	return NewClient(dial, 3284)
+}
+
+func TestClientErrorFixtures(t *testing.T) {
+	tests := []struct {
+		fixture     string
+		status      int
+		message     string
+		busy        bool
+		serverError bool
+	}{
+		{"error_busy_conflict.json", 409, "agent is running, wait for it to become stable", true, false},
+		{"error_busy_bad_request.json", 400, "agent is not stable, send raw input instead", true, false},
+		{"error_busy_unexpected.json", 500, "failed to send message: message can only be sent when the agent is waiting for user input", true, false},
+		{"error_internal.json", 500, "failed to write to terminal: broken pipe", false, true},
+		{"error_validation.json", 422, `body.type: expected value to be one of "user, raw"`, false, false},
+	}
+	for _, tt := range tests {
+		body, err := os.ReadFile("testdata/" + tt.fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = testClient(t, tt.status, string(body)).SendMessage("hi")
+
+		var responseErr *ResponseError
+		if !errors.As(err, &responseErr) {
+			t.Errorf("%s: error = %v, want a ResponseError", tt.fixture, err)
+			continue
+		}
+		if responseErr.Status != tt.status || responseErr.Message != tt.message {
+			t.Errorf("%s: got %d %q, want %d %q", tt.fixture, responseErr.Status, responseErr.Message, tt.status, tt.message)
+		}
+		if errors.Is(err, ErrAgentBusy) != tt.busy {
+			t.Errorf("%s: errors.Is(ErrAgentBusy) = %v", tt.fixture, !tt.busy)
+		}
+		if errors.Is(err, ErrServerError) != tt.serverError {
+			t.Errorf("%s: errors.Is(ErrServerError) = %v", tt.fixture, !tt.serverError)
+		}
+		if errors.Is(err, ErrUnreachable) {
+			t.Errorf("%s: a response matches ErrUnreachable", tt.fixture)
+		}
+	}
+}
+
+func TestClientErrorWithoutProblemBody(t *testing.T) {
+	_, err := testClient(t, http.StatusNotFound, "404 page not found\n").GetMessages()
+	if !errors.Is(err, ErrNotFound) || errors.Is(err, ErrAgentBusy) {
+		t.Errorf("error = %v, want ErrNotFound", err)
+	}
+	if !strings.HasSuffix(err.Error(), ": 404 page not found") {
+		t.Errorf("error = %q, want the body as its message", err)
+	}
+
+	_, err = testClient(t, http.StatusBadGateway, "").GetStatus()
+	if !errors.Is(err, ErrServerError) || !strings.HasSuffix(err.Error(), ": Bad Gateway") {
+		t.Errorf("error = %v, want ErrServerError with the status text", err)
+	}
+}
+
+func TestClientUnreachable(t *testing.T) {
+	dial := dialerFunc(func(network, addr string) (net.Conn, error) {
+		return nil, errors.New("tunnel closed")
+	})
+	client := NewClient(dial, 3284)
+
+	err := client.SendRaw("\x1b")
+	if !errors.Is(err, ErrUnreachable) || !strings.Contains(err.Error(), "tunnel closed") {
+		t.Errorf("SendRaw error = %v, want ErrUnreachable", err)
+	}
+	var responseErr *ResponseError
+	if errors.As(err, &responseErr) {
+		t.Errorf("transport error is a ResponseError: %v", err)
+	}
+	if _, err := client.GetStatus(); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("GetStatus error = %v, want ErrUnreachable", err)
+	}
+}
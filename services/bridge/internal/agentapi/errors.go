@@ -0,0 +1,131 @@
+package agentapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrAgentBusy matches a request refused because the agent is working on
+	// a message and takes no other until it is stable again
+	ErrAgentBusy = errors.New("agent is busy")
+
+	// ErrNotFound matches a 404, e.g. from an endpoint the running AgentAPI
+	// version doesn't have
+	ErrNotFound = errors.New("agentapi endpoint not found")
+
+	// ErrServerError matches a 5xx that isn't the agent being busy
+	ErrServerError = errors.New("agentapi server error")
+
+	// ErrUnreachable wraps the transport error of a request that got no
+	// response at all: the tunnel is down or AgentAPI isn't listening
+	ErrUnreachable = errors.New("agentapi unreachable")
+)
+
+// busyMessages are the parts of the error messages AgentAPI versions send
+// when a user message arrives while the agent is running. Older versions
+// report it as a 500, which otherwise means AgentAPI itself failed.
+var busyMessages = []string{
+	"waiting for user input",
+	"not stable",
+	"agent is busy",
+	"agent is running",
+}
+
+// ResponseError is an AgentAPI response that wasn't a success. It matches
+// ErrAgentBusy, ErrNotFound or ErrServerError with errors.Is.
+type ResponseError struct {
+	Status  int
+	Message string // From the response body, or the status text without one
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("agentapi returned %d: %s", e.Status, e.Message)
+}
+
+// Is reports whether e is one of the sentinel errors
+func (e *ResponseError) Is(target error) bool {
+	switch target {
+	case ErrAgentBusy:
+		return e.busy()
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrServerError:
+		return e.Status >= http.StatusInternalServerError && !e.busy()
+	}
+	return false
+}
+
+// busy reports whether the agent refused the request for being busy
+func (e *ResponseError) busy() bool {
+	if e.Status == http.StatusConflict {
+		return true
+	}
+	if e.Status != http.StatusBadRequest && e.Status < http.StatusInternalServerError {
+		return false
+	}
+	message := strings.ToLower(e.Message)
+	for _, busy := range busyMessages {
+		if strings.Contains(message, busy) {
+			return true
+		}
+	}
+	return false
+}
+
+// problem is AgentAPI's error response body, an RFC 9457 problem document
+type problem struct {
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+	Errors []struct {
+		Message  string `json:"message"`
+		Location string `json:"location,omitempty"`
+	} `json:"errors"`
+}
+
+// maxErrorBody is how much of an error response is read for its message
+const maxErrorBody = 64 << 10
+
+// parseErrorResponse builds the error for a response that wasn't a success.
+// The message is taken from the problem document's individual errors, which
+// carry the underlying cause, then its detail and title; a body that isn't
+// a problem document is used as is.
+func parseErrorResponse(resp *http.Response) *ResponseError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	e := &ResponseError{Status: resp.StatusCode}
+
+	var p problem
+	if err := json.Unmarshal(body, &p); err == nil {
+		var messages []string
+		for _, item := range p.Errors {
+			if item.Message == "" {
+				continue
+			}
+			if item.Location != "" {
+				messages = append(messages, item.Location+": "+item.Message)
+			} else {
+				messages = append(messages, item.Message)
+			}
+		}
+		switch {
+		case len(messages) > 0:
+			e.Message = strings.Join(messages, "; ")
+		case p.Detail != "":
+			e.Message = p.Detail
+		default:
+			e.Message = p.Title
+		}
+	}
+	if e.Message == "" {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
@@ -0,0 +1,98 @@
+package agentapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// Message kinds, telling what a message holds beyond its role
+const (
+	KindMessage    = "message"     // Plain conversation text
+	KindToolCall   = "tool_call"   // The agent using a tool
+	KindToolResult = "tool_result" // What a tool returned
+	KindSystem     = "system"      // Set by the agent or AgentAPI, not a participant
+)
+
+// messageFields are the fields a Message decodes; everything else AgentAPI
+// sends goes into Metadata
+var messageFields = map[string]bool{"id": true, "role": true, "message": true, "time": true}
+
+// UnmarshalJSON decodes a message, keeping the fields it doesn't know as
+// Metadata, verbatim, and deriving Kind from them
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type plain Message
+	var known plain
+	if err := json.Unmarshal(data, &known); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	*m = Message(known)
+	m.Kind = messageKind(m.Role, fields)
+	m.Metadata = unknownFields(fields)
+	return nil
+}
+
+// messageKind classifies a message by an explicit kind or type field, its
+// role, or the tool fields it has, in that order
+func messageKind(role string, fields map[string]json.RawMessage) string {
+	for _, key := range []string{"kind", "type"} {
+		var value string
+		if json.Unmarshal(fields[key], &value) != nil {
+			continue
+		}
+		switch value {
+		case KindMessage, KindToolCall, KindToolResult, KindSystem:
+			return value
+		case "tool_use":
+			return KindToolCall
+		}
+	}
+
+	switch role {
+	case "system":
+		return KindSystem
+	case "tool":
+		return KindToolResult
+	}
+	switch {
+	case fields["tool_use"] != nil, fields["tool_calls"] != nil, fields["tool_call"] != nil:
+		return KindToolCall
+	case fields["tool_result"] != nil, fields["tool_use_id"] != nil, fields["tool_call_id"] != nil:
+		return KindToolResult
+	}
+	return KindMessage
+}
+
+// unknownFields returns the fields a Message doesn't decode as a JSON object
+// with their values exactly as sent, or nil if there are none
+func unknownFields(fields map[string]json.RawMessage) json.RawMessage {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		if !messageFields[key] {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(fields[key])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
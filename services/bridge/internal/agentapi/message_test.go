@@ -0,0 +1,75 @@
+package agentapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// wantKinds are the kinds and metadata of the messages in
+// testdata/messages_kinds.json, by ID
+var wantKinds = map[int]struct {
+	kind     string
+	metadata string
+}{
+	0: {KindMessage, ""},
+	1: {KindMessage, `{"type":"message"}`},
+	2: {KindToolCall, `{"tool_use":{"id": "toolu_01", "name": "Bash", "input": {"command": "ls -la"}},"type":"tool_use"}`},
+	3: {KindToolResult, `{"is_error":false,"tool_use_id":"toolu_01"}`},
+	4: {KindSystem, ""},
+	5: {KindToolCall, `{"tool_calls":[{"name": "Read", "input": {"path": "go.mod"}}]}`},
+	6: {KindToolResult, `{"future_field":1.50,"kind":"tool_result"}`},
+}
+
+func TestMessageKindFixture(t *testing.T) {
+	body, err := os.ReadFile("testdata/messages_kinds.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := testClient(t, http.StatusOK, string(body)).GetMessages()
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(messages) != len(wantKinds) {
+		t.Fatalf("got %d messages, want %d", len(messages), len(wantKinds))
+	}
+	for _, m := range messages {
+		want := wantKinds[m.ID]
+		if m.Kind != want.kind || string(m.Metadata) != want.metadata {
+			t.Errorf("message %d: kind %q metadata %s, want %q %s", m.ID, m.Kind, m.Metadata, want.kind, want.metadata)
+		}
+		if m.Role == "" || m.Message == "" || m.Time == "" {
+			t.Errorf("message %d lost its fields: %+v", m.ID, m)
+		}
+		if m.Metadata != nil && !json.Valid(m.Metadata) {
+			t.Errorf("message %d: metadata %s isn't JSON", m.ID, m.Metadata)
+		}
+	}
+}
+
+func TestMessageUpdateKindFixture(t *testing.T) {
+	stream, err := os.ReadFile("testdata/agentapi_tools.sse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, _ := parseStream(t, strings.NewReader(string(stream)), 0)
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+	for _, event := range events {
+		var update MessageUpdateData
+		if err := json.Unmarshal(event.Data, &update); err != nil {
+			t.Fatalf("event %s: %v", event.ID, err)
+		}
+		want := wantKinds[update.ID]
+		if update.Kind != want.kind {
+			t.Errorf("update %d: kind %q, want %q", update.ID, update.Kind, want.kind)
+		}
+		// Decoding leaves the event as AgentAPI sent it, to be forwarded
+		if !strings.Contains(string(stream), "data: "+string(event.Data)+"\n") {
+			t.Errorf("event %s data changed: %s", event.ID, event.Data)
+		}
+	}
+}
@@ -1,19 +1,17 @@
 package agentapi
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
-	gossh "golang.org/x/crypto/ssh"
 )
 
 // EventType represents the type of SSE event
@@ -28,15 +26,12 @@ const (
 type SSEEvent struct {
 	Type EventType
 	Data json.RawMessage
+	ID   string // The stream's last event ID when the event was sent
 }
 
-// MessageUpdateData represents message_update event data
-type MessageUpdateData struct {
-	ID      int    `json:"id"`
-	Role    string `json:"role"` // "user" or "assistant"
-	Message string `json:"message"`
-	Time    string `json:"time"` // ISO timestamp
-}
+// MessageUpdateData represents message_update event data, which has the
+// fields of a /messages entry
+type MessageUpdateData = Message
 
 // StatusChangeData represents status_change event data
 type StatusChangeData struct {
@@ -47,6 +42,20 @@ type StatusChangeData struct {
 // EventHandler is called when an SSE event is received
 type EventHandler func(event SSEEvent)
 
+// FailureHandler is told when the stream keeps failing to connect, with the
+// last error, and when it connects again afterwards, with nil
+type FailureHandler func(err error)
+
+// connectionLoops counts the SSE connection loops running, across clients
+var connectionLoops atomic.Int64
+
+// ConnectionLoops returns how many SSE clients are connected or retrying,
+// that is, started and not closed. A client nobody closes retries forever,
+// so this is what leak checks watch.
+func ConnectionLoops() int64 {
+	return connectionLoops.Load()
+}
+
 // SSEClient manages an SSE connection to AgentAPI /events endpoint
 type SSEClient struct {
 	httpClient *http.Client
@@ -59,11 +68,19 @@ type SSEClient struct {
 	connected  bool
 	mu         sync.Mutex
 	reconnects int
+
+	onFailure       FailureHandler
+	failureAttempts int  // Failed attempts in a row before onFailure is told
+	failing         bool // onFailure was told of an error and not of the recovery
+
+	maxEventSize int
+	lastEventID  string        // Sent as Last-Event-ID so a reconnect resumes the stream
+	retry        time.Duration // Reconnect delay, as set by the stream's retry: field
 }
 
 // NewSSEClient creates a new SSE client for AgentAPI events
-func NewSSEClient(sshClient *gossh.Client, port int, handler EventHandler) *SSEClient {
-	httpClient := ssh.TunnelHTTPClient(sshClient)
+func NewSSEClient(dialer ssh.Dialer, port int, handler EventHandler) *SSEClient {
+	httpClient := ssh.TunnelHTTPClient(dialer)
 	// SSE connections need longer timeout
 	httpClient.Timeout = 0 // No timeout for SSE
 
@@ -76,7 +93,55 @@ func NewSSEClient(sshClient *gossh.Client, port int, handler EventHandler) *SSEC
 		ctx:        ctx,
 		cancel:     cancel,
 		handler:    handler,
+
+		maxEventSize: DefaultMaxEventSize,
+		retry:        time.Second,
+	}
+}
+
+// SetMaxEventSize sets the largest event the client accepts, in bytes of
+// data. Larger events are skipped. Takes effect on the next connection.
+func (c *SSEClient) SetMaxEventSize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxEventSize = size
+}
+
+// SetFailureHandler sets the handler told when attempts connection attempts
+// in a row have failed, and when the stream is up again after that. The
+// client keeps retrying either way.
+func (c *SSEClient) SetFailureHandler(attempts int, handler FailureHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failureAttempts = attempts
+	c.onFailure = handler
+}
+
+// reportFailure tells the failure handler the stream failed for the
+// attempts-th time in a row, or is up again when err is nil
+func (c *SSEClient) reportFailure(err error) {
+	c.mu.Lock()
+	handler := c.onFailure
+	report := handler != nil && (err == nil) == c.failing
+	if err != nil && c.reconnects < c.failureAttempts {
+		report = false
+	}
+	if report {
+		c.failing = err != nil
 	}
+	c.mu.Unlock()
+
+	if report {
+		handler(err)
+	}
+}
+
+// LastEventID returns the ID of the last event received, if the stream
+// sends IDs
+func (c *SSEClient) LastEventID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastEventID
 }
 
 // Connect starts the SSE connection
@@ -88,13 +153,16 @@ func (c *SSEClient) Connect() error {
 	}
 	c.mu.Unlock()
 
+	connectionLoops.Add(1)
 	go c.connectionLoop()
 	return nil
 }
 
 // connectionLoop handles connection and reconnection with backoff
 func (c *SSEClient) connectionLoop() {
-	backoff := time.Second
+	defer connectionLoops.Add(-1)
+
+	backoff := c.retryDelay()
 	maxBackoff := 30 * time.Second
 
 	for {
@@ -104,16 +172,22 @@ func (c *SSEClient) connectionLoop() {
 		default:
 		}
 
-		err := c.connectAndRead()
+		established, err := c.connectAndRead()
 		if err != nil {
 			if c.ctx.Err() != nil {
 				// Context cancelled, exit gracefully
 				return
 			}
+			if established {
+				// The stream was up, so start backing off afresh
+				backoff = c.retryDelay()
+				c.reconnects = 0
+			}
 
 			c.reconnects++
 			log.Printf("[WARN] [SSE] Connection failed (attempt %d): %v, retrying in %v",
 				c.reconnects, err, backoff)
+			c.reportFailure(err)
 
 			select {
 			case <-c.ctx.Done():
@@ -128,33 +202,46 @@ func (c *SSEClient) connectionLoop() {
 			}
 		} else {
 			// Successful connection, reset backoff
-			backoff = time.Second
+			backoff = c.retryDelay()
 			c.reconnects = 0
 		}
 	}
 }
 
-// connectAndRead establishes SSE connection and reads events
-func (c *SSEClient) connectAndRead() error {
+// retryDelay returns the delay before the first reconnect attempt
+func (c *SSEClient) retryDelay() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.retry
+}
+
+// connectAndRead establishes SSE connection and reads events. established
+// reports whether the stream was opened before the error.
+func (c *SSEClient) connectAndRead() (established bool, err error) {
 	url := c.baseURL + "/events"
 	log.Printf("[DEBUG] [SSE] Connecting to %s", url)
 
 	req, err := http.NewRequestWithContext(c.ctx, "GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return false, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Accept", "text/event-stream")
 	req.Header.Set("Cache-Control", "no-cache")
+	if lastEventID := c.LastEventID(); lastEventID != "" {
+		// AgentAPI resumes after this event instead of replaying the stream
+		req.Header.Set("Last-Event-ID", lastEventID)
+		log.Printf("[DEBUG] [SSE] Resuming after event %s", lastEventID)
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to connect: %w", err)
+		return false, fmt.Errorf("failed to connect: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+		return false, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
 	}
 
 	c.mu.Lock()
@@ -162,6 +249,7 @@ func (c *SSEClient) connectAndRead() error {
 	c.mu.Unlock()
 
 	log.Printf("[INFO] [SSE] Connected to %s", url)
+	c.reportFailure(nil)
 
 	defer func() {
 		c.mu.Lock()
@@ -169,15 +257,16 @@ func (c *SSEClient) connectAndRead() error {
 		c.mu.Unlock()
 	}()
 
-	return c.readEvents(resp.Body)
+	return true, c.readEvents(resp.Body)
 }
 
 // readEvents reads and parses SSE events from the response body
 func (c *SSEClient) readEvents(body io.Reader) error {
-	reader := bufio.NewReader(body)
-
-	var eventType string
-	var dataLines []string
+	// Seeded with the ID resumed after, so events without one of their own
+	// don't lose the place
+	c.mu.Lock()
+	parser := newSSEParser(body, c.maxEventSize, c.lastEventID)
+	c.mu.Unlock()
 
 	for {
 		select {
@@ -186,51 +275,37 @@ func (c *SSEClient) readEvents(body io.Reader) error {
 		default:
 		}
 
-		line, err := reader.ReadString('\n')
+		event, err := parser.Next()
+
+		// Events skipped or without data move the ID on too
+		c.mu.Lock()
+		if parser.SawEventID() {
+			c.lastEventID = parser.LastEventID()
+		}
+		if parser.Retry() > 0 {
+			c.retry = parser.Retry()
+		}
+		c.mu.Unlock()
+
 		if err != nil {
 			if err == io.EOF {
 				return fmt.Errorf("connection closed")
 			}
 			return fmt.Errorf("read error: %w", err)
 		}
-
-		line = strings.TrimRight(line, "\r\n")
-
-		if line == "" {
-			// Empty line marks end of event
-			if eventType != "" && len(dataLines) > 0 {
-				data := strings.Join(dataLines, "\n")
-				c.handleEvent(eventType, data)
-			}
-			eventType = ""
-			dataLines = nil
-			continue
-		}
-
-		if strings.HasPrefix(line, "event:") {
-			eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
-		} else if strings.HasPrefix(line, "data:") {
-			data := strings.TrimPrefix(line, "data:")
-			if len(data) > 0 && data[0] == ' ' {
-				data = data[1:] // Remove optional leading space
-			}
-			dataLines = append(dataLines, data)
-		}
-		// Ignore other fields like id:, retry:, comments (lines starting with :)
+		c.handleEvent(event)
 	}
 }
 
 // handleEvent processes a received event
-func (c *SSEClient) handleEvent(eventType, data string) {
-	log.Printf("[DEBUG] [SSE] Received event: type=%s", eventType)
-
-	event := SSEEvent{
-		Type: EventType(eventType),
-		Data: json.RawMessage(data),
-	}
+func (c *SSEClient) handleEvent(event SSEEvent) {
+	log.Printf("[DEBUG] [SSE] Received event: type=%s id=%s", event.Type, event.ID)
 
-	if c.handler != nil {
-		c.handler(event)
+	c.mu.Lock()
+	handler := c.handler
+	c.mu.Unlock()
+	if handler != nil {
+		handler(event)
 	}
 }
 
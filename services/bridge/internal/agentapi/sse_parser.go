@@ -0,0 +1,207 @@
+package agentapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxEventSize is the largest event an SSEClient accepts unless
+// SetMaxEventSize says otherwise. A whole chat message arrives as one
+// message_update, so this is generous.
+const DefaultMaxEventSize = 8 << 20
+
+// sseReadBufferSize is the initial read buffer; lines longer than it are
+// accumulated up to the event size cap
+const sseReadBufferSize = 64 << 10
+
+// sseParser reads events from a text/event-stream body. It follows the
+// WHATWG event stream format: lines end in LF, CRLF or a lone CR, lines
+// starting with ':' are comments, and a blank line ends an event. An event
+// still open when the stream ends is delivered rather than dropped.
+type sseParser struct {
+	reader       *bufio.Reader
+	maxEventSize int
+
+	line    []byte
+	skipLF  bool // The last line ended in CR, so a leading LF belongs to it
+	eof     bool
+	idField string // The last id: field, which becomes lastID when an event ends
+	lastID  string
+	idSeen  bool // An id: field was parsed, so lastID may differ from the seed
+	retry   time.Duration
+	skipped int // Oversized events skipped
+}
+
+// newSSEParser returns a parser for a stream resumed after lastID, the last
+// event ID of the stream before it ("" for a new one). Like a browser's
+// EventSource, events keep that ID until the stream sends an id: field.
+func newSSEParser(r io.Reader, maxEventSize int, lastID string) *sseParser {
+	if maxEventSize <= 0 {
+		maxEventSize = DefaultMaxEventSize
+	}
+	return &sseParser{
+		reader:       bufio.NewReaderSize(r, sseReadBufferSize),
+		maxEventSize: maxEventSize,
+		idField:      lastID,
+		lastID:       lastID,
+	}
+}
+
+// LastEventID returns the ID of the last event that ended, as set by the
+// latest id: field before it, or the ID the parser was seeded with
+func (p *sseParser) LastEventID() string {
+	return p.lastID
+}
+
+// SawEventID reports whether the stream sent an id: field
+func (p *sseParser) SawEventID() bool {
+	return p.idSeen
+}
+
+// Retry returns the reconnect delay set by the stream's last retry: field,
+// or 0 if it set none
+func (p *sseParser) Retry() time.Duration {
+	return p.retry
+}
+
+// Next returns the next event that has a type and data. It returns io.EOF
+// once the stream has ended and every event in it was returned.
+func (p *sseParser) Next() (SSEEvent, error) {
+	var eventType string
+	var data strings.Builder
+	hasData := false
+	oversized := false
+
+	for {
+		line, tooLong, err := p.readLine()
+		if err == io.EOF && (hasData || oversized) {
+			// The stream ended mid-event; deliver what was sent
+			line, err = nil, nil
+		}
+		if err != nil {
+			return SSEEvent{}, err
+		}
+
+		if len(line) == 0 && !tooLong {
+			// Empty line marks end of event. Its ID counts even if the
+			// event is dropped, so a resumed stream starts after it.
+			p.lastID = p.idField
+			if oversized {
+				p.skipped++
+				log.Printf("[WARN] [SSE] Skipped event type=%s over %d bytes", eventType, p.maxEventSize)
+			} else if eventType != "" && hasData {
+				return SSEEvent{Type: EventType(eventType), Data: json.RawMessage(data.String()), ID: p.lastID}, nil
+			}
+			if p.eof {
+				return SSEEvent{}, io.EOF
+			}
+			eventType = ""
+			data.Reset()
+			hasData = false
+			oversized = false
+			continue
+		}
+
+		field, value := splitField(line)
+		if tooLong {
+			// Only a data: line can legitimately be this long; anything
+			// else is junk, so the event goes either way
+			oversized = true
+			continue
+		}
+
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+			hasData = true
+			if data.Len() > p.maxEventSize {
+				oversized = true
+				data.Reset()
+			}
+		case "id":
+			// IDs containing NUL are ignored, per the spec
+			if !strings.ContainsRune(value, 0) {
+				p.idField = value
+				p.idSeen = true
+			}
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				p.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+		// Comments (empty field name) and unknown fields are ignored
+	}
+}
+
+// splitField splits a line into its field name and value, dropping one
+// space after the colon
+func splitField(line []byte) (string, string) {
+	i := bytes.IndexByte(line, ':')
+	if i < 0 {
+		return string(line), ""
+	}
+	value := line[i+1:]
+	if len(value) > 0 && value[0] == ' ' {
+		value = value[1:]
+	}
+	return string(line[:i]), string(value)
+}
+
+// readLine returns the next line without its terminator. A line longer than
+// the event size cap is consumed but truncated, and reported as tooLong.
+// The final line of a stream need not be terminated.
+func (p *sseParser) readLine() (line []byte, tooLong bool, err error) {
+	if p.eof {
+		return nil, false, io.EOF
+	}
+	p.line = p.line[:0]
+	for {
+		if _, err := p.reader.Peek(1); err != nil {
+			if err == io.EOF {
+				p.eof = true
+				if len(p.line) > 0 || tooLong {
+					return p.line, tooLong, nil
+				}
+			}
+			return nil, false, err
+		}
+		chunk, _ := p.reader.Peek(p.reader.Buffered())
+		if p.skipLF {
+			p.skipLF = false
+			if chunk[0] == '\n' {
+				p.reader.Discard(1)
+				continue
+			}
+		}
+
+		end := bytes.IndexAny(chunk, "\r\n")
+		take := chunk
+		if end >= 0 {
+			take = chunk[:end]
+		}
+		if room := p.maxEventSize - len(p.line); len(take) > room {
+			take = take[:room]
+			tooLong = true
+		}
+		p.line = append(p.line, take...)
+
+		if end < 0 {
+			p.reader.Discard(len(chunk))
+			continue
+		}
+		p.skipLF = chunk[end] == '\r'
+		p.reader.Discard(end + 1)
+		return p.line, tooLong, nil
+	}
+}
@@ -0,0 +1,292 @@
+package agentapi
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// parseStream returns every event in a stream and the parser that read it
+func parseStream(t *testing.T, r io.Reader, maxEventSize int) ([]SSEEvent, *sseParser) {
+	t.Helper()
+	parser := newSSEParser(r, maxEventSize, "")
+	var events []SSEEvent
+	for {
+		event, err := parser.Next()
+		if err == io.EOF {
+			return events, parser
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		events = append(events, event)
+	}
+}
+
+// ev builds an expected event
+func ev(eventType, data, id string) SSEEvent {
+	return SSEEvent{Type: EventType(eventType), Data: []byte(data), ID: id}
+}
+
+func TestSSEParserFixture(t *testing.T) {
+	stream, err := os.ReadFile("testdata/agentapi.sse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SSEEvent{
+		ev("status_change", `{"status":"running","agent_type":"claude"}`, "1"),
+		ev("message_update", `{"id":0,"role":"user","message":"hi","time":"2025-01-01T00:00:00Z"}`, "2"),
+		ev("message_update", `{"id":1,"role":"assistant",`+"\n"+`"message":"hello\nthere","time":"2025-01-01T00:00:01Z"}`, "3"),
+		ev("status_change", `{"status":"stable","agent_type":"claude"}`, "4"),
+	}
+
+	// However the stream is split into reads, and whatever its line endings
+	crlf := strings.ReplaceAll(string(stream), "\n", "\r\n")
+	cr := strings.ReplaceAll(string(stream), "\n", "\r")
+	for name, r := range map[string]io.Reader{
+		"whole":    strings.NewReader(string(stream)),
+		"one byte": iotest.OneByteReader(strings.NewReader(string(stream))),
+		"crlf":     iotest.OneByteReader(strings.NewReader(crlf)),
+		"cr":       strings.NewReader(cr),
+	} {
+		events, parser := parseStream(t, r, 0)
+		if !reflect.DeepEqual(events, want) {
+			t.Errorf("%s: events = %q\nwant %q", name, events, want)
+		}
+		if parser.LastEventID() != "4" || parser.Retry() != 2500*time.Millisecond {
+			t.Errorf("%s: last ID %q, retry %v", name, parser.LastEventID(), parser.Retry())
+		}
+	}
+}
+
+func TestSSEParserShapes(t *testing.T) {
+	big := strings.Repeat("x", 3*sseReadBufferSize)
+	tests := []struct {
+		name        string
+		stream      string
+		maxSize     int
+		want        []SSEEvent
+		wantLastID  string
+		wantSkipped int
+	}{
+		{
+			name:   "no trailing newline at EOF",
+			stream: "event: status_change\ndata: {}",
+			want:   []SSEEvent{ev("status_change", "{}", "")},
+		},
+		{
+			name:   "no blank line at EOF",
+			stream: "id: 9\nevent: status_change\ndata: {}\n",
+			want:   []SSEEvent{ev("status_change", "{}", "9")}, wantLastID: "9",
+		},
+		{
+			name:   "CRLF-only event terminators",
+			stream: "event: a\r\ndata: 1\r\n\r\nevent: b\r\ndata: 2\r\n\r\n",
+			want:   []SSEEvent{ev("a", "1", ""), ev("b", "2", "")},
+		},
+		{
+			name:   "comments and unknown fields between lines",
+			stream: ":hello\nevent: a\n: mid-event\nfoo: bar\ndata:1\n:\ndata:  2\n\n",
+			want:   []SSEEvent{ev("a", "1\n 2", "")},
+		},
+		{
+			name:   "ID carries over to events without one",
+			stream: "id: 5\nevent: a\ndata: 1\n\nevent: b\ndata: 2\n\nid\nevent: c\ndata: 3\n\n",
+			want:   []SSEEvent{ev("a", "1", "5"), ev("b", "2", "5"), ev("c", "3", "")},
+		},
+		{
+			name:   "ID with NUL is ignored",
+			stream: "id: 5\n\nid: 6\x007\nevent: a\ndata: 1\n\n",
+			want:   []SSEEvent{ev("a", "1", "5")}, wantLastID: "5",
+		},
+		{
+			name:   "ID of a data-less event still counts",
+			stream: "event: a\ndata: 1\n\nid: 8\n\n",
+			want:   []SSEEvent{ev("a", "1", "")}, wantLastID: "8",
+		},
+		{
+			name:   "events without type or data are dropped",
+			stream: "data: untyped\n\nevent: a\n\nevent: b\ndata: 1\n\n",
+			want:   []SSEEvent{ev("b", "1", "")},
+		},
+		{
+			name:   "line longer than the read buffer",
+			stream: "event: a\ndata: " + big + "\n\n",
+			want:   []SSEEvent{ev("a", big, "")},
+		},
+		{
+			name:    "oversized line is skipped",
+			stream:  "id: 1\nevent: a\ndata: " + big + "\nid: 2\n\nevent: b\ndata: 1\n\n",
+			maxSize: sseReadBufferSize,
+			want:    []SSEEvent{ev("b", "1", "2")}, wantLastID: "2", wantSkipped: 1,
+		},
+		{
+			name:    "oversized across data lines is skipped",
+			stream:  "event: a\ndata: 123456\ndata: 123456\n\nevent: b\ndata: 1\n\n",
+			maxSize: 10,
+			want:    []SSEEvent{ev("b", "1", "")}, wantSkipped: 1,
+		},
+		{
+			name:    "oversized event at EOF",
+			stream:  "event: a\ndata: 123456789012",
+			maxSize: 10,
+			want:    nil, wantSkipped: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, parser := parseStream(t, iotest.HalfReader(strings.NewReader(tt.stream)), tt.maxSize)
+			if !reflect.DeepEqual(events, tt.want) {
+				t.Errorf("events = %.200q\nwant %.200q", events, tt.want)
+			}
+			if tt.wantLastID == "" && len(tt.want) > 0 {
+				tt.wantLastID = tt.want[len(tt.want)-1].ID
+			}
+			if parser.LastEventID() != tt.wantLastID {
+				t.Errorf("last ID = %q, want %q", parser.LastEventID(), tt.wantLastID)
+			}
+			if parser.skipped != tt.wantSkipped {
+				t.Errorf("skipped = %d, want %d", parser.skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestSSEParserSeededWithLastID(t *testing.T) {
+	// A resumed stream whose events have no IDs keeps the one resumed after
+	parser := newSSEParser(strings.NewReader("event: a\ndata: 1\n\n"), 0, "41")
+	if event, err := parser.Next(); err != nil || event.ID != "41" {
+		t.Fatalf("event = %+v, %v", event, err)
+	}
+	if parser.LastEventID() != "41" || parser.SawEventID() {
+		t.Errorf("last ID %q, saw an ID %v", parser.LastEventID(), parser.SawEventID())
+	}
+
+	// An empty id: field resets it, as the spec says
+	parser = newSSEParser(strings.NewReader("id:\nevent: a\ndata: 1\n\n"), 0, "41")
+	if event, err := parser.Next(); err != nil || event.ID != "" || !parser.SawEventID() {
+		t.Errorf("event after an empty id = %+v, %v", event, err)
+	}
+}
+
+func TestSSEParserReadError(t *testing.T) {
+	r := io.MultiReader(strings.NewReader("event: a\ndata: 1\n\nevent: b\n"), iotest.ErrReader(fmt.Errorf("reset")))
+	parser := newSSEParser(r, 0, "")
+	if event, err := parser.Next(); err != nil || event.Type != "a" {
+		t.Fatalf("first event = %+v, %v", event, err)
+	}
+	if _, err := parser.Next(); err == nil || err == io.EOF {
+		t.Errorf("Next after a read error = %v", err)
+	}
+}
+
+// dialerFunc adapts a function to ssh.Dialer
+type dialerFunc func(network, addr string) (net.Conn, error)
+
+func (f dialerFunc) Dial(network, addr string) (net.Conn, error) {
+	return f(network, addr)
+}
+
+func TestSSEClientResumesWithLastEventID(t *testing.T) {
+	resumedFrom := make(chan string, 2)
+	connections := 0
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connections++
+		w.Header().Set("Content-Type", "text/event-stream")
+		switch connections {
+		case 1:
+			// Reconnect fast, then drop the stream after one event
+			io.WriteString(w, "retry: 10\n\nid: 41\nevent: status_change\ndata: {\"status\":\"stable\"}\n\n")
+		case 2:
+			// A stream that sends no IDs leaves the place where it was
+			resumedFrom <- r.Header.Get("Last-Event-ID")
+			io.WriteString(w, "event: status_change\ndata: {\"status\":\"running\"}\n\n")
+		default:
+			resumedFrom <- r.Header.Get("Last-Event-ID")
+			<-r.Context().Done()
+		}
+	}))
+	defer agent.Close()
+
+	events := make(chan SSEEvent, 2)
+	dial := dialerFunc(func(network, _ string) (net.Conn, error) {
+		return net.Dial(network, agent.Listener.Addr().String())
+	})
+	client := NewSSEClient(dial, 3284, func(event SSEEvent) { events <- event })
+	client.Connect()
+	defer client.Close()
+
+	select {
+	case event := <-events:
+		if event.Type != EventStatusChange || event.ID != "41" {
+			t.Errorf("event = %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	for reconnect := 1; reconnect <= 2; reconnect++ {
+		select {
+		case id := <-resumedFrom:
+			if id != "41" {
+				t.Errorf("reconnect %d: Last-Event-ID = %q, want 41", reconnect, id)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no reconnect %d", reconnect)
+		}
+	}
+}
+
+func TestSSEClientReportsRepeatedFailures(t *testing.T) {
+	var up atomic.Bool
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer agent.Close()
+
+	failures := make(chan error, 4)
+	dial := dialerFunc(func(network, _ string) (net.Conn, error) {
+		return net.Dial(network, agent.Listener.Addr().String())
+	})
+	client := NewSSEClient(dial, 3284, func(SSEEvent) {})
+	client.retry = time.Millisecond
+	client.SetFailureHandler(3, func(err error) {
+		if err != nil {
+			// Told once; the client keeps retrying
+			up.Store(true)
+		}
+		failures <- err
+	})
+	client.Connect()
+	defer client.Close()
+
+	for i, wantErr := range []bool{true, false} {
+		select {
+		case err := <-failures:
+			if (err != nil) != wantErr {
+				t.Fatalf("report %d = %v, want error=%v", i, err, wantErr)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no report %d", i)
+		}
+	}
+	select {
+	case err := <-failures:
+		t.Errorf("unexpected report %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,107 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Credential backends, as recorded with each stored host
+const (
+	BackendSQLite   = "sqlite"   // Encrypted in the bridge database (the default)
+	BackendExec     = "exec"     // Printed by an external command, e.g. pass or vault
+	BackendKeychain = "keychain" // Kept in the macOS keychain
+)
+
+// ErrReadOnly is returned when a secret is given to a backend that can't
+// store it
+var ErrReadOnly = errors.New("credential backend is read-only")
+
+// CredentialStore keeps the secrets (passwords and private keys) of SSH
+// hosts. What Put returns is kept in the database with the host: the secret
+// itself, encrypted, for the default backend, or a reference to it for the
+// others.
+type CredentialStore interface {
+	// Backend names the store, one of the Backend constants
+	Backend() string
+	// ReadOnly reports whether secrets are managed outside the bridge, so
+	// hosts are created without one
+	ReadOnly() bool
+	// Put stores a host's secret and returns what to keep in the database
+	Put(hostID, secret string) ([]byte, error)
+	// Get returns the secret for what Put returned
+	Get(stored []byte) (string, error)
+	// Delete removes a secret Put stored, once its host is gone
+	Delete(stored []byte) error
+}
+
+// SQLiteStore keeps secrets encrypted in the bridge database
+type SQLiteStore struct {
+	cipher *Cipher
+}
+
+// NewSQLiteStore returns the default store, encrypting secrets with cipher
+func NewSQLiteStore(cipher *Cipher) *SQLiteStore {
+	return &SQLiteStore{cipher: cipher}
+}
+
+func (s *SQLiteStore) Backend() string { return BackendSQLite }
+func (s *SQLiteStore) ReadOnly() bool  { return false }
+
+// Put encrypts the secret; the ciphertext is what the database keeps
+func (s *SQLiteStore) Put(hostID, secret string) ([]byte, error) {
+	return s.cipher.EncryptString(secret)
+}
+
+func (s *SQLiteStore) Get(stored []byte) (string, error) {
+	return s.cipher.DecryptString(stored)
+}
+
+// Delete has nothing to do: the secret goes with the host's row
+func (s *SQLiteStore) Delete(stored []byte) error { return nil }
+
+// Credentials finds the secret of each host in the backend it lives in. New
+// secrets go to the active backend; the others are kept for reading hosts
+// that haven't been moved yet.
+type Credentials struct {
+	active CredentialStore
+	stores map[string]CredentialStore
+}
+
+// NewCredentials returns credentials writing to active and reading from it
+// and from others
+func NewCredentials(active CredentialStore, others ...CredentialStore) *Credentials {
+	c := &Credentials{active: active, stores: map[string]CredentialStore{active.Backend(): active}}
+	for _, store := range others {
+		if _, ok := c.stores[store.Backend()]; !ok {
+			c.stores[store.Backend()] = store
+		}
+	}
+	return c
+}
+
+// Active returns the store new secrets are written to
+func (c *Credentials) Active() CredentialStore {
+	return c.active
+}
+
+// Store returns the store of a backend. Hosts stored before backends
+// existed have none recorded and are in the database.
+func (c *Credentials) Store(backend string) (CredentialStore, error) {
+	if backend == "" {
+		backend = BackendSQLite
+	}
+	store, ok := c.stores[backend]
+	if !ok {
+		return nil, fmt.Errorf("credential backend %s is not available", backend)
+	}
+	return store, nil
+}
+
+// Get returns a secret kept in backend
+func (c *Credentials) Get(backend string, stored []byte) (string, error) {
+	store, err := c.Store(backend)
+	if err != nil {
+		return "", err
+	}
+	return store.Get(stored)
+}
@@ -3,11 +3,15 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"strings"
 )
 
 // getEncryptionKey derives a 32-byte key from the environment variable or generates a default
@@ -22,11 +26,73 @@ func getEncryptionKey() []byte {
 	return hash[:]
 }
 
-// Encrypt encrypts plaintext using AES-256-GCM
-func Encrypt(plaintext []byte) ([]byte, error) {
-	key := getEncryptionKey()
+// keySize is the AES-256 key length in bytes
+const keySize = 32
+
+// Cipher encrypts and decrypts data with a single AES-256-GCM key
+type Cipher struct {
+	key []byte
+}
 
-	block, err := aes.NewCipher(key)
+// NewCipher creates a cipher for a 32-byte key
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", keySize, len(key))
+	}
+	return &Cipher{key: key}, nil
+}
+
+// EnvCipher returns a cipher using the key from BRIDGE_ENCRYPTION_KEY
+func EnvCipher() *Cipher {
+	return &Cipher{key: getEncryptionKey()}
+}
+
+// Derive returns a cipher with a key of its own for purpose, computed from
+// this cipher's key, so data encrypted for one purpose can't be decrypted as
+// another's
+func (c *Cipher) Derive(purpose string) *Cipher {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(purpose))
+	return &Cipher{key: mac.Sum(nil)}
+}
+
+// LoadOrCreateKeyFile reads a hex-encoded key from path, generating a random
+// key and saving it (readable only by the owner) if the file doesn't exist
+func LoadOrCreateKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("invalid key file %s", path)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			// Created concurrently; use the winner's key
+			return LoadOrCreateKeyFile(path)
+		}
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		return nil, err
+	}
+	return key, f.Sync()
+}
+
+// Encrypt encrypts plaintext using AES-256-GCM
+func (c *Cipher) Encrypt(plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(c.key)
 	if err != nil {
 		return nil, err
 	}
@@ -47,10 +113,8 @@ func Encrypt(plaintext []byte) ([]byte, error) {
 }
 
 // Decrypt decrypts ciphertext using AES-256-GCM
-func Decrypt(ciphertext []byte) ([]byte, error) {
-	key := getEncryptionKey()
-
-	block, err := aes.NewCipher(key)
+func (c *Cipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(c.key)
 	if err != nil {
 		return nil, err
 	}
@@ -75,13 +139,13 @@ func Decrypt(ciphertext []byte) ([]byte, error) {
 }
 
 // EncryptString is a convenience wrapper for string encryption
-func EncryptString(plaintext string) ([]byte, error) {
-	return Encrypt([]byte(plaintext))
+func (c *Cipher) EncryptString(plaintext string) ([]byte, error) {
+	return c.Encrypt([]byte(plaintext))
 }
 
 // DecryptString is a convenience wrapper for string decryption
-func DecryptString(ciphertext []byte) (string, error) {
-	plaintext, err := Decrypt(ciphertext)
+func (c *Cipher) DecryptString(ciphertext []byte) (string, error) {
+	plaintext, err := c.Decrypt(ciphertext)
 	if err != nil {
 		return "", err
 	}
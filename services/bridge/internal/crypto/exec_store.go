@@ -0,0 +1,107 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// maxSecretSize bounds what a secret command may print
+const maxSecretSize = 64 << 10
+
+// ExecStore reads secrets from an external command, such as `pass show` or
+// a vault CLI wrapper. The command is run with the host ID as its last
+// argument and prints the secret on stdout. Secrets are managed with the
+// external tool, so the store is read-only and the database keeps only the
+// host ID.
+type ExecStore struct {
+	command []string
+	timeout time.Duration
+}
+
+// NewExecStore returns a store running command, split on spaces with no
+// shell involved, for at most timeout per secret
+func NewExecStore(command string, timeout time.Duration) (*ExecStore, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, errors.New("the exec credential backend needs a command")
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("invalid credential command timeout %s", timeout)
+	}
+	return &ExecStore{command: fields, timeout: timeout}, nil
+}
+
+func (s *ExecStore) Backend() string { return BackendExec }
+func (s *ExecStore) ReadOnly() bool  { return true }
+
+// Put returns the host ID as the reference. A secret can't be written, so
+// one is only accepted if the command already prints it, as when moving a
+// host here from another backend.
+func (s *ExecStore) Put(hostID, secret string) ([]byte, error) {
+	if secret != "" {
+		current, err := s.Get([]byte(hostID))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrReadOnly, err)
+		}
+		if current != secret {
+			return nil, fmt.Errorf("%w: %s prints a different secret for %s", ErrReadOnly, s.command[0], hostID)
+		}
+	}
+	return []byte(hostID), nil
+}
+
+// Get runs the command for the referenced host. Trailing line breaks are
+// dropped from its output.
+func (s *ExecStore) Get(stored []byte) (string, error) {
+	hostID := string(stored)
+	if hostID == "" {
+		return "", errors.New("no host reference stored")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	args := append(append([]string{}, s.command[1:]...), hostID)
+	cmd := exec.CommandContext(ctx, s.command[0], args...)
+	// Don't wait on children of a killed command that still hold its output
+	cmd.WaitDelay = time.Second
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("%s timed out after %s", s.command[0], s.timeout)
+	}
+	if err != nil {
+		if msg := firstLine(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s failed: %v: %s", s.command[0], err, msg)
+		}
+		return "", fmt.Errorf("%s failed: %v", s.command[0], err)
+	}
+	if stdout.Len() > maxSecretSize {
+		return "", fmt.Errorf("%s printed more than %d bytes", s.command[0], maxSecretSize)
+	}
+
+	secret := strings.TrimRight(stdout.String(), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s printed no secret for %s", s.command[0], hostID)
+	}
+	return secret, nil
+}
+
+// Delete has nothing to do: the secret is managed with the external tool
+func (s *ExecStore) Delete(stored []byte) error { return nil }
+
+// firstLine returns the first non-empty line of s, for error messages
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,96 @@
+//go:build darwin && keychain
+
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// keychainTimeout bounds each call to the security tool
+const keychainTimeout = 10 * time.Second
+
+// errItemNotFound is the exit status of security for a missing item
+const errItemNotFound = 44
+
+// KeychainStore keeps secrets as generic passwords in the login keychain,
+// using the security tool. Each host is an account of service, which is
+// what the database keeps. Secrets are stored base64 encoded so private
+// keys survive the tool's handling of line breaks.
+type KeychainStore struct {
+	service string
+}
+
+// NewKeychainStore returns a store keeping secrets under service
+func NewKeychainStore(service string) (CredentialStore, error) {
+	if _, err := exec.LookPath("security"); err != nil {
+		return nil, fmt.Errorf("keychain backend: %w", err)
+	}
+	return &KeychainStore{service: service}, nil
+}
+
+func (s *KeychainStore) Backend() string { return BackendKeychain }
+func (s *KeychainStore) ReadOnly() bool  { return false }
+
+// Put adds or replaces the host's item. The command is written to an
+// interactive security session so the secret never shows up in a process
+// listing.
+func (s *KeychainStore) Put(hostID, secret string) ([]byte, error) {
+	line := fmt.Sprintf("add-generic-password -U -s %q -a %q -w %s\n",
+		s.service, hostID, base64.StdEncoding.EncodeToString([]byte(secret)))
+	if _, err := s.run(strings.NewReader(line), "-i"); err != nil {
+		return nil, err
+	}
+	return []byte(hostID), nil
+}
+
+func (s *KeychainStore) Get(stored []byte) (string, error) {
+	out, err := s.run(nil, "find-generic-password", "-s", s.service, "-a", string(stored), "-w")
+	if err != nil {
+		return "", err
+	}
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(out))
+	if err != nil {
+		return "", fmt.Errorf("keychain item for %s is not one the bridge stored", stored)
+	}
+	return string(secret), nil
+}
+
+// Delete removes the host's item; one already gone is not an error
+func (s *KeychainStore) Delete(stored []byte) error {
+	_, err := s.run(nil, "delete-generic-password", "-s", s.service, "-a", string(stored))
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == errItemNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *KeychainStore) run(stdin *strings.Reader, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), keychainTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "security", args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := firstLine(stderr.String()); msg != "" {
+			return "", fmt.Errorf("security %s failed: %w: %s", args[0], err, msg)
+		}
+		return "", fmt.Errorf("security %s failed: %w", args[0], err)
+	}
+	// An interactive session exits cleanly even when its command fails
+	if stdin != nil && stderr.Len() > 0 {
+		return "", fmt.Errorf("security %s failed: %s", args[0], firstLine(stderr.String()))
+	}
+	return stdout.String(), nil
+}
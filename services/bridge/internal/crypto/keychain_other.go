@@ -0,0 +1,10 @@
+//go:build !(darwin && keychain)
+
+package crypto
+
+import "errors"
+
+// NewKeychainStore is only available in macOS builds with the keychain tag
+func NewKeychainStore(service string) (CredentialStore, error) {
+	return nil, errors.New("keychain backend not built in; build on macOS with -tags keychain")
+}
@@ -0,0 +1,39 @@
+// Package datadir locates the bridge's data on disk: the data directory and
+// the directories of its profiles. It is shared by the bridge and the tools
+// that find a running bridge's files, such as rcctl.
+package datadir
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// DefaultProfile is the profile used when none is selected. Its data is
+// kept directly in the data directory.
+const DefaultProfile = "default"
+
+// Default returns the data directory used when none is given:
+// $XDG_DATA_HOME/remote-claude-bridge, else ~/.local/share/remote-claude-bridge
+func Default() string {
+	if xdgDataHome := os.Getenv("XDG_DATA_HOME"); xdgDataHome != "" {
+		return filepath.Join(xdgDataHome, "remote-claude-bridge")
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "./data"
+	}
+	return filepath.Join(homeDir, ".local", "share", "remote-claude-bridge")
+}
+
+// ProfilesDir returns the directory holding the named profiles
+func ProfilesDir(dataDir string) string {
+	return filepath.Join(dataDir, "profiles")
+}
+
+// ProfileDir returns the directory holding a profile's data
+func ProfileDir(dataDir, profile string) string {
+	if profile == DefaultProfile {
+		return dataDir
+	}
+	return filepath.Join(ProfilesDir(dataDir), profile)
+}
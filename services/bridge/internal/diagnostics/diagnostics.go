@@ -0,0 +1,200 @@
+// Package diagnostics measures the SSH link between the bridge and a host:
+// the round-trip time of a trivial command and the throughput of a short
+// transfer. Probes only run when a client asks, no more than once per
+// MinInterval per host, and their samples can be kept as a short history.
+package diagnostics
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// RTTProbes is how many trivial commands are timed per probe
+	RTTProbes = 3
+
+	// ThroughputBytes is how much data the throughput probe transfers
+	ThroughputBytes = 256 << 10
+
+	// MinInterval is the shortest time between probes of one host
+	MinInterval = 10 * time.Second
+
+	// HistorySize is how many recorded samples are kept per host
+	HistorySize = 20
+)
+
+// rttCommand is the trivial command timed for the round-trip time
+const rttCommand = "true"
+
+// throughputCommand writes ThroughputBytes bytes to stdout
+var throughputCommand = fmt.Sprintf("head -c %d /dev/zero", ThroughputBytes)
+
+// Runner runs a command on a host, writing its stdout to w, and returns once
+// it has exited
+type Runner interface {
+	Run(cmd string, w io.Writer) error
+}
+
+// Sample is the outcome of one probe
+type Sample struct {
+	At   time.Time
+	RTTs []time.Duration // One per trivial command, in order
+
+	// The throughput probe: bytes received, the time the command took, and
+	// the rate once the fixed cost of running a command (the fastest RTT)
+	// is taken off that time
+	ThroughputBytes    int64
+	ThroughputDuration time.Duration
+	BytesPerSecond     float64
+}
+
+// RTTMin returns the fastest round trip
+func (s Sample) RTTMin() time.Duration {
+	return reduce(s.RTTs, func(a, b time.Duration) bool { return b < a })
+}
+
+// RTTMax returns the slowest round trip
+func (s Sample) RTTMax() time.Duration {
+	return reduce(s.RTTs, func(a, b time.Duration) bool { return b > a })
+}
+
+// RTTAvg returns the mean round trip
+func (s Sample) RTTAvg() time.Duration {
+	if len(s.RTTs) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, rtt := range s.RTTs {
+		total += rtt
+	}
+	return total / time.Duration(len(s.RTTs))
+}
+
+// reduce returns the element of ds that no other is better than
+func reduce(ds []time.Duration, better func(a, b time.Duration) bool) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	best := ds[0]
+	for _, d := range ds[1:] {
+		if better(best, d) {
+			best = d
+		}
+	}
+	return best
+}
+
+// Probe times RTTProbes trivial commands and then a transfer of
+// ThroughputBytes bytes
+func Probe(r Runner) (Sample, error) {
+	sample := Sample{At: time.Now()}
+	for i := 0; i < RTTProbes; i++ {
+		start := time.Now()
+		if err := r.Run(rttCommand, io.Discard); err != nil {
+			return Sample{}, fmt.Errorf("round-trip probe failed: %w", err)
+		}
+		sample.RTTs = append(sample.RTTs, time.Since(start))
+	}
+
+	var received countingWriter
+	start := time.Now()
+	if err := r.Run(throughputCommand, &received); err != nil {
+		return Sample{}, fmt.Errorf("throughput probe failed: %w", err)
+	}
+	sample.ThroughputDuration = time.Since(start)
+	sample.ThroughputBytes = int64(received)
+	if sample.ThroughputBytes != ThroughputBytes {
+		return Sample{}, fmt.Errorf("throughput probe failed: received %d of %d bytes", sample.ThroughputBytes, ThroughputBytes)
+	}
+	sample.BytesPerSecond = bytesPerSecond(sample.ThroughputBytes, sample.ThroughputDuration, sample.RTTMin())
+	return sample, nil
+}
+
+// bytesPerSecond is the rate n bytes were transferred at in elapsed, less
+// the overhead every command has. When the overhead is all of elapsed (the
+// transfer was too quick to tell apart), elapsed is used whole.
+func bytesPerSecond(n int64, elapsed, overhead time.Duration) float64 {
+	transfer := elapsed - overhead
+	if transfer <= 0 {
+		transfer = elapsed
+	}
+	if transfer <= 0 {
+		return 0
+	}
+	return float64(n) / transfer.Seconds()
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// RateLimitError is returned by Tracker.Probe for a host probed too recently
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("probed less than %s ago; retry in %s", MinInterval, e.RetryAfter.Round(time.Second))
+}
+
+// Tracker rate-limits probes per host and keeps the samples recorded for
+// each host. It lives in memory only.
+type Tracker struct {
+	mu      sync.Mutex
+	lastRun map[string]time.Time
+	history map[string][]Sample
+	now     func() time.Time
+}
+
+// NewTracker returns an empty tracker
+func NewTracker() *Tracker {
+	return &Tracker{
+		lastRun: make(map[string]time.Time),
+		history: make(map[string][]Sample),
+		now:     time.Now,
+	}
+}
+
+// Probe probes a host with r unless it was probed within MinInterval, in
+// which case it returns a *RateLimitError. A failed probe counts too, so a
+// broken link isn't hammered. With record, the sample is added to the
+// host's history.
+func (t *Tracker) Probe(hostID string, r Runner, record bool) (Sample, error) {
+	t.mu.Lock()
+	now := t.now()
+	if last, ok := t.lastRun[hostID]; ok {
+		if wait := last.Add(MinInterval).Sub(now); wait > 0 {
+			t.mu.Unlock()
+			return Sample{}, &RateLimitError{RetryAfter: wait}
+		}
+	}
+	t.lastRun[hostID] = now
+	t.mu.Unlock()
+
+	sample, err := Probe(r)
+	if err != nil || !record {
+		return sample, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	history := append(t.history[hostID], sample)
+	if len(history) > HistorySize {
+		history = history[len(history)-HistorySize:]
+	}
+	t.history[hostID] = history
+	return sample, nil
+}
+
+// History returns the samples recorded for a host, oldest first
+func (t *Tracker) History(hostID string) []Sample {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Sample(nil), t.history[hostID]...)
+}
@@ -0,0 +1,150 @@
+package diagnostics
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRunner answers commands after a delay, as a host over a slow link
+// would: every command costs rtt, and the throughput probe transfer more
+type fakeRunner struct {
+	rtt      time.Duration
+	transfer time.Duration
+	short    int
+	err      error
+	runs     int
+}
+
+func (r *fakeRunner) Run(cmd string, w io.Writer) error {
+	r.runs++
+	if r.err != nil {
+		return r.err
+	}
+	time.Sleep(r.rtt)
+	if cmd == throughputCommand {
+		time.Sleep(r.transfer)
+		w.Write(make([]byte, ThroughputBytes-r.short))
+	}
+	return nil
+}
+
+func TestProbe(t *testing.T) {
+	r := &fakeRunner{rtt: 20 * time.Millisecond, transfer: 100 * time.Millisecond}
+	sample, err := Probe(r)
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+
+	if len(sample.RTTs) != RTTProbes || r.runs != RTTProbes+1 {
+		t.Fatalf("%d RTTs from %d runs", len(sample.RTTs), r.runs)
+	}
+	for _, rtt := range sample.RTTs {
+		if rtt < r.rtt || rtt > r.rtt+50*time.Millisecond {
+			t.Errorf("RTT = %s, want about %s", rtt, r.rtt)
+		}
+	}
+	if sample.RTTMin() > sample.RTTAvg() || sample.RTTAvg() > sample.RTTMax() {
+		t.Errorf("min %s, avg %s, max %s out of order", sample.RTTMin(), sample.RTTAvg(), sample.RTTMax())
+	}
+
+	// The transfer took rtt+transfer; the rate is over transfer alone
+	if sample.ThroughputBytes != ThroughputBytes || sample.ThroughputDuration < r.rtt+r.transfer {
+		t.Errorf("throughput probe = %d bytes in %s", sample.ThroughputBytes, sample.ThroughputDuration)
+	}
+	want := float64(ThroughputBytes) / r.transfer.Seconds()
+	if sample.BytesPerSecond > want*1.05 || sample.BytesPerSecond < want*0.6 {
+		t.Errorf("BytesPerSecond = %.0f, want about %.0f", sample.BytesPerSecond, want)
+	}
+}
+
+func TestProbeShortTransfer(t *testing.T) {
+	_, err := Probe(&fakeRunner{short: 1})
+	if err == nil || !strings.Contains(err.Error(), "received 262143 of 262144 bytes") {
+		t.Errorf("Probe = %v, want a short transfer error", err)
+	}
+}
+
+func TestBytesPerSecond(t *testing.T) {
+	tests := []struct {
+		n                 int64
+		elapsed, overhead time.Duration
+		want              float64
+	}{
+		{1000, 1500 * time.Millisecond, 500 * time.Millisecond, 1000},
+		{1000, time.Second, 0, 1000},
+		// Overhead as long as the whole transfer can't be taken off
+		{1000, time.Second, 2 * time.Second, 1000},
+		{1000, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := bytesPerSecond(tt.n, tt.elapsed, tt.overhead); got != tt.want {
+			t.Errorf("bytesPerSecond(%d, %s, %s) = %v, want %v", tt.n, tt.elapsed, tt.overhead, got, tt.want)
+		}
+	}
+}
+
+func TestTrackerRateLimit(t *testing.T) {
+	tracker := NewTracker()
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+	r := &fakeRunner{}
+
+	if _, err := tracker.Probe("host-1", r, false); err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+
+	now = now.Add(4 * time.Second)
+	_, err := tracker.Probe("host-1", r, false)
+	var limited *RateLimitError
+	if !errors.As(err, &limited) || limited.RetryAfter != 6*time.Second {
+		t.Fatalf("second probe: %v, want a rate limit with 6s to wait", err)
+	}
+	if r.runs != RTTProbes+1 {
+		t.Errorf("rate-limited probe ran %d commands", r.runs-RTTProbes-1)
+	}
+
+	// Other hosts have their own limit
+	if _, err := tracker.Probe("host-2", r, false); err != nil {
+		t.Errorf("Probe(host-2): %v", err)
+	}
+
+	// Failed probes count, so a broken link isn't probed in a loop
+	now = now.Add(MinInterval)
+	r.err = errors.New("channel open failed")
+	if _, err := tracker.Probe("host-1", r, false); err == nil || !strings.Contains(err.Error(), "round-trip probe failed") {
+		t.Errorf("failed probe: %v", err)
+	}
+	if _, err := tracker.Probe("host-1", r, false); !errors.As(err, &limited) {
+		t.Errorf("probe after a failure: %v, want a rate limit", err)
+	}
+}
+
+func TestTrackerHistory(t *testing.T) {
+	tracker := NewTracker()
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+	r := &fakeRunner{}
+
+	// Unrecorded samples aren't kept
+	tracker.Probe("host-1", r, false)
+	if got := tracker.History("host-1"); len(got) != 0 {
+		t.Errorf("history has %d samples, want 0", len(got))
+	}
+
+	var last Sample
+	for i := 0; i < HistorySize+5; i++ {
+		now = now.Add(MinInterval)
+		sample, err := tracker.Probe("host-1", r, true)
+		if err != nil {
+			t.Fatalf("Probe %d: %v", i, err)
+		}
+		last = sample
+	}
+	history := tracker.History("host-1")
+	if len(history) != HistorySize || !history[HistorySize-1].At.Equal(last.At) {
+		t.Errorf("history has %d samples ending %v, want %d ending with the last", len(history), history[len(history)-1].At, HistorySize)
+	}
+}
@@ -0,0 +1,155 @@
+package env
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/shellargs"
+	rcssh "github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// Errors from the env captures
+var (
+	ErrShellNotReady  = errors.New("shell did not start")
+	ErrEnvNotCaptured = errors.New("env capture file never appeared")
+	ErrNotAtShell     = errors.New("pane is not running a shell")
+)
+
+// Timing of the spawn capture; variables so tests can shorten them
+var (
+	// shellPollInterval is how often a new pane's foreground program is
+	// checked, for at most shellReadyTimeout
+	shellPollInterval = 100 * time.Millisecond
+	shellReadyTimeout = 10 * time.Second
+
+	// captureReadDelays are the waits before each read of the capture file
+	captureReadDelays = []time.Duration{
+		200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond,
+		1600 * time.Millisecond, 3200 * time.Millisecond,
+	}
+)
+
+// captureShells are the shells envCaptureKeys runs in, as tmux names a
+// pane's foreground program
+var captureShells = []string{"bash", "zsh", "sh", "dash", "ash", "ksh", "mksh", "oksh"}
+
+// IsShell reports whether a pane's foreground program, as tmux names it in
+// #{pane_current_command}, is one of the shells the bridge types into: a
+// pane showing one is at its prompt
+func IsShell(command string) bool {
+	// Login shells are named with a leading dash
+	return slices.Contains(captureShells, strings.TrimPrefix(command, "-"))
+}
+
+// runFunc runs a command line on the host and returns its output and exit
+// status. The spawn capture reaches the host through it, so tests can stand
+// in for a slow shell.
+type runFunc func(cmd string) (rcssh.BatchResult, error)
+
+// sshRunner runs commands over an SSH connection
+func sshRunner(client *ssh.Client) runFunc {
+	return func(cmd string) (rcssh.BatchResult, error) {
+		results, err := rcssh.RunBatch(client, []string{cmd})
+		if err != nil {
+			return rcssh.BatchResult{}, err
+		}
+		return results[0], nil
+	}
+}
+
+// WaitForShell waits until the foreground program of a new tmux pane is a
+// shell the capture command runs in. Until then, keys typed into the pane
+// go to whatever the shell's startup runs, or are read before the prompt
+// and mangled. It returns ErrShellNotReady if that takes too long.
+func (m *Manager) WaitForShell(sshClient *ssh.Client, tmux pty.Tmux, tmuxName string) error {
+	return waitForShell(sshRunner(sshClient), tmux, tmuxName)
+}
+
+func waitForShell(run runFunc, tmux pty.Tmux, tmuxName string) error {
+	deadline := time.Now().Add(shellReadyTimeout)
+	for {
+		command, isShell, err := paneCommand(run, tmux, tmuxName)
+		if err != nil {
+			return err
+		}
+		if isShell {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w within %s (pane runs %q)", ErrShellNotReady, shellReadyTimeout, command)
+		}
+		time.Sleep(shellPollInterval)
+	}
+}
+
+// paneCommand returns a pane's foreground program and whether it is a shell
+// the capture command runs in. A failed tmux query reads as no program.
+func paneCommand(run runFunc, tmux pty.Tmux, tmuxName string) (string, bool, error) {
+	result, err := run(tmux.Cmdf("display-message -t %s -p '#{pane_current_command}'", shellargs.Quote(tmuxName)))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get pane command: %w", err)
+	}
+	if !result.OK() {
+		return "", false, nil
+	}
+	command := strings.TrimSpace(result.Output)
+	return command, IsShell(command), nil
+}
+
+// captureEnv runs the spawn capture, see captureEnvTo
+func captureEnv(run runFunc, tmux pty.Tmux, processID, tmuxName string) ([]EnvVar, error) {
+	return captureEnvTo(run, tmux, envCaptureFile(processID), envCaptureKeys(processID), tmuxName)
+}
+
+// captureCurrentEnv captures the environment of a shell that has been
+// running a while. Unlike at spawn, nothing is waited for: if the pane
+// runs anything but a shell, it isn't typed into and ErrNotAtShell is
+// returned.
+func captureCurrentEnv(run runFunc, tmux pty.Tmux, processID, tmuxName string) ([]EnvVar, error) {
+	command, isShell, err := paneCommand(run, tmux, tmuxName)
+	if err != nil {
+		return nil, err
+	}
+	if !isShell {
+		return nil, fmt.Errorf("%w (pane runs %q)", ErrNotAtShell, command)
+	}
+	return captureEnvTo(run, tmux, envCurrentFile(processID), envCurrentKeys(processID), tmuxName)
+}
+
+// captureEnvTo types keys into the pane and reads back what they wrote to
+// file, waiting longer between each read. The keys are typed literally, so
+// nothing in them is taken for a key name, and not at all if the file is
+// already there: an earlier attempt's command may have run late.
+func captureEnvTo(run runFunc, tmux pty.Tmux, file, keys, tmuxName string) ([]EnvVar, error) {
+	captureFile := shellargs.Quote(file)
+	target := shellargs.Quote(tmuxName)
+	sendCmd := fmt.Sprintf("test -e %s || %s", captureFile,
+		tmux.Cmdf(`send-keys -t %s -l %s \; send-keys -t %s Enter`, target, shellargs.Quote(keys), target))
+	result, err := run(sendCmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send env command: %w", err)
+	}
+	if !result.OK() {
+		return nil, fmt.Errorf("failed to send env command: tmux exited with status %d", result.ExitCode)
+	}
+
+	readCmd := fmt.Sprintf("cat %s 2>/dev/null && rm -f %s", captureFile, captureFile)
+	for i, delay := range captureReadDelays {
+		time.Sleep(delay)
+		result, err := run(readCmd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read env output: %w", err)
+		}
+		if result.OK() && result.Output != "" {
+			return parseEnvOutput(result.Output), nil
+		}
+		log.Printf("[DEBUG] [ENV] No env capture yet for %s (read %d of %d)", tmuxName, i+1, len(captureReadDelays))
+	}
+	return nil, fmt.Errorf("%w after %d reads", ErrEnvNotCaptured, len(captureReadDelays))
+}
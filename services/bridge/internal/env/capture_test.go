@@ -0,0 +1,174 @@
+package env
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	rcssh "github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// fastCapture shortens the spawn capture's waits for a test
+func fastCapture(t *testing.T) {
+	t.Helper()
+	savedPoll, savedTimeout, savedDelays := shellPollInterval, shellReadyTimeout, captureReadDelays
+	t.Cleanup(func() { shellPollInterval, shellReadyTimeout, captureReadDelays = savedPoll, savedTimeout, savedDelays })
+	shellPollInterval = 5 * time.Millisecond
+	shellReadyTimeout = 500 * time.Millisecond
+	captureReadDelays = []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond}
+}
+
+// slowShell stands in for a host whose new pane runs its RC files' programs
+// for a while before the shell is in the foreground, and whose shell takes
+// a while to run what is typed
+type slowShell struct {
+	mu         sync.Mutex
+	started    time.Time
+	readyAfter time.Duration // Until then the pane runs startup
+	startup    string        // What the pane runs before the shell
+	runsAfter  time.Duration // From the keys being typed to the file being written; <0 never
+	typed      []time.Time   // When each capture command was typed
+	written    bool          // The capture file exists
+	reads      int
+}
+
+func newSlowShell(readyAfter, runsAfter time.Duration) *slowShell {
+	return &slowShell{started: time.Now(), readyAfter: readyAfter, startup: "node", runsAfter: runsAfter}
+}
+
+func (sh *slowShell) run(cmd string) (rcssh.BatchResult, error) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	switch {
+	case strings.Contains(cmd, "#{pane_current_command}"):
+		if time.Since(sh.started) < sh.readyAfter {
+			return rcssh.BatchResult{Output: sh.startup + "\n"}, nil
+		}
+		return rcssh.BatchResult{Output: "-zsh\n"}, nil
+
+	case strings.HasPrefix(cmd, "test -e "):
+		if !strings.Contains(cmd, "send-keys -t rc-proc-1 -l ' (umask 077") || !strings.HasSuffix(cmd, `\; send-keys -t rc-proc-1 Enter`) {
+			return rcssh.BatchResult{ExitCode: 1}, errors.New("unexpected send command: " + cmd)
+		}
+		if !sh.written {
+			sh.typed = append(sh.typed, time.Now())
+		}
+		return rcssh.BatchResult{}, nil
+
+	case strings.HasPrefix(cmd, "cat "):
+		sh.reads++
+		if !sh.written && len(sh.typed) > 0 && sh.runsAfter >= 0 && time.Since(sh.typed[0]) >= sh.runsAfter {
+			sh.written = true
+		}
+		if !sh.written {
+			return rcssh.BatchResult{ExitCode: 1}, nil
+		}
+		sh.written = false
+		return rcssh.BatchResult{Output: "HOME=/home/me\nNVM_DIR=/home/me/.nvm\n"}, nil
+	}
+	return rcssh.BatchResult{ExitCode: 127}, errors.New("unexpected command: " + cmd)
+}
+
+func TestCaptureWaitsForSlowShell(t *testing.T) {
+	fastCapture(t)
+	sh := newSlowShell(60*time.Millisecond, 15*time.Millisecond)
+
+	if err := waitForShell(sh.run, pty.Tmux{}, "rc-proc-1"); err != nil {
+		t.Fatalf("waitForShell: %v", err)
+	}
+	vars, err := captureEnv(sh.run, pty.Tmux{}, "proc-1", "rc-proc-1")
+	if err != nil {
+		t.Fatalf("captureEnv: %v", err)
+	}
+	if len(vars) != 2 || vars[1] != (EnvVar{Key: "NVM_DIR", Value: "/home/me/.nvm"}) {
+		t.Errorf("vars = %+v", vars)
+	}
+	if len(sh.typed) != 1 || sh.typed[0].Sub(sh.started) < sh.readyAfter {
+		t.Errorf("typed at %v, before the shell was ready after %v", sh.typed, sh.readyAfter)
+	}
+	// The first read came too early; it was tried again
+	if sh.reads < 2 {
+		t.Errorf("reads = %d, want the read retried", sh.reads)
+	}
+}
+
+func TestCaptureGivesUp(t *testing.T) {
+	fastCapture(t)
+
+	// A pane that never gets to a shell isn't typed into
+	sh := newSlowShell(time.Hour, 0)
+	sh.startup = "htop"
+	if err := waitForShell(sh.run, pty.Tmux{}, "rc-proc-1"); !errors.Is(err, ErrShellNotReady) || !strings.Contains(err.Error(), "htop") {
+		t.Errorf("waitForShell = %v, want ErrShellNotReady", err)
+	}
+
+	// A shell that doesn't run the command in time
+	sh = newSlowShell(0, -1)
+	if _, err := captureEnv(sh.run, pty.Tmux{}, "proc-1", "rc-proc-1"); !errors.Is(err, ErrEnvNotCaptured) {
+		t.Fatalf("captureEnv = %v, want ErrEnvNotCaptured", err)
+	}
+	if sh.reads != len(captureReadDelays) {
+		t.Errorf("reads = %d, want %d", sh.reads, len(captureReadDelays))
+	}
+
+	// It runs it late: trying again reads what it wrote without typing it again
+	sh.written = true
+	vars, err := captureEnv(sh.run, pty.Tmux{}, "proc-1", "rc-proc-1")
+	if err != nil || len(vars) != 2 {
+		t.Fatalf("captureEnv after the command ran late = %+v, %v", vars, err)
+	}
+	if len(sh.typed) != 1 {
+		t.Errorf("capture command typed %d times, want once", len(sh.typed))
+	}
+}
+
+func TestCaptureUsesHostTmux(t *testing.T) {
+	fastCapture(t)
+	var cmds []string
+	run := func(cmd string) (rcssh.BatchResult, error) {
+		cmds = append(cmds, cmd)
+		if strings.Contains(cmd, "pane_current_command") {
+			return rcssh.BatchResult{Output: "bash\n"}, nil
+		}
+		return rcssh.BatchResult{Output: "A=1\n"}, nil
+	}
+	tmux := pty.Tmux{SocketPath: "/run/team.sock"}
+	if err := waitForShell(run, tmux, "rc-proc-1"); err != nil {
+		t.Fatalf("waitForShell: %v", err)
+	}
+	if _, err := captureEnv(run, tmux, "proc-1", "rc-proc-1"); err != nil {
+		t.Fatalf("captureEnv: %v", err)
+	}
+	for _, cmd := range cmds[:2] {
+		if !strings.Contains(cmd, "tmux -S /run/team.sock ") {
+			t.Errorf("command not run on the host's tmux server: %s", cmd)
+		}
+	}
+}
+
+func TestCaptureCurrentOnlyAtShell(t *testing.T) {
+	fastCapture(t)
+
+	// A pane running something else isn't typed into, nor waited on
+	sh := newSlowShell(time.Hour, 0)
+	sh.startup = "vim"
+	if _, err := captureCurrentEnv(sh.run, pty.Tmux{}, "proc-1", "rc-proc-1"); !errors.Is(err, ErrNotAtShell) || !strings.Contains(err.Error(), "vim") {
+		t.Errorf("captureCurrentEnv = %v, want ErrNotAtShell", err)
+	}
+	if len(sh.typed) != 0 {
+		t.Errorf("capture command typed into a pane running vim")
+	}
+
+	sh = newSlowShell(0, 0)
+	vars, err := captureCurrentEnv(sh.run, pty.Tmux{}, "proc-1", "rc-proc-1")
+	if err != nil || len(vars) != 2 {
+		t.Fatalf("captureCurrentEnv = %+v, %v", vars, err)
+	}
+	if len(sh.typed) != 1 {
+		t.Errorf("capture command typed %d times, want once", len(sh.typed))
+	}
+}
@@ -0,0 +1,70 @@
+package env
+
+import (
+	"slices"
+)
+
+// CriticalKeys are the system variables a managed section may only set when
+// forced: every new shell on the host depends on them, and a wrong value
+// shadows the right one everywhere, down to the bridge finding tmux.
+var CriticalKeys = []string{"PATH", "HOME", "SHELL", "USER"}
+
+// Conflict is a variable an env update would write over a system variable
+// of the same name, which its export would shadow in every new shell
+type Conflict struct {
+	Key         string
+	SystemValue string // The value shadowed; MaskedValue for secrets, "" when unset
+	IsMasked    bool
+	Critical    bool // One of CriticalKeys
+	Allowed     bool // By force for a critical key, by allowOverride for others
+}
+
+// FindConflicts returns the conflicts of writing requested as the managed
+// section, in the order requested. A key that overlaps a system variable is
+// a conflict unless the section already holds it, as it was let through
+// when added and the system value seen now may be the section's own export.
+// A critical key always is, whether set on the system or not. Secret system
+// values, as masker decides, are masked.
+func FindConflicts(requested, system, managed []EnvVar, allowOverride []string, force bool, masker *Masker) []Conflict {
+	systemVars := varMap(system)
+	managedVars := varMap(managed)
+
+	var conflicts []Conflict
+	seen := make(map[string]bool, len(requested))
+	for _, v := range requested {
+		if seen[v.Key] {
+			continue
+		}
+		seen[v.Key] = true
+
+		value, onSystem := systemVars[v.Key]
+		_, inSection := managedVars[v.Key]
+		critical := slices.Contains(CriticalKeys, v.Key)
+		if !critical && (!onSystem || inSection) {
+			continue
+		}
+
+		conflict := Conflict{Key: v.Key, SystemValue: value, Critical: critical}
+		if onSystem && masker.IsSecret(v.Key) {
+			conflict.SystemValue, conflict.IsMasked = MaskedValue, true
+		}
+		if critical {
+			conflict.Allowed = force
+		} else {
+			conflict.Allowed = slices.Contains(allowOverride, v.Key)
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	return conflicts
+}
+
+// Blocked returns the keys of the conflicts that aren't allowed
+func Blocked(conflicts []Conflict) []string {
+	var keys []string
+	for _, c := range conflicts {
+		if !c.Allowed {
+			keys = append(keys, c.Key)
+		}
+	}
+	return keys
+}
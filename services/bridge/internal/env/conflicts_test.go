@@ -0,0 +1,103 @@
+package env
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindConflicts(t *testing.T) {
+	m, err := NewMasker(DefaultSecretPatterns)
+	if err != nil {
+		t.Fatalf("NewMasker: %v", err)
+	}
+	system := []EnvVar{
+		{Key: "PATH", Value: "/usr/bin:/bin"},
+		{Key: "HOME", Value: "/home/me"},
+		{Key: "LANG", Value: "C.UTF-8"},
+		{Key: "EDITOR", Value: "vim"},
+		{Key: "GITHUB_TOKEN", Value: "ghp_system"},
+	}
+	managed := []EnvVar{{Key: "EDITOR", Value: "vim"}}
+
+	tests := []struct {
+		name          string
+		requested     []EnvVar
+		allowOverride []string
+		force         bool
+		want          []Conflict
+	}{
+		{
+			name:      "new keys only",
+			requested: []EnvVar{{Key: "PROJECT", Value: "demo"}},
+		},
+		{
+			name:      "key already in the section",
+			requested: []EnvVar{{Key: "EDITOR", Value: "nano"}},
+		},
+		{
+			name:      "overlap",
+			requested: []EnvVar{{Key: "LANG", Value: "en_US.UTF-8"}},
+			want:      []Conflict{{Key: "LANG", SystemValue: "C.UTF-8"}},
+		},
+		{
+			name:          "overlap allowed",
+			requested:     []EnvVar{{Key: "LANG", Value: "en_US.UTF-8"}},
+			allowOverride: []string{"LANG"},
+			want:          []Conflict{{Key: "LANG", SystemValue: "C.UTF-8", Allowed: true}},
+		},
+		{
+			name:      "force doesn't allow an overlap",
+			requested: []EnvVar{{Key: "LANG", Value: "en_US.UTF-8"}},
+			force:     true,
+			want:      []Conflict{{Key: "LANG", SystemValue: "C.UTF-8"}},
+		},
+		{
+			name:      "secret system value masked",
+			requested: []EnvVar{{Key: "GITHUB_TOKEN", Value: "ghp_mine"}},
+			want:      []Conflict{{Key: "GITHUB_TOKEN", SystemValue: MaskedValue, IsMasked: true}},
+		},
+		{
+			name:          "critical key not allowed by allowOverride",
+			requested:     []EnvVar{{Key: "PATH", Value: "/opt/bin"}},
+			allowOverride: []string{"PATH"},
+			want:          []Conflict{{Key: "PATH", SystemValue: "/usr/bin:/bin", Critical: true}},
+		},
+		{
+			name:      "critical key forced",
+			requested: []EnvVar{{Key: "HOME", Value: "/srv/me"}},
+			force:     true,
+			want:      []Conflict{{Key: "HOME", SystemValue: "/home/me", Critical: true, Allowed: true}},
+		},
+		{
+			name:      "critical key unset on the system",
+			requested: []EnvVar{{Key: "SHELL", Value: "/bin/zsh"}, {Key: "SHELL", Value: "/bin/bash"}},
+			want:      []Conflict{{Key: "SHELL", Critical: true}},
+		},
+		{
+			name: "mixed, in request order",
+			requested: []EnvVar{
+				{Key: "USER", Value: "root"},
+				{Key: "PROJECT", Value: "demo"},
+				{Key: "LANG", Value: "en_US.UTF-8"},
+			},
+			allowOverride: []string{"LANG"},
+			want: []Conflict{
+				{Key: "USER", Critical: true},
+				{Key: "LANG", SystemValue: "C.UTF-8", Allowed: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FindConflicts(tt.requested, system, managed, tt.allowOverride, tt.force, m)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindConflicts = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	blocked := Blocked([]Conflict{{Key: "PATH", Critical: true}, {Key: "LANG", Allowed: true}, {Key: "TERM"}})
+	if !reflect.DeepEqual(blocked, []string{"PATH", "TERM"}) {
+		t.Errorf("Blocked = %v", blocked)
+	}
+}
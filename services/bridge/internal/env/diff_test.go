@@ -0,0 +1,165 @@
+package env
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{"same", "a\nb\n", "a\nb\n", ""},
+		{"add to empty", "", "a\nb\n", "@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"remove all", "a\n", "", "@@ -1 +0,0 @@\n-a\n"},
+		{
+			"change in the middle",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			"1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			"@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			"distant changes get their own hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			"one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			"@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+		{
+			"close changes share a hunk",
+			"1\n2\n3\n4\n5\n6\n7\n",
+			"one\n2\n3\n4\n5\n6\nseven\n",
+			"@@ -1,7 +1,7 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n-7\n+seven\n",
+		},
+		{"insert between", "a\nc\n", "a\nb\nc\n", "@@ -1,2 +1,3 @@\n a\n+b\n c\n"},
+	}
+	for _, tt := range tests {
+		got := unifiedDiff("rc", tt.old, tt.new, 3)
+		if tt.want != "" {
+			tt.want = "--- rc\n+++ rc\n" + tt.want
+		}
+		if got != tt.want {
+			t.Errorf("%s:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiffLinesKeepsCommonLines(t *testing.T) {
+	ops := diffLines(
+		[]string{"a", "x", "b", "y", "c"},
+		[]string{"a", "b", "z", "c"},
+	)
+	var got strings.Builder
+	for _, op := range ops {
+		got.WriteByte(op.kind)
+		got.WriteString(op.line)
+		got.WriteByte(' ')
+	}
+	if want := " a -x  b -y +z  c "; got.String() != want {
+		t.Errorf("ops = %q, want %q", got.String(), want)
+	}
+}
+
+const previewRc = "export PATH=$PATH:~/bin\n" +
+	"alias ll='ls -l'\n" +
+	SectionStart + "\n" +
+	"export EDITOR=\"vim\"\n" +
+	"export API_KEY=\"sk-old\"\n" +
+	"export PROJECT=\"demo\"\n" +
+	SectionEnd + "\n"
+
+func TestPreviewRcFile(t *testing.T) {
+	masker, err := NewMasker(DefaultSecretPatterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		vars []EnvVar
+		diff string
+	}{
+		{
+			"add",
+			[]EnvVar{{"EDITOR", "vim"}, {"API_KEY", "sk-old"}, {"PROJECT", "demo"}, {"REGION", "eu"}},
+			"@@ -4,4 +4,5 @@\n" +
+				" export EDITOR=\"vim\"\n" +
+				" export API_KEY=\"********\"\n" +
+				" export PROJECT=\"demo\"\n" +
+				"+export REGION=\"eu\"\n" +
+				" " + SectionEnd + "\n",
+		},
+		{
+			"remove",
+			[]EnvVar{{"API_KEY", "sk-old"}, {"PROJECT", "demo"}},
+			"@@ -1,7 +1,6 @@\n" +
+				" export PATH=$PATH:~/bin\n" +
+				" alias ll='ls -l'\n" +
+				" " + SectionStart + "\n" +
+				"-export EDITOR=\"vim\"\n" +
+				" export API_KEY=\"********\"\n" +
+				" export PROJECT=\"demo\"\n" +
+				" " + SectionEnd + "\n",
+		},
+		{
+			// A changed secret is marked as such, without either value
+			"change",
+			[]EnvVar{{"EDITOR", "nano"}, {"API_KEY", "sk-new"}, {"PROJECT", "demo"}},
+			"@@ -1,7 +1,7 @@\n" +
+				" export PATH=$PATH:~/bin\n" +
+				" alias ll='ls -l'\n" +
+				" " + SectionStart + "\n" +
+				"-export EDITOR=\"vim\"\n" +
+				"-export API_KEY=\"********\"\n" +
+				"+export EDITOR=\"nano\"\n" +
+				"+export API_KEY=\"******** (changed)\"\n" +
+				" export PROJECT=\"demo\"\n" +
+				" " + SectionEnd + "\n",
+		},
+		{
+			"remove all",
+			nil,
+			"@@ -1,7 +1,2 @@\n" +
+				" export PATH=$PATH:~/bin\n" +
+				" alias ll='ls -l'\n" +
+				"-" + SectionStart + "\n" +
+				"-export EDITOR=\"vim\"\n" +
+				"-export API_KEY=\"********\"\n" +
+				"-export PROJECT=\"demo\"\n" +
+				"-" + SectionEnd + "\n",
+		},
+	}
+	for _, tt := range tests {
+		preview := previewRcFile("~/.bashrc", previewRc, tt.vars, masker)
+		if want := "--- ~/.bashrc\n+++ ~/.bashrc\n" + tt.diff; preview.Diff != want {
+			t.Errorf("%s diff:\n%s\nwant:\n%s", tt.name, preview.Diff, want)
+		}
+		if strings.Contains(preview.Diff+preview.Section, "sk-") {
+			t.Errorf("%s: preview shows a secret:\n%s%s", tt.name, preview.Diff, preview.Section)
+		}
+		if (preview.Section == "") != (len(tt.vars) == 0) {
+			t.Errorf("%s: section = %q", tt.name, preview.Section)
+		}
+	}
+}
+
+func TestPreviewMatchesWrite(t *testing.T) {
+	vars := []EnvVar{{"EDITOR", "nano"}, {"PROJECT", "it's \"quoted\"\nand multi-line"}}
+	for _, content := range []string{"", "export PATH=$PATH:~/bin", previewRc} {
+		preview := previewRcFile("~/.profile", content, vars, nil)
+		written := rewriteRcFile(content, vars)
+		// The write is the file without its section, plus the section shown
+		if written != removeManagedSection(content)+preview.Section &&
+			written != removeManagedSection(content)+"\n"+preview.Section {
+			t.Errorf("write of %q:\n%s\ndoesn't end with the previewed section:\n%s", content, written, preview.Section)
+		}
+		if preview.Diff != unifiedDiff("~/.profile", content, written, diffContext) {
+			t.Errorf("preview of %q diffs something other than the write:\n%s", content, preview.Diff)
+		}
+	}
+
+	current, _ := extractManagedSection(previewRc)
+	if preview := previewRcFile("~/.profile", previewRc, current, nil); preview.Diff != "" {
+		t.Errorf("unchanged vars produced a diff:\n%s", preview.Diff)
+	}
+}
@@ -0,0 +1,69 @@
+package env
+
+import (
+	"cmp"
+	"slices"
+)
+
+// VarChange is an env var whose value differs between two captures
+type VarChange struct {
+	Key      string
+	Old      string
+	New      string
+	IsMasked bool // Old and New are MaskedValue; only the change shows
+}
+
+// VarDiff is how one capture of an environment differs from an earlier one,
+// each list sorted by key
+type VarDiff struct {
+	Added   []EnvVar
+	Removed []EnvVar
+	Changed []VarChange
+}
+
+// DiffVars compares a later capture of an environment against an earlier
+// one. Secret values, as masker decides, are masked; a changed secret is
+// still listed, with both values masked. Where a key appears more than
+// once, its last value counts, as with env's output.
+func DiffVars(earlier, later []EnvVar, masker *Masker) VarDiff {
+	before := varMap(earlier)
+	after := varMap(later)
+	mask := func(key, value string) string {
+		if masker.IsSecret(key) {
+			return MaskedValue
+		}
+		return value
+	}
+
+	var diff VarDiff
+	for key, value := range after {
+		old, ok := before[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, EnvVar{Key: key, Value: mask(key, value)})
+		case old != value:
+			diff.Changed = append(diff.Changed, VarChange{
+				Key: key, Old: mask(key, old), New: mask(key, value), IsMasked: masker.IsSecret(key),
+			})
+		}
+	}
+	for key, value := range before {
+		if _, ok := after[key]; !ok {
+			diff.Removed = append(diff.Removed, EnvVar{Key: key, Value: mask(key, value)})
+		}
+	}
+
+	byKey := func(a, b EnvVar) int { return cmp.Compare(a.Key, b.Key) }
+	slices.SortFunc(diff.Added, byKey)
+	slices.SortFunc(diff.Removed, byKey)
+	slices.SortFunc(diff.Changed, func(a, b VarChange) int { return cmp.Compare(a.Key, b.Key) })
+	return diff
+}
+
+func varMap(vars []EnvVar) map[string]string {
+	m := make(map[string]string, len(vars))
+	for _, v := range vars {
+		m[v.Key] = v.Value
+	}
+	return m
+}
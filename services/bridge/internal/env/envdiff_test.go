@@ -0,0 +1,52 @@
+package env
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffVars(t *testing.T) {
+	m, err := NewMasker(DefaultSecretPatterns)
+	if err != nil {
+		t.Fatalf("NewMasker: %v", err)
+	}
+	spawn := []EnvVar{
+		{Key: "HOME", Value: "/home/me"},
+		{Key: "PATH", Value: "/usr/bin"},
+		{Key: "OLDPWD", Value: "/"},
+		{Key: "GITHUB_TOKEN", Value: "ghp_old"},
+		{Key: "DB_PASSWORD", Value: "hunter2"},
+		{Key: "AWS_SECRET_ACCESS_KEY", Value: "unchanged"},
+	}
+	current := []EnvVar{
+		{Key: "HOME", Value: "/home/me"},
+		{Key: "PATH", Value: "/opt/bin:/usr/bin"},
+		{Key: "GITHUB_TOKEN", Value: "ghp_new"},
+		{Key: "AWS_SECRET_ACCESS_KEY", Value: "unchanged"},
+		{Key: "NODE_ENV", Value: "test"},
+		{Key: "NODE_ENV", Value: "production"}, // Last one wins
+		{Key: "STRIPE_API_KEY", Value: "sk_live"},
+	}
+
+	want := VarDiff{
+		Added: []EnvVar{
+			{Key: "NODE_ENV", Value: "production"},
+			{Key: "STRIPE_API_KEY", Value: MaskedValue},
+		},
+		Removed: []EnvVar{
+			{Key: "DB_PASSWORD", Value: MaskedValue},
+			{Key: "OLDPWD", Value: "/"},
+		},
+		Changed: []VarChange{
+			{Key: "GITHUB_TOKEN", Old: MaskedValue, New: MaskedValue, IsMasked: true},
+			{Key: "PATH", Old: "/usr/bin", New: "/opt/bin:/usr/bin"},
+		},
+	}
+	if got := DiffVars(spawn, current, m); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffVars =\n%+v\nwant\n%+v", got, want)
+	}
+
+	if got := DiffVars(spawn, spawn, m); got.Added != nil || got.Removed != nil || got.Changed != nil {
+		t.Errorf("DiffVars of a capture with itself = %+v", got)
+	}
+}
@@ -0,0 +1,125 @@
+package env
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/shellargs"
+	rcssh "github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// The env a new shell starts with, such as a clone's or a template's, is
+// exported in the shell rather than given on the tmux command line, where
+// any user on the host could read it with ps. It is written through SSH's
+// stdin to a file only the user can read, and the shell is typed a command
+// that sources and removes it: only the file's path is on a command line.
+
+// writeFunc runs a command line on the host that reads stdin. Env injection
+// writes through it, so tests can see what goes on the command line and
+// what through stdin.
+type writeFunc func(cmd string, stdin io.Reader) error
+
+// sshWriter runs commands reading stdin over an SSH connection
+func sshWriter(client *ssh.Client) writeFunc {
+	return func(cmd string, stdin io.Reader) error {
+		var stderr bytes.Buffer
+		code, err := rcssh.Exec(context.Background(), client, rcssh.ExecRequest{Command: cmd, Stdin: stdin, Stderr: &stderr})
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return fmt.Errorf("exited with status %d: %s", code, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+}
+
+// envInjectFile is the file a new shell sources its starting env from
+func envInjectFile(processID string) string {
+	return processTmpDir(processID) + "/env-inject"
+}
+
+// envInjectKeys is typed into a new shell to source its starting env. Like
+// the env capture, the leading space keeps it out of shell history and
+// clear hides it.
+func envInjectKeys(processID string) string {
+	file := shellargs.Quote(envInjectFile(processID))
+	return fmt.Sprintf(" . %s 2>/dev/null; rm -f %s; clear", file, file)
+}
+
+// envInjectScript returns the shell lines exporting vars, and how many
+// there are. Keys that aren't shell names can't be exported and are left
+// out.
+func envInjectScript(vars []EnvVar) (string, int) {
+	var script strings.Builder
+	n := 0
+	for _, v := range vars {
+		if !isName(v.Key) {
+			log.Printf("[DEBUG] [ENV] Not exporting %q, not a shell name", v.Key)
+			continue
+		}
+		fmt.Fprintf(&script, "export %s=%s\n", v.Key, shellargs.Quote(v.Value))
+		n++
+	}
+	return script.String(), n
+}
+
+// isName reports whether s is a shell variable name
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isNameChar(s[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// InjectProcessEnv exports vars in a new process's shell, through a file
+// only the user can read, which the shell removes once sourced. Call
+// WaitForShell first; the command is typed whether or not the shell is at
+// its prompt.
+func (m *Manager) InjectProcessEnv(sshClient *ssh.Client, tmux pty.Tmux, processID, tmuxName string, vars []EnvVar) error {
+	if err := checkProcessID(processID); err != nil {
+		return err
+	}
+
+	n, err := injectEnv(sshRunner(sshClient), sshWriter(sshClient), tmux, processID, tmuxName, vars)
+	if err != nil {
+		log.Printf("[WARN] [ENV] Failed to inject env for %s: %v", tmuxName, err)
+		return err
+	}
+	log.Printf("[DEBUG] [ENV] Injected %d env vars for %s", n, tmuxName)
+	return nil
+}
+
+func injectEnv(run runFunc, write writeFunc, tmux pty.Tmux, processID, tmuxName string, vars []EnvVar) (int, error) {
+	script, n := envInjectScript(vars)
+	if n == 0 {
+		return 0, nil
+	}
+
+	writeCmd := fmt.Sprintf("umask 077 && mkdir -p %s && cat > %s",
+		shellargs.Quote(processTmpDir(processID)), shellargs.Quote(envInjectFile(processID)))
+	if err := write(writeCmd, strings.NewReader(script)); err != nil {
+		return 0, fmt.Errorf("failed to write env file: %w", err)
+	}
+
+	target := shellargs.Quote(tmuxName)
+	result, err := run(tmux.Cmdf(`send-keys -t %s -l %s \; send-keys -t %s Enter`, target, shellargs.Quote(envInjectKeys(processID)), target))
+	if err != nil {
+		return 0, fmt.Errorf("failed to send env command: %w", err)
+	}
+	if !result.OK() {
+		return 0, fmt.Errorf("failed to send env command: tmux exited with status %d", result.ExitCode)
+	}
+	return n, nil
+}
@@ -0,0 +1,108 @@
+package env
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	rcssh "github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+func TestInjectEnvKeepsValuesOffCommandLines(t *testing.T) {
+	vars := []EnvVar{
+		{Key: "API_TOKEN", Value: "sk-live-123"},
+		{Key: "GREETING", Value: "it's \"here\"\nand $HOME"},
+		{Key: "BASH_FUNC_f%%", Value: "() { evil; }"},
+	}
+	var cmds []string
+	var stdin string
+	run := func(cmd string) (rcssh.BatchResult, error) {
+		cmds = append(cmds, cmd)
+		return rcssh.BatchResult{}, nil
+	}
+	write := func(cmd string, r io.Reader) error {
+		cmds = append(cmds, cmd)
+		data, err := io.ReadAll(r)
+		stdin = string(data)
+		return err
+	}
+
+	n, err := injectEnv(run, write, pty.Tmux{}, "proc-1", "rc-proc-1", vars)
+	if err != nil || n != 2 {
+		t.Fatalf("injectEnv = %d, %v", n, err)
+	}
+	if len(cmds) != 2 {
+		t.Fatalf("commands = %q, want a write and a send", cmds)
+	}
+	for _, cmd := range cmds {
+		for _, v := range vars {
+			if strings.Contains(cmd, v.Value) || strings.Contains(cmd, "sk-live") {
+				t.Errorf("value of %s on the command line: %s", v.Key, cmd)
+			}
+		}
+	}
+	if want := "umask 077 && mkdir -p ~/.remote-claude/tmp/proc-1 && cat > ~/.remote-claude/tmp/proc-1/env-inject"; cmds[0] != want {
+		t.Errorf("write command = %q, want %q", cmds[0], want)
+	}
+	if !strings.Contains(cmds[1], "send-keys -t rc-proc-1 -l ' . ~/.remote-claude/tmp/proc-1/env-inject") {
+		t.Errorf("send command = %q", cmds[1])
+	}
+	if !strings.Contains(stdin, "export API_TOKEN=sk-live-123\n") || strings.Contains(stdin, "BASH_FUNC") {
+		t.Errorf("env file = %q", stdin)
+	}
+
+	// Nothing to export writes and types nothing
+	cmds = nil
+	if n, err := injectEnv(run, write, pty.Tmux{}, "proc-1", "rc-proc-1", vars[2:]); n != 0 || err != nil || cmds != nil {
+		t.Errorf("injectEnv with no valid vars = %d, %v, ran %q", n, err, cmds)
+	}
+}
+
+func TestInjectEnvExportsInShell(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	vars := []EnvVar{{Key: "API_TOKEN", Value: "sk-live-123"}, {Key: "GREETING", Value: "it's \"here\"\nand $HOME"}}
+	home := t.TempDir()
+	write := func(cmd string, r io.Reader) error {
+		c := exec.Command("sh", "-c", cmd)
+		c.Env = append(os.Environ(), "HOME="+home)
+		c.Stdin = r
+		return c.Run()
+	}
+	var keys string
+	run := func(string) (rcssh.BatchResult, error) {
+		keys = envInjectKeys("proc-1")
+		return rcssh.BatchResult{}, nil
+	}
+	if _, err := injectEnv(run, write, pty.Tmux{}, "proc-1", "rc-proc-1", vars); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(home, ".remote-claude", "tmp", "proc-1", "env-inject")
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatalf("env file: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		t.Errorf("env file has mode %v, want it private to the user", perm)
+	}
+
+	// The typed command exports the values as given and removes the file
+	c := exec.Command("sh", "-c", strings.TrimSuffix(keys, "; clear")+`; printf '%s|%s' "$API_TOKEN" "$GREETING"`)
+	c.Env = append(os.Environ(), "HOME="+home)
+	output, err := c.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := vars[0].Value + "|" + vars[1].Value; string(output) != want {
+		t.Errorf("shell has %q, want %q", output, want)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("env file left behind: %v", err)
+	}
+}
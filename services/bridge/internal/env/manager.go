@@ -1,11 +1,13 @@
 package env
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"regexp"
 	"strings"
 
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/shellargs"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -15,6 +17,11 @@ const (
 	SectionEnd   = "# <<< remote-claude env <<<"
 )
 
+// ErrUnterminatedQuote is returned for a managed section, edited by hand,
+// with a quote that is never closed. Writes refuse such a file rather than
+// drop the assignments after the quote.
+var ErrUnterminatedQuote = errors.New("unterminated quote in the managed section")
+
 // EnvVar represents an environment variable
 type EnvVar struct {
 	Key   string `json:"key"`
@@ -45,16 +52,23 @@ func (m *Manager) DetectRcFile(sshClient *ssh.Client) (string, error) {
 
 	shell := strings.TrimSpace(string(output))
 	log.Printf("[DEBUG] [ENV] Detected shell: %s", shell)
+	return RcFileForShell(shell, ""), nil
+}
 
-	// Map shell to RC file
+// RcFileForShell returns the RC file of a login shell on a host running
+// osName (uname -s), for callers that already know both. Bash on macOS
+// starts as a login shell, which reads ~/.bash_profile and not ~/.bashrc.
+func RcFileForShell(shell, osName string) string {
 	switch {
 	case strings.Contains(shell, "zsh"):
-		return "~/.zshrc", nil
+		return "~/.zshrc"
+	case strings.Contains(shell, "bash") && osName == "Darwin":
+		return "~/.bash_profile"
 	case strings.Contains(shell, "bash"):
-		return "~/.bashrc", nil
+		return "~/.bashrc"
 	default:
 		// Fallback to .profile for other shells
-		return "~/.profile", nil
+		return "~/.profile"
 	}
 }
 
@@ -75,62 +89,137 @@ func (m *Manager) ReadSystemEnvVars(sshClient *ssh.Client) ([]EnvVar, error) {
 	return parseEnvOutput(string(output)), nil
 }
 
-// CaptureProcessEnvAtSpawn captures environment variables immediately after a shell spawns.
-// This should be called ONCE right after the shell is created but before user interaction.
-// It runs `env` in the tmux pane to capture the current shell environment (including sourced RC vars).
-func (m *Manager) CaptureProcessEnvAtSpawn(sshClient *ssh.Client, tmuxName string) ([]EnvVar, error) {
-	// Strategy: send `env` to the tmux pane, write to temp file, then read it back
-	// The leading space prevents the command from being saved to shell history
-	// We also use `clear` after to hide the output from the user
+// processTmpDir is where temp files for a process live on the remote host.
+// It's under the user's home rather than /tmp, where other local users could
+// read captured env values.
+func processTmpDir(processID string) string {
+	return "~/.remote-claude/tmp/" + processID
+}
 
-	session, err := sshClient.NewSession()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+// envCaptureFile is the file the shell writes its environment to at spawn
+func envCaptureFile(processID string) string {
+	return processTmpDir(processID) + "/env"
+}
+
+// envCaptureKeys is typed into a new shell to capture its environment. The
+// leading space keeps it out of shell history, the subshell keeps the umask
+// change from leaking into the user's shell, and clear hides the command.
+func envCaptureKeys(processID string) string {
+	return fmt.Sprintf(" (umask 077 && mkdir -p %s && env > %s) 2>/dev/null && clear",
+		shellargs.Quote(processTmpDir(processID)), shellargs.Quote(envCaptureFile(processID)))
+}
+
+// envCurrentFile is the file a running shell writes its environment to
+// when it is captured again, apart from the spawn capture's
+func envCurrentFile(processID string) string {
+	return processTmpDir(processID) + "/env-current"
+}
+
+// envCurrentKeys is typed into a running shell to capture its environment.
+// Rather than clearing the screen, it erases the line it was typed on, so
+// the new prompt takes the old one's place.
+func envCurrentKeys(processID string) string {
+	return fmt.Sprintf(" (umask 077 && mkdir -p %s && env > %s) 2>/dev/null; printf '\\033[1A\\033[2K'",
+		shellargs.Quote(processTmpDir(processID)), shellargs.Quote(envCurrentFile(processID)))
+}
+
+// checkProcessID rejects IDs that would resolve outside processTmpDir
+func checkProcessID(processID string) error {
+	if processID == "" || processID == "." || processID == ".." || strings.ContainsRune(processID, '/') {
+		return fmt.Errorf("invalid process ID %q", processID)
 	}
-	defer session.Close()
+	return nil
+}
 
-	// Create temp file path with unique suffix based on tmux session name
-	tmpFile := fmt.Sprintf("/tmp/rc_env_%s", strings.ReplaceAll(tmuxName, ":", "_"))
+// CaptureProcessEnvAtSpawn captures the environment of a new shell,
+// including what its RC files set, by typing env into the tmux pane. It
+// writes to a file only the user can read, which is read back and removed.
+// Call WaitForShell first; the command is typed whether or not the shell is
+// at its prompt.
+func (m *Manager) CaptureProcessEnvAtSpawn(sshClient *ssh.Client, tmux pty.Tmux, processID, tmuxName string) ([]EnvVar, error) {
+	if err := checkProcessID(processID); err != nil {
+		return nil, err
+	}
 
-	// Send env command to the tmux session, writing to temp file, then clear the screen
-	// Leading space prevents history recording in most shells
-	// The && clear hides the env output from the user
-	sendCmd := fmt.Sprintf(`tmux send-keys -t %s " env > %s 2>/dev/null && clear" Enter`, tmuxName, tmpFile)
-	_, err = session.Output(sendCmd)
+	vars, err := captureEnv(sshRunner(sshClient), tmux, processID, tmuxName)
 	if err != nil {
-		log.Printf("[WARN] [ENV] Failed to send env command at spawn: %v", err)
-		return nil, fmt.Errorf("failed to send env command: %w", err)
+		log.Printf("[WARN] [ENV] Failed to capture env at spawn for %s: %v", tmuxName, err)
+		return nil, err
 	}
+	log.Printf("[DEBUG] [ENV] Captured %d env vars at spawn for %s", len(vars), tmuxName)
+	return vars, nil
+}
 
-	// Wait a moment for the command to execute
-	session2, err := sshClient.NewSession()
+// CaptureProcessEnv captures the current environment of a process's shell,
+// with what was exported since it started, by typing env into the tmux
+// pane. It returns ErrNotAtShell, typing nothing, if the pane's foreground
+// program isn't a shell.
+func (m *Manager) CaptureProcessEnv(sshClient *ssh.Client, tmux pty.Tmux, processID, tmuxName string) ([]EnvVar, error) {
+	if err := checkProcessID(processID); err != nil {
+		return nil, err
+	}
+
+	vars, err := captureCurrentEnv(sshRunner(sshClient), tmux, processID, tmuxName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+		log.Printf("[WARN] [ENV] Failed to capture current env for %s: %v", tmuxName, err)
+		return nil, err
 	}
-	defer session2.Close()
+	log.Printf("[DEBUG] [ENV] Captured %d current env vars for %s", len(vars), tmuxName)
+	return vars, nil
+}
+
+// processArtifacts lists the files a process may leave on the remote host
+func processArtifacts(processID, tmuxName string) []string {
+	return []string{
+		envCaptureFile(processID),
+		envCurrentFile(processID),
+		envInjectFile(processID),
+		// Where env captures were written before they moved to processTmpDir
+		"/tmp/rc_env_" + strings.ReplaceAll(tmuxName, ":", "_"),
+	}
+}
 
-	// Wait and read the temp file
-	readCmd := fmt.Sprintf(`sleep 0.3 && cat %s 2>/dev/null && rm -f %s 2>/dev/null`, tmpFile, tmpFile)
-	envOutput, err := session2.Output(readCmd)
+// cleanupCommand removes a process's artifacts and then its temp directory,
+// tolerating any that are already gone
+func cleanupCommand(processID, tmuxName string) string {
+	artifacts := processArtifacts(processID, tmuxName)
+	quoted := make([]string, len(artifacts))
+	for i, path := range artifacts {
+		quoted[i] = shellargs.Quote(path)
+	}
+	return fmt.Sprintf("rm -f -- %s; rmdir -- %s 2>/dev/null; true",
+		strings.Join(quoted, " "), shellargs.Quote(processTmpDir(processID)))
+}
+
+// CleanupProcessArtifacts removes the temp files a killed process may have
+// left on the remote host, such as an env capture that raced with the kill
+func (m *Manager) CleanupProcessArtifacts(sshClient *ssh.Client, processID, tmuxName string) error {
+	if err := checkProcessID(processID); err != nil {
+		return err
+	}
+
+	session, err := sshClient.NewSession()
 	if err != nil {
-		log.Printf("[WARN] [ENV] Failed to read env output at spawn: %v", err)
-		return nil, fmt.Errorf("failed to read env output: %w", err)
+		return fmt.Errorf("failed to create SSH session: %w", err)
 	}
+	defer session.Close()
 
-	vars := parseEnvOutput(string(envOutput))
-	log.Printf("[DEBUG] [ENV] Captured %d env vars at spawn for %s", len(vars), tmuxName)
-	return vars, nil
+	if output, err := session.CombinedOutput(cleanupCommand(processID, tmuxName)); err != nil {
+		return fmt.Errorf("failed to clean up process artifacts: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	log.Printf("[DEBUG] [ENV] Cleaned up artifacts for process %s", processID)
+	return nil
 }
 
 // ReadProcessEnvVars is deprecated - use CaptureProcessEnvAtSpawn instead
 // This method is kept for fallback purposes only
-func (m *Manager) ReadProcessEnvVars(sshClient *ssh.Client, tmuxName string) ([]EnvVar, error) {
-	return m.readProcessEnvFallback(sshClient, tmuxName)
+func (m *Manager) ReadProcessEnvVars(sshClient *ssh.Client, tmux pty.Tmux, tmuxName string) ([]EnvVar, error) {
+	return m.readProcessEnvFallback(sshClient, tmux, tmuxName)
 }
 
 // readProcessEnvFallback reads env from /proc/<pid>/environ as a fallback
 // Note: This only shows the initial environment, not current exported vars
-func (m *Manager) readProcessEnvFallback(sshClient *ssh.Client, tmuxName string) ([]EnvVar, error) {
+func (m *Manager) readProcessEnvFallback(sshClient *ssh.Client, tmux pty.Tmux, tmuxName string) ([]EnvVar, error) {
 	session, err := sshClient.NewSession()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create SSH session: %w", err)
@@ -138,7 +227,7 @@ func (m *Manager) readProcessEnvFallback(sshClient *ssh.Client, tmuxName string)
 	defer session.Close()
 
 	// Get the shell PID from tmux
-	cmd := fmt.Sprintf("tmux list-panes -t %s -F '#{pane_pid}' | head -1", tmuxName)
+	cmd := tmux.Cmdf("list-panes -t %s -F '#{pane_pid}' | head -1", tmuxName)
 	pidOutput, err := session.Output(cmd)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get pane PID: %w", err)
@@ -197,27 +286,97 @@ func (m *Manager) ReadCustomEnvVars(sshClient *ssh.Client, rcFile string) ([]Env
 		return nil, fmt.Errorf("failed to read RC file: %w", err)
 	}
 
-	content := string(output)
-	return extractManagedSection(content), nil
+	vars, err := extractManagedSection(string(output))
+	if err != nil {
+		// List what could be read; writes refuse the file until it's fixed
+		log.Printf("[WARN] [ENV] %s: %v", rcFile, err)
+	}
+	return vars, nil
+}
+
+// readRcFile returns the contents of the RC file, empty if it doesn't exist
+func readRcFile(sshClient *ssh.Client, rcFile string) (string, error) {
+	session, err := sshClient.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	cmd := fmt.Sprintf("cat %s 2>/dev/null || echo ''", rcFile)
+	output, err := session.Output(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to read RC file: %w", err)
+	}
+	return string(output), nil
 }
 
 // WriteCustomEnvVars writes the managed section to the RC file
 func (m *Manager) WriteCustomEnvVars(sshClient *ssh.Client, rcFile string, vars []EnvVar) error {
 	// First, read the current RC file
+	content, err := readRcFile(sshClient, rcFile)
+	if err != nil {
+		return err
+	}
+	if err := checkRcFile(rcFile, content); err != nil {
+		return err
+	}
+	content = rewriteRcFile(content, vars)
+
+	// Write back atomically using a temp file
 	session, err := sshClient.NewSession()
 	if err != nil {
 		return fmt.Errorf("failed to create SSH session: %w", err)
 	}
 	defer session.Close()
 
-	cmd := fmt.Sprintf("cat %s 2>/dev/null || echo ''", rcFile)
-	output, err := session.Output(cmd)
+	// Escape content for shell
+	escapedContent := strings.ReplaceAll(content, "'", "'\"'\"'")
+
+	// Write to temp file and mv (atomic)
+	cmd := fmt.Sprintf("printf '%%s' '%s' > %s.tmp && mv %s.tmp %s",
+		escapedContent, rcFile, rcFile, rcFile)
+	_, err = session.Output(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to write RC file: %w", err)
+	}
+
+	log.Printf("[DEBUG] [ENV] Wrote %d custom env vars to %s", len(vars), rcFile)
+	return nil
+}
+
+// Preview is what WriteCustomEnvVars would change in an RC file
+type Preview struct {
+	Diff    string // Unified diff of the RC file, "" if nothing would change
+	Section string // The managed section that would be written, "" if none
+}
+
+// PreviewCustomEnvVars shows what WriteCustomEnvVars would do to the RC file
+// without writing it. Values of keys masker counts as secret are masked.
+func (m *Manager) PreviewCustomEnvVars(sshClient *ssh.Client, rcFile string, vars []EnvVar, masker *Masker) (*Preview, error) {
+	content, err := readRcFile(sshClient, rcFile)
 	if err != nil {
-		return fmt.Errorf("failed to read RC file: %w", err)
+		return nil, err
+	}
+	if err := checkRcFile(rcFile, content); err != nil {
+		return nil, err
 	}
+	return previewRcFile(rcFile, content, vars, masker), nil
+}
 
-	content := string(output)
+// checkRcFile refuses an RC file whose managed section can't be read whole,
+// since rewriting it would drop the assignments after the bad value
+func checkRcFile(rcFile, content string) error {
+	if _, err := extractManagedSection(content); err != nil {
+		return fmt.Errorf("not rewriting %s: %w", rcFile, err)
+	}
+	return nil
+}
 
+// rewriteRcFile replaces the managed section of an RC file's content with
+// one holding vars, appended at the end, or drops it when there are none.
+// Writes and previews both go through it, so a preview shows the exact bytes
+// a write would.
+func rewriteRcFile(content string, vars []EnvVar) string {
 	// Remove existing managed section
 	content = removeManagedSection(content)
 
@@ -230,27 +389,64 @@ func (m *Manager) WriteCustomEnvVars(sshClient *ssh.Client, rcFile string, vars
 		}
 		content += newSection
 	}
+	return content
+}
 
-	// Write back atomically using a temp file
-	session2, err := sshClient.NewSession()
-	if err != nil {
-		return fmt.Errorf("failed to create SSH session: %w", err)
+// previewRcFile diffs content against its rewrite with vars. Secret values
+// are masked on both sides by rewriting them too, so a hand-written section
+// holding secrets is shown as the bridge would write it; a secret whose value
+// changes is shown as changed without either value.
+func previewRcFile(rcFile, content string, vars []EnvVar, masker *Masker) *Preview {
+	current, _ := extractManagedSection(content)
+	oldText, newText := content, rewriteRcFile(content, vars)
+	shown := vars
+	if masker != nil && (hasSecret(current, masker) || hasSecret(vars, masker)) {
+		oldValues := make(map[string]string, len(current))
+		for _, v := range current {
+			oldValues[v.Key] = v.Value
+		}
+		masked := maskSecrets(current, masker, nil)
+		shown = maskSecrets(vars, masker, oldValues)
+		if len(current) > 0 {
+			oldText = rewriteRcFile(content, masked)
+		}
+		newText = rewriteRcFile(content, shown)
 	}
-	defer session2.Close()
 
-	// Escape content for shell
-	escapedContent := strings.ReplaceAll(content, "'", "'\"'\"'")
+	preview := &Preview{Diff: unifiedDiff(rcFile, oldText, newText, diffContext)}
+	if len(shown) > 0 {
+		preview.Section = buildManagedSection(shown)
+	}
+	return preview
+}
 
-	// Write to temp file and mv (atomic)
-	cmd = fmt.Sprintf("printf '%%s' '%s' > %s.tmp && mv %s.tmp %s",
-		escapedContent, rcFile, rcFile, rcFile)
-	_, err = session2.Output(cmd)
-	if err != nil {
-		return fmt.Errorf("failed to write RC file: %w", err)
+// changedSecret stands in for a secret whose value a write would change
+const changedSecret = MaskedValue + " (changed)"
+
+// maskSecrets returns vars with secret values masked. A secret whose value
+// differs from its old one gets changedSecret, so the change shows in a diff.
+func maskSecrets(vars []EnvVar, masker *Masker, old map[string]string) []EnvVar {
+	masked := make([]EnvVar, len(vars))
+	for i, v := range vars {
+		masked[i] = v
+		if !masker.IsSecret(v.Key) {
+			continue
+		}
+		masked[i].Value = MaskedValue
+		if value, ok := old[v.Key]; ok && value != v.Value {
+			masked[i].Value = changedSecret
+		}
 	}
+	return masked
+}
 
-	log.Printf("[DEBUG] [ENV] Wrote %d custom env vars to %s", len(vars), rcFile)
-	return nil
+func hasSecret(vars []EnvVar, masker *Masker) bool {
+	for _, v := range vars {
+		if masker.IsSecret(v.Key) {
+			return true
+		}
+	}
+	return false
 }
 
 // parseEnvOutput parses output from env command into EnvVar slice
@@ -284,42 +480,159 @@ func parseEnvOutput(output string) []EnvVar {
 	return vars
 }
 
-// extractManagedSection extracts env vars from the managed section
-func extractManagedSection(content string) []EnvVar {
-	var vars []EnvVar
-
+// extractManagedSection extracts env vars from the managed section. On
+// ErrUnterminatedQuote it returns the vars before the quote.
+func extractManagedSection(content string) ([]EnvVar, error) {
 	startIdx := strings.Index(content, SectionStart)
 	endIdx := strings.Index(content, SectionEnd)
 
 	if startIdx == -1 || endIdx == -1 || endIdx <= startIdx {
-		return vars // No managed section found
+		return nil, nil // No managed section found
 	}
 
 	// Extract section content (between markers)
-	sectionContent := content[startIdx+len(SectionStart) : endIdx]
-
-	// Parse export statements
-	// Match: export KEY=VALUE or export KEY="VALUE" or export KEY='VALUE'
-	exportRegex := regexp.MustCompile(`(?m)^export\s+([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
-	matches := exportRegex.FindAllStringSubmatch(sectionContent, -1)
-
-	for _, match := range matches {
-		if len(match) >= 3 {
-			key := match[1]
-			value := match[2]
-
-			// Remove surrounding quotes if present
-			value = strings.TrimSpace(value)
-			if (strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"")) ||
-				(strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'")) {
-				value = value[1 : len(value)-1]
+	return parseAssignments(content[startIdx+len(SectionStart) : endIdx])
+}
+
+// parseAssignments parses shell variable assignments, with or without a
+// leading "export", reading values the way the shell would: single quotes are
+// literal, double quotes allow \" \\ \$ \` escapes, either kind may span
+// lines, and a backslash-newline outside single quotes continues the line.
+// Comments and lines that aren't assignments are skipped. A quote that is
+// never closed stops parsing with ErrUnterminatedQuote, since where the value
+// was meant to end can't be told.
+func parseAssignments(s string) ([]EnvVar, error) {
+	var vars []EnvVar
+
+	for i := 0; i < len(s); {
+		i = skipBlanks(s, i)
+		if i >= len(s) {
+			break
+		}
+		switch s[i] {
+		case '\n', '\r', ';':
+			i++
+			continue
+		case '#':
+			i = skipLine(s, i)
+			continue
+		}
+
+		stmt := i
+		if strings.HasPrefix(s[i:], "export") && i+6 < len(s) && (s[i+6] == ' ' || s[i+6] == '\t') {
+			i = skipBlanks(s, i+6)
+		}
+
+		keyEnd := i
+		for keyEnd < len(s) && isNameChar(s[keyEnd], keyEnd == i) {
+			keyEnd++
+		}
+		if keyEnd == i || keyEnd >= len(s) || s[keyEnd] != '=' {
+			i = skipLine(s, stmt)
+			continue
+		}
+		key := s[i:keyEnd]
+
+		value, next, ok := parseValue(s, keyEnd+1)
+		if !ok {
+			return vars, fmt.Errorf("%w, in the value of %s", ErrUnterminatedQuote, key)
+		}
+		vars = append(vars, EnvVar{Key: key, Value: value})
+
+		// Anything after the value other than another statement is ignored
+		i = skipBlanks(s, next)
+		if i < len(s) && s[i] != ';' {
+			i = skipLine(s, i)
+		}
+	}
+
+	return vars, nil
+}
+
+// parseValue reads a shell word starting at i, returning the unquoted value
+// and the index just past it. ok is false if a quote is never closed.
+func parseValue(s string, i int) (value string, next int, ok bool) {
+	var sb strings.Builder
+
+	for i < len(s) {
+		c := s[i]
+		switch c {
+		case ' ', '\t', '\n', '\r', ';':
+			return sb.String(), i, true
+
+		case '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end == -1 {
+				return "", i, false
+			}
+			sb.WriteString(s[i+1 : i+1+end])
+			i += end + 2
+
+		case '"':
+			i++
+			for {
+				if i >= len(s) {
+					return "", i, false
+				}
+				c := s[i]
+				if c == '"' {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(s) {
+					switch s[i+1] {
+					case '"', '\\', '$', '`':
+						sb.WriteByte(s[i+1])
+						i += 2
+						continue
+					case '\n':
+						i += 2
+						continue
+					}
+				}
+				sb.WriteByte(c)
+				i++
+			}
+
+		case '\\':
+			if i+1 < len(s) && s[i+1] != '\n' {
+				sb.WriteByte(s[i+1])
 			}
+			i += 2
 
-			vars = append(vars, EnvVar{Key: key, Value: value})
+		default:
+			sb.WriteByte(c)
+			i++
 		}
 	}
 
-	return vars
+	return sb.String(), i, true
+}
+
+// isNameChar reports whether c may appear in a variable name
+func isNameChar(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
+
+func skipBlanks(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// skipLine returns the index just past the end of the line containing i
+func skipLine(s string, i int) int {
+	if end := strings.IndexByte(s[i:], '\n'); end != -1 {
+		return i + end + 1
+	}
+	return len(s)
 }
 
 // removeManagedSection removes the managed section from content
@@ -341,7 +654,8 @@ func removeManagedSection(content string) string {
 	return content[:startIdx] + content[endOfEndMarker:]
 }
 
-// buildManagedSection builds the managed section content
+// buildManagedSection builds the managed section content. Every value is
+// double-quoted so extractManagedSection reads back exactly what was written.
 func buildManagedSection(vars []EnvVar) string {
 	var sb strings.Builder
 
@@ -349,18 +663,7 @@ func buildManagedSection(vars []EnvVar) string {
 	sb.WriteString("\n")
 
 	for _, v := range vars {
-		// Quote values that contain spaces or special chars
-		value := v.Value
-		if strings.ContainsAny(value, " \t\"'$`\\") {
-			// Escape double quotes and use double quotes
-			value = strings.ReplaceAll(value, "\\", "\\\\")
-			value = strings.ReplaceAll(value, "\"", "\\\"")
-			value = strings.ReplaceAll(value, "$", "\\$")
-			value = strings.ReplaceAll(value, "`", "\\`")
-			value = "\"" + value + "\""
-		}
-
-		sb.WriteString(fmt.Sprintf("export %s=%s\n", v.Key, value))
+		sb.WriteString(fmt.Sprintf("export %s=%s\n", v.Key, quoteValue(v.Value)))
 	}
 
 	sb.WriteString(SectionEnd)
@@ -368,3 +671,23 @@ func buildManagedSection(vars []EnvVar) string {
 
 	return sb.String()
 }
+
+// quoteValue double-quotes a value for the RC file, escaping the characters
+// the shell treats specially inside double quotes. Newlines are left as-is:
+// a newline inside double quotes is part of the value in every POSIX shell,
+// whereas $'\n' escapes only work in bash and zsh.
+func quoteValue(value string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '"', '\\', '$', '`':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
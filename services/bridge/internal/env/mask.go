@@ -0,0 +1,46 @@
+package env
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DefaultSecretPatterns match the keys whose values are masked by default
+var DefaultSecretPatterns = []string{"*_TOKEN", "*_SECRET", "*_KEY", "*PASSWORD*"}
+
+// MaskedValue is sent in place of a secret value
+const MaskedValue = "********"
+
+// Masker decides which env vars hold secrets. Patterns are shell-style globs
+// ("*_TOKEN") matched case-insensitively against the whole key.
+type Masker struct {
+	patterns []string
+}
+
+// NewMasker creates a masker for the given key patterns
+func NewMasker(patterns []string) (*Masker, error) {
+	m := &Masker{}
+	for _, p := range patterns {
+		p = strings.ToUpper(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid secret pattern %q: %w", p, err)
+		}
+		m.patterns = append(m.patterns, p)
+	}
+	return m, nil
+}
+
+// IsSecret reports whether the value of key should be masked
+func (m *Masker) IsSecret(key string) bool {
+	key = strings.ToUpper(key)
+	for _, p := range m.patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package env
+
+import "testing"
+
+func TestDefaultSecretPatterns(t *testing.T) {
+	m, err := NewMasker(DefaultSecretPatterns)
+	if err != nil {
+		t.Fatalf("NewMasker: %v", err)
+	}
+
+	tests := []struct {
+		key    string
+		secret bool
+	}{
+		{"AWS_SECRET_ACCESS_KEY", true},
+		{"ANTHROPIC_API_KEY", true},
+		{"GITHUB_TOKEN", true},
+		{"CLIENT_SECRET", true},
+		{"DB_PASSWORD", true},
+		{"PASSWORD", true},
+		{"MYSQL_PASSWORD_FILE", true},
+		{"github_token", true},
+		{"HOME", false},
+		{"PATH", false},
+		{"KEYBOARD_LAYOUT", false},
+		{"TOKEN", false},
+		{"SECRET_PATH", false},
+	}
+	for _, tt := range tests {
+		if got := m.IsSecret(tt.key); got != tt.secret {
+			t.Errorf("IsSecret(%q) = %v, want %v", tt.key, got, tt.secret)
+		}
+	}
+}
+
+func TestCustomSecretPatterns(t *testing.T) {
+	m, err := NewMasker([]string{" stripe_* ", "", "*_DSN"})
+	if err != nil {
+		t.Fatalf("NewMasker: %v", err)
+	}
+	if !m.IsSecret("STRIPE_LIVE") || !m.IsSecret("SENTRY_DSN") {
+		t.Error("expected custom patterns to match")
+	}
+	if m.IsSecret("GITHUB_TOKEN") {
+		t.Error("custom patterns should replace the defaults")
+	}
+
+	if _, err := NewMasker([]string{"[A-"}); err == nil {
+		t.Error("expected malformed pattern to be rejected")
+	}
+
+	empty, _ := NewMasker(nil)
+	if empty.IsSecret("GITHUB_TOKEN") {
+		t.Error("no patterns should mask nothing")
+	}
+}
@@ -0,0 +1,128 @@
+// Package i18n holds the localized messages of the errors the bridge sends.
+// An error's code says what went wrong and its details the particulars; the
+// message is a short description of the code, in the client's language when
+// the catalog has it.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// DefaultLocale is the locale every code has a message in, used when the
+// client's has none
+const DefaultLocale = "en"
+
+//go:embed catalog/*.json
+var embedded embed.FS
+
+// Catalog holds error messages by locale and code. Locales other than
+// DefaultLocale may leave codes out.
+type Catalog struct {
+	messages map[string]map[protocol.ErrorCode]string
+}
+
+// Load reads a catalog from the JSON files of fsys: catalog/<locale>.json,
+// each an object of messages keyed by error code
+func Load(fsys fs.FS) (*Catalog, error) {
+	files, err := fs.Glob(fsys, "catalog/*.json")
+	if err != nil {
+		return nil, err
+	}
+	c := &Catalog{messages: make(map[string]map[protocol.ErrorCode]string, len(files))}
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		var messages map[protocol.ErrorCode]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		c.messages[normalizeLocale(strings.TrimSuffix(path.Base(file), ".json"))] = messages
+	}
+	return c, nil
+}
+
+// Default returns the catalog built into the bridge, checked against
+// protocol.ErrorCodes
+func Default() (*Catalog, error) {
+	c, err := Load(embedded)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Validate(protocol.ErrorCodes()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks DefaultLocale has a message for each of codes, and no
+// locale has one for a code outside them
+func (c *Catalog) Validate(codes []protocol.ErrorCode) error {
+	known := make(map[protocol.ErrorCode]bool, len(codes))
+	for _, code := range codes {
+		known[code] = true
+		if strings.TrimSpace(c.messages[DefaultLocale][code]) == "" {
+			return fmt.Errorf("error code %s has no %s message", code, DefaultLocale)
+		}
+	}
+	for _, locale := range c.Locales() {
+		for code := range c.messages[locale] {
+			if !known[code] {
+				return fmt.Errorf("%s catalog has a message for unknown error code %s", locale, code)
+			}
+		}
+	}
+	return nil
+}
+
+// Locales returns the catalog's locales in order
+func (c *Catalog) Locales() []string {
+	locales := make([]string, 0, len(c.messages))
+	for locale := range c.messages {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Resolve returns the catalog locale closest to a client's: the locale
+// itself ("pt-br"), its language ("pt"), or DefaultLocale
+func (c *Catalog) Resolve(locale string) string {
+	locale = normalizeLocale(locale)
+	if _, ok := c.messages[locale]; ok {
+		return locale
+	}
+	if language, _, found := strings.Cut(locale, "-"); found {
+		if _, ok := c.messages[language]; ok {
+			return language
+		}
+	}
+	return DefaultLocale
+}
+
+// Message returns the message of code in the locale closest to the
+// client's, falling back to DefaultLocale and then to the code itself
+func (c *Catalog) Message(locale string, code protocol.ErrorCode) string {
+	if message, ok := c.messages[c.Resolve(locale)][code]; ok {
+		return message
+	}
+	if message, ok := c.messages[DefaultLocale][code]; ok {
+		return message
+	}
+	return string(code)
+}
+
+// normalizeLocale lowercases a BCP 47 tag and accepts POSIX underscores:
+// "pt_BR" and "PT-br" are both "pt-br"
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
@@ -0,0 +1,87 @@
+package i18n
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+func TestDefaultCatalogCoversErrorCodes(t *testing.T) {
+	c, err := Default()
+	if err != nil {
+		t.Fatalf("Default: %v", err)
+	}
+	for _, code := range protocol.ErrorCodes() {
+		if message := c.Message(DefaultLocale, code); message == "" || message == string(code) {
+			t.Errorf("%s has no %s message", code, DefaultLocale)
+		}
+	}
+}
+
+func testCatalog(t *testing.T) *Catalog {
+	t.Helper()
+	c, err := Load(fstest.MapFS{
+		"catalog/en.json":    {Data: []byte(`{"NOT_FOUND": "Not found", "NO_PTY": "Process has no terminal"}`)},
+		"catalog/pt.json":    {Data: []byte(`{"NOT_FOUND": "Não encontrado", "NO_PTY": "O processo não tem terminal"}`)},
+		"catalog/pt-BR.json": {Data: []byte(`{"NOT_FOUND": "Não achado"}`)},
+	})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return c
+}
+
+func TestCatalogMessage(t *testing.T) {
+	c := testCatalog(t)
+	tests := []struct {
+		locale string
+		code   protocol.ErrorCode
+		want   string
+	}{
+		{"pt-BR", protocol.ErrorNotFound, "Não achado"},
+		{"pt_br", protocol.ErrorNotFound, "Não achado"},
+		{"pt-PT", protocol.ErrorNotFound, "Não encontrado"},
+		// A locale missing a code falls back to the default, not its language
+		{"pt-BR", protocol.ErrorNoPty, "Process has no terminal"},
+		{"pt", protocol.ErrorNoPty, "O processo não tem terminal"},
+		{"he-IL", protocol.ErrorNotFound, "Not found"},
+		{"", protocol.ErrorNotFound, "Not found"},
+		// A code without any message is sent as itself
+		{"en", protocol.ErrorSendFailed, "SEND_FAILED"},
+	}
+	for _, tt := range tests {
+		if got := c.Message(tt.locale, tt.code); got != tt.want {
+			t.Errorf("Message(%q, %s) = %q, want %q", tt.locale, tt.code, got, tt.want)
+		}
+	}
+
+	if got := c.Locales(); strings.Join(got, ",") != "en,pt,pt-br" {
+		t.Errorf("Locales() = %v", got)
+	}
+	if got := c.Resolve("PT-br"); got != "pt-br" {
+		t.Errorf("Resolve(PT-br) = %q", got)
+	}
+}
+
+func TestCatalogValidate(t *testing.T) {
+	c := testCatalog(t)
+	if err := c.Validate([]protocol.ErrorCode{protocol.ErrorNotFound, protocol.ErrorNoPty}); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+
+	// Every code needs a default message, and messages need a code
+	err := c.Validate([]protocol.ErrorCode{protocol.ErrorNotFound, protocol.ErrorNoPty, protocol.ErrorSendFailed})
+	if err == nil || !strings.Contains(err.Error(), "SEND_FAILED has no en message") {
+		t.Errorf("Validate with a missing message = %v", err)
+	}
+	err = c.Validate([]protocol.ErrorCode{protocol.ErrorNotFound})
+	if err == nil || !strings.Contains(err.Error(), "unknown error code NO_PTY") {
+		t.Errorf("Validate with an unknown code = %v", err)
+	}
+
+	if _, err := Load(fstest.MapFS{"catalog/en.json": {Data: []byte(`{"NOT_FOUND": 1}`)}}); err == nil {
+		t.Error("Load accepted a catalog that isn't messages")
+	}
+}
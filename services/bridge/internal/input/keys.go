@@ -0,0 +1,51 @@
+package input
+
+// NamedKeys maps key names to the bytes a terminal sends for them, with
+// cursor keys in normal (not application) mode, as the app's key bar sends
+// them. In ModeKeys these are the only control characters and escape
+// sequences let through.
+var NamedKeys = func() map[string]string {
+	keys := map[string]string{
+		"Enter":     "\r",
+		"Tab":       "\t",
+		"BackTab":   "\x1b[Z",
+		"Backspace": "\x7f",
+		"Escape":    "\x1b",
+		"Up":        "\x1b[A",
+		"Down":      "\x1b[B",
+		"Right":     "\x1b[C",
+		"Left":      "\x1b[D",
+		"Home":      "\x1b[H",
+		"End":       "\x1b[F",
+		"Insert":    "\x1b[2~",
+		"Delete":    "\x1b[3~",
+		"PageUp":    "\x1b[5~",
+		"PageDown":  "\x1b[6~",
+		"F1":        "\x1bOP",
+		"F2":        "\x1bOQ",
+		"F3":        "\x1bOR",
+		"F4":        "\x1bOS",
+		"F5":        "\x1b[15~",
+		"F6":        "\x1b[17~",
+		"F7":        "\x1b[18~",
+		"F8":        "\x1b[19~",
+		"F9":        "\x1b[20~",
+		"F10":       "\x1b[21~",
+		"F11":       "\x1b[23~",
+		"F12":       "\x1b[24~",
+	}
+	// Ctrl+A through Ctrl+Z are bytes 1-26
+	for c := byte('a'); c <= 'z'; c++ {
+		keys["C-"+string(c)] = string(c - 'a' + 1)
+	}
+	return keys
+}()
+
+// allowedSequences is the set of NamedKeys' sequences
+var allowedSequences = func() map[string]bool {
+	allowed := make(map[string]bool, len(NamedKeys))
+	for _, seq := range NamedKeys {
+		allowed[seq] = true
+	}
+	return allowed
+}()
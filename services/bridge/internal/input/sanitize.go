@@ -0,0 +1,112 @@
+// Package input limits what the bridge types into a terminal or sends as
+// Claude's raw input to well-formed text and key sequences.
+//
+// A malformed escape sequence, e.g. a lone ESC followed by garbage, can put a
+// full-screen program into a mode that is painful to get out of from a
+// phone. Input is read as escape sequences (CSI, SS3, string sequences such
+// as OSC, and two-byte escapes), control characters and UTF-8 text, and what
+// a mode doesn't allow is dropped whole:
+//
+//	ModeText  text, newlines and tabs; every other control character and
+//	          every escape sequence is dropped
+//	ModeKeys  text, plus the control characters and escape sequences that
+//	          NamedKeys produces
+//	ModeRaw   everything, as sent
+//
+// Text is kept rune by rune, so a multi-byte character is never split;
+// bytes that aren't valid UTF-8 and C1 control characters are dropped in
+// both filtering modes.
+package input
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Mode is how input is sanitized
+type Mode string
+
+const (
+	ModeText Mode = "text" // Printable text, newlines and tabs
+	ModeKeys Mode = "keys" // Printable text and the sequences of NamedKeys
+	ModeRaw  Mode = "raw"  // Passed through untouched
+)
+
+const esc = 0x1b
+
+// Sanitize returns data with what mode doesn't allow dropped
+func Sanitize(data string, mode Mode) (string, error) {
+	switch mode {
+	case ModeRaw:
+		return data, nil
+	case ModeText, ModeKeys:
+	default:
+		return "", fmt.Errorf("unknown input mode %q", mode)
+	}
+
+	var out strings.Builder
+	out.Grow(len(data))
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == esc:
+			n := sequenceLength(data[i:])
+			if mode == ModeKeys && allowedSequences[data[i:i+n]] {
+				out.WriteString(data[i : i+n])
+			}
+			i += n
+		case c < 0x20 || c == 0x7f:
+			if c == '\n' || c == '\t' || (mode == ModeKeys && allowedSequences[string(c)]) {
+				out.WriteByte(c)
+			}
+			i++
+		default:
+			r, size := utf8.DecodeRuneInString(data[i:])
+			if r != utf8.RuneError && (r < 0x80 || r > 0x9f) {
+				out.WriteString(data[i : i+size])
+			}
+			i += size
+		}
+	}
+	return out.String(), nil
+}
+
+// sequenceLength returns the length of the escape sequence data starts
+// with, up to the end of data for one that is cut off. ESC followed by
+// anything that can't continue a sequence is a sequence of its own.
+func sequenceLength(data string) int {
+	if len(data) < 2 {
+		return len(data)
+	}
+	switch data[1] {
+	case '[': // CSI: parameter bytes, intermediate bytes, a final byte
+		i := 2
+		for i < len(data) && data[i] >= 0x20 && data[i] <= 0x3f {
+			i++
+		}
+		if i < len(data) && data[i] >= 0x40 && data[i] <= 0x7e {
+			return i + 1
+		}
+		return i
+	case 'O': // SS3: one more byte
+		if len(data) > 2 && data[2] >= 0x20 && data[2] <= 0x7e {
+			return 3
+		}
+		return 2
+	case ']', 'P', 'X', '^', '_': // OSC, DCS, SOS, PM, APC: a string up to BEL or ST
+		for i := 2; i < len(data); i++ {
+			if data[i] == '\a' {
+				return i + 1
+			}
+			if data[i] == esc && i+1 < len(data) && data[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return len(data)
+	}
+	if data[1] >= 0x20 && data[1] <= 0x7e {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,95 @@
+package input
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		text  string
+		keys  string
+	}{
+		{"empty", "", "", ""},
+		{"plain text", "hello world", "hello world", "hello world"},
+		{"newline and tab", "a\tb\nc", "a\tb\nc", "a\tb\nc"},
+		{"carriage return is Enter", "ls\r", "ls", "ls\r"},
+		{"ctrl keys", "\x03\x04\x1a", "", "\x03\x04\x1a"},
+		{"nul and other C0", "a\x00b\x1cc\x1fd", "abcd", "abcd"},
+		{"backspace", "ab\x7f", "ab", "ab\x7f"},
+		{"arrow keys", "\x1b[A\x1b[B\x1b[C\x1b[D", "", "\x1b[A\x1b[B\x1b[C\x1b[D"},
+		{"function keys", "\x1bOP\x1b[15~\x1b[24~", "", "\x1bOP\x1b[15~\x1b[24~"},
+		{"lone escape", "\x1b", "", "\x1b"},
+		{"escape then escape", "\x1b\x1b", "", "\x1b\x1b"},
+		{"escape followed by garbage", "\x1bzq", "q", "q"},
+		{"cut off CSI", "ok\x1b[12;", "ok", "ok"},
+		{"unknown CSI", "a\x1b[?1049hb", "ab", "ab"},
+		{"CSI with a control byte inside", "\x1b[1\x03", "", "\x03"},
+		{"mouse mode CSI", "\x1b[?1000h", "", ""},
+		{"OSC ended by BEL", "a\x1b]0;title\ab", "ab", "ab"},
+		{"OSC ended by ST", "a\x1b]52;c;Zm9v\x1b\\b", "ab", "ab"},
+		{"unterminated OSC", "a\x1b]0;title", "a", "a"},
+		{"DCS", "\x1bPq#0\x1b\\x", "x", "x"},
+		{"cut off SS3", "\x1bO", "", ""},
+		{"C1 control", "a\u009bb\u0085c", "abc", "abc"},
+		{"multi-byte text", "héllo 日本語 🚀", "héllo 日本語 🚀", "héllo 日本語 🚀"},
+		{"multi-byte around a sequence", "日\x1b[A本", "日本", "日\x1b[A本"},
+		{"invalid UTF-8", "a\xffb\xc3", "ab", "ab"},
+		{"truncated rune", "ok\xe6\x97", "ok", "ok"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for mode, want := range map[Mode]string{ModeText: tt.text, ModeKeys: tt.keys, ModeRaw: tt.input} {
+				got, err := Sanitize(tt.input, mode)
+				if err != nil {
+					t.Fatalf("Sanitize(%q, %s) error: %v", tt.input, mode, err)
+				}
+				if got != want {
+					t.Errorf("Sanitize(%q, %s) = %q, want %q", tt.input, mode, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSanitizeKeepsEveryNamedKey(t *testing.T) {
+	// Followed by a newline, as a letter after ESC would make an Alt chord
+	for name, seq := range NamedKeys {
+		got, err := Sanitize("x"+seq+"\n", ModeKeys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "x"+seq+"\n" {
+			t.Errorf("%s: Sanitize(%q, keys) = %q", name, seq, got)
+		}
+	}
+}
+
+func TestSanitizeKeepsUTF8Valid(t *testing.T) {
+	// Any input, cut at any byte, sanitizes to valid UTF-8 without a
+	// control character outside the named keys
+	input := "日本\x1b[A語\x1b]0;t\a🚀\xff\x1b\u0085é"
+	for i := range len(input) + 1 {
+		for _, mode := range []Mode{ModeText, ModeKeys} {
+			got, _ := Sanitize(input[:i], mode)
+			if !utf8.ValidString(got) {
+				t.Errorf("Sanitize(%q, %s) = %q, not valid UTF-8", input[:i], mode, got)
+			}
+			if strings.ContainsRune(got, 0x85) || strings.Contains(got, "\x1b]") {
+				t.Errorf("Sanitize(%q, %s) = %q kept a control sequence", input[:i], mode, got)
+			}
+		}
+	}
+}
+
+func TestSanitizeUnknownMode(t *testing.T) {
+	for _, mode := range []Mode{"", "binary"} {
+		if _, err := Sanitize("a", mode); err == nil {
+			t.Errorf("Sanitize with mode %q succeeded", mode)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package process
+
+// SetAgentStatus records the status a Claude process's AgentAPI last
+// reported, "running" or "stable", with its agent type; an empty status
+// means it is unknown, such as when AgentAPI stopped answering. It reports
+// whether the status changed.
+func (p *Process) SetAgentStatus(status, agentType string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if status == "" {
+		agentType = ""
+	} else if agentType == "" {
+		// status_change events may leave the type out
+		agentType = p.agentType
+	}
+	changed := p.agentStatus != status
+	p.agentStatus, p.agentType = status, agentType
+	return changed
+}
+
+// AgentStatus returns the status AgentAPI last reported and its agent type,
+// or "" if it isn't known
+func (p *Process) AgentStatus() (status, agentType string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.agentStatus, p.agentType
+}
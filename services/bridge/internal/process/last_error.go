@@ -0,0 +1,84 @@
+package process
+
+import (
+	"slices"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// Operation is a kind of work on a process that can fail. A process's last
+// error is cleared when the operation that failed next succeeds.
+type Operation string
+
+const (
+	OpPtyWrite    Operation = "pty_write"    // Writing input into the PTY
+	OpPtyResize   Operation = "pty_resize"   // Resizing the PTY
+	OpAgentEvents Operation = "agent_events" // AgentAPI's event stream
+	OpAgentStatus Operation = "agent_status" // Asking AgentAPI for its status
+	OpClaudeStart Operation = "claude_start" // Starting Claude under AgentAPI
+	OpClaudeKill  Operation = "claude_kill"  // Stopping Claude's AgentAPI server
+)
+
+// LastError is the most recent failure on a process that hasn't been
+// cleared since
+type LastError struct {
+	Operation Operation
+	Code      protocol.ErrorCode
+	Message   string
+	At        time.Time
+}
+
+// SetLastError records a failure on the process, replacing the previous
+// one. It reports whether that changed the error the process shows: the
+// same failure again only keeps its first time.
+func (p *Process) SetLastError(e LastError) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lastError != nil && p.lastError.Operation == e.Operation &&
+		p.lastError.Code == e.Code && p.lastError.Message == e.Message {
+		return false
+	}
+	p.lastError = &e
+	return true
+}
+
+// ClearLastError clears the process's last error if one of ops failed, or
+// whatever failed if ops is empty. It reports whether there was an error to
+// clear.
+func (p *Process) ClearLastError(ops ...Operation) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lastError == nil {
+		return false
+	}
+	if len(ops) > 0 && !slices.Contains(ops, p.lastError.Operation) {
+		return false
+	}
+	p.lastError = nil
+	return true
+}
+
+// GetLastError returns the process's last error, or nil if it has none
+func (p *Process) GetLastError() *LastError {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lastError == nil {
+		return nil
+	}
+	e := *p.lastError
+	return &e
+}
+
+// toProtocol converts a last error for ProcessInfo
+func (e *LastError) toProtocol() *protocol.ProcessError {
+	if e == nil {
+		return nil
+	}
+	return &protocol.ProcessError{
+		Operation: string(e.Operation),
+		Code:      e.Code,
+		Message:   e.Message,
+		At:        e.At.UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,234 @@
+package process
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// PortState is why a port in the pool is taken, if it is
+type PortState int
+
+const (
+	PortFree PortState = iota
+	// PortActive is allocated to, or found in use by, a process the
+	// registry tracks; only releasing the port frees it
+	PortActive
+	// PortReserved is held for something a host scan found that may be gone
+	// by now, such as a stale AgentAPI or a detached session's recorded
+	// port. Reservations are re-verified when allocation runs out of ports.
+	PortReserved
+)
+
+// String returns the state's name, for logs
+func (s PortState) String() string {
+	switch s {
+	case PortActive:
+		return "active"
+	case PortReserved:
+		return "reserved"
+	}
+	return "free"
+}
+
+// portEntry is the state of one port in the pool
+type portEntry struct {
+	state  PortState
+	hostID string    // Host a reservation was found on
+	reason string    // What the reservation is for
+	since  time.Time // When the port was taken
+}
+
+// PortProbe reports whether something listens on a port of a host. known
+// is false when it can't tell, e.g. because the host isn't connected.
+type PortProbe func(hostID string, port int) (listening, known bool)
+
+// PortPool manages port allocation for AgentAPI servers
+type PortPool struct {
+	portRange PortRange
+	ports     map[int]*portEntry
+	mu        sync.Mutex
+
+	// Re-verification of reservations, see SetReclaim
+	probe  PortProbe
+	maxAge time.Duration
+}
+
+// NewPortPool creates a new port pool over the given range
+func NewPortPool(portRange PortRange) *PortPool {
+	pool := &PortPool{
+		portRange: portRange,
+		ports:     make(map[int]*portEntry),
+	}
+	// Initialize all ports as available
+	for port := portRange.Min; port <= portRange.Max; port++ {
+		pool.ports[port] = &portEntry{}
+	}
+	return pool
+}
+
+// SetReclaim sets how reservations are re-verified when allocation finds no
+// free port: a reservation is reclaimed when probe finds nothing listening
+// on it, or, when probe can't tell, once it is older than maxAge (0 keeps
+// those). A reservation something still listens on is kept however old.
+func (p *PortPool) SetReclaim(probe PortProbe, maxAge time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.probe = probe
+	p.maxAge = maxAge
+}
+
+// Allocate allocates an available port. When every port is taken, the
+// reservations are re-verified and the ports of those that are gone are
+// reclaimed before giving up.
+func (p *PortPool) Allocate() (int, error) {
+	if port, ok := p.allocateFree(); ok {
+		return port, nil
+	}
+	if p.reclaim() > 0 {
+		if port, ok := p.allocateFree(); ok {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no available ports in range %s", p.portRange)
+}
+
+// allocateFree allocates the lowest free port
+func (p *PortPool) allocateFree() (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for port := p.portRange.Min; port <= p.portRange.Max; port++ {
+		if entry := p.ports[port]; entry.state == PortFree {
+			*entry = portEntry{state: PortActive, since: time.Now()}
+			log.Printf("[DEBUG] [PORT] Allocated port %d", port)
+			return port, true
+		}
+	}
+	return 0, false
+}
+
+// Release releases a port back to the pool
+func (p *PortPool) Release(port int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.portRange.Contains(port) {
+		*p.ports[port] = portEntry{}
+		log.Printf("[DEBUG] [PORT] Released port %d", port)
+	}
+}
+
+// MarkInUse marks a port as in use by a process the registry tracks (for
+// existing processes found during reconnect). A reservation of the port
+// becomes active.
+func (p *PortPool) MarkInUse(port int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if entry := p.ports[port]; entry != nil && entry.state != PortActive {
+		*entry = portEntry{state: PortActive, since: time.Now()}
+		log.Printf("[DEBUG] [PORT] Marked port %d as in-use (existing process)", port)
+	}
+}
+
+// Reserve holds a port for something found on a host that may have gone
+// away, so it isn't allocated while it may still be bound. A port already
+// taken keeps its state, and a reservation keeps its original age.
+func (p *PortPool) Reserve(port int, hostID, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if entry := p.ports[port]; entry != nil && entry.state == PortFree {
+		*entry = portEntry{state: PortReserved, hostID: hostID, reason: reason, since: time.Now()}
+		log.Printf("[DEBUG] [PORT] Reserved port %d for %s on host %s", port, reason, hostID)
+	}
+}
+
+// IsInUse checks if a port is in use or reserved
+func (p *PortPool) IsInUse(port int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry := p.ports[port]
+	return entry != nil && entry.state != PortFree
+}
+
+// State returns a port's state
+func (p *PortPool) State(port int) PortState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if entry := p.ports[port]; entry != nil {
+		return entry.state
+	}
+	return PortFree
+}
+
+// AvailableCount returns the number of available ports
+func (p *PortPool) AvailableCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	count := 0
+	for _, entry := range p.ports {
+		if entry.state == PortFree {
+			count++
+		}
+	}
+	return count
+}
+
+// reclaim re-verifies every reservation, probing them all at once outside
+// the lock, and frees the ports of those that are gone. A port taken again
+// while it was probed is left alone. Returns the number of ports freed.
+func (p *PortPool) reclaim() int {
+	type reservation struct {
+		port int
+		portEntry
+		listening, known bool
+	}
+	p.mu.Lock()
+	probe, maxAge := p.probe, p.maxAge
+	var reservations []*reservation
+	for port, entry := range p.ports {
+		if entry.state == PortReserved {
+			reservations = append(reservations, &reservation{port: port, portEntry: *entry})
+		}
+	}
+	p.mu.Unlock()
+
+	if probe != nil {
+		var wg sync.WaitGroup
+		for _, r := range reservations {
+			wg.Go(func() {
+				r.listening, r.known = probe(r.hostID, r.port)
+			})
+		}
+		wg.Wait()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	reclaimed := 0
+	for _, r := range reservations {
+		age := time.Since(r.since).Round(time.Second)
+		var why string
+		switch {
+		case r.known && !r.listening:
+			why = "nothing listens on it"
+		case !r.known && maxAge > 0 && age > maxAge:
+			why = fmt.Sprintf("it could not be verified and is older than %v", maxAge)
+		default:
+			continue
+		}
+		if *p.ports[r.port] != r.portEntry {
+			continue
+		}
+		*p.ports[r.port] = portEntry{}
+		reclaimed++
+		log.Printf("[INFO] [PORT] Reclaimed port %d reserved %v ago for %s on host %s: %s", r.port, age, r.reason, r.hostID, why)
+	}
+	if reclaimed == 0 {
+		log.Printf("[WARN] [PORT] No port in range %s is free and none of %d reservations could be reclaimed", p.portRange, len(reservations))
+	}
+	return reclaimed
+}
@@ -0,0 +1,50 @@
+package process
+
+import "fmt"
+
+const (
+	// Limits for a configured AgentAPI port range
+	LowestPort       = 1024
+	HighestPort      = 65535
+	MaxPortRangeSize = 512
+)
+
+// DefaultPortRange is the AgentAPI port range used when none is configured
+// (3284-3299, 16 ports)
+var DefaultPortRange = PortRange{Min: 3284, Max: 3299}
+
+// PortRange is an inclusive range of ports for AgentAPI servers
+type PortRange struct {
+	Min int
+	Max int
+}
+
+// Validate checks the range holds 1 to MaxPortRangeSize ports between
+// LowestPort and HighestPort
+func (r PortRange) Validate() error {
+	if r.Min < LowestPort || r.Max > HighestPort {
+		return fmt.Errorf("port range %s must be within %d-%d", r, LowestPort, HighestPort)
+	}
+	if r.Max < r.Min {
+		return fmt.Errorf("port range %s ends before it starts", r)
+	}
+	if r.Size() > MaxPortRangeSize {
+		return fmt.Errorf("port range %s has %d ports, at most %d are allowed", r, r.Size(), MaxPortRangeSize)
+	}
+	return nil
+}
+
+// Size returns the number of ports in the range
+func (r PortRange) Size() int {
+	return r.Max - r.Min + 1
+}
+
+// Contains reports whether port is in the range
+func (r PortRange) Contains(port int) bool {
+	return port >= r.Min && port <= r.Max
+}
+
+// String formats the range as "min-max"
+func (r PortRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
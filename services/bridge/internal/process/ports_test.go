@@ -0,0 +1,146 @@
+package process
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPortRangeValidate(t *testing.T) {
+	tests := []struct {
+		r     PortRange
+		valid bool
+	}{
+		{DefaultPortRange, true},
+		{PortRange{Min: 8000, Max: 8000}, true},
+		{PortRange{Min: 40000, Max: 40511}, true},
+		{PortRange{Min: 65024, Max: 65535}, true},
+		{PortRange{Min: 40000, Max: 40512}, false}, // 513 ports
+		{PortRange{Min: 8001, Max: 8000}, false},
+		{PortRange{Min: 1023, Max: 1100}, false},
+		{PortRange{Min: 65500, Max: 65536}, false},
+		{PortRange{}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.r.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: Validate() = %v, want valid=%v", tt.r, err, tt.valid)
+		}
+	}
+}
+
+func TestRegistryAllocatesFromConfiguredRange(t *testing.T) {
+	r := NewRegistry(PortRange{Min: 40000, Max: 40001})
+	if r.PortRange() != (PortRange{Min: 40000, Max: 40001}) {
+		t.Errorf("PortRange() = %s", r.PortRange())
+	}
+
+	// Ports outside the range are never tracked
+	r.MarkPortInUse(DefaultPortRange.Min)
+	if r.IsPortInUse(DefaultPortRange.Min) {
+		t.Errorf("port %d outside the range was marked in use", DefaultPortRange.Min)
+	}
+
+	first, err1 := r.AllocatePort()
+	second, err2 := r.AllocatePort()
+	if err1 != nil || err2 != nil || first != 40000 || second != 40001 {
+		t.Fatalf("allocated %d (%v), %d (%v), want 40000 and 40001", first, err1, second, err2)
+	}
+	if _, err := r.AllocatePort(); err == nil {
+		t.Error("allocated a port from an exhausted range")
+	}
+	r.ReleasePort(first)
+	if port, err := r.AllocatePort(); err != nil || port != first {
+		t.Errorf("after release allocated %d (%v), want %d", port, err, first)
+	}
+}
+
+// ghostPool returns a pool over 40000-40003 whose every port is reserved on
+// host-1, as after weeks of host connects finding sessions that later died
+func ghostPool(t *testing.T) *PortPool {
+	t.Helper()
+	pool := NewPortPool(PortRange{Min: 40000, Max: 40003})
+	for port := 40000; port <= 40003; port++ {
+		pool.Reserve(port, "host-1", "detached session")
+	}
+	if _, err := pool.Allocate(); err == nil {
+		t.Fatal("allocated from a pool without a probe whose ports are all reserved")
+	}
+	return pool
+}
+
+func TestPortPoolReclaimsReservationsNothingListensOn(t *testing.T) {
+	pool := ghostPool(t)
+	var mu sync.Mutex
+	probed := map[int]string{}
+	pool.SetReclaim(func(hostID string, port int) (bool, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		probed[port] = hostID
+		return port == 40000 || port == 40002, true
+	}, 0)
+
+	port, err := pool.Allocate()
+	if err != nil || port != 40001 {
+		t.Fatalf("Allocate() = %d, %v, want 40001", port, err)
+	}
+	if len(probed) != 4 || probed[40003] != "host-1" {
+		t.Errorf("probed %v, want every reservation on host-1", probed)
+	}
+	// Still listening, so still reserved
+	for _, port := range []int{40000, 40002} {
+		if state := pool.State(port); state != PortReserved {
+			t.Errorf("port %d is %s, want reserved", port, state)
+		}
+	}
+	if port, err := pool.Allocate(); err != nil || port != 40003 {
+		t.Errorf("second Allocate() = %d, %v, want the other reclaimed port 40003", port, err)
+	}
+	if _, err := pool.Allocate(); err == nil {
+		t.Error("allocated a port something listens on")
+	}
+}
+
+func TestPortPoolExpiresUnverifiableReservations(t *testing.T) {
+	pool := ghostPool(t)
+	pool.SetReclaim(func(string, int) (bool, bool) { return false, false }, time.Hour)
+
+	// Nothing can be verified and nothing is old enough yet
+	if _, err := pool.Allocate(); err == nil {
+		t.Fatal("allocated a port whose reservation hasn't expired")
+	}
+
+	pool.mu.Lock()
+	pool.ports[40002].since = time.Now().Add(-2 * time.Hour)
+	pool.mu.Unlock()
+	if port, err := pool.Allocate(); err != nil || port != 40002 {
+		t.Errorf("Allocate() = %d, %v, want the expired 40002", port, err)
+	}
+}
+
+func TestPortPoolNeverReclaimsActivePorts(t *testing.T) {
+	pool := NewPortPool(PortRange{Min: 40000, Max: 40001})
+	pool.SetReclaim(func(hostID string, port int) (bool, bool) {
+		t.Errorf("probed active port %d", port)
+		return false, true
+	}, time.Nanosecond)
+
+	first, _ := pool.Allocate()
+	pool.Reserve(40001, "host-1", "stale AgentAPI (refused)")
+	pool.MarkInUse(40001) // Reattached
+	if state := pool.State(40001); state != PortActive {
+		t.Fatalf("reattached port is %s, want active", state)
+	}
+	if _, err := pool.Allocate(); err == nil {
+		t.Error("allocated from a pool of active ports")
+	}
+
+	// Reserving doesn't downgrade an allocated port
+	pool.Reserve(first, "host-1", "detached session")
+	if state := pool.State(first); state != PortActive {
+		t.Errorf("allocated port is %s after Reserve, want active", state)
+	}
+	pool.Release(first)
+	if state := pool.State(first); state != PortFree {
+		t.Errorf("released port is %s, want free", state)
+	}
+}
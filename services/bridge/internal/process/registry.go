@@ -1,9 +1,11 @@
 package process
 
 import (
-	"fmt"
+	"cmp"
 	"log"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/agentapi"
@@ -11,12 +13,6 @@ import (
 	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
 )
 
-const (
-	// Port range for AgentAPI servers (3284-3299, 16 ports)
-	MinPort = 3284
-	MaxPort = 3299
-)
-
 // ProcessType represents the type of process
 type ProcessType string
 
@@ -33,17 +29,34 @@ type EnvVar struct {
 
 // Process represents a managed process (shell or Claude)
 type Process struct {
-	ID            string
-	Type          ProcessType
-	HostID        string
-	PTY           *pty.Session
-	Port          *int        // AgentAPI port (only for Claude)
-	CWD           string
-	Name          *string     // Custom user-defined name
-	StartedAt     time.Time
-	ShellPID      *int        // Shell process PID on remote
-	AgentAPIPID   *int        // AgentAPI server PID (only for Claude)
-	EnvVars       []EnvVar    // Captured environment variables at spawn time
+	ID              string
+	Type            ProcessType
+	HostID          string
+	PTY             *pty.Session
+	Port            *int // AgentAPI port (only for Claude)
+	CWD             string
+	ClaudeCWD       string  // CWD snapshot taken at claude_start (only for Claude)
+	ClaudeSessionID string  // Session ID Claude was started with, "" when unknown (only for Claude)
+	Name            *string // Custom user-defined name
+	StartedAt       time.Time
+	ShellPID        *int              // Shell process PID on remote
+	AgentAPIPID     *int              // AgentAPI server PID (only for Claude)
+	EnvVars         []EnvVar          // Captured environment variables at spawn time
+	WorkspaceID     *string           // Workspace this process is grouped under
+	Pinned          bool              // Listed before unpinned processes
+	SortWeight      int               // Position among the host's processes (see GetByHost)
+	TermOptions     map[string]string // Terminal options chosen for the tmux session (see pty.SetTermOptions)
+	Timeline        bool              // Shell hooks report commands for the command timeline
+	Color           string            // Color the process is shown with, see protocol.AppearanceColors
+	Icon            string            // Icon the process is shown with, see protocol.AppearanceIcons
+
+	// RetainFullHistory exempts the process's PTY history from the
+	// per-process cap (see storage.PrunePtyHistory)
+	RetainFullHistory bool
+
+	// StartupHooks are the commands typed into the shell when it was
+	// created; set before the process is registered
+	StartupHooks []string
 
 	// AgentAPI clients (only for Claude processes)
 	AgentClient *agentapi.Client
@@ -52,6 +65,31 @@ type Process struct {
 	// State flags
 	PtyReady      bool
 	AgentAPIReady bool
+	Exited        bool // The tmux session is gone; the process stays listed until killed
+
+	shutdown bool // Unregistered; AgentAPI clients set afterwards are closed, see Shutdown
+
+	lastError *LastError // See SetLastError
+
+	// What AgentAPI last reported, see SetAgentStatus
+	agentStatus string
+	agentType   string
+
+	// Whether a Claude process's terminal dropped out of agentapi attach,
+	// and when the attach was last typed; see TerminalAttachChange
+	terminalDetached bool
+	attachTypedAt    time.Time
+
+	// The working directory last reported as changed and when, see
+	// CWDChange; reportedCWDSet is false until the baseline is taken
+	reportedCWD    string
+	reportedCWDSet bool
+	reportedCWDAt  time.Time
+
+	// Bytes moved through the bridge, also added to the host's total once
+	// registered (see CountTraffic)
+	traffic     Traffic
+	hostTraffic atomic.Pointer[Traffic]
 
 	mu sync.Mutex
 }
@@ -60,97 +98,24 @@ type Process struct {
 type Registry struct {
 	processes      sync.Map // map[processID]*Process
 	hostProcesses  sync.Map // map[hostID][]processID
-	staleProcesses sync.Map // map[hostID][]protocol.StaleProcess
+	stale          staleStore
 	portPool       *PortPool
+	traffic        hostTraffic
 	mu             sync.Mutex
 }
 
-// PortPool manages port allocation for AgentAPI servers
-type PortPool struct {
-	ports map[int]bool // port -> inUse
-	mu    sync.Mutex
-}
-
-// NewPortPool creates a new port pool
-func NewPortPool() *PortPool {
-	pool := &PortPool{
-		ports: make(map[int]bool),
-	}
-	// Initialize all ports as available
-	for port := MinPort; port <= MaxPort; port++ {
-		pool.ports[port] = false
-	}
-	return pool
-}
-
-// Allocate allocates an available port
-func (p *PortPool) Allocate() (int, error) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	for port := MinPort; port <= MaxPort; port++ {
-		if !p.ports[port] {
-			p.ports[port] = true
-			log.Printf("[DEBUG] [PORT] Allocated port %d", port)
-			return port, nil
-		}
-	}
-	return 0, fmt.Errorf("no available ports in range %d-%d", MinPort, MaxPort)
-}
-
-// Release releases a port back to the pool
-func (p *PortPool) Release(port int) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	if port >= MinPort && port <= MaxPort {
-		p.ports[port] = false
-		log.Printf("[DEBUG] [PORT] Released port %d", port)
-	}
-}
-
-// MarkInUse marks a port as in use (for existing processes found during reconnect)
-func (p *PortPool) MarkInUse(port int) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	if port >= MinPort && port <= MaxPort {
-		if !p.ports[port] {
-			p.ports[port] = true
-			log.Printf("[DEBUG] [PORT] Marked port %d as in-use (existing process)", port)
-		}
-	}
-}
-
-// IsInUse checks if a port is in use
-func (p *PortPool) IsInUse(port int) bool {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	return p.ports[port]
-}
-
-// AvailableCount returns the number of available ports
-func (p *PortPool) AvailableCount() int {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	count := 0
-	for _, inUse := range p.ports {
-		if !inUse {
-			count++
-		}
-	}
-	return count
-}
-
-// NewRegistry creates a new process registry
-func NewRegistry() *Registry {
+// NewRegistry creates a new process registry that allocates AgentAPI ports
+// from portRange
+func NewRegistry(portRange PortRange) *Registry {
 	return &Registry{
-		portPool: NewPortPool(),
+		portPool: NewPortPool(portRange),
+		traffic:  hostTraffic{since: time.Now()},
 	}
 }
 
 // Register registers a new process
 func (r *Registry) Register(proc *Process) {
+	proc.hostTraffic.Store(r.traffic.host(proc.HostID))
 	r.processes.Store(proc.ID, proc)
 
 	// Track by host
@@ -165,20 +130,23 @@ func (r *Registry) Register(proc *Process) {
 	r.hostProcesses.Store(proc.HostID, hostProcs)
 
 	log.Printf("[DEBUG] [REGISTRY] Registered process %s (type=%s, hostID=%s)",
-		proc.ID, proc.Type, proc.HostID)
+		proc.ID, proc.GetType(), proc.HostID)
 }
 
-// Unregister removes a process from the registry
+// Unregister removes a process from the registry and shuts its AgentAPI
+// clients down (see Process.Shutdown). The PTY is left as it is: callers
+// that are done with the tmux session detach or close it themselves.
 func (r *Registry) Unregister(processID string) {
 	procVal, ok := r.processes.Load(processID)
 	if !ok {
 		return
 	}
 	proc := procVal.(*Process)
+	proc.Shutdown()
 
 	// Release port if allocated
-	if proc.Port != nil {
-		r.portPool.Release(*proc.Port)
+	if port, ok := proc.GetPort(); ok {
+		r.portPool.Release(port)
 	}
 
 	// Remove from processes map
@@ -214,7 +182,8 @@ func (r *Registry) Get(processID string) *Process {
 	return nil
 }
 
-// GetByHost returns all processes for a host
+// GetByHost returns all processes for a host: pinned ones first, then by
+// ascending sort weight, then oldest first
 func (r *Registry) GetByHost(hostID string) []*Process {
 	r.mu.Lock()
 	procIDs, ok := r.hostProcesses.Load(hostID)
@@ -224,15 +193,45 @@ func (r *Registry) GetByHost(hostID string) []*Process {
 		return nil
 	}
 
-	var procs []*Process
+	type entry struct {
+		proc       *Process
+		pinned     bool
+		sortWeight int
+		startedAt  time.Time
+	}
+	var entries []entry
 	for _, id := range procIDs.([]string) {
 		if proc := r.Get(id); proc != nil {
-			procs = append(procs, proc)
+			proc.mu.Lock()
+			entries = append(entries, entry{proc, proc.Pinned, proc.SortWeight, proc.StartedAt})
+			proc.mu.Unlock()
 		}
 	}
+	slices.SortStableFunc(entries, func(a, b entry) int {
+		if a.pinned != b.pinned {
+			if a.pinned {
+				return -1
+			}
+			return 1
+		}
+		if c := cmp.Compare(a.sortWeight, b.sortWeight); c != 0 {
+			return c
+		}
+		return a.startedAt.Compare(b.startedAt)
+	})
+
+	var procs []*Process
+	for _, e := range entries {
+		procs = append(procs, e.proc)
+	}
 	return procs
 }
 
+// PortRange returns the range AgentAPI ports are allocated from
+func (r *Registry) PortRange() PortRange {
+	return r.portPool.portRange
+}
+
 // AllocatePort allocates a port from the pool
 func (r *Registry) AllocatePort() (int, error) {
 	return r.portPool.Allocate()
@@ -253,6 +252,18 @@ func (r *Registry) MarkPortInUse(port int) {
 	r.portPool.MarkInUse(port)
 }
 
+// ReservePort holds a port for something found on a host that may be gone,
+// such as a stale AgentAPI; see PortPool.Reserve
+func (r *Registry) ReservePort(port int, hostID, reason string) {
+	r.portPool.Reserve(port, hostID, reason)
+}
+
+// SetPortReclaim sets how port reservations are re-verified when the pool
+// runs out; see PortPool.SetReclaim
+func (r *Registry) SetPortReclaim(probe PortProbe, maxAge time.Duration) {
+	r.portPool.SetReclaim(probe, maxAge)
+}
+
 // ConvertToInfo converts a Process to protocol.ProcessInfo
 func (p *Process) ToInfo() protocol.ProcessInfo {
 	p.mu.Lock()
@@ -264,12 +275,33 @@ func (p *Process) ToInfo() protocol.ProcessInfo {
 		HostID:        p.HostID,
 		Port:          p.Port,
 		CWD:           p.CWD,
+		ClaudeCWD:     p.ClaudeCWD,
 		Name:          p.Name,
 		PtyReady:      p.PtyReady,
 		AgentAPIReady: p.AgentAPIReady,
 		StartedAt:     p.StartedAt.Format(time.RFC3339),
 		ShellPID:      p.ShellPID,
 		AgentAPIPID:   p.AgentAPIPID,
+		WorkspaceID:   p.WorkspaceID,
+		Pinned:        p.Pinned,
+		SortWeight:    p.SortWeight,
+		TermOptions:   pty.EffectiveTermOptions(p.TermOptions),
+		Timeline:      p.Timeline,
+		Exited:        p.Exited,
+		LastError:     p.lastError.toProtocol(),
+		Color:         p.Color,
+		Icon:          p.Icon,
+
+		RetainFullHistory: p.RetainFullHistory,
+		StartupHooks:      p.StartupHooks,
+	}
+	if p.agentStatus != "" {
+		status := p.agentStatus
+		info.AgentStatus = &status
+	}
+	if p.Type == TypeClaude {
+		attached := !p.terminalDetached
+		info.TerminalAttached = &attached
 	}
 	return info
 }
@@ -303,6 +335,23 @@ func (p *Process) SetPtyReady(ready bool) {
 	p.PtyReady = ready
 }
 
+// MarkExited records that the process's tmux session is gone, so neither
+// its PTY nor its AgentAPI server can be ready again
+func (p *Process) MarkExited() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Exited = true
+	p.PtyReady = false
+	p.AgentAPIReady = false
+}
+
+// HasExited reports whether the process's tmux session is gone
+func (p *Process) HasExited() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Exited
+}
+
 // SetShellPID sets the shell process PID
 func (p *Process) SetShellPID(pid int) {
 	p.mu.Lock()
@@ -329,6 +378,84 @@ func (p *Process) SetName(name string) {
 	log.Printf("[DEBUG] [PROCESS] Updated process %s name to %q", p.ID, name)
 }
 
+// SetWorkspaceID assigns the process to a workspace (empty clears it)
+func (p *Process) SetWorkspaceID(workspaceID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if workspaceID == "" {
+		p.WorkspaceID = nil
+	} else {
+		p.WorkspaceID = &workspaceID
+	}
+}
+
+// SetOrder sets the process's position in its host's list
+func (p *Process) SetOrder(pinned bool, sortWeight int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Pinned = pinned
+	p.SortWeight = sortWeight
+}
+
+// SetTermOptions records the terminal options chosen for the process
+func (p *Process) SetTermOptions(options map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.TermOptions = options
+}
+
+// GetTermOptions returns the terminal options chosen for the process
+func (p *Process) GetTermOptions() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.TermOptions
+}
+
+// SetAppearance sets the color and icon the process is shown with
+func (p *Process) SetAppearance(color, icon string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Color = color
+	p.Icon = icon
+}
+
+// Appearance returns the color and icon the process is shown with
+func (p *Process) Appearance() (color, icon string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Color, p.Icon
+}
+
+// SetRetainFullHistory sets whether the process's PTY history is exempt
+// from the per-process cap
+func (p *Process) SetRetainFullHistory(retain bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.RetainFullHistory = retain
+}
+
+// RetainsFullHistory reports whether the process's PTY history is exempt
+// from the per-process cap
+func (p *Process) RetainsFullHistory() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.RetainFullHistory
+}
+
+// SetTimeline records whether the process's command timeline is on
+func (p *Process) SetTimeline(enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Timeline = enabled
+}
+
+// TimelineEnabled reports whether the process's command timeline is on
+func (p *Process) TimelineEnabled() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Timeline
+}
+
 // SetCWD updates the current working directory
 func (p *Process) SetCWD(cwd string) {
 	p.mu.Lock()
@@ -336,6 +463,62 @@ func (p *Process) SetCWD(cwd string) {
 	p.CWD = cwd
 }
 
+// SetClaudeCWD records the working directory Claude was started in. It is
+// not touched by CWD refreshes; pass "" to clear it when Claude exits.
+func (p *Process) SetClaudeCWD(cwd string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ClaudeCWD = cwd
+}
+
+// GetClaudeCWD returns the working directory Claude was started in
+func (p *Process) GetClaudeCWD() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ClaudeCWD
+}
+
+// SetClaudeSessionID records the session ID Claude was started with, which
+// names its transcript
+func (p *Process) SetClaudeSessionID(sessionID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ClaudeSessionID = sessionID
+}
+
+// GetClaudeSessionID returns the session ID Claude was started with, or ""
+// when it isn't known
+func (p *Process) GetClaudeSessionID() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ClaudeSessionID
+}
+
+// GetCWD returns the last known working directory
+func (p *Process) GetCWD() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.CWD
+}
+
+// CWDChange reports a refreshed working directory that clients should be
+// told about: one that differs from the last reported, at most once per
+// debounce. A directory that changes back within the debounce is never
+// reported. known is the directory clients had before the refresh, taken as
+// the baseline until the first report.
+func (p *Process) CWDChange(known string, now time.Time, debounce time.Duration) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.reportedCWDSet {
+		p.reportedCWD, p.reportedCWDSet = known, true
+	}
+	if p.CWD == p.reportedCWD || now.Sub(p.reportedCWDAt) < debounce {
+		return "", false
+	}
+	p.reportedCWD, p.reportedCWDAt = p.CWD, now
+	return p.CWD, true
+}
+
 // RefreshCWD queries and updates the current working directory from the PTY session
 func (p *Process) RefreshCWD() {
 	if p.PTY == nil {
@@ -351,22 +534,40 @@ func (p *Process) RefreshCWD() {
 	p.mu.Unlock()
 }
 
+// RefreshCWDs refreshes the working directory of several processes, batching
+// the tmux queries into one SSH session per host. It returns the pane info
+// read for each process, by process ID.
+func RefreshCWDs(procs []*Process) map[string]pty.PaneInfo {
+	var withPTY []*Process
+	var sessions []*pty.Session
+	for _, p := range procs {
+		if p.PTY != nil {
+			withPTY = append(withPTY, p)
+			sessions = append(sessions, p.PTY)
+		}
+	}
+
+	panes := make(map[string]pty.PaneInfo, len(withPTY))
+	infos, errs := pty.RefreshPaneInfos(sessions)
+	for i, err := range errs {
+		p := withPTY[i]
+		if err != nil {
+			log.Printf("[WARN] [PROCESS] Failed to refresh CWD for process %s: %v", p.ID, err)
+			continue
+		}
+		p.SetCWD(infos[i].CWD)
+		panes[p.ID] = infos[i]
+	}
+	return panes
+}
+
 // Close closes the process and its resources (kills tmux session)
 func (p *Process) Close() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	// Close SSE client first
-	if p.SSEClient != nil {
-		p.SSEClient.Close()
-		p.SSEClient = nil
-	}
-
-	// Close AgentAPI client
-	if p.AgentClient != nil {
-		p.AgentClient.Close()
-		p.AgentClient = nil
-	}
+	// Close AgentAPI clients first
+	p.closeAgentClients()
 
 	// Close PTY (kills tmux session)
 	if p.PTY != nil {
@@ -381,17 +582,8 @@ func (p *Process) Detach() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	// Close SSE client
-	if p.SSEClient != nil {
-		p.SSEClient.Close()
-		p.SSEClient = nil
-	}
-
-	// Close AgentAPI client
-	if p.AgentClient != nil {
-		p.AgentClient.Close()
-		p.AgentClient = nil
-	}
+	// Close AgentAPI clients
+	p.closeAgentClients()
 
 	// Detach from PTY (tmux session keeps running)
 	if p.PTY != nil {
@@ -400,18 +592,49 @@ func (p *Process) Detach() error {
 	return nil
 }
 
-// SetAgentClients sets the AgentAPI clients for this process
+// SetAgentClients sets the AgentAPI clients for this process, closing any
+// it replaces. On a process already shut down they are closed instead, so
+// an SSE stream started by a restore racing Unregister doesn't outlive it.
 func (p *Process) SetAgentClients(client *agentapi.Client, sse *agentapi.SSEClient) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.AgentClient = client
-	p.SSEClient = sse
+	if p.SSEClient != sse {
+		if p.SSEClient != nil {
+			p.SSEClient.Close()
+		}
+		p.SSEClient = sse
+	}
+	if p.AgentClient != client {
+		if p.AgentClient != nil {
+			p.AgentClient.Close()
+		}
+		p.AgentClient = client
+	}
+	if p.shutdown {
+		p.closeAgentClients()
+	}
 }
 
 // ClearAgentClients closes and removes AgentAPI clients
 func (p *Process) ClearAgentClients() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.closeAgentClients()
+}
+
+// Shutdown closes the process's AgentAPI clients, stopping its SSE stream,
+// for good: clients set afterwards are closed as well. The tmux session and
+// the PTY are left alone. Calling it again does nothing.
+func (p *Process) Shutdown() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.shutdown = true
+	p.closeAgentClients()
+}
+
+// closeAgentClients closes and removes the AgentAPI clients. Must be called
+// with p.mu held.
+func (p *Process) closeAgentClients() {
 	if p.SSEClient != nil {
 		p.SSEClient.Close()
 		p.SSEClient = nil
@@ -443,78 +666,18 @@ func (r *Registry) Close() {
 }
 
 // DetachAll detaches from all processes without killing them
-// Tmux sessions continue running on remote hosts and can be reattached later
-func (r *Registry) DetachAll() {
+// Tmux sessions continue running on remote hosts and can be reattached later.
+// detached, if set, is called with each process once it is detached.
+func (r *Registry) DetachAll(detached func(proc *Process)) {
 	log.Printf("[INFO] [REGISTRY] Detaching from all processes (sessions will persist)")
 	r.processes.Range(func(key, value interface{}) bool {
 		proc := value.(*Process)
 		if err := proc.Detach(); err != nil {
 			log.Printf("[WARN] [REGISTRY] Error detaching process %s: %v", proc.ID, err)
 		}
+		if detached != nil {
+			detached(proc)
+		}
 		return true
 	})
 }
-
-// SetStaleProcesses sets the stale processes for a host
-func (r *Registry) SetStaleProcesses(hostID string, stale []protocol.StaleProcess) {
-	r.staleProcesses.Store(hostID, stale)
-	log.Printf("[DEBUG] [REGISTRY] Set %d stale processes for host %s", len(stale), hostID)
-}
-
-// GetStaleProcesses returns the stale processes for a host
-func (r *Registry) GetStaleProcesses(hostID string) []protocol.StaleProcess {
-	if val, ok := r.staleProcesses.Load(hostID); ok {
-		return val.([]protocol.StaleProcess)
-	}
-	return nil
-}
-
-// GetStaleProcess returns a stale process by its process ID, or nil if not found
-func (r *Registry) GetStaleProcess(hostID string, processID string) *protocol.StaleProcess {
-	val, ok := r.staleProcesses.Load(hostID)
-	if !ok {
-		return nil
-	}
-
-	stale := val.([]protocol.StaleProcess)
-	for i := range stale {
-		if stale[i].ProcessID != nil && *stale[i].ProcessID == processID {
-			return &stale[i]
-		}
-	}
-	return nil
-}
-
-// RemoveStaleProcess removes a stale process by its process ID
-// Returns true if a stale process was removed
-func (r *Registry) RemoveStaleProcess(hostID string, processID string) bool {
-	val, ok := r.staleProcesses.Load(hostID)
-	if !ok {
-		return false
-	}
-
-	stale := val.([]protocol.StaleProcess)
-	newStale := make([]protocol.StaleProcess, 0, len(stale))
-	removed := false
-
-	for _, sp := range stale {
-		if sp.ProcessID != nil && *sp.ProcessID == processID {
-			removed = true
-			continue
-		}
-		newStale = append(newStale, sp)
-	}
-
-	if removed {
-		r.staleProcesses.Store(hostID, newStale)
-		log.Printf("[DEBUG] [REGISTRY] Removed stale process %s from host %s (%d remaining)", processID, hostID, len(newStale))
-	}
-
-	return removed
-}
-
-// ClearStaleProcesses clears all stale processes for a host
-func (r *Registry) ClearStaleProcesses(hostID string) {
-	r.staleProcesses.Delete(hostID)
-	log.Printf("[DEBUG] [REGISTRY] Cleared stale processes for host %s", hostID)
-}
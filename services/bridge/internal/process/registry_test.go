@@ -0,0 +1,202 @@
+package process
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/agentapi"
+)
+
+func TestGetByHostOrder(t *testing.T) {
+	r := NewRegistry(DefaultPortRange)
+	base := time.Unix(1000, 0)
+	for _, p := range []*Process{
+		{ID: "late", StartedAt: base.Add(3 * time.Second)},
+		{ID: "heavy", SortWeight: 5, StartedAt: base},
+		{ID: "pinned-heavy", Pinned: true, SortWeight: 4, StartedAt: base},
+		{ID: "early", StartedAt: base.Add(time.Second)},
+		{ID: "pinned", Pinned: true, SortWeight: 1, StartedAt: base.Add(time.Hour)},
+		{ID: "light", SortWeight: 2, StartedAt: base},
+	} {
+		p.HostID = "host-1"
+		r.Register(p)
+	}
+	r.Register(&Process{ID: "elsewhere", HostID: "host-2"})
+
+	var got []string
+	for _, p := range r.GetByHost("host-1") {
+		got = append(got, p.ID)
+	}
+	// Equal weights, from before ordering was stored, fall back to age
+	want := []string{"pinned", "pinned-heavy", "early", "late", "light", "heavy"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetByHost = %v, want %v", got, want)
+	}
+}
+
+func TestCWDChange(t *testing.T) {
+	p := &Process{ID: "proc-1", CWD: "/home"}
+	base := time.Unix(1000, 0)
+	const debounce = 10 * time.Second
+
+	// Each step is a refresh that found cwd, at base+at
+	for i, step := range []struct {
+		cwd    string
+		at     time.Duration
+		report string // "" when nothing is reported
+	}{
+		{"/home", 0, ""},                // Same as the baseline clients had
+		{"/work", time.Second, "/work"}, // First change, reported at once
+		{"/work", 2 * time.Second, ""},
+		{"/tmp", 5 * time.Second, ""},  // Within the debounce: held
+		{"/work", 8 * time.Second, ""}, // Back before it ended: never reported
+		{"/tmp", 9 * time.Second, ""},
+		{"/tmp", 11 * time.Second, "/tmp"}, // Held change, once the debounce ended
+		{"/srv", 30 * time.Second, "/srv"},
+	} {
+		p.SetCWD(step.cwd)
+		cwd, changed := p.CWDChange("/home", base.Add(step.at), debounce)
+		if changed != (step.report != "") || cwd != step.report {
+			t.Errorf("step %d (%s at %v): reported %q, %v; want %q", i, step.cwd, step.at, cwd, changed, step.report)
+		}
+	}
+}
+
+func TestSetAgentStatus(t *testing.T) {
+	p := &Process{ID: "proc-1"}
+	if !p.SetAgentStatus("running", "claude") {
+		t.Error("first status wasn't a change")
+	}
+	// status_change events may leave the type out
+	if !p.SetAgentStatus("stable", "") {
+		t.Error("running to stable wasn't a change")
+	}
+	if status, agentType := p.AgentStatus(); status != "stable" || agentType != "claude" {
+		t.Errorf("status = %q (%q), want stable (claude)", status, agentType)
+	}
+	if p.SetAgentStatus("stable", "claude") {
+		t.Error("the same status was a change")
+	}
+	if !p.SetAgentStatus("", "") {
+		t.Error("clearing the status wasn't a change")
+	}
+	if status, agentType := p.AgentStatus(); status != "" || agentType != "" || p.ToInfo().AgentStatus != nil {
+		t.Errorf("status = %q (%q) after clearing", status, agentType)
+	}
+}
+
+func TestRevertToShell(t *testing.T) {
+	p := &Process{ID: "proc-1", Type: TypeClaude, ClaudeCWD: "/work"}
+	p.SetPort(3284)
+	p.SetAgentAPIPID(42)
+	p.SetAgentAPIReady(true)
+	p.SetAgentStatus("running", "claude")
+
+	if port, ok := p.RevertToShell(); !ok || port != 3284 {
+		t.Errorf("RevertToShell = %d, %v; want the port to release", port, ok)
+	}
+	info := p.ToInfo()
+	if info.Type != "shell" || info.Port != nil || info.AgentAPIPID != nil || info.AgentAPIReady || info.ClaudeCWD != "" || info.AgentStatus != nil {
+		t.Errorf("after reverting: %+v", info)
+	}
+	if _, ok := p.RevertToShell(); ok {
+		t.Error("a port was released twice")
+	}
+}
+
+func TestTerminalAttachChange(t *testing.T) {
+	p := &Process{ID: "proc-1", Type: TypeClaude}
+	base := time.Unix(1000, 0)
+	const grace = 15 * time.Second
+	p.MarkTerminalAttaching(base)
+
+	// Each step is a refresh that found command in the foreground, at base+at
+	for i, step := range []struct {
+		command  string
+		at       time.Duration
+		attached bool
+		changed  bool
+	}{
+		{"bash", time.Second, true, false},         // The attach is still starting
+		{"agentapi", 2 * time.Second, true, false}, // Started
+		{"bash", 5 * time.Second, false, true},     // Exited, even within the grace
+		{"bash", 30 * time.Second, false, false},
+		{"agentapi", 40 * time.Second, true, true}, // Typed again by the user
+		{"vim", 60 * time.Second, false, true},
+	} {
+		attached, changed := p.TerminalAttachChange(step.command, base.Add(step.at), grace)
+		if attached != step.attached || changed != step.changed {
+			t.Errorf("step %d (%s at %v): attached %v, changed %v; want %v, %v", i, step.command, step.at, attached, changed, step.attached, step.changed)
+		}
+	}
+	if info := p.ToInfo(); info.TerminalAttached == nil || *info.TerminalAttached {
+		t.Errorf("info = %+v, want the terminal detached", info)
+	}
+
+	// Typing the attach again starts a new grace
+	p.MarkTerminalAttaching(base.Add(70 * time.Second))
+	if attached, changed := p.TerminalAttachChange("bash", base.Add(75*time.Second), grace); !attached || changed {
+		t.Errorf("within the new grace: attached %v, changed %v", attached, changed)
+	}
+	if attached, changed := p.TerminalAttachChange("bash", base.Add(90*time.Second), grace); attached || !changed {
+		t.Errorf("after the new grace: attached %v, changed %v", attached, changed)
+	}
+
+	// Reverting to a shell forgets it; shells have no terminal attachment
+	p.RevertToShell()
+	if _, changed := p.TerminalAttachChange("bash", base.Add(100*time.Second), grace); changed || p.ToInfo().TerminalAttached != nil {
+		t.Errorf("shell: changed %v, info %+v", changed, p.ToInfo())
+	}
+}
+
+// deadTunnel is a dialer for an SSH connection that is gone
+type deadTunnel struct{}
+
+func (deadTunnel) Dial(network, addr string) (net.Conn, error) {
+	return nil, errors.New("ssh: tunnel closed")
+}
+
+// waitForConnectionLoops waits for the number of running SSE connection
+// loops to settle at want
+func waitForConnectionLoops(t *testing.T, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for agentapi.ConnectionLoops() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d SSE connection loops running, want %d", agentapi.ConnectionLoops(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUnregisterShutsDownAgentClients(t *testing.T) {
+	before := agentapi.ConnectionLoops()
+	r := NewRegistry(DefaultPortRange)
+	p := &Process{ID: "proc-1", HostID: "host-1", Type: TypeClaude}
+	r.Register(p)
+	sse := agentapi.NewSSEClient(deadTunnel{}, 3284, nil)
+	p.SetAgentClients(agentapi.NewClient(deadTunnel{}, 3284), sse)
+	sse.Connect()
+	waitForConnectionLoops(t, before+1)
+
+	// The stream retries against the dead tunnel until the process goes
+	r.Unregister(p.ID)
+	waitForConnectionLoops(t, before)
+	if p.AgentClient != nil || p.SSEClient != nil {
+		t.Errorf("clients kept after Unregister: %v, %v", p.AgentClient, p.SSEClient)
+	}
+	p.Shutdown()
+	r.Unregister(p.ID)
+
+	// A restore that raced the removal can't start the stream again
+	late := agentapi.NewSSEClient(deadTunnel{}, 3284, nil)
+	p.SetAgentClients(agentapi.NewClient(deadTunnel{}, 3284), late)
+	late.Connect()
+	waitForConnectionLoops(t, before)
+	if p.SSEClient != nil {
+		t.Error("SSE client set after Shutdown was kept")
+	}
+}
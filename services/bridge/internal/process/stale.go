@@ -0,0 +1,163 @@
+package process
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// staleKey identifies a stale process within its host. Detached tmux sessions
+// are keyed by process ID and orphaned AgentAPI servers by port, so the two
+// kinds never replace each other.
+func staleKey(sp protocol.StaleProcess) string {
+	if sp.ProcessID != nil {
+		return "process:" + *sp.ProcessID
+	}
+	return "port:" + strconv.Itoa(sp.Port)
+}
+
+// StaleKind is the kind of a stale process, each found by its own scan
+type StaleKind int
+
+const (
+	StaleDetached StaleKind = iota // Detached tmux sessions, by process ID
+	StaleAgentAPI                  // Orphaned AgentAPI servers, by port
+)
+
+// staleKind returns the kind of a stale process
+func staleKind(sp protocol.StaleProcess) StaleKind {
+	if sp.ProcessID != nil {
+		return StaleDetached
+	}
+	return StaleAgentAPI
+}
+
+// staleStore holds the stale processes of every host. Producers merge into it,
+// or replace the entries of their kind after a full scan, and both skip
+// registered processes, so an entry removed by a reattach isn't brought back
+// by a scan that started before it.
+type staleStore struct {
+	hosts map[string]map[string]protocol.StaleProcess // hostID -> staleKey -> entry
+	mu    sync.Mutex
+}
+
+// MergeStaleProcesses adds stale processes for a host, replacing entries with
+// the same key. Entries for processes that are registered again are skipped.
+func (r *Registry) MergeStaleProcesses(hostID string, stale []protocol.StaleProcess) {
+	r.stale.mu.Lock()
+	defer r.stale.mu.Unlock()
+
+	added := r.mergeStaleLocked(hostID, stale)
+	log.Printf("[DEBUG] [REGISTRY] Merged %d stale processes for host %s (%d total)", added, hostID, len(r.stale.hosts[hostID]))
+}
+
+// ReplaceStaleProcesses replaces a host's stale processes of one kind with
+// the ones a full scan for that kind found, so entries that are gone from
+// the host are dropped. Entries of the other kind are kept, and processes
+// that are registered again are skipped, as for MergeStaleProcesses.
+func (r *Registry) ReplaceStaleProcesses(hostID string, kind StaleKind, stale []protocol.StaleProcess) {
+	r.stale.mu.Lock()
+	defer r.stale.mu.Unlock()
+
+	removed := 0
+	for key, sp := range r.stale.hosts[hostID] {
+		if staleKind(sp) == kind {
+			delete(r.stale.hosts[hostID], key)
+			removed++
+		}
+	}
+	added := r.mergeStaleLocked(hostID, stale)
+	log.Printf("[DEBUG] [REGISTRY] Replaced %d stale processes of host %s with %d (%d total)", removed, hostID, added, len(r.stale.hosts[hostID]))
+}
+
+// mergeStaleLocked adds stale processes for a host and returns how many were
+// added. The caller holds r.stale.mu.
+func (r *Registry) mergeStaleLocked(hostID string, stale []protocol.StaleProcess) int {
+	entries := r.stale.hosts[hostID]
+	added := 0
+	for _, sp := range stale {
+		// Reattach registers a process before removing its stale entry, and
+		// removal waits for this lock, so a registered process is never stale
+		if sp.ProcessID != nil && r.Get(*sp.ProcessID) != nil {
+			continue
+		}
+		if entries == nil {
+			entries = make(map[string]protocol.StaleProcess)
+			if r.stale.hosts == nil {
+				r.stale.hosts = make(map[string]map[string]protocol.StaleProcess)
+			}
+			r.stale.hosts[hostID] = entries
+		}
+		entries[staleKey(sp)] = sp
+		added++
+	}
+	return added
+}
+
+// GetStaleProcesses returns a snapshot of the stale processes for a host:
+// detached tmux sessions by process ID, then orphaned AgentAPI servers by port
+func (r *Registry) GetStaleProcesses(hostID string) []protocol.StaleProcess {
+	r.stale.mu.Lock()
+	entries := r.stale.hosts[hostID]
+	if len(entries) == 0 {
+		r.stale.mu.Unlock()
+		return nil
+	}
+	stale := make([]protocol.StaleProcess, 0, len(entries))
+	for _, sp := range entries {
+		stale = append(stale, sp)
+	}
+	r.stale.mu.Unlock()
+
+	sort.Slice(stale, func(i, j int) bool {
+		a, b := stale[i], stale[j]
+		if (a.ProcessID == nil) != (b.ProcessID == nil) {
+			return a.ProcessID != nil
+		}
+		if a.ProcessID != nil {
+			return *a.ProcessID < *b.ProcessID
+		}
+		return a.Port < b.Port
+	})
+	return stale
+}
+
+// GetStaleProcess returns a copy of the stale process with the given process
+// ID, or nil if not found
+func (r *Registry) GetStaleProcess(hostID string, processID string) *protocol.StaleProcess {
+	r.stale.mu.Lock()
+	defer r.stale.mu.Unlock()
+
+	sp, ok := r.stale.hosts[hostID][staleKey(protocol.StaleProcess{ProcessID: &processID})]
+	if !ok {
+		return nil
+	}
+	return &sp
+}
+
+// RemoveStaleProcess removes a stale process by its process ID
+// Returns true if a stale process was removed
+func (r *Registry) RemoveStaleProcess(hostID string, processID string) bool {
+	r.stale.mu.Lock()
+	defer r.stale.mu.Unlock()
+
+	entries := r.stale.hosts[hostID]
+	key := staleKey(protocol.StaleProcess{ProcessID: &processID})
+	if _, ok := entries[key]; !ok {
+		return false
+	}
+	delete(entries, key)
+	log.Printf("[DEBUG] [REGISTRY] Removed stale process %s from host %s (%d remaining)", processID, hostID, len(entries))
+	return true
+}
+
+// ClearStaleProcesses clears all stale processes for a host
+func (r *Registry) ClearStaleProcesses(hostID string) {
+	r.stale.mu.Lock()
+	delete(r.stale.hosts, hostID)
+	r.stale.mu.Unlock()
+	log.Printf("[DEBUG] [REGISTRY] Cleared stale processes for host %s", hostID)
+}
@@ -0,0 +1,124 @@
+package process
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+func detached(processID string, port int) protocol.StaleProcess {
+	tmuxName := "rc-" + processID
+	return protocol.StaleProcess{Reason: "detached", TmuxSession: &tmuxName, ProcessID: &processID, Port: port}
+}
+
+func staleIDs(stale []protocol.StaleProcess) []string {
+	ids := make([]string, len(stale))
+	for i, sp := range stale {
+		ids[i] = staleKey(sp)
+	}
+	return ids
+}
+
+func TestMergeStaleProcesses(t *testing.T) {
+	r := NewRegistry(DefaultPortRange)
+	r.MergeStaleProcesses("host-1", []protocol.StaleProcess{detached("proc-b", 3290), {Port: 3291, Reason: "timeout"}})
+	// A detached Claude process and an orphaned AgentAPI on the same port are
+	// kept apart, and merging again updates rather than duplicates
+	r.MergeStaleProcesses("host-1", []protocol.StaleProcess{
+		{Port: 3290, Reason: "refused"}, {Port: 3291, Reason: "refused"}, detached("proc-a", 0),
+	})
+	r.MergeStaleProcesses("host-2", []protocol.StaleProcess{detached("proc-c", 0)})
+
+	got := r.GetStaleProcesses("host-1")
+	want := []string{"process:proc-a", "process:proc-b", "port:3290", "port:3291"}
+	if !reflect.DeepEqual(staleIDs(got), want) {
+		t.Fatalf("stale = %v, want %v", staleIDs(got), want)
+	}
+	if got[3].Reason != "refused" {
+		t.Errorf("port 3291 reason = %q, want the later merge", got[3].Reason)
+	}
+
+	if sp := r.GetStaleProcess("host-1", "proc-b"); sp == nil || sp.Port != 3290 {
+		t.Errorf("GetStaleProcess(proc-b) = %+v, want port 3290", sp)
+	}
+	if r.GetStaleProcess("host-1", "proc-c") != nil {
+		t.Error("GetStaleProcess found another host's process")
+	}
+	if !r.RemoveStaleProcess("host-1", "proc-b") || r.RemoveStaleProcess("host-1", "proc-b") {
+		t.Error("RemoveStaleProcess should remove proc-b exactly once")
+	}
+
+	r.ClearStaleProcesses("host-1")
+	if got := r.GetStaleProcesses("host-1"); got != nil {
+		t.Errorf("stale after clear = %v", got)
+	}
+	if got := r.GetStaleProcesses("host-2"); len(got) != 1 {
+		t.Errorf("host-2 stale = %v, want untouched", got)
+	}
+}
+
+func TestMergeSkipsRegisteredProcesses(t *testing.T) {
+	r := NewRegistry(DefaultPortRange)
+	r.Register(&Process{ID: "proc-a", HostID: "host-1"})
+	r.MergeStaleProcesses("host-1", []protocol.StaleProcess{detached("proc-a", 0), detached("proc-b", 0)})
+	if got := staleIDs(r.GetStaleProcesses("host-1")); !reflect.DeepEqual(got, []string{"process:proc-b"}) {
+		t.Errorf("stale = %v, want only the unregistered process", got)
+	}
+}
+
+func TestReplaceStaleProcesses(t *testing.T) {
+	r := NewRegistry(DefaultPortRange)
+	r.MergeStaleProcesses("host-1", []protocol.StaleProcess{
+		detached("proc-a", 0), detached("proc-b", 0), {Port: 3290, Reason: "refused"},
+	})
+	r.Register(&Process{ID: "proc-c", HostID: "host-1"})
+
+	// The next tmux scan no longer finds proc-a; the AgentAPI entries stay
+	r.ReplaceStaleProcesses("host-1", StaleDetached, []protocol.StaleProcess{detached("proc-b", 0), detached("proc-c", 0)})
+	if got := staleIDs(r.GetStaleProcesses("host-1")); !reflect.DeepEqual(got, []string{"process:proc-b", "port:3290"}) {
+		t.Errorf("stale after tmux scan = %v", got)
+	}
+
+	// The next port scan finds nothing; the detached sessions stay
+	r.ReplaceStaleProcesses("host-1", StaleAgentAPI, nil)
+	if got := staleIDs(r.GetStaleProcesses("host-1")); !reflect.DeepEqual(got, []string{"process:proc-b"}) {
+		t.Errorf("stale after port scan = %v", got)
+	}
+}
+
+// TestReattachNotUndoneByAuth races a reconnecting client's host scan, which
+// reports a process as detached, against the user reattaching it. However
+// they interleave, a reattached process must not be stale afterwards.
+func TestReattachNotUndoneByAuth(t *testing.T) {
+	const rounds = 200
+	r := NewRegistry(DefaultPortRange)
+	for i := 0; i < rounds; i++ {
+		id := fmt.Sprintf("proc-%d", i)
+		var wg sync.WaitGroup
+		wg.Add(3)
+		// Two clients authenticate in parallel and both see the process detached
+		for j := 0; j < 2; j++ {
+			go func() {
+				defer wg.Done()
+				r.MergeStaleProcesses("host-1", []protocol.StaleProcess{detached(id, 0)})
+			}()
+		}
+		// The user reattaches it, as handleProcessReattach does
+		go func() {
+			defer wg.Done()
+			r.Register(&Process{ID: id, HostID: "host-1"})
+			r.RemoveStaleProcess("host-1", id)
+		}()
+		wg.Wait()
+
+		if r.GetStaleProcess("host-1", id) != nil {
+			t.Fatalf("round %d: reattached process %s is stale again", i, id)
+		}
+	}
+	if got := r.GetStaleProcesses("host-1"); got != nil {
+		t.Errorf("stale = %v, want none", staleIDs(got))
+	}
+}
@@ -0,0 +1,114 @@
+package process
+
+import (
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/agentapi"
+)
+
+// The fields below change while a process runs: Claude is started and
+// killed on it, its AgentAPI server is reattached and its environment is
+// captured, all while host_status and process_list read it through ToInfo.
+// Outside this package they are read and written only through these
+// methods; ID, HostID, StartedAt and PTY are set before Register and never
+// change.
+
+// GetType returns whether the process is a plain shell or runs Claude
+func (p *Process) GetType() ProcessType {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Type
+}
+
+// GetPort returns the AgentAPI port, and false if none is allocated
+func (p *Process) GetPort() (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Port == nil {
+		return 0, false
+	}
+	return *p.Port, true
+}
+
+// GetShellPID returns the shell's PID, and false if it isn't known
+func (p *Process) GetShellPID() (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ShellPID == nil {
+		return 0, false
+	}
+	return *p.ShellPID, true
+}
+
+// GetAgentAPIPID returns the AgentAPI server's PID, and false if it isn't
+// known
+func (p *Process) GetAgentAPIPID() (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.AgentAPIPID == nil {
+		return 0, false
+	}
+	return *p.AgentAPIPID, true
+}
+
+// IsPtyReady reports whether the PTY is up
+func (p *Process) IsPtyReady() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.PtyReady
+}
+
+// GetAgentClient returns the AgentAPI client, or nil if Claude isn't
+// attached
+func (p *Process) GetAgentClient() *agentapi.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.AgentClient
+}
+
+// SetEventHandler points the process's open AgentAPI event stream at a new
+// handler, and reports false if no stream is open
+func (p *Process) SetEventHandler(handler agentapi.EventHandler) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.SSEClient == nil {
+		return false
+	}
+	p.SSEClient.SetHandler(handler)
+	return true
+}
+
+// SetEnvVars sets the environment captured from the process's shell
+func (p *Process) SetEnvVars(envVars []EnvVar) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.EnvVars = envVars
+}
+
+// GetEnvVars returns the environment captured from the process's shell.
+// The slice is replaced, never changed, so callers may read it unlocked.
+func (p *Process) GetEnvVars() []EnvVar {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.EnvVars
+}
+
+// RevertToShell drops everything Claude had on the process, once its
+// AgentAPI server is killed, and returns the port that was allocated to it
+// so the caller can release it
+func (p *Process) RevertToShell() (port int, hadPort bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Port != nil {
+		port, hadPort = *p.Port, true
+	}
+	p.Type = TypeShell
+	p.AgentAPIReady = false
+	p.Port = nil
+	p.AgentAPIPID = nil
+	p.ClaudeCWD = ""
+	p.ClaudeSessionID = ""
+	p.agentStatus, p.agentType = "", ""
+	p.terminalDetached, p.attachTypedAt = false, time.Time{}
+	return port, hadPort
+}
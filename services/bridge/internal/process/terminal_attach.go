@@ -0,0 +1,49 @@
+package process
+
+import "time"
+
+// AttachCommand is the program in the foreground of a Claude process's pane
+// while its terminal shows Claude: the agentapi attach typed by claude_start
+const AttachCommand = "agentapi"
+
+// MarkTerminalAttaching records that agentapi attach was just typed into a
+// Claude process's pane, or found running there, and takes the terminal for
+// attached
+func (p *Process) MarkTerminalAttaching(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attachTypedAt = now
+	p.terminalDetached = false
+}
+
+// TerminalAttachChange takes the program a refresh found in the foreground
+// of a Claude process's pane, and reports whether its terminal is attached
+// and whether that changed. The terminal is attached while the pane runs
+// AttachCommand; a pane showing something else within grace of
+// MarkTerminalAttaching, before the attach was first seen running, is taken
+// for still starting it. Shell processes never change.
+func (p *Process) TerminalAttachChange(command string, now time.Time, grace time.Duration) (attached, changed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Type != TypeClaude {
+		return false, false
+	}
+	if command != AttachCommand && now.Sub(p.attachTypedAt) < grace {
+		return !p.terminalDetached, false
+	}
+	detached := command != AttachCommand
+	if !detached {
+		p.attachTypedAt = time.Time{}
+	}
+	changed = detached != p.terminalDetached
+	p.terminalDetached = detached
+	return !detached, changed
+}
+
+// TerminalAttached reports whether a Claude process's terminal was last
+// seen showing Claude
+func (p *Process) TerminalAttached() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Type == TypeClaude && !p.terminalDetached
+}
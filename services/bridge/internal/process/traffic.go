@@ -0,0 +1,81 @@
+package process
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// TrafficKind is a kind of traffic a process moves through the bridge
+type TrafficKind int
+
+const (
+	TrafficPtyOutput  TrafficKind = iota // pty_output and pty_snapshot sent to clients
+	TrafficPtyInput                      // pty_input received from clients
+	TrafficChatEvents                    // chat_event data forwarded to clients
+	TrafficHistory                       // PTY history served to clients
+
+	trafficKinds
+)
+
+// Traffic counts bytes per kind of traffic. Counters only go up, and reset
+// when the bridge restarts.
+type Traffic struct {
+	bytes [trafficKinds]atomic.Uint64
+}
+
+func (t *Traffic) add(kind TrafficKind, n int) {
+	t.bytes[kind].Add(uint64(n))
+}
+
+// Stats returns a point-in-time copy of the counters
+func (t *Traffic) Stats() protocol.ProcessStats {
+	return protocol.ProcessStats{
+		PtyOutputBytes: t.bytes[TrafficPtyOutput].Load(),
+		PtyInputBytes:  t.bytes[TrafficPtyInput].Load(),
+		ChatEventBytes: t.bytes[TrafficChatEvents].Load(),
+		HistoryBytes:   t.bytes[TrafficHistory].Load(),
+	}
+}
+
+// hostTraffic totals the traffic of each host's processes, including the
+// ones since killed
+type hostTraffic struct {
+	since time.Time
+	hosts sync.Map // map[hostID]*Traffic
+}
+
+func (h *hostTraffic) host(hostID string) *Traffic {
+	t, _ := h.hosts.LoadOrStore(hostID, &Traffic{})
+	return t.(*Traffic)
+}
+
+// CountTraffic adds n bytes of a kind of traffic to the process and its
+// host's total
+func (p *Process) CountTraffic(kind TrafficKind, n int) {
+	if n <= 0 {
+		return
+	}
+	p.traffic.add(kind, n)
+	if host := p.hostTraffic.Load(); host != nil {
+		host.add(kind, n)
+	}
+}
+
+// TrafficStats returns the traffic counted for the process
+func (p *Process) TrafficStats() protocol.ProcessStats {
+	return p.traffic.Stats()
+}
+
+// TrafficRollup returns the traffic of each host's processes since the
+// registry was created, with the time it was created
+func (r *Registry) TrafficRollup() (map[string]protocol.ProcessStats, time.Time) {
+	hosts := make(map[string]protocol.ProcessStats)
+	r.traffic.hosts.Range(func(hostID, t any) bool {
+		hosts[hostID.(string)] = t.(*Traffic).Stats()
+		return true
+	})
+	return hosts, r.traffic.since
+}
@@ -0,0 +1,60 @@
+package process
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+func TestTrafficCounting(t *testing.T) {
+	r := NewRegistry(DefaultPortRange)
+	a := &Process{ID: "a", HostID: "host-1"}
+	b := &Process{ID: "b", HostID: "host-1"}
+	other := &Process{ID: "other", HostID: "host-2"}
+	for _, p := range []*Process{a, b, other} {
+		r.Register(p)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.CountTraffic(TrafficPtyOutput, 10)
+			a.CountTraffic(TrafficPtyInput, 1)
+			b.CountTraffic(TrafficChatEvents, 7)
+			other.CountTraffic(TrafficHistory, 3)
+		}()
+	}
+	wg.Wait()
+	a.CountTraffic(TrafficPtyOutput, 0)
+
+	if got, want := a.TrafficStats(), (protocol.ProcessStats{PtyOutputBytes: 1000, PtyInputBytes: 100}); got != want {
+		t.Errorf("a = %+v, want %+v", got, want)
+	}
+	if got, want := b.TrafficStats(), (protocol.ProcessStats{ChatEventBytes: 700}); got != want {
+		t.Errorf("b = %+v, want %+v", got, want)
+	}
+
+	// Host totals keep the traffic of processes since unregistered
+	r.Unregister("a")
+	hosts, since := r.TrafficRollup()
+	if since.IsZero() {
+		t.Error("rollup has no start time")
+	}
+	want := map[string]protocol.ProcessStats{
+		"host-1": {PtyOutputBytes: 1000, PtyInputBytes: 100, ChatEventBytes: 700},
+		"host-2": {HistoryBytes: 300},
+	}
+	if len(hosts) != len(want) || hosts["host-1"] != want["host-1"] || hosts["host-2"] != want["host-2"] {
+		t.Errorf("rollup = %+v, want %+v", hosts, want)
+	}
+
+	// A process never registered counts for itself only
+	lone := &Process{ID: "lone", HostID: "host-1"}
+	lone.CountTraffic(TrafficPtyOutput, 5)
+	if hosts, _ := r.TrafficRollup(); hosts["host-1"].PtyOutputBytes != 1000 || lone.TrafficStats().PtyOutputBytes != 5 {
+		t.Errorf("unregistered process: %+v, %+v", lone.TrafficStats(), hosts["host-1"])
+	}
+}
@@ -10,13 +10,35 @@ import (
 func TestMessageTypeAlignment(t *testing.T) {
 	expectedTypes := map[string]string{
 		// Authentication
-		"AUTH":        "auth",
-		"AUTH_RESULT": "auth_result",
+		"AUTH":                         "auth",
+		"AUTH_RESULT":                  "auth_result",
+		"SESSION_REFRESH_TOKEN":        "session_refresh_token",
+		"SESSION_REFRESH_TOKEN_RESULT": "session_refresh_token_result",
+		"SESSION_INVITE_CREATE":        "session_invite_create",
+		"SESSION_INVITE_CREATE_RESULT": "session_invite_create_result",
+		"SESSION_INVITE_REVOKE":        "session_invite_revoke",
+		"SESSION_INVITE_REVOKE_RESULT": "session_invite_revoke_result",
+		"SESSION_INFO":                 "session_info",
+		"SESSION_INFO_RESULT":          "session_info_result",
 
 		// Host Management
 		"HOST_CONNECT":    "host_connect",
 		"HOST_DISCONNECT": "host_disconnect",
 		"HOST_STATUS":     "host_status",
+		"HOST_STATUS_REQUEST": "host_status_request",
+		"HOST_CONNECT_PROGRESS": "host_connect_progress",
+		"HOST_EXEC":             "host_exec",
+		"HOST_EXEC_RESULT":      "host_exec_result",
+		"HOST_DIAGNOSTICS":      "host_diagnostics",
+		"HOST_DIAGNOSTICS_RESULT": "host_diagnostics_result",
+		"FILE_DOWNLOAD":          "file_download",
+		"FILE_DOWNLOAD_CHUNK":    "file_download_chunk",
+		"FILE_DOWNLOAD_COMPLETE": "file_download_complete",
+		"FILE_DOWNLOAD_CANCEL":   "file_download_cancel",
+		"FILE_UPLOAD_BEGIN":      "file_upload_begin",
+		"FILE_UPLOAD_CHUNK":      "file_upload_chunk",
+		"FILE_UPLOAD_COMMIT":     "file_upload_commit",
+		"FILE_UPLOAD_RESULT":     "file_upload_result",
 
 		// Process Management
 		"PROCESS_LIST":        "process_list",
@@ -27,30 +49,90 @@ func TestMessageTypeAlignment(t *testing.T) {
 		"PROCESS_KILL":        "process_kill",
 		"PROCESS_KILLED":      "process_killed",
 		"PROCESS_UPDATED":     "process_updated",
+		"PROCESS_ADDED":       "process_added",
+		"PROCESS_REMOVED":     "process_removed",
+		"STALE_PROCESSES_CHANGED": "stale_processes_changed",
+		"PROCESS_CWD_CHANGED": "process_cwd_changed",
+		"PROCESS_CLONE":       "process_clone",
+		"PROCESS_PIN":         "process_pin",
+		"PROCESS_SET_ORDER":   "process_set_order",
+		"PROCESS_TERM_OPTIONS": "process_term_options",
+		"PROCESS_CLEAR_ERROR":  "process_clear_error",
+		"PROCESS_SET_APPEARANCE": "process_set_appearance",
+		"PROCESS_CREATE_FROM_TEMPLATE":        "process_create_from_template",
+		"PROCESS_CREATE_FROM_TEMPLATE_RESULT": "process_create_from_template_result",
+
+		// Command timeline
+		"PROCESS_ENABLE_TIMELINE":      "process_enable_timeline",
+		"PROCESS_TIMELINE_LIST":        "process_timeline_list",
+		"PROCESS_TIMELINE_LIST_RESULT": "process_timeline_list_result",
+
+		// Archived processes
+		"ARCHIVED_PROCESS_LIST":          "archived_process_list",
+		"ARCHIVED_PROCESS_LIST_RESULT":   "archived_process_list_result",
+		"ARCHIVED_PROCESS_GET":           "archived_process_get",
+		"ARCHIVED_PROCESS_GET_RESULT":    "archived_process_get_result",
+		"ARCHIVED_PROCESS_DELETE":        "archived_process_delete",
+		"ARCHIVED_PROCESS_DELETE_RESULT": "archived_process_delete_result",
+		"PROCESSES_SUBSCRIBE":   "processes_subscribe",
+		"PROCESSES_UNSUBSCRIBE": "processes_unsubscribe",
+		"PROCESS_ALERT":         "process_alert",
 
 		// Claude Conversion
-		"CLAUDE_START": "claude_start",
-		"CLAUDE_KILL":  "claude_kill",
+		"CLAUDE_START":             "claude_start",
+		"CLAUDE_KILL":              "claude_kill",
+		"CLAUDE_REATTACH_TERMINAL": "claude_reattach_terminal",
 
 		// PTY (Terminal)
 		"PTY_INPUT":            "pty_input",
 		"PTY_OUTPUT":           "pty_output",
 		"PTY_RESIZE":           "pty_resize",
+		"PTY_SNAPSHOT":         "pty_snapshot",
+		"PTY_SCROLL":           "pty_scroll",
+		"PTY_SCROLL_STATE":     "pty_scroll_state",
 		"PTY_HISTORY_REQUEST":  "pty_history_request",
 		"PTY_HISTORY_RESPONSE": "pty_history_response",
 		"PTY_HISTORY_CHUNK":    "pty_history_chunk",
 		"PTY_HISTORY_COMPLETE": "pty_history_complete",
 
+		// Snippets
+		"SNIPPET_EXECUTE":        "snippet_execute",
+		"SNIPPET_EXECUTE_RESULT": "snippet_execute_result",
+
 		// Chat (AgentAPI)
 		"CHAT_SUBSCRIBE":     "chat_subscribe",
+		"CHAT_SUBSCRIBE_RESULT": "chat_subscribe_result",
 		"CHAT_UNSUBSCRIBE":   "chat_unsubscribe",
 		"CHAT_SEND":          "chat_send",
+		"CHAT_SEND_RESULT":   "chat_send_result",
 		"CHAT_RAW":           "chat_raw",
 		"CHAT_EVENT":         "chat_event",
 		"CHAT_STATUS":        "chat_status",
 		"CHAT_STATUS_RESULT": "chat_status_result",
 		"CHAT_HISTORY":       "chat_history",
 		"CHAT_MESSAGES":      "chat_messages",
+		"CHAT_SEARCH":        "chat_search",
+		"CHAT_SEARCH_RESULT": "chat_search_result",
+		"CHAT_USAGE":         "chat_usage",
+		"CHAT_USAGE_RESULT":  "chat_usage_result",
+		"CHAT_DRAFT_SET":     "chat_draft_set",
+		"CHAT_DRAFT_GET":     "chat_draft_get",
+		"CHAT_DRAFT_RESULT":  "chat_draft_result",
+
+		// Bridge update check
+		"BRIDGE_UPDATE_CHECK":        "bridge_update_check",
+		"BRIDGE_UPDATE_CHECK_RESULT": "bridge_update_check_result",
+		"BRIDGE_UPDATE_AVAILABLE":    "bridge_update_available",
+
+		// History encryption at rest
+		"STORAGE_ENCRYPT_NOW":      "storage_encrypt_now",
+		"STORAGE_ENCRYPT_PROGRESS": "storage_encrypt_progress",
+		"STORAGE_ENCRYPT_RESULT":   "storage_encrypt_result",
+		"STORAGE_FLUSH":            "storage_flush",
+		"STORAGE_FLUSH_RESULT":     "storage_flush_result",
+
+		// Confirmation of destructive requests
+		"CONFIRMATION_CHALLENGE": "confirmation_challenge",
 
 		// Error
 		"ERROR": "error",
@@ -60,9 +142,31 @@ func TestMessageTypeAlignment(t *testing.T) {
 	goConstants := map[string]string{
 		"AUTH":               TypeAuth,
 		"AUTH_RESULT":        TypeAuthResult,
+		"SESSION_REFRESH_TOKEN":        TypeSessionRefreshToken,
+		"SESSION_REFRESH_TOKEN_RESULT": TypeSessionRefreshTokenResult,
+		"SESSION_INVITE_CREATE":        TypeSessionInviteCreate,
+		"SESSION_INVITE_CREATE_RESULT": TypeSessionInviteCreateResult,
+		"SESSION_INVITE_REVOKE":        TypeSessionInviteRevoke,
+		"SESSION_INVITE_REVOKE_RESULT": TypeSessionInviteRevokeResult,
+		"SESSION_INFO":                 TypeSessionInfo,
+		"SESSION_INFO_RESULT":          TypeSessionInfoResult,
 		"HOST_CONNECT":       TypeHostConnect,
 		"HOST_DISCONNECT":    TypeHostDisconnect,
 		"HOST_STATUS":        TypeHostStatus,
+		"HOST_STATUS_REQUEST": TypeHostStatusRequest,
+		"HOST_CONNECT_PROGRESS": TypeHostConnectProgress,
+		"HOST_EXEC":             TypeHostExec,
+		"HOST_EXEC_RESULT":      TypeHostExecResult,
+		"HOST_DIAGNOSTICS":      TypeHostDiagnostics,
+		"HOST_DIAGNOSTICS_RESULT": TypeHostDiagnosticsResult,
+		"FILE_DOWNLOAD":          TypeFileDownload,
+		"FILE_DOWNLOAD_CHUNK":    TypeFileDownloadChunk,
+		"FILE_DOWNLOAD_COMPLETE": TypeFileDownloadComplete,
+		"FILE_DOWNLOAD_CANCEL":   TypeFileDownloadCancel,
+		"FILE_UPLOAD_BEGIN":      TypeFileUploadBegin,
+		"FILE_UPLOAD_CHUNK":      TypeFileUploadChunk,
+		"FILE_UPLOAD_COMMIT":     TypeFileUploadCommit,
+		"FILE_UPLOAD_RESULT":     TypeFileUploadResult,
 		"PROCESS_LIST":        TypeProcessList,
 		"PROCESS_LIST_RESULT": TypeProcessListResult,
 		"PROCESS_CREATE":      TypeProcessCreate,
@@ -71,24 +175,74 @@ func TestMessageTypeAlignment(t *testing.T) {
 		"PROCESS_KILL":        TypeProcessKill,
 		"PROCESS_KILLED":      TypeProcessKilled,
 		"PROCESS_UPDATED":     TypeProcessUpdated,
+		"PROCESS_ADDED":       TypeProcessAdded,
+		"PROCESS_REMOVED":     TypeProcessRemoved,
+		"STALE_PROCESSES_CHANGED": TypeStaleProcessesChanged,
+		"PROCESS_CWD_CHANGED": TypeProcessCWDChanged,
+		"PROCESS_CLONE":       TypeProcessClone,
+		"PROCESS_PIN":         TypeProcessPin,
+		"PROCESS_SET_ORDER":   TypeProcessSetOrder,
+		"PROCESS_TERM_OPTIONS": TypeProcessTermOptions,
+		"PROCESS_CLEAR_ERROR":  TypeProcessClearError,
+		"PROCESS_SET_APPEARANCE": TypeProcessSetAppearance,
+		"PROCESS_CREATE_FROM_TEMPLATE":        TypeProcessCreateFromTemplate,
+		"PROCESS_CREATE_FROM_TEMPLATE_RESULT": TypeProcessCreateFromTemplateResult,
+
+		// Command timeline
+		"PROCESS_ENABLE_TIMELINE":      TypeProcessEnableTimeline,
+		"PROCESS_TIMELINE_LIST":        TypeProcessTimelineList,
+		"PROCESS_TIMELINE_LIST_RESULT": TypeProcessTimelineListResult,
+		"ARCHIVED_PROCESS_LIST":          TypeArchivedProcessList,
+		"ARCHIVED_PROCESS_LIST_RESULT":   TypeArchivedProcessListResult,
+		"ARCHIVED_PROCESS_GET":           TypeArchivedProcessGet,
+		"ARCHIVED_PROCESS_GET_RESULT":    TypeArchivedProcessGetResult,
+		"ARCHIVED_PROCESS_DELETE":        TypeArchivedProcessDelete,
+		"ARCHIVED_PROCESS_DELETE_RESULT": TypeArchivedProcessDeleteResult,
+		"PROCESSES_SUBSCRIBE":   TypeProcessesSubscribe,
+		"PROCESSES_UNSUBSCRIBE": TypeProcessesUnsubscribe,
+		"PROCESS_ALERT":         TypeProcessAlert,
 		"CLAUDE_START":       TypeClaudeStart,
 		"CLAUDE_KILL":        TypeClaudeKill,
+		"CLAUDE_REATTACH_TERMINAL": TypeClaudeReattachTerminal,
 		"PTY_INPUT":            TypePtyInput,
 		"PTY_OUTPUT":           TypePtyOutput,
 		"PTY_RESIZE":           TypePtyResize,
+		"PTY_SNAPSHOT":         TypePtySnapshot,
+		"PTY_SCROLL":           TypePtyScroll,
+		"PTY_SCROLL_STATE":     TypePtyScrollState,
 		"PTY_HISTORY_REQUEST":  TypePtyHistoryRequest,
 		"PTY_HISTORY_RESPONSE": TypePtyHistoryResponse,
 		"PTY_HISTORY_CHUNK":    TypePtyHistoryChunk,
 		"PTY_HISTORY_COMPLETE": TypePtyHistoryComplete,
+		"SNIPPET_EXECUTE":        TypeSnippetExecute,
+		"SNIPPET_EXECUTE_RESULT": TypeSnippetExecuteResult,
 		"CHAT_SUBSCRIBE":     TypeChatSubscribe,
+		"CHAT_SUBSCRIBE_RESULT": TypeChatSubscribeResult,
 		"CHAT_UNSUBSCRIBE":   TypeChatUnsubscribe,
 		"CHAT_SEND":          TypeChatSend,
+		"CHAT_SEND_RESULT":   TypeChatSendResult,
 		"CHAT_RAW":           TypeChatRaw,
 		"CHAT_EVENT":         TypeChatEvent,
 		"CHAT_STATUS":        TypeChatStatus,
 		"CHAT_STATUS_RESULT": TypeChatStatusResult,
 		"CHAT_HISTORY":       TypeChatHistory,
 		"CHAT_MESSAGES":      TypeChatMessages,
+		"CHAT_SEARCH":        TypeChatSearch,
+		"CHAT_SEARCH_RESULT": TypeChatSearchResult,
+		"CHAT_USAGE":         TypeChatUsage,
+		"CHAT_USAGE_RESULT":  TypeChatUsageResult,
+		"CHAT_DRAFT_SET":     TypeChatDraftSet,
+		"CHAT_DRAFT_GET":     TypeChatDraftGet,
+		"CHAT_DRAFT_RESULT":  TypeChatDraftResult,
+		"BRIDGE_UPDATE_CHECK":        TypeBridgeUpdateCheck,
+		"BRIDGE_UPDATE_CHECK_RESULT": TypeBridgeUpdateCheckResult,
+		"BRIDGE_UPDATE_AVAILABLE":    TypeBridgeUpdateAvailable,
+		"STORAGE_ENCRYPT_NOW":      TypeStorageEncryptNow,
+		"STORAGE_ENCRYPT_PROGRESS": TypeStorageEncryptProgress,
+		"STORAGE_ENCRYPT_RESULT":   TypeStorageEncryptResult,
+		"STORAGE_FLUSH":            TypeStorageFlush,
+		"STORAGE_FLUSH_RESULT":     TypeStorageFlushResult,
+		"CONFIRMATION_CHALLENGE": TypeConfirmationChallenge,
 		"ERROR":              TypeError,
 	}
 
@@ -113,6 +267,19 @@ func TestMessageTypeAlignment(t *testing.T) {
 func TestPayloadJSONFieldAlignment(t *testing.T) {
 	token := "test-token"
 	sessionID := "session-123"
+	chatStatus := "stable"
+	latestMessageID := 3
+	count := 2
+	retain := true
+	processName := "auth fixes"
+	claudeType := ProcessTypeClaude
+	uptime := int64(3600)
+	diffMode := ProcessEnvModeDiff
+	retryAfter := int64(1000)
+	expiresIn := 3600
+	costUSD := 0.25
+	timestamp := int64(1700000000000)
+	keepHistory := false
 
 	tests := []struct {
 		name           string
@@ -122,19 +289,127 @@ func TestPayloadJSONFieldAlignment(t *testing.T) {
 		{
 			name: "AuthPayload",
 			payload: AuthPayload{
-				ReconnectToken: &token,
+				ProtocolVersion: &count,
+				ReconnectToken:  &token,
+				Compression:     true,
+				ClientTimestamp: &timestamp,
+				Locale:          &token,
+				Capabilities:    []string{CapabilityServerTimestamps},
 			},
-			expectedFields: []string{"reconnectToken"},
+			expectedFields: []string{"protocolVersion", "reconnectToken", "compression", "clientTimestamp", "locale", "capabilities"},
 		},
 		{
 			name: "AuthResultPayload",
 			payload: AuthResultPayload{
+				Success:         true,
+				SessionID:       &sessionID,
+				ReconnectToken:  &token,
+				TokenExpiresAt:  &token,
+				Reconnected:     false,
+				ServerVersion:   "dev",
+				Profile:         "default",
+				ServerTimestamp: timestamp,
+				ClockSkewMs:     &timestamp,
+				Locale:          "en",
+				Role:            "observer",
+				Scope:           &SessionScope{HostID: &token},
+				Capabilities:    []string{CapabilityServerTimestamps},
+			},
+			expectedFields: []string{"success", "sessionId", "reconnectToken", "tokenExpiresAt", "reconnected", "serverVersion", "protocolVersion", "profile", "serverTimestamp", "clockSkewMs", "locale", "role", "scope", "capabilities"},
+		},
+		{
+			name:           "SessionRefreshTokenPayload",
+			payload:        SessionRefreshTokenPayload{ReconnectToken: token},
+			expectedFields: []string{"reconnectToken"},
+		},
+		{
+			name: "SessionRefreshTokenResultPayload",
+			payload: SessionRefreshTokenResultPayload{
 				Success:        true,
-				SessionID:      &sessionID,
 				ReconnectToken: &token,
-				Reconnected:    false,
+				TokenExpiresAt: &token,
+			},
+			expectedFields: []string{"success", "reconnectToken", "tokenExpiresAt"},
+		},
+		{
+			name: "SessionInviteCreatePayload",
+			payload: SessionInviteCreatePayload{
+				Role:             &token,
+				HostID:           &token,
+				ProcessID:        &token,
+				ExpiresInSeconds: &expiresIn,
+				Label:            &token,
+			},
+			expectedFields: []string{"role", "hostId", "processId", "expiresInSeconds", "label"},
+		},
+		{
+			name: "SessionInvite",
+			payload: SessionInvite{
+				ID:        "invite-1",
+				Role:      "observer",
+				HostID:    &token,
+				ProcessID: &token,
+				Label:     &token,
+				CreatedAt: "2024-01-01T00:00:00Z",
+				ExpiresAt: &token,
 			},
-			expectedFields: []string{"success", "sessionId", "reconnectToken", "reconnected"},
+			expectedFields: []string{"id", "role", "hostId", "processId", "label", "createdAt", "expiresAt"},
+		},
+		{
+			name:           "SessionInviteCreateResultPayload",
+			payload:        SessionInviteCreateResultPayload{Success: true, Invite: &SessionInvite{}, Token: &token},
+			expectedFields: []string{"success", "invite", "token"},
+		},
+		{
+			name:           "SessionInviteRevokeResultPayload",
+			payload:        SessionInviteRevokeResultPayload{Success: true, ID: "invite-1", Disconnected: 1},
+			expectedFields: []string{"success", "id", "disconnected"},
+		},
+		{
+			name: "SessionInfoResultPayload",
+			payload: SessionInfoResultPayload{
+				Session:  ConnectedSessionInfo{ID: "session-1", Role: "owner"},
+				Sessions: []ConnectedSessionInfo{},
+			},
+			expectedFields: []string{"session"},
+		},
+		{
+			name: "ConnectedSessionInfo",
+			payload: ConnectedSessionInfo{
+				ID:          "session-1",
+				DeviceLabel: "phone",
+				Role:        "observer",
+				CreatedAt:   "2024-01-01T00:00:00Z",
+				Latency:     &SessionLatency{RTTMs: 42.5, Samples: 3},
+			},
+			expectedFields: []string{"id", "deviceLabel", "role", "createdAt", "latency"},
+		},
+		{
+			name:           "SessionLatency",
+			payload:        SessionLatency{},
+			expectedFields: []string{"rttMs", "lastRttMs", "minRttMs", "samples", "measuredAt"},
+		},
+		{
+			// Clients that didn't ask for server timestamps see the same
+			// payloads as before
+			name:           "PtyOutputPayload",
+			payload:        PtyOutputPayload{ProcessID: "proc-id", Data: "ls\r\n"},
+			expectedFields: []string{"processId", "data"},
+		},
+		{
+			name:           "PtyOutputPayload with server timestamp",
+			payload:        PtyOutputPayload{ProcessID: "proc-id", Data: "ls\r\n", ServerTs: timestamp},
+			expectedFields: []string{"processId", "data", "serverTs"},
+		},
+		{
+			name:           "ChatEventPayload",
+			payload:        ChatEventPayload{HostID: "host-id", ProcessID: "proc-id", Event: "status_change", Data: json.RawMessage(`{}`)},
+			expectedFields: []string{"hostId", "processId", "event", "data"},
+		},
+		{
+			name:           "ChatEventPayload with server timestamp",
+			payload:        ChatEventPayload{HostID: "host-id", ProcessID: "proc-id", Event: "status_change", Data: json.RawMessage(`{}`), ServerTs: timestamp},
+			expectedFields: []string{"hostId", "processId", "event", "data", "serverTs"},
 		},
 		{
 			name: "ProcessInfo",
@@ -146,43 +421,589 @@ func TestPayloadJSONFieldAlignment(t *testing.T) {
 				PtyReady:      true,
 				AgentAPIReady: false,
 				StartedAt:     "2024-01-01T00:00:00Z",
+				Pinned:        true,
+				SortWeight:    2,
+				TermOptions:   map[string]string{"status": "off"},
+				Timeline:      true,
+				Exited:        true,
+				LastError:     &ProcessError{Operation: "pty_resize", Code: ErrorPtyError, Message: "resize failed", At: "2024-01-01T00:00:00Z"},
+				Color:         "#1e90ff",
+				Icon:          "rocket",
+				Stats:         &ProcessStats{PtyOutputBytes: 1024},
+				StartupHooks:  []string{"cd ~/repo"},
+			},
+			expectedFields: []string{"id", "type", "hostId", "cwd", "ptyReady", "agentApiReady", "startedAt", "pinned", "sortWeight", "termOptions", "timeline", "exited", "lastError", "color", "icon", "stats", "startupHooks"},
+		},
+		{
+			name:           "ProcessError",
+			payload:        ProcessError{},
+			expectedFields: []string{"operation", "code", "message", "at"},
+		},
+		{
+			name:           "ProcessStats",
+			payload:        ProcessStats{},
+			expectedFields: []string{"ptyOutputBytes", "ptyInputBytes", "chatEventBytes", "historyBytes"},
+		},
+		{
+			name:           "ProcessListPayload",
+			payload:        ProcessListPayload{HostID: "host-id", ListLight: true, IncludeStats: true},
+			expectedFields: []string{"hostId", "listLight", "includeStats"},
+		},
+		{
+			name: "HostConfigImportSSHConfigPayload",
+			payload: HostConfigImportSSHConfigPayload{
+				ConfigText:        &token,
+				Select:            []string{"web"},
+				ReadIdentityFiles: true,
+			},
+			expectedFields: []string{"configText", "select", "readIdentityFiles"},
+		},
+		{
+			name: "HostConfigImportSSHConfigResultPayload",
+			payload: HostConfigImportSSHConfigResultPayload{
+				Success: true,
+				Entries: []SSHConfigEntry{},
+				Created: []SSHHostConfig{{}},
+				Failed:  []SSHConfigImportFailure{{Alias: "db", Error: "exists"}},
+			},
+			expectedFields: []string{"success", "entries", "created", "failed"},
+		},
+		{
+			name: "SSHHostConfig",
+			payload: SSHHostConfig{
+				ID:                "host-1",
+				Name:              "web",
+				AuthType:          "key",
+				CredentialBackend: "exec",
+				TmuxSocketPath:    "/srv/shared/tmux.sock",
+				TmuxCommand:       "/opt/tmux/bin/tmux",
+				MaxConcurrentOps:  2,
+				Color:             "green",
+				Icon:              "server",
+				StartupHooks:      []string{"cd ~/repo"},
+			},
+			expectedFields: []string{"id", "name", "host", "port", "username", "authType", "credentialBackend", "autoConnect", "tmuxSocketPath", "tmuxCommand", "maxConcurrentOps", "color", "icon", "createdAt", "updatedAt", "startupHooks"},
+		},
+		{
+			name: "SSHConfigEntry",
+			payload: SSHConfigEntry{
+				Alias:        "web",
+				HostName:     "web.example.com",
+				User:         "deploy",
+				Port:         22,
+				AuthType:     "key",
+				IdentityFile: &token,
+				ProxyJump:    &token,
+			},
+			expectedFields: []string{"alias", "hostName", "user", "port", "authType", "identityFile", "proxyJump", "exists"},
+		},
+		{
+			name: "HostWarning",
+			payload: HostWarning{
+				Code:        HostWarningDuplicate,
+				Message:     "duplicate",
+				DuplicateOf: []string{"host-id"},
+			},
+			expectedFields: []string{"code", "message", "duplicateOf"},
+		},
+		{
+			name: "ChatSearchPayload",
+			payload: ChatSearchPayload{
+				Query:  "login test",
+				HostID: "host-id",
+				Role:   "user",
+				Limit:  10,
+			},
+			expectedFields: []string{"query", "hostId", "role", "limit"},
+		},
+		{
+			name: "ChatSearchProcessResult",
+			payload: ChatSearchProcessResult{
+				ProcessID:   "proc-id",
+				HostID:      "host-id",
+				ProcessName: &processName,
+				MatchCount:  1,
+			},
+			expectedFields: []string{"processId", "hostId", "processName", "matchCount", "matches"},
+		},
+		{
+			name: "ChatSearchMatch",
+			payload: ChatSearchMatch{
+				MessageID:  1,
+				Role:       "user",
+				Highlights: []TextRange{{Start: 0, Length: 5}},
+			},
+			expectedFields: []string{"messageId", "role", "time", "snippet", "highlights"},
+		},
+		{
+			name: "HostExecPayload",
+			payload: HostExecPayload{
+				RequestID: "req-1",
+				HostID:    "host-id",
+				Command:   "df -h",
+				TimeoutMs: &count,
+				CWD:       &processName,
+			},
+			expectedFields: []string{"requestId", "hostId", "command", "timeoutMs", "cwd"},
+		},
+		{
+			name: "HostExecResultPayload",
+			payload: HostExecResultPayload{
+				RequestID: "req-1",
+				HostID:    "host-id",
+				Command:   "df -h",
+			},
+			expectedFields: []string{"requestId", "hostId", "command", "exitCode", "timedOut", "durationMs", "stdout", "stderr", "stdoutTruncated", "stderrTruncated"},
+		},
+		{
+			name: "SnippetExecutePayload",
+			payload: SnippetExecutePayload{
+				RequestID:   "req-1",
+				SnippetID:   "snip-1",
+				ProcessID:   "proc-1",
+				ProcessIDs:  []string{"proc-1"},
+				WorkspaceID: "ws-1",
+				Variables:   map[string]string{"env": "prod"},
+				Mode:        InputModeKeys,
+			},
+			expectedFields: []string{"requestId", "snippetId", "processId", "processIds", "workspaceId", "variables", "mode"},
+		},
+		{
+			name:           "SnippetExecuteResultPayload",
+			payload:        SnippetExecuteResultPayload{SnippetID: "snip-1", Mode: InputModeText},
+			expectedFields: []string{"snippetId", "mode", "results"},
+		},
+		{
+			name: "SnippetTargetResult",
+			payload: SnippetTargetResult{
+				ProcessID:       "proc-1",
+				HostID:          "host-id",
+				Code:            ErrorPtyDetached,
+				Error:           &processName,
+				SuggestedAction: SuggestedActionReattachProcess,
+			},
+			expectedFields: []string{"processId", "hostId", "success", "code", "error", "suggestedAction"},
+		},
+		{
+			name:           "FileDownloadPayload",
+			payload:        FileDownloadPayload{DownloadID: "dl-1", HostID: "host-id", Path: "dist/app.apk", ProcessID: &processName},
+			expectedFields: []string{"downloadId", "hostId", "path", "processId"},
+		},
+		{
+			name:           "FileDownloadChunkPayload",
+			payload:        FileDownloadChunkPayload{DownloadID: "dl-1", Data: "aGk="},
+			expectedFields: []string{"downloadId", "seq", "data", "totalSize"},
+		},
+		{
+			name:           "FileDownloadCompletePayload",
+			payload:        FileDownloadCompletePayload{DownloadID: "dl-1", Code: ErrorFileNotFound, Error: &processName, SHA256: "abc"},
+			expectedFields: []string{"downloadId", "hostId", "path", "resolvedPath", "symlink", "success", "cancelled", "code", "error", "size", "chunks", "sha256"},
+		},
+		{
+			name:           "FileDownloadCancelPayload",
+			payload:        FileDownloadCancelPayload{DownloadID: "dl-1"},
+			expectedFields: []string{"downloadId"},
+		},
+		{
+			name:           "FileUploadBeginPayload",
+			payload:        FileUploadBeginPayload{UploadID: "up-1", HostID: "host-id", Path: "notes.txt", ProcessID: &processName, Mode: &processName, Overwrite: true},
+			expectedFields: []string{"uploadId", "hostId", "path", "processId", "size", "sha256", "mode", "overwrite"},
+		},
+		{
+			name:           "FileUploadChunkPayload",
+			payload:        FileUploadChunkPayload{UploadID: "up-1", Data: "aGk="},
+			expectedFields: []string{"uploadId", "seq", "data"},
+		},
+		{
+			name:           "FileUploadCommitPayload",
+			payload:        FileUploadCommitPayload{UploadID: "up-1"},
+			expectedFields: []string{"uploadId"},
+		},
+		{
+			name:           "FileUploadResultPayload",
+			payload:        FileUploadResultPayload{UploadID: "up-1"},
+			expectedFields: []string{"uploadId", "hostId", "path", "resolvedPath", "size", "mode", "replaced"},
+		},
+		{
+			name:           "HostDiagnosticsPayload",
+			payload:        HostDiagnosticsPayload{HostID: "host-id", Record: true},
+			expectedFields: []string{"hostId", "record"},
+		},
+		{
+			name:           "HostDiagnosticsSample",
+			payload:        HostDiagnosticsSample{At: "2024-01-01T00:00:00Z"},
+			expectedFields: []string{"at", "rttMs", "rttMinMs", "rttAvgMs", "rttMaxMs", "throughputBytes", "throughputMs", "throughputBytesPerSec"},
+		},
+		{
+			name:           "HostKeepaliveStatus",
+			payload:        HostKeepaliveStatus{IntervalSeconds: 30, LastOkAt: &processName, LastFailure: &processName, LastFailureAt: &processName},
+			expectedFields: []string{"intervalSeconds", "lastOkAt", "lastFailure", "lastFailureAt"},
+		},
+		{
+			name:           "HostDiagnosticsResultPayload",
+			payload:        HostDiagnosticsResultPayload{HostID: "host-id", Success: true},
+			expectedFields: []string{"hostId", "success", "connectedAt", "connectionAgeSeconds", "keepalive", "channels", "ops", "history"},
+		},
+		{
+			name: "HostStatusPayload",
+			payload: HostStatusPayload{
+				HostID:           "host-id",
+				Connected:        true,
+				Processes:        []ProcessInfo{},
+				RebootDetected:   true,
+				BootTime:         &token,
+				PreviousBootTime: &token,
+				Platform:         &HostPlatform{OS: "Darwin", Arch: "arm64"},
+			},
+			expectedFields: []string{"hostId", "connected", "processes", "rebootDetected", "bootTime", "previousBootTime", "platform"},
+		},
+		{
+			name: "HostPlatform",
+			payload: HostPlatform{
+				OS:          "Linux",
+				Arch:        "x86_64",
+				Release:     strPtr("Ubuntu 22.04.4 LTS"),
+				Shell:       strPtr("/bin/bash"),
+				TmuxVersion: strPtr("3.2a"),
+			},
+			expectedFields: []string{"os", "arch", "release", "shell", "tmuxVersion", "hasProc"},
+		},
+		{
+			name: "ReattachReport",
+			payload: ReattachReport{
+				Reattached: []string{"proc-1"},
+				Retried:    []string{"proc-1", "proc-2"},
+				Failed:     []ReattachFailure{{ProcessID: "proc-2", Error: "open failed"}},
+			},
+			expectedFields: []string{"reattached", "retried", "failed"},
+		},
+		{
+			name:           "ProcessEnvListPayload",
+			payload:        ProcessEnvListPayload{ProcessID: "proc-1", Mode: &diffMode},
+			expectedFields: []string{"processId", "mode"},
+		},
+		{
+			name: "EnvUpdatePayload",
+			payload: EnvUpdatePayload{
+				HostID:        "host-1",
+				CustomVars:    []EnvVar{},
+				DryRun:        true,
+				AllowOverride: []string{"LANG"},
+				Force:         true,
+			},
+			expectedFields: []string{"hostId", "customVars", "dryRun", "allowOverride", "force"},
+		},
+		{
+			name:           "EnvConflict",
+			payload:        EnvConflict{Key: "PATH", SystemValue: strPtr("/usr/bin"), Critical: true},
+			expectedFields: []string{"key", "systemValue", "isMasked", "critical", "allowed"},
+		},
+		{
+			name: "ProcessEnvResultPayload",
+			payload: ProcessEnvResultPayload{
+				ProcessID: "proc-1",
+				Mode:      ProcessEnvModeDiff,
+				Vars:      []EnvVar{{Key: "PATH", Value: "/usr/bin"}},
+				Diff: &ProcessEnvDiff{
+					Added:   []EnvVar{{Key: "NODE_ENV", Value: "production"}},
+					Removed: []EnvVar{},
+					Changed: []EnvVarChange{{Key: "GITHUB_TOKEN", Old: "********", New: "********", IsMasked: true}},
+				},
+				Code:         ErrorExecLimit,
+				Error:        strPtr("rate limited"),
+				RetryAfterMs: &retryAfter,
+			},
+			expectedFields: []string{"processId", "mode", "vars", "diff", "code", "error", "retryAfterMs"},
+		},
+		{
+			name:           "EnvVarChange",
+			payload:        EnvVarChange{Key: "PATH"},
+			expectedFields: []string{"key", "old", "new", "isMasked"},
+		},
+		{
+			name:           "ChatUsagePayload",
+			payload:        ChatUsagePayload{ProcessID: "proc-id"},
+			expectedFields: []string{"processId"},
+		},
+		{
+			name: "ChatUsageResultPayload",
+			payload: ChatUsageResultPayload{
+				ProcessID:      "proc-id",
+				UsageAvailable: true,
+				Source:         ChatUsageSourceCache,
+				UpdatedAt:      &chatStatus,
+				Usage:          &ChatUsage{},
+			},
+			expectedFields: []string{"processId", "usageAvailable", "source", "updatedAt", "usage"},
+		},
+		{
+			name: "ChatUsage",
+			payload: ChatUsage{
+				EstimatedCostUSD: &costUSD,
+			},
+			expectedFields: []string{"inputTokens", "outputTokens", "cacheCreationInputTokens", "cacheReadInputTokens", "messageCount", "estimatedCostUsd", "models"},
+		},
+		{
+			name: "ChatModelUsage",
+			payload: ChatModelUsage{
+				Model:            "claude-sonnet-4-5",
+				EstimatedCostUSD: &costUSD,
+			},
+			expectedFields: []string{"model", "inputTokens", "outputTokens", "cacheCreationInputTokens", "cacheReadInputTokens", "messageCount", "estimatedCostUsd"},
+		},
+		{
+			name:           "ProcessKillPayload",
+			payload:        ProcessKillPayload{ProcessID: "proc-id", KeepHistory: &keepHistory, Confirmation: Confirmation{ConfirmRequired: true, ConfirmToken: "token"}},
+			expectedFields: []string{"processId", "keepHistory", "confirmRequired", "confirmToken"},
+		},
+		{
+			name:           "ProcessKilledPayload",
+			payload:        ProcessKilledPayload{ProcessID: "proc-id", Archived: true},
+			expectedFields: []string{"processId", "archived"},
+		},
+		{
+			name: "ArchivedProcess",
+			payload: ArchivedProcess{
+				ID:               "proc-id",
+				HostID:           "host-id",
+				Type:             ProcessTypeClaude,
+				Name:             &processName,
+				CWD:              "/home/user",
+				StartedAt:        "2024-01-01T00:00:00Z",
+				ArchivedAt:       "2024-01-02T00:00:00Z",
+				PtyHistorySize:   1024,
+				ChatMessageCount: 3,
+			},
+			expectedFields: []string{"id", "hostId", "type", "name", "cwd", "startedAt", "archivedAt", "ptyHistorySize", "chatMessageCount"},
+		},
+		{
+			name:           "ArchivedProcessListPayload",
+			payload:        ArchivedProcessListPayload{HostID: "host-id"},
+			expectedFields: []string{"hostId"},
+		},
+		{
+			name:           "ArchivedProcessListResultPayload",
+			payload:        ArchivedProcessListResultPayload{Processes: []ArchivedProcess{}, Code: ErrorStorageError, Error: &processName},
+			expectedFields: []string{"processes", "code", "error"},
+		},
+		{
+			name:           "HostStatusRequestPayload",
+			payload:        HostStatusRequestPayload{HostID: "host-id"},
+			expectedFields: []string{"hostId"},
+		},
+		{
+			name:           "ProcessAddedPayload",
+			payload:        ProcessAddedPayload{HostID: "host-id", Process: ProcessInfo{ID: "proc-id"}, MetadataDiscarded: true},
+			expectedFields: []string{"hostId", "process", "metadataDiscarded"},
+		},
+		{
+			name:           "ProcessRemovedPayload",
+			payload:        ProcessRemovedPayload{HostID: "host-id", ProcessID: "proc-id", Reason: ProcessRemovedDetached},
+			expectedFields: []string{"hostId", "processId", "reason"},
+		},
+		{
+			name:           "StaleProcessesChangedPayload",
+			payload:        StaleProcessesChangedPayload{HostID: "host-id", StaleProcesses: []StaleProcess{}},
+			expectedFields: []string{"hostId", "staleProcesses"},
+		},
+		{
+			name:           "ProcessCWDChangedPayload",
+			payload:        ProcessCWDChangedPayload{ProcessID: "proc-id", CWD: "/home/user"},
+			expectedFields: []string{"processId", "cwd"},
+		},
+		{
+			name:           "ArchivedProcessGetPayload",
+			payload:        ArchivedProcessGetPayload{ProcessID: "proc-id"},
+			expectedFields: []string{"processId"},
+		},
+		{
+			name:           "ArchivedProcessGetResultPayload",
+			payload:        ArchivedProcessGetResultPayload{Process: ArchivedProcess{ID: "proc-id"}},
+			expectedFields: []string{"process"},
+		},
+		{
+			name:           "ArchivedProcessDeletePayload",
+			payload:        ArchivedProcessDeletePayload{ProcessID: "proc-id"},
+			expectedFields: []string{"processId"},
+		},
+		{
+			name:           "ArchivedProcessDeleteResultPayload",
+			payload:        ArchivedProcessDeleteResultPayload{Success: true, ID: &processName, Code: ErrorNotFound, Error: &processName},
+			expectedFields: []string{"success", "id", "code", "error"},
+		},
+		{
+			name: "ConfirmationChallengePayload",
+			payload: ConfirmationChallengePayload{
+				Action:           TypeProcessKill,
+				Target:           "proc-id",
+				Token:            "token",
+				ExpiresInSeconds: 30,
+				Summary:          "Kill Claude process",
+				ProcessName:      &processName,
+				ProcessType:      &claudeType,
+				UptimeSeconds:    &uptime,
+				ChatMessages:     &count,
 			},
-			expectedFields: []string{"id", "type", "hostId", "cwd", "ptyReady", "agentApiReady", "startedAt"},
+			expectedFields: []string{"action", "target", "token", "expiresInSeconds", "summary", "processName", "processType", "uptimeSeconds", "chatMessages"},
+		},
+		{
+			name:           "ChatDraftSetPayload",
+			payload:        ChatDraftSetPayload{ProcessID: "proc-id", Text: "draft", Clear: true},
+			expectedFields: []string{"processId", "text", "clear"},
+		},
+		{
+			name:           "ChatDraftGetPayload",
+			payload:        ChatDraftGetPayload{ProcessID: "proc-id"},
+			expectedFields: []string{"processId"},
+		},
+		{
+			name:           "ChatDraftResultPayload",
+			payload:        ChatDraftResultPayload{ProcessID: "proc-id", Draft: &ChatDraft{}},
+			expectedFields: []string{"processId", "draft"},
+		},
+		{
+			name:           "ChatDraft",
+			payload:        ChatDraft{Text: "draft", UpdatedAt: "2024-01-01T00:00:00Z"},
+			expectedFields: []string{"text", "updatedAt"},
+		},
+		{
+			name:           "TextRange",
+			payload:        TextRange{Start: 1, Length: 2},
+			expectedFields: []string{"start", "length"},
+		},
+		{
+			name: "SSHChannelUsage",
+			payload: SSHChannelUsage{
+				Open:  7,
+				Limit: 10,
+			},
+			expectedFields: []string{"open", "limit", "tunnelConnections", "tunnelOpen"},
+		},
+		{
+			name: "UnmanagedSession",
+			payload: UnmanagedSession{
+				TmuxSession: "rc-test",
+			},
+			expectedFields: []string{"tmuxSession"},
 		},
 		{
 			name: "HostConnectPayload",
 			payload: HostConnectPayload{
-				HostID:   "host-id",
-				Host:     "192.168.1.1",
-				Port:     22,
-				Username: "user",
-				AuthType: "password",
+				HostID:       "host-id",
+				WantProgress: true,
 			},
-			expectedFields: []string{"hostId", "host", "port", "username", "authType"},
+			expectedFields: []string{"hostId", "wantProgress"},
+		},
+		{
+			name: "HostConnectProgressPayload",
+			payload: HostConnectProgressPayload{
+				HostID:  "host-id",
+				Stage:   HostConnectReattaching,
+				Found:   &count,
+				Current: &count,
+				Total:   &count,
+			},
+			expectedFields: []string{"hostId", "stage", "found", "current", "total"},
 		},
 		{
 			name: "ProcessCreatePayload",
 			payload: ProcessCreatePayload{
-				HostID: "host-id",
+				HostID:    "host-id",
+				Timeline:  true,
+				SkipHooks: true,
+				PtyOutput: &PtyOutputOverride{},
 			},
-			expectedFields: []string{"hostId"},
+			expectedFields: []string{"hostId", "timeline", "skipHooks", "ptyOutput"},
+		},
+		{
+			name: "PtyOutputOverride",
+			payload: PtyOutputOverride{
+				ReadBufferSize:    &count,
+				CoalesceWindowMs:  &count,
+				MaxCoalescedBytes: &count,
+			},
+			expectedFields: []string{"readBufferSize", "coalesceWindowMs", "maxCoalescedBytes"},
+		},
+		{
+			name:           "PtyOutputSettings",
+			payload:        PtyOutputSettings{ReadBufferSize: 4096, MaxCoalescedBytes: 65536},
+			expectedFields: []string{"readBufferSize", "coalesceWindowMs", "maxCoalescedBytes"},
+		},
+		{
+			name: "PtyFailureDetails",
+			payload: PtyFailureDetails{
+				ProcessID:       "proc-id",
+				HostID:          "host-id",
+				SuggestedAction: SuggestedActionReconnectHost,
+			},
+			expectedFields: []string{"processId", "hostId", "suggestedAction"},
+		},
+		{
+			name: "ProcessClonePayload",
+			payload: ProcessClonePayload{
+				SourceProcessID: "proc-id",
+				Cols:            &count,
+			},
+			expectedFields: []string{"sourceProcessId", "cols"},
+		},
+		{
+			name: "ProcessCreatedPayload",
+			payload: ProcessCreatedPayload{
+				ClonedFrom: &processName,
+			},
+			expectedFields: []string{"process", "clonedFrom"},
 		},
 		{
 			name: "PtyInputPayload",
 			payload: PtyInputPayload{
 				ProcessID: "proc-id",
 				Data:      "ls -la\n",
+				Mode:      InputModeRaw,
 			},
-			expectedFields: []string{"processId", "data"},
+			expectedFields: []string{"processId", "data", "mode"},
 		},
 		{
-			name: "ChatSendPayload",
-			payload: ChatSendPayload{
+			name: "ChatRawPayload",
+			payload: ChatRawPayload{
 				HostID:    "host-id",
 				ProcessID: "proc-id",
-				Content:   "Hello",
+				Content:   "\x1b[A",
+				Mode:      InputModeKeys,
 			},
-			expectedFields: []string{"hostId", "processId", "content"},
+			expectedFields: []string{"hostId", "processId", "content", "mode"},
+		},
+		{
+			name: "ChatSendPayload",
+			payload: ChatSendPayload{
+				HostID:          "host-id",
+				ProcessID:       "proc-id",
+				Content:         "Hello",
+				ClientMessageID: strPtr("client-id"),
+			},
+			expectedFields: []string{"hostId", "processId", "content", "clientMessageId"},
+		},
+		{
+			name: "ChatSendResultPayload",
+			payload: ChatSendResultPayload{
+				HostID:          "host-id",
+				ProcessID:       "proc-id",
+				ClientMessageID: "client-id",
+				Success:         true,
+				Message:         &ChatMessage{ID: -1, Role: "user", Message: "Hello", Pending: true, ClientMessageID: "client-id"},
+			},
+			expectedFields: []string{"hostId", "processId", "clientMessageId", "success", "message"},
+		},
+		{
+			name: "ChatMessage",
+			payload: ChatMessage{
+				ID:       3,
+				Role:     "assistant",
+				Message:  "Reading the file",
+				Time:     "2026-01-01T10:00:00Z",
+				Kind:     "tool_call",
+				Metadata: json.RawMessage(`{"tool":"Read"}`),
+			},
+			expectedFields: []string{"id", "role", "message", "time", "kind", "metadata"},
 		},
 		{
 			name: "ProcessUpdatedPayload",
@@ -191,15 +1012,134 @@ func TestPayloadJSONFieldAlignment(t *testing.T) {
 				Type:          ProcessTypeClaude,
 				PtyReady:      true,
 				AgentAPIReady: true,
+				ClaudeCWD:     "/home/project",
+				Pinned:        true,
+				Exited:        true,
+				LastError:     &ProcessError{Operation: "agent_events", Code: ErrorAgentAPIDown},
+				AgentStatus:   strPtr("running"),
+				Color:         "purple",
+				Icon:          "bug",
+
+				RetainFullHistory: true,
+				TerminalAttached:  &retain,
+			},
+			expectedFields: []string{"id", "type", "ptyReady", "agentApiReady", "claudeCwd", "pinned", "sortWeight", "timeline", "exited", "lastError", "agentStatus", "color", "icon", "retainFullHistory", "terminalAttached"},
+		},
+		{
+			name:           "ClaudeReattachTerminalPayload",
+			payload:        ClaudeReattachTerminalPayload{ProcessID: "proc-id"},
+			expectedFields: []string{"processId"},
+		},
+		{
+			name: "ProcessPinPayload",
+			payload: ProcessPinPayload{
+				ProcessID: "proc-id",
+				Pinned:    true,
+			},
+			expectedFields: []string{"processId", "pinned"},
+		},
+		{
+			name: "ProcessSetOrderPayload",
+			payload: ProcessSetOrderPayload{
+				HostID:     "host-id",
+				ProcessIDs: []string{"proc-2", "proc-1"},
+			},
+			expectedFields: []string{"hostId", "processIds"},
+		},
+		{
+			name: "ProcessTermOptionsPayload",
+			payload: ProcessTermOptionsPayload{
+				ProcessID: "proc-id",
+				Options:   map[string]string{"mouse": "on"},
+			},
+			expectedFields: []string{"processId", "options"},
+		},
+		{
+			name:           "ProcessClearErrorPayload",
+			payload:        ProcessClearErrorPayload{ProcessID: "proc-id"},
+			expectedFields: []string{"processId"},
+		},
+		{
+			name: "ProcessSetAppearancePayload",
+			payload: ProcessSetAppearancePayload{
+				ProcessID: "proc-id",
+				Color:     &token,
+				Icon:      &token,
+
+				RetainFullHistory: &retain,
 			},
-			expectedFields: []string{"id", "type", "ptyReady", "agentApiReady"},
+			expectedFields: []string{"processId", "color", "icon", "retainFullHistory"},
+		},
+		{
+			name: "ProcessEnableTimelinePayload",
+			payload: ProcessEnableTimelinePayload{
+				ProcessID: "proc-id",
+				Disable:   true,
+			},
+			expectedFields: []string{"processId", "disable"},
+		},
+		{
+			name: "ProcessTimelineListPayload",
+			payload: ProcessTimelineListPayload{
+				ProcessID: "proc-id",
+				Before:    &timestamp,
+				Limit:     20,
+			},
+			expectedFields: []string{"processId", "before", "limit"},
+		},
+		{
+			name: "ProcessTimelineListResultPayload",
+			payload: ProcessTimelineListResultPayload{
+				ProcessID: "proc-id",
+				Commands: []TimelineCommand{{
+					ID:        7,
+					Command:   "make test",
+					StartedAt: "2024-01-01T00:00:00Z",
+					EndedAt:   &processName,
+					ExitCode:  &count,
+				}},
+				HasMore: true,
+			},
+			expectedFields: []string{"processId", "commands", "hasMore"},
+		},
+		{
+			name: "TimelineCommand",
+			payload: TimelineCommand{
+				ID:        7,
+				Command:   "make test",
+				StartedAt: "2024-01-01T00:00:00Z",
+				EndedAt:   &processName,
+				ExitCode:  &count,
+			},
+			expectedFields: []string{"id", "command", "startedAt", "endedAt", "exitCode"},
+		},
+		{
+			name: "ProcessAlertPayload",
+			payload: ProcessAlertPayload{
+				ProcessID: "proc-id",
+				HostID:    "host-id",
+				Kind:      ProcessAlertBell,
+				Timestamp: "2024-01-01T00:00:00Z",
+			},
+			expectedFields: []string{"processId", "hostId", "kind", "timestamp"},
+		},
+		{
+			name: "PtySnapshotPayload",
+			payload: PtySnapshotPayload{
+				ProcessID: "proc-id",
+				Cols:      80,
+				Rows:      24,
+				Data:      "$ ls\r\n",
+			},
+			expectedFields: []string{"processId", "cols", "rows", "data"},
 		},
 		{
 			name: "PtyHistoryRequestPayload",
 			payload: PtyHistoryRequestPayload{
 				ProcessID: "proc-id",
+				ChunkSize: &count,
 			},
-			expectedFields: []string{"processId"},
+			expectedFields: []string{"processId", "chunkSize"},
 		},
 		{
 			name: "PtyHistoryResponsePayload",
@@ -226,8 +1166,108 @@ func TestPayloadJSONFieldAlignment(t *testing.T) {
 			payload: PtyHistoryCompletePayload{
 				ProcessID: "proc-id",
 				Success:   true,
+				Stats:     &PtyHistoryTransferStats{Bytes: 1024, Chunks: 1, ChunkSize: 65536},
+			},
+			expectedFields: []string{"processId", "success", "stats"},
+		},
+		{
+			name:           "PtyHistoryTransferStats",
+			payload:        PtyHistoryTransferStats{Bytes: 1024, Chunks: 1, ChunkSize: 65536, DurationMs: 2, BytesPerSecond: 512000},
+			expectedFields: []string{"bytes", "chunks", "chunkSize", "durationMs", "bytesPerSecond"},
+		},
+		{
+			name: "ChatSubscribeResultPayload",
+			payload: ChatSubscribeResultPayload{
+				HostID:          "host-id",
+				ProcessID:       "proc-id",
+				Success:         true,
+				Status:          &chatStatus,
+				LatestMessageID: &latestMessageID,
+			},
+			expectedFields: []string{"hostId", "processId", "success", "status", "latestMessageId"},
+		},
+		{
+			name: "EnvVar",
+			payload: EnvVar{
+				Key:      "GITHUB_TOKEN",
+				Value:    "********",
+				IsMasked: true,
+			},
+			expectedFields: []string{"key", "value", "isMasked"},
+		},
+		{
+			name: "EnvRevealResultPayload",
+			payload: EnvRevealResultPayload{
+				HostID:  "host-id",
+				Key:     "GITHUB_TOKEN",
+				Success: true,
+				Value:   &token,
+			},
+			expectedFields: []string{"hostId", "key", "success", "value"},
+		},
+		{
+			name: "ProfileListResultPayload",
+			payload: ProfileListResultPayload{
+				Profiles: []string{"default", "work"},
+				Current:  "work",
+			},
+			expectedFields: []string{"profiles", "current"},
+		},
+		{
+			name: "StorageEncryptProgressPayload",
+			payload: StorageEncryptProgressPayload{
+				Table: "chat_history",
+				Done:  256,
+				Total: 1000,
+			},
+			expectedFields: []string{"table", "done", "total"},
+		},
+		{
+			name: "StorageEncryptResultPayload",
+			payload: StorageEncryptResultPayload{
+				Success:   true,
+				Encrypted: 1000,
 			},
-			expectedFields: []string{"processId", "success"},
+			expectedFields: []string{"success", "encrypted"},
+		},
+		{
+			name:           "StorageFlushPayload",
+			payload:        StorageFlushPayload{RequestID: "req-1", ProcessID: "proc-1"},
+			expectedFields: []string{"requestId", "processId"},
+		},
+		{
+			name: "StorageFlushResultPayload",
+			payload: StorageFlushResultPayload{
+				RequestID: "req-1",
+				ProcessID: "proc-1",
+				Success:   true,
+			},
+			expectedFields: []string{"requestId", "processId", "success", "durationMs"},
+		},
+		{
+			name: "BridgeInfoResultPayload",
+			payload: BridgeInfoResultPayload{
+				Version:         "dev",
+				ProtocolVersion: ProtocolVersion,
+				ListenAddresses: []string{":8080"},
+			},
+			expectedFields: []string{"version", "commit", "buildDate", "protocolVersion", "startedAt", "uptimeSeconds",
+				"profile", "dataDir", "listenAddresses", "hostCount", "connectedHostCount", "processCount", "sessionCount", "connectedSessions", "portRange", "sshChannels", "ptyOutput"},
+		},
+		{
+			name: "BridgeUpdateStatusPayload",
+			payload: BridgeUpdateStatusPayload{
+				CurrentVersion:        "1.4.2",
+				Available:             true,
+				LatestVersion:         strPtr("1.5.0"),
+				ReleaseURL:            strPtr("https://example.com/releases/1.5.0"),
+				Notes:                 strPtr("Faster reattach"),
+				LatestProtocolVersion: &count,
+				CheckedAt:             strPtr("2026-01-01T10:00:00Z"),
+				Error:                 strPtr("offline"),
+			},
+			expectedFields: []string{"currentVersion", "available", "latestVersion", "releaseUrl", "notes",
+				"latestProtocolVersion", "checkedAt", "error"},
 		},
 	}
 
@@ -252,6 +1292,27 @@ func TestPayloadJSONFieldAlignment(t *testing.T) {
 	}
 }
 
+// TestServerTimestampOptional verifies pty_output and chat_event keep the
+// shape older clients know unless a session asked for server timestamps
+func TestServerTimestampOptional(t *testing.T) {
+	for name, payload := range map[string]interface{}{
+		"PtyOutputPayload": PtyOutputPayload{ProcessID: "proc-id", Data: "ls"},
+		"ChatEventPayload": ChatEventPayload{HostID: "host-id", ProcessID: "proc-id", Event: "status_change", Data: json.RawMessage(`{}`)},
+	} {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("Failed to marshal %s: %v", name, err)
+		}
+		var result map[string]interface{}
+		if err := json.Unmarshal(data, &result); err != nil {
+			t.Fatalf("Failed to unmarshal %s: %v", name, err)
+		}
+		if _, ok := result["serverTs"]; ok {
+			t.Errorf("%s without a server timestamp has serverTs: %s", name, data)
+		}
+	}
+}
+
 // TestBidirectionalParsing verifies Go can parse messages from TypeScript format
 func TestBidirectionalParsing(t *testing.T) {
 	// Simulate a message that would come from TypeScript
@@ -294,3 +1355,31 @@ func TestProcessTypeValues(t *testing.T) {
 		t.Errorf("ProcessTypeClaude mismatch: got %q, want %q", ProcessTypeClaude, "claude")
 	}
 }
+
+// TestErrorCodeValues verifies ErrorCodes matches the TypeScript ErrorCode union
+func TestErrorCodeValues(t *testing.T) {
+	expected := []string{
+		"INVALID_MESSAGE", "UNKNOWN_MESSAGE_TYPE", "HANDLER_ERROR", "INVALID_ARGS", "VALIDATION_ERROR", "STORAGE_ERROR", "UNAUTHORIZED", "FORBIDDEN", "NOT_AUTHENTICATED", "PROTOCOL_MISMATCH",
+		"NOT_CONNECTED", "SSH_DOWN",
+		"NOT_FOUND", "ALREADY_EXISTS", "ATTACH_FAILED", "INVALID_STATE", "NOT_CLAUDE", "NO_PORTS",
+		"NO_PTY", "PTY_NOT_READY", "PTY_ERROR", "PTY_DETACHED", "PTY_CLOSED", "SEND_FAILED", "AGENT_BUSY", "AGENTAPI_DOWN",
+		"UNSUPPORTED_SHELL",
+		"EXEC_LIMIT", "EXEC_FAILED",
+		"FILE_NOT_FOUND", "PERMISSION_DENIED", "NOT_A_FILE", "FILE_TOO_LARGE",
+		"UPLOAD_INCOMPLETE", "CHECKSUM_MISMATCH",
+		"SNIPPET_RENDER_ERROR",
+		"CONFIRMATION_INVALID",
+	}
+	codes := ErrorCodes()
+	if len(codes) != len(expected) {
+		t.Fatalf("ErrorCodes has %d codes, TypeScript has %d", len(codes), len(expected))
+	}
+	for i, code := range codes {
+		if string(code) != expected[i] {
+			t.Errorf("ErrorCodes()[%d] = %q, want %q", i, code, expected[i])
+		}
+	}
+	if ErrorCode("MARKER").Valid() {
+		t.Error("an unlisted code is Valid")
+	}
+}
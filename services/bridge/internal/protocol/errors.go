@@ -0,0 +1,95 @@
+package protocol
+
+// ErrorCode says why a request failed, so clients can branch on the code
+// instead of parsing the message. The bridge only sends codes listed here,
+// and each has a message in the i18n catalog. Result payloads that report a
+// failure in Error carry its code in Code.
+type ErrorCode string
+
+const (
+	// Message handling
+	ErrorInvalidMessage     ErrorCode = "INVALID_MESSAGE"      // Message was not valid JSON
+	ErrorUnknownMessageType ErrorCode = "UNKNOWN_MESSAGE_TYPE" // Details: type
+	ErrorHandlerError       ErrorCode = "HANDLER_ERROR"        // Unexpected handler failure. Details: type
+	ErrorInvalidArgs        ErrorCode = "INVALID_ARGS"         // Details: InvalidArgsDetails
+	ErrorValidation         ErrorCode = "VALIDATION_ERROR"     // Payload failed its validate tags. Details: ValidationErrorDetails
+	ErrorStorageError       ErrorCode = "STORAGE_ERROR"
+	ErrorUnauthorized       ErrorCode = "UNAUTHORIZED"      // Auth token, invite or REST API token missing or wrong
+	ErrorForbidden          ErrorCode = "FORBIDDEN"         // Request not allowed, e.g. a write on the read-only admin socket. Details: type
+	ErrorNotAuthenticated   ErrorCode = "NOT_AUTHENTICATED" // Request sent before a successful auth. Details: type
+	ErrorProtocolMismatch   ErrorCode = "PROTOCOL_MISMATCH" // Client speaks an older protocol than the bridge's ProtocolVersion
+
+	// Hosts
+	ErrorNotConnected ErrorCode = "NOT_CONNECTED" // Host (details: hostId) or AgentAPI (details: processId) not connected
+	ErrorSSHDown      ErrorCode = "SSH_DOWN"      // Host connection died under a PTY operation. Details: PtyFailureDetails
+
+	// Processes
+	ErrorNotFound      ErrorCode = "NOT_FOUND"      // Details: processId
+	ErrorAlreadyExists ErrorCode = "ALREADY_EXISTS" // Details: processId
+	ErrorAttachFailed  ErrorCode = "ATTACH_FAILED"  // Details: hostId, tmuxSession
+	ErrorInvalidState  ErrorCode = "INVALID_STATE"  // Details: processId, processType
+	ErrorNotClaude     ErrorCode = "NOT_CLAUDE"     // Details: processId, processType
+	ErrorNoPorts       ErrorCode = "NO_PORTS"       // Details: minPort, maxPort
+
+	// PTY and chat
+	ErrorNoPty        ErrorCode = "NO_PTY"        // Details: processId
+	ErrorPtyNotReady  ErrorCode = "PTY_NOT_READY" // Details: processId
+	ErrorPtyError     ErrorCode = "PTY_ERROR"     // Details: hostId or processId, plus port when starting Claude or sourceProcessId when cloning
+	ErrorPtyDetached  ErrorCode = "PTY_DETACHED"  // PTY has no live attachment. Details: PtyFailureDetails
+	ErrorPtyClosed    ErrorCode = "PTY_CLOSED"    // PTY session was closed. Details: PtyFailureDetails
+	ErrorSendFailed   ErrorCode = "SEND_FAILED"   // AgentAPI refused the message. Details: processId, status
+	ErrorAgentBusy    ErrorCode = "AGENT_BUSY"    // Agent is running and takes no message until it is stable. Details: processId
+	ErrorAgentAPIDown ErrorCode = "AGENTAPI_DOWN" // AgentAPI unreachable or failing. Details: processId, plus status when it answered
+
+	// Command timeline
+	ErrorUnsupportedShell ErrorCode = "UNSUPPORTED_SHELL" // Shell has no timeline hooks. Details: processId, shell
+
+	// Host commands
+	ErrorExecLimit  ErrorCode = "EXEC_LIMIT"  // Too many host_exec commands running for the session. Details: hostId, limit, requestId
+	ErrorExecFailed ErrorCode = "EXEC_FAILED" // Command could not be run. Details: hostId, requestId
+
+	// File downloads and uploads; uploads name an uploadId instead of a downloadId
+	ErrorFileNotFound     ErrorCode = "FILE_NOT_FOUND"    // File, or an upload's directory, doesn't exist. Details: downloadId, hostId, path
+	ErrorPermissionDenied ErrorCode = "PERMISSION_DENIED" // File can't be read, or written, by the host user. Details: downloadId, hostId, path, resolvedPath
+	ErrorNotAFile         ErrorCode = "NOT_A_FILE"        // Path is a directory or special file. Details: downloadId, hostId, path, resolvedPath
+	ErrorFileTooLarge     ErrorCode = "FILE_TOO_LARGE"    // Details: downloadId, hostId, path, size, limit
+	ErrorUploadIncomplete ErrorCode = "UPLOAD_INCOMPLETE" // Committed with chunks missing or the wrong size; the upload is kept. Details: uploadId, size, received, missing
+	ErrorChecksumMismatch ErrorCode = "CHECKSUM_MISMATCH" // Uploaded bytes don't match the declared sha256; the upload is dropped. Details: uploadId, expected, actual
+
+	// Snippets
+	ErrorSnippetRender ErrorCode = "SNIPPET_RENDER_ERROR" // Snippet uses a variable with no value for the process. Details: snippetId, processId, variable
+
+	// Confirmation of destructive requests
+	ErrorConfirmationInvalid ErrorCode = "CONFIRMATION_INVALID" // Token unknown, used, expired or for another request. Details: action, target, reason
+)
+
+// ErrorCodes returns every error code the bridge can send
+func ErrorCodes() []ErrorCode {
+	return []ErrorCode{
+		ErrorInvalidMessage, ErrorUnknownMessageType, ErrorHandlerError, ErrorInvalidArgs, ErrorValidation, ErrorStorageError, ErrorUnauthorized, ErrorForbidden, ErrorNotAuthenticated, ErrorProtocolMismatch,
+		ErrorNotConnected, ErrorSSHDown,
+		ErrorNotFound, ErrorAlreadyExists, ErrorAttachFailed, ErrorInvalidState, ErrorNotClaude, ErrorNoPorts,
+		ErrorNoPty, ErrorPtyNotReady, ErrorPtyError, ErrorPtyDetached, ErrorPtyClosed, ErrorSendFailed, ErrorAgentBusy, ErrorAgentAPIDown,
+		ErrorUnsupportedShell,
+		ErrorExecLimit, ErrorExecFailed,
+		ErrorFileNotFound, ErrorPermissionDenied, ErrorNotAFile, ErrorFileTooLarge,
+		ErrorUploadIncomplete, ErrorChecksumMismatch,
+		ErrorSnippetRender,
+		ErrorConfirmationInvalid,
+	}
+}
+
+// Valid reports whether c is one of ErrorCodes
+func (c ErrorCode) Valid() bool {
+	for _, code := range ErrorCodes() {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorDetails are machine-readable facts about an error, such as the
+// processId or port it concerns. A "reason" entry explains the error in
+// English, for logs and for clients without a better message.
+type ErrorDetails map[string]interface{}
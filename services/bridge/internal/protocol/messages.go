@@ -5,49 +5,107 @@ import (
 	"time"
 )
 
+// ProtocolVersion is bumped on incompatible changes to the message protocol.
+// Version 2 requires auth before any other message, sends host_status once
+// and process_added/process_removed/stale_processes_changed after it, and
+// sends errors with an ErrorCode.
+const ProtocolVersion = 2
+
 // MessageType constants - MUST match TypeScript MessageTypes exactly
 const (
 	// Authentication
-	TypeAuth       = "auth"
-	TypeAuthResult = "auth_result"
+	TypeAuth                      = "auth"
+	TypeAuthResult                = "auth_result"
+	TypeSessionRefreshToken       = "session_refresh_token"
+	TypeSessionRefreshTokenResult = "session_refresh_token_result"
+	TypeSessionInviteCreate       = "session_invite_create"
+	TypeSessionInviteCreateResult = "session_invite_create_result"
+	TypeSessionInviteRevoke       = "session_invite_revoke"
+	TypeSessionInviteRevokeResult = "session_invite_revoke_result"
+	TypeSessionInfo               = "session_info"
+	TypeSessionInfoResult         = "session_info_result"
 
 	// Host Configuration (CRUD - stored in bridge)
-	TypeHostConfigList         = "host_config_list"
-	TypeHostConfigListResult   = "host_config_list_result"
-	TypeHostConfigCreate       = "host_config_create"
-	TypeHostConfigCreateResult = "host_config_create_result"
-	TypeHostConfigUpdate       = "host_config_update"
-	TypeHostConfigUpdateResult = "host_config_update_result"
-	TypeHostConfigDelete       = "host_config_delete"
-	TypeHostConfigDeleteResult = "host_config_delete_result"
+	TypeHostConfigList                  = "host_config_list"
+	TypeHostConfigListResult            = "host_config_list_result"
+	TypeHostConfigCreate                = "host_config_create"
+	TypeHostConfigCreateResult          = "host_config_create_result"
+	TypeHostConfigUpdate                = "host_config_update"
+	TypeHostConfigUpdateResult          = "host_config_update_result"
+	TypeHostConfigDelete                = "host_config_delete"
+	TypeHostConfigDeleteResult          = "host_config_delete_result"
+	TypeHostConfigImportSSHConfig       = "host_config_import_sshconfig"
+	TypeHostConfigImportSSHConfigResult = "host_config_import_sshconfig_result"
 
 	// Host Connection (runtime)
 	TypeHostConnect            = "host_connect"
 	TypeHostDisconnect         = "host_disconnect"
 	TypeHostStatus             = "host_status"
+	TypeHostStatusRequest      = "host_status_request"
 	TypeHostCheckRequirements  = "host_check_requirements"
 	TypeHostRequirementsResult = "host_requirements_result"
+	TypeHostConnectProgress    = "host_connect_progress"
+	TypeHostExec               = "host_exec"
+	TypeHostExecResult         = "host_exec_result"
+	TypeHostDiagnostics        = "host_diagnostics"
+	TypeHostDiagnosticsResult  = "host_diagnostics_result"
 
 	// Process Management
-	TypeProcessList       = "process_list"
-	TypeProcessListResult = "process_list_result"
-	TypeProcessCreate     = "process_create"
-	TypeProcessCreated    = "process_created"
-	TypeProcessSelect     = "process_select"
-	TypeProcessKill       = "process_kill"
-	TypeProcessKilled     = "process_killed"
-	TypeProcessUpdated    = "process_updated"
-	TypeProcessReattach   = "process_reattach"
-	TypeProcessRename     = "process_rename"
+	TypeProcessList          = "process_list"
+	TypeProcessListResult    = "process_list_result"
+	TypeProcessCreate        = "process_create"
+	TypeProcessCreated       = "process_created"
+	TypeProcessSelect        = "process_select"
+	TypeProcessKill          = "process_kill"
+	TypeProcessKilled        = "process_killed"
+	TypeProcessUpdated       = "process_updated"
+	TypeProcessReattach      = "process_reattach"
+	TypeProcessRename        = "process_rename"
+	TypeProcessClone         = "process_clone"
+	TypeProcessPin           = "process_pin"
+	TypeProcessSetOrder      = "process_set_order"
+	TypeProcessTermOptions   = "process_term_options"
+	TypeProcessClearError    = "process_clear_error"
+	TypeProcessSetAppearance = "process_set_appearance"
+
+	// Changes to a host's process list between host_status snapshots
+	TypeProcessAdded          = "process_added"
+	TypeProcessRemoved        = "process_removed"
+	TypeStaleProcessesChanged = "stale_processes_changed"
+	TypeProcessCWDChanged     = "process_cwd_changed"
+
+	// Archived processes, killed with their history kept
+	TypeArchivedProcessList         = "archived_process_list"
+	TypeArchivedProcessListResult   = "archived_process_list_result"
+	TypeArchivedProcessGet          = "archived_process_get"
+	TypeArchivedProcessGetResult    = "archived_process_get_result"
+	TypeArchivedProcessDelete       = "archived_process_delete"
+	TypeArchivedProcessDeleteResult = "archived_process_delete_result"
+
+	// Command timeline
+	TypeProcessEnableTimeline     = "process_enable_timeline"
+	TypeProcessTimelineList       = "process_timeline_list"
+	TypeProcessTimelineListResult = "process_timeline_list_result"
+
+	// Process state pushes
+	TypeProcessesSubscribe   = "processes_subscribe"
+	TypeProcessesUnsubscribe = "processes_unsubscribe"
+	TypeProcessAlert         = "process_alert"
 
 	// Claude Conversion
-	TypeClaudeStart = "claude_start"
-	TypeClaudeKill  = "claude_kill"
+	TypeClaudeStart            = "claude_start"
+	TypeClaudeKill             = "claude_kill"
+	TypeClaudeReattachTerminal = "claude_reattach_terminal"
 
 	// PTY (Terminal)
-	TypePtyInput  = "pty_input"
-	TypePtyOutput = "pty_output"
-	TypePtyResize = "pty_resize"
+	TypePtyInput    = "pty_input"
+	TypePtyOutput   = "pty_output"
+	TypePtyResize   = "pty_resize"
+	TypePtySnapshot = "pty_snapshot"
+
+	// PTY Scrollback (tmux copy mode)
+	TypePtyScroll      = "pty_scroll"
+	TypePtyScrollState = "pty_scroll_state"
 
 	// PTY History
 	TypePtyHistoryRequest  = "pty_history_request"
@@ -56,21 +114,32 @@ const (
 	TypePtyHistoryComplete = "pty_history_complete"
 
 	// Chat (AgentAPI)
-	TypeChatSubscribe    = "chat_subscribe"
-	TypeChatUnsubscribe  = "chat_unsubscribe"
-	TypeChatSend         = "chat_send"
-	TypeChatRaw          = "chat_raw"
-	TypeChatEvent        = "chat_event"
-	TypeChatStatus       = "chat_status"
-	TypeChatStatusResult = "chat_status_result"
-	TypeChatHistory      = "chat_history"
-	TypeChatMessages     = "chat_messages"
+	TypeChatSubscribe       = "chat_subscribe"
+	TypeChatSubscribeResult = "chat_subscribe_result"
+	TypeChatUnsubscribe     = "chat_unsubscribe"
+	TypeChatSend            = "chat_send"
+	TypeChatSendResult      = "chat_send_result"
+	TypeChatRaw             = "chat_raw"
+	TypeChatEvent           = "chat_event"
+	TypeChatStatus          = "chat_status"
+	TypeChatStatusResult    = "chat_status_result"
+	TypeChatHistory         = "chat_history"
+	TypeChatMessages        = "chat_messages"
+	TypeChatSearch          = "chat_search"
+	TypeChatSearchResult    = "chat_search_result"
+	TypeChatUsage           = "chat_usage"
+	TypeChatUsageResult     = "chat_usage_result"
+	TypeChatDraftSet        = "chat_draft_set"
+	TypeChatDraftGet        = "chat_draft_get"
+	TypeChatDraftResult     = "chat_draft_result"
 
 	// Environment Variables - Host Level
-	TypeEnvList      = "env_list"
-	TypeEnvUpdate    = "env_update"
-	TypeEnvResult    = "env_result"
-	TypeEnvSetRcFile = "env_set_rc_file"
+	TypeEnvList         = "env_list"
+	TypeEnvUpdate       = "env_update"
+	TypeEnvResult       = "env_result"
+	TypeEnvSetRcFile    = "env_set_rc_file"
+	TypeEnvReveal       = "env_reveal"
+	TypeEnvRevealResult = "env_reveal_result"
 
 	// Environment Variables - Process Level
 	TypeProcessEnvList   = "process_env_list"
@@ -80,6 +149,18 @@ const (
 	TypePortsScan   = "ports_scan"
 	TypePortsResult = "ports_result"
 
+	// File downloads from hosts
+	TypeFileDownload         = "file_download"
+	TypeFileDownloadChunk    = "file_download_chunk"
+	TypeFileDownloadComplete = "file_download_complete"
+	TypeFileDownloadCancel   = "file_download_cancel"
+
+	// File uploads to hosts
+	TypeFileUploadBegin  = "file_upload_begin"
+	TypeFileUploadChunk  = "file_upload_chunk"
+	TypeFileUploadCommit = "file_upload_commit"
+	TypeFileUploadResult = "file_upload_result"
+
 	// Snippets (global, unrelated to hosts/processes)
 	TypeSnippetList         = "snippet_list"
 	TypeSnippetListResult   = "snippet_list_result"
@@ -90,6 +171,59 @@ const (
 	TypeSnippetDelete       = "snippet_delete"
 	TypeSnippetDeleteResult = "snippet_delete_result"
 
+	// Typing a snippet into processes' terminals
+	TypeSnippetExecute       = "snippet_execute"
+	TypeSnippetExecuteResult = "snippet_execute_result"
+
+	// Workspaces (process groups that may span hosts)
+	TypeWorkspaceList         = "workspace_list"
+	TypeWorkspaceListResult   = "workspace_list_result"
+	TypeWorkspaceCreate       = "workspace_create"
+	TypeWorkspaceCreateResult = "workspace_create_result"
+	TypeWorkspaceUpdate       = "workspace_update"
+	TypeWorkspaceUpdateResult = "workspace_update_result"
+	TypeWorkspaceDelete       = "workspace_delete"
+	TypeWorkspaceDeleteResult = "workspace_delete_result"
+	TypeWorkspaceAssign       = "workspace_assign"
+	TypeWorkspaceAssignResult = "workspace_assign_result"
+
+	// Process templates (saved recipes for new processes)
+	TypeProcessTemplateList             = "process_template_list"
+	TypeProcessTemplateListResult       = "process_template_list_result"
+	TypeProcessTemplateCreate           = "process_template_create"
+	TypeProcessTemplateCreateResult     = "process_template_create_result"
+	TypeProcessTemplateUpdate           = "process_template_update"
+	TypeProcessTemplateUpdateResult     = "process_template_update_result"
+	TypeProcessTemplateDelete           = "process_template_delete"
+	TypeProcessTemplateDeleteResult     = "process_template_delete_result"
+	TypeProcessCreateFromTemplate       = "process_create_from_template"
+	TypeProcessCreateFromTemplateResult = "process_create_from_template_result"
+
+	// Bridge diagnostics
+	TypeBridgeInfo       = "bridge_info"
+	TypeBridgeInfoResult = "bridge_info_result"
+
+	// Bridge update check (see --update-check)
+	TypeBridgeUpdateCheck       = "bridge_update_check"
+	TypeBridgeUpdateCheckResult = "bridge_update_check_result"
+	TypeBridgeUpdateAvailable   = "bridge_update_available"
+
+	// Data profiles (read-only; the profile is chosen at bridge startup)
+	TypeProfileList       = "profile_list"
+	TypeProfileListResult = "profile_list_result"
+
+	// History encryption at rest (see --encrypt-history)
+	TypeStorageEncryptNow      = "storage_encrypt_now"
+	TypeStorageEncryptProgress = "storage_encrypt_progress"
+	TypeStorageEncryptResult   = "storage_encrypt_result"
+
+	// Saving buffered history now rather than at the next persist
+	TypeStorageFlush       = "storage_flush"
+	TypeStorageFlushResult = "storage_flush_result"
+
+	// Confirmation of destructive requests
+	TypeConfirmationChallenge = "confirmation_challenge"
+
 	// Error
 	TypeError = "error"
 )
@@ -97,22 +231,52 @@ const (
 // AllMessageTypes returns all message type constants for alignment testing
 func AllMessageTypes() []string {
 	return []string{
-		TypeAuth, TypeAuthResult,
+		TypeAuth, TypeAuthResult, TypeSessionRefreshToken, TypeSessionRefreshTokenResult,
+		TypeSessionInviteCreate, TypeSessionInviteCreateResult, TypeSessionInviteRevoke, TypeSessionInviteRevokeResult,
+		TypeSessionInfo, TypeSessionInfoResult,
 		TypeHostConfigList, TypeHostConfigListResult, TypeHostConfigCreate, TypeHostConfigCreateResult,
 		TypeHostConfigUpdate, TypeHostConfigUpdateResult, TypeHostConfigDelete, TypeHostConfigDeleteResult,
-		TypeHostConnect, TypeHostDisconnect, TypeHostStatus, TypeHostCheckRequirements, TypeHostRequirementsResult,
+		TypeHostConfigImportSSHConfig, TypeHostConfigImportSSHConfigResult,
+		TypeHostConnect, TypeHostDisconnect, TypeHostStatus, TypeHostStatusRequest, TypeHostCheckRequirements, TypeHostRequirementsResult,
+		TypeHostConnectProgress, TypeHostExec, TypeHostExecResult,
+		TypeHostDiagnostics, TypeHostDiagnosticsResult,
 		TypeProcessList, TypeProcessListResult, TypeProcessCreate, TypeProcessCreated,
 		TypeProcessSelect, TypeProcessKill, TypeProcessKilled, TypeProcessUpdated, TypeProcessReattach, TypeProcessRename,
-		TypeClaudeStart, TypeClaudeKill,
-		TypePtyInput, TypePtyOutput, TypePtyResize,
+		TypeProcessAdded, TypeProcessRemoved, TypeStaleProcessesChanged, TypeProcessCWDChanged,
+		TypeProcessClone, TypeProcessPin, TypeProcessSetOrder, TypeProcessTermOptions, TypeProcessClearError,
+		TypeProcessSetAppearance,
+		TypeArchivedProcessList, TypeArchivedProcessListResult, TypeArchivedProcessGet, TypeArchivedProcessGetResult,
+		TypeArchivedProcessDelete, TypeArchivedProcessDeleteResult,
+		TypeProcessEnableTimeline, TypeProcessTimelineList, TypeProcessTimelineListResult,
+		TypeProcessesSubscribe, TypeProcessesUnsubscribe, TypeProcessAlert,
+		TypeClaudeStart, TypeClaudeKill, TypeClaudeReattachTerminal,
+		TypePtyInput, TypePtyOutput, TypePtyResize, TypePtySnapshot,
+		TypePtyScroll, TypePtyScrollState,
 		TypePtyHistoryRequest, TypePtyHistoryResponse, TypePtyHistoryChunk, TypePtyHistoryComplete,
-		TypeChatSubscribe, TypeChatUnsubscribe, TypeChatSend, TypeChatRaw,
+		TypeChatSubscribe, TypeChatSubscribeResult, TypeChatUnsubscribe, TypeChatSend, TypeChatSendResult, TypeChatRaw,
 		TypeChatEvent, TypeChatStatus, TypeChatStatusResult, TypeChatHistory, TypeChatMessages,
-		TypeEnvList, TypeEnvUpdate, TypeEnvResult, TypeEnvSetRcFile,
+		TypeChatSearch, TypeChatSearchResult, TypeChatUsage, TypeChatUsageResult,
+		TypeChatDraftSet, TypeChatDraftGet, TypeChatDraftResult,
+		TypeEnvList, TypeEnvUpdate, TypeEnvResult, TypeEnvSetRcFile, TypeEnvReveal, TypeEnvRevealResult,
 		TypeProcessEnvList, TypeProcessEnvResult,
 		TypePortsScan, TypePortsResult,
+		TypeFileDownload, TypeFileDownloadChunk, TypeFileDownloadComplete, TypeFileDownloadCancel,
+		TypeFileUploadBegin, TypeFileUploadChunk, TypeFileUploadCommit, TypeFileUploadResult,
 		TypeSnippetList, TypeSnippetListResult, TypeSnippetCreate, TypeSnippetCreateResult,
 		TypeSnippetUpdate, TypeSnippetUpdateResult, TypeSnippetDelete, TypeSnippetDeleteResult,
+		TypeSnippetExecute, TypeSnippetExecuteResult,
+		TypeWorkspaceList, TypeWorkspaceListResult, TypeWorkspaceCreate, TypeWorkspaceCreateResult,
+		TypeWorkspaceUpdate, TypeWorkspaceUpdateResult, TypeWorkspaceDelete, TypeWorkspaceDeleteResult,
+		TypeWorkspaceAssign, TypeWorkspaceAssignResult,
+		TypeProcessTemplateList, TypeProcessTemplateListResult, TypeProcessTemplateCreate, TypeProcessTemplateCreateResult,
+		TypeProcessTemplateUpdate, TypeProcessTemplateUpdateResult, TypeProcessTemplateDelete, TypeProcessTemplateDeleteResult,
+		TypeProcessCreateFromTemplate, TypeProcessCreateFromTemplateResult,
+		TypeBridgeInfo, TypeBridgeInfoResult,
+		TypeBridgeUpdateCheck, TypeBridgeUpdateCheckResult, TypeBridgeUpdateAvailable,
+		TypeProfileList, TypeProfileListResult,
+		TypeStorageEncryptNow, TypeStorageEncryptProgress, TypeStorageEncryptResult,
+		TypeStorageFlush, TypeStorageFlushResult,
+		TypeConfirmationChallenge,
 		TypeError,
 	}
 }
@@ -154,17 +318,63 @@ const (
 
 // ProcessInfo represents a running process
 type ProcessInfo struct {
-	ID            string      `json:"id"`
-	Type          ProcessType `json:"type"`
-	HostID        string      `json:"hostId"`
-	Port          *int        `json:"port,omitempty"`
-	CWD           string      `json:"cwd"`
-	Name          *string     `json:"name,omitempty"` // Custom user-defined name
-	PtyReady      bool        `json:"ptyReady"`
-	AgentAPIReady bool        `json:"agentApiReady"`
-	StartedAt     string      `json:"startedAt"` // ISO timestamp
-	ShellPID      *int        `json:"shellPid,omitempty"`
-	AgentAPIPID   *int        `json:"agentApiPid,omitempty"`
+	ID            string            `json:"id"`
+	Type          ProcessType       `json:"type"`
+	HostID        string            `json:"hostId"`
+	Port          *int              `json:"port,omitempty"`
+	CWD           string            `json:"cwd"`
+	ClaudeCWD     string            `json:"claudeCwd,omitempty"` // CWD when Claude was started; unlike cwd it does not follow cd
+	Name          *string           `json:"name,omitempty"`      // Custom user-defined name
+	PtyReady      bool              `json:"ptyReady"`
+	AgentAPIReady bool              `json:"agentApiReady"`
+	StartedAt     string            `json:"startedAt"` // ISO timestamp
+	ShellPID      *int              `json:"shellPid,omitempty"`
+	AgentAPIPID   *int              `json:"agentApiPid,omitempty"`
+	WorkspaceID   *string           `json:"workspaceId,omitempty"`
+	Pinned        bool              `json:"pinned"`                // Listed before unpinned processes
+	SortWeight    int               `json:"sortWeight"`            // Position among the host's processes, from 0
+	TermOptions   map[string]string `json:"termOptions,omitempty"` // tmux options of the session; absent ones follow the host's tmux config
+	Timeline      bool              `json:"timeline"`              // Commands run in the shell are recorded (see process_enable_timeline)
+	Exited        bool              `json:"exited,omitempty"`      // The tmux session is gone (it exited or the tmux server died); only process_kill applies
+	LastError     *ProcessError     `json:"lastError,omitempty"`   // The last failure on the process, until it is fixed or dismissed
+	AgentStatus   *string           `json:"agentStatus,omitempty"` // Claude's AgentAPI status, "running" or "stable"; absent when unknown
+	Color         string            `json:"color,omitempty"`       // Set by process_set_appearance, see AppearanceColors
+	Icon          string            `json:"icon,omitempty"`        // Set by process_set_appearance, see AppearanceIcons
+	Stats         *ProcessStats     `json:"stats,omitempty"`       // Only in process_list results asked for with includeStats
+
+	// RetainFullHistory exempts the process's PTY history from the bridge's
+	// per-process cap; set by process_set_appearance. HistorySize is the
+	// PTY history stored for it in bytes, only in process_list results
+	// asked for with includeStats.
+	RetainFullHistory bool   `json:"retainFullHistory,omitempty"`
+	HistorySize       *int64 `json:"historySize,omitempty"`
+
+	// TerminalAttached says whether a Claude process's terminal shows Claude
+	// (agentapi attach in the foreground of the pane) or dropped back to
+	// its shell, see claude_reattach_terminal; absent for shells
+	TerminalAttached *bool `json:"terminalAttached,omitempty"`
+
+	// StartupHooks are the commands typed into the shell when it was
+	// created: its host's, then its template's
+	StartupHooks []string `json:"startupHooks,omitempty"`
+}
+
+// ProcessError is a failure on a process, kept until the operation that
+// failed next succeeds or the user dismisses it with process_clear_error
+type ProcessError struct {
+	Operation string    `json:"operation"` // "pty_write", "pty_resize", "agent_events", "agent_status", "claude_start" or "claude_kill"
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	At        string    `json:"at"` // ISO timestamp
+}
+
+// ProcessStats counts the bytes a process moved through the bridge since the
+// bridge started
+type ProcessStats struct {
+	PtyOutputBytes uint64 `json:"ptyOutputBytes"` // pty_output and pty_snapshot sent to clients
+	PtyInputBytes  uint64 `json:"ptyInputBytes"`  // pty_input received from clients
+	ChatEventBytes uint64 `json:"chatEventBytes"` // chat_event data forwarded to clients
+	HistoryBytes   uint64 `json:"historyBytes"`   // PTY history served to clients
 }
 
 // StaleProcess represents a detected but not connected process
@@ -177,20 +387,167 @@ type StaleProcess struct {
 	StartedAt   *string `json:"startedAt,omitempty"`   // When the session was created
 }
 
+// UnmanagedSession is a tmux session with the bridge's rc- prefix whose name
+// doesn't embed a valid process ID. It is reported but can't be reattached.
+type UnmanagedSession struct {
+	TmuxSession string  `json:"tmuxSession"`
+	StartedAt   *string `json:"startedAt,omitempty"` // When the session was created
+}
+
 // ============================================================================
 // Authentication Payloads
 // ============================================================================
 
 type AuthPayload struct {
-	ReconnectToken *string `json:"reconnectToken,omitempty"` // Optional token for reconnection
-}
+	// Protocol the client speaks; a client that doesn't say speaks version 1
+	ProtocolVersion *int    `json:"protocolVersion,omitempty"`
+	ReconnectToken  *string `json:"reconnectToken,omitempty"`           // Optional token for reconnection
+	Token           *string `json:"token,omitempty"`                    // Bridge auth token (required if the bridge has one)
+	Compression     bool    `json:"compression,omitempty"`              // Opt into permessage-deflate (if negotiated)
+	ClientTimestamp *int64  `json:"clientTimestamp,omitempty"`          // Client's clock (ms since epoch), to measure skew
+	Locale          *string `json:"locale,omitempty" validate:"max=35"` // BCP 47 tag (e.g. "he-IL") error messages are sent in
+	// Terminal size for processes this device creates or reattaches without
+	// cols/rows, and restores its selected processes to on reconnect
+	DefaultCols *int    `json:"defaultCols,omitempty" validate:"min=10,max=1000"`
+	DefaultRows *int    `json:"defaultRows,omitempty" validate:"min=10,max=1000"`
+	DeviceLabel *string `json:"deviceLabel,omitempty" validate:"max=64"` // Names the device in the bridge's logs (e.g. "phone")
+	// An invite from session_invite_create, in place of the auth token; the
+	// session gets the invite's role and scope
+	InviteToken *string `json:"inviteToken,omitempty"`
+	// Ask for less than the credentials grant, e.g. "observer" to watch
+	// without being able to type
+	Role *string `json:"role,omitempty" validate:"oneof=observer owner"`
+	// Optional behaviors the client understands (see the Capability
+	// constants); ones the bridge doesn't know are ignored
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// CapabilityServerTimestamps asks for the bridge's clock on pty_output and
+// chat_event, as serverTs, to measure one-way delay with clockSkewMs
+const CapabilityServerTimestamps = "server_timestamps"
 
 type AuthResultPayload struct {
-	Success        bool    `json:"success"`
-	SessionID      *string `json:"sessionId,omitempty"`
-	ReconnectToken *string `json:"reconnectToken,omitempty"` // Token to use for reconnection
-	Reconnected    bool    `json:"reconnected"`              // Whether this was a reconnection
-	Error          *string `json:"error,omitempty"`
+	Success         bool    `json:"success"`
+	SessionID       *string `json:"sessionId,omitempty"`
+	ReconnectToken  *string `json:"reconnectToken,omitempty"` // Token to use for reconnection
+	TokenExpiresAt  *string `json:"tokenExpiresAt,omitempty"` // ISO timestamp; see SessionRefreshTokenResultPayload
+	Reconnected     bool    `json:"reconnected"`              // Whether this was a reconnection
+	Compression     bool    `json:"compression,omitempty"`    // Whether outgoing frames may be compressed
+	ServerVersion   string  `json:"serverVersion"`            // Bridge build version
+	ProtocolVersion int     `json:"protocolVersion"`
+	Profile         string  `json:"profile"`                   // Data profile the bridge is serving
+	ServerTimestamp int64   `json:"serverTimestamp,omitempty"` // Bridge clock (ms since epoch) when auth was handled
+	ClockSkewMs     *int64  `json:"clockSkewMs,omitempty"`     // Bridge clock minus the client's, transit time included; when clientTimestamp was sent
+	Locale          string  `json:"locale"`                    // Catalog locale error messages are sent in, the closest to the requested one
+	// What the session may do: "owner", or "observer", which gets FORBIDDEN
+	// for requests that change anything. Scope is set when an invite
+	// limited the session to one host or process.
+	Role  string        `json:"role,omitempty"`
+	Scope *SessionScope `json:"scope,omitempty"`
+	// The requested capabilities the bridge enabled for the session
+	Capabilities []string  `json:"capabilities,omitempty"`
+	Code         ErrorCode `json:"code,omitempty"`
+	Error        *string   `json:"error,omitempty"`
+}
+
+// SessionScope limits a session to one host, or one process on it
+type SessionScope struct {
+	HostID    *string `json:"hostId,omitempty"`
+	ProcessID *string `json:"processId,omitempty"`
+}
+
+// SessionRefreshTokenPayload asks for a new reconnect token in place of the
+// current one, which stops working
+type SessionRefreshTokenPayload struct {
+	ReconnectToken string `json:"reconnectToken" validate:"required"` // The session's current token
+}
+
+// SessionRefreshTokenResultPayload carries the new reconnect token. Tokens
+// work for as long as the bridge keeps running; TokenExpiresAt is when the
+// token stops surviving bridge restarts, so clients should refresh before
+// then. Refreshing fails when the token is no longer current.
+type SessionRefreshTokenResultPayload struct {
+	Success        bool      `json:"success"`
+	ReconnectToken *string   `json:"reconnectToken,omitempty"`
+	TokenExpiresAt *string   `json:"tokenExpiresAt,omitempty"` // ISO timestamp
+	Code           ErrorCode `json:"code,omitempty"`
+	Error          *string   `json:"error,omitempty"`
+}
+
+// SessionInviteCreatePayload mints an invite token another client can
+// authenticate with instead of the bridge's auth token, e.g. to let a
+// coworker watch a session
+type SessionInviteCreatePayload struct {
+	Role             *string `json:"role,omitempty" validate:"oneof=observer owner"` // Default "observer"
+	HostID           *string `json:"hostId,omitempty"`                               // Limit the invite to one host
+	ProcessID        *string `json:"processId,omitempty"`                            // Limit the invite to one process
+	ExpiresInSeconds *int    `json:"expiresInSeconds,omitempty" validate:"min=60"`   // The invite lasts until revoked without one
+	Label            *string `json:"label,omitempty" validate:"max=64"`              // Who the invite is for
+}
+
+// SessionInvite describes an invite. The token itself is only ever sent in
+// the create result; the bridge keeps a hash of it.
+type SessionInvite struct {
+	ID        string  `json:"id"`
+	Role      string  `json:"role"`
+	HostID    *string `json:"hostId,omitempty"`
+	ProcessID *string `json:"processId,omitempty"`
+	Label     *string `json:"label,omitempty"`
+	CreatedAt string  `json:"createdAt"`           // ISO timestamp
+	ExpiresAt *string `json:"expiresAt,omitempty"` // ISO timestamp
+}
+
+type SessionInviteCreateResultPayload struct {
+	Success bool           `json:"success"`
+	Invite  *SessionInvite `json:"invite,omitempty"`
+	Token   *string        `json:"token,omitempty"` // For the invited client's AuthPayload.InviteToken
+	Code    ErrorCode      `json:"code,omitempty"`
+	Error   *string        `json:"error,omitempty"`
+}
+
+// SessionInviteRevokePayload revokes an invite. Sessions authenticated with
+// it are disconnected.
+type SessionInviteRevokePayload struct {
+	ID string `json:"id" validate:"required"`
+}
+
+type SessionInviteRevokeResultPayload struct {
+	Success      bool      `json:"success"`
+	ID           string    `json:"id"`
+	Disconnected int       `json:"disconnected"` // Sessions that were using the invite
+	Code         ErrorCode `json:"code,omitempty"`
+	Error        *string   `json:"error,omitempty"`
+}
+
+type SessionInfoPayload struct {
+	// empty - no params needed
+}
+
+// SessionInfoResultPayload describes the requesting session, and for an
+// owner every connected one, with the latency the bridge measured to each
+type SessionInfoResultPayload struct {
+	Session  ConnectedSessionInfo   `json:"session"`
+	Sessions []ConnectedSessionInfo `json:"sessions,omitempty"` // Every connected session; owners only
+}
+
+// ConnectedSessionInfo is a session connected to the bridge
+type ConnectedSessionInfo struct {
+	ID          string          `json:"id"`
+	DeviceLabel string          `json:"deviceLabel,omitempty"`
+	Role        string          `json:"role"`
+	CreatedAt   string          `json:"createdAt"`         // ISO timestamp
+	LastSeenAt  string          `json:"lastSeenAt"`        // ISO timestamp of the last message from the client
+	Latency     *SessionLatency `json:"latency,omitempty"` // Absent until a ping was answered
+}
+
+// SessionLatency is the round-trip time between the bridge and a client,
+// measured by the bridge's WebSocket pings
+type SessionLatency struct {
+	RTTMs      float64 `json:"rttMs"`     // Moving average of the round trips
+	LastRTTMs  float64 `json:"lastRttMs"` // The last round trip
+	MinRTTMs   float64 `json:"minRttMs"`
+	Samples    int     `json:"samples"`
+	MeasuredAt string  `json:"measuredAt"` // ISO timestamp of the last pong
 }
 
 // ============================================================================
@@ -199,15 +556,24 @@ type AuthResultPayload struct {
 
 // SSHHostConfig represents a stored SSH host configuration
 type SSHHostConfig struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	Host        string `json:"host"`
-	Port        int    `json:"port"`
-	Username    string `json:"username"`
-	AuthType    string `json:"authType"` // "password" or "key"
-	AutoConnect bool   `json:"autoConnect"`
-	CreatedAt   string `json:"createdAt"` // ISO timestamp
-	UpdatedAt   string `json:"updatedAt"` // ISO timestamp
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	Host              string `json:"host"`
+	Port              int    `json:"port"`
+	Username          string `json:"username"`
+	AuthType          string `json:"authType"`          // "password", "key" or "agent"
+	CredentialBackend string `json:"credentialBackend"` // Where the credential lives: "sqlite", "exec" or "keychain"
+	AutoConnect       bool   `json:"autoConnect"`
+	TmuxSocketPath    string `json:"tmuxSocketPath,omitempty"`   // tmux server socket (tmux -S); the user's default server when empty
+	TmuxCommand       string `json:"tmuxCommand,omitempty"`      // Program run instead of tmux; tmux from the PATH when empty
+	MaxConcurrentOps  int    `json:"maxConcurrentOps,omitempty"` // Remote operations run at once on the host; the bridge's limit when 0
+	Color             string `json:"color,omitempty"`            // See AppearanceColors
+	Icon              string `json:"icon,omitempty"`             // See AppearanceIcons
+	CreatedAt         string `json:"createdAt"`                  // ISO timestamp
+	UpdatedAt         string `json:"updatedAt"`                  // ISO timestamp
+	// Commands typed into every new shell on the host, in order, before its
+	// env is captured; see process_create's skipHooks
+	StartupHooks []string `json:"startupHooks,omitempty"`
 	// Note: credentials are NOT included in responses for security
 }
 
@@ -220,46 +586,108 @@ type HostConfigListResultPayload struct {
 }
 
 type HostConfigCreatePayload struct {
-	Name        string  `json:"name"`
-	Host        string  `json:"host"`
-	Port        int     `json:"port"`
-	Username    string  `json:"username"`
-	AuthType    string  `json:"authType"`   // "password" or "key"
-	Credential  string  `json:"credential"` // password or private key
-	AutoConnect *bool   `json:"autoConnect,omitempty"`
+	Name        string `json:"name" validate:"required"`
+	Host        string `json:"host" validate:"required"`
+	Port        int    `json:"port" validate:"required,min=1,max=65535"`
+	Username    string `json:"username" validate:"required"`
+	AuthType    string `json:"authType" validate:"required,oneof=password key agent"` // "password", "key" or "agent"
+	Credential  string `json:"credential"`                                            // password or private key; empty for agent
+	AutoConnect *bool  `json:"autoConnect,omitempty"`
+	Color       string `json:"color,omitempty" validate:"color"`
+	Icon        string `json:"icon,omitempty" validate:"icon"`
 }
 
 type HostConfigCreateResultPayload struct {
 	Success bool           `json:"success"`
 	Host    *SSHHostConfig `json:"host,omitempty"`
+	Code    ErrorCode      `json:"code,omitempty"`
 	Error   *string        `json:"error,omitempty"`
 }
 
+// HostConfigImportSSHConfigPayload reads an OpenSSH client config: the
+// bridge machine's ~/.ssh/config, or ConfigText when set. Without Select it
+// only lists the config's hosts; with it, host configs are created for the
+// selected ones.
+type HostConfigImportSSHConfigPayload struct {
+	ConfigText *string  `json:"configText,omitempty"` // Config uploaded by the client; its Include directives aren't followed
+	Select     []string `json:"select,omitempty"`     // Aliases to create host configs for
+	// The user agreed to the bridge reading the selected hosts' identity
+	// files into their host configs. Hosts that need one fail without it.
+	ReadIdentityFiles bool  `json:"readIdentityFiles,omitempty"`
+	AutoConnect       *bool `json:"autoConnect,omitempty"` // For the created host configs
+}
+
+// SSHConfigEntry is a host of an ssh_config, as it would be imported
+type SSHConfigEntry struct {
+	Alias        string  `json:"alias"` // Becomes the host config's name
+	HostName     string  `json:"hostName"`
+	User         string  `json:"user"` // The bridge's user when the config sets none
+	Port         int     `json:"port"`
+	AuthType     string  `json:"authType"`               // "key" with an identity file, else "agent"
+	IdentityFile *string `json:"identityFile,omitempty"` // The first one found on the bridge machine
+	ProxyJump    *string `json:"proxyJump,omitempty"`    // Host configs can't jump, so they connect directly
+	Exists       bool    `json:"exists"`                 // A host config with this name exists and isn't imported again
+}
+
+// SSHConfigImportFailure is a selected host that couldn't be imported
+type SSHConfigImportFailure struct {
+	Alias string `json:"alias"`
+	Error string `json:"error"`
+}
+
+type HostConfigImportSSHConfigResultPayload struct {
+	Success  bool                     `json:"success"`
+	Entries  []SSHConfigEntry         `json:"entries"`
+	Created  []SSHHostConfig          `json:"created,omitempty"`
+	Failed   []SSHConfigImportFailure `json:"failed,omitempty"`
+	Warnings []string                 `json:"warnings,omitempty"` // Config lines that were skipped, and why
+	Code     ErrorCode                `json:"code,omitempty"`
+	Error    *string                  `json:"error,omitempty"`
+}
+
 type HostConfigUpdatePayload struct {
-	ID          string  `json:"id"`
-	Name        *string `json:"name,omitempty"`
-	Host        *string `json:"host,omitempty"`
-	Port        *int    `json:"port,omitempty"`
-	Username    *string `json:"username,omitempty"`
-	AuthType    *string `json:"authType,omitempty"`
+	ID          string  `json:"id" validate:"required"`
+	Name        *string `json:"name,omitempty" validate:"min=1"`
+	Host        *string `json:"host,omitempty" validate:"min=1"`
+	Port        *int    `json:"port,omitempty" validate:"min=1,max=65535"`
+	Username    *string `json:"username,omitempty" validate:"min=1"`
+	AuthType    *string `json:"authType,omitempty" validate:"oneof=password key agent"`
 	Credential  *string `json:"credential,omitempty"` // only set if changing credential
 	AutoConnect *bool   `json:"autoConnect,omitempty"`
+	// The tmux server the bridge uses on the host, for an account shared by
+	// several users; "" goes back to the default. Sessions already running
+	// keep theirs.
+	TmuxSocketPath *string `json:"tmuxSocketPath,omitempty"`
+	TmuxCommand    *string `json:"tmuxCommand,omitempty"`
+	// How many remote operations (commands and tunnel dials) the bridge
+	// runs at once on the host, for small devices; 0 goes back to the
+	// bridge's limit. Applies to a connected host right away.
+	MaxConcurrentOps *int `json:"maxConcurrentOps,omitempty" validate:"min=0,max=64"`
+	// Replaces the host's startup hooks, one command per entry; an empty
+	// list clears them. Applies to shells created after it.
+	StartupHooks *[]string `json:"startupHooks,omitempty" validate:"max=20"`
+	// How the host is shown; "" clears it
+	Color *string `json:"color,omitempty" validate:"color"`
+	Icon  *string `json:"icon,omitempty" validate:"icon"`
 }
 
 type HostConfigUpdateResultPayload struct {
 	Success bool           `json:"success"`
 	Host    *SSHHostConfig `json:"host,omitempty"`
+	Code    ErrorCode      `json:"code,omitempty"`
 	Error   *string        `json:"error,omitempty"`
 }
 
 type HostConfigDeletePayload struct {
-	ID string `json:"id"`
+	ID string `json:"id" validate:"required"`
+	Confirmation
 }
 
 type HostConfigDeleteResultPayload struct {
-	Success bool    `json:"success"`
-	ID      *string `json:"id,omitempty"`
-	Error   *string `json:"error,omitempty"`
+	Success bool      `json:"success"`
+	ID      *string   `json:"id,omitempty"`
+	Code    ErrorCode `json:"code,omitempty"`
+	Error   *string   `json:"error,omitempty"`
 }
 
 // ============================================================================
@@ -267,12 +695,34 @@ type HostConfigDeleteResultPayload struct {
 // ============================================================================
 
 type HostConnectPayload struct {
-	HostID string `json:"hostId"`
+	HostID string `json:"hostId" validate:"required"`
 	// No credentials needed - bridge has them stored
+	WantProgress bool `json:"wantProgress,omitempty"` // Stream host_connect_progress before HOST_STATUS
+}
+
+// HostConnectStage is a step of connecting to a host
+type HostConnectStage string
+
+const (
+	HostConnectSSHHandshake         HostConnectStage = "ssh_handshake"
+	HostConnectScanningTmux         HostConnectStage = "scanning_tmux"
+	HostConnectReattaching          HostConnectStage = "reattaching"
+	HostConnectScanningPorts        HostConnectStage = "scanning_ports"
+	HostConnectCheckingRequirements HostConnectStage = "checking_requirements"
+)
+
+// HostConnectProgressPayload reports that a host connect reached a stage.
+// Sent before the final HOST_STATUS, only when the connect asked for it.
+type HostConnectProgressPayload struct {
+	HostID  string           `json:"hostId"`
+	Stage   HostConnectStage `json:"stage"`
+	Found   *int             `json:"found,omitempty"`   // scanning_tmux: tmux sessions found
+	Current *int             `json:"current,omitempty"` // reattaching: processes finished, reattached or not (1-based)
+	Total   *int             `json:"total,omitempty"`   // reattaching: processes to reattach
 }
 
 type HostDisconnectPayload struct {
-	HostID string `json:"hostId"`
+	HostID string `json:"hostId" validate:"required"`
 }
 
 // HostRequirements represents the installation status of required tools
@@ -281,34 +731,222 @@ type HostRequirements struct {
 	ClaudePath        *string `json:"claudePath,omitempty"`
 	AgentAPIInstalled bool    `json:"agentApiInstalled"`
 	AgentAPIPath      *string `json:"agentApiPath,omitempty"`
-	CheckedAt         string  `json:"checkedAt"` // ISO timestamp
+	TmuxTmpDir        *string `json:"tmuxTmpDir,omitempty"` // TMUX_TMPDIR, when it moves tmux's sockets out of /tmp
+	CheckedAt         string  `json:"checkedAt"`            // ISO timestamp
+}
+
+// HostPlatform is what a host runs, read once per connection and recorded
+// with the host
+type HostPlatform struct {
+	OS          string  `json:"os"`                    // uname -s: "Linux", "Darwin", ...
+	Arch        string  `json:"arch"`                  // uname -m: "x86_64", "arm64", ...
+	Release     *string `json:"release,omitempty"`     // e.g. "Ubuntu 22.04.4 LTS" or "macOS 14.5"
+	Shell       *string `json:"shell,omitempty"`       // The login shell
+	TmuxVersion *string `json:"tmuxVersion,omitempty"` // Unset when tmux isn't installed
+	HasProc     bool    `json:"hasProc"`               // Whether /proc is mounted
 }
 
 type HostStatusPayload struct {
-	HostID         string            `json:"hostId"`
-	Connected      bool              `json:"connected"`
-	Processes      []ProcessInfo     `json:"processes"`
-	StaleProcesses *[]StaleProcess   `json:"staleProcesses,omitempty"`
-	Error          *string           `json:"error,omitempty"`
-	Requirements   *HostRequirements `json:"requirements,omitempty"`
+	HostID         string                `json:"hostId"`
+	Connected      bool                  `json:"connected"`
+	Processes      []ProcessInfo         `json:"processes"`
+	StaleProcesses *[]StaleProcess       `json:"staleProcesses,omitempty"`
+	Code           ErrorCode             `json:"code,omitempty"`
+	Error          *string               `json:"error,omitempty"`
+	Requirements   *HostRequirements     `json:"requirements,omitempty"` // Last found; fresh ones follow as host_requirements_result
+	Reason         *HostDisconnectReason `json:"reason,omitempty"`       // Set when a connected host became disconnected
+	// rc-* tmux sessions found by the host_connect scan that the bridge
+	// didn't create
+	UnmanagedSessions []UnmanagedSession `json:"unmanagedSessions,omitempty"`
+	Warnings          []HostWarning      `json:"warnings,omitempty"`
+	Channels          *SSHChannelUsage   `json:"channels,omitempty"` // Set while connected
+	// Set by host_connect when the host rebooted since the bridge last
+	// connected to it: its tmux sessions are gone, and the processes the
+	// bridge knew of were dropped instead of reattached
+	RebootDetected   bool    `json:"rebootDetected,omitempty"`
+	BootTime         *string `json:"bootTime,omitempty"`         // ISO timestamp; set by host_connect when readable
+	PreviousBootTime *string `json:"previousBootTime,omitempty"` // ISO timestamp; set with rebootDetected
+	// Set by host_connect when scans were still running at its deadline:
+	// scanning_tmux, scanning_ports and/or checking_requirements. What they
+	// would have found is missing from the other fields.
+	TimedOut []HostConnectStage `json:"timedOut,omitempty"`
+	// Set when processes were reattached for this status: by host_connect,
+	// and after an auth that found PTYs detached
+	Reattach *ReattachReport `json:"reattach,omitempty"`
+	// Set while connected, once read; from the last connect before then
+	Platform *HostPlatform `json:"platform,omitempty"`
+}
+
+// ReattachReport is the outcome of reattaching a host's processes. Retried
+// processes were refused for the host's session limits at first; they are
+// listed again under reattached or failed.
+type ReattachReport struct {
+	Reattached []string          `json:"reattached"`
+	Retried    []string          `json:"retried,omitempty"`
+	Failed     []ReattachFailure `json:"failed,omitempty"`
+}
+
+type ReattachFailure struct {
+	ProcessID string `json:"processId"`
+	Error     string `json:"error"`
+}
+
+// HostDisconnectReason explains why a host transitioned to disconnected
+type HostDisconnectReason string
+
+const (
+	HostDisconnectUserDisconnect        HostDisconnectReason = "user_disconnect"
+	HostDisconnectKeepaliveFailed       HostDisconnectReason = "keepalive_failed"
+	HostDisconnectAuthFailedOnReconnect HostDisconnectReason = "auth_failed_on_reconnect"
+)
+
+// HostWarningCode says what a HostWarning is about
+type HostWarningCode string
+
+const (
+	// The host reaches the same machine as other connected hosts
+	// (DuplicateOf). Its scan skips tmux sessions they already own.
+	HostWarningDuplicate HostWarningCode = "HOST_DUPLICATE"
+	// The host's tmux server is gone, and with it every process on the
+	// host, which are all reported exited
+	HostWarningTmuxServerGone HostWarningCode = "TMUX_SERVER_GONE"
+)
+
+// HostWarning is a problem with a host connection that didn't stop it
+// from connecting
+type HostWarning struct {
+	Code        HostWarningCode `json:"code"`
+	Message     string          `json:"message"`
+	DuplicateOf []string        `json:"duplicateOf,omitempty"` // Host IDs, for HOST_DUPLICATE
+}
+
+// SSHChannelUsage reports the channels (PTY attaches, exec sessions and
+// AgentAPI tunnels) open on a host's SSH connections. Once the primary
+// connection nears the limit, tunnels move to secondary connections.
+type SSHChannelUsage struct {
+	Open              int `json:"open"`              // On the primary connection
+	Limit             int `json:"limit"`             // Allowed per connection, lowered when the server rejects a channel
+	TunnelConnections int `json:"tunnelConnections"` // Secondary connections carrying tunnels
+	TunnelOpen        int `json:"tunnelOpen"`        // Channels open on the secondary connections
+}
+
+// HostStatusRequestPayload asks for a full host_status of a connected host.
+// The bridge sends one on host_connect and on reconnect; in between, changes
+// arrive as process_added, process_removed, process_updated and
+// stale_processes_changed.
+type HostStatusRequestPayload struct {
+	HostID string `json:"hostId" validate:"required"`
 }
 
 type HostCheckRequirementsPayload struct {
-	HostID string `json:"hostId"`
+	HostID string `json:"hostId" validate:"required"`
 }
 
 type HostRequirementsResultPayload struct {
 	HostID       string           `json:"hostId"`
 	Requirements HostRequirements `json:"requirements"`
+	Code         ErrorCode        `json:"code,omitempty"`
 	Error        *string          `json:"error,omitempty"`
 }
 
+// HostExecPayload runs a one-off command on a connected host, outside any
+// process. The command runs under sh with stdin closed.
+type HostExecPayload struct {
+	RequestID string  `json:"requestId,omitempty"` // Echoed in the result, to match concurrent commands
+	HostID    string  `json:"hostId" validate:"required"`
+	Command   string  `json:"command" validate:"required"`
+	TimeoutMs *int    `json:"timeoutMs,omitempty" validate:"min=1"` // Default 30s, capped by the bridge's maximum
+	CWD       *string `json:"cwd,omitempty"`                        // Default: the login directory
+}
+
+// HostExecResultPayload is the outcome of a host_exec command. Each stream
+// is cut off at the bridge's output cap, with its Truncated flag set.
+type HostExecResultPayload struct {
+	RequestID       string `json:"requestId,omitempty"`
+	HostID          string `json:"hostId"`
+	Command         string `json:"command"`
+	ExitCode        int    `json:"exitCode"` // -1 when killed or timed out
+	TimedOut        bool   `json:"timedOut"`
+	DurationMs      int64  `json:"durationMs"`
+	Stdout          string `json:"stdout"`
+	Stderr          string `json:"stderr"`
+	StdoutTruncated bool   `json:"stdoutTruncated"`
+	StderrTruncated bool   `json:"stderrTruncated"`
+}
+
+// HostDiagnosticsPayload probes the SSH link to a host: the round-trip time
+// of a trivial command and the throughput of a short transfer. A host can be
+// probed once per 10 seconds.
+type HostDiagnosticsPayload struct {
+	HostID string `json:"hostId" validate:"required"`
+	Record bool   `json:"record,omitempty"` // Add the sample to the host's history
+}
+
+// HostDiagnosticsSample is the outcome of one probe. Times are in
+// milliseconds, with fractions.
+type HostDiagnosticsSample struct {
+	At                    string    `json:"at"`    // ISO timestamp
+	RTTMs                 []float64 `json:"rttMs"` // Each trivial command
+	RTTMinMs              float64   `json:"rttMinMs"`
+	RTTAvgMs              float64   `json:"rttAvgMs"`
+	RTTMaxMs              float64   `json:"rttMaxMs"`
+	ThroughputBytes       int64     `json:"throughputBytes"`
+	ThroughputMs          float64   `json:"throughputMs"`
+	ThroughputBytesPerSec float64   `json:"throughputBytesPerSec"` // Less the fixed cost of a command (rttMinMs)
+}
+
+// HostKeepaliveStatus reports the keepalives of a host's connection
+type HostKeepaliveStatus struct {
+	IntervalSeconds int      `json:"intervalSeconds"`
+	LastOkAt        *string  `json:"lastOkAt,omitempty"` // ISO timestamp; absent before the first keepalive
+	LastRTTMs       *float64 `json:"lastRttMs,omitempty"`
+	LastFailure     *string  `json:"lastFailure,omitempty"`   // Why a connection to the host was last lost
+	LastFailureAt   *string  `json:"lastFailureAt,omitempty"` // ISO timestamp
+}
+
+// HostChannelStatus counts the channels open on a host's SSH connections
+type HostChannelStatus struct {
+	Open              int `json:"open"`  // On the primary connection
+	Limit             int `json:"limit"` // Allowed per connection
+	TunnelConnections int `json:"tunnelConnections"`
+	TunnelOpen        int `json:"tunnelOpen"`
+}
+
+// HostOpsStatus counts the remote operations (commands and tunnel dials)
+// running on a host, and those waiting for its limit
+type HostOpsStatus struct {
+	Limit   int `json:"limit"`
+	Running int `json:"running"`
+	Queued  int `json:"queued"`
+}
+
+// HostDiagnosticsResultPayload answers host_diagnostics. The connection
+// status and history are sent even when the probe fails or is refused for
+// running too soon after the last one.
+type HostDiagnosticsResultPayload struct {
+	HostID               string                  `json:"hostId"`
+	Success              bool                    `json:"success"`
+	Code                 ErrorCode               `json:"code,omitempty"`
+	Error                *string                 `json:"error,omitempty"`
+	RetryAfterMs         *int64                  `json:"retryAfterMs,omitempty"` // When the probe was refused by the rate limit
+	Sample               *HostDiagnosticsSample  `json:"sample,omitempty"`
+	ConnectedAt          string                  `json:"connectedAt"` // ISO timestamp
+	ConnectionAgeSeconds int64                   `json:"connectionAgeSeconds"`
+	Keepalive            HostKeepaliveStatus     `json:"keepalive"`
+	Channels             HostChannelStatus       `json:"channels"`
+	Ops                  HostOpsStatus           `json:"ops"`
+	History              []HostDiagnosticsSample `json:"history"` // Recorded samples, oldest first, at most 20
+	Platform             *HostPlatform           `json:"platform,omitempty"`
+}
+
 // ============================================================================
 // Process Management Payloads
 // ============================================================================
 
 type ProcessListPayload struct {
-	HostID string `json:"hostId"`
+	HostID       string `json:"hostId" validate:"required"`
+	ListLight    bool   `json:"listLight,omitempty"`    // Return cached state without querying the host
+	IncludeStats bool   `json:"includeStats,omitempty"` // Include each process's traffic stats
 }
 
 type ProcessListResultPayload struct {
@@ -317,48 +955,302 @@ type ProcessListResultPayload struct {
 }
 
 type ProcessCreatePayload struct {
-	HostID string  `json:"hostId"`
-	CWD    *string `json:"cwd,omitempty"`
-	Cols   *int    `json:"cols,omitempty"`
-	Rows   *int    `json:"rows,omitempty"`
+	HostID   string  `json:"hostId" validate:"required"`
+	CWD      *string `json:"cwd,omitempty"`
+	Cols     *int    `json:"cols,omitempty" validate:"min=10,max=1000"`
+	Rows     *int    `json:"rows,omitempty" validate:"min=10,max=1000"`
+	Timeline bool    `json:"timeline,omitempty"` // Record the command timeline; turned on once the shell is up, with a process_updated
+	// Don't type the host's startup hooks into the new shell
+	SkipHooks bool `json:"skipHooks,omitempty"`
+
+	PtyOutput *PtyOutputOverride `json:"ptyOutput,omitempty"` // How this process's output is read and delivered, e.g. for streaming logs
+}
+
+// PtyOutputOverride changes how one process's PTY output is read and
+// delivered; unset fields keep the bridge's settings (see PtyOutputSettings)
+type PtyOutputOverride struct {
+	ReadBufferSize    *int `json:"readBufferSize,omitempty" validate:"min=512,max=1048576"`
+	CoalesceWindowMs  *int `json:"coalesceWindowMs,omitempty" validate:"min=0,max=500"`
+	MaxCoalescedBytes *int `json:"maxCoalescedBytes,omitempty" validate:"min=1024,max=1048576"`
 }
 
 type ProcessCreatedPayload struct {
-	Process ProcessInfo `json:"process"`
+	Process    ProcessInfo `json:"process"`
+	ClonedFrom *string     `json:"clonedFrom,omitempty"` // Source process, for process_clone
+	Cols       int         `json:"cols,omitempty"`       // Terminal size the PTY was given
+	Rows       int         `json:"rows,omitempty"`
+}
+
+// ProcessClonePayload asks for a new shell process that starts in the source
+// process's working directory with its captured env vars. The source may be
+// live or detached (known only from stored metadata).
+type ProcessClonePayload struct {
+	SourceProcessID string `json:"sourceProcessId" validate:"required"`
+	Cols            *int   `json:"cols,omitempty" validate:"min=10,max=1000"` // Defaults to the source's size
+	Rows            *int   `json:"rows,omitempty" validate:"min=10,max=1000"`
+}
+
+// ProcessPinPayload pins a process to the top of its host's list, or unpins
+// it. Answered with process_updated.
+type ProcessPinPayload struct {
+	ProcessID string `json:"processId" validate:"required"`
+	Pinned    bool   `json:"pinned"`
+}
+
+// ProcessSetOrderPayload reorders a host's processes: the listed ones come
+// first, in order, followed by the rest in their current order. Answered
+// with process_list_result.
+type ProcessSetOrderPayload struct {
+	HostID     string   `json:"hostId" validate:"required"`
+	ProcessIDs []string `json:"processIds"`
+}
+
+// ProcessTermOptionsPayload sets tmux options of a process's session:
+// "status" and "mouse" ("on" or "off") and "history-limit" (lines, only for
+// panes created afterwards). An empty value resets an option to its default.
+// Options not listed keep their value. Answered with process_updated.
+type ProcessTermOptionsPayload struct {
+	ProcessID string            `json:"processId" validate:"required"`
+	Options   map[string]string `json:"options"`
+}
+
+// ProcessClearErrorPayload dismisses a process's last error. Answered with
+// process_updated.
+type ProcessClearErrorPayload struct {
+	ProcessID string `json:"processId" validate:"required"`
+}
+
+// AppearanceColors are the named colors a host or process can be shown with.
+// A color can also be given in hex, as "#rgb" or "#rrggbb".
+var AppearanceColors = []string{
+	"red", "orange", "yellow", "green", "teal", "blue", "indigo", "purple", "pink", "brown", "gray",
+}
+
+// AppearanceIcons are the icons a host or process can be shown with
+var AppearanceIcons = []string{
+	"server", "laptop", "desktop", "cloud", "database", "container", "terminal", "code",
+	"bug", "flask", "rocket", "globe", "lock", "key", "star", "heart", "bolt", "fire",
+}
+
+// ProcessSetAppearancePayload sets the color and icon a process is shown
+// with, and whether its PTY history is exempt from the bridge's history cap.
+// They are stored with the process metadata, so every client sees them and
+// they survive reattach and restarts. An empty value clears one; one that is
+// omitted is left alone. Answered with process_updated.
+type ProcessSetAppearancePayload struct {
+	ProcessID         string  `json:"processId" validate:"required"`
+	Color             *string `json:"color,omitempty" validate:"color"`
+	Icon              *string `json:"icon,omitempty" validate:"icon"`
+	RetainFullHistory *bool   `json:"retainFullHistory,omitempty"`
+}
+
+// ProcessEnableTimelinePayload turns a process's command timeline on or
+// off. Turning it on loads hooks into the shell that mark where each command
+// starts and ends; turning it off removes them. Only bash and zsh are
+// supported, and the shell must be at its prompt. Answered with
+// process_updated.
+type ProcessEnableTimelinePayload struct {
+	ProcessID string `json:"processId" validate:"required"`
+	Disable   bool   `json:"disable,omitempty"`
+}
+
+// ProcessTimelineListPayload asks for a page of the commands run in a
+// process, newest first
+type ProcessTimelineListPayload struct {
+	ProcessID string `json:"processId" validate:"required"`
+	Before    *int64 `json:"before,omitempty"`                 // Only commands older than this one (a TimelineCommand id), for the next page
+	Limit     int    `json:"limit,omitempty" validate:"min=0"` // Maximum commands, default 50, at most 500
+}
+
+// TimelineCommand is a command run in a process's shell
+type TimelineCommand struct {
+	ID        int64   `json:"id"`
+	Command   string  `json:"command"`           // Empty when the shell didn't report it
+	StartedAt string  `json:"startedAt"`         // ISO timestamp
+	EndedAt   *string `json:"endedAt,omitempty"` // Absent while running, or if the end was missed
+	ExitCode  *int    `json:"exitCode,omitempty"`
+}
+
+type ProcessTimelineListResultPayload struct {
+	ProcessID string            `json:"processId"`
+	Commands  []TimelineCommand `json:"commands"`
+	HasMore   bool              `json:"hasMore"` // Older commands remain; ask with before set to the last id
+	Code      ErrorCode         `json:"code,omitempty"`
+	Error     *string           `json:"error,omitempty"`
 }
 
 type ProcessSelectPayload struct {
-	ProcessID string `json:"processId"`
+	ProcessID string `json:"processId" validate:"required"`
 }
 
+// ProcessKillPayload kills a process. Its history is kept, archived under
+// its ID (see ArchivedProcess), unless keepHistory is false.
 type ProcessKillPayload struct {
-	ProcessID string `json:"processId"`
+	ProcessID   string `json:"processId" validate:"required"`
+	KeepHistory *bool  `json:"keepHistory,omitempty"` // true when omitted
+	Confirmation
 }
 
 type ProcessKilledPayload struct {
 	ProcessID string `json:"processId"`
+	Archived  bool   `json:"archived,omitempty"` // Its history was kept
+}
+
+// ProcessAddedPayload is a process that joined a host's list without a
+// process_create, i.e. a reattached one
+type ProcessAddedPayload struct {
+	HostID  string      `json:"hostId"`
+	Process ProcessInfo `json:"process"`
+	// Set when the reattach found the tmux session had been recreated and
+	// dropped the stored port, type and env vars of the original process
+	MetadataDiscarded bool `json:"metadataDiscarded,omitempty"`
+}
+
+// ProcessRemovedReason says why a process left its host's list
+type ProcessRemovedReason string
+
+const (
+	// Its PTY couldn't be reattached; it is listed as stale instead
+	ProcessRemovedDetached ProcessRemovedReason = "detached"
+)
+
+// ProcessRemovedPayload is a process that left a host's list without a
+// process_kill
+type ProcessRemovedPayload struct {
+	HostID    string               `json:"hostId"`
+	ProcessID string               `json:"processId"`
+	Reason    ProcessRemovedReason `json:"reason"`
+}
+
+// ProcessCWDChangedPayload is a process's new working directory, found by
+// the periodic pane refresh
+type ProcessCWDChangedPayload struct {
+	ProcessID string `json:"processId"`
+	CWD       string `json:"cwd"`
+}
+
+// StaleProcessesChangedPayload is a host's stale processes, all of them,
+// after the list changed
+type StaleProcessesChangedPayload struct {
+	HostID         string         `json:"hostId"`
+	StaleProcesses []StaleProcess `json:"staleProcesses"`
+}
+
+// ArchivedProcess is a process killed with its history kept. chat_history,
+// pty_history_request and process_timeline_list work on its ID until it is
+// deleted, by archived_process_delete or once it is older than the bridge's
+// archive max age.
+type ArchivedProcess struct {
+	ID               string      `json:"id"`
+	HostID           string      `json:"hostId"`
+	Type             ProcessType `json:"type"`
+	Name             *string     `json:"name,omitempty"`
+	CWD              string      `json:"cwd,omitempty"`
+	StartedAt        string      `json:"startedAt"`  // ISO timestamp
+	ArchivedAt       string      `json:"archivedAt"` // ISO timestamp
+	PtyHistorySize   int64       `json:"ptyHistorySize"`
+	ChatMessageCount int         `json:"chatMessageCount"`
+}
+
+// ArchivedProcessListPayload lists the archived processes of a host, or of
+// every host when hostId is omitted
+type ArchivedProcessListPayload struct {
+	HostID string `json:"hostId,omitempty"`
+}
+
+type ArchivedProcessListResultPayload struct {
+	Processes []ArchivedProcess `json:"processes"` // Most recently archived first
+	Code      ErrorCode         `json:"code,omitempty"`
+	Error     *string           `json:"error,omitempty"`
+}
+
+type ArchivedProcessGetPayload struct {
+	ProcessID string `json:"processId" validate:"required"`
+}
+
+type ArchivedProcessGetResultPayload struct {
+	Process ArchivedProcess `json:"process"`
+}
+
+// ArchivedProcessDeletePayload deletes an archived process with its history
+type ArchivedProcessDeletePayload struct {
+	ProcessID string `json:"processId" validate:"required"`
+}
+
+type ArchivedProcessDeleteResultPayload struct {
+	Success bool      `json:"success"`
+	ID      *string   `json:"id,omitempty"`
+	Code    ErrorCode `json:"code,omitempty"`
+	Error   *string   `json:"error,omitempty"`
 }
 
 type ProcessReattachPayload struct {
-	HostID      string `json:"hostId"`
-	TmuxSession string `json:"tmuxSession"`
-	ProcessID   string `json:"processId"` // Original process ID from tmux session name
+	HostID      string `json:"hostId" validate:"required"`
+	TmuxSession string `json:"tmuxSession" validate:"required"`
+	ProcessID   string `json:"processId" validate:"required"` // Original process ID from tmux session name
+	Cols        *int   `json:"cols,omitempty" validate:"min=10,max=1000"`
+	Rows        *int   `json:"rows,omitempty" validate:"min=10,max=1000"`
 }
 
 type ProcessRenamePayload struct {
-	ProcessID string `json:"processId"`
-	Name      string `json:"name"`
+	ProcessID string `json:"processId" validate:"required"`
+	Name      string `json:"name" validate:"required"`
 }
 
 type ProcessUpdatedPayload struct {
-	ID            string      `json:"id"`
-	Type          ProcessType `json:"type"`
-	Port          *int        `json:"port,omitempty"`
-	Name          *string     `json:"name,omitempty"`
-	PtyReady      bool        `json:"ptyReady"`
-	AgentAPIReady bool        `json:"agentApiReady"`
-	ShellPID      *int        `json:"shellPid,omitempty"`
-	AgentAPIPID   *int        `json:"agentApiPid,omitempty"`
+	ID            string            `json:"id"`
+	HostID        string            `json:"hostId,omitempty"`
+	Type          ProcessType       `json:"type"`
+	Port          *int              `json:"port,omitempty"`
+	Name          *string           `json:"name,omitempty"`
+	PtyReady      bool              `json:"ptyReady"`
+	AgentAPIReady bool              `json:"agentApiReady"`
+	ShellPID      *int              `json:"shellPid,omitempty"`
+	AgentAPIPID   *int              `json:"agentApiPid,omitempty"`
+	WorkspaceID   *string           `json:"workspaceId,omitempty"`
+	CWD           string            `json:"cwd,omitempty"`
+	ClaudeCWD     string            `json:"claudeCwd,omitempty"`
+	Pinned        bool              `json:"pinned"`
+	SortWeight    int               `json:"sortWeight"`
+	TermOptions   map[string]string `json:"termOptions,omitempty"`
+	Timeline      bool              `json:"timeline"`
+	Exited        bool              `json:"exited,omitempty"`
+	LastError     *ProcessError     `json:"lastError,omitempty"`
+	AgentStatus   *string           `json:"agentStatus,omitempty"`
+	Color         string            `json:"color,omitempty"`
+	Icon          string            `json:"icon,omitempty"`
+
+	RetainFullHistory bool `json:"retainFullHistory,omitempty"`
+
+	TerminalAttached *bool `json:"terminalAttached,omitempty"`
+}
+
+// ProcessesSubscribePayload subscribes to pushed process state for a host:
+// process_created, process_updated and process_killed. The bridge replies
+// with a process_list_result snapshot.
+type ProcessesSubscribePayload struct {
+	HostID string `json:"hostId" validate:"required"`
+}
+
+type ProcessesUnsubscribePayload struct {
+	HostID string `json:"hostId" validate:"required"`
+}
+
+// ProcessAlertKind is the tmux alert behind a process_alert
+type ProcessAlertKind string
+
+const (
+	ProcessAlertBell     ProcessAlertKind = "bell"
+	ProcessAlertActivity ProcessAlertKind = "activity"
+)
+
+// ProcessAlertPayload is pushed to a host's process subscribers when a
+// process the bridge isn't attached to rings the bell or has output
+type ProcessAlertPayload struct {
+	ProcessID string           `json:"processId"`
+	HostID    string           `json:"hostId"`
+	Kind      ProcessAlertKind `json:"kind"`
+	Timestamp string           `json:"timestamp"` // ISO timestamp
 }
 
 // ============================================================================
@@ -366,32 +1258,101 @@ type ProcessUpdatedPayload struct {
 // ============================================================================
 
 type ClaudeStartPayload struct {
-	ProcessID  string  `json:"processId"`
+	ProcessID  string  `json:"processId" validate:"required"`
 	ClaudeArgs *string `json:"claudeArgs,omitempty"` // Optional extra arguments for claude command
 }
 
 type ClaudeKillPayload struct {
-	ProcessID string `json:"processId"`
+	ProcessID string `json:"processId" validate:"required"`
+	Confirmation
+}
+
+// ClaudeReattachTerminalPayload types agentapi attach into a Claude
+// process's pane again once it has dropped back to its shell
+// (terminalAttached false). The bridge only types it at an idle shell
+// prompt, and answers with process_updated.
+type ClaudeReattachTerminalPayload struct {
+	ProcessID string `json:"processId" validate:"required"`
 }
 
 // ============================================================================
 // PTY (Terminal) Payloads
 // ============================================================================
 
+// InputMode is how input bound for a terminal or Claude is sanitized: what
+// the mode doesn't allow is dropped before it is sent
+type InputMode string
+
+const (
+	InputModeText InputMode = "text" // Text, newlines and tabs; no other control characters or escape sequences
+	InputModeKeys InputMode = "keys" // Text and the sequences of named keys (Enter, arrows, Ctrl+letter, ...)
+	InputModeRaw  InputMode = "raw"  // Sent as is
+)
+
 type PtyInputPayload struct {
-	ProcessID string `json:"processId"`
-	Data      string `json:"data"`
+	ProcessID string    `json:"processId" validate:"required"`
+	Data      string    `json:"data"`
+	Mode      InputMode `json:"mode,omitempty" validate:"oneof=text keys raw"` // Default "raw", as terminal emulators send anything
 }
 
 type PtyOutputPayload struct {
 	ProcessID string `json:"processId"`
 	Data      string `json:"data"`
+	ServerTs  int64  `json:"serverTs,omitempty"` // Bridge clock (ms since epoch) when sent; with CapabilityServerTimestamps
 }
 
 type PtyResizePayload struct {
+	ProcessID string `json:"processId" validate:"required"`
+	Cols      int    `json:"cols" validate:"required,min=10,max=1000"`
+	Rows      int    `json:"rows" validate:"required,min=10,max=1000"`
+}
+
+// PtySnapshotPayload is the screen of a process as it is now, sent on
+// process_select so the client can paint it before live output arrives, and
+// after the bridge typed into a new shell to capture its env vars.
+// Data is the visible screen plus some scrollback, with escape sequences
+// kept and "\r\n" line endings, captured at cols x rows.
+type PtySnapshotPayload struct {
 	ProcessID string `json:"processId"`
 	Cols      int    `json:"cols"`
 	Rows      int    `json:"rows"`
+	Data      string `json:"data"`
+}
+
+// ============================================================================
+// PTY Scrollback Payloads
+// ============================================================================
+
+// PtyScrollAction is a way of scrolling a pane's tmux scrollback
+type PtyScrollAction string
+
+const (
+	PtyScrollUp       PtyScrollAction = "up"       // Count lines back
+	PtyScrollDown     PtyScrollAction = "down"     // Count lines forward
+	PtyScrollPageUp   PtyScrollAction = "pageUp"   // Count pages back
+	PtyScrollPageDown PtyScrollAction = "pageDown" // Count pages forward
+	PtyScrollTop      PtyScrollAction = "top"      // Oldest line of the scrollback
+	PtyScrollBottom   PtyScrollAction = "bottom"   // Back to live output
+	PtyScrollExit     PtyScrollAction = "exit"     // Leave copy mode where it is, back to live output
+)
+
+// PtyScrollPayload scrolls a process's pane in tmux copy mode, which shows
+// the scrollback as tmux keeps it, full-screen programs included. Scrolling
+// back enters copy mode; scrolling forward to the bottom leaves it. Input
+// sent with pty_input leaves it first, so no keystroke is lost to it.
+type PtyScrollPayload struct {
+	ProcessID string          `json:"processId" validate:"required"`
+	Action    PtyScrollAction `json:"action" validate:"required,oneof=up down pageUp pageDown top bottom exit"`
+	Count     *int            `json:"count,omitempty" validate:"min=1,max=10000"` // Lines or pages; 1 when omitted
+}
+
+// PtyScrollStatePayload is pushed after pty_scroll, and after pty_input left
+// copy mode, with where the pane is in its scrollback
+type PtyScrollStatePayload struct {
+	ProcessID      string `json:"processId"`
+	InCopyMode     bool   `json:"inCopyMode"`     // False once live output resumes
+	ScrollPosition int    `json:"scrollPosition"` // Lines above the live screen; 0 at the bottom
+	HistorySize    int    `json:"historySize"`    // Lines of scrollback tmux holds
 }
 
 // ============================================================================
@@ -399,7 +1360,10 @@ type PtyResizePayload struct {
 // ============================================================================
 
 type PtyHistoryRequestPayload struct {
-	ProcessID string `json:"processId"`
+	ProcessID string `json:"processId" validate:"required"`
+	// ChunkSize is the requested size of pty_history_chunk data in bytes,
+	// clamped to 8 KB-512 KB and the server's ceiling; 64 KB when omitted
+	ChunkSize *int `json:"chunkSize,omitempty" validate:"min=1"`
 }
 
 type PtyHistoryResponsePayload struct {
@@ -417,35 +1381,75 @@ type PtyHistoryChunkPayload struct {
 }
 
 type PtyHistoryCompletePayload struct {
-	ProcessID string  `json:"processId"`
-	Success   bool    `json:"success"`
-	Error     *string `json:"error,omitempty"`
+	ProcessID string                   `json:"processId"`
+	Success   bool                     `json:"success"`
+	Code      ErrorCode                `json:"code,omitempty"`
+	Error     *string                  `json:"error,omitempty"`
+	Stats     *PtyHistoryTransferStats `json:"stats,omitempty"` // Set when the transfer succeeded
+}
+
+// PtyHistoryTransferStats describes a completed history transfer
+type PtyHistoryTransferStats struct {
+	Bytes          int64 `json:"bytes"` // History bytes sent, before base64
+	Chunks         int   `json:"chunks"`
+	ChunkSize      int   `json:"chunkSize"` // Chunk size used after clamping
+	DurationMs     int64 `json:"durationMs"`
+	BytesPerSecond int64 `json:"bytesPerSecond"`
 }
 
 // ============================================================================
 // Chat (AgentAPI) Payloads
 // ============================================================================
 
+// ChatSubscribePayload subscribes to chat_event for a process; a processId of
+// "*" subscribes to every process on the host
 type ChatSubscribePayload struct {
-	HostID    string `json:"hostId"`
-	ProcessID string `json:"processId"`
+	HostID    string `json:"hostId" validate:"required"`
+	ProcessID string `json:"processId" validate:"required"`
+}
+
+type ChatSubscribeResultPayload struct {
+	HostID          string    `json:"hostId"`
+	ProcessID       string    `json:"processId"`
+	Success         bool      `json:"success"`
+	Status          *string   `json:"status,omitempty"`          // Current agent status; omitted for host-wide subscriptions
+	LatestMessageID *int      `json:"latestMessageId,omitempty"` // Latest cached chat message, if any
+	Code            ErrorCode `json:"code,omitempty"`
+	Error           *string   `json:"error,omitempty"`
 }
 
 type ChatUnsubscribePayload struct {
-	HostID    string `json:"hostId"`
-	ProcessID string `json:"processId"`
+	HostID    string `json:"hostId" validate:"required"`
+	ProcessID string `json:"processId" validate:"required"`
 }
 
 type ChatSendPayload struct {
-	HostID    string `json:"hostId"`
-	ProcessID string `json:"processId"`
-	Content   string `json:"content"`
+	HostID          string  `json:"hostId"`
+	ProcessID       string  `json:"processId" validate:"required"`
+	Content         string  `json:"content" validate:"required"`
+	ClientMessageID *string `json:"clientMessageId,omitempty" validate:"max=64"` // Echoed in the result; the bridge assigns one without it
+}
+
+// ChatSendResultPayload answers every chat_send. On success, Message is the
+// sent message as cached, pending until AgentAPI echoes it: the chat_event
+// with that message_update carries the same ClientMessageID, and the
+// message replaces the pending one in chat history. On failure an error
+// with Code was sent first.
+type ChatSendResultPayload struct {
+	HostID          string       `json:"hostId"`
+	ProcessID       string       `json:"processId"`
+	ClientMessageID string       `json:"clientMessageId"`
+	Success         bool         `json:"success"`
+	Message         *ChatMessage `json:"message,omitempty"`
+	Code            ErrorCode    `json:"code,omitempty"`
+	Error           *string      `json:"error,omitempty"`
 }
 
 type ChatRawPayload struct {
-	HostID    string `json:"hostId"`
-	ProcessID string `json:"processId"`
-	Content   string `json:"content"`
+	HostID    string    `json:"hostId"`
+	ProcessID string    `json:"processId" validate:"required"`
+	Content   string    `json:"content"`
+	Mode      InputMode `json:"mode,omitempty" validate:"oneof=text keys raw"` // Default "keys"
 }
 
 type MessageUpdateData struct {
@@ -465,11 +1469,21 @@ type ChatEventPayload struct {
 	ProcessID string          `json:"processId"`
 	Event     string          `json:"event"` // "message_update" or "status_change"
 	Data      json.RawMessage `json:"data"`
+
+	// ClientMessageID is set on a message_update of a message sent with
+	// chat_send, to match it with the chat_send_result
+	ClientMessageID string `json:"clientMessageId,omitempty"`
+
+	ServerTs int64 `json:"serverTs,omitempty"` // Bridge clock (ms since epoch) when sent; with CapabilityServerTimestamps
 }
 
+// ChatStatusPayload asks for a Claude process's status. The bridge answers
+// with the status it tracks from AgentAPI's events, which process_updated
+// also pushes; Force asks AgentAPI instead.
 type ChatStatusPayload struct {
 	HostID    string `json:"hostId"`
-	ProcessID string `json:"processId"`
+	ProcessID string `json:"processId" validate:"required"`
+	Force     bool   `json:"force,omitempty"`
 }
 
 type ChatStatusResultPayload struct {
@@ -481,49 +1495,257 @@ type ChatStatusResultPayload struct {
 
 type ChatHistoryPayload struct {
 	HostID    string `json:"hostId"`
-	ProcessID string `json:"processId"`
+	ProcessID string `json:"processId" validate:"required"`
 }
 
 type ChatMessage struct {
-	ID      int    `json:"id"`
+	ID      int    `json:"id"`   // Negative while pending
 	Role    string `json:"role"` // "user" or "assistant"
 	Message string `json:"message"`
 	Time    string `json:"time"`
+
+	// Pending marks a message sent with chat_send that AgentAPI hasn't
+	// echoed yet
+	Pending         bool   `json:"pending,omitempty"`
+	ClientMessageID string `json:"clientMessageId,omitempty"` // Set on messages sent with chat_send
+
+	// Kind is "message", "tool_call", "tool_result" or "system", derived from
+	// what AgentAPI sent; Metadata is a JSON object of the fields AgentAPI
+	// sent beyond the ones above, as sent. Both are unset on messages
+	// cached before they were recorded.
+	Kind     string          `json:"kind,omitempty"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
 }
 
 type ChatMessagesPayload struct {
 	HostID    string        `json:"hostId"`
 	ProcessID string        `json:"processId"`
 	Messages  []ChatMessage `json:"messages"`
+	Draft     *ChatDraft    `json:"draft,omitempty"` // In answer to chat_history, when the process has one
+}
+
+// ChatSearchPayload searches the stored chat history of every process.
+// Words must all appear in a message; "quoted text" must appear as a phrase.
+type ChatSearchPayload struct {
+	Query  string `json:"query" validate:"required"`
+	HostID string `json:"hostId,omitempty"`                               // Only this host's processes
+	Role   string `json:"role,omitempty" validate:"oneof=user assistant"` // Only "user" or "assistant" messages
+	Limit  int    `json:"limit,omitempty" validate:"min=0"`               // Maximum matches, default 50, at most 500
+}
+
+// TextRange is a span of text in UTF-16 code units, as JavaScript indexes
+// strings
+type TextRange struct {
+	Start  int `json:"start"`
+	Length int `json:"length"`
+}
+
+type ChatSearchMatch struct {
+	MessageID  int         `json:"messageId"`
+	Role       string      `json:"role"`
+	Time       string      `json:"time"`
+	Snippet    string      `json:"snippet"`    // Excerpt of the message around the matches
+	Highlights []TextRange `json:"highlights"` // Matched text within snippet
+}
+
+// ChatSearchProcessResult holds the matches of one process. MatchCount counts
+// every match in the process, including ones past the limit.
+type ChatSearchProcessResult struct {
+	ProcessID   string            `json:"processId"`
+	HostID      string            `json:"hostId"`
+	ProcessName *string           `json:"processName,omitempty"`
+	MatchCount  int               `json:"matchCount"`
+	Matches     []ChatSearchMatch `json:"matches"`
+}
+
+// ChatSearchResultPayload lists processes in order of their best match
+type ChatSearchResultPayload struct {
+	Query   string                    `json:"query"`
+	Results []ChatSearchProcessResult `json:"results"`
+	Code    ErrorCode                 `json:"code,omitempty"`
+	Error   *string                   `json:"error,omitempty"`
+}
+
+// ChatUsagePayload requests the token usage of a Claude process
+type ChatUsagePayload struct {
+	ProcessID string `json:"processId" validate:"required"`
+}
+
+// ChatModelUsage is the usage of one model. Input tokens exclude those
+// written to and read from the prompt cache.
+type ChatModelUsage struct {
+	Model                    string   `json:"model"`
+	InputTokens              int64    `json:"inputTokens"`
+	OutputTokens             int64    `json:"outputTokens"`
+	CacheCreationInputTokens int64    `json:"cacheCreationInputTokens"`
+	CacheReadInputTokens     int64    `json:"cacheReadInputTokens"`
+	MessageCount             int      `json:"messageCount"`               // Assistant responses
+	EstimatedCostUSD         *float64 `json:"estimatedCostUsd,omitempty"` // At list prices, absent for unknown models
+}
+
+// ChatUsage totals the usage of a Claude conversation across models
+type ChatUsage struct {
+	InputTokens              int64            `json:"inputTokens"`
+	OutputTokens             int64            `json:"outputTokens"`
+	CacheCreationInputTokens int64            `json:"cacheCreationInputTokens"`
+	CacheReadInputTokens     int64            `json:"cacheReadInputTokens"`
+	MessageCount             int              `json:"messageCount"`
+	EstimatedCostUSD         *float64         `json:"estimatedCostUsd,omitempty"` // Absent if any model is unknown
+	Models                   []ChatModelUsage `json:"models"`
+}
+
+// ChatUsageResultPayload answers chat_usage. UsageAvailable is false when
+// the process has no usage to report yet; Source is then empty.
+type ChatUsageResultPayload struct {
+	ProcessID      string     `json:"processId"`
+	UsageAvailable bool       `json:"usageAvailable"`
+	Source         string     `json:"source,omitempty"`    // "agentapi", "transcript" or "cache"
+	UpdatedAt      *string    `json:"updatedAt,omitempty"` // ISO timestamp, when Source is "cache"
+	Usage          *ChatUsage `json:"usage,omitempty"`
+}
+
+// Chat usage sources
+const (
+	ChatUsageSourceAgentAPI   = "agentapi"
+	ChatUsageSourceTranscript = "transcript"
+	ChatUsageSourceCache      = "cache"
+)
+
+// ChatDraftSetPayload saves the half-typed message of a process, replacing
+// its previous draft. Empty text or Clear deletes the draft.
+type ChatDraftSetPayload struct {
+	ProcessID string `json:"processId" validate:"required"`
+	Text      string `json:"text"` // At most 32 KB
+	Clear     bool   `json:"clear,omitempty"`
+}
+
+// ChatDraftGetPayload requests the draft of a process
+type ChatDraftGetPayload struct {
+	ProcessID string `json:"processId" validate:"required"`
+}
+
+// ChatDraft is the half-typed message of a process. It is deleted once a
+// chat_send starting with its text succeeds.
+type ChatDraft struct {
+	Text      string `json:"text"`
+	UpdatedAt string `json:"updatedAt"` // ISO timestamp
+}
+
+// ChatDraftResultPayload answers chat_draft_set and chat_draft_get
+type ChatDraftResultPayload struct {
+	ProcessID string     `json:"processId"`
+	Draft     *ChatDraft `json:"draft,omitempty"` // Absent when the process has none
+}
+
+// ============================================================================
+// Confirmation Payloads
+// ============================================================================
+
+// Confirmation makes a destructive request two-phase. With ConfirmRequired
+// (or when the bridge requires it), the bridge answers with a
+// confirmation_challenge instead of acting, and the request is sent again
+// with the challenge's token.
+type Confirmation struct {
+	ConfirmRequired bool   `json:"confirmRequired,omitempty"`
+	ConfirmToken    string `json:"confirmToken,omitempty"`
+}
+
+// ConfirmationChallengePayload asks the user to confirm a destructive
+// request. The token is single-use and only valid on this connection's
+// session, for the same request, until it expires.
+type ConfirmationChallengePayload struct {
+	Action           string `json:"action"` // Message type to send again, with confirmToken
+	Target           string `json:"target"` // processId, or host config id
+	Token            string `json:"token"`
+	ExpiresInSeconds int    `json:"expiresInSeconds"`
+	Summary          string `json:"summary"` // What will be destroyed, for showing the user
+
+	// Set when the target is a process
+	ProcessName   *string      `json:"processName,omitempty"`
+	ProcessType   *ProcessType `json:"processType,omitempty"`
+	UptimeSeconds *int64       `json:"uptimeSeconds,omitempty"`
+	ChatMessages  *int         `json:"chatMessages,omitempty"` // Cached chat messages of a Claude process
 }
 
 // ============================================================================
 // Error Payload
 // ============================================================================
 
+// ErrorPayload reports a failed request. Message is a short description of
+// the code in the session's locale, the same for every error with that code;
+// what is particular to this error, including any human-readable reason, is
+// in Details.
 type ErrorPayload struct {
-	Code    string      `json:"code"`
+	Code    ErrorCode   `json:"code"`
 	Message string      `json:"message"`
-	Details interface{} `json:"details,omitempty"`
+	Details interface{} `json:"details,omitempty"` // ErrorDetails, or a struct such as InvalidArgsDetails
+}
+
+// InvalidArgsDetails is the Details of an INVALID_ARGS error
+type InvalidArgsDetails struct {
+	Tokens []string `json:"tokens"` // Offending tokens, as written by the user
+	Reason string   `json:"reason"`
+}
+
+// SuggestedAction tells a client how to recover from an error
+type SuggestedAction string
+
+const (
+	SuggestedActionReattachProcess SuggestedAction = "reattach_process" // Send process_reattach
+	SuggestedActionReconnectHost   SuggestedAction = "reconnect_host"   // Send host_connect
+)
+
+// PtyFailureDetails are the Details of the errors a failed pty_input or
+// pty_resize reports: PTY_DETACHED, PTY_CLOSED and SSH_DOWN
+type PtyFailureDetails struct {
+	ProcessID       string          `json:"processId"`
+	HostID          string          `json:"hostId"`
+	SuggestedAction SuggestedAction `json:"suggestedAction,omitempty"`
+	Reason          string          `json:"reason,omitempty"`
 }
 
 // ============================================================================
 // Environment Variables Payloads
 // ============================================================================
 
+// EnvVar is a single environment variable. Values of keys that look like
+// secrets are replaced with a placeholder and IsMasked is set; the real value
+// is available through env_reveal. Clients may send masked vars back in
+// env_update unchanged to keep the current value.
 type EnvVar struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	Key      string `json:"key" validate:"required"`
+	Value    string `json:"value"`
+	IsMasked bool   `json:"isMasked"`
 }
 
 // Host-level env management
 type EnvListPayload struct {
-	HostID string `json:"hostId"`
+	HostID string `json:"hostId" validate:"required"`
 }
 
+// EnvUpdatePayload replaces the managed section of a host's RC file. With
+// DryRun the file is left alone and the result previews the change.
+//
+// A variable that would shadow a system variable of the same name is a
+// conflict, and the update is refused unless its key is in AllowOverride.
+// PATH, HOME, SHELL and USER are refused unless Force is set, as a wrong
+// value breaks every new shell on the host. A key the section already holds
+// is only a conflict if it is one of those.
 type EnvUpdatePayload struct {
-	HostID     string   `json:"hostId"`
-	CustomVars []EnvVar `json:"customVars"`
+	HostID        string   `json:"hostId" validate:"required"`
+	CustomVars    []EnvVar `json:"customVars"`
+	DryRun        bool     `json:"dryRun,omitempty"`
+	AllowOverride []string `json:"allowOverride,omitempty"` // Keys that may shadow a system variable
+	Force         bool     `json:"force,omitempty"`         // Allows PATH, HOME, SHELL and USER
+}
+
+// EnvConflict is a custom variable that shadows a system variable
+type EnvConflict struct {
+	Key         string  `json:"key"`
+	SystemValue *string `json:"systemValue,omitempty"` // The value shadowed, masked for secrets; unset when the system has none
+	IsMasked    bool    `json:"isMasked"`
+	Critical    bool    `json:"critical"` // PATH, HOME, SHELL or USER, which need force
+	Allowed     bool    `json:"allowed"`  // By allowOverride, or force for a critical key
 }
 
 type EnvResultPayload struct {
@@ -532,23 +1754,79 @@ type EnvResultPayload struct {
 	CustomVars     []EnvVar `json:"customVars"`
 	RcFile         string   `json:"rcFile"`
 	DetectedRcFile string   `json:"detectedRcFile"`
-	Error          *string  `json:"error,omitempty"`
+	DryRun         bool     `json:"dryRun,omitempty"`
+	Diff           *string  `json:"diff,omitempty"`           // Dry run: unified diff of the RC file, "" if unchanged
+	ManagedSection *string  `json:"managedSection,omitempty"` // Dry run: the section that would be written, "" if none
+	// env_update: the requested variables that shadow system ones. The
+	// update is refused, with Error set, while any isn't allowed; a dry run
+	// previews it anyway.
+	Conflicts []EnvConflict `json:"conflicts,omitempty"`
+	Code      ErrorCode     `json:"code,omitempty"`
+	Error     *string       `json:"error,omitempty"`
 }
 
 type EnvSetRcFilePayload struct {
-	HostID string `json:"hostId"`
+	HostID string `json:"hostId" validate:"required"`
 	RcFile string `json:"rcFile"`
 }
 
-// Process-level env viewer (read-only)
+// EnvRevealPayload requests the unmasked value of a single host env var
+type EnvRevealPayload struct {
+	HostID string `json:"hostId" validate:"required"`
+	Key    string `json:"key" validate:"required"`
+}
+
+type EnvRevealResultPayload struct {
+	HostID  string    `json:"hostId"`
+	Key     string    `json:"key"`
+	Success bool      `json:"success"`
+	Value   *string   `json:"value,omitempty"`
+	Code    ErrorCode `json:"code,omitempty"`
+	Error   *string   `json:"error,omitempty"`
+}
+
+// ProcessEnvMode is which environment process_env_list returns
+type ProcessEnvMode string
+
+const (
+	ProcessEnvModeSpawn   ProcessEnvMode = "spawn"   // As captured when the shell started
+	ProcessEnvModeCurrent ProcessEnvMode = "current" // Captured again from the live pane
+	ProcessEnvModeDiff    ProcessEnvMode = "diff"    // Captured again, with how it differs from spawn
+)
+
+// Process-level env viewer (read-only). A current capture types into the
+// pane, so it is only made while the pane runs a shell, and at most once
+// per process every 10 seconds.
 type ProcessEnvListPayload struct {
-	ProcessID string `json:"processId"`
+	ProcessID string          `json:"processId" validate:"required"`
+	Mode      *ProcessEnvMode `json:"mode,omitempty" validate:"oneof=spawn current diff"` // Default "spawn"
 }
 
 type ProcessEnvResultPayload struct {
-	ProcessID string   `json:"processId"`
-	Vars      []EnvVar `json:"vars"`
-	Error     *string  `json:"error,omitempty"`
+	ProcessID    string          `json:"processId"`
+	Mode         ProcessEnvMode  `json:"mode"`
+	Vars         []EnvVar        `json:"vars"`           // Current vars in current and diff modes
+	Diff         *ProcessEnvDiff `json:"diff,omitempty"` // Diff mode only
+	Code         ErrorCode       `json:"code,omitempty"`
+	Error        *string         `json:"error,omitempty"`
+	RetryAfterMs *int64          `json:"retryAfterMs,omitempty"` // When the capture was refused by the rate limit
+}
+
+// ProcessEnvDiff is how a process's current environment differs from its
+// spawn snapshot, each list sorted by key. Secret values are masked.
+type ProcessEnvDiff struct {
+	Added   []EnvVar       `json:"added"`
+	Removed []EnvVar       `json:"removed"`
+	Changed []EnvVarChange `json:"changed"`
+}
+
+// EnvVarChange is an env var whose value changed since spawn. A secret's
+// values are both masked, so only the change shows.
+type EnvVarChange struct {
+	Key      string `json:"key"`
+	Old      string `json:"old"`
+	New      string `json:"new"`
+	IsMasked bool   `json:"isMasked"`
 }
 
 // ============================================================================
@@ -556,7 +1834,7 @@ type ProcessEnvResultPayload struct {
 // ============================================================================
 
 type PortsScanPayload struct {
-	HostID string `json:"hostId"`
+	HostID string `json:"hostId" validate:"required"`
 }
 
 type PortInfo struct {
@@ -566,9 +1844,10 @@ type PortInfo struct {
 	ProcessName *string      `json:"processName,omitempty"`
 	ProcessType *ProcessType `json:"processType,omitempty"`
 	// Enriched from netstat/ss/lsof
-	NetPID     *int    `json:"netPid,omitempty"`
-	NetProcess *string `json:"netProcess,omitempty"`
-	NetUser    *string `json:"netUser,omitempty"`
+	NetPID       *int    `json:"netPid,omitempty"`
+	NetPIDMethod *string `json:"netPidMethod,omitempty"` // How NetPID was found: "ss", "netstat", "lsof" or "proc"
+	NetProcess   *string `json:"netProcess,omitempty"`
+	NetUser      *string `json:"netUser,omitempty"`
 }
 
 type PortsResultPayload struct {
@@ -576,9 +1855,100 @@ type PortsResultPayload struct {
 	Ports        []PortInfo `json:"ports"`
 	NetTool      *string    `json:"netTool,omitempty"`      // Which tool was used
 	NetToolError *string    `json:"netToolError,omitempty"` // Error if no tool available
+	Code         ErrorCode  `json:"code,omitempty"`
 	Error        *string    `json:"error,omitempty"`
 }
 
+// ============================================================================
+// File Download Payloads
+// ============================================================================
+
+// FileDownloadPayload streams a file from a host. Symbolic links are
+// followed. The file arrives in file_download_chunk messages, followed by
+// file_download_complete; a file that can't be read is refused with an
+// error naming the downloadId.
+type FileDownloadPayload struct {
+	DownloadID string  `json:"downloadId" validate:"required"` // Chosen by the client; names the download's messages and file_download_cancel
+	HostID     string  `json:"hostId" validate:"required"`
+	Path       string  `json:"path" validate:"required"`
+	ProcessID  *string `json:"processId,omitempty"` // Relative paths resolve against its CWD; default: the login directory
+}
+
+// FileDownloadChunkPayload is the next piece of a downloaded file
+type FileDownloadChunkPayload struct {
+	DownloadID string `json:"downloadId"`
+	Seq        int    `json:"seq"`       // 0 for the first chunk
+	Data       string `json:"data"`      // Base64 encoded
+	TotalSize  int64  `json:"totalSize"` // Size of the whole file, before base64
+}
+
+// FileDownloadCompletePayload ends a download. On success Size and SHA256
+// describe the bytes sent, for the client to check what it reassembled.
+type FileDownloadCompletePayload struct {
+	DownloadID   string    `json:"downloadId"`
+	HostID       string    `json:"hostId"`
+	Path         string    `json:"path"`         // As requested
+	ResolvedPath string    `json:"resolvedPath"` // Absolute, with symbolic links followed
+	Symlink      bool      `json:"symlink"`      // Path is a symbolic link to ResolvedPath
+	Success      bool      `json:"success"`
+	Cancelled    bool      `json:"cancelled"` // Stopped by file_download_cancel
+	Code         ErrorCode `json:"code,omitempty"`
+	Error        *string   `json:"error,omitempty"`
+	Size         int64     `json:"size"` // Bytes sent
+	Chunks       int       `json:"chunks"`
+	SHA256       string    `json:"sha256,omitempty"` // Hex, set on success
+}
+
+// FileDownloadCancelPayload stops a download the session started
+type FileDownloadCancelPayload struct {
+	DownloadID string `json:"downloadId" validate:"required"`
+}
+
+// ============================================================================
+// File Upload Payloads
+// ============================================================================
+
+// FileUploadBeginPayload starts an upload to a host. The file follows in
+// file_upload_chunk messages and is written by file_upload_commit; nothing
+// is answered unless the upload is refused, with an error naming the
+// uploadId. An upload left without messages for the bridge's idle timeout
+// (5 minutes by default) is dropped.
+type FileUploadBeginPayload struct {
+	UploadID  string  `json:"uploadId" validate:"required"` // Chosen by the client; names the upload's messages
+	HostID    string  `json:"hostId" validate:"required"`
+	Path      string  `json:"path" validate:"required"`
+	ProcessID *string `json:"processId,omitempty"` // Relative paths resolve against its CWD; default: the login directory
+	Size      int64   `json:"size" validate:"min=0"`
+	SHA256    string  `json:"sha256" validate:"required"` // Hex, of the whole file
+	Mode      *string `json:"mode,omitempty"`             // Octal permissions, e.g. "0755"; default: "0644"
+	Overwrite bool    `json:"overwrite,omitempty"`        // Replace a file already at path
+}
+
+// FileUploadChunkPayload is a piece of an uploaded file. Chunks may arrive
+// in any order; one sent again replaces the first.
+type FileUploadChunkPayload struct {
+	UploadID string `json:"uploadId" validate:"required"`
+	Seq      int    `json:"seq" validate:"min=0"` // 0 for the first chunk
+	Data     string `json:"data"`                 // Base64 encoded
+}
+
+// FileUploadCommitPayload checks an upload's size and checksum and writes
+// it to the host, answered by file_upload_result
+type FileUploadCommitPayload struct {
+	UploadID string `json:"uploadId" validate:"required"`
+}
+
+// FileUploadResultPayload reports an upload written to its host
+type FileUploadResultPayload struct {
+	UploadID     string `json:"uploadId"`
+	HostID       string `json:"hostId"`
+	Path         string `json:"path"`         // As requested
+	ResolvedPath string `json:"resolvedPath"` // Absolute
+	Size         int64  `json:"size"`
+	Mode         string `json:"mode"`     // Octal, e.g. "0644"
+	Replaced     bool   `json:"replaced"` // A file was overwritten
+}
+
 // ============================================================================
 // Snippets Payloads
 // ============================================================================
@@ -601,34 +1971,379 @@ type SnippetListResultPayload struct {
 }
 
 type SnippetCreatePayload struct {
-	Name    string `json:"name"`
+	Name    string `json:"name" validate:"required"`
 	Content string `json:"content"`
 }
 
 type SnippetCreateResultPayload struct {
-	Success bool     `json:"success"`
-	Snippet *Snippet `json:"snippet,omitempty"`
-	Error   *string  `json:"error,omitempty"`
+	Success bool      `json:"success"`
+	Snippet *Snippet  `json:"snippet,omitempty"`
+	Code    ErrorCode `json:"code,omitempty"`
+	Error   *string   `json:"error,omitempty"`
 }
 
 type SnippetUpdatePayload struct {
-	ID      string  `json:"id"`
-	Name    *string `json:"name,omitempty"`
+	ID      string  `json:"id" validate:"required"`
+	Name    *string `json:"name,omitempty" validate:"min=1"`
 	Content *string `json:"content,omitempty"`
 }
 
 type SnippetUpdateResultPayload struct {
-	Success bool     `json:"success"`
-	Snippet *Snippet `json:"snippet,omitempty"`
-	Error   *string  `json:"error,omitempty"`
+	Success bool      `json:"success"`
+	Snippet *Snippet  `json:"snippet,omitempty"`
+	Code    ErrorCode `json:"code,omitempty"`
+	Error   *string   `json:"error,omitempty"`
 }
 
 type SnippetDeletePayload struct {
-	ID string `json:"id"`
+	ID string `json:"id" validate:"required"`
 }
 
 type SnippetDeleteResultPayload struct {
-	Success bool    `json:"success"`
-	ID      *string `json:"id,omitempty"`
-	Error   *string `json:"error,omitempty"`
+	Success bool      `json:"success"`
+	ID      *string   `json:"id,omitempty"`
+	Code    ErrorCode `json:"code,omitempty"`
+	Error   *string   `json:"error,omitempty"`
+}
+
+// SnippetExecutePayload types a snippet into the terminals of one process,
+// a list of processes on any hosts, or every process of a workspace; exactly
+// one of ProcessID, ProcessIDs and WorkspaceID is given. The snippet is
+// rendered per process: {{name}} is replaced by Variables[name], or else by
+// the process's own processId, processName, hostId, cwd or claudeCwd.
+type SnippetExecutePayload struct {
+	RequestID   string            `json:"requestId,omitempty"` // Echoed in the result
+	SnippetID   string            `json:"snippetId" validate:"required"`
+	ProcessID   string            `json:"processId,omitempty"`
+	ProcessIDs  []string          `json:"processIds,omitempty"`
+	WorkspaceID string            `json:"workspaceId,omitempty"`
+	Variables   map[string]string `json:"variables,omitempty"`
+	Mode        InputMode         `json:"mode,omitempty" validate:"oneof=text keys raw"` // How the rendered content is sanitized; default "text"
+}
+
+// SnippetExecuteResultPayload answers a snippet_execute with the outcome for
+// each target, in the order they were named, and the input mode applied. A
+// target that failed doesn't stop the others.
+type SnippetExecuteResultPayload struct {
+	RequestID string                `json:"requestId,omitempty"`
+	SnippetID string                `json:"snippetId"`
+	Mode      InputMode             `json:"mode"`
+	Results   []SnippetTargetResult `json:"results"`
+}
+
+// SnippetTargetResult is the outcome of a snippet_execute on one process.
+// Code is SNIPPET_RENDER_ERROR when a variable had no value for it, or what
+// a pty_input would have failed with.
+type SnippetTargetResult struct {
+	ProcessID       string          `json:"processId"`
+	HostID          string          `json:"hostId,omitempty"` // Empty when the process isn't known
+	Success         bool            `json:"success"`
+	Code            ErrorCode       `json:"code,omitempty"`
+	Error           *string         `json:"error,omitempty"` // Untranslated particulars
+	SuggestedAction SuggestedAction `json:"suggestedAction,omitempty"`
+}
+
+// ============================================================================
+// Workspace Payloads
+// ============================================================================
+
+// Workspace groups processes (possibly on different hosts) for a combined view
+type Workspace struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	ProcessIDs []string `json:"processIds"`
+	CreatedAt  string   `json:"createdAt"` // ISO timestamp
+	UpdatedAt  string   `json:"updatedAt"` // ISO timestamp
+}
+
+type WorkspaceListPayload struct {
+	// empty - no params needed
+}
+
+type WorkspaceListResultPayload struct {
+	Workspaces []Workspace `json:"workspaces"`
+}
+
+type WorkspaceCreatePayload struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type WorkspaceCreateResultPayload struct {
+	Success   bool       `json:"success"`
+	Workspace *Workspace `json:"workspace,omitempty"`
+	Code      ErrorCode  `json:"code,omitempty"`
+	Error     *string    `json:"error,omitempty"`
+}
+
+type WorkspaceUpdatePayload struct {
+	ID   string  `json:"id" validate:"required"`
+	Name *string `json:"name,omitempty" validate:"min=1"`
+}
+
+type WorkspaceUpdateResultPayload struct {
+	Success   bool       `json:"success"`
+	Workspace *Workspace `json:"workspace,omitempty"`
+	Code      ErrorCode  `json:"code,omitempty"`
+	Error     *string    `json:"error,omitempty"`
+}
+
+type WorkspaceDeletePayload struct {
+	ID string `json:"id" validate:"required"`
+}
+
+type WorkspaceDeleteResultPayload struct {
+	Success bool      `json:"success"`
+	ID      *string   `json:"id,omitempty"`
+	Code    ErrorCode `json:"code,omitempty"`
+	Error   *string   `json:"error,omitempty"`
+}
+
+// WorkspaceAssignPayload moves a process into a workspace; nil workspaceId removes it
+type WorkspaceAssignPayload struct {
+	ProcessID   string  `json:"processId" validate:"required"`
+	WorkspaceID *string `json:"workspaceId"`
+}
+
+type WorkspaceAssignResultPayload struct {
+	Success     bool      `json:"success"`
+	ProcessID   string    `json:"processId"`
+	WorkspaceID *string   `json:"workspaceId,omitempty"`
+	Code        ErrorCode `json:"code,omitempty"`
+	Error       *string   `json:"error,omitempty"`
+}
+
+// ============================================================================
+// Process Template Payloads
+// ============================================================================
+
+// ProcessTemplate is a saved recipe for a new process: a shell started in
+// cwd with env and shell, and optionally Claude started in it with
+// claudeArgs. Secret env values are masked as in env_result.
+type ProcessTemplate struct {
+	ID              string   `json:"id"`
+	Name            string   `json:"name"`
+	HostID          *string  `json:"hostId,omitempty"` // Host the template is for; any host when absent
+	CWD             *string  `json:"cwd,omitempty"`
+	Env             []EnvVar `json:"env"`
+	Shell           *string  `json:"shell,omitempty"` // Command the pane runs instead of the login shell
+	ClaudeArgs      *string  `json:"claudeArgs,omitempty"`
+	AutoStartClaude bool     `json:"autoStartClaude"`
+	StartupHooks    []string `json:"startupHooks,omitempty"` // Typed into the shell after the host's, in order
+	CreatedAt       string   `json:"createdAt"`              // ISO timestamp
+	UpdatedAt       string   `json:"updatedAt"`              // ISO timestamp
+}
+
+type ProcessTemplateListPayload struct {
+	// empty - no params needed
+}
+
+type ProcessTemplateListResultPayload struct {
+	Templates []ProcessTemplate `json:"templates"`
+}
+
+type ProcessTemplateCreatePayload struct {
+	Name            string   `json:"name" validate:"required"`
+	HostID          *string  `json:"hostId,omitempty"`
+	CWD             *string  `json:"cwd,omitempty"`
+	Env             []EnvVar `json:"env,omitempty"`
+	Shell           *string  `json:"shell,omitempty"`
+	ClaudeArgs      *string  `json:"claudeArgs,omitempty"`
+	AutoStartClaude bool     `json:"autoStartClaude,omitempty"`
+	StartupHooks    []string `json:"startupHooks,omitempty" validate:"max=20"`
+}
+
+type ProcessTemplateCreateResultPayload struct {
+	Success  bool             `json:"success"`
+	Template *ProcessTemplate `json:"template,omitempty"`
+	Code     ErrorCode        `json:"code,omitempty"`
+	Error    *string          `json:"error,omitempty"`
+}
+
+// ProcessTemplateUpdatePayload changes the fields that are set; an empty
+// string clears hostId, cwd, shell or claudeArgs. Masked env values keep
+// the stored value.
+type ProcessTemplateUpdatePayload struct {
+	ID              string    `json:"id" validate:"required"`
+	Name            *string   `json:"name,omitempty" validate:"min=1"`
+	HostID          *string   `json:"hostId,omitempty"`
+	CWD             *string   `json:"cwd,omitempty"`
+	Env             *[]EnvVar `json:"env,omitempty"`
+	Shell           *string   `json:"shell,omitempty"`
+	ClaudeArgs      *string   `json:"claudeArgs,omitempty"`
+	AutoStartClaude *bool     `json:"autoStartClaude,omitempty"`
+	StartupHooks    *[]string `json:"startupHooks,omitempty" validate:"max=20"` // An empty list clears them
+}
+
+type ProcessTemplateUpdateResultPayload struct {
+	Success  bool             `json:"success"`
+	Template *ProcessTemplate `json:"template,omitempty"`
+	Code     ErrorCode        `json:"code,omitempty"`
+	Error    *string          `json:"error,omitempty"`
+}
+
+type ProcessTemplateDeletePayload struct {
+	ID string `json:"id" validate:"required"`
+}
+
+type ProcessTemplateDeleteResultPayload struct {
+	Success bool      `json:"success"`
+	ID      *string   `json:"id,omitempty"`
+	Code    ErrorCode `json:"code,omitempty"`
+	Error   *string   `json:"error,omitempty"`
+}
+
+// Stages of process_create_from_template, in order
+const (
+	TemplateStageResolve = "resolve" // Template, host and overrides checked; nothing is changed
+	TemplateStageCreate  = "create"  // Shell started in the template's cwd, with its env and shell
+	TemplateStageClaude  = "claude"  // Claude started in the shell, when autoStartClaude
+)
+
+// ProcessCreateFromTemplatePayload runs a template. hostId is required for
+// a template without a host, and must match the template's otherwise. Set
+// overrides replace the template's values; env overrides are merged into
+// its env by key.
+type ProcessCreateFromTemplatePayload struct {
+	TemplateID      string   `json:"templateId" validate:"required"`
+	HostID          *string  `json:"hostId,omitempty"`
+	CWD             *string  `json:"cwd,omitempty"`
+	Env             []EnvVar `json:"env,omitempty"`
+	ClaudeArgs      *string  `json:"claudeArgs,omitempty"`
+	AutoStartClaude *bool    `json:"autoStartClaude,omitempty"`
+	Cols            *int     `json:"cols,omitempty" validate:"min=10,max=1000"`
+	Rows            *int     `json:"rows,omitempty" validate:"min=10,max=1000"`
+	SkipHooks       bool     `json:"skipHooks,omitempty"` // Type neither the host's nor the template's startup hooks
+}
+
+// ProcessCreateFromTemplateResultPayload follows the process_created and
+// process_updated messages of the stages that ran. A failed stage leaves the
+// earlier ones in place: a process whose Claude failed to start stays a
+// shell, and is in process.
+type ProcessCreateFromTemplateResultPayload struct {
+	Success     bool         `json:"success"`
+	TemplateID  string       `json:"templateId"`
+	Stages      []string     `json:"stages"` // Stages that completed
+	Process     *ProcessInfo `json:"process,omitempty"`
+	FailedStage *string      `json:"failedStage,omitempty"`
+	ErrorCode   *ErrorCode   `json:"errorCode,omitempty"`
+	Error       *string      `json:"error,omitempty"` // Reason, in English
+}
+
+// ============================================================================
+// Bridge Info Payloads
+// ============================================================================
+
+type BridgeInfoPayload struct {
+	// empty - no params needed
+}
+
+// BridgeInfoResultPayload describes the running bridge for diagnostics
+type BridgeInfoResultPayload struct {
+	Version            string                     `json:"version"`
+	Commit             string                     `json:"commit"`
+	BuildDate          string                     `json:"buildDate"`
+	ProtocolVersion    int                        `json:"protocolVersion"`
+	StartedAt          string                     `json:"startedAt"` // ISO timestamp
+	UptimeSeconds      int64                      `json:"uptimeSeconds"`
+	Profile            string                     `json:"profile"`
+	DataDir            string                     `json:"dataDir"` // Directory holding the active profile's data
+	ListenAddresses    []string                   `json:"listenAddresses"`
+	HostCount          int                        `json:"hostCount"`          // Configured hosts
+	ConnectedHostCount int                        `json:"connectedHostCount"` // Hosts with a live SSH connection
+	ProcessCount       int                        `json:"processCount"`       // Attached processes
+	SessionCount       int                        `json:"sessionCount"`       // Client sessions, including ones awaiting reconnect
+	ConnectedSessions  int                        `json:"connectedSessions"`
+	PortRange          PortRange                  `json:"portRange"`        // AgentAPI ports this bridge allocates and scans
+	SSHChannels        int                        `json:"sshChannels"`      // Channels open on all SSH connections
+	Update             *BridgeUpdateStatusPayload `json:"update,omitempty"` // Unset while update checks are off
+	PtyOutput          PtyOutputSettings          `json:"ptyOutput"`        // How new processes' output is read and delivered
+}
+
+// PtyOutputSettings is how PTY output is read and delivered: reads of at
+// most ReadBufferSize bytes, and output read within CoalesceWindowMs of a
+// chunk merged into one pty_output of at most MaxCoalescedBytes (a window of
+// 0 sends every read as it is)
+type PtyOutputSettings struct {
+	ReadBufferSize    int `json:"readBufferSize"`
+	CoalesceWindowMs  int `json:"coalesceWindowMs"`
+	MaxCoalescedBytes int `json:"maxCoalescedBytes"`
+}
+
+// BridgeUpdateStatusPayload is what the bridge's update check last found.
+// It answers bridge_update_check, and is pushed as bridge_update_available
+// to every session when a check finds a newer release than the last one
+// pushed. The bridge never installs anything itself.
+type BridgeUpdateStatusPayload struct {
+	CurrentVersion        string  `json:"currentVersion"`
+	Available             bool    `json:"available"`                       // LatestVersion is newer than CurrentVersion
+	LatestVersion         *string `json:"latestVersion,omitempty"`         // Unset until a check succeeds
+	ReleaseURL            *string `json:"releaseUrl,omitempty"`            // Where to get the latest release
+	Notes                 *string `json:"notes,omitempty"`                 // Release notes
+	LatestProtocolVersion *int    `json:"latestProtocolVersion,omitempty"` // Protocol the latest release speaks, when the manifest says
+	CheckedAt             *string `json:"checkedAt,omitempty"`             // ISO timestamp of the last check
+	Error                 *string `json:"error,omitempty"`                 // Why the last check failed, or that checks are off
+}
+
+// PortRange is an inclusive range of ports
+type PortRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// ============================================================================
+// Profile Payloads
+// ============================================================================
+
+type ProfileListPayload struct {
+	// empty - no params needed
+}
+
+type ProfileListResultPayload struct {
+	Profiles []string  `json:"profiles"` // "default" first, then named profiles
+	Current  string    `json:"current"`
+	Code     ErrorCode `json:"code,omitempty"`
+	Error    *string   `json:"error,omitempty"`
+}
+
+// ============================================================================
+// Storage Encryption Payloads
+// ============================================================================
+
+type StorageEncryptNowPayload struct {
+	// empty - no params needed
+}
+
+// StorageEncryptProgressPayload reports storage_encrypt_now working through
+// a table: once as it starts, then after every batch of rows
+type StorageEncryptProgressPayload struct {
+	Table string `json:"table"` // "pty_history", "chat_history" or "process_metadata"
+	Done  int    `json:"done"`  // Rows encrypted so far
+	Total int    `json:"total"` // Rows not yet encrypted when the table was started
+}
+
+type StorageEncryptResultPayload struct {
+	Success   bool      `json:"success"`
+	Encrypted int       `json:"encrypted"` // Rows encrypted, in every table
+	Code      ErrorCode `json:"code,omitempty"`
+	Error     *string   `json:"error,omitempty"`
+}
+
+// ============================================================================
+// Storage Flush Payloads
+// ============================================================================
+
+// StorageFlushPayload saves buffered PTY history and chat to the database
+// now, e.g. before an operation that might take the bridge down
+type StorageFlushPayload struct {
+	RequestID string `json:"requestId,omitempty"` // Echoed in the result
+	ProcessID string `json:"processId,omitempty"` // Only this process's buffers; every process's when empty
+}
+
+type StorageFlushResultPayload struct {
+	RequestID  string    `json:"requestId,omitempty"`
+	ProcessID  string    `json:"processId,omitempty"`
+	Success    bool      `json:"success"`
+	DurationMs int64     `json:"durationMs"`
+	Code       ErrorCode `json:"code,omitempty"`
+	Error      *string   `json:"error,omitempty"`
 }
@@ -0,0 +1,359 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// Payload Validation
+// ============================================================================
+//
+// Request payloads declare their constraints in validate tags, as a
+// comma-separated list of rules:
+//
+//	required     strings must not be blank, numbers not zero, pointers not
+//	             nil and slices and maps not empty
+//	min=N max=N  bounds on a number, or on the length of a string or slice
+//	oneof=a b c  the value must be one of the listed words
+//	color        a string must be one of AppearanceColors or a hex color
+//	icon         a string must be one of AppearanceIcons
+//
+// color and icon take "" as no color or icon. min, max, oneof, color and
+// icon only apply to fields that are set: a zero value that
+// isn't required is taken as omitted, and a nil pointer is skipped. A pointer
+// that is set has its value checked, so {"port": 0} fails min=1 on a *int.
+// Structs, embedded structs and slices of structs are checked field by field.
+
+// FieldError is one payload field that failed validation
+type FieldError struct {
+	Field   string `json:"field"` // JSON path, e.g. "customVars[1].key"
+	Rule    string `json:"rule"`  // "required", "min", "max", "oneof", "color", "icon" or "type"
+	Message string `json:"message"`
+}
+
+// ValidationErrorDetails is the Details of a VALIDATION_ERROR
+type ValidationErrorDetails struct {
+	Type   string       `json:"type"` // Message type of the rejected request
+	Fields []FieldError `json:"fields"`
+}
+
+// requestPayloads maps each App → Bridge message type to its payload type.
+// Types without a payload (host_config_list, snippet_list, ...) aren't listed.
+var requestPayloads = map[string]reflect.Type{
+	TypeAuth:                      reflect.TypeOf(AuthPayload{}),
+	TypeSessionRefreshToken:       reflect.TypeOf(SessionRefreshTokenPayload{}),
+	TypeSessionInviteCreate:       reflect.TypeOf(SessionInviteCreatePayload{}),
+	TypeSessionInviteRevoke:       reflect.TypeOf(SessionInviteRevokePayload{}),
+	TypeHostConfigCreate:          reflect.TypeOf(HostConfigCreatePayload{}),
+	TypeHostConfigUpdate:          reflect.TypeOf(HostConfigUpdatePayload{}),
+	TypeHostConfigDelete:          reflect.TypeOf(HostConfigDeletePayload{}),
+	TypeHostConfigImportSSHConfig: reflect.TypeOf(HostConfigImportSSHConfigPayload{}),
+	TypeHostConnect:               reflect.TypeOf(HostConnectPayload{}),
+	TypeHostDisconnect:            reflect.TypeOf(HostDisconnectPayload{}),
+	TypeHostCheckRequirements:     reflect.TypeOf(HostCheckRequirementsPayload{}),
+	TypeHostStatusRequest:         reflect.TypeOf(HostStatusRequestPayload{}),
+	TypeHostExec:                  reflect.TypeOf(HostExecPayload{}),
+	TypeHostDiagnostics:           reflect.TypeOf(HostDiagnosticsPayload{}),
+	TypeProcessList:               reflect.TypeOf(ProcessListPayload{}),
+	TypeProcessCreate:             reflect.TypeOf(ProcessCreatePayload{}),
+	TypeProcessKill:               reflect.TypeOf(ProcessKillPayload{}),
+	TypeProcessSelect:             reflect.TypeOf(ProcessSelectPayload{}),
+	TypeProcessReattach:           reflect.TypeOf(ProcessReattachPayload{}),
+	TypeProcessRename:             reflect.TypeOf(ProcessRenamePayload{}),
+	TypeProcessClone:              reflect.TypeOf(ProcessClonePayload{}),
+	TypeProcessPin:                reflect.TypeOf(ProcessPinPayload{}),
+	TypeProcessSetOrder:           reflect.TypeOf(ProcessSetOrderPayload{}),
+	TypeProcessTermOptions:        reflect.TypeOf(ProcessTermOptionsPayload{}),
+	TypeProcessClearError:         reflect.TypeOf(ProcessClearErrorPayload{}),
+	TypeProcessSetAppearance:      reflect.TypeOf(ProcessSetAppearancePayload{}),
+	TypeProcessEnableTimeline:     reflect.TypeOf(ProcessEnableTimelinePayload{}),
+	TypeProcessTimelineList:       reflect.TypeOf(ProcessTimelineListPayload{}),
+	TypeArchivedProcessList:       reflect.TypeOf(ArchivedProcessListPayload{}),
+	TypeArchivedProcessGet:        reflect.TypeOf(ArchivedProcessGetPayload{}),
+	TypeArchivedProcessDelete:     reflect.TypeOf(ArchivedProcessDeletePayload{}),
+	TypeProcessesSubscribe:        reflect.TypeOf(ProcessesSubscribePayload{}),
+	TypeProcessesUnsubscribe:      reflect.TypeOf(ProcessesUnsubscribePayload{}),
+	TypeClaudeStart:               reflect.TypeOf(ClaudeStartPayload{}),
+	TypeClaudeKill:                reflect.TypeOf(ClaudeKillPayload{}),
+	TypeClaudeReattachTerminal:    reflect.TypeOf(ClaudeReattachTerminalPayload{}),
+	TypePtyInput:                  reflect.TypeOf(PtyInputPayload{}),
+	TypePtyResize:                 reflect.TypeOf(PtyResizePayload{}),
+	TypePtyScroll:                 reflect.TypeOf(PtyScrollPayload{}),
+	TypePtyHistoryRequest:         reflect.TypeOf(PtyHistoryRequestPayload{}),
+	TypeChatSubscribe:             reflect.TypeOf(ChatSubscribePayload{}),
+	TypeChatUnsubscribe:           reflect.TypeOf(ChatUnsubscribePayload{}),
+	TypeChatSend:                  reflect.TypeOf(ChatSendPayload{}),
+	TypeChatRaw:                   reflect.TypeOf(ChatRawPayload{}),
+	TypeChatStatus:                reflect.TypeOf(ChatStatusPayload{}),
+	TypeChatHistory:               reflect.TypeOf(ChatHistoryPayload{}),
+	TypeChatSearch:                reflect.TypeOf(ChatSearchPayload{}),
+	TypeChatUsage:                 reflect.TypeOf(ChatUsagePayload{}),
+	TypeChatDraftSet:              reflect.TypeOf(ChatDraftSetPayload{}),
+	TypeChatDraftGet:              reflect.TypeOf(ChatDraftGetPayload{}),
+	TypeEnvList:                   reflect.TypeOf(EnvListPayload{}),
+	TypeEnvUpdate:                 reflect.TypeOf(EnvUpdatePayload{}),
+	TypeEnvSetRcFile:              reflect.TypeOf(EnvSetRcFilePayload{}),
+	TypeEnvReveal:                 reflect.TypeOf(EnvRevealPayload{}),
+	TypeProcessEnvList:            reflect.TypeOf(ProcessEnvListPayload{}),
+	TypePortsScan:                 reflect.TypeOf(PortsScanPayload{}),
+	TypeFileDownload:              reflect.TypeOf(FileDownloadPayload{}),
+	TypeFileDownloadCancel:        reflect.TypeOf(FileDownloadCancelPayload{}),
+	TypeFileUploadBegin:           reflect.TypeOf(FileUploadBeginPayload{}),
+	TypeFileUploadChunk:           reflect.TypeOf(FileUploadChunkPayload{}),
+	TypeFileUploadCommit:          reflect.TypeOf(FileUploadCommitPayload{}),
+	TypeSnippetCreate:             reflect.TypeOf(SnippetCreatePayload{}),
+	TypeSnippetUpdate:             reflect.TypeOf(SnippetUpdatePayload{}),
+	TypeSnippetDelete:             reflect.TypeOf(SnippetDeletePayload{}),
+	TypeSnippetExecute:            reflect.TypeOf(SnippetExecutePayload{}),
+	TypeWorkspaceCreate:           reflect.TypeOf(WorkspaceCreatePayload{}),
+	TypeWorkspaceUpdate:           reflect.TypeOf(WorkspaceUpdatePayload{}),
+	TypeWorkspaceDelete:           reflect.TypeOf(WorkspaceDeletePayload{}),
+	TypeWorkspaceAssign:           reflect.TypeOf(WorkspaceAssignPayload{}),
+	TypeProcessTemplateCreate:     reflect.TypeOf(ProcessTemplateCreatePayload{}),
+	TypeProcessTemplateUpdate:     reflect.TypeOf(ProcessTemplateUpdatePayload{}),
+	TypeProcessTemplateDelete:     reflect.TypeOf(ProcessTemplateDeletePayload{}),
+	TypeProcessCreateFromTemplate: reflect.TypeOf(ProcessCreateFromTemplatePayload{}),
+	TypeStorageFlush:              reflect.TypeOf(StorageFlushPayload{}),
+}
+
+// RequestPayload returns a new zero payload for a request type, as a pointer,
+// or nil if the type takes no payload
+func RequestPayload(msgType string) interface{} {
+	t, ok := requestPayloads[msgType]
+	if !ok {
+		return nil
+	}
+	return reflect.New(t).Interface()
+}
+
+// DecodePayload decodes a request's payload into a new value of its payload
+// type and validates it. It returns the decoded payload (nil for types
+// without one) and the fields that failed; a payload that doesn't decode is
+// reported as a single "type" failure.
+func DecodePayload(msg *Message) (interface{}, []FieldError) {
+	payload := RequestPayload(msg.Type)
+	if payload == nil {
+		return nil, nil
+	}
+	if len(msg.Payload) > 0 && string(msg.Payload) != "null" {
+		if err := json.Unmarshal(msg.Payload, payload); err != nil {
+			return nil, []FieldError{decodeError(err)}
+		}
+	}
+	return payload, Validate(payload)
+}
+
+// decodeError describes a payload that isn't valid JSON for its type
+func decodeError(err error) FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return FieldError{Field: typeErr.Field, Rule: "type", Message: fmt.Sprintf("must be a %s", jsonKind(typeErr.Type))}
+	}
+	return FieldError{Field: "", Rule: "type", Message: err.Error()}
+}
+
+// jsonKind names the JSON kind a Go type is decoded from
+func jsonKind(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	default:
+		return "number"
+	}
+}
+
+// Validate checks v's fields against their validate tags. v is a struct or a
+// pointer to one.
+func Validate(v interface{}) []FieldError {
+	var errs []FieldError
+	validateStruct(reflect.Indirect(reflect.ValueOf(v)), "", &errs)
+	return errs
+}
+
+func validateStruct(v reflect.Value, prefix string, errs *[]FieldError) {
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		value := v.Field(i)
+		if field.Anonymous {
+			validateStruct(reflect.Indirect(value), prefix, errs)
+			continue
+		}
+		name := jsonName(field)
+		if name == "-" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		validateField(value, name, field.Tag.Get("validate"), errs)
+	}
+}
+
+// jsonName is the name a field has in JSON
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func validateField(value reflect.Value, name, tag string, errs *[]FieldError) {
+	rules := parseRules(tag)
+	_, required := rules["required"]
+
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			if required {
+				*errs = append(*errs, FieldError{Field: name, Rule: "required", Message: "is required"})
+			}
+			return
+		}
+		// A pointer that is set is checked even if it points at a zero value
+		value = value.Elem()
+		required = true
+	} else if required && isBlank(value) {
+		*errs = append(*errs, FieldError{Field: name, Rule: "required", Message: "is required"})
+		return
+	}
+
+	if required || !value.IsZero() {
+		for _, rule := range []string{"min", "max", "oneof", "color", "icon"} {
+			if arg, ok := rules[rule]; ok {
+				if err := checkRule(value, rule, arg); err != "" {
+					*errs = append(*errs, FieldError{Field: name, Rule: rule, Message: err})
+				}
+			}
+		}
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		validateStruct(value, name, errs)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			validateStruct(reflect.Indirect(value.Index(i)), fmt.Sprintf("%s[%d]", name, i), errs)
+		}
+	}
+}
+
+// parseRules splits a validate tag into rule names and their arguments
+func parseRules(tag string) map[string]string {
+	rules := make(map[string]string)
+	for _, rule := range strings.Split(tag, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(rule, "=")
+		rules[name] = arg
+	}
+	return rules
+}
+
+// isBlank reports whether a required value is missing
+func isBlank(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	default:
+		return value.IsZero()
+	}
+}
+
+// checkRule checks one min, max, oneof, color or icon rule, returning why it
+// failed or ""
+func checkRule(value reflect.Value, rule, arg string) string {
+	switch rule {
+	case "color":
+		if value.Kind() == reflect.String && !validColor(value.String()) {
+			return "must be a hex color (#rgb or #rrggbb) or one of " + strings.Join(AppearanceColors, ", ")
+		}
+		return ""
+	case "icon":
+		if value.Kind() == reflect.String && value.String() != "" && !slices.Contains(AppearanceIcons, value.String()) {
+			return "must be one of " + strings.Join(AppearanceIcons, ", ")
+		}
+		return ""
+	}
+
+	if rule == "oneof" {
+		allowed := strings.Fields(arg)
+		got := fmt.Sprint(value.Interface())
+		for _, a := range allowed {
+			if got == a {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(allowed, ", ")
+	}
+
+	bound, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		panic(fmt.Sprintf("protocol: bad %s=%q in validate tag", rule, arg))
+	}
+	var n float64
+	what := ""
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = value.Float()
+	case reflect.String, reflect.Slice, reflect.Map:
+		n = float64(value.Len())
+		what = "length "
+	default:
+		return ""
+	}
+	if rule == "min" && n < bound {
+		return fmt.Sprintf("%smust be at least %s", what, arg)
+	}
+	if rule == "max" && n > bound {
+		return fmt.Sprintf("%smust be at most %s", what, arg)
+	}
+	return ""
+}
+
+// validColor reports whether s is "", one of AppearanceColors, or a hex
+// color, "#rgb" or "#rrggbb"
+func validColor(s string) bool {
+	if s == "" || slices.Contains(AppearanceColors, s) {
+		return true
+	}
+	hex, ok := strings.CutPrefix(s, "#")
+	if !ok || (len(hex) != 3 && len(hex) != 6) {
+		return false
+	}
+	for _, c := range hex {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,240 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func intPtr(n int) *int       { return &n }
+func strPtr(s string) *string { return &s }
+
+// TestValidatePayloads checks a valid and an invalid payload of every
+// request type, and that the invalid one fails on exactly the fields listed
+func TestValidatePayloads(t *testing.T) {
+	envDiff, envBogus := ProcessEnvModeDiff, ProcessEnvMode("bogus")
+	tooManyHooks := make([]string, 21)
+	tests := []struct {
+		msgType string
+		valid   interface{}
+		invalid interface{}
+		fields  []string // "field:rule", sorted
+	}{
+		{TypeAuth,
+			AuthPayload{DefaultCols: intPtr(60), DefaultRows: intPtr(40), DeviceLabel: strPtr("phone"), Role: strPtr("observer")},
+			AuthPayload{DefaultCols: intPtr(2000), DefaultRows: intPtr(5), DeviceLabel: strPtr(strings.Repeat("x", 65)), Role: strPtr("admin")},
+			[]string{"defaultCols:max", "defaultRows:min", "deviceLabel:max", "role:oneof"}},
+		{TypeSessionRefreshToken, SessionRefreshTokenPayload{ReconnectToken: "tok"}, SessionRefreshTokenPayload{}, []string{"reconnectToken:required"}},
+		{TypeSessionInviteCreate,
+			SessionInviteCreatePayload{ProcessID: strPtr("proc-1"), ExpiresInSeconds: intPtr(3600), Label: strPtr("alex")},
+			SessionInviteCreatePayload{Role: strPtr("admin"), ExpiresInSeconds: intPtr(5), Label: strPtr(strings.Repeat("x", 65))},
+			[]string{"expiresInSeconds:min", "label:max", "role:oneof"}},
+		{TypeSessionInviteRevoke, SessionInviteRevokePayload{ID: "invite-1"}, SessionInviteRevokePayload{}, []string{"id:required"}},
+		{TypeHostConfigCreate,
+			HostConfigCreatePayload{Name: "box", Host: "10.0.0.2", Port: 22, Username: "me", AuthType: "agent", Color: "#1e90ff", Icon: "server"},
+			HostConfigCreatePayload{Name: " ", Host: "10.0.0.2", Port: 70000, AuthType: "token", Color: "#12345", Icon: "unicorn"},
+			[]string{"authType:oneof", "color:color", "icon:icon", "name:required", "port:max", "username:required"}},
+		{TypeHostConfigUpdate,
+			HostConfigUpdatePayload{ID: "host-1", Port: intPtr(2222), AuthType: strPtr("key"), MaxConcurrentOps: intPtr(0), Color: strPtr(""), Icon: strPtr(""), StartupHooks: &[]string{}},
+			HostConfigUpdatePayload{Name: strPtr(""), Port: intPtr(0), AuthType: strPtr("token"), MaxConcurrentOps: intPtr(65), Color: strPtr("crimson"), StartupHooks: &tooManyHooks},
+			[]string{"authType:oneof", "color:color", "id:required", "maxConcurrentOps:max", "name:min", "port:min", "startupHooks:max"}},
+		{TypeHostConfigDelete, HostConfigDeletePayload{ID: "host-1", Confirmation: Confirmation{ConfirmToken: "t"}}, HostConfigDeletePayload{}, []string{"id:required"}},
+		{TypeHostConfigImportSSHConfig, HostConfigImportSSHConfigPayload{Select: []string{"devbox"}}, nil, nil},
+		{TypeHostConnect, HostConnectPayload{HostID: "host-1"}, HostConnectPayload{WantProgress: true}, []string{"hostId:required"}},
+		{TypeHostDisconnect, HostDisconnectPayload{HostID: "host-1"}, HostDisconnectPayload{}, []string{"hostId:required"}},
+		{TypeHostCheckRequirements, HostCheckRequirementsPayload{HostID: "host-1"}, HostCheckRequirementsPayload{}, []string{"hostId:required"}},
+		{TypeHostStatusRequest, HostStatusRequestPayload{HostID: "host-1"}, HostStatusRequestPayload{}, []string{"hostId:required"}},
+		{TypeHostExec,
+			HostExecPayload{HostID: "host-1", Command: "uptime", TimeoutMs: intPtr(1000)},
+			HostExecPayload{HostID: "host-1", Command: "  ", TimeoutMs: intPtr(0)},
+			[]string{"command:required", "timeoutMs:min"}},
+		{TypeHostDiagnostics, HostDiagnosticsPayload{HostID: "host-1"}, HostDiagnosticsPayload{Record: true}, []string{"hostId:required"}},
+		{TypeProcessList, ProcessListPayload{HostID: "host-1"}, ProcessListPayload{}, []string{"hostId:required"}},
+		{TypeProcessCreate,
+			ProcessCreatePayload{HostID: "host-1", Cols: intPtr(80), Rows: intPtr(24), PtyOutput: &PtyOutputOverride{CoalesceWindowMs: intPtr(0)}},
+			ProcessCreatePayload{HostID: "host-1", Cols: intPtr(0), Rows: intPtr(1001),
+				PtyOutput: &PtyOutputOverride{ReadBufferSize: intPtr(100), CoalesceWindowMs: intPtr(501), MaxCoalescedBytes: intPtr(1 << 21)}},
+			[]string{"cols:min", "ptyOutput.coalesceWindowMs:max", "ptyOutput.maxCoalescedBytes:max", "ptyOutput.readBufferSize:min", "rows:max"}},
+		{TypeProcessKill, ProcessKillPayload{ProcessID: "proc-1"}, ProcessKillPayload{Confirmation: Confirmation{ConfirmRequired: true}}, []string{"processId:required"}},
+		{TypeProcessSelect, ProcessSelectPayload{ProcessID: "proc-1"}, ProcessSelectPayload{}, []string{"processId:required"}},
+		{TypeProcessReattach,
+			ProcessReattachPayload{HostID: "host-1", TmuxSession: "rc-proc-1", ProcessID: "proc-1"},
+			ProcessReattachPayload{HostID: "host-1", Cols: intPtr(5)},
+			[]string{"cols:min", "processId:required", "tmuxSession:required"}},
+		{TypeProcessRename, ProcessRenamePayload{ProcessID: "proc-1", Name: "build"}, ProcessRenamePayload{ProcessID: "proc-1"}, []string{"name:required"}},
+		{TypeProcessClone,
+			ProcessClonePayload{SourceProcessID: "proc-1", Rows: intPtr(50)},
+			ProcessClonePayload{Cols: intPtr(9)},
+			[]string{"cols:min", "sourceProcessId:required"}},
+		{TypeProcessPin, ProcessPinPayload{ProcessID: "proc-1", Pinned: true}, ProcessPinPayload{Pinned: true}, []string{"processId:required"}},
+		{TypeProcessSetOrder, ProcessSetOrderPayload{HostID: "host-1", ProcessIDs: []string{}}, ProcessSetOrderPayload{ProcessIDs: []string{"proc-1"}}, []string{"hostId:required"}},
+		{TypeProcessTermOptions, ProcessTermOptionsPayload{ProcessID: "proc-1", Options: map[string]string{"mouse": "on"}}, ProcessTermOptionsPayload{}, []string{"processId:required"}},
+		{TypeProcessClearError, ProcessClearErrorPayload{ProcessID: "proc-1"}, ProcessClearErrorPayload{}, []string{"processId:required"}},
+		{TypeProcessSetAppearance,
+			ProcessSetAppearancePayload{ProcessID: "proc-1", Color: strPtr("teal"), Icon: strPtr("rocket")},
+			ProcessSetAppearancePayload{Color: strPtr("#ggg"), Icon: strPtr("Rocket")},
+			[]string{"color:color", "icon:icon", "processId:required"}},
+		{TypeProcessEnableTimeline, ProcessEnableTimelinePayload{ProcessID: "proc-1"}, ProcessEnableTimelinePayload{Disable: true}, []string{"processId:required"}},
+		{TypeProcessTimelineList,
+			ProcessTimelineListPayload{ProcessID: "proc-1", Limit: 20},
+			ProcessTimelineListPayload{ProcessID: "proc-1", Limit: -1},
+			[]string{"limit:min"}},
+		{TypeArchivedProcessList, ArchivedProcessListPayload{}, nil, nil},
+		{TypeArchivedProcessGet, ArchivedProcessGetPayload{ProcessID: "proc-1"}, ArchivedProcessGetPayload{}, []string{"processId:required"}},
+		{TypeArchivedProcessDelete, ArchivedProcessDeletePayload{ProcessID: "proc-1"}, ArchivedProcessDeletePayload{}, []string{"processId:required"}},
+		{TypeProcessesSubscribe, ProcessesSubscribePayload{HostID: "host-1"}, ProcessesSubscribePayload{}, []string{"hostId:required"}},
+		{TypeProcessesUnsubscribe, ProcessesUnsubscribePayload{HostID: "host-1"}, ProcessesUnsubscribePayload{}, []string{"hostId:required"}},
+		{TypeClaudeStart, ClaudeStartPayload{ProcessID: "proc-1", ClaudeArgs: strPtr("--resume")}, ClaudeStartPayload{}, []string{"processId:required"}},
+		{TypeClaudeKill, ClaudeKillPayload{ProcessID: "proc-1"}, ClaudeKillPayload{}, []string{"processId:required"}},
+		{TypeClaudeReattachTerminal, ClaudeReattachTerminalPayload{ProcessID: "proc-1"}, ClaudeReattachTerminalPayload{}, []string{"processId:required"}},
+		{TypePtyInput, PtyInputPayload{ProcessID: "proc-1", Data: "ls\r", Mode: InputModeText}, PtyInputPayload{Data: "ls\r", Mode: "binary"}, []string{"mode:oneof", "processId:required"}},
+		{TypePtyResize,
+			PtyResizePayload{ProcessID: "proc-1", Cols: 10, Rows: 1000},
+			PtyResizePayload{ProcessID: "proc-1", Rows: 5},
+			[]string{"cols:required", "rows:min"}},
+		{TypePtyScroll,
+			PtyScrollPayload{ProcessID: "proc-1", Action: PtyScrollPageUp, Count: intPtr(2)},
+			PtyScrollPayload{ProcessID: "proc-1", Action: "left", Count: intPtr(0)},
+			[]string{"action:oneof", "count:min"}},
+		{TypePtyHistoryRequest, PtyHistoryRequestPayload{ProcessID: "proc-1"}, PtyHistoryRequestPayload{ProcessID: "proc-1", ChunkSize: intPtr(0)}, []string{"chunkSize:min"}},
+		{TypeChatSubscribe, ChatSubscribePayload{HostID: "host-1", ProcessID: "*"}, ChatSubscribePayload{ProcessID: "proc-1"}, []string{"hostId:required"}},
+		{TypeChatUnsubscribe, ChatUnsubscribePayload{HostID: "host-1", ProcessID: "proc-1"}, ChatUnsubscribePayload{HostID: "host-1"}, []string{"processId:required"}},
+		{TypeChatSend, ChatSendPayload{ProcessID: "proc-1", Content: "hello"}, ChatSendPayload{HostID: "host-1", ProcessID: "proc-1", ClientMessageID: strPtr(strings.Repeat("x", 65))}, []string{"clientMessageId:max", "content:required"}},
+		{TypeChatRaw, ChatRawPayload{ProcessID: "proc-1", Content: "\r", Mode: InputModeKeys}, ChatRawPayload{Content: "y", Mode: "bytes"}, []string{"mode:oneof", "processId:required"}},
+		{TypeChatStatus, ChatStatusPayload{ProcessID: "proc-1"}, ChatStatusPayload{HostID: "host-1"}, []string{"processId:required"}},
+		{TypeChatHistory, ChatHistoryPayload{ProcessID: "proc-1"}, ChatHistoryPayload{HostID: "host-1"}, []string{"processId:required"}},
+		{TypeChatSearch,
+			ChatSearchPayload{Query: "deploy", Role: "assistant"},
+			ChatSearchPayload{Query: "  ", Role: "system", Limit: -5},
+			[]string{"limit:min", "query:required", "role:oneof"}},
+		{TypeChatUsage, ChatUsagePayload{ProcessID: "proc-1"}, ChatUsagePayload{}, []string{"processId:required"}},
+		{TypeChatDraftSet, ChatDraftSetPayload{ProcessID: "proc-1", Clear: true}, ChatDraftSetPayload{Text: "hi"}, []string{"processId:required"}},
+		{TypeChatDraftGet, ChatDraftGetPayload{ProcessID: "proc-1"}, ChatDraftGetPayload{}, []string{"processId:required"}},
+		{TypeEnvList, EnvListPayload{HostID: "host-1"}, EnvListPayload{}, []string{"hostId:required"}},
+		{TypeEnvUpdate,
+			EnvUpdatePayload{HostID: "host-1", CustomVars: []EnvVar{{Key: "EDITOR", Value: "vim"}}},
+			EnvUpdatePayload{HostID: "host-1", CustomVars: []EnvVar{{Key: "EDITOR"}, {Value: "orphan"}}},
+			[]string{"customVars[1].key:required"}},
+		{TypeEnvSetRcFile, EnvSetRcFilePayload{HostID: "host-1", RcFile: "~/.zshrc"}, EnvSetRcFilePayload{RcFile: "~/.zshrc"}, []string{"hostId:required"}},
+		{TypeEnvReveal, EnvRevealPayload{HostID: "host-1", Key: "TOKEN"}, EnvRevealPayload{}, []string{"hostId:required", "key:required"}},
+		{TypeProcessEnvList, ProcessEnvListPayload{ProcessID: "proc-1", Mode: &envDiff}, ProcessEnvListPayload{Mode: &envBogus}, []string{"mode:oneof", "processId:required"}},
+		{TypePortsScan, PortsScanPayload{HostID: "host-1"}, PortsScanPayload{}, []string{"hostId:required"}},
+		{TypeFileDownload,
+			FileDownloadPayload{DownloadID: "dl-1", HostID: "host-1", Path: "dist/app.apk", ProcessID: strPtr("proc-1")},
+			FileDownloadPayload{HostID: "host-1", Path: " "},
+			[]string{"downloadId:required", "path:required"}},
+		{TypeFileDownloadCancel, FileDownloadCancelPayload{DownloadID: "dl-1"}, FileDownloadCancelPayload{}, []string{"downloadId:required"}},
+		{TypeFileUploadBegin,
+			FileUploadBeginPayload{UploadID: "up-1", HostID: "host-1", Path: "notes.txt", SHA256: "e3b0c442", Mode: strPtr("0600")},
+			FileUploadBeginPayload{UploadID: "up-1", Path: "notes.txt", Size: -1},
+			[]string{"hostId:required", "sha256:required", "size:min"}},
+		{TypeFileUploadChunk, FileUploadChunkPayload{UploadID: "up-1", Data: "aGk="}, FileUploadChunkPayload{UploadID: "up-1", Seq: -1}, []string{"seq:min"}},
+		{TypeFileUploadCommit, FileUploadCommitPayload{UploadID: "up-1"}, FileUploadCommitPayload{}, []string{"uploadId:required"}},
+		{TypeSnippetCreate, SnippetCreatePayload{Name: "deploy", Content: "make deploy"}, SnippetCreatePayload{Content: "make deploy"}, []string{"name:required"}},
+		{TypeSnippetUpdate, SnippetUpdatePayload{ID: "snip-1", Content: strPtr("")}, SnippetUpdatePayload{ID: "snip-1", Name: strPtr("")}, []string{"name:min"}},
+		{TypeSnippetDelete, SnippetDeletePayload{ID: "snip-1"}, SnippetDeletePayload{}, []string{"id:required"}},
+		{TypeSnippetExecute, SnippetExecutePayload{SnippetID: "snip-1", ProcessIDs: []string{"proc-1"}, Mode: InputModeRaw}, SnippetExecutePayload{ProcessID: "proc-1", Mode: "shell"}, []string{"mode:oneof", "snippetId:required"}},
+		{TypeWorkspaceCreate, WorkspaceCreatePayload{Name: "work"}, WorkspaceCreatePayload{}, []string{"name:required"}},
+		{TypeWorkspaceUpdate, WorkspaceUpdatePayload{ID: "ws-1", Name: strPtr("home")}, WorkspaceUpdatePayload{Name: strPtr("")}, []string{"id:required", "name:min"}},
+		{TypeWorkspaceDelete, WorkspaceDeletePayload{ID: "ws-1"}, WorkspaceDeletePayload{}, []string{"id:required"}},
+		{TypeWorkspaceAssign, WorkspaceAssignPayload{ProcessID: "proc-1"}, WorkspaceAssignPayload{WorkspaceID: strPtr("ws-1")}, []string{"processId:required"}},
+		{TypeProcessTemplateCreate,
+			ProcessTemplateCreatePayload{Name: "api", Env: []EnvVar{{Key: "A"}}, StartupHooks: []string{"make deps"}},
+			ProcessTemplateCreatePayload{Env: []EnvVar{{Value: "x"}}, StartupHooks: tooManyHooks},
+			[]string{"env[0].key:required", "name:required", "startupHooks:max"}},
+		{TypeProcessTemplateUpdate,
+			ProcessTemplateUpdatePayload{ID: "tmpl-1", Name: strPtr("api"), StartupHooks: &[]string{}},
+			ProcessTemplateUpdatePayload{Name: strPtr(""), StartupHooks: &tooManyHooks},
+			[]string{"id:required", "name:min", "startupHooks:max"}},
+		{TypeProcessTemplateDelete, ProcessTemplateDeletePayload{ID: "tmpl-1"}, ProcessTemplateDeletePayload{}, []string{"id:required"}},
+		{
+			TypeProcessCreateFromTemplate,
+			ProcessCreateFromTemplatePayload{TemplateID: "tmpl-1", Cols: intPtr(80)},
+			ProcessCreateFromTemplatePayload{Cols: intPtr(5), Rows: intPtr(5000)},
+			[]string{"cols:min", "rows:max", "templateId:required"},
+		},
+		{TypeStorageFlush, StorageFlushPayload{ProcessID: "proc-1"}, nil, nil},
+	}
+
+	covered := make(map[string]bool)
+	for _, tt := range tests {
+		covered[tt.msgType] = true
+
+		if got := decodeFields(t, tt.msgType, tt.valid); len(got) != 0 {
+			t.Errorf("%s: valid payload failed on %v", tt.msgType, got)
+		}
+		if tt.invalid == nil {
+			continue
+		}
+		if got := decodeFields(t, tt.msgType, tt.invalid); !reflect.DeepEqual(got, tt.fields) {
+			t.Errorf("%s: invalid payload failed on %v, want %v", tt.msgType, got, tt.fields)
+		}
+	}
+
+	for msgType := range requestPayloads {
+		if !covered[msgType] {
+			t.Errorf("%s has no validation test", msgType)
+		}
+	}
+}
+
+// decodeFields sends payload through DecodePayload as a msgType message and
+// returns the failures as sorted "field:rule" strings
+func decodeFields(t *testing.T, msgType string, payload interface{}) []string {
+	t.Helper()
+	msg, err := NewMessage(msgType, payload)
+	if err != nil {
+		t.Fatalf("NewMessage: %v", err)
+	}
+	decoded, errs := DecodePayload(msg)
+	if decoded == nil && len(errs) == 0 {
+		t.Fatalf("%s has no registered payload", msgType)
+	}
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Field+":"+e.Rule)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestDecodePayloadErrors(t *testing.T) {
+	// A value of the wrong JSON type names the field
+	msg := &Message{Type: TypePtyResize, Payload: json.RawMessage(`{"processId":"proc-1","cols":"80","rows":24}`)}
+	_, errs := DecodePayload(msg)
+	if len(errs) != 1 || errs[0].Field != "cols" || errs[0].Rule != "type" || errs[0].Message != "must be a number" {
+		t.Errorf("wrong type: %+v", errs)
+	}
+
+	// A missing payload is validated as an empty one
+	_, errs = DecodePayload(&Message{Type: TypeChatSend})
+	if len(errs) != 2 {
+		t.Errorf("missing payload: %+v", errs)
+	}
+
+	// Types without a payload pass through
+	payload, errs := DecodePayload(&Message{Type: TypeSnippetList, Payload: json.RawMessage(`{}`)})
+	if payload != nil || errs != nil {
+		t.Errorf("snippet_list = %v, %v", payload, errs)
+	}
+}
+
+func TestValidColor(t *testing.T) {
+	for color, want := range map[string]bool{
+		"":        true,
+		"teal":    true,
+		"#0af":    true,
+		"#00AAFF": true,
+		"Teal":    false,
+		"crimson": false,
+		"00aaff":  false,
+		"#0aff":   false,
+		"#00aafg": false,
+	} {
+		if got := validColor(color); got != want {
+			t.Errorf("validColor(%q) = %v, want %v", color, got, want)
+		}
+	}
+}
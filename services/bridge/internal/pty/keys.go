@@ -0,0 +1,44 @@
+package pty
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/shellargs"
+	rcssh "github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// runTmux runs a tmux command line against the session on its host
+func (s *Session) runTmux(cmd string) (rcssh.BatchResult, error) {
+	s.mu.Lock()
+	sshClient := s.sshClient
+	s.mu.Unlock()
+
+	results, err := rcssh.RunBatch(sshClient, []string{s.tmux.Cmd(cmd)})
+	if err != nil {
+		return rcssh.BatchResult{}, err
+	}
+	if !results[0].OK() {
+		return results[0], fmt.Errorf("tmux exited with status %d", results[0].ExitCode)
+	}
+	return results[0], nil
+}
+
+// PaneCommand returns the name of the program in the foreground of the
+// tmux pane: the shell at its prompt, or the command it is running
+func (s *Session) PaneCommand() (string, error) {
+	result, err := s.runTmux(fmt.Sprintf("display-message -t %s -p '#{pane_current_command}'", s.TmuxName))
+	if err != nil {
+		return "", fmt.Errorf("failed to get pane command: %w", err)
+	}
+	return strings.TrimSpace(result.Output), nil
+}
+
+// SendLine types line into the tmux pane and presses Enter, as if the user
+// had. Unlike Write, it works while the session is detached.
+func (s *Session) SendLine(line string) error {
+	if _, err := s.runTmux(fmt.Sprintf(`send-keys -t %s -l %s \; send-keys -t %s Enter`, s.TmuxName, shellargs.Quote(line), s.TmuxName)); err != nil {
+		return fmt.Errorf("failed to send keys: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package pty
+
+import (
+	"fmt"
+	"time"
+)
+
+// Bounds of the output tuning settings
+const (
+	MinReadBufferSize = 512
+	MaxReadBufferSize = 1 << 20
+	MaxCoalesceWindow = 500 * time.Millisecond
+	MinCoalescedSize  = 1 << 10
+	MaxCoalescedSize  = 1 << 20
+)
+
+// OutputTuning trades output latency against message count. Each read of
+// the PTY is at most ReadBufferSize bytes. With a CoalesceWindow, output
+// read within the window after a chunk is merged into it, up to
+// MaxCoalescedBytes, so the first byte of a chunk waits at most the window.
+// A zero window delivers every read as it is.
+type OutputTuning struct {
+	ReadBufferSize    int
+	CoalesceWindow    time.Duration
+	MaxCoalescedBytes int
+}
+
+// DefaultOutputTuning returns the tuning used unless configured otherwise:
+// 4 KB reads, each delivered right away
+func DefaultOutputTuning() OutputTuning {
+	return OutputTuning{
+		ReadBufferSize:    4096,
+		MaxCoalescedBytes: 64 << 10,
+	}
+}
+
+// Validate checks every setting is within its bounds
+func (t OutputTuning) Validate() error {
+	if t.ReadBufferSize < MinReadBufferSize || t.ReadBufferSize > MaxReadBufferSize {
+		return fmt.Errorf("PTY read buffer size %d must be within %d-%d bytes", t.ReadBufferSize, MinReadBufferSize, MaxReadBufferSize)
+	}
+	if t.CoalesceWindow < 0 || t.CoalesceWindow > MaxCoalesceWindow {
+		return fmt.Errorf("PTY output coalescing window %s must be within 0-%s", t.CoalesceWindow, MaxCoalesceWindow)
+	}
+	if t.MaxCoalescedBytes < MinCoalescedSize || t.MaxCoalescedBytes > MaxCoalescedSize {
+		return fmt.Errorf("PTY coalesced output size %d must be within %d-%d bytes", t.MaxCoalescedBytes, MinCoalescedSize, MaxCoalescedSize)
+	}
+	return nil
+}
+
+// SetOutputTuning changes how the session reads and delivers output. The
+// read buffer size applies from the next attachment's read loops; the
+// coalescing settings from the next chunk delivered.
+func (s *Session) SetOutputTuning(tuning OutputTuning) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.output = tuning
+}
+
+// OutputTuning returns how the session reads and delivers output
+func (s *Session) OutputTuning() OutputTuning {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.outputTuning()
+}
+
+// outputTuning is OutputTuning for callers holding s.mu. A session that was
+// never tuned uses the defaults.
+func (s *Session) outputTuning() OutputTuning {
+	if s.output == (OutputTuning{}) {
+		return DefaultOutputTuning()
+	}
+	return s.output
+}
+
+// coalesceOutput merges the output chunks that arrive within the coalescing
+// window after ev into it, as long as they were read in the same state and
+// fit in the maximum size. next is the event that ended the merge, if one
+// was taken from the stream; ok is false if the session was killed.
+func (s *Session) coalesceOutput(ev outputEvent, events <-chan outputEvent, stopped <-chan struct{}) (merged outputEvent, next *outputEvent, ok bool) {
+	s.mu.Lock()
+	tuning := s.outputTuning()
+	s.mu.Unlock()
+	if tuning.CoalesceWindow <= 0 || len(ev.data) >= tuning.MaxCoalescedBytes {
+		return ev, nil, true
+	}
+
+	timer := time.NewTimer(tuning.CoalesceWindow)
+	defer timer.Stop()
+	merged = ev
+	for {
+		select {
+		case <-stopped:
+			return merged, nil, false
+		case <-timer.C:
+			return merged, nil, true
+		case more := <-events:
+			if !more.isOutput() || more.copyMode != merged.copyMode || more.muted != merged.muted ||
+				len(merged.data)+len(more.data) > tuning.MaxCoalescedBytes {
+				return merged, &more, true
+			}
+			// Each chunk's data is a copy the read loop made, so it can grow
+			merged.data = append(merged.data, more.data...)
+			if len(merged.data) == tuning.MaxCoalescedBytes {
+				return merged, nil, true
+			}
+		}
+	}
+}
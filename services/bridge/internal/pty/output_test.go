@@ -0,0 +1,152 @@
+package pty
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// loadResult is what the output handler received under synthetic load
+type loadResult struct {
+	messages int
+	maxBytes int
+	maxDelay time.Duration // Longest a chunk took from being written to being delivered
+}
+
+// runSyntheticLoad writes chunks small chunks to a session's read loop, one
+// per millisecond, each tagged with its index, and measures the deliveries
+func runSyntheticLoad(t *testing.T, tuning OutputTuning, chunks int) loadResult {
+	t.Helper()
+	reader, writer := io.Pipe()
+	s := &Session{ID: "proc-1", attached: true}
+	s.SetOutputTuning(tuning)
+
+	written := make([]time.Time, chunks)
+	var mu sync.Mutex
+	var res loadResult
+	var delivered strings.Builder
+	s.SetOutputHandler(func(data []byte) {
+		now := time.Now()
+		mu.Lock()
+		defer mu.Unlock()
+		res.messages++
+		res.maxBytes = max(res.maxBytes, len(data))
+		delivered.Write(data)
+		// Chunks are "<index>;" padded to 32 bytes; the first one in a
+		// message waited longest
+		first, _, _ := strings.Cut(strings.TrimLeft(string(data), "."), ";")
+		if i, err := strconv.Atoi(first); err == nil && i < chunks {
+			res.maxDelay = max(res.maxDelay, now.Sub(written[i]))
+		}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.readLoop(reader, "stdout")
+	}()
+	var want strings.Builder
+	for i := range chunks {
+		tag := strconv.Itoa(i) + ";"
+		chunk := strings.Repeat(".", 32-len(tag)) + tag
+		want.WriteString(chunk)
+		mu.Lock()
+		written[i] = time.Now()
+		mu.Unlock()
+		writer.Write([]byte(chunk))
+		time.Sleep(time.Millisecond)
+	}
+	writer.Close()
+	<-done
+	flushTestOutput(t, s)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if delivered.String() != want.String() {
+		t.Errorf("delivered %d bytes out of order or incomplete, want %d", delivered.Len(), want.Len())
+	}
+	return res
+}
+
+func TestOutputTuningTradesLatencyForMessages(t *testing.T) {
+	const chunks = 200
+
+	// Every read is sent right away
+	immediate := runSyntheticLoad(t, DefaultOutputTuning(), chunks)
+	if immediate.messages != chunks {
+		t.Errorf("without coalescing: %d messages, want one per write (%d)", immediate.messages, chunks)
+	}
+	if immediate.maxDelay >= 25*time.Millisecond {
+		t.Errorf("without coalescing: a chunk waited %s", immediate.maxDelay)
+	}
+
+	// Reads within 25ms are merged: far fewer messages, none held much
+	// longer than the window
+	window := 25 * time.Millisecond
+	coalesced := runSyntheticLoad(t, OutputTuning{ReadBufferSize: 4096, CoalesceWindow: window, MaxCoalescedBytes: 64 << 10}, chunks)
+	if coalesced.messages > chunks/4 {
+		t.Errorf("with a %s window: %d messages, want at most %d", window, coalesced.messages, chunks/4)
+	}
+	if coalesced.maxDelay > window+100*time.Millisecond {
+		t.Errorf("with a %s window: a chunk waited %s", window, coalesced.maxDelay)
+	}
+	t.Logf("immediate: %d messages, max delay %s; coalesced: %d messages, max delay %s",
+		immediate.messages, immediate.maxDelay, coalesced.messages, coalesced.maxDelay)
+
+	// The size cap ends a merge early
+	capped := runSyntheticLoad(t, OutputTuning{ReadBufferSize: 4096, CoalesceWindow: 400 * time.Millisecond, MaxCoalescedBytes: 1024}, chunks)
+	if capped.maxBytes > 1024 {
+		t.Errorf("merged message of %d bytes, want at most 1024", capped.maxBytes)
+	}
+	if capped.messages < chunks*32/1024 {
+		t.Errorf("with a 1 KB cap: %d messages, want at least %d", capped.messages, chunks*32/1024)
+	}
+}
+
+func TestReadBufferSizeBoundsReads(t *testing.T) {
+	s := &Session{ID: "proc-1", attached: true}
+	s.SetOutputTuning(OutputTuning{ReadBufferSize: 512, MaxCoalescedBytes: 1024})
+
+	var mu sync.Mutex
+	var sizes []int
+	s.SetOutputHandler(func(data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		sizes = append(sizes, len(data))
+	})
+	s.readLoop(bytes.NewReader(make([]byte, 4096)), "stdout")
+	flushTestOutput(t, s)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sizes) != 8 {
+		t.Errorf("read sizes = %v, want 8 reads of 512 bytes", sizes)
+	}
+}
+
+func TestOutputTuningValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		tuning OutputTuning
+		ok     bool
+	}{
+		{"defaults", DefaultOutputTuning(), true},
+		{"coalescing", OutputTuning{ReadBufferSize: 65536, CoalesceWindow: 50 * time.Millisecond, MaxCoalescedBytes: 256 << 10}, true},
+		{"read buffer too small", OutputTuning{ReadBufferSize: 100, MaxCoalescedBytes: 4096}, false},
+		{"read buffer too large", OutputTuning{ReadBufferSize: 2 << 20, MaxCoalescedBytes: 4096}, false},
+		{"negative window", OutputTuning{ReadBufferSize: 4096, CoalesceWindow: -time.Millisecond, MaxCoalescedBytes: 4096}, false},
+		{"window too long", OutputTuning{ReadBufferSize: 4096, CoalesceWindow: time.Second, MaxCoalescedBytes: 4096}, false},
+		{"merged size too small", OutputTuning{ReadBufferSize: 4096, MaxCoalescedBytes: 100}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.tuning.Validate(); (err == nil) != tt.ok {
+				t.Errorf("Validate() = %v, want ok=%v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -2,45 +2,96 @@ package pty
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
+	"path"
 	"strings"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	rcssh "github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
 )
 
 // TmuxSessionInfo contains information about a discovered tmux session
 type TmuxSessionInfo struct {
 	Name      string
-	ProcessID string // Extracted from session name (after rc- prefix)
+	ProcessID string // Extracted from session name (after rc- prefix); empty if unmanaged
 	Created   time.Time
 	Attached  bool
 	Width     int
 	Height    int
 }
 
-// ScanTmuxSessions scans for existing remote-claude tmux sessions on a host
-func ScanTmuxSessions(sshClient *ssh.Client) ([]TmuxSessionInfo, error) {
+// Managed reports whether the session's name embeds a valid process ID, i.e.
+// the bridge created it. Other rc-* sessions must not be reattached.
+func (t TmuxSessionInfo) Managed() bool {
+	return t.ProcessID != ""
+}
+
+// listSessionsCommand lists a host's tmux sessions in the format
+// parseTmuxSessions reads: name:created:attached:width:height
+func listSessionsCommand(tmux Tmux) string {
+	return tmux.Cmd(`list-sessions -F '#{session_name}:#{session_created}:#{session_attached}:#{session_width}:#{session_height}'`)
+}
+
+// ScanTmuxSessions scans for existing remote-claude tmux sessions on a host.
+// Sessions that carry the prefix but no valid process ID are returned
+// unmanaged. When ctx is done first, the scan is cut off and ctx's error
+// returned.
+func ScanTmuxSessions(ctx context.Context, sshClient *ssh.Client, tmux Tmux) ([]TmuxSessionInfo, error) {
 	session, err := sshClient.NewSession()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create SSH session: %w", err)
 	}
 	defer session.Close()
 
-	// List sessions with format: name:created:attached:width:height
+	// Closing the session hangs up on the command when ctx is done first
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	defer stop()
+
 	// Only list sessions starting with our prefix
-	cmd := fmt.Sprintf(`tmux list-sessions -F '#{session_name}:#{session_created}:#{session_attached}:#{session_width}:#{session_height}' 2>/dev/null | grep '^%s'`, TmuxSessionPrefix)
+	cmd := fmt.Sprintf(`%s 2>/dev/null | grep '^%s'`, listSessionsCommand(tmux), TmuxSessionPrefix)
 
 	var stdout bytes.Buffer
 	session.Stdout = &stdout
 
 	// Don't fail if no sessions exist (grep returns 1 if no matches)
 	session.Run(cmd)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sessions := parseTmuxSessions(stdout.String())
+	log.Printf("[DEBUG] [PTY] Scanned %d tmux sessions on host", len(sessions))
+	return sessions, nil
+}
 
+// ProbeTmuxSessions lists the remote-claude tmux sessions on a host with the
+// query ScanTmuxSessions uses, but tells a tmux server without sessions from
+// one that isn't running: running is false when list-sessions fails, as it
+// does when there is no server to connect to.
+func ProbeTmuxSessions(sshClient *ssh.Client, tmux Tmux) (sessions []TmuxSessionInfo, running bool, err error) {
+	results, err := rcssh.RunBatch(sshClient, []string{listSessionsCommand(tmux) + " 2>/dev/null"})
+	if err != nil {
+		return nil, false, err
+	}
+	switch result := results[0]; result.ExitCode {
+	case 0:
+		return parseTmuxSessions(result.Output), true, nil
+	case 127:
+		return nil, false, fmt.Errorf("tmux not found")
+	default:
+		return nil, false, nil
+	}
+}
+
+// parseTmuxSessions parses the list-sessions output of ScanTmuxSessions.
+// tmux doesn't allow ':' in session names, so the name is the first field.
+func parseTmuxSessions(output string) []TmuxSessionInfo {
 	var sessions []TmuxSessionInfo
-	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	lines := strings.Split(strings.TrimSpace(output), "\n")
 
 	for _, line := range lines {
 		if line == "" {
@@ -57,9 +108,9 @@ func ScanTmuxSessions(sshClient *ssh.Client) ([]TmuxSessionInfo, error) {
 			continue
 		}
 
-		processID := strings.TrimPrefix(name, TmuxSessionPrefix)
-		if processID == "" {
-			continue
+		processID, ok := ProcessIDFromTmuxName(name)
+		if !ok {
+			log.Printf("[DEBUG] [PTY] tmux session %q has no valid process ID, treating as unmanaged", name)
 		}
 
 		// Parse created timestamp (Unix epoch)
@@ -88,55 +139,79 @@ func ScanTmuxSessions(sshClient *ssh.Client) ([]TmuxSessionInfo, error) {
 		})
 	}
 
-	log.Printf("[DEBUG] [PTY] Scanned %d tmux sessions on host", len(sessions))
-	return sessions, nil
+	return sessions
 }
 
 // IsTmuxAvailable checks if tmux is installed on the remote host
-func IsTmuxAvailable(sshClient *ssh.Client) bool {
+func IsTmuxAvailable(sshClient *ssh.Client, tmux Tmux) bool {
 	session, err := sshClient.NewSession()
 	if err != nil {
 		return false
 	}
 	defer session.Close()
 
-	err = session.Run("which tmux >/dev/null 2>&1")
+	err = session.Run(tmux.availableCommand())
 	return err == nil
 }
 
 // TmuxSessionExists checks if a specific tmux session exists
-func TmuxSessionExists(sshClient *ssh.Client, tmuxName string) bool {
+func TmuxSessionExists(sshClient *ssh.Client, tmux Tmux, tmuxName string) bool {
 	session, err := sshClient.NewSession()
 	if err != nil {
 		return false
 	}
 	defer session.Close()
 
-	cmd := fmt.Sprintf("tmux has-session -t %s 2>/dev/null", tmuxName)
+	cmd := tmux.Cmdf("has-session -t %s 2>/dev/null", tmuxName)
 	err = session.Run(cmd)
 	return err == nil
 }
 
-// CheckRequirements checks if claude and agentapi are installed on the remote host
+// requiredCommands are the commands CheckRequirements looks for, in the
+// order their which checks are batched
+var requiredCommands = []string{"claude", "agentapi"}
+
+// defaultTmuxTmpDir is where tmux puts its sockets without TMUX_TMPDIR
+const defaultTmuxTmpDir = "/tmp"
+
+// tmuxTmpDirCommand prints TMUX_TMPDIR, batched after the which checks
+const tmuxTmpDirCommand = `printf '%s' "$TMUX_TMPDIR"`
+
+// CheckRequirements checks if claude and agentapi are installed on the
+// remote host, and whether TMUX_TMPDIR moves tmux's sockets out of /tmp
 func CheckRequirements(sshClient *ssh.Client) *protocol.HostRequirements {
 	requirements := &protocol.HostRequirements{
 		CheckedAt: time.Now().UTC().Format(time.RFC3339),
 	}
 
-	// Check for claude
-	claudePath := checkCommand(sshClient, "claude")
+	// Check for everything in one SSH session
+	cmds := make([]string, len(requiredCommands), len(requiredCommands)+1)
+	for i, cmd := range requiredCommands {
+		cmds[i] = fmt.Sprintf("which %s", cmd)
+	}
+	cmds = append(cmds, tmuxTmpDirCommand)
+	results, err := rcssh.RunBatch(sshClient, cmds)
+	if err != nil {
+		log.Printf("[WARN] [PTY] Requirements check failed: %v", err)
+		return requirements
+	}
+
+	claudePath := commandPath(results[0])
 	if claudePath != "" {
 		requirements.ClaudeInstalled = true
 		requirements.ClaudePath = &claudePath
 	}
 
-	// Check for agentapi
-	agentApiPath := checkCommand(sshClient, "agentapi")
+	agentApiPath := commandPath(results[1])
 	if agentApiPath != "" {
 		requirements.AgentAPIInstalled = true
 		requirements.AgentAPIPath = &agentApiPath
 	}
 
+	if tmpDir := tmuxTmpDir(results[len(requiredCommands)]); tmpDir != "" {
+		requirements.TmuxTmpDir = &tmpDir
+	}
+
 	log.Printf("[DEBUG] [PTY] Requirements check: claude=%v (%v), agentapi=%v (%v)",
 		requirements.ClaudeInstalled, requirements.ClaudePath,
 		requirements.AgentAPIInstalled, requirements.AgentAPIPath)
@@ -144,21 +219,24 @@ func CheckRequirements(sshClient *ssh.Client) *protocol.HostRequirements {
 	return requirements
 }
 
-// checkCommand checks if a command is available and returns its path
-func checkCommand(sshClient *ssh.Client, cmd string) string {
-	session, err := sshClient.NewSession()
-	if err != nil {
+// tmuxTmpDir returns the TMUX_TMPDIR printed by tmuxTmpDirCommand, or "" if
+// it is unset or the default
+func tmuxTmpDir(result rcssh.BatchResult) string {
+	if !result.OK() {
 		return ""
 	}
-	defer session.Close()
-
-	var stdout bytes.Buffer
-	session.Stdout = &stdout
-
-	err = session.Run(fmt.Sprintf("which %s 2>/dev/null", cmd))
-	if err != nil {
+	tmpDir := strings.TrimSpace(result.Output)
+	if tmpDir == "" || path.Clean(tmpDir) == defaultTmuxTmpDir {
 		return ""
 	}
+	return tmpDir
+}
 
-	return strings.TrimSpace(stdout.String())
+// commandPath returns the path printed by a which check, or "" if the
+// command wasn't found
+func commandPath(result rcssh.BatchResult) string {
+	if !result.OK() {
+		return ""
+	}
+	return strings.TrimSpace(result.Output)
 }
@@ -0,0 +1,75 @@
+package pty
+
+import (
+	"strings"
+	"testing"
+)
+
+const testProcessID = "6f1c2a9e-3b4d-4c5e-8f70-1a2b3c4d5e6f"
+
+func TestProcessIDFromTmuxName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string // "" for not ours
+	}{
+		{"rc-" + testProcessID, testProcessID},
+		{"rc-test", ""},
+		{"rc-", ""},
+		{testProcessID, ""},
+		// Forms uuid.Parse accepts but the bridge never names sessions with
+		{"rc-" + strings.ToUpper(testProcessID), ""},
+		{"rc-{" + testProcessID + "}", ""},
+		{"rc-urn:uuid:" + testProcessID, ""},
+		{"rc-" + strings.ReplaceAll(testProcessID, "-", ""), ""},
+		{"rc-" + testProcessID + " extra", ""},
+		{"rc-" + testProcessID + "'; rm -rf ~", ""},
+		{"rc-my session", ""},
+		{`rc-"quoted"`, ""},
+	}
+	for _, tt := range tests {
+		got, ok := ProcessIDFromTmuxName(tt.name)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("ProcessIDFromTmuxName(%q) = %q, %v; want %q", tt.name, got, ok, tt.want)
+		}
+	}
+}
+
+func TestNormalizeProcessID(t *testing.T) {
+	for _, id := range []string{testProcessID, strings.ToUpper(testProcessID), "{" + testProcessID + "}"} {
+		if got, err := NormalizeProcessID(id); err != nil || got != testProcessID {
+			t.Errorf("NormalizeProcessID(%q) = %q, %v; want %q", id, got, err, testProcessID)
+		}
+	}
+	for _, id := range []string{"", "test", "proc-1", testProcessID + "x"} {
+		if got, err := NormalizeProcessID(id); err == nil {
+			t.Errorf("NormalizeProcessID(%q) = %q, want an error", id, got)
+		}
+	}
+}
+
+func TestParseTmuxSessionsMarksUnmanaged(t *testing.T) {
+	output := "rc-" + testProcessID + ":1700000000:1:120:30\n" +
+		"rc-test:1700000001:0:80:24\n" +
+		"rc-my session:1700000002:0:80:24\n" +
+		"rc-it's \"odd\":1700000003:0:80:24\n" +
+		"rc-truncated:1700000004\n"
+
+	sessions := parseTmuxSessions(output)
+	if len(sessions) != 4 {
+		t.Fatalf("parsed %d sessions, want 4: %+v", len(sessions), sessions)
+	}
+	if s := sessions[0]; !s.Managed() || s.ProcessID != testProcessID || !s.Attached || s.Width != 120 {
+		t.Errorf("managed session = %+v", s)
+	}
+	for _, s := range sessions[1:] {
+		if s.Managed() || s.ProcessID != "" {
+			t.Errorf("session %q treated as ours: %+v", s.Name, s)
+		}
+		if s.Created.IsZero() {
+			t.Errorf("session %q lost its creation time", s.Name)
+		}
+	}
+	if sessions[3].Name != `rc-it's "odd"` {
+		t.Errorf("name = %q", sessions[3].Name)
+	}
+}
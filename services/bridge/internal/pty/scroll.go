@@ -0,0 +1,114 @@
+package pty
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/shellargs"
+	rcssh "github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// scrollCommand returns the tmux arguments (for Tmux.Cmd) that scroll
+// the pane of tmuxName in copy mode. Scrolling back enters copy mode with
+// -e, so scrolling forward past the live screen leaves it again. The other
+// actions only apply in copy mode: outside it, scroll-down and cancel would
+// fail, and copy-mode would freeze the pane for nothing.
+func scrollCommand(tmuxName string, action protocol.PtyScrollAction, count int) (string, error) {
+	if count < 1 {
+		count = 1
+	}
+	back := func(command string) string {
+		return fmt.Sprintf(`copy-mode -e -t %s \; send-keys -t %s -X %s`, tmuxName, tmuxName, command)
+	}
+	inMode := func(command string) string {
+		return fmt.Sprintf("if-shell -F -t %s '#{pane_in_mode}' %s", tmuxName,
+			shellargs.Quote(fmt.Sprintf("send-keys -t %s -X %s", tmuxName, command)))
+	}
+
+	switch action {
+	case protocol.PtyScrollUp:
+		return back(fmt.Sprintf("-N %d scroll-up", count)), nil
+	case protocol.PtyScrollPageUp:
+		return back(fmt.Sprintf("-N %d page-up", count)), nil
+	case protocol.PtyScrollTop:
+		return back("history-top"), nil
+	case protocol.PtyScrollDown:
+		return inMode(fmt.Sprintf("-N %d scroll-down", count)), nil
+	case protocol.PtyScrollPageDown:
+		return inMode(fmt.Sprintf("-N %d page-down", count)), nil
+	case protocol.PtyScrollBottom, protocol.PtyScrollExit:
+		// At the bottom, copy mode only freezes the live screen
+		return inMode("cancel"), nil
+	}
+	return "", fmt.Errorf("unknown scroll action %q", action)
+}
+
+// Scroll scrolls the pane's scrollback in tmux copy mode and returns the
+// pane's state after. tmux runs the command as a client of its own, so the
+// attachment and its output loop carry on: output shows the copy-mode view.
+func (s *Session) Scroll(action protocol.PtyScrollAction, count int) (PaneInfo, error) {
+	cmd, err := scrollCommand(s.TmuxName, action, count)
+	if err != nil {
+		return PaneInfo{}, err
+	}
+	info, err := s.runCopyModeCommand(cmd)
+	if err != nil {
+		return PaneInfo{}, fmt.Errorf("failed to scroll: %w", err)
+	}
+	log.Printf("[DEBUG] [PTY] Scrolled session %s: %s x%d, copy mode=%v position=%d",
+		s.ID, action, count, info.InCopyMode, info.ScrollPosition)
+	return info, nil
+}
+
+// LeaveCopyMode takes the pane out of copy mode if Scroll left it there,
+// since copy mode would take keystrokes written to the PTY as its own
+// commands. left reports whether it had to; info is only set then. Callers
+// must leave copy mode before every Write of user input.
+func (s *Session) LeaveCopyMode() (info PaneInfo, left bool, err error) {
+	if !s.InCopyMode() {
+		return PaneInfo{}, false, nil
+	}
+	cmd, _ := scrollCommand(s.TmuxName, protocol.PtyScrollExit, 1)
+	info, err = s.runCopyModeCommand(cmd)
+	if err != nil {
+		return PaneInfo{}, false, fmt.Errorf("failed to leave copy mode: %w", err)
+	}
+	log.Printf("[DEBUG] [PTY] Left copy mode of session %s before input", s.ID)
+	return info, true, nil
+}
+
+// InCopyMode returns whether the pane was in copy mode after the last
+// Scroll. It is not refreshed otherwise, so it may stay set after tmux left
+// copy mode on its own; LeaveCopyMode is harmless then.
+func (s *Session) InCopyMode() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.copyMode
+}
+
+// runCopyModeCommand runs a tmux command line and reads the pane's info in
+// the same SSH session, recording whether the pane is in copy mode
+func (s *Session) runCopyModeCommand(cmd string) (PaneInfo, error) {
+	s.mu.Lock()
+	sshClient := s.sshClient
+	s.mu.Unlock()
+
+	results, err := rcssh.RunBatch(sshClient, []string{s.tmux.Cmd(cmd), paneInfoCommand(s.tmux, s.TmuxName)})
+	if err != nil {
+		return PaneInfo{}, err
+	}
+	if !results[0].OK() {
+		return PaneInfo{}, fmt.Errorf("tmux exited with status %d", results[0].ExitCode)
+	}
+	info, err := parsePaneInfo(results[1].Output)
+	if err != nil {
+		return PaneInfo{}, err
+	}
+
+	s.mu.Lock()
+	s.copyMode = info.InCopyMode
+	s.cwd = info.CWD
+	s.mu.Unlock()
+	return info, nil
+}
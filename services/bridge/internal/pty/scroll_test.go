@@ -0,0 +1,56 @@
+package pty
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+func TestScrollCommand(t *testing.T) {
+	tests := []struct {
+		action protocol.PtyScrollAction
+		count  int
+		want   string
+	}{
+		{protocol.PtyScrollUp, 3, `copy-mode -e -t rc-a \; send-keys -t rc-a -X -N 3 scroll-up`},
+		{protocol.PtyScrollUp, 0, `copy-mode -e -t rc-a \; send-keys -t rc-a -X -N 1 scroll-up`},
+		{protocol.PtyScrollPageUp, 2, `copy-mode -e -t rc-a \; send-keys -t rc-a -X -N 2 page-up`},
+		{protocol.PtyScrollTop, 5, `copy-mode -e -t rc-a \; send-keys -t rc-a -X history-top`},
+		{protocol.PtyScrollDown, 4, `if-shell -F -t rc-a '#{pane_in_mode}' 'send-keys -t rc-a -X -N 4 scroll-down'`},
+		{protocol.PtyScrollPageDown, 1, `if-shell -F -t rc-a '#{pane_in_mode}' 'send-keys -t rc-a -X -N 1 page-down'`},
+		{protocol.PtyScrollBottom, 1, `if-shell -F -t rc-a '#{pane_in_mode}' 'send-keys -t rc-a -X cancel'`},
+		{protocol.PtyScrollExit, 1, `if-shell -F -t rc-a '#{pane_in_mode}' 'send-keys -t rc-a -X cancel'`},
+	}
+	for _, tt := range tests {
+		got, err := scrollCommand("rc-a", tt.action, tt.count)
+		if err != nil || got != tt.want {
+			t.Errorf("scrollCommand(%s, %d) = %q, %v\nwant %q", tt.action, tt.count, got, err, tt.want)
+		}
+	}
+
+	if _, err := scrollCommand("rc-a", "left", 1); err == nil {
+		t.Error("unknown action accepted")
+	}
+}
+
+func TestCopyModeOutputIsNotCaptured(t *testing.T) {
+	s := &Session{ID: "proc-1", attached: true}
+	var captured, forwarded strings.Builder
+	s.SetCaptureHandler(func(data []byte) { captured.Write(data) })
+	s.SetOutputHandler(func(data []byte) { forwarded.Write(data) })
+
+	s.readLoop(strings.NewReader("live "), "stdout")
+	s.copyMode = true
+	s.readLoop(strings.NewReader("scrollback "), "stdout")
+	s.copyMode = false
+	s.readLoop(strings.NewReader("live again"), "stdout")
+	flushTestOutput(t, s)
+
+	if got := captured.String(); got != "live live again" {
+		t.Errorf("captured = %q", got)
+	}
+	if got := forwarded.String(); got != "live scrollback live again" {
+		t.Errorf("forwarded = %q", got)
+	}
+}
@@ -1,12 +1,18 @@
 package pty
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/shellargs"
+	rcssh "github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -15,11 +21,53 @@ const (
 	TmuxSessionPrefix = "rc-"
 )
 
+// Output delivery; variables so tests can change them
+var (
+	// outputQueueSize is how many chunks the read loops can be ahead of
+	// delivery before they wait for it
+	outputQueueSize = 64
+
+	// detachFlushTimeout bounds how long Detach waits for the output read
+	// before the attachment closed to be delivered
+	detachFlushTimeout = 5 * time.Second
+)
+
+// Errors returned by operations on a session that can't take input
+var (
+	ErrClosed      = errors.New("session is closed")
+	ErrNotAttached = errors.New("session is not attached")
+)
+
 // TmuxSessionName generates a tmux session name from process ID
 func TmuxSessionName(processID string) string {
 	return TmuxSessionPrefix + processID
 }
 
+// NormalizeProcessID returns the canonical form of a process ID: a UUID in
+// lowercase 8-4-4-4-12 form, as the bridge generates them
+func NormalizeProcessID(processID string) (string, error) {
+	id, err := uuid.Parse(processID)
+	if err != nil {
+		return "", fmt.Errorf("invalid process ID %q: %w", processID, err)
+	}
+	return id.String(), nil
+}
+
+// ProcessIDFromTmuxName returns the process ID embedded in a tmux session
+// name. Only names the bridge could have created (the prefix followed by a
+// canonical process ID) qualify; anything else, like a user's own rc-test
+// session, is not one of ours.
+func ProcessIDFromTmuxName(tmuxName string) (string, bool) {
+	processID, ok := strings.CutPrefix(tmuxName, TmuxSessionPrefix)
+	if !ok {
+		return "", false
+	}
+	if normalized, err := NormalizeProcessID(processID); err != nil || normalized != processID {
+		return "", false
+	}
+	return processID, true
+}
+
 // Session represents a PTY session backed by tmux for persistence.
 // Unlike a raw SSH shell which dies with the connection, tmux sessions persist
 // and can be reattached after disconnect/reconnect.
@@ -27,6 +75,7 @@ type Session struct {
 	ID         string
 	HostID     string
 	TmuxName   string // tmux session name (rc-{processID})
+	tmux       Tmux   // Runs tmux against the session's server; set at creation
 	sshClient  *ssh.Client
 	sshSession *ssh.Session // Current attachment session (nil when detached)
 	stdin      io.WriteCloser
@@ -40,8 +89,30 @@ type Session struct {
 	Cols int
 	Rows int
 
-	// Output handler
-	onOutput func(data []byte)
+	// Output handlers. onCapture is installed once for the lifetime of the
+	// session (history capture); onOutput is swapped as clients come and go.
+	onCapture func(data []byte)
+	onOutput  func(data []byte)
+
+	// copyMode is set while Scroll has the pane in tmux copy mode (see
+	// scroll.go). Output then redraws old scrollback, so it isn't captured.
+	copyMode bool
+
+	// muted is set while the bridge types into the pane itself (see
+	// MuteOutput). Output is still captured, but not forwarded.
+	muted bool
+
+	// Output is delivered in order by one goroutine (see dispatchOutput),
+	// started with the first output or handler change. The read loops only
+	// queue chunks, and output handler changes are queued in the same
+	// stream, so a chunk goes to the handler set before it was queued.
+	events  chan outputEvent
+	stopped chan struct{}   // Closed when the session is killed
+	readers *sync.WaitGroup // Read loops of the current attachment
+
+	// How output is read and delivered (see OutputTuning); the defaults when
+	// zero
+	output OutputTuning
 
 	// Lifecycle
 	startedAt time.Time
@@ -50,10 +121,14 @@ type Session struct {
 
 // SessionConfig contains configuration for creating a PTY session
 type SessionConfig struct {
-	Cols       int
-	Rows       int
-	TermType   string
-	InitialCWD string
+	Cols        int
+	Rows        int
+	TermType    string
+	InitialCWD  string            // Directory the shell starts in; the tmux default when empty
+	Shell       string            // Command the pane runs, through sh -c; the user's default shell when empty
+	TermOptions map[string]string // Validated terminal options (see SetTermOptions); defaults when nil
+	Tmux        Tmux              // The host's tmux (see Tmux)
+	Output      OutputTuning      // How output is read and delivered; DefaultOutputTuning when zero
 }
 
 // DefaultSessionConfig returns default PTY session configuration
@@ -65,6 +140,29 @@ func DefaultSessionConfig() SessionConfig {
 	}
 }
 
+// monitorOptions continues a tmux command line to turn on activity and bell
+// monitoring for the session's window, so tmux flags alerts while the bridge
+// is detached. -q keeps tmux versions without monitor-bell working.
+func monitorOptions(tmuxName string) string {
+	return fmt.Sprintf(" \\; set-window-option -q -t %s monitor-activity on \\; set-window-option -q -t %s monitor-bell on",
+		tmuxName, tmuxName)
+}
+
+// newSessionCommand returns the tmux command line that creates a detached
+// session with its terminal options set up
+func newSessionCommand(tmuxName string, config SessionConfig) string {
+	var cmd strings.Builder
+	cmd.WriteString(config.Tmux.Cmdf("new-session -d -s %s -x %d -y %d", tmuxName, config.Cols, config.Rows))
+	if config.InitialCWD != "" {
+		cmd.WriteString(" -c " + shellargs.Quote(config.InitialCWD))
+	}
+	if config.Shell != "" {
+		cmd.WriteString(" " + shellargs.Quote(config.Shell))
+	}
+	cmd.WriteString(" \\; " + termOptionsCommand(tmuxName, config.TermOptions))
+	return cmd.String() + monitorOptions(tmuxName)
+}
+
 // NewSession creates a new PTY session backed by tmux.
 // This creates a new tmux session on the remote and attaches to it.
 func NewSession(id, hostID string, sshClient *ssh.Client, config SessionConfig) (*Session, error) {
@@ -78,10 +176,8 @@ func NewSession(id, hostID string, sshClient *ssh.Client, config SessionConfig)
 		return nil, fmt.Errorf("failed to create SSH session for tmux create: %w", err)
 	}
 
-	// Create detached tmux session with specified size and disable status bar
-	// The status bar is disabled to provide a cleaner terminal experience on mobile
-	createCmd := fmt.Sprintf("tmux new-session -d -s %s -x %d -y %d \\; set-option -t %s status off",
-		tmuxName, config.Cols, config.Rows, tmuxName)
+	// Create detached tmux session with specified size and directory
+	createCmd := newSessionCommand(tmuxName, config)
 	log.Printf("[DEBUG] [PTY] Running: %s", createCmd)
 
 	if err := createSession.Run(createCmd); err != nil {
@@ -95,11 +191,13 @@ func NewSession(id, hostID string, sshClient *ssh.Client, config SessionConfig)
 		ID:        id,
 		HostID:    hostID,
 		TmuxName:  tmuxName,
+		tmux:      config.Tmux,
 		sshClient: sshClient,
 		Cols:      config.Cols,
 		Rows:      config.Rows,
 		startedAt: time.Now(),
 		cwd:       config.InitialCWD,
+		output:    config.Output,
 	}
 
 	// Attach to the tmux session
@@ -113,17 +211,18 @@ func NewSession(id, hostID string, sshClient *ssh.Client, config SessionConfig)
 	return session, nil
 }
 
-// AttachToExisting attaches to an existing tmux session (for reconnection)
-func AttachToExisting(id, hostID, tmuxName string, sshClient *ssh.Client, cols, rows int, startedAt time.Time) (*Session, error) {
+// AttachToExisting attaches to an existing tmux session (for reconnection),
+// re-applying its terminal options (validated, see SetTermOptions)
+func AttachToExisting(id, hostID, tmuxName string, sshClient *ssh.Client, tmux Tmux, cols, rows int, startedAt time.Time, termOptions map[string]string) (*Session, error) {
 	log.Printf("[DEBUG] [PTY] Attaching to existing tmux session id=%s tmuxName=%s", id, tmuxName)
 
-	// Verify the tmux session exists and ensure status bar is disabled
+	// Verify the tmux session exists and set up its options
 	checkSession, err := sshClient.NewSession()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create SSH session: %w", err)
 	}
-	// Check session exists AND disable status bar (for sessions created before this feature)
-	checkCmd := fmt.Sprintf("tmux has-session -t %s && tmux set-option -t %s status off", tmuxName, tmuxName)
+	// Check session exists AND apply its options (for sessions created before they were set)
+	checkCmd := tmux.Cmdf("has-session -t %s", tmuxName) + " && " + tmux.Cmd(termOptionsCommand(tmuxName, termOptions)+monitorOptions(tmuxName))
 	if err := checkSession.Run(checkCmd); err != nil {
 		checkSession.Close()
 		return nil, fmt.Errorf("tmux session %s does not exist", tmuxName)
@@ -134,6 +233,7 @@ func AttachToExisting(id, hostID, tmuxName string, sshClient *ssh.Client, cols,
 		ID:        id,
 		HostID:    hostID,
 		TmuxName:  tmuxName,
+		tmux:      tmux,
 		sshClient: sshClient,
 		Cols:      cols,
 		Rows:      rows,
@@ -155,7 +255,7 @@ func (s *Session) Attach() error {
 	defer s.mu.Unlock()
 
 	if s.closed {
-		return fmt.Errorf("session is closed")
+		return ErrClosed
 	}
 
 	if s.attached {
@@ -200,7 +300,7 @@ func (s *Session) Attach() error {
 	}
 
 	// Start tmux attach command
-	attachCmd := fmt.Sprintf("tmux attach-session -t %s", s.TmuxName)
+	attachCmd := s.tmux.Cmdf("attach-session -t %s", s.TmuxName)
 	log.Printf("[DEBUG] [PTY] Running: %s", attachCmd)
 
 	if err := sshSession.Start(attachCmd); err != nil {
@@ -212,18 +312,22 @@ func (s *Session) Attach() error {
 	s.stdin = stdin
 	s.stdout = stdout
 	s.stderr = stderr
+	s.readers = &sync.WaitGroup{}
 	s.attached = true
 
 	log.Printf("[DEBUG] [PTY] Attached to tmux session %s", s.TmuxName)
 	return nil
 }
 
-// Detach detaches from the tmux session (keeps it running on remote)
+// Detach detaches from the tmux session (keeps it running on remote). It
+// returns once the output read before the attachment closed has been
+// delivered, so none of it reaches an output handler set afterwards and the
+// capture handler has all of it.
 func (s *Session) Detach() error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 
 	if !s.attached {
+		s.mu.Unlock()
 		return nil // Already detached
 	}
 
@@ -235,10 +339,15 @@ func (s *Session) Detach() error {
 		s.sshSession = nil
 	}
 
+	readers := s.readers
 	s.stdin = nil
 	s.stdout = nil
 	s.stderr = nil
+	s.readers = nil
 	s.attached = false
+	s.mu.Unlock()
+
+	s.quiesceOutput(readers)
 
 	log.Printf("[INFO] [PTY] Detached from session %s (tmux %s still running)", s.ID, s.TmuxName)
 	return nil
@@ -251,6 +360,11 @@ func (s *Session) Kill() error {
 
 	log.Printf("[DEBUG] [PTY] Killing session %s", s.ID)
 
+	// Output still queued is dropped
+	if !s.closed && s.stopped != nil {
+		close(s.stopped)
+	}
+
 	// First detach if attached
 	if s.sshSession != nil {
 		s.sshSession.Close()
@@ -259,6 +373,7 @@ func (s *Session) Kill() error {
 	s.stdin = nil
 	s.stdout = nil
 	s.stderr = nil
+	s.readers = nil
 	s.attached = false
 
 	// Now kill the tmux session
@@ -269,7 +384,7 @@ func (s *Session) Kill() error {
 	}
 	defer killSession.Close()
 
-	killCmd := fmt.Sprintf("tmux kill-session -t %s 2>/dev/null", s.TmuxName)
+	killCmd := s.tmux.Cmdf("kill-session -t %s 2>/dev/null", s.TmuxName)
 	killSession.Run(killCmd) // Ignore error - might already be dead
 
 	s.closed = true
@@ -277,11 +392,29 @@ func (s *Session) Kill() error {
 	return nil
 }
 
-// SetOutputHandler sets the callback for output data
+// SetOutputHandler sets the callback for output data. The change is queued
+// behind the output already read: that still goes to the previous handler,
+// and everything read after it to this one.
 func (s *Session) SetOutputHandler(handler func(data []byte)) {
+	s.queueOutput(outputEvent{setHandler: true, handler: handler})
+}
+
+// SetCaptureHandler sets the callback that receives every chunk of output
+// before the output handler. Unlike the output handler it is not tied to a
+// client, so it keeps running across client reconnects.
+func (s *Session) SetCaptureHandler(handler func(data []byte)) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.onOutput = handler
+	s.onCapture = handler
+}
+
+// MuteOutput stops forwarding output to the output handler, or starts it
+// again, while still capturing it. The screen a muted client shows falls
+// behind the pane's, so it should be repainted once output is unmuted.
+func (s *Session) MuteOutput(muted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.muted = muted
 }
 
 // StartOutputLoop starts reading output from the PTY and forwarding it
@@ -289,19 +422,33 @@ func (s *Session) StartOutputLoop() {
 	s.mu.Lock()
 	stdout := s.stdout
 	stderr := s.stderr
+	readers := s.readers
 	s.mu.Unlock()
 
-	if stdout != nil {
-		go s.readLoop(stdout, "stdout")
+	if readers == nil {
+		return
 	}
-	if stderr != nil {
-		go s.readLoop(stderr, "stderr")
+	start := func(reader io.Reader, source string) {
+		if reader == nil {
+			return
+		}
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			s.readLoop(reader, source)
+		}()
 	}
+	start(stdout, "stdout")
+	start(stderr, "stderr")
 }
 
-// readLoop continuously reads from a reader and forwards to handler
+// readLoop continuously reads from a reader and queues the output for
+// delivery, until the reader ends or the session is killed. Output read
+// after a detach is still delivered: it was produced while attached.
 func (s *Session) readLoop(reader io.Reader, source string) {
-	buf := make([]byte, 4096)
+	s.mu.Lock()
+	buf := make([]byte, s.outputTuning().ReadBufferSize)
+	s.mu.Unlock()
 	for {
 		n, err := reader.Read(buf)
 		if err != nil {
@@ -316,19 +463,162 @@ func (s *Session) readLoop(reader io.Reader, source string) {
 			copy(data, buf[:n])
 
 			s.mu.Lock()
-			handler := s.onOutput
-			closed := s.closed
-			attached := s.attached
+			copyMode := s.copyMode
+			muted := s.muted
 			s.mu.Unlock()
 
-			if closed || !attached {
+			if !s.queueOutput(outputEvent{data: data, copyMode: copyMode, muted: muted}) {
 				return
 			}
+		}
+	}
+}
+
+// outputEvent is an entry in a session's output stream: a chunk of output
+// with the state it was read in, a change of output handler, or a marker
+// closed once everything before it has been delivered
+type outputEvent struct {
+	data     []byte
+	copyMode bool
+	muted    bool
+
+	setHandler bool
+	handler    func(data []byte)
 
-			if handler != nil {
-				handler(data)
+	flushed chan struct{}
+}
+
+// isOutput reports whether the event is a chunk of output
+func (ev outputEvent) isOutput() bool {
+	return !ev.setHandler && ev.flushed == nil
+}
+
+// outputStream returns the session's output stream, starting its delivery
+// goroutine if needed. ok is false once the session is closed.
+func (s *Session) outputStream() (events chan<- outputEvent, stopped <-chan struct{}, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, nil, false
+	}
+	if s.events == nil {
+		s.events = make(chan outputEvent, outputQueueSize)
+		s.stopped = make(chan struct{})
+		go s.dispatchOutput(s.events, s.stopped)
+	}
+	return s.events, s.stopped, true
+}
+
+// queueOutput adds an event to the output stream, waiting while the stream
+// is full. It returns false if the session is closed.
+func (s *Session) queueOutput(ev outputEvent) bool {
+	events, stopped, ok := s.outputStream()
+	if !ok {
+		return false
+	}
+	select {
+	case events <- ev:
+		return true
+	case <-stopped:
+		return false
+	}
+}
+
+// dispatchOutput delivers the output stream's events in order until the
+// session is killed
+func (s *Session) dispatchOutput(events <-chan outputEvent, stopped <-chan struct{}) {
+	var next *outputEvent
+	for {
+		var ev outputEvent
+		if next != nil {
+			ev, next = *next, nil
+		} else {
+			select {
+			case <-stopped:
+				return
+			case ev = <-events:
 			}
 		}
+		if ev.isOutput() {
+			var ok bool
+			if ev, next, ok = s.coalesceOutput(ev, events, stopped); !ok {
+				return
+			}
+		}
+		s.deliverOutput(ev)
+	}
+}
+
+// deliverOutput handles one event of the output stream: output goes to the
+// capture handler unless it was read in copy mode, and to the output handler
+// unless it was read while muted
+func (s *Session) deliverOutput(ev outputEvent) {
+	switch {
+	case ev.flushed != nil:
+		close(ev.flushed)
+	case ev.setHandler:
+		s.mu.Lock()
+		s.onOutput = ev.handler
+		s.mu.Unlock()
+	default:
+		s.mu.Lock()
+		capture := s.onCapture
+		handler := s.onOutput
+		s.mu.Unlock()
+
+		if capture != nil && !ev.copyMode {
+			capture(ev.data)
+		}
+		if handler != nil && !ev.muted {
+			handler(ev.data)
+		}
+	}
+}
+
+// flushOutput waits until the output queued so far has been delivered, or
+// until the deadline. It returns false if it wasn't.
+func (s *Session) flushOutput(deadline time.Time) bool {
+	events, stopped, ok := s.outputStream()
+	if !ok {
+		return false
+	}
+	flushed := make(chan struct{})
+	select {
+	case events <- outputEvent{flushed: flushed}:
+	case <-stopped:
+		return false
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+	select {
+	case <-flushed:
+		return true
+	case <-stopped:
+		return false
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
+
+// quiesceOutput waits for the read loops of a closed attachment to end and
+// for what they read to be delivered, for at most detachFlushTimeout
+func (s *Session) quiesceOutput(readers *sync.WaitGroup) {
+	deadline := time.Now().Add(detachFlushTimeout)
+	if readers != nil {
+		done := make(chan struct{})
+		go func() {
+			readers.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(detachFlushTimeout):
+			log.Printf("[WARN] [PTY] Output of session %s still being read %s after detach", s.ID, detachFlushTimeout)
+			return
+		}
+	}
+	if !s.flushOutput(deadline) {
+		log.Printf("[WARN] [PTY] Output of session %s not delivered before detach returned", s.ID)
 	}
 }
 
@@ -337,11 +627,11 @@ func (s *Session) Write(data []byte) error {
 	s.mu.Lock()
 	if s.closed {
 		s.mu.Unlock()
-		return fmt.Errorf("session is closed")
+		return ErrClosed
 	}
 	if !s.attached || s.stdin == nil {
 		s.mu.Unlock()
-		return fmt.Errorf("session is not attached")
+		return ErrNotAttached
 	}
 	stdin := s.stdin
 	s.mu.Unlock()
@@ -365,7 +655,7 @@ func (s *Session) Resize(cols, rows int) error {
 	s.mu.Lock()
 	if s.closed {
 		s.mu.Unlock()
-		return fmt.Errorf("session is closed")
+		return ErrClosed
 	}
 	tmuxName := s.TmuxName
 	sshClient := s.sshClient
@@ -381,7 +671,7 @@ func (s *Session) Resize(cols, rows int) error {
 	defer resizeSession.Close()
 
 	// Resize the tmux session
-	resizeCmd := fmt.Sprintf("tmux resize-window -t %s -x %d -y %d", tmuxName, cols, rows)
+	resizeCmd := s.tmux.Cmdf("resize-window -t %s -x %d -y %d", tmuxName, cols, rows)
 	if err := resizeSession.Run(resizeCmd); err != nil {
 		log.Printf("[WARN] [PTY] Resize window failed for session %s: %v (continuing)", s.ID, err)
 	}
@@ -445,45 +735,305 @@ func (s *Session) SetCWD(cwd string) {
 	s.cwd = cwd
 }
 
+// PaneInfo describes the active pane of a tmux session
+type PaneInfo struct {
+	CWD      string
+	ShellPID int
+	Created  time.Time // When the tmux session was created
+	Bell     bool      // A bell rang in the window since alerts were last cleared
+	Activity bool      // The window had output since alerts were last cleared
+
+	InCopyMode     bool // The pane shows its scrollback in copy mode, not live output
+	ScrollPosition int  // Lines the copy-mode view is above the live screen
+	HistorySize    int  // Lines of scrollback tmux holds for the pane
+
+	Command string // Program in the foreground: the shell at its prompt, or what it runs
+}
+
+// paneInfoFields is the number of fields paneInfoCommand prints
+const paneInfoFields = 9
+
+// paneInfoCommand lists the working directory, shell PID, session creation
+// time, window alert flags, copy-mode state and foreground program of the
+// active pane, tab separated. #{pane_current_path} is the CWD of the process
+// in the pane and #{pane_pid} is the PID of the shell tmux started there.
+// tmux only raises the alert flags of a session's current window while no
+// client is attached to it, and only sets #{scroll_position} in copy mode.
+func paneInfoCommand(tmux Tmux, tmuxName string) string {
+	return tmux.Cmdf("list-panes -t %s -F '#{pane_current_path}\t#{pane_pid}\t#{session_created}\t#{window_bell_flag}\t#{window_activity_flag}\t#{pane_in_mode}\t#{scroll_position}\t#{history_size}\t#{pane_current_command}' 2>/dev/null | head -1", tmuxName)
+}
+
+// parsePaneInfo parses the output of paneInfoCommand
+func parsePaneInfo(output string) (PaneInfo, error) {
+	// The path comes first, so a tab in it doesn't shift the other fields
+	fields := strings.Split(strings.TrimSuffix(output, "\n"), "\t")
+	if len(fields) < paneInfoFields {
+		return PaneInfo{}, fmt.Errorf("unexpected pane info %q", output)
+	}
+	// Everything before the last fields is the path
+	path := len(fields) - paneInfoFields + 1
+	fields = append([]string{strings.Join(fields[:path], "\t")}, fields[path:]...)
+
+	pid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return PaneInfo{}, fmt.Errorf("failed to parse PID from pane info %q: %w", output, err)
+	}
+	created, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return PaneInfo{}, fmt.Errorf("failed to parse creation time from pane info %q: %w", output, err)
+	}
+	bell, err := parseTmuxFlag(fields[3])
+	if err != nil {
+		return PaneInfo{}, fmt.Errorf("failed to parse bell flag from pane info %q: %w", output, err)
+	}
+	activity, err := parseTmuxFlag(fields[4])
+	if err != nil {
+		return PaneInfo{}, fmt.Errorf("failed to parse activity flag from pane info %q: %w", output, err)
+	}
+	inMode, err := parseTmuxFlag(fields[5])
+	if err != nil {
+		return PaneInfo{}, fmt.Errorf("failed to parse copy-mode flag from pane info %q: %w", output, err)
+	}
+	scrollPosition, err := parseTmuxCount(fields[6])
+	if err != nil {
+		return PaneInfo{}, fmt.Errorf("failed to parse scroll position from pane info %q: %w", output, err)
+	}
+	historySize, err := parseTmuxCount(fields[7])
+	if err != nil {
+		return PaneInfo{}, fmt.Errorf("failed to parse history size from pane info %q: %w", output, err)
+	}
+	return PaneInfo{
+		CWD:            fields[0],
+		ShellPID:       pid,
+		Created:        time.Unix(created, 0),
+		Bell:           bell,
+		Activity:       activity,
+		InCopyMode:     inMode,
+		ScrollPosition: scrollPosition,
+		HistorySize:    historySize,
+		Command:        fields[8],
+	}, nil
+}
+
+// parseTmuxFlag parses a tmux boolean format variable, which expands to "1"
+// or "0" (or nothing, on versions that don't know it)
+func parseTmuxFlag(value string) (bool, error) {
+	switch value {
+	case "1":
+		return true, nil
+	case "0", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected flag %q", value)
+}
+
+// parseTmuxCount parses a tmux numeric format variable, which expands to
+// nothing where it doesn't apply
+func parseTmuxCount(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("unexpected count %q", value)
+	}
+	return n, nil
+}
+
+// RefreshPaneInfo queries the working directory and shell PID of the tmux
+// pane in one SSH session and updates the internal cwd field
+func (s *Session) RefreshPaneInfo() (PaneInfo, error) {
+	s.mu.Lock()
+	sshClient := s.sshClient
+	tmuxName := s.TmuxName
+	s.mu.Unlock()
+
+	info, err := QueryPaneInfo(sshClient, s.tmux, tmuxName)
+	if err != nil {
+		return PaneInfo{}, err
+	}
+
+	s.SetCWD(info.CWD)
+	return info, nil
+}
+
+// QueryPaneInfo reads the pane info of a tmux session the bridge isn't
+// attached to, e.g. before reattaching it
+func QueryPaneInfo(sshClient *ssh.Client, tmux Tmux, tmuxName string) (PaneInfo, error) {
+	results, err := rcssh.RunBatch(sshClient, []string{paneInfoCommand(tmux, tmuxName)})
+	if err != nil {
+		return PaneInfo{}, fmt.Errorf("failed to get pane info: %w", err)
+	}
+	return parsePaneInfo(results[0].Output)
+}
+
 // RefreshCWD queries the current working directory from the tmux pane
 // and updates the internal cwd field. Returns the current CWD.
 func (s *Session) RefreshCWD() (string, error) {
+	info, err := s.RefreshPaneInfo()
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("[DEBUG] [PTY] Refreshed CWD for session %s: %s", s.ID, info.CWD)
+	return info.CWD, nil
+}
+
+// RefreshCWDs refreshes the working directory of many sessions using one SSH
+// session per host instead of one per pane. The returned errors line up with
+// sessions; a session whose pane can't be read keeps its previous CWD.
+func RefreshCWDs(sessions []*Session) []error {
+	_, errs := RefreshPaneInfos(sessions)
+	return errs
+}
+
+// RefreshPaneInfos reads the pane info of many sessions, batched like
+// RefreshCWDs, and updates their CWDs. The returned infos and errors line up
+// with sessions; an info is only valid when its error is nil.
+func RefreshPaneInfos(sessions []*Session) ([]PaneInfo, []error) {
+	infos := make([]PaneInfo, len(sessions))
+	errs := make([]error, len(sessions))
+
+	for sshClient, indexes := range groupByClient(sessions, errs) {
+		cmds := make([]string, len(indexes))
+		for j, i := range indexes {
+			cmds[j] = paneInfoCommand(sessions[i].tmux, sessions[i].TmuxName)
+		}
+
+		results, err := rcssh.RunBatch(sshClient, cmds)
+		for j, i := range indexes {
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to get pane info: %w", err)
+				continue
+			}
+			info, parseErr := parsePaneInfo(results[j].Output)
+			if parseErr != nil {
+				errs[i] = parseErr
+				continue
+			}
+			sessions[i].SetCWD(info.CWD)
+			infos[i] = info
+		}
+		log.Printf("[DEBUG] [PTY] Refreshed CWD for %d sessions in one batch", len(indexes))
+	}
+
+	return infos, errs
+}
+
+// ClearAlerts clears the bell and activity flags of many sessions' windows
+// (tmux kill-session -C), with one SSH session per host. The returned errors
+// line up with sessions.
+func ClearAlerts(sessions []*Session) []error {
+	errs := make([]error, len(sessions))
+
+	for sshClient, indexes := range groupByClient(sessions, errs) {
+		cmds := make([]string, len(indexes))
+		for j, i := range indexes {
+			cmds[j] = sessions[i].tmux.Cmdf("kill-session -C -t %s", sessions[i].TmuxName)
+		}
+
+		results, err := rcssh.RunBatch(sshClient, cmds)
+		for j, i := range indexes {
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to clear alerts: %w", err)
+			} else if !results[j].OK() {
+				errs[i] = fmt.Errorf("failed to clear alerts for %s: exit code %d", sessions[i].TmuxName, results[j].ExitCode)
+			}
+		}
+	}
+
+	return errs
+}
+
+// groupByClient groups session indexes by SSH client, since sessions on the
+// same host share one, so each host's queries can be batched. Sessions
+// without a client get an error in errs.
+func groupByClient(sessions []*Session, errs []error) map[*ssh.Client][]int {
+	byClient := make(map[*ssh.Client][]int)
+	for i, s := range sessions {
+		s.mu.Lock()
+		sshClient := s.sshClient
+		s.mu.Unlock()
+		if sshClient == nil {
+			errs[i] = fmt.Errorf("SSH client not available")
+			continue
+		}
+		byClient[sshClient] = append(byClient[sshClient], i)
+	}
+	return byClient
+}
+
+// CapturePane returns the full scrollback of the tmux pane as plain text
+// (tmux capture-pane -S -), with wrapped lines joined and "\n" line endings
+func (s *Session) CapturePane() ([]byte, error) {
 	s.mu.Lock()
 	sshClient := s.sshClient
 	tmuxName := s.TmuxName
 	s.mu.Unlock()
 
 	if sshClient == nil {
-		return "", fmt.Errorf("SSH client not available")
+		return nil, fmt.Errorf("SSH client not available")
 	}
 
 	session, err := sshClient.NewSession()
 	if err != nil {
-		return "", fmt.Errorf("failed to create SSH session: %w", err)
+		return nil, fmt.Errorf("failed to create SSH session: %w", err)
 	}
 	defer session.Close()
 
-	// Get the current working directory of the shell in the tmux pane
-	// #{pane_current_path} gives us the CWD of the process in the active pane
-	cmd := fmt.Sprintf("tmux list-panes -t %s -F '#{pane_current_path}' 2>/dev/null | head -1", tmuxName)
+	cmd := s.tmux.Cmdf("capture-pane -p -J -S - -t %s", tmuxName)
 	output, err := session.Output(cmd)
 	if err != nil {
-		return "", fmt.Errorf("failed to get CWD: %w", err)
+		return nil, fmt.Errorf("failed to capture pane: %w", err)
 	}
 
-	// Trim whitespace from output
-	cwd := string(output)
-	if len(cwd) > 0 && cwd[len(cwd)-1] == '\n' {
-		cwd = cwd[:len(cwd)-1]
-	}
+	log.Printf("[DEBUG] [PTY] Captured %d bytes of scrollback for session %s", len(output), s.ID)
+	return output, nil
+}
 
-	// Update internal state
+// CaptureScreen returns the visible screen of the tmux pane and up to
+// scrollback lines above it, with escape sequences kept (tmux capture-pane
+// -e), so a terminal can paint it as it is. Lines are separated by "\r\n".
+// When the capture is larger than maxBytes, whole lines are dropped from the
+// top.
+func (s *Session) CaptureScreen(scrollback, maxBytes int) ([]byte, error) {
 	s.mu.Lock()
-	s.cwd = cwd
+	sshClient := s.sshClient
+	tmuxName := s.TmuxName
 	s.mu.Unlock()
 
-	log.Printf("[DEBUG] [PTY] Refreshed CWD for session %s: %s", s.ID, cwd)
-	return cwd, nil
+	if sshClient == nil {
+		return nil, fmt.Errorf("SSH client not available")
+	}
+
+	session, err := sshClient.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	cmd := s.tmux.Cmdf("capture-pane -e -p -S -%d -t %s", scrollback, tmuxName)
+	output, err := session.Output(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture screen: %w", err)
+	}
+	return clipScreen(output, maxBytes), nil
+}
+
+// clipScreen converts captured lines to "\r\n" endings, without one after the
+// last line so the cursor stays on it, and drops lines from the top until the
+// result fits in maxBytes
+func clipScreen(output []byte, maxBytes int) []byte {
+	lines := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	size := -2
+	for _, line := range lines {
+		size += len(line) + 2
+	}
+	for len(lines) > 0 && size > maxBytes {
+		size -= len(lines[0]) + 2
+		lines = lines[1:]
+	}
+	return []byte(strings.Join(lines, "\r\n"))
 }
 
 // GetTmuxName returns the tmux session name
@@ -491,6 +1041,12 @@ func (s *Session) GetTmuxName() string {
 	return s.TmuxName
 }
 
+// Tmux returns the tmux the session runs under, for commands on its pane
+// from outside the package
+func (s *Session) Tmux() Tmux {
+	return s.tmux
+}
+
 // Wait waits for the SSH session to complete (when tmux detaches or exits)
 func (s *Session) Wait() error {
 	s.mu.Lock()
@@ -512,34 +1068,11 @@ func (s *Session) UpdateSSHClient(sshClient *ssh.Client) {
 
 // GetShellPID returns the PID of the shell process running inside the tmux session
 func (s *Session) GetShellPID() (int, error) {
-	s.mu.Lock()
-	sshClient := s.sshClient
-	tmuxName := s.TmuxName
-	s.mu.Unlock()
-
-	if sshClient == nil {
-		return 0, fmt.Errorf("SSH client not available")
-	}
-
-	session, err := sshClient.NewSession()
-	if err != nil {
-		return 0, fmt.Errorf("failed to create SSH session: %w", err)
-	}
-	defer session.Close()
-
-	// Get the PID of the shell running in the tmux pane
-	// #{pane_pid} gives us the PID of the process in the active pane
-	cmd := fmt.Sprintf("tmux list-panes -t %s -F '#{pane_pid}' 2>/dev/null | head -1", tmuxName)
-	output, err := session.Output(cmd)
+	info, err := s.RefreshPaneInfo()
 	if err != nil {
-		return 0, fmt.Errorf("failed to get shell PID: %w", err)
-	}
-
-	var pid int
-	if _, err := fmt.Sscanf(string(output), "%d", &pid); err != nil {
-		return 0, fmt.Errorf("failed to parse PID from output %q: %w", string(output), err)
+		return 0, err
 	}
 
-	log.Printf("[DEBUG] [PTY] Got shell PID %d for session %s", pid, s.ID)
-	return pid, nil
+	log.Printf("[DEBUG] [PTY] Got shell PID %d for session %s", info.ShellPID, s.ID)
+	return info.ShellPID, nil
 }
@@ -0,0 +1,206 @@
+package pty
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flushTestOutput waits for the output a test read to be delivered
+func flushTestOutput(t *testing.T, s *Session) {
+	t.Helper()
+	if !s.flushOutput(time.Now().Add(5 * time.Second)) {
+		t.Fatal("output not delivered")
+	}
+}
+
+func TestReadLoopCapturesWithoutOutputHandler(t *testing.T) {
+	s := &Session{ID: "proc-1", attached: true}
+
+	var mu sync.Mutex
+	var captured, forwarded strings.Builder
+	s.SetCaptureHandler(func(data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		captured.Write(data)
+	})
+
+	// No output handler installed (no client yet): output is still captured
+	s.readLoop(strings.NewReader("before client\r\n"), "stdout")
+
+	s.SetOutputHandler(func(data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		forwarded.Write(data)
+	})
+	s.readLoop(strings.NewReader("after client\r\n"), "stdout")
+	flushTestOutput(t, s)
+
+	if got := captured.String(); got != "before client\r\nafter client\r\n" {
+		t.Errorf("captured = %q", got)
+	}
+	if got := forwarded.String(); got != "after client\r\n" {
+		t.Errorf("forwarded = %q", got)
+	}
+
+	// Muted output is captured but not forwarded
+	s.MuteOutput(true)
+	s.readLoop(strings.NewReader(" env > file\r\n"), "stdout")
+	s.MuteOutput(false)
+	s.readLoop(strings.NewReader("$ "), "stdout")
+	flushTestOutput(t, s)
+	if got := captured.String(); got != "before client\r\nafter client\r\n env > file\r\n$ " {
+		t.Errorf("captured = %q", got)
+	}
+	if got := forwarded.String(); got != "after client\r\n$ " {
+		t.Errorf("forwarded while muted = %q", got)
+	}
+}
+
+func TestOutputHandlerSwapsKeepOrder(t *testing.T) {
+	const chunks, swaps = 20000, 5000
+
+	reader, writer := io.Pipe()
+	s := &Session{ID: "proc-1", attached: true, stdout: reader, readers: &sync.WaitGroup{}}
+
+	// Every delivery is logged with the handler it went to
+	type delivery struct {
+		handler int
+		data    string
+	}
+	var mu sync.Mutex
+	var deliveries []delivery
+	var captured bytes.Buffer
+	handler := func(i int) func([]byte) {
+		return func(data []byte) {
+			mu.Lock()
+			defer mu.Unlock()
+			deliveries = append(deliveries, delivery{i, string(data)})
+		}
+	}
+	s.SetCaptureHandler(func(data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		captured.Write(data)
+	})
+	s.SetOutputHandler(handler(0))
+	s.StartOutputLoop()
+
+	var want bytes.Buffer
+	for i := range chunks {
+		fmt.Fprintf(&want, "%08d\n", i)
+	}
+	go func() {
+		data := want.Bytes()
+		for len(data) > 0 {
+			n := min(len(data), 7+len(data)%13)
+			writer.Write(data[:n])
+			data = data[n:]
+		}
+		writer.Close()
+	}()
+	for i := 1; i <= swaps; i++ {
+		s.SetOutputHandler(handler(i))
+	}
+
+	// Detach returns once what was read has been delivered
+	if err := s.Detach(); err != nil {
+		t.Fatalf("Detach: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var forwarded strings.Builder
+	last := 0
+	for _, d := range deliveries {
+		if d.handler < last {
+			t.Fatalf("output went to handler %d after handler %d", d.handler, last)
+		}
+		last = d.handler
+		forwarded.WriteString(d.data)
+	}
+	if forwarded.String() != want.String() {
+		t.Errorf("forwarded %d bytes out of order or incomplete, want %d", forwarded.Len(), want.Len())
+	}
+	if captured.String() != want.String() {
+		t.Errorf("captured %d bytes out of order or incomplete, want %d", captured.Len(), want.Len())
+	}
+}
+
+func TestParsePaneInfoAlertFlags(t *testing.T) {
+	info, err := parsePaneInfo("/home/user/a\tb\t4242\t1700000000\t1\t0\t0\t\t120\tbash\n")
+	if err != nil {
+		t.Fatalf("parsePaneInfo: %v", err)
+	}
+	if info.CWD != "/home/user/a\tb" || info.ShellPID != 4242 || !info.Bell || info.Activity || info.HistorySize != 120 || info.Command != "bash" {
+		t.Errorf("info = %+v, want the tabbed path with only the bell flag", info)
+	}
+
+	// tmux versions without a flag expand it to nothing
+	if info, err := parsePaneInfo("/tmp\t4242\t1700000000\t\t1\t0\t\t0\tzsh"); err != nil || info.Bell || !info.Activity {
+		t.Errorf("missing bell flag: %+v, %v", info, err)
+	}
+
+	// In copy mode, the scroll position is set
+	if info, err := parsePaneInfo("/tmp\t4242\t1700000000\t0\t0\t1\t37\t500\tagentapi"); err != nil || !info.InCopyMode || info.ScrollPosition != 37 {
+		t.Errorf("copy mode: %+v, %v", info, err)
+	}
+
+	for _, output := range []string{
+		"/tmp\t4242\t1700000000",                           // no alert flags
+		"/tmp\t4242\t1700000000\t0\t0",                     // no copy-mode state
+		"/tmp\t4242\t1700000000\t0\t0\t0\t\t0",             // no foreground program
+		"/tmp\t4242\t1700000000\tyes\t0\t0\t\t0\tbash",     // bad bell flag
+		"/tmp\t4242\t1700000000\t0\t#{oops}\t0\t\t0\tbash", // unexpanded activity flag
+		"/tmp\t4242\t1700000000\t0\t0\t1\t-3\t0\tbash",     // bad scroll position
+	} {
+		if _, err := parsePaneInfo(output); err == nil {
+			t.Errorf("parsePaneInfo(%q) succeeded", output)
+		}
+	}
+}
+
+func TestNewSessionCommand(t *testing.T) {
+	config := DefaultSessionConfig()
+	plain := newSessionCommand("rc-a", config)
+	if !strings.HasPrefix(plain, `tmux new-session -d -s rc-a -x 80 -y 24 \; set-option -t rc-a status off`) {
+		t.Errorf("plain = %q", plain)
+	}
+
+	config.InitialCWD = "/work/my repo"
+	got := newSessionCommand("rc-a", config)
+	want := `tmux new-session -d -s rc-a -x 80 -y 24 -c '/work/my repo' \; `
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("command = %q, want prefix %q", got, want)
+	}
+
+	// The shell is tmux's shell-command argument, a single word
+	config.Shell = "zsh -l"
+	got = newSessionCommand("rc-a", config)
+	want = `tmux new-session -d -s rc-a -x 80 -y 24 -c '/work/my repo' 'zsh -l' \; `
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("command = %q, want prefix %q", got, want)
+	}
+}
+
+func TestClipScreen(t *testing.T) {
+	output := []byte("\x1b[32mone\x1b[0m\ntwo\nthree\n")
+	tests := []struct {
+		maxBytes int
+		want     string
+	}{
+		{100, "\x1b[32mone\x1b[0m\r\ntwo\r\nthree"},
+		{10, "two\r\nthree"},
+		{5, "three"},
+		{4, ""},
+	}
+	for _, tt := range tests {
+		if got := string(clipScreen(output, tt.maxBytes)); got != tt.want {
+			t.Errorf("clipScreen(%d) = %q, want %q", tt.maxBytes, got, tt.want)
+		}
+	}
+}
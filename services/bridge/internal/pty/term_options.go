@@ -0,0 +1,166 @@
+package pty
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Terminal options a client may set on a process's tmux session. Only these
+// reach tmux, with values checked by validate, so a client can't smuggle in
+// other tmux commands.
+const (
+	OptionStatus       = "status"
+	OptionMouse        = "mouse"
+	OptionHistoryLimit = "history-limit"
+)
+
+// MaxHistoryLimit bounds history-limit, which tmux allocates per pane
+const MaxHistoryLimit = 1000000
+
+// termOption is a tmux option clients may set
+type termOption struct {
+	name     string
+	fallback string // Value applied when the option isn't set; "" unsets it, leaving the host's tmux config in charge
+	validate func(value string) (string, error)
+}
+
+// termOptions lists the settable options in the order they are applied
+var termOptions = []termOption{
+	// The status bar is off by default for a cleaner terminal on mobile
+	{OptionStatus, "off", onOff},
+	{OptionMouse, "", onOff},
+	// Only applies to panes created after it is set
+	{OptionHistoryLimit, "", historyLimit},
+}
+
+func onOff(value string) (string, error) {
+	if value != "on" && value != "off" {
+		return "", fmt.Errorf("must be on or off")
+	}
+	return value, nil
+}
+
+func historyLimit(value string) (string, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > MaxHistoryLimit {
+		return "", fmt.Errorf("must be a number of lines from 0 to %d", MaxHistoryLimit)
+	}
+	// Canonical form, so "+5" or "05" reach tmux as 5
+	return strconv.Itoa(n), nil
+}
+
+// TermOptionError reports an option that can't be set
+type TermOptionError struct {
+	Name   string
+	Value  string
+	Reason string
+}
+
+func (e *TermOptionError) Error() string {
+	return fmt.Sprintf("terminal option %s=%q: %s", e.Name, e.Value, e.Reason)
+}
+
+// ValidateTermOptions checks options against the settable ones and returns
+// them normalized. An empty value resets an option to its default.
+func ValidateTermOptions(options map[string]string) (map[string]string, error) {
+	normalized := make(map[string]string, len(options))
+	for name, value := range options {
+		i := slices.IndexFunc(termOptions, func(o termOption) bool { return o.name == name })
+		if i < 0 {
+			return nil, &TermOptionError{Name: name, Value: value, Reason: "unknown option"}
+		}
+		if value == "" {
+			normalized[name] = ""
+			continue
+		}
+		v, err := termOptions[i].validate(value)
+		if err != nil {
+			return nil, &TermOptionError{Name: name, Value: value, Reason: err.Error()}
+		}
+		normalized[name] = v
+	}
+	return normalized, nil
+}
+
+// MergeTermOptions returns the options set on a process after changes are
+// applied to them: an empty value in changes removes the option
+func MergeTermOptions(current, changes map[string]string) map[string]string {
+	merged := maps.Clone(current)
+	if merged == nil {
+		merged = make(map[string]string)
+	}
+	for name, value := range changes {
+		if value == "" {
+			delete(merged, name)
+		} else {
+			merged[name] = value
+		}
+	}
+	return merged
+}
+
+// EffectiveTermOptions returns the options a session set up with options
+// has, including defaults. Options left to the host's tmux config are
+// absent.
+func EffectiveTermOptions(options map[string]string) map[string]string {
+	effective := make(map[string]string)
+	for _, o := range termOptions {
+		if value := options[o.name]; value != "" {
+			effective[o.name] = value
+		} else if o.fallback != "" {
+			effective[o.name] = o.fallback
+		}
+	}
+	return effective
+}
+
+// termOptionsCommand returns the tmux commands, joined with \;, that set up
+// every settable option of a session: to its value in options, which must be
+// validated, or else to its default. Every option is set, so applying it
+// also undoes options set before.
+func termOptionsCommand(tmuxName string, options map[string]string) string {
+	var cmd strings.Builder
+	for i, o := range termOptions {
+		if i > 0 {
+			cmd.WriteString(` \; `)
+		}
+		value := options[o.name]
+		if value == "" {
+			value = o.fallback
+		}
+		if value == "" {
+			fmt.Fprintf(&cmd, "set-option -u -t %s %s", tmuxName, o.name)
+		} else {
+			fmt.Fprintf(&cmd, "set-option -t %s %s %s", tmuxName, o.name, value)
+		}
+	}
+	return cmd.String()
+}
+
+// SetTermOptions applies options, validated, to the tmux session. Options
+// not in it go back to their defaults.
+func (s *Session) SetTermOptions(options map[string]string) error {
+	s.mu.Lock()
+	sshClient := s.sshClient
+	tmuxName := s.TmuxName
+	s.mu.Unlock()
+
+	if sshClient == nil {
+		return fmt.Errorf("SSH client not available")
+	}
+
+	session, err := sshClient.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	cmd := s.tmux.Cmd(termOptionsCommand(tmuxName, options))
+	if output, err := session.CombinedOutput(cmd); err != nil {
+		return fmt.Errorf("failed to set terminal options: %w: %s", err, output)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package pty
+
+import (
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestValidateTermOptions(t *testing.T) {
+	got, err := ValidateTermOptions(map[string]string{"status": "on", "mouse": "", "history-limit": "+0100"})
+	want := map[string]string{"status": "on", "mouse": "", "history-limit": "100"}
+	if err != nil || !maps.Equal(got, want) {
+		t.Errorf("ValidateTermOptions = %v, %v; want %v", got, err, want)
+	}
+
+	for _, options := range []map[string]string{
+		{"status -g": "on"},
+		{"set-titles": "on"},
+		{"status": "1"},
+		{"mouse": `on \; run-shell id`},
+		{"history-limit": "1e6"},
+		{"history-limit": "2000000"},
+	} {
+		_, err := ValidateTermOptions(options)
+		var optErr *TermOptionError
+		if !errors.As(err, &optErr) {
+			t.Errorf("ValidateTermOptions(%v) = %v, want a TermOptionError", options, err)
+		}
+	}
+}
+
+func TestTermOptionsCommand(t *testing.T) {
+	// Every option is set, to its default when not chosen
+	got := termOptionsCommand("rc-a", nil)
+	want := `set-option -t rc-a status off \; set-option -u -t rc-a mouse \; set-option -u -t rc-a history-limit`
+	if got != want {
+		t.Errorf("defaults = %q, want %q", got, want)
+	}
+
+	got = termOptionsCommand("rc-a", map[string]string{"status": "on", "history-limit": "50000"})
+	want = `set-option -t rc-a status on \; set-option -u -t rc-a mouse \; set-option -t rc-a history-limit 50000`
+	if got != want {
+		t.Errorf("command = %q, want %q", got, want)
+	}
+
+	config := DefaultSessionConfig()
+	config.TermOptions = map[string]string{"mouse": "on"}
+	if cmd := newSessionCommand("rc-a", config); !strings.Contains(cmd, `\; set-option -t rc-a status off \; set-option -t rc-a mouse on \;`) {
+		t.Errorf("new session = %q", cmd)
+	}
+}
+
+func TestMergeTermOptions(t *testing.T) {
+	current := map[string]string{"status": "on", "mouse": "on"}
+	got := MergeTermOptions(current, map[string]string{"mouse": "", "history-limit": "10"})
+	want := map[string]string{"status": "on", "history-limit": "10"}
+	if !maps.Equal(got, want) {
+		t.Errorf("MergeTermOptions = %v, want %v", got, want)
+	}
+	if current["mouse"] != "on" {
+		t.Error("MergeTermOptions modified the current options")
+	}
+
+	if got := EffectiveTermOptions(nil); !maps.Equal(got, map[string]string{"status": "off"}) {
+		t.Errorf("EffectiveTermOptions(nil) = %v", got)
+	}
+}
@@ -0,0 +1,45 @@
+package pty
+
+import (
+	"fmt"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/shellargs"
+)
+
+// Tmux builds the tmux command lines run on a host. Every tmux invocation
+// goes through it, so a host whose account is shared can point them all at
+// its own tmux server. The zero value runs tmux from the PATH against the
+// user's default server.
+type Tmux struct {
+	Command    string // Program run instead of tmux, e.g. a build outside the PATH
+	SocketPath string // Server socket (tmux -S); the default server when empty
+}
+
+// program returns the tmux program, shell-quoted
+func (t Tmux) program() string {
+	if t.Command == "" {
+		return "tmux"
+	}
+	return shellargs.Quote(t.Command)
+}
+
+// Cmd returns the command line that runs tmux with args, which are used as
+// they are (quoted by the caller where needed)
+func (t Tmux) Cmd(args string) string {
+	cmd := t.program()
+	if t.SocketPath != "" {
+		cmd += " -S " + shellargs.Quote(t.SocketPath)
+	}
+	return cmd + " " + args
+}
+
+// Cmdf is Cmd with the arguments formatted as by fmt.Sprintf
+func (t Tmux) Cmdf(format string, a ...any) string {
+	return t.Cmd(fmt.Sprintf(format, a...))
+}
+
+// availableCommand returns the command line that succeeds if the tmux
+// program can be run
+func (t Tmux) availableCommand() string {
+	return "command -v " + t.program() + " >/dev/null 2>&1"
+}
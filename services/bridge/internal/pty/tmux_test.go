@@ -0,0 +1,96 @@
+package pty
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	rcssh "github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+func TestTmuxCmd(t *testing.T) {
+	tests := []struct {
+		tmux Tmux
+		want string
+	}{
+		{Tmux{}, "tmux kill-session -t rc-a"},
+		{Tmux{SocketPath: "/tmp/shared/default"}, "tmux -S /tmp/shared/default kill-session -t rc-a"},
+		{Tmux{Command: "/opt/tmux 3.4/bin/tmux", SocketPath: "~/run/tmux's.sock"},
+			`'/opt/tmux 3.4/bin/tmux' -S ~/'run/tmux'\''s.sock' kill-session -t rc-a`},
+	}
+	for _, tt := range tests {
+		if got := tt.tmux.Cmdf("kill-session -t %s", "rc-a"); got != tt.want {
+			t.Errorf("%+v: command = %q, want %q", tt.tmux, got, tt.want)
+		}
+	}
+}
+
+// TestTmuxCommandsHonorTheHost checks the command lines built for a session
+// run its host's tmux
+func TestTmuxCommandsHonorTheHost(t *testing.T) {
+	tmux := Tmux{Command: "/opt/tmux/bin/tmux", SocketPath: "/srv/shared.sock"}
+	prefix := "/opt/tmux/bin/tmux -S /srv/shared.sock "
+
+	config := DefaultSessionConfig()
+	config.Tmux = tmux
+	for name, cmd := range map[string]string{
+		"new-session":   newSessionCommand("rc-a", config),
+		"list-panes":    paneInfoCommand(tmux, "rc-a"),
+		"list-sessions": listSessionsCommand(tmux),
+	} {
+		if !strings.HasPrefix(cmd, prefix+name+" ") {
+			t.Errorf("%s command = %q, want it run by %q", name, cmd, prefix)
+		}
+	}
+}
+
+// hardCodedTmux matches a tmux command line written out instead of built
+// with Tmux
+var hardCodedTmux = regexp.MustCompile("[\"`](which )?tmux( [a-z]+-[a-z]+\\b| ?[\"`])")
+
+// TestNoHardCodedTmux checks every tmux command line goes through Tmux, so
+// none of them miss a host's socket or command
+func TestNoHardCodedTmux(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	envFiles, err := filepath.Glob("../env/*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range append(files, envFiles...) {
+		if file == "tmux.go" || strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, line := range strings.Split(string(data), "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(line), "//") && hardCodedTmux.MatchString(line) {
+				t.Errorf("%s:%d runs tmux without Tmux: %s", file, i+1, strings.TrimSpace(line))
+			}
+		}
+	}
+}
+
+func TestTmuxTmpDir(t *testing.T) {
+	tests := []struct {
+		result rcssh.BatchResult
+		want   string
+	}{
+		{rcssh.BatchResult{Output: ""}, ""},
+		{rcssh.BatchResult{Output: "/tmp"}, ""},
+		{rcssh.BatchResult{Output: "/tmp/"}, ""},
+		{rcssh.BatchResult{Output: "/run/user/1000"}, "/run/user/1000"},
+		{rcssh.BatchResult{Output: "/run/user/1000", ExitCode: 1}, ""},
+	}
+	for _, tt := range tests {
+		if got := tmuxTmpDir(tt.result); got != tt.want {
+			t.Errorf("tmuxTmpDir(%+v) = %q, want %q", tt.result, got, tt.want)
+		}
+	}
+}
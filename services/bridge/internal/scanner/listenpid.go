@@ -0,0 +1,173 @@
+package scanner
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// PIDMethodProc is the method FindListeningPID reports when the PID was
+// found through /proc; the others are named after their tool (see netTools)
+const PIDMethodProc = "proc"
+
+// procListenState is the st column of a listening socket in /proc/net/tcp
+const procListenState = "0A"
+
+// FindListeningPID finds the process listening on a TCP port of the host,
+// and reports which method found it. The platform's network tools are tried
+// first, as ScanNetworkPorts does; run without root they may not be
+// installed, or may list a socket without its process. The last resort
+// needs no tools: the socket's inode from /proc/net/tcp, matched to the
+// process holding it under /proc/<pid>/fd, which is readable for the user's
+// own processes, such as the AgentAPI servers the bridge started. It is
+// skipped on hosts known to have no /proc.
+func FindListeningPID(sshClient *gossh.Client, port int, platform ssh.Platform) (pid int, method string, err error) {
+	ports := process.PortRange{Min: port, Max: port}
+	tools := netToolsFor(platform)
+	cmds := make([]string, len(tools))
+	for i, tool := range tools {
+		cmds[i] = tool.cmd(ports)
+	}
+	results, err := ssh.RunBatch(sshClient, cmds)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to run network tools: %w", err)
+	}
+	for i, tool := range tools {
+		for _, r := range tool.parse(results[i].Output, port, port) {
+			if r.PID > 0 {
+				return r.PID, tool.name, nil
+			}
+		}
+	}
+	if platform.Known() && !platform.HasProc {
+		return 0, "", fmt.Errorf("no process found listening on port %d, and the host has no /proc", port)
+	}
+
+	results, err = ssh.RunBatch(sshClient, []string{
+		"cat /proc/net/tcp /proc/net/tcp6 2>/dev/null",
+		`ls -l /proc/[0-9]*/fd 2>/dev/null | grep -E '^/proc/|socket:\['`,
+	})
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read /proc: %w", err)
+	}
+	pid, err = listeningPIDFromProc(results[0].Output, results[1].Output, port)
+	if err != nil {
+		return 0, "", err
+	}
+	return pid, PIDMethodProc, nil
+}
+
+// listeningPIDFromProc finds the process holding a socket listening on
+// port, from the contents of /proc/net/tcp{,6} and a listing of /proc/*/fd
+func listeningPIDFromProc(netTCP, fdListing string, port int) (int, error) {
+	var inodes []uint64
+	for _, sock := range parseProcNetTCP(netTCP) {
+		if sock.Port == port && sock.State == procListenState && sock.Inode != 0 {
+			inodes = append(inodes, sock.Inode)
+		}
+	}
+	if len(inodes) == 0 {
+		return 0, fmt.Errorf("nothing is listening on port %d", port)
+	}
+
+	owners := parseProcFDListing(fdListing)
+	for _, inode := range inodes {
+		if pid, ok := owners[inode]; ok {
+			return pid, nil
+		}
+	}
+	return 0, errors.New("no tool could tell which process listens on the port, and it isn't one of this user's")
+}
+
+// procSocket is a row of /proc/net/tcp or /proc/net/tcp6
+type procSocket struct {
+	IP    net.IP
+	Port  int
+	State string // Hex TCP state; 0A is LISTEN
+	Inode uint64
+}
+
+// parseProcNetTCP parses /proc/net/tcp and /proc/net/tcp6, skipping their
+// headers and rows it can't read:
+//
+//	sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+//	 0: 0100007F:0CD4 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 48213 ...
+func parseProcNetTCP(output string) []procSocket {
+	var sockets []procSocket
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 10 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+		ip, port, err := decodeProcAddr(fields[1])
+		if err != nil {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			continue
+		}
+		sockets = append(sockets, procSocket{IP: ip, Port: port, State: strings.ToUpper(fields[3]), Inode: inode})
+	}
+	return sockets
+}
+
+// decodeProcAddr decodes an address of /proc/net/tcp{,6}: the IP as hex
+// 32-bit words in host byte order (little-endian on the hosts the bridge
+// supports), then the port in hex, e.g. 0100007F:0CD4 is 127.0.0.1:3284
+func decodeProcAddr(s string) (net.IP, int, error) {
+	addr, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid address %q", s)
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid port in %q", s)
+	}
+	raw, err := hex.DecodeString(addr)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("invalid IP in %q", s)
+	}
+	for word := 0; word < len(raw); word += 4 {
+		raw[word], raw[word+1], raw[word+2], raw[word+3] = raw[word+3], raw[word+2], raw[word+1], raw[word]
+	}
+	return net.IP(raw), int(port), nil
+}
+
+// parseProcFDListing maps socket inodes to the PIDs holding them, from
+// ls -l of /proc/*/fd directories: a "/proc/<pid>/fd:" line starts each
+// process's entries, which end in "-> socket:[<inode>]" for sockets. Where
+// processes share a socket, the first listed wins.
+func parseProcFDListing(output string) map[uint64]int {
+	owners := make(map[uint64]int)
+	pid := 0
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if dir, ok := strings.CutPrefix(line, "/proc/"); ok {
+			pid = 0
+			if id, rest, ok := strings.Cut(dir, "/"); ok && strings.HasPrefix(rest, "fd") {
+				pid, _ = strconv.Atoi(id)
+			}
+			continue
+		}
+		_, target, ok := strings.Cut(line, "-> socket:[")
+		if !ok || pid <= 0 {
+			continue
+		}
+		inode, err := strconv.ParseUint(strings.TrimSuffix(target, "]"), 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, seen := owners[inode]; !seen {
+			owners[inode] = pid
+		}
+	}
+	return owners
+}
@@ -0,0 +1,128 @@
+package scanner
+
+import (
+	"net"
+	"testing"
+)
+
+// Fixture /proc content from a host with an AgentAPI server on 3284 owned
+// by the user (pid 900), sshd on 22 owned by root and an IPv6 server on
+// 40007 (pid 1234), plus a connection to 3284 that isn't listening
+const (
+	fixtureProcNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 15120 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0CD4 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 48213 1 0000000000000000 100 0 0 10 0
+   2: 0100007F:D2F0 0100007F:0CD4 01 00000000:00000000 00:00000000 00000000  1000        0 48990 1 0000000000000000 20 4 30 10 -1
+  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000001000000:9C47 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 51002 1 0000000000000000 100 0 0 10 0
+`
+	fixtureFDListing = `/proc/900/fd:
+lrwx------ 1 dev dev 64 Oct 16 09:12 0 -> /dev/null
+lrwx------ 1 dev dev 64 Oct 16 09:12 3 -> socket:[48213]
+/proc/901/fd:
+lrwx------ 1 dev dev 64 Oct 16 09:12 5 -> socket:[48990]
+/proc/1234/fd:
+lrwx------ 1 dev dev 64 Oct 16 09:12 4 -> socket:[51002]
+/proc/1300/fd:
+lrwx------ 1 dev dev 64 Oct 16 09:12 3 -> socket:[48213]
+`
+)
+
+func TestDecodeProcAddr(t *testing.T) {
+	tests := []struct {
+		in   string
+		ip   string
+		port int
+	}{
+		{"0100007F:0CD4", "127.0.0.1", 3284},
+		{"00000000:0016", "0.0.0.0", 22},
+		{"0101A8C0:9C47", "192.168.1.1", 40007},
+		{"00000000000000000000000001000000:9C47", "::1", 40007},
+		{"0000000000000000FFFF00000100007F:0CD4", "127.0.0.1", 3284}, // IPv4-mapped
+		{"B80D0120000000000000000001000000:01BB", "2001:db8::1", 443},
+	}
+	for _, tt := range tests {
+		ip, port, err := decodeProcAddr(tt.in)
+		if err != nil {
+			t.Errorf("decodeProcAddr(%q) error: %v", tt.in, err)
+			continue
+		}
+		if !ip.Equal(net.ParseIP(tt.ip)) || port != tt.port {
+			t.Errorf("decodeProcAddr(%q) = %s:%d, want %s:%d", tt.in, ip, port, tt.ip, tt.port)
+		}
+	}
+
+	for _, bad := range []string{"", "0100007F", "0100007F:", "0100007F:XYZ", "01007F:0CD4", "0100007G:0CD4", "0100007F:10000"} {
+		if _, _, err := decodeProcAddr(bad); err == nil {
+			t.Errorf("decodeProcAddr(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestParseProcNetTCP(t *testing.T) {
+	sockets := parseProcNetTCP(fixtureProcNetTCP + "   9: garbage\n")
+	if len(sockets) != 4 {
+		t.Fatalf("parsed %d sockets, want 4 (headers and bad rows skipped): %+v", len(sockets), sockets)
+	}
+	want := []struct {
+		port  int
+		state string
+		inode uint64
+	}{
+		{22, "0A", 15120},
+		{3284, "0A", 48213},
+		{54000, "01", 48990},
+		{40007, "0A", 51002},
+	}
+	for i, w := range want {
+		s := sockets[i]
+		if s.Port != w.port || s.State != w.state || s.Inode != w.inode {
+			t.Errorf("socket %d = %+v, want port %d state %s inode %d", i, s, w.port, w.state, w.inode)
+		}
+	}
+}
+
+func TestParseProcFDListing(t *testing.T) {
+	owners := parseProcFDListing(fixtureFDListing + "lrwx------ 1 dev dev 64 Oct 16 09:12 6 -> socket:[oops]\n")
+	want := map[uint64]int{48213: 900, 48990: 901, 51002: 1234}
+	if len(owners) != len(want) {
+		t.Fatalf("owners = %v, want %v", owners, want)
+	}
+	for inode, pid := range want {
+		if owners[inode] != pid {
+			t.Errorf("inode %d owned by %d, want %d", inode, owners[inode], pid)
+		}
+	}
+
+	// Entries before any directory header, or under an unreadable one,
+	// belong to no process
+	orphans := parseProcFDListing("lrwx------ 1 dev dev 64 Oct 16 09:12 3 -> socket:[1]\n/proc/self/fd:\nlrwx------ 1 dev dev 64 Oct 16 09:12 3 -> socket:[2]\n")
+	if len(orphans) != 0 {
+		t.Errorf("owners = %v, want none", orphans)
+	}
+}
+
+func TestListeningPIDFromProc(t *testing.T) {
+	tests := []struct {
+		name    string
+		port    int
+		fds     string
+		wantPID int
+		wantErr bool
+	}{
+		{"own IPv4 server", 3284, fixtureFDListing, 900, false},
+		{"own IPv6 server", 40007, fixtureFDListing, 1234, false},
+		{"another user's server", 22, fixtureFDListing, 0, true},
+		{"only a connection to the port", 54000, fixtureFDListing, 0, true},
+		{"nothing on the port", 8080, fixtureFDListing, 0, true},
+		{"no fd listing", 3284, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pid, err := listeningPIDFromProc(fixtureProcNetTCP, tt.fds, tt.port)
+			if (err != nil) != tt.wantErr || pid != tt.wantPID {
+				t.Errorf("listeningPIDFromProc(port %d) = %d, %v; want %d, error %v", tt.port, pid, err, tt.wantPID, tt.wantErr)
+			}
+		})
+	}
+}
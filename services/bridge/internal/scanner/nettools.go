@@ -1,12 +1,15 @@
 package scanner
 
 import (
+	"fmt"
 	"log"
 	"regexp"
 	"strconv"
 	"strings"
 
-	"golang.org/x/crypto/ssh"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 // NetToolResult contains info about a port from network tools
@@ -24,57 +27,75 @@ type NetToolInfo struct {
 	Error   string           // Error message if no tool available
 }
 
-// ScanNetworkPorts uses available network tools (ss, netstat, lsof) to find
-// which processes are listening on the AgentAPI ports range.
-// It tries tools in order of preference: ss (modern), netstat (legacy), lsof (fallback)
-func ScanNetworkPorts(sshClient *ssh.Client, minPort, maxPort int) NetToolInfo {
-	// Try ss first (modern, preferred)
-	if results, err := trySS(sshClient, minPort, maxPort); err == nil {
-		return NetToolInfo{Tool: "ss", Results: results}
-	}
+// netTool is a network tool that can list the processes listening on ports
+type netTool struct {
+	name  string
+	cmd   func(ports process.PortRange) string
+	parse func(output string, minPort, maxPort int) []NetToolResult
+}
 
-	// Try netstat (legacy but widely available)
-	if results, err := tryNetstat(sshClient, minPort, maxPort); err == nil {
-		return NetToolInfo{Tool: "netstat", Results: results}
-	}
+// netTools are tried in order of preference: ss (modern), netstat (legacy),
+// lsof (fallback). ss and netstat list every listening socket and their
+// parsers keep the ports in range; lsof filters by range itself.
+var netTools = []netTool{
+	// ss -tlnp: TCP, listening, numeric, processes
+	{"ss", func(process.PortRange) string { return "ss -tlnp 2>/dev/null" }, parseSSOutput},
+	// netstat -tlnp: TCP, listening, numeric, programs
+	{"netstat", func(process.PortRange) string { return "netstat -tlnp 2>/dev/null" }, parseNetstatOutput},
+	// lsof -iTCP:<min>-<max> -sTCP:LISTEN -n -P
+	{"lsof", func(ports process.PortRange) string {
+		return fmt.Sprintf("lsof -iTCP:%d-%d -sTCP:LISTEN -n -P 2>/dev/null", ports.Min, ports.Max)
+	}, parseLsofOutput},
+}
 
-	// Try lsof (fallback)
-	if results, err := tryLsof(sshClient, minPort, maxPort); err == nil {
-		return NetToolInfo{Tool: "lsof", Results: results}
+// netToolsFor returns the network tools worth trying on a platform, in
+// order. macOS has no ss, and its netstat takes -p for a protocol and lists
+// no processes, so only lsof is tried there. An unknown platform tries all.
+func netToolsFor(platform ssh.Platform) []netTool {
+	if !platform.IsDarwin() {
+		return netTools
 	}
-
-	return NetToolInfo{
-		Error: "No network tools available (ss, netstat, or lsof required)",
+	var tools []netTool
+	for _, tool := range netTools {
+		if tool.name == "lsof" {
+			tools = append(tools, tool)
+		}
 	}
+	return tools
 }
 
-// trySS uses the ss command to scan ports
-// ss -tlnp shows TCP listening sockets with process info
-func trySS(sshClient *ssh.Client, minPort, maxPort int) ([]NetToolResult, error) {
-	session, err := sshClient.NewSession()
+// ScanNetworkPorts uses available network tools (ss, netstat, lsof) to find
+// which processes are listening on the AgentAPI port range.
+// It checks which of the platform's tools are installed in one SSH session,
+// then runs the preferred one in a second.
+func ScanNetworkPorts(sshClient *gossh.Client, ports process.PortRange, platform ssh.Platform) NetToolInfo {
+	tools := netToolsFor(platform)
+	checks := make([]string, len(tools))
+	for i, tool := range tools {
+		checks[i] = "which " + tool.name
+	}
+	installed, err := ssh.RunBatch(sshClient, checks)
 	if err != nil {
-		return nil, err
+		log.Printf("[WARN] [NETTOOLS] Failed to check for network tools: %v", err)
+		return NetToolInfo{Error: err.Error()}
 	}
-	defer session.Close()
 
-	// ss -tlnp: TCP, listening, numeric, processes
-	// Filter for our port range using grep
-	cmd := "ss -tlnp 2>/dev/null | grep -E ':(328[4-9]|329[0-9])\\s'"
-	output, err := session.Output(cmd)
-	if err != nil {
-		// Check if ss command exists
-		session2, _ := sshClient.NewSession()
-		if session2 != nil {
-			defer session2.Close()
-			if _, err := session2.Output("which ss"); err != nil {
-				return nil, err
-			}
+	for i, tool := range tools {
+		if !installed[i].OK() {
+			continue
+		}
+		results, err := ssh.RunBatch(sshClient, []string{tool.cmd(ports)})
+		if err != nil {
+			log.Printf("[WARN] [NETTOOLS] Failed to run %s: %v", tool.name, err)
+			return NetToolInfo{Tool: tool.name, Error: err.Error()}
 		}
-		// ss exists but no matches, return empty
-		return []NetToolResult{}, nil
+		// No output just means nothing is listening in range
+		return NetToolInfo{Tool: tool.name, Results: tool.parse(results[0].Output, ports.Min, ports.Max)}
 	}
 
-	return parseSSOutput(string(output), minPort, maxPort), nil
+	return NetToolInfo{
+		Error: "No network tools available (ss, netstat, or lsof required)",
+	}
 }
 
 // parseSSOutput parses ss -tlnp output
@@ -118,33 +139,6 @@ func parseSSOutput(output string, minPort, maxPort int) []NetToolResult {
 	return results
 }
 
-// tryNetstat uses the netstat command to scan ports
-func tryNetstat(sshClient *ssh.Client, minPort, maxPort int) ([]NetToolResult, error) {
-	session, err := sshClient.NewSession()
-	if err != nil {
-		return nil, err
-	}
-	defer session.Close()
-
-	// netstat -tlnp: TCP, listening, numeric, programs
-	cmd := "netstat -tlnp 2>/dev/null | grep -E ':(328[4-9]|329[0-9])\\s'"
-	output, err := session.Output(cmd)
-	if err != nil {
-		// Check if netstat command exists
-		session2, _ := sshClient.NewSession()
-		if session2 != nil {
-			defer session2.Close()
-			if _, err := session2.Output("which netstat"); err != nil {
-				return nil, err
-			}
-		}
-		// netstat exists but no matches
-		return []NetToolResult{}, nil
-	}
-
-	return parseNetstatOutput(string(output), minPort, maxPort), nil
-}
-
 // parseNetstatOutput parses netstat -tlnp output
 // Format: tcp 0 0 0.0.0.0:3284 0.0.0.0:* LISTEN 12345/node
 func parseNetstatOutput(output string, minPort, maxPort int) []NetToolResult {
@@ -185,33 +179,6 @@ func parseNetstatOutput(output string, minPort, maxPort int) []NetToolResult {
 	return results
 }
 
-// tryLsof uses the lsof command to scan ports
-func tryLsof(sshClient *ssh.Client, minPort, maxPort int) ([]NetToolResult, error) {
-	session, err := sshClient.NewSession()
-	if err != nil {
-		return nil, err
-	}
-	defer session.Close()
-
-	// lsof -iTCP:3284-3299 -sTCP:LISTEN -n -P
-	cmd := "lsof -iTCP:3284-3299 -sTCP:LISTEN -n -P 2>/dev/null"
-	output, err := session.Output(cmd)
-	if err != nil {
-		// Check if lsof command exists
-		session2, _ := sshClient.NewSession()
-		if session2 != nil {
-			defer session2.Close()
-			if _, err := session2.Output("which lsof"); err != nil {
-				return nil, err
-			}
-		}
-		// lsof exists but no matches
-		return []NetToolResult{}, nil
-	}
-
-	return parseLsofOutput(string(output), minPort, maxPort), nil
-}
-
 // parseLsofOutput parses lsof output
 // Format: COMMAND PID USER FD TYPE DEVICE SIZE/OFF NODE NAME
 // Example: node 12345 user 23u IPv4 12345 0t0 TCP *:3284 (LISTEN)
@@ -0,0 +1,72 @@
+package scanner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+func TestNetToolCommandsFollowPortRange(t *testing.T) {
+	ports := process.PortRange{Min: 40000, Max: 40511}
+	for _, tool := range netTools {
+		cmd := tool.cmd(ports)
+		if strings.Contains(cmd, "3284") || strings.Contains(cmd, "328[") {
+			t.Errorf("%s command %q still filters for the default range", tool.name, cmd)
+		}
+		if tool.name == "lsof" && !strings.Contains(cmd, "-iTCP:40000-40511 ") {
+			t.Errorf("lsof command %q doesn't select the configured range", cmd)
+		}
+	}
+}
+
+func TestParsersKeepPortsInRange(t *testing.T) {
+	ss := `State  Recv-Q Send-Q Local Address:Port Peer Address:Port Process
+LISTEN 0      128    0.0.0.0:22         0.0.0.0:*     users:(("sshd",pid=800,fd=3))
+LISTEN 0      128    0.0.0.0:3284       0.0.0.0:*     users:(("agentapi",pid=900,fd=3))
+LISTEN 0      128    127.0.0.1:40007    0.0.0.0:*     users:(("agentapi",pid=1234,fd=3))
+LISTEN 0      128    [::]:40511         [::]:*        users:(("agentapi",pid=1235,fd=3))
+`
+	netstat := `Proto Recv-Q Send-Q Local Address   Foreign Address State  PID/Program name
+tcp        0      0 0.0.0.0:3284    0.0.0.0:*       LISTEN 900/agentapi
+tcp        0      0 127.0.0.1:40007 0.0.0.0:*       LISTEN 1234/agentapi
+tcp6       0      0 :::40511        :::*            LISTEN 1235/agentapi
+`
+	tests := []struct {
+		name   string
+		parse  func(string, int, int) []NetToolResult
+		output string
+	}{
+		{"ss", parseSSOutput, ss},
+		{"netstat", parseNetstatOutput, netstat},
+	}
+	for _, tt := range tests {
+		results := tt.parse(tt.output, 40000, 40511)
+		if len(results) != 2 || results[0].Port != 40007 || results[0].PID != 1234 || results[1].Port != 40511 {
+			t.Errorf("%s: results = %+v, want ports 40007 and 40511", tt.name, results)
+		}
+	}
+}
+
+func TestNetToolsForPlatform(t *testing.T) {
+	names := func(tools []netTool) string {
+		var names []string
+		for _, tool := range tools {
+			names = append(names, tool.name)
+		}
+		return strings.Join(names, ",")
+	}
+	for _, tt := range []struct {
+		platform ssh.Platform
+		want     string
+	}{
+		{ssh.Platform{}, "ss,netstat,lsof"},
+		{ssh.Platform{OS: "Linux", HasProc: true}, "ss,netstat,lsof"},
+		{ssh.Platform{OS: "Darwin"}, "lsof"},
+	} {
+		if got := names(netToolsFor(tt.platform)); got != tt.want {
+			t.Errorf("tools for %q = %s, want %s", tt.platform.OS, got, tt.want)
+		}
+	}
+}
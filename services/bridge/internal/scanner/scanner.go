@@ -1,6 +1,7 @@
 package scanner
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -26,34 +27,45 @@ type ScanResult struct {
 // Scanner scans for existing AgentAPI servers through SSH tunnel
 type Scanner struct {
 	timeout time.Duration
+	ports   process.PortRange
 }
 
-// NewScanner creates a new port scanner
-func NewScanner() *Scanner {
+// NewScanner creates a new port scanner over the given AgentAPI port range
+func NewScanner(ports process.PortRange) *Scanner {
 	return &Scanner{
 		timeout: 2 * time.Second,
+		ports:   ports,
 	}
 }
 
-// ScanPorts scans all AgentAPI ports (3284-3299) through the SSH tunnel
-// Returns active processes found and stale processes (refused/timeout)
-func (s *Scanner) ScanPorts(sshClient *gossh.Client, hostID string) ([]protocol.ProcessInfo, []protocol.StaleProcess) {
+// ScanPorts scans all AgentAPI ports in the scanner's range through the SSH tunnel
+// Returns active processes found and stale processes (refused/timeout).
+// Probes still running when ctx is done are cut off.
+func (s *Scanner) ScanPorts(ctx context.Context, sshClient *gossh.Client, hostID string) ([]protocol.ProcessInfo, []protocol.StaleProcess) {
 	log.Printf("[DEBUG] [SCANNER] Starting port scan for hostID=%s", hostID)
 
 	var wg sync.WaitGroup
-	results := make(chan ScanResult, process.MaxPort-process.MinPort+1)
+	results := make(chan ScanResult, s.ports.Size())
 
 	// Create tunneled HTTP client
 	httpClient := ssh.TunnelHTTPClient(sshClient)
 	httpClient.Timeout = s.timeout
 
-	// Scan all ports concurrently
-	for port := process.MinPort; port <= process.MaxPort; port++ {
+	// Scan all ports concurrently, as far as the host's operation limit
+	// allows; a probe's timeout includes its wait for a slot
+	for port := s.ports.Min; port <= s.ports.Max; port++ {
 		wg.Add(1)
 		go func(p int) {
 			defer wg.Done()
-			result := s.scanPort(httpClient, p)
-			results <- result
+			ctx, cancel := context.WithTimeout(ctx, s.timeout)
+			defer cancel()
+			release, err := ssh.AcquireOp(ctx, sshClient)
+			if err != nil {
+				results <- ScanResult{Port: p, Active: false, Status: "error", Error: err}
+				return
+			}
+			defer release()
+			results <- s.scanPort(ctx, httpClient, p)
 		}(port)
 	}
 
@@ -97,10 +109,14 @@ func (s *Scanner) ScanPorts(sshClient *gossh.Client, hostID string) ([]protocol.
 }
 
 // scanPort checks a single port for an active AgentAPI server
-func (s *Scanner) scanPort(client *http.Client, port int) ScanResult {
+func (s *Scanner) scanPort(ctx context.Context, client *http.Client, port int) ScanResult {
 	url := fmt.Sprintf("http://localhost:%d/status", port)
 
-	resp, err := client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return ScanResult{Port: port, Active: false, Status: "error", Error: err}
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		// Determine error type
 		errStr := err.Error()
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/admin"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/session"
+)
+
+// ============================================================================
+// Admin Socket
+// ============================================================================
+//
+// The admin socket serves protocol requests to scripts on the bridge's own
+// machine (see cmd/rcctl) without the WebSocket session layer: no auth, no
+// subscriptions, nothing pushed. Each connection gets a session of its own
+// that the session manager doesn't know, so broadcasts never reach it, and
+// each request line is answered with every message its handler sent.
+//
+// Access is by file permission: the socket is only usable by the bridge's
+// user. Requests that change state are refused unless Config.AdminWrites.
+
+// adminReadTypes are the requests the admin socket serves
+var adminReadTypes = map[string]bool{
+	protocol.TypeHostConfigList:      true,
+	protocol.TypeProcessList:         true,
+	protocol.TypeProcessTimelineList: true,
+	protocol.TypeProcessTemplateList: true,
+	protocol.TypeChatHistory:         true,
+	protocol.TypeChatStatus:          true,
+	protocol.TypeChatSearch:          true,
+	protocol.TypeChatUsage:           true,
+	protocol.TypeSnippetList:         true,
+	protocol.TypeWorkspaceList:       true,
+	protocol.TypeBridgeInfo:          true,
+	protocol.TypeSessionInfo:         true,
+	protocol.TypeProfileList:         true,
+}
+
+// adminWriteTypes are the requests the admin socket serves only with
+// Config.AdminWrites
+var adminWriteTypes = map[string]bool{
+	protocol.TypeProcessKill:   true,
+	protocol.TypeProcessRename: true,
+	protocol.TypeClaudeKill:    true,
+}
+
+// adminServer is the admin socket's listener and open connections
+type adminServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	conns    map[*net.UnixConn]bool
+	wg       sync.WaitGroup
+}
+
+// startAdmin starts serving the admin socket in the profile's directory
+func (s *Server) startAdmin() error {
+	path := admin.SocketPath(s.profileDir)
+	if err := removeStaleSocket(path); err != nil {
+		return err
+	}
+
+	// Connecting needs write permission on the socket, which the usual
+	// umask already denies others between Listen and Chmod
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on admin socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict admin socket: %w", err)
+	}
+
+	s.admin = &adminServer{listener: listener, conns: make(map[*net.UnixConn]bool)}
+	s.admin.wg.Add(1)
+	go s.acceptAdmin()
+	// Requests in progress are answered while storage is still open
+	s.onShutdown(shutdownAccepting, "admin socket", shutdownAcceptingTimeout, s.stopAdmin)
+
+	mode := "read-only"
+	if s.config.AdminWrites {
+		mode = "read-write"
+	}
+	log.Printf("[INFO] [ADMIN] Admin socket: %s (%s)", path, mode)
+	return nil
+}
+
+// removeStaleSocket removes a socket left by a bridge that didn't shut down
+// cleanly, and fails if a running bridge still serves it
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("another bridge is serving the admin socket %s", path)
+	}
+	log.Printf("[WARN] [ADMIN] Removing stale admin socket %s", path)
+	return os.Remove(path)
+}
+
+// stopAdmin stops accepting admin connections and waits for the requests
+// in progress to be answered
+func (s *Server) stopAdmin() {
+	if s.admin == nil {
+		return
+	}
+	s.admin.listener.Close()
+
+	// Closing the read side ends each connection after its current request
+	s.admin.mu.Lock()
+	for conn := range s.admin.conns {
+		conn.CloseRead()
+	}
+	s.admin.mu.Unlock()
+	s.admin.wg.Wait()
+}
+
+func (s *Server) acceptAdmin() {
+	defer s.admin.wg.Done()
+	for {
+		conn, err := s.admin.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("[ERROR] [ADMIN] Accept failed: %v", err)
+			}
+			return
+		}
+		unixConn := conn.(*net.UnixConn)
+		s.admin.mu.Lock()
+		s.admin.conns[unixConn] = true
+		s.admin.mu.Unlock()
+
+		s.admin.wg.Add(1)
+		go func() {
+			defer s.admin.wg.Done()
+			defer func() {
+				s.admin.mu.Lock()
+				delete(s.admin.conns, unixConn)
+				s.admin.mu.Unlock()
+				unixConn.Close()
+			}()
+			s.serveAdmin(unixConn)
+		}()
+	}
+}
+
+// adminSink collects the messages sent for the request in progress.
+// Messages sent between requests, by work a handler left running, are
+// dropped.
+type adminSink struct {
+	messages []protocol.Message
+	active   bool
+}
+
+func (a *adminSink) send(msg *protocol.Message) error {
+	if a.active {
+		a.messages = append(a.messages, *msg)
+	}
+	return nil
+}
+
+// serveAdmin answers the requests on one admin connection, one at a time
+func (s *Server) serveAdmin(conn net.Conn) {
+	now := time.Now()
+	sess := &session.Session{
+		ID:              "admin-" + uuid.New().String(),
+		State:           session.StateConnected,
+		CreatedAt:       now,
+		LastSeenAt:      now,
+		HostConnections: make(map[string]bool),
+	}
+	// Scripts on the bridge's own account are trusted as the owner; writes
+	// are gated by Config.AdminWrites instead
+	sess.SetRole(session.RoleOwner, session.Scope{}, "")
+	sink := &adminSink{}
+	cs := &ConnectedSession{Session: sess, server: s, sink: sink.send}
+	log.Printf("[DEBUG] [ADMIN] New connection, session=%s", sess.ID)
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64<<10), admin.MaxRequestSize)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		// The sink is only touched under the session lock, as writes are
+		cs.Lock()
+		sink.messages, sink.active = nil, true
+		cs.Unlock()
+
+		s.handleAdminRequest(cs, scanner.Bytes())
+
+		cs.Lock()
+		response := admin.Response{Messages: sink.messages}
+		sink.active = false
+		cs.Unlock()
+		if response.Messages == nil {
+			response.Messages = []protocol.Message{}
+		}
+		if err := encoder.Encode(response); err != nil {
+			log.Printf("[WARN] [ADMIN] Failed to send response to session %s: %v", sess.ID, err)
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("[WARN] [ADMIN] Read error on session %s: %v", sess.ID, err)
+	}
+	log.Printf("[DEBUG] [ADMIN] Connection closed, session=%s", sess.ID)
+}
+
+// handleAdminRequest runs one request line through the handler registered
+// for its type, as the WebSocket dispatcher would
+func (s *Server) handleAdminRequest(cs *ConnectedSession, line []byte) {
+	var msg protocol.Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		cs.SendErrorDetails(protocol.ErrorInvalidMessage, protocol.ErrorDetails{"reason": err.Error()})
+		return
+	}
+	log.Printf("[DEBUG] [ADMIN] Received from session %s: %s", cs.ID, loggableMessage(msg.Type, line))
+
+	handler, ok := s.handlers[msg.Type]
+	if !ok || !(adminReadTypes[msg.Type] || adminWriteTypes[msg.Type]) {
+		cs.SendErrorDetails(protocol.ErrorUnknownMessageType,
+			protocol.ErrorDetails{"type": msg.Type, "reason": "not served on the admin socket"})
+		return
+	}
+	if adminWriteTypes[msg.Type] && !s.config.AdminWrites {
+		log.Printf("[WARN] [ADMIN] Refused %s from session %s: admin writes are disabled", msg.Type, cs.ID)
+		cs.SendErrorDetails(protocol.ErrorForbidden,
+			protocol.ErrorDetails{"type": msg.Type, "reason": "admin writes are disabled; start the bridge with -allow-admin-writes"})
+		return
+	}
+
+	(&dispatcher{server: s, connSession: cs}).run(handler, &msg)
+}
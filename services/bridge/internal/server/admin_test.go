@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/admin"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/datadir"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// startTestAdmin starts the admin socket of s and connects a client to it
+func startTestAdmin(t *testing.T, s *Server) *admin.Client {
+	t.Helper()
+	if err := s.startAdmin(); err != nil {
+		t.Fatalf("startAdmin: %v", err)
+	}
+	client, err := admin.Dial(admin.SocketPath(s.profileDir))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// adminRequest sends a request and returns its response
+func adminRequest(t *testing.T, client *admin.Client, msgType string, payload interface{}) *admin.Response {
+	t.Helper()
+	resp, err := client.Request(msgType, payload)
+	if err != nil {
+		t.Fatalf("Request %s: %v", msgType, err)
+	}
+	return resp
+}
+
+// adminErrorCode returns the code of the error a request was answered with
+func adminErrorCode(t *testing.T, resp *admin.Response) protocol.ErrorCode {
+	t.Helper()
+	var adminErr *admin.Error
+	if err := resp.Err(); !errors.As(err, &adminErr) {
+		t.Fatalf("expected an error, got %+v", resp.Messages)
+	}
+	return adminErr.Code
+}
+
+func TestAdminSocketRoundTrip(t *testing.T) {
+	s := newQuietServer(t)
+	if err := s.storage.CreateSSHHost(storage.SSHHost{ID: "host-1", Name: "dev", Host: "dev.example", Port: 22, Username: "me", AuthType: "agent"}); err != nil {
+		t.Fatalf("CreateSSHHost: %v", err)
+	}
+	registerTestClaude(t, s, "proc-1")
+	client := startTestAdmin(t, s)
+
+	// Only the bridge's user may connect
+	path := admin.SocketPath(datadir.ProfileDir(s.dataDir, s.config.Profile))
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %v, want a socket with 0600", info.Mode())
+	}
+
+	var hosts protocol.HostConfigListResultPayload
+	resp := adminRequest(t, client, protocol.TypeHostConfigList, struct{}{})
+	if found, err := resp.Find(protocol.TypeHostConfigListResult, &hosts); !found || err != nil {
+		t.Fatalf("host_config_list answered with %+v", resp.Messages)
+	}
+	if len(hosts.Hosts) != 1 || hosts.Hosts[0].ID != "host-1" {
+		t.Errorf("hosts = %+v", hosts.Hosts)
+	}
+
+	var list protocol.ProcessListResultPayload
+	resp = adminRequest(t, client, protocol.TypeProcessList, protocol.ProcessListPayload{HostID: "host-1", ListLight: true})
+	if found, _ := resp.Find(protocol.TypeProcessListResult, &list); !found || len(list.Processes) != 1 || list.Processes[0].ID != "proc-1" {
+		t.Errorf("process_list answered with %+v", resp.Messages)
+	}
+
+	// Requests are validated, and only some types are served
+	resp = adminRequest(t, client, protocol.TypeProcessList, protocol.ProcessListPayload{})
+	if code := adminErrorCode(t, resp); code != protocol.ErrorValidation {
+		t.Errorf("process_list without a host: %s", code)
+	}
+	resp = adminRequest(t, client, protocol.TypeHostConnect, protocol.HostConnectPayload{HostID: "host-1"})
+	if code := adminErrorCode(t, resp); code != protocol.ErrorUnknownMessageType {
+		t.Errorf("host_connect: %s", code)
+	}
+
+	// A line that isn't a message gets an error line too, and the
+	// connection stays usable
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte("not json\n" + `{"type":"bridge_info","payload":{}}` + "\n"))
+	for _, want := range []string{protocol.TypeError, protocol.TypeBridgeInfoResult} {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("ReadBytes: %v", err)
+		}
+		var raw admin.Response
+		if err := json.Unmarshal(line, &raw); err != nil || len(raw.Messages) != 1 || raw.Messages[0].Type != want {
+			t.Errorf("response %s, want one %s", line, want)
+		}
+	}
+
+	// Stopping the server ends connections and removes the socket
+	s.stopAdmin()
+	if _, err := client.Request(protocol.TypeBridgeInfo, struct{}{}); err == nil {
+		t.Error("request served after stop")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket left after stop: %v", err)
+	}
+}
+
+func TestAdminSocketWrites(t *testing.T) {
+	s := newQuietServer(t)
+	registerTestClaude(t, s, "proc-1")
+	client := startTestAdmin(t, s)
+
+	// Read-only by default
+	resp := adminRequest(t, client, protocol.TypeProcessKill, protocol.ProcessKillPayload{ProcessID: "proc-1"})
+	if code := adminErrorCode(t, resp); code != protocol.ErrorForbidden {
+		t.Errorf("kill without writes: %s", code)
+	}
+	if s.processRegistry.Get("proc-1") == nil {
+		t.Fatal("process killed with admin writes disabled")
+	}
+
+	// With writes on, a confirmation token is good for the next request on
+	// the same connection
+	s.config.AdminWrites = true
+	s.config.ConfirmKills = true
+	var challenge protocol.ConfirmationChallengePayload
+	resp = adminRequest(t, client, protocol.TypeProcessKill, protocol.ProcessKillPayload{ProcessID: "proc-1"})
+	if found, _ := resp.Find(protocol.TypeConfirmationChallenge, &challenge); !found || challenge.Token == "" {
+		t.Fatalf("kill answered with %+v, want a challenge", resp.Messages)
+	}
+	resp = adminRequest(t, client, protocol.TypeProcessKill, protocol.ProcessKillPayload{ProcessID: "proc-1",
+		Confirmation: protocol.Confirmation{ConfirmToken: challenge.Token}})
+	if found, _ := resp.Find(protocol.TypeProcessKilled, nil); !found {
+		t.Fatalf("confirmed kill answered with %+v", resp.Messages)
+	}
+	if s.processRegistry.Get("proc-1") != nil {
+		t.Error("process not killed")
+	}
+}
+
+func TestAdminSocketStaleFile(t *testing.T) {
+	s := newQuietServer(t)
+	path := admin.SocketPath(s.profileDir)
+
+	// A socket nobody serves is left from a crash and replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	startTestAdmin(t, s)
+
+	// A served one belongs to another bridge
+	other := newQuietServer(t)
+	other.profileDir = s.profileDir
+	if err := other.startAdmin(); err == nil || !strings.Contains(err.Error(), "another bridge") {
+		t.Errorf("startAdmin over a live socket = %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(other.dataDir, admin.SocketName), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	other.profileDir = other.dataDir
+	if err := other.startAdmin(); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("startAdmin over a file = %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"log"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+)
+
+// ============================================================================
+// Claude Agent Status
+// ============================================================================
+//
+// The bridge tracks each Claude process's AgentAPI status ("running" or
+// "stable") from the status_change events of its stream, and from status
+// polls the bridge makes anyway. Every change is pushed as process_updated,
+// and chat_status answers from it, so clients have one source for the
+// badge instead of polling AgentAPI through the bridge.
+
+// trackAgentStatus records a Claude process's AgentAPI status, pushing it
+// to the host's subscribers if it changed; "" means it is unknown
+func (s *Server) trackAgentStatus(proc *process.Process, status, agentType string) {
+	if !proc.SetAgentStatus(status, agentType) {
+		return
+	}
+	log.Printf("[DEBUG] [CLAUDE] Process %s agent status: %q", proc.ID, status)
+	if err := s.notifyProcessUpdated(nil, proc); err != nil {
+		log.Printf("[ERROR] [CLAUDE] Failed to push agent status of process %s: %v", proc.ID, err)
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/agentapi"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+func TestAgentStatusTracked(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	watcher, watcherCS := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 1)
+	dispatch(t, s, watcherCS, protocol.TypeProcessesSubscribe, protocol.ProcessesSubscribePayload{HostID: "host-1"})
+	readPayload(t, watcher, protocol.TypeProcessListResult, &protocol.ProcessListResultPayload{})
+
+	var polls atomic.Int32
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"stable","agent_type":"claude"}`))
+	}))
+	t.Cleanup(agent.Close)
+	dial := dialerFunc(func(network, _ string) (net.Conn, error) {
+		return net.Dial(network, agent.Listener.Addr().String())
+	})
+	proc := s.processRegistry.Get("proc-0")
+	proc.UpdateType(process.TypeClaude)
+	proc.SetAgentClients(agentapi.NewClient(dial, 3284), nil)
+
+	// A status_change from the stream is tracked and pushed
+	data, _ := json.Marshal(agentapi.StatusChangeData{Status: "running", AgentType: "claude"})
+	s.handleAgentAPIEvent("host-1", "proc-0", agentapi.SSEEvent{Type: agentapi.EventStatusChange, Data: data})
+	var updated protocol.ProcessUpdatedPayload
+	readPayload(t, watcher, protocol.TypeProcessUpdated, &updated)
+	if updated.AgentStatus == nil || *updated.AgentStatus != "running" {
+		t.Fatalf("agentStatus = %v, want running", updated.AgentStatus)
+	}
+	if status, agentType := proc.AgentStatus(); status != "running" || agentType != "claude" {
+		t.Errorf("tracked status = %q (%q)", status, agentType)
+	}
+	if info := proc.ToInfo(); info.AgentStatus == nil || *info.AgentStatus != "running" {
+		t.Errorf("process info agentStatus = %v", info.AgentStatus)
+	}
+
+	// The same status again changes nothing
+	s.handleAgentAPIEvent("host-1", "proc-0", agentapi.SSEEvent{Type: agentapi.EventStatusChange, Data: data})
+	expectNothingQueued(t, watcher, watcherCS)
+
+	// chat_status answers from it without asking AgentAPI
+	var status protocol.ChatStatusResultPayload
+	dispatch(t, s, cs, protocol.TypeChatStatus, protocol.ChatStatusPayload{HostID: "host-1", ProcessID: "proc-0"})
+	readPayload(t, conn, protocol.TypeChatStatusResult, &status)
+	if status.Status != "running" || status.AgentType == nil || *status.AgentType != "claude" {
+		t.Errorf("status = %+v, want the tracked running", status)
+	}
+	if n := polls.Load(); n != 0 {
+		t.Errorf("AgentAPI was polled %d times", n)
+	}
+
+	// Forcing it polls, and the poll's answer is tracked and pushed
+	dispatch(t, s, cs, protocol.TypeChatStatus, protocol.ChatStatusPayload{HostID: "host-1", ProcessID: "proc-0", Force: true})
+	readPayload(t, conn, protocol.TypeChatStatusResult, &status)
+	if status.Status != "stable" || polls.Load() != 1 {
+		t.Errorf("forced status = %q after %d polls, want stable after 1", status.Status, polls.Load())
+	}
+	updated = protocol.ProcessUpdatedPayload{}
+	readPayload(t, watcher, protocol.TypeProcessUpdated, &updated)
+	if updated.AgentStatus == nil || *updated.AgentStatus != "stable" {
+		t.Errorf("agentStatus = %v after the poll, want stable", updated.AgentStatus)
+	}
+}
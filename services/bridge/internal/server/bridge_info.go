@@ -0,0 +1,72 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// ============================================================================
+// Bridge Info (diagnostics)
+// ============================================================================
+
+// listenAddresses returns every address the bridge accepts clients on
+func (s *Server) listenAddresses() []string {
+	return []string{s.addr}
+}
+
+// bridgeInfo collects version and runtime counters for the diagnostics view
+func (s *Server) bridgeInfo() protocol.BridgeInfoResultPayload {
+	info := protocol.BridgeInfoResultPayload{
+		Version:            s.config.Build.Version,
+		Commit:             s.config.Build.Commit,
+		BuildDate:          s.config.Build.Date,
+		ProtocolVersion:    protocol.ProtocolVersion,
+		StartedAt:          s.startedAt.Format(time.RFC3339),
+		UptimeSeconds:      int64(time.Since(s.startedAt).Seconds()),
+		Profile:            s.config.Profile,
+		DataDir:            s.profileDir,
+		ListenAddresses:    s.listenAddresses(),
+		ConnectedHostCount: len(s.sshManager.GetAllConnections()),
+		ProcessCount:       s.processRegistry.Count(),
+		SessionCount:       s.sessionManager.GetSessionCount(),
+		ConnectedSessions:  len(s.sessionManager.GetConnectedSessions()),
+		PortRange:          protocol.PortRange{Min: s.config.PortRange.Min, Max: s.config.PortRange.Max},
+		PtyOutput: protocol.PtyOutputSettings{
+			ReadBufferSize:    s.config.PtyOutput.ReadBufferSize,
+			CoalesceWindowMs:  int(s.config.PtyOutput.CoalesceWindow.Milliseconds()),
+			MaxCoalescedBytes: s.config.PtyOutput.MaxCoalescedBytes,
+		},
+	}
+
+	hosts, err := s.storage.ListSSHHosts()
+	if err != nil {
+		log.Printf("[WARN] [BRIDGE] Failed to count hosts: %v", err)
+	}
+	info.HostCount = len(hosts)
+
+	for _, hostID := range s.sshManager.GetAllConnections() {
+		if conn := s.sshManager.GetConnection(hostID); conn != nil {
+			usage := conn.ChannelUsage()
+			info.SSHChannels += usage.Open + usage.TunnelOpen
+		}
+	}
+
+	if s.updates != nil {
+		info.Update = toUpdateStatusPayload(s.updates.Status())
+	}
+
+	return info
+}
+
+// handleBridgeInfo returns the bridge version, uptime and runtime counters
+func (s *Server) handleBridgeInfo(connSession *ConnectedSession, msg *protocol.Message) error {
+	log.Printf("[DEBUG] [BRIDGE] Bridge info requested by session %s", connSession.ID)
+
+	response, err := protocol.NewMessage(protocol.TypeBridgeInfoResult, s.bridgeInfo())
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
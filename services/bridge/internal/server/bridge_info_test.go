@@ -0,0 +1,101 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+)
+
+func TestBridgeInfo(t *testing.T) {
+	config := DefaultConfig()
+	config.Build = BuildInfo{Version: "1.4.0", Commit: "abc1234", Date: "2024-05-01T00:00:00Z"}
+	config.PtyOutput = pty.OutputTuning{ReadBufferSize: 8192, CoalesceWindow: 20 * time.Millisecond, MaxCoalescedBytes: 32 << 10}
+	s := newTestServer(t, config)
+	conn, cs := connectTestClient(t, s)
+
+	dispatch(t, s, cs, protocol.TypeBridgeInfo, protocol.BridgeInfoPayload{})
+
+	var info protocol.BridgeInfoResultPayload
+	readPayload(t, conn, protocol.TypeBridgeInfoResult, &info)
+	if info.Version != "1.4.0" || info.Commit != "abc1234" || info.BuildDate != "2024-05-01T00:00:00Z" {
+		t.Errorf("unexpected build info: %+v", info)
+	}
+	if info.ProtocolVersion != protocol.ProtocolVersion {
+		t.Errorf("protocolVersion = %d, want %d", info.ProtocolVersion, protocol.ProtocolVersion)
+	}
+	if info.DataDir != s.dataDir || len(info.ListenAddresses) != 1 || info.ListenAddresses[0] != s.addr {
+		t.Errorf("unexpected paths: dataDir=%q listen=%v", info.DataDir, info.ListenAddresses)
+	}
+	if info.ConnectedSessions != 1 || info.SessionCount != 1 {
+		t.Errorf("sessions = %d connected / %d total, want 1/1", info.ConnectedSessions, info.SessionCount)
+	}
+	if info.HostCount != 0 || info.ProcessCount != 0 {
+		t.Errorf("unexpected counts: %+v", info)
+	}
+	want := protocol.PtyOutputSettings{ReadBufferSize: 8192, CoalesceWindowMs: 20, MaxCoalescedBytes: 32 << 10}
+	if info.PtyOutput != want {
+		t.Errorf("ptyOutput = %+v, want %+v", info.PtyOutput, want)
+	}
+}
+
+func TestProcessCreatePtyOutputOverride(t *testing.T) {
+	t.Setenv("FAKE_TMUX_HOLD", "10")
+	config := DefaultConfig()
+	config.PtyOutput.CoalesceWindow = 10 * time.Millisecond
+	s := newTestServer(t, config)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+
+	create := func(override *protocol.PtyOutputOverride) pty.OutputTuning {
+		t.Helper()
+		dispatch(t, s, cs, protocol.TypeProcessCreate, protocol.ProcessCreatePayload{HostID: "host-1", PtyOutput: override})
+		var created protocol.ProcessCreatedPayload
+		readPayload(t, conn, protocol.TypeProcessCreated, &created)
+		proc := s.processRegistry.Get(created.Process.ID)
+		if proc == nil || proc.PTY == nil {
+			t.Fatalf("process %s not registered with a PTY", created.Process.ID)
+		}
+		return proc.PTY.OutputTuning()
+	}
+
+	if got := create(nil); got != config.PtyOutput {
+		t.Errorf("tuning without override = %+v, want the bridge's %+v", got, config.PtyOutput)
+	}
+
+	// A process streaming logs trades latency for fewer, larger messages
+	window, size := 200, 256<<10
+	want := config.PtyOutput
+	want.CoalesceWindow = 200 * time.Millisecond
+	want.MaxCoalescedBytes = size
+	if got := create(&protocol.PtyOutputOverride{CoalesceWindowMs: &window, MaxCoalescedBytes: &size}); got != want {
+		t.Errorf("tuning with override = %+v, want %+v", got, want)
+	}
+}
+
+func TestNewRejectsInvalidPtyOutput(t *testing.T) {
+	config := DefaultConfig()
+	config.PtyOutput.CoalesceWindow = time.Minute
+	if _, err := New("127.0.0.1:0", t.TempDir(), config); err == nil {
+		t.Error("New accepted a one-minute coalescing window")
+	}
+}
+
+func TestBuildInfoDefaultsWhenUnset(t *testing.T) {
+	s := newTestServer(t, Config{})
+	conn, _ := connectTestClient(t, s)
+
+	if s.config.Build.Version != "dev" || s.config.Build.Commit != "unknown" || s.config.Build.Date != "unknown" {
+		t.Errorf("unexpected defaults: %+v", s.config.Build)
+	}
+
+	// connectTestClient already consumed one auth result; re-auth to inspect it
+	auth, _ := protocol.NewMessage(protocol.TypeAuth, protocol.AuthPayload{ProtocolVersion: &protocolVersion})
+	conn.WriteJSON(auth)
+	var result protocol.AuthResultPayload
+	readPayload(t, conn, protocol.TypeAuthResult, &result)
+	if result.ServerVersion != "dev" || result.ProtocolVersion != protocol.ProtocolVersion {
+		t.Errorf("auth result version = %q/%d", result.ServerVersion, result.ProtocolVersion)
+	}
+}
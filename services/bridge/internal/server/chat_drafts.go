@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// handleChatDraftSet saves the half-typed chat message of a process, so a
+// client that reconnects can restore its compose box
+func (s *Server) handleChatDraftSet(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ChatDraftSetPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [CHAT] Draft set: processId=%s len=%d clear=%v", payload.ProcessID, len(payload.Text), payload.Clear)
+
+	if s.processRegistry.Get(payload.ProcessID) == nil {
+		return connSession.sendProcessNotFound(payload.ProcessID)
+	}
+	if len(payload.Text) > storage.MaxChatDraftSize {
+		return connSession.SendErrorDetails(protocol.ErrorInvalidArgs, protocol.InvalidArgsDetails{
+			Tokens: []string{},
+			Reason: fmt.Sprintf("draft is over the %d byte limit", storage.MaxChatDraftSize),
+		})
+	}
+	if s.storage == nil {
+		return connSession.SendErrorDetails(protocol.ErrorStorageError, protocol.ErrorDetails{"processId": payload.ProcessID, "reason": "chat drafts are not stored"})
+	}
+
+	text := payload.Text
+	if payload.Clear {
+		text = ""
+	}
+	if err := s.storage.SetChatDraft(payload.ProcessID, text); err != nil {
+		log.Printf("[ERROR] [CHAT] Failed to save draft of process %s: %v", payload.ProcessID, err)
+		return connSession.SendErrorDetails(protocol.ErrorStorageError, protocol.ErrorDetails{"processId": payload.ProcessID, "reason": err.Error()})
+	}
+
+	return s.sendChatDraft(connSession, payload.ProcessID)
+}
+
+// handleChatDraftGet returns the draft of a process. chat_history returns it
+// too; this is for clients that only need the draft.
+func (s *Server) handleChatDraftGet(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ChatDraftGetPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [CHAT] Draft get: processId=%s", payload.ProcessID)
+
+	return s.sendChatDraft(connSession, payload.ProcessID)
+}
+
+func (s *Server) sendChatDraft(connSession *ConnectedSession, processID string) error {
+	response, err := protocol.NewMessage(protocol.TypeChatDraftResult, protocol.ChatDraftResultPayload{
+		ProcessID: processID,
+		Draft:     s.chatDraft(processID),
+	})
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
+
+// chatDraft returns the stored draft of a process, or nil if it has none or
+// it can't be read
+func (s *Server) chatDraft(processID string) *protocol.ChatDraft {
+	if s.storage == nil {
+		return nil
+	}
+	draft, err := s.storage.GetChatDraft(processID)
+	if err != nil {
+		log.Printf("[WARN] [CHAT] Failed to load draft of process %s: %v", processID, err)
+		return nil
+	}
+	if draft == nil {
+		return nil
+	}
+	return &protocol.ChatDraft{
+		Text:      draft.Text,
+		UpdatedAt: draft.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// clearSentChatDraft deletes the draft of a process once the message it was
+// for has been sent
+func (s *Server) clearSentChatDraft(processID, sent string) {
+	if s.storage == nil {
+		return
+	}
+	cleared, err := s.storage.ClearSentChatDraft(processID, sent)
+	if err != nil {
+		log.Printf("[WARN] [CHAT] Failed to clear sent draft of process %s: %v", processID, err)
+		return
+	}
+	if cleared {
+		log.Printf("[DEBUG] [CHAT] Cleared sent draft of process %s", processID)
+	}
+}
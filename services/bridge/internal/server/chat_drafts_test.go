@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/agentapi"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// dialerFunc is an ssh.Dialer
+type dialerFunc func(network, addr string) (net.Conn, error)
+
+func (f dialerFunc) Dial(network, addr string) (net.Conn, error) {
+	return f(network, addr)
+}
+
+// registerTestClaude registers a Claude process whose AgentAPI accepts
+// every message and has none to list
+func registerTestClaude(t *testing.T, s *Server, processID string) {
+	t.Helper()
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true,"messages":[]}`))
+	}))
+	t.Cleanup(agent.Close)
+	dial := dialerFunc(func(network, _ string) (net.Conn, error) {
+		return net.Dial(network, agent.Listener.Addr().String())
+	})
+	s.processRegistry.Register(&process.Process{ID: processID, HostID: "host-1", Type: process.TypeClaude,
+		StartedAt: time.Now(), AgentClient: agentapi.NewClient(dial, 3284)})
+}
+
+func TestChatDrafts(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	registerTestClaude(t, s, "proc-1")
+
+	var result protocol.ChatDraftResultPayload
+	dispatch(t, s, cs, protocol.TypeChatDraftGet, protocol.ChatDraftGetPayload{ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypeChatDraftResult, &result)
+	if result.ProcessID != "proc-1" || result.Draft != nil {
+		t.Errorf("draft before one was set = %+v", result)
+	}
+
+	dispatch(t, s, cs, protocol.TypeChatDraftSet, protocol.ChatDraftSetPayload{ProcessID: "proc-1", Text: "refactor the"})
+	readPayload(t, conn, protocol.TypeChatDraftResult, &result)
+	if result.Draft == nil || result.Draft.Text != "refactor the" || result.Draft.UpdatedAt == "" {
+		t.Errorf("set result = %+v", result)
+	}
+
+	// Returned with the history, in one round trip
+	var messages protocol.ChatMessagesPayload
+	dispatch(t, s, cs, protocol.TypeChatHistory, protocol.ChatHistoryPayload{HostID: "host-1", ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypeChatMessages, &messages)
+	if messages.Draft == nil || messages.Draft.Text != "refactor the" {
+		t.Errorf("history draft = %+v", messages.Draft)
+	}
+
+	// Sending a message the draft leads deletes it
+	dispatch(t, s, cs, protocol.TypeChatSend, protocol.ChatSendPayload{HostID: "host-1", ProcessID: "proc-1", Content: "refactor the parser"})
+	readPayload(t, conn, protocol.TypeChatSendResult, nil)
+	dispatch(t, s, cs, protocol.TypeChatDraftGet, protocol.ChatDraftGetPayload{ProcessID: "proc-1"})
+	result = protocol.ChatDraftResultPayload{}
+	readPayload(t, conn, protocol.TypeChatDraftResult, &result)
+	if result.Draft != nil {
+		t.Errorf("draft = %+v after it was sent", result.Draft)
+	}
+
+	dispatch(t, s, cs, protocol.TypeChatDraftSet, protocol.ChatDraftSetPayload{ProcessID: "proc-1", Text: "later"})
+	readPayload(t, conn, protocol.TypeChatDraftResult, nil)
+	dispatch(t, s, cs, protocol.TypeChatDraftSet, protocol.ChatDraftSetPayload{ProcessID: "proc-1", Text: "ignored", Clear: true})
+	result = protocol.ChatDraftResultPayload{}
+	readPayload(t, conn, protocol.TypeChatDraftResult, &result)
+	if result.Draft != nil {
+		t.Errorf("draft = %+v after clearing it", result.Draft)
+	}
+}
+
+func TestChatDraftSetErrors(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	registerTestClaude(t, s, "proc-1")
+
+	var errPayload protocol.ErrorPayload
+	dispatch(t, s, cs, protocol.TypeChatDraftSet, protocol.ChatDraftSetPayload{ProcessID: "proc-1", Text: strings.Repeat("x", 32*1024+1)})
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorInvalidArgs {
+		t.Errorf("oversized draft error = %+v", errPayload)
+	}
+
+	dispatch(t, s, cs, protocol.TypeChatDraftSet, protocol.ChatDraftSetPayload{ProcessID: "nope", Text: "hi"})
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotFound {
+		t.Errorf("unknown process error = %+v", errPayload)
+	}
+}
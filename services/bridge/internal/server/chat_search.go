@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// ============================================================================
+// Chat Search Handler
+// ============================================================================
+
+func (s *Server) sendChatSearchResult(connSession *ConnectedSession, query string, results []protocol.ChatSearchProcessResult, err error) error {
+	payload := protocol.ChatSearchResultPayload{Query: query, Results: results}
+	if payload.Results == nil {
+		payload.Results = []protocol.ChatSearchProcessResult{}
+	}
+	if err != nil {
+		payload.Code = failureCode(err, protocol.ErrorStorageError)
+		payload.Error = strPtr(err.Error())
+	}
+	msg, _ := protocol.NewMessage(protocol.TypeChatSearchResult, payload)
+	return connSession.Send(msg)
+}
+
+// handleChatSearch searches the stored chat history of every process,
+// including ones that are no longer running
+func (s *Server) handleChatSearch(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ChatSearchPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [CHAT] Search: query=%q hostId=%s role=%s limit=%d", payload.Query, payload.HostID, payload.Role, payload.Limit)
+
+	if s.storage == nil {
+		return s.sendChatSearchResult(connSession, payload.Query, nil, newFailure(protocol.ErrorStorageError, "chat history is not stored"))
+	}
+	if payload.Role != "" && payload.Role != "user" && payload.Role != "assistant" {
+		return s.sendChatSearchResult(connSession, payload.Query, nil, newFailure(protocol.ErrorInvalidArgs, `role must be "user" or "assistant"`))
+	}
+
+	groups, err := s.storage.SearchChat(storage.ChatSearchQuery{
+		Query:  payload.Query,
+		HostID: payload.HostID,
+		Role:   payload.Role,
+		Limit:  payload.Limit,
+	})
+	if err != nil {
+		log.Printf("[WARN] [CHAT] Search failed: %v", err)
+		return s.sendChatSearchResult(connSession, payload.Query, nil, err)
+	}
+
+	results := make([]protocol.ChatSearchProcessResult, len(groups))
+	for i, g := range groups {
+		result := protocol.ChatSearchProcessResult{
+			ProcessID:  g.ProcessID,
+			HostID:     g.HostID,
+			MatchCount: g.MatchCount,
+			Matches:    make([]protocol.ChatSearchMatch, len(g.Matches)),
+		}
+		// A running process's name may be newer than the stored one
+		if proc := s.processRegistry.Get(g.ProcessID); proc != nil {
+			result.ProcessName = proc.ToInfo().Name
+		} else if g.ProcessName != "" {
+			result.ProcessName = strPtr(g.ProcessName)
+		}
+		for j, m := range g.Matches {
+			highlights := make([]protocol.TextRange, len(m.Highlights))
+			for k, h := range m.Highlights {
+				highlights[k] = protocol.TextRange{Start: h.Start, Length: h.Length}
+			}
+			result.Matches[j] = protocol.ChatSearchMatch{
+				MessageID:  m.MessageID,
+				Role:       m.Role,
+				Time:       m.MessageTime,
+				Snippet:    m.Snippet,
+				Highlights: highlights,
+			}
+		}
+		results[i] = result
+	}
+
+	log.Printf("[DEBUG] [CHAT] Search %q matched %d processes", payload.Query, len(results))
+	return s.sendChatSearchResult(connSession, payload.Query, results, nil)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+func TestChatSearchHandler(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+
+	s.storage.SetChatMessages("proc-live", "host-1", []storage.ChatMessage{
+		{MessageID: 1, Role: "user", Message: "deploy the staging build", MessageTime: "2026-01-01T10:00:00Z"},
+		{MessageID: 2, Role: "assistant", Message: "Staging deploy finished", MessageTime: "2026-01-01T10:05:00Z"},
+	})
+	s.storage.SetChatMessages("proc-gone", "host-2", []storage.ChatMessage{
+		{MessageID: 1, Role: "user", Message: "roll back staging", MessageTime: "2026-01-02T10:00:00Z"},
+	})
+	if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{
+		ProcessID: "proc-gone", HostID: "host-2", ProcessType: "claude", TmuxName: "rc-proc-gone", Name: "rollback",
+	}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+
+	// The live name wins over the stored one
+	proc := &process.Process{ID: "proc-live", HostID: "host-1", Type: process.TypeClaude}
+	proc.SetName("release")
+	s.processRegistry.Register(proc)
+
+	dispatch(t, s, cs, protocol.TypeChatSearch, protocol.ChatSearchPayload{Query: "staging"})
+	var result protocol.ChatSearchResultPayload
+	readPayload(t, conn, protocol.TypeChatSearchResult, &result)
+	if result.Error != nil || result.Query != "staging" || len(result.Results) != 2 {
+		t.Fatalf("result = %+v", result)
+	}
+	names := make(map[string]string)
+	for _, r := range result.Results {
+		if r.ProcessName != nil {
+			names[r.ProcessID] = *r.ProcessName
+		}
+		if len(r.Matches) != r.MatchCount {
+			t.Errorf("%s: %d matches, count %d", r.ProcessID, len(r.Matches), r.MatchCount)
+		}
+		for _, m := range r.Matches {
+			if len(m.Highlights) != 1 || m.Highlights[0].Length != len("staging") {
+				t.Errorf("%s/%d highlights = %+v in %q", r.ProcessID, m.MessageID, m.Highlights, m.Snippet)
+			}
+		}
+	}
+	if names["proc-live"] != "release" || names["proc-gone"] != "rollback" {
+		t.Errorf("names = %v", names)
+	}
+
+	dispatch(t, s, cs, protocol.TypeChatSearch, protocol.ChatSearchPayload{Query: "staging", HostID: "host-1", Role: "assistant"})
+	readPayload(t, conn, protocol.TypeChatSearchResult, &result)
+	if len(result.Results) != 1 || result.Results[0].ProcessID != "proc-live" || result.Results[0].Matches[0].MessageID != 2 {
+		t.Fatalf("filtered = %+v", result)
+	}
+
+	// Bad requests get an error result
+	for _, payload := range []protocol.ChatSearchPayload{{Query: "  "}, {Query: "staging", Role: "system"}} {
+		dispatch(t, s, cs, protocol.TypeChatSearch, payload)
+		var rejected protocol.ChatSearchResultPayload
+		readPayload(t, conn, protocol.TypeChatSearchResult, &rejected)
+		if rejected.Error == nil || rejected.Results == nil || len(rejected.Results) != 0 {
+			t.Errorf("%+v: result = %+v", payload, rejected)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/agentapi"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// registerStubClaude registers a Claude process whose AgentAPI answers
+// every request with status and body, or can't be reached when status is 0
+func registerStubClaude(t *testing.T, s *Server, processID string, status int, body string) {
+	t.Helper()
+	dial := dialerFunc(func(string, string) (net.Conn, error) {
+		return nil, errors.New("tunnel closed")
+	})
+	if status != 0 {
+		agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}))
+		t.Cleanup(agent.Close)
+		dial = func(network, _ string) (net.Conn, error) {
+			return net.Dial(network, agent.Listener.Addr().String())
+		}
+	}
+	s.processRegistry.Register(&process.Process{ID: processID, HostID: "host-1", Type: process.TypeClaude,
+		StartedAt: time.Now(), AgentClient: agentapi.NewClient(dial, 3284)})
+}
+
+func TestChatSendAgentErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		code   protocol.ErrorCode
+	}{
+		{"conflict", 409, `{"title":"Conflict","status":409,"detail":"agent is running"}`, protocol.ErrorAgentBusy},
+		{"busy 500", 500, `{"title":"Internal Server Error","status":500,"detail":"unexpected error occurred",` +
+			`"errors":[{"message":"failed to send message: message can only be sent when the agent is waiting for user input"}]}`, protocol.ErrorAgentBusy},
+		{"server error", 500, `{"title":"Internal Server Error","status":500,"detail":"failed to write to terminal"}`, protocol.ErrorAgentAPIDown},
+		{"unreachable", 0, "", protocol.ErrorAgentAPIDown},
+		{"validation", 422, `{"title":"Unprocessable Entity","status":422,"detail":"validation failed"}`, protocol.ErrorSendFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newQuietServer(t)
+			conn, cs := connectTestClient(t, s)
+			registerStubClaude(t, s, "proc-1", tt.status, tt.body)
+
+			for _, msgType := range []string{protocol.TypeChatSend, protocol.TypeChatRaw} {
+				dispatch(t, s, cs, msgType, protocol.ChatSendPayload{HostID: "host-1", ProcessID: "proc-1", Content: "hi"})
+				var errPayload protocol.ErrorPayload
+				readPayload(t, conn, protocol.TypeError, &errPayload)
+				details, _ := errPayload.Details.(map[string]interface{})
+				if errPayload.Code != tt.code || details["processId"] != "proc-1" {
+					t.Errorf("%s error = %+v, want %s", msgType, errPayload, tt.code)
+				}
+				if status, _ := details["status"].(float64); int(status) != tt.status {
+					t.Errorf("%s error status = %v, want %d", msgType, details["status"], tt.status)
+				}
+				if msgType == protocol.TypeChatSend {
+					var result protocol.ChatSendResultPayload
+					readPayload(t, conn, protocol.TypeChatSendResult, &result)
+					if result.Success || result.Code != tt.code || result.ClientMessageID == "" {
+						t.Errorf("chat_send result = %+v, want %s", result, tt.code)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestChatSendLocalEcho(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	registerStubClaude(t, s, "proc-1", 200, `{"ok":true}`)
+	cs.SubscribeChat("host-1", "proc-1")
+
+	dispatch(t, s, cs, protocol.TypeChatSend, protocol.ChatSendPayload{HostID: "host-1", ProcessID: "proc-1",
+		Content: "fix the build", ClientMessageID: strPtr("client-1")})
+	var result protocol.ChatSendResultPayload
+	readPayload(t, conn, protocol.TypeChatSendResult, &result)
+	if !result.Success || result.ClientMessageID != "client-1" || result.Message == nil || !result.Message.Pending {
+		t.Fatalf("chat_send result = %+v", result)
+	}
+
+	// The pending message is in the history before AgentAPI echoes it
+	dispatch(t, s, cs, protocol.TypeChatHistory, protocol.ChatHistoryPayload{HostID: "host-1", ProcessID: "proc-1"})
+	var history protocol.ChatMessagesPayload
+	readPayload(t, conn, protocol.TypeChatMessages, &history)
+	if len(history.Messages) != 1 || !reflect.DeepEqual(history.Messages[0], *result.Message) {
+		t.Fatalf("history = %+v, want the pending message", history.Messages)
+	}
+
+	// The echo, delivered twice, carries the client's ID both times
+	for range 2 {
+		s.handleAgentAPIEvent("host-1", "proc-1", agentapi.SSEEvent{Type: agentapi.EventMessageUpdate,
+			Data: []byte(`{"id":3,"role":"user","message":"fix the build","time":"2026-01-01T10:00:00Z"}`)})
+		var event protocol.ChatEventPayload
+		readPayload(t, conn, protocol.TypeChatEvent, &event)
+		if event.ClientMessageID != "client-1" {
+			t.Errorf("chat_event = %+v, want client-1", event)
+		}
+	}
+
+	dispatch(t, s, cs, protocol.TypeChatHistory, protocol.ChatHistoryPayload{HostID: "host-1", ProcessID: "proc-1"})
+	var confirmed protocol.ChatMessagesPayload
+	readPayload(t, conn, protocol.TypeChatMessages, &confirmed)
+	if len(confirmed.Messages) != 1 || confirmed.Messages[0].ID != 3 || confirmed.Messages[0].Pending ||
+		confirmed.Messages[0].ClientMessageID != "client-1" {
+		t.Errorf("history = %+v, want the echo in place of the pending message", confirmed.Messages)
+	}
+}
+
+func TestChatSendFailureDropsPending(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	registerStubClaude(t, s, "proc-1", 0, "")
+
+	dispatch(t, s, cs, protocol.TypeChatSend, protocol.ChatSendPayload{HostID: "host-1", ProcessID: "proc-1", Content: "hello"})
+	readPayload(t, conn, protocol.TypeError, nil)
+	var result protocol.ChatSendResultPayload
+	readPayload(t, conn, protocol.TypeChatSendResult, &result)
+	if result.Success || result.Code != protocol.ErrorAgentAPIDown {
+		t.Fatalf("chat_send result = %+v", result)
+	}
+
+	if history, _ := s.storage.GetChatHistory("proc-1"); len(history) != 0 {
+		t.Errorf("history = %+v, want the failed message dropped", history)
+	}
+}
+
+func TestChatRawInputModes(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	var mu sync.Mutex
+	var sent []string
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req agentapi.MessageRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		sent = append(sent, req.Content)
+		mu.Unlock()
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	t.Cleanup(agent.Close)
+	dial := dialerFunc(func(network, _ string) (net.Conn, error) {
+		return net.Dial(network, agent.Listener.Addr().String())
+	})
+	s.processRegistry.Register(&process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeClaude,
+		StartedAt: time.Now(), AgentClient: agentapi.NewClient(dial, 3284)})
+
+	// A lone ESC followed by garbage is dropped whole; keys pass by default
+	const content = "\x1b[A\x1bzq\r"
+	for _, mode := range []protocol.InputMode{"", protocol.InputModeText, protocol.InputModeRaw} {
+		dispatch(t, s, cs, protocol.TypeChatRaw, protocol.ChatRawPayload{HostID: "host-1", ProcessID: "proc-1", Content: content, Mode: mode})
+	}
+	// Input left empty isn't sent
+	dispatch(t, s, cs, protocol.TypeChatRaw, protocol.ChatRawPayload{HostID: "host-1", ProcessID: "proc-1", Content: "\x1b[?1049h"})
+	expectNothingQueued(t, conn, cs)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"\x1b[Aq\r", "q", content}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %q, want %q", sent, want)
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/agentapi"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/session"
+)
+
+// messageUpdate builds a message_update SSE event
+func messageUpdate(t *testing.T, id int, text string) agentapi.SSEEvent {
+	t.Helper()
+	data, err := json.Marshal(agentapi.MessageUpdateData{ID: id, Role: "assistant", Message: text, Time: "2024-01-01T00:00:00Z"})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return agentapi.SSEEvent{Type: agentapi.EventMessageUpdate, Data: data}
+}
+
+// expectNothingQueued asserts that no message is pending for a client by
+// sending it a marker and checking the marker is the next thing it reads
+func expectNothingQueued(t *testing.T, conn *websocket.Conn, cs *ConnectedSession) {
+	t.Helper()
+	if err := cs.SendErrorDetails(protocol.ErrorHandlerError, protocol.ErrorDetails{"reason": "nothing queued"}); err != nil {
+		t.Fatalf("SendErrorDetails: %v", err)
+	}
+	var marker protocol.ErrorPayload
+	readPayload(t, conn, protocol.TypeError, &marker)
+	if errorReason(marker) != "nothing queued" {
+		t.Fatalf("expected marker, got %+v", marker)
+	}
+}
+
+func TestChatEventsOnlyReachSubscribedSessions(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	subscriber, subCS := connectTestClient(t, s)
+	other, otherCS := connectTestClient(t, s)
+
+	s.processRegistry.Register(&process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeShell})
+
+	dispatch(t, s, subCS, protocol.TypeChatSubscribe, protocol.ChatSubscribePayload{HostID: "host-1", ProcessID: "proc-1"})
+	var subscribed protocol.ChatSubscribeResultPayload
+	readPayload(t, subscriber, protocol.TypeChatSubscribeResult, &subscribed)
+	if !subscribed.Success || subscribed.Status == nil || *subscribed.Status != "disconnected" {
+		t.Fatalf("subscribe result = %+v", subscribed)
+	}
+	if subscribed.LatestMessageID != nil {
+		t.Errorf("expected no cached message yet, got %d", *subscribed.LatestMessageID)
+	}
+
+	s.handleAgentAPIEvent("host-1", "proc-1", messageUpdate(t, 7, "hello"))
+
+	var event protocol.ChatEventPayload
+	readPayload(t, subscriber, protocol.TypeChatEvent, &event)
+	if event.ProcessID != "proc-1" || event.Event != string(agentapi.EventMessageUpdate) {
+		t.Errorf("chat_event = %+v", event)
+	}
+	expectNothingQueued(t, other, otherCS)
+
+	// The event was cached even though only one session received it, and a
+	// late subscriber learns where the cache ends
+	dispatch(t, s, otherCS, protocol.TypeChatSubscribe, protocol.ChatSubscribePayload{HostID: "host-1", ProcessID: "proc-1"})
+	var late protocol.ChatSubscribeResultPayload
+	readPayload(t, other, protocol.TypeChatSubscribeResult, &late)
+	if late.LatestMessageID == nil || *late.LatestMessageID != 7 {
+		t.Errorf("latestMessageId = %v, want 7", late.LatestMessageID)
+	}
+}
+
+func TestChatHostWideSubscription(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+
+	dispatch(t, s, cs, protocol.TypeChatSubscribe, protocol.ChatSubscribePayload{HostID: "host-1", ProcessID: session.AllProcesses})
+	var subscribed protocol.ChatSubscribeResultPayload
+	readPayload(t, conn, protocol.TypeChatSubscribeResult, &subscribed)
+	if !subscribed.Success || subscribed.Status != nil {
+		t.Fatalf("host-wide subscribe result = %+v", subscribed)
+	}
+
+	// Processes started after subscribing are covered; other hosts are not
+	s.handleAgentAPIEvent("host-1", "proc-new", messageUpdate(t, 1, "hi"))
+	var event protocol.ChatEventPayload
+	readPayload(t, conn, protocol.TypeChatEvent, &event)
+	if event.ProcessID != "proc-new" {
+		t.Errorf("chat_event processId = %s, want proc-new", event.ProcessID)
+	}
+	s.handleAgentAPIEvent("host-2", "proc-other", messageUpdate(t, 1, "hi"))
+	expectNothingQueued(t, conn, cs)
+
+	dispatch(t, s, cs, protocol.TypeChatUnsubscribe, protocol.ChatUnsubscribePayload{HostID: "host-1", ProcessID: session.AllProcesses})
+	if cs.IsSubscribedToChat("host-1", "proc-new") {
+		t.Errorf("expected host-wide subscription to be removed")
+	}
+}
+
+func TestChatSubscribeUnknownProcess(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+
+	dispatch(t, s, cs, protocol.TypeChatSubscribe, protocol.ChatSubscribePayload{HostID: "host-1", ProcessID: "missing"})
+	var result protocol.ChatSubscribeResultPayload
+	readPayload(t, conn, protocol.TypeChatSubscribeResult, &result)
+	if result.Success || result.Error == nil {
+		t.Fatalf("expected failure, got %+v", result)
+	}
+	if cs.IsSubscribedToChat("host-1", "missing") {
+		t.Errorf("failed subscribe should not be recorded")
+	}
+}
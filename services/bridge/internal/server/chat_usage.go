@@ -0,0 +1,221 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/agentapi"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/usage"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// handleChatUsage reports the token usage of a Claude process. Having no
+// usage to report is not an error: the result says usageAvailable=false.
+func (s *Server) handleChatUsage(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ChatUsagePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [CHAT] Usage: processId=%s", payload.ProcessID)
+
+	response, err := protocol.NewMessage(protocol.TypeChatUsageResult, s.processUsage(payload.ProcessID))
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
+
+// processUsage reads the usage of a live Claude process, caching it, and
+// falls back to the cached copy for processes that are gone or whose usage
+// can't be read right now
+func (s *Server) processUsage(processID string) protocol.ChatUsageResultPayload {
+	result := protocol.ChatUsageResultPayload{ProcessID: processID}
+
+	if proc := s.processRegistry.Get(processID); proc != nil {
+		if u, source := s.fetchUsage(proc); u != nil {
+			s.cacheUsage(processID, u)
+			result.UsageAvailable = true
+			result.Source = source
+			result.Usage = u
+			return result
+		}
+	}
+
+	if s.storage == nil {
+		return result
+	}
+	data, updatedAt, err := s.storage.GetProcessUsage(processID)
+	if err != nil {
+		log.Printf("[WARN] [CHAT] Failed to load cached usage for process %s: %v", processID, err)
+		return result
+	}
+	if data == nil {
+		return result
+	}
+	var cached protocol.ChatUsage
+	if err := json.Unmarshal(data, &cached); err != nil {
+		log.Printf("[WARN] [CHAT] Ignoring unreadable cached usage for process %s: %v", processID, err)
+		return result
+	}
+	at := updatedAt.UTC().Format(time.RFC3339)
+	result.UsageAvailable = true
+	result.Source = protocol.ChatUsageSourceCache
+	result.UpdatedAt = &at
+	result.Usage = &cached
+	return result
+}
+
+// fetchUsage reads the usage of a Claude process from AgentAPI, or from the
+// conversation transcript on the host when the AgentAPI running has no
+// usage endpoint or can't be reached. It returns nil when there is no usage
+// yet or neither source can be read.
+func (s *Server) fetchUsage(proc *process.Process) (*protocol.ChatUsage, string) {
+	if proc.GetType() != process.TypeClaude {
+		return nil, ""
+	}
+
+	if client := proc.GetAgentClient(); client != nil {
+		resp, err := client.GetUsage()
+		switch {
+		case err == nil:
+			models := make([]usage.ModelUsage, len(resp.Models))
+			for i, m := range resp.Models {
+				models[i] = usage.ModelUsage{
+					Model: m.Model,
+					Tokens: usage.Tokens{
+						Input:         m.InputTokens,
+						Output:        m.OutputTokens,
+						CacheCreation: m.CacheCreationInputTokens,
+						CacheRead:     m.CacheReadInputTokens,
+					},
+					Messages: m.Messages,
+				}
+			}
+			return chatUsage(usage.FromModels(models)), protocol.ChatUsageSourceAgentAPI
+		case !errors.Is(err, agentapi.ErrUsageUnsupported):
+			log.Printf("[WARN] [CHAT] GetUsage failed for process %s, reading transcript: %v", proc.ID, err)
+		}
+	}
+
+	conn := s.sshManager.GetConnection(proc.HostID)
+	if conn == nil {
+		return nil, ""
+	}
+	cwd := proc.GetClaudeCWD()
+	if cwd == "" {
+		cwd = proc.GetCWD()
+	}
+	u, err := s.transcripts.read(conn.Client, proc.ID, proc.GetClaudeSessionID(), cwd)
+	if err != nil {
+		log.Printf("[WARN] [CHAT] Failed to read transcript usage for process %s: %v", proc.ID, err)
+		return nil, ""
+	}
+	return chatUsage(u), protocol.ChatUsageSourceTranscript
+}
+
+// transcripts keeps what was read of each Claude process's transcript, so
+// a usage poll reads only what was appended since the last
+type transcripts struct {
+	mu      sync.Mutex
+	entries map[string]*transcriptEntry // By process ID
+}
+
+type transcriptEntry struct {
+	mu sync.Mutex // Held while the transcript is read
+	t  *usage.Transcript
+}
+
+// read reads the usage in a process's transcript: the one named by
+// sessionID, or the latest in cwd when Claude's session isn't known. A
+// process whose session or directory changed is read afresh.
+func (c *transcripts) read(client *cryptossh.Client, processID, sessionID, cwd string) (*usage.Usage, error) {
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]*transcriptEntry)
+	}
+	entry := c.entries[processID]
+	if entry == nil {
+		entry = &transcriptEntry{}
+		c.entries[processID] = entry
+	}
+	c.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.t == nil || entry.t.SessionID != sessionID || entry.t.CWD != cwd {
+		entry.t = &usage.Transcript{SessionID: sessionID, CWD: cwd}
+	}
+	return entry.t.Read(client)
+}
+
+// forget drops what was read of a process's transcript
+func (c *transcripts) forget(processID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, processID)
+}
+
+// chatUsage converts usage to its protocol form, or nil if there is none
+func chatUsage(u *usage.Usage) *protocol.ChatUsage {
+	if u.Messages == 0 {
+		return nil
+	}
+	result := &protocol.ChatUsage{
+		InputTokens:              u.Input,
+		OutputTokens:             u.Output,
+		CacheCreationInputTokens: u.CacheCreation,
+		CacheReadInputTokens:     u.CacheRead,
+		MessageCount:             u.Messages,
+		Models:                   make([]protocol.ChatModelUsage, len(u.Models)),
+	}
+	if cost, ok := u.Cost(); ok {
+		result.EstimatedCostUSD = &cost
+	}
+	for i, m := range u.Models {
+		result.Models[i] = protocol.ChatModelUsage{
+			Model:                    m.Model,
+			InputTokens:              m.Input,
+			OutputTokens:             m.Output,
+			CacheCreationInputTokens: m.CacheCreation,
+			CacheReadInputTokens:     m.CacheRead,
+			MessageCount:             m.Messages,
+		}
+		if cost, ok := m.Cost(); ok {
+			result.Models[i].EstimatedCostUSD = &cost
+		}
+	}
+	return result
+}
+
+// cacheUsage stores usage so it outlives the process
+func (s *Server) cacheUsage(processID string, u *protocol.ChatUsage) {
+	if s.storage == nil {
+		return
+	}
+	data, err := json.Marshal(u)
+	if err != nil {
+		log.Printf("[WARN] [CHAT] Failed to marshal usage for process %s: %v", processID, err)
+		return
+	}
+	if err := s.storage.UpdateProcessUsage(processID, data); err != nil {
+		log.Printf("[WARN] [CHAT] Failed to cache usage for process %s: %v", processID, err)
+	}
+}
+
+// refreshUsage caches the usage of a process once Claude has finished a
+// turn, so the cache stays close to current without clients asking
+func (s *Server) refreshUsage(processID string) {
+	proc := s.processRegistry.Get(processID)
+	if proc == nil {
+		return
+	}
+	if u, _ := s.fetchUsage(proc); u != nil {
+		s.cacheUsage(processID, u)
+	}
+}
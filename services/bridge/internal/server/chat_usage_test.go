@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/agentapi"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/usage"
+)
+
+// writeTranscript puts a transcript where Claude keeps those of cwd
+func writeTranscript(t *testing.T, configDir, cwd, name, content string) {
+	t.Helper()
+	dir := filepath.Join(configDir, "projects", usage.ProjectDir(cwd))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTestClaude turns a registered shell into Claude started in cwd
+func startTestClaude(t *testing.T, s *Server, processID, cwd string) {
+	t.Helper()
+	proc := s.processRegistry.Get(processID)
+	proc.UpdateType(process.TypeClaude)
+	proc.SetClaudeCWD(cwd)
+	if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{
+		ProcessID: processID, HostID: proc.HostID, ProcessType: "claude", TmuxName: proc.PTY.TmuxName,
+		ClaudeCWD: cwd, StartedAt: time.Now(),
+	}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+}
+
+// requestUsage sends chat_usage and reads the result
+func requestUsage(t *testing.T, s *Server, conn *websocket.Conn, cs *ConnectedSession, processID string) protocol.ChatUsageResultPayload {
+	t.Helper()
+	dispatch(t, s, cs, protocol.TypeChatUsage, protocol.ChatUsagePayload{ProcessID: processID})
+	var result protocol.ChatUsageResultPayload
+	readPayload(t, conn, protocol.TypeChatUsageResult, &result)
+	return result
+}
+
+const usageLine = `{"type":"assistant","uuid":"u-%d","message":{"id":"msg_%d","model":"claude-sonnet-4-5-20250929","usage":{"input_tokens":100,"output_tokens":50,"cache_creation_input_tokens":0,"cache_read_input_tokens":0}}}` + "\n"
+
+func TestChatUsage(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("CLAUDE_CONFIG_DIR", configDir)
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 3)
+
+	// A shell has no usage, nor does Claude before its first response
+	if result := requestUsage(t, s, conn, cs, "proc-0"); result.UsageAvailable || result.Usage != nil {
+		t.Errorf("shell usage = %+v, want unavailable", result)
+	}
+	startTestClaude(t, s, "proc-1", "/work/app")
+	if result := requestUsage(t, s, conn, cs, "proc-1"); result.UsageAvailable {
+		t.Errorf("usage without a transcript = %+v, want unavailable", result)
+	}
+
+	// The older transcript is ignored
+	writeTranscript(t, configDir, "/work/app", "old.jsonl", fmt.Sprintf(usageLine, 1, 1)+fmt.Sprintf(usageLine, 2, 2))
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(configDir, "projects", usage.ProjectDir("/work/app"), "old.jsonl"), old, old)
+	writeTranscript(t, configDir, "/work/app", "current.jsonl", fmt.Sprintf(usageLine, 3, 3))
+
+	result := requestUsage(t, s, conn, cs, "proc-1")
+	if !result.UsageAvailable || result.Source != protocol.ChatUsageSourceTranscript || result.Usage == nil {
+		t.Fatalf("usage = %+v, want it from the transcript", result)
+	}
+	u := result.Usage
+	if u.MessageCount != 1 || u.InputTokens != 100 || u.OutputTokens != 50 || len(u.Models) != 1 || u.EstimatedCostUSD == nil {
+		t.Errorf("usage = %+v", u)
+	}
+
+	// Claude started with a known session reads its own transcript, though
+	// it isn't the latest in the directory, and sees what is appended to it
+	const sessionID = "0b6f3c9e-5d2a-4c1b-9f0e-3a7d8c2b1e4f"
+	startTestClaude(t, s, "proc-2", "/work/app")
+	s.processRegistry.Get("proc-2").SetClaudeSessionID(sessionID)
+	writeTranscript(t, configDir, "/work/app", sessionID+".jsonl", fmt.Sprintf(usageLine, 4, 4)+fmt.Sprintf(usageLine, 5, 5))
+	os.Chtimes(filepath.Join(configDir, "projects", usage.ProjectDir("/work/app"), sessionID+".jsonl"), old, old)
+	if result := requestUsage(t, s, conn, cs, "proc-2"); result.Usage == nil || result.Usage.MessageCount != 2 {
+		t.Errorf("usage of the session = %+v, want its 2 messages", result.Usage)
+	}
+	f, err := os.OpenFile(filepath.Join(configDir, "projects", usage.ProjectDir("/work/app"), sessionID+".jsonl"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(f, usageLine, 6, 6)
+	f.Close()
+	os.Chtimes(f.Name(), old, old)
+	if result := requestUsage(t, s, conn, cs, "proc-2"); result.Usage == nil || result.Usage.MessageCount != 3 || result.Usage.InputTokens != 300 {
+		t.Errorf("usage after an append = %+v, want 3 messages", result.Usage)
+	}
+	if result := requestUsage(t, s, conn, cs, "proc-1"); result.Usage == nil || result.Usage.MessageCount != 1 {
+		t.Errorf("usage of the process without a session = %+v, want the latest transcript's", result.Usage)
+	}
+
+	// Once the process is gone the cached copy is reported
+	s.processRegistry.Unregister("proc-1")
+	result = requestUsage(t, s, conn, cs, "proc-1")
+	if !result.UsageAvailable || result.Source != protocol.ChatUsageSourceCache || result.UpdatedAt == nil ||
+		result.Usage == nil || result.Usage.MessageCount != 1 {
+		t.Errorf("usage after exit = %+v, want the cached copy", result)
+	}
+}
+
+func TestUsageRefreshedWhenStable(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("CLAUDE_CONFIG_DIR", configDir)
+	s := newQuietServer(t)
+	shellTestHost(t, s)
+	registerShells(t, s, 1)
+	startTestClaude(t, s, "proc-0", "/work/app")
+	writeTranscript(t, configDir, "/work/app", "current.jsonl", fmt.Sprintf(usageLine, 1, 1)+fmt.Sprintf(usageLine, 2, 2))
+
+	// Going busy doesn't refresh, finishing the turn does
+	for _, status := range []string{"running", "stable"} {
+		s.handleAgentAPIEvent("host-1", "proc-0", agentapi.SSEEvent{
+			Type: agentapi.EventStatusChange,
+			Data: json.RawMessage(`{"status":"` + status + `","agent_type":"claude"}`),
+		})
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _, err := s.storage.GetProcessUsage("proc-0")
+		if err != nil {
+			t.Fatalf("GetProcessUsage: %v", err)
+		}
+		if data != nil {
+			var cached protocol.ChatUsage
+			if err := json.Unmarshal(data, &cached); err != nil || cached.MessageCount != 2 {
+				t.Errorf("cached usage = %s, %v", data, err)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("usage not cached after the status went stable")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
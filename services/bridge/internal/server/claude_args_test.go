@@ -0,0 +1,69 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+func TestClaudeStartRejectsInjectedArgs(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+
+	args := "--verbose; rm -rf ~"
+	dispatch(t, s, cs, protocol.TypeClaudeStart, protocol.ClaudeStartPayload{
+		ProcessID:  "proc-1",
+		ClaudeArgs: &args,
+	})
+
+	var errPayload struct {
+		Code    string                      `json:"code"`
+		Details protocol.InvalidArgsDetails `json:"details"`
+	}
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != "INVALID_ARGS" {
+		t.Errorf("code = %s, want INVALID_ARGS", errPayload.Code)
+	}
+	if !reflect.DeepEqual(errPayload.Details.Tokens, []string{"--verbose;"}) {
+		t.Errorf("tokens = %q", errPayload.Details.Tokens)
+	}
+}
+
+func TestClaudeSession(t *testing.T) {
+	const id = "0b6f3c9e-5d2a-4c1b-9f0e-3a7d8c2b1e4f"
+	for _, tt := range []struct {
+		args    []string
+		want    string // Session ID; "new" for one added
+		addsArg bool
+	}{
+		{nil, "new", true},
+		{[]string{"--model", "opus", "fix the bug"}, "new", true},
+		{[]string{"--session-id", id}, id, false},
+		{[]string{"--resume=" + id}, id, false},
+		{[]string{"-r", id, "--verbose"}, id, false},
+		{[]string{"--resume"}, "", false},
+		{[]string{"--continue"}, "", false},
+		{[]string{"--resume", id, "--fork-session"}, "", false},
+	} {
+		args, sessionID := claudeSession(tt.args)
+		switch {
+		case tt.want == "new" && (sessionID == "" || sessionID == id):
+			t.Errorf("%q: session %q, want a new one", tt.args, sessionID)
+		case tt.want != "new" && sessionID != tt.want:
+			t.Errorf("%q: session %q, want %q", tt.args, sessionID, tt.want)
+		}
+		if added := len(args) > len(tt.args); added != tt.addsArg {
+			t.Errorf("%q: args %q", tt.args, args)
+		}
+		if tt.addsArg && (args[0] != "--session-id" || args[1] != sessionID) {
+			t.Errorf("%q: args %q don't start with the session", tt.args, args)
+		}
+	}
+
+	// The command runs claude with it, quoted like any other argument
+	cmd, sessionID, failure := claudeCommand(nil)
+	if failure != nil || cmd != "claude --session-id "+sessionID {
+		t.Errorf("claudeCommand(nil) = %q, %q, %v", cmd, sessionID, failure)
+	}
+}
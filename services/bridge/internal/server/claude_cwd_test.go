@@ -0,0 +1,103 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// setPaneCWD makes the fake tmux report dir as a session's working directory
+func setPaneCWD(t *testing.T, tmuxName, dir string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(os.Getenv("FAKE_TMUX_CWD"), tmuxName), []byte(dir), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClaudeCWDSurvivesCd(t *testing.T) {
+	t.Setenv("FAKE_TMUX_CWD", t.TempDir())
+	s := newQuietServer(t)
+	watcher, watcherCS := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 1)
+	dispatch(t, s, watcherCS, protocol.TypeProcessesSubscribe, protocol.ProcessesSubscribePayload{HostID: "host-1"})
+	readPayload(t, watcher, protocol.TypeProcessListResult, &protocol.ProcessListResultPayload{})
+
+	setPaneCWD(t, "rc-proc-0", "/work/repo")
+	proc := s.processRegistry.Get("proc-0")
+	if got := snapshotClaudeCWD(proc); got != "/work/repo" {
+		t.Fatalf("snapshot = %q, want /work/repo", got)
+	}
+	proc.SetClaudeCWD("/work/repo")
+
+	// The shell moves on; the live CWD follows it but the Claude CWD doesn't
+	setPaneCWD(t, "rc-proc-0", "/work/repo/sub")
+	s.refreshCWDs()
+	var changed protocol.ProcessCWDChangedPayload
+	readPayload(t, watcher, protocol.TypeProcessCWDChanged, &changed)
+	if changed.CWD != "/work/repo/sub" || proc.GetClaudeCWD() != "/work/repo" {
+		t.Errorf("cwd = %q, claudeCwd = %q; want /work/repo/sub and /work/repo", changed.CWD, proc.GetClaudeCWD())
+	}
+}
+
+func TestClaudeKillClearsClaudeCWD(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+	if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{ProcessID: "proc-1", HostID: "host-1",
+		ProcessType: "claude", Port: 3284, TmuxName: "rc-proc-1", ClaudeCWD: "/work/repo", StartedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	s.processRegistry.Register(&process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeClaude,
+		CWD: "/work/repo/sub", ClaudeCWD: "/work/repo"})
+
+	dispatch(t, s, cs, protocol.TypeClaudeKill, protocol.ClaudeKillPayload{ProcessID: "proc-1"})
+	var updated protocol.ProcessUpdatedPayload
+	readPayload(t, conn, protocol.TypeProcessUpdated, &updated)
+	if updated.Type != protocol.ProcessTypeShell || updated.ClaudeCWD != "" {
+		t.Errorf("after kill: type = %s, claudeCwd = %q", updated.Type, updated.ClaudeCWD)
+	}
+	if meta, _ := s.storage.GetProcessMetadata("proc-1"); meta == nil || meta.ClaudeCWD != "" {
+		t.Errorf("stored metadata = %+v, want claude cwd cleared", meta)
+	}
+}
+
+func TestRestoreClaudeCWD(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	for _, id := range []string{"proc-saved", "proc-legacy"} {
+		if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{ProcessID: id, HostID: "host-1",
+			ProcessType: "claude", Port: 3284, TmuxName: pty.TmuxSessionName(id), StartedAt: time.Now()}); err != nil {
+			t.Fatalf("SaveProcessMetadata: %v", err)
+		}
+	}
+	reattached := func(id string) *process.Process {
+		ptySession := &pty.Session{ID: id, HostID: "host-1", TmuxName: pty.TmuxSessionName(id)}
+		ptySession.SetCWD("/work/repo/sub")
+		return &process.Process{ID: id, HostID: "host-1", PTY: ptySession}
+	}
+
+	// A recorded Claude CWD wins over wherever the shell is now
+	saved := reattached("proc-saved")
+	s.restoreClaudeCWD(saved, "/work/repo")
+	if got := saved.GetClaudeCWD(); got != "/work/repo" {
+		t.Errorf("restored claude cwd = %q, want /work/repo", got)
+	}
+	if meta, _ := s.storage.GetProcessMetadata("proc-saved"); meta.ClaudeCWD != "" {
+		t.Errorf("restoring rewrote storage: %q", meta.ClaudeCWD)
+	}
+
+	// Claude started before the column existed is backfilled from the live CWD
+	legacy := reattached("proc-legacy")
+	s.restoreClaudeCWD(legacy, "")
+	if got := legacy.GetClaudeCWD(); got != "/work/repo/sub" {
+		t.Errorf("backfilled claude cwd = %q, want /work/repo/sub", got)
+	}
+	if meta, _ := s.storage.GetProcessMetadata("proc-legacy"); meta.ClaudeCWD != "/work/repo/sub" {
+		t.Errorf("backfill not persisted: %q", meta.ClaudeCWD)
+	}
+}
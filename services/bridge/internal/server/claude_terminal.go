@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/env"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+)
+
+// terminalAttachGrace is how long after agentapi attach is typed a pane may
+// still show the shell before the terminal is taken for detached
+var terminalAttachGrace = 15 * time.Second
+
+// agentAPIAttachCommand returns the command line that shows Claude's UI in
+// a terminal, from the AgentAPI server on port
+func agentAPIAttachCommand(port int) string {
+	return fmt.Sprintf("agentapi attach --url http://localhost:%d", port)
+}
+
+// checkTerminalAttachments compares the foreground program of Claude
+// processes' panes, read by a pane refresh, with agentapi attach, and
+// pushes process_updated for the processes whose terminal dropped back to
+// the shell or came back
+func (s *Server) checkTerminalAttachments(procs []*process.Process, panes map[string]pty.PaneInfo, now time.Time) {
+	for _, proc := range procs {
+		pane, ok := panes[proc.ID]
+		if !ok {
+			continue
+		}
+		attached, changed := proc.TerminalAttachChange(pane.Command, now, terminalAttachGrace)
+		if !changed {
+			continue
+		}
+		if attached {
+			log.Printf("[INFO] [CLAUDE] Terminal of process %s shows Claude again", proc.ID)
+		} else {
+			log.Printf("[WARN] [CLAUDE] Terminal of process %s left agentapi attach, pane runs %q", proc.ID, pane.Command)
+		}
+		if err := s.notifyProcessUpdated(nil, proc); err != nil {
+			log.Printf("[ERROR] [CLAUDE] Failed to push terminal state of process %s: %v", proc.ID, err)
+		}
+	}
+}
+
+// handleClaudeReattachTerminal types agentapi attach into a Claude process's
+// pane again, after the attach exited and left the terminal at the shell.
+// It is only typed while the pane shows an idle shell, so it can't land in
+// whatever else runs there; a pane that already shows Claude is just taken
+// for attached. Answered with process_updated.
+func (s *Server) handleClaudeReattachTerminal(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ClaudeReattachTerminalPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	proc := s.processRegistry.Get(payload.ProcessID)
+	if proc == nil {
+		return connSession.sendProcessNotFound(payload.ProcessID)
+	}
+	port, ok := proc.GetPort()
+	if proc.GetType() != process.TypeClaude || !ok {
+		return connSession.SendErrorDetails(protocol.ErrorNotClaude,
+			protocol.ErrorDetails{"processId": proc.ID, "processType": proc.GetType()})
+	}
+	if proc.PTY == nil {
+		return connSession.SendErrorDetails(protocol.ErrorNoPty, protocol.ErrorDetails{"processId": proc.ID})
+	}
+
+	command, err := proc.PTY.PaneCommand()
+	if err != nil {
+		log.Printf("[ERROR] [CLAUDE] Failed to read pane command of process %s: %v", proc.ID, err)
+		return s.sendPtyFailure(connSession, proc, process.OpPtyWrite, err)
+	}
+	switch {
+	case command == process.AttachCommand:
+		log.Printf("[DEBUG] [CLAUDE] Terminal of process %s already shows Claude", proc.ID)
+	case env.IsShell(command):
+		log.Printf("[INFO] [CLAUDE] Reattaching terminal of process %s to AgentAPI on port %d", proc.ID, port)
+		if err := proc.PTY.SendLine(agentAPIAttachCommand(port)); err != nil {
+			log.Printf("[ERROR] [CLAUDE] Failed to type attach command into process %s: %v", proc.ID, err)
+			return s.sendPtyFailure(connSession, proc, process.OpPtyWrite, err)
+		}
+		s.clearProcessError(proc, process.OpPtyWrite)
+	default:
+		return connSession.SendErrorDetails(protocol.ErrorInvalidState, protocol.ErrorDetails{
+			"processId":   proc.ID,
+			"processType": proc.GetType(),
+			"paneCommand": command,
+			"reason":      fmt.Sprintf("the pane runs %s, not an idle shell", command),
+		})
+	}
+
+	proc.MarkTerminalAttaching(time.Now())
+	return s.notifyProcessUpdated(connSession, proc)
+}
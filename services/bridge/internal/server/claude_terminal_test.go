@@ -0,0 +1,118 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// startFakeClaude makes a registered shell look like a Claude process whose
+// AgentAPI server listens on 3284
+func startFakeClaude(s *Server, processID string) *process.Process {
+	proc := s.processRegistry.Get(processID)
+	proc.SetPort(3284)
+	proc.UpdateType(process.TypeClaude)
+	return proc
+}
+
+func TestTerminalAttachDetection(t *testing.T) {
+	t.Setenv("FAKE_TMUX_COMMAND", "agentapi")
+	s := newQuietServer(t)
+	watcher, watcherCS := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 2)
+	startFakeClaude(s, "proc-0")
+	dispatch(t, s, watcherCS, protocol.TypeProcessesSubscribe, protocol.ProcessesSubscribePayload{HostID: "host-1"})
+	var list protocol.ProcessListResultPayload
+	readPayload(t, watcher, protocol.TypeProcessListResult, &list)
+	for _, info := range list.Processes {
+		if attached := info.TerminalAttached; (info.ID == "proc-0") != (attached != nil && *attached) {
+			t.Errorf("%s listed with terminalAttached %v", info.ID, attached)
+		}
+	}
+
+	// The terminal shows Claude: only the new CWDs are pushed
+	s.refreshCWDs()
+	for i := 0; i < 2; i++ {
+		readPayload(t, watcher, protocol.TypeProcessCWDChanged, nil)
+	}
+	expectNothingQueued(t, watcher, watcherCS)
+
+	// The attach exited; the shell process's pane showing bash means nothing
+	t.Setenv("FAKE_TMUX_COMMAND", "bash")
+	s.refreshCWDs()
+	var updated protocol.ProcessUpdatedPayload
+	readPayload(t, watcher, protocol.TypeProcessUpdated, &updated)
+	if updated.ID != "proc-0" || updated.TerminalAttached == nil || *updated.TerminalAttached {
+		t.Errorf("after the attach exited: %+v", updated)
+	}
+	s.refreshCWDs()
+	expectNothingQueued(t, watcher, watcherCS)
+
+	// Attached again from the terminal itself
+	t.Setenv("FAKE_TMUX_COMMAND", "agentapi")
+	s.refreshCWDs()
+	readPayload(t, watcher, protocol.TypeProcessUpdated, &updated)
+	if updated.TerminalAttached == nil || !*updated.TerminalAttached {
+		t.Errorf("after attaching again: %+v", updated)
+	}
+	expectNothingQueued(t, watcher, watcherCS)
+}
+
+func TestClaudeReattachTerminal(t *testing.T) {
+	t.Setenv("FAKE_TMUX_COMMAND", "vim")
+	keys := recordTmuxKeys(t)
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 2)
+	proc := startFakeClaude(s, "proc-0")
+	// Panes are only read on hosts somebody watches
+	dispatch(t, s, cs, protocol.TypeProcessesSubscribe, protocol.ProcessesSubscribePayload{HostID: "host-1"})
+	readPayload(t, conn, protocol.TypeProcessListResult, nil)
+	s.refreshCWDs()
+	for i := 0; i < 2; i++ {
+		readPayload(t, conn, protocol.TypeProcessCWDChanged, nil)
+	}
+	readPayload(t, conn, protocol.TypeProcessUpdated, nil)
+	if proc.TerminalAttached() {
+		t.Fatal("terminal attached while the pane runs vim")
+	}
+
+	// Nothing is typed into a pane running something else
+	dispatch(t, s, cs, protocol.TypeClaudeReattachTerminal, protocol.ClaudeReattachTerminalPayload{ProcessID: "proc-0"})
+	var errPayload protocol.ErrorPayload
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorInvalidState {
+		t.Errorf("pane running vim: %s", errPayload.Code)
+	}
+	if typed := keys(); typed != "" {
+		t.Errorf("typed %q into vim", typed)
+	}
+
+	// At the shell prompt the attach command is typed again
+	t.Setenv("FAKE_TMUX_COMMAND", "-zsh")
+	dispatch(t, s, cs, protocol.TypeClaudeReattachTerminal, protocol.ClaudeReattachTerminalPayload{ProcessID: "proc-0"})
+	var updated protocol.ProcessUpdatedPayload
+	readPayload(t, conn, protocol.TypeProcessUpdated, &updated)
+	if updated.TerminalAttached == nil || !*updated.TerminalAttached {
+		t.Errorf("after reattaching: %+v", updated)
+	}
+	if typed := keys(); !strings.Contains(typed, "agentapi attach --url http://localhost:3284") || strings.Count(typed, "Enter") != 1 {
+		t.Errorf("typed %q", typed)
+	}
+
+	// The pane still shows the shell while the attach starts
+	s.refreshCWDs()
+	if !proc.TerminalAttached() {
+		t.Error("terminal taken for detached before the attach could start")
+	}
+
+	dispatch(t, s, cs, protocol.TypeClaudeReattachTerminal, protocol.ClaudeReattachTerminalPayload{ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotClaude {
+		t.Errorf("shell process: %s", errPayload.Code)
+	}
+}
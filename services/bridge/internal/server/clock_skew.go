@@ -0,0 +1,24 @@
+package server
+
+import (
+	"log"
+	"time"
+)
+
+// clockSkewWarnThreshold is how far a client's clock may be from the
+// bridge's before it is logged
+const clockSkewWarnThreshold = 30 * time.Second
+
+// measureClockSkew returns how far the bridge's clock is ahead of a client's,
+// from the timestamp (ms since the epoch) the client sent with auth. It
+// includes the auth message's transit time, so only large values mean
+// anything; those are logged, since a bridge host without NTP otherwise
+// only shows up as odd message times in the app.
+func measureClockSkew(sessionID string, now time.Time, clientTimestamp int64) time.Duration {
+	skew := now.Sub(time.UnixMilli(clientTimestamp))
+	if skew.Abs() > clockSkewWarnThreshold {
+		log.Printf("[WARN] [AUTH] Clock of session %s is %s off from the bridge's (bridge ahead by %s); check NTP on both",
+			sessionID, skew.Abs().Round(time.Second), skew.Round(time.Millisecond))
+	}
+	return skew
+}
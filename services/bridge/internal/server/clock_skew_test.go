@@ -0,0 +1,36 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+func TestAuthReportsClockSkew(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+
+	// A client whose clock is five minutes behind
+	clientTime := time.Now().Add(-5 * time.Minute).UnixMilli()
+	authAgain(t, s, cs, protocol.AuthPayload{ProtocolVersion: &protocolVersion, ClientTimestamp: &clientTime})
+	var result protocol.AuthResultPayload
+	readPayload(t, conn, protocol.TypeAuthResult, &result)
+	if result.ClockSkewMs == nil {
+		t.Fatal("no clockSkewMs in auth result")
+	}
+	if skew := time.Duration(*result.ClockSkewMs) * time.Millisecond; skew < 5*time.Minute || skew > 5*time.Minute+5*time.Second {
+		t.Errorf("clockSkewMs = %d, want about five minutes", *result.ClockSkewMs)
+	}
+	if result.ServerTimestamp-clientTime != *result.ClockSkewMs {
+		t.Errorf("serverTimestamp %d doesn't match the skew", result.ServerTimestamp)
+	}
+
+	// Clients that don't send their time get no skew
+	result = protocol.AuthResultPayload{}
+	authAgain(t, s, cs, protocol.AuthPayload{ProtocolVersion: &protocolVersion})
+	readPayload(t, conn, protocol.TypeAuthResult, &result)
+	if result.ClockSkewMs != nil || result.ServerTimestamp == 0 {
+		t.Errorf("without clientTimestamp: skew %v, serverTimestamp %d", result.ClockSkewMs, result.ServerTimestamp)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// wsByteStats counts WebSocket payload bytes before compression and the bytes
+// actually written to the wire (frame headers included) across all sessions
+type wsByteStats struct {
+	payloadBytes atomic.Uint64
+	wireBytes    atomic.Uint64
+}
+
+// WSByteCounts is a point-in-time copy of the WebSocket byte counters
+type WSByteCounts struct {
+	PayloadBytes uint64 `json:"payloadBytes"`
+	WireBytes    uint64 `json:"wireBytes"`
+}
+
+func (s *wsByteStats) snapshot() WSByteCounts {
+	return WSByteCounts{
+		PayloadBytes: s.payloadBytes.Load(),
+		WireBytes:    s.wireBytes.Load(),
+	}
+}
+
+// countingConn wraps the hijacked network connection to count written bytes
+type countingConn struct {
+	net.Conn
+	stats *wsByteStats
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.stats.wireBytes.Add(uint64(n))
+	return n, err
+}
+
+// countingResponseWriter intercepts Hijack so the upgraded WebSocket writes
+// through a countingConn. The upgrader writes the handshake and all frames
+// directly to the returned net.Conn.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	stats *wsByteStats
+}
+
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not implement http.Hijacker")
+	}
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &countingConn{Conn: conn, stats: w.stats}, brw, nil
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// wireBytesForChatMessages returns the bytes written to the wire for one large
+// chat_messages frame, with or without compression negotiated
+func wireBytesForChatMessages(t *testing.T, compression bool) (int, uint64) {
+	config := DefaultConfig()
+	config.WSCompression = true
+	s := newTestServer(t, config)
+
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	conn, cs := dialAndAuth(t, s, url, compression)
+	if cs.Compression != compression {
+		t.Fatalf("session compression = %v, want %v", cs.Compression, compression)
+	}
+
+	messages := make([]protocol.ChatMessage, 200)
+	for i := range messages {
+		messages[i] = protocol.ChatMessage{
+			ID:      i,
+			Role:    "assistant",
+			Message: fmt.Sprintf("Here is the updated implementation for step %d of the refactor.", i),
+			Time:    "2024-01-01T00:00:00Z",
+		}
+	}
+	msg, _ := protocol.NewMessage(protocol.TypeChatMessages, protocol.ChatMessagesPayload{
+		HostID:    "host-1",
+		ProcessID: "proc-1",
+		Messages:  messages,
+	})
+
+	before := s.wsStats.snapshot().WireBytes
+	if err := cs.Send(msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	var received protocol.Message
+	if err := conn.ReadJSON(&received); err != nil {
+		t.Fatalf("ReadJSON: %v", err)
+	}
+	if received.Type != protocol.TypeChatMessages {
+		t.Fatalf("expected chat_messages, got %s", received.Type)
+	}
+	return len(msg.Payload), s.wsStats.snapshot().WireBytes - before
+}
+
+func TestCompressionReducesWireBytes(t *testing.T) {
+	payloadSize, plain := wireBytesForChatMessages(t, false)
+	_, compressed := wireBytesForChatMessages(t, true)
+
+	if plain < uint64(payloadSize) {
+		t.Errorf("uncompressed wire bytes %d smaller than payload %d", plain, payloadSize)
+	}
+	if compressed*2 > plain {
+		t.Errorf("expected compression to at least halve wire bytes: plain=%d compressed=%d", plain, compressed)
+	}
+}
+
+func TestCompressionSkipsSmallFrames(t *testing.T) {
+	config := DefaultConfig()
+	config.WSCompression = true
+	s := newTestServer(t, config)
+
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	conn, cs := dialAndAuth(t, s, url, true)
+
+	msg, _ := protocol.NewMessage(protocol.TypePtyOutput, protocol.PtyOutputPayload{ProcessID: "p", Data: "x"})
+	before := s.wsStats.snapshot()
+	if err := cs.Send(msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	var received protocol.Message
+	if err := conn.ReadJSON(&received); err != nil {
+		t.Fatalf("ReadJSON: %v", err)
+	}
+	after := s.wsStats.snapshot()
+
+	payload := after.PayloadBytes - before.PayloadBytes
+	wire := after.WireBytes - before.WireBytes
+	// An uncompressed frame is the payload plus a 2-byte header
+	if wire != payload+2 {
+		t.Errorf("small frame should be sent uncompressed: payload=%d wire=%d", payload, wire)
+	}
+}
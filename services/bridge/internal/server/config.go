@@ -0,0 +1,247 @@
+package server
+
+import (
+	"compress/flate"
+	"slices"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/crypto"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/env"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// BuildInfo identifies the running build. It is injected into main at link
+// time; unset fields are reported as "dev"/"unknown".
+type BuildInfo struct {
+	Version string
+	Commit  string
+	Date    string
+}
+
+// withDefaults fills in placeholders for fields not set at build time
+func (b BuildInfo) withDefaults() BuildInfo {
+	if b.Version == "" {
+		b.Version = "dev"
+	}
+	if b.Commit == "" {
+		b.Commit = "unknown"
+	}
+	if b.Date == "" {
+		b.Date = "unknown"
+	}
+	return b
+}
+
+// Config holds tunable server options set from command-line flags
+type Config struct {
+	// Build identifies this bridge build in auth results, /health and bridge_info
+	Build BuildInfo
+
+	// Profile selects an isolated data profile (database and encryption key)
+	// within the data directory; empty means DefaultProfile
+	Profile string
+
+	// BasePath prefixes every HTTP route (e.g. "/bridge" serves /bridge/ws),
+	// for running behind a reverse proxy at a path prefix; empty serves at
+	// the root
+	BasePath string
+
+	// ExternalURL is the address clients reach the bridge at, including any
+	// proxy prefix (e.g. "https://example.com/bridge"). It is used for URLs
+	// the bridge hands out; when empty they are built from the request and,
+	// if it came from a trusted proxy, its X-Forwarded-Proto/X-Forwarded-Host
+	// headers.
+	ExternalURL string
+
+	// TrustedProxies are the IP addresses and CIDR ranges (e.g. "10.0.0.0/8")
+	// of reverse proxies whose X-Forwarded-* headers are honored; anyone else
+	// could point the URLs the bridge hands out at another host
+	TrustedProxies []string
+
+	// AuthToken, when set, must be presented by WebSocket clients in the auth
+	// message and by REST clients as "Authorization: Bearer <token>"
+	AuthToken string
+
+	// HandshakeTimeout is how long a WebSocket connection may go without a
+	// successful auth before it is closed (0 waits forever)
+	HandshakeTimeout time.Duration
+
+	// PingInterval is how often WebSocket clients are pinged to measure
+	// their round-trip time (0 disables)
+	PingInterval time.Duration
+
+	// AdminSocket serves protocol requests to local tools on a Unix socket
+	// in the profile's data directory; AdminWrites lets it serve requests
+	// that change state, such as process_kill, as well as reads
+	AdminSocket bool
+	AdminWrites bool
+
+	// WebSocket compression (permessage-deflate)
+	WSCompression          bool // Offer permessage-deflate during the upgrade
+	WSCompressionLevel     int  // flate level used once a client opts in
+	WSCompressionThreshold int  // Frames smaller than this are sent uncompressed
+
+	// SlowHandlerThreshold logs a warning for message handlers that take at
+	// least this long (0 disables the warning)
+	SlowHandlerThreshold time.Duration
+
+	// CWDRefreshInterval is how often the working directories of processes on
+	// hosts with clients are refreshed from tmux and pushed (0 disables)
+	CWDRefreshInterval time.Duration
+
+	// TmuxProbeInterval is how often the tmux servers of hosts with clients
+	// are checked for sessions that vanished under their processes (0
+	// disables)
+	TmuxProbeInterval time.Duration
+
+	// AlertInterval is the minimum time between process_alert pushes for one
+	// process; alerts are polled along with the CWD refresh
+	AlertInterval time.Duration
+
+	// HostConnectTimeout bounds the scans host_connect runs once the SSH
+	// connection is up; scans still running then are left out of the
+	// HOST_STATUS and listed in its timedOut (0 waits for them all)
+	HostConnectTimeout time.Duration
+
+	// ReattachConcurrency is how many of a host's processes are reattached
+	// at once, on host_connect and after an auth; starts are spaced out so
+	// hosts limiting SSH sessions aren't hit all at once (below 1 means 1)
+	ReattachConcurrency int
+
+	// MaxConcurrentOps is how many remote operations (command sessions and
+	// tunnel dials) run at once on a host unless its settings say otherwise;
+	// the rest wait their turn. 0 means ssh.DefaultMaxConcurrentOps.
+	MaxConcurrentOps int
+
+	// PortRange is the range AgentAPI ports are allocated from and scanned;
+	// the zero value means process.DefaultPortRange
+	PortRange process.PortRange
+
+	// StalePortMaxAge is how old a port reservation left by a host scan, for
+	// a stale AgentAPI or a detached session, may get before it is reclaimed
+	// when the port range runs out and its host can't be asked whether the
+	// port is still bound (0 keeps such reservations)
+	StalePortMaxAge time.Duration
+
+	// PtyOutput is how processes' PTY output is read and coalesced into
+	// pty_output messages; process_create may override it per process. The
+	// zero value means pty.DefaultOutputTuning.
+	PtyOutput pty.OutputTuning
+
+	// PtyHistoryMaxChunkSize caps the chunk size clients may request for pty
+	// history transfers, within the protocol's 8 KB-512 KB bounds
+	PtyHistoryMaxChunkSize int
+
+	// host_exec limits: the longest timeout a command may ask for, the
+	// bytes of stdout and of stderr kept per command, and how many
+	// commands one session may run at once
+	HostExecMaxTimeout    time.Duration
+	HostExecMaxOutput     int
+	HostExecMaxConcurrent int
+
+	// FileDownloadMaxSize is the largest file, in bytes, file_download
+	// sends
+	FileDownloadMaxSize int64
+
+	// FileUploadMaxSize is the largest file, in bytes, file_upload_begin
+	// accepts. Uploads are held in memory until committed, and dropped
+	// when left without messages for FileUploadIdleTimeout.
+	FileUploadMaxSize     int64
+	FileUploadIdleTimeout time.Duration
+
+	// ConfirmKills makes process_kill and claude_kill two-phase for every
+	// client, as if each request set confirmRequired
+	ConfirmKills bool
+
+	// ArchiveMaxAge is how long a process killed with its history kept
+	// stays archived before it is deleted (0 keeps archives until a client
+	// deletes them)
+	ArchiveMaxAge time.Duration
+
+	// PersistInterval is how often PTY history and chat buffers are saved
+	// to the database; a crash loses at most this much of them, less for
+	// processes flushed early
+	PersistInterval time.Duration
+
+	// FlushMaxBytes and FlushMaxAge flush a process's buffers before the
+	// next persist once that many bytes of its output or chat are unsaved,
+	// or its oldest unsaved data is that old (0 disables either)
+	FlushMaxBytes int64
+	FlushMaxAge   time.Duration
+
+	// PtyHistoryMaxBytes is how much PTY history is kept per process; older
+	// output is dropped by the periodic persist, except for processes set to
+	// retain their full history (0 keeps it all)
+	PtyHistoryMaxBytes int64
+
+	// MinFreeSpace is the free space, in bytes, the data directory's volume
+	// must have for the bridge to start (0 skips the check)
+	MinFreeSpace int64
+
+	// EnvSecretPatterns are glob patterns for env var keys whose values are
+	// masked in env listings until explicitly revealed
+	EnvSecretPatterns []string
+
+	// CredentialBackend is where new host credentials are kept: "sqlite"
+	// (encrypted in the database), "exec" (printed by CredentialCommand) or
+	// "keychain" (macOS builds with the keychain tag). Hosts kept elsewhere
+	// are moved to it at startup.
+	CredentialBackend string
+
+	// EncryptHistory encrypts chat messages, PTY history and process env
+	// vars in the database with a key derived from the credentials key.
+	// Rows stored before are encrypted when next persisted, or all at once
+	// by storage_encrypt_now.
+	EncryptHistory bool
+
+	// UpdateCheck fetches the release manifest at UpdateManifestURL, which
+	// must be https, at startup and daily, and tells clients when it lists
+	// a newer release. Nothing is installed.
+	UpdateCheck       bool
+	UpdateManifestURL string
+
+	// CredentialCommand is run with a host ID as its last argument to print
+	// the host's secret, for the exec backend; it may run for at most
+	// CredentialTimeout
+	CredentialCommand string
+	CredentialTimeout time.Duration
+}
+
+// DefaultConfig returns the configuration used when no flags are given
+func DefaultConfig() Config {
+	return Config{
+		AdminSocket:            true,
+		HandshakeTimeout:       10 * time.Second,
+		PingInterval:           15 * time.Second,
+		WSCompression:          false,
+		WSCompressionLevel:     flate.BestSpeed,
+		WSCompressionThreshold: 512,
+		SlowHandlerThreshold:   500 * time.Millisecond,
+		CWDRefreshInterval:     15 * time.Second,
+		TmuxProbeInterval:      30 * time.Second,
+		AlertInterval:          30 * time.Second,
+		HostConnectTimeout:     30 * time.Second,
+		ReattachConcurrency:    3,
+		MaxConcurrentOps:       ssh.DefaultMaxConcurrentOps,
+		PortRange:              process.DefaultPortRange,
+		StalePortMaxAge:        7 * 24 * time.Hour,
+		PtyOutput:              pty.DefaultOutputTuning(),
+		PtyHistoryMaxChunkSize: maxHistoryChunkSize,
+		HostExecMaxTimeout:     5 * time.Minute,
+		HostExecMaxOutput:      256 << 10,
+		HostExecMaxConcurrent:  4,
+		FileDownloadMaxSize:    100 << 20,
+		FileUploadMaxSize:      50 << 20,
+		FileUploadIdleTimeout:  5 * time.Minute,
+		ArchiveMaxAge:          30 * 24 * time.Hour,
+		PersistInterval:        30 * time.Second,
+		FlushMaxBytes:          64 << 10,
+		FlushMaxAge:            5 * time.Second,
+		MinFreeSpace:           64 << 20,
+		EnvSecretPatterns:      slices.Clone(env.DefaultSecretPatterns),
+		CredentialBackend:      crypto.BackendSQLite,
+		CredentialTimeout:      10 * time.Second,
+	}
+}
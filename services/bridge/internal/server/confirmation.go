@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// confirmationTTL is how long the token of a confirmation_challenge is valid
+var confirmationTTL = 30 * time.Second
+
+// confirmDestructive runs the two-phase protocol of a destructive request,
+// and reports whether the request should go ahead: it carries a valid token,
+// or neither the client nor the bridge (required) asked for confirmation.
+// Otherwise it has sent the client a challenge, built by challenge, or an
+// error for a bad token.
+func (s *Server) confirmDestructive(connSession *ConnectedSession, action, target string, c protocol.Confirmation, required bool,
+	challenge func() protocol.ConfirmationChallengePayload) (bool, error) {
+	if c.ConfirmToken != "" {
+		err := connSession.Confirm(c.ConfirmToken, action, target)
+		if err == nil {
+			log.Printf("[DEBUG] [CONFIRM] Session %s confirmed %s on %s", connSession.ID, action, target)
+			return true, nil
+		}
+		log.Printf("[WARN] [CONFIRM] Session %s sent a bad token for %s on %s: %v", connSession.ID, action, target, err)
+		return false, connSession.SendErrorDetails(protocol.ErrorConfirmationInvalid,
+			protocol.ErrorDetails{"action": action, "target": target, "reason": err.Error()})
+	}
+	if !c.ConfirmRequired && !required {
+		return true, nil
+	}
+
+	payload := challenge()
+	payload.Action = action
+	payload.Target = target
+	payload.Token = connSession.IssueConfirmation(action, target, confirmationTTL)
+	payload.ExpiresInSeconds = int(confirmationTTL / time.Second)
+	log.Printf("[INFO] [CONFIRM] Session %s must confirm %s: %s", connSession.ID, action, payload.Summary)
+
+	msg, err := protocol.NewMessage(protocol.TypeConfirmationChallenge, payload)
+	if err != nil {
+		return false, err
+	}
+	return false, connSession.Send(msg)
+}
+
+// processChallenge describes what doing action to a process destroys: its
+// name, how long it has run and, for Claude, its conversation
+func (s *Server) processChallenge(proc *process.Process, action string) protocol.ConfirmationChallengePayload {
+	info := proc.ToInfo()
+	name := proc.ID
+	if info.Name != nil && *info.Name != "" {
+		name = *info.Name
+	}
+	uptime := time.Since(proc.StartedAt).Round(time.Second)
+	uptimeSeconds := int64(uptime / time.Second)
+
+	challenge := protocol.ConfirmationChallengePayload{
+		ProcessName:   &name,
+		ProcessType:   &info.Type,
+		UptimeSeconds: &uptimeSeconds,
+	}
+	summary := fmt.Sprintf("%s process %q, running for %s", info.Type, name, uptime)
+	if info.Type == protocol.ProcessTypeClaude {
+		summary = fmt.Sprintf("Claude process %q, running for %s", name, uptime)
+		if s.storage != nil {
+			if count, err := s.storage.GetChatMessageCount(proc.ID); err == nil {
+				challenge.ChatMessages = &count
+				summary += fmt.Sprintf(", with %d chat messages", count)
+			}
+		}
+	}
+
+	switch action {
+	case protocol.TypeClaudeKill:
+		challenge.Summary = "Stop Claude in " + summary + "; the shell is kept"
+	default:
+		challenge.Summary = "Kill " + summary
+	}
+	return challenge
+}
+
+// hostConfigChallenge describes the host config a host_config_delete removes
+func hostConfigChallenge(host *storage.SSHHost) protocol.ConfirmationChallengePayload {
+	return protocol.ConfirmationChallengePayload{
+		Summary: fmt.Sprintf("Delete host config %q (%s@%s:%d)", host.Name, host.Username, host.Host, host.Port),
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+func TestProcessKillConfirmation(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	registerTestClaude(t, s, "proc-1")
+	registerTestClaude(t, s, "proc-2")
+	s.processRegistry.Get("proc-1").SetName("auth fixes")
+
+	var challenge protocol.ConfirmationChallengePayload
+	dispatch(t, s, cs, protocol.TypeProcessKill, protocol.ProcessKillPayload{ProcessID: "proc-1",
+		Confirmation: protocol.Confirmation{ConfirmRequired: true}})
+	readPayload(t, conn, protocol.TypeConfirmationChallenge, &challenge)
+	if challenge.Action != protocol.TypeProcessKill || challenge.Target != "proc-1" || challenge.Token == "" ||
+		challenge.ExpiresInSeconds != 30 || challenge.ChatMessages == nil || *challenge.ChatMessages != 0 {
+		t.Errorf("challenge = %+v", challenge)
+	}
+	if !strings.Contains(challenge.Summary, `Kill Claude process "auth fixes", running for`) {
+		t.Errorf("summary = %q", challenge.Summary)
+	}
+	if s.processRegistry.Get("proc-1") == nil {
+		t.Fatal("process killed before it was confirmed")
+	}
+
+	// The token only confirms the request it was issued for
+	var errPayload protocol.ErrorPayload
+	dispatch(t, s, cs, protocol.TypeProcessKill, protocol.ProcessKillPayload{ProcessID: "proc-2",
+		Confirmation: protocol.Confirmation{ConfirmToken: challenge.Token}})
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorConfirmationInvalid || s.processRegistry.Get("proc-2") == nil {
+		t.Errorf("token for another process: %+v", errPayload)
+	}
+
+	dispatch(t, s, cs, protocol.TypeProcessKill, protocol.ProcessKillPayload{ProcessID: "proc-1",
+		Confirmation: protocol.Confirmation{ConfirmRequired: true}})
+	readPayload(t, conn, protocol.TypeConfirmationChallenge, &challenge)
+	dispatch(t, s, cs, protocol.TypeProcessKill, protocol.ProcessKillPayload{ProcessID: "proc-1",
+		Confirmation: protocol.Confirmation{ConfirmToken: challenge.Token}})
+	readPayload(t, conn, protocol.TypeProcessKilled, nil)
+	if s.processRegistry.Get("proc-1") != nil {
+		t.Error("process not killed after confirming")
+	}
+
+	// Tokens are single-use
+	registerTestClaude(t, s, "proc-1")
+	dispatch(t, s, cs, protocol.TypeProcessKill, protocol.ProcessKillPayload{ProcessID: "proc-1",
+		Confirmation: protocol.Confirmation{ConfirmToken: challenge.Token}})
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorConfirmationInvalid || s.processRegistry.Get("proc-1") == nil {
+		t.Errorf("reused token: %+v", errPayload)
+	}
+
+	// Without confirmRequired, kills act at once as before
+	dispatch(t, s, cs, protocol.TypeProcessKill, protocol.ProcessKillPayload{ProcessID: "proc-2"})
+	readPayload(t, conn, protocol.TypeProcessKilled, nil)
+	expectNothingQueued(t, conn, cs)
+}
+
+func TestConfirmationExpires(t *testing.T) {
+	saved := confirmationTTL
+	t.Cleanup(func() { confirmationTTL = saved })
+	confirmationTTL = -time.Second
+
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	registerTestClaude(t, s, "proc-1")
+
+	var challenge protocol.ConfirmationChallengePayload
+	dispatch(t, s, cs, protocol.TypeClaudeKill, protocol.ClaudeKillPayload{ProcessID: "proc-1",
+		Confirmation: protocol.Confirmation{ConfirmRequired: true}})
+	readPayload(t, conn, protocol.TypeConfirmationChallenge, &challenge)
+	if !strings.HasPrefix(challenge.Summary, "Stop Claude in ") {
+		t.Errorf("summary = %q", challenge.Summary)
+	}
+
+	var errPayload protocol.ErrorPayload
+	dispatch(t, s, cs, protocol.TypeClaudeKill, protocol.ClaudeKillPayload{ProcessID: "proc-1",
+		Confirmation: protocol.Confirmation{ConfirmToken: challenge.Token}})
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorConfirmationInvalid || !strings.Contains(errorReason(errPayload), "expired") {
+		t.Errorf("expired token: %+v", errPayload)
+	}
+	if proc := s.processRegistry.Get("proc-1"); proc.Type != "claude" {
+		t.Errorf("Claude stopped with an expired token")
+	}
+}
+
+func TestConfirmKillsConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.CWDRefreshInterval = 0
+	config.TmuxProbeInterval = 0
+	config.ConfirmKills = true
+	s := newTestServer(t, config)
+	conn, cs := connectTestClient(t, s)
+	registerTestClaude(t, s, "proc-1")
+	if err := s.storage.CreateSSHHost(storage.SSHHost{
+		ID: "host-1", Name: "devbox", Host: "10.0.0.2", Port: 22, Username: "dev", AuthType: "agent",
+	}); err != nil {
+		t.Fatalf("CreateSSHHost: %v", err)
+	}
+
+	// The bridge requires confirming kills even when the client didn't ask
+	dispatch(t, s, cs, protocol.TypeProcessKill, protocol.ProcessKillPayload{ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypeConfirmationChallenge, nil)
+	if s.processRegistry.Get("proc-1") == nil {
+		t.Error("process killed without confirmation")
+	}
+
+	// Other destructive requests only when asked to
+	var result protocol.HostConfigDeleteResultPayload
+	dispatch(t, s, cs, protocol.TypeHostConfigDelete, protocol.HostConfigDeletePayload{ID: "host-1",
+		Confirmation: protocol.Confirmation{ConfirmRequired: true}})
+	var challenge protocol.ConfirmationChallengePayload
+	readPayload(t, conn, protocol.TypeConfirmationChallenge, &challenge)
+	if challenge.Summary != `Delete host config "devbox" (dev@10.0.0.2:22)` || challenge.ProcessName != nil {
+		t.Errorf("challenge = %+v", challenge)
+	}
+	dispatch(t, s, cs, protocol.TypeHostConfigDelete, protocol.HostConfigDeletePayload{ID: "host-1",
+		Confirmation: protocol.Confirmation{ConfirmToken: challenge.Token}})
+	readPayload(t, conn, protocol.TypeHostConfigDeleteResult, &result)
+	if !result.Success {
+		t.Errorf("delete result = %+v", result)
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/crypto"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// ============================================================================
+// Credential Backends
+// ============================================================================
+//
+// Host secrets live in one of the crypto.CredentialStore backends: encrypted
+// in the database (the default), printed by an external command, or in the
+// macOS keychain. Config.CredentialBackend picks where new secrets go; the
+// database records the backend of each host, and hosts kept elsewhere are
+// moved over at startup where their secret can still be read.
+
+// keychainService is the keychain service host secrets are kept under
+const keychainService = "remote-claude-bridge"
+
+// newCredentials sets up the configured backend, along with the others
+// available for reading hosts that haven't been moved to it
+func newCredentials(config Config, cipher *crypto.Cipher) (*crypto.Credentials, error) {
+	backend := config.CredentialBackend
+	if backend == "" {
+		backend = crypto.BackendSQLite
+	}
+	switch backend {
+	case crypto.BackendSQLite, crypto.BackendExec, crypto.BackendKeychain:
+	default:
+		return nil, fmt.Errorf("unknown credential backend %q: use %s, %s or %s",
+			backend, crypto.BackendSQLite, crypto.BackendExec, crypto.BackendKeychain)
+	}
+
+	stores := []crypto.CredentialStore{crypto.NewSQLiteStore(cipher)}
+	if config.CredentialCommand != "" || backend == crypto.BackendExec {
+		execStore, err := crypto.NewExecStore(config.CredentialCommand, config.CredentialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, execStore)
+	}
+	keychain, err := crypto.NewKeychainStore(keychainService)
+	if err == nil {
+		stores = append(stores, keychain)
+	} else if backend == crypto.BackendKeychain {
+		return nil, err
+	}
+
+	for _, store := range stores {
+		if store.Backend() == backend {
+			log.Printf("[INFO] [CREDENTIALS] Keeping host credentials in %s", backend)
+			return crypto.NewCredentials(store, stores...), nil
+		}
+	}
+	return nil, fmt.Errorf("credential backend %s is not available", backend)
+}
+
+// credentialBackend returns the backend a host's credential lives in
+func credentialBackend(host storage.SSHHost) string {
+	if host.CredentialBackend == "" {
+		return crypto.BackendSQLite
+	}
+	return host.CredentialBackend
+}
+
+// hostCredential returns the password or private key of a host. Hosts using
+// the SSH agent have none.
+func (s *Server) hostCredential(host storage.SSHHost) (string, error) {
+	if host.AuthType == "agent" {
+		return "", nil
+	}
+	return s.credentials.Get(host.CredentialBackend, host.CredentialEncrypted)
+}
+
+// storeCredential puts a host's secret in the active backend and records
+// where it went on host
+func (s *Server) storeCredential(host *storage.SSHHost, secret string) error {
+	active := s.credentials.Active()
+	stored, err := active.Put(host.ID, secret)
+	if err != nil {
+		return err
+	}
+	host.CredentialEncrypted = stored
+	host.CredentialBackend = active.Backend()
+	return nil
+}
+
+// credentialError is what a client is told when its credential can't be
+// stored: why, for a read-only backend, which has nothing to hide
+func credentialError(err error) error {
+	if errors.Is(err, crypto.ErrReadOnly) {
+		return newFailure(protocol.ErrorInvalidState, err.Error())
+	}
+	return newFailure(protocol.ErrorStorageError, "failed to store credential")
+}
+
+// forgetCredential removes a secret from the backend it was kept in, once
+// its host is gone or its secret moved
+func (s *Server) forgetCredential(hostID, backend string, stored []byte) {
+	store, err := s.credentials.Store(backend)
+	if err == nil {
+		err = store.Delete(stored)
+	}
+	if err != nil {
+		log.Printf("[WARN] [CREDENTIALS] Failed to remove the credential of host %s from %s: %v", hostID, backend, err)
+	}
+}
+
+// migrateCredentials moves the credentials of hosts kept in another backend
+// to the active one. A host whose secret can't be read, or can't be put in
+// the active backend, stays where it is and is read from there.
+func (s *Server) migrateCredentials() {
+	hosts, err := s.storage.ListSSHHosts()
+	if err != nil {
+		log.Printf("[ERROR] [CREDENTIALS] Failed to list hosts to migrate: %v", err)
+		return
+	}
+
+	active := s.credentials.Active()
+	moved := 0
+	for _, host := range hosts {
+		from := credentialBackend(host)
+		if from == active.Backend() {
+			continue
+		}
+		secret, err := s.hostCredential(host)
+		if err != nil {
+			log.Printf("[WARN] [CREDENTIALS] Credential of host %s stays in %s: %v", host.ID, from, err)
+			continue
+		}
+		stored, err := active.Put(host.ID, secret)
+		if err != nil {
+			log.Printf("[WARN] [CREDENTIALS] Credential of host %s stays in %s: %v", host.ID, from, err)
+			continue
+		}
+		if err := s.storage.SetSSHHostCredential(host.ID, active.Backend(), stored); err != nil {
+			log.Printf("[ERROR] [CREDENTIALS] Failed to move credential of host %s: %v", host.ID, err)
+			continue
+		}
+		s.forgetCredential(host.ID, from, host.CredentialEncrypted)
+		moved++
+	}
+	if moved > 0 {
+		log.Printf("[INFO] [CREDENTIALS] Moved %d host credentials to %s", moved, active.Backend())
+	}
+}
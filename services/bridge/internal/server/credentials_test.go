@@ -0,0 +1,156 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/crypto"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// newSecretCommand writes a stand-in for pass to a directory: it prints the
+// contents of the file named after the host ID it is given. It returns the
+// command and the directory to add secrets to.
+func newSecretCommand(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := filepath.Join(dir, "secret")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nexec cat \"$(dirname \"$0\")/$1\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return script, dir
+}
+
+func execCredentialConfig(command string) Config {
+	config := DefaultConfig()
+	config.CredentialBackend = crypto.BackendExec
+	config.CredentialCommand = command
+	return config
+}
+
+func TestExecCredentialBackend(t *testing.T) {
+	command, secrets := newSecretCommand(t)
+	s := newTestServer(t, execCredentialConfig(command))
+	conn, cs := connectTestClient(t, s)
+
+	// Hosts are created without a credential, which the command provides
+	dispatch(t, s, cs, protocol.TypeHostConfigCreate, protocol.HostConfigCreatePayload{
+		Name: "build box", Host: "10.0.0.5", Port: 22, Username: "me", AuthType: "password",
+	})
+	var created protocol.HostConfigCreateResultPayload
+	readPayload(t, conn, protocol.TypeHostConfigCreateResult, &created)
+	if !created.Success || created.Host.CredentialBackend != crypto.BackendExec {
+		t.Fatalf("create = %+v", created)
+	}
+	host, err := s.storage.GetSSHHost(created.Host.ID)
+	if err != nil || host == nil {
+		t.Fatalf("GetSSHHost: %v", err)
+	}
+	if string(host.CredentialEncrypted) != host.ID {
+		t.Errorf("stored credential = %q, want only the host ID", host.CredentialEncrypted)
+	}
+
+	if err := os.WriteFile(filepath.Join(secrets, host.ID), []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := s.hostCredential(*host); err != nil || secret != "hunter2" {
+		t.Errorf("hostCredential = %q, %v", secret, err)
+	}
+
+	dispatch(t, s, cs, protocol.TypeHostConfigList, nil)
+	var list protocol.HostConfigListResultPayload
+	readPayload(t, conn, protocol.TypeHostConfigListResult, &list)
+	if len(list.Hosts) != 1 || list.Hosts[0].CredentialBackend != crypto.BackendExec {
+		t.Errorf("list = %+v", list.Hosts)
+	}
+
+	// A secret the command doesn't print can't be stored
+	dispatch(t, s, cs, protocol.TypeHostConfigCreate, protocol.HostConfigCreatePayload{
+		Name: "db", Host: "10.0.0.6", Port: 22, Username: "me", AuthType: "password", Credential: "letmein",
+	})
+	readPayload(t, conn, protocol.TypeHostConfigCreateResult, &created)
+	if created.Success || created.Error == nil || !strings.Contains(*created.Error, "read-only") {
+		t.Errorf("create with a credential = %+v", created)
+	}
+}
+
+func TestCredentialBackendMigration(t *testing.T) {
+	dataDir := t.TempDir()
+	command, secrets := newSecretCommand(t)
+	start := func(config Config) *Server {
+		t.Helper()
+		s, err := New("127.0.0.1:0", dataDir, config)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		return s
+	}
+	backendOf := func(s *Server, id string) *storage.SSHHost {
+		t.Helper()
+		host, err := s.storage.GetSSHHost(id)
+		if err != nil || host == nil {
+			t.Fatalf("GetSSHHost(%s): %v", id, err)
+		}
+		return host
+	}
+
+	s := start(DefaultConfig())
+	moved, err := s.createSSHHost(storage.SSHHost{Name: "web", Host: "10.0.0.5", Port: 22, Username: "me", AuthType: "password"}, "hunter2")
+	if err != nil {
+		t.Fatalf("createSSHHost: %v", err)
+	}
+	kept, err := s.createSSHHost(storage.SSHHost{Name: "db", Host: "10.0.0.6", Port: 22, Username: "me", AuthType: "password"}, "letmein")
+	if err != nil {
+		t.Fatalf("createSSHHost: %v", err)
+	}
+	if moved.CredentialBackend != crypto.BackendSQLite {
+		t.Errorf("default backend = %s", moved.CredentialBackend)
+	}
+	s.Stop()
+
+	// Switching to exec moves the hosts whose secret the command prints; the
+	// others stay encrypted and readable
+	if err := os.WriteFile(filepath.Join(secrets, moved.ID), []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s = start(execCredentialConfig(command))
+	host := backendOf(s, moved.ID)
+	if host.CredentialBackend != crypto.BackendExec || string(host.CredentialEncrypted) != moved.ID {
+		t.Errorf("moved host = %s %q", host.CredentialBackend, host.CredentialEncrypted)
+	}
+	host = backendOf(s, kept.ID)
+	if host.CredentialBackend != crypto.BackendSQLite {
+		t.Errorf("kept host moved to %s", host.CredentialBackend)
+	}
+	if secret, err := s.hostCredential(*host); err != nil || secret != "letmein" {
+		t.Errorf("kept host credential = %q, %v", secret, err)
+	}
+	s.Stop()
+
+	// And back, while the command can still be read from
+	config := DefaultConfig()
+	config.CredentialCommand = command
+	s = start(config)
+	defer s.Stop()
+	host = backendOf(s, moved.ID)
+	if host.CredentialBackend != crypto.BackendSQLite {
+		t.Fatalf("host not moved back: %s", host.CredentialBackend)
+	}
+	if secret, err := s.cipher.DecryptString(host.CredentialEncrypted); err != nil || secret != "hunter2" {
+		t.Errorf("moved back credential = %q, %v", secret, err)
+	}
+}
+
+func TestCredentialBackendConfig(t *testing.T) {
+	for _, config := range []Config{
+		{CredentialBackend: "vault"},
+		{CredentialBackend: crypto.BackendExec},
+	} {
+		if _, err := New("127.0.0.1:0", t.TempDir(), config); err == nil {
+			t.Errorf("New accepted %+v", config)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// ============================================================================
+// Message Dispatch
+// ============================================================================
+//
+// Handlers run on per-session worker goroutines instead of the connection's
+// read loop, so a slow handler cannot stall the messages behind it.
+//
+// Ordering model:
+//   - Every message type belongs to one category (see messageCategory).
+//   - Each category has its own queue and worker per session. Messages in the
+//     same category are handled one at a time, in the order they were read.
+//   - There is no ordering between categories: a pty_input read after a
+//     host_connect may be handled before the host_connect completes. Clients
+//     already wait for the result of a request before sending messages that
+//     depend on it (e.g. input for a process only after process_created).
+//   - auth is a barrier: it runs only after everything read before it has been
+//     handled, and nothing read after it starts until it completes.
+
+// Message categories, each with its own ordered queue
+const (
+	categoryInput   = "input"   // Keystrokes, resizes and scrolls, in order; must never wait behind slow work
+	categoryHost    = "host"    // SSH connect/disconnect and remote scans, which can take seconds
+	categoryDefault = "default" // Everything else
+)
+
+// dispatchQueueSize is the number of pending messages per category before the
+// read loop blocks (applying backpressure to the client)
+const dispatchQueueSize = 256
+
+// messageCategory returns the dispatch category for a message type
+func messageCategory(msgType string) string {
+	switch msgType {
+	case protocol.TypePtyInput, protocol.TypePtyResize, protocol.TypePtyScroll:
+		return categoryInput
+	case protocol.TypeHostConnect, protocol.TypeHostDisconnect,
+		protocol.TypeHostCheckRequirements, protocol.TypePortsScan:
+		return categoryHost
+	default:
+		return categoryDefault
+	}
+}
+
+// dispatcher runs a session's handlers on one worker goroutine per category
+type dispatcher struct {
+	server      *Server
+	connSession *ConnectedSession
+	queues      map[string]chan func()
+	wg          sync.WaitGroup
+}
+
+func newDispatcher(s *Server, connSession *ConnectedSession) *dispatcher {
+	d := &dispatcher{
+		server:      s,
+		connSession: connSession,
+		queues:      make(map[string]chan func()),
+	}
+	for _, category := range []string{categoryInput, categoryHost, categoryDefault} {
+		queue := make(chan func(), dispatchQueueSize)
+		d.queues[category] = queue
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for fn := range queue {
+				fn()
+			}
+		}()
+	}
+	return d
+}
+
+// dispatch queues a message for its category's worker. auth is handled as a
+// barrier on the calling goroutine.
+func (d *dispatcher) dispatch(handler MessageHandler, msg *protocol.Message) {
+	if msg.Type == protocol.TypeAuth {
+		d.drain()
+		d.run(handler, msg)
+		return
+	}
+	d.queues[messageCategory(msg.Type)] <- func() { d.run(handler, msg) }
+}
+
+// drain blocks until every queued message has been handled
+func (d *dispatcher) drain() {
+	var pending sync.WaitGroup
+	for _, queue := range d.queues {
+		pending.Add(1)
+		queue <- pending.Done
+	}
+	pending.Wait()
+}
+
+// close stops accepting messages and waits for queued handlers to finish
+func (d *dispatcher) close() {
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.wg.Wait()
+}
+
+// run invokes a handler, recording its duration and reporting errors to the
+// client. A request the session's role doesn't permit is answered with
+// FORBIDDEN, and a payload that fails validation with VALIDATION_ERROR;
+// neither reaches the handler.
+func (d *dispatcher) run(handler MessageHandler, msg *protocol.Message) {
+	if reason := checkPermission(d.connSession, msg); reason != "" {
+		refuseForbidden(d.connSession, msg.Type, reason)
+		return
+	}
+	if _, fields := protocol.DecodePayload(msg); len(fields) > 0 {
+		log.Printf("[WARN] [WS] Invalid %s payload: %s", msg.Type, validationMessage(fields))
+		d.connSession.SendErrorDetails(protocol.ErrorValidation, protocol.ValidationErrorDetails{Type: msg.Type, Fields: fields})
+		return
+	}
+
+	start := time.Now()
+	err := handler(d.connSession, msg)
+	elapsed := time.Since(start)
+
+	slow := d.server.config.SlowHandlerThreshold > 0 && elapsed >= d.server.config.SlowHandlerThreshold
+	d.server.handlerStats.record(msg.Type, elapsed, slow)
+	if slow {
+		log.Printf("[WARN] [WS] Slow handler for %s took %s (session %s)",
+			msg.Type, elapsed.Round(time.Millisecond), d.connSession.ID)
+	}
+
+	if err != nil {
+		log.Printf("[ERROR] [WS] Handler error for %s: %v", msg.Type, err)
+		d.connSession.SendErrorDetails(protocol.ErrorHandlerError, protocol.ErrorDetails{"type": msg.Type, "reason": err.Error()})
+	}
+}
+
+// validationMessage summarizes failed fields for the log, e.g.
+// "cols must be at least 10; processId is required"
+func validationMessage(fields []protocol.FieldError) string {
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = strings.TrimSpace(f.Field + " " + f.Message)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// HandlerTiming summarizes handler durations for one message type
+type HandlerTiming struct {
+	Count     uint64  `json:"count"`
+	SlowCount uint64  `json:"slowCount"`
+	TotalMs   float64 `json:"totalMs"`
+	MaxMs     float64 `json:"maxMs"`
+}
+
+// handlerStats accumulates handler timings across all sessions
+type handlerStats struct {
+	mu      sync.Mutex
+	timings map[string]*HandlerTiming
+}
+
+func (h *handlerStats) record(msgType string, elapsed time.Duration, slow bool) {
+	ms := float64(elapsed) / float64(time.Millisecond)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.timings == nil {
+		h.timings = make(map[string]*HandlerTiming)
+	}
+	t, ok := h.timings[msgType]
+	if !ok {
+		t = &HandlerTiming{}
+		h.timings[msgType] = t
+	}
+	t.Count++
+	t.TotalMs += ms
+	if ms > t.MaxMs {
+		t.MaxMs = ms
+	}
+	if slow {
+		t.SlowCount++
+	}
+}
+
+func (h *handlerStats) snapshot() map[string]HandlerTiming {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make(map[string]HandlerTiming, len(h.timings))
+	for msgType, t := range h.timings {
+		out[msgType] = *t
+	}
+	return out
+}
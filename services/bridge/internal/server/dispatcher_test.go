@@ -0,0 +1,175 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+func TestMessageCategory(t *testing.T) {
+	tests := map[string]string{
+		protocol.TypePtyInput:          categoryInput,
+		protocol.TypePtyResize:         categoryInput,
+		protocol.TypePtyScroll:         categoryInput,
+		protocol.TypeHostConnect:       categoryHost,
+		protocol.TypePortsScan:         categoryHost,
+		protocol.TypePtyHistoryRequest: categoryDefault,
+		protocol.TypeProcessCreate:     categoryDefault,
+	}
+	for msgType, want := range tests {
+		if got := messageCategory(msgType); got != want {
+			t.Errorf("messageCategory(%s) = %s, want %s", msgType, got, want)
+		}
+	}
+}
+
+func TestKeystrokesFlowWhileSlowHandlerRuns(t *testing.T) {
+	config := DefaultConfig()
+	config.SlowHandlerThreshold = 50 * time.Millisecond
+	s := newTestServer(t, config)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	s.handlers[protocol.TypeHostConnect] = func(cs *ConnectedSession, msg *protocol.Message) error {
+		close(started)
+		<-release
+		return nil
+	}
+
+	var mu sync.Mutex
+	var received []string
+	inputDone := make(chan struct{})
+	const keystrokes = 50
+	s.handlers[protocol.TypePtyInput] = func(cs *ConnectedSession, msg *protocol.Message) error {
+		var payload protocol.PtyInputPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, payload.Data)
+		if len(received) == keystrokes {
+			close(inputDone)
+		}
+		return nil
+	}
+
+	conn, _ := connectTestClient(t, s)
+
+	connect, _ := protocol.NewMessage(protocol.TypeHostConnect, protocol.HostConnectPayload{HostID: "host-1"})
+	conn.WriteJSON(connect)
+	<-started
+
+	for i := 0; i < keystrokes; i++ {
+		input, _ := protocol.NewMessage(protocol.TypePtyInput, protocol.PtyInputPayload{
+			ProcessID: "proc-1",
+			Data:      fmt.Sprintf("%d", i),
+		})
+		conn.WriteJSON(input)
+	}
+
+	select {
+	case <-inputDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pty_input blocked behind slow host_connect")
+	}
+
+	mu.Lock()
+	for i, data := range received {
+		if data != fmt.Sprintf("%d", i) {
+			t.Fatalf("pty_input out of order at %d: %v", i, received)
+		}
+	}
+	mu.Unlock()
+
+	time.Sleep(config.SlowHandlerThreshold)
+	close(release)
+
+	// Timing is recorded once the slow handler returns
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		timing := s.handlerStats.snapshot()[protocol.TypeHostConnect]
+		if timing.Count == 1 {
+			if timing.SlowCount != 1 || timing.MaxMs < 50 {
+				t.Errorf("unexpected host_connect timing: %+v", timing)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("host_connect timing not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := s.handlerStats.snapshot()[protocol.TypePtyInput].Count; got != keystrokes {
+		t.Errorf("pty_input count = %d, want %d", got, keystrokes)
+	}
+}
+
+func TestInvalidPayloadNeverReachesHandler(t *testing.T) {
+	s := newQuietServer(t)
+	called := make(chan struct{}, 1)
+	s.handlers[protocol.TypePtyResize] = func(cs *ConnectedSession, msg *protocol.Message) error {
+		called <- struct{}{}
+		return nil
+	}
+	conn, _ := connectTestClient(t, s)
+
+	resize, _ := protocol.NewMessage(protocol.TypePtyResize, protocol.PtyResizePayload{ProcessID: "proc-1", Cols: 0, Rows: 24})
+	conn.WriteJSON(resize)
+
+	var errPayload struct {
+		Code    protocol.ErrorCode              `json:"code"`
+		Message string                          `json:"message"`
+		Details protocol.ValidationErrorDetails `json:"details"`
+	}
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorValidation || errPayload.Details.Type != protocol.TypePtyResize {
+		t.Fatalf("error = %+v", errPayload)
+	}
+	if len(errPayload.Details.Fields) != 1 || errPayload.Details.Fields[0].Field != "cols" || errPayload.Details.Fields[0].Rule != "required" {
+		t.Errorf("fields = %+v", errPayload.Details.Fields)
+	}
+	if errPayload.Message != "Invalid request" {
+		t.Errorf("message = %q", errPayload.Message)
+	}
+	select {
+	case <-called:
+		t.Error("handler ran for an invalid payload")
+	default:
+	}
+
+	// A valid resize gets through
+	resize, _ = protocol.NewMessage(protocol.TypePtyResize, protocol.PtyResizePayload{ProcessID: "proc-1", Cols: 80, Rows: 24})
+	conn.WriteJSON(resize)
+	select {
+	case <-called:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler not called for a valid payload")
+	}
+}
+
+// TestHandlersHavePayloadTypes catches a handler added without registering
+// its payload for validation
+func TestHandlersHavePayloadTypes(t *testing.T) {
+	noPayload := map[string]bool{
+		protocol.TypeHostConfigList:      true,
+		protocol.TypeSnippetList:         true,
+		protocol.TypeWorkspaceList:       true,
+		protocol.TypeProcessTemplateList: true,
+		protocol.TypeBridgeInfo:          true,
+		protocol.TypeSessionInfo:         true,
+		protocol.TypeBridgeUpdateCheck:   true,
+		protocol.TypeProfileList:         true,
+		protocol.TypeStorageEncryptNow:   true,
+	}
+	s := newQuietServer(t)
+	for msgType := range s.handlers {
+		if protocol.RequestPayload(msgType) == nil && !noPayload[msgType] {
+			t.Errorf("%s has a handler but no registered payload type", msgType)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/env"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/session"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+const testRcFile = "export EDITOR=vim\n" +
+	env.SectionStart + "\n" +
+	"export ANTHROPIC_API_KEY=sk-ant-real\n" +
+	"export PROJECT=demo\n" +
+	env.SectionEnd + "\n"
+
+// envTestHost connects host-1 to an SSH server that serves a fixed
+// environment and RC file, and records every command it runs
+func envTestHost(t *testing.T, s *Server) func() []string {
+	t.Helper()
+	var mu sync.Mutex
+	var commands []string
+
+	srv := startTestSSHServer(t)
+	srv.HandleExec(func(cmd string) string {
+		mu.Lock()
+		commands = append(commands, cmd)
+		mu.Unlock()
+		switch {
+		case cmd == "echo $SHELL":
+			return "/bin/bash\n"
+		case cmd == "env":
+			return "HOME=/home/user\nGITHUB_TOKEN=ghp_system\n"
+		case strings.HasPrefix(cmd, "cat "):
+			return testRcFile
+		}
+		return ""
+	})
+	if _, err := s.sshManager.Connect("host-1", "127.0.0.1", srv.Port(), "user",
+		ssh.AuthConfig{AuthType: "password", Password: "secret"}); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), commands...)
+	}
+}
+
+func findEnvVar(vars []protocol.EnvVar, key string) protocol.EnvVar {
+	for _, v := range vars {
+		if v.Key == key {
+			return v
+		}
+	}
+	return protocol.EnvVar{}
+}
+
+func TestEnvListMasksSecrets(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	envTestHost(t, s)
+	conn, cs := connectTestClient(t, s)
+	readPayload(t, conn, protocol.TypeHostStatus, nil)
+	readPayload(t, conn, protocol.TypeHostRequirementsResult, nil)
+
+	dispatch(t, s, cs, protocol.TypeEnvList, protocol.EnvListPayload{HostID: "host-1"})
+	var result protocol.EnvResultPayload
+	readPayload(t, conn, protocol.TypeEnvResult, &result)
+
+	if v := findEnvVar(result.SystemVars, "GITHUB_TOKEN"); !v.IsMasked || v.Value != env.MaskedValue {
+		t.Errorf("GITHUB_TOKEN = %+v, want masked", v)
+	}
+	if v := findEnvVar(result.SystemVars, "HOME"); v.IsMasked || v.Value != "/home/user" {
+		t.Errorf("HOME = %+v, want unmasked", v)
+	}
+	if v := findEnvVar(result.CustomVars, "ANTHROPIC_API_KEY"); !v.IsMasked || v.Value != env.MaskedValue {
+		t.Errorf("ANTHROPIC_API_KEY = %+v, want masked", v)
+	}
+
+	// Process env captured at spawn is masked the same way
+	s.processRegistry.Register(&process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeShell,
+		EnvVars: []process.EnvVar{{Key: "DB_PASSWORD", Value: "hunter2"}, {Key: "LANG", Value: "C"}}})
+	dispatch(t, s, cs, protocol.TypeProcessEnvList, protocol.ProcessEnvListPayload{ProcessID: "proc-1"})
+	var procEnv protocol.ProcessEnvResultPayload
+	readPayload(t, conn, protocol.TypeProcessEnvResult, &procEnv)
+	if v := findEnvVar(procEnv.Vars, "DB_PASSWORD"); !v.IsMasked || v.Value == "hunter2" {
+		t.Errorf("DB_PASSWORD = %+v, want masked", v)
+	}
+	if v := findEnvVar(procEnv.Vars, "LANG"); v.IsMasked || v.Value != "C" {
+		t.Errorf("LANG = %+v, want unmasked", v)
+	}
+}
+
+func TestProcessEnvDiff(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+
+	// The fake tmux doesn't run what is typed; the shell's capture is already
+	// there, as if the capture command had run
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	captureDir := filepath.Join(home, ".remote-claude", "tmp", "proc-1")
+	if err := os.MkdirAll(captureDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(captureDir, "env-current"),
+		[]byte("PATH=/opt/bin:/usr/bin\nGITHUB_TOKEN=ghp_new\nNODE_ENV=production\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ptySession := &pty.Session{ID: "proc-1", HostID: "host-1", TmuxName: pty.TmuxSessionName("proc-1")}
+	ptySession.UpdateSSHClient(s.sshManager.GetConnection("host-1").Client)
+	s.processRegistry.Register(&process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeShell, PTY: ptySession,
+		EnvVars: []process.EnvVar{{Key: "PATH", Value: "/usr/bin"}, {Key: "GITHUB_TOKEN", Value: "ghp_old"}, {Key: "OLDPWD", Value: "/"}}})
+
+	// Observers may only see the spawn snapshot: the capture types into the pane
+	mode := protocol.ProcessEnvModeDiff
+	cs.SetRole(session.RoleObserver, session.Scope{}, "")
+	dispatch(t, s, cs, protocol.TypeProcessEnvList, protocol.ProcessEnvListPayload{ProcessID: "proc-1", Mode: &mode})
+	readPayload(t, conn, protocol.TypeError, nil)
+	cs.SetRole(session.RoleOwner, session.Scope{}, "")
+
+	dispatch(t, s, cs, protocol.TypeProcessEnvList, protocol.ProcessEnvListPayload{ProcessID: "proc-1", Mode: &mode})
+	// The pane is repainted after the capture
+	readPayload(t, conn, protocol.TypePtySnapshot, nil)
+	var result protocol.ProcessEnvResultPayload
+	readPayload(t, conn, protocol.TypeProcessEnvResult, &result)
+	if result.Error != nil || result.Mode != protocol.ProcessEnvModeDiff || len(result.Vars) != 3 {
+		t.Fatalf("result = %+v", result)
+	}
+	if v := findEnvVar(result.Vars, "GITHUB_TOKEN"); !v.IsMasked || v.Value != env.MaskedValue {
+		t.Errorf("GITHUB_TOKEN = %+v, want masked", v)
+	}
+	want := &protocol.ProcessEnvDiff{
+		Added:   []protocol.EnvVar{{Key: "NODE_ENV", Value: "production"}},
+		Removed: []protocol.EnvVar{{Key: "OLDPWD", Value: "/"}},
+		Changed: []protocol.EnvVarChange{
+			{Key: "GITHUB_TOKEN", Old: env.MaskedValue, New: env.MaskedValue, IsMasked: true},
+			{Key: "PATH", Old: "/usr/bin", New: "/opt/bin:/usr/bin"},
+		},
+	}
+	if !reflect.DeepEqual(result.Diff, want) {
+		t.Errorf("diff = %+v, want %+v", result.Diff, want)
+	}
+
+	// Captured too recently to be captured again
+	mode = protocol.ProcessEnvModeCurrent
+	dispatch(t, s, cs, protocol.TypeProcessEnvList, protocol.ProcessEnvListPayload{ProcessID: "proc-1", Mode: &mode})
+	result = protocol.ProcessEnvResultPayload{}
+	readPayload(t, conn, protocol.TypeProcessEnvResult, &result)
+	if result.RetryAfterMs == nil || result.Error == nil || len(result.Vars) != 0 || result.Diff != nil {
+		t.Errorf("result = %+v, want refused by the rate limit", result)
+	}
+	expectNothingQueued(t, conn, cs)
+}
+
+func TestEnvReveal(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	envTestHost(t, s)
+	conn, cs := connectTestClient(t, s)
+	readPayload(t, conn, protocol.TypeHostStatus, nil)
+	readPayload(t, conn, protocol.TypeHostRequirementsResult, nil)
+
+	tests := []struct {
+		key   string
+		value string
+	}{
+		{"ANTHROPIC_API_KEY", "sk-ant-real"}, // RC file managed section
+		{"GITHUB_TOKEN", "ghp_system"},       // System environment
+	}
+	for _, tt := range tests {
+		dispatch(t, s, cs, protocol.TypeEnvReveal, protocol.EnvRevealPayload{HostID: "host-1", Key: tt.key})
+		var result protocol.EnvRevealResultPayload
+		readPayload(t, conn, protocol.TypeEnvRevealResult, &result)
+		if !result.Success || result.Value == nil || *result.Value != tt.value {
+			t.Errorf("reveal %s = %+v, want %q", tt.key, result, tt.value)
+		}
+	}
+
+	dispatch(t, s, cs, protocol.TypeEnvReveal, protocol.EnvRevealPayload{HostID: "host-1", Key: "MISSING_TOKEN"})
+	var missing protocol.EnvRevealResultPayload
+	readPayload(t, conn, protocol.TypeEnvRevealResult, &missing)
+	if missing.Success || missing.Value != nil || missing.Error == nil {
+		t.Errorf("reveal of unknown key = %+v, want error", missing)
+	}
+
+	dispatch(t, s, cs, protocol.TypeEnvReveal, protocol.EnvRevealPayload{HostID: "host-2", Key: "GITHUB_TOKEN"})
+	var disconnected protocol.EnvRevealResultPayload
+	readPayload(t, conn, protocol.TypeEnvRevealResult, &disconnected)
+	if disconnected.Success || disconnected.Error == nil {
+		t.Errorf("reveal on disconnected host = %+v, want error", disconnected)
+	}
+
+	if got := loggableMessage(protocol.TypeEnvRevealResult, []byte(`{"value":"sk-ant-real"}`)); strings.Contains(got, "sk-ant-real") {
+		t.Errorf("reveal result logged in the clear: %s", got)
+	}
+}
+
+// hasEnvVar reports whether values of type t can hold a protocol.EnvVar
+func hasEnvVar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		return hasEnvVar(t.Elem())
+	case reflect.Struct:
+		if t == reflect.TypeOf(protocol.EnvVar{}) {
+			return true
+		}
+		for i := 0; i < t.NumField(); i++ {
+			if hasEnvVar(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TestRedactedMessageTypes catches a request that sends env values being
+// added without keeping its payload out of the logs
+func TestRedactedMessageTypes(t *testing.T) {
+	s := newQuietServer(t)
+	for msgType := range s.handlers {
+		payload := protocol.RequestPayload(msgType)
+		if payload != nil && hasEnvVar(reflect.TypeOf(payload)) && !redactedMessageTypes[msgType] {
+			t.Errorf("%s carries env values but is logged in full", msgType)
+		}
+	}
+
+	msg, _ := protocol.NewMessage(protocol.TypeProcessTemplateCreate, protocol.ProcessTemplateCreatePayload{
+		Name: "api", Env: []protocol.EnvVar{{Key: "ANTHROPIC_API_KEY", Value: "sk-ant-real"}},
+	})
+	data, _ := json.Marshal(msg)
+	if got := loggableMessage(msg.Type, data); strings.Contains(got, "sk-ant-real") {
+		t.Errorf("template create logged in the clear: %s", got)
+	}
+}
+
+func TestDefaultConfigOwnsSecretPatterns(t *testing.T) {
+	config := DefaultConfig()
+	config.EnvSecretPatterns[0] = "CHANGED"
+	if env.DefaultSecretPatterns[0] == "CHANGED" {
+		t.Error("changing a config's secret patterns changed the defaults")
+	}
+}
+
+func TestEnvUpdateKeepsMaskedValues(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	commands := envTestHost(t, s)
+	conn, cs := connectTestClient(t, s)
+	readPayload(t, conn, protocol.TypeHostStatus, nil)
+	readPayload(t, conn, protocol.TypeHostRequirementsResult, nil)
+
+	// The client edits PROJECT and sends the masked key back untouched
+	dispatch(t, s, cs, protocol.TypeEnvUpdate, protocol.EnvUpdatePayload{
+		HostID: "host-1",
+		CustomVars: []protocol.EnvVar{
+			{Key: "ANTHROPIC_API_KEY", Value: env.MaskedValue, IsMasked: true},
+			{Key: "PROJECT", Value: "other"},
+		},
+	})
+	var result protocol.EnvResultPayload
+	readPayload(t, conn, protocol.TypeEnvResult, &result)
+	if result.Error != nil {
+		t.Fatalf("update failed: %s", *result.Error)
+	}
+	if v := findEnvVar(result.CustomVars, "ANTHROPIC_API_KEY"); !v.IsMasked || v.Value != env.MaskedValue {
+		t.Errorf("ANTHROPIC_API_KEY echoed as %+v, want masked", v)
+	}
+
+	var write string
+	for _, cmd := range commands() {
+		if strings.HasPrefix(cmd, "printf ") {
+			write = cmd
+		}
+	}
+	if !strings.Contains(write, `export ANTHROPIC_API_KEY="sk-ant-real"`) || !strings.Contains(write, `export PROJECT="other"`) {
+		t.Errorf("RC file write should keep the real secret, got: %s", write)
+	}
+
+	// A masked var with no current value cannot be written
+	dispatch(t, s, cs, protocol.TypeEnvUpdate, protocol.EnvUpdatePayload{
+		HostID:     "host-1",
+		CustomVars: []protocol.EnvVar{{Key: "NEW_TOKEN", Value: env.MaskedValue, IsMasked: true}},
+	})
+	var rejected protocol.EnvResultPayload
+	readPayload(t, conn, protocol.TypeEnvResult, &rejected)
+	if rejected.Error == nil {
+		t.Error("expected masked var without a current value to be rejected")
+	}
+}
+
+func TestEnvUpdateDryRun(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	commands := envTestHost(t, s)
+	conn, cs := connectTestClient(t, s)
+	readPayload(t, conn, protocol.TypeHostStatus, nil)
+	readPayload(t, conn, protocol.TypeHostRequirementsResult, nil)
+
+	update := protocol.EnvUpdatePayload{
+		HostID: "host-1",
+		CustomVars: []protocol.EnvVar{
+			{Key: "ANTHROPIC_API_KEY", Value: env.MaskedValue, IsMasked: true},
+			{Key: "PROJECT", Value: "other"},
+		},
+		DryRun: true,
+	}
+	dispatch(t, s, cs, protocol.TypeEnvUpdate, update)
+	var preview protocol.EnvResultPayload
+	readPayload(t, conn, protocol.TypeEnvResult, &preview)
+	if preview.Error != nil || !preview.DryRun || preview.Diff == nil || preview.ManagedSection == nil {
+		t.Fatalf("dry run = %+v", preview)
+	}
+	for _, cmd := range commands() {
+		if strings.HasPrefix(cmd, "printf ") {
+			t.Fatalf("dry run wrote the RC file: %s", cmd)
+		}
+	}
+	if !strings.Contains(*preview.Diff, "-export PROJECT=\"demo\"\n+export PROJECT=\"other\"\n") {
+		t.Errorf("diff:\n%s", *preview.Diff)
+	}
+	if strings.Contains(*preview.Diff+*preview.ManagedSection, "sk-ant-real") {
+		t.Errorf("preview shows the secret:\n%s", *preview.Diff)
+	}
+
+	// The real write puts down the section the dry run showed, bar the secret
+	update.DryRun = false
+	dispatch(t, s, cs, protocol.TypeEnvUpdate, update)
+	var result protocol.EnvResultPayload
+	readPayload(t, conn, protocol.TypeEnvResult, &result)
+	if result.Error != nil || result.DryRun || result.Diff != nil {
+		t.Fatalf("update = %+v", result)
+	}
+	var write string
+	for _, cmd := range commands() {
+		if strings.HasPrefix(cmd, "printf ") {
+			write = cmd
+		}
+	}
+	want := strings.Replace(*preview.ManagedSection, env.MaskedValue, "sk-ant-real", 1)
+	if !strings.Contains(write, "'export EDITOR=vim\n"+want+"'") {
+		t.Errorf("write:\n%s\nwant the previewed section:\n%s", write, want)
+	}
+}
+
+func TestEnvUpdateConflicts(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	commands := envTestHost(t, s)
+	conn, cs := connectTestClient(t, s)
+	readPayload(t, conn, protocol.TypeHostStatus, nil)
+	readPayload(t, conn, protocol.TypeHostRequirementsResult, nil)
+
+	update := func(payload protocol.EnvUpdatePayload) (protocol.EnvResultPayload, bool) {
+		t.Helper()
+		before := len(commands())
+		payload.HostID = "host-1"
+		dispatch(t, s, cs, protocol.TypeEnvUpdate, payload)
+		var result protocol.EnvResultPayload
+		readPayload(t, conn, protocol.TypeEnvResult, &result)
+		for _, cmd := range commands()[before:] {
+			if strings.HasPrefix(cmd, "printf ") {
+				return result, true
+			}
+		}
+		return result, false
+	}
+	token := []protocol.EnvVar{{Key: "PROJECT", Value: "demo"}, {Key: "GITHUB_TOKEN", Value: "ghp_mine"}}
+
+	// Shadowing a system var is refused, showing what would be shadowed
+	result, written := update(protocol.EnvUpdatePayload{CustomVars: token})
+	wantConflict := protocol.EnvConflict{Key: "GITHUB_TOKEN", SystemValue: strPtr(env.MaskedValue), IsMasked: true}
+	if written || result.Error == nil || !reflect.DeepEqual(result.Conflicts, []protocol.EnvConflict{wantConflict}) {
+		t.Fatalf("unallowed override: written %v, result %+v", written, result)
+	}
+
+	// A dry run previews it anyway
+	result, written = update(protocol.EnvUpdatePayload{CustomVars: token, DryRun: true})
+	if written || result.Error != nil || result.Diff == nil || len(result.Conflicts) != 1 || result.Conflicts[0].Allowed {
+		t.Fatalf("dry run: written %v, result %+v", written, result)
+	}
+
+	// Allowing the key lets it through
+	result, written = update(protocol.EnvUpdatePayload{CustomVars: token, AllowOverride: []string{"GITHUB_TOKEN"}})
+	wantConflict.Allowed = true
+	if !written || result.Error != nil || !reflect.DeepEqual(result.Conflicts, []protocol.EnvConflict{wantConflict}) {
+		t.Fatalf("allowed override: written %v, result %+v", written, result)
+	}
+
+	// A critical key needs force, whatever allowOverride says
+	home := []protocol.EnvVar{{Key: "HOME", Value: "/srv/user"}}
+	result, written = update(protocol.EnvUpdatePayload{CustomVars: home, AllowOverride: []string{"HOME"}})
+	if written || result.Error == nil || !strings.Contains(*result.Error, "HOME") {
+		t.Fatalf("critical key without force: written %v, result %+v", written, result)
+	}
+	result, written = update(protocol.EnvUpdatePayload{CustomVars: home, Force: true})
+	wantHome := protocol.EnvConflict{Key: "HOME", SystemValue: strPtr("/home/user"), Critical: true, Allowed: true}
+	if !written || result.Error != nil || !reflect.DeepEqual(result.Conflicts, []protocol.EnvConflict{wantHome}) {
+		t.Fatalf("forced critical key: written %v, result %+v", written, result)
+	}
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/i18n"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// panicOnUnknownErrorCode makes sending a code outside protocol.ErrorCodes
+// panic for the rest of the test
+func panicOnUnknownErrorCode(t *testing.T) {
+	t.Helper()
+	previous := unknownErrorCode
+	unknownErrorCode = func(code protocol.ErrorCode) {
+		panic(fmt.Sprintf("unknown error code %q", code))
+	}
+	t.Cleanup(func() { unknownErrorCode = previous })
+}
+
+func TestHandlersOnlySendKnownErrorCodes(t *testing.T) {
+	panicOnUnknownErrorCode(t)
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+
+	// Collect every error the client receives while the handlers run
+	var received []protocol.ErrorCode
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var msg protocol.Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type != protocol.TypeError {
+				continue
+			}
+			var payload protocol.ErrorPayload
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil || errorReason(payload) == "handlers done" {
+				return
+			}
+			received = append(received, payload.Code)
+		}
+	}()
+
+	// A shell without a PTY and a Claude process without AgentAPI on a host
+	// that isn't connected, so handlers reach their error paths
+	s.processRegistry.Register(&process.Process{ID: "proc-shell", HostID: "host-1", Type: process.TypeShell})
+	s.processRegistry.Register(&process.Process{ID: "proc-claude", HostID: "host-1", Type: process.TypeClaude})
+	payloads := []string{
+		`{}`,
+		`"not an object"`,
+		`{"hostId":"host-1","processId":"missing","id":"missing"}`,
+		`{"hostId":"host-1","processId":"proc-shell","data":"x","cols":80,"rows":24}`,
+		`{"hostId":"host-1","processId":"proc-claude","content":"hi"}`,
+		`{"hostId":"host-1","processId":"proc-shell","claudeArgs":"--model 'unterminated"}`,
+	}
+
+	types := make([]string, 0, len(s.handlers))
+	for msgType := range s.handlers {
+		types = append(types, msgType)
+	}
+	sort.Strings(types)
+	d := newDispatcher(s, cs)
+	defer d.close()
+	for _, msgType := range types {
+		handler := s.handlers[msgType]
+		for _, payload := range payloads {
+			msg := &protocol.Message{Type: msgType, Payload: json.RawMessage(payload)}
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("%s with %s: %v", msgType, payload, r)
+					}
+				}()
+				d.run(handler, msg)
+			}()
+		}
+	}
+
+	if err := cs.SendErrorDetails(protocol.ErrorHandlerError, protocol.ErrorDetails{"reason": "handlers done"}); err != nil {
+		t.Fatalf("SendErrorDetails: %v", err)
+	}
+	<-done
+	if len(received) == 0 {
+		t.Fatal("no errors received; the handlers weren't exercised")
+	}
+	for _, code := range received {
+		if !code.Valid() {
+			t.Errorf("client received unknown error code %q", code)
+		}
+	}
+}
+
+// TestErrorCodesAreConstants checks every call that sends an error passes a
+// protocol.Error* constant, since a string literal converts to ErrorCode
+// silently and may only be sent on a path no test reaches
+func TestErrorCodesAreConstants(t *testing.T) {
+	// Position of the code argument in each function that sends an error
+	codeArg := map[string]int{"SendError": 0, "SendErrorDetails": 0, "writeRESTError": 3, "newFailure": 0, "failureCode": 1}
+
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatalf("parse %s: %v", file, err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			// The senders and newFailure pass their code parameter along, as
+			// sendFailure does a requestFailure's
+			if fn, ok := n.(*ast.FuncDecl); ok {
+				_, sender := codeArg[fn.Name.Name]
+				return !sender && fn.Name.Name != "sendFailure"
+			}
+			if lit, ok := n.(*ast.CompositeLit); ok {
+				if typ, ok := lit.Type.(*ast.Ident); ok && typ.Name == "requestFailure" && len(lit.Elts) > 0 && !isErrorConstant(lit.Elts[0]) {
+					t.Errorf("%s: requestFailure has a code that isn't a protocol.Error* constant", fset.Position(lit.Pos()))
+				}
+				return true
+			}
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			var name string
+			switch fun := call.Fun.(type) {
+			case *ast.Ident:
+				name = fun.Name
+			case *ast.SelectorExpr:
+				name = fun.Sel.Name
+			}
+			i, ok := codeArg[name]
+			if !ok || i >= len(call.Args) {
+				return true
+			}
+			if !isErrorConstant(call.Args[i]) {
+				t.Errorf("%s: %s sends a code that isn't a protocol.Error* constant", fset.Position(call.Pos()), name)
+			}
+			return true
+		})
+	}
+}
+
+// errorReason returns the "reason" in an error's details
+func errorReason(payload protocol.ErrorPayload) string {
+	details, _ := payload.Details.(map[string]interface{})
+	reason, _ := details["reason"].(string)
+	return reason
+}
+
+// isErrorConstant reports whether expr is protocol.Error<Something>
+func isErrorConstant(expr ast.Expr) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	return ok && pkg.Name == "protocol" && strings.HasPrefix(sel.Sel.Name, "Error")
+}
+
+// useTestCatalog replaces a server's message catalog with one that has a
+// Portuguese message for NOT_FOUND
+func useTestCatalog(t *testing.T, s *Server) {
+	t.Helper()
+	catalog, err := i18n.Load(fstest.MapFS{
+		"catalog/en.json": {Data: []byte(`{"NOT_FOUND": "Not found", "NO_PTY": "Process has no terminal"}`)},
+		"catalog/pt.json": {Data: []byte(`{"NOT_FOUND": "Não encontrado"}`)},
+	})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	s.catalog = catalog
+}
+
+func TestErrorMessagesFollowLocale(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	useTestCatalog(t, s)
+	conn, cs := connectTestClient(t, s)
+
+	// Without a locale messages are in English, with the particulars in
+	// the details
+	cs.sendProcessNotFound("proc-1")
+	var errPayload protocol.ErrorPayload
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	details, _ := errPayload.Details.(map[string]interface{})
+	if errPayload.Message != "Not found" || details["processId"] != "proc-1" {
+		t.Errorf("error = %+v", errPayload)
+	}
+
+	locale := "pt-BR"
+	authAgain(t, s, cs, protocol.AuthPayload{ProtocolVersion: &protocolVersion, Locale: &locale})
+	var result protocol.AuthResultPayload
+	readPayload(t, conn, protocol.TypeAuthResult, &result)
+	if result.Locale != "pt" {
+		t.Errorf("auth result locale = %q, want pt", result.Locale)
+	}
+
+	// Codes the locale has no message for stay in English
+	cs.sendProcessNotFound("proc-1")
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Message != "Não encontrado" {
+		t.Errorf("localized message = %q", errPayload.Message)
+	}
+	cs.SendErrorDetails(protocol.ErrorNoPty, protocol.ErrorDetails{"processId": "proc-1"})
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Message != "Process has no terminal" {
+		t.Errorf("fallback message = %q", errPayload.Message)
+	}
+}
+
+func TestRESTErrorMessagesFollowAcceptLanguage(t *testing.T) {
+	s := newRESTTestServer(t)
+	useTestCatalog(t, s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/processes/missing", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Accept-Language", "pt-BR,pt;q=0.9,en;q=0.8")
+	rec := httptest.NewRecorder()
+	s.restHandler().ServeHTTP(rec, req)
+
+	var errPayload protocol.ErrorPayload
+	if err := json.Unmarshal(rec.Body.Bytes(), &errPayload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if errPayload.Code != protocol.ErrorNotFound || errPayload.Message != "Não encontrado" || errorReason(errPayload) != "process not found" {
+		t.Errorf("error = %+v", errPayload)
+	}
+}
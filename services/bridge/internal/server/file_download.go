@@ -0,0 +1,434 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/shellargs"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// ============================================================================
+// File Downloads
+// ============================================================================
+//
+// file_download gets a file the terminal mentions, such as a build's
+// ./dist/app.apk, to the phone without scp. One command over the host's SSH
+// connection resolves the path (against a process's CWD when it is
+// relative, following symbolic links) and checks the file can be read and
+// isn't over Config.FileDownloadMaxSize; a second one cats it, and the
+// output is sent as it arrives in file_download_chunk messages. The
+// completion carries a SHA-256 of what was sent.
+
+// fileDownloadChunkSize is the size of file_download_chunk data before base64
+const fileDownloadChunkSize = 64 << 10
+
+// fileProbeTimeout bounds resolving and checking a file to download
+const fileProbeTimeout = 15 * time.Second
+
+var (
+	errDownloadCancelled = errors.New("download cancelled")
+	errDownloadGrew      = errors.New("file grew past the download size limit")
+)
+
+// fileTransferKey names a download or upload: their IDs are chosen by
+// clients, so they are only unique within a session
+type fileTransferKey struct {
+	sessionID string
+	id        string
+}
+
+// fileDownloads are the downloads running on the bridge, to cancel
+type fileDownloads struct {
+	mu      sync.Mutex
+	running map[fileTransferKey]context.CancelCauseFunc
+}
+
+// start records a download and returns its context, or false if the
+// session already runs one with the ID
+func (d *fileDownloads) start(key fileTransferKey) (context.Context, context.CancelCauseFunc, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.running[key]; ok {
+		return nil, nil, false
+	}
+	if d.running == nil {
+		d.running = make(map[fileTransferKey]context.CancelCauseFunc)
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	d.running[key] = cancel
+	return ctx, cancel, true
+}
+
+// finish forgets a download that ended
+func (d *fileDownloads) finish(key fileTransferKey) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.running, key)
+}
+
+// cancel stops a download, reporting false if it isn't running
+func (d *fileDownloads) cancel(key fileTransferKey) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cancel, ok := d.running[key]
+	if ok {
+		cancel(errDownloadCancelled)
+	}
+	return ok
+}
+
+// cancelSession stops every download of a session, whose connection closed
+func (d *fileDownloads) cancelSession(sessionID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, cancel := range d.running {
+		if key.sessionID == sessionID {
+			cancel(errDownloadCancelled)
+		}
+	}
+}
+
+// fileProbe is what probing a file to download found
+type fileProbe struct {
+	status       string // ok, missing, denied or notfile
+	symlink      bool
+	size         int64
+	resolvedPath string
+}
+
+// fileProbeScript resolves p on the host and prints
+// "<status> <symlink 0|1> [size]" and, once it exists, the resolved path on
+// the next line. A file behind a directory the user can't search is denied
+// rather than missing.
+func fileProbeScript(p string) string {
+	return `f=` + shellargs.Quote(p) + `
+if [ -L "$f" ]; then l=1; else l=0; fi
+if [ ! -e "$f" ]; then
+	d=$(dirname "$f")
+	if [ -d "$d" ] && [ ! -x "$d" ]; then echo "denied $l"; else echo "missing $l"; fi
+	exit 0
+fi
+r=$(readlink -f "$f" 2>/dev/null || realpath "$f" 2>/dev/null)
+if [ -z "$r" ]; then case $f in /*) r=$f ;; *) r=$(pwd -P)/${f#./} ;; esac; fi
+if [ ! -f "$r" ]; then echo "notfile $l"
+elif [ ! -r "$r" ]; then echo "denied $l"
+else echo "ok $l $(wc -c < "$r" | tr -d ' ')"
+fi
+printf '%s\n' "$r"`
+}
+
+// parseFileProbe parses the output of fileProbeScript
+func parseFileProbe(output string) (fileProbe, error) {
+	line, rest, _ := strings.Cut(output, "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return fileProbe{}, fmt.Errorf("unexpected probe output %q", output)
+	}
+	probe := fileProbe{
+		status:       fields[0],
+		symlink:      fields[1] == "1",
+		resolvedPath: strings.TrimSuffix(rest, "\n"),
+	}
+	switch probe.status {
+	case "ok":
+		if len(fields) != 3 {
+			return fileProbe{}, fmt.Errorf("unexpected probe output %q", output)
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fileProbe{}, fmt.Errorf("unexpected file size %q", fields[2])
+		}
+		probe.size = size
+	case "missing", "denied", "notfile":
+	default:
+		return fileProbe{}, fmt.Errorf("unexpected probe output %q", output)
+	}
+	return probe, nil
+}
+
+// chunkSender sends what is written to it as file_download_chunk messages
+// of fileDownloadChunkSize, hashing it on the way. When the file passes the
+// size limit or a chunk can't be sent it cancels the download and refuses
+// further writes.
+type chunkSender struct {
+	connSession *ConnectedSession
+	downloadID  string
+	totalSize   int64
+	limit       int64
+	cancel      context.CancelCauseFunc
+
+	buf    []byte
+	hash   hash.Hash
+	sent   int64
+	chunks int
+	err    error
+}
+
+func (w *chunkSender) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.sent+int64(len(w.buf)+len(p)) > w.limit {
+		w.fail(errDownloadGrew)
+		return 0, w.err
+	}
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= fileDownloadChunkSize {
+		if err := w.send(w.buf[:fileDownloadChunkSize]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[fileDownloadChunkSize:]
+	}
+	return len(p), nil
+}
+
+// flush sends what is left of the file
+func (w *chunkSender) flush() error {
+	if w.err != nil || len(w.buf) == 0 {
+		return w.err
+	}
+	err := w.send(w.buf)
+	w.buf = nil
+	return err
+}
+
+func (w *chunkSender) send(data []byte) error {
+	msg, err := protocol.NewMessage(protocol.TypeFileDownloadChunk, protocol.FileDownloadChunkPayload{
+		DownloadID: w.downloadID,
+		Seq:        w.chunks,
+		Data:       base64.StdEncoding.EncodeToString(data),
+		TotalSize:  w.totalSize,
+	})
+	if err == nil {
+		// At background priority, like history chunks, so the terminal
+		// stays live while a big file goes through
+		err = w.connSession.sendBackground(msg)
+	}
+	if err != nil {
+		w.fail(fmt.Errorf("failed to send chunk %d: %w", w.chunks, err))
+		return w.err
+	}
+	w.hash.Write(data)
+	w.sent += int64(len(data))
+	w.chunks++
+	return nil
+}
+
+func (w *chunkSender) fail(err error) {
+	w.err = err
+	w.cancel(err)
+}
+
+// handleFileDownload streams a file from a host. Like host_exec it runs in
+// the background, so a slow host doesn't hold up the session's other
+// messages.
+func (s *Server) handleFileDownload(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.FileDownloadPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	conn := s.sshManager.GetConnection(payload.HostID)
+	if conn == nil {
+		return connSession.SendErrorDetails(protocol.ErrorNotConnected,
+			protocol.ErrorDetails{"downloadId": payload.DownloadID, "hostId": payload.HostID})
+	}
+
+	var proc *process.Process
+	if payload.ProcessID != nil {
+		proc = s.processRegistry.Get(*payload.ProcessID)
+		if proc == nil || proc.HostID != payload.HostID {
+			return connSession.SendErrorDetails(protocol.ErrorNotFound,
+				protocol.ErrorDetails{"downloadId": payload.DownloadID, "processId": *payload.ProcessID})
+		}
+	}
+
+	key := fileTransferKey{connSession.ID, payload.DownloadID}
+	ctx, cancel, ok := s.downloads.start(key)
+	if !ok {
+		return connSession.SendErrorDetails(protocol.ErrorAlreadyExists,
+			protocol.ErrorDetails{"downloadId": payload.DownloadID, "reason": "a download with this ID is running"})
+	}
+
+	go func() {
+		defer cancel(nil)
+		if err := s.runFileDownload(ctx, key, cancel, connSession, conn, proc, payload); err != nil {
+			log.Printf("[ERROR] [DOWNLOAD] Failed to send download %s to session %s: %v", payload.DownloadID, connSession.ID, err)
+		}
+	}()
+	return nil
+}
+
+// transferDir is the directory a relative download or upload path resolves
+// against: the process's as it is now, since relative paths are the ones
+// its terminal printed, or the login directory
+func transferDir(proc *process.Process) string {
+	if proc == nil {
+		return ""
+	}
+	if proc.PTY != nil {
+		if cwd, err := proc.PTY.RefreshCWD(); err == nil {
+			return cwd
+		}
+	}
+	return proc.GetCWD()
+}
+
+// probeDownload resolves and checks a file to download
+func (s *Server) probeDownload(ctx context.Context, conn *ssh.Connection, filePath, dir string) (fileProbe, error) {
+	ctx, cancel := context.WithTimeout(ctx, fileProbeTimeout)
+	defer cancel()
+	var stdout bytes.Buffer
+	stderr := &cappedBuffer{max: 4 << 10}
+	exitCode, err := s.hostExec(ctx, conn, ssh.ExecRequest{Command: fileProbeScript(filePath), Dir: dir, Stdout: &stdout, Stderr: stderr})
+	if err != nil {
+		return fileProbe{}, err
+	}
+	if exitCode != 0 {
+		// Only the cd into dir fails the script
+		return fileProbe{}, fmt.Errorf("exit %d: %s", exitCode, strings.TrimSpace(stderr.buf.String()))
+	}
+	return parseFileProbe(stdout.String())
+}
+
+// checkDownloadable refuses a file that is missing, unreadable, not a
+// regular file or too big
+func (s *Server) checkDownloadable(payload protocol.FileDownloadPayload, probe fileProbe) error {
+	details := protocol.ErrorDetails{"downloadId": payload.DownloadID, "hostId": payload.HostID, "path": payload.Path}
+	if probe.resolvedPath != "" {
+		details["resolvedPath"] = probe.resolvedPath
+	}
+	switch probe.status {
+	case "missing":
+		if probe.symlink {
+			details["reason"] = "symbolic link to a file that doesn't exist"
+		}
+		return &requestFailure{protocol.ErrorFileNotFound, details}
+	case "denied":
+		details["reason"] = "not readable by the host user"
+		return &requestFailure{protocol.ErrorPermissionDenied, details}
+	case "notfile":
+		details["reason"] = "directories and special files can't be downloaded"
+		return &requestFailure{protocol.ErrorNotAFile, details}
+	}
+	if limit := s.config.FileDownloadMaxSize; probe.size > limit {
+		details["size"] = probe.size
+		details["limit"] = limit
+		details["reason"] = fmt.Sprintf("%d bytes is over the %d byte limit", probe.size, limit)
+		return &requestFailure{protocol.ErrorFileTooLarge, details}
+	}
+	return nil
+}
+
+// runFileDownload checks a file and streams it, then sends
+// file_download_complete, or refuses the download with an error. The
+// download's ID is released before either is sent, so the client can reuse
+// it as soon as it hears back.
+func (s *Server) runFileDownload(ctx context.Context, key fileTransferKey, cancel context.CancelCauseFunc, connSession *ConnectedSession, conn *ssh.Connection, proc *process.Process, payload protocol.FileDownloadPayload) error {
+	filePath := payload.Path
+	if !path.IsAbs(filePath) {
+		// Keeps a name starting with - from reading as an option
+		filePath = "./" + filePath
+	}
+	probe, err := s.probeDownload(ctx, conn, filePath, transferDir(proc))
+	if err == nil {
+		err = s.checkDownloadable(payload, probe)
+	}
+	var failure *requestFailure
+	switch {
+	case errors.As(err, &failure):
+		s.downloads.finish(key)
+		return connSession.sendFailure(failure)
+	case err != nil:
+		s.downloads.finish(key)
+		log.Printf("[ERROR] [DOWNLOAD] Failed to check %s on host %s: %v", payload.Path, payload.HostID, err)
+		return connSession.SendErrorDetails(protocol.ErrorExecFailed,
+			protocol.ErrorDetails{"downloadId": payload.DownloadID, "hostId": payload.HostID, "reason": err.Error()})
+	}
+
+	log.Printf("[INFO] [DOWNLOAD] Session %s downloading %s from host %s (%d bytes)", connSession.ID, probe.resolvedPath, payload.HostID, probe.size)
+
+	start := time.Now()
+	sender := &chunkSender{
+		connSession: connSession,
+		downloadID:  payload.DownloadID,
+		totalSize:   probe.size,
+		limit:       s.config.FileDownloadMaxSize,
+		cancel:      cancel,
+		hash:        sha256.New(),
+	}
+	stderr := &cappedBuffer{max: 4 << 10}
+	exitCode, err := s.hostExec(ctx, conn, ssh.ExecRequest{
+		Command: "cat " + shellargs.Quote(probe.resolvedPath),
+		Stdout:  sender,
+		Stderr:  stderr,
+	})
+	if err == nil && exitCode != 0 {
+		err = fmt.Errorf("cat exited %d: %s", exitCode, strings.TrimSpace(stderr.buf.String()))
+	}
+	if err == nil {
+		err = sender.flush()
+	}
+	if cause := context.Cause(ctx); cause != nil {
+		err = cause
+	}
+
+	result := protocol.FileDownloadCompletePayload{
+		DownloadID:   payload.DownloadID,
+		HostID:       payload.HostID,
+		Path:         payload.Path,
+		ResolvedPath: probe.resolvedPath,
+		Symlink:      probe.symlink,
+		Size:         sender.sent,
+		Chunks:       sender.chunks,
+	}
+	switch {
+	case errors.Is(err, errDownloadCancelled):
+		result.Cancelled = true
+		log.Printf("[INFO] [DOWNLOAD] Download %s of %s cancelled after %d bytes", payload.DownloadID, probe.resolvedPath, sender.sent)
+	case err != nil:
+		result.Code = failureCode(err, protocol.ErrorExecFailed)
+		result.Error = strPtr(err.Error())
+		log.Printf("[WARN] [DOWNLOAD] Download %s of %s failed after %d bytes: %v", payload.DownloadID, probe.resolvedPath, sender.sent, err)
+	default:
+		result.Success = true
+		result.SHA256 = hex.EncodeToString(sender.hash.Sum(nil))
+		log.Printf("[INFO] [DOWNLOAD] Sent %s (%d bytes in %d chunks) in %s", probe.resolvedPath, sender.sent, sender.chunks, time.Since(start).Round(time.Millisecond))
+	}
+
+	s.downloads.finish(key)
+	response, err := protocol.NewMessage(protocol.TypeFileDownloadComplete, result)
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
+
+// handleFileDownloadCancel stops one of the session's downloads. A download
+// that already finished is not an error: its completion crossed the cancel.
+func (s *Server) handleFileDownloadCancel(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.FileDownloadCancelPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+	if !s.downloads.cancel(fileTransferKey{connSession.ID, payload.DownloadID}) {
+		log.Printf("[DEBUG] [DOWNLOAD] Session %s cancelled download %s, which isn't running", connSession.ID, payload.DownloadID)
+	}
+	return nil
+}
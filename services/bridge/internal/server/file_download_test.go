@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// readDownload reads a download's chunks, checking their order, until its
+// file_download_complete, and returns the reassembled file
+func readDownload(t *testing.T, conn *websocket.Conn) ([]byte, protocol.FileDownloadCompletePayload) {
+	t.Helper()
+	var file bytes.Buffer
+	for seq := 0; ; seq++ {
+		var msg protocol.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("ReadJSON: %v", err)
+		}
+		switch msg.Type {
+		case protocol.TypeFileDownloadComplete:
+			var complete protocol.FileDownloadCompletePayload
+			json.Unmarshal(msg.Payload, &complete)
+			return file.Bytes(), complete
+		case protocol.TypeFileDownloadChunk:
+			var chunk protocol.FileDownloadChunkPayload
+			json.Unmarshal(msg.Payload, &chunk)
+			if chunk.Seq != seq || chunk.DownloadID != "dl-1" {
+				t.Fatalf("chunk %d of %s, want %d of dl-1", chunk.Seq, chunk.DownloadID, seq)
+			}
+			data, err := base64.StdEncoding.DecodeString(chunk.Data)
+			if err != nil {
+				t.Fatalf("chunk %d: %v", seq, err)
+			}
+			file.Write(data)
+		default:
+			t.Fatalf("unexpected %s: %s", msg.Type, msg.Payload)
+		}
+	}
+}
+
+// downloadRefusal is the error a download is refused with
+type downloadRefusal struct {
+	Code    protocol.ErrorCode    `json:"code"`
+	Details protocol.ErrorDetails `json:"details"`
+}
+
+func readRefusal(t *testing.T, conn *websocket.Conn) downloadRefusal {
+	t.Helper()
+	var refused downloadRefusal
+	readPayload(t, conn, protocol.TypeError, &refused)
+	return refused
+}
+
+func TestFileDownload(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAKE_TMUX_CWD", t.TempDir())
+	config := DefaultConfig()
+	config.CWDRefreshInterval = 0
+	config.FileDownloadMaxSize = 1 << 20
+	s := newTestServer(t, config)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 1)
+	setPaneCWD(t, "rc-proc-0", dir)
+
+	// Three full chunks and a partial one, behind a symlink
+	fixture := make([]byte, 3*fileDownloadChunkSize+1234)
+	rand.Read(fixture)
+	if err := os.WriteFile(filepath.Join(dir, "app.apk"), fixture, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("app.apk", filepath.Join(dir, "latest.apk")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A relative path resolves against the process's directory
+	processID := "proc-0"
+	dispatch(t, s, cs, protocol.TypeFileDownload, protocol.FileDownloadPayload{
+		DownloadID: "dl-1", HostID: "host-1", Path: "latest.apk", ProcessID: &processID})
+	file, complete := readDownload(t, conn)
+	if !bytes.Equal(file, fixture) {
+		t.Fatalf("reassembled %d bytes, want the %d byte fixture", len(file), len(fixture))
+	}
+	sum := sha256.Sum256(file)
+	if !complete.Success || complete.SHA256 != hex.EncodeToString(sum[:]) || complete.Size != int64(len(fixture)) || complete.Chunks != 4 {
+		t.Errorf("complete = %+v", complete)
+	}
+	if !complete.Symlink || complete.ResolvedPath != filepath.Join(dir, "app.apk") || complete.Path != "latest.apk" {
+		t.Errorf("resolved %q (symlink %v), want the link followed", complete.ResolvedPath, complete.Symlink)
+	}
+
+	// An empty file is one without chunks
+	os.WriteFile(filepath.Join(dir, "empty"), nil, 0644)
+	dispatch(t, s, cs, protocol.TypeFileDownload, protocol.FileDownloadPayload{
+		DownloadID: "dl-1", HostID: "host-1", Path: filepath.Join(dir, "empty")})
+	file, complete = readDownload(t, conn)
+	if sum := sha256.Sum256(nil); len(file) != 0 || !complete.Success || complete.Symlink || complete.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("empty file: %d bytes, %+v", len(file), complete)
+	}
+
+	// Files that can't be sent are refused up front
+	os.Symlink("nowhere", filepath.Join(dir, "dangling"))
+	os.WriteFile(filepath.Join(dir, "huge"), make([]byte, config.FileDownloadMaxSize+1), 0644)
+	for _, tc := range []struct {
+		path string
+		code protocol.ErrorCode
+	}{
+		{"missing.apk", protocol.ErrorFileNotFound},
+		{"dangling", protocol.ErrorFileNotFound},
+		{".", protocol.ErrorNotAFile},
+		{"huge", protocol.ErrorFileTooLarge},
+	} {
+		dispatch(t, s, cs, protocol.TypeFileDownload, protocol.FileDownloadPayload{
+			DownloadID: "dl-1", HostID: "host-1", Path: tc.path, ProcessID: &processID})
+		refused := readRefusal(t, conn)
+		if refused.Code != tc.code || refused.Details["downloadId"] != "dl-1" || refused.Details["path"] != tc.path {
+			t.Errorf("%s: %s %v, want %s", tc.path, refused.Code, refused.Details, tc.code)
+		}
+	}
+}
+
+func TestFileDownloadPermissionDenied(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	os.WriteFile(secret, []byte("key"), 0)
+
+	// Root reads whatever the mode says, so answer for a user who can't
+	exec := s.hostExec
+	s.hostExec = func(ctx context.Context, conn *ssh.Connection, req ssh.ExecRequest) (int, error) {
+		if os.Geteuid() == 0 && strings.Contains(req.Command, "readlink") {
+			io.WriteString(req.Stdout, "denied 0\n"+secret+"\n")
+			return 0, nil
+		}
+		return exec(ctx, conn, req)
+	}
+
+	dispatch(t, s, cs, protocol.TypeFileDownload, protocol.FileDownloadPayload{DownloadID: "dl-1", HostID: "host-1", Path: secret})
+	refused := readRefusal(t, conn)
+	if refused.Code != protocol.ErrorPermissionDenied || refused.Details["resolvedPath"] != secret {
+		t.Errorf("refused with %s %v, want PERMISSION_DENIED", refused.Code, refused.Details)
+	}
+}
+
+func TestFileDownloadCancel(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	fixture := filepath.Join(t.TempDir(), "build.log")
+	os.WriteFile(fixture, make([]byte, 4*fileDownloadChunkSize), 0644)
+
+	// cat sends a chunk, then stalls like a slow link
+	exec := s.hostExec
+	s.hostExec = func(ctx context.Context, conn *ssh.Connection, req ssh.ExecRequest) (int, error) {
+		if !strings.HasPrefix(req.Command, "cat ") {
+			return exec(ctx, conn, req)
+		}
+		req.Stdout.Write(make([]byte, fileDownloadChunkSize))
+		<-ctx.Done()
+		return -1, ctx.Err()
+	}
+
+	dispatch(t, s, cs, protocol.TypeFileDownload, protocol.FileDownloadPayload{DownloadID: "dl-1", HostID: "host-1", Path: fixture})
+	readPayload(t, conn, protocol.TypeFileDownloadChunk, nil)
+
+	// A second download can't take the running one's ID
+	dispatch(t, s, cs, protocol.TypeFileDownload, protocol.FileDownloadPayload{DownloadID: "dl-1", HostID: "host-1", Path: fixture})
+	refused := readRefusal(t, conn)
+	if refused.Code != protocol.ErrorAlreadyExists {
+		t.Errorf("second download refused with %s, want ALREADY_EXISTS", refused.Code)
+	}
+
+	dispatch(t, s, cs, protocol.TypeFileDownloadCancel, protocol.FileDownloadCancelPayload{DownloadID: "dl-1"})
+	var complete protocol.FileDownloadCompletePayload
+	readPayload(t, conn, protocol.TypeFileDownloadComplete, &complete)
+	if !complete.Cancelled || complete.Success || complete.SHA256 != "" || complete.Chunks != 1 {
+		t.Errorf("complete = %+v, want cancelled after a chunk", complete)
+	}
+}
@@ -0,0 +1,526 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/shellargs"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// ============================================================================
+// File Uploads
+// ============================================================================
+//
+// file_upload_begin declares a file to put on a host, such as a config file
+// or a screenshot for Claude, with its size and SHA-256. Its
+// file_upload_chunk messages are kept on the bridge, in any order, until
+// file_upload_commit checks them against the declared size and checksum.
+// The file is then written over the host's SSH connection in one command:
+// the bytes go to its stdin as base64, which is binary safe whatever the
+// remote shell, are decoded into a temporary file next to the target, and
+// that is moved over the target once its mode is set, so nobody sees half
+// a file. Uploads nobody touched for Config.FileUploadIdleTimeout are
+// dropped.
+
+// defaultUploadMode is the mode of an uploaded file that doesn't ask for one
+const defaultUploadMode = 0o644
+
+// fileUploadWriteTimeout bounds writing a committed upload to its host
+const fileUploadWriteTimeout = 10 * time.Minute
+
+// uploadExistsExit is the write script's exit status when a file appeared
+// at the target, which it may not replace, while the upload was written
+const uploadExistsExit = 3
+
+// fileUpload is an upload waiting for its chunks or being committed
+type fileUpload struct {
+	begin      protocol.FileUploadBeginPayload
+	mode       uint32
+	chunks     map[int][]byte // Decoded, by seq
+	received   int64          // Bytes in chunks
+	lastActive time.Time
+	committing bool
+}
+
+// fileUploads are the uploads started on the bridge
+type fileUploads struct {
+	mu      sync.Mutex
+	pending map[fileTransferKey]*fileUpload
+}
+
+// begin records an upload, or reports false if the session already has one
+// with the ID
+func (u *fileUploads) begin(key fileTransferKey, upload *fileUpload) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.pending[key]; ok {
+		return false
+	}
+	if u.pending == nil {
+		u.pending = make(map[fileTransferKey]*fileUpload)
+	}
+	u.pending[key] = upload
+	return true
+}
+
+// add stores a chunk of an upload; a chunk sent again replaces the first.
+// Chunks adding up to more than the declared size are refused.
+func (u *fileUploads) add(key fileTransferKey, seq int, data []byte, now time.Time) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	upload, ok := u.pending[key]
+	if !ok {
+		return uploadNotFound(key.id)
+	}
+	if upload.committing {
+		return &requestFailure{protocol.ErrorInvalidState,
+			protocol.ErrorDetails{"uploadId": key.id, "reason": "upload is being committed"}}
+	}
+	received := upload.received - int64(len(upload.chunks[seq])) + int64(len(data))
+	if received > upload.begin.Size {
+		return &requestFailure{protocol.ErrorInvalidArgs, protocol.ErrorDetails{
+			"uploadId": key.id, "seq": seq,
+			"reason": fmt.Sprintf("chunks add up to %d bytes, more than the declared %d", received, upload.begin.Size)}}
+	}
+	upload.chunks[seq] = data
+	upload.received = received
+	upload.lastActive = now
+	return nil
+}
+
+// take marks an upload as being committed and returns it, so no chunk can
+// change it meanwhile
+func (u *fileUploads) take(key fileTransferKey, now time.Time) (*fileUpload, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	upload, ok := u.pending[key]
+	if !ok {
+		return nil, uploadNotFound(key.id)
+	}
+	if upload.committing {
+		return nil, &requestFailure{protocol.ErrorInvalidState,
+			protocol.ErrorDetails{"uploadId": key.id, "reason": "upload is already being committed"}}
+	}
+	upload.committing = true
+	upload.lastActive = now
+	return upload, nil
+}
+
+// release hands a taken upload back, for more chunks or another commit
+func (u *fileUploads) release(key fileTransferKey, now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if upload, ok := u.pending[key]; ok {
+		upload.committing = false
+		upload.lastActive = now
+	}
+}
+
+// finish forgets an upload that was written or refused
+func (u *fileUploads) finish(key fileTransferKey) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.pending, key)
+}
+
+// sweep drops the uploads that weren't touched for idle, other than those
+// being committed, and returns them
+func (u *fileUploads) sweep(now time.Time, idle time.Duration) []fileTransferKey {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var dropped []fileTransferKey
+	for key, upload := range u.pending {
+		if !upload.committing && now.Sub(upload.lastActive) >= idle {
+			delete(u.pending, key)
+			dropped = append(dropped, key)
+		}
+	}
+	return dropped
+}
+
+func uploadNotFound(uploadID string) *requestFailure {
+	return &requestFailure{protocol.ErrorNotFound,
+		protocol.ErrorDetails{"uploadId": uploadID, "reason": "no such upload; it may have been idle too long"}}
+}
+
+// assemble returns an upload's chunks in order, or UPLOAD_INCOMPLETE
+// naming the missing ones when they don't run from 0 without a gap or don't
+// add up to the declared size
+func (upload *fileUpload) assemble(uploadID string) ([][]byte, error) {
+	seqs := 0
+	for seq := range upload.chunks {
+		seqs = max(seqs, seq+1)
+	}
+	ordered := make([][]byte, 0, seqs)
+	missing := []int{}
+	for seq := 0; seq < seqs; seq++ {
+		chunk, ok := upload.chunks[seq]
+		if !ok {
+			missing = append(missing, seq)
+		}
+		ordered = append(ordered, chunk)
+	}
+	if len(missing) > 0 || upload.received != upload.begin.Size {
+		return nil, &requestFailure{protocol.ErrorUploadIncomplete, protocol.ErrorDetails{
+			"uploadId": uploadID,
+			"size":     upload.begin.Size,
+			"received": upload.received,
+			"missing":  missing,
+		}}
+	}
+	return ordered, nil
+}
+
+// parseUploadMode parses an octal file mode such as "0755"
+func parseUploadMode(mode *string) (uint32, error) {
+	if mode == nil {
+		return defaultUploadMode, nil
+	}
+	m, err := strconv.ParseUint(*mode, 8, 32)
+	if err != nil || m > 0o7777 {
+		return 0, fmt.Errorf("mode %q is not an octal file mode like 0644", *mode)
+	}
+	return uint32(m), nil
+}
+
+// uploadProbe is what probing an upload's target found
+type uploadProbe struct {
+	status       string // new, exists, missing, denied or notfile
+	resolvedPath string
+}
+
+// uploadProbeScript resolves where p would be written on the host and
+// prints a status and, once the directory is found, the absolute path on
+// the next line: new, exists (a file or symbolic link is there), missing
+// (no such directory), denied (the directory can't be written) or notfile
+// (a directory or special file is there).
+func uploadProbeScript(p string) string {
+	return `t=` + shellargs.Quote(p) + `
+d=$(dirname "$t"); b=$(basename "$t")
+if [ ! -d "$d" ]; then echo missing; exit 0; fi
+if ! d=$(cd "$d" 2>/dev/null && pwd -P); then echo denied; exit 0; fi
+case $d in */) r=$d$b ;; *) r=$d/$b ;; esac
+if [ -e "$r" ] && [ ! -f "$r" ]; then echo notfile
+elif [ ! -w "$d" ]; then echo denied
+elif [ -e "$r" ] || [ -L "$r" ]; then echo exists
+else echo new
+fi
+printf '%s\n' "$r"`
+}
+
+// parseUploadProbe parses the output of uploadProbeScript
+func parseUploadProbe(output string) (uploadProbe, error) {
+	status, rest, _ := strings.Cut(output, "\n")
+	probe := uploadProbe{status: strings.TrimSpace(status), resolvedPath: strings.TrimSuffix(rest, "\n")}
+	switch probe.status {
+	case "new", "exists", "denied", "notfile":
+		if probe.resolvedPath == "" && probe.status != "denied" {
+			return uploadProbe{}, fmt.Errorf("unexpected probe output %q", output)
+		}
+	case "missing":
+	default:
+		return uploadProbe{}, fmt.Errorf("unexpected probe output %q", output)
+	}
+	return probe, nil
+}
+
+// uploadWriteScript decodes base64 from stdin into a temporary file beside
+// target, sets its mode and moves it over target. Unless overwrite is set,
+// it exits uploadExistsExit instead when something is at target by then.
+// The temporary file is removed however the script ends.
+func uploadWriteScript(target string, mode uint32, overwrite bool) string {
+	dir, name := path.Split(target)
+	tmp := dir + "." + name + ".rc-upload-"
+	noClobber := "0"
+	if !overwrite {
+		noClobber = "1"
+	}
+	return `r=` + shellargs.Quote(target) + `
+tmp=` + shellargs.Quote(tmp) + `$$
+trap 'rm -f "$tmp"' EXIT
+if command -v base64 >/dev/null 2>&1; then dec() { base64 -d; }; else dec() { openssl base64 -d -A; }; fi
+(umask 077 && dec > "$tmp") || exit 1
+chmod ` + fmt.Sprintf("%04o", mode) + ` "$tmp" || exit 1
+if [ ` + noClobber + ` = 1 ] && { [ -e "$r" ] || [ -L "$r" ]; }; then exit ` + strconv.Itoa(uploadExistsExit) + `; fi
+mv -f "$tmp" "$r"`
+}
+
+// handleFileUploadBegin starts an upload after checking its host, process,
+// size, checksum and mode. Nothing is sent unless it is refused.
+func (s *Server) handleFileUploadBegin(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.FileUploadBeginPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	details := protocol.ErrorDetails{"uploadId": payload.UploadID, "hostId": payload.HostID, "path": payload.Path}
+	if limit := s.config.FileUploadMaxSize; payload.Size > limit {
+		details["size"] = payload.Size
+		details["limit"] = limit
+		details["reason"] = fmt.Sprintf("%d bytes is over the %d byte limit", payload.Size, limit)
+		return connSession.SendErrorDetails(protocol.ErrorFileTooLarge, details)
+	}
+	sum, err := hex.DecodeString(payload.SHA256)
+	if err != nil || len(sum) != sha256.Size {
+		details["reason"] = "sha256 must be 64 hex digits"
+		return connSession.SendErrorDetails(protocol.ErrorInvalidArgs, details)
+	}
+	mode, err := parseUploadMode(payload.Mode)
+	if err != nil {
+		details["reason"] = err.Error()
+		return connSession.SendErrorDetails(protocol.ErrorInvalidArgs, details)
+	}
+
+	if s.sshManager.GetConnection(payload.HostID) == nil {
+		return connSession.SendErrorDetails(protocol.ErrorNotConnected,
+			protocol.ErrorDetails{"uploadId": payload.UploadID, "hostId": payload.HostID})
+	}
+	if payload.ProcessID != nil {
+		if proc := s.processRegistry.Get(*payload.ProcessID); proc == nil || proc.HostID != payload.HostID {
+			return connSession.SendErrorDetails(protocol.ErrorNotFound,
+				protocol.ErrorDetails{"uploadId": payload.UploadID, "processId": *payload.ProcessID})
+		}
+	}
+
+	payload.SHA256 = hex.EncodeToString(sum)
+	upload := &fileUpload{begin: payload, mode: mode, chunks: make(map[int][]byte), lastActive: time.Now()}
+	if !s.uploads.begin(fileTransferKey{connSession.ID, payload.UploadID}, upload) {
+		return connSession.SendErrorDetails(protocol.ErrorAlreadyExists,
+			protocol.ErrorDetails{"uploadId": payload.UploadID, "reason": "an upload with this ID is pending"})
+	}
+	log.Printf("[INFO] [UPLOAD] Session %s uploading %s (%d bytes) to host %s", connSession.ID, payload.Path, payload.Size, payload.HostID)
+	return nil
+}
+
+// handleFileUploadChunk stores a piece of one of the session's uploads
+func (s *Server) handleFileUploadChunk(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.FileUploadChunkPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+	data, err := base64.StdEncoding.DecodeString(payload.Data)
+	if err != nil {
+		return connSession.SendErrorDetails(protocol.ErrorInvalidArgs,
+			protocol.ErrorDetails{"uploadId": payload.UploadID, "seq": payload.Seq, "reason": "data is not valid base64"})
+	}
+	var failure *requestFailure
+	if err := s.uploads.add(fileTransferKey{connSession.ID, payload.UploadID}, payload.Seq, data, time.Now()); errors.As(err, &failure) {
+		return connSession.sendFailure(failure)
+	}
+	return nil
+}
+
+// handleFileUploadCommit checks one of the session's uploads and writes it
+// to its host in the background, then answers with file_upload_result. An
+// upload missing chunks, or whose host isn't connected, is kept to commit
+// again; any other refusal drops it.
+func (s *Server) handleFileUploadCommit(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.FileUploadCommitPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	key := fileTransferKey{connSession.ID, payload.UploadID}
+	var failure *requestFailure
+	upload, err := s.uploads.take(key, time.Now())
+	if errors.As(err, &failure) {
+		return connSession.sendFailure(failure)
+	}
+	chunks, err := upload.assemble(payload.UploadID)
+	if errors.As(err, &failure) {
+		s.uploads.release(key, time.Now())
+		return connSession.sendFailure(failure)
+	}
+
+	hash := sha256.New()
+	for _, chunk := range chunks {
+		hash.Write(chunk)
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != upload.begin.SHA256 {
+		s.uploads.finish(key)
+		log.Printf("[WARN] [UPLOAD] Upload %s of %s doesn't match its checksum", payload.UploadID, upload.begin.Path)
+		return connSession.SendErrorDetails(protocol.ErrorChecksumMismatch,
+			protocol.ErrorDetails{"uploadId": payload.UploadID, "expected": upload.begin.SHA256, "actual": actual})
+	}
+
+	conn := s.sshManager.GetConnection(upload.begin.HostID)
+	if conn == nil {
+		s.uploads.release(key, time.Now())
+		return connSession.SendErrorDetails(protocol.ErrorNotConnected,
+			protocol.ErrorDetails{"uploadId": payload.UploadID, "hostId": upload.begin.HostID})
+	}
+	var proc *process.Process
+	if id := upload.begin.ProcessID; id != nil {
+		if proc = s.processRegistry.Get(*id); proc == nil {
+			s.uploads.finish(key)
+			return connSession.SendErrorDetails(protocol.ErrorNotFound,
+				protocol.ErrorDetails{"uploadId": payload.UploadID, "processId": *id})
+		}
+	}
+
+	go func() {
+		if err := s.runFileUpload(key, connSession, conn, proc, upload, chunks); err != nil {
+			log.Printf("[ERROR] [UPLOAD] Failed to answer upload %s of session %s: %v", payload.UploadID, connSession.ID, err)
+		}
+	}()
+	return nil
+}
+
+// runFileUpload checks where an upload goes and writes it, then sends
+// file_upload_result, or refuses it with an error. Either way the upload
+// is dropped before anything is sent, so its ID can be reused at once.
+func (s *Server) runFileUpload(key fileTransferKey, connSession *ConnectedSession, conn *ssh.Connection, proc *process.Process, upload *fileUpload, chunks [][]byte) error {
+	begin := upload.begin
+	ctx, cancel := context.WithTimeout(context.Background(), fileUploadWriteTimeout)
+	defer cancel()
+
+	target := begin.Path
+	if !path.IsAbs(target) {
+		// Keeps a name starting with - from reading as an option
+		target = "./" + target
+	}
+	probe, err := s.probeUpload(ctx, conn, target, transferDir(proc))
+	if err == nil {
+		err = s.checkUploadable(begin, probe)
+	}
+	if err == nil {
+		err = s.writeUpload(ctx, conn, probe.resolvedPath, upload, chunks)
+	}
+	s.uploads.finish(key)
+
+	var failure *requestFailure
+	switch {
+	case errors.As(err, &failure):
+		return connSession.sendFailure(failure)
+	case err != nil:
+		log.Printf("[ERROR] [UPLOAD] Failed to write %s on host %s: %v", begin.Path, begin.HostID, err)
+		return connSession.SendErrorDetails(protocol.ErrorExecFailed,
+			protocol.ErrorDetails{"uploadId": begin.UploadID, "hostId": begin.HostID, "reason": err.Error()})
+	}
+
+	log.Printf("[INFO] [UPLOAD] Wrote %s (%d bytes) on host %s", probe.resolvedPath, begin.Size, begin.HostID)
+	response, err := protocol.NewMessage(protocol.TypeFileUploadResult, protocol.FileUploadResultPayload{
+		UploadID:     begin.UploadID,
+		HostID:       begin.HostID,
+		Path:         begin.Path,
+		ResolvedPath: probe.resolvedPath,
+		Size:         begin.Size,
+		Mode:         fmt.Sprintf("%04o", upload.mode),
+		Replaced:     probe.status == "exists",
+	})
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
+
+// probeUpload resolves and checks where an upload goes
+func (s *Server) probeUpload(ctx context.Context, conn *ssh.Connection, target, dir string) (uploadProbe, error) {
+	ctx, cancel := context.WithTimeout(ctx, fileProbeTimeout)
+	defer cancel()
+	var stdout bytes.Buffer
+	stderr := &cappedBuffer{max: 4 << 10}
+	exitCode, err := s.hostExec(ctx, conn, ssh.ExecRequest{Command: uploadProbeScript(target), Dir: dir, Stdout: &stdout, Stderr: stderr})
+	if err != nil {
+		return uploadProbe{}, err
+	}
+	if exitCode != 0 {
+		// Only the cd into dir fails the script
+		return uploadProbe{}, fmt.Errorf("exit %d: %s", exitCode, strings.TrimSpace(stderr.buf.String()))
+	}
+	return parseUploadProbe(stdout.String())
+}
+
+// checkUploadable refuses a target whose directory is missing or can't be
+// written, that isn't a regular file, or that exists without overwrite
+func (s *Server) checkUploadable(begin protocol.FileUploadBeginPayload, probe uploadProbe) error {
+	details := protocol.ErrorDetails{"uploadId": begin.UploadID, "hostId": begin.HostID, "path": begin.Path}
+	if probe.resolvedPath != "" {
+		details["resolvedPath"] = probe.resolvedPath
+	}
+	switch probe.status {
+	case "missing":
+		details["reason"] = "directory doesn't exist"
+		return &requestFailure{protocol.ErrorFileNotFound, details}
+	case "denied":
+		details["reason"] = "directory isn't writable by the host user"
+		return &requestFailure{protocol.ErrorPermissionDenied, details}
+	case "notfile":
+		details["reason"] = "a directory or special file is in the way"
+		return &requestFailure{protocol.ErrorNotAFile, details}
+	case "exists":
+		if !begin.Overwrite {
+			details["reason"] = "file exists; set overwrite to replace it"
+			return &requestFailure{protocol.ErrorAlreadyExists, details}
+		}
+	}
+	return nil
+}
+
+// writeUpload streams an upload's chunks to uploadWriteScript as base64
+func (s *Server) writeUpload(ctx context.Context, conn *ssh.Connection, target string, upload *fileUpload, chunks [][]byte) error {
+	stdin, feed := io.Pipe()
+	go func() {
+		enc := base64.NewEncoder(base64.StdEncoding, feed)
+		for _, chunk := range chunks {
+			if _, err := enc.Write(chunk); err != nil {
+				return
+			}
+		}
+		feed.CloseWithError(enc.Close())
+	}()
+	// Unblocks the feed when the command stops reading early
+	defer stdin.Close()
+
+	stderr := &cappedBuffer{max: 4 << 10}
+	exitCode, err := s.hostExec(ctx, conn, ssh.ExecRequest{
+		Command: uploadWriteScript(target, upload.mode, upload.begin.Overwrite),
+		Stdin:   stdin,
+		Stderr:  stderr,
+	})
+	switch {
+	case err != nil:
+		return err
+	case exitCode == uploadExistsExit:
+		return &requestFailure{protocol.ErrorAlreadyExists, protocol.ErrorDetails{
+			"uploadId": upload.begin.UploadID, "hostId": upload.begin.HostID, "path": upload.begin.Path,
+			"resolvedPath": target, "reason": "a file was created at the path during the upload"}}
+	case exitCode != 0:
+		return fmt.Errorf("write exited %d: %s", exitCode, strings.TrimSpace(stderr.buf.String()))
+	}
+	return nil
+}
+
+// uploadSweepLoop drops uploads left idle for longer than idle until the
+// server stops
+func (s *Server) uploadSweepLoop(idle time.Duration) {
+	ticker := time.NewTicker(min(idle, time.Minute))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			for _, key := range s.uploads.sweep(time.Now(), idle) {
+				log.Printf("[INFO] [UPLOAD] Dropped upload %s of session %s, idle for %s", key.id, key.sessionID, idle)
+			}
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// runLocally runs host commands through the local sh, which unlike the test
+// SSH server feeds them stdin and reports their exit status
+func runLocally(ctx context.Context, conn *ssh.Connection, req ssh.ExecRequest) (int, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", req.Command)
+	cmd.Dir = req.Dir
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = req.Stdin, req.Stdout, req.Stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+func TestFileUploadChunks(t *testing.T) {
+	var uploads fileUploads
+	key := fileTransferKey{"session-1", "up-1"}
+	start := time.Now()
+	upload := &fileUpload{begin: protocol.FileUploadBeginPayload{Size: 10}, chunks: make(map[int][]byte)}
+	if !uploads.begin(key, upload) || uploads.begin(key, &fileUpload{}) {
+		t.Fatal("begin should take an ID once")
+	}
+
+	// Out of order, with a chunk sent again
+	for _, c := range []struct {
+		seq  int
+		data string
+	}{{2, "789"}, {0, "0123"}, {2, "89"}} {
+		if err := uploads.add(key, c.seq, []byte(c.data), start); err != nil {
+			t.Fatalf("add %d: %v", c.seq, err)
+		}
+	}
+	var failure *requestFailure
+	_, err := upload.assemble("up-1")
+	if !errors.As(err, &failure) || failure.code != protocol.ErrorUploadIncomplete {
+		t.Fatalf("assemble with chunk 1 missing: %v", err)
+	}
+	if details := failure.details.(protocol.ErrorDetails); !slices.Equal(details["missing"].([]int), []int{1}) || details["received"] != int64(6) {
+		t.Errorf("incomplete details = %v, want chunk 1 missing and 6 bytes received", details)
+	}
+
+	// Past the declared size
+	if err := uploads.add(key, 1, []byte("4567x"), start); !errors.As(err, &failure) || failure.code != protocol.ErrorInvalidArgs {
+		t.Errorf("add past the size: %v", err)
+	}
+	if err := uploads.add(key, 1, []byte("4567"), start); err != nil {
+		t.Fatalf("add 1: %v", err)
+	}
+	chunks, err := upload.assemble("up-1")
+	if err != nil || string(bytes.Join(chunks, nil)) != "0123456789" {
+		t.Fatalf("assemble = %q, %v", chunks, err)
+	}
+
+	// Nothing changes an upload being committed
+	if _, err := uploads.take(key, start); err != nil {
+		t.Fatal(err)
+	}
+	if err := uploads.add(key, 0, []byte("x"), start); !errors.As(err, &failure) || failure.code != protocol.ErrorInvalidState {
+		t.Errorf("add while committing: %v", err)
+	}
+	if _, err := uploads.take(key, start); !errors.As(err, &failure) || failure.code != protocol.ErrorInvalidState {
+		t.Errorf("second take: %v", err)
+	}
+	if err := uploads.add(fileTransferKey{"session-2", "up-1"}, 0, nil, start); !errors.As(err, &failure) || failure.code != protocol.ErrorNotFound {
+		t.Errorf("another session's upload: %v", err)
+	}
+}
+
+func TestFileUploadSweep(t *testing.T) {
+	var uploads fileUploads
+	start := time.Now()
+	for _, id := range []string{"idle", "active", "committing"} {
+		uploads.begin(fileTransferKey{"session-1", id},
+			&fileUpload{begin: protocol.FileUploadBeginPayload{Size: 1}, chunks: make(map[int][]byte), lastActive: start})
+	}
+	uploads.add(fileTransferKey{"session-1", "active"}, 0, []byte("a"), start.Add(4*time.Minute))
+	uploads.take(fileTransferKey{"session-1", "committing"}, start)
+
+	dropped := uploads.sweep(start.Add(5*time.Minute), 5*time.Minute)
+	if len(dropped) != 1 || dropped[0].id != "idle" {
+		t.Fatalf("dropped %v, want only the idle upload", dropped)
+	}
+	if len(uploads.pending) != 2 {
+		t.Errorf("%d uploads left, want 2", len(uploads.pending))
+	}
+
+	// A committed upload that went back for more chunks ages again
+	uploads.release(fileTransferKey{"session-1", "committing"}, start)
+	if dropped := uploads.sweep(start.Add(10*time.Minute), 5*time.Minute); len(dropped) != 2 {
+		t.Errorf("dropped %v, want both uploads left", dropped)
+	}
+}
+
+func TestUploadWriteScript(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	dir := t.TempDir()
+	target := filepath.Join(dir, `it's a "$HOME" file`)
+
+	// Every byte value, so the transfer has to be binary safe
+	content := make([]byte, 3*256+1)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	write := func(mode uint32, overwrite bool) int {
+		t.Helper()
+		var stderr bytes.Buffer
+		code, err := runLocally(context.Background(), nil, ssh.ExecRequest{
+			Command: uploadWriteScript(target, mode, overwrite),
+			Stdin:   strings.NewReader(base64.StdEncoding.EncodeToString(content)),
+			Stderr:  &stderr,
+		})
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if code != 0 && code != uploadExistsExit {
+			t.Fatalf("write exited %d: %s", code, stderr.String())
+		}
+		return code
+	}
+
+	write(0o640, false)
+	got, err := os.ReadFile(target)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("wrote %d bytes (%v), want the %d byte fixture", len(got), err, len(content))
+	}
+	if info, _ := os.Stat(target); info.Mode().Perm() != 0o640 {
+		t.Errorf("mode = %o, want 640", info.Mode().Perm())
+	}
+
+	// A file at the target is kept unless the upload overwrites it
+	content = []byte("second")
+	if code := write(0o600, false); code != uploadExistsExit {
+		t.Errorf("write over a file exited %d, want %d", code, uploadExistsExit)
+	}
+	write(0o600, true)
+	if got, _ := os.ReadFile(target); string(got) != "second" {
+		t.Errorf("overwritten file = %q", got)
+	}
+
+	// The temporary files are gone either way
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want only the target", len(entries))
+	}
+}
+
+// beginUpload starts an upload of content and sends it in chunks of size,
+// last chunk first
+func beginUpload(t *testing.T, s *Server, cs *ConnectedSession, begin protocol.FileUploadBeginPayload, content []byte, size int) {
+	t.Helper()
+	sum := sha256.Sum256(content)
+	begin.Size = int64(len(content))
+	begin.SHA256 = hex.EncodeToString(sum[:])
+	dispatch(t, s, cs, protocol.TypeFileUploadBegin, begin)
+	for seq := (len(content) - 1) / size; seq >= 0; seq-- {
+		chunk := content[seq*size : min((seq+1)*size, len(content))]
+		dispatch(t, s, cs, protocol.TypeFileUploadChunk, protocol.FileUploadChunkPayload{
+			UploadID: begin.UploadID, Seq: seq, Data: base64.StdEncoding.EncodeToString(chunk)})
+	}
+}
+
+func TestFileUpload(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAKE_TMUX_CWD", t.TempDir())
+	config := DefaultConfig()
+	config.CWDRefreshInterval = 0
+	config.FileUploadMaxSize = 1 << 20
+	s := newTestServer(t, config)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 1)
+	setPaneCWD(t, "rc-proc-0", dir)
+	s.hostExec = runLocally
+
+	// A relative path lands in the process's directory
+	processID := "proc-0"
+	content := []byte("listen 8080\n\x00\xff")
+	mode := "0600"
+	begin := protocol.FileUploadBeginPayload{UploadID: "up-1", HostID: "host-1", Path: "app.conf", ProcessID: &processID, Mode: &mode}
+	beginUpload(t, s, cs, begin, content, 4)
+	dispatch(t, s, cs, protocol.TypeFileUploadCommit, protocol.FileUploadCommitPayload{UploadID: "up-1"})
+	var result protocol.FileUploadResultPayload
+	readPayload(t, conn, protocol.TypeFileUploadResult, &result)
+	target := filepath.Join(dir, "app.conf")
+	if result.ResolvedPath != target || result.Mode != "0600" || result.Size != int64(len(content)) || result.Replaced {
+		t.Errorf("result = %+v", result)
+	}
+	if got, _ := os.ReadFile(target); !bytes.Equal(got, content) {
+		t.Errorf("wrote %q, want %q", got, content)
+	}
+
+	// The ID is free again, but the file is only replaced on request
+	beginUpload(t, s, cs, begin, []byte("listen 9090\n"), 4)
+	dispatch(t, s, cs, protocol.TypeFileUploadCommit, protocol.FileUploadCommitPayload{UploadID: "up-1"})
+	if refused := readRefusal(t, conn); refused.Code != protocol.ErrorAlreadyExists || refused.Details["resolvedPath"] != target {
+		t.Errorf("refused with %s %v, want ALREADY_EXISTS", refused.Code, refused.Details)
+	}
+	begin.Overwrite = true
+	beginUpload(t, s, cs, begin, []byte("listen 9090\n"), 4)
+	dispatch(t, s, cs, protocol.TypeFileUploadCommit, protocol.FileUploadCommitPayload{UploadID: "up-1"})
+	readPayload(t, conn, protocol.TypeFileUploadResult, &result)
+	if got, _ := os.ReadFile(target); !result.Replaced || string(got) != "listen 9090\n" {
+		t.Errorf("overwrite: %+v, file %q", result, got)
+	}
+
+	// A missing chunk keeps the upload to commit again
+	content = []byte("0123456789")
+	sum := sha256.Sum256(content)
+	dispatch(t, s, cs, protocol.TypeFileUploadBegin, protocol.FileUploadBeginPayload{
+		UploadID: "up-2", HostID: "host-1", Path: filepath.Join(dir, "digits"), Size: 10, SHA256: hex.EncodeToString(sum[:])})
+	dispatch(t, s, cs, protocol.TypeFileUploadChunk, protocol.FileUploadChunkPayload{UploadID: "up-2", Seq: 1, Data: base64.StdEncoding.EncodeToString(content[5:])})
+	dispatch(t, s, cs, protocol.TypeFileUploadCommit, protocol.FileUploadCommitPayload{UploadID: "up-2"})
+	if refused := readRefusal(t, conn); refused.Code != protocol.ErrorUploadIncomplete {
+		t.Errorf("refused with %s, want UPLOAD_INCOMPLETE", refused.Code)
+	}
+	dispatch(t, s, cs, protocol.TypeFileUploadChunk, protocol.FileUploadChunkPayload{UploadID: "up-2", Seq: 0, Data: base64.StdEncoding.EncodeToString(content[:5])})
+	dispatch(t, s, cs, protocol.TypeFileUploadCommit, protocol.FileUploadCommitPayload{UploadID: "up-2"})
+	readPayload(t, conn, protocol.TypeFileUploadResult, &result)
+	if result.Mode != "0644" {
+		t.Errorf("default mode = %s, want 0644", result.Mode)
+	}
+
+	// Bytes that don't match the checksum are dropped
+	dispatch(t, s, cs, protocol.TypeFileUploadBegin, protocol.FileUploadBeginPayload{
+		UploadID: "up-3", HostID: "host-1", Path: "other", Size: 10, SHA256: hex.EncodeToString(sum[:])})
+	dispatch(t, s, cs, protocol.TypeFileUploadChunk, protocol.FileUploadChunkPayload{UploadID: "up-3", Data: base64.StdEncoding.EncodeToString([]byte("9876543210"))})
+	dispatch(t, s, cs, protocol.TypeFileUploadCommit, protocol.FileUploadCommitPayload{UploadID: "up-3"})
+	if refused := readRefusal(t, conn); refused.Code != protocol.ErrorChecksumMismatch {
+		t.Errorf("refused with %s, want CHECKSUM_MISMATCH", refused.Code)
+	}
+	dispatch(t, s, cs, protocol.TypeFileUploadCommit, protocol.FileUploadCommitPayload{UploadID: "up-3"})
+	if refused := readRefusal(t, conn); refused.Code != protocol.ErrorNotFound {
+		t.Errorf("commit after a mismatch refused with %s, want NOT_FOUND", refused.Code)
+	}
+
+	// Refused up front
+	for _, tc := range []struct {
+		begin protocol.FileUploadBeginPayload
+		code  protocol.ErrorCode
+	}{
+		{protocol.FileUploadBeginPayload{UploadID: "up-4", HostID: "host-1", Path: "big", Size: config.FileUploadMaxSize + 1, SHA256: hex.EncodeToString(sum[:])}, protocol.ErrorFileTooLarge},
+		{protocol.FileUploadBeginPayload{UploadID: "up-4", HostID: "host-1", Path: "x", SHA256: "abc"}, protocol.ErrorInvalidArgs},
+		{protocol.FileUploadBeginPayload{UploadID: "up-4", HostID: "host-1", Path: "x", SHA256: hex.EncodeToString(sum[:]), Mode: strPtr("rwx")}, protocol.ErrorInvalidArgs},
+		{protocol.FileUploadBeginPayload{UploadID: "up-4", HostID: "host-2", Path: "x", SHA256: hex.EncodeToString(sum[:])}, protocol.ErrorNotConnected},
+	} {
+		dispatch(t, s, cs, protocol.TypeFileUploadBegin, tc.begin)
+		if refused := readRefusal(t, conn); refused.Code != tc.code || refused.Details["uploadId"] != "up-4" {
+			t.Errorf("%+v refused with %s %v, want %s", tc.begin, refused.Code, refused.Details, tc.code)
+		}
+	}
+
+	// A missing directory is refused at commit
+	beginUpload(t, s, cs, protocol.FileUploadBeginPayload{UploadID: "up-5", HostID: "host-1", Path: "nowhere/app.conf"}, content, 4)
+	dispatch(t, s, cs, protocol.TypeFileUploadCommit, protocol.FileUploadCommitPayload{UploadID: "up-5"})
+	if refused := readRefusal(t, conn); refused.Code != protocol.ErrorFileNotFound {
+		t.Errorf("refused with %s, want FILE_NOT_FOUND", refused.Code)
+	}
+	expectNothingQueued(t, conn, cs)
+}
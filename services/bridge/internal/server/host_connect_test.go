@@ -0,0 +1,357 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// Process IDs of the tmux sessions on the slow host
+const (
+	slowProcA   = "00000000-0000-4000-8000-00000000000a"
+	slowProcB   = "00000000-0000-4000-8000-00000000000b"
+	slowOrphan  = "00000000-0000-4000-8000-00000000000c"
+	slowForeign = "rc-test" // a user's own session that happens to share our prefix
+)
+
+// slowHostConfig stores a host served by an SSH server that takes a while to
+// answer each command and has tmux sessions for two registered processes, one
+// orphan and one the bridge didn't create. It returns the host ID.
+func slowHostConfig(t *testing.T, s *Server) string {
+	t.Helper()
+	srv := startTestSSHServer(t)
+	srv.HandleExec(func(cmd string) string {
+		time.Sleep(20 * time.Millisecond)
+		if strings.HasPrefix(cmd, "tmux list-sessions") {
+			var out strings.Builder
+			for _, name := range []string{pty.TmuxSessionName(slowProcA), pty.TmuxSessionName(slowProcB), pty.TmuxSessionName(slowOrphan), slowForeign} {
+				out.WriteString(name + ":1700000000:0:120:30\n")
+			}
+			return out.String()
+		}
+		return ""
+	})
+
+	hostID := storeTestHost(t, s, "slow box", srv)
+	for _, id := range []string{slowProcA, slowProcB} {
+		s.processRegistry.Register(&process.Process{ID: id, HostID: hostID, Type: process.TypeShell,
+			PTY: &pty.Session{ID: id, HostID: hostID, TmuxName: pty.TmuxSessionName(id)}})
+	}
+	return hostID
+}
+
+// phasedHostConfig stores a host with no tmux sessions whose tmux scan,
+// AgentAPI port scan and requirements check take the given times. It
+// returns the host ID.
+func phasedHostConfig(t *testing.T, s *Server, tmux, ports, requirements time.Duration) string {
+	t.Helper()
+	srv := startTestSSHServer(t)
+	srv.DelayTunnels(ports)
+	srv.HandleExec(func(cmd string) string {
+		switch {
+		case strings.HasPrefix(cmd, "tmux list-sessions"):
+			time.Sleep(tmux)
+		case strings.Contains(cmd, "which claude"):
+			time.Sleep(requirements)
+		}
+		return ""
+	})
+	return storeTestHost(t, s, "phased box", srv)
+}
+
+// storeTestHost stores a host config for srv and returns its ID
+func storeTestHost(t *testing.T, s *Server, name string, srv *testSSHServer) string {
+	t.Helper()
+	conn, cs := connectTestClient(t, s)
+	dispatch(t, s, cs, protocol.TypeHostConfigCreate, protocol.HostConfigCreatePayload{
+		Name: name, Host: "127.0.0.1", Port: srv.Port(), Username: "user", AuthType: "password", Credential: "secret",
+	})
+	var created protocol.HostConfigCreateResultPayload
+	readPayload(t, conn, protocol.TypeHostConfigCreateResult, &created)
+	if !created.Success {
+		t.Fatalf("host create failed: %+v", created)
+	}
+	return created.Host.ID
+}
+
+func TestHostConnectStreamsProgress(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	hostID := slowHostConfig(t, s)
+	conn, cs := connectTestClient(t, s)
+
+	dispatch(t, s, cs, protocol.TypeHostConnect, protocol.HostConnectPayload{HostID: hostID, WantProgress: true})
+
+	var updates []protocol.HostConnectProgressPayload
+	for {
+		var msg protocol.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("ReadJSON: %v", err)
+		}
+		if msg.Type == protocol.TypeHostStatus {
+			break
+		}
+		if msg.Type != protocol.TypeHostConnectProgress {
+			t.Fatalf("unexpected %s before HOST_STATUS: %s", msg.Type, msg.Payload)
+		}
+		var update protocol.HostConnectProgressPayload
+		if err := json.Unmarshal(msg.Payload, &update); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if update.HostID != hostID {
+			t.Errorf("progress for host %q, want %q", update.HostID, hostID)
+		}
+		updates = append(updates, update)
+	}
+
+	type step struct {
+		stage                 protocol.HostConnectStage
+		found, current, total int
+	}
+	deref := func(p *int) int {
+		if p == nil {
+			return 0
+		}
+		return *p
+	}
+	// The scans run at once, so only the steps of each are ordered
+	var tmux, others []step
+	for _, u := range updates {
+		got := step{u.Stage, deref(u.Found), deref(u.Current), deref(u.Total)}
+		switch u.Stage {
+		case protocol.HostConnectScanningTmux, protocol.HostConnectReattaching:
+			tmux = append(tmux, got)
+		default:
+			others = append(others, got)
+		}
+	}
+	wantTmux := []step{
+		{stage: protocol.HostConnectScanningTmux, found: 3}, // rc-test isn't ours
+		{stage: protocol.HostConnectReattaching, current: 1, total: 2},
+		{stage: protocol.HostConnectReattaching, current: 2, total: 2},
+	}
+	if !reflect.DeepEqual(tmux, wantTmux) {
+		t.Errorf("tmux progress:\n%+v\nwant:\n%+v", tmux, wantTmux)
+	}
+	if len(others) != 3 || others[0].stage != protocol.HostConnectSSHHandshake ||
+		!slices.ContainsFunc(others, func(s step) bool { return s.stage == protocol.HostConnectScanningPorts }) ||
+		!slices.ContainsFunc(others, func(s step) bool { return s.stage == protocol.HostConnectCheckingRequirements }) {
+		t.Errorf("progress = %+v, want the handshake, then the port scan and requirements check", others)
+	}
+}
+
+func TestHostConnectScansAtOnce(t *testing.T) {
+	const phase = 300 * time.Millisecond
+	// Under the default operation limit the port probes would queue
+	config := DefaultConfig()
+	config.MaxConcurrentOps = ssh.MaxConcurrentOpsLimit
+	s := newTestServer(t, config)
+	hostID := phasedHostConfig(t, s, phase, phase, phase)
+	conn, cs := connectTestClient(t, s)
+
+	start := time.Now()
+	dispatch(t, s, cs, protocol.TypeHostConnect, protocol.HostConnectPayload{HostID: hostID})
+	var status protocol.HostStatusPayload
+	readPayload(t, conn, protocol.TypeHostStatus, &status)
+	elapsed := time.Since(start)
+
+	if !status.Connected || status.Requirements == nil || status.TimedOut != nil {
+		t.Errorf("host status = %+v, want connected with every scan done", status)
+	}
+	// One after another, the scans would take 3*phase
+	if elapsed < phase || elapsed >= 2*phase {
+		t.Errorf("connect took %v, want the scans to overlap (each takes %v)", elapsed, phase)
+	}
+}
+
+func TestHostConnectTimeoutReportsUnfinishedScans(t *testing.T) {
+	config := DefaultConfig()
+	config.HostConnectTimeout = 200 * time.Millisecond
+	s := newTestServer(t, config)
+	hostID := phasedHostConfig(t, s, time.Second, 0, 0)
+	conn, cs := connectTestClient(t, s)
+
+	start := time.Now()
+	dispatch(t, s, cs, protocol.TypeHostConnect, protocol.HostConnectPayload{HostID: hostID, WantProgress: true})
+	var msg protocol.Message
+	for msg.Type != protocol.TypeHostStatus {
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("ReadJSON: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("connect took %v, want it cut off at the deadline", elapsed)
+	}
+
+	// The status carries what finished and names what didn't
+	var status map[string]any
+	if err := json.Unmarshal(msg.Payload, &status); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if status["connected"] != true || status["requirements"] == nil {
+		t.Errorf("host status = %s, want connected with requirements", msg.Payload)
+	}
+	if !reflect.DeepEqual(status["timedOut"], []any{"scanning_tmux"}) {
+		t.Errorf("timedOut = %v, want [scanning_tmux]", status["timedOut"])
+	}
+
+	// The late tmux scan reports nothing after the status
+	time.Sleep(time.Second)
+	expectNothingQueued(t, conn, cs)
+}
+
+func TestHostConnectDoesNotReattachAfterTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.HostConnectTimeout = 200 * time.Millisecond
+	s := newTestServer(t, config)
+	srv := startTestSSHServer(t)
+	srv.HandleExec(func(cmd string) string {
+		if strings.HasPrefix(cmd, "tmux list-sessions") {
+			time.Sleep(time.Second)
+			return pty.TmuxSessionName(slowProcA) + ":1700000000:0:120:30\n"
+		}
+		return ""
+	})
+	hostID := storeTestHost(t, s, "late box", srv)
+	s.processRegistry.Register(&process.Process{ID: slowProcA, HostID: hostID, Type: process.TypeShell,
+		PTY: &pty.Session{ID: slowProcA, HostID: hostID, TmuxName: pty.TmuxSessionName(slowProcA)}})
+	var reattached atomic.Int32
+	s.reattach = func(*Server, *ConnectedSession, *process.Process, *ssh.Connection) error {
+		reattached.Add(1)
+		return nil
+	}
+	conn, cs := connectTestClient(t, s)
+
+	dispatch(t, s, cs, protocol.TypeHostConnect, protocol.HostConnectPayload{HostID: hostID})
+	var status protocol.HostStatusPayload
+	readPayload(t, conn, protocol.TypeHostStatus, &status)
+	if !slices.Contains(status.TimedOut, protocol.HostConnectScanningTmux) {
+		t.Fatalf("timedOut = %v, want the tmux scan", status.TimedOut)
+	}
+
+	// The listing the scan was waiting on comes too late to act on
+	time.Sleep(1200 * time.Millisecond)
+	if n := reattached.Load(); n != 0 {
+		t.Errorf("reattached %d processes after the status went out", n)
+	}
+}
+
+func TestHostConnectWithoutProgress(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	hostID := slowHostConfig(t, s)
+	conn, cs := connectTestClient(t, s)
+	// Left from an earlier connect; the session and the server are gone since
+	gone := "00000000-0000-4000-8000-00000000000d"
+	s.processRegistry.MergeStaleProcesses(hostID, []protocol.StaleProcess{
+		{Reason: "detached", ProcessID: &gone}, {Reason: "refused", Port: 3299},
+	})
+
+	// Older clients don't ask for progress and get HOST_STATUS only
+	dispatch(t, s, cs, protocol.TypeHostConnect, protocol.HostConnectPayload{HostID: hostID})
+	var status protocol.HostStatusPayload
+	readPayload(t, conn, protocol.TypeHostStatus, &status)
+	if !status.Connected {
+		t.Errorf("host status = %+v, want connected", status)
+	}
+	if status.Channels == nil || status.Channels.Limit != ssh.DefaultMaxChannels || status.Channels.TunnelConnections != 0 {
+		t.Errorf("channels = %+v, want the default limit and no tunnel connections", status.Channels)
+	}
+
+	// The foreign session is reported apart and never offered for reattach
+	if len(status.UnmanagedSessions) != 1 || status.UnmanagedSessions[0].TmuxSession != slowForeign {
+		t.Errorf("unmanaged sessions = %+v, want only %s", status.UnmanagedSessions, slowForeign)
+	}
+	if status.StaleProcesses == nil || len(*status.StaleProcesses) != 1 || *(*status.StaleProcesses)[0].ProcessID != slowOrphan {
+		t.Errorf("stale processes = %+v, want only the orphan, the ones gone dropped", status.StaleProcesses)
+	}
+}
+
+// sharedMachineHosts stores two host configs that reach one machine, whose
+// tmux has sessions for processes a and b. It returns the host IDs.
+func sharedMachineHosts(t *testing.T, s *Server, a, b string) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	bin := t.TempDir()
+	fakeTmux := fmt.Sprintf("#!/bin/sh\n[ \"$1\" = list-sessions ] || exit 1\nprintf '%s:1700000000:0:120:30\\n%s:1700000000:0:120:30\\n'\n",
+		pty.TmuxSessionName(a), pty.TmuxSessionName(b))
+	if err := os.WriteFile(filepath.Join(bin, "tmux"), []byte(fakeTmux), 0755); err != nil {
+		t.Fatal(err)
+	}
+	srv := startTestSSHServer(t)
+	srv.HandleExec(func(cmd string) string {
+		c := exec.Command("sh", "-c", cmd)
+		c.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+		output, _ := c.Output()
+		return string(output)
+	})
+
+	conn, cs := connectTestClient(t, s)
+	var ids []string
+	for _, name := range []string{"box", "box (again)"} {
+		dispatch(t, s, cs, protocol.TypeHostConfigCreate, protocol.HostConfigCreatePayload{
+			Name: name, Host: "127.0.0.1", Port: srv.Port(), Username: "user", AuthType: "password", Credential: "secret",
+		})
+		var created protocol.HostConfigCreateResultPayload
+		readPayload(t, conn, protocol.TypeHostConfigCreateResult, &created)
+		if !created.Success {
+			t.Fatalf("host create failed: %+v", created)
+		}
+		ids = append(ids, created.Host.ID)
+	}
+	return ids[0], ids[1]
+}
+
+func TestHostConnectDuplicateMachine(t *testing.T) {
+	const procA, orphan = "00000000-0000-4000-8000-00000000000a", "00000000-0000-4000-8000-00000000000c"
+	s := newTestServer(t, DefaultConfig())
+	first, second := sharedMachineHosts(t, s, procA, orphan)
+	conn, cs := connectTestClient(t, s)
+
+	dispatch(t, s, cs, protocol.TypeHostConnect, protocol.HostConnectPayload{HostID: first})
+	var status protocol.HostStatusPayload
+	readPayload(t, conn, protocol.TypeHostStatus, &status)
+	if len(status.Warnings) != 0 {
+		t.Errorf("first host warnings = %+v", status.Warnings)
+	}
+	if status.StaleProcesses == nil || len(*status.StaleProcesses) != 2 {
+		t.Fatalf("first host stale processes = %+v, want both sessions", status.StaleProcesses)
+	}
+	// One of them gets reattached under the first host
+	s.processRegistry.Register(&process.Process{ID: procA, HostID: first, Type: process.TypeShell,
+		PTY: &pty.Session{ID: procA, HostID: first, TmuxName: pty.TmuxSessionName(procA)}})
+
+	dispatch(t, s, cs, protocol.TypeHostConnect, protocol.HostConnectPayload{HostID: second})
+	status = protocol.HostStatusPayload{}
+	readPayload(t, conn, protocol.TypeHostStatus, &status)
+	if len(status.Warnings) != 1 || status.Warnings[0].Code != protocol.HostWarningDuplicate ||
+		!reflect.DeepEqual(status.Warnings[0].DuplicateOf, []string{first}) {
+		t.Errorf("second host warnings = %+v, want HOST_DUPLICATE of %s", status.Warnings, first)
+	}
+	if len(status.Processes) != 0 || status.StaleProcesses != nil {
+		t.Errorf("second host claimed sessions: processes %+v, stale %+v", status.Processes, status.StaleProcesses)
+	}
+	if proc := s.processRegistry.Get(procA); proc == nil || proc.HostID != first {
+		t.Errorf("process %s moved to another host: %+v", procA, proc)
+	}
+
+	// Both configs recorded the machine's fingerprint
+	hostA, _ := s.storage.GetSSHHost(first)
+	hostB, _ := s.storage.GetSSHHost(second)
+	if hostA.Fingerprint == "" || hostA.Fingerprint != hostB.Fingerprint {
+		t.Errorf("fingerprints = %q and %q, want the same", hostA.Fingerprint, hostB.Fingerprint)
+	}
+}
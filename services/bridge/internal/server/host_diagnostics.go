@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/diagnostics"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// diagnosticsCommandTimeout bounds each command of a diagnostics probe
+const diagnosticsCommandTimeout = 15 * time.Second
+
+// sshRunner runs diagnostics probe commands over a host connection
+type sshRunner struct {
+	conn *ssh.Connection
+}
+
+func (r sshRunner) Run(cmd string, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticsCommandTimeout)
+	defer cancel()
+	code, err := r.conn.Exec(ctx, ssh.ExecRequest{Command: cmd, Stdout: w, Stderr: io.Discard})
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("%s exited with status %d", cmd, code)
+	}
+	return nil
+}
+
+// handleHostDiagnostics measures the SSH link to a host, to tell a slow host
+// link from a slow client link. The probe runs in the background, as it
+// takes a few round trips.
+func (s *Server) handleHostDiagnostics(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.HostDiagnosticsPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [DIAGNOSTICS] Request: hostId=%s record=%v", payload.HostID, payload.Record)
+
+	conn := s.sshManager.GetConnection(payload.HostID)
+	if conn == nil || !s.sshManager.IsConnected(payload.HostID) {
+		return connSession.sendHostNotConnected(payload.HostID)
+	}
+
+	go func() {
+		if err := s.runHostDiagnostics(connSession, conn, payload); err != nil {
+			log.Printf("[ERROR] [DIAGNOSTICS] Failed to send result to session %s: %v", connSession.ID, err)
+		}
+	}()
+	return nil
+}
+
+// runHostDiagnostics probes a host and sends the result with the
+// connection's health
+func (s *Server) runHostDiagnostics(connSession *ConnectedSession, conn *ssh.Connection, payload protocol.HostDiagnosticsPayload) error {
+	result := protocol.HostDiagnosticsResultPayload{HostID: payload.HostID}
+
+	sample, err := s.diagnostics.Probe(payload.HostID, sshRunner{conn: conn}, payload.Record)
+	var limited *diagnostics.RateLimitError
+	switch {
+	case errors.As(err, &limited):
+		retryAfter := limited.RetryAfter.Milliseconds()
+		result.RetryAfterMs = &retryAfter
+		result.Code = protocol.ErrorExecLimit
+		result.Error = strPtr(err.Error())
+	case err != nil:
+		log.Printf("[WARN] [DIAGNOSTICS] Probe of host %s failed: %v", payload.HostID, err)
+		result.Code = protocol.ErrorExecFailed
+		result.Error = strPtr(err.Error())
+	default:
+		result.Success = true
+		result.Sample = toDiagnosticsSample(sample)
+		log.Printf("[INFO] [DIAGNOSTICS] Host %s: rtt avg=%s throughput=%.0f B/s",
+			payload.HostID, sample.RTTAvg().Round(time.Microsecond), sample.BytesPerSecond)
+	}
+
+	// The health is read after the probe, which may have seen the link die
+	if health, ok := s.sshManager.Health(payload.HostID); ok {
+		result.ConnectedAt = health.ConnectedAt.UTC().Format(time.RFC3339)
+		result.ConnectionAgeSeconds = int64(time.Since(health.ConnectedAt) / time.Second)
+		result.Keepalive = toKeepaliveStatus(health)
+		result.Channels = protocol.HostChannelStatus{
+			Open:              health.Channels.Open,
+			Limit:             health.Channels.Limit,
+			TunnelConnections: health.Channels.TunnelConnections,
+			TunnelOpen:        health.Channels.TunnelOpen,
+		}
+		result.Ops = protocol.HostOpsStatus{
+			Limit:   health.Ops.Limit,
+			Running: health.Ops.Running,
+			Queued:  health.Ops.Queued,
+		}
+	}
+
+	result.Platform = s.hostPlatformInfo(payload.HostID)
+
+	result.History = []protocol.HostDiagnosticsSample{}
+	for _, recorded := range s.diagnostics.History(payload.HostID) {
+		result.History = append(result.History, *toDiagnosticsSample(recorded))
+	}
+
+	response, err := protocol.NewMessage(protocol.TypeHostDiagnosticsResult, result)
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
+
+// toDiagnosticsSample converts a probe sample to its protocol form
+func toDiagnosticsSample(sample diagnostics.Sample) *protocol.HostDiagnosticsSample {
+	rtts := make([]float64, len(sample.RTTs))
+	for i, rtt := range sample.RTTs {
+		rtts[i] = milliseconds(rtt)
+	}
+	return &protocol.HostDiagnosticsSample{
+		At:                    sample.At.UTC().Format(time.RFC3339Nano),
+		RTTMs:                 rtts,
+		RTTMinMs:              milliseconds(sample.RTTMin()),
+		RTTAvgMs:              milliseconds(sample.RTTAvg()),
+		RTTMaxMs:              milliseconds(sample.RTTMax()),
+		ThroughputBytes:       sample.ThroughputBytes,
+		ThroughputMs:          milliseconds(sample.ThroughputDuration),
+		ThroughputBytesPerSec: sample.BytesPerSecond,
+	}
+}
+
+// toKeepaliveStatus describes the keepalives of a host's connection
+func toKeepaliveStatus(health ssh.Health) protocol.HostKeepaliveStatus {
+	status := protocol.HostKeepaliveStatus{IntervalSeconds: int(health.KeepaliveInterval / time.Second)}
+	if !health.LastKeepalive.IsZero() {
+		status.LastOkAt = strPtr(health.LastKeepalive.UTC().Format(time.RFC3339))
+		rtt := milliseconds(health.LastKeepaliveRTT)
+		status.LastRTTMs = &rtt
+	}
+	if health.LastFailure != nil {
+		status.LastFailure = strPtr(health.LastFailure.Error)
+		status.LastFailureAt = strPtr(health.LastFailure.At.UTC().Format(time.RFC3339))
+	}
+	return status
+}
+
+// milliseconds returns d in milliseconds, with fractions
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
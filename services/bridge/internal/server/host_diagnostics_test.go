@@ -0,0 +1,78 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/diagnostics"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+func TestHostDiagnostics(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	const delay = 20 * time.Millisecond
+	srv := startTestSSHServer(t)
+	srv.HandleExec(func(cmd string) string {
+		time.Sleep(delay)
+		if strings.Contains(cmd, "head -c ") {
+			return strings.Repeat("\x00", diagnostics.ThroughputBytes)
+		}
+		return ""
+	})
+	if _, err := s.sshManager.Connect("host-1", "127.0.0.1", srv.Port(), "user",
+		ssh.AuthConfig{AuthType: "password", Password: "secret"}); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	conn, cs := connectTestClient(t, s)
+	readPayload(t, conn, protocol.TypeHostStatus, nil)
+	readPayload(t, conn, protocol.TypeHostRequirementsResult, nil)
+
+	var result protocol.HostDiagnosticsResultPayload
+	dispatch(t, s, cs, protocol.TypeHostDiagnostics, protocol.HostDiagnosticsPayload{HostID: "host-1", Record: true})
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	readPayload(t, conn, protocol.TypeHostDiagnosticsResult, &result)
+	if !result.Success || result.Sample == nil {
+		t.Fatalf("result = %+v", result)
+	}
+	sample := result.Sample
+	if len(sample.RTTMs) != diagnostics.RTTProbes || sample.RTTMinMs < float64(delay/time.Millisecond) ||
+		sample.RTTMinMs > sample.RTTAvgMs || sample.RTTAvgMs > sample.RTTMaxMs {
+		t.Errorf("round trips = %+v", sample)
+	}
+	if sample.ThroughputBytes != diagnostics.ThroughputBytes || sample.ThroughputBytesPerSec <= 0 {
+		t.Errorf("throughput = %+v", sample)
+	}
+	if result.ConnectedAt == "" || result.Channels.Limit == 0 || result.Keepalive.IntervalSeconds == 0 ||
+		result.Ops != (protocol.HostOpsStatus{Limit: ssh.DefaultMaxConcurrentOps}) {
+		t.Errorf("health = %+v", result)
+	}
+	if len(result.History) != 1 {
+		t.Errorf("history = %+v", result.History)
+	}
+
+	// A second probe within the interval is refused, with the history kept
+	result = protocol.HostDiagnosticsResultPayload{}
+	dispatch(t, s, cs, protocol.TypeHostDiagnostics, protocol.HostDiagnosticsPayload{HostID: "host-1", Record: true})
+	readPayload(t, conn, protocol.TypeHostDiagnosticsResult, &result)
+	if result.Success || result.RetryAfterMs == nil || *result.RetryAfterMs <= 0 ||
+		*result.RetryAfterMs > diagnostics.MinInterval.Milliseconds() || result.Sample != nil {
+		t.Errorf("rate limited result = %+v", result)
+	}
+	if len(result.History) != 1 {
+		t.Errorf("history = %+v", result.History)
+	}
+}
+
+func TestHostDiagnosticsNotConnected(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+
+	dispatch(t, s, cs, protocol.TypeHostDiagnostics, protocol.HostDiagnosticsPayload{HostID: "missing"})
+	var errPayload protocol.ErrorPayload
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotConnected {
+		t.Errorf("code = %s", errPayload.Code)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"log"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// ============================================================================
+// Host Disconnect Notifications
+// ============================================================================
+
+// teardownHost detaches and unregisters all processes for a host and closes its
+// SSH connection. Tmux sessions keep running on the remote host.
+func (s *Server) teardownHost(hostID string) {
+	procs := s.processRegistry.GetByHost(hostID)
+	for _, proc := range procs {
+		proc.Detach()
+		s.processRegistry.Unregister(proc.ID)
+	}
+
+	// Clear stale processes for this host
+	s.processRegistry.ClearStaleProcesses(hostID)
+
+	// Close SSH connection
+	s.sshManager.Disconnect(hostID)
+	s.forgetRequirements(hostID)
+}
+
+// handleConnectionLost is called by the SSH manager when a host connection
+// dies without a user disconnect
+func (s *Server) handleConnectionLost(hostID string, err error) {
+	log.Printf("[WARN] [HOST] Connection lost for hostID=%s: %v", hostID, err)
+
+	s.teardownHost(hostID)
+	for _, sess := range s.sessionManager.GetConnectedSessions() {
+		s.sessionManager.RemoveHostConnection(sess.ID, hostID)
+	}
+
+	s.broadcastHostDisconnected(hostID, protocol.HostDisconnectKeepaliveFailed, strPtr(err.Error()))
+}
+
+// broadcastHostDisconnected tells every connected session whose scope allows
+// the host that it is no longer connected, and why
+func (s *Server) broadcastHostDisconnected(hostID string, reason protocol.HostDisconnectReason, errMsg *string) {
+	status := protocol.HostStatusPayload{
+		HostID:    hostID,
+		Connected: false,
+		Processes: []protocol.ProcessInfo{},
+		Error:     errMsg,
+		Reason:    &reason,
+	}
+	if errMsg != nil {
+		status.Code = protocol.ErrorNotConnected
+	}
+
+	log.Printf("[INFO] [HOST] Host %s disconnected (%s), notifying all sessions", hostID, reason)
+	for _, sess := range s.sessionManager.GetConnectedSessions() {
+		cs := &ConnectedSession{Session: sess, server: s}
+		if err := cs.sendScopedHostStatus(status); err != nil {
+			log.Printf("[WARN] [WS] Failed to send host status to session %s: %v", sess.ID, err)
+		}
+	}
+}
+
+// broadcast sends a message to every connected session
+func (s *Server) broadcast(msg *protocol.Message) {
+	for _, sess := range s.sessionManager.GetConnectedSessions() {
+		cs := &ConnectedSession{Session: sess, server: s}
+		if err := cs.Send(msg); err != nil {
+			log.Printf("[WARN] [WS] Failed to broadcast %s to session %s: %v", msg.Type, sess.ID, err)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/agentapi"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is a minimal in-process SSH server that accepts the password
+// "secret" and answers keepalives. Drop closes every accepted connection,
+// which looks like the host going away to the client. Sessions are rejected
+// unless HandleExec or HandleExecInput installs a handler for exec
+// requests; other channels, such as port forwards, are always rejected,
+// after DelayTunnels if set.
+type testSSHServer struct {
+	listener    net.Listener
+	mu          sync.Mutex
+	conns       []net.Conn
+	exec        func(cmd string, stdin io.Reader) string
+	tunnelDelay time.Duration
+}
+
+func startTestSSHServer(t *testing.T) *testSSHServer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	signer, err := cryptossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey: %v", err)
+	}
+	config := &cryptossh.ServerConfig{
+		PasswordCallback: func(c cryptossh.ConnMetadata, pass []byte) (*cryptossh.Permissions, error) {
+			if string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, cryptossh.ErrNoAuth
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	srv := &testSSHServer{listener: listener}
+	t.Cleanup(func() {
+		listener.Close()
+		srv.Drop()
+	})
+
+	go func() {
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			srv.mu.Lock()
+			srv.conns = append(srv.conns, netConn)
+			srv.mu.Unlock()
+
+			go func() {
+				_, chans, reqs, err := cryptossh.NewServerConn(netConn, config)
+				if err != nil {
+					return
+				}
+				go func() {
+					for req := range reqs {
+						req.Reply(true, nil)
+					}
+				}()
+				for ch := range chans {
+					if ch.ChannelType() != "session" {
+						go srv.rejectTunnel(ch)
+						continue
+					}
+					exec := srv.execHandler()
+					if exec == nil {
+						ch.Reject(cryptossh.Prohibited, "no channels in tests")
+						continue
+					}
+					go serveExec(ch, exec)
+				}
+			}()
+		}
+	}()
+	return srv
+}
+
+// HandleExec answers exec requests on new sessions with the output of fn,
+// or rejects sessions again when fn is nil
+func (srv *testSSHServer) HandleExec(fn func(cmd string) string) {
+	if fn == nil {
+		srv.HandleExecInput(nil)
+		return
+	}
+	srv.HandleExecInput(func(cmd string, _ io.Reader) string { return fn(cmd) })
+}
+
+// HandleExecInput is HandleExec for a handler that reads the session's
+// stdin
+func (srv *testSSHServer) HandleExecInput(fn func(cmd string, stdin io.Reader) string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.exec = fn
+}
+
+// DelayTunnels makes the server take d to turn down each port forward, as a
+// slow AgentAPI port scan
+func (srv *testSSHServer) DelayTunnels(d time.Duration) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.tunnelDelay = d
+}
+
+func (srv *testSSHServer) rejectTunnel(ch cryptossh.NewChannel) {
+	srv.mu.Lock()
+	delay := srv.tunnelDelay
+	srv.mu.Unlock()
+	time.Sleep(delay)
+	ch.Reject(cryptossh.Prohibited, "no channels in tests")
+}
+
+func (srv *testSSHServer) execHandler() func(cmd string, stdin io.Reader) string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.exec
+}
+
+// serveExec runs a single exec request on a session channel and exits 0.
+// A PTY may be requested first; output is written to it the same way, but
+// the command gets no stdin, as a terminal's is never closed.
+func serveExec(newCh cryptossh.NewChannel, exec func(cmd string, stdin io.Reader) string) {
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	var stdin io.Reader = ch
+	for req := range reqs {
+		if req.Type == "pty-req" {
+			stdin = strings.NewReader("")
+			req.Reply(true, nil)
+			continue
+		}
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		if err := cryptossh.Unmarshal(req.Payload, &payload); err != nil {
+			req.Reply(false, nil)
+			return
+		}
+		req.Reply(true, nil)
+		ch.Write([]byte(exec(payload.Command, stdin)))
+		ch.SendRequest("exit-status", false, cryptossh.Marshal(struct{ Status uint32 }{0}))
+		return
+	}
+}
+
+func (srv *testSSHServer) Port() int {
+	return srv.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (srv *testSSHServer) Drop() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, c := range srv.conns {
+		c.Close()
+	}
+	srv.conns = nil
+}
+
+func TestKeepaliveFailureBroadcastsHostStatus(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	s.sshManager.KeepAliveInterval = 20 * time.Millisecond
+
+	srv := startTestSSHServer(t)
+	if _, err := s.sshManager.Connect("host-1", "127.0.0.1", srv.Port(), "user",
+		ssh.AuthConfig{AuthType: "password", Password: "secret"}); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	// Each client is sent the current state of the connected host on auth
+	clients := []*websocket.Conn{}
+	for i := 0; i < 2; i++ {
+		conn, _ := connectTestClient(t, s)
+		readPayload(t, conn, protocol.TypeHostStatus, nil)
+		readPayload(t, conn, protocol.TypeHostRequirementsResult, nil)
+		clients = append(clients, conn)
+	}
+
+	srv.Drop()
+
+	for _, conn := range clients {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var status protocol.HostStatusPayload
+		readPayload(t, conn, protocol.TypeHostStatus, &status)
+		if status.HostID != "host-1" || status.Connected {
+			t.Errorf("unexpected host status: %+v", status)
+		}
+		if status.Reason == nil || *status.Reason != protocol.HostDisconnectKeepaliveFailed {
+			t.Errorf("reason = %v, want %s", status.Reason, protocol.HostDisconnectKeepaliveFailed)
+		}
+	}
+
+	if s.sshManager.GetConnection("host-1") != nil {
+		t.Error("lost connection should be removed from the manager")
+	}
+}
+
+func TestUserDisconnectNotifiesOtherSessions(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+
+	srv := startTestSSHServer(t)
+	if _, err := s.sshManager.Connect("host-1", "127.0.0.1", srv.Port(), "user",
+		ssh.AuthConfig{AuthType: "password", Password: "secret"}); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	_, csA := connectTestClient(t, s)
+	connB, _ := connectTestClient(t, s)
+	readPayload(t, connB, protocol.TypeHostStatus, nil)
+	readPayload(t, connB, protocol.TypeHostRequirementsResult, nil)
+
+	dispatch(t, s, csA, protocol.TypeHostDisconnect, protocol.HostDisconnectPayload{HostID: "host-1"})
+
+	connB.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var status protocol.HostStatusPayload
+	readPayload(t, connB, protocol.TypeHostStatus, &status)
+	if status.Connected || status.Reason == nil || *status.Reason != protocol.HostDisconnectUserDisconnect {
+		t.Errorf("unexpected host status: %+v", status)
+	}
+}
+
+func TestDeadHostOnAuthStopsSSE(t *testing.T) {
+	s := newQuietServer(t)
+	// Only the auth-time check may notice the host is gone
+	s.sshManager.KeepAliveInterval = time.Hour
+
+	srv := startTestSSHServer(t)
+	sshConn, err := s.sshManager.Connect("host-1", "127.0.0.1", srv.Port(), "user",
+		ssh.AuthConfig{AuthType: "password", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	// A Claude process whose event stream keeps retrying: the server
+	// rejects tunnels
+	before := agentapi.ConnectionLoops()
+	proc := &process.Process{ID: "proc-0", HostID: "host-1", Type: process.TypeClaude}
+	proc.SetPort(3284)
+	s.processRegistry.Register(proc)
+	sse := s.newSSEClient(sshConn, proc, 3284)
+	proc.SetAgentClients(agentapi.NewClient(sshConn, 3284), sse)
+	sse.Connect()
+
+	srv.Drop()
+	conn, _ := connectTestClient(t, s)
+	var status protocol.HostStatusPayload
+	readPayload(t, conn, protocol.TypeHostStatus, &status)
+	if status.Connected || s.processRegistry.Get("proc-0") != nil {
+		t.Fatalf("dead host still connected: %+v", status)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for agentapi.ConnectionLoops() > before {
+		if time.Now().After(deadline) {
+			t.Fatal("the removed process's SSE stream still retries against the dead tunnel")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// defaultHostExecTimeout applies to host_exec commands that don't give one
+const defaultHostExecTimeout = 30 * time.Second
+
+// hostExecFunc runs a command on a host connection and returns its exit
+// status; see ssh.Exec
+type hostExecFunc func(ctx context.Context, conn *ssh.Connection, req ssh.ExecRequest) (int, error)
+
+// cappedBuffer keeps the first max bytes written to it and discards the
+// rest, so a chatty command can't exhaust memory
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// hostExecTimeout returns the timeout for a host_exec command, capped by
+// the configured maximum
+func (s *Server) hostExecTimeout(timeoutMs *int) time.Duration {
+	timeout := defaultHostExecTimeout
+	if timeoutMs != nil && *timeoutMs > 0 {
+		timeout = time.Duration(*timeoutMs) * time.Millisecond
+	}
+	if limit := s.config.HostExecMaxTimeout; limit > 0 && timeout > limit {
+		timeout = limit
+	}
+	return timeout
+}
+
+// handleHostExec runs a one-off command on a host in its own SSH session,
+// outside any process. Commands run in the background so a slow one doesn't
+// hold up the session's other messages; the number running at once per
+// session is capped.
+func (s *Server) handleHostExec(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.HostExecPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(payload.Command) == "" {
+		return connSession.SendErrorDetails(protocol.ErrorInvalidArgs, protocol.InvalidArgsDetails{
+			Tokens: []string{},
+			Reason: "command is required",
+		})
+	}
+
+	conn := s.sshManager.GetConnection(payload.HostID)
+	if conn == nil {
+		return connSession.sendHostNotConnected(payload.HostID)
+	}
+
+	limit := s.config.HostExecMaxConcurrent
+	if !connSession.AcquireExec(limit) {
+		log.Printf("[WARN] [EXEC] Session %s refused command on host %s: %d already running", connSession.ID, payload.HostID, limit)
+		return connSession.SendErrorDetails(protocol.ErrorExecLimit, protocol.ErrorDetails{
+			"hostId":    payload.HostID,
+			"limit":     limit,
+			"requestId": payload.RequestID,
+			"reason":    fmt.Sprintf("%d commands are already running", limit),
+		})
+	}
+
+	var dir string
+	if payload.CWD != nil {
+		dir = *payload.CWD
+	}
+	timeout := s.hostExecTimeout(payload.TimeoutMs)
+
+	// There is no audit log, so the log is the record of what was run
+	log.Printf("[INFO] [EXEC] Session %s running on host %s (cwd=%q, timeout=%s): %s", connSession.ID, payload.HostID, dir, timeout, payload.Command)
+
+	go func() {
+		defer connSession.ReleaseExec()
+		if err := s.runHostExec(connSession, conn, payload, dir, timeout); err != nil {
+			log.Printf("[ERROR] [EXEC] Failed to send result to session %s: %v", connSession.ID, err)
+		}
+	}()
+	return nil
+}
+
+// runHostExec runs a host_exec command and sends its result
+func (s *Server) runHostExec(connSession *ConnectedSession, conn *ssh.Connection, payload protocol.HostExecPayload, dir string, timeout time.Duration) error {
+	stdout := &cappedBuffer{max: s.config.HostExecMaxOutput}
+	stderr := &cappedBuffer{max: s.config.HostExecMaxOutput}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	exitCode, err := s.hostExec(ctx, conn, ssh.ExecRequest{
+		Command: payload.Command,
+		Dir:     dir,
+		Stdout:  stdout,
+		Stderr:  stderr,
+	})
+	duration := time.Since(start)
+
+	timedOut := errors.Is(err, context.DeadlineExceeded)
+	if err != nil && !timedOut {
+		log.Printf("[ERROR] [EXEC] Command on host %s failed: %v", payload.HostID, err)
+		return connSession.SendErrorDetails(protocol.ErrorExecFailed,
+			protocol.ErrorDetails{"hostId": payload.HostID, "requestId": payload.RequestID, "reason": err.Error()})
+	}
+
+	log.Printf("[INFO] [EXEC] Command on host %s finished: exit=%d timedOut=%v duration=%s stdout=%d stderr=%d",
+		payload.HostID, exitCode, timedOut, duration.Round(time.Millisecond), stdout.buf.Len(), stderr.buf.Len())
+
+	response, err := protocol.NewMessage(protocol.TypeHostExecResult, protocol.HostExecResultPayload{
+		RequestID:       payload.RequestID,
+		HostID:          payload.HostID,
+		Command:         payload.Command,
+		ExitCode:        exitCode,
+		TimedOut:        timedOut,
+		DurationMs:      duration.Milliseconds(),
+		Stdout:          stdout.buf.String(),
+		Stderr:          stderr.buf.String(),
+		StdoutTruncated: stdout.truncated,
+		StderrTruncated: stderr.truncated,
+	})
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
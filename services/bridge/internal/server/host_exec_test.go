@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// fakeExec replaces host_exec's SSH layer. Commands it knows:
+//
+//	out <n>    writes n bytes to stdout and n/2 to stderr
+//	exit <n>   writes to stderr and exits n
+//	sleep      runs until the timeout
+//	block      waits for release
+//	broken     fails to run
+type fakeExec struct {
+	release chan struct{}
+	started chan ssh.ExecRequest
+}
+
+func newFakeExec(s *Server) *fakeExec {
+	f := &fakeExec{release: make(chan struct{}), started: make(chan ssh.ExecRequest, 16)}
+	s.hostExec = f.exec
+	return f
+}
+
+func (f *fakeExec) exec(ctx context.Context, conn *ssh.Connection, req ssh.ExecRequest) (int, error) {
+	f.started <- req
+	name, arg, _ := strings.Cut(req.Command, " ")
+	switch name {
+	case "out":
+		var n int
+		fmt.Sscan(arg, &n)
+		// Written in pieces, as a session delivers it
+		for i := 0; i < n; i += 100 {
+			io.WriteString(req.Stdout, strings.Repeat("o", min(100, n-i)))
+		}
+		io.WriteString(req.Stderr, strings.Repeat("e", n/2))
+		return 0, nil
+	case "exit":
+		var code int
+		fmt.Sscan(arg, &code)
+		io.WriteString(req.Stderr, "failed\n")
+		return code, nil
+	case "sleep":
+		<-ctx.Done()
+		return -1, ctx.Err()
+	case "block":
+		<-f.release
+		return 0, nil
+	}
+	return -1, errors.New("failed to create SSH session: channel rejected")
+}
+
+// runExec sends host_exec and reads the result
+func runExec(t *testing.T, s *Server, conn *websocket.Conn, cs *ConnectedSession, payload protocol.HostExecPayload) protocol.HostExecResultPayload {
+	t.Helper()
+	dispatch(t, s, cs, protocol.TypeHostExec, payload)
+	var result protocol.HostExecResultPayload
+	readPayload(t, conn, protocol.TypeHostExecResult, &result)
+	return result
+}
+
+func TestHostExec(t *testing.T) {
+	config := DefaultConfig()
+	config.CWDRefreshInterval = 0
+	config.HostExecMaxOutput = 1000
+	config.HostExecMaxTimeout = 50 * time.Millisecond
+	s := newTestServer(t, config)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	fake := newFakeExec(s)
+
+	cwd := "/srv/app"
+	result := runExec(t, s, conn, cs, protocol.HostExecPayload{RequestID: "r1", HostID: "host-1", Command: "out 600", CWD: &cwd})
+	if req := <-fake.started; req.Dir != "/srv/app" {
+		t.Errorf("dir = %q, want the requested cwd", req.Dir)
+	}
+	if result.RequestID != "r1" || result.ExitCode != 0 || result.TimedOut || len(result.Stdout) != 600 || len(result.Stderr) != 300 ||
+		result.StdoutTruncated || result.StderrTruncated {
+		t.Errorf("small output: %+v", result)
+	}
+
+	// Each stream is capped on its own
+	result = runExec(t, s, conn, cs, protocol.HostExecPayload{HostID: "host-1", Command: "out 1500"})
+	<-fake.started
+	if len(result.Stdout) != 1000 || !result.StdoutTruncated || len(result.Stderr) != 750 || result.StderrTruncated {
+		t.Errorf("truncation: stdout %d (%v), stderr %d (%v)", len(result.Stdout), result.StdoutTruncated, len(result.Stderr), result.StderrTruncated)
+	}
+
+	result = runExec(t, s, conn, cs, protocol.HostExecPayload{HostID: "host-1", Command: "exit 3"})
+	<-fake.started
+	if result.ExitCode != 3 || result.TimedOut || result.Stderr != "failed\n" || result.Command != "exit 3" {
+		t.Errorf("nonzero exit: %+v", result)
+	}
+
+	// The requested timeout is capped by the configured maximum
+	timeoutMs := int(time.Hour / time.Millisecond)
+	start := time.Now()
+	result = runExec(t, s, conn, cs, protocol.HostExecPayload{HostID: "host-1", Command: "sleep", TimeoutMs: &timeoutMs})
+	<-fake.started
+	if !result.TimedOut || result.ExitCode != -1 || result.DurationMs < 50 {
+		t.Errorf("timeout: %+v", result)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timed out after %s, want the 50ms maximum", elapsed)
+	}
+
+	dispatch(t, s, cs, protocol.TypeHostExec, protocol.HostExecPayload{RequestID: "r2", HostID: "host-1", Command: "broken"})
+	<-fake.started
+	var errPayload struct {
+		Code    protocol.ErrorCode    `json:"code"`
+		Details protocol.ErrorDetails `json:"details"`
+	}
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorExecFailed || errPayload.Details["requestId"] != "r2" {
+		t.Errorf("failure: %+v", errPayload)
+	}
+}
+
+func TestHostExecRejects(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	newFakeExec(s)
+
+	var errPayload protocol.ErrorPayload
+	dispatch(t, s, cs, protocol.TypeHostExec, protocol.HostExecPayload{HostID: "host-1", Command: "  "})
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorInvalidArgs {
+		t.Errorf("empty command: %s", errPayload.Code)
+	}
+
+	dispatch(t, s, cs, protocol.TypeHostExec, protocol.HostExecPayload{HostID: "host-2", Command: "uptime"})
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotConnected {
+		t.Errorf("unknown host: %s", errPayload.Code)
+	}
+}
+
+func TestHostExecConcurrencyCap(t *testing.T) {
+	config := DefaultConfig()
+	config.CWDRefreshInterval = 0
+	config.HostExecMaxConcurrent = 2
+	s := newTestServer(t, config)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	fake := newFakeExec(s)
+
+	for i := 0; i < 2; i++ {
+		dispatch(t, s, cs, protocol.TypeHostExec, protocol.HostExecPayload{HostID: "host-1", Command: "block"})
+		<-fake.started
+	}
+
+	// A third is refused while both run
+	dispatch(t, s, cs, protocol.TypeHostExec, protocol.HostExecPayload{RequestID: "r3", HostID: "host-1", Command: "uptime"})
+	var errPayload struct {
+		Code    protocol.ErrorCode    `json:"code"`
+		Details protocol.ErrorDetails `json:"details"`
+	}
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorExecLimit || errPayload.Details["limit"] != float64(2) || errPayload.Details["requestId"] != "r3" {
+		t.Errorf("over the cap: %+v", errPayload)
+	}
+
+	// Another session has its own slots
+	otherConn, otherCS := connectTestClient(t, s)
+	result := runExec(t, s, otherConn, otherCS, protocol.HostExecPayload{HostID: "host-1", Command: "exit 0"})
+	<-fake.started
+	if result.ExitCode != 0 {
+		t.Errorf("other session: %+v", result)
+	}
+
+	// Finished commands free their slots
+	close(fake.release)
+	for i := 0; i < 2; i++ {
+		readPayload(t, conn, protocol.TypeHostExecResult, nil)
+	}
+	runExec(t, s, conn, cs, protocol.HostExecPayload{HostID: "host-1", Command: "exit 0"})
+	<-fake.started
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/sshconfig"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+// handleHostConfigImportSSHConfig lists the hosts of an ssh_config and
+// creates host configs for the ones selected. A host with an identity file
+// is imported with key auth, its key read from the bridge machine only when
+// the user agreed to it; other hosts use the bridge's SSH agent.
+func (s *Server) handleHostConfigImportSSHConfig(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.HostConfigImportSSHConfigPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return s.sendHostConfigImportResult(connSession, protocol.HostConfigImportSSHConfigResultPayload{}, newFailure(protocol.ErrorInvalidArgs, "invalid payload: "+err.Error()))
+	}
+
+	log.Printf("[DEBUG] [HOST_CONFIG] Import ssh_config request: uploaded=%v select=%v", payload.ConfigText != nil, payload.Select)
+
+	config, err := loadSSHConfig(payload.ConfigText)
+	if err != nil {
+		log.Printf("[WARN] [HOST_CONFIG] Failed to read ssh_config: %v", err)
+		return s.sendHostConfigImportResult(connSession, protocol.HostConfigImportSSHConfigResultPayload{}, err)
+	}
+
+	existing, err := s.storage.ListSSHHosts()
+	if err != nil {
+		log.Printf("[ERROR] [HOST_CONFIG] Failed to list hosts: %v", err)
+		return s.sendHostConfigImportResult(connSession, protocol.HostConfigImportSSHConfigResultPayload{}, newFailure(protocol.ErrorStorageError, "failed to list hosts"))
+	}
+	names := make(map[string]bool, len(existing))
+	for _, h := range existing {
+		names[h.Name] = true
+	}
+
+	result := protocol.HostConfigImportSSHConfigResultPayload{
+		Entries:  []protocol.SSHConfigEntry{},
+		Warnings: config.Warnings,
+	}
+	for _, alias := range config.Hosts() {
+		entry := toSSHConfigEntry(config.Resolve(alias))
+		entry.Exists = names[alias]
+		result.Entries = append(result.Entries, entry)
+	}
+
+	autoConnect := payload.AutoConnect != nil && *payload.AutoConnect
+	for _, alias := range payload.Select {
+		i := slices.IndexFunc(result.Entries, func(e protocol.SSHConfigEntry) bool { return e.Alias == alias })
+		if i < 0 {
+			result.Failed = append(result.Failed, protocol.SSHConfigImportFailure{Alias: alias, Error: "not a host in the config"})
+			continue
+		}
+		created, warnings, err := s.importSSHConfigEntry(result.Entries[i], payload.ReadIdentityFiles, autoConnect)
+		if err != nil {
+			result.Failed = append(result.Failed, protocol.SSHConfigImportFailure{Alias: alias, Error: err.Error()})
+			continue
+		}
+		result.Warnings = append(result.Warnings, warnings...)
+		result.Entries[i].Exists = true
+		result.Created = append(result.Created, *created)
+	}
+
+	if len(payload.Select) > 0 {
+		log.Printf("[INFO] [HOST_CONFIG] Imported %d of %d selected ssh_config hosts", len(result.Created), len(payload.Select))
+	}
+	return s.sendHostConfigImportResult(connSession, result, nil)
+}
+
+// loadSSHConfig parses uploaded config text, or else the bridge user's
+// ~/.ssh/config
+func loadSSHConfig(configText *string) (*sshconfig.Config, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, newFailure(protocol.ErrorHandlerError, "failed to find home directory: "+err.Error())
+	}
+	if configText != nil {
+		return sshconfig.Parse(strings.NewReader(*configText), home)
+	}
+	config, err := sshconfig.Load(filepath.Join(home, ".ssh", "config"), home)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, newFailure(protocol.ErrorFileNotFound, "no ~/.ssh/config on the bridge machine")
+	}
+	return config, err
+}
+
+// toSSHConfigEntry describes how a resolved ssh_config host would be imported
+func toSSHConfigEntry(e sshconfig.Entry) protocol.SSHConfigEntry {
+	entry := protocol.SSHConfigEntry{
+		Alias:    e.Alias,
+		HostName: e.HostName,
+		User:     e.User,
+		Port:     e.Port,
+		AuthType: "agent",
+	}
+	if entry.User == "" {
+		// ssh logs in as the local user
+		if u, err := user.Current(); err == nil {
+			entry.User = u.Username
+		}
+	}
+	for _, path := range e.IdentityFiles {
+		if _, err := os.Stat(path); err == nil {
+			entry.AuthType = "key"
+			entry.IdentityFile = strPtr(path)
+			break
+		}
+	}
+	if e.ProxyJump != "" {
+		entry.ProxyJump = strPtr(e.ProxyJump)
+	}
+	return entry
+}
+
+// importSSHConfigEntry creates a host config for an ssh_config host, and
+// returns warnings about what didn't carry over. A passphrase-protected key
+// can't be stored, so the host falls back to the agent, which likely holds it.
+func (s *Server) importSSHConfigEntry(entry protocol.SSHConfigEntry, readIdentityFiles, autoConnect bool) (*protocol.SSHHostConfig, []string, error) {
+	if entry.Exists {
+		return nil, nil, fmt.Errorf("a host config named %s exists", entry.Alias)
+	}
+	if entry.User == "" {
+		return nil, nil, fmt.Errorf("no user set")
+	}
+
+	host := storage.SSHHost{
+		Name:        entry.Alias,
+		Host:        entry.HostName,
+		Port:        entry.Port,
+		Username:    entry.User,
+		AuthType:    entry.AuthType,
+		AutoConnect: autoConnect,
+	}
+	var credential string
+	var warnings []string
+	if entry.ProxyJump != nil {
+		warnings = append(warnings, fmt.Sprintf("%s: ProxyJump %s is not supported, so the host is connected to directly", entry.Alias, *entry.ProxyJump))
+	}
+	if entry.AuthType == "key" {
+		if !readIdentityFiles {
+			return nil, nil, fmt.Errorf("reading identity file %s needs confirmation", *entry.IdentityFile)
+		}
+		key, err := os.ReadFile(*entry.IdentityFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read identity file: %w", err)
+		}
+		_, err = ssh.ParsePrivateKey(key)
+		var missing *ssh.PassphraseMissingError
+		switch {
+		case errors.As(err, &missing):
+			host.AuthType = "agent"
+			warnings = append(warnings, fmt.Sprintf("%s: %s is passphrase-protected, so the SSH agent is used instead", entry.Alias, *entry.IdentityFile))
+		case err != nil:
+			return nil, nil, fmt.Errorf("identity file %s is not a private key: %w", *entry.IdentityFile, err)
+		default:
+			credential = string(key)
+		}
+	}
+
+	created, err := s.createSSHHost(host, credential)
+	return created, warnings, err
+}
+
+func (s *Server) sendHostConfigImportResult(connSession *ConnectedSession, payload protocol.HostConfigImportSSHConfigResultPayload, err error) error {
+	payload.Success = err == nil
+	if payload.Entries == nil {
+		payload.Entries = []protocol.SSHConfigEntry{}
+	}
+	if err != nil {
+		errStr := err.Error()
+		// Anything else is a config that doesn't parse
+		payload.Code = failureCode(err, protocol.ErrorInvalidArgs)
+		payload.Error = &errStr
+	}
+	msg, _ := protocol.NewMessage(protocol.TypeHostConfigImportSSHConfigResult, payload)
+	return connSession.Send(msg)
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"golang.org/x/crypto/ssh"
+)
+
+// writeSSHConfig makes a home directory whose ~/.ssh holds config and a
+// plain and a passphrase-protected key, and returns the plain key
+func writeSSHConfig(t *testing.T, config string) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := filepath.Join(home, ".ssh")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := string(pem.EncodeToMemory(plain))
+	for name, data := range map[string]string{
+		"config":    config,
+		"id_plain":  key,
+		"id_locked": string(pem.EncodeToMemory(locked)),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return key
+}
+
+const importTestConfig = `
+Host web
+    HostName web.example.com
+    User deploy
+    Port 2222
+    IdentityFile ~/.ssh/id_missing
+    IdentityFile ~/.ssh/id_plain
+
+Host db
+    HostName db.internal
+    User admin
+    ProxyJump bastion
+
+Host locked
+    User me
+    IdentityFile ~/.ssh/id_locked
+
+Match host *.corp
+    User matched
+`
+
+func TestHostConfigImportSSHConfig(t *testing.T) {
+	key := writeSSHConfig(t, importTestConfig)
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+
+	var result protocol.HostConfigImportSSHConfigResultPayload
+	dispatch(t, s, cs, protocol.TypeHostConfigImportSSHConfig, protocol.HostConfigImportSSHConfigPayload{})
+	readPayload(t, conn, protocol.TypeHostConfigImportSSHConfigResult, &result)
+	if !result.Success || len(result.Entries) != 3 || len(result.Created) != 0 {
+		t.Fatalf("listing = %+v", result)
+	}
+	web, db := result.Entries[0], result.Entries[1]
+	if web.Alias != "web" || web.HostName != "web.example.com" || web.User != "deploy" || web.Port != 2222 ||
+		web.AuthType != "key" || web.IdentityFile == nil || !strings.HasSuffix(*web.IdentityFile, "/.ssh/id_plain") {
+		t.Errorf("web = %+v", web)
+	}
+	if db.AuthType != "agent" || db.IdentityFile != nil || db.ProxyJump == nil || *db.ProxyJump != "bastion" || db.Port != 22 {
+		t.Errorf("db = %+v", db)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "Match block skipped") {
+		t.Errorf("warnings = %q", result.Warnings)
+	}
+
+	// Keys are only read once the user agreed to it
+	result = protocol.HostConfigImportSSHConfigResultPayload{}
+	dispatch(t, s, cs, protocol.TypeHostConfigImportSSHConfig, protocol.HostConfigImportSSHConfigPayload{Select: []string{"web", "db", "nope"}})
+	readPayload(t, conn, protocol.TypeHostConfigImportSSHConfigResult, &result)
+	if len(result.Created) != 1 || result.Created[0].Name != "db" || result.Created[0].AuthType != "agent" {
+		t.Errorf("created = %+v", result.Created)
+	}
+	if len(result.Failed) != 2 || result.Failed[0].Alias != "web" || !strings.Contains(result.Failed[0].Error, "needs confirmation") ||
+		result.Failed[1].Alias != "nope" {
+		t.Errorf("failed = %+v", result.Failed)
+	}
+	if !strings.Contains(strings.Join(result.Warnings, "\n"), "ProxyJump bastion is not supported") {
+		t.Errorf("warnings = %q", result.Warnings)
+	}
+
+	result = protocol.HostConfigImportSSHConfigResultPayload{}
+	dispatch(t, s, cs, protocol.TypeHostConfigImportSSHConfig, protocol.HostConfigImportSSHConfigPayload{
+		Select: []string{"web", "locked", "db"}, ReadIdentityFiles: true,
+	})
+	readPayload(t, conn, protocol.TypeHostConfigImportSSHConfigResult, &result)
+	if len(result.Created) != 2 || result.Created[0].AuthType != "key" || result.Created[1].AuthType != "agent" {
+		t.Errorf("created = %+v", result.Created)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].Alias != "db" || !strings.Contains(result.Failed[0].Error, "exists") {
+		t.Errorf("failed = %+v", result.Failed)
+	}
+	if !strings.Contains(strings.Join(result.Warnings, "\n"), "passphrase-protected") {
+		t.Errorf("warnings = %q", result.Warnings)
+	}
+
+	stored, err := s.storage.GetSSHHost(result.Created[0].ID)
+	if err != nil || stored == nil {
+		t.Fatalf("GetSSHHost: %v", err)
+	}
+	if credential, err := s.cipher.DecryptString(stored.CredentialEncrypted); err != nil || credential != key {
+		t.Errorf("stored key = %q, %v; want the identity file", credential, err)
+	}
+}
+
+func TestHostConfigImportUploadedSSHConfig(t *testing.T) {
+	writeSSHConfig(t, "Host local\n")
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+
+	// Uploaded text replaces the bridge's config, and can't include its files
+	text := "Include ~/.ssh/config\nHost uploaded\n    HostName 10.0.0.5\n    User root\n"
+	var result protocol.HostConfigImportSSHConfigResultPayload
+	dispatch(t, s, cs, protocol.TypeHostConfigImportSSHConfig, protocol.HostConfigImportSSHConfigPayload{ConfigText: &text, Select: []string{"uploaded"}})
+	readPayload(t, conn, protocol.TypeHostConfigImportSSHConfigResult, &result)
+	if len(result.Entries) != 1 || result.Entries[0].Alias != "uploaded" || !result.Entries[0].Exists {
+		t.Errorf("entries = %+v", result.Entries)
+	}
+	if len(result.Created) != 1 || result.Created[0].Host != "10.0.0.5" || result.Created[0].Username != "root" {
+		t.Errorf("created = %+v", result.Created)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "Include not followed") {
+		t.Errorf("warnings = %q", result.Warnings)
+	}
+
+	os.Remove(filepath.Join(os.Getenv("HOME"), ".ssh", "config"))
+	result = protocol.HostConfigImportSSHConfigResultPayload{}
+	dispatch(t, s, cs, protocol.TypeHostConfigImportSSHConfig, protocol.HostConfigImportSSHConfigPayload{})
+	readPayload(t, conn, protocol.TypeHostConfigImportSSHConfigResult, &result)
+	if result.Success || result.Error == nil || !strings.Contains(*result.Error, "no ~/.ssh/config") {
+		t.Errorf("without a config = %+v", result)
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"log"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// ============================================================================
+// Per-Host Operation Limit
+// ============================================================================
+//
+// A host connecting gets its tmux scan, port probes, requirements checks and
+// env reads all at once, which can choke a small device's SSH daemon. The
+// ssh.Manager runs at most Config.MaxConcurrentOps remote operations (command
+// sessions and tunnel dials) at once per host; a host's settings may lower or
+// raise that.
+
+// hostMaxConcurrentOps returns the operation limit a host's settings set,
+// or 0 if it uses the bridge's
+func (s *Server) hostMaxConcurrentOps(hostID string) int {
+	if s.storage == nil {
+		return 0
+	}
+	limit, err := s.storage.GetHostMaxConcurrentOps(hostID)
+	if err != nil {
+		log.Printf("[WARN] [HOST] Failed to get operation limit of host %s, using the bridge's: %v", hostID, err)
+		return 0
+	}
+	return limit
+}
+
+// updateHostMaxConcurrentOps saves the operation limit a host_config_update
+// changes, if it does, applying it to the host's connection, and returns
+// the host's setting after it
+func (s *Server) updateHostMaxConcurrentOps(hostID string, payload protocol.HostConfigUpdatePayload) (int, error) {
+	if payload.MaxConcurrentOps == nil {
+		return s.hostMaxConcurrentOps(hostID), nil
+	}
+	limit := *payload.MaxConcurrentOps
+	if err := s.storage.SetHostMaxConcurrentOps(hostID, limit); err != nil {
+		return 0, err
+	}
+	s.sshManager.SetHostMaxConcurrentOps(hostID, limit)
+	log.Printf("[INFO] [HOST] Set operation limit of host %s to %d (0 is the bridge's %d)", hostID, limit, s.config.MaxConcurrentOps)
+	return limit, nil
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+func TestHostConfigUpdateMaxConcurrentOps(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	hostID := phasedHostConfig(t, s, 0, 0, 0)
+	conn, cs := connectTestClient(t, s)
+
+	limit := 2
+	dispatch(t, s, cs, protocol.TypeHostConfigUpdate, protocol.HostConfigUpdatePayload{ID: hostID, MaxConcurrentOps: &limit})
+	var updated protocol.HostConfigUpdateResultPayload
+	readPayload(t, conn, protocol.TypeHostConfigUpdateResult, &updated)
+	if !updated.Success || updated.Host.MaxConcurrentOps != 2 {
+		t.Fatalf("update result = %+v", updated)
+	}
+
+	// The connection takes the host's limit
+	dispatch(t, s, cs, protocol.TypeHostConnect, protocol.HostConnectPayload{HostID: hostID})
+	var status protocol.HostStatusPayload
+	readPayload(t, conn, protocol.TypeHostStatus, &status)
+	if !status.Connected {
+		t.Fatalf("host status = %+v", status)
+	}
+	if health, _ := s.sshManager.Health(hostID); health.Ops.Limit != 2 {
+		t.Errorf("operation limit = %d, want the host's 2", health.Ops.Limit)
+	}
+
+	dispatch(t, s, cs, protocol.TypeHostConfigList, protocol.HostConfigListPayload{})
+	var list protocol.HostConfigListResultPayload
+	readPayload(t, conn, protocol.TypeHostConfigListResult, &list)
+	if len(list.Hosts) != 1 || list.Hosts[0].MaxConcurrentOps != 2 {
+		t.Errorf("list = %+v", list.Hosts)
+	}
+
+	// 0 goes back to the bridge's limit, on the live connection too
+	none := 0
+	dispatch(t, s, cs, protocol.TypeHostConfigUpdate, protocol.HostConfigUpdatePayload{ID: hostID, MaxConcurrentOps: &none})
+	updated = protocol.HostConfigUpdateResultPayload{}
+	readPayload(t, conn, protocol.TypeHostConfigUpdateResult, &updated)
+	if !updated.Success || updated.Host.MaxConcurrentOps != 0 {
+		t.Errorf("after clearing the limit: %+v", updated.Host)
+	}
+	if health, _ := s.sshManager.Health(hostID); health.Ops.Limit != ssh.DefaultMaxConcurrentOps {
+		t.Errorf("operation limit = %d, want the bridge's %d", health.Ops.Limit, ssh.DefaultMaxConcurrentOps)
+	}
+}
+
+func TestNewRejectsInvalidMaxConcurrentOps(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxConcurrentOps = ssh.MaxConcurrentOpsLimit + 1
+	if _, err := New("127.0.0.1:0", t.TempDir(), config); err == nil {
+		t.Error("New accepted an operation limit above the maximum")
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/env"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// ============================================================================
+// Host Platform
+// ============================================================================
+//
+// What a host runs (its OS, login shell, tmux version and whether /proc is
+// mounted) is read once per connection by host_connect, kept on the
+// connection and recorded with the host. Code that differs between hosts
+// picks its path from it rather than trying each in turn: the RC file env
+// vars go to, and the tools that find the process listening on a port.
+// Before a host was ever read, every path is tried as before.
+
+// platformTimeout bounds reading a host's platform
+const platformTimeout = 10 * time.Second
+
+// detectHostPlatform reads a host's platform, unless its connection already
+// has, and records it. It returns nil when the platform can't be read.
+func (s *Server) detectHostPlatform(hostID string, conn *ssh.Connection) *protocol.HostPlatform {
+	if platform, ok := conn.Platform(); ok {
+		return toProtocolPlatform(platform)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), platformTimeout)
+	defer cancel()
+	platform, err := conn.DetectPlatform(ctx, s.hostTmux(hostID).Cmd("-V"))
+	if err != nil {
+		log.Printf("[WARN] [HOST] Could not read platform of host %s: %v", hostID, err)
+		return nil
+	}
+	log.Printf("[INFO] [HOST] Host %s runs %s %s (%s), shell %s, tmux %s, /proc %v",
+		hostID, platform.OS, platform.Arch, platform.Release, platform.Shell, platform.TmuxVersion, platform.HasProc)
+	if err := s.storage.SetHostPlatform(hostID, storage.HostPlatform(platform)); err != nil {
+		log.Printf("[WARN] [HOST] Failed to record platform of host %s: %v", hostID, err)
+	}
+	return toProtocolPlatform(platform)
+}
+
+// hostPlatform returns what a host runs: as read on its connection, or as
+// recorded at its last connect. It is unknown when neither was read.
+func (s *Server) hostPlatform(hostID string) ssh.Platform {
+	if conn := s.sshManager.GetConnection(hostID); conn != nil {
+		if platform, ok := conn.Platform(); ok {
+			return platform
+		}
+	}
+	if s.storage == nil {
+		return ssh.Platform{}
+	}
+	recorded, err := s.storage.GetHostPlatform(hostID)
+	if err != nil {
+		log.Printf("[WARN] [HOST] Failed to get recorded platform of host %s: %v", hostID, err)
+		return ssh.Platform{}
+	}
+	if recorded == nil {
+		return ssh.Platform{}
+	}
+	return ssh.Platform(*recorded)
+}
+
+// hostPlatformInfo returns a host's platform in its protocol form, or nil
+// when it is unknown
+func (s *Server) hostPlatformInfo(hostID string) *protocol.HostPlatform {
+	platform := s.hostPlatform(hostID)
+	if !platform.Known() {
+		return nil
+	}
+	return toProtocolPlatform(platform)
+}
+
+// toProtocolPlatform converts a platform to its protocol form
+func toProtocolPlatform(platform ssh.Platform) *protocol.HostPlatform {
+	return &protocol.HostPlatform{
+		OS:          platform.OS,
+		Arch:        platform.Arch,
+		Release:     nilIfEmpty(platform.Release),
+		Shell:       nilIfEmpty(platform.Shell),
+		TmuxVersion: nilIfEmpty(platform.TmuxVersion),
+		HasProc:     platform.HasProc,
+	}
+}
+
+// detectRcFile returns the RC file of a host's login shell, from its
+// platform when that was read and from the shell itself otherwise
+func (s *Server) detectRcFile(hostID string, sshConn *ssh.Connection) string {
+	if platform := s.hostPlatform(hostID); platform.Shell != "" {
+		return env.RcFileForShell(platform.Shell, platform.OS)
+	}
+	rcFile, err := s.envManager.DetectRcFile(sshConn.Client)
+	if err != nil {
+		log.Printf("[WARN] [ENV] Failed to detect RC file: %v", err)
+		return "~/.bashrc" // Default fallback
+	}
+	return rcFile
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+const (
+	linuxPlatform = "os=Linux\narch=x86_64\nrelease=Ubuntu 22.04.4 LTS\nshell=/bin/bash\ntmux=tmux 3.2a\nproc=1\n"
+	macPlatform   = "os=Darwin\narch=arm64\nrelease=macOS 14.5\nshell=/bin/bash\ntmux=tmux 3.4\nproc=0\n"
+)
+
+// platformTestHost adds a host that reports facts as its platform and
+// records every command run on it. Network tool batches run under the local
+// sh, with tools that list nothing; other commands output nothing.
+func platformTestHost(t *testing.T, s *Server, conn *websocket.Conn, cs *ConnectedSession, facts string) (string, func() []string) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	bin := t.TempDir()
+	for _, name := range []string{"ss", "netstat", "lsof"} {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	var commands []string
+	srv := startTestSSHServer(t)
+	srv.HandleExec(func(cmd string) string {
+		mu.Lock()
+		commands = append(commands, cmd)
+		mu.Unlock()
+		switch {
+		case strings.Contains(cmd, "uname -s"):
+			return facts
+		case strings.Contains(cmd, "__rc_batch_"):
+			c := exec.Command("sh", "-c", cmd)
+			c.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+			output, _ := c.Output()
+			return string(output)
+		}
+		return ""
+	})
+
+	dispatch(t, s, cs, protocol.TypeHostConfigCreate, protocol.HostConfigCreatePayload{
+		Name: "box", Host: "127.0.0.1", Port: srv.Port(), Username: "user", AuthType: "password", Credential: "secret",
+	})
+	var created protocol.HostConfigCreateResultPayload
+	readPayload(t, conn, protocol.TypeHostConfigCreateResult, &created)
+	return created.Host.ID, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), commands...)
+	}
+}
+
+func countContaining(commands []string, substr string) int {
+	n := 0
+	for _, cmd := range commands {
+		if strings.Contains(cmd, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestHostPlatformSelectsFallbacks(t *testing.T) {
+	tests := []struct {
+		name       string
+		facts      string
+		wantOS     string
+		wantRcFile string
+		wantRun    []string // Commands run looking for a listening PID
+		wantNotRun []string
+	}{
+		{
+			name:       "linux",
+			facts:      linuxPlatform,
+			wantOS:     "Linux",
+			wantRcFile: "~/.bashrc",
+			wantRun:    []string{"ss -tlnp", "netstat -tlnp", "lsof -iTCP", "/proc/net/tcp"},
+		},
+		{
+			name:       "macOS",
+			facts:      macPlatform,
+			wantOS:     "Darwin",
+			wantRcFile: "~/.bash_profile",
+			wantRun:    []string{"lsof -iTCP"},
+			wantNotRun: []string{"ss -tlnp", "netstat -tlnp", "/proc/net/tcp"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newQuietServer(t)
+			conn, cs := connectTestClient(t, s)
+			hostID, commands := platformTestHost(t, s, conn, cs, tt.facts)
+
+			connect := func() protocol.HostStatusPayload {
+				t.Helper()
+				dispatch(t, s, cs, protocol.TypeHostConnect, protocol.HostConnectPayload{HostID: hostID})
+				var status protocol.HostStatusPayload
+				readPayload(t, conn, protocol.TypeHostStatus, &status)
+				if !status.Connected {
+					t.Fatalf("connect failed: %+v", status)
+				}
+				return status
+			}
+			status := connect()
+			if status.Platform == nil || status.Platform.OS != tt.wantOS || status.Platform.Shell == nil ||
+				*status.Platform.Shell != "/bin/bash" || status.Platform.HasProc != (tt.wantOS == "Linux") {
+				t.Fatalf("platform = %+v", status.Platform)
+			}
+			if recorded, err := s.storage.GetHostPlatform(hostID); err != nil || recorded == nil || recorded.OS != tt.wantOS {
+				t.Errorf("recorded platform = %+v, %v", recorded, err)
+			}
+
+			// A connect that reuses the connection doesn't read it again
+			if status := connect(); status.Platform == nil || status.Platform.OS != tt.wantOS {
+				t.Errorf("platform on reconnect = %+v", status.Platform)
+			}
+			if n := countContaining(commands(), "uname -s"); n != 1 {
+				t.Errorf("platform read %d times", n)
+			}
+
+			before := len(commands())
+			sshConn := s.sshManager.GetConnection(hostID)
+			if _, err := s.detectAgentAPIPID(hostID, sshConn.Client, 3284); err == nil {
+				t.Error("found a PID with no tool listing one")
+			}
+			if rcFile := s.detectRcFile(hostID, sshConn); rcFile != tt.wantRcFile {
+				t.Errorf("rc file = %q, want %q", rcFile, tt.wantRcFile)
+			}
+			run := commands()[before:]
+			for _, want := range tt.wantRun {
+				if countContaining(run, want) == 0 {
+					t.Errorf("%q not run: %q", want, run)
+				}
+			}
+			for _, unwanted := range append(tt.wantNotRun, "echo $SHELL") {
+				if countContaining(run, unwanted) != 0 {
+					t.Errorf("%q run: %q", unwanted, run)
+				}
+			}
+		})
+	}
+}
+
+func TestHostPlatformInDiagnostics(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	hostID, _ := platformTestHost(t, s, conn, cs, macPlatform)
+
+	dispatch(t, s, cs, protocol.TypeHostConnect, protocol.HostConnectPayload{HostID: hostID})
+	readPayload(t, conn, protocol.TypeHostStatus, nil)
+
+	dispatch(t, s, cs, protocol.TypeHostDiagnostics, protocol.HostDiagnosticsPayload{HostID: hostID})
+	var result protocol.HostDiagnosticsResultPayload
+	readPayload(t, conn, protocol.TypeHostDiagnosticsResult, &result)
+	if result.Platform == nil || result.Platform.OS != "Darwin" || result.Platform.Release == nil || *result.Platform.Release != "macOS 14.5" {
+		t.Errorf("diagnostics platform = %+v", result.Platform)
+	}
+
+	// Once disconnected, a host is described as recorded at its last connect
+	s.sshManager.Disconnect(hostID)
+	if platform := s.hostPlatform(hostID); !platform.IsDarwin() || platform.TmuxVersion != "3.4" {
+		t.Errorf("recorded platform = %+v", platform)
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// ============================================================================
+// Host Reboot Detection
+// ============================================================================
+//
+// A reboot takes every tmux session on a host with it. The bridge records the
+// boot time a host reports on each host_connect; a different one on a later
+// connect (including the one a client makes after a keepalive failure) means
+// the processes it knew of are gone, so they are dropped rather than left to
+// fail reattaching one by one.
+
+// bootTimeSlack absorbs drift in a reported boot time: Linux derives btime
+// from the clock, which NTP steps
+const bootTimeSlack = 30 * time.Second
+
+// bootTimeTimeout bounds reading a host's boot time
+const bootTimeTimeout = 10 * time.Second
+
+// hostBoot is what host_connect learned about a host's boot time
+type hostBoot struct {
+	bootTime         time.Time // Zero when unreadable
+	previousBootTime time.Time // Set when the host rebooted
+}
+
+func (b hostBoot) rebooted() bool {
+	return !b.previousBootTime.IsZero()
+}
+
+// apply reports the boot time, and any reboot, in a HOST_STATUS payload
+func (b hostBoot) apply(status *protocol.HostStatusPayload) {
+	if !b.bootTime.IsZero() {
+		status.BootTime = strPtr(b.bootTime.UTC().Format(time.RFC3339))
+	}
+	if b.rebooted() {
+		status.RebootDetected = true
+		status.PreviousBootTime = strPtr(b.previousBootTime.UTC().Format(time.RFC3339))
+	}
+}
+
+// checkHostBoot reads a host's boot time, compares it with the one recorded
+// at the last connect and records it
+func (s *Server) checkHostBoot(hostID string, conn *ssh.Connection) hostBoot {
+	ctx, cancel := context.WithTimeout(context.Background(), bootTimeTimeout)
+	defer cancel()
+	bootTime, err := conn.BootTime(ctx)
+	if err != nil {
+		log.Printf("[WARN] [HOST] Could not read boot time of host %s: %v", hostID, err)
+		return hostBoot{}
+	}
+	boot := hostBoot{bootTime: bootTime}
+
+	previous, err := s.storage.GetHostBootTime(hostID)
+	if err != nil {
+		log.Printf("[WARN] [HOST] Failed to get recorded boot time of host %s: %v", hostID, err)
+		return boot
+	}
+	if err := s.storage.SetHostBootTime(hostID, bootTime); err != nil {
+		log.Printf("[WARN] [HOST] Failed to record boot time of host %s: %v", hostID, err)
+	}
+	if previous.IsZero() {
+		return boot
+	}
+	if drift := bootTime.Sub(previous); drift > bootTimeSlack || drift < -bootTimeSlack {
+		log.Printf("[INFO] [HOST] Host %s rebooted: boot time %s, was %s", hostID, bootTime.UTC().Format(time.RFC3339), previous.UTC().Format(time.RFC3339))
+		boot.previousBootTime = previous
+	}
+	return boot
+}
+
+// purgeRebootedHost drops the processes of a host whose tmux sessions went
+// with a reboot: the registered ones, the stale ones and their stored metadata
+func (s *Server) purgeRebootedHost(hostID string) {
+	for _, proc := range s.processRegistry.GetByHost(hostID) {
+		proc.Detach()
+		s.processRegistry.Unregister(proc.ID)
+		s.alertLimiter.forget(proc.ID)
+	}
+	s.processRegistry.ClearStaleProcesses(hostID)
+
+	processIDs, err := s.storage.DeleteHostProcessMetadata(hostID)
+	if err != nil {
+		log.Printf("[WARN] [HOST] Failed to delete process metadata of rebooted host %s: %v", hostID, err)
+		return
+	}
+	for _, processID := range processIDs {
+		if err := s.storage.SetProcessWorkspace(processID, ""); err != nil {
+			log.Printf("[WARN] [HOST] Error clearing workspace for process %s: %v", processID, err)
+		}
+	}
+	log.Printf("[INFO] [HOST] Dropped %d processes of rebooted host %s", len(processIDs), hostID)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+func TestHostConnectDetectsReboot(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+
+	var bootTime atomic.Int64
+	bootTime.Store(1700000000)
+	srv := startTestSSHServer(t)
+	srv.HandleExec(func(cmd string) string {
+		if strings.Contains(cmd, "btime") {
+			return strconv.FormatInt(bootTime.Load(), 10) + "\n"
+		}
+		return ""
+	})
+	dispatch(t, s, cs, protocol.TypeHostConfigCreate, protocol.HostConfigCreatePayload{
+		Name: "box", Host: "127.0.0.1", Port: srv.Port(), Username: "user", AuthType: "password", Credential: "secret",
+	})
+	var created protocol.HostConfigCreateResultPayload
+	readPayload(t, conn, protocol.TypeHostConfigCreateResult, &created)
+	hostID := created.Host.ID
+
+	connect := func() protocol.HostStatusPayload {
+		t.Helper()
+		dispatch(t, s, cs, protocol.TypeHostConnect, protocol.HostConnectPayload{HostID: hostID})
+		var status protocol.HostStatusPayload
+		readPayload(t, conn, protocol.TypeHostStatus, &status)
+		if !status.Connected {
+			t.Fatalf("connect failed: %+v", status)
+		}
+		return status
+	}
+
+	status := connect()
+	if status.RebootDetected || status.BootTime == nil || *status.BootTime != "2023-11-14T22:13:20Z" {
+		t.Errorf("first connect = %+v", status)
+	}
+
+	// The host goes away with a process the bridge knows of
+	const processID = "00000000-0000-4000-8000-00000000000a"
+	s.sshManager.Disconnect(hostID)
+	s.processRegistry.Register(&process.Process{ID: processID, HostID: hostID, Type: process.TypeShell,
+		PTY: &pty.Session{ID: processID, HostID: hostID, TmuxName: pty.TmuxSessionName(processID)}})
+	if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{ProcessID: processID, HostID: hostID,
+		ProcessType: "shell", TmuxName: pty.TmuxSessionName(processID)}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+
+	// A boot time within the slack is the same boot
+	bootTime.Add(int64(bootTimeSlack / time.Second))
+	if status := connect(); status.RebootDetected {
+		t.Errorf("drifted boot time reported as a reboot: %+v", status)
+	}
+	if s.processRegistry.Get(processID) == nil {
+		t.Fatal("process dropped without a reboot")
+	}
+
+	s.sshManager.Disconnect(hostID)
+	bootTime.Store(1700086400)
+	status = connect()
+	if !status.RebootDetected || status.PreviousBootTime == nil || *status.PreviousBootTime != "2023-11-14T22:13:50Z" ||
+		status.BootTime == nil || *status.BootTime != "2023-11-15T22:13:20Z" {
+		t.Errorf("connect after reboot = %+v", status)
+	}
+	if len(status.Processes) != 0 || s.processRegistry.Get(processID) != nil {
+		t.Errorf("process of rebooted host still listed: %+v", status.Processes)
+	}
+	if meta, err := s.storage.GetProcessMetadata(processID); err != nil || meta != nil {
+		t.Errorf("metadata of rebooted host = %+v, %v", meta, err)
+	}
+
+	if status := connect(); status.RebootDetected {
+		t.Errorf("reboot reported twice: %+v", status)
+	}
+}
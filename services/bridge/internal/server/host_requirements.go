@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// ============================================================================
+// Host Requirements
+// ============================================================================
+//
+// The requirements check looks claude and agentapi up through the host's
+// login shell, which takes seconds on hosts whose shell init is slow (nvm,
+// conda). host_status never waits for it: it carries the host's last result,
+// or none before the first, and a check started in the background pushes
+// host_requirements_result to the sessions that were sent the status once it
+// is done. A host has at most one check running, which its disconnect
+// cancels.
+
+// requirementsFunc checks what a host has installed
+type requirementsFunc func(client *gossh.Client) *protocol.HostRequirements
+
+// requirementsCheck is a host's running requirements check
+type requirementsCheck struct {
+	cancel   context.CancelFunc
+	sessions map[string]*ConnectedSession // Sent the result, by session ID
+}
+
+// hostRequirements holds the last requirements of each connected host and
+// the checks running
+type hostRequirements struct {
+	mu      sync.Mutex
+	results map[string]*protocol.HostRequirements
+	running map[string]*requirementsCheck
+}
+
+func newHostRequirements() *hostRequirements {
+	return &hostRequirements{
+		results: make(map[string]*protocol.HostRequirements),
+		running: make(map[string]*requirementsCheck),
+	}
+}
+
+// cachedRequirements returns a host's last requirements, or nil if none were
+// checked since it connected
+func (s *Server) cachedRequirements(hostID string) *protocol.HostRequirements {
+	s.requirements.mu.Lock()
+	defer s.requirements.mu.Unlock()
+	return s.requirements.results[hostID]
+}
+
+// storeRequirements records the requirements a host was found with
+func (s *Server) storeRequirements(hostID string, requirements *protocol.HostRequirements) {
+	s.requirements.mu.Lock()
+	defer s.requirements.mu.Unlock()
+	s.requirements.results[hostID] = requirements
+}
+
+// refreshRequirements checks a host's requirements in the background and
+// sends the result to connSession as host_requirements_result. A check
+// already running for the host is joined instead of starting another.
+func (s *Server) refreshRequirements(connSession *ConnectedSession, hostID string, client *gossh.Client) {
+	r := s.requirements
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if check, ok := r.running[hostID]; ok {
+		check.sessions[connSession.ID] = connSession
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	check := &requirementsCheck{cancel: cancel, sessions: map[string]*ConnectedSession{connSession.ID: connSession}}
+	r.running[hostID] = check
+
+	go func() {
+		defer cancel()
+		done := make(chan *protocol.HostRequirements, 1)
+		go func() { done <- s.checkRequirements(client) }()
+
+		// A disconnect closes the client, which ends the check soon after
+		var requirements *protocol.HostRequirements
+		select {
+		case requirements = <-done:
+		case <-ctx.Done():
+			log.Printf("[DEBUG] [HOST] Requirements check for %s cancelled", hostID)
+			return
+		}
+
+		r.mu.Lock()
+		if r.running[hostID] != check || ctx.Err() != nil {
+			r.mu.Unlock()
+			return
+		}
+		delete(r.running, hostID)
+		r.results[hostID] = requirements
+		sessions := check.sessions
+		r.mu.Unlock()
+
+		log.Printf("[INFO] [HOST] Requirements check for %s: claude=%v, agentapi=%v",
+			hostID, requirements.ClaudeInstalled, requirements.AgentAPIInstalled)
+		msg, err := protocol.NewMessage(protocol.TypeHostRequirementsResult, protocol.HostRequirementsResultPayload{
+			HostID:       hostID,
+			Requirements: *requirements,
+		})
+		if err != nil {
+			log.Printf("[ERROR] [HOST] Failed to create requirements result: %v", err)
+			return
+		}
+		for _, sess := range sessions {
+			if err := sess.Send(msg); err != nil {
+				log.Printf("[WARN] [HOST] Failed to send requirements of %s to session %s: %v", hostID, sess.ID, err)
+			}
+		}
+	}()
+}
+
+// forgetRequirements cancels a host's running requirements check and drops
+// its last result, once it disconnected
+func (s *Server) forgetRequirements(hostID string) {
+	r := s.requirements
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if check, ok := r.running[hostID]; ok {
+		check.cancel()
+		delete(r.running, hostID)
+	}
+	delete(r.results, hostID)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// gateRequirements replaces the requirements check with one that finds
+// claude installed once the test sends on the returned channel, and returns
+// the number of checks started
+func gateRequirements(t *testing.T, s *Server) (chan<- struct{}, *int32) {
+	t.Helper()
+	gate := make(chan struct{})
+	var started int32
+	s.checkRequirements = func(*gossh.Client) *protocol.HostRequirements {
+		atomic.AddInt32(&started, 1)
+		<-gate
+		return &protocol.HostRequirements{ClaudeInstalled: true, CheckedAt: "2026-01-01T00:00:00Z"}
+	}
+	return gate, &started
+}
+
+// stallRequirements makes requirements checks wait out the test, for tests
+// that would otherwise read a host_requirements_result among their messages
+func stallRequirements(t *testing.T, s *Server) {
+	t.Helper()
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	s.checkRequirements = func(*gossh.Client) *protocol.HostRequirements {
+		<-stop
+		return &protocol.HostRequirements{}
+	}
+}
+
+func TestHostRequirementsInBackground(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	watcher, watcherCS := connectTestClient(t, s)
+	shellTestHost(t, s)
+	gate, started := gateRequirements(t, s)
+	clients := []struct {
+		conn *websocket.Conn
+		cs   *ConnectedSession
+	}{{conn, cs}, {watcher, watcherCS}}
+
+	// Both statuses come back while the check is still running, without
+	// requirements, and every request joins the one check
+	for _, c := range clients {
+		dispatch(t, s, c.cs, protocol.TypeHostStatusRequest, protocol.HostStatusRequestPayload{HostID: "host-1"})
+	}
+	dispatch(t, s, cs, protocol.TypeHostCheckRequirements, protocol.HostCheckRequirementsPayload{HostID: "host-1"})
+	var status protocol.HostStatusPayload
+	readPayload(t, conn, protocol.TypeHostStatus, &status)
+	if status.Requirements != nil {
+		t.Errorf("requirements = %+v before the first check finished", status.Requirements)
+	}
+	readPayload(t, watcher, protocol.TypeHostStatus, nil)
+	expectNothingQueued(t, conn, cs)
+
+	// The result is pushed once to each session that asked
+	gate <- struct{}{}
+	for _, c := range clients {
+		var result protocol.HostRequirementsResultPayload
+		readPayload(t, c.conn, protocol.TypeHostRequirementsResult, &result)
+		if result.HostID != "host-1" || !result.Requirements.ClaudeInstalled || result.Error != nil {
+			t.Errorf("result = %+v", result)
+		}
+		expectNothingQueued(t, c.conn, c.cs)
+	}
+	if got := atomic.LoadInt32(started); got != 1 {
+		t.Errorf("started %d checks, want 1", got)
+	}
+
+	// The next status carries it, and a fresh check follows
+	dispatch(t, s, cs, protocol.TypeHostStatusRequest, protocol.HostStatusRequestPayload{HostID: "host-1"})
+	status = protocol.HostStatusPayload{}
+	readPayload(t, conn, protocol.TypeHostStatus, &status)
+	if status.Requirements == nil || !status.Requirements.ClaudeInstalled {
+		t.Errorf("requirements = %+v, want the last result", status.Requirements)
+	}
+	gate <- struct{}{}
+	readPayload(t, conn, protocol.TypeHostRequirementsResult, nil)
+
+	// A disconnect cancels the check running and forgets the result
+	dispatch(t, s, cs, protocol.TypeHostStatusRequest, protocol.HostStatusRequestPayload{HostID: "host-1"})
+	readPayload(t, conn, protocol.TypeHostStatus, nil)
+	s.teardownHost("host-1")
+	gate <- struct{}{}
+	expectNothingQueued(t, conn, cs)
+	if got := s.cachedRequirements("host-1"); got != nil {
+		t.Errorf("requirements = %+v after disconnect", got)
+	}
+	if got := atomic.LoadInt32(started); got != 3 {
+		t.Errorf("started %d checks, want 3", got)
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// hostScanStages are the scans of a host connect, in the order timedOut lists them
+var hostScanStages = []protocol.HostConnectStage{
+	protocol.HostConnectScanningTmux,
+	protocol.HostConnectScanningPorts,
+	protocol.HostConnectCheckingRequirements,
+}
+
+// hostScan is what the scans of a host connect found. The fields of a scan
+// listed in timedOut are left empty.
+type hostScan struct {
+	// scanning_tmux, and reattaching for the sessions it found
+	tmuxSessions      []pty.TmuxSessionInfo
+	tmuxErr           error
+	processInfos      []protocol.ProcessInfo
+	detachedProcesses []protocol.StaleProcess
+	unmanagedSessions []protocol.UnmanagedSession
+	reattach          *protocol.ReattachReport
+
+	// scanning_ports
+	scannedProcesses []protocol.ProcessInfo
+	staleAgentAPIs   []protocol.StaleProcess
+
+	// checking_requirements
+	requirements *protocol.HostRequirements
+
+	timedOut []protocol.HostConnectStage
+}
+
+// scanHost runs the tmux session scan, the AgentAPI port scan and the
+// requirements check of a host connect at once, each over its own SSH
+// session, and waits at most HostConnectTimeout for them. The scans only
+// read the host: reattaching the registered processes whose sessions the
+// tmux scan found follows the join, and marking ports and merging stale
+// processes is left to the caller. A scan still running at the deadline is
+// listed in timedOut and cut off; whatever it still returns, and its
+// progress, are dropped, since HOST_STATUS goes out without them.
+func (s *Server) scanHost(connSession *ConnectedSession, hostID string, conn *ssh.Connection, duplicateOf []string, progress hostConnectProgress) hostScan {
+	ctx := context.Background()
+	if s.config.HostConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.HostConnectTimeout)
+		defer cancel()
+	}
+
+	var (
+		mu       sync.Mutex
+		scan     hostScan
+		finished = make(map[protocol.HostConnectStage]bool)
+		expired  bool
+	)
+	// finish records a scan's results unless the deadline passed first
+	finish := func(stage protocol.HostConnectStage, record func()) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !expired && ctx.Err() == nil {
+			record()
+			finished[stage] = true
+		}
+	}
+	report := hostConnectProgress(func(update protocol.HostConnectProgressPayload) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !expired {
+			progress.report(update)
+		}
+	})
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		tmuxSessions, unmanagedSessions, err := s.listTmuxSessions(ctx, hostID, conn, duplicateOf, report)
+		finish(protocol.HostConnectScanningTmux, func() {
+			scan.tmuxSessions = tmuxSessions
+			scan.unmanagedSessions = unmanagedSessions
+			scan.tmuxErr = err
+		})
+	})
+	wg.Go(func() {
+		// Existing AgentAPI servers, for Claude process detection
+		report(protocol.HostConnectProgressPayload{Stage: protocol.HostConnectScanningPorts})
+		scannedProcesses, staleAgentAPIs := s.portScanner.ScanPorts(ctx, conn.Client, hostID)
+		finish(protocol.HostConnectScanningPorts, func() {
+			scan.scannedProcesses = scannedProcesses
+			scan.staleAgentAPIs = staleAgentAPIs
+		})
+	})
+	wg.Go(func() {
+		// claude and agentapi installation
+		report(protocol.HostConnectProgressPayload{Stage: protocol.HostConnectCheckingRequirements})
+		requirements := s.checkRequirements(conn.Client)
+		finish(protocol.HostConnectCheckingRequirements, func() {
+			scan.requirements = requirements
+		})
+	})
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	mu.Lock()
+	expired = true
+	for _, stage := range hostScanStages {
+		if !finished[stage] {
+			scan.timedOut = append(scan.timedOut, stage)
+		}
+	}
+	mu.Unlock()
+	if len(scan.timedOut) > 0 {
+		log.Printf("[WARN] [HOST] Connect to host %s timed out after %v, still %v", hostID, s.config.HostConnectTimeout, scan.timedOut)
+	}
+
+	// Only a listing that came in before the join is acted on, so nothing is
+	// reattached after HOST_STATUS goes out
+	if !finished[protocol.HostConnectScanningTmux] {
+		return scan
+	}
+	if scan.tmuxErr != nil {
+		log.Printf("[WARN] [TMUX] Failed to scan tmux sessions: %v", scan.tmuxErr)
+		return scan
+	}
+	scan.processInfos, scan.detachedProcesses, scan.reattach = s.registerTmuxSessions(connSession, conn, scan.tmuxSessions, progress)
+	return scan
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// ============================================================================
+// Host Status Updates
+// ============================================================================
+//
+// A full host_status, with every process, the stale processes and a fresh
+// requirements check, is sent on host_connect and on reconnect, and when a
+// client asks with host_status_request. Changes in between are sent as
+// deltas: process_created and process_killed for the client's own requests,
+// process_added and process_removed for processes that join or leave a
+// host's list otherwise, process_updated for changes to one, and
+// stale_processes_changed for the stale list.
+
+// handleHostStatusRequest sends a full host_status of a connected host
+func (s *Server) handleHostStatusRequest(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.HostStatusRequestPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [HOST] Status request: hostId=%s", payload.HostID)
+
+	if s.sshManager.GetConnection(payload.HostID) == nil {
+		return connSession.sendHostNotConnected(payload.HostID)
+	}
+	return s.sendHostStatus(connSession, payload.HostID)
+}
+
+// notifyProcessAdded sends process_added to the session that added the
+// process and pushes it to the host's subscribers
+func (s *Server) notifyProcessAdded(connSession *ConnectedSession, proc *process.Process, metadataDiscarded bool) error {
+	msg, err := protocol.NewMessage(protocol.TypeProcessAdded, protocol.ProcessAddedPayload{
+		HostID:            proc.HostID,
+		Process:           proc.ToInfo(),
+		MetadataDiscarded: metadataDiscarded,
+	})
+	if err != nil {
+		return err
+	}
+	s.publishProcessMessage(proc.HostID, proc.ID, msg, connSession)
+	return connSession.Send(msg)
+}
+
+// publishProcessRemoved pushes process_removed to the host's subscribers,
+// except a session that is sent the host's full status instead
+func (s *Server) publishProcessRemoved(hostID, processID string, reason protocol.ProcessRemovedReason, except *ConnectedSession) {
+	msg, err := protocol.NewMessage(protocol.TypeProcessRemoved, protocol.ProcessRemovedPayload{
+		HostID:    hostID,
+		ProcessID: processID,
+		Reason:    reason,
+	})
+	if err != nil {
+		log.Printf("[ERROR] [PROCESS] Failed to create process removed message: %v", err)
+		return
+	}
+	s.publishProcessMessage(hostID, processID, msg, except)
+}
+
+// staleProcessesChanged returns a stale_processes_changed message with the
+// host's stale processes that a session's scope allows
+func (s *Server) staleProcessesChanged(connSession *ConnectedSession, hostID string) (*protocol.Message, error) {
+	stale := connSession.scopedStaleProcesses(hostID, s.processRegistry.GetStaleProcesses(hostID))
+	if stale == nil {
+		stale = []protocol.StaleProcess{}
+	}
+	return protocol.NewMessage(protocol.TypeStaleProcessesChanged, protocol.StaleProcessesChangedPayload{
+		HostID:         hostID,
+		StaleProcesses: stale,
+	})
+}
+
+// publishStaleProcessesChanged pushes a host's stale processes to its
+// subscribers, except one that was already sent them
+func (s *Server) publishStaleProcessesChanged(hostID string, except *ConnectedSession) {
+	s.eachProcessSubscriber(hostID, except, func(target *ConnectedSession) {
+		msg, err := s.staleProcessesChanged(target, hostID)
+		if err != nil {
+			log.Printf("[ERROR] [PROCESS] Failed to create stale processes message: %v", err)
+			return
+		}
+		if err := target.Send(msg); err != nil {
+			log.Printf("[ERROR] [PROCESS] Failed to push %s to session %s: %v", msg.Type, target.ID, err)
+		}
+	})
+}
+
+// notifyStaleProcessesChanged sends a host's stale processes to the session
+// that changed them and pushes them to the host's subscribers
+func (s *Server) notifyStaleProcessesChanged(connSession *ConnectedSession, hostID string) error {
+	s.publishStaleProcessesChanged(hostID, connSession)
+	msg, err := s.staleProcessesChanged(connSession, hostID)
+	if err != nil {
+		return err
+	}
+	return connSession.Send(msg)
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// fakeTmuxPID and fakeTmuxCreated describe the pane of every fake tmux session
+const (
+	fakeTmuxPID     = 4242
+	fakeTmuxCreated = 1700000000
+)
+
+// fakeTmux has a session for every name except rc-gone, whose pane's working
+// directory is the contents of $FAKE_TMUX_CWD/<name>, or /home/user/<name>
+// when that doesn't exist. A session's window has a bell or activity
+// alert while $FAKE_TMUX_ALERTS/<name>.bell or .activity exists, until
+// kill-session -C clears it. new-session writes its arguments, one per line,
+// to $FAKE_TMUX_NEW/<name> when that is set, and set-option appends its
+// arguments as a line to $FAKE_TMUX_OPTIONS, as send-keys does to
+// $FAKE_TMUX_KEYS. The pane runs $FAKE_TMUX_COMMAND, or bash when that is
+// unset. Attaching writes the file $FAKE_TMUX_OUTPUT, if set, and exits
+// after $FAKE_TMUX_HOLD seconds.
+// copy-mode puts a pane in copy mode, scrolled 7 lines back, while
+// $FAKE_TMUX_MODE is set, and if-shell with cancel takes it out unless
+// $FAKE_TMUX_MODE/<name>.stuck exists; both append their arguments to
+// $FAKE_TMUX_KEYS like send-keys.
+// list-sessions lists the names in the file $FAKE_TMUX_SESSIONS, and fails
+// like a tmux without a server when there is no such file.
+var fakeTmux = fmt.Sprintf(`#!/bin/sh
+[ "$3" != rc-gone ] || exit 1
+cwd() { cat "$FAKE_TMUX_CWD/$1" 2>/dev/null || echo "/home/user/$1"; }
+flag() { [ -e "$FAKE_TMUX_ALERTS/$2.$1" ] && echo 1 || echo 0; }
+mode() { [ -e "$FAKE_TMUX_MODE/$1" ] && echo 1 || echo 0; }
+case "$1" in
+list-panes) # list-panes -t <name> -F <format>
+	printf '%%s\n' "$5" | sed -e "s|#{pane_current_path}|$(cwd "$3")|" -e 's|#{pane_pid}|%d|' -e 's|#{session_created}|%d|' \
+		-e "s|#{window_bell_flag}|$(flag bell "$3")|" -e "s|#{window_activity_flag}|$(flag activity "$3")|" \
+		-e "s|#{pane_in_mode}|$(mode "$3")|" -e "s|#{scroll_position}|$([ "$(mode "$3")" = 0 ] || echo 7)|" -e 's|#{history_size}|500|' \
+		-e "s|#{pane_current_command}|${FAKE_TMUX_COMMAND:-bash}|" ;;
+kill-session) # kill-session -C -t <name>
+	[ "$2" = -C ] && rm -f "$FAKE_TMUX_ALERTS/$4.bell" "$FAKE_TMUX_ALERTS/$4.activity" ;;
+new-session) # new-session -d -s <name> ...
+	[ -z "$FAKE_TMUX_NEW" ] || printf '%%s\n' "$@" > "$FAKE_TMUX_NEW/$4" ;;
+set-option) # set-option [-u] -t <name> <option> [value] [\; set-option ...]
+	[ -z "$FAKE_TMUX_OPTIONS" ] || echo "$*" >> "$FAKE_TMUX_OPTIONS" ;;
+display-message) # display-message -t <name> -p '#{pane_current_command}'
+	echo "${FAKE_TMUX_COMMAND:-bash}" ;;
+send-keys) # send-keys -t <name> -l <text> \; send-keys -t <name> Enter
+	[ -z "$FAKE_TMUX_KEYS" ] || echo "$*" >> "$FAKE_TMUX_KEYS" ;;
+attach-session)
+	[ -z "$FAKE_TMUX_OUTPUT" ] || cat "$FAKE_TMUX_OUTPUT"
+	[ -z "$FAKE_TMUX_HOLD" ] || sleep "$FAKE_TMUX_HOLD" ;;
+list-sessions) # list-sessions -F <format>
+	[ -f "$FAKE_TMUX_SESSIONS" ] || { echo "no server running" >&2; exit 1; }
+	sed 's/$/:%[2]d:0:80:24/' "$FAKE_TMUX_SESSIONS" ;;
+copy-mode) # copy-mode -e -t <name> \; send-keys -t <name> -X ...
+	[ -z "$FAKE_TMUX_KEYS" ] || echo "$*" >> "$FAKE_TMUX_KEYS"
+	[ -z "$FAKE_TMUX_MODE" ] || touch "$FAKE_TMUX_MODE/$4" ;;
+if-shell) # if-shell -F -t <name> '#{pane_in_mode}' <command>
+	[ ! -e "$FAKE_TMUX_MODE/$4.stuck" ] || exit 1
+	[ -z "$FAKE_TMUX_KEYS" ] || echo "$*" >> "$FAKE_TMUX_KEYS"
+	case "$6" in *cancel) rm -f "$FAKE_TMUX_MODE/$4" ;; esac ;;
+has-session) ;;
+*) exit 1 ;;
+esac
+`, fakeTmuxPID, fakeTmuxCreated)
+
+// shellTestHost connects host-1 to an SSH server that runs commands with the
+// local shell, with fake tmux and claude binaries first on the PATH. It
+// returns a counter of the SSH sessions the bridge opened.
+func shellTestHost(t *testing.T, s *Server) *int32 {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	bin := t.TempDir()
+	for name, script := range map[string]string{"tmux": fakeTmux, "claude": "#!/bin/sh\n"} {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var sessions int32
+	srv := startTestSSHServer(t)
+	srv.HandleExecInput(func(cmd string, stdin io.Reader) string {
+		atomic.AddInt32(&sessions, 1)
+		c := exec.Command("sh", "-c", cmd)
+		c.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+		c.Stdin = stdin
+		output, _ := c.Output()
+		return string(output)
+	})
+	if _, err := s.sshManager.Connect("host-1", "127.0.0.1", srv.Port(), "user",
+		ssh.AuthConfig{AuthType: "password", Password: "secret"}); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	return &sessions
+}
+
+func TestHostStatusBatchesPaneQueries(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+	sessions := shellTestHost(t, s)
+	client := s.sshManager.GetConnection("host-1").Client
+
+	// Ten processes on the host, one of whose tmux session has gone away
+	const numProcs = 10
+	for i := 0; i < numProcs; i++ {
+		id := fmt.Sprintf("proc-%d", i)
+		tmuxName := pty.TmuxSessionName(id)
+		if i == numProcs-1 {
+			tmuxName = "rc-gone"
+		}
+		ptySession := &pty.Session{ID: id, HostID: "host-1", TmuxName: tmuxName}
+		ptySession.UpdateSSHClient(client)
+		s.processRegistry.Register(&process.Process{ID: id, HostID: "host-1", Type: process.TypeShell,
+			CWD: "/previous", PTY: ptySession})
+	}
+
+	dispatch(t, s, cs, protocol.TypeHostStatusRequest, protocol.HostStatusRequestPayload{HostID: "host-1"})
+	var status protocol.HostStatusPayload
+	readPayload(t, conn, protocol.TypeHostStatus, &status)
+	var result protocol.HostRequirementsResultPayload
+	readPayload(t, conn, protocol.TypeHostRequirementsResult, &result)
+
+	dispatch(t, s, cs, protocol.TypeProcessList, protocol.ProcessListPayload{HostID: "host-1"})
+	var list protocol.ProcessListResultPayload
+	readPayload(t, conn, protocol.TypeProcessListResult, &list)
+
+	// One session per process per refresh plus one per which check before;
+	// now one batch for the panes, one for requirements and one for the list
+	if got := atomic.LoadInt32(sessions); got > 3 {
+		t.Errorf("opened %d SSH sessions for %d processes, want at most 3", got, numProcs)
+	}
+
+	for _, infos := range [][]protocol.ProcessInfo{status.Processes, list.Processes} {
+		if len(infos) != numProcs {
+			t.Fatalf("got %d processes, want %d", len(infos), numProcs)
+		}
+		for _, info := range infos {
+			want := "/home/user/" + pty.TmuxSessionName(info.ID)
+			if info.ID == fmt.Sprintf("proc-%d", numProcs-1) {
+				want = "/previous"
+			}
+			if info.CWD != want {
+				t.Errorf("%s CWD = %q, want %q", info.ID, info.CWD, want)
+			}
+		}
+	}
+
+	// The requirements follow the status, which has none before the first check
+	if status.Requirements != nil || !result.Requirements.ClaudeInstalled {
+		t.Errorf("status requirements = %+v, pushed %+v, want claude installed pushed", status.Requirements, result.Requirements)
+	}
+}
+
+func TestRenameSendsOnlyAnUpdate(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	sessions := shellTestHost(t, s)
+	registerShells(t, s, 15)
+
+	// Neither the host's full status nor a requirements check: the host
+	// isn't touched at all
+	before := atomic.LoadInt32(sessions)
+	dispatch(t, s, cs, protocol.TypeProcessRename, protocol.ProcessRenamePayload{ProcessID: "proc-3", Name: "api"})
+	var update protocol.ProcessUpdatedPayload
+	readPayload(t, conn, protocol.TypeProcessUpdated, &update)
+	if update.ID != "proc-3" || update.Name == nil || *update.Name != "api" {
+		t.Errorf("update = %+v", update)
+	}
+	expectNothingQueued(t, conn, cs)
+	if got := atomic.LoadInt32(sessions); got != before {
+		t.Errorf("rename opened %d SSH sessions", got-before)
+	}
+}
+
+func TestReattachSendsDeltas(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	watcher, watcherCS := connectTestClient(t, s)
+	shellTestHost(t, s)
+	dispatch(t, s, watcherCS, protocol.TypeProcessesSubscribe, protocol.ProcessesSubscribePayload{HostID: "host-1"})
+	readPayload(t, watcher, protocol.TypeProcessListResult, nil)
+
+	const id, other = "6f1c2a9e-3b4d-4c5e-8f70-1a2b3c4d5e6f", "0f1c2a9e-3b4d-4c5e-8f70-1a2b3c4d5e6f"
+	var stale []protocol.StaleProcess
+	for _, processID := range []string{id, other} {
+		tmuxName := pty.TmuxSessionName(processID)
+		stale = append(stale, protocol.StaleProcess{Reason: "detached", TmuxSession: &tmuxName, ProcessID: &processID})
+	}
+	s.processRegistry.MergeStaleProcesses("host-1", stale)
+
+	dispatch(t, s, cs, protocol.TypeProcessReattach, protocol.ProcessReattachPayload{
+		HostID: "host-1", TmuxSession: pty.TmuxSessionName(id), ProcessID: id,
+	})
+	for _, c := range []struct {
+		conn *websocket.Conn
+		cs   *ConnectedSession
+	}{{conn, cs}, {watcher, watcherCS}} {
+		var added protocol.ProcessAddedPayload
+		readPayload(t, c.conn, protocol.TypeProcessAdded, &added)
+		if added.HostID != "host-1" || added.Process.ID != id {
+			t.Errorf("added = %+v", added)
+		}
+		var changed protocol.StaleProcessesChangedPayload
+		readPayload(t, c.conn, protocol.TypeStaleProcessesChanged, &changed)
+		if len(changed.StaleProcesses) != 1 || *changed.StaleProcesses[0].ProcessID != other {
+			t.Errorf("stale processes = %+v, want only %s", changed.StaleProcesses, other)
+		}
+		expectNothingQueued(t, c.conn, c.cs)
+	}
+
+	// The full status is there for the asking, but not for a host that
+	// isn't connected
+	dispatch(t, s, cs, protocol.TypeHostStatusRequest, protocol.HostStatusRequestPayload{HostID: "host-1"})
+	var status protocol.HostStatusPayload
+	readPayload(t, conn, protocol.TypeHostStatus, &status)
+	if len(status.Processes) != 1 || status.StaleProcesses == nil || len(*status.StaleProcesses) != 1 {
+		t.Errorf("host status = %+v", status)
+	}
+	readPayload(t, conn, protocol.TypeHostRequirementsResult, nil)
+	var errPayload protocol.ErrorPayload
+	dispatch(t, s, cs, protocol.TypeHostStatusRequest, protocol.HostStatusRequestPayload{HostID: "host-2"})
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotConnected {
+		t.Errorf("status of a host that isn't connected = %+v", errPayload)
+	}
+}
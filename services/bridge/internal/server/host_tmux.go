@@ -0,0 +1,65 @@
+package server
+
+import (
+	"log"
+	"strings"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// ============================================================================
+// Per-Host tmux
+// ============================================================================
+//
+// Several users sharing an account on a host would otherwise share one tmux
+// server, and see (and reattach) each other's sessions. A host's settings can
+// point the bridge at another server's socket, or another tmux program, and
+// every tmux command on the host goes through the pty.Tmux built from them.
+// Sessions keep the tmux they were created or reattached with; a change
+// applies to the ones that come after it and to the next scan.
+
+// hostTmux returns the tmux the bridge runs on a host, from its settings
+func (s *Server) hostTmux(hostID string) pty.Tmux {
+	if s.storage == nil {
+		return pty.Tmux{}
+	}
+	settings, err := s.storage.GetHostTmux(hostID)
+	if err != nil {
+		log.Printf("[WARN] [TMUX] Failed to get tmux settings of host %s, using the defaults: %v", hostID, err)
+		return pty.Tmux{}
+	}
+	return pty.Tmux{Command: settings.Command, SocketPath: settings.SocketPath}
+}
+
+// sshHostConfig converts a stored host to its protocol form (credentials
+// omitted), with its tmux settings, operation limit and startup hooks
+func (s *Server) sshHostConfig(h storage.SSHHost) protocol.SSHHostConfig {
+	config := toSSHHostConfig(h)
+	tmux := s.hostTmux(h.ID)
+	config.TmuxSocketPath, config.TmuxCommand = tmux.SocketPath, tmux.Command
+	config.MaxConcurrentOps = s.hostMaxConcurrentOps(h.ID)
+	config.StartupHooks = s.hostStartupHooks(h.ID)
+	return config
+}
+
+// updateHostTmux saves the tmux settings a host_config_update changes, if
+// any, and returns the host's settings after it
+func (s *Server) updateHostTmux(hostID string, payload protocol.HostConfigUpdatePayload) (pty.Tmux, error) {
+	tmux := s.hostTmux(hostID)
+	if payload.TmuxSocketPath == nil && payload.TmuxCommand == nil {
+		return tmux, nil
+	}
+	if payload.TmuxSocketPath != nil {
+		tmux.SocketPath = strings.TrimSpace(*payload.TmuxSocketPath)
+	}
+	if payload.TmuxCommand != nil {
+		tmux.Command = strings.TrimSpace(*payload.TmuxCommand)
+	}
+	if err := s.storage.SetHostTmux(hostID, storage.HostTmux{SocketPath: tmux.SocketPath, Command: tmux.Command}); err != nil {
+		return pty.Tmux{}, err
+	}
+	log.Printf("[INFO] [TMUX] Set tmux of host %s: socket %q, command %q", hostID, tmux.SocketPath, tmux.Command)
+	return tmux, nil
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// sharedTmux installs a tmux command for host-1 that records its arguments
+// and runs the fake tmux without the socket, and returns the arguments of
+// every call so far
+func sharedTmux(t *testing.T, s *Server, socketPath string) func() []string {
+	t.Helper()
+	log := filepath.Join(t.TempDir(), "calls")
+	command := filepath.Join(t.TempDir(), "shared tmux")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$*\" >> '%s'\n[ \"$1\" != -S ] || shift 2\nexec tmux \"$@\"\n", log)
+	if err := os.WriteFile(command, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.storage.SetHostTmux("host-1", storage.HostTmux{SocketPath: socketPath, Command: command}); err != nil {
+		t.Fatalf("SetHostTmux: %v", err)
+	}
+	return func() []string {
+		data, _ := os.ReadFile(log)
+		return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+}
+
+func TestHostTmuxRunsEveryCommandOnTheHostsServer(t *testing.T) {
+	t.Setenv("FAKE_TMUX_HOLD", "10")
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	const socket = "/tmp/rc shared/default"
+	calls := sharedTmux(t, s, socket)
+
+	dispatch(t, s, cs, protocol.TypeProcessCreate, protocol.ProcessCreatePayload{HostID: "host-1"})
+	var created protocol.ProcessCreatedPayload
+	readPayload(t, conn, protocol.TypeProcessCreated, &created)
+	processID := created.Process.ID
+	dispatch(t, s, cs, protocol.TypePtyResize, protocol.PtyResizePayload{ProcessID: processID, Cols: 100, Rows: 30})
+	dispatch(t, s, cs, protocol.TypeProcessList, protocol.ProcessListPayload{HostID: "host-1"})
+	readPayload(t, conn, protocol.TypeProcessListResult, nil)
+	fakeTmuxSessions(t, pty.TmuxSessionName(uuid.New().String()))
+	if sessions, _, err := s.listTmuxSessions(context.Background(), "host-1", s.sshManager.GetConnection("host-1"), nil, nil); err != nil || len(sessions) != 1 {
+		t.Errorf("scan found %d sessions, %v; want 1", len(sessions), err)
+	}
+	dispatch(t, s, cs, protocol.TypeProcessKill, protocol.ProcessKillPayload{ProcessID: processID})
+	readPayload(t, conn, protocol.TypeProcessKilled, nil)
+
+	ran := make(map[string]bool)
+	for _, call := range calls() {
+		args, ok := strings.CutPrefix(call, "-S "+socket+" ")
+		if !ok {
+			t.Errorf("tmux ran %q without the host's socket", call)
+			continue
+		}
+		ran[strings.Fields(args)[0]] = true
+	}
+	for _, command := range []string{"new-session", "attach-session", "resize-window", "list-panes", "list-sessions", "kill-session"} {
+		if !ran[command] {
+			t.Errorf("%s didn't run through the host's tmux; ran %v", command, calls())
+		}
+	}
+}
+
+func TestHostTmuxReadsDetachedCloneSource(t *testing.T) {
+	t.Setenv("FAKE_TMUX_CWD", t.TempDir())
+	t.Setenv("FAKE_TMUX_NEW", t.TempDir())
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	const socket = "/tmp/rc shared/default"
+	calls := sharedTmux(t, s, socket)
+
+	if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{
+		ProcessID: "detached", HostID: "host-1", ProcessType: "shell", TmuxName: pty.TmuxSessionName("detached"),
+		CWD: "/stored/detached", StartedAt: time.Now(),
+	}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	setPaneCWD(t, "rc-detached", "/work/live")
+
+	// The pane is read on the host's tmux server, not the default one
+	dispatch(t, s, cs, protocol.TypeProcessClone, protocol.ProcessClonePayload{SourceProcessID: "detached"})
+	var created protocol.ProcessCreatedPayload
+	readPayload(t, conn, protocol.TypeProcessCreated, &created)
+	if created.Process.CWD != "/work/live" {
+		t.Errorf("clone cwd = %q, want the pane's", created.Process.CWD)
+	}
+	if !slices.ContainsFunc(calls(), func(call string) bool {
+		return strings.HasPrefix(call, "-S "+socket+" list-panes") && strings.Contains(call, "rc-detached")
+	}) {
+		t.Errorf("source pane not read through the host's tmux; ran %q", calls())
+	}
+}
+
+func TestHostConfigUpdateTmux(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	if err := s.storage.CreateSSHHost(storage.SSHHost{ID: "host-1", Name: "dev", Host: "dev.example", Port: 22, Username: "me", AuthType: "agent"}); err != nil {
+		t.Fatalf("CreateSSHHost: %v", err)
+	}
+
+	socket, command := " /srv/shared/tmux.sock ", "/opt/tmux/bin/tmux"
+	dispatch(t, s, cs, protocol.TypeHostConfigUpdate, protocol.HostConfigUpdatePayload{ID: "host-1", TmuxSocketPath: &socket, TmuxCommand: &command})
+	var updated protocol.HostConfigUpdateResultPayload
+	readPayload(t, conn, protocol.TypeHostConfigUpdateResult, &updated)
+	if !updated.Success || updated.Host.TmuxSocketPath != "/srv/shared/tmux.sock" || updated.Host.TmuxCommand != command {
+		t.Fatalf("update result = %+v", updated)
+	}
+	if tmux := s.hostTmux("host-1"); tmux.SocketPath != "/srv/shared/tmux.sock" || tmux.Command != command {
+		t.Errorf("host tmux = %+v", tmux)
+	}
+
+	// Other updates leave it, and the list shows it
+	name := "shared"
+	dispatch(t, s, cs, protocol.TypeHostConfigUpdate, protocol.HostConfigUpdatePayload{ID: "host-1", Name: &name})
+	readPayload(t, conn, protocol.TypeHostConfigUpdateResult, nil)
+	dispatch(t, s, cs, protocol.TypeHostConfigList, protocol.HostConfigListPayload{})
+	var list protocol.HostConfigListResultPayload
+	readPayload(t, conn, protocol.TypeHostConfigListResult, &list)
+	if len(list.Hosts) != 1 || list.Hosts[0].TmuxSocketPath != "/srv/shared/tmux.sock" || list.Hosts[0].TmuxCommand != command {
+		t.Errorf("list = %+v", list.Hosts)
+	}
+
+	// "" goes back to the default server
+	none := ""
+	dispatch(t, s, cs, protocol.TypeHostConfigUpdate, protocol.HostConfigUpdatePayload{ID: "host-1", TmuxSocketPath: &none})
+	updated = protocol.HostConfigUpdateResultPayload{}
+	readPayload(t, conn, protocol.TypeHostConfigUpdateResult, &updated)
+	if updated.Host.TmuxSocketPath != "" || updated.Host.TmuxCommand != command {
+		t.Errorf("after clearing the socket: %+v", updated.Host)
+	}
+}
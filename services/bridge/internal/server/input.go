@@ -0,0 +1,40 @@
+package server
+
+import (
+	"cmp"
+	"log"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/input"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// ============================================================================
+// Input Sanitizing
+// ============================================================================
+//
+// pty_input, chat_raw and snippet_execute send a client's bytes on to a
+// terminal or to Claude's raw input. A malformed escape sequence among them
+// can leave a full-screen program in a mode that is hard to get out of from
+// a phone, so each request may say how its input is sanitized (see package
+// input), with a default that fits what it is for: pty_input comes from
+// terminal emulators and is raw, chat_raw carries the key bar's keys, and a
+// snippet is text.
+
+// inputMode returns the input mode a request asked for, or def if it asked
+// for none
+func inputMode(requested, def protocol.InputMode) protocol.InputMode {
+	return cmp.Or(requested, def)
+}
+
+// sanitizeInput drops what mode doesn't allow from input bound for target,
+// logging how much it dropped
+func sanitizeInput(data string, mode protocol.InputMode, target string) (string, error) {
+	clean, err := input.Sanitize(data, input.Mode(mode))
+	if err != nil {
+		return "", err
+	}
+	if dropped := len(data) - len(clean); dropped > 0 {
+		log.Printf("[DEBUG] [INPUT] Dropped %d of %d bytes of input to %s in %s mode", dropped, len(data), target, mode)
+	}
+	return clean, nil
+}
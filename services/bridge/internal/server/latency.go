@@ -0,0 +1,195 @@
+package server
+
+import (
+	"log"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/session"
+)
+
+// ============================================================================
+// Client Latency
+// ============================================================================
+//
+// "The terminal feels slow" is either the SSH leg or the bridge-client one.
+// For the latter the bridge pings every WebSocket client each
+// Config.PingInterval and times the pongs into a moving average on the
+// session, which session_info, the admin socket and GET /api/v1/sessions
+// report. Clients that auth with the server_timestamps capability also get
+// the bridge's clock on pty_output and chat_event, to tell one-way delay
+// apart from the round trip.
+
+// pingWriteTimeout bounds writing a ping to a client's connection
+const pingWriteTimeout = 5 * time.Second
+
+// heartbeat pings a connection and times its pongs. Only the newest ping is
+// timed; a pong coming after the next ping was sent is ignored.
+type heartbeat struct {
+	mu     sync.Mutex
+	seq    uint64
+	sentAt time.Time // When ping seq was sent; zero once answered
+}
+
+// run pings conn every interval until stop is closed or a ping fails
+func (h *heartbeat) run(conn *websocket.Conn, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		h.mu.Lock()
+		h.seq++
+		payload := strconv.FormatUint(h.seq, 10)
+		h.sentAt = time.Now()
+		h.mu.Unlock()
+		if err := conn.WriteControl(websocket.PingMessage, []byte(payload), time.Now().Add(pingWriteTimeout)); err != nil {
+			log.Printf("[DEBUG] [WS] Ping to %s failed: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// answered returns the round trip of the ping a pong answers, and false
+// if the pong isn't for the newest unanswered ping
+func (h *heartbeat) answered(payload string) (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sentAt.IsZero() || payload != strconv.FormatUint(h.seq, 10) {
+		return 0, false
+	}
+	rtt := time.Since(h.sentAt)
+	h.sentAt = time.Time{}
+	return rtt, true
+}
+
+// startHeartbeat pings a connection's client until stop is closed, recording
+// the round trips on whichever session the connection has then; auth may
+// swap it for the session it reconnects to. Pongs are handled by the
+// connection's read loop.
+func (s *Server) startHeartbeat(connSession *ConnectedSession, stop <-chan struct{}) {
+	if s.config.PingInterval <= 0 {
+		return
+	}
+	h := &heartbeat{}
+	connSession.Conn.SetPongHandler(func(payload string) error {
+		if rtt, ok := h.answered(payload); ok {
+			connSession.RecordRTT(rtt, time.Now())
+		}
+		return nil
+	})
+	go h.run(connSession.Conn, s.config.PingInterval, stop)
+}
+
+// supportedCapabilities are the AuthPayload capabilities the bridge knows
+var supportedCapabilities = []string{protocol.CapabilityServerTimestamps}
+
+// configureCapabilities enables the capabilities a client asked for that
+// the bridge knows, and returns them. Like the locale they are renegotiated
+// by every auth.
+func (s *Server) configureCapabilities(session *ConnectedSession, requested []string) []string {
+	var enabled []string
+	for _, capability := range requested {
+		if slices.Contains(supportedCapabilities, capability) && !slices.Contains(enabled, capability) {
+			enabled = append(enabled, capability)
+		}
+	}
+	session.SetServerTimestamps(slices.Contains(enabled, protocol.CapabilityServerTimestamps))
+	return enabled
+}
+
+// stampedMessage builds a pty_output or chat_event at most twice: once
+// with the bridge's clock, for sessions with the server_timestamps
+// capability, and once without it for the rest, whose clients may not know
+// the field. It is used by one goroutine.
+type stampedMessage struct {
+	build   func(serverTs int64) (*protocol.Message, error)
+	plain   *protocol.Message
+	stamped *protocol.Message
+}
+
+func newStampedMessage(build func(serverTs int64) (*protocol.Message, error)) *stampedMessage {
+	return &stampedMessage{build: build}
+}
+
+// forSession returns the message to send to a session
+func (m *stampedMessage) forSession(sess *session.Session) (*protocol.Message, error) {
+	var err error
+	if !sess.ServerTimestamps() {
+		if m.plain == nil {
+			m.plain, err = m.build(0)
+		}
+		return m.plain, err
+	}
+	if m.stamped == nil {
+		m.stamped, err = m.build(time.Now().UnixMilli())
+	}
+	return m.stamped, err
+}
+
+// connectedSessionInfo describes a session for session_info and the
+// REST sessions view
+func connectedSessionInfo(sess *session.Session) protocol.ConnectedSessionInfo {
+	role, _ := sess.Role()
+	sess.Lock()
+	deviceLabel := sess.DeviceLabel
+	sess.Unlock()
+
+	info := protocol.ConnectedSessionInfo{
+		ID:          sess.ID,
+		DeviceLabel: deviceLabel,
+		Role:        string(role),
+		CreatedAt:   sess.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	if rtt := sess.RTT(); rtt.Samples > 0 {
+		info.Latency = &protocol.SessionLatency{
+			RTTMs:      durationMs(rtt.Smoothed),
+			LastRTTMs:  durationMs(rtt.Last),
+			MinRTTMs:   durationMs(rtt.Min),
+			Samples:    rtt.Samples,
+			MeasuredAt: rtt.LastAt.UTC().Format(time.RFC3339),
+		}
+	}
+	return info
+}
+
+// durationMs converts a duration to fractional milliseconds
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// connectedSessionInfos describes every connected session, oldest first
+func (s *Server) connectedSessionInfos() []protocol.ConnectedSessionInfo {
+	sessions := s.sessionManager.GetConnectedSessions()
+	slices.SortFunc(sessions, func(a, b *session.Session) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	infos := make([]protocol.ConnectedSessionInfo, 0, len(sessions))
+	for _, sess := range sessions {
+		infos = append(infos, connectedSessionInfo(sess))
+	}
+	return infos
+}
+
+// handleSessionInfo describes the requesting session and its latency, and
+// to an owner that may see the whole bridge, every connected session
+func (s *Server) handleSessionInfo(connSession *ConnectedSession, msg *protocol.Message) error {
+	log.Printf("[DEBUG] [SESSION] Session info requested by session %s", connSession.ID)
+
+	result := protocol.SessionInfoResultPayload{Session: connectedSessionInfo(connSession.Session)}
+	if role, scope := connSession.Role(); role == session.RoleOwner && scope.Unlimited() {
+		result.Sessions = s.connectedSessionInfos()
+	}
+	response, err := protocol.NewMessage(protocol.TypeSessionInfoResult, result)
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/agentapi"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/session"
+)
+
+func TestHeartbeatAnswered(t *testing.T) {
+	h := &heartbeat{seq: 2, sentAt: time.Now().Add(-30 * time.Millisecond)}
+	if _, ok := h.answered("1"); ok {
+		t.Error("a pong for an older ping was timed")
+	}
+	rtt, ok := h.answered("2")
+	if !ok || rtt < 30*time.Millisecond {
+		t.Errorf("answered = %v, %v; want at least 30ms", rtt, ok)
+	}
+	if _, ok := h.answered("2"); ok {
+		t.Error("a second pong for the same ping was timed")
+	}
+}
+
+// dialWithCapabilities connects a loopback client that asks for capabilities
+func dialWithCapabilities(t *testing.T, s *Server, capabilities []string) (*websocket.Conn, protocol.AuthResultPayload) {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	t.Cleanup(ts.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	auth, _ := protocol.NewMessage(protocol.TypeAuth, protocol.AuthPayload{ProtocolVersion: &protocolVersion, Capabilities: capabilities})
+	if err := conn.WriteJSON(auth); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var result protocol.AuthResultPayload
+	readPayload(t, conn, protocol.TypeAuthResult, &result)
+	if !result.Success {
+		t.Fatalf("auth failed: %+v", result)
+	}
+	return conn, result
+}
+
+func TestSessionInfoReportsLatency(t *testing.T) {
+	config := DefaultConfig()
+	config.PingInterval = 10 * time.Millisecond
+	s := newTestServer(t, config)
+	conn, cs := connectTestClient(t, s)
+	_, other := connectTestClient(t, s)
+
+	// The client answers pings while it reads, so ask until one was timed
+	var info protocol.SessionInfoResultPayload
+	deadline := time.Now().Add(5 * time.Second)
+	for info.Session.Latency == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("no ping was answered")
+		}
+		time.Sleep(20 * time.Millisecond)
+		dispatch(t, s, cs, protocol.TypeSessionInfo, protocol.SessionInfoPayload{})
+		readPayload(t, conn, protocol.TypeSessionInfoResult, &info)
+	}
+	if info.Session.ID != cs.ID || info.Session.Role != "owner" {
+		t.Errorf("session = %+v", info.Session)
+	}
+	if l := info.Session.Latency; l.Samples < 1 || l.RTTMs <= 0 || l.MinRTTMs > l.RTTMs || l.MeasuredAt == "" {
+		t.Errorf("latency = %+v", l)
+	}
+
+	// An owner sees every connected session, as the REST view does
+	ids := func(infos []protocol.ConnectedSessionInfo) []string {
+		var ids []string
+		for _, info := range infos {
+			ids = append(ids, info.ID)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	want := []string{cs.ID, other.ID}
+	slices.Sort(want)
+	if got := ids(info.Sessions); !slices.Equal(got, want) {
+		t.Errorf("sessions = %v, want %v", got, want)
+	}
+	rec := restGet(t, s, "/api/v1/sessions", "")
+	var listed restSessionListResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got := ids(listed.Sessions); rec.Code != http.StatusOK || !slices.Equal(got, want) {
+		t.Errorf("REST sessions = %d %v, want %v", rec.Code, got, want)
+	}
+
+	// An observer only sees its own
+	cs.SetRole(session.RoleObserver, session.Scope{}, "")
+	info = protocol.SessionInfoResultPayload{}
+	dispatch(t, s, cs, protocol.TypeSessionInfo, protocol.SessionInfoPayload{})
+	readPayload(t, conn, protocol.TypeSessionInfoResult, &info)
+	if info.Session.ID != cs.ID || info.Sessions != nil {
+		t.Errorf("observer's info = %+v", info)
+	}
+}
+
+func TestServerTimestampsCapability(t *testing.T) {
+	s := newQuietServer(t)
+	stampedConn, auth := dialWithCapabilities(t, s, []string{"unknown", protocol.CapabilityServerTimestamps})
+	if !slices.Equal(auth.Capabilities, []string{protocol.CapabilityServerTimestamps}) {
+		t.Fatalf("capabilities = %v, want only the known one", auth.Capabilities)
+	}
+	stamped := &ConnectedSession{Session: s.sessionManager.GetSession(*auth.SessionID), server: s}
+	plainConn, plain := connectTestClient(t, s)
+
+	// pty_output carries the bridge's clock only for the session that asked
+	proc := &process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeShell}
+	before := time.Now().UnixMilli()
+	s.forwardPtyOutput(stamped, proc, []byte("ls\r\n"))
+	var output protocol.PtyOutputPayload
+	readPayload(t, stampedConn, protocol.TypePtyOutput, &output)
+	if output.ServerTs < before || output.ServerTs > time.Now().UnixMilli() {
+		t.Errorf("serverTs = %d, want the time it was sent", output.ServerTs)
+	}
+	s.forwardPtyOutput(plain, proc, []byte("ls\r\n"))
+	var raw map[string]interface{}
+	readPayload(t, plainConn, protocol.TypePtyOutput, &raw)
+	if _, ok := raw["serverTs"]; ok {
+		t.Errorf("pty_output to a session without the capability: %v", raw)
+	}
+
+	// So does chat_event, built once per kind for every subscriber
+	stamped.SubscribeChat("host-1", "proc-1")
+	plain.SubscribeChat("host-1", "proc-1")
+	data, _ := json.Marshal(agentapi.StatusChangeData{Status: "running"})
+	s.handleAgentAPIEvent("host-1", "proc-1", agentapi.SSEEvent{Type: agentapi.EventStatusChange, Data: data})
+	var event protocol.ChatEventPayload
+	readPayload(t, stampedConn, protocol.TypeChatEvent, &event)
+	if event.ServerTs == 0 {
+		t.Error("chat_event to the session with the capability has no serverTs")
+	}
+	raw = nil
+	readPayload(t, plainConn, protocol.TypeChatEvent, &raw)
+	if _, ok := raw["serverTs"]; ok {
+		t.Errorf("chat_event to a session without the capability: %v", raw)
+	}
+}
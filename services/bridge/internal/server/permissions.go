@@ -0,0 +1,275 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/session"
+)
+
+// ============================================================================
+// Permissions
+// ============================================================================
+//
+// Every request needs a role (see session.Role), granted at auth. Observers
+// may read state, history and output and choose what they watch; typing into
+// a terminal, changing processes, hosts, env vars or stored config, and
+// revealing secrets need the owner. A type missing from messageRoles needs
+// the owner.
+
+// messageRoles is the role each request type needs
+var messageRoles = map[string]session.Role{
+	protocol.TypeAuth:                session.RoleNone,
+	protocol.TypeSessionRefreshToken: session.RoleObserver,
+	protocol.TypeSessionInviteCreate: session.RoleOwner,
+	protocol.TypeSessionInviteRevoke: session.RoleOwner,
+
+	// Host config and connections
+	protocol.TypeHostConfigList:            session.RoleObserver,
+	protocol.TypeHostConfigCreate:          session.RoleOwner,
+	protocol.TypeHostConfigUpdate:          session.RoleOwner,
+	protocol.TypeHostConfigDelete:          session.RoleOwner,
+	protocol.TypeHostConfigImportSSHConfig: session.RoleOwner,
+	protocol.TypeHostConnect:               session.RoleOwner,
+	protocol.TypeHostDisconnect:            session.RoleOwner,
+	protocol.TypeHostStatusRequest:         session.RoleObserver,
+	protocol.TypeHostCheckRequirements:     session.RoleOwner,
+	protocol.TypeHostExec:                  session.RoleOwner,
+	protocol.TypeHostDiagnostics:           session.RoleOwner,
+	protocol.TypePortsScan:                 session.RoleOwner,
+	protocol.TypeFileDownload:              session.RoleOwner,
+	protocol.TypeFileDownloadCancel:        session.RoleOwner,
+	protocol.TypeFileUploadBegin:           session.RoleOwner,
+	protocol.TypeFileUploadChunk:           session.RoleOwner,
+	protocol.TypeFileUploadCommit:          session.RoleOwner,
+
+	// Processes
+	protocol.TypeProcessList:            session.RoleObserver,
+	protocol.TypeProcessSelect:          session.RoleObserver,
+	protocol.TypeProcessTimelineList:    session.RoleObserver,
+	protocol.TypeProcessesSubscribe:     session.RoleObserver,
+	protocol.TypeProcessesUnsubscribe:   session.RoleObserver,
+	protocol.TypeProcessCreate:          session.RoleOwner,
+	protocol.TypeProcessKill:            session.RoleOwner,
+	protocol.TypeProcessReattach:        session.RoleOwner,
+	protocol.TypeProcessRename:          session.RoleOwner,
+	protocol.TypeProcessClone:           session.RoleOwner,
+	protocol.TypeProcessPin:             session.RoleOwner,
+	protocol.TypeProcessSetOrder:        session.RoleOwner,
+	protocol.TypeProcessTermOptions:     session.RoleOwner,
+	protocol.TypeProcessEnableTimeline:  session.RoleOwner,
+	protocol.TypeProcessClearError:      session.RoleOwner,
+	protocol.TypeProcessSetAppearance:   session.RoleOwner,
+	protocol.TypeClaudeStart:            session.RoleOwner,
+	protocol.TypeClaudeKill:             session.RoleOwner,
+	protocol.TypeClaudeReattachTerminal: session.RoleOwner,
+
+	// Archived processes
+	protocol.TypeArchivedProcessList:   session.RoleObserver,
+	protocol.TypeArchivedProcessGet:    session.RoleObserver,
+	protocol.TypeArchivedProcessDelete: session.RoleOwner,
+
+	// Terminal; resizing and scrolling change what the owner sees too
+	protocol.TypePtyHistoryRequest: session.RoleObserver,
+	protocol.TypePtyInput:          session.RoleOwner,
+	protocol.TypePtyResize:         session.RoleOwner,
+	protocol.TypePtyScroll:         session.RoleOwner,
+
+	// Chat
+	protocol.TypeChatSubscribe:   session.RoleObserver,
+	protocol.TypeChatUnsubscribe: session.RoleObserver,
+	protocol.TypeChatStatus:      session.RoleObserver,
+	protocol.TypeChatHistory:     session.RoleObserver,
+	protocol.TypeChatSearch:      session.RoleObserver,
+	protocol.TypeChatUsage:       session.RoleObserver,
+	protocol.TypeChatDraftGet:    session.RoleObserver,
+	protocol.TypeChatSend:        session.RoleOwner,
+	protocol.TypeChatRaw:         session.RoleOwner,
+	protocol.TypeChatDraftSet:    session.RoleOwner,
+
+	// Environment variables; values stay masked for observers
+	protocol.TypeEnvList:        session.RoleObserver,
+	protocol.TypeProcessEnvList: session.RoleObserver,
+	protocol.TypeEnvUpdate:      session.RoleOwner,
+	protocol.TypeEnvSetRcFile:   session.RoleOwner,
+	protocol.TypeEnvReveal:      session.RoleOwner,
+
+	// Snippets, workspaces and templates
+	protocol.TypeSnippetList:               session.RoleObserver,
+	protocol.TypeSnippetCreate:             session.RoleOwner,
+	protocol.TypeSnippetUpdate:             session.RoleOwner,
+	protocol.TypeSnippetDelete:             session.RoleOwner,
+	protocol.TypeSnippetExecute:            session.RoleOwner,
+	protocol.TypeWorkspaceList:             session.RoleObserver,
+	protocol.TypeWorkspaceCreate:           session.RoleOwner,
+	protocol.TypeWorkspaceUpdate:           session.RoleOwner,
+	protocol.TypeWorkspaceDelete:           session.RoleOwner,
+	protocol.TypeWorkspaceAssign:           session.RoleOwner,
+	protocol.TypeProcessTemplateList:       session.RoleObserver,
+	protocol.TypeProcessTemplateCreate:     session.RoleOwner,
+	protocol.TypeProcessTemplateUpdate:     session.RoleOwner,
+	protocol.TypeProcessTemplateDelete:     session.RoleOwner,
+	protocol.TypeProcessCreateFromTemplate: session.RoleOwner,
+
+	// Diagnostics and storage
+	protocol.TypeBridgeInfo:        session.RoleObserver,
+	protocol.TypeSessionInfo:       session.RoleObserver,
+	protocol.TypeBridgeUpdateCheck: session.RoleObserver,
+	protocol.TypeProfileList:       session.RoleObserver,
+	protocol.TypeStorageEncryptNow: session.RoleOwner,
+	protocol.TypeStorageFlush:      session.RoleOwner,
+}
+
+// unscopedTypes may be requested by a session limited to one host or
+// process even though they name neither
+var unscopedTypes = map[string]bool{
+	protocol.TypeAuth:                true,
+	protocol.TypeSessionRefreshToken: true,
+	protocol.TypeBridgeInfo:          true,
+	protocol.TypeBridgeUpdateCheck:   true,
+	protocol.TypeSessionInfo:         true,
+	protocol.TypeFileDownloadCancel:  true, // Only reaches the session's own downloads
+	protocol.TypeFileUploadChunk:     true, // Only reaches the session's own uploads
+	protocol.TypeFileUploadCommit:    true, // Only reaches the session's own uploads
+}
+
+// requiredRole returns the role a request type needs
+func requiredRole(msgType string) session.Role {
+	if role, ok := messageRoles[msgType]; ok {
+		return role
+	}
+	return session.RoleOwner
+}
+
+// scopeTarget is the host and process a request names, whichever it has
+type scopeTarget struct {
+	HostID    string `json:"hostId"`
+	ProcessID string `json:"processId"`
+}
+
+// checkPermission reports why a session may not make a request, or "" if it
+// may: its role is too low, or the request leaves the host or process its
+// scope is limited to. A scoped session may only make requests that name a
+// host or process in its scope.
+func checkPermission(connSession *ConnectedSession, msg *protocol.Message) string {
+	role, scope := connSession.Role()
+	required := requiredRole(msg.Type)
+	if !role.Allows(required) {
+		if role == session.RoleNone {
+			return "authenticate first"
+		}
+		return "needs the " + string(required) + " role"
+	}
+	if scope.Unlimited() || unscopedTypes[msg.Type] {
+		return ""
+	}
+
+	var target scopeTarget
+	json.Unmarshal(msg.Payload, &target)
+	if target.HostID == "" && target.ProcessID == "" {
+		return "the session is limited to one host or process, and the request names neither"
+	}
+	if !scope.Allows(target.HostID, target.ProcessID) {
+		return "outside the host or process the session is limited to"
+	}
+	// A request may name a process without its host
+	if target.ProcessID != "" {
+		if hostID := connSession.server.processHost(target.ProcessID); hostID != "" && !scope.Allows(hostID, "") {
+			return "outside the host or process the session is limited to"
+		}
+	}
+	return ""
+}
+
+// processHost returns the host of a live or archived process, or "" if
+// neither is known
+func (s *Server) processHost(processID string) string {
+	if proc := s.processRegistry.Get(processID); proc != nil {
+		return proc.HostID
+	}
+	if s.storage != nil {
+		if meta, _ := s.storage.GetArchivedProcess(processID); meta != nil {
+			return meta.HostID
+		}
+	}
+	return ""
+}
+
+// refuseForbidden answers a request the session may not make with FORBIDDEN
+func refuseForbidden(connSession *ConnectedSession, msgType, reason string) {
+	role, _ := connSession.Role()
+	log.Printf("[WARN] [WS] Refused %s from session %s (role %q): %s", msgType, connSession.ID, role, reason)
+	connSession.SendErrorDetails(protocol.ErrorForbidden,
+		protocol.ErrorDetails{"type": msgType, "role": string(role), "reason": reason})
+}
+
+// ============================================================================
+// Scoped State
+// ============================================================================
+//
+// State sent without a request naming a host or process, such as host_status
+// and pushes to a host's subscribers, is cut to what the session's scope
+// allows, so a session limited to one process only learns of that one.
+
+// inScope reports whether the session's scope allows a process on a host,
+// or with processID "", the host's process list as a whole
+func (cs *ConnectedSession) inScope(hostID, processID string) bool {
+	_, scope := cs.Role()
+	if processID == "" && scope.ProcessID != "" {
+		return false
+	}
+	return scope.Allows(hostID, processID)
+}
+
+// scopedProcessInfos returns the processes of infos on hostID that the
+// session's scope allows
+func (cs *ConnectedSession) scopedProcessInfos(hostID string, infos []protocol.ProcessInfo) []protocol.ProcessInfo {
+	if _, scope := cs.Role(); scope.Unlimited() {
+		return infos
+	}
+	return slices.DeleteFunc(slices.Clone(infos), func(info protocol.ProcessInfo) bool {
+		return !cs.inScope(hostID, info.ID)
+	})
+}
+
+// scopedStaleProcesses returns the stale processes on hostID that the
+// session's scope allows. Stale AgentAPI servers name no process, so a
+// session limited to one process doesn't see them.
+func (cs *ConnectedSession) scopedStaleProcesses(hostID string, stale []protocol.StaleProcess) []protocol.StaleProcess {
+	if _, scope := cs.Role(); scope.Unlimited() {
+		return stale
+	}
+	return slices.DeleteFunc(slices.Clone(stale), func(p protocol.StaleProcess) bool {
+		processID := ""
+		if p.ProcessID != nil {
+			processID = *p.ProcessID
+		}
+		return !cs.inScope(hostID, processID)
+	})
+}
+
+// sendScopedHostStatus sends a host's status with its processes, stale
+// processes and unmanaged sessions cut to what the session's scope allows
+func (cs *ConnectedSession) sendScopedHostStatus(status protocol.HostStatusPayload) error {
+	if !cs.inScope(status.HostID, "") {
+		if _, scope := cs.Role(); !scope.Allows(status.HostID, "") {
+			return nil
+		}
+		status.UnmanagedSessions = nil
+	}
+	status.Processes = cs.scopedProcessInfos(status.HostID, status.Processes)
+	if status.StaleProcesses != nil {
+		stale := cs.scopedStaleProcesses(status.HostID, *status.StaleProcesses)
+		status.StaleProcesses = nil
+		if len(stale) > 0 {
+			status.StaleProcesses = &stale
+		}
+	}
+	msg, err := protocol.NewMessage(protocol.TypeHostStatus, status)
+	if err != nil {
+		return err
+	}
+	return cs.Send(msg)
+}
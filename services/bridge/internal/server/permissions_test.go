@@ -0,0 +1,280 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/session"
+)
+
+func TestMessageRolesCoverHandlers(t *testing.T) {
+	s := newQuietServer(t)
+	for msgType := range s.handlers {
+		if _, ok := messageRoles[msgType]; !ok {
+			t.Errorf("handler for %s has no entry in messageRoles", msgType)
+		}
+	}
+	for msgType := range messageRoles {
+		if _, ok := s.handlers[msgType]; !ok {
+			t.Errorf("messageRoles has %s, which has no handler", msgType)
+		}
+	}
+}
+
+// runAs runs a request through the dispatcher on cs and returns the error
+// code it was refused with, or "" if it reached the handler
+func runAs(t *testing.T, s *Server, cs *ConnectedSession, msgType, payload string) protocol.ErrorCode {
+	t.Helper()
+	var code protocol.ErrorCode
+	sink := &ConnectedSession{Session: cs.Session, server: s, sink: func(msg *protocol.Message) error {
+		if msg.Type == protocol.TypeError {
+			var errPayload protocol.ErrorPayload
+			json.Unmarshal(msg.Payload, &errPayload)
+			code = errPayload.Code
+		}
+		return nil
+	}}
+	reached := false
+	handler := func(*ConnectedSession, *protocol.Message) error {
+		reached = true
+		return nil
+	}
+	d := &dispatcher{server: s, connSession: sink}
+	d.run(handler, &protocol.Message{Type: msgType, Payload: []byte(payload)})
+	if reached == (code != "") {
+		t.Fatalf("%s: reached=%v, code=%q", msgType, reached, code)
+	}
+	return code
+}
+
+func TestObserverPermissions(t *testing.T) {
+	s := newQuietServer(t)
+	sess := s.sessionManager.CreateSession(nil)
+	cs := &ConnectedSession{Session: sess, server: s}
+	proc := `{"hostId":"host-1","processId":"proc-1","data":"ls\n"}`
+
+	// Not authenticated
+	sess.SetRole(session.RoleNone, session.Scope{}, "")
+	if code := runAs(t, s, cs, protocol.TypeChatHistory, proc); code != protocol.ErrorForbidden {
+		t.Errorf("unauthenticated chat_history = %q, want FORBIDDEN", code)
+	}
+	if code := runAs(t, s, cs, protocol.TypeAuth, `{}`); code != "" {
+		t.Errorf("unauthenticated auth = %q, want allowed", code)
+	}
+
+	sess.SetRole(session.RoleObserver, session.Scope{}, "")
+	for _, msgType := range []string{protocol.TypePtyHistoryRequest, protocol.TypeChatHistory, protocol.TypeProcessSelect, protocol.TypeEnvList} {
+		if code := runAs(t, s, cs, msgType, proc); code != "" {
+			t.Errorf("observer %s = %q, want allowed", msgType, code)
+		}
+	}
+	for _, msgType := range []string{protocol.TypePtyInput, protocol.TypeProcessKill, protocol.TypeEnvReveal,
+		protocol.TypeSnippetDelete, protocol.TypeHostDisconnect, protocol.TypeHostDiagnostics, protocol.TypeSessionInviteCreate} {
+		if code := runAs(t, s, cs, msgType, proc); code != protocol.ErrorForbidden {
+			t.Errorf("observer %s = %q, want FORBIDDEN", msgType, code)
+		}
+	}
+
+	sess.SetRole(session.RoleOwner, session.Scope{}, "")
+	if code := runAs(t, s, cs, protocol.TypePtyInput, proc); code != "" {
+		t.Errorf("owner pty_input = %q, want allowed", code)
+	}
+}
+
+func TestScopedPermissions(t *testing.T) {
+	s := newQuietServer(t)
+	s.processRegistry.Register(&process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeShell})
+	s.processRegistry.Register(&process.Process{ID: "proc-2", HostID: "host-2", Type: process.TypeShell})
+	sess := s.sessionManager.CreateSession(nil)
+	cs := &ConnectedSession{Session: sess, server: s}
+	sess.SetRole(session.RoleObserver, session.Scope{HostID: "host-1"}, "")
+
+	tests := []struct {
+		msgType, payload string
+		allowed          bool
+	}{
+		{protocol.TypeProcessSelect, `{"processId":"proc-1"}`, true},
+		{protocol.TypeProcessSelect, `{"processId":"proc-2"}`, false}, // On another host
+		{protocol.TypeProcessList, `{"hostId":"host-1"}`, true},
+		{protocol.TypeProcessList, `{"hostId":"host-2"}`, false},
+		{protocol.TypeSnippetList, `{}`, false}, // Names no host
+		{protocol.TypeBridgeInfo, `{}`, true},
+	}
+	for _, tt := range tests {
+		code := runAs(t, s, cs, tt.msgType, tt.payload)
+		if (code == "") != tt.allowed {
+			t.Errorf("%s %s = %q, want allowed=%v", tt.msgType, tt.payload, code, tt.allowed)
+		}
+	}
+}
+
+func TestSessionInviteLifecycle(t *testing.T) {
+	config := DefaultConfig()
+	config.AuthToken = "secret"
+	config.CWDRefreshInterval = 0
+	config.TmuxProbeInterval = 0
+	s := newTestServer(t, config)
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	t.Cleanup(ts.Close)
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	dial := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	auth := func(conn *websocket.Conn, payload protocol.AuthPayload) protocol.AuthResultPayload {
+		payload.ProtocolVersion = &protocolVersion
+		msg, _ := protocol.NewMessage(protocol.TypeAuth, payload)
+		conn.WriteJSON(msg)
+		var result protocol.AuthResultPayload
+		readPayload(t, conn, protocol.TypeAuthResult, &result)
+		return result
+	}
+	send := func(conn *websocket.Conn, msgType string, payload interface{}) {
+		msg, _ := protocol.NewMessage(msgType, payload)
+		if err := conn.WriteJSON(msg); err != nil {
+			t.Fatalf("WriteJSON: %v", err)
+		}
+	}
+
+	owner := dial()
+	// Requests before auth are refused
+	send(owner, protocol.TypeSnippetList, struct{}{})
+	var errPayload protocol.ErrorPayload
+	readPayload(t, owner, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotAuthenticated {
+		t.Fatalf("unauthenticated snippet_list = %+v, want NOT_AUTHENTICATED", errPayload)
+	}
+
+	token := "secret"
+	if result := auth(owner, protocol.AuthPayload{Token: &token}); !result.Success || result.Role != "owner" {
+		t.Fatalf("owner auth = %+v", result)
+	}
+
+	hostID := "host-1"
+	send(owner, protocol.TypeSessionInviteCreate, protocol.SessionInviteCreatePayload{HostID: &hostID})
+	var created protocol.SessionInviteCreateResultPayload
+	readPayload(t, owner, protocol.TypeSessionInviteCreateResult, &created)
+	if !created.Success || created.Token == nil || created.Invite.Role != "observer" {
+		t.Fatalf("invite create = %+v", created)
+	}
+
+	observer := dial()
+	result := auth(observer, protocol.AuthPayload{InviteToken: created.Token})
+	if !result.Success || result.Role != "observer" || result.Scope == nil || *result.Scope.HostID != hostID {
+		t.Fatalf("observer auth = %+v", result)
+	}
+	send(observer, protocol.TypePtyInput, protocol.PtyInputPayload{ProcessID: "proc-1", Data: "x"})
+	readPayload(t, observer, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorForbidden {
+		t.Fatalf("observer pty_input = %+v, want FORBIDDEN", errPayload)
+	}
+
+	send(owner, protocol.TypeSessionInviteRevoke, protocol.SessionInviteRevokePayload{ID: created.Invite.ID})
+	var revoked protocol.SessionInviteRevokeResultPayload
+	readPayload(t, owner, protocol.TypeSessionInviteRevokeResult, &revoked)
+	if !revoked.Success || revoked.Disconnected != 1 {
+		t.Fatalf("invite revoke = %+v", revoked)
+	}
+	observer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := observer.ReadMessage(); err == nil {
+		t.Fatal("observer still connected after its invite was revoked")
+	}
+
+	if result := auth(dial(), protocol.AuthPayload{InviteToken: created.Token}); result.Success {
+		t.Fatalf("auth with a revoked invite = %+v", result)
+	}
+}
+
+func TestPtyOutputReachesObservers(t *testing.T) {
+	s := newQuietServer(t)
+	_, owner := connectTestClient(t, s)
+	watching, observer := connectTestClient(t, s)
+	proc := &process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeShell}
+
+	observer.SetRole(session.RoleObserver, session.Scope{}, "")
+	observer.SelectProcess("host-1", "proc-1")
+	s.forwardPtyOutput(owner, proc, []byte("hello"))
+
+	var output protocol.PtyOutputPayload
+	readPayload(t, watching, protocol.TypePtyOutput, &output)
+	if output.ProcessID != "proc-1" || output.Data != "hello" {
+		t.Errorf("observer got %+v", output)
+	}
+}
+
+func TestScopedSessionsSeeOnlyTheirProcess(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	for _, id := range []string{"proc-1", "proc-2"} {
+		s.processRegistry.Register(&process.Process{ID: id, HostID: "host-1", Type: process.TypeShell})
+	}
+	processID := "proc-2"
+	s.processRegistry.MergeStaleProcesses("host-1", []protocol.StaleProcess{{Reason: "detached", ProcessID: &processID}})
+	cs.SetRole(session.RoleObserver, session.Scope{HostID: "host-1", ProcessID: "proc-1"}, "")
+	cs.SubscribeProcesses("host-1")
+
+	dispatch(t, s, cs, protocol.TypeProcessList, protocol.ProcessListPayload{HostID: "host-1", ListLight: true})
+	var list protocol.ProcessListResultPayload
+	readPayload(t, conn, protocol.TypeProcessListResult, &list)
+	if len(list.Processes) != 1 || list.Processes[0].ID != "proc-1" {
+		t.Errorf("process list = %+v, want only proc-1", list.Processes)
+	}
+
+	if err := s.sendHostStatus(cs, "host-1"); err != nil {
+		t.Fatalf("sendHostStatus: %v", err)
+	}
+	var status protocol.HostStatusPayload
+	readPayload(t, conn, protocol.TypeHostStatus, &status)
+	if len(status.Processes) != 1 || status.Processes[0].ID != "proc-1" || status.StaleProcesses != nil {
+		t.Errorf("host status = %+v, want only proc-1", status)
+	}
+
+	// Pushes about other processes, and the host's stale list, pass it by
+	for _, id := range []string{"proc-2", "proc-1"} {
+		s.notifyProcessUpdated(nil, s.processRegistry.Get(id))
+	}
+	s.publishStaleProcessesChanged("host-1", nil)
+	var update protocol.ProcessUpdatedPayload
+	readPayload(t, conn, protocol.TypeProcessUpdated, &update)
+	if update.ID != "proc-1" {
+		t.Errorf("pushed update of %s", update.ID)
+	}
+	var changed protocol.StaleProcessesChangedPayload
+	readPayload(t, conn, protocol.TypeStaleProcessesChanged, &changed)
+	if len(changed.StaleProcesses) != 0 {
+		t.Errorf("pushed stale processes %+v", changed.StaleProcesses)
+	}
+	expectNothingQueued(t, conn, cs)
+}
+
+func TestInviteForUnknownProcessNeedsHost(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+
+	processID := "proc-gone"
+	dispatch(t, s, cs, protocol.TypeSessionInviteCreate, protocol.SessionInviteCreatePayload{ProcessID: &processID})
+	var result protocol.SessionInviteCreateResultPayload
+	readPayload(t, conn, protocol.TypeSessionInviteCreateResult, &result)
+	if result.Success || result.Error == nil {
+		t.Errorf("invite for an unknown process without a host = %+v", result)
+	}
+
+	hostID := "host-1"
+	dispatch(t, s, cs, protocol.TypeSessionInviteCreate, protocol.SessionInviteCreatePayload{HostID: &hostID, ProcessID: &processID})
+	readPayload(t, conn, protocol.TypeSessionInviteCreateResult, &result)
+	if !result.Success || result.Invite.HostID == nil || *result.Invite.HostID != hostID {
+		t.Errorf("invite with the host given = %+v", result)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// portProbeTimeout bounds the probe of one reserved port
+const portProbeTimeout = 3 * time.Second
+
+// probeReservedPort reports whether something listens on a port of a host,
+// by opening a tunnel to it. It can't tell when the host isn't connected or
+// the tunnel neither opens nor is refused in time.
+func (s *Server) probeReservedPort(hostID string, port int) (listening, known bool) {
+	conn := s.sshManager.GetConnection(hostID)
+	if conn == nil {
+		return false, false
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	// The timeout includes waiting for one of the host's operation slots
+	ctx, cancel := context.WithTimeout(context.Background(), portProbeTimeout)
+	defer cancel()
+	done := make(chan dialResult, 1)
+	go func() {
+		c, err := conn.DialContext(ctx, "tcp", fmt.Sprintf("localhost:%d", port))
+		done <- dialResult{c, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err == nil {
+			r.conn.Close()
+			return true, true
+		}
+		// The host refused the connection: nothing is bound to the port
+		var open *gossh.OpenChannelError
+		if errors.As(r.err, &open) && open.Reason == gossh.ConnectionFailed {
+			return false, true
+		}
+		return false, false
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return false, false
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"log"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// checkPortRange records the configured AgentAPI port range. When it differs
+// from the range the bridge last ran with, it warns about every process
+// recorded on a port outside the new range and returns them: those ports
+// are no longer scanned, so their AgentAPI servers won't be found again.
+func (s *Server) checkPortRange() []storage.ProcessMetadata {
+	current := s.config.PortRange.String()
+	previous, ok, err := s.storage.GetSetting(storage.SettingClaudePortRange)
+	if err != nil {
+		log.Printf("[WARN] [PORTS] Failed to read the previous port range: %v", err)
+		return nil
+	}
+
+	var outside []storage.ProcessMetadata
+	if ok && previous != current {
+		log.Printf("[WARN] [PORTS] AgentAPI port range changed from %s to %s", previous, current)
+		outside, err = s.storage.GetProcessMetadataOutsidePorts(s.config.PortRange.Min, s.config.PortRange.Max)
+		if err != nil {
+			log.Printf("[WARN] [PORTS] Failed to check processes against the new port range: %v", err)
+		}
+		for _, meta := range outside {
+			log.Printf("[WARN] [PORTS] Process %s on host %s is recorded on port %d, outside %s; its AgentAPI server won't be scanned",
+				meta.ProcessID, meta.HostID, meta.Port, current)
+		}
+	}
+
+	if !ok || previous != current {
+		if err := s.storage.SetSetting(storage.SettingClaudePortRange, current); err != nil {
+			log.Printf("[WARN] [PORTS] Failed to save the port range: %v", err)
+		}
+	}
+	log.Printf("[INFO] [PORTS] AgentAPI port range: %s (%d ports)", current, s.config.PortRange.Size())
+	return outside
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+func TestInvalidPortRangeRejected(t *testing.T) {
+	config := DefaultConfig()
+	config.PortRange = process.PortRange{Min: 40000, Max: 41000}
+	if _, err := New("127.0.0.1:0", t.TempDir(), config); err == nil {
+		t.Fatal("New accepted a range of 1001 ports")
+	}
+}
+
+func TestPortRangeInBridgeInfo(t *testing.T) {
+	config := DefaultConfig()
+	config.PortRange = process.PortRange{Min: 40000, Max: 40511}
+	s := newTestServer(t, config)
+	conn, cs := connectTestClient(t, s)
+
+	if got := s.processRegistry.PortRange(); got != config.PortRange {
+		t.Errorf("registry range = %s, want %s", got, config.PortRange)
+	}
+	dispatch(t, s, cs, protocol.TypeBridgeInfo, protocol.BridgeInfoPayload{})
+	var info protocol.BridgeInfoResultPayload
+	readPayload(t, conn, protocol.TypeBridgeInfoResult, &info)
+	if info.PortRange != (protocol.PortRange{Min: 40000, Max: 40511}) {
+		t.Errorf("bridge_info portRange = %+v", info.PortRange)
+	}
+}
+
+func TestPortRangeChangeWarnsAboutRecordedProcesses(t *testing.T) {
+	dataDir := t.TempDir()
+	s, err := New("127.0.0.1:0", dataDir, DefaultConfig())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for _, meta := range []storage.ProcessMetadata{
+		{ProcessID: "proc-in", HostID: "host-1", ProcessType: "claude", Port: 3299, TmuxName: "rc-proc-in"},
+		{ProcessID: "proc-out", HostID: "host-1", ProcessType: "claude", Port: 3284, TmuxName: "rc-proc-out"},
+	} {
+		if err := s.storage.SaveProcessMetadata(meta); err != nil {
+			t.Fatalf("SaveProcessMetadata: %v", err)
+		}
+	}
+
+	// Restarting with the same range has nothing to warn about
+	if outside := s.checkPortRange(); outside != nil {
+		t.Errorf("unchanged range reported %+v", outside)
+	}
+
+	// A narrower range leaves proc-out behind, once
+	s.config.PortRange = process.PortRange{Min: 3290, Max: 3305}
+	outside := s.checkPortRange()
+	if len(outside) != 1 || outside[0].ProcessID != "proc-out" || outside[0].Port != 3284 {
+		t.Errorf("outside = %+v, want proc-out on 3284", outside)
+	}
+	if outside := s.checkPortRange(); outside != nil {
+		t.Errorf("range already recorded, still reported %+v", outside)
+	}
+	s.Stop()
+
+	// The next start records its own range
+	config := DefaultConfig()
+	config.PortRange = process.PortRange{Min: 40000, Max: 40015}
+	s, err = New("127.0.0.1:0", dataDir, config)
+	if err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	defer s.Stop()
+	if value, _, _ := s.storage.GetSetting(storage.SettingClaudePortRange); value != "40000-40015" {
+		t.Errorf("recorded range = %q, want 40000-40015", value)
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// Startup Preflight
+// ============================================================================
+//
+// New checks the data directory before opening the database, so a bridge
+// started on a read-only or full volume, or next to another bridge, stops
+// with a message saying what to fix instead of failing partway through.
+
+// instanceLockFileName is the lock file a bridge holds in its profile's
+// directory while it runs. Two bridges on one database would hand out the
+// same ports and overwrite each other's state.
+const instanceLockFileName = "bridge.lock"
+
+// errLockHeld is returned by lockFile when another process holds the lock
+var errLockHeld = errors.New("lock held by another process")
+
+// checkDataDir checks that files can be created in dir and that its volume
+// has at least minFree bytes free (0 skips that check)
+func checkDataDir(dir string, minFree int64) error {
+	probe, err := os.CreateTemp(dir, ".preflight-*")
+	if err != nil {
+		return fmt.Errorf("data directory %s is not writable: %w; mount a writable volume there, fix its owner and permissions, or choose another with --data-dir", dir, err)
+	}
+	probe.Close()
+	if err := os.Remove(probe.Name()); err != nil {
+		return fmt.Errorf("data directory %s does not let files be removed: %w; fix its owner and permissions, or choose another with --data-dir", dir, err)
+	}
+
+	if minFree <= 0 {
+		return nil
+	}
+	free, err := freeSpace(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("[WARN] [PREFLIGHT] Failed to check free space in %s: %v", dir, err)
+		return nil
+	}
+	if free < uint64(minFree) {
+		return fmt.Errorf("only %d MB free in %s, below the %d MB minimum; free up space or lower --min-free-space", free>>20, dir, minFree>>20)
+	}
+	return nil
+}
+
+// checkDatabase checks that the database, if it exists, can be written
+func checkDatabase(dbPath string) error {
+	f, err := os.OpenFile(dbPath, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("database %s is not writable: %w; fix its owner and permissions, or check the volume isn't mounted read-only", dbPath, err)
+	}
+	return f.Close()
+}
+
+// instanceLock is the lock a running bridge holds on its profile's data
+type instanceLock struct {
+	file *os.File
+}
+
+// lockInstance takes the instance lock of a profile's directory. It fails if
+// another bridge holds it. A lock left by a bridge that exited without
+// releasing it, e.g. one that crashed, is released by the OS and taken over.
+func lockInstance(dir string) (*instanceLock, error) {
+	path := filepath.Join(dir, instanceLockFileName)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open instance lock %s: %w", path, err)
+	}
+
+	if err := lockFile(file); errors.Is(err, errLockHeld) {
+		file.Close()
+		if pid := lockHolder(path); pid != 0 {
+			return nil, fmt.Errorf("another bridge (pid %d) is using %s; stop it, or give this one its own --data-dir or --profile", pid, dir)
+		}
+		return nil, fmt.Errorf("another bridge is using %s; stop it, or give this one its own --data-dir or --profile", dir)
+	} else if err != nil {
+		// Some network filesystems have no locks; run without one
+		log.Printf("[WARN] [PREFLIGHT] Failed to lock %s, not checking for other bridges: %v", path, err)
+		file.Close()
+		return &instanceLock{}, nil
+	}
+
+	if pid := lockHolder(path); pid != 0 && pid != os.Getpid() {
+		log.Printf("[WARN] [PREFLIGHT] Bridge pid %d exited without releasing %s; taking it over", pid, path)
+	}
+	if err := file.Truncate(0); err == nil {
+		_, err = file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		log.Printf("[WARN] [PREFLIGHT] Failed to record pid in %s: %v", path, err)
+	}
+	return &instanceLock{file: file}, nil
+}
+
+// lockHolder returns the pid recorded in a lock file, or 0
+func lockHolder(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, 32))
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+// Release releases the lock. The pid is cleared first, so the next bridge
+// doesn't take this one for crashed.
+func (l *instanceLock) Release() {
+	if l == nil || l.file == nil {
+		return
+	}
+	if err := l.file.Truncate(0); err != nil {
+		log.Printf("[WARN] [PREFLIGHT] Failed to clear pid in %s: %v", l.file.Name(), err)
+	}
+	l.file.Close() // Closing the file releases the lock
+	l.file = nil
+}
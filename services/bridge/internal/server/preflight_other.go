@@ -0,0 +1,19 @@
+//go:build !linux && !darwin
+
+package server
+
+import (
+	"errors"
+	"os"
+)
+
+// freeSpace is not checked on this platform
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// lockFile is not supported on this platform; lockInstance runs without
+// a lock
+func lockFile(f *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInstanceLock(t *testing.T) {
+	dataDir := t.TempDir()
+	first, err := New("127.0.0.1:0", dataDir, DefaultConfig())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	// A second bridge on the same profile is turned away, naming the first
+	_, err = New("127.0.0.1:0", dataDir, DefaultConfig())
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("another bridge (pid %d)", os.Getpid())) {
+		t.Fatalf("second New = %v, want the lock held", err)
+	}
+
+	// Another profile has its own database, and its own lock
+	config := DefaultConfig()
+	config.Profile = "work"
+	other, err := New("127.0.0.1:0", dataDir, config)
+	if err != nil {
+		t.Fatalf("New with another profile: %v", err)
+	}
+	other.Stop()
+
+	// Stopping releases the lock, without leaving a pid behind
+	first.Stop()
+	if pid := lockHolder(filepath.Join(dataDir, instanceLockFileName)); pid != 0 {
+		t.Errorf("pid %d left in the lock after Stop", pid)
+	}
+	s, err := New("127.0.0.1:0", dataDir, DefaultConfig())
+	if err != nil {
+		t.Fatalf("New after Stop: %v", err)
+	}
+	s.Stop()
+}
+
+func TestInstanceLockStale(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, instanceLockFileName)
+
+	// A bridge that crashed left its pid, but the OS released its lock
+	if err := os.WriteFile(path, []byte("4194303\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := lockInstance(dir)
+	if err != nil {
+		t.Fatalf("lockInstance over a stale lock: %v", err)
+	}
+	defer lock.Release()
+	if pid := lockHolder(path); pid != os.Getpid() {
+		t.Errorf("lock holder = %d, want %d", pid, os.Getpid())
+	}
+}
+
+func TestPreflightReadOnlyDataDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions don't apply to root")
+	}
+	dataDir := t.TempDir()
+	if err := os.Chmod(dataDir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dataDir, 0700) })
+
+	_, err := New("127.0.0.1:0", dataDir, DefaultConfig())
+	if err == nil || !strings.Contains(err.Error(), "is not writable") || !strings.Contains(err.Error(), "--data-dir") {
+		t.Errorf("New on a read-only data dir = %v", err)
+	}
+
+	// A database that can't be written is caught before it is opened
+	if err := os.Chmod(dataDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(dataDir, dbFileName)
+	if err := os.WriteFile(dbPath, nil, 0400); err != nil {
+		t.Fatal(err)
+	}
+	_, err = New("127.0.0.1:0", dataDir, DefaultConfig())
+	if err == nil || !strings.Contains(err.Error(), "database "+dbPath+" is not writable") {
+		t.Errorf("New with a read-only database = %v", err)
+	}
+
+	// Neither left the lock held
+	lock, err := lockInstance(dataDir)
+	if err != nil {
+		t.Fatalf("lock after failed starts: %v", err)
+	}
+	lock.Release()
+}
+
+func TestPreflightFreeSpace(t *testing.T) {
+	config := DefaultConfig()
+	config.MinFreeSpace = 1 << 62
+	_, err := New("127.0.0.1:0", t.TempDir(), config)
+	if err == nil || !strings.Contains(err.Error(), "--min-free-space") {
+		t.Errorf("New below the free space minimum = %v", err)
+	}
+}
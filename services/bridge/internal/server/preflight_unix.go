@@ -0,0 +1,29 @@
+//go:build linux || darwin
+
+package server
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// freeSpace returns the bytes available to the bridge on dir's volume
+func freeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// lockFile takes an exclusive flock on f without waiting. The lock goes with
+// the file's descriptor, so it ends when the file is closed or the process
+// exits, however it exits.
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errLockHeld
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+)
+
+// ============================================================================
+// Process Alerts (tmux bell and activity)
+// ============================================================================
+
+// alertLimiter allows at most one alert per process every interval
+type alertLimiter struct {
+	interval time.Duration
+	last     map[string]time.Time // processID -> when its last alert was sent
+	mu       sync.Mutex
+}
+
+func newAlertLimiter(interval time.Duration) *alertLimiter {
+	return &alertLimiter{interval: interval, last: make(map[string]time.Time)}
+}
+
+// allow reports whether an alert for the process may be sent at now, and if
+// so records it as sent
+func (l *alertLimiter) allow(processID string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if last, ok := l.last[processID]; ok && now.Sub(last) < l.interval {
+		return false
+	}
+	l.last[processID] = now
+	return true
+}
+
+// forget drops the record of a process that went away
+func (l *alertLimiter) forget(processID string) {
+	l.mu.Lock()
+	delete(l.last, processID)
+	l.mu.Unlock()
+}
+
+// alertKind returns the alert a pane's flags call for, if any. A bell is
+// reported over activity, since the output that rang it is activity too.
+func alertKind(pane pty.PaneInfo) (protocol.ProcessAlertKind, bool) {
+	switch {
+	case pane.Bell:
+		return protocol.ProcessAlertBell, true
+	case pane.Activity:
+		return protocol.ProcessAlertActivity, true
+	}
+	return "", false
+}
+
+// forwardAlerts pushes process_alert to the host's process subscribers for
+// processes the bridge isn't attached to whose window flagged a bell or
+// activity, then clears the flags of the ones it pushed. A rate-limited
+// alert keeps its flags, so it's pushed once the interval has passed.
+func (s *Server) forwardAlerts(hostID string, procs []*process.Process, panes map[string]pty.PaneInfo) {
+	now := time.Now()
+	var notified []*pty.Session
+	for _, proc := range procs {
+		pane, ok := panes[proc.ID]
+		if !ok || proc.PTY.IsAttached() {
+			continue
+		}
+		kind, ok := alertKind(pane)
+		if !ok || !s.alertLimiter.allow(proc.ID, now) {
+			continue
+		}
+
+		msg, err := protocol.NewMessage(protocol.TypeProcessAlert, protocol.ProcessAlertPayload{
+			ProcessID: proc.ID,
+			HostID:    hostID,
+			Kind:      kind,
+			Timestamp: now.Format(time.RFC3339),
+		})
+		if err != nil {
+			log.Printf("[ERROR] [ALERT] Failed to create alert message: %v", err)
+			continue
+		}
+		log.Printf("[DEBUG] [ALERT] %s in process %s on host %s", kind, proc.ID, hostID)
+		s.publishProcessMessage(hostID, proc.ID, msg, nil)
+		notified = append(notified, proc.PTY)
+	}
+
+	for i, err := range pty.ClearAlerts(notified) {
+		if err != nil {
+			log.Printf("[WARN] [ALERT] Failed to clear alerts for process %s: %v", notified[i].ID, err)
+		}
+	}
+}
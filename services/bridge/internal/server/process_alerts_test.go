@@ -0,0 +1,110 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+func TestAlertLimiter(t *testing.T) {
+	l := newAlertLimiter(30 * time.Second)
+	start := time.Unix(1700000000, 0)
+
+	if !l.allow("proc-1", start) {
+		t.Fatal("first alert was limited")
+	}
+	if l.allow("proc-1", start.Add(29*time.Second)) {
+		t.Error("second alert within the interval was allowed")
+	}
+	if !l.allow("proc-2", start.Add(time.Second)) {
+		t.Error("another process's alert was limited")
+	}
+	// The limited alert didn't restart the interval
+	if !l.allow("proc-1", start.Add(30*time.Second)) {
+		t.Error("alert after the interval was limited")
+	}
+
+	l.forget("proc-2")
+	if !l.allow("proc-2", start.Add(2*time.Second)) {
+		t.Error("alert for a forgotten process was limited")
+	}
+}
+
+// raiseAlert makes the fake tmux report a bell or activity alert for a session
+func raiseAlert(t *testing.T, tmuxName, kind string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(os.Getenv("FAKE_TMUX_ALERTS"), tmuxName+"."+kind), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func alertRaised(tmuxName, kind string) bool {
+	_, err := os.Stat(filepath.Join(os.Getenv("FAKE_TMUX_ALERTS"), tmuxName+"."+kind))
+	return err == nil
+}
+
+func TestAlertsPushedToSubscribers(t *testing.T) {
+	t.Setenv("FAKE_TMUX_ALERTS", t.TempDir())
+	s := newQuietServer(t)
+	watcher, watcherCS := connectTestClient(t, s)
+	sessions := shellTestHost(t, s)
+	registerShells(t, s, 2)
+	dispatch(t, s, watcherCS, protocol.TypeProcessesSubscribe, protocol.ProcessesSubscribePayload{HostID: "host-1"})
+	readPayload(t, watcher, protocol.TypeProcessListResult, &protocol.ProcessListResultPayload{})
+
+	// The first refresh pushes the new CWDs; nothing has alerted yet
+	s.refreshCWDs()
+	for i := 0; i < 2; i++ {
+		readPayload(t, watcher, protocol.TypeProcessCWDChanged, &protocol.ProcessCWDChangedPayload{})
+	}
+	expectNothingQueued(t, watcher, watcherCS)
+
+	// A bell wins over the activity that came with it
+	raiseAlert(t, "rc-proc-0", "bell")
+	raiseAlert(t, "rc-proc-0", "activity")
+	raiseAlert(t, "rc-proc-1", "activity")
+	before := atomic.LoadInt32(sessions)
+	s.refreshCWDs()
+	kinds := make(map[string]protocol.ProcessAlertKind)
+	for i := 0; i < 2; i++ {
+		var alert protocol.ProcessAlertPayload
+		readPayload(t, watcher, protocol.TypeProcessAlert, &alert)
+		if alert.HostID != "host-1" || alert.Timestamp == "" {
+			t.Errorf("alert = %+v", alert)
+		}
+		kinds[alert.ProcessID] = alert.Kind
+	}
+	if kinds["proc-0"] != protocol.ProcessAlertBell || kinds["proc-1"] != protocol.ProcessAlertActivity {
+		t.Errorf("alerts = %v, want a bell for proc-0 and activity for proc-1", kinds)
+	}
+	// One batch to read the panes and one to clear both sessions' alerts
+	if got := atomic.LoadInt32(sessions) - before; got != 2 {
+		t.Errorf("refresh with alerts opened %d SSH sessions, want 2", got)
+	}
+	if alertRaised("rc-proc-0", "bell") || alertRaised("rc-proc-1", "activity") {
+		t.Error("alerts weren't cleared after the push")
+	}
+
+	// Another bell within the interval is held, not cleared
+	raiseAlert(t, "rc-proc-0", "bell")
+	s.refreshCWDs()
+	expectNothingQueued(t, watcher, watcherCS)
+	if !alertRaised("rc-proc-0", "bell") {
+		t.Fatal("a rate-limited alert was cleared")
+	}
+
+	// and pushed once the interval has passed
+	s.alertLimiter.mu.Lock()
+	s.alertLimiter.last["proc-0"] = time.Now().Add(-s.config.AlertInterval)
+	s.alertLimiter.mu.Unlock()
+	s.refreshCWDs()
+	var alert protocol.ProcessAlertPayload
+	readPayload(t, watcher, protocol.TypeProcessAlert, &alert)
+	if alert.ProcessID != "proc-0" || alert.Kind != protocol.ProcessAlertBell {
+		t.Errorf("held alert = %+v, want proc-0's bell", alert)
+	}
+}
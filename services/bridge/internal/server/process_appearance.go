@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// handleProcessSetAppearance sets the color and icon a process is shown
+// with, and whether its PTY history is exempt from the history cap. They are
+// stored with the process metadata, so reattaching keeps them. The payload's
+// validation has already checked the color and icon.
+func (s *Server) handleProcessSetAppearance(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ProcessSetAppearancePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	proc := s.processRegistry.Get(payload.ProcessID)
+	if proc == nil {
+		return connSession.sendProcessNotFound(payload.ProcessID)
+	}
+
+	color, icon := proc.Appearance()
+	if payload.Color != nil {
+		color = *payload.Color
+	}
+	if payload.Icon != nil {
+		icon = *payload.Icon
+	}
+	if err := s.storage.SetProcessAppearance(payload.ProcessID, storage.Appearance{Color: color, Icon: icon}); err != nil {
+		log.Printf("[ERROR] [PROCESS] Failed to save appearance of process %s: %v", payload.ProcessID, err)
+		return connSession.SendErrorDetails(protocol.ErrorStorageError, protocol.ErrorDetails{"processId": payload.ProcessID, "reason": err.Error()})
+	}
+	proc.SetAppearance(color, icon)
+	log.Printf("[DEBUG] [PROCESS] Set appearance of process %s: color %q, icon %q", payload.ProcessID, color, icon)
+
+	if payload.RetainFullHistory != nil {
+		if err := s.storage.SetProcessRetainFullHistory(payload.ProcessID, *payload.RetainFullHistory); err != nil {
+			log.Printf("[ERROR] [PROCESS] Failed to save history retention of process %s: %v", payload.ProcessID, err)
+			return connSession.SendErrorDetails(protocol.ErrorStorageError, protocol.ErrorDetails{"processId": payload.ProcessID, "reason": err.Error()})
+		}
+		proc.SetRetainFullHistory(*payload.RetainFullHistory)
+	}
+
+	return s.notifyProcessUpdated(connSession, proc)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+func TestProcessSetAppearance(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	watcher, watcherCS := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 1)
+	if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{ProcessID: "proc-0", HostID: "host-1", ProcessType: "shell",
+		TmuxName: "rc-proc-0", StartedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	dispatch(t, s, watcherCS, protocol.TypeProcessesSubscribe, protocol.ProcessesSubscribePayload{HostID: "host-1"})
+	readPayload(t, watcher, protocol.TypeProcessListResult, nil)
+
+	color, icon := "#1e90ff", "rocket"
+	dispatch(t, s, cs, protocol.TypeProcessSetAppearance, protocol.ProcessSetAppearancePayload{ProcessID: "proc-0", Color: &color, Icon: &icon})
+	for _, c := range []*websocket.Conn{conn, watcher} {
+		var updated protocol.ProcessUpdatedPayload
+		readPayload(t, c, protocol.TypeProcessUpdated, &updated)
+		if updated.Color != color || updated.Icon != icon {
+			t.Errorf("process_updated = %+v", updated)
+		}
+	}
+
+	// An omitted field is kept and "" clears one
+	none := ""
+	dispatch(t, s, cs, protocol.TypeProcessSetAppearance, protocol.ProcessSetAppearancePayload{ProcessID: "proc-0", Icon: &none})
+	var updated protocol.ProcessUpdatedPayload
+	readPayload(t, conn, protocol.TypeProcessUpdated, &updated)
+	readPayload(t, watcher, protocol.TypeProcessUpdated, nil)
+	if updated.Color != color || updated.Icon != "" {
+		t.Errorf("after clearing the icon: %+v", updated)
+	}
+	if meta, err := s.storage.GetProcessMetadata("proc-0"); err != nil || meta.Appearance != (storage.Appearance{Color: color}) {
+		t.Errorf("stored %+v, %v", meta, err)
+	}
+
+	dispatch(t, s, cs, protocol.TypeProcessSetAppearance, protocol.ProcessSetAppearancePayload{ProcessID: "proc-9", Color: &color})
+	var errPayload protocol.ErrorPayload
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotFound {
+		t.Errorf("unknown process: %s", errPayload.Code)
+	}
+}
+
+func TestProcessSetAppearanceValidation(t *testing.T) {
+	s := newQuietServer(t)
+	conn, _ := connectTestClient(t, s)
+
+	color, icon := "#12345", "unicorn"
+	msg, _ := protocol.NewMessage(protocol.TypeProcessSetAppearance, protocol.ProcessSetAppearancePayload{ProcessID: "proc-0", Color: &color, Icon: &icon})
+	conn.WriteJSON(msg)
+	var errPayload struct {
+		Code    protocol.ErrorCode              `json:"code"`
+		Details protocol.ValidationErrorDetails `json:"details"`
+	}
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorValidation || len(errPayload.Details.Fields) != 2 ||
+		errPayload.Details.Fields[0].Field != "color" || errPayload.Details.Fields[1].Field != "icon" {
+		t.Errorf("error = %+v", errPayload)
+	}
+}
+
+func TestReattachKeepsAppearance(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+
+	const processID = "00000000-0000-4000-8000-000000000000"
+	tmuxName := pty.TmuxSessionName(processID)
+	if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{ProcessID: processID, HostID: "host-1", ProcessType: "shell",
+		TmuxName: tmuxName, ShellPID: fakeTmuxPID, StartedAt: time.Unix(fakeTmuxCreated, 0).Add(2 * time.Second)}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	appearance := storage.Appearance{Color: "teal", Icon: "database"}
+	if err := s.storage.SetProcessAppearance(processID, appearance); err != nil {
+		t.Fatalf("SetProcessAppearance: %v", err)
+	}
+
+	dispatch(t, s, cs, protocol.TypeProcessReattach, protocol.ProcessReattachPayload{HostID: "host-1", TmuxSession: tmuxName, ProcessID: processID})
+	var added protocol.ProcessAddedPayload
+	readPayload(t, conn, protocol.TypeProcessAdded, &added)
+	if added.Process.Color != appearance.Color || added.Process.Icon != appearance.Icon {
+		t.Errorf("reattached process = %+v", added.Process)
+	}
+}
+
+func TestHostConfigAppearance(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+
+	dispatch(t, s, cs, protocol.TypeHostConfigCreate, protocol.HostConfigCreatePayload{
+		Name: "db", Host: "db.example", Port: 22, Username: "me", AuthType: "agent", Color: "green", Icon: "database",
+	})
+	var created protocol.HostConfigCreateResultPayload
+	readPayload(t, conn, protocol.TypeHostConfigCreateResult, &created)
+	if !created.Success || created.Host.Color != "green" || created.Host.Icon != "database" {
+		t.Fatalf("create result = %+v", created)
+	}
+
+	// An omitted field is kept and "" clears one
+	color, none := "#abc", ""
+	dispatch(t, s, cs, protocol.TypeHostConfigUpdate, protocol.HostConfigUpdatePayload{ID: created.Host.ID, Color: &color, Icon: &none})
+	var updated protocol.HostConfigUpdateResultPayload
+	readPayload(t, conn, protocol.TypeHostConfigUpdateResult, &updated)
+	if !updated.Success || updated.Host.Color != color || updated.Host.Icon != "" {
+		t.Fatalf("update result = %+v", updated)
+	}
+	name := "database"
+	dispatch(t, s, cs, protocol.TypeHostConfigUpdate, protocol.HostConfigUpdatePayload{ID: created.Host.ID, Name: &name})
+	readPayload(t, conn, protocol.TypeHostConfigUpdateResult, nil)
+
+	dispatch(t, s, cs, protocol.TypeHostConfigList, protocol.HostConfigListPayload{})
+	var list protocol.HostConfigListResultPayload
+	readPayload(t, conn, protocol.TypeHostConfigListResult, &list)
+	if len(list.Hosts) != 1 || list.Hosts[0].Color != color || list.Hosts[0].Icon != "" {
+		t.Errorf("list = %+v", list.Hosts)
+	}
+}
+
+func TestProcessRetainFullHistory(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 2)
+	for _, id := range []string{"proc-0", "proc-1"} {
+		if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{ProcessID: id, HostID: "host-1", ProcessType: "shell",
+			TmuxName: "rc-" + id, StartedAt: time.Now()}); err != nil {
+			t.Fatalf("SaveProcessMetadata: %v", err)
+		}
+		if err := s.storage.AppendPtyOutput(id, "host-1", []byte("0123456789")); err != nil {
+			t.Fatalf("AppendPtyOutput: %v", err)
+		}
+		if err := s.storage.AppendPtyOutput(id, "host-1", []byte("abcdefghij")); err != nil {
+			t.Fatalf("AppendPtyOutput: %v", err)
+		}
+	}
+
+	color, retain := "teal", true
+	dispatch(t, s, cs, protocol.TypeProcessSetAppearance, protocol.ProcessSetAppearancePayload{ProcessID: "proc-0", Color: &color, RetainFullHistory: &retain})
+	var updated protocol.ProcessUpdatedPayload
+	readPayload(t, conn, protocol.TypeProcessUpdated, &updated)
+	if !updated.RetainFullHistory || updated.Color != color {
+		t.Errorf("process_updated = %+v", updated)
+	}
+
+	// Changing the appearance alone keeps the flag
+	dispatch(t, s, cs, protocol.TypeProcessSetAppearance, protocol.ProcessSetAppearancePayload{ProcessID: "proc-0", Color: &color})
+	readPayload(t, conn, protocol.TypeProcessUpdated, &updated)
+	if !updated.RetainFullHistory {
+		t.Errorf("process_updated without the flag = %+v", updated)
+	}
+
+	s.storage.SetPtyHistoryMaxBytes(10)
+	if _, err := s.storage.PrunePtyHistory(); err != nil {
+		t.Fatalf("PrunePtyHistory: %v", err)
+	}
+
+	dispatch(t, s, cs, protocol.TypeProcessList, protocol.ProcessListPayload{HostID: "host-1", ListLight: true, IncludeStats: true})
+	var list protocol.ProcessListResultPayload
+	readPayload(t, conn, protocol.TypeProcessListResult, &list)
+	want := map[string]int64{"proc-0": 20, "proc-1": 10}
+	for _, info := range list.Processes {
+		if info.HistorySize == nil || *info.HistorySize != want[info.ID] || info.RetainFullHistory != (info.ID == "proc-0") {
+			t.Errorf("%s: retain %v, history size %v, want %d", info.ID, info.RetainFullHistory, info.HistorySize, want[info.ID])
+		}
+	}
+
+	if meta, err := s.storage.GetProcessMetadata("proc-0"); err != nil || !meta.RetainFullHistory {
+		t.Errorf("stored %+v, %v", meta, err)
+	}
+}
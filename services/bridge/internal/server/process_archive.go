@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// ============================================================================
+// Archived Processes
+// ============================================================================
+//
+// process_kill keeps a process's history unless told not to: the storage
+// archives it (see storage.ArchiveProcess) and the history handlers keep
+// reading it by process ID. These handlers browse and delete archives; the
+// storage deletes those older than Config.ArchiveMaxAge itself.
+
+// toArchivedProcess converts an archived process's metadata for the client
+func (s *Server) toArchivedProcess(meta *storage.ProcessMetadata) protocol.ArchivedProcess {
+	archived := protocol.ArchivedProcess{
+		ID:             meta.ProcessID,
+		HostID:         meta.HostID,
+		Type:           protocol.ProcessType(meta.ProcessType),
+		CWD:            meta.CWD,
+		StartedAt:      meta.StartedAt.Format(time.RFC3339),
+		ArchivedAt:     meta.ArchivedAt.Format(time.RFC3339),
+		PtyHistorySize: s.storage.GetPtyHistorySize(meta.ProcessID),
+	}
+	if meta.Name != "" {
+		archived.Name = strPtr(meta.Name)
+	}
+	if count, err := s.storage.GetChatMessageCount(meta.ProcessID); err != nil {
+		log.Printf("[WARN] [ARCHIVE] Failed to count chat messages of process %s: %v", meta.ProcessID, err)
+	} else {
+		archived.ChatMessageCount = count
+	}
+	return archived
+}
+
+// handleArchivedProcessList sends the archived processes of a host, or of
+// every host, that the session's scope covers
+func (s *Server) handleArchivedProcessList(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ArchivedProcessListPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [ARCHIVE] List request: hostId=%q", payload.HostID)
+
+	result := protocol.ArchivedProcessListResultPayload{Processes: []protocol.ArchivedProcess{}}
+	if s.storage == nil {
+		result.Code = protocol.ErrorStorageError
+		result.Error = strPtr("processes are not archived without storage")
+	} else if metas, err := s.storage.ListArchivedProcesses(payload.HostID); err != nil {
+		log.Printf("[WARN] [ARCHIVE] List failed: %v", err)
+		result.Code = protocol.ErrorStorageError
+		result.Error = strPtr(err.Error())
+	} else {
+		_, scope := connSession.Role()
+		for i := range metas {
+			if scope.Allows(metas[i].HostID, metas[i].ProcessID) {
+				result.Processes = append(result.Processes, s.toArchivedProcess(&metas[i]))
+			}
+		}
+	}
+
+	response, err := protocol.NewMessage(protocol.TypeArchivedProcessListResult, result)
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
+
+// handleArchivedProcessGet sends one archived process
+func (s *Server) handleArchivedProcessGet(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ArchivedProcessGetPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [ARCHIVE] Get request: processId=%s", payload.ProcessID)
+
+	if s.storage == nil {
+		return connSession.sendProcessNotFound(payload.ProcessID)
+	}
+	meta, err := s.storage.GetArchivedProcess(payload.ProcessID)
+	if err != nil {
+		log.Printf("[ERROR] [ARCHIVE] Failed to get archived process %s: %v", payload.ProcessID, err)
+		return connSession.SendErrorDetails(protocol.ErrorStorageError, protocol.ErrorDetails{"processId": payload.ProcessID, "reason": err.Error()})
+	}
+	if meta == nil {
+		return connSession.sendProcessNotFound(payload.ProcessID)
+	}
+
+	response, err := protocol.NewMessage(protocol.TypeArchivedProcessGetResult, protocol.ArchivedProcessGetResultPayload{
+		Process: s.toArchivedProcess(meta),
+	})
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
+
+// handleArchivedProcessDelete deletes an archived process with its history.
+// A live process is not touched; it is killed with process_kill.
+func (s *Server) handleArchivedProcessDelete(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ArchivedProcessDeletePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [ARCHIVE] Delete request: processId=%s", payload.ProcessID)
+
+	result := protocol.ArchivedProcessDeleteResultPayload{}
+	if s.storage == nil {
+		result.Code = protocol.ErrorStorageError
+		result.Error = strPtr("processes are not archived without storage")
+	} else if deleted, err := s.storage.DeleteArchivedProcess(payload.ProcessID); err != nil {
+		log.Printf("[ERROR] [ARCHIVE] Failed to delete archived process %s: %v", payload.ProcessID, err)
+		result.Code = protocol.ErrorStorageError
+		result.Error = strPtr(err.Error())
+	} else if !deleted {
+		result.Code = protocol.ErrorNotFound
+		result.Error = strPtr("archived process not found")
+	} else {
+		log.Printf("[INFO] [ARCHIVE] Deleted archived process %s", payload.ProcessID)
+		result.Success = true
+		result.ID = strPtr(payload.ProcessID)
+	}
+
+	response, err := protocol.NewMessage(protocol.TypeArchivedProcessDeleteResult, result)
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/session"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// registerArchivable registers a Claude process with metadata, PTY output
+// and a chat message stored for it
+func registerArchivable(t *testing.T, s *Server, processID string) {
+	t.Helper()
+	registerTestClaude(t, s, processID)
+	if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{ProcessID: processID, HostID: "host-1", ProcessType: "claude",
+		CWD: "/src", Name: "auth fixes", StartedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	s.storage.RegisterProcess(processID, "host-1")
+	if err := s.storage.AppendPtyOutput(processID, "host-1", []byte("$ make\n")); err != nil {
+		t.Fatalf("AppendPtyOutput: %v", err)
+	}
+	if _, err := s.storage.UpsertChatMessage(processID, "host-1", storage.ChatMessage{MessageID: 1, Role: "user", Message: "fix the tests"}); err != nil {
+		t.Fatalf("UpsertChatMessage: %v", err)
+	}
+}
+
+func TestProcessKillArchives(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	registerArchivable(t, s, "proc-1")
+
+	var killed protocol.ProcessKilledPayload
+	dispatch(t, s, cs, protocol.TypeProcessKill, protocol.ProcessKillPayload{ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypeProcessKilled, &killed)
+	if !killed.Archived || s.processRegistry.Get("proc-1") != nil {
+		t.Fatalf("killed = %+v, registered = %v", killed, s.processRegistry.Get("proc-1") != nil)
+	}
+	if metas, _ := s.storage.GetProcessMetadataByHost("host-1"); len(metas) != 0 {
+		t.Errorf("archived process still listed on its host: %+v", metas)
+	}
+
+	// Its history is read back by ID
+	var history protocol.PtyHistoryResponsePayload
+	dispatch(t, s, cs, protocol.TypePtyHistoryRequest, protocol.PtyHistoryRequestPayload{ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypePtyHistoryResponse, &history)
+	if history.TotalSize != int64(len("$ make\n")) {
+		t.Errorf("archived PTY history size = %d", history.TotalSize)
+	}
+	readPayload(t, conn, protocol.TypePtyHistoryChunk, nil)
+	readPayload(t, conn, protocol.TypePtyHistoryComplete, nil)
+	var chat protocol.ChatMessagesPayload
+	dispatch(t, s, cs, protocol.TypeChatHistory, protocol.ChatHistoryPayload{HostID: "host-1", ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypeChatMessages, &chat)
+	if len(chat.Messages) != 1 || chat.Messages[0].Message != "fix the tests" {
+		t.Errorf("archived chat history = %+v", chat.Messages)
+	}
+
+	var got protocol.ArchivedProcessGetResultPayload
+	dispatch(t, s, cs, protocol.TypeArchivedProcessGet, protocol.ArchivedProcessGetPayload{ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypeArchivedProcessGetResult, &got)
+	if p := got.Process; p.ID != "proc-1" || p.HostID != "host-1" || p.Type != protocol.ProcessTypeClaude || p.Name == nil || *p.Name != "auth fixes" ||
+		p.CWD != "/src" || p.ArchivedAt == "" || p.PtyHistorySize != int64(len("$ make\n")) || p.ChatMessageCount != 1 {
+		t.Errorf("archived process = %+v", p)
+	}
+}
+
+func TestProcessKillWithoutHistory(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	registerArchivable(t, s, "proc-1")
+
+	keepHistory := false
+	var killed protocol.ProcessKilledPayload
+	dispatch(t, s, cs, protocol.TypeProcessKill, protocol.ProcessKillPayload{ProcessID: "proc-1", KeepHistory: &keepHistory})
+	readPayload(t, conn, protocol.TypeProcessKilled, &killed)
+	if killed.Archived {
+		t.Error("killed with keepHistory false, but archived")
+	}
+	if meta, _ := s.storage.GetProcessMetadata("proc-1"); meta != nil {
+		t.Errorf("metadata = %+v after kill", meta)
+	}
+	if size := s.storage.GetPtyHistorySize("proc-1"); size != 0 {
+		t.Errorf("PTY history size = %d after kill", size)
+	}
+
+	var errPayload protocol.ErrorPayload
+	dispatch(t, s, cs, protocol.TypeArchivedProcessGet, protocol.ArchivedProcessGetPayload{ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotFound {
+		t.Errorf("get of a purged process = %+v", errPayload)
+	}
+}
+
+func TestArchivedProcessListAndDelete(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	registerArchivable(t, s, "proc-1")
+	registerArchivable(t, s, "proc-2")
+	dispatch(t, s, cs, protocol.TypeProcessKill, protocol.ProcessKillPayload{ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypeProcessKilled, nil)
+
+	var list protocol.ArchivedProcessListResultPayload
+	dispatch(t, s, cs, protocol.TypeArchivedProcessList, protocol.ArchivedProcessListPayload{HostID: "host-1"})
+	readPayload(t, conn, protocol.TypeArchivedProcessListResult, &list)
+	if len(list.Processes) != 1 || list.Processes[0].ID != "proc-1" || list.Error != nil {
+		t.Fatalf("archived processes = %+v, error %v", list.Processes, list.Error)
+	}
+
+	// A live process isn't archived, and can't be deleted as one
+	var errPayload protocol.ErrorPayload
+	dispatch(t, s, cs, protocol.TypeArchivedProcessGet, protocol.ArchivedProcessGetPayload{ProcessID: "proc-2"})
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotFound {
+		t.Errorf("get of a live process = %+v", errPayload)
+	}
+	var deleted protocol.ArchivedProcessDeleteResultPayload
+	dispatch(t, s, cs, protocol.TypeArchivedProcessDelete, protocol.ArchivedProcessDeletePayload{ProcessID: "proc-2"})
+	readPayload(t, conn, protocol.TypeArchivedProcessDeleteResult, &deleted)
+	if deleted.Success || s.storage.GetPtyHistorySize("proc-2") == 0 {
+		t.Errorf("delete of a live process = %+v", deleted)
+	}
+
+	deleted = protocol.ArchivedProcessDeleteResultPayload{}
+	dispatch(t, s, cs, protocol.TypeArchivedProcessDelete, protocol.ArchivedProcessDeletePayload{ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypeArchivedProcessDeleteResult, &deleted)
+	if !deleted.Success || deleted.ID == nil || *deleted.ID != "proc-1" {
+		t.Errorf("delete = %+v", deleted)
+	}
+	if size := s.storage.GetPtyHistorySize("proc-1"); size != 0 {
+		t.Errorf("PTY history size = %d after delete", size)
+	}
+	list = protocol.ArchivedProcessListResultPayload{}
+	dispatch(t, s, cs, protocol.TypeArchivedProcessList, protocol.ArchivedProcessListPayload{})
+	readPayload(t, conn, protocol.TypeArchivedProcessListResult, &list)
+	if len(list.Processes) != 0 {
+		t.Errorf("archived processes after delete = %+v", list.Processes)
+	}
+}
+
+func TestArchivedProcessScope(t *testing.T) {
+	s := newQuietServer(t)
+	for _, p := range []struct{ id, host string }{{"proc-1", "host-1"}, {"proc-2", "host-2"}} {
+		if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{ProcessID: p.id, HostID: p.host, ProcessType: "shell", StartedAt: time.Now()}); err != nil {
+			t.Fatalf("SaveProcessMetadata: %v", err)
+		}
+		if _, err := s.storage.ArchiveProcess(p.id); err != nil {
+			t.Fatalf("ArchiveProcess: %v", err)
+		}
+	}
+	s.processRegistry.Register(&process.Process{ID: "proc-3", HostID: "host-1", Type: process.TypeShell})
+	sess := s.sessionManager.CreateSession(nil)
+	cs := &ConnectedSession{Session: sess, server: s}
+	sess.SetRole(session.RoleObserver, session.Scope{HostID: "host-1"}, "")
+
+	// An archived process is checked against its stored host
+	tests := []struct {
+		msgType, payload string
+		allowed          bool
+	}{
+		{protocol.TypeArchivedProcessGet, `{"processId":"proc-1"}`, true},
+		{protocol.TypeArchivedProcessGet, `{"processId":"proc-2"}`, false},
+		{protocol.TypeChatHistory, `{"processId":"proc-2"}`, false},
+		{protocol.TypeArchivedProcessList, `{"hostId":"host-1"}`, true},
+		{protocol.TypeArchivedProcessList, `{}`, false},
+	}
+	for _, tt := range tests {
+		code := runAs(t, s, cs, tt.msgType, tt.payload)
+		if (code == "") != tt.allowed {
+			t.Errorf("%s %s = %q, want allowed=%v", tt.msgType, tt.payload, code, tt.allowed)
+		}
+	}
+}
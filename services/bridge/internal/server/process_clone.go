@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/env"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// sessionEnvKeys are captured env vars that describe the source shell's own
+// session rather than the user's environment. A clone gets fresh values.
+var sessionEnvKeys = map[string]bool{
+	"TMUX":      true,
+	"TMUX_PANE": true,
+	"TERM":      true,
+	"PWD":       true,
+	"OLDPWD":    true,
+	"SHLVL":     true,
+	"_":         true,
+	"COLUMNS":   true,
+	"LINES":     true,
+}
+
+// cloneSource is what a clone takes from its source process
+type cloneSource struct {
+	live       bool // In the registry, rather than known only from metadata
+	hostID     string
+	tmuxName   string // Set for detached sources
+	cwd        string
+	env        []process.EnvVar
+	cols, rows int // 0 when unknown
+}
+
+// cloneEnv returns the env vars a clone inherits from captured ones
+func cloneEnv(vars []process.EnvVar) []env.EnvVar {
+	var inherited []env.EnvVar
+	for _, v := range vars {
+		if v.Key == "" || sessionEnvKeys[v.Key] {
+			continue
+		}
+		inherited = append(inherited, env.EnvVar{Key: v.Key, Value: v.Value})
+	}
+	return inherited
+}
+
+// handleProcessClone creates a shell process that starts where another one
+// is: same host, same working directory, same captured env vars, and same
+// workspace. Detached sources are cloned from their stored metadata.
+func (s *Server) handleProcessClone(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ProcessClonePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [PROCESS] Clone request: sourceProcessId=%s", payload.SourceProcessID)
+
+	source, err := s.resolveCloneSource(payload.SourceProcessID)
+	if err != nil {
+		log.Printf("[ERROR] [PROCESS] Failed to load clone source %s: %v", payload.SourceProcessID, err)
+		return connSession.SendErrorDetails(protocol.ErrorStorageError, protocol.ErrorDetails{"reason": err.Error()})
+	}
+	if source == nil {
+		return connSession.sendProcessNotFound(payload.SourceProcessID)
+	}
+
+	sshConn := s.sshManager.GetConnection(source.hostID)
+	if sshConn == nil {
+		return connSession.sendHostNotConnected(source.hostID)
+	}
+
+	// A detached source's directory is read from its tmux pane if the
+	// session is still there
+	if !source.live {
+		source.cwd = s.detachedCWD(payload.SourceProcessID, source, sshConn)
+	}
+
+	ptyConfig := pty.DefaultSessionConfig()
+	if source.cols > 0 && source.rows > 0 {
+		ptyConfig.Cols, ptyConfig.Rows = source.cols, source.rows
+	}
+	if payload.Cols != nil {
+		ptyConfig.Cols = *payload.Cols
+	}
+	if payload.Rows != nil {
+		ptyConfig.Rows = *payload.Rows
+	}
+	ptyConfig.InitialCWD = source.cwd
+	inherited := cloneEnv(source.env)
+
+	// The source's env already reflects its startup hooks, and its cwd may
+	// be one they would cd away from
+	proc, _, err := s.startShellProcess(connSession, source.hostID, sshConn, ptyConfig, inherited, nil)
+	if err != nil {
+		log.Printf("[ERROR] [PROCESS] Failed to create PTY session for clone of %s: %v", payload.SourceProcessID, err)
+		return connSession.SendErrorDetails(protocol.ErrorPtyError,
+			protocol.ErrorDetails{"hostId": source.hostID, "sourceProcessId": payload.SourceProcessID, "reason": err.Error()})
+	}
+
+	s.copyWorkspace(payload.SourceProcessID, proc)
+
+	log.Printf("[INFO] [PROCESS] Cloned process %s as %s (cwd=%q, %d env vars)",
+		payload.SourceProcessID, proc.ID, source.cwd, len(inherited))
+
+	created := processCreated(proc)
+	created.ClonedFrom = strPtr(payload.SourceProcessID)
+	response, err := protocol.NewMessage(protocol.TypeProcessCreated, created)
+	if err != nil {
+		return err
+	}
+	s.publishProcessMessage(source.hostID, proc.ID, response, connSession)
+
+	return connSession.Send(response)
+}
+
+// resolveCloneSource reads what a clone needs from a live process, falling
+// back to stored metadata. It returns nil if the process is unknown.
+func (s *Server) resolveCloneSource(processID string) (*cloneSource, error) {
+	var source *cloneSource
+	if proc := s.processRegistry.Get(processID); proc != nil {
+		proc.RefreshCWD()
+		source = &cloneSource{live: true, hostID: proc.HostID, cwd: proc.GetCWD(), env: proc.GetEnvVars()}
+		if proc.PTY != nil {
+			source.cols, source.rows = proc.PTY.GetDimensions()
+		}
+	}
+	if s.storage == nil || (source != nil && len(source.env) > 0) {
+		return source, nil
+	}
+
+	meta, err := s.storage.GetProcessMetadata(processID)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return source, nil
+	}
+	if source == nil {
+		source = &cloneSource{hostID: meta.HostID, tmuxName: meta.TmuxName, cwd: meta.CWD}
+	}
+	// The live process may not have finished capturing its env yet
+	if len(source.env) == 0 {
+		source.env = make([]process.EnvVar, len(meta.EnvVars))
+		for i, v := range meta.EnvVars {
+			source.env[i] = process.EnvVar{Key: v.Key, Value: v.Value}
+		}
+	}
+	return source, nil
+}
+
+// detachedCWD returns the working directory of a detached process's tmux
+// pane, read through its host's tmux, or the stored one if the pane can't be
+// read
+func (s *Server) detachedCWD(processID string, source *cloneSource, sshConn *ssh.Connection) string {
+	tmuxName := source.tmuxName
+	if tmuxName == "" {
+		tmuxName = pty.TmuxSessionName(processID)
+	}
+	pane, err := pty.QueryPaneInfo(sshConn.Client, s.hostTmux(source.hostID), tmuxName)
+	if err != nil || pane.CWD == "" {
+		log.Printf("[DEBUG] [PROCESS] Using stored CWD for detached process %s: %v", processID, err)
+		return source.cwd
+	}
+	return pane.CWD
+}
+
+// copyWorkspace puts a clone in its source's workspace, if it has one
+func (s *Server) copyWorkspace(sourceID string, clone *process.Process) {
+	if s.storage == nil {
+		return
+	}
+	workspaceID, err := s.storage.GetProcessWorkspace(sourceID)
+	if err != nil {
+		log.Printf("[WARN] [PROCESS] Failed to read workspace of process %s: %v", sourceID, err)
+		return
+	}
+	if workspaceID == "" {
+		return
+	}
+	if err := s.storage.SetProcessWorkspace(clone.ID, workspaceID); err != nil {
+		log.Printf("[WARN] [PROCESS] Failed to copy workspace to clone %s: %v", clone.ID, err)
+		return
+	}
+	clone.SetWorkspaceID(workspaceID)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// newSessionArgs returns the arguments the fake tmux got for a new session
+func newSessionArgs(t *testing.T, processID string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(os.Getenv("FAKE_TMUX_NEW"), pty.TmuxSessionName(processID)))
+	if err != nil {
+		t.Fatalf("tmux new-session not run for %s: %v", processID, err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// flagValues returns the values given for a command line flag
+func flagValues(args []string, flag string) []string {
+	var values []string
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == flag {
+			values = append(values, args[i+1])
+		}
+	}
+	return values
+}
+
+// expectEnvInjected checks that a new process's env went to a private file,
+// holding the export lines want, which the shell was first typed a command
+// to source; and that no value was on tmux's command line or among the
+// typed keys
+func expectEnvInjected(t *testing.T, keys func() string, home, processID string, want []string, values ...string) {
+	t.Helper()
+	typed := typedLines(t, keys, processID)
+	if len(typed) < 2 || !strings.HasPrefix(typed[0], " . ~/.remote-claude/tmp/"+processID+"/env-inject") {
+		t.Errorf("typed %q, want the env file sourced first", typed)
+	}
+	data, err := os.ReadFile(filepath.Join(home, ".remote-claude", "tmp", processID, "env-inject"))
+	if err != nil {
+		t.Fatalf("env file of %s: %v", processID, err)
+	}
+	if got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); !slices.Equal(got, want) {
+		t.Errorf("env file of %s = %q, want %q", processID, got, want)
+	}
+	args := newSessionArgs(t, processID)
+	if got := flagValues(args, "-e"); got != nil {
+		t.Errorf("new-session -e %q", got)
+	}
+	for _, value := range values {
+		if slices.ContainsFunc(args, func(arg string) bool { return strings.Contains(arg, value) }) || strings.Contains(keys(), value) {
+			t.Errorf("%q on a command line", value)
+		}
+	}
+}
+
+func TestProcessCloneLive(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("FAKE_TMUX_CWD", t.TempDir())
+	t.Setenv("FAKE_TMUX_NEW", t.TempDir())
+	keys := recordTmuxKeys(t)
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 1)
+
+	source := s.processRegistry.Get("proc-0")
+	source.EnvVars = []process.EnvVar{
+		{Key: "API_URL", Value: "http://localhost:8080"},
+		{Key: "GREETING", Value: "it's here"},
+		{Key: "TMUX", Value: "/tmp/tmux-1000/default,42,0"},
+		{Key: "PWD", Value: "/cached"},
+	}
+	source.PTY.Cols, source.PTY.Rows = 132, 40
+	if err := s.storage.CreateWorkspace(storage.Workspace{ID: "ws-1", Name: "api"}); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	if err := s.storage.SetProcessWorkspace("proc-0", "ws-1"); err != nil {
+		t.Fatalf("SetProcessWorkspace: %v", err)
+	}
+
+	// The shell has moved since its CWD was last cached
+	setPaneCWD(t, "rc-proc-0", "/work/repo")
+	dispatch(t, s, cs, protocol.TypeProcessClone, protocol.ProcessClonePayload{SourceProcessID: "proc-0", Rows: intPtr(50)})
+
+	var created protocol.ProcessCreatedPayload
+	readPayload(t, conn, protocol.TypeProcessCreated, &created)
+	if created.ClonedFrom == nil || *created.ClonedFrom != "proc-0" {
+		t.Errorf("clonedFrom = %v, want proc-0", created.ClonedFrom)
+	}
+	clone := created.Process
+	if clone.ID == "proc-0" || clone.HostID != "host-1" || clone.Type != protocol.ProcessTypeShell || clone.CWD != "/work/repo" {
+		t.Errorf("clone = %+v", clone)
+	}
+	if clone.WorkspaceID == nil || *clone.WorkspaceID != "ws-1" {
+		t.Errorf("clone workspace = %v, want ws-1", clone.WorkspaceID)
+	}
+	if ws, _ := s.storage.GetProcessWorkspace(clone.ID); ws != "ws-1" {
+		t.Errorf("stored clone workspace = %q, want ws-1", ws)
+	}
+
+	args := newSessionArgs(t, clone.ID)
+	if got := flagValues(args, "-c"); !slices.Equal(got, []string{"/work/repo"}) {
+		t.Errorf("-c %q, want the refreshed CWD", got)
+	}
+	// The source's env without session vars, exported in the shell
+	expectEnvInjected(t, keys, home, clone.ID,
+		[]string{"export API_URL=http://localhost:8080", `export GREETING='it'\''s here'`},
+		"http://localhost:8080", "it's here")
+	if cols, rows := flagValues(args, "-x"), flagValues(args, "-y"); !slices.Equal(cols, []string{"132"}) || !slices.Equal(rows, []string{"50"}) {
+		t.Errorf("size = %q x %q, want the source's width and the requested height", cols, rows)
+	}
+}
+
+func TestProcessCloneDetached(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("FAKE_TMUX_CWD", t.TempDir())
+	t.Setenv("FAKE_TMUX_NEW", t.TempDir())
+	keys := recordTmuxKeys(t)
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+
+	// "gone" has no tmux session left, so its stored CWD is used
+	for _, id := range []string{"detached", "gone"} {
+		if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{
+			ProcessID: id, HostID: "host-1", ProcessType: "shell", TmuxName: pty.TmuxSessionName(id),
+			CWD: "/stored/" + id, StartedAt: time.Now(),
+			EnvVars: []storage.EnvVar{{Key: "PROJECT", Value: id}, {Key: "SHLVL", Value: "2"}},
+		}); err != nil {
+			t.Fatalf("SaveProcessMetadata: %v", err)
+		}
+	}
+	setPaneCWD(t, "rc-detached", "/work/live")
+
+	for id, wantCWD := range map[string]string{"detached": "/work/live", "gone": "/stored/gone"} {
+		dispatch(t, s, cs, protocol.TypeProcessClone, protocol.ProcessClonePayload{SourceProcessID: id})
+		var created protocol.ProcessCreatedPayload
+		readPayload(t, conn, protocol.TypeProcessCreated, &created)
+		if created.ClonedFrom == nil || *created.ClonedFrom != id || created.Process.CWD != wantCWD {
+			t.Errorf("clone of %s: clonedFrom = %v, cwd = %q; want %s", id, created.ClonedFrom, created.Process.CWD, wantCWD)
+		}
+		args := newSessionArgs(t, created.Process.ID)
+		expectEnvInjected(t, keys, home, created.Process.ID, []string{"export PROJECT=" + id})
+		if got := flagValues(args, "-x"); !slices.Equal(got, []string{"80"}) {
+			t.Errorf("clone of %s: -x %q, want the default width", id, got)
+		}
+	}
+}
+
+func TestProcessCloneUnknownSource(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+
+	dispatch(t, s, cs, protocol.TypeProcessClone, protocol.ProcessClonePayload{SourceProcessID: "nope"})
+	var errPayload protocol.ErrorPayload
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotFound {
+		t.Errorf("code = %s, want %s", errPayload.Code, protocol.ErrorNotFound)
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/env"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// envCaptureRetryDelay is how long after a failed spawn capture it is tried
+// once more, e.g. for a shell whose RC files take longer than the capture
+// waits; a variable so tests can shorten it
+var envCaptureRetryDelay = 15 * time.Second
+
+// envRefreshInterval is how often a process's current env may be captured,
+// as each capture types into its pane
+var envRefreshInterval = 10 * time.Second
+
+// injectSpawnEnv exports the env a new shell process starts with, such as
+// a clone's, in its shell once the shell is up. It is never put on the tmux
+// command line, where other users on the host could read it (see
+// env.Manager.InjectProcessEnv). Like startup hooks, this is best-effort: a
+// failure is logged and the process starts without the vars.
+func (s *Server) injectSpawnEnv(proc *process.Process, vars []env.EnvVar) {
+	if len(vars) == 0 {
+		return
+	}
+	sshConn := s.sshManager.GetConnection(proc.HostID)
+	if sshConn == nil {
+		log.Printf("[WARN] [PROCESS] Host %s disconnected before the env of process %s was exported", proc.HostID, proc.ID)
+		return
+	}
+	tmux := proc.PTY.Tmux()
+	if err := s.envManager.WaitForShell(sshConn.Client, tmux, proc.PTY.TmuxName); err != nil {
+		// Keys typed now may still reach the shell once its startup is done
+		log.Printf("[WARN] [PROCESS] Exporting env of process %s before its shell is ready: %v", proc.ID, err)
+	}
+	if err := s.envManager.InjectProcessEnv(sshConn.Client, tmux, proc.ID, proc.PTY.TmuxName, vars); err != nil {
+		log.Printf("[WARN] [PROCESS] Failed to export %d env vars in process %s: %v", len(vars), proc.ID, err)
+	}
+}
+
+// captureSpawnEnv captures a new shell process's environment once the shell
+// has started, and stores it with the process. If that fails it is tried
+// once more later, rather than leaving the process without env vars.
+func (s *Server) captureSpawnEnv(connSession *ConnectedSession, proc *process.Process) {
+	envVars, err := s.tryCaptureSpawnEnv(connSession, proc)
+	if err != nil {
+		log.Printf("[WARN] [PROCESS] Failed to capture env vars for process %s, retrying in %s: %v", proc.ID, envCaptureRetryDelay, err)
+		time.Sleep(envCaptureRetryDelay)
+		if s.processRegistry.Get(proc.ID) != proc {
+			return
+		}
+		if envVars, err = s.tryCaptureSpawnEnv(connSession, proc); err != nil {
+			log.Printf("[WARN] [PROCESS] Failed to capture env vars for process %s: %v", proc.ID, err)
+			return
+		}
+	}
+
+	// Convert env.EnvVar to process.EnvVar and store in process
+	procEnvVars := make([]process.EnvVar, len(envVars))
+	for i, v := range envVars {
+		procEnvVars[i] = process.EnvVar{Key: v.Key, Value: v.Value}
+	}
+	proc.SetEnvVars(procEnvVars)
+	log.Printf("[DEBUG] [PROCESS] Captured %d env vars for process %s", len(procEnvVars), proc.ID)
+
+	// Persist env vars to storage for reconnect survival
+	if s.storage != nil {
+		storageEnvVars := make([]storage.EnvVar, len(envVars))
+		for i, v := range envVars {
+			storageEnvVars[i] = storage.EnvVar{Key: v.Key, Value: v.Value}
+		}
+		if err := s.storage.UpdateProcessEnvVars(proc.ID, storageEnvVars); err != nil {
+			log.Printf("[WARN] [PROCESS] Failed to persist env vars for process %s: %v", proc.ID, err)
+		}
+	}
+}
+
+// tryCaptureSpawnEnv waits for the process's shell to start and captures
+// its environment. The capture command is typed into the pane, so output is
+// kept from clients meanwhile, and the session is then sent the screen the
+// command left (cleared, at the prompt).
+func (s *Server) tryCaptureSpawnEnv(connSession *ConnectedSession, proc *process.Process) ([]env.EnvVar, error) {
+	sshConn := s.sshManager.GetConnection(proc.HostID)
+	if sshConn == nil {
+		return nil, fmt.Errorf("host %s is not connected", proc.HostID)
+	}
+	tmux := proc.PTY.Tmux()
+	if err := s.envManager.WaitForShell(sshConn.Client, tmux, proc.PTY.TmuxName); err != nil {
+		return nil, err
+	}
+
+	proc.PTY.MuteOutput(true)
+	defer func() {
+		proc.PTY.MuteOutput(false)
+		if err := s.sendPtySnapshot(connSession, proc); err != nil {
+			log.Printf("[WARN] [PTY] Failed to repaint process %s after env capture: %v", proc.ID, err)
+		}
+	}()
+	return s.envManager.CaptureProcessEnvAtSpawn(sshConn.Client, tmux, proc.ID, proc.PTY.TmuxName)
+}
+
+// envRefreshLimitError is returned for a process whose env was captured
+// less than envRefreshInterval ago
+type envRefreshLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *envRefreshLimitError) Error() string {
+	return fmt.Sprintf("env captured less than %s ago; retry in %s", envRefreshInterval, e.RetryAfter.Round(time.Second))
+}
+
+// envRefreshes rate-limits current env captures per process
+type envRefreshes struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// start records a capture of processID, or returns an *envRefreshLimitError
+// if one was started too recently. A failed capture counts too.
+func (r *envRefreshes) start(processID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if last, ok := r.last[processID]; ok {
+		if wait := last.Add(envRefreshInterval).Sub(now); wait > 0 {
+			return &envRefreshLimitError{RetryAfter: wait}
+		}
+	}
+	if r.last == nil {
+		r.last = make(map[string]time.Time)
+	}
+	r.last[processID] = now
+	return nil
+}
+
+// sendCurrentProcessEnv captures a process's env from its live pane and
+// sends it, compared against the spawn snapshot in diff mode
+func (s *Server) sendCurrentProcessEnv(connSession *ConnectedSession, proc *process.Process, mode protocol.ProcessEnvMode) error {
+	result := protocol.ProcessEnvResultPayload{ProcessID: proc.ID, Mode: mode, Vars: []protocol.EnvVar{}}
+
+	current, err := s.captureCurrentEnv(connSession, proc)
+	var limited *envRefreshLimitError
+	switch {
+	case errors.As(err, &limited):
+		retryAfter := limited.RetryAfter.Milliseconds()
+		result.RetryAfterMs = &retryAfter
+		result.Code = protocol.ErrorExecLimit
+		result.Error = strPtr(err.Error())
+	case err != nil:
+		result.Code = failureCode(err, protocol.ErrorExecFailed)
+		result.Error = strPtr(err.Error())
+	default:
+		for _, v := range current {
+			result.Vars = append(result.Vars, s.toProtocolEnvVar(v.Key, v.Value))
+		}
+		if mode == protocol.ProcessEnvModeDiff {
+			spawnVars := proc.GetEnvVars()
+			spawn := make([]env.EnvVar, len(spawnVars))
+			for i, v := range spawnVars {
+				spawn[i] = env.EnvVar{Key: v.Key, Value: v.Value}
+			}
+			result.Diff = s.toProcessEnvDiff(env.DiffVars(spawn, current, s.envMasker))
+		}
+		log.Printf("[DEBUG] [ENV] Returning %d current env vars for process %s", len(result.Vars), proc.ID)
+	}
+
+	response, err := protocol.NewMessage(protocol.TypeProcessEnvResult, result)
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
+
+// captureCurrentEnv captures the env of a process's shell as it is now. As
+// at spawn, output is kept from clients while the capture command is typed,
+// and the session is then sent the screen it left.
+func (s *Server) captureCurrentEnv(connSession *ConnectedSession, proc *process.Process) ([]env.EnvVar, error) {
+	if proc.PTY == nil {
+		return nil, newFailure(protocol.ErrorNoPty, fmt.Sprintf("process %s has no terminal", proc.ID))
+	}
+	sshConn := s.sshManager.GetConnection(proc.HostID)
+	if sshConn == nil {
+		return nil, newFailure(protocol.ErrorNotConnected, fmt.Sprintf("host %s is not connected", proc.HostID))
+	}
+	if err := s.envRefreshes.start(proc.ID); err != nil {
+		return nil, err
+	}
+
+	proc.PTY.MuteOutput(true)
+	defer func() {
+		proc.PTY.MuteOutput(false)
+		if err := s.sendPtySnapshot(connSession, proc); err != nil {
+			log.Printf("[WARN] [PTY] Failed to repaint process %s after env capture: %v", proc.ID, err)
+		}
+	}()
+	return s.envManager.CaptureProcessEnv(sshConn.Client, proc.PTY.Tmux(), proc.ID, proc.PTY.TmuxName)
+}
+
+// toProcessEnvDiff converts an env diff, whose secret values DiffVars
+// already masked, to its protocol form
+func (s *Server) toProcessEnvDiff(diff env.VarDiff) *protocol.ProcessEnvDiff {
+	result := &protocol.ProcessEnvDiff{
+		Added:   []protocol.EnvVar{},
+		Removed: []protocol.EnvVar{},
+		Changed: []protocol.EnvVarChange{},
+	}
+	for _, v := range diff.Added {
+		result.Added = append(result.Added, protocol.EnvVar{Key: v.Key, Value: v.Value, IsMasked: s.envMasker.IsSecret(v.Key)})
+	}
+	for _, v := range diff.Removed {
+		result.Removed = append(result.Removed, protocol.EnvVar{Key: v.Key, Value: v.Value, IsMasked: s.envMasker.IsSecret(v.Key)})
+	}
+	for _, c := range diff.Changed {
+		result.Changed = append(result.Changed, protocol.EnvVarChange{Key: c.Key, Old: c.Old, New: c.New, IsMasked: c.IsMasked})
+	}
+	return result
+}
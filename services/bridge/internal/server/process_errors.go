@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/agentapi"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// ============================================================================
+// Process Last Error
+// ============================================================================
+//
+// A process shows the last thing that failed on it (see process.LastError)
+// until the operation that failed next succeeds or the user dismisses it.
+// Every change is stored with the process metadata and pushed as
+// process_updated, so a client that missed the error message still sees it.
+
+// sseFailureAttempts is how many times in a row AgentAPI's event stream
+// fails to connect before the process shows it
+const sseFailureAttempts = 5
+
+// setProcessError records a failure of op as the process's last error
+func (s *Server) setProcessError(proc *process.Process, op process.Operation, code protocol.ErrorCode, err error) {
+	if !proc.SetLastError(process.LastError{Operation: op, Code: code, Message: err.Error(), At: time.Now()}) {
+		return
+	}
+	log.Printf("[DEBUG] [PROCESS] Process %s last error: %s %s: %v", proc.ID, op, code, err)
+	s.processErrorChanged(nil, proc)
+}
+
+// clearProcessError clears the process's last error if one of ops failed,
+// or whatever failed if ops is empty
+func (s *Server) clearProcessError(proc *process.Process, ops ...process.Operation) {
+	if !proc.ClearLastError(ops...) {
+		return
+	}
+	log.Printf("[DEBUG] [PROCESS] Cleared last error of process %s", proc.ID)
+	s.processErrorChanged(nil, proc)
+}
+
+// processErrorChanged stores the process's last error and pushes it to the
+// session that changed it (if any) and the host's subscribers
+func (s *Server) processErrorChanged(connSession *ConnectedSession, proc *process.Process) error {
+	if s.storage != nil {
+		var stored *storage.ProcessError
+		if e := proc.GetLastError(); e != nil {
+			stored = &storage.ProcessError{Operation: string(e.Operation), Code: string(e.Code), Message: e.Message, At: e.At}
+		}
+		if err := s.storage.SetProcessLastError(proc.ID, stored); err != nil {
+			log.Printf("[WARN] [PROCESS] Failed to save last error of process %s: %v", proc.ID, err)
+		}
+	}
+	return s.notifyProcessUpdated(connSession, proc)
+}
+
+// fromStoredProcessError converts a stored last error back for the process
+func fromStoredProcessError(e *storage.ProcessError) process.LastError {
+	return process.LastError{
+		Operation: process.Operation(e.Operation),
+		Code:      protocol.ErrorCode(e.Code),
+		Message:   e.Message,
+		At:        e.At,
+	}
+}
+
+// claudeStartFailed records why Claude couldn't be started on a process and
+// returns the failure
+func (s *Server) claudeStartFailed(proc *process.Process, failure *requestFailure) *requestFailure {
+	s.setProcessError(proc, process.OpClaudeStart, failure.code, failure)
+	return failure
+}
+
+// agentStatus asks a Claude process's AgentAPI for its status, recording a
+// failure as the process's last error. The answer is tracked as the
+// process's agent status, see trackAgentStatus.
+func (s *Server) agentStatus(proc *process.Process, client *agentapi.Client) (*agentapi.StatusResponse, error) {
+	status, err := client.GetStatus()
+	if err != nil {
+		s.setProcessError(proc, process.OpAgentStatus, agentFailure(proc.ID, err).code, err)
+		s.trackAgentStatus(proc, "", "")
+		return nil, err
+	}
+	s.clearProcessError(proc, process.OpAgentStatus)
+	s.trackAgentStatus(proc, status.Status, status.AgentType)
+	return status, nil
+}
+
+// newSSEClient returns a client for a Claude process's AgentAPI event
+// stream that forwards its events, and shows on the process when the stream
+// keeps failing to connect
+func (s *Server) newSSEClient(dialer ssh.Dialer, proc *process.Process, port int) *agentapi.SSEClient {
+	client := agentapi.NewSSEClient(dialer, port, func(event agentapi.SSEEvent) {
+		s.handleAgentAPIEvent(proc.HostID, proc.ID, event)
+	})
+	client.SetFailureHandler(sseFailureAttempts, func(err error) {
+		s.agentEventsFailed(proc, err)
+	})
+	return client
+}
+
+// agentEventsFailed records that a Claude process's event stream keeps
+// failing to connect, or clears that when err is nil
+func (s *Server) agentEventsFailed(proc *process.Process, err error) {
+	if err == nil {
+		log.Printf("[INFO] [CLAUDE] AgentAPI events for process %s are back", proc.ID)
+		s.clearProcessError(proc, process.OpAgentEvents)
+		return
+	}
+	log.Printf("[WARN] [CLAUDE] AgentAPI events for process %s failed %d times in a row: %v", proc.ID, sseFailureAttempts, err)
+	s.setProcessError(proc, process.OpAgentEvents, protocol.ErrorAgentAPIDown, err)
+}
+
+// handleProcessClearError dismisses a process's last error
+func (s *Server) handleProcessClearError(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ProcessClearErrorPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	proc := s.processRegistry.Get(payload.ProcessID)
+	if proc == nil {
+		return connSession.sendProcessNotFound(payload.ProcessID)
+	}
+
+	if proc.ClearLastError() {
+		log.Printf("[INFO] [PROCESS] Session %s dismissed the last error of process %s", connSession.ID, proc.ID)
+	}
+	return s.processErrorChanged(connSession, proc)
+}
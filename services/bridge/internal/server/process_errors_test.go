@@ -0,0 +1,149 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/agentapi"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+func TestProcessErrorFromPtyInput(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	watcher, watcherCS := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 1)
+	if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{ProcessID: "proc-0", HostID: "host-1", ProcessType: "shell",
+		TmuxName: "rc-proc-0", StartedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	dispatch(t, s, watcherCS, protocol.TypeProcessesSubscribe, protocol.ProcessesSubscribePayload{HostID: "host-1"})
+	readPayload(t, watcher, protocol.TypeProcessListResult, &protocol.ProcessListResultPayload{})
+
+	// Never attached, so the write fails
+	dispatch(t, s, cs, protocol.TypePtyInput, protocol.PtyInputPayload{ProcessID: "proc-0", Data: "ls\n"})
+	readPtyFailure(t, conn)
+	var updated protocol.ProcessUpdatedPayload
+	readPayload(t, watcher, protocol.TypeProcessUpdated, &updated)
+	if e := updated.LastError; e == nil || e.Operation != "pty_write" || e.Code != protocol.ErrorPtyDetached || e.Message == "" || e.At == "" {
+		t.Fatalf("lastError = %+v", updated.LastError)
+	}
+	if meta, _ := s.storage.GetProcessMetadata("proc-0"); meta == nil || meta.LastError == nil || meta.LastError.Code != string(protocol.ErrorPtyDetached) {
+		t.Errorf("stored metadata = %+v, want the last error", meta)
+	}
+
+	// The same failure isn't pushed again, and a resize succeeding doesn't
+	// clear a failed write
+	dispatch(t, s, cs, protocol.TypePtyInput, protocol.PtyInputPayload{ProcessID: "proc-0", Data: "ls\n"})
+	readPtyFailure(t, conn)
+	dispatch(t, s, cs, protocol.TypePtyResize, protocol.PtyResizePayload{ProcessID: "proc-0", Cols: 100, Rows: 30})
+	expectNothingQueued(t, watcher, watcherCS)
+
+	dispatch(t, s, cs, protocol.TypeProcessClearError, protocol.ProcessClearErrorPayload{ProcessID: "proc-0"})
+	updated = protocol.ProcessUpdatedPayload{}
+	readPayload(t, conn, protocol.TypeProcessUpdated, &updated)
+	if updated.LastError != nil {
+		t.Errorf("lastError = %+v after it was dismissed", updated.LastError)
+	}
+	readPayload(t, watcher, protocol.TypeProcessUpdated, nil)
+	if meta, _ := s.storage.GetProcessMetadata("proc-0"); meta.LastError != nil {
+		t.Errorf("stored last error = %+v after it was dismissed", meta.LastError)
+	}
+}
+
+func TestProcessErrorFromAgentAPI(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	var down atomic.Bool
+	down.Store(true)
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"title":"Internal Server Error","status":500,"detail":"agent crashed"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"stable","agent_type":"claude"}`))
+	}))
+	t.Cleanup(agent.Close)
+	dial := dialerFunc(func(network, _ string) (net.Conn, error) {
+		return net.Dial(network, agent.Listener.Addr().String())
+	})
+	proc := &process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeClaude, AgentClient: agentapi.NewClient(dial, 3284)}
+	s.processRegistry.Register(proc)
+
+	// A status poll failing, then succeeding
+	var status protocol.ChatStatusResultPayload
+	dispatch(t, s, cs, protocol.TypeChatStatus, protocol.ChatStatusPayload{HostID: "host-1", ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypeChatStatusResult, &status)
+	if e := proc.GetLastError(); e == nil || e.Operation != process.OpAgentStatus || e.Code != protocol.ErrorAgentAPIDown {
+		t.Fatalf("last error = %+v after a failed poll", e)
+	}
+	down.Store(false)
+	dispatch(t, s, cs, protocol.TypeChatStatus, protocol.ChatStatusPayload{HostID: "host-1", ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypeChatStatusResult, &status)
+	if e := proc.GetLastError(); status.Status != "stable" || e != nil {
+		t.Fatalf("status %q, last error = %+v after a good poll", status.Status, e)
+	}
+
+	// The event stream failing to reconnect, until it is back
+	s.agentEventsFailed(proc, errors.New("failed to connect: connection refused"))
+	if e := proc.GetLastError(); e == nil || e.Operation != process.OpAgentEvents {
+		t.Fatalf("last error = %+v after the stream failed", e)
+	}
+	// A good poll doesn't mean the stream is back
+	dispatch(t, s, cs, protocol.TypeChatStatus, protocol.ChatStatusPayload{HostID: "host-1", ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypeChatStatusResult, &status)
+	if proc.GetLastError() == nil {
+		t.Fatal("a status poll cleared the stream's error")
+	}
+	s.agentEventsFailed(proc, nil)
+	if e := proc.GetLastError(); e != nil {
+		t.Errorf("last error = %+v after the stream came back", e)
+	}
+}
+
+func TestProcessErrorFromClaude(t *testing.T) {
+	config := DefaultConfig()
+	config.PortRange = process.PortRange{Min: 3284, Max: 3284}
+	s := newTestServer(t, config)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 1)
+	shell := s.processRegistry.Get("proc-0")
+	shell.SetPtyReady(true)
+
+	// Starting fails with every port taken
+	if _, err := s.processRegistry.AllocatePort(); err != nil {
+		t.Fatalf("AllocatePort: %v", err)
+	}
+	dispatch(t, s, cs, protocol.TypeClaudeStart, protocol.ClaudeStartPayload{ProcessID: "proc-0"})
+	readPayload(t, conn, protocol.TypeError, nil)
+	if e := shell.GetLastError(); e == nil || e.Operation != process.OpClaudeStart || e.Code != protocol.ErrorNoPorts {
+		t.Errorf("last error = %+v after claude_start failed", e)
+	}
+
+	// Killing fails to reach AgentAPI through the detached PTY. The stream's
+	// error goes with Claude, but the kill's stays.
+	pid := 4242
+	claude := &process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeClaude, AgentAPIPID: &pid,
+		PTY: &pty.Session{ID: "proc-1", HostID: "host-1", TmuxName: "rc-proc-1"}}
+	s.processRegistry.Register(claude)
+	s.agentEventsFailed(claude, errors.New("connection refused"))
+	dispatch(t, s, cs, protocol.TypeClaudeKill, protocol.ClaudeKillPayload{ProcessID: "proc-1"})
+	var updated protocol.ProcessUpdatedPayload
+	readPayload(t, conn, protocol.TypeProcessUpdated, &updated)
+	if e := updated.LastError; updated.Type != protocol.ProcessTypeShell || e == nil || e.Operation != "claude_kill" || e.Code != protocol.ErrorPtyError {
+		t.Errorf("after kill: type = %s, lastError = %+v", updated.Type, e)
+	}
+}
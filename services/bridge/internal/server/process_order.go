@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// handleProcessPin pins a process to the top of its host's list, or unpins
+// it. The pin is stored with the process metadata so it survives reattach
+// and bridge restarts.
+func (s *Server) handleProcessPin(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ProcessPinPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [PROCESS] Pin request: processId=%s pinned=%v", payload.ProcessID, payload.Pinned)
+
+	proc := s.processRegistry.Get(payload.ProcessID)
+	if proc == nil {
+		return connSession.sendProcessNotFound(payload.ProcessID)
+	}
+
+	if err := s.storage.SetProcessPinned(payload.ProcessID, payload.Pinned); err != nil {
+		log.Printf("[ERROR] [PROCESS] Failed to pin process %s: %v", payload.ProcessID, err)
+		return connSession.SendErrorDetails(protocol.ErrorStorageError, protocol.ErrorDetails{"processId": payload.ProcessID, "reason": err.Error()})
+	}
+	s.syncProcessOrder(proc.HostID)
+
+	return s.notifyProcessUpdated(connSession, proc)
+}
+
+// handleProcessSetOrder reorders a host's processes, live and detached. Live
+// processes whose position changed are pushed as process_updated, and the
+// requester gets the reordered list.
+func (s *Server) handleProcessSetOrder(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ProcessSetOrderPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [PROCESS] Set order request: hostId=%s processIds=%v", payload.HostID, payload.ProcessIDs)
+
+	orders, err := s.storage.GetProcessOrders(payload.HostID)
+	if err != nil {
+		log.Printf("[ERROR] [PROCESS] Failed to read process order for host %s: %v", payload.HostID, err)
+		return connSession.SendErrorDetails(protocol.ErrorStorageError, protocol.ErrorDetails{"hostId": payload.HostID, "reason": err.Error()})
+	}
+	for _, id := range payload.ProcessIDs {
+		if _, ok := orders[id]; !ok {
+			return connSession.SendErrorDetails(protocol.ErrorNotFound,
+				protocol.ErrorDetails{"processId": id, "hostId": payload.HostID, "reason": "process not found on host"})
+		}
+	}
+
+	if err := s.storage.SetProcessOrder(payload.HostID, payload.ProcessIDs); err != nil {
+		log.Printf("[ERROR] [PROCESS] Failed to reorder processes on host %s: %v", payload.HostID, err)
+		return connSession.SendErrorDetails(protocol.ErrorStorageError, protocol.ErrorDetails{"hostId": payload.HostID, "reason": err.Error()})
+	}
+
+	for _, proc := range s.syncProcessOrder(payload.HostID) {
+		if err := s.notifyProcessUpdated(connSession, proc); err != nil {
+			return err
+		}
+	}
+	return s.sendProcessList(connSession, payload.HostID, false, false)
+}
+
+// syncProcessOrder copies the stored order of a host's processes to the live
+// ones, and returns those whose order changed
+func (s *Server) syncProcessOrder(hostID string) []*process.Process {
+	orders, err := s.storage.GetProcessOrders(hostID)
+	if err != nil {
+		log.Printf("[WARN] [PROCESS] Failed to read process order for host %s: %v", hostID, err)
+		return nil
+	}
+
+	var changed []*process.Process
+	for _, proc := range s.processRegistry.GetByHost(hostID) {
+		order, ok := orders[proc.ID]
+		if !ok {
+			continue
+		}
+		if info := proc.ToInfo(); info.Pinned == order.Pinned && info.SortWeight == order.SortWeight {
+			continue
+		}
+		proc.SetOrder(order.Pinned, order.SortWeight)
+		changed = append(changed, proc)
+	}
+	return changed
+}
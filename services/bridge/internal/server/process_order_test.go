@@ -0,0 +1,129 @@
+package server
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+)
+
+// createShell creates a shell process on host-1 and returns its ID
+func createShell(t *testing.T, s *Server, conn *websocket.Conn, cs *ConnectedSession) string {
+	t.Helper()
+	dispatch(t, s, cs, protocol.TypeProcessCreate, protocol.ProcessCreatePayload{HostID: "host-1"})
+	var created protocol.ProcessCreatedPayload
+	readPayload(t, conn, protocol.TypeProcessCreated, &created)
+	return created.Process.ID
+}
+
+// listOrder requests the process list of host-1 and returns its IDs in order
+func listOrder(t *testing.T, s *Server, conn *websocket.Conn, cs *ConnectedSession) []string {
+	t.Helper()
+	dispatch(t, s, cs, protocol.TypeProcessList, protocol.ProcessListPayload{HostID: "host-1", ListLight: true})
+	return readListOrder(t, s, conn)
+}
+
+// readListOrder reads a process list of host-1 and returns its IDs in order,
+// checking each process's order against the stored one
+func readListOrder(t *testing.T, s *Server, conn *websocket.Conn) []string {
+	t.Helper()
+	var result protocol.ProcessListResultPayload
+	readPayload(t, conn, protocol.TypeProcessListResult, &result)
+	orders, err := s.storage.GetProcessOrders("host-1")
+	if err != nil {
+		t.Fatalf("GetProcessOrders: %v", err)
+	}
+	var ids []string
+	for _, info := range result.Processes {
+		if stored := orders[info.ID]; info.Pinned != stored.Pinned || info.SortWeight != stored.SortWeight {
+			t.Errorf("process %s: listed pinned=%v weight=%d, stored %+v", info.ID, info.Pinned, info.SortWeight, stored)
+		}
+		ids = append(ids, info.ID)
+	}
+	return ids
+}
+
+func TestProcessPinAndOrder(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	watcher, watcherCS := connectTestClient(t, s)
+	shellTestHost(t, s)
+
+	a := createShell(t, s, conn, cs)
+	b := createShell(t, s, conn, cs)
+	c := createShell(t, s, conn, cs)
+	if got := listOrder(t, s, conn, cs); !slices.Equal(got, []string{a, b, c}) {
+		t.Fatalf("initial order = %v, want creation order", got)
+	}
+	dispatch(t, s, watcherCS, protocol.TypeProcessesSubscribe, protocol.ProcessesSubscribePayload{HostID: "host-1"})
+	readPayload(t, watcher, protocol.TypeProcessListResult, nil)
+
+	// Pinning moves c to the top and is pushed to subscribers
+	dispatch(t, s, cs, protocol.TypeProcessPin, protocol.ProcessPinPayload{ProcessID: c, Pinned: true})
+	for _, ws := range []*websocket.Conn{conn, watcher} {
+		var updated protocol.ProcessUpdatedPayload
+		readPayload(t, ws, protocol.TypeProcessUpdated, &updated)
+		if updated.ID != c || !updated.Pinned || updated.SortWeight != 2 {
+			t.Errorf("process_updated = %+v, want %s pinned at weight 2", updated, c)
+		}
+	}
+	if got := listOrder(t, s, conn, cs); !slices.Equal(got, []string{c, a, b}) {
+		t.Errorf("after pin = %v, want pinned first", got)
+	}
+
+	// Moving b first pushes b and a, whose weights changed, then lists
+	dispatch(t, s, cs, protocol.TypeProcessSetOrder, protocol.ProcessSetOrderPayload{HostID: "host-1", ProcessIDs: []string{b}})
+	var moved []string
+	for range 2 {
+		var updated protocol.ProcessUpdatedPayload
+		readPayload(t, conn, protocol.TypeProcessUpdated, &updated)
+		moved = append(moved, updated.ID)
+	}
+	if !slices.Contains(moved, a) || !slices.Contains(moved, b) {
+		t.Errorf("process_updated for %v, want %s and %s", moved, a, b)
+	}
+	if got := readListOrder(t, s, conn); !slices.Equal(got, []string{c, b, a}) {
+		t.Errorf("after set order = %v, want pinned, then the listed one, then the rest", got)
+	}
+	for range 2 {
+		readPayload(t, watcher, protocol.TypeProcessUpdated, nil)
+	}
+	expectNothingQueued(t, watcher, watcherCS)
+
+	// Unknown processes are rejected without changing anything
+	dispatch(t, s, cs, protocol.TypeProcessSetOrder, protocol.ProcessSetOrderPayload{HostID: "host-1", ProcessIDs: []string{a, "nope"}})
+	var errPayload protocol.ErrorPayload
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotFound {
+		t.Errorf("code = %s, want %s", errPayload.Code, protocol.ErrorNotFound)
+	}
+	if got := listOrder(t, s, conn, cs); !slices.Equal(got, []string{c, b, a}) {
+		t.Errorf("after rejected set order = %v", got)
+	}
+
+	// Killing b closes its gap: a and c move up one
+	dispatch(t, s, cs, protocol.TypeProcessKill, protocol.ProcessKillPayload{ProcessID: b})
+	readPayload(t, conn, protocol.TypeProcessKilled, nil)
+	if got := listOrder(t, s, conn, cs); !slices.Equal(got, []string{c, a}) {
+		t.Errorf("after kill = %v", got)
+	}
+	if info := s.processRegistry.Get(a).ToInfo(); info.SortWeight != 0 {
+		t.Errorf("weight of %s = %d after kill, want 0", a, info.SortWeight)
+	}
+
+	// The order survives detaching and reattaching, as after a restart
+	s.processRegistry.Unregister(c)
+	dispatch(t, s, cs, protocol.TypeProcessReattach, protocol.ProcessReattachPayload{
+		HostID: "host-1", TmuxSession: pty.TmuxSessionName(c), ProcessID: c,
+	})
+	readPayload(t, conn, protocol.TypeProcessAdded, nil)
+	readPayload(t, conn, protocol.TypeStaleProcessesChanged, nil)
+	if info := s.processRegistry.Get(c).ToInfo(); !info.Pinned || info.SortWeight != 1 {
+		t.Errorf("reattached order = pinned %v weight %d, want pinned at 1", info.Pinned, info.SortWeight)
+	}
+	if got := listOrder(t, s, conn, cs); !slices.Equal(got, []string{c, a}) {
+		t.Errorf("after reattach = %v", got)
+	}
+}
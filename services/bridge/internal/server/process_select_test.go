@@ -0,0 +1,84 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// screenFixture is what tmux capture-pane -e -p prints for a small pane
+const screenFixture = "\x1b[1m$\x1b[0m make test\nok  \tbridge\t0.4s\n\x1b[1m$\x1b[0m \n"
+
+// registerScreenShell connects host-1 to srv and registers a 120x30 shell
+// process, proc-1, on it
+func registerScreenShell(t *testing.T, s *Server, srv *testSSHServer) *process.Process {
+	t.Helper()
+	if _, err := s.sshManager.Connect("host-1", "127.0.0.1", srv.Port(), "user",
+		ssh.AuthConfig{AuthType: "password", Password: "secret"}); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	ptySession := &pty.Session{ID: "proc-1", HostID: "host-1", TmuxName: pty.TmuxSessionName("proc-1"), Cols: 120, Rows: 30}
+	ptySession.UpdateSSHClient(s.sshManager.GetConnection("host-1").Client)
+	proc := &process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeShell, PTY: ptySession}
+	s.processRegistry.Register(proc)
+	return proc
+}
+
+func TestProcessSelectSendsSnapshot(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+
+	var mu sync.Mutex
+	var commands []string
+	srv := startTestSSHServer(t)
+	srv.HandleExec(func(cmd string) string {
+		mu.Lock()
+		commands = append(commands, cmd)
+		mu.Unlock()
+		if strings.Contains(cmd, "tmux capture-pane") {
+			return screenFixture
+		}
+		return ""
+	})
+	proc := registerScreenShell(t, s, srv)
+
+	dispatch(t, s, cs, protocol.TypeProcessSelect, protocol.ProcessSelectPayload{ProcessID: "proc-1"})
+	var snapshot protocol.PtySnapshotPayload
+	readPayload(t, conn, protocol.TypePtySnapshot, &snapshot)
+	want := "\x1b[1m$\x1b[0m make test\r\nok  \tbridge\t0.4s\r\n\x1b[1m$\x1b[0m "
+	if snapshot.ProcessID != "proc-1" || snapshot.Cols != 120 || snapshot.Rows != 30 || snapshot.Data != want {
+		t.Errorf("snapshot = %+v", snapshot)
+	}
+
+	// The capture keeps escapes and reaches back two screens
+	mu.Lock()
+	if len(commands) != 1 || !strings.Contains(commands[0], "tmux capture-pane -e -p -S -60 -t rc-proc-1") {
+		t.Errorf("commands = %q", commands)
+	}
+	mu.Unlock()
+	if got := proc.TrafficStats().PtyOutputBytes; got != uint64(len(want)) {
+		t.Errorf("pty output traffic = %d, want %d", got, len(want))
+	}
+
+	// When the capture fails the client gets nothing
+	srv.HandleExec(nil)
+	dispatch(t, s, cs, protocol.TypeProcessSelect, protocol.ProcessSelectPayload{ProcessID: "proc-1"})
+	expectNothingQueued(t, conn, cs)
+}
+
+func TestProcessSelectUnknownProcess(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+
+	dispatch(t, s, cs, protocol.TypeProcessSelect, protocol.ProcessSelectPayload{ProcessID: "nope"})
+	var errPayload protocol.ErrorPayload
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotFound {
+		t.Errorf("error = %+v", errPayload)
+	}
+}
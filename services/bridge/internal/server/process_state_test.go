@@ -0,0 +1,82 @@
+package server
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// TestConcurrentStartKillInfo starts and kills Claude on a process while
+// others list it and ask for its status. Run it with -race.
+func TestConcurrentStartKillInfo(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	proc := &process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeShell}
+	s.processRegistry.Register(proc)
+
+	handle := func(msgType string, payload interface{}) {
+		msg, _ := protocol.NewMessage(msgType, payload)
+		if err := s.handlers[msgType](cs, msg); err != nil {
+			t.Errorf("handler %s: %v", msgType, err)
+		}
+	}
+
+	const rounds = 200
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go func() {
+		// What restoreClaude does once AgentAPI answers
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if proc.GetType() != process.TypeShell {
+				continue
+			}
+			port, err := s.processRegistry.AllocatePort()
+			if err != nil {
+				t.Errorf("AllocatePort: %v", err)
+				return
+			}
+			proc.SetPort(port)
+			proc.SetAgentAPIPID(1000 + i)
+			proc.UpdateType(process.TypeClaude)
+			proc.SetAgentAPIReady(true)
+			proc.SetAgentStatus("stable", "claude")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			handle(protocol.TypeClaudeKill, protocol.ClaudeKillPayload{ProcessID: "proc-1"})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			for _, p := range s.processRegistry.GetByHost("host-1") {
+				p.ToInfo()
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			handle(protocol.TypeChatStatus, protocol.ChatStatusPayload{HostID: "host-1", ProcessID: "proc-1"})
+		}
+	}()
+	wg.Wait()
+
+	// Killed for good, nothing of Claude is left
+	handle(protocol.TypeClaudeKill, protocol.ClaudeKillPayload{ProcessID: "proc-1"})
+	if info := proc.ToInfo(); info.Type != protocol.ProcessTypeShell || info.Port != nil || info.AgentAPIPID != nil || info.AgentStatus != nil {
+		t.Errorf("after the last kill: %+v", info)
+	}
+}
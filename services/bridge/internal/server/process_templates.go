@@ -0,0 +1,465 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/env"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// ============================================================================
+// Process Template Handlers
+// ============================================================================
+//
+// A template saves the steps of setting up a process: a shell in a working
+// directory with extra env vars (and maybe another shell), then Claude
+// started in it. process_create_from_template runs those steps through the
+// same code as process_create and claude_start.
+
+// toProtocolTemplate converts a stored template to its protocol form,
+// masking secret env values
+func (s *Server) toProtocolTemplate(tmpl *storage.ProcessTemplate) *protocol.ProcessTemplate {
+	env := make([]protocol.EnvVar, len(tmpl.EnvVars))
+	for i, v := range tmpl.EnvVars {
+		env[i] = s.toProtocolEnvVar(v.Key, v.Value)
+	}
+	return &protocol.ProcessTemplate{
+		ID:              tmpl.ID,
+		Name:            tmpl.Name,
+		HostID:          nilIfEmpty(tmpl.HostID),
+		CWD:             nilIfEmpty(tmpl.CWD),
+		Env:             env,
+		Shell:           nilIfEmpty(tmpl.Shell),
+		ClaudeArgs:      nilIfEmpty(tmpl.ClaudeArgs),
+		AutoStartClaude: tmpl.AutoStartClaude,
+		StartupHooks:    tmpl.StartupHooks,
+		CreatedAt:       tmpl.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:       tmpl.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// templateEnv converts env vars sent by a client for a template. Masked
+// placeholders keep the value the template already has.
+func templateEnv(vars []protocol.EnvVar, current []storage.EnvVar) ([]storage.EnvVar, error) {
+	out := make([]storage.EnvVar, 0, len(vars))
+	for _, v := range vars {
+		if !validEnvKey(v.Key) {
+			return nil, fmt.Errorf("invalid env var name %q", v.Key)
+		}
+		value := v.Value
+		if v.IsMasked {
+			var found bool
+			for _, c := range current {
+				if c.Key == v.Key {
+					value, found = c.Value, true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("no current value for masked variable %s", v.Key)
+			}
+		}
+		out = append(out, storage.EnvVar{Key: v.Key, Value: value})
+	}
+	return out, nil
+}
+
+// validEnvKey reports whether key can be exported by a shell
+func validEnvKey(key string) bool {
+	for i, c := range key {
+		letter := c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return key != ""
+}
+
+// checkTemplate rejects a template that could never run
+func checkTemplate(tmpl *storage.ProcessTemplate) error {
+	if tmpl.Name == "" {
+		return fmt.Errorf("template name is required")
+	}
+	if _, _, failure := claudeCommand(&tmpl.ClaudeArgs); failure != nil {
+		return fmt.Errorf("invalid claudeArgs: %v", failure)
+	}
+	return nil
+}
+
+// handleProcessTemplateList returns all process templates
+func (s *Server) handleProcessTemplateList(connSession *ConnectedSession, msg *protocol.Message) error {
+	templates, err := s.storage.ListProcessTemplates()
+	if err != nil {
+		log.Printf("[ERROR] [TEMPLATE] Failed to list templates: %v", err)
+		return connSession.SendErrorDetails(protocol.ErrorStorageError, protocol.ErrorDetails{"reason": err.Error()})
+	}
+
+	protoTemplates := make([]protocol.ProcessTemplate, len(templates))
+	for i := range templates {
+		protoTemplates[i] = *s.toProtocolTemplate(&templates[i])
+	}
+
+	response, err := protocol.NewMessage(protocol.TypeProcessTemplateListResult, protocol.ProcessTemplateListResultPayload{
+		Templates: protoTemplates,
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [TEMPLATE] Returning %d templates", len(protoTemplates))
+	return connSession.Send(response)
+}
+
+func (s *Server) sendTemplateCreateResult(connSession *ConnectedSession, tmpl *storage.ProcessTemplate, err error) error {
+	payload := protocol.ProcessTemplateCreateResultPayload{Success: err == nil}
+	if err != nil {
+		payload.Code = failureCode(err, protocol.ErrorStorageError)
+		payload.Error = strPtr(err.Error())
+	} else {
+		payload.Template = s.toProtocolTemplate(tmpl)
+	}
+	msg, _ := protocol.NewMessage(protocol.TypeProcessTemplateCreateResult, payload)
+	return connSession.Send(msg)
+}
+
+// handleProcessTemplateCreate saves a new process template
+func (s *Server) handleProcessTemplateCreate(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ProcessTemplateCreatePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [TEMPLATE] Creating template: %s", payload.Name)
+
+	env, err := templateEnv(payload.Env, nil)
+	if err != nil {
+		return s.sendTemplateCreateResult(connSession, nil, newFailure(protocol.ErrorInvalidArgs, err.Error()))
+	}
+	hooks, err := checkStartupHooks(payload.StartupHooks)
+	if err != nil {
+		return s.sendTemplateCreateResult(connSession, nil, newFailure(protocol.ErrorInvalidArgs, err.Error()))
+	}
+	tmpl := storage.ProcessTemplate{
+		ID:              uuid.New().String(),
+		Name:            payload.Name,
+		HostID:          derefString(payload.HostID),
+		CWD:             derefString(payload.CWD),
+		EnvVars:         env,
+		Shell:           derefString(payload.Shell),
+		ClaudeArgs:      derefString(payload.ClaudeArgs),
+		AutoStartClaude: payload.AutoStartClaude,
+		StartupHooks:    hooks,
+	}
+	if err := checkTemplate(&tmpl); err != nil {
+		return s.sendTemplateCreateResult(connSession, nil, newFailure(protocol.ErrorInvalidArgs, err.Error()))
+	}
+	if err := s.storage.CreateProcessTemplate(tmpl); err != nil {
+		log.Printf("[ERROR] [TEMPLATE] Failed to create template: %v", err)
+		return s.sendTemplateCreateResult(connSession, nil, err)
+	}
+
+	created, err := s.storage.GetProcessTemplate(tmpl.ID)
+	if err != nil || created == nil {
+		log.Printf("[ERROR] [TEMPLATE] Failed to get created template: %v", err)
+		return s.sendTemplateCreateResult(connSession, nil, fmt.Errorf("template created but failed to retrieve"))
+	}
+
+	log.Printf("[INFO] [TEMPLATE] Created template %s (%s)", created.ID, created.Name)
+	return s.sendTemplateCreateResult(connSession, created, nil)
+}
+
+func (s *Server) sendTemplateUpdateResult(connSession *ConnectedSession, tmpl *storage.ProcessTemplate, err error) error {
+	payload := protocol.ProcessTemplateUpdateResultPayload{Success: err == nil}
+	if err != nil {
+		payload.Code = failureCode(err, protocol.ErrorStorageError)
+		payload.Error = strPtr(err.Error())
+	} else {
+		payload.Template = s.toProtocolTemplate(tmpl)
+	}
+	msg, _ := protocol.NewMessage(protocol.TypeProcessTemplateUpdateResult, payload)
+	return connSession.Send(msg)
+}
+
+// handleProcessTemplateUpdate changes the fields of a template that are set
+func (s *Server) handleProcessTemplateUpdate(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ProcessTemplateUpdatePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [TEMPLATE] Updating template: %s", payload.ID)
+
+	existing, err := s.storage.GetProcessTemplate(payload.ID)
+	if err != nil {
+		log.Printf("[ERROR] [TEMPLATE] Failed to get template: %v", err)
+		return s.sendTemplateUpdateResult(connSession, nil, err)
+	}
+	if existing == nil {
+		return s.sendTemplateUpdateResult(connSession, nil, newFailure(protocol.ErrorNotFound, "template not found"))
+	}
+
+	if payload.Name != nil {
+		existing.Name = *payload.Name
+	}
+	if payload.HostID != nil {
+		existing.HostID = *payload.HostID
+	}
+	if payload.CWD != nil {
+		existing.CWD = *payload.CWD
+	}
+	if payload.Env != nil {
+		if existing.EnvVars, err = templateEnv(*payload.Env, existing.EnvVars); err != nil {
+			return s.sendTemplateUpdateResult(connSession, nil, newFailure(protocol.ErrorInvalidArgs, err.Error()))
+		}
+	}
+	if payload.Shell != nil {
+		existing.Shell = *payload.Shell
+	}
+	if payload.ClaudeArgs != nil {
+		existing.ClaudeArgs = *payload.ClaudeArgs
+	}
+	if payload.AutoStartClaude != nil {
+		existing.AutoStartClaude = *payload.AutoStartClaude
+	}
+	if payload.StartupHooks != nil {
+		if existing.StartupHooks, err = checkStartupHooks(*payload.StartupHooks); err != nil {
+			return s.sendTemplateUpdateResult(connSession, nil, newFailure(protocol.ErrorInvalidArgs, err.Error()))
+		}
+	}
+	if err := checkTemplate(existing); err != nil {
+		return s.sendTemplateUpdateResult(connSession, nil, newFailure(protocol.ErrorInvalidArgs, err.Error()))
+	}
+
+	if err := s.storage.UpdateProcessTemplate(*existing); err != nil {
+		log.Printf("[ERROR] [TEMPLATE] Failed to update template: %v", err)
+		return s.sendTemplateUpdateResult(connSession, nil, err)
+	}
+
+	updated, err := s.storage.GetProcessTemplate(payload.ID)
+	if err != nil || updated == nil {
+		log.Printf("[ERROR] [TEMPLATE] Failed to get updated template: %v", err)
+		return s.sendTemplateUpdateResult(connSession, nil, fmt.Errorf("template updated but failed to retrieve"))
+	}
+
+	log.Printf("[INFO] [TEMPLATE] Updated template %s (%s)", updated.ID, updated.Name)
+	return s.sendTemplateUpdateResult(connSession, updated, nil)
+}
+
+func (s *Server) sendTemplateDeleteResult(connSession *ConnectedSession, id string, err error) error {
+	payload := protocol.ProcessTemplateDeleteResultPayload{Success: err == nil}
+	if err != nil {
+		payload.Code = failureCode(err, protocol.ErrorStorageError)
+		payload.Error = strPtr(err.Error())
+	} else {
+		payload.ID = &id
+	}
+	msg, _ := protocol.NewMessage(protocol.TypeProcessTemplateDeleteResult, payload)
+	return connSession.Send(msg)
+}
+
+// handleProcessTemplateDelete deletes a template. Processes created from it
+// are untouched.
+func (s *Server) handleProcessTemplateDelete(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ProcessTemplateDeletePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [TEMPLATE] Deleting template: %s", payload.ID)
+
+	existing, err := s.storage.GetProcessTemplate(payload.ID)
+	if err != nil {
+		log.Printf("[ERROR] [TEMPLATE] Failed to get template: %v", err)
+		return s.sendTemplateDeleteResult(connSession, "", err)
+	}
+	if existing == nil {
+		return s.sendTemplateDeleteResult(connSession, "", newFailure(protocol.ErrorNotFound, "template not found"))
+	}
+
+	if err := s.storage.DeleteProcessTemplate(payload.ID); err != nil {
+		log.Printf("[ERROR] [TEMPLATE] Failed to delete template: %v", err)
+		return s.sendTemplateDeleteResult(connSession, "", err)
+	}
+
+	log.Printf("[INFO] [TEMPLATE] Deleted template %s (%s)", existing.ID, existing.Name)
+	return s.sendTemplateDeleteResult(connSession, payload.ID, nil)
+}
+
+// templateRun is a template with a request's overrides applied, checked
+// and ready to run
+type templateRun struct {
+	hostID      string
+	sshConn     *ssh.Connection
+	ptyConfig   pty.SessionConfig
+	startClaude bool
+	claudeCmd   string
+	sessionID   string // Claude's, see claudeSession
+	env         []env.EnvVar
+	hooks       []string // The host's startup hooks, then the template's
+}
+
+// resolveTemplateRun applies a request's overrides to its template and
+// checks everything the later stages need that can be checked up front
+func (s *Server) resolveTemplateRun(connSession *ConnectedSession, payload *protocol.ProcessCreateFromTemplatePayload) (*templateRun, *requestFailure) {
+	tmpl, err := s.storage.GetProcessTemplate(payload.TemplateID)
+	if err != nil {
+		return nil, &requestFailure{protocol.ErrorStorageError, protocol.ErrorDetails{"reason": err.Error()}}
+	}
+	if tmpl == nil {
+		return nil, &requestFailure{protocol.ErrorNotFound,
+			protocol.ErrorDetails{"templateId": payload.TemplateID, "reason": "template not found"}}
+	}
+
+	hostID := derefString(payload.HostID)
+	switch {
+	case tmpl.HostID != "" && hostID == "":
+		hostID = tmpl.HostID
+	case tmpl.HostID != "" && hostID != tmpl.HostID:
+		return nil, &requestFailure{protocol.ErrorInvalidArgs,
+			protocol.ErrorDetails{"hostId": hostID, "reason": fmt.Sprintf("template is for host %s", tmpl.HostID)}}
+	case hostID == "":
+		return nil, &requestFailure{protocol.ErrorInvalidArgs,
+			protocol.ErrorDetails{"reason": "hostId is required for a template without a host"}}
+	}
+	sshConn := s.sshManager.GetConnection(hostID)
+	if sshConn == nil {
+		return nil, &requestFailure{protocol.ErrorNotConnected, protocol.ErrorDetails{"hostId": hostID}}
+	}
+
+	vars, err := templateEnv(payload.Env, tmpl.EnvVars)
+	if err != nil {
+		return nil, &requestFailure{protocol.ErrorInvalidArgs, protocol.ErrorDetails{"reason": err.Error()}}
+	}
+	vars = mergeEnv(tmpl.EnvVars, vars)
+
+	claudeArgs := tmpl.ClaudeArgs
+	if payload.ClaudeArgs != nil {
+		claudeArgs = *payload.ClaudeArgs
+	}
+	claudeCmd, claudeSessionID, failure := claudeCommand(&claudeArgs)
+	if failure != nil {
+		return nil, failure
+	}
+
+	run := &templateRun{
+		hostID:      hostID,
+		sshConn:     sshConn,
+		ptyConfig:   pty.DefaultSessionConfig(),
+		startClaude: tmpl.AutoStartClaude,
+		claudeCmd:   claudeCmd,
+		sessionID:   claudeSessionID,
+	}
+	if payload.AutoStartClaude != nil {
+		run.startClaude = *payload.AutoStartClaude
+	}
+	run.ptyConfig.Cols, run.ptyConfig.Rows = connSession.ptySize(payload.Cols, payload.Rows, run.ptyConfig.Cols, run.ptyConfig.Rows)
+	run.ptyConfig.InitialCWD = tmpl.CWD
+	if payload.CWD != nil {
+		run.ptyConfig.InitialCWD = *payload.CWD
+	}
+	run.ptyConfig.Shell = tmpl.Shell
+	for _, v := range vars {
+		run.env = append(run.env, env.EnvVar{Key: v.Key, Value: v.Value})
+	}
+	if !payload.SkipHooks {
+		run.hooks = append(s.hostStartupHooks(hostID), tmpl.StartupHooks...)
+	}
+	return run, nil
+}
+
+// mergeEnv returns base with overrides applied by key: a key in both takes
+// the override's value in base's place, and new keys go last
+func mergeEnv(base, overrides []storage.EnvVar) []storage.EnvVar {
+	merged := append([]storage.EnvVar(nil), base...)
+	for _, o := range overrides {
+		replaced := false
+		for i := range merged {
+			if merged[i].Key == o.Key {
+				merged[i].Value, replaced = o.Value, true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, o)
+		}
+	}
+	return merged
+}
+
+// handleProcessCreateFromTemplate runs a template's stages in order,
+// sending the usual process_created and process_updated messages as they
+// complete, then a result saying how far it got. A failed stage doesn't undo
+// the ones before it.
+func (s *Server) handleProcessCreateFromTemplate(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ProcessCreateFromTemplatePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [TEMPLATE] Create from template %s", payload.TemplateID)
+
+	result := protocol.ProcessCreateFromTemplateResultPayload{TemplateID: payload.TemplateID, Stages: []string{}}
+	fail := func(stage string, failure *requestFailure) error {
+		log.Printf("[WARN] [TEMPLATE] Template %s failed at %s: %v", payload.TemplateID, stage, failure)
+		result.FailedStage = &stage
+		result.ErrorCode = &failure.code
+		result.Error = strPtr(failure.Error())
+		return s.sendTemplateRunResult(connSession, result)
+	}
+
+	run, failure := s.resolveTemplateRun(connSession, &payload)
+	if failure != nil {
+		return fail(protocol.TemplateStageResolve, failure)
+	}
+	result.Stages = append(result.Stages, protocol.TemplateStageResolve)
+
+	proc, hooksSent, err := s.startShellProcess(connSession, run.hostID, run.sshConn, run.ptyConfig, run.env, run.hooks)
+	if err != nil {
+		return fail(protocol.TemplateStageCreate, &requestFailure{protocol.ErrorPtyError,
+			protocol.ErrorDetails{"hostId": run.hostID, "reason": err.Error()}})
+	}
+	created, err := protocol.NewMessage(protocol.TypeProcessCreated, processCreated(proc))
+	if err != nil {
+		return err
+	}
+	s.publishProcessMessage(run.hostID, proc.ID, created, connSession)
+	if err := connSession.Send(created); err != nil {
+		return err
+	}
+	result.Stages = append(result.Stages, protocol.TemplateStageCreate)
+	info := proc.ToInfo()
+	result.Process = &info
+
+	if run.startClaude {
+		// Claude starts in the shell the hooks set up, rather than getting
+		// them typed into its terminal
+		<-hooksSent
+		if failure := s.startClaude(proc, run.claudeCmd, run.sessionID); failure != nil {
+			return fail(protocol.TemplateStageClaude, failure)
+		}
+		if err := s.notifyProcessUpdated(connSession, proc); err != nil {
+			return err
+		}
+		result.Stages = append(result.Stages, protocol.TemplateStageClaude)
+		info = proc.ToInfo()
+	}
+
+	log.Printf("[INFO] [TEMPLATE] Created process %s from template %s (stages: %v)", proc.ID, payload.TemplateID, result.Stages)
+	result.Success = true
+	return s.sendTemplateRunResult(connSession, result)
+}
+
+func (s *Server) sendTemplateRunResult(connSession *ConnectedSession, result protocol.ProcessCreateFromTemplateResultPayload) error {
+	msg, err := protocol.NewMessage(protocol.TypeProcessCreateFromTemplateResult, result)
+	if err != nil {
+		return err
+	}
+	return connSession.Send(msg)
+}
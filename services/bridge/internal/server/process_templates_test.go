@@ -0,0 +1,204 @@
+package server
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// createTemplate saves a template and returns it as sent back
+func createTemplate(t *testing.T, s *Server, conn *websocket.Conn, cs *ConnectedSession, payload protocol.ProcessTemplateCreatePayload) *protocol.ProcessTemplate {
+	t.Helper()
+	dispatch(t, s, cs, protocol.TypeProcessTemplateCreate, payload)
+	var created protocol.ProcessTemplateCreateResultPayload
+	readPayload(t, conn, protocol.TypeProcessTemplateCreateResult, &created)
+	if !created.Success || created.Template == nil {
+		t.Fatalf("create failed: %+v", created)
+	}
+	return created.Template
+}
+
+func TestProcessTemplateCRUD(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+
+	tmpl := createTemplate(t, s, conn, cs, protocol.ProcessTemplateCreatePayload{
+		Name: "api",
+		CWD:  strPtr("/work/api"),
+		Env: []protocol.EnvVar{
+			{Key: "REGION", Value: "eu"},
+			{Key: "API_TOKEN", Value: "sk-live"},
+		},
+		ClaudeArgs:      strPtr("--model opus"),
+		AutoStartClaude: true,
+	})
+	if tmpl.HostID != nil || tmpl.CWD == nil || *tmpl.CWD != "/work/api" || !tmpl.AutoStartClaude {
+		t.Errorf("created = %+v", tmpl)
+	}
+	if len(tmpl.Env) != 2 || tmpl.Env[1].Value == "sk-live" || !tmpl.Env[1].IsMasked {
+		t.Errorf("env = %+v, want the token masked", tmpl.Env)
+	}
+
+	// Sending the masked env back keeps the token; an empty string clears
+	// the working directory
+	env := append(tmpl.Env, protocol.EnvVar{Key: "DEBUG", Value: "1"})
+	dispatch(t, s, cs, protocol.TypeProcessTemplateUpdate, protocol.ProcessTemplateUpdatePayload{
+		ID: tmpl.ID, Env: &env, CWD: strPtr(""),
+	})
+	var updated protocol.ProcessTemplateUpdateResultPayload
+	readPayload(t, conn, protocol.TypeProcessTemplateUpdateResult, &updated)
+	if !updated.Success || updated.Template.CWD != nil || len(updated.Template.Env) != 3 {
+		t.Fatalf("update = %+v", updated)
+	}
+	stored, _ := s.storage.GetProcessTemplate(tmpl.ID)
+	if stored.EnvVars[1].Value != "sk-live" || stored.EnvVars[2].Value != "1" || stored.Name != "api" {
+		t.Errorf("stored = %+v", stored)
+	}
+
+	for _, bad := range []protocol.ProcessTemplateUpdatePayload{
+		{ID: tmpl.ID, ClaudeArgs: strPtr("--resume; rm -rf ~")},
+		{ID: tmpl.ID, Env: &[]protocol.EnvVar{{Key: "NEW_SECRET", Value: "********", IsMasked: true}}},
+		{ID: tmpl.ID, Env: &[]protocol.EnvVar{{Key: "1BAD", Value: "x"}}},
+		{ID: "missing", Name: strPtr("x")},
+	} {
+		dispatch(t, s, cs, protocol.TypeProcessTemplateUpdate, bad)
+		var rejected protocol.ProcessTemplateUpdateResultPayload
+		readPayload(t, conn, protocol.TypeProcessTemplateUpdateResult, &rejected)
+		if rejected.Success || rejected.Error == nil {
+			t.Errorf("update %+v was accepted", bad)
+		}
+	}
+
+	dispatch(t, s, cs, protocol.TypeProcessTemplateList, protocol.ProcessTemplateListPayload{})
+	var list protocol.ProcessTemplateListResultPayload
+	readPayload(t, conn, protocol.TypeProcessTemplateListResult, &list)
+	if len(list.Templates) != 1 || list.Templates[0].ID != tmpl.ID {
+		t.Errorf("list = %+v", list.Templates)
+	}
+
+	dispatch(t, s, cs, protocol.TypeProcessTemplateDelete, protocol.ProcessTemplateDeletePayload{ID: tmpl.ID})
+	var deleted protocol.ProcessTemplateDeleteResultPayload
+	readPayload(t, conn, protocol.TypeProcessTemplateDeleteResult, &deleted)
+	if !deleted.Success || deleted.ID == nil || *deleted.ID != tmpl.ID {
+		t.Errorf("delete = %+v", deleted)
+	}
+	if stored, _ := s.storage.GetProcessTemplate(tmpl.ID); stored != nil {
+		t.Errorf("template still stored: %+v", stored)
+	}
+}
+
+func TestProcessCreateFromTemplate(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("FAKE_TMUX_NEW", t.TempDir())
+	t.Setenv("FAKE_TMUX_HOLD", "10")
+	keys := recordTmuxKeys(t)
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+
+	tmpl := createTemplate(t, s, conn, cs, protocol.ProcessTemplateCreatePayload{
+		Name:            "api",
+		HostID:          strPtr("host-1"),
+		CWD:             strPtr("/work/api"),
+		Env:             []protocol.EnvVar{{Key: "REGION", Value: "eu"}, {Key: "API_TOKEN", Value: "sk-live"}},
+		Shell:           strPtr("zsh -l"),
+		ClaudeArgs:      strPtr("--model opus"),
+		AutoStartClaude: true,
+	})
+
+	// Overrides replace the template's values by key; a masked one is the
+	// template's own
+	dispatch(t, s, cs, protocol.TypeProcessCreateFromTemplate, protocol.ProcessCreateFromTemplatePayload{
+		TemplateID: tmpl.ID,
+		Env: []protocol.EnvVar{
+			{Key: "REGION", Value: "us"},
+			{Key: "API_TOKEN", Value: "********", IsMasked: true},
+			{Key: "DEBUG", Value: "1"},
+		},
+	})
+
+	var created protocol.ProcessCreatedPayload
+	readPayload(t, conn, protocol.TypeProcessCreated, &created)
+	proc := s.processRegistry.Get(created.Process.ID)
+	t.Cleanup(proc.ClearAgentClients)
+	if created.Process.Type != protocol.ProcessTypeShell {
+		t.Errorf("created as %s, want a shell first", created.Process.Type)
+	}
+	var claude protocol.ProcessUpdatedPayload
+	readPayload(t, conn, protocol.TypeProcessUpdated, &claude)
+	if claude.Type != protocol.ProcessTypeClaude {
+		t.Errorf("process_updated = %+v, want type claude", claude)
+	}
+	var result protocol.ProcessCreateFromTemplateResultPayload
+	readPayload(t, conn, protocol.TypeProcessCreateFromTemplateResult, &result)
+	want := []string{protocol.TemplateStageResolve, protocol.TemplateStageCreate, protocol.TemplateStageClaude}
+	if !result.Success || !slices.Equal(result.Stages, want) || result.FailedStage != nil {
+		t.Errorf("result = %+v", result)
+	}
+	if result.Process == nil || result.Process.ID != proc.ID || result.Process.Type != protocol.ProcessTypeClaude {
+		t.Errorf("result process = %+v", result.Process)
+	}
+
+	args := newSessionArgs(t, proc.ID)
+	if got := flagValues(args, "-c"); !slices.Equal(got, []string{"/work/api"}) {
+		t.Errorf("new-session -c = %v", got)
+	}
+	// Secret or not, the vars are exported in the shell, never on a command
+	// line
+	expectEnvInjected(t, keys, home, proc.ID, []string{"export REGION=us", "export API_TOKEN=sk-live", "export DEBUG=1"}, "sk-live")
+	if !slices.Contains(args, "zsh -l") {
+		t.Errorf("new-session doesn't run the template's shell: %v", args)
+	}
+}
+
+func TestProcessCreateFromTemplateFailsMidway(t *testing.T) {
+	t.Setenv("FAKE_TMUX_HOLD", "10")
+	config := DefaultConfig()
+	config.CWDRefreshInterval = 0
+	config.TmuxProbeInterval = 0
+	config.PortRange = process.PortRange{Min: 40000, Max: 40000}
+	s := newTestServer(t, config)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+
+	tmpl := createTemplate(t, s, conn, cs, protocol.ProcessTemplateCreatePayload{Name: "claude", AutoStartClaude: true})
+
+	// A template without a host needs one from the request
+	dispatch(t, s, cs, protocol.TypeProcessCreateFromTemplate, protocol.ProcessCreateFromTemplatePayload{TemplateID: tmpl.ID})
+	var unscoped protocol.ProcessCreateFromTemplateResultPayload
+	readPayload(t, conn, protocol.TypeProcessCreateFromTemplateResult, &unscoped)
+	if unscoped.Success || unscoped.FailedStage == nil || *unscoped.FailedStage != protocol.TemplateStageResolve ||
+		unscoped.ErrorCode == nil || *unscoped.ErrorCode != protocol.ErrorInvalidArgs || len(unscoped.Stages) != 0 {
+		t.Errorf("unscoped result = %+v", unscoped)
+	}
+
+	// With the only port taken, Claude can't start, but the shell stays
+	if _, err := s.processRegistry.AllocatePort(); err != nil {
+		t.Fatalf("AllocatePort: %v", err)
+	}
+	dispatch(t, s, cs, protocol.TypeProcessCreateFromTemplate, protocol.ProcessCreateFromTemplatePayload{
+		TemplateID: tmpl.ID, HostID: strPtr("host-1"),
+	})
+	var created protocol.ProcessCreatedPayload
+	readPayload(t, conn, protocol.TypeProcessCreated, &created)
+	var result protocol.ProcessCreateFromTemplateResultPayload
+	readPayload(t, conn, protocol.TypeProcessCreateFromTemplateResult, &result)
+	if result.Success || result.FailedStage == nil || *result.FailedStage != protocol.TemplateStageClaude ||
+		result.ErrorCode == nil || *result.ErrorCode != protocol.ErrorNoPorts || result.Error == nil {
+		t.Errorf("result = %+v", result)
+	}
+	if want := []string{protocol.TemplateStageResolve, protocol.TemplateStageCreate}; !slices.Equal(result.Stages, want) {
+		t.Errorf("stages = %v, want %v", result.Stages, want)
+	}
+	if result.Process == nil || result.Process.ID != created.Process.ID {
+		t.Errorf("result process = %+v, want the created shell", result.Process)
+	}
+	if proc := s.processRegistry.Get(created.Process.ID); proc == nil || proc.Type != process.TypeShell {
+		t.Errorf("shell not kept after the failed stage: %+v", proc)
+	}
+	expectNothingQueued(t, conn, cs)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+)
+
+// handleProcessTermOptions sets tmux options of a process's session. The
+// options are stored with the process metadata, so reattaching applies them
+// again instead of the defaults.
+func (s *Server) handleProcessTermOptions(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ProcessTermOptionsPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [PROCESS] Terminal options request: processId=%s options=%v", payload.ProcessID, payload.Options)
+
+	proc := s.processRegistry.Get(payload.ProcessID)
+	if proc == nil {
+		return connSession.sendProcessNotFound(payload.ProcessID)
+	}
+
+	changes, err := pty.ValidateTermOptions(payload.Options)
+	if err != nil {
+		var optErr *pty.TermOptionError
+		errors.As(err, &optErr)
+		return connSession.SendErrorDetails(protocol.ErrorInvalidArgs, protocol.InvalidArgsDetails{
+			Tokens: []string{optErr.Name},
+			Reason: optErr.Reason,
+		})
+	}
+
+	if proc.PTY == nil {
+		return connSession.SendErrorDetails(protocol.ErrorNoPty, protocol.ErrorDetails{"processId": payload.ProcessID})
+	}
+
+	options := pty.MergeTermOptions(proc.GetTermOptions(), changes)
+	if err := proc.PTY.SetTermOptions(options); err != nil {
+		log.Printf("[ERROR] [PROCESS] Failed to set terminal options of process %s: %v", payload.ProcessID, err)
+		return connSession.SendErrorDetails(protocol.ErrorPtyError, protocol.ErrorDetails{"processId": payload.ProcessID, "reason": err.Error()})
+	}
+	proc.SetTermOptions(options)
+
+	// The session has the options now; failing to store them only loses
+	// them on reattach
+	if err := s.storage.SetProcessTermOptions(payload.ProcessID, options); err != nil {
+		log.Printf("[WARN] [PROCESS] Failed to save terminal options of process %s: %v", payload.ProcessID, err)
+	}
+
+	return s.notifyProcessUpdated(connSession, proc)
+}
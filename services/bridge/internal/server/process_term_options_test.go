@@ -0,0 +1,171 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// recordTmuxOptions makes the fake tmux record its set-option calls, and
+// returns a function reading the last one
+func recordTmuxOptions(t *testing.T) func() string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "options")
+	t.Setenv("FAKE_TMUX_OPTIONS", path)
+	return func() string {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("no set-option call recorded: %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		return lines[len(lines)-1]
+	}
+}
+
+func TestProcessTermOptions(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	lastSet := recordTmuxOptions(t)
+	shellTestHost(t, s)
+	registerShells(t, s, 1)
+	if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{ProcessID: "proc-0", HostID: "host-1", ProcessType: "shell",
+		TmuxName: "rc-proc-0", StartedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+
+	var updated protocol.ProcessUpdatedPayload
+	dispatch(t, s, cs, protocol.TypeProcessTermOptions, protocol.ProcessTermOptionsPayload{
+		ProcessID: "proc-0", Options: map[string]string{"mouse": "on", "history-limit": "05000"},
+	})
+	readPayload(t, conn, protocol.TypeProcessUpdated, &updated)
+	want := map[string]string{"status": "off", "mouse": "on", "history-limit": "5000"}
+	if !maps.Equal(updated.TermOptions, want) {
+		t.Errorf("termOptions = %v, want %v", updated.TermOptions, want)
+	}
+	if got, want := lastSet(), "set-option -t rc-proc-0 status off ; set-option -t rc-proc-0 mouse on ; set-option -t rc-proc-0 history-limit 5000"; got != want {
+		t.Errorf("tmux ran %q, want %q", got, want)
+	}
+
+	// Options not listed are kept, and an empty value resets one
+	updated = protocol.ProcessUpdatedPayload{}
+	dispatch(t, s, cs, protocol.TypeProcessTermOptions, protocol.ProcessTermOptionsPayload{
+		ProcessID: "proc-0", Options: map[string]string{"status": "on", "mouse": ""},
+	})
+	readPayload(t, conn, protocol.TypeProcessUpdated, &updated)
+	want = map[string]string{"status": "on", "history-limit": "5000"}
+	if !maps.Equal(updated.TermOptions, want) {
+		t.Errorf("termOptions = %v, want %v", updated.TermOptions, want)
+	}
+	if got := lastSet(); !strings.Contains(got, "set-option -u -t rc-proc-0 mouse") {
+		t.Errorf("tmux ran %q, want mouse unset", got)
+	}
+
+	// Only the chosen options are stored, not the defaults
+	meta, err := s.storage.GetProcessMetadata("proc-0")
+	if err != nil || meta == nil {
+		t.Fatalf("GetProcessMetadata: %v", err)
+	}
+	if !maps.Equal(meta.TermOptions, want) {
+		t.Errorf("stored options = %v, want %v", meta.TermOptions, want)
+	}
+}
+
+func TestProcessTermOptionsRejects(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	sessions := shellTestHost(t, s)
+	registerShells(t, s, 1)
+
+	for _, options := range []map[string]string{
+		{"status; run-shell 'touch pwned'": "on"},
+		{"default-command": "sh"},
+		{"status": "on; kill-server"},
+		{"mouse": "yes"},
+		{"history-limit": "-1"},
+		{"history-limit": fmt.Sprint(pty.MaxHistoryLimit + 1)},
+		{"history-limit": `5000 \; kill-server`},
+	} {
+		dispatch(t, s, cs, protocol.TypeProcessTermOptions, protocol.ProcessTermOptionsPayload{ProcessID: "proc-0", Options: options})
+		var errPayload struct {
+			Code    protocol.ErrorCode          `json:"code"`
+			Details protocol.InvalidArgsDetails `json:"details"`
+		}
+		readPayload(t, conn, protocol.TypeError, &errPayload)
+		if errPayload.Code != protocol.ErrorInvalidArgs || len(errPayload.Details.Tokens) != 1 {
+			t.Errorf("%v: %+v", options, errPayload)
+		}
+	}
+	if got := atomic.LoadInt32(sessions); got != 0 {
+		t.Errorf("rejected options ran %d commands on the host", got)
+	}
+
+	dispatch(t, s, cs, protocol.TypeProcessTermOptions, protocol.ProcessTermOptionsPayload{ProcessID: "proc-9", Options: map[string]string{"mouse": "on"}})
+	var errPayload protocol.ErrorPayload
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotFound {
+		t.Errorf("unknown process: %s", errPayload.Code)
+	}
+}
+
+func TestReattachReappliesTermOptions(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	lastSet := recordTmuxOptions(t)
+	shellTestHost(t, s)
+
+	created := time.Unix(fakeTmuxCreated, 0)
+	for i, shellPID := range []int{fakeTmuxPID, 1111} {
+		processID := fmt.Sprintf("00000000-0000-4000-8000-%012d", i)
+		tmuxName := pty.TmuxSessionName(processID)
+		if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{ProcessID: processID, HostID: "host-1", ProcessType: "shell",
+			TmuxName: tmuxName, ShellPID: shellPID, StartedAt: created.Add(2 * time.Second)}); err != nil {
+			t.Fatalf("SaveProcessMetadata: %v", err)
+		}
+		options := map[string]string{"status": "on", "mouse": "on"}
+		if err := s.storage.SetProcessTermOptions(processID, options); err != nil {
+			t.Fatalf("SetProcessTermOptions: %v", err)
+		}
+
+		dispatch(t, s, cs, protocol.TypeProcessReattach, protocol.ProcessReattachPayload{
+			HostID: "host-1", TmuxSession: tmuxName, ProcessID: processID,
+		})
+		readPayload(t, conn, protocol.TypeProcessAdded, nil)
+		readPayload(t, conn, protocol.TypeStaleProcessesChanged, nil)
+		proc := s.processRegistry.Get(processID)
+		if proc == nil {
+			t.Fatal("process not registered")
+		}
+
+		if shellPID == fakeTmuxPID {
+			// The stored options replace the defaults
+			if got := lastSet(); !strings.HasPrefix(got, "set-option -t "+tmuxName+" status on ; set-option -t "+tmuxName+" mouse on ;") {
+				t.Errorf("reattach ran %q, want the stored options", got)
+			}
+			if got := proc.ToInfo().TermOptions; !maps.Equal(got, options) {
+				t.Errorf("termOptions = %v, want %v", got, options)
+			}
+			continue
+		}
+
+		// Another session under the process's name goes back to the defaults
+		if got := lastSet(); !strings.HasPrefix(got, "set-option -t "+tmuxName+" status off ; set-option -u -t "+tmuxName+" mouse") {
+			t.Errorf("discarded metadata: tmux ran %q, want the defaults", got)
+		}
+		if got := proc.ToInfo().TermOptions; !maps.Equal(got, map[string]string{"status": "off"}) {
+			t.Errorf("discarded metadata: termOptions = %v", got)
+		}
+		if meta, err := s.storage.GetProcessMetadata(processID); err != nil || meta == nil || meta.TermOptions != nil {
+			t.Errorf("discarded metadata: stored %+v, %v", meta, err)
+		}
+	}
+}
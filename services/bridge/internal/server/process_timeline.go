@@ -0,0 +1,219 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/timeline"
+)
+
+// timelineShellError reports a pane the timeline hooks can't be typed into
+type timelineShellError struct {
+	command string // What tmux says runs in the pane
+	err     error  // timeline.ErrUnsupportedShell or timeline.ErrNotAtPrompt
+}
+
+func (e *timelineShellError) Error() string {
+	return fmt.Sprintf("%v (%s)", e.err, e.command)
+}
+
+func (e *timelineShellError) Unwrap() error {
+	return e.err
+}
+
+// setTimeline loads the command timeline hooks into a process's shell, or
+// removes them, by typing a line at its prompt
+func (s *Server) setTimeline(proc *process.Process, enabled bool) error {
+	command, err := proc.PTY.PaneCommand()
+	if err != nil {
+		return err
+	}
+	shell, err := timeline.Shell(command)
+	if err != nil {
+		if !enabled {
+			// Whatever runs in the pane, the hooks are in a shell below it
+			err = timeline.ErrNotAtPrompt
+		}
+		return &timelineShellError{command: command, err: err}
+	}
+
+	if !enabled {
+		if err := proc.PTY.SendLine(timeline.DisableLine); err != nil {
+			return err
+		}
+		proc.SetTimeline(false)
+		return nil
+	}
+
+	conn := s.sshManager.GetConnection(proc.HostID)
+	if conn == nil {
+		return fmt.Errorf("host %s is not connected", proc.HostID)
+	}
+	results, err := conn.RunBatch([]string{timeline.SaveCommand(shell)})
+	if err != nil {
+		return err
+	}
+	if !results[0].OK() {
+		return fmt.Errorf("failed to save %s hooks: exit status %d", shell, results[0].ExitCode)
+	}
+	// On before the hooks load, so their first markers are recorded
+	proc.SetTimeline(true)
+	if err := proc.PTY.SendLine(timeline.EnableLine(shell)); err != nil {
+		proc.SetTimeline(false)
+		return err
+	}
+	return nil
+}
+
+// handleProcessEnableTimeline turns a process's command timeline on or off.
+// The setting is stored with the process metadata, so reattaching keeps
+// recording; the hooks themselves live in the shell.
+func (s *Server) handleProcessEnableTimeline(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ProcessEnableTimelinePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [TIMELINE] Enable request: processId=%s disable=%v", payload.ProcessID, payload.Disable)
+
+	proc := s.processRegistry.Get(payload.ProcessID)
+	if proc == nil {
+		return connSession.sendProcessNotFound(payload.ProcessID)
+	}
+	if proc.PTY == nil {
+		return connSession.SendErrorDetails(protocol.ErrorNoPty, protocol.ErrorDetails{"processId": payload.ProcessID})
+	}
+	if s.storage == nil {
+		return connSession.SendErrorDetails(protocol.ErrorStorageError, protocol.ErrorDetails{"processId": payload.ProcessID, "reason": "command timeline is not stored"})
+	}
+
+	enabled := !payload.Disable
+	if err := s.setTimeline(proc, enabled); err != nil {
+		return s.sendTimelineError(connSession, proc, err)
+	}
+	if err := s.storage.SetProcessTimeline(payload.ProcessID, enabled); err != nil {
+		log.Printf("[WARN] [TIMELINE] Failed to save timeline setting of process %s: %v", payload.ProcessID, err)
+	}
+	log.Printf("[INFO] [TIMELINE] Set timeline of process %s: enabled=%v", payload.ProcessID, enabled)
+
+	return s.notifyProcessUpdated(connSession, proc)
+}
+
+// sendTimelineError reports why a process's timeline couldn't be set
+func (s *Server) sendTimelineError(connSession *ConnectedSession, proc *process.Process, err error) error {
+	var shellErr *timelineShellError
+	if errors.As(err, &shellErr) {
+		if errors.Is(err, timeline.ErrUnsupportedShell) {
+			return connSession.SendErrorDetails(protocol.ErrorUnsupportedShell,
+				protocol.ErrorDetails{"processId": proc.ID, "shell": shellErr.command, "reason": err.Error()})
+		}
+		return connSession.SendErrorDetails(protocol.ErrorInvalidState,
+			protocol.ErrorDetails{"processId": proc.ID, "processType": proc.GetType(), "command": shellErr.command, "reason": err.Error()})
+	}
+	log.Printf("[ERROR] [TIMELINE] Failed to set timeline of process %s: %v", proc.ID, err)
+	return connSession.SendErrorDetails(protocol.ErrorPtyError, protocol.ErrorDetails{"processId": proc.ID, "reason": err.Error()})
+}
+
+// enableTimelineAtStart turns on the timeline of a process created with it,
+// once its shell has had a moment to start
+func (s *Server) enableTimelineAtStart(connSession *ConnectedSession, proc *process.Process) {
+	time.Sleep(200 * time.Millisecond)
+
+	if err := s.setTimeline(proc, true); err != nil {
+		log.Printf("[WARN] [TIMELINE] Could not turn on timeline of new process %s: %v", proc.ID, err)
+		return
+	}
+	if err := s.storage.SetProcessTimeline(proc.ID, true); err != nil {
+		log.Printf("[WARN] [TIMELINE] Failed to save timeline setting of process %s: %v", proc.ID, err)
+	}
+	if err := s.notifyProcessUpdated(connSession, proc); err != nil {
+		log.Printf("[WARN] [TIMELINE] Failed to send process update: %v", err)
+	}
+}
+
+// timelineRecorder stores the commands a process's output reports
+type timelineRecorder struct {
+	mu        sync.Mutex
+	tracker   timeline.Tracker
+	processID string
+	store     *storage.Store
+}
+
+// record parses a chunk of output and, if enabled, stores the commands it
+// starts and ends
+func (r *timelineRecorder) record(data []byte, enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := r.tracker.Feed(data)
+	if !enabled {
+		return
+	}
+	for _, event := range events {
+		var err error
+		switch event.Kind {
+		case timeline.EventStarted:
+			err = r.store.StartTimelineCommand(r.processID, event.Command, time.Now())
+		case timeline.EventFinished:
+			err = r.store.FinishTimelineCommand(r.processID, time.Now(), event.ExitCode)
+		}
+		if err != nil {
+			log.Printf("[WARN] [TIMELINE] Failed to store command of process %s: %v", r.processID, err)
+		}
+	}
+}
+
+// handleProcessTimelineList sends a page of the commands run in a process.
+// The process may be gone: its timeline is kept until it is killed without
+// its history, or its archive is deleted.
+func (s *Server) handleProcessTimelineList(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ProcessTimelineListPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [TIMELINE] List request: processId=%s limit=%d", payload.ProcessID, payload.Limit)
+
+	result := protocol.ProcessTimelineListResultPayload{ProcessID: payload.ProcessID, Commands: []protocol.TimelineCommand{}}
+	if s.storage == nil {
+		result.Code = protocol.ErrorStorageError
+		result.Error = strPtr("command timeline is not stored")
+	} else {
+		var before int64
+		if payload.Before != nil {
+			before = *payload.Before
+		}
+		commands, hasMore, err := s.storage.ListTimelineCommands(payload.ProcessID, before, payload.Limit)
+		if err != nil {
+			log.Printf("[WARN] [TIMELINE] List failed: %v", err)
+			result.Code = protocol.ErrorStorageError
+			result.Error = strPtr(err.Error())
+		}
+		result.HasMore = hasMore
+		for _, c := range commands {
+			cmd := protocol.TimelineCommand{
+				ID:        c.ID,
+				Command:   c.Command,
+				StartedAt: c.StartedAt.Format(time.RFC3339Nano),
+				ExitCode:  c.ExitCode,
+			}
+			if c.EndedAt != nil {
+				cmd.EndedAt = strPtr(c.EndedAt.Format(time.RFC3339Nano))
+			}
+			result.Commands = append(result.Commands, cmd)
+		}
+	}
+
+	response, err := protocol.NewMessage(protocol.TypeProcessTimelineListResult, result)
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/timeline"
+)
+
+// recordTmuxKeys makes the fake tmux record its send-keys calls, and
+// returns a function reading them
+func recordTmuxKeys(t *testing.T) func() string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	t.Setenv("FAKE_TMUX_KEYS", path)
+	return func() string {
+		t.Helper()
+		data, _ := os.ReadFile(path)
+		return string(data)
+	}
+}
+
+func TestProcessEnableTimeline(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	keys := recordTmuxKeys(t)
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 1)
+	if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{ProcessID: "proc-0", HostID: "host-1", ProcessType: "shell",
+		TmuxName: "rc-proc-0", StartedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+
+	var updated protocol.ProcessUpdatedPayload
+	dispatch(t, s, cs, protocol.TypeProcessEnableTimeline, protocol.ProcessEnableTimelinePayload{ProcessID: "proc-0"})
+	readPayload(t, conn, protocol.TypeProcessUpdated, &updated)
+	if !updated.Timeline {
+		t.Error("timeline not reported on")
+	}
+	// The hooks are saved on the host and loaded by typing at the prompt
+	if _, err := os.Stat(filepath.Join(home, ".cache/remote-claude/timeline.bash")); err != nil {
+		t.Errorf("hooks not saved: %v", err)
+	}
+	if got := keys(); !strings.Contains(got, "-t rc-proc-0 -l "+timeline.EnableLine("bash")+" ; send-keys -t rc-proc-0 Enter") {
+		t.Errorf("typed %q, want the line loading the hooks", got)
+	}
+	if meta, err := s.storage.GetProcessMetadata("proc-0"); err != nil || !meta.Timeline {
+		t.Errorf("stored setting = %+v, %v", meta, err)
+	}
+
+	updated = protocol.ProcessUpdatedPayload{}
+	dispatch(t, s, cs, protocol.TypeProcessEnableTimeline, protocol.ProcessEnableTimelinePayload{ProcessID: "proc-0", Disable: true})
+	readPayload(t, conn, protocol.TypeProcessUpdated, &updated)
+	if updated.Timeline {
+		t.Error("timeline still reported on")
+	}
+	if got := keys(); !strings.Contains(got, "-l "+timeline.DisableLine+" ;") {
+		t.Errorf("typed %q, want the line removing the hooks", got)
+	}
+	if meta, err := s.storage.GetProcessMetadata("proc-0"); err != nil || meta.Timeline {
+		t.Errorf("stored setting = %+v, %v", meta, err)
+	}
+}
+
+func TestProcessEnableTimelineRejects(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	keys := recordTmuxKeys(t)
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 1)
+
+	var errPayload struct {
+		Code    protocol.ErrorCode    `json:"code"`
+		Details protocol.ErrorDetails `json:"details"`
+	}
+	t.Setenv("FAKE_TMUX_COMMAND", "fish")
+	dispatch(t, s, cs, protocol.TypeProcessEnableTimeline, protocol.ProcessEnableTimelinePayload{ProcessID: "proc-0"})
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorUnsupportedShell || errPayload.Details["shell"] != "fish" {
+		t.Errorf("unsupported shell: %+v", errPayload)
+	}
+
+	// Typing would go to the program, not the shell
+	t.Setenv("FAKE_TMUX_COMMAND", "vim")
+	for _, disable := range []bool{false, true} {
+		dispatch(t, s, cs, protocol.TypeProcessEnableTimeline, protocol.ProcessEnableTimelinePayload{ProcessID: "proc-0", Disable: disable})
+		readPayload(t, conn, protocol.TypeError, &errPayload)
+		if errPayload.Code != protocol.ErrorInvalidState || errPayload.Details["command"] != "vim" {
+			t.Errorf("busy shell (disable=%v): %+v", disable, errPayload)
+		}
+	}
+	if got := keys(); got != "" {
+		t.Errorf("typed %q into a pane without a supported shell", got)
+	}
+	if s.processRegistry.Get("proc-0").TimelineEnabled() {
+		t.Error("timeline turned on")
+	}
+
+	dispatch(t, s, cs, protocol.TypeProcessEnableTimeline, protocol.ProcessEnableTimelinePayload{ProcessID: "proc-9"})
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotFound {
+		t.Errorf("unknown process: %s", errPayload.Code)
+	}
+}
+
+func TestTimelineRecorder(t *testing.T) {
+	s := newQuietServer(t)
+	recorder := &timelineRecorder{processID: "proc-0", store: s.storage}
+
+	recorder.record([]byte("\x1b]633;E;ls\a\x1b]133;C\afile\r\n\x1b]133;D;0\a"), false)
+	recorder.record([]byte("\x1b]133;A\a$ \x1b]633;E;make\a\x1b]1"), true)
+	recorder.record([]byte("33;C\abuilding\r\n\x1b]133;D;2\a\x1b]133;A\a$ "), true)
+
+	commands, _, err := s.storage.ListTimelineCommands("proc-0", 0, 0)
+	if err != nil {
+		t.Fatalf("ListTimelineCommands: %v", err)
+	}
+	// Only commands seen while recording are stored
+	if len(commands) != 1 || commands[0].Command != "make" || commands[0].ExitCode == nil || *commands[0].ExitCode != 2 {
+		t.Errorf("commands = %+v", commands)
+	}
+}
+
+func TestProcessTimelineList(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+
+	start := time.UnixMilli(1700000000123)
+	for i, command := range []string{"make", "make test", "git push"} {
+		if err := s.storage.StartTimelineCommand("proc-0", command, start.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("StartTimelineCommand: %v", err)
+		}
+		if i < 2 {
+			code := i
+			if err := s.storage.FinishTimelineCommand("proc-0", start.Add(time.Duration(i)*time.Minute+time.Second), &code); err != nil {
+				t.Fatalf("FinishTimelineCommand: %v", err)
+			}
+		}
+	}
+
+	var page protocol.ProcessTimelineListResultPayload
+	dispatch(t, s, cs, protocol.TypeProcessTimelineList, protocol.ProcessTimelineListPayload{ProcessID: "proc-0", Limit: 2})
+	readPayload(t, conn, protocol.TypeProcessTimelineListResult, &page)
+	if page.Error != nil || !page.HasMore || len(page.Commands) != 2 {
+		t.Fatalf("first page = %+v", page)
+	}
+	if running := page.Commands[0]; running.Command != "git push" || running.EndedAt != nil || running.ExitCode != nil {
+		t.Errorf("running command = %+v", running)
+	}
+	done := page.Commands[1]
+	if done.Command != "make test" || done.ExitCode == nil || *done.ExitCode != 1 || done.EndedAt == nil {
+		t.Fatalf("finished command = %+v", done)
+	}
+	startedAt, err := time.Parse(time.RFC3339Nano, done.StartedAt)
+	if err != nil || !startedAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("startedAt = %q, %v", done.StartedAt, err)
+	}
+
+	page = protocol.ProcessTimelineListResultPayload{}
+	dispatch(t, s, cs, protocol.TypeProcessTimelineList, protocol.ProcessTimelineListPayload{ProcessID: "proc-0", Before: &done.ID, Limit: 2})
+	readPayload(t, conn, protocol.TypeProcessTimelineListResult, &page)
+	if page.HasMore || len(page.Commands) != 1 || page.Commands[0].Command != "make" {
+		t.Errorf("last page = %+v", page)
+	}
+
+	page = protocol.ProcessTimelineListResultPayload{}
+	dispatch(t, s, cs, protocol.TypeProcessTimelineList, protocol.ProcessTimelineListPayload{ProcessID: "proc-9"})
+	readPayload(t, conn, protocol.TypeProcessTimelineListResult, &page)
+	if page.Commands == nil || len(page.Commands) != 0 || page.HasMore {
+		t.Errorf("unknown process = %+v", page)
+	}
+}
+
+func TestReattachKeepsRecordingTimeline(t *testing.T) {
+	s := newQuietServer(t)
+	_, cs := connectTestClient(t, s)
+	output := filepath.Join(t.TempDir(), "output")
+	if err := os.WriteFile(output, []byte("\x1b]633;E;sleep 1\a\x1b]133;C\a\x1b]133;D;0\a\x1b]133;A\a$ "), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAKE_TMUX_OUTPUT", output)
+	shellTestHost(t, s)
+
+	processID := "00000000-0000-4000-8000-000000000000"
+	tmuxName := pty.TmuxSessionName(processID)
+	if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{ProcessID: processID, HostID: "host-1", ProcessType: "shell",
+		TmuxName: tmuxName, ShellPID: fakeTmuxPID, StartedAt: time.Unix(fakeTmuxCreated+2, 0)}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	if err := s.storage.SetProcessTimeline(processID, true); err != nil {
+		t.Fatalf("SetProcessTimeline: %v", err)
+	}
+
+	dispatch(t, s, cs, protocol.TypeProcessReattach, protocol.ProcessReattachPayload{
+		HostID: "host-1", TmuxSession: tmuxName, ProcessID: processID,
+	})
+	if proc := s.processRegistry.Get(processID); proc == nil || !proc.ToInfo().Timeline {
+		t.Fatal("reattached process isn't recording its timeline")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		commands, _, err := s.storage.ListTimelineCommands(processID, 0, 0)
+		if err != nil {
+			t.Fatalf("ListTimelineCommands: %v", err)
+		}
+		if len(commands) == 1 && commands[0].Command == "sleep 1" && commands[0].EndedAt != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("commands = %+v, want the one in the output", commands)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// ============================================================================
+// Process State Pushes
+// ============================================================================
+
+// handleProcessesSubscribe starts pushing process state changes on a host to
+// the session and replies with the current process list
+func (s *Server) handleProcessesSubscribe(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ProcessesSubscribePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [PROCESS] Subscribe: hostId=%s session=%s", payload.HostID, connSession.ID)
+	connSession.SubscribeProcesses(payload.HostID)
+
+	// Cached state only; the CWD refresher keeps it current from here on
+	return s.sendProcessList(connSession, payload.HostID, false, false)
+}
+
+// handleProcessesUnsubscribe stops process state pushes for a host
+func (s *Server) handleProcessesUnsubscribe(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.ProcessesUnsubscribePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [PROCESS] Unsubscribe: hostId=%s session=%s", payload.HostID, connSession.ID)
+	connSession.UnsubscribeProcesses(payload.HostID)
+	return nil
+}
+
+// eachProcessSubscriber calls fn with every connected session subscribed to
+// the host, except one
+func (s *Server) eachProcessSubscriber(hostID string, except *ConnectedSession, fn func(target *ConnectedSession)) {
+	for _, sess := range s.sessionManager.GetConnectedSessions() {
+		if (except != nil && sess.ID == except.ID) || !sess.IsSubscribedToProcesses(hostID) {
+			continue
+		}
+		fn(&ConnectedSession{Session: sess, server: s})
+	}
+}
+
+// publishProcessMessage sends a state message about a process to every
+// connected session subscribed to its host whose scope allows the process,
+// except one that was already sent it
+func (s *Server) publishProcessMessage(hostID, processID string, msg *protocol.Message, except *ConnectedSession) {
+	s.eachProcessSubscriber(hostID, except, func(target *ConnectedSession) {
+		if !target.inScope(hostID, processID) {
+			return
+		}
+		if err := target.Send(msg); err != nil {
+			log.Printf("[ERROR] [PROCESS] Failed to push %s to session %s: %v", msg.Type, target.ID, err)
+		}
+	})
+}
+
+// notifyProcessUpdated sends process_updated to the session that made the
+// change (if any) and pushes it to the host's subscribers
+func (s *Server) notifyProcessUpdated(connSession *ConnectedSession, proc *process.Process) error {
+	msg, err := protocol.NewMessage(protocol.TypeProcessUpdated, processUpdatedPayload(proc.ToInfo()))
+	if err != nil {
+		return err
+	}
+	s.publishProcessMessage(proc.HostID, proc.ID, msg, connSession)
+	if connSession == nil {
+		return nil
+	}
+	return connSession.Send(msg)
+}
+
+// hostsWithClients returns the hosts that a connected session has connected
+// to or subscribed to
+func (s *Server) hostsWithClients() map[string]bool {
+	hosts := make(map[string]bool)
+	for _, sess := range s.sessionManager.GetConnectedSessions() {
+		for _, hostID := range s.sessionManager.GetSessionHostConnections(sess.ID) {
+			hosts[hostID] = true
+		}
+		for _, hostID := range sess.ProcessSubscriptions() {
+			hosts[hostID] = true
+		}
+	}
+	return hosts
+}
+
+// cwdChangeDebounce is the least time between two process_cwd_changed pushes
+// for one process; a directory left within it is never pushed
+var cwdChangeDebounce = 10 * time.Second
+
+// cwdRefreshLoop refreshes working directories every interval until the
+// server stops
+func (s *Server) cwdRefreshLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.refreshCWDs()
+		}
+	}
+}
+
+// refreshCWDs refreshes the cached working directory of every process on
+// hosts that have clients, in one batch per host, and pushes
+// process_cwd_changed for the processes whose directory changed (see
+// Process.CWDChange), storing the new one. The same query reads tmux's alert
+// flags, which are forwarded as process alerts, and the panes' foreground
+// programs, which tell whether Claude processes' terminals still show Claude.
+// Hosts nobody is watching aren't queried.
+func (s *Server) refreshCWDs() {
+	for hostID := range s.hostsWithClients() {
+		// Exited processes have no pane left to read
+		procs := slices.DeleteFunc(s.processRegistry.GetByHost(hostID), (*process.Process).HasExited)
+		if len(procs) == 0 || s.sshManager.GetConnection(hostID) == nil {
+			continue
+		}
+
+		before := make([]string, len(procs))
+		for i, proc := range procs {
+			before[i] = proc.GetCWD()
+		}
+		panes := process.RefreshCWDs(procs)
+		now := time.Now()
+		for i, proc := range procs {
+			if cwd, changed := proc.CWDChange(before[i], now, cwdChangeDebounce); changed {
+				s.notifyCWDChanged(proc, cwd)
+			}
+		}
+		s.forwardAlerts(hostID, procs, panes)
+		s.checkTerminalAttachments(procs, panes, now)
+	}
+}
+
+// notifyCWDChanged stores a process's new working directory and pushes it to
+// the host's subscribers
+func (s *Server) notifyCWDChanged(proc *process.Process, cwd string) {
+	log.Printf("[DEBUG] [PROCESS] CWD of %s changed to %s", proc.ID, cwd)
+	if s.storage != nil {
+		if err := s.storage.UpdateProcessCWD(proc.ID, cwd); err != nil {
+			log.Printf("[WARN] [PROCESS] Failed to store CWD of %s: %v", proc.ID, err)
+		}
+	}
+	msg, err := protocol.NewMessage(protocol.TypeProcessCWDChanged, protocol.ProcessCWDChangedPayload{ProcessID: proc.ID, CWD: cwd})
+	if err != nil {
+		log.Printf("[ERROR] [PROCESS] Failed to create CWD change: %v", err)
+		return
+	}
+	s.publishProcessMessage(proc.HostID, proc.ID, msg, nil)
+}
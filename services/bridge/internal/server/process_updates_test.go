@@ -0,0 +1,197 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// newQuietServer creates a test server without the background CWD refresher,
+// so only the test decides when the host is queried
+func newQuietServer(t *testing.T) *Server {
+	t.Helper()
+	config := DefaultConfig()
+	config.CWDRefreshInterval = 0
+	config.TmuxProbeInterval = 0
+	return newTestServer(t, config)
+}
+
+// registerShells registers n shell processes on host-1 with a cached CWD
+func registerShells(t *testing.T, s *Server, n int) {
+	t.Helper()
+	client := s.sshManager.GetConnection("host-1").Client
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("proc-%d", i)
+		ptySession := &pty.Session{ID: id, HostID: "host-1", TmuxName: pty.TmuxSessionName(id)}
+		ptySession.UpdateSSHClient(client)
+		s.processRegistry.Register(&process.Process{ID: id, HostID: "host-1", Type: process.TypeShell,
+			CWD: "/cached", PTY: ptySession})
+	}
+}
+
+func TestProcessListLight(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	sessions := shellTestHost(t, s)
+	registerShells(t, s, 3)
+
+	dispatch(t, s, cs, protocol.TypeProcessList, protocol.ProcessListPayload{HostID: "host-1", ListLight: true})
+	var light protocol.ProcessListResultPayload
+	readPayload(t, conn, protocol.TypeProcessListResult, &light)
+	if got := atomic.LoadInt32(sessions); got != 0 {
+		t.Errorf("light list opened %d SSH sessions, want 0", got)
+	}
+	if len(light.Processes) != 3 || light.Processes[0].CWD != "/cached" {
+		t.Errorf("light list = %+v, want 3 processes with the cached CWD", light.Processes)
+	}
+
+	// A full list still refreshes from tmux
+	dispatch(t, s, cs, protocol.TypeProcessList, protocol.ProcessListPayload{HostID: "host-1"})
+	var full protocol.ProcessListResultPayload
+	readPayload(t, conn, protocol.TypeProcessListResult, &full)
+	if atomic.LoadInt32(sessions) == 0 {
+		t.Error("full list didn't query the host")
+	}
+	for _, info := range full.Processes {
+		if want := "/home/user/" + pty.TmuxSessionName(info.ID); info.CWD != want {
+			t.Errorf("%s CWD = %q, want %q", info.ID, info.CWD, want)
+		}
+	}
+}
+
+func TestCWDRefresherSkipsHostsWithoutClients(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	sessions := shellTestHost(t, s)
+	registerShells(t, s, 2)
+
+	// The client is connected but neither connected to nor watching host-1
+	s.refreshCWDs()
+	if got := atomic.LoadInt32(sessions); got != 0 {
+		t.Errorf("refresher opened %d SSH sessions for a host without clients", got)
+	}
+	if cwd := s.processRegistry.Get("proc-0").GetCWD(); cwd != "/cached" {
+		t.Errorf("CWD = %q, want it left alone", cwd)
+	}
+
+	// Subscribing replies with the cached list, without querying the host
+	dispatch(t, s, cs, protocol.TypeProcessesSubscribe, protocol.ProcessesSubscribePayload{HostID: "host-1"})
+	var snapshot protocol.ProcessListResultPayload
+	readPayload(t, conn, protocol.TypeProcessListResult, &snapshot)
+	if len(snapshot.Processes) != 2 || atomic.LoadInt32(sessions) != 0 {
+		t.Errorf("subscribe snapshot = %+v after %d SSH sessions, want 2 cached processes", snapshot.Processes, atomic.LoadInt32(sessions))
+	}
+
+	// Now the host is refreshed in one batch and changes are pushed
+	s.refreshCWDs()
+	if got := atomic.LoadInt32(sessions); got != 1 {
+		t.Errorf("refresh opened %d SSH sessions, want 1", got)
+	}
+	for i := 0; i < 2; i++ {
+		var changed protocol.ProcessCWDChangedPayload
+		readPayload(t, conn, protocol.TypeProcessCWDChanged, &changed)
+		if want := "/home/user/" + pty.TmuxSessionName(changed.ProcessID); changed.CWD != want {
+			t.Errorf("change = %+v, want CWD %q", changed, want)
+		}
+	}
+
+	// Unchanged directories aren't pushed again
+	s.refreshCWDs()
+	expectNothingQueued(t, conn, cs)
+
+	dispatch(t, s, cs, protocol.TypeProcessesUnsubscribe, protocol.ProcessesUnsubscribePayload{HostID: "host-1"})
+	before := atomic.LoadInt32(sessions)
+	s.refreshCWDs()
+	if got := atomic.LoadInt32(sessions); got != before {
+		t.Errorf("refresher queried the host after the last client unsubscribed")
+	}
+}
+
+func TestProcessesSubscribePushesChanges(t *testing.T) {
+	s := newQuietServer(t)
+	watcher, watcherCS := connectTestClient(t, s)
+	other, otherCS := connectTestClient(t, s)
+	bystander, bystanderCS := connectTestClient(t, s)
+	s.processRegistry.Register(&process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeShell})
+
+	dispatch(t, s, watcherCS, protocol.TypeProcessesSubscribe, protocol.ProcessesSubscribePayload{HostID: "host-1"})
+	readPayload(t, watcher, protocol.TypeProcessListResult, &protocol.ProcessListResultPayload{})
+	dispatch(t, s, bystanderCS, protocol.TypeProcessesSubscribe, protocol.ProcessesSubscribePayload{HostID: "host-2"})
+	readPayload(t, bystander, protocol.TypeProcessListResult, &protocol.ProcessListResultPayload{})
+
+	// Another client's rename reaches the watcher, and the renaming client
+	// gets its usual reply once
+	dispatch(t, s, otherCS, protocol.TypeProcessRename, protocol.ProcessRenamePayload{ProcessID: "proc-1", Name: "api"})
+	var update protocol.ProcessUpdatedPayload
+	readPayload(t, watcher, protocol.TypeProcessUpdated, &update)
+	if update.ID != "proc-1" || update.Name == nil || *update.Name != "api" {
+		t.Errorf("pushed update = %+v, want proc-1 named api", update)
+	}
+	readPayload(t, other, protocol.TypeProcessUpdated, &update)
+	expectNothingQueued(t, other, otherCS)
+	expectNothingQueued(t, bystander, bystanderCS)
+
+	// Kills are pushed too
+	dispatch(t, s, otherCS, protocol.TypeProcessKill, protocol.ProcessKillPayload{ProcessID: "proc-1"})
+	var killed protocol.ProcessKilledPayload
+	readPayload(t, watcher, protocol.TypeProcessKilled, &killed)
+	if killed.ProcessID != "proc-1" {
+		t.Errorf("pushed kill = %+v", killed)
+	}
+	expectNothingQueued(t, bystander, bystanderCS)
+}
+
+func TestCWDChangedPushes(t *testing.T) {
+	t.Setenv("FAKE_TMUX_CWD", t.TempDir())
+	s := newQuietServer(t)
+	watcher, watcherCS := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 1)
+	if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{ProcessID: "proc-0", HostID: "host-1", ProcessType: "shell",
+		TmuxName: "rc-proc-0", CWD: "/cached", StartedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	dispatch(t, s, watcherCS, protocol.TypeProcessesSubscribe, protocol.ProcessesSubscribePayload{HostID: "host-1"})
+	readPayload(t, watcher, protocol.TypeProcessListResult, nil)
+	debounce := cwdChangeDebounce
+	t.Cleanup(func() { cwdChangeDebounce = debounce })
+
+	// Each step sets the pane's directory, with the debounce running or
+	// not, and refreshes
+	for i, step := range []struct {
+		cwd      string
+		debounce time.Duration
+		push     string // "" when nothing is pushed
+	}{
+		{"/cached", 0, ""},
+		{"/work", 0, "/work"},
+		{"/work", 0, ""},
+		{"/tmp", time.Hour, ""},
+		{"/work", time.Hour, ""},
+		{"/srv", time.Hour, ""},
+		{"/srv", 0, "/srv"},
+	} {
+		setPaneCWD(t, "rc-proc-0", step.cwd)
+		cwdChangeDebounce = step.debounce
+		s.refreshCWDs()
+		if step.push == "" {
+			expectNothingQueued(t, watcher, watcherCS)
+			continue
+		}
+		var changed protocol.ProcessCWDChangedPayload
+		readPayload(t, watcher, protocol.TypeProcessCWDChanged, &changed)
+		if changed.ProcessID != "proc-0" || changed.CWD != step.push {
+			t.Errorf("step %d: pushed %+v, want %s", i, changed, step.push)
+		}
+		expectNothingQueued(t, watcher, watcherCS)
+		if meta, err := s.storage.GetProcessMetadata("proc-0"); err != nil || meta == nil || meta.CWD != step.push {
+			t.Errorf("step %d: stored %+v, %v", i, meta, err)
+		}
+	}
+}
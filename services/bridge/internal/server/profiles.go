@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/crypto"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/datadir"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// ============================================================================
+// Data Profiles
+// ============================================================================
+//
+// A profile is an isolated set of bridge data with its own database and its
+// own credential encryption key. The default profile keeps the original
+// layout: bridge.db directly in the data directory, encrypted with the key
+// from BRIDGE_ENCRYPTION_KEY. Named profiles live in
+// <data-dir>/profiles/<name>/ next to a key file generated on first use.
+// The profile is chosen at startup; switching requires a restart.
+
+// DefaultProfile is the profile used when none is selected
+const DefaultProfile = datadir.DefaultProfile
+
+const (
+	dbFileName  = "bridge.db"
+	keyFileName = "bridge.key"
+)
+
+var profileNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// ValidateProfileName rejects names that aren't safe as a directory name
+func ValidateProfileName(name string) error {
+	if !profileNameRe.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q: use letters, digits, '-' and '_' (max 64)", name)
+	}
+	return nil
+}
+
+// openProfile creates a profile's directory and key on first use and returns
+// the directory and the cipher for its stored credentials
+func openProfile(dataDir, profile string) (string, *crypto.Cipher, error) {
+	dir := datadir.ProfileDir(dataDir, profile)
+	if profile == DefaultProfile {
+		return dir, crypto.EnvCipher(), nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", nil, fmt.Errorf("failed to create profile directory: %w", err)
+	}
+	key, err := crypto.LoadOrCreateKeyFile(filepath.Join(dir, keyFileName))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to load profile key: %w", err)
+	}
+	cipher, err := crypto.NewCipher(key)
+	if err != nil {
+		return "", nil, err
+	}
+	log.Printf("[INFO] [PROFILE] Using profile %s in %s", profile, dir)
+	return dir, cipher, nil
+}
+
+// listProfiles returns the default profile followed by the named profiles
+// found under dataDir, in name order
+func listProfiles(dataDir string) ([]string, error) {
+	profiles := []string{DefaultProfile}
+
+	entries, err := os.ReadDir(datadir.ProfilesDir(dataDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return profiles, nil
+	}
+	if err != nil {
+		return profiles, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != DefaultProfile && ValidateProfileName(entry.Name()) == nil {
+			profiles = append(profiles, entry.Name())
+		}
+	}
+	return profiles, nil
+}
+
+// handleProfileList lists the profiles available in the data directory
+func (s *Server) handleProfileList(connSession *ConnectedSession, msg *protocol.Message) error {
+	log.Printf("[DEBUG] [PROFILE] Profile list requested by session %s", connSession.ID)
+
+	result := protocol.ProfileListResultPayload{Current: s.config.Profile}
+	profiles, err := listProfiles(s.dataDir)
+	if err != nil {
+		log.Printf("[WARN] [PROFILE] Failed to list profiles: %v", err)
+		result.Code = protocol.ErrorStorageError
+		result.Error = strPtr(err.Error())
+	}
+	result.Profiles = profiles
+
+	response, err := protocol.NewMessage(protocol.TypeProfileListResult, result)
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
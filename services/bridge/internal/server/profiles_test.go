@@ -0,0 +1,130 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/datadir"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// newProfileServer starts a server for profile within a shared data directory
+func newProfileServer(t *testing.T, dataDir, profile string) *Server {
+	t.Helper()
+	config := DefaultConfig()
+	config.Profile = profile
+	s, err := New("127.0.0.1:0", dataDir, config)
+	if err != nil {
+		t.Fatalf("New(%s): %v", profile, err)
+	}
+	t.Cleanup(s.Stop)
+	return s
+}
+
+func TestProfilesAreIsolated(t *testing.T) {
+	dataDir := t.TempDir()
+	work := newProfileServer(t, dataDir, "work")
+	personal := newProfileServer(t, dataDir, "personal")
+
+	// Each profile gets its own database and key, created on first use
+	for _, profile := range []string{"work", "personal"} {
+		for _, name := range []string{dbFileName, keyFileName} {
+			if _, err := os.Stat(filepath.Join(datadir.ProfileDir(dataDir, profile), name)); err != nil {
+				t.Errorf("profile %s: %v", profile, err)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, dbFileName)); !os.IsNotExist(err) {
+		t.Errorf("named profiles should not touch the default database: %v", err)
+	}
+
+	conn, cs := connectTestClient(t, work)
+	dispatch(t, work, cs, protocol.TypeHostConfigCreate, protocol.HostConfigCreatePayload{
+		Name: "build box", Host: "10.0.0.5", Port: 22, Username: "me", AuthType: "password", Credential: "hunter2",
+	})
+	var created protocol.HostConfigCreateResultPayload
+	readPayload(t, conn, protocol.TypeHostConfigCreateResult, &created)
+	if !created.Success {
+		t.Fatalf("host create failed: %+v", created)
+	}
+	dispatch(t, work, cs, protocol.TypeSnippetCreate, protocol.SnippetCreatePayload{Name: "deploy", Content: "make deploy"})
+	readPayload(t, conn, protocol.TypeSnippetCreateResult, nil)
+
+	if hosts, _ := personal.storage.ListSSHHosts(); len(hosts) != 0 {
+		t.Errorf("personal profile sees %d hosts from work", len(hosts))
+	}
+	if snippets, _ := personal.storage.ListSnippets(); len(snippets) != 0 {
+		t.Errorf("personal profile sees %d snippets from work", len(snippets))
+	}
+
+	// Credentials encrypted in one profile can't be read with another's key
+	host, err := work.storage.GetSSHHost(created.Host.ID)
+	if err != nil || host == nil {
+		t.Fatalf("GetSSHHost: %v", err)
+	}
+	if cred, err := work.cipher.DecryptString(host.CredentialEncrypted); err != nil || cred != "hunter2" {
+		t.Errorf("work profile decrypt = %q, %v", cred, err)
+	}
+	if _, err := personal.cipher.DecryptString(host.CredentialEncrypted); err == nil {
+		t.Error("personal profile decrypted a work credential")
+	}
+}
+
+func TestProfileKeyPersistsAcrossRestarts(t *testing.T) {
+	dataDir := t.TempDir()
+	config := DefaultConfig()
+	config.Profile = "work"
+	first, err := New("127.0.0.1:0", dataDir, config)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	encrypted, err := first.cipher.EncryptString("hunter2")
+	if err != nil {
+		t.Fatalf("EncryptString: %v", err)
+	}
+	first.Stop()
+
+	second := newProfileServer(t, dataDir, "work")
+	if cred, err := second.cipher.DecryptString(encrypted); err != nil || cred != "hunter2" {
+		t.Errorf("decrypt after restart = %q, %v", cred, err)
+	}
+}
+
+func TestProfileListAndIndicator(t *testing.T) {
+	dataDir := t.TempDir()
+	newProfileServer(t, dataDir, "personal")
+	s := newProfileServer(t, dataDir, "work")
+	os.MkdirAll(filepath.Join(datadir.ProfilesDir(dataDir), "not a profile"), 0700)
+
+	conn, cs := connectTestClient(t, s)
+	dispatch(t, s, cs, protocol.TypeProfileList, protocol.ProfileListPayload{})
+	var list protocol.ProfileListResultPayload
+	readPayload(t, conn, protocol.TypeProfileListResult, &list)
+	if want := []string{DefaultProfile, "personal", "work"}; !reflect.DeepEqual(list.Profiles, want) {
+		t.Errorf("profiles = %v, want %v", list.Profiles, want)
+	}
+	if list.Current != "work" {
+		t.Errorf("current = %q, want work", list.Current)
+	}
+
+	info := s.bridgeInfo()
+	if info.Profile != "work" || info.DataDir != filepath.Join(datadir.ProfilesDir(dataDir), "work") {
+		t.Errorf("bridge info profile = %q dataDir = %q", info.Profile, info.DataDir)
+	}
+}
+
+func TestInvalidProfileName(t *testing.T) {
+	for _, name := range []string{"../escape", "a/b", ".hidden", "with space", ""} {
+		if err := ValidateProfileName(name); err == nil {
+			t.Errorf("ValidateProfileName(%q) should fail", name)
+		}
+	}
+
+	config := DefaultConfig()
+	config.Profile = "../escape"
+	if _, err := New("127.0.0.1:0", t.TempDir(), config); err == nil {
+		t.Error("New should reject an invalid profile name")
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"syscall"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// ptyErrorKind is why a PTY write or resize failed
+type ptyErrorKind int
+
+const (
+	ptyErrorOther     ptyErrorKind = iota
+	ptyErrorDetached               // The session has no attachment to write to
+	ptyErrorClosed                 // The session was closed
+	ptyErrorTransport              // The SSH channel or connection under it failed
+)
+
+// classifyPtyError sorts a PTY write or resize error by what the client
+// can do about it
+func classifyPtyError(err error) ptyErrorKind {
+	var opErr *net.OpError
+	var openErr *cryptossh.OpenChannelError
+	switch {
+	case errors.Is(err, pty.ErrClosed):
+		return ptyErrorClosed
+	case errors.Is(err, pty.ErrNotAttached):
+		return ptyErrorDetached
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.ErrClosedPipe),
+		errors.Is(err, net.ErrClosed), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.As(err, &opErr), errors.As(err, &openErr):
+		return ptyErrorTransport
+	}
+	return ptyErrorOther
+}
+
+// recordPtyFailure records a failed pty_input, pty_resize or pty_scroll as
+// the process's last error of op, and returns how to report it. Transport
+// errors trigger a check of the host connection: a dead one is torn down
+// and broadcast as disconnected, and reported as SSH_DOWN; on a live one
+// only the attachment died, so the process needs reattaching.
+func (s *Server) recordPtyFailure(proc *process.Process, op process.Operation, err error) *requestFailure {
+	details := protocol.PtyFailureDetails{ProcessID: proc.ID, HostID: proc.HostID, Reason: err.Error()}
+
+	var failure *requestFailure
+	switch classifyPtyError(err) {
+	case ptyErrorClosed:
+		details.SuggestedAction = protocol.SuggestedActionReattachProcess
+		failure = &requestFailure{protocol.ErrorPtyClosed, details}
+	case ptyErrorDetached:
+		details.SuggestedAction = protocol.SuggestedActionReattachProcess
+		failure = &requestFailure{protocol.ErrorPtyDetached, details}
+	case ptyErrorTransport:
+		if s.sshManager.CheckConnection(proc.HostID, err) {
+			details.SuggestedAction = protocol.SuggestedActionReattachProcess
+			failure = &requestFailure{protocol.ErrorPtyDetached, details}
+		} else {
+			details.SuggestedAction = protocol.SuggestedActionReconnectHost
+			failure = &requestFailure{protocol.ErrorSSHDown, details}
+		}
+	default:
+		s.setProcessError(proc, op, protocol.ErrorPtyError, err)
+		return &requestFailure{protocol.ErrorPtyError, protocol.ErrorDetails{"processId": proc.ID, "reason": err.Error()}}
+	}
+	log.Printf("[WARN] [PTY] Process %s failed with %s, suggesting %s: %v", proc.ID, failure.code, details.SuggestedAction, err)
+	s.setProcessError(proc, op, failure.code, err)
+	return failure
+}
+
+// sendPtyFailure records a failed pty_input, pty_resize or pty_scroll and
+// reports it to the session that sent it (see recordPtyFailure)
+func (s *Server) sendPtyFailure(connSession *ConnectedSession, proc *process.Process, op process.Operation, err error) error {
+	return connSession.sendFailure(s.recordPtyFailure(proc, op, err))
+}
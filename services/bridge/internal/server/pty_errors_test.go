@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+func TestClassifyPtyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ptyErrorKind
+	}{
+		{"closed", pty.ErrClosed, ptyErrorClosed},
+		{"not attached", fmt.Errorf("write: %w", pty.ErrNotAttached), ptyErrorDetached},
+		{"channel EOF", fmt.Errorf("failed to write to PTY: %w", io.EOF), ptyErrorTransport},
+		{"resize session EOF", fmt.Errorf("failed to create SSH session for resize: %w", io.EOF), ptyErrorTransport},
+		{"connection reset", &net.OpError{Op: "write", Net: "tcp", Err: syscall.ECONNRESET}, ptyErrorTransport},
+		{"closed connection", fmt.Errorf("failed to write to PTY: %w", net.ErrClosed), ptyErrorTransport},
+		{"channel rejected", &cryptossh.OpenChannelError{Reason: cryptossh.ConnectionFailed}, ptyErrorTransport},
+		{"other", errors.New("tmux: no server running"), ptyErrorOther},
+	}
+	for _, tt := range tests {
+		if got := classifyPtyError(tt.err); got != tt.want {
+			t.Errorf("%s: classifyPtyError(%v) = %d, want %d", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+// readPtyFailure reads an error and decodes its PtyFailureDetails, leaving
+// out the reason every failure must have
+func readPtyFailure(t *testing.T, conn *websocket.Conn) (protocol.ErrorCode, protocol.PtyFailureDetails) {
+	t.Helper()
+	var payload struct {
+		Code    protocol.ErrorCode         `json:"code"`
+		Details protocol.PtyFailureDetails `json:"details"`
+	}
+	readPayload(t, conn, protocol.TypeError, &payload)
+	if payload.Details.Reason == "" {
+		t.Errorf("%s has no reason", payload.Code)
+	}
+	payload.Details.Reason = ""
+	return payload.Code, payload.Details
+}
+
+func TestPtyFailuresSuggestReattach(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 1)
+	proc := s.processRegistry.Get("proc-0")
+
+	// Never attached
+	dispatch(t, s, cs, protocol.TypePtyInput, protocol.PtyInputPayload{ProcessID: "proc-0", Data: "ls\n"})
+	code, details := readPtyFailure(t, conn)
+	want := protocol.PtyFailureDetails{ProcessID: "proc-0", HostID: "host-1", SuggestedAction: protocol.SuggestedActionReattachProcess}
+	if code != protocol.ErrorPtyDetached || details != want {
+		t.Errorf("detached input: %s %+v", code, details)
+	}
+
+	// A transport error on a live host means only the attachment died
+	if err := s.sendPtyFailure(cs, proc, process.OpPtyWrite, fmt.Errorf("failed to write to PTY: %w", io.EOF)); err != nil {
+		t.Fatalf("sendPtyFailure: %v", err)
+	}
+	if code, details := readPtyFailure(t, conn); code != protocol.ErrorPtyDetached || details != want {
+		t.Errorf("dead attachment: %s %+v", code, details)
+	}
+
+	proc.PTY.Close()
+	dispatch(t, s, cs, protocol.TypePtyResize, protocol.PtyResizePayload{ProcessID: "proc-0", Cols: 100, Rows: 30})
+	if code, details := readPtyFailure(t, conn); code != protocol.ErrorPtyClosed || details != want {
+		t.Errorf("closed resize: %s %+v", code, details)
+	}
+	expectNothingQueued(t, conn, cs)
+}
+
+func TestPtyFailureWhenConnectionDies(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	watcher, _ := connectTestClient(t, s)
+	shellTestHost(t, s)
+
+	dispatch(t, s, cs, protocol.TypeProcessCreate, protocol.ProcessCreatePayload{HostID: "host-1"})
+	var created protocol.ProcessCreatedPayload
+	readPayload(t, conn, protocol.TypeProcessCreated, &created)
+	processID := created.Process.ID
+
+	// The SSH connection dies under the attached session
+	s.sshManager.GetConnection("host-1").Client.Close()
+
+	dispatch(t, s, cs, protocol.TypePtyInput, protocol.PtyInputPayload{ProcessID: processID, Data: "ls\n"})
+
+	// Every client hears the host went down, then the sender gets the error
+	for _, c := range []*websocket.Conn{conn, watcher} {
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var status protocol.HostStatusPayload
+		readPayload(t, c, protocol.TypeHostStatus, &status)
+		if status.HostID != "host-1" || status.Connected {
+			t.Errorf("host status = %+v, want host-1 disconnected", status)
+		}
+	}
+	code, details := readPtyFailure(t, conn)
+	want := protocol.PtyFailureDetails{ProcessID: processID, HostID: "host-1", SuggestedAction: protocol.SuggestedActionReconnectHost}
+	if code != protocol.ErrorSSHDown || details != want {
+		t.Errorf("after connection died: %s %+v", code, details)
+	}
+
+	if s.sshManager.GetConnection("host-1") != nil {
+		t.Error("dead connection should be removed from the manager")
+	}
+	if s.processRegistry.Get(processID) != nil {
+		t.Error("processes of the dead host should be unregistered")
+	}
+}
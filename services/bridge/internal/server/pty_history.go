@@ -0,0 +1,39 @@
+package server
+
+import (
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// Bounds and default for the chunk size of pty history transfers. Bigger
+// chunks mean fewer messages on fast links; smaller ones interleave better
+// with live output on slow links.
+const (
+	minHistoryChunkSize     = 8 << 10
+	maxHistoryChunkSize     = 512 << 10
+	defaultHistoryChunkSize = 64 << 10
+)
+
+// historyChunkSize returns the chunk size to use for a history request:
+// the requested size, or the default, clamped to the protocol bounds and
+// the configured ceiling
+func (s *Server) historyChunkSize(requested *int) int {
+	ceiling := maxHistoryChunkSize
+	if s.config.PtyHistoryMaxChunkSize > 0 {
+		ceiling = min(max(s.config.PtyHistoryMaxChunkSize, minHistoryChunkSize), maxHistoryChunkSize)
+	}
+	size := defaultHistoryChunkSize
+	if requested != nil {
+		size = *requested
+	}
+	return min(max(size, minHistoryChunkSize), ceiling)
+}
+
+// setTransferRate fills in the duration and throughput of a history transfer
+func setTransferRate(stats *protocol.PtyHistoryTransferStats, elapsed time.Duration) {
+	stats.DurationMs = elapsed.Milliseconds()
+	if elapsed > 0 {
+		stats.BytesPerSecond = int64(float64(stats.Bytes) / elapsed.Seconds())
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+func TestHistoryChunkSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		ceiling   int
+		requested *int
+		want      int
+	}{
+		{"default", maxHistoryChunkSize, nil, defaultHistoryChunkSize},
+		{"in bounds", maxHistoryChunkSize, intPtr(100000), 100000},
+		{"below minimum", maxHistoryChunkSize, intPtr(1), minHistoryChunkSize},
+		{"negative", maxHistoryChunkSize, intPtr(-5), minHistoryChunkSize},
+		{"minimum", maxHistoryChunkSize, intPtr(minHistoryChunkSize), minHistoryChunkSize},
+		{"maximum", maxHistoryChunkSize, intPtr(maxHistoryChunkSize), maxHistoryChunkSize},
+		{"above maximum", maxHistoryChunkSize, intPtr(1 << 30), maxHistoryChunkSize},
+		{"server ceiling", 32 << 10, intPtr(100000), 32 << 10},
+		{"default above ceiling", 32 << 10, nil, 32 << 10},
+		{"ceiling below minimum", 1024, intPtr(1 << 20), minHistoryChunkSize},
+		{"ceiling above maximum", 4 << 20, intPtr(1 << 30), maxHistoryChunkSize},
+		{"no ceiling", 0, intPtr(1 << 30), maxHistoryChunkSize},
+	}
+	for _, tt := range tests {
+		s := &Server{config: Config{PtyHistoryMaxChunkSize: tt.ceiling}}
+		if got := s.historyChunkSize(tt.requested); got != tt.want {
+			t.Errorf("%s: historyChunkSize = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPtyHistoryYieldsToLiveOutput(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+
+	history := bytes.Repeat([]byte("0123456789abcdef"), 5*minHistoryChunkSize/16)
+	if err := s.storage.AppendPtyOutput("proc-1", "host-1", history); err != nil {
+		t.Fatalf("AppendPtyOutput: %v", err)
+	}
+
+	// Hold the connection while the transfer and the live messages queue up
+	cs.Session.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dispatch(t, s, cs, protocol.TypePtyHistoryRequest, protocol.PtyHistoryRequestPayload{ProcessID: "proc-1", ChunkSize: intPtr(1)})
+	}()
+	live := []struct {
+		msgType string
+		payload interface{}
+	}{
+		{protocol.TypePtyOutput, protocol.PtyOutputPayload{ProcessID: "proc-1", Data: "$ "}},
+		{protocol.TypeProcessUpdated, protocol.ProcessUpdatedPayload{ID: "proc-1"}},
+	}
+	for _, m := range live {
+		msg, err := protocol.NewMessage(m.msgType, m.payload)
+		if err != nil {
+			t.Fatalf("NewMessage: %v", err)
+		}
+		go cs.Send(msg)
+	}
+	time.Sleep(20 * time.Millisecond)
+	cs.Session.Unlock()
+
+	// The live messages and the history response are sent in any order, but
+	// all before the first chunk
+	seen := map[string]int{}
+	for i := 0; i < 3; i++ {
+		var msg protocol.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("ReadJSON: %v", err)
+		}
+		seen[msg.Type]++
+	}
+	if seen[protocol.TypePtyOutput] != 1 || seen[protocol.TypeProcessUpdated] != 1 || seen[protocol.TypePtyHistoryResponse] != 1 {
+		t.Fatalf("messages before the first chunk = %v", seen)
+	}
+
+	for i := 0; i < 5; i++ {
+		var chunk protocol.PtyHistoryChunkPayload
+		readPayload(t, conn, protocol.TypePtyHistoryChunk, &chunk)
+		if chunk.ChunkIndex != i || chunk.TotalChunks != 5 || chunk.IsLast != (i == 4) {
+			t.Errorf("chunk %d = index %d of %d, last %v", i, chunk.ChunkIndex, chunk.TotalChunks, chunk.IsLast)
+		}
+	}
+	var complete protocol.PtyHistoryCompletePayload
+	readPayload(t, conn, protocol.TypePtyHistoryComplete, &complete)
+	<-done
+
+	stats := complete.Stats
+	if !complete.Success || stats == nil {
+		t.Fatalf("complete = %+v", complete)
+	}
+	if stats.Bytes != int64(len(history)) || stats.Chunks != 5 || stats.ChunkSize != minHistoryChunkSize {
+		t.Errorf("stats = %+v, want %d bytes in 5 chunks of %d", *stats, len(history), minHistoryChunkSize)
+	}
+	if stats.DurationMs < 0 || (stats.DurationMs > 0 && stats.BytesPerSecond <= 0) {
+		t.Errorf("stats = %+v, want a duration and rate", *stats)
+	}
+}
+
+func TestPtyHistoryReportsMissingChunks(t *testing.T) {
+	s := newQuietServer(t)
+	history := bytes.Repeat([]byte("0123456789abcdef"), 3*minHistoryChunkSize/16)
+	if err := s.storage.AppendPtyOutput("proc-1", "host-1", history); err != nil {
+		t.Fatalf("AppendPtyOutput: %v", err)
+	}
+
+	// The second chunk fails to send; the rest still go, but the client
+	// has a gap and must not take the history as complete
+	var complete protocol.PtyHistoryCompletePayload
+	chunks := 0
+	cs := &ConnectedSession{Session: s.sessionManager.CreateSession(nil), server: s, sink: func(msg *protocol.Message) error {
+		switch msg.Type {
+		case protocol.TypePtyHistoryChunk:
+			if chunks++; chunks == 2 {
+				return errors.New("write failed")
+			}
+		case protocol.TypePtyHistoryComplete:
+			json.Unmarshal(msg.Payload, &complete)
+		}
+		return nil
+	}}
+	msg, _ := protocol.NewMessage(protocol.TypePtyHistoryRequest, protocol.PtyHistoryRequestPayload{ProcessID: "proc-1", ChunkSize: intPtr(1)})
+	if err := s.handlePtyHistoryRequest(cs, msg); err != nil {
+		t.Fatalf("handlePtyHistoryRequest: %v", err)
+	}
+	if chunks != 3 {
+		t.Errorf("%d chunks attempted, want 3", chunks)
+	}
+	if complete.Success || complete.Code != protocol.ErrorHandlerError || complete.Error == nil || *complete.Error != "History cut short: sent 2 of 3 chunks" {
+		t.Errorf("complete = %+v", complete)
+	}
+	if complete.Stats == nil || complete.Stats.Bytes != int64(2*minHistoryChunkSize) {
+		t.Errorf("stats = %+v, want the two chunks sent", complete.Stats)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+)
+
+// ============================================================================
+// PTY Scrollback
+// ============================================================================
+//
+// pty_scroll scrolls a pane through tmux copy mode, so a touch client can
+// page back through what tmux itself kept, full-screen programs included,
+// rather than rebuilding it from captured output. Each scroll is answered
+// with pty_scroll_state. Copy mode would swallow keystrokes as its own
+// commands, so pty_input takes the pane out of it first (see
+// handlePtyInput).
+
+func (s *Server) handlePtyScroll(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.PtyScrollPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [PTY] Scroll: processId=%s action=%s", payload.ProcessID, payload.Action)
+
+	proc := s.processRegistry.Get(payload.ProcessID)
+	if proc == nil {
+		return connSession.sendProcessNotFound(payload.ProcessID)
+	}
+	if proc.PTY == nil {
+		return connSession.SendErrorDetails(protocol.ErrorNoPty, protocol.ErrorDetails{"processId": proc.ID})
+	}
+
+	count := 1
+	if payload.Count != nil {
+		count = *payload.Count
+	}
+	info, err := proc.PTY.Scroll(payload.Action, count)
+	if err != nil {
+		log.Printf("[ERROR] [PTY] Scroll error for process %s: %v", proc.ID, err)
+		// Scrolling drives the pane through tmux, as input does
+		return s.sendPtyFailure(connSession, proc, process.OpPtyWrite, err)
+	}
+	return sendPtyScrollState(connSession, proc, info)
+}
+
+// leaveCopyMode takes a process's pane out of copy mode before input is
+// written to it, telling the client live output resumed
+func (s *Server) leaveCopyMode(connSession *ConnectedSession, proc *process.Process) error {
+	info, left, err := proc.PTY.LeaveCopyMode()
+	if err != nil || !left {
+		return err
+	}
+	return sendPtyScrollState(connSession, proc, info)
+}
+
+// sendPtyScrollState sends where a process's pane is in its scrollback
+func sendPtyScrollState(connSession *ConnectedSession, proc *process.Process, info pty.PaneInfo) error {
+	msg, err := protocol.NewMessage(protocol.TypePtyScrollState, protocol.PtyScrollStatePayload{
+		ProcessID:      proc.ID,
+		InCopyMode:     info.InCopyMode,
+		ScrollPosition: info.ScrollPosition,
+		HistorySize:    info.HistorySize,
+	})
+	if err != nil {
+		return err
+	}
+	return connSession.Send(msg)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+)
+
+func TestPtyScrollLeavesCopyModeBeforeInput(t *testing.T) {
+	modes := t.TempDir()
+	t.Setenv("FAKE_TMUX_MODE", modes)
+	t.Setenv("FAKE_TMUX_HOLD", "10")
+	keys := recordTmuxKeys(t)
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+
+	dispatch(t, s, cs, protocol.TypeProcessCreate, protocol.ProcessCreatePayload{HostID: "host-1"})
+	var created protocol.ProcessCreatedPayload
+	readPayload(t, conn, protocol.TypeProcessCreated, &created)
+	processID := created.Process.ID
+	tmuxName := pty.TmuxSessionName(processID)
+
+	// The env capture is typed into the new shell first
+	for deadline := time.Now().Add(5 * time.Second); !strings.Contains(keys(), "env > "); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("env capture never typed")
+		}
+	}
+	typed := keys()
+
+	// Scrolling back enters copy mode and reports the position
+	three := 3
+	dispatch(t, s, cs, protocol.TypePtyScroll, protocol.PtyScrollPayload{ProcessID: processID, Action: protocol.PtyScrollUp, Count: &three})
+	var state protocol.PtyScrollStatePayload
+	readPayload(t, conn, protocol.TypePtyScrollState, &state)
+	want := protocol.PtyScrollStatePayload{ProcessID: processID, InCopyMode: true, ScrollPosition: 7, HistorySize: 500}
+	if state != want {
+		t.Errorf("after scroll up: %+v, want %+v", state, want)
+	}
+	if got := strings.TrimPrefix(keys(), typed); got != "copy-mode -e -t "+tmuxName+" ; send-keys -t "+tmuxName+" -X -N 3 scroll-up\n" {
+		t.Errorf("tmux ran %q", got)
+	}
+
+	// Input leaves copy mode first, and the client hears live output resumed
+	dispatch(t, s, cs, protocol.TypePtyInput, protocol.PtyInputPayload{ProcessID: processID, Data: "q"})
+	readPayload(t, conn, protocol.TypePtyScrollState, &state)
+	if want := (protocol.PtyScrollStatePayload{ProcessID: processID, HistorySize: 500}); state != want {
+		t.Errorf("after input: %+v, want %+v", state, want)
+	}
+	if got := keys(); !strings.HasSuffix(got, "if-shell -F -t "+tmuxName+" #{pane_in_mode} send-keys -t "+tmuxName+" -X cancel\n") {
+		t.Errorf("tmux ran %q, want copy mode cancelled", got)
+	}
+
+	// Out of copy mode, input goes straight to the PTY
+	before := keys()
+	dispatch(t, s, cs, protocol.TypePtyInput, protocol.PtyInputPayload{ProcessID: processID, Data: "ls\r"})
+	expectNothingQueued(t, conn, cs)
+	if keys() != before {
+		t.Errorf("tmux ran %q for input outside copy mode", strings.TrimPrefix(keys(), before))
+	}
+
+	// When copy mode can't be left, the input isn't written
+	dispatch(t, s, cs, protocol.TypePtyScroll, protocol.PtyScrollPayload{ProcessID: processID, Action: protocol.PtyScrollTop})
+	readPayload(t, conn, protocol.TypePtyScrollState, &state)
+	if err := os.WriteFile(filepath.Join(modes, tmuxName+".stuck"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	dispatch(t, s, cs, protocol.TypePtyInput, protocol.PtyInputPayload{ProcessID: processID, Data: "q"})
+	var failure protocol.ErrorPayload
+	readPayload(t, conn, protocol.TypeError, &failure)
+	if failure.Code != protocol.ErrorPtyError || !strings.Contains(errorReason(failure), "copy mode") {
+		t.Errorf("stuck copy mode: %+v", failure)
+	}
+	expectNothingQueued(t, conn, cs)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// reattachFunc reattaches a process's PTY over an SSH connection
+type reattachFunc func(s *Server, connSession *ConnectedSession, proc *process.Process, sshConn *ssh.Connection) error
+
+// Reattach pacing: each start waits reattachStagger, plus up to as much again
+// at random, after the one before; a process refused for the host's session
+// limits is tried once more after reattachRetryDelay, jittered the same way
+var (
+	reattachStagger    = 100 * time.Millisecond
+	reattachRetryDelay = time.Second
+)
+
+// jittered returns d plus up to d more, at random
+func jittered(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d + rand.N(d)
+}
+
+// reattachProcesses reattaches the PTYs of procs on one host, at most
+// ReattachConcurrency at once with their starts spread out. A process the
+// host refused a channel for (see ssh.IsChannelRejection) is retried once.
+// done, if set, is called with each process's final outcome as it is known,
+// one call at a time. The report lists processes in the order of procs; it
+// is nil when there are none.
+func (s *Server) reattachProcesses(connSession *ConnectedSession, procs []*process.Process, sshConn *ssh.Connection, done func(proc *process.Process, err error)) *protocol.ReattachReport {
+	if len(procs) == 0 {
+		return nil
+	}
+	errs := make([]error, len(procs))
+	retried := make([]bool, len(procs))
+
+	var doneMu sync.Mutex
+	attach := func(i int) {
+		proc := procs[i]
+		err := s.reattach(s, connSession, proc, sshConn)
+		if err != nil && ssh.IsChannelRejection(err) {
+			log.Printf("[WARN] [PTY] Reattach of process %s refused by the host, retrying: %v", proc.ID, err)
+			retried[i] = true
+			time.Sleep(jittered(reattachRetryDelay))
+			err = s.reattach(s, connSession, proc, sshConn)
+		}
+		errs[i] = err
+		if done != nil {
+			doneMu.Lock()
+			done(proc, err)
+			doneMu.Unlock()
+		}
+	}
+
+	workers := min(max(s.config.ReattachConcurrency, 1), len(procs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Go(func() {
+			for i := range jobs {
+				attach(i)
+			}
+		})
+	}
+	for i := range procs {
+		if i > 0 {
+			time.Sleep(jittered(reattachStagger))
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	report := &protocol.ReattachReport{Reattached: []string{}}
+	for i, proc := range procs {
+		if retried[i] {
+			report.Retried = append(report.Retried, proc.ID)
+		}
+		if errs[i] != nil {
+			report.Failed = append(report.Failed, protocol.ReattachFailure{ProcessID: proc.ID, Error: errs[i].Error()})
+			continue
+		}
+		report.Reattached = append(report.Reattached, proc.ID)
+	}
+	log.Printf("[INFO] [PTY] Reattached %d of %d processes on host %s (%d retried)",
+		len(report.Reattached), len(procs), sshConn.ID, len(report.Retried))
+	return report
+}
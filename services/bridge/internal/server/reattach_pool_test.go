@@ -0,0 +1,163 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	cryptossh "golang.org/x/crypto/ssh"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
+)
+
+// sessionLimitedHost stands in for the SSH layer of a host that refuses a
+// channel while limit are open, as sshd does past MaxSessions. Each reattach
+// holds one channel for hold.
+type sessionLimitedHost struct {
+	limit int
+	hold  time.Duration
+	fail  map[string]error // Processes whose reattach fails without a channel
+
+	mu       sync.Mutex
+	open     int
+	peak     int
+	attempts map[string]int
+}
+
+func (h *sessionLimitedHost) reattach(_ *Server, _ *ConnectedSession, proc *process.Process, _ *ssh.Connection) error {
+	h.mu.Lock()
+	h.attempts[proc.ID]++
+	if err := h.fail[proc.ID]; err != nil {
+		h.mu.Unlock()
+		return err
+	}
+	if h.open >= h.limit {
+		h.mu.Unlock()
+		return fmt.Errorf("failed to reattach to tmux session: failed to create SSH session: %w",
+			&cryptossh.OpenChannelError{Reason: cryptossh.ResourceShortage, Message: "open failed"})
+	}
+	h.open++
+	h.peak = max(h.peak, h.open)
+	h.mu.Unlock()
+
+	time.Sleep(h.hold)
+
+	h.mu.Lock()
+	h.open--
+	h.mu.Unlock()
+	return nil
+}
+
+// limitedHost installs a sessionLimitedHost as s's reattach, with quick
+// reattach pacing
+func limitedHost(t *testing.T, s *Server, limit int, fail map[string]error) *sessionLimitedHost {
+	t.Helper()
+	savedStagger, savedRetry := reattachStagger, reattachRetryDelay
+	t.Cleanup(func() { reattachStagger, reattachRetryDelay = savedStagger, savedRetry })
+	reattachStagger, reattachRetryDelay = time.Millisecond, 100*time.Millisecond
+
+	host := &sessionLimitedHost{limit: limit, hold: 50 * time.Millisecond, fail: fail, attempts: make(map[string]int)}
+	s.reattach = host.reattach
+	return host
+}
+
+// detachedProcs returns n processes with IDs proc-0..proc-n-1
+func detachedProcs(n int) []*process.Process {
+	procs := make([]*process.Process, n)
+	for i := range procs {
+		procs[i] = &process.Process{ID: fmt.Sprintf("proc-%d", i), HostID: "host-1"}
+	}
+	return procs
+}
+
+func TestReattachPoolStaysUnderSessionLimit(t *testing.T) {
+	s := newQuietServer(t)
+	host := limitedHost(t, s, 3, nil)
+	procs := detachedProcs(12)
+
+	var done []string
+	report := s.reattachProcesses(nil, procs, &ssh.Connection{ID: "host-1"}, func(proc *process.Process, err error) {
+		done = append(done, proc.ID)
+	})
+
+	if host.peak > 3 {
+		t.Errorf("%d reattaches at once, over the limit of 3", host.peak)
+	}
+	if len(report.Reattached) != 12 || report.Retried != nil || report.Failed != nil {
+		t.Errorf("report = %+v, want all reattached the first time", report)
+	}
+	for i, proc := range procs {
+		if report.Reattached[i] != proc.ID || host.attempts[proc.ID] != 1 {
+			t.Errorf("%s: listed as %s, tried %d times", proc.ID, report.Reattached[i], host.attempts[proc.ID])
+		}
+	}
+	if len(done) != 12 {
+		t.Errorf("done called for %v", done)
+	}
+}
+
+func TestReattachPoolRetriesSessionLimitRejects(t *testing.T) {
+	s := newQuietServer(t)
+	s.config.ReattachConcurrency = 6
+	broken := errors.New("failed to reattach to tmux session: can't find session")
+	host := limitedHost(t, s, 3, map[string]error{"proc-6": broken})
+
+	// Twice the workers the host allows: the last three of the first six are
+	// refused, and get in once the first three are done
+	report := s.reattachProcesses(nil, detachedProcs(7), &ssh.Connection{ID: "host-1"}, nil)
+
+	want := &protocol.ReattachReport{
+		Reattached: []string{"proc-0", "proc-1", "proc-2", "proc-3", "proc-4", "proc-5"},
+		Retried:    []string{"proc-3", "proc-4", "proc-5"},
+		Failed:     []protocol.ReattachFailure{{ProcessID: "proc-6", Error: broken.Error()}},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+	// Other failures aren't retried
+	if host.attempts["proc-6"] != 1 {
+		t.Errorf("proc-6 tried %d times", host.attempts["proc-6"])
+	}
+	if host.peak > 3 {
+		t.Errorf("%d reattaches at once, over the limit of 3", host.peak)
+	}
+
+	if s.reattachProcesses(nil, nil, &ssh.Connection{ID: "host-1"}, nil) != nil {
+		t.Error("report for no processes")
+	}
+}
+
+func TestAuthReattachReportedInHostStatus(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	stallRequirements(t, s)
+	broken := errors.New("failed to reattach to tmux session: can't find session")
+	limitedHost(t, s, 3, map[string]error{"proc-4": broken})
+
+	client := s.sshManager.GetConnection("host-1").Client
+	for i := range 5 {
+		id := fmt.Sprintf("proc-%d", i)
+		ptySession := &pty.Session{ID: id, HostID: "host-1", TmuxName: pty.TmuxSessionName(id)}
+		ptySession.UpdateSSHClient(client)
+		s.processRegistry.Register(&process.Process{ID: id, HostID: "host-1", Type: process.TypeShell, PTY: ptySession})
+	}
+
+	s.sendCurrentHostStates(cs)
+	var status protocol.HostStatusPayload
+	readPayload(t, conn, protocol.TypeHostStatus, &status)
+	if status.Reattach == nil || len(status.Reattach.Reattached) != 4 || status.Reattach.Retried != nil ||
+		len(status.Reattach.Failed) != 1 || status.Reattach.Failed[0].ProcessID != "proc-4" {
+		t.Fatalf("reattach = %+v", status.Reattach)
+	}
+	if len(status.Processes) != 4 || status.StaleProcesses == nil || len(*status.StaleProcesses) != 1 {
+		t.Errorf("host status = %+v, want the failed process stale", status)
+	}
+	expectNothingQueued(t, conn, cs)
+}
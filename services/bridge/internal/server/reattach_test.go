@@ -0,0 +1,301 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+func TestReattachReconcilesMetadata(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+
+	created := time.Unix(fakeTmuxCreated, 0)
+	tests := []struct {
+		name      string
+		meta      *storage.ProcessMetadata // nil: nothing stored
+		discarded bool
+	}{
+		{"matching", &storage.ProcessMetadata{ShellPID: fakeTmuxPID, StartedAt: created.Add(2 * time.Second)}, false},
+		{"different shell PID", &storage.ProcessMetadata{ShellPID: 1111, StartedAt: created.Add(2 * time.Second)}, true},
+		{"session created after process", &storage.ProcessMetadata{StartedAt: created.Add(-time.Hour)}, true},
+		{"missing metadata", nil, false},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processID := fmt.Sprintf("00000000-0000-4000-8000-%012d", i)
+			tmuxName := pty.TmuxSessionName(processID)
+			if tt.meta != nil {
+				meta := *tt.meta
+				meta.ProcessID = processID
+				meta.HostID = "host-1"
+				meta.ProcessType = "claude"
+				meta.Port = 3290
+				meta.TmuxName = tmuxName
+				meta.Name = "api server"
+				meta.EnvVars = []storage.EnvVar{{Key: "FOO", Value: "bar"}}
+				if err := s.storage.SaveProcessMetadata(meta); err != nil {
+					t.Fatalf("SaveProcessMetadata: %v", err)
+				}
+			}
+
+			dispatch(t, s, cs, protocol.TypeProcessReattach, protocol.ProcessReattachPayload{
+				HostID: "host-1", TmuxSession: tmuxName, ProcessID: processID,
+			})
+			var added protocol.ProcessAddedPayload
+			readPayload(t, conn, protocol.TypeProcessAdded, &added)
+			if added.MetadataDiscarded != tt.discarded {
+				t.Errorf("metadataDiscarded = %v, want %v", added.MetadataDiscarded, tt.discarded)
+			}
+			readPayload(t, conn, protocol.TypeStaleProcessesChanged, nil)
+
+			proc := s.processRegistry.Get(processID)
+			if proc == nil {
+				t.Fatal("process not registered")
+			}
+			if proc.Type != process.TypeShell {
+				t.Errorf("type = %s, want shell (AgentAPI isn't reachable)", proc.Type)
+			}
+			if proc.ShellPID == nil || *proc.ShellPID != fakeTmuxPID {
+				t.Errorf("shell PID = %v, want %d", proc.ShellPID, fakeTmuxPID)
+			}
+			if tt.meta == nil {
+				return
+			}
+			if proc.Name == nil || *proc.Name != "api server" {
+				t.Errorf("name = %v, want the saved name kept", proc.Name)
+			}
+
+			stored, err := s.storage.GetProcessMetadata(processID)
+			if err != nil || stored == nil {
+				t.Fatalf("GetProcessMetadata: %v", err)
+			}
+			if tt.discarded {
+				if len(proc.EnvVars) != 0 {
+					t.Errorf("env vars restored from stale metadata: %v", proc.EnvVars)
+				}
+				if stored.Port != 0 || stored.ProcessType != "shell" || len(stored.EnvVars) != 0 {
+					t.Errorf("stale metadata kept in storage: %+v", stored)
+				}
+				if stored.ShellPID != fakeTmuxPID || stored.Name != "api server" {
+					t.Errorf("stored metadata = %+v, want the live shell PID and saved name", stored)
+				}
+			} else {
+				if len(proc.EnvVars) != 1 || proc.EnvVars[0].Key != "FOO" {
+					t.Errorf("env vars = %v, want the saved ones", proc.EnvVars)
+				}
+				if stored.Port != 3290 || stored.ProcessType != "claude" {
+					t.Errorf("matching metadata changed in storage: %+v", stored)
+				}
+			}
+		})
+	}
+}
+
+func TestReattachRejectsForeignSessions(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+	sessions := shellTestHost(t, s)
+
+	const id = "6f1c2a9e-3b4d-4c5e-8f70-1a2b3c4d5e6f"
+	tests := []struct {
+		name                   string
+		processID, tmuxSession string
+	}{
+		{"non-UUID session", "test", "rc-test"},
+		{"ID not embedded in session", id, "rc-test"},
+		{"another process's session", id, pty.TmuxSessionName("0f1c2a9e-3b4d-4c5e-8f70-1a2b3c4d5e6f")},
+		{"spaces", id, pty.TmuxSessionName(id) + " extra"},
+		{"quotes", id, pty.TmuxSessionName(id) + `'"; touch pwned`},
+		{"braced ID", "{" + id + "}", "rc-{" + id + "}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := atomic.LoadInt32(sessions)
+			dispatch(t, s, cs, protocol.TypeProcessReattach, protocol.ProcessReattachPayload{
+				HostID: "host-1", TmuxSession: tt.tmuxSession, ProcessID: tt.processID,
+			})
+			var errPayload struct {
+				Code string `json:"code"`
+			}
+			readPayload(t, conn, protocol.TypeError, &errPayload)
+			if errPayload.Code != string(protocol.ErrorAttachFailed) {
+				t.Errorf("code = %s, want %s", errPayload.Code, protocol.ErrorAttachFailed)
+			}
+			if got := atomic.LoadInt32(sessions); got != before {
+				t.Errorf("rejected reattach ran %d commands on the host", got-before)
+			}
+		})
+	}
+	if procs := s.processRegistry.GetByHost("host-1"); len(procs) != 0 {
+		t.Errorf("registered %d processes", len(procs))
+	}
+	if metas, err := s.storage.GetProcessMetadataByHost("host-1"); err != nil || len(metas) != 0 {
+		t.Errorf("stored metadata = %+v, %v", metas, err)
+	}
+
+	// A process ID in another case is normalized to the one in the name
+	dispatch(t, s, cs, protocol.TypeProcessReattach, protocol.ProcessReattachPayload{
+		HostID: "host-1", TmuxSession: pty.TmuxSessionName(id), ProcessID: strings.ToUpper(id),
+	})
+	readPayload(t, conn, protocol.TypeProcessAdded, nil)
+	readPayload(t, conn, protocol.TypeStaleProcessesChanged, nil)
+	if s.processRegistry.Get(id) == nil {
+		t.Errorf("process %s not registered", id)
+	}
+}
+
+func TestReattachStartedAt(t *testing.T) {
+	stored := time.Unix(fakeTmuxCreated+2, 0)
+	created := time.Unix(fakeTmuxCreated, 0)
+	pane := pty.PaneInfo{ShellPID: fakeTmuxPID, Created: created}
+	tests := []struct {
+		name    string
+		meta    *storage.ProcessMetadata
+		pane    pty.PaneInfo
+		paneErr error
+		want    time.Time // zero: about now
+	}{
+		{"stored", &storage.ProcessMetadata{StartedAt: stored}, pane, nil, stored},
+		{"stored without pane", &storage.ProcessMetadata{StartedAt: stored}, pty.PaneInfo{}, errors.New("no pane"), stored},
+		{"missing from metadata", &storage.ProcessMetadata{StartedAt: time.Unix(0, 0)}, pane, nil, created},
+		{"zero in metadata", &storage.ProcessMetadata{}, pane, nil, created},
+		{"no metadata", nil, pane, nil, created},
+		{"no metadata or pane", nil, pty.PaneInfo{}, errors.New("no pane"), time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := time.Now()
+			got := reattachStartedAt(tt.meta, tt.pane, tt.paneErr)
+			if tt.want.IsZero() {
+				if got.Before(before) || got.After(time.Now()) {
+					t.Errorf("started at %v, want now", got)
+				}
+			} else if !got.Equal(tt.want) {
+				t.Errorf("started at %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReattachKeepsStartTimes(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+
+	created := time.Unix(fakeTmuxCreated, 0)
+	ids := []string{
+		"00000000-0000-4000-8000-000000000010",
+		"00000000-0000-4000-8000-000000000011",
+		"00000000-0000-4000-8000-000000000012",
+	}
+	// The second was stored without a start time, so it takes the tmux
+	// session's creation time and is the oldest
+	startedAt := map[string]time.Time{
+		ids[0]: created.Add(2 * time.Second),
+		ids[1]: created,
+		ids[2]: created.Add(10 * time.Second),
+	}
+	for _, id := range ids {
+		meta := storage.ProcessMetadata{ProcessID: id, HostID: "host-1", ProcessType: "shell",
+			TmuxName: pty.TmuxSessionName(id), ShellPID: fakeTmuxPID, StartedAt: startedAt[id]}
+		if id == ids[1] {
+			meta.StartedAt = time.Time{}
+		}
+		if err := s.storage.SaveProcessMetadata(meta); err != nil {
+			t.Fatalf("SaveProcessMetadata: %v", err)
+		}
+	}
+
+	reattach := func(order []string) {
+		t.Helper()
+		for _, id := range order {
+			dispatch(t, s, cs, protocol.TypeProcessReattach, protocol.ProcessReattachPayload{
+				HostID: "host-1", TmuxSession: pty.TmuxSessionName(id), ProcessID: id,
+			})
+			var added protocol.ProcessAddedPayload
+			readPayload(t, conn, protocol.TypeProcessAdded, &added)
+			if added.MetadataDiscarded {
+				t.Errorf("metadata of %s discarded", id)
+			}
+			readPayload(t, conn, protocol.TypeStaleProcessesChanged, nil)
+		}
+		procs := s.processRegistry.GetByHost("host-1")
+		slices.SortStableFunc(procs, func(a, b *process.Process) int { return a.StartedAt.Compare(b.StartedAt) })
+		var got []string
+		for _, proc := range procs {
+			got = append(got, proc.ID)
+			if !proc.StartedAt.Equal(startedAt[proc.ID]) {
+				t.Errorf("%s started at %v, want %v", proc.ID, proc.StartedAt, startedAt[proc.ID])
+			}
+		}
+		if want := []string{ids[1], ids[0], ids[2]}; !slices.Equal(got, want) {
+			t.Errorf("order = %v, want %v", got, want)
+		}
+	}
+
+	reattach([]string{ids[2], ids[0], ids[1]})
+	meta, err := s.storage.GetProcessMetadata(ids[1])
+	if err != nil || meta == nil || !meta.StartedAt.Equal(created) {
+		t.Fatalf("stored metadata = %+v, %v; want the tmux creation time recorded", meta, err)
+	}
+
+	// Reconnecting reattaches them again, in whatever order
+	for _, id := range ids {
+		proc := s.processRegistry.Get(id)
+		if err := proc.Detach(); err != nil {
+			t.Fatalf("Detach: %v", err)
+		}
+		s.processRegistry.Unregister(id)
+	}
+	reattach([]string{ids[1], ids[2], ids[0]})
+}
+
+func TestHostConnectSkipsRecreatedSessions(t *testing.T) {
+	const same, recreated = "00000000-0000-4000-8000-000000000001", "00000000-0000-4000-8000-000000000002"
+	fakeTmuxSessions(t, pty.TmuxSessionName(same), pty.TmuxSessionName(recreated))
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	stallRequirements(t, s)
+
+	// Both were registered before the host went away; the second's session
+	// now runs another shell, as after a reboot and tmux-resurrect
+	client := s.sshManager.GetConnection("host-1").Client
+	for _, p := range []struct {
+		id       string
+		shellPID int
+	}{{same, fakeTmuxPID}, {recreated, 1111}} {
+		ptySession := &pty.Session{ID: p.id, HostID: "host-1", TmuxName: pty.TmuxSessionName(p.id)}
+		ptySession.UpdateSSHClient(client)
+		s.processRegistry.Register(&process.Process{ID: p.id, HostID: "host-1", Type: process.TypeClaude, PTY: ptySession,
+			Port: intPtr(3290), ShellPID: intPtr(p.shellPID), StartedAt: time.Unix(fakeTmuxCreated+2, 0)})
+	}
+
+	s.sendCurrentHostStates(cs)
+	var status protocol.HostStatusPayload
+	readPayload(t, conn, protocol.TypeHostStatus, &status)
+	if len(status.Processes) != 1 || status.Processes[0].ID != same {
+		t.Errorf("processes = %+v, want only %s", status.Processes, same)
+	}
+	if status.StaleProcesses == nil || len(*status.StaleProcesses) != 1 || *(*status.StaleProcesses)[0].ProcessID != recreated {
+		t.Errorf("stale processes = %+v, want %s offered for reattach", status.StaleProcesses, recreated)
+	}
+	if proc := s.processRegistry.Get(recreated); proc != nil {
+		t.Errorf("process bound to a recreated session: %+v", proc)
+	}
+	if proc := s.processRegistry.Get(same); proc == nil || !proc.PTY.IsAttached() {
+		t.Errorf("matching process not reattached: %+v", proc)
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// ============================================================================
+// Read-only REST API (/api/v1)
+// ============================================================================
+//
+// These endpoints mirror the shapes of the corresponding protocol payloads so
+// scripts and dashboards can read bridge state without speaking the WebSocket
+// protocol. They only read from the registry and storage: no SSH commands are
+// run and nothing is mutated.
+
+// restHost is an SSHHostConfig plus its live connection state
+type restHost struct {
+	protocol.SSHHostConfig
+	Connected bool `json:"connected"`
+}
+
+type restHostListResult struct {
+	Hosts []restHost `json:"hosts"`
+}
+
+// restProcessMetadata is the persisted metadata for a process
+type restProcessMetadata struct {
+	TmuxName    string `json:"tmuxName"`
+	ProcessType string `json:"processType"`
+	Port        *int   `json:"port,omitempty"`
+	CWD         string `json:"cwd,omitempty"`
+	ClaudeCWD   string `json:"claudeCwd,omitempty"`
+	Name        string `json:"name,omitempty"`
+	StartedAt   string `json:"startedAt"`  // ISO timestamp
+	LastSeenAt  string `json:"lastSeenAt"` // ISO timestamp
+}
+
+type restSessionListResult struct {
+	Sessions []protocol.ConnectedSessionInfo `json:"sessions"`
+}
+
+type restProcessResult struct {
+	Process  *protocol.ProcessInfo `json:"process,omitempty"` // nil when not attached
+	Metadata *restProcessMetadata  `json:"metadata,omitempty"`
+}
+
+// restHandler returns the handler serving all /api/v1 routes
+func (s *Server) restHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/hosts", s.handleRESTHosts)
+	mux.HandleFunc("GET /api/v1/hosts/{id}/processes", s.handleRESTHostProcesses)
+	mux.HandleFunc("GET /api/v1/processes/{id}", s.handleRESTProcess)
+	mux.HandleFunc("GET /api/v1/snippets", s.handleRESTSnippets)
+	mux.HandleFunc("GET /api/v1/sessions", s.handleRESTSessions)
+	return s.logREST(s.requireAuthToken(mux))
+}
+
+// requireAuthToken rejects requests without a valid "Authorization: Bearer <token>" header
+func (s *Server) requireAuthToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !s.checkAuthToken(token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			s.writeRESTError(w, r, http.StatusUnauthorized, protocol.ErrorUnauthorized, "missing or invalid auth token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder captures the response status for request logging
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logREST logs each REST request with its status and duration
+func (s *Server) logREST(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		log.Printf("[DEBUG] [REST] %s %s from %s -> %d (%s)",
+			r.Method, r.URL.Path, r.RemoteAddr, rec.status, time.Since(start).Round(time.Microsecond))
+	})
+}
+
+func writeRESTJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[WARN] [REST] Failed to encode response: %v", err)
+	}
+}
+
+// writeRESTError writes an error with the code's message in the language
+// the request prefers, and reason in its details
+func (s *Server) writeRESTError(w http.ResponseWriter, r *http.Request, status int, code protocol.ErrorCode, reason string) {
+	if !code.Valid() {
+		unknownErrorCode(code)
+	}
+	writeRESTJSON(w, status, protocol.ErrorPayload{
+		Code:    code,
+		Message: s.catalog.Message(restLocale(r), code),
+		Details: protocol.ErrorDetails{"reason": reason},
+	})
+}
+
+// restLocale returns the first language of a request's Accept-Language
+// header; quality weights are ignored
+func restLocale(r *http.Request) string {
+	first, _, _ := strings.Cut(r.Header.Get("Accept-Language"), ",")
+	tag, _, _ := strings.Cut(first, ";")
+	if tag = strings.TrimSpace(tag); tag == "*" {
+		return ""
+	}
+	return tag
+}
+
+// handleRESTHosts lists configured hosts with their live connection state
+func (s *Server) handleRESTHosts(w http.ResponseWriter, r *http.Request) {
+	hosts, err := s.storage.ListSSHHosts()
+	if err != nil {
+		log.Printf("[ERROR] [REST] Failed to list hosts: %v", err)
+		s.writeRESTError(w, r, http.StatusInternalServerError, protocol.ErrorStorageError, err.Error())
+		return
+	}
+
+	result := restHostListResult{Hosts: make([]restHost, len(hosts))}
+	for i, h := range hosts {
+		conn := s.sshManager.GetConnection(h.ID)
+		result.Hosts[i] = restHost{
+			SSHHostConfig: s.sshHostConfig(h),
+			Connected:     conn != nil && conn.IsAlive(),
+		}
+	}
+	writeRESTJSON(w, http.StatusOK, result)
+}
+
+// handleRESTHostProcesses lists the registered processes for a host
+func (s *Server) handleRESTHostProcesses(w http.ResponseWriter, r *http.Request) {
+	hostID := r.PathValue("id")
+	host, err := s.storage.GetSSHHost(hostID)
+	if err != nil {
+		s.writeRESTError(w, r, http.StatusInternalServerError, protocol.ErrorStorageError, err.Error())
+		return
+	}
+	if host == nil {
+		s.writeRESTError(w, r, http.StatusNotFound, protocol.ErrorNotFound, "host not found")
+		return
+	}
+
+	procs := s.processRegistry.GetByHost(hostID)
+	processInfos := make([]protocol.ProcessInfo, 0, len(procs))
+	for _, proc := range procs {
+		processInfos = append(processInfos, proc.ToInfo())
+	}
+	writeRESTJSON(w, http.StatusOK, protocol.ProcessListResultPayload{
+		HostID:    hostID,
+		Processes: processInfos,
+	})
+}
+
+// handleRESTProcess returns a process's live info and its persisted metadata
+func (s *Server) handleRESTProcess(w http.ResponseWriter, r *http.Request) {
+	processID := r.PathValue("id")
+
+	var result restProcessResult
+	if proc := s.processRegistry.Get(processID); proc != nil {
+		info := proc.ToInfo()
+		result.Process = &info
+	}
+
+	meta, err := s.storage.GetProcessMetadata(processID)
+	if err != nil {
+		s.writeRESTError(w, r, http.StatusInternalServerError, protocol.ErrorStorageError, err.Error())
+		return
+	}
+	if meta != nil {
+		result.Metadata = &restProcessMetadata{
+			TmuxName:    meta.TmuxName,
+			ProcessType: meta.ProcessType,
+			CWD:         meta.CWD,
+			ClaudeCWD:   meta.ClaudeCWD,
+			Name:        meta.Name,
+			StartedAt:   meta.StartedAt.Format(time.RFC3339),
+			LastSeenAt:  meta.LastSeenAt.Format(time.RFC3339),
+		}
+		if meta.Port > 0 {
+			port := meta.Port
+			result.Metadata.Port = &port
+		}
+	}
+
+	if result.Process == nil && result.Metadata == nil {
+		s.writeRESTError(w, r, http.StatusNotFound, protocol.ErrorNotFound, "process not found")
+		return
+	}
+	writeRESTJSON(w, http.StatusOK, result)
+}
+
+// handleRESTSnippets lists all snippets
+func (s *Server) handleRESTSnippets(w http.ResponseWriter, r *http.Request) {
+	snippets, err := s.storage.ListSnippets()
+	if err != nil {
+		s.writeRESTError(w, r, http.StatusInternalServerError, protocol.ErrorStorageError, err.Error())
+		return
+	}
+
+	protoSnippets := make([]protocol.Snippet, len(snippets))
+	for i, snippet := range snippets {
+		protoSnippets[i] = toProtocolSnippet(snippet)
+	}
+	writeRESTJSON(w, http.StatusOK, protocol.SnippetListResultPayload{Snippets: protoSnippets})
+}
+
+// handleRESTSessions lists the connected sessions with their latency
+func (s *Server) handleRESTSessions(w http.ResponseWriter, r *http.Request) {
+	writeRESTJSON(w, http.StatusOK, restSessionListResult{Sessions: s.connectedSessionInfos()})
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// restGet performs a GET against the REST handler with an optional bearer token
+func restGet(t *testing.T, s *Server, path, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.restHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+func newRESTTestServer(t *testing.T) *Server {
+	t.Helper()
+	config := DefaultConfig()
+	config.AuthToken = "secret"
+	s := newTestServer(t, config)
+
+	if err := s.storage.CreateSSHHost(storage.SSHHost{
+		ID: "host-1", Name: "devbox", Host: "10.0.0.2", Port: 22, Username: "dev",
+		AuthType: "password", CredentialEncrypted: []byte("not-returned"),
+	}); err != nil {
+		t.Fatalf("CreateSSHHost: %v", err)
+	}
+	s.storage.CreateSnippet(storage.Snippet{ID: "snip-1", Name: "list", Content: "ls -la"})
+	s.processRegistry.Register(&process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeShell, CWD: "/srv"})
+	return s
+}
+
+func TestRESTRequiresAuthToken(t *testing.T) {
+	s := newRESTTestServer(t)
+
+	for _, path := range []string{"/api/v1/hosts", "/api/v1/hosts/host-1/processes", "/api/v1/processes/proc-1", "/api/v1/snippets", "/api/v1/sessions"} {
+		if rec := restGet(t, s, path, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s without token: status %d, want 401", path, rec.Code)
+		}
+		if rec := restGet(t, s, path, "wrong"); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s with wrong token: status %d, want 401", path, rec.Code)
+		}
+		if rec := restGet(t, s, path, "secret"); rec.Code != http.StatusOK {
+			t.Errorf("%s with token: status %d, want 200", path, rec.Code)
+		}
+	}
+}
+
+func TestRESTNotFound(t *testing.T) {
+	s := newRESTTestServer(t)
+
+	for _, path := range []string{"/api/v1/hosts/missing/processes", "/api/v1/processes/missing", "/api/v1/nothing"} {
+		if rec := restGet(t, s, path, "secret"); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", path, rec.Code)
+		}
+	}
+}
+
+func TestRESTResponseShapes(t *testing.T) {
+	s := newRESTTestServer(t)
+
+	rec := restGet(t, s, "/api/v1/hosts", "secret")
+	var hosts map[string][]map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &hosts); err != nil {
+		t.Fatalf("hosts: %v", err)
+	}
+	if len(hosts["hosts"]) != 1 {
+		t.Fatalf("expected 1 host, got %v", hosts)
+	}
+	host := hosts["hosts"][0]
+	if host["id"] != "host-1" || host["connected"] != false {
+		t.Errorf("unexpected host: %v", host)
+	}
+	for key := range host {
+		if key == "credential" || key == "credentialEncrypted" {
+			t.Errorf("host response leaks %s", key)
+		}
+	}
+
+	rec = restGet(t, s, "/api/v1/hosts/host-1/processes", "secret")
+	var list protocol.ProcessListResultPayload
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if list.HostID != "host-1" || len(list.Processes) != 1 || list.Processes[0].CWD != "/srv" {
+		t.Errorf("unexpected process list: %+v", list)
+	}
+
+	rec = restGet(t, s, "/api/v1/processes/proc-1", "secret")
+	var proc restProcessResult
+	json.Unmarshal(rec.Body.Bytes(), &proc)
+	if proc.Process == nil || proc.Process.ID != "proc-1" {
+		t.Errorf("unexpected process: %+v", proc)
+	}
+
+	rec = restGet(t, s, "/api/v1/snippets", "secret")
+	var snippets protocol.SnippetListResultPayload
+	json.Unmarshal(rec.Body.Bytes(), &snippets)
+	if len(snippets.Snippets) != 1 || snippets.Snippets[0].Content != "ls -la" {
+		t.Errorf("unexpected snippets: %+v", snippets)
+	}
+}
+
+func TestRESTIsReadOnly(t *testing.T) {
+	s := newRESTTestServer(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/snippets", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	s.restHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ============================================================================
+// HTTP Routing
+// ============================================================================
+//
+// All routes live on a server-owned mux under Config.BasePath, so the bridge
+// can sit behind a reverse proxy at a path prefix (e.g. nginx forwarding
+// https://example.com/bridge/ to it with --base-path /bridge). URLs handed
+// to clients are built from Config.ExternalURL when set, or from the request
+// otherwise, with its X-Forwarded-Proto/X-Forwarded-Host headers if it came
+// from one of Config.TrustedProxies.
+
+// Route paths, relative to the base path
+const (
+	routeWebSocket = "/ws"
+	routeHealth    = "/health"
+	routeREST      = "/api/v1"
+)
+
+// normalizeBasePath returns a base path as "/prefix" without a trailing
+// slash, or "" for the root
+func normalizeBasePath(basePath string) (string, error) {
+	basePath = strings.TrimSpace(basePath)
+	if basePath == "" || basePath == "/" {
+		return "", nil
+	}
+	if strings.ContainsAny(basePath, "?#{} ") {
+		return "", fmt.Errorf("invalid base path %q", basePath)
+	}
+	basePath = path.Clean("/" + basePath)
+	if basePath == "/" {
+		return "", nil
+	}
+	return basePath, nil
+}
+
+// normalizeExternalURL checks that an external URL is an absolute http(s)
+// URL and returns it without a trailing slash, or "" if unset
+func normalizeExternalURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid external URL %q: %w", raw, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid external URL %q: must be an absolute http or https URL", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid external URL %q: must not have a query or fragment", raw)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// parseTrustedProxies parses trusted proxy addresses and CIDR ranges; an
+// address stands for itself alone. Empty entries are skipped.
+func parseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// fromTrustedProxy reports whether a request's peer is a trusted proxy
+func (s *Server) fromTrustedProxy(r *http.Request) bool {
+	if len(s.trustedProxies) == 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler returns the handler serving all of the bridge's HTTP routes under
+// the configured base path
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(routeWebSocket, s.handleWebSocket)
+	mux.HandleFunc(routeHealth, s.handleHealth)
+	mux.Handle(routeREST+"/", s.restHandler())
+	if s.config.BasePath == "" {
+		return mux
+	}
+
+	prefixed := http.NewServeMux()
+	prefixed.Handle(s.config.BasePath+"/", http.StripPrefix(s.config.BasePath, mux))
+	return prefixed
+}
+
+// externalURL returns the absolute URL clients use to reach a route, e.g.
+// "https://example.com/bridge/api/v1" for routeREST behind a proxy
+func (s *Server) externalURL(r *http.Request, route string) string {
+	if s.config.ExternalURL != "" {
+		return s.config.ExternalURL + route
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if s.fromTrustedProxy(r) {
+		if proto := forwardedValue(r, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fwdHost := forwardedValue(r, "X-Forwarded-Host"); fwdHost != "" {
+			host = fwdHost
+		}
+	}
+	return scheme + "://" + host + s.config.BasePath + route
+}
+
+// externalWebSocketURL returns the ws:// or wss:// URL of the WebSocket endpoint
+func (s *Server) externalWebSocketURL(r *http.Request) string {
+	u := s.externalURL(r, routeWebSocket)
+	if rest, ok := strings.CutPrefix(u, "https://"); ok {
+		return "wss://" + rest
+	}
+	return "ws://" + strings.TrimPrefix(u, "http://")
+}
+
+// forwardedValue returns the first value of a proxy header; proxies chained
+// behind each other append theirs after the client-facing one
+func forwardedValue(r *http.Request, header string) string {
+	value, _, _ := strings.Cut(r.Header.Get(header), ",")
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestNormalizeBasePath(t *testing.T) {
+	tests := map[string]string{
+		"":           "",
+		"/":          "",
+		"bridge":     "/bridge",
+		"/bridge/":   "/bridge",
+		"//a//b/":    "/a/b",
+		"/a/../b":    "/b",
+		" /bridge ":  "/bridge",
+		"/../../":    "",
+		"/v2/bridge": "/v2/bridge",
+	}
+	for in, want := range tests {
+		got, err := normalizeBasePath(in)
+		if err != nil || got != want {
+			t.Errorf("normalizeBasePath(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"/bridge?x=1", "/a#b", "/{id}", "/my bridge"} {
+		if _, err := normalizeBasePath(in); err == nil {
+			t.Errorf("normalizeBasePath(%q) should fail", in)
+		}
+	}
+}
+
+func TestNormalizeExternalURL(t *testing.T) {
+	tests := map[string]string{
+		"":                            "",
+		"https://example.com/bridge/": "https://example.com/bridge",
+		"http://10.0.0.2:8080":        "http://10.0.0.2:8080",
+	}
+	for in, want := range tests {
+		got, err := normalizeExternalURL(in)
+		if err != nil || got != want {
+			t.Errorf("normalizeExternalURL(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"example.com/bridge", "ftp://example.com", "https://", "https://example.com/?a=1", "://x"} {
+		if _, err := normalizeExternalURL(in); err == nil {
+			t.Errorf("normalizeExternalURL(%q) should fail", in)
+		}
+	}
+}
+
+// routeStatus returns the status of a request to the server's handler
+func routeStatus(s *Server, method, path string) int {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestRoutes(t *testing.T) {
+	for _, basePath := range []string{"", "/bridge"} {
+		config := DefaultConfig()
+		config.AuthToken = "secret"
+		config.BasePath = basePath + "/"
+		s := newTestServer(t, config)
+
+		routes := map[string]int{
+			basePath + "/health":          http.StatusOK,
+			basePath + "/api/v1/snippets": http.StatusOK,
+			basePath + "/api/v1/nope":     http.StatusNotFound,
+			basePath + "/ws":              http.StatusBadRequest, // Not a WebSocket upgrade
+			basePath + "/":                http.StatusNotFound,
+		}
+		if basePath != "" {
+			routes["/health"] = http.StatusNotFound
+			routes["/api/v1/snippets"] = http.StatusNotFound
+			routes["/ws"] = http.StatusNotFound
+			routes["/bridgehealth"] = http.StatusNotFound
+		}
+		for path, want := range routes {
+			if got := routeStatus(s, http.MethodGet, path); got != want {
+				t.Errorf("base path %q: GET %s = %d, want %d", basePath, path, got, want)
+			}
+		}
+
+		// The WebSocket endpoint upgrades under the base path
+		ts := httptest.NewServer(s.Handler())
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+basePath+"/ws", nil)
+		if err != nil {
+			t.Errorf("base path %q: WebSocket dial: %v", basePath, err)
+		} else {
+			conn.Close()
+		}
+		ts.Close()
+	}
+
+	// Routes stay off the global mux
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	if _, pattern := http.DefaultServeMux.Handler(req); pattern != "" {
+		t.Errorf("route %q registered on http.DefaultServeMux", pattern)
+	}
+}
+
+func TestExternalURLs(t *testing.T) {
+	tests := []struct {
+		name        string
+		basePath    string
+		externalURL string
+		proxies     []string
+		headers     map[string]string
+		tls         bool
+		wantREST    string
+		wantWS      string
+	}{
+		{
+			name:     "direct",
+			wantREST: "http://bridge.local:8080/api/v1",
+			wantWS:   "ws://bridge.local:8080/ws",
+		},
+		{
+			name:     "direct TLS",
+			tls:      true,
+			wantREST: "https://bridge.local:8080/api/v1",
+			wantWS:   "wss://bridge.local:8080/ws",
+		},
+		{
+			name:     "behind a proxy",
+			basePath: "/bridge",
+			proxies:  []string{"192.0.2.1"},
+			headers:  map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "example.com"},
+			wantREST: "https://example.com/bridge/api/v1",
+			wantWS:   "wss://example.com/bridge/ws",
+		},
+		{
+			name:     "chained proxies",
+			basePath: "/bridge",
+			proxies:  []string{"10.0.0.0/8", "192.0.2.0/24"},
+			headers:  map[string]string{"X-Forwarded-Proto": "https, http", "X-Forwarded-Host": "example.com, proxy.internal"},
+			wantREST: "https://example.com/bridge/api/v1",
+			wantWS:   "wss://example.com/bridge/ws",
+		},
+		{
+			name:     "untrusted peer",
+			basePath: "/bridge",
+			headers:  map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example"},
+			wantREST: "http://bridge.local:8080/bridge/api/v1",
+			wantWS:   "ws://bridge.local:8080/bridge/ws",
+		},
+		{
+			name:     "peer outside the trusted range",
+			proxies:  []string{"10.0.0.0/8"},
+			headers:  map[string]string{"X-Forwarded-Host": "evil.example"},
+			wantREST: "http://bridge.local:8080/api/v1",
+			wantWS:   "ws://bridge.local:8080/ws",
+		},
+		{
+			name:     "bogus proto",
+			proxies:  []string{"192.0.2.1"},
+			headers:  map[string]string{"X-Forwarded-Proto": "gopher"},
+			wantREST: "http://bridge.local:8080/api/v1",
+			wantWS:   "ws://bridge.local:8080/ws",
+		},
+		{
+			name:        "explicit external URL",
+			basePath:    "/bridge",
+			externalURL: "https://example.com/remote/bridge/",
+			headers:     map[string]string{"X-Forwarded-Proto": "http", "X-Forwarded-Host": "ignored.example"},
+			wantREST:    "https://example.com/remote/bridge/api/v1",
+			wantWS:      "wss://example.com/remote/bridge/ws",
+		},
+	}
+	for _, tt := range tests {
+		config := DefaultConfig()
+		config.BasePath = tt.basePath
+		config.ExternalURL = tt.externalURL
+		config.TrustedProxies = tt.proxies
+		s := newTestServer(t, config)
+
+		// From 192.0.2.1
+		req := httptest.NewRequest(http.MethodGet, "http://bridge.local:8080"+tt.basePath+"/health", nil)
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		if tt.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+
+		var health struct {
+			Endpoints map[string]string `json:"endpoints"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil {
+			t.Fatalf("%s: decode /health: %v", tt.name, err)
+		}
+		if got := health.Endpoints["rest"]; got != tt.wantREST {
+			t.Errorf("%s: rest = %q, want %q", tt.name, got, tt.wantREST)
+		}
+		if got := health.Endpoints["websocket"]; got != tt.wantWS {
+			t.Errorf("%s: websocket = %q, want %q", tt.name, got, tt.wantWS)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := parseTrustedProxies([]string{"", " 127.0.0.1 ", "10.1.2.3/8", "::1", "::ffff:192.0.2.1"})
+	if err != nil {
+		t.Fatalf("parseTrustedProxies: %v", err)
+	}
+	want := []string{"127.0.0.1/32", "10.0.0.0/8", "::1/128", "192.0.2.1/32"}
+	if len(prefixes) != len(want) {
+		t.Fatalf("prefixes = %v, want %v", prefixes, want)
+	}
+	for i := range want {
+		if prefixes[i].String() != want[i] {
+			t.Errorf("prefix %d = %s, want %s", i, prefixes[i], want[i])
+		}
+	}
+	for _, bad := range []string{"proxy.local", "10.0.0.0/33"} {
+		if _, err := parseTrustedProxies([]string{bad}); err == nil {
+			t.Errorf("parseTrustedProxies(%q) accepted", bad)
+		}
+	}
+}
@@ -1,40 +1,84 @@
 package server
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/agentapi"
 	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/crypto"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/diagnostics"
 	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/env"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/i18n"
 	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
 	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
 	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
 	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/scanner"
 	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/session"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/shellargs"
 	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/ssh"
 	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/update"
 	cryptossh "golang.org/x/crypto/ssh"
 )
 
 // Server represents the Bridge WebSocket server
 type Server struct {
-	addr            string
-	dataDir         string
-	upgrader        websocket.Upgrader
-	sessionManager  *session.Manager
-	sshManager      *ssh.Manager
-	processRegistry *process.Registry
-	portScanner     *scanner.Scanner
-	storage         *storage.Store
-	envManager      *env.Manager
-	handlers        map[string]MessageHandler
+	addr              string
+	dataDir           string
+	profileDir        string // Data directory of the active profile
+	config            Config
+	upgrader          websocket.Upgrader
+	wsStats           wsByteStats
+	handlerStats      handlerStats
+	alertLimiter      *alertLimiter
+	diagnostics       *diagnostics.Tracker
+	sessionManager    *session.Manager
+	sshManager        *ssh.Manager
+	processRegistry   *process.Registry
+	portScanner       *scanner.Scanner
+	storage           *storage.Store
+	envManager        *env.Manager
+	envMasker         *env.Masker
+	trustedProxies    []netip.Prefix      // Peers whose X-Forwarded-* headers are honored
+	envRefreshes      envRefreshes        // Rate-limits current env captures
+	transcripts       transcripts         // What was read of Claude transcripts, for usage
+	downloads         fileDownloads       // Running file_download transfers
+	uploads           fileUploads         // file_upload_begin transfers not yet written
+	cipher            *crypto.Cipher      // Encrypts host credentials kept in the database
+	catalog           *i18n.Catalog       // Localized error messages
+	credentials       *crypto.Credentials // Finds host credentials in their backends
+	handlers          map[string]MessageHandler
+	hostExec          hostExecFunc     // Runs host_exec commands; replaced in tests
+	checkRequirements requirementsFunc // Checks host requirements; replaced in tests
+	reattach          reattachFunc     // Reattaches a process's PTY; replaced in tests
+	typeSnippet       snippetWriteFunc // Types a rendered snippet into a PTY; replaced in tests
+	requirements      *hostRequirements
+	admin             *adminServer    // Admin socket, once started
+	instanceLock      *instanceLock   // Held on profileDir until Stop
+	encrypting        atomic.Bool     // A storage_encrypt_now is running
+	updates           *update.Checker // Release manifest checker; nil while update checks are off
+	updateMu          sync.Mutex
+	updatePushed      string // Latest release pushed as bridge_update_available
+	startedAt         time.Time
+	done              chan struct{}               // Closed by Stop to end background tasks
+	httpServer        atomic.Pointer[http.Server] // Set by Start
+	liveConns         liveConnections             // WebSocket connections being served
+	shutdown          shutdownHooks               // Steps Stop runs, in order
 }
 
 // MessageHandler handles a specific message type
@@ -44,85 +88,246 @@ type MessageHandler func(s *ConnectedSession, msg *protocol.Message) error
 type ConnectedSession struct {
 	*session.Session
 	server *Server
+	sink   func(*protocol.Message) error // Takes sent messages in place of a connection, for admin socket requests
 }
 
 // New creates a new Bridge server
-func New(addr string, dataDir string) (*Server, error) {
+func New(addr string, dataDir string, config Config) (*Server, error) {
+	envMasker, err := env.NewMasker(config.EnvSecretPatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Profile == "" {
+		config.Profile = DefaultProfile
+	}
+	if err := ValidateProfileName(config.Profile); err != nil {
+		return nil, err
+	}
+	if config.PortRange == (process.PortRange{}) {
+		config.PortRange = process.DefaultPortRange
+	}
+	if err := config.PortRange.Validate(); err != nil {
+		return nil, err
+	}
+	if config.MaxConcurrentOps == 0 {
+		config.MaxConcurrentOps = ssh.DefaultMaxConcurrentOps
+	}
+	if config.MaxConcurrentOps < 1 || config.MaxConcurrentOps > ssh.MaxConcurrentOpsLimit {
+		return nil, fmt.Errorf("host operation limit %d must be within 1-%d", config.MaxConcurrentOps, ssh.MaxConcurrentOpsLimit)
+	}
+	if config.PtyOutput == (pty.OutputTuning{}) {
+		config.PtyOutput = pty.DefaultOutputTuning()
+	}
+	if err := config.PtyOutput.Validate(); err != nil {
+		return nil, err
+	}
+	if config.BasePath, err = normalizeBasePath(config.BasePath); err != nil {
+		return nil, err
+	}
+	if config.ExternalURL, err = normalizeExternalURL(config.ExternalURL); err != nil {
+		return nil, err
+	}
+	trustedProxies, err := parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	var updates *update.Checker
+	if config.UpdateCheck {
+		if updates, err = update.NewChecker(config.UpdateManifestURL, config.Build.Version, nil); err != nil {
+			return nil, fmt.Errorf("cannot check for updates: %w", err)
+		}
+	}
+	if err := checkDataDir(dataDir, config.MinFreeSpace); err != nil {
+		return nil, err
+	}
+	profileDir, cipher, err := openProfile(dataDir, config.Profile)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := newCredentials(config, cipher)
+	if err != nil {
+		return nil, err
+	}
+	catalog, err := i18n.Default()
+	if err != nil {
+		return nil, fmt.Errorf("invalid error message catalog: %w", err)
+	}
+
+	// Only one bridge may use a profile's database
+	lock, err := lockInstance(profileDir)
+	if err != nil {
+		return nil, err
+	}
+
 	// Initialize storage
-	dbPath := filepath.Join(dataDir, "bridge.db")
+	dbPath := filepath.Join(profileDir, dbFileName)
+	if err := checkDatabase(dbPath); err != nil {
+		lock.Release()
+		return nil, err
+	}
 	store, err := storage.NewStore(dbPath)
 	if err != nil {
+		lock.Release()
 		return nil, fmt.Errorf("failed to initialize storage: %w", err)
 	}
+	// The key is always set, so rows encrypted by an earlier run stay
+	// readable after --encrypt-history is dropped
+	if err := store.SetHistoryEncryption(cipher.Derive("history"), config.EncryptHistory); err != nil {
+		store.Close()
+		lock.Release()
+		return nil, fmt.Errorf("failed to set up history encryption: %w", err)
+	}
+	store.SetArchiveMaxAge(config.ArchiveMaxAge)
+	store.SetPtyHistoryMaxBytes(config.PtyHistoryMaxBytes)
+	if config.PersistInterval > 0 {
+		store.SetPersistInterval(config.PersistInterval)
+	}
+	store.SetEarlyFlush(config.FlushMaxBytes, config.FlushMaxAge)
+
+	config.Build = config.Build.withDefaults()
 
 	s := &Server{
-		addr:    addr,
-		dataDir: dataDir,
+		addr:         addr,
+		dataDir:      dataDir,
+		profileDir:   profileDir,
+		config:       config,
+		instanceLock: lock,
+		startedAt:    time.Now(),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				// Allow all origins in development
 				// TODO: Restrict in production
 				return true
 			},
+			// Only negotiates the extension; writes stay uncompressed until
+			// the client opts in via AuthPayload.Compression
+			EnableCompression: config.WSCompression,
 		},
 		sessionManager:  session.NewManager(),
 		sshManager:      ssh.NewManager(),
-		processRegistry: process.NewRegistry(),
-		portScanner:     scanner.NewScanner(),
+		processRegistry: process.NewRegistry(config.PortRange),
+		portScanner:     scanner.NewScanner(config.PortRange),
 		storage:         store,
 		envManager:      env.NewManager(),
+		envMasker:       envMasker,
+		trustedProxies:  trustedProxies,
+		cipher:          cipher,
+		credentials:     credentials,
+		catalog:         catalog,
 		handlers:        make(map[string]MessageHandler),
+		hostExec: func(ctx context.Context, conn *ssh.Connection, req ssh.ExecRequest) (int, error) {
+			return conn.Exec(ctx, req)
+		},
+		checkRequirements: pty.CheckRequirements,
+		reattach:          (*Server).reattachProcess,
+		typeSnippet:       (*Server).writeSnippet,
+		requirements:      newHostRequirements(),
+		alertLimiter:      newAlertLimiter(config.AlertInterval),
+		diagnostics:       diagnostics.NewTracker(),
+		updates:           updates,
+		done:              make(chan struct{}),
+	}
+
+	// Warn about processes left on ports a previous range allowed
+	s.checkPortRange()
+
+	// Free ports held for processes that died while nobody reattached them
+	s.processRegistry.SetPortReclaim(s.probeReservedPort, config.StalePortMaxAge)
+
+	// Move host credentials to a newly chosen backend
+	s.migrateCredentials()
+
+	// Let clients resume their sessions after a restart
+	s.sessionManager.SetTokenStore(store)
+	if _, err := store.DeleteExpiredSessionInvites(); err != nil {
+		log.Printf("[WARN] [AUTH] Failed to drop expired session invites: %v", err)
 	}
 
 	// Register message handlers
 	s.registerHandlers()
+	s.registerShutdownHooks()
+
+	// Notify clients when a host connection dies on its own
+	s.sshManager.OnConnectionLost(s.handleConnectionLost)
+	s.sshManager.MaxConcurrentOps = config.MaxConcurrentOps
+
+	if config.CWDRefreshInterval > 0 {
+		go s.cwdRefreshLoop(config.CWDRefreshInterval)
+	}
+	if config.TmuxProbeInterval > 0 {
+		go s.tmuxProbeLoop(config.TmuxProbeInterval)
+	}
+	if config.FileUploadIdleTimeout > 0 {
+		go s.uploadSweepLoop(config.FileUploadIdleTimeout)
+	}
+	if s.updates != nil {
+		go s.updateCheckLoop()
+	}
 
 	return s, nil
 }
 
-// Stop gracefully shuts down the server
+// Stop gracefully shuts down the server.
+// It runs the shutdown hooks in order (see shutdownStage): no new
+// connections, client connections closed, processes detached (they survive
+// bridge restarts), background loops stopped, and storage closed last.
 func (s *Server) Stop() {
 	log.Printf("[INFO] [SERVER] Shutting down...")
-
-	// Close storage first (persists all data)
-	if s.storage != nil {
-		if err := s.storage.Close(); err != nil {
-			log.Printf("[WARN] [SERVER] Error closing storage: %v", err)
-		}
-	}
-
-	// Detach from all processes (don't kill them - they survive bridge restarts)
-	// We intentionally do NOT close SSH connections here - just detach from tmux.
-	// This ensures tmux sessions keep running on remote hosts.
-	// The OS will clean up connections when the process exits.
-	s.processRegistry.DetachAll()
-	s.sessionManager.Stop()
-
-	log.Printf("[INFO] [SERVER] Shutdown complete")
+	start := time.Now()
+	s.runShutdown()
+	log.Printf("[INFO] [SERVER] Shutdown complete in %v", time.Since(start).Round(time.Millisecond))
 }
 
 // registerHandlers sets up message type handlers
 func (s *Server) registerHandlers() {
 	s.handlers[protocol.TypeAuth] = s.handleAuth
+	s.handlers[protocol.TypeSessionRefreshToken] = s.handleSessionRefreshToken
+	s.handlers[protocol.TypeSessionInviteCreate] = s.handleSessionInviteCreate
+	s.handlers[protocol.TypeSessionInviteRevoke] = s.handleSessionInviteRevoke
 	// Host Config (CRUD)
 	s.handlers[protocol.TypeHostConfigList] = s.handleHostConfigList
 	s.handlers[protocol.TypeHostConfigCreate] = s.handleHostConfigCreate
 	s.handlers[protocol.TypeHostConfigUpdate] = s.handleHostConfigUpdate
 	s.handlers[protocol.TypeHostConfigDelete] = s.handleHostConfigDelete
+	s.handlers[protocol.TypeHostConfigImportSSHConfig] = s.handleHostConfigImportSSHConfig
 	// Host Connection (runtime)
 	s.handlers[protocol.TypeHostConnect] = s.handleHostConnect
 	s.handlers[protocol.TypeHostDisconnect] = s.handleHostDisconnect
+	s.handlers[protocol.TypeHostStatusRequest] = s.handleHostStatusRequest
 	s.handlers[protocol.TypeHostCheckRequirements] = s.handleHostCheckRequirements
+	s.handlers[protocol.TypeHostExec] = s.handleHostExec
+	s.handlers[protocol.TypeFileDownload] = s.handleFileDownload
+	s.handlers[protocol.TypeFileDownloadCancel] = s.handleFileDownloadCancel
+	s.handlers[protocol.TypeFileUploadBegin] = s.handleFileUploadBegin
+	s.handlers[protocol.TypeFileUploadChunk] = s.handleFileUploadChunk
+	s.handlers[protocol.TypeFileUploadCommit] = s.handleFileUploadCommit
+	s.handlers[protocol.TypeHostDiagnostics] = s.handleHostDiagnostics
 	s.handlers[protocol.TypeProcessList] = s.handleProcessList
 	s.handlers[protocol.TypeProcessCreate] = s.handleProcessCreate
 	s.handlers[protocol.TypeProcessKill] = s.handleProcessKill
 	s.handlers[protocol.TypeProcessSelect] = s.handleProcessSelect
 	s.handlers[protocol.TypeProcessReattach] = s.handleProcessReattach
 	s.handlers[protocol.TypeProcessRename] = s.handleProcessRename
+	s.handlers[protocol.TypeProcessClone] = s.handleProcessClone
+	s.handlers[protocol.TypeProcessPin] = s.handleProcessPin
+	s.handlers[protocol.TypeProcessSetOrder] = s.handleProcessSetOrder
+	s.handlers[protocol.TypeProcessTermOptions] = s.handleProcessTermOptions
+	s.handlers[protocol.TypeProcessClearError] = s.handleProcessClearError
+	s.handlers[protocol.TypeProcessSetAppearance] = s.handleProcessSetAppearance
+	s.handlers[protocol.TypeProcessEnableTimeline] = s.handleProcessEnableTimeline
+	s.handlers[protocol.TypeProcessTimelineList] = s.handleProcessTimelineList
+	s.handlers[protocol.TypeArchivedProcessList] = s.handleArchivedProcessList
+	s.handlers[protocol.TypeArchivedProcessGet] = s.handleArchivedProcessGet
+	s.handlers[protocol.TypeArchivedProcessDelete] = s.handleArchivedProcessDelete
+	s.handlers[protocol.TypeProcessesSubscribe] = s.handleProcessesSubscribe
+	s.handlers[protocol.TypeProcessesUnsubscribe] = s.handleProcessesUnsubscribe
 	s.handlers[protocol.TypeClaudeStart] = s.handleClaudeStart
 	s.handlers[protocol.TypeClaudeKill] = s.handleClaudeKill
+	s.handlers[protocol.TypeClaudeReattachTerminal] = s.handleClaudeReattachTerminal
 	s.handlers[protocol.TypePtyInput] = s.handlePtyInput
 	s.handlers[protocol.TypePtyResize] = s.handlePtyResize
+	s.handlers[protocol.TypePtyScroll] = s.handlePtyScroll
 	s.handlers[protocol.TypePtyHistoryRequest] = s.handlePtyHistoryRequest
 	s.handlers[protocol.TypeChatSubscribe] = s.handleChatSubscribe
 	s.handlers[protocol.TypeChatUnsubscribe] = s.handleChatUnsubscribe
@@ -130,10 +335,15 @@ func (s *Server) registerHandlers() {
 	s.handlers[protocol.TypeChatRaw] = s.handleChatRaw
 	s.handlers[protocol.TypeChatStatus] = s.handleChatStatus
 	s.handlers[protocol.TypeChatHistory] = s.handleChatHistory
+	s.handlers[protocol.TypeChatSearch] = s.handleChatSearch
+	s.handlers[protocol.TypeChatUsage] = s.handleChatUsage
+	s.handlers[protocol.TypeChatDraftSet] = s.handleChatDraftSet
+	s.handlers[protocol.TypeChatDraftGet] = s.handleChatDraftGet
 	// Environment Variables
 	s.handlers[protocol.TypeEnvList] = s.handleEnvList
 	s.handlers[protocol.TypeEnvUpdate] = s.handleEnvUpdate
 	s.handlers[protocol.TypeEnvSetRcFile] = s.handleEnvSetRcFile
+	s.handlers[protocol.TypeEnvReveal] = s.handleEnvReveal
 	s.handlers[protocol.TypeProcessEnvList] = s.handleProcessEnvList
 	// Ports Scanning
 	s.handlers[protocol.TypePortsScan] = s.handlePortsScan
@@ -142,37 +352,104 @@ func (s *Server) registerHandlers() {
 	s.handlers[protocol.TypeSnippetCreate] = s.handleSnippetCreate
 	s.handlers[protocol.TypeSnippetUpdate] = s.handleSnippetUpdate
 	s.handlers[protocol.TypeSnippetDelete] = s.handleSnippetDelete
+	s.handlers[protocol.TypeSnippetExecute] = s.handleSnippetExecute
+	// Workspaces
+	s.handlers[protocol.TypeWorkspaceList] = s.handleWorkspaceList
+	s.handlers[protocol.TypeWorkspaceCreate] = s.handleWorkspaceCreate
+	s.handlers[protocol.TypeWorkspaceUpdate] = s.handleWorkspaceUpdate
+	s.handlers[protocol.TypeWorkspaceDelete] = s.handleWorkspaceDelete
+	s.handlers[protocol.TypeWorkspaceAssign] = s.handleWorkspaceAssign
+	s.handlers[protocol.TypeProcessTemplateList] = s.handleProcessTemplateList
+	s.handlers[protocol.TypeProcessTemplateCreate] = s.handleProcessTemplateCreate
+	s.handlers[protocol.TypeProcessTemplateUpdate] = s.handleProcessTemplateUpdate
+	s.handlers[protocol.TypeProcessTemplateDelete] = s.handleProcessTemplateDelete
+	s.handlers[protocol.TypeProcessCreateFromTemplate] = s.handleProcessCreateFromTemplate
+	// Diagnostics
+	s.handlers[protocol.TypeBridgeInfo] = s.handleBridgeInfo
+	s.handlers[protocol.TypeSessionInfo] = s.handleSessionInfo
+	s.handlers[protocol.TypeBridgeUpdateCheck] = s.handleBridgeUpdateCheck
+	s.handlers[protocol.TypeProfileList] = s.handleProfileList
+	// History encryption
+	s.handlers[protocol.TypeStorageEncryptNow] = s.handleStorageEncryptNow
+	s.handlers[protocol.TypeStorageFlush] = s.handleStorageFlush
 }
 
 // Start starts the HTTP server with WebSocket endpoint
 func (s *Server) Start() error {
-	http.HandleFunc("/ws", s.handleWebSocket)
-	http.HandleFunc("/health", s.handleHealth)
-
-	log.Printf("[INFO] WebSocket endpoint: /ws")
-	log.Printf("[INFO] Health endpoint: /health")
+	log.Printf("[INFO] WebSocket endpoint: %s", s.config.BasePath+routeWebSocket)
+	log.Printf("[INFO] Health endpoint: %s", s.config.BasePath+routeHealth)
+	log.Printf("[INFO] REST endpoint: %s (read-only)", s.config.BasePath+routeREST)
+	if s.config.ExternalURL != "" {
+		log.Printf("[INFO] External URL: %s", s.config.ExternalURL)
+	}
+	if s.config.AuthToken == "" {
+		log.Printf("[WARN] No auth token configured - WebSocket and REST endpoints are unauthenticated")
+	}
+	if s.config.AdminSocket {
+		if err := s.startAdmin(); err != nil {
+			return err
+		}
+	}
 	log.Printf("[INFO] Starting server on %s", s.addr)
 
-	return http.ListenAndServe(s.addr, nil)
+	httpServer := &http.Server{Addr: s.addr, Handler: s.Handler()}
+	s.httpServer.Store(httpServer)
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
 }
 
 // handleHealth returns server health status
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := map[string]interface{}{
+		"status":          "ok",
+		"version":         s.config.Build.Version,
+		"commit":          s.config.Build.Commit,
+		"protocolVersion": protocol.ProtocolVersion,
+		"websocket":       s.wsStats.snapshot(),
+		"handlers":        s.handlerStats.snapshot(),
+		"traffic":         s.trafficRollup(),
+		"endpoints": map[string]string{
+			"websocket": s.externalWebSocketURL(r),
+			"rest":      s.externalURL(r, routeREST),
+		},
+	}
+	if s.storage != nil {
+		// Buffers that stop being persisted are lost on the next crash
+		stats := s.storage.Stats()
+		health["storage"] = stats
+		if !stats.Healthy {
+			health["status"] = "degraded"
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"ok"}`))
+	json.NewEncoder(w).Encode(health)
 }
 
 // handleWebSocket upgrades HTTP connections to WebSocket
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := s.upgrader.Upgrade(w, r, nil)
+	conn, err := s.upgrader.Upgrade(&countingResponseWriter{ResponseWriter: w, stats: &s.wsStats}, r, nil)
 	if err != nil {
 		log.Printf("[ERROR] WebSocket upgrade failed: %v", err)
 		return
 	}
+	// gorilla compresses every write once the extension is negotiated;
+	// keep writes plain until the client opts in during auth
+	conn.EnableWriteCompression(false)
+
+	if !s.liveConns.add(conn) {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "bridge shutting down"), time.Now().Add(time.Second))
+		conn.Close()
+		return
+	}
 
-	// Create a new session - reconnection happens via auth message
-	sess := s.sessionManager.CreateSession(conn)
+	// The session stays provisional until auth, which registers it or
+	// swaps in the session it reconnects to, and grants its role
+	sess := s.sessionManager.NewProvisionalSession(conn)
 
 	remoteAddr := conn.RemoteAddr().String()
 	log.Printf("[DEBUG] [WS] New connection from %s, session=%s", remoteAddr, sess.ID)
@@ -182,15 +459,29 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		Session: sess,
 		server:  s,
 	}
-	go s.handleConnection(connSession)
+	go func() {
+		defer s.liveConns.done(conn)
+		s.handleConnection(connSession)
+	}()
 }
 
-// handleConnection handles a WebSocket connection
+// handleConnection handles a WebSocket connection. auth must come first:
+// until one succeeds every other message is refused with NOT_AUTHENTICATED,
+// and a connection that doesn't authenticate within HandshakeTimeout is
+// closed.
 func (s *Server) handleConnection(connSession *ConnectedSession) {
 	defer func() {
 		if connSession.Conn != nil {
 			connSession.Conn.Close()
 		}
+		if !connSession.authenticated() {
+			log.Printf("[DEBUG] [WS] Provisional session %s closed before authenticating", connSession.ID)
+			return
+		}
+
+		// Chunks sent from now on would be lost, and leave a reconnected
+		// client with a file it can't reassemble
+		s.downloads.cancelSession(connSession.ID)
 
 		// Detach all PTY sessions for this session's hosts (but don't kill them)
 		// This allows processes to continue running and be reattached on reconnect
@@ -203,9 +494,29 @@ func (s *Server) handleConnection(connSession *ConnectedSession) {
 
 	remoteAddr := connSession.Conn.RemoteAddr().String()
 
+	// Handlers run off the read loop; wait for them before detaching processes
+	d := newDispatcher(s, connSession)
+	defer d.close()
+
+	stopHeartbeat := make(chan struct{})
+	defer close(stopHeartbeat)
+	s.startHeartbeat(connSession, stopHeartbeat)
+
+	handshaking := s.config.HandshakeTimeout > 0
+	if handshaking {
+		connSession.Conn.SetReadDeadline(time.Now().Add(s.config.HandshakeTimeout))
+	}
+
 	for {
 		messageType, message, err := connSession.Conn.ReadMessage()
 		if err != nil {
+			var netErr net.Error
+			if handshaking && errors.As(err, &netErr) && netErr.Timeout() {
+				log.Printf("[WARN] [WS] %s did not authenticate within %v, closing", remoteAddr, s.config.HandshakeTimeout)
+				connSession.Conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "auth timeout"), time.Now().Add(time.Second))
+				return
+			}
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("[ERROR] [WS] Read error from %s: %v", remoteAddr, err)
 			} else {
@@ -215,14 +526,20 @@ func (s *Server) handleConnection(connSession *ConnectedSession) {
 		}
 
 		if messageType == websocket.TextMessage {
-			log.Printf("[DEBUG] [WS] Received from %s: %s", remoteAddr, string(message))
 			connSession.LastSeenAt = time.Now()
 
 			// Parse message
 			var msg protocol.Message
 			if err := json.Unmarshal(message, &msg); err != nil {
-				log.Printf("[ERROR] [WS] Failed to parse message: %v", err)
-				connSession.SendError("INVALID_MESSAGE", "Failed to parse message")
+				log.Printf("[ERROR] [WS] Failed to parse message from %s: %v: %s", remoteAddr, err, string(message))
+				connSession.SendErrorDetails(protocol.ErrorInvalidMessage, protocol.ErrorDetails{"reason": err.Error()})
+				continue
+			}
+			log.Printf("[DEBUG] [WS] Received from %s: %s", remoteAddr, loggableMessage(msg.Type, message))
+
+			if msg.Type != protocol.TypeAuth && !connSession.authenticated() {
+				log.Printf("[WARN] [WS] Refused %s from %s before auth", msg.Type, remoteAddr)
+				connSession.SendErrorDetails(protocol.ErrorNotAuthenticated, protocol.ErrorDetails{"type": msg.Type})
 				continue
 			}
 
@@ -230,23 +547,49 @@ func (s *Server) handleConnection(connSession *ConnectedSession) {
 			handler, ok := s.handlers[msg.Type]
 			if !ok {
 				log.Printf("[WARN] [WS] Unknown message type: %s", msg.Type)
-				connSession.SendError("UNKNOWN_MESSAGE_TYPE", "Unknown message type: "+msg.Type)
+				connSession.SendErrorDetails(protocol.ErrorUnknownMessageType, protocol.ErrorDetails{"type": msg.Type})
 				continue
 			}
 
-			if err := handler(connSession, &msg); err != nil {
-				log.Printf("[ERROR] [WS] Handler error for %s: %v", msg.Type, err)
-				connSession.SendError("HANDLER_ERROR", err.Error())
+			d.dispatch(handler, &msg)
+
+			// auth ran on this goroutine, so its outcome is known here
+			if handshaking && connSession.authenticated() {
+				connSession.Conn.SetReadDeadline(time.Time{})
+				handshaking = false
 			}
 		}
 	}
 }
 
+// authenticated reports whether the session's connection completed an auth;
+// only a successful one grants a role
+func (cs *ConnectedSession) authenticated() bool {
+	role, _ := cs.Role()
+	return role != session.RoleNone
+}
+
 // Send sends a message to the client
 func (cs *ConnectedSession) Send(msg *protocol.Message) error {
 	cs.Session.Lock()
 	defer cs.Session.Unlock()
+	return cs.write(msg)
+}
+
+// sendBackground sends a message that can wait, such as a history chunk. It
+// is written only while no Send is waiting, so live output and lifecycle
+// messages go ahead of it.
+func (cs *ConnectedSession) sendBackground(msg *protocol.Message) error {
+	cs.Session.LockBackground()
+	defer cs.Session.Unlock()
+	return cs.write(msg)
+}
 
+// write writes a message to the connection; the session lock must be held
+func (cs *ConnectedSession) write(msg *protocol.Message) error {
+	if cs.sink != nil {
+		return cs.sink(msg)
+	}
 	if cs.Conn == nil {
 		return nil // Connection closed, silently ignore
 	}
@@ -256,20 +599,118 @@ func (cs *ConnectedSession) Send(msg *protocol.Message) error {
 		return err
 	}
 
-	log.Printf("[DEBUG] [WS] Sending to session %s: %s", cs.ID, string(data))
+	log.Printf("[DEBUG] [WS] Sending to session %s: %s", cs.ID, loggableMessage(msg.Type, data))
+	// Toggled per frame under the session lock, so the compressor is never
+	// shared between concurrent writers
+	if cs.Compression {
+		cs.Conn.EnableWriteCompression(len(data) >= cs.server.config.WSCompressionThreshold)
+	}
+	cs.server.wsStats.payloadBytes.Add(uint64(len(data)))
 	return cs.Conn.WriteMessage(websocket.TextMessage, data)
 }
 
-// SendError sends an error message to the client
-func (cs *ConnectedSession) SendError(code, message string) error {
+// SendError sends an error without details to the client
+func (cs *ConnectedSession) SendError(code protocol.ErrorCode) error {
+	return cs.SendErrorDetails(code, nil)
+}
+
+// unknownErrorCode is called when a handler sends a code outside
+// protocol.ErrorCodes. Tests replace it to fail loudly.
+var unknownErrorCode = func(code protocol.ErrorCode) {
+	log.Printf("[ERROR] [WS] Sending unknown error code %q", code)
+}
+
+// SendErrorDetails sends an error with structured details to the client. The
+// message is the code's, in the locale the client asked for at auth; the
+// details say what happened, with a "reason" where there is one.
+func (cs *ConnectedSession) SendErrorDetails(code protocol.ErrorCode, details interface{}) error {
+	if !code.Valid() {
+		unknownErrorCode(code)
+	}
+	cs.Session.Lock()
+	defer cs.Session.Unlock()
 	msg, err := protocol.NewMessage(protocol.TypeError, protocol.ErrorPayload{
 		Code:    code,
-		Message: message,
+		Message: cs.server.catalog.Message(cs.Locale, code),
+		Details: details,
 	})
 	if err != nil {
 		return err
 	}
-	return cs.Send(msg)
+	return cs.write(msg)
+}
+
+// sendProcessNotFound reports a process ID the registry doesn't know
+func (cs *ConnectedSession) sendProcessNotFound(processID string) error {
+	return cs.SendErrorDetails(protocol.ErrorNotFound, protocol.ErrorDetails{"processId": processID})
+}
+
+// sendHostNotConnected reports a host without a live SSH connection
+func (cs *ConnectedSession) sendHostNotConnected(hostID string) error {
+	return cs.SendErrorDetails(protocol.ErrorNotConnected, protocol.ErrorDetails{"hostId": hostID})
+}
+
+// sendAgentError reports a failed AgentAPI request for a process: a busy
+// agent, an AgentAPI that is down or failing, or a refused message
+func (cs *ConnectedSession) sendAgentError(processID string, err error) error {
+	return cs.sendFailure(agentFailure(processID, err))
+}
+
+// agentFailure is the error code and details for a failed AgentAPI request,
+// see sendAgentError
+func agentFailure(processID string, err error) *requestFailure {
+	details := protocol.ErrorDetails{"processId": processID, "reason": err.Error()}
+	var responseErr *agentapi.ResponseError
+	if errors.As(err, &responseErr) {
+		details["status"] = responseErr.Status
+	}
+	switch {
+	case errors.Is(err, agentapi.ErrAgentBusy):
+		return &requestFailure{protocol.ErrorAgentBusy, details}
+	case errors.Is(err, agentapi.ErrUnreachable), errors.Is(err, agentapi.ErrServerError):
+		return &requestFailure{protocol.ErrorAgentAPIDown, details}
+	}
+	return &requestFailure{protocol.ErrorSendFailed, details}
+}
+
+// requestFailure is why a step shared by several handlers failed, as the
+// error code and details a handler sends for it
+type requestFailure struct {
+	code    protocol.ErrorCode
+	details interface{}
+}
+
+// Error returns the failure's reason, or its code when it has none
+func (f *requestFailure) Error() string {
+	switch details := f.details.(type) {
+	case protocol.ErrorDetails:
+		if reason, ok := details["reason"].(string); ok {
+			return reason
+		}
+	case protocol.InvalidArgsDetails:
+		return details.Reason
+	}
+	return string(f.code)
+}
+
+// newFailure is a failure with only a reason as its details
+func newFailure(code protocol.ErrorCode, reason string) *requestFailure {
+	return &requestFailure{code, protocol.ErrorDetails{"reason": reason}}
+}
+
+// failureCode returns the code of err when it is a requestFailure, or
+// fallback, for a result payload's Code
+func failureCode(err error, fallback protocol.ErrorCode) protocol.ErrorCode {
+	var failure *requestFailure
+	if errors.As(err, &failure) {
+		return failure.code
+	}
+	return fallback
+}
+
+// sendFailure reports a failed step to the client as an error
+func (cs *ConnectedSession) sendFailure(f *requestFailure) error {
+	return cs.SendErrorDetails(f.code, f.details)
 }
 
 // ============================================================================
@@ -283,6 +724,52 @@ func (s *Server) handleAuth(connSession *ConnectedSession, msg *protocol.Message
 		log.Printf("[DEBUG] [AUTH] Session %s authenticating (new session)", connSession.ID)
 	}
 
+	// A connection authenticates once. Swapping in another session later
+	// would leave its attached processes forwarding output to the old one,
+	// so a client resumes a session, or changes role, on a new connection.
+	if connSession.authenticated() {
+		log.Printf("[WARN] [AUTH] Session %s sent auth again, refused", connSession.ID)
+		response, err := protocol.NewMessage(protocol.TypeAuthResult, protocol.AuthResultPayload{
+			Success:         false,
+			ServerVersion:   s.config.Build.Version,
+			ProtocolVersion: protocol.ProtocolVersion,
+			Profile:         s.config.Profile,
+			Code:            protocol.ErrorInvalidState,
+			Error:           strPtr("Connection is already authenticated; open a new one to resume another session"),
+		})
+		if err != nil {
+			return err
+		}
+		return connSession.Send(response)
+	}
+
+	role, scope, inviteID, authErr := s.authenticate(payload)
+	if authErr == nil {
+		authErr = checkProtocolVersion(payload.ProtocolVersion)
+	}
+	if authErr != nil {
+		log.Printf("[WARN] [AUTH] Session %s failed to authenticate: %s", connSession.ID, authErr)
+		response, err := protocol.NewMessage(protocol.TypeAuthResult, protocol.AuthResultPayload{
+			Success:         false,
+			ServerVersion:   s.config.Build.Version,
+			ProtocolVersion: protocol.ProtocolVersion,
+			Profile:         s.config.Profile,
+			Code:            authErr.code,
+			Error:           strPtr(authErr.Error()),
+		})
+		if err != nil {
+			return err
+		}
+		return connSession.Send(response)
+	}
+
+	now := time.Now()
+	var clockSkewMs *int64
+	if payload.ClientTimestamp != nil {
+		skew := measureClockSkew(connSession.ID, now, *payload.ClientTimestamp).Milliseconds()
+		clockSkewMs = &skew
+	}
+
 	var reconnected bool
 	var finalSession *ConnectedSession = connSession
 
@@ -293,14 +780,16 @@ func (s *Server) handleAuth(connSession *ConnectedSession, msg *protocol.Message
 		// Try to reconnect using the token
 		existingSession := s.sessionManager.Reconnect(*payload.ReconnectToken, connSession.Conn)
 		if existingSession != nil {
-			// Successful reconnection - remove the new session that was created on connect
+			// Successful reconnection - drop the connection's own,
+			// provisional session
 			s.sessionManager.RemoveSession(connSession.ID)
 
-			// Use the existing session
-			finalSession = &ConnectedSession{
-				Session: existingSession,
-				server:  s,
-			}
+			// Adopt the existing session for the rest of this connection, so
+			// later messages and the disconnect on close apply to it (along
+			// with its chat subscriptions). auth runs as a dispatch barrier
+			// and this is the connection's first, so no handler or output
+			// forwarder is using connSession yet.
+			connSession.Session = existingSession
 			reconnected = true
 			log.Printf("[INFO] [AUTH] Session %s reconnected successfully", existingSession.ID)
 		} else {
@@ -308,14 +797,36 @@ func (s *Server) handleAuth(connSession *ConnectedSession, msg *protocol.Message
 		}
 	}
 
+	if !reconnected {
+		// Listed and given a reconnect token only now
+		s.sessionManager.Register(finalSession.Session)
+	}
+	finalSession.SetRole(role, scope, inviteID)
+	s.configureCompression(finalSession, payload.Compression)
+	locale := s.configureLocale(finalSession, payload.Locale)
+	s.configureDevice(finalSession, payload)
+	capabilities := s.configureCapabilities(finalSession, payload.Capabilities)
+
 	sessionID := finalSession.ID
 	reconnectToken := finalSession.ReconnectToken
+	tokenExpiresAt := finalSession.TokenExpiresAt.UTC().Format(time.RFC3339)
 
 	response, err := protocol.NewMessage(protocol.TypeAuthResult, protocol.AuthResultPayload{
-		Success:        true,
-		SessionID:      &sessionID,
-		ReconnectToken: &reconnectToken,
-		Reconnected:    reconnected,
+		Success:         true,
+		SessionID:       &sessionID,
+		ReconnectToken:  &reconnectToken,
+		TokenExpiresAt:  &tokenExpiresAt,
+		Reconnected:     reconnected,
+		Compression:     finalSession.Compression,
+		ServerVersion:   s.config.Build.Version,
+		ProtocolVersion: protocol.ProtocolVersion,
+		Profile:         s.config.Profile,
+		ServerTimestamp: now.UnixMilli(),
+		ClockSkewMs:     clockSkewMs,
+		Locale:          locale,
+		Role:            string(role),
+		Scope:           protocolScope(scope),
+		Capabilities:    capabilities,
 	})
 	if err != nil {
 		return err
@@ -325,13 +836,165 @@ func (s *Server) handleAuth(connSession *ConnectedSession, msg *protocol.Message
 		return err
 	}
 
+	// An observer only watches: reattaching processes would move their
+	// output away from the owner
+	if role == session.RoleObserver {
+		return nil
+	}
+
 	// Send current state of all connected hosts
 	// This ensures frontend knows what's already connected after app restart
 	s.sendCurrentHostStates(finalSession)
 
+	if reconnected {
+		s.restoreSessionView(finalSession)
+	}
+
+	return nil
+}
+
+// redactedMessageTypes carry env var values in the clear, so their payloads
+// are left out of debug logs: every request whose payload has an EnvVar (see
+// TestRedactedMessageTypes), and env_reveal_result. Other results mask secret
+// values.
+var redactedMessageTypes = map[string]bool{
+	protocol.TypeEnvUpdate:                 true,
+	protocol.TypeEnvRevealResult:           true,
+	protocol.TypeProcessTemplateCreate:     true,
+	protocol.TypeProcessTemplateUpdate:     true,
+	protocol.TypeProcessCreateFromTemplate: true,
+}
+
+// loggableMessage returns a raw message for debug logging, redacting payloads
+// that may contain secrets
+func loggableMessage(msgType string, data []byte) string {
+	if redactedMessageTypes[msgType] {
+		return fmt.Sprintf(`{"type":%q,"payload":"<redacted>"}`, msgType)
+	}
+	return string(data)
+}
+
+// authenticate returns the role and scope an auth payload's credential
+// grants, or why it grants none. An invite grants what it was created with;
+// the bridge's auth token, or none when the bridge has none, grants the
+// owner. Either can be lowered to observer by asking for it.
+func (s *Server) authenticate(payload protocol.AuthPayload) (role session.Role, scope session.Scope, inviteID string, failure *requestFailure) {
+	if payload.InviteToken != nil && *payload.InviteToken != "" {
+		invite, err := s.lookupInvite(*payload.InviteToken)
+		if err != nil {
+			log.Printf("[ERROR] [AUTH] Failed to look up session invite: %v", err)
+			return session.RoleNone, session.Scope{}, "", newFailure(protocol.ErrorStorageError, "Invite could not be checked")
+		}
+		if invite == nil {
+			return session.RoleNone, session.Scope{}, "", newFailure(protocol.ErrorUnauthorized, "Invalid or expired invite")
+		}
+		role = session.Role(invite.Role)
+		scope = session.Scope{HostID: invite.HostID, ProcessID: invite.ProcessID}
+		inviteID = invite.ID
+	} else {
+		var token string
+		if payload.Token != nil {
+			token = *payload.Token
+		}
+		if !s.checkAuthToken(token) {
+			return session.RoleNone, session.Scope{}, "", newFailure(protocol.ErrorUnauthorized, "Invalid auth token")
+		}
+		role = session.RoleOwner
+	}
+
+	if payload.Role != nil && session.Role(*payload.Role) == session.RoleObserver {
+		role = session.RoleObserver
+	}
+	return role, scope, inviteID, nil
+}
+
+// checkProtocolVersion returns why a client speaking the given protocol
+// version can't be served, or nil when it can. Older clients are turned away;
+// a newer one learns the bridge's version from auth_result and decides
+// whether it can speak it.
+func checkProtocolVersion(version *int) *requestFailure {
+	clientVersion := 1
+	if version != nil {
+		clientVersion = *version
+	}
+	if clientVersion < protocol.ProtocolVersion {
+		return newFailure(protocol.ErrorProtocolMismatch, fmt.Sprintf("Client speaks protocol version %d; this bridge needs version %d, update the app", clientVersion, protocol.ProtocolVersion))
+	}
 	return nil
 }
 
+// protocolScope converts a session's scope for the client, or nil when it
+// is unlimited
+func protocolScope(scope session.Scope) *protocol.SessionScope {
+	if scope.Unlimited() {
+		return nil
+	}
+	return &protocol.SessionScope{HostID: nullableString(scope.HostID), ProcessID: nullableString(scope.ProcessID)}
+}
+
+// checkAuthToken reports whether token matches the configured auth token.
+// When no token is configured every client is accepted.
+func (s *Server) checkAuthToken(token string) bool {
+	if s.config.AuthToken == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AuthToken)) == 1
+}
+
+// configureLocale records the locale a session's error messages are sent in,
+// and returns the catalog locale they will come in. Like compression it is
+// renegotiated by every auth.
+func (s *Server) configureLocale(session *ConnectedSession, requested *string) string {
+	session.Lock()
+	defer session.Unlock()
+
+	session.Locale = ""
+	if requested != nil {
+		session.Locale = *requested
+	}
+	return s.catalog.Resolve(session.Locale)
+}
+
+// configureDevice records the device a session's client declared: its label
+// and the terminal size for processes it doesn't give one. Like the locale it
+// is renegotiated by every auth.
+func (s *Server) configureDevice(session *ConnectedSession, payload protocol.AuthPayload) {
+	session.Lock()
+	session.DeviceLabel = derefString(payload.DeviceLabel)
+	session.Unlock()
+
+	var cols, rows int
+	if payload.DefaultCols != nil {
+		cols = *payload.DefaultCols
+	}
+	if payload.DefaultRows != nil {
+		rows = *payload.DefaultRows
+	}
+	session.SetDefaultTermSize(cols, rows)
+	if payload.DeviceLabel != nil || cols != 0 || rows != 0 {
+		log.Printf("[DEBUG] [AUTH] Session %s is device %q, default terminal %dx%d", session.ID, derefString(payload.DeviceLabel), cols, rows)
+	}
+}
+
+// configureCompression enables per-frame write compression for a session when
+// the client requested it and the extension was negotiated on this connection
+func (s *Server) configureCompression(session *ConnectedSession, requested bool) {
+	session.Lock()
+	defer session.Unlock()
+
+	session.Compression = false
+	if !requested || !s.config.WSCompression || session.Conn == nil {
+		return
+	}
+	if err := session.Conn.SetCompressionLevel(s.config.WSCompressionLevel); err != nil {
+		log.Printf("[WARN] [AUTH] Invalid compression level %d: %v", s.config.WSCompressionLevel, err)
+		return
+	}
+	session.Compression = true
+	log.Printf("[DEBUG] [AUTH] Session %s enabled compression (level=%d, threshold=%d)",
+		session.ID, s.config.WSCompressionLevel, s.config.WSCompressionThreshold)
+}
+
 // sendCurrentHostStates sends HOST_STATUS for all connected SSH hosts
 // It also reattaches any detached PTY sessions to the new WebSocket session
 func (s *Server) sendCurrentHostStates(session *ConnectedSession) {
@@ -347,29 +1010,39 @@ func (s *Server) sendCurrentHostStates(session *ConnectedSession) {
 		// Check if SSH connection is actually alive
 		if !sshConn.IsAlive() {
 			log.Printf("[WARN] [AUTH] SSH connection for host %s is dead, skipping", hostID)
-			// Clean up dead connection and its processes
-			procs := s.processRegistry.GetByHost(hostID)
-			for _, proc := range procs {
-				s.processRegistry.Unregister(proc.ID)
-			}
-			s.sshManager.Disconnect(hostID)
+			// Clean up dead connection and its processes, as if the
+			// keepalive had noticed
+			s.teardownHost(hostID)
+			s.broadcastHostDisconnected(hostID, protocol.HostDisconnectKeepaliveFailed, strPtr("SSH connection is no longer alive"))
 			continue
 		}
 
 		// Get processes for this host from process registry
 		processes := s.processRegistry.GetByHost(hostID)
-		processInfos := make([]protocol.ProcessInfo, 0, len(processes))
+		reported := make([]*process.Process, 0, len(processes))
 		var staleProcesses []protocol.StaleProcess
 
+		// Reattach detached PTYs first, a few at a time
+		var detached []*process.Process
+		for _, proc := range processes {
+			if proc.PTY != nil && !proc.PTY.IsAttached() {
+				log.Printf("[DEBUG] [AUTH] Process %s PTY not attached, attempting reattach", proc.ID)
+				detached = append(detached, proc)
+			}
+		}
+		reattachErrs := make(map[string]error)
+		reattach := s.reattachProcesses(session, detached, sshConn, func(proc *process.Process, err error) {
+			reattachErrs[proc.ID] = err
+		})
+
 		for _, proc := range processes {
 			if proc.PTY == nil {
 				continue
 			}
 
-			// Check if PTY is attached - if not, try to reattach
-			if !proc.PTY.IsAttached() {
-				log.Printf("[DEBUG] [AUTH] Process %s PTY not attached, attempting reattach", proc.ID)
-				if err := s.reattachProcess(session, proc, sshConn.Client); err != nil {
+			// Check if PTY is attached - if not, see how reattaching it went
+			if err, ok := reattachErrs[proc.ID]; ok {
+				if err != nil {
 					log.Printf("[WARN] [AUTH] Failed to reattach process %s: %v", proc.ID, err)
 					// Report as stale/detached process
 					tmuxName := proc.PTY.TmuxName
@@ -381,12 +1054,14 @@ func (s *Server) sendCurrentHostStates(session *ConnectedSession) {
 						StartedAt:   &startedAt,
 					}
 					// Include port if this was a Claude process
-					if proc.Port != nil {
-						stale.Port = *proc.Port
+					if port, ok := proc.GetPort(); ok {
+						stale.Port = port
 					}
 					staleProcesses = append(staleProcesses, stale)
-					// Unregister from registry since it needs manual reattach
+					// Unregister from registry since it needs manual reattach;
+					// that also stops its SSE stream, left on the dead tunnel
 					s.processRegistry.Unregister(proc.ID)
+					s.publishProcessRemoved(hostID, proc.ID, protocol.ProcessRemovedDetached, session)
 					continue
 				}
 				log.Printf("[INFO] [AUTH] Successfully reattached process %s", proc.ID)
@@ -398,25 +1073,21 @@ func (s *Server) sendCurrentHostStates(session *ConnectedSession) {
 			}
 
 			// If this is a Claude process, restore/update AgentAPI clients
-			if proc.Type == process.TypeClaude && proc.Port != nil {
-				port := *proc.Port
-				if proc.SSEClient != nil {
+			if port, ok := proc.GetPort(); ok && proc.GetType() == process.TypeClaude {
+				if proc.SetEventHandler(func(event agentapi.SSEEvent) {
+					s.handleAgentAPIEvent(proc.HostID, proc.ID, event)
+				}) {
 					// SSE client exists, just update the handler
-					log.Printf("[DEBUG] [AUTH] Updating SSE handler for Claude process %s", proc.ID)
-					proc.SSEClient.SetHandler(func(event agentapi.SSEEvent) {
-						s.handleAgentAPIEvent(session, proc.HostID, proc.ID, event)
-					})
+					log.Printf("[DEBUG] [AUTH] Updated SSE handler for Claude process %s", proc.ID)
 				} else {
 					// SSE client doesn't exist, need to restore AgentAPI clients
 					log.Printf("[DEBUG] [AUTH] Restoring AgentAPI clients for Claude process %s on port %d", proc.ID, port)
 
 					// Create new AgentAPI client
-					agentClient := agentapi.NewClient(sshConn.Client, port)
+					agentClient := agentapi.NewClient(sshConn, port)
 
-					// Create new SSE client with event handler pointing to new session
-					sseClient := agentapi.NewSSEClient(sshConn.Client, port, func(event agentapi.SSEEvent) {
-						s.handleAgentAPIEvent(session, proc.HostID, proc.ID, event)
-					})
+					// Create new SSE client; events go to whichever sessions are subscribed
+					sseClient := s.newSSEClient(sshConn, proc, port)
 
 					// Store new clients
 					proc.SetAgentClients(agentClient, sseClient)
@@ -431,72 +1102,69 @@ func (s *Server) sendCurrentHostStates(session *ConnectedSession) {
 					if err != nil {
 						log.Printf("[WARN] [AUTH] AgentAPI not responding for process %s: %v", proc.ID, err)
 						proc.SetAgentAPIReady(false)
+						proc.SetAgentStatus("", "")
 					} else {
 						log.Printf("[INFO] [AUTH] AgentAPI reconnected for process %s: status=%s", proc.ID, status.Status)
 						proc.SetAgentAPIReady(true)
+						proc.SetAgentStatus(status.Status, status.AgentType)
 					}
 				}
 			}
 
-			// Refresh CWD from tmux before sending
-			proc.RefreshCWD()
-
 			// Process is attached (or was just reattached), report it
-			processInfos = append(processInfos, protocol.ProcessInfo{
-				ID:            proc.ID,
-				Type:          protocol.ProcessType(proc.Type),
-				HostID:        proc.HostID,
-				CWD:           proc.CWD,
-				Name:          proc.Name,
-				Port:          proc.Port,
-				PtyReady:      proc.PtyReady,
-				AgentAPIReady: proc.AgentAPIReady,
-				ShellPID:      proc.ShellPID,
-				AgentAPIPID:   proc.AgentAPIPID,
-				StartedAt:     proc.StartedAt.Format("2006-01-02T15:04:05Z07:00"),
-			})
+			reported = append(reported, proc)
 		}
 
-		// Store stale processes in registry for later updates
-		s.processRegistry.SetStaleProcesses(hostID, staleProcesses)
+		// Refresh CWDs from tmux before sending, in one batch for the host
+		process.RefreshCWDs(reported)
+
+		processInfos := make([]protocol.ProcessInfo, 0, len(reported))
+		for _, proc := range reported {
+			processInfos = append(processInfos, proc.ToInfo())
+		}
 
-		// Check requirements (claude and agentapi installation)
-		requirements := pty.CheckRequirements(sshConn.Client)
+		// Merge newly detached processes into the registry and report all of
+		// the host's stale processes, including ones found earlier
+		s.processRegistry.MergeStaleProcesses(hostID, staleProcesses)
+		if len(staleProcesses) > 0 {
+			s.publishStaleProcessesChanged(hostID, session)
+		}
+		staleProcesses = s.processRegistry.GetStaleProcesses(hostID)
 
 		var stalePtr *[]protocol.StaleProcess
 		if len(staleProcesses) > 0 {
 			stalePtr = &staleProcesses
 		}
 
-		msg, err := protocol.NewMessage(protocol.TypeHostStatus, protocol.HostStatusPayload{
+		if err := session.sendScopedHostStatus(protocol.HostStatusPayload{
 			HostID:         hostID,
 			Connected:      true,
 			Processes:      processInfos,
 			StaleProcesses: stalePtr,
-			Requirements:   requirements,
-		})
-		if err != nil {
-			log.Printf("[ERROR] [AUTH] Failed to create host status message: %v", err)
+			Requirements:   s.cachedRequirements(hostID),
+			Channels:       channelUsage(sshConn),
+			Reattach:       reattach,
+			Platform:       s.hostPlatformInfo(hostID),
+		}); err != nil {
+			log.Printf("[ERROR] [AUTH] Failed to send host status: %v", err)
 			continue
 		}
+		log.Printf("[DEBUG] [AUTH] Sent HOST_STATUS for %s with %d processes, %d stale", hostID, len(processInfos), len(staleProcesses))
 
-		if err := session.Send(msg); err != nil {
-			log.Printf("[ERROR] [AUTH] Failed to send host status: %v", err)
-		} else {
-			log.Printf("[DEBUG] [AUTH] Sent HOST_STATUS for %s with %d processes, %d stale", hostID, len(processInfos), len(staleProcesses))
-		}
+		// Fresh requirements (claude and agentapi installation) follow
+		s.refreshRequirements(session, hostID, sshConn.Client)
 	}
 }
 
-// sendHostStatus sends a HOST_STATUS message with current processes and stale processes for a host
+// sendHostStatus sends a HOST_STATUS message with current processes and stale processes for a host.
 func (s *Server) sendHostStatus(connSession *ConnectedSession, hostID string) error {
 	// Get all active processes for this host
 	processes := s.processRegistry.GetByHost(hostID)
 	processInfos := make([]protocol.ProcessInfo, 0, len(processes))
 
+	// Refresh CWDs from tmux before sending
+	process.RefreshCWDs(processes)
 	for _, proc := range processes {
-		// Refresh CWD from tmux before sending
-		proc.RefreshCWD()
 		processInfos = append(processInfos, proc.ToInfo())
 	}
 
@@ -508,25 +1176,40 @@ func (s *Server) sendHostStatus(connSession *ConnectedSession, hostID string) er
 		stalePtr = &staleProcesses
 	}
 
-	// Check requirements if we have an SSH connection
-	var requirements *protocol.HostRequirements
-	if sshConn := s.sshManager.GetConnection(hostID); sshConn != nil {
-		requirements = pty.CheckRequirements(sshConn.Client)
+	// The last requirements go with the status, and fresh ones follow
+	sshConn := s.sshManager.GetConnection(hostID)
+	var channels *protocol.SSHChannelUsage
+	if sshConn != nil {
+		channels = channelUsage(sshConn)
 	}
 
-	msg, err := protocol.NewMessage(protocol.TypeHostStatus, protocol.HostStatusPayload{
+	if err := connSession.sendScopedHostStatus(protocol.HostStatusPayload{
 		HostID:         hostID,
 		Connected:      true,
 		Processes:      processInfos,
 		StaleProcesses: stalePtr,
-		Requirements:   requirements,
-	})
-	if err != nil {
+		Requirements:   s.cachedRequirements(hostID),
+		Channels:       channels,
+		Platform:       s.hostPlatformInfo(hostID),
+	}); err != nil {
 		return err
 	}
-
 	log.Printf("[DEBUG] [HOST] Sent HOST_STATUS for %s with %d processes, %d stale", hostID, len(processInfos), len(staleProcesses))
-	return connSession.Send(msg)
+	if sshConn != nil {
+		s.refreshRequirements(connSession, hostID, sshConn.Client)
+	}
+	return nil
+}
+
+// channelUsage reports the channels open on a host's SSH connections
+func channelUsage(conn *ssh.Connection) *protocol.SSHChannelUsage {
+	usage := conn.ChannelUsage()
+	return &protocol.SSHChannelUsage{
+		Open:              usage.Open,
+		Limit:             usage.Limit,
+		TunnelConnections: usage.TunnelConnections,
+		TunnelOpen:        usage.TunnelOpen,
+	}
 }
 
 // ============================================================================
@@ -543,22 +1226,30 @@ func (s *Server) handleHostConfigList(connSession *ConnectedSession, msg *protoc
 	// Convert to protocol format (without credentials)
 	configHosts := make([]protocol.SSHHostConfig, len(hosts))
 	for i, h := range hosts {
-		configHosts[i] = protocol.SSHHostConfig{
-			ID:          h.ID,
-			Name:        h.Name,
-			Host:        h.Host,
-			Port:        h.Port,
-			Username:    h.Username,
-			AuthType:    h.AuthType,
-			AutoConnect: h.AutoConnect,
-			CreatedAt:   h.CreatedAt.Format(time.RFC3339),
-			UpdatedAt:   h.UpdatedAt.Format(time.RFC3339),
-		}
+		configHosts[i] = s.sshHostConfig(h)
 	}
 
 	return s.sendHostConfigListResult(connSession, configHosts, nil)
 }
 
+// toSSHHostConfig converts a stored host to its protocol form (credentials omitted)
+func toSSHHostConfig(h storage.SSHHost) protocol.SSHHostConfig {
+	return protocol.SSHHostConfig{
+		ID:                h.ID,
+		Name:              h.Name,
+		Host:              h.Host,
+		Port:              h.Port,
+		Username:          h.Username,
+		AuthType:          h.AuthType,
+		CredentialBackend: credentialBackend(h),
+		AutoConnect:       h.AutoConnect,
+		Color:             h.Color,
+		Icon:              h.Icon,
+		CreatedAt:         h.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:         h.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
 func (s *Server) sendHostConfigListResult(connSession *ConnectedSession, hosts []protocol.SSHHostConfig, err error) error {
 	if hosts == nil {
 		hosts = []protocol.SSHHostConfig{}
@@ -572,61 +1263,67 @@ func (s *Server) sendHostConfigListResult(connSession *ConnectedSession, hosts [
 func (s *Server) handleHostConfigCreate(connSession *ConnectedSession, msg *protocol.Message) error {
 	var payload protocol.HostConfigCreatePayload
 	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-		return s.sendHostConfigCreateResult(connSession, nil, fmt.Errorf("invalid payload: %w", err))
-	}
-
-	// Validate required fields
-	if payload.Name == "" || payload.Host == "" || payload.Username == "" || payload.Credential == "" {
-		return s.sendHostConfigCreateResult(connSession, nil, fmt.Errorf("missing required fields"))
+		return s.sendHostConfigCreateResult(connSession, nil, newFailure(protocol.ErrorInvalidArgs, "invalid payload: "+err.Error()))
 	}
 
-	// Encrypt credential
-	encryptedCred, err := crypto.EncryptString(payload.Credential)
-	if err != nil {
-		log.Printf("[ERROR] [HOST_CONFIG] Failed to encrypt credential: %v", err)
-		return s.sendHostConfigCreateResult(connSession, nil, fmt.Errorf("failed to encrypt credential"))
+	// Validate required fields; the SSH agent needs no credential, and a
+	// read-only backend provides it
+	needsCredential := payload.AuthType != "agent" && !s.credentials.Active().ReadOnly()
+	if payload.Name == "" || payload.Host == "" || payload.Username == "" || (payload.Credential == "" && needsCredential) {
+		return s.sendHostConfigCreateResult(connSession, nil, newFailure(protocol.ErrorInvalidArgs, "missing required fields"))
 	}
 
-	// Generate ID
-	hostID := fmt.Sprintf("host_%d_%s", time.Now().UnixMilli(), uuid.New().String()[:8])
-
 	autoConnect := false
 	if payload.AutoConnect != nil {
 		autoConnect = *payload.AutoConnect
 	}
 
-	// Create host record
-	host := storage.SSHHost{
-		ID:                  hostID,
-		Name:                payload.Name,
-		Host:                payload.Host,
-		Port:                payload.Port,
-		Username:            payload.Username,
-		AuthType:            payload.AuthType,
-		CredentialEncrypted: encryptedCred,
-		AutoConnect:         autoConnect,
+	configHost, err := s.createSSHHost(storage.SSHHost{
+		Name:        payload.Name,
+		Host:        payload.Host,
+		Port:        payload.Port,
+		Username:    payload.Username,
+		AuthType:    payload.AuthType,
+		AutoConnect: autoConnect,
+		Appearance:  storage.Appearance{Color: payload.Color, Icon: payload.Icon},
+	}, payload.Credential)
+	return s.sendHostConfigCreateResult(connSession, configHost, err)
+}
+
+// createSSHHost stores a new host config with its credential in the active
+// backend, and returns it in protocol form (without the credential)
+func (s *Server) createSSHHost(host storage.SSHHost, credential string) (*protocol.SSHHostConfig, error) {
+	// Generate ID
+	host.ID = fmt.Sprintf("host_%d_%s", time.Now().UnixMilli(), uuid.New().String()[:8])
+
+	if err := s.storeCredential(&host, credential); err != nil {
+		log.Printf("[ERROR] [HOST_CONFIG] Failed to store credential: %v", err)
+		return nil, credentialError(err)
 	}
 
 	if err := s.storage.CreateSSHHost(host); err != nil {
 		log.Printf("[ERROR] [HOST_CONFIG] Failed to create host: %v", err)
-		return s.sendHostConfigCreateResult(connSession, nil, fmt.Errorf("failed to create host"))
+		return nil, fmt.Errorf("failed to create host")
 	}
 
 	// Return created host (without credential)
 	configHost := &protocol.SSHHostConfig{
-		ID:          host.ID,
-		Name:        host.Name,
-		Host:        host.Host,
-		Port:        host.Port,
-		Username:    host.Username,
-		AuthType:    host.AuthType,
-		AutoConnect: host.AutoConnect,
-		CreatedAt:   time.Now().Format(time.RFC3339),
-		UpdatedAt:   time.Now().Format(time.RFC3339),
+		ID:                host.ID,
+		Name:              host.Name,
+		Host:              host.Host,
+		Port:              host.Port,
+		Username:          host.Username,
+		AuthType:          host.AuthType,
+		CredentialBackend: host.CredentialBackend,
+		AutoConnect:       host.AutoConnect,
+		Color:             host.Color,
+		Icon:              host.Icon,
+		CreatedAt:         time.Now().Format(time.RFC3339),
+		UpdatedAt:         time.Now().Format(time.RFC3339),
 	}
 
 	log.Printf("[INFO] [HOST_CONFIG] Created host: %s (%s)", host.ID, host.Name)
-	return s.sendHostConfigCreateResult(connSession, configHost, nil)
+	return configHost, nil
 }
 
 func (s *Server) sendHostConfigCreateResult(connSession *ConnectedSession, host *protocol.SSHHostConfig, err error) error {
@@ -636,6 +1333,7 @@ func (s *Server) sendHostConfigCreateResult(connSession *ConnectedSession, host
 	}
 	if err != nil {
 		errStr := err.Error()
+		payload.Code = failureCode(err, protocol.ErrorStorageError)
 		payload.Error = &errStr
 	}
 	msg, _ := protocol.NewMessage(protocol.TypeHostConfigCreateResult, payload)
@@ -645,7 +1343,7 @@ func (s *Server) sendHostConfigCreateResult(connSession *ConnectedSession, host
 func (s *Server) handleHostConfigUpdate(connSession *ConnectedSession, msg *protocol.Message) error {
 	var payload protocol.HostConfigUpdatePayload
 	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-		return s.sendHostConfigUpdateResult(connSession, nil, fmt.Errorf("invalid payload: %w", err))
+		return s.sendHostConfigUpdateResult(connSession, nil, newFailure(protocol.ErrorInvalidArgs, "invalid payload: "+err.Error()))
 	}
 
 	// Get existing host
@@ -655,7 +1353,14 @@ func (s *Server) handleHostConfigUpdate(connSession *ConnectedSession, msg *prot
 		return s.sendHostConfigUpdateResult(connSession, nil, fmt.Errorf("failed to get host"))
 	}
 	if existing == nil {
-		return s.sendHostConfigUpdateResult(connSession, nil, fmt.Errorf("host not found"))
+		return s.sendHostConfigUpdateResult(connSession, nil, newFailure(protocol.ErrorNotFound, "host not found"))
+	}
+	if payload.StartupHooks != nil {
+		hooks, err := checkStartupHooks(*payload.StartupHooks)
+		if err != nil {
+			return s.sendHostConfigUpdateResult(connSession, nil, newFailure(protocol.ErrorInvalidArgs, err.Error()))
+		}
+		payload.StartupHooks = &hooks
 	}
 
 	// Apply updates
@@ -677,13 +1382,18 @@ func (s *Server) handleHostConfigUpdate(connSession *ConnectedSession, msg *prot
 	if payload.AutoConnect != nil {
 		existing.AutoConnect = *payload.AutoConnect
 	}
+	if payload.Color != nil {
+		existing.Color = *payload.Color
+	}
+	if payload.Icon != nil {
+		existing.Icon = *payload.Icon
+	}
+	previous := *existing
 	if payload.Credential != nil && *payload.Credential != "" {
-		encryptedCred, err := crypto.EncryptString(*payload.Credential)
-		if err != nil {
-			log.Printf("[ERROR] [HOST_CONFIG] Failed to encrypt credential: %v", err)
-			return s.sendHostConfigUpdateResult(connSession, nil, fmt.Errorf("failed to encrypt credential"))
+		if err := s.storeCredential(existing, *payload.Credential); err != nil {
+			log.Printf("[ERROR] [HOST_CONFIG] Failed to store credential: %v", err)
+			return s.sendHostConfigUpdateResult(connSession, nil, credentialError(err))
 		}
-		existing.CredentialEncrypted = encryptedCred
 	}
 
 	// Save updates
@@ -691,18 +1401,43 @@ func (s *Server) handleHostConfigUpdate(connSession *ConnectedSession, msg *prot
 		log.Printf("[ERROR] [HOST_CONFIG] Failed to update host: %v", err)
 		return s.sendHostConfigUpdateResult(connSession, nil, fmt.Errorf("failed to update host"))
 	}
+	if from := credentialBackend(previous); from != credentialBackend(*existing) {
+		s.forgetCredential(existing.ID, from, previous.CredentialEncrypted)
+	}
+	tmux, err := s.updateHostTmux(existing.ID, payload)
+	if err != nil {
+		log.Printf("[ERROR] [HOST_CONFIG] Failed to update tmux settings: %v", err)
+		return s.sendHostConfigUpdateResult(connSession, nil, fmt.Errorf("failed to update host"))
+	}
+	maxOps, err := s.updateHostMaxConcurrentOps(existing.ID, payload)
+	if err != nil {
+		log.Printf("[ERROR] [HOST_CONFIG] Failed to update operation limit: %v", err)
+		return s.sendHostConfigUpdateResult(connSession, nil, fmt.Errorf("failed to update host"))
+	}
+	hooks, err := s.updateHostStartupHooks(existing.ID, payload)
+	if err != nil {
+		log.Printf("[ERROR] [HOST_CONFIG] Failed to update startup hooks: %v", err)
+		return s.sendHostConfigUpdateResult(connSession, nil, fmt.Errorf("failed to update host"))
+	}
 
 	// Return updated host (without credential)
 	configHost := &protocol.SSHHostConfig{
-		ID:          existing.ID,
-		Name:        existing.Name,
-		Host:        existing.Host,
-		Port:        existing.Port,
-		Username:    existing.Username,
-		AuthType:    existing.AuthType,
-		AutoConnect: existing.AutoConnect,
-		CreatedAt:   existing.CreatedAt.Format(time.RFC3339),
-		UpdatedAt:   time.Now().Format(time.RFC3339),
+		ID:                existing.ID,
+		Name:              existing.Name,
+		Host:              existing.Host,
+		Port:              existing.Port,
+		Username:          existing.Username,
+		AuthType:          existing.AuthType,
+		CredentialBackend: credentialBackend(*existing),
+		AutoConnect:       existing.AutoConnect,
+		TmuxSocketPath:    tmux.SocketPath,
+		TmuxCommand:       tmux.Command,
+		MaxConcurrentOps:  maxOps,
+		Color:             existing.Color,
+		Icon:              existing.Icon,
+		CreatedAt:         existing.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:         time.Now().Format(time.RFC3339),
+		StartupHooks:      hooks,
 	}
 
 	log.Printf("[INFO] [HOST_CONFIG] Updated host: %s (%s)", existing.ID, existing.Name)
@@ -716,6 +1451,7 @@ func (s *Server) sendHostConfigUpdateResult(connSession *ConnectedSession, host
 	}
 	if err != nil {
 		errStr := err.Error()
+		payload.Code = failureCode(err, protocol.ErrorStorageError)
 		payload.Error = &errStr
 	}
 	msg, _ := protocol.NewMessage(protocol.TypeHostConfigUpdateResult, payload)
@@ -725,7 +1461,7 @@ func (s *Server) sendHostConfigUpdateResult(connSession *ConnectedSession, host
 func (s *Server) handleHostConfigDelete(connSession *ConnectedSession, msg *protocol.Message) error {
 	var payload protocol.HostConfigDeletePayload
 	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-		return s.sendHostConfigDeleteResult(connSession, "", fmt.Errorf("invalid payload: %w", err))
+		return s.sendHostConfigDeleteResult(connSession, "", newFailure(protocol.ErrorInvalidArgs, "invalid payload: "+err.Error()))
 	}
 
 	// Check if host exists
@@ -735,7 +1471,15 @@ func (s *Server) handleHostConfigDelete(connSession *ConnectedSession, msg *prot
 		return s.sendHostConfigDeleteResult(connSession, "", fmt.Errorf("failed to get host"))
 	}
 	if existing == nil {
-		return s.sendHostConfigDeleteResult(connSession, "", fmt.Errorf("host not found"))
+		return s.sendHostConfigDeleteResult(connSession, "", newFailure(protocol.ErrorNotFound, "host not found"))
+	}
+
+	confirmed, err := s.confirmDestructive(connSession, protocol.TypeHostConfigDelete, payload.ID, payload.Confirmation, false,
+		func() protocol.ConfirmationChallengePayload {
+			return hostConfigChallenge(existing)
+		})
+	if !confirmed {
+		return err
 	}
 
 	// Delete the host
@@ -743,6 +1487,7 @@ func (s *Server) handleHostConfigDelete(connSession *ConnectedSession, msg *prot
 		log.Printf("[ERROR] [HOST_CONFIG] Failed to delete host: %v", err)
 		return s.sendHostConfigDeleteResult(connSession, "", fmt.Errorf("failed to delete host"))
 	}
+	s.forgetCredential(existing.ID, credentialBackend(*existing), existing.CredentialEncrypted)
 
 	log.Printf("[INFO] [HOST_CONFIG] Deleted host: %s (%s)", payload.ID, existing.Name)
 	return s.sendHostConfigDeleteResult(connSession, payload.ID, nil)
@@ -756,6 +1501,7 @@ func (s *Server) sendHostConfigDeleteResult(connSession *ConnectedSession, id st
 		payload.ID = &id
 	} else {
 		errStr := err.Error()
+		payload.Code = failureCode(err, protocol.ErrorStorageError)
 		payload.Error = &errStr
 	}
 	msg, _ := protocol.NewMessage(protocol.TypeHostConfigDeleteResult, payload)
@@ -780,6 +1526,7 @@ func (s *Server) handleHostConnect(connSession *ConnectedSession, msg *protocol.
 			HostID:    payload.HostID,
 			Connected: false,
 			Processes: []protocol.ProcessInfo{},
+			Code:      protocol.ErrorStorageError,
 			Error:     strPtr("Failed to get host configuration"),
 		})
 		return connSession.Send(response)
@@ -790,20 +1537,22 @@ func (s *Server) handleHostConnect(connSession *ConnectedSession, msg *protocol.
 			HostID:    payload.HostID,
 			Connected: false,
 			Processes: []protocol.ProcessInfo{},
+			Code:      protocol.ErrorNotFound,
 			Error:     strPtr("Host not found - please add it in settings first"),
 		})
 		return connSession.Send(response)
 	}
 
-	// Decrypt credential
-	credential, err := crypto.DecryptString(hostConfig.CredentialEncrypted)
+	// Read the credential from its backend
+	credential, err := s.hostCredential(*hostConfig)
 	if err != nil {
-		log.Printf("[ERROR] [HOST] Failed to decrypt credential: %v", err)
+		log.Printf("[ERROR] [HOST] Failed to read credential from %s: %v", credentialBackend(*hostConfig), err)
 		response, _ := protocol.NewMessage(protocol.TypeHostStatus, protocol.HostStatusPayload{
 			HostID:    payload.HostID,
 			Connected: false,
 			Processes: []protocol.ProcessInfo{},
-			Error:     strPtr("Failed to decrypt credentials"),
+			Code:      protocol.ErrorStorageError,
+			Error:     strPtr(fmt.Sprintf("Failed to read credentials from %s", credentialBackend(*hostConfig))),
 		})
 		return connSession.Send(response)
 	}
@@ -820,14 +1569,28 @@ func (s *Server) handleHostConnect(connSession *ConnectedSession, msg *protocol.
 		authConfig.PrivateKey = credential
 	}
 
-	// Establish SSH connection
+	// Stream connect stages to a client that asked for them
+	progress := s.newHostConnectProgress(connSession, payload)
+	progress.report(protocol.HostConnectProgressPayload{Stage: protocol.HostConnectSSHHandshake})
+
+	// Establish SSH connection, with the host's operation limit
+	s.sshManager.SetHostMaxConcurrentOps(payload.HostID, s.hostMaxConcurrentOps(payload.HostID))
+	wasConnected := s.sshManager.GetConnection(payload.HostID) != nil
 	conn, err := s.sshManager.Connect(payload.HostID, hostConfig.Host, hostConfig.Port, hostConfig.Username, authConfig)
 	if err != nil {
 		log.Printf("[ERROR] [HOST] SSH connection failed: %v", err)
+		// A previously connected host whose credentials are now rejected is
+		// gone for every device, not just the one that asked
+		if wasConnected && ssh.IsAuthError(err) {
+			s.teardownHost(payload.HostID)
+			s.broadcastHostDisconnected(payload.HostID, protocol.HostDisconnectAuthFailedOnReconnect, strPtr(err.Error()))
+			return nil
+		}
 		response, _ := protocol.NewMessage(protocol.TypeHostStatus, protocol.HostStatusPayload{
 			HostID:    payload.HostID,
 			Connected: false,
 			Processes: []protocol.ProcessInfo{},
+			Code:      protocol.ErrorNotConnected,
 			Error:     strPtr(err.Error()),
 		})
 		return connSession.Send(response)
@@ -836,29 +1599,60 @@ func (s *Server) handleHostConnect(connSession *ConnectedSession, msg *protocol.
 	// Track host connection in session
 	s.sessionManager.AddHostConnection(connSession.ID, payload.HostID)
 
-	// Scan for existing tmux sessions
-	// Returns: reattached processes (already registered) and detached sessions (need manual reattach)
-	processInfos, detachedProcesses := s.scanAndRegisterTmuxSessions(connSession, payload.HostID, conn.Client)
+	// Another host config may reach the same machine. Both would claim its
+	// tmux sessions, so leave the ones the other host owns alone.
+	if err := s.storage.SetSSHHostFingerprint(payload.HostID, conn.Fingerprint()); err != nil {
+		log.Printf("[WARN] [HOST] Failed to store fingerprint for host %s: %v", payload.HostID, err)
+	}
+	var warnings []protocol.HostWarning
+	duplicateOf := s.sshManager.DuplicateHosts(payload.HostID)
+	if len(duplicateOf) > 0 {
+		log.Printf("[WARN] [HOST] Host %s reaches the same machine as connected hosts %v", payload.HostID, duplicateOf)
+		warnings = append(warnings, protocol.HostWarning{
+			Code:        protocol.HostWarningDuplicate,
+			Message:     "Another connected host reaches the same machine; its tmux sessions are not claimed again",
+			DuplicateOf: duplicateOf,
+		})
+	}
 
-	// Also scan for existing AgentAPI servers (for Claude process detection)
-	scannedProcesses, staleAgentAPIs := s.portScanner.ScanPorts(conn.Client, payload.HostID)
+	// After a reboot there is nothing left to reattach
+	boot := s.checkHostBoot(payload.HostID, conn)
+	if boot.rebooted() {
+		s.purgeRebootedHost(payload.HostID)
+	}
+
+	// Read before the scan, which picks its tools from it
+	platform := s.detectHostPlatform(payload.HostID, conn)
+
+	// Scan for existing tmux sessions (reattached processes, detached
+	// sessions that need manual reattach and rc-* sessions the bridge didn't
+	// create), existing AgentAPI servers and requirements, all at once
+	scan := s.scanHost(connSession, payload.HostID, conn, duplicateOf, progress)
+	processInfos, detachedProcesses, unmanagedSessions := scan.processInfos, scan.detachedProcesses, scan.unmanagedSessions
+	scannedProcesses, staleAgentAPIs := scan.scannedProcesses, scan.staleAgentAPIs
+	requirements := scan.requirements
+	if requirements != nil {
+		s.storeRequirements(payload.HostID, requirements)
+	}
 
-	// Mark occupied ports as in-use in the port pool to prevent reallocation
-	// This is critical for preventing port conflicts after reconnect
+	// Reserve occupied ports in the port pool to prevent reallocation
+	// This is critical for preventing port conflicts after reconnect. Nothing
+	// releases these if the process behind them dies unseen, so they are
+	// re-verified when the pool runs out.
 	for _, scanned := range scannedProcesses {
 		if scanned.Port != nil {
-			s.processRegistry.MarkPortInUse(*scanned.Port)
+			s.processRegistry.ReservePort(*scanned.Port, payload.HostID, "AgentAPI found by scan")
 		}
 	}
 	for _, stale := range staleAgentAPIs {
 		if stale.Port > 0 {
-			s.processRegistry.MarkPortInUse(stale.Port)
+			s.processRegistry.ReservePort(stale.Port, payload.HostID, "stale AgentAPI ("+stale.Reason+")")
 		}
 	}
-	// Also mark ports from detached tmux sessions (from stored metadata)
+	// Also reserve ports from detached tmux sessions (from stored metadata)
 	for _, detached := range detachedProcesses {
 		if detached.Port > 0 {
-			s.processRegistry.MarkPortInUse(detached.Port)
+			s.processRegistry.ReservePort(detached.Port, payload.HostID, "detached session")
 		}
 	}
 	// Mark ports from reattached processes (still in registry)
@@ -884,38 +1678,72 @@ func (s *Server) handleHostConnect(connSession *ConnectedSession, msg *protocol.
 		}
 	}
 
-	// Merge stale processes: detached tmux sessions + stale AgentAPI ports
-	var allStaleProcesses []protocol.StaleProcess
-	allStaleProcesses = append(allStaleProcesses, detachedProcesses...)
-	allStaleProcesses = append(allStaleProcesses, staleAgentAPIs...)
-
-	// Store stale processes in registry for later updates
-	s.processRegistry.SetStaleProcesses(payload.HostID, allStaleProcesses)
-
-	// Check requirements (claude and agentapi installation)
-	requirements := pty.CheckRequirements(conn.Client)
+	// Replace the host's stale processes with what the scans found: each
+	// lists every detached tmux session or stale AgentAPI port, so entries
+	// it didn't find are gone. A scan that failed or timed out changes nothing.
+	if detachedProcesses != nil {
+		s.processRegistry.ReplaceStaleProcesses(payload.HostID, process.StaleDetached, detachedProcesses)
+	}
+	if !slices.Contains(scan.timedOut, protocol.HostConnectScanningPorts) {
+		s.processRegistry.ReplaceStaleProcesses(payload.HostID, process.StaleAgentAPI, staleAgentAPIs)
+	}
+	allStaleProcesses := s.processRegistry.GetStaleProcesses(payload.HostID)
 
 	log.Printf("[INFO] [HOST] Connected to %s@%s:%d (found %d active, %d detached, %d stale AgentAPI, claude=%v, agentapi=%v)",
 		conn.Username, conn.Host, conn.Port, len(processInfos), len(detachedProcesses), len(staleAgentAPIs),
-		requirements.ClaudeInstalled, requirements.AgentAPIInstalled)
+		requirements != nil && requirements.ClaudeInstalled, requirements != nil && requirements.AgentAPIInstalled)
 
 	var stalePtr *[]protocol.StaleProcess
 	if len(allStaleProcesses) > 0 {
 		stalePtr = &allStaleProcesses
 	}
 
-	response, err := protocol.NewMessage(protocol.TypeHostStatus, protocol.HostStatusPayload{
-		HostID:         payload.HostID,
-		Connected:      true,
-		Processes:      processInfos,
-		StaleProcesses: stalePtr,
-		Requirements:   requirements,
-	})
-	if err != nil {
-		return err
+	status := protocol.HostStatusPayload{
+		HostID:            payload.HostID,
+		Connected:         true,
+		Processes:         processInfos,
+		StaleProcesses:    stalePtr,
+		Requirements:      requirements,
+		UnmanagedSessions: unmanagedSessions,
+		Warnings:          warnings,
+		Channels:          channelUsage(conn),
+		TimedOut:          scan.timedOut,
+		Reattach:          scan.reattach,
+		Platform:          platform,
+	}
+	boot.apply(&status)
+	return connSession.sendScopedHostStatus(status)
+}
+
+// hostConnectProgress reports a stage of handleHostConnect. A nil
+// hostConnectProgress, for clients that didn't ask for progress, reports nothing.
+type hostConnectProgress func(update protocol.HostConnectProgressPayload)
+
+func (progress hostConnectProgress) report(update protocol.HostConnectProgressPayload) {
+	if progress != nil {
+		progress(update)
 	}
+}
 
-	return connSession.Send(response)
+// newHostConnectProgress returns a hostConnectProgress that sends
+// HOST_CONNECT_PROGRESS to connSession, or nil if the connect didn't ask for it
+func (s *Server) newHostConnectProgress(connSession *ConnectedSession, payload protocol.HostConnectPayload) hostConnectProgress {
+	if !payload.WantProgress {
+		return nil
+	}
+	return func(update protocol.HostConnectProgressPayload) {
+		update.HostID = payload.HostID
+		log.Printf("[DEBUG] [HOST] Connect progress for %s: %s", payload.HostID, update.Stage)
+
+		msg, err := protocol.NewMessage(protocol.TypeHostConnectProgress, update)
+		if err != nil {
+			log.Printf("[ERROR] [HOST] Failed to create connect progress message: %v", err)
+			return
+		}
+		if err := connSession.Send(msg); err != nil {
+			log.Printf("[WARN] [HOST] Failed to send connect progress: %v", err)
+		}
+	}
 }
 
 func (s *Server) handleHostDisconnect(connSession *ConnectedSession, msg *protocol.Message) error {
@@ -928,22 +1756,15 @@ func (s *Server) handleHostDisconnect(connSession *ConnectedSession, msg *protoc
 
 	// Detach from all processes for this host (don't kill them)
 	// Tmux sessions continue running on the remote host
-	procs := s.processRegistry.GetByHost(payload.HostID)
-	for _, proc := range procs {
-		proc.Detach()
-		s.processRegistry.Unregister(proc.ID)
-	}
-
-	// Clear stale processes for this host
-	s.processRegistry.ClearStaleProcesses(payload.HostID)
-
-	// Close SSH connection
-	s.sshManager.Disconnect(payload.HostID)
+	s.teardownHost(payload.HostID)
 
 	// Remove from session tracking
 	s.sessionManager.RemoveHostConnection(connSession.ID, payload.HostID)
 
 	log.Printf("[INFO] [HOST] Disconnected hostID=%s", payload.HostID)
+
+	// Let other devices know the host went away
+	s.broadcastHostDisconnected(payload.HostID, protocol.HostDisconnectUserDisconnect, nil)
 	return nil
 }
 
@@ -964,6 +1785,7 @@ func (s *Server) handleHostCheckRequirements(connSession *ConnectedSession, msg
 			Requirements: protocol.HostRequirements{
 				CheckedAt: "",
 			},
+			Code:  protocol.ErrorNotConnected,
 			Error: &errMsg,
 		})
 		if err != nil {
@@ -972,21 +1794,9 @@ func (s *Server) handleHostCheckRequirements(connSession *ConnectedSession, msg
 		return connSession.Send(response)
 	}
 
-	// Check requirements
-	requirements := pty.CheckRequirements(sshConn.Client)
-
-	log.Printf("[INFO] [HOST] Requirements check for %s: claude=%v, agentapi=%v",
-		payload.HostID, requirements.ClaudeInstalled, requirements.AgentAPIInstalled)
-
-	response, err := protocol.NewMessage(protocol.TypeHostRequirementsResult, protocol.HostRequirementsResultPayload{
-		HostID:       payload.HostID,
-		Requirements: *requirements,
-	})
-	if err != nil {
-		return err
-	}
-
-	return connSession.Send(response)
+	// The result comes when the check, or one already running, is done
+	s.refreshRequirements(connSession, payload.HostID, sshConn.Client)
+	return nil
 }
 
 func (s *Server) handleProcessList(connSession *ConnectedSession, msg *protocol.Message) error {
@@ -995,20 +1805,35 @@ func (s *Server) handleProcessList(connSession *ConnectedSession, msg *protocol.
 		return err
 	}
 
-	log.Printf("[DEBUG] [PROCESS] List request: hostId=%s", payload.HostID)
+	log.Printf("[DEBUG] [PROCESS] List request: hostId=%s light=%v", payload.HostID, payload.ListLight)
 
-	// Get processes for this host
-	procs := s.processRegistry.GetByHost(payload.HostID)
+	return s.sendProcessList(connSession, payload.HostID, !payload.ListLight, payload.IncludeStats)
+}
+
+// sendProcessList sends the processes on a host. With refresh, CWDs are
+// queried from tmux first; otherwise the host isn't touched and the CWDs are
+// those cached by the last refresh. With includeStats, each process's
+// traffic stats and stored history size are included.
+func (s *Server) sendProcessList(connSession *ConnectedSession, hostID string, refresh, includeStats bool) error {
+	procs := s.processRegistry.GetByHost(hostID)
 	var processInfos []protocol.ProcessInfo
+	if refresh {
+		process.RefreshCWDs(procs)
+	}
 	for _, proc := range procs {
-		// Refresh CWD from tmux before sending
-		proc.RefreshCWD()
-		processInfos = append(processInfos, proc.ToInfo())
+		info := proc.ToInfo()
+		if includeStats {
+			stats := proc.TrafficStats()
+			info.Stats = &stats
+			historySize := s.storage.GetPtyHistorySize(proc.ID)
+			info.HistorySize = &historySize
+		}
+		processInfos = append(processInfos, info)
 	}
 
 	response, err := protocol.NewMessage(protocol.TypeProcessListResult, protocol.ProcessListResultPayload{
-		HostID:    payload.HostID,
-		Processes: processInfos,
+		HostID:    hostID,
+		Processes: connSession.scopedProcessInfos(hostID, processInfos),
 	})
 	if err != nil {
 		return err
@@ -1028,40 +1853,102 @@ func (s *Server) handleProcessCreate(connSession *ConnectedSession, msg *protoco
 	// Get SSH connection for this host
 	sshConn := s.sshManager.GetConnection(payload.HostID)
 	if sshConn == nil {
-		return connSession.SendError("NOT_CONNECTED", "Host is not connected")
+		return connSession.sendHostNotConnected(payload.HostID)
 	}
 
-	// Generate process ID
-	processID := uuid.New().String()
-
 	// Configure PTY
 	ptyConfig := pty.DefaultSessionConfig()
-	if payload.Cols != nil {
-		ptyConfig.Cols = *payload.Cols
-	}
-	if payload.Rows != nil {
-		ptyConfig.Rows = *payload.Rows
-	}
+	ptyConfig.Cols, ptyConfig.Rows = connSession.ptySize(payload.Cols, payload.Rows, ptyConfig.Cols, ptyConfig.Rows)
 	if payload.CWD != nil {
 		ptyConfig.InitialCWD = *payload.CWD
 	}
+	if payload.PtyOutput != nil {
+		ptyConfig.Output = withPtyOutputOverride(s.config.PtyOutput, payload.PtyOutput)
+		if err := ptyConfig.Output.Validate(); err != nil {
+			return connSession.SendErrorDetails(protocol.ErrorInvalidArgs, protocol.InvalidArgsDetails{
+				Tokens: []string{"ptyOutput"},
+				Reason: err.Error(),
+			})
+		}
+	}
 
-	// Create PTY session
-	ptySession, err := pty.NewSession(processID, payload.HostID, sshConn.Client, ptyConfig)
+	var hooks []string
+	if !payload.SkipHooks {
+		hooks = s.hostStartupHooks(payload.HostID)
+	}
+	proc, _, err := s.startShellProcess(connSession, payload.HostID, sshConn, ptyConfig, nil, hooks)
 	if err != nil {
 		log.Printf("[ERROR] [PROCESS] Failed to create PTY session: %v", err)
-		return connSession.SendError("PTY_ERROR", err.Error())
+		return connSession.SendErrorDetails(protocol.ErrorPtyError, protocol.ErrorDetails{"hostId": payload.HostID, "reason": err.Error()})
+	}
+	if payload.Timeline && s.storage != nil {
+		go s.enableTimelineAtStart(connSession, proc)
+	}
+
+	// Send process created notification
+	response, err := protocol.NewMessage(protocol.TypeProcessCreated, processCreated(proc))
+	if err != nil {
+		return err
+	}
+	s.publishProcessMessage(payload.HostID, proc.ID, response, connSession)
+
+	return connSession.Send(response)
+}
+
+// processCreated describes a new process for process_created, with the
+// terminal size its PTY was given
+func processCreated(proc *process.Process) protocol.ProcessCreatedPayload {
+	created := protocol.ProcessCreatedPayload{Process: proc.ToInfo()}
+	if proc.PTY != nil {
+		created.Cols, created.Rows = proc.PTY.GetDimensions()
+	}
+	return created
+}
+
+// withPtyOutputOverride applies a process_create's output settings over the
+// bridge's
+func withPtyOutputOverride(tuning pty.OutputTuning, override *protocol.PtyOutputOverride) pty.OutputTuning {
+	if override.ReadBufferSize != nil {
+		tuning.ReadBufferSize = *override.ReadBufferSize
+	}
+	if override.CoalesceWindowMs != nil {
+		tuning.CoalesceWindow = time.Duration(*override.CoalesceWindowMs) * time.Millisecond
+	}
+	if override.MaxCoalescedBytes != nil {
+		tuning.MaxCoalescedBytes = *override.MaxCoalescedBytes
+	}
+	return tuning
+}
+
+// startShellProcess creates a tmux-backed shell process on a host, registers
+// it, and starts streaming its output to connSession. The startup hooks are
+// typed into the shell once it is up; the channel returned is closed when
+// they have been.
+func (s *Server) startShellProcess(connSession *ConnectedSession, hostID string, sshConn *ssh.Connection, ptyConfig pty.SessionConfig, spawnEnv []env.EnvVar, hooks []string) (*process.Process, <-chan struct{}, error) {
+	// Generate process ID
+	processID := uuid.New().String()
+
+	// Create PTY session
+	ptyConfig.Tmux = s.hostTmux(hostID)
+	if ptyConfig.Output == (pty.OutputTuning{}) {
+		ptyConfig.Output = s.config.PtyOutput
+	}
+	ptySession, err := pty.NewSession(processID, hostID, sshConn.Client, ptyConfig)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Create process record
 	proc := &process.Process{
 		ID:        processID,
 		Type:      process.TypeShell,
-		HostID:    payload.HostID,
+		HostID:    hostID,
 		PTY:       ptySession,
 		CWD:       ptyConfig.InitialCWD,
 		StartedAt: time.Now(),
 		PtyReady:  true,
+
+		StartupHooks: hooks,
 	}
 
 	// Get and set the shell PID
@@ -1076,75 +1963,52 @@ func (s *Server) handleProcessCreate(connSession *ConnectedSession, msg *protoco
 
 	// Register process with storage for history tracking and metadata persistence
 	if s.storage != nil {
-		s.storage.RegisterProcess(processID, payload.HostID)
+		s.storage.RegisterProcess(processID, hostID)
 
 		// Save process metadata for recovery after bridge restart
-		shellPID := 0
-		if proc.ShellPID != nil {
-			shellPID = *proc.ShellPID
-		}
+		shellPID, _ := proc.GetShellPID()
 		if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{
 			ProcessID:   processID,
-			HostID:      payload.HostID,
+			HostID:      hostID,
 			ProcessType: "shell",
 			TmuxName:    ptySession.TmuxName,
-			CWD:         proc.CWD,
+			CWD:         proc.GetCWD(),
 			ShellPID:    shellPID,
 			StartedAt:   proc.StartedAt,
 		}); err != nil {
 			log.Printf("[WARN] [PROCESS] Failed to save process metadata: %v", err)
 		}
+		if len(hooks) > 0 {
+			if err := s.storage.SetProcessStartupHooks(processID, hooks); err != nil {
+				log.Printf("[WARN] [PROCESS] Failed to save startup hooks of process %s: %v", processID, err)
+			}
+		}
+		// Metadata places the new process last on its host
+		s.syncProcessOrder(hostID)
 	}
 
-	// Capture environment variables at spawn time (before user interaction)
-	// This captures the shell's environment AFTER sourcing RC files
+	// Export the env the process starts with and type the startup hooks,
+	// then capture environment variables at spawn time (before user
+	// interaction). This captures the shell's environment AFTER sourcing RC
+	// files and running the hooks.
+	hooksSent := make(chan struct{})
 	go func() {
-		// Small delay to ensure shell has fully initialized and sourced RC files
-		time.Sleep(200 * time.Millisecond)
-
-		envVars, err := s.envManager.CaptureProcessEnvAtSpawn(sshConn.Client, ptySession.TmuxName)
-		if err != nil {
-			log.Printf("[WARN] [PROCESS] Failed to capture env vars for process %s: %v", processID, err)
-			return
-		}
-
-		// Convert env.EnvVar to process.EnvVar and store in process
-		procEnvVars := make([]process.EnvVar, len(envVars))
-		for i, v := range envVars {
-			procEnvVars[i] = process.EnvVar{Key: v.Key, Value: v.Value}
-		}
-		proc.EnvVars = procEnvVars
-		log.Printf("[DEBUG] [PROCESS] Captured %d env vars for process %s", len(procEnvVars), processID)
-
-		// Persist env vars to storage for reconnect survival
-		if s.storage != nil {
-			storageEnvVars := make([]storage.EnvVar, len(envVars))
-			for i, v := range envVars {
-				storageEnvVars[i] = storage.EnvVar{Key: v.Key, Value: v.Value}
-			}
-			if err := s.storage.UpdateProcessEnvVars(processID, storageEnvVars); err != nil {
-				log.Printf("[WARN] [PROCESS] Failed to persist env vars for process %s: %v", processID, err)
-			}
-		}
+		s.injectSpawnEnv(proc, spawnEnv)
+		s.sendStartupHooks(proc)
+		close(hooksSent)
+		s.captureSpawnEnv(connSession, proc)
 	}()
 
-	// Set up PTY output handler to forward to WebSocket
+	// Capture output to history, and forward it to the WebSocket
+	s.installPtyCapture(proc)
 	s.updatePtyOutputHandler(connSession, proc)
 
 	// Start reading PTY output
 	ptySession.StartOutputLoop()
 
-	log.Printf("[INFO] [PROCESS] Created shell process %s for host %s", processID, payload.HostID)
+	log.Printf("[INFO] [PROCESS] Created shell process %s for host %s", processID, hostID)
 
-	// Send process created notification
-	response, err := protocol.NewMessage(protocol.TypeProcessCreated, protocol.ProcessCreatedPayload{
-		Process: proc.ToInfo(),
-	})
-	if err != nil {
-		return err
-	}
-
-	return connSession.Send(response)
+	return proc, hooksSent, nil
 }
 
 func (s *Server) handleProcessKill(connSession *ConnectedSession, msg *protocol.Message) error {
@@ -1158,7 +2022,15 @@ func (s *Server) handleProcessKill(connSession *ConnectedSession, msg *protocol.
 	// Get the process
 	proc := s.processRegistry.Get(payload.ProcessID)
 	if proc == nil {
-		return connSession.SendError("NOT_FOUND", "Process not found")
+		return connSession.sendProcessNotFound(payload.ProcessID)
+	}
+
+	confirmed, err := s.confirmDestructive(connSession, protocol.TypeProcessKill, proc.ID, payload.Confirmation, s.config.ConfirmKills,
+		func() protocol.ConfirmationChallengePayload {
+			return s.processChallenge(proc, protocol.TypeProcessKill)
+		})
+	if !confirmed {
+		return err
 	}
 
 	// Close the process (PTY)
@@ -1166,28 +2038,57 @@ func (s *Server) handleProcessKill(connSession *ConnectedSession, msg *protocol.
 		log.Printf("[WARN] [PROCESS] Error closing process %s: %v", payload.ProcessID, err)
 	}
 
-	// Clear history and metadata from storage
+	// Remove temp files the process left on the host
+	if sshConn := s.sshManager.GetConnection(proc.HostID); sshConn != nil {
+		tmuxName := pty.TmuxSessionName(proc.ID)
+		if proc.PTY != nil {
+			tmuxName = proc.PTY.TmuxName
+		}
+		if err := s.envManager.CleanupProcessArtifacts(sshConn.Client, proc.ID, tmuxName); err != nil {
+			log.Printf("[WARN] [PROCESS] Error cleaning up artifacts for process %s: %v", payload.ProcessID, err)
+		}
+	}
+
+	// Archive the process with its history, or clear both from storage
+	archived := false
 	if s.storage != nil {
-		if err := s.storage.UnregisterProcess(payload.ProcessID); err != nil {
-			log.Printf("[WARN] [PROCESS] Error clearing storage for process %s: %v", payload.ProcessID, err)
+		if payload.KeepHistory == nil || *payload.KeepHistory {
+			var err error
+			if archived, err = s.storage.ArchiveProcess(payload.ProcessID); err != nil {
+				log.Printf("[WARN] [PROCESS] Error archiving process %s: %v", payload.ProcessID, err)
+			}
 		}
-		if err := s.storage.DeleteProcessMetadata(payload.ProcessID); err != nil {
-			log.Printf("[WARN] [PROCESS] Error deleting metadata for process %s: %v", payload.ProcessID, err)
+		if !archived {
+			if err := s.storage.UnregisterProcess(payload.ProcessID); err != nil {
+				log.Printf("[WARN] [PROCESS] Error clearing storage for process %s: %v", payload.ProcessID, err)
+			}
+			if err := s.storage.DeleteProcessMetadata(payload.ProcessID); err != nil {
+				log.Printf("[WARN] [PROCESS] Error deleting metadata for process %s: %v", payload.ProcessID, err)
+			}
+		}
+		// Either closed the gap in the host's sort weights
+		s.syncProcessOrder(proc.HostID)
+		if err := s.storage.SetProcessWorkspace(payload.ProcessID, ""); err != nil {
+			log.Printf("[WARN] [PROCESS] Error clearing workspace for process %s: %v", payload.ProcessID, err)
 		}
 	}
 
 	// Unregister from registry
 	s.processRegistry.Unregister(payload.ProcessID)
+	s.alertLimiter.forget(payload.ProcessID)
+	s.transcripts.forget(payload.ProcessID)
 
-	log.Printf("[INFO] [PROCESS] Killed process %s", payload.ProcessID)
+	log.Printf("[INFO] [PROCESS] Killed process %s (archived=%v)", payload.ProcessID, archived)
 
 	// Send process killed notification
 	response, err := protocol.NewMessage(protocol.TypeProcessKilled, protocol.ProcessKilledPayload{
 		ProcessID: payload.ProcessID,
+		Archived:  archived,
 	})
 	if err != nil {
 		return err
 	}
+	s.publishProcessMessage(proc.HostID, payload.ProcessID, response, connSession)
 
 	return connSession.Send(response)
 }
@@ -1203,7 +2104,7 @@ func (s *Server) handleProcessRename(connSession *ConnectedSession, msg *protoco
 	// Get the process
 	proc := s.processRegistry.Get(payload.ProcessID)
 	if proc == nil {
-		return connSession.SendError("NOT_FOUND", "Process not found")
+		return connSession.sendProcessNotFound(payload.ProcessID)
 	}
 
 	// Update the name in memory
@@ -1216,23 +2117,8 @@ func (s *Server) handleProcessRename(connSession *ConnectedSession, msg *protoco
 		}
 	}
 
-	// Broadcast process updated to all sessions
-	info := proc.ToInfo()
-	response, err := protocol.NewMessage(protocol.TypeProcessUpdated, protocol.ProcessUpdatedPayload{
-		ID:            info.ID,
-		Type:          info.Type,
-		Port:          info.Port,
-		Name:          info.Name,
-		PtyReady:      info.PtyReady,
-		AgentAPIReady: info.AgentAPIReady,
-		ShellPID:      info.ShellPID,
-		AgentAPIPID:   info.AgentAPIPID,
-	})
-	if err != nil {
-		return err
-	}
-
-	return connSession.Send(response)
+	// Send process updated, and push it to the host's subscribers
+	return s.notifyProcessUpdated(connSession, proc)
 }
 
 func (s *Server) handleProcessReattach(connSession *ConnectedSession, msg *protocol.Message) error {
@@ -1241,40 +2127,41 @@ func (s *Server) handleProcessReattach(connSession *ConnectedSession, msg *proto
 		return err
 	}
 
-	log.Printf("[DEBUG] [PROCESS] Reattach request: hostId=%s tmuxSession=%s processId=%s",
+	log.Printf("[DEBUG] [PROCESS] Reattach request: hostId=%s tmuxSession=%q processId=%q",
 		payload.HostID, payload.TmuxSession, payload.ProcessID)
 
+	// Only sessions the bridge created may be reattached, and only under the
+	// process ID embedded in their name
+	processID, err := pty.NormalizeProcessID(payload.ProcessID)
+	if err == nil {
+		if embedded, ok := pty.ProcessIDFromTmuxName(payload.TmuxSession); !ok || embedded != processID {
+			err = fmt.Errorf("tmux session %q was not created for process %s", payload.TmuxSession, processID)
+		}
+	}
+	if err != nil {
+		log.Printf("[WARN] [PROCESS] Rejected reattach: %v", err)
+		return connSession.SendErrorDetails(protocol.ErrorAttachFailed,
+			protocol.ErrorDetails{"hostId": payload.HostID, "tmuxSession": payload.TmuxSession, "reason": err.Error()})
+	}
+	payload.ProcessID = processID
+
 	// Get the SSH connection for this host
 	conn := s.sshManager.GetConnection(payload.HostID)
 	if conn == nil {
-		return connSession.SendError("NOT_CONNECTED", "Host is not connected")
+		return connSession.sendHostNotConnected(payload.HostID)
 	}
 
 	// Check if process already exists (shouldn't happen, but be safe)
 	if existingProc := s.processRegistry.Get(payload.ProcessID); existingProc != nil {
-		return connSession.SendError("ALREADY_EXISTS", "Process is already registered")
-	}
-
-	// Attach to the existing tmux session
-	// Use default terminal size - client can resize later
-	ptySession, err := pty.AttachToExisting(
-		payload.ProcessID,
-		payload.HostID,
-		payload.TmuxSession,
-		conn.Client,
-		120, // default cols
-		30,  // default rows
-		time.Now(), // We don't have the original start time anymore
-	)
-	if err != nil {
-		log.Printf("[ERROR] [PROCESS] Failed to attach to tmux session %s: %v", payload.TmuxSession, err)
-		return connSession.SendError("ATTACH_FAILED", fmt.Sprintf("Failed to attach: %v", err))
+		return connSession.SendErrorDetails(protocol.ErrorAlreadyExists,
+			protocol.ErrorDetails{"processId": payload.ProcessID, "reason": "process is already registered"})
 	}
 
 	// Get stale process info before removing (to get the port if it was a Claude process)
 	staleProc := s.processRegistry.GetStaleProcess(payload.HostID, payload.ProcessID)
 	var savedPort int
-	var savedName string
+	var savedName, savedClaudeCWD, savedClaudeSessionID string
+	var savedTermOptions map[string]string
 	if staleProc != nil {
 		log.Printf("[DEBUG] [PROCESS] Found stale process %s with port=%d reason=%s", payload.ProcessID, staleProc.Port, staleProc.Reason)
 		if staleProc.Port > 0 {
@@ -1284,8 +2171,9 @@ func (s *Server) handleProcessReattach(connSession *ConnectedSession, msg *proto
 
 	// Always check storage for metadata (name, port, env vars, etc.)
 	var savedEnvVars []process.EnvVar
+	var meta *storage.ProcessMetadata
 	if s.storage != nil {
-		if meta, err := s.storage.GetProcessMetadata(payload.ProcessID); err == nil && meta != nil {
+		if meta, err = s.storage.GetProcessMetadata(payload.ProcessID); err == nil && meta != nil {
 			log.Printf("[DEBUG] [PROCESS] Found metadata in storage: type=%s port=%d name=%q envVars=%d", meta.ProcessType, meta.Port, meta.Name, len(meta.EnvVars))
 			if meta.Port > 0 && savedPort == 0 {
 				savedPort = meta.Port
@@ -1293,6 +2181,9 @@ func (s *Server) handleProcessReattach(connSession *ConnectedSession, msg *proto
 			if meta.Name != "" {
 				savedName = meta.Name
 			}
+			savedClaudeCWD = meta.ClaudeCWD
+			savedClaudeSessionID = meta.ClaudeSessionID
+			savedTermOptions = meta.TermOptions
 			// Load saved env vars
 			if len(meta.EnvVars) > 0 {
 				savedEnvVars = make([]process.EnvVar, len(meta.EnvVars))
@@ -1307,34 +2198,159 @@ func (s *Server) handleProcessReattach(connSession *ConnectedSession, msg *proto
 		}
 	}
 
+	// Query the live pane once: its shell PID is recorded on the process and
+	// used to check the stored metadata belongs to this session, and its
+	// creation time stands in for a start time the metadata lacks
+	tmux := s.hostTmux(payload.HostID)
+	paneInfo, paneErr := pty.QueryPaneInfo(conn.Client, tmux, payload.TmuxSession)
+	if paneErr != nil {
+		log.Printf("[WARN] [PROCESS] Could not get pane info for reattached process %s: %v", payload.ProcessID, paneErr)
+	}
+
+	// A session recreated under the same name isn't the process the metadata
+	// describes; restoring its port could attach Claude to a foreign server
+	metadataDiscarded := meta != nil && paneErr == nil && !paneMatchesMetadata(paneInfo, meta)
+	trustedMeta := meta
+	if metadataDiscarded {
+		trustedMeta = nil
+	}
+	startedAt := reattachStartedAt(trustedMeta, paneInfo, paneErr)
+
+	// Attach to the existing tmux session, by default at the client's
+	// default size - it can resize later
+	cols, rows := connSession.ptySize(payload.Cols, payload.Rows, 120, 30)
+	ptySession, err := pty.AttachToExisting(
+		payload.ProcessID,
+		payload.HostID,
+		payload.TmuxSession,
+		conn.Client,
+		tmux,
+		cols,
+		rows,
+		startedAt,
+		savedTermOptions,
+	)
+	if err != nil {
+		log.Printf("[ERROR] [PROCESS] Failed to attach to tmux session %s: %v", payload.TmuxSession, err)
+		return connSession.SendErrorDetails(protocol.ErrorAttachFailed,
+			protocol.ErrorDetails{"hostId": payload.HostID, "tmuxSession": payload.TmuxSession, "reason": err.Error()})
+	}
+	ptySession.SetOutputTuning(s.config.PtyOutput)
+	if paneErr == nil {
+		ptySession.SetCWD(paneInfo.CWD)
+	}
+
+	if metadataDiscarded {
+		log.Printf("[WARN] [PROCESS] tmux session %s does not match stored metadata for %s (shell PID %d, stored %d); reattaching as a plain shell",
+			payload.TmuxSession, payload.ProcessID, paneInfo.ShellPID, meta.ShellPID)
+		savedPort = 0
+		savedClaudeCWD = ""
+		savedClaudeSessionID = ""
+		savedEnvVars = nil
+		if savedTermOptions != nil {
+			savedTermOptions = nil
+			if err := ptySession.SetTermOptions(nil); err != nil {
+				log.Printf("[WARN] [PROCESS] Failed to reset terminal options of process %s: %v", payload.ProcessID, err)
+			}
+		}
+		if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{
+			ProcessID:   payload.ProcessID,
+			HostID:      payload.HostID,
+			ProcessType: "shell",
+			TmuxName:    payload.TmuxSession,
+			Name:        savedName,
+			ShellPID:    paneInfo.ShellPID,
+			StartedAt:   startedAt,
+		}); err != nil {
+			log.Printf("[WARN] [PROCESS] Failed to replace stale metadata for process %s: %v", payload.ProcessID, err)
+		}
+		if err := s.storage.SetProcessTermOptions(payload.ProcessID, nil); err != nil {
+			log.Printf("[WARN] [PROCESS] Failed to clear terminal options of process %s: %v", payload.ProcessID, err)
+		}
+		if meta.Timeline {
+			if err := s.storage.SetProcessTimeline(payload.ProcessID, false); err != nil {
+				log.Printf("[WARN] [PROCESS] Failed to clear timeline setting of process %s: %v", payload.ProcessID, err)
+			}
+		}
+		if meta.LastError != nil {
+			if err := s.storage.SetProcessLastError(payload.ProcessID, nil); err != nil {
+				log.Printf("[WARN] [PROCESS] Failed to clear last error of process %s: %v", payload.ProcessID, err)
+			}
+		}
+		if meta.StartupHooks != nil {
+			if err := s.storage.SetProcessStartupHooks(payload.ProcessID, nil); err != nil {
+				log.Printf("[WARN] [PROCESS] Failed to clear startup hooks of process %s: %v", payload.ProcessID, err)
+			}
+		}
+	}
+	if meta != nil && !metadataDiscarded && !hasStartedAt(meta.StartedAt) {
+		// Recorded before start times were kept; keep the one found now, so
+		// the process sorts the same way after the next reattach
+		if err := s.storage.UpdateProcessStartedAt(payload.ProcessID, startedAt); err != nil {
+			log.Printf("[WARN] [PROCESS] Failed to record start time of process %s: %v", payload.ProcessID, err)
+		}
+	}
+
 	// Create process record (default to shell, will restore Claude below if port exists)
 	proc := &process.Process{
 		ID:        payload.ProcessID,
 		Type:      process.TypeShell,
 		HostID:    payload.HostID,
 		PTY:       ptySession,
-		StartedAt: time.Now(),
+		StartedAt: startedAt,
 		PtyReady:  true,
 		EnvVars:   savedEnvVars, // Restore saved env vars
 	}
+	if trustedMeta != nil {
+		proc.StartupHooks = trustedMeta.StartupHooks
+	}
 
 	// Restore saved name if available
 	if savedName != "" {
 		proc.SetName(savedName)
 	}
 
-	// Get and set the shell PID
-	if shellPID, err := ptySession.GetShellPID(); err == nil {
-		proc.SetShellPID(shellPID)
-	} else {
-		log.Printf("[WARN] [PROCESS] Could not get shell PID for reattached process %s: %v", payload.ProcessID, err)
+	// Restore the process's place in the host's list
+	if meta != nil {
+		proc.SetOrder(meta.Pinned, meta.SortWeight)
+		proc.SetAppearance(meta.Color, meta.Icon)
+		proc.SetRetainFullHistory(meta.RetainFullHistory)
+	}
+	proc.SetTermOptions(savedTermOptions)
+	// The hooks are still in the session's shell, so keep recording
+	proc.SetTimeline(meta != nil && meta.Timeline && !metadataDiscarded)
+	// A failure from before the bridge restarted shows until it is cleared
+	if meta != nil && meta.LastError != nil && !metadataDiscarded {
+		proc.SetLastError(fromStoredProcessError(meta.LastError))
+	}
+
+	// Restore workspace assignment (kept in its own table, survives detach)
+	if s.storage != nil {
+		if workspaceID, err := s.storage.GetProcessWorkspace(payload.ProcessID); err != nil {
+			log.Printf("[WARN] [PROCESS] Error getting workspace for process %s: %v", payload.ProcessID, err)
+		} else {
+			proc.SetWorkspaceID(workspaceID)
+		}
+	}
+
+	// Set the shell PID
+	if paneErr == nil {
+		proc.SetShellPID(paneInfo.ShellPID)
 	}
 
 	// Register process
 	s.processRegistry.Register(proc)
 
-	// Remove from stale processes
+	// Remove from stale processes, including the lists of hosts that reach
+	// the same machine, so the session isn't offered twice
 	s.processRegistry.RemoveStaleProcess(payload.HostID, payload.ProcessID)
+	for _, otherID := range s.sshManager.DuplicateHosts(payload.HostID) {
+		s.processRegistry.RemoveStaleProcess(otherID, payload.ProcessID)
+	}
+
+	// Set up history capture, recovering output produced while detached
+	s.installPtyCapture(proc)
+	s.backfillPtyHistory(proc)
 
 	// Set up output handler
 	s.updatePtyOutputHandler(connSession, proc)
@@ -1345,19 +2361,82 @@ func (s *Server) handleProcessReattach(connSession *ConnectedSession, msg *proto
 	// Restore Claude state if we have a saved port
 	if savedPort > 0 {
 		log.Printf("[INFO] [PROCESS] Attempting to restore Claude state for process %s with port %d", payload.ProcessID, savedPort)
-		s.restoreClaude(connSession, proc, conn.Client, savedPort)
-		log.Printf("[INFO] [PROCESS] After restoreClaude: process %s type=%s", payload.ProcessID, proc.Type)
+		s.restoreClaude(connSession, proc, conn, savedPort, savedClaudeCWD, savedClaudeSessionID)
+		log.Printf("[INFO] [PROCESS] After restoreClaude: process %s type=%s", payload.ProcessID, proc.GetType())
 	} else {
 		log.Printf("[DEBUG] [PROCESS] No saved port found, process %s will remain as shell", payload.ProcessID)
 	}
 
-	log.Printf("[INFO] [PROCESS] Reattached to process %s (tmux: %s, type: %s)", payload.ProcessID, payload.TmuxSession, proc.Type)
+	log.Printf("[INFO] [PROCESS] Reattached to process %s (tmux: %s, type: %s)", payload.ProcessID, payload.TmuxSession, proc.GetType())
 
-	// Send HOST_STATUS with updated processes and stale processes
-	return s.sendHostStatus(connSession, payload.HostID)
+	// The process joins the host's list and leaves its stale list, for this
+	// client and the clients watching the host, and of hosts reaching the
+	// same machine
+	if err := s.notifyProcessAdded(connSession, proc, metadataDiscarded); err != nil {
+		return err
+	}
+	for _, hostID := range append([]string{payload.HostID}, s.sshManager.DuplicateHosts(payload.HostID)...) {
+		if err := s.notifyStaleProcessesChanged(connSession, hostID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tmuxCreatedSlack allows for the delay between tmux creating a session and
+// the bridge recording the process as started
+const tmuxCreatedSlack = time.Minute
+
+// paneMatchesMetadata reports whether a live tmux pane is the one the stored
+// metadata was recorded for. A session recreated under the same name (by
+// tmux-resurrect, or other tooling after a reboot) runs a different shell and
+// was created after the original process started.
+func paneMatchesMetadata(pane pty.PaneInfo, meta *storage.ProcessMetadata) bool {
+	return paneMatches(pane, meta.ShellPID, meta.StartedAt)
+}
+
+// paneMatches reports whether a live tmux pane runs the shell with shellPID,
+// for a process started at startedAt; a zero PID or start time isn't checked
+func paneMatches(pane pty.PaneInfo, shellPID int, startedAt time.Time) bool {
+	if shellPID != 0 && pane.ShellPID != shellPID {
+		return false
+	}
+	return !hasStartedAt(startedAt) || !pane.Created.After(startedAt.Add(tmuxCreatedSlack))
+}
+
+// errPaneReplaced is returned by reattachProcess when a registered process's
+// tmux session now runs a different shell than the one it was started with
+var errPaneReplaced = errors.New("tmux session was recreated")
+
+// reattachStartedAt picks the start time of a reattached process: the one
+// stored with its metadata, else when its tmux session was created, else
+// now, so it sorts among the host's processes where it did before
+func reattachStartedAt(meta *storage.ProcessMetadata, pane pty.PaneInfo, paneErr error) time.Time {
+	switch {
+	case meta != nil && hasStartedAt(meta.StartedAt):
+		return meta.StartedAt
+	case paneErr == nil && hasStartedAt(pane.Created):
+		return pane.Created
+	default:
+		return time.Now()
+	}
+}
+
+// hasStartedAt reports whether a start time was recorded. Metadata stored
+// without one reads back as the zero time or the Unix epoch.
+func hasStartedAt(t time.Time) bool {
+	return t.Unix() > 0
 }
 
-func (s *Server) handleProcessSelect(session *ConnectedSession, msg *protocol.Message) error {
+// ptySnapshotMaxBytes caps the pty_snapshot sent on process_select; lines
+// past it are dropped from the top of the scrollback
+const ptySnapshotMaxBytes = 256 << 10
+
+// handleProcessSelect sends the selected process's current screen as a
+// pty_snapshot, so the client can paint it without waiting for output or
+// pulling the full history. Live output follows as usual. If the screen
+// can't be captured nothing is sent.
+func (s *Server) handleProcessSelect(connSession *ConnectedSession, msg *protocol.Message) error {
 	var payload protocol.ProcessSelectPayload
 	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
 		return err
@@ -1365,7 +2444,42 @@ func (s *Server) handleProcessSelect(session *ConnectedSession, msg *protocol.Me
 
 	log.Printf("[DEBUG] [PROCESS] Select request: processId=%s", payload.ProcessID)
 
-	// TODO: Implement in Phase 3
+	proc := s.processRegistry.Get(payload.ProcessID)
+	if proc == nil {
+		return connSession.sendProcessNotFound(payload.ProcessID)
+	}
+	connSession.SelectProcess(proc.HostID, proc.ID)
+	return s.sendPtySnapshot(connSession, proc)
+}
+
+// sendPtySnapshot sends a process's current screen, or nothing if it has no
+// PTY or the screen can't be captured
+func (s *Server) sendPtySnapshot(connSession *ConnectedSession, proc *process.Process) error {
+	if proc.PTY == nil {
+		return nil
+	}
+
+	// The visible screen plus as much scrollback again
+	cols, rows := proc.PTY.GetDimensions()
+	data, err := proc.PTY.CaptureScreen(rows*2, ptySnapshotMaxBytes)
+	if err != nil {
+		log.Printf("[WARN] [PTY] No snapshot for process %s: %v", proc.ID, err)
+		return nil
+	}
+
+	response, err := protocol.NewMessage(protocol.TypePtySnapshot, protocol.PtySnapshotPayload{
+		ProcessID: proc.ID,
+		Cols:      cols,
+		Rows:      rows,
+		Data:      string(data),
+	})
+	if err != nil {
+		return err
+	}
+	if err := connSession.Send(response); err != nil {
+		return err
+	}
+	proc.CountTraffic(process.TrafficPtyOutput, len(data))
 	return nil
 }
 
@@ -1381,79 +2495,154 @@ func (s *Server) handleClaudeStart(connSession *ConnectedSession, msg *protocol.
 	}
 	log.Printf("[DEBUG] [CLAUDE] Start request: processId=%s, claudeArgs=%q", payload.ProcessID, claudeArgsStr)
 
+	claudeCmd, sessionID, failure := claudeCommand(payload.ClaudeArgs)
+	if failure != nil {
+		log.Printf("[WARN] [CLAUDE] Rejected claudeArgs for process %s: %v", payload.ProcessID, failure)
+		return connSession.sendFailure(failure)
+	}
+
 	// Get the process
 	proc := s.processRegistry.Get(payload.ProcessID)
 	if proc == nil {
-		return connSession.SendError("NOT_FOUND", "Process not found")
+		return connSession.sendProcessNotFound(payload.ProcessID)
+	}
+
+	if failure := s.startClaude(proc, claudeCmd, sessionID); failure != nil {
+		return connSession.sendFailure(failure)
+	}
+
+	// Send process_updated notification with all fields including PIDs
+	return s.notifyProcessUpdated(connSession, proc)
+}
+
+// claudeCommand returns the command line that runs claude with claudeArgs,
+// and the session ID it runs with (see claudeSession). The arguments are
+// written into the shell, so every one is re-quoted and anything the shell
+// would interpret is refused.
+func claudeCommand(claudeArgs *string) (cmd, sessionID string, failure *requestFailure) {
+	var args []string
+	if claudeArgs != nil && *claudeArgs != "" {
+		var err error
+		args, err = shellargs.Parse(*claudeArgs)
+		if err != nil {
+			var invalid *shellargs.InvalidArgsError
+			if errors.As(err, &invalid) {
+				return "", "", &requestFailure{protocol.ErrorInvalidArgs, protocol.InvalidArgsDetails{
+					Tokens: invalid.Tokens,
+					Reason: invalid.Reason,
+				}}
+			}
+			return "", "", &requestFailure{protocol.ErrorInvalidArgs, protocol.ErrorDetails{"reason": err.Error()}}
+		}
+	}
+	args, sessionID = claudeSession(args)
+	return "claude " + shellargs.Join(args), sessionID, nil
+}
+
+// claudeSession returns args with --session-id and a new session ID added,
+// so the conversation's transcript can be found by its name, unless they
+// pick the session themselves. Resuming a named session keeps its ID; one
+// that can't be told from the args, such as --continue or a fork, is "".
+func claudeSession(args []string) ([]string, string) {
+	var sessionID string
+	picked := false
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		switch name {
+		case "--session-id", "-r", "--resume":
+			picked = true
+			if !hasValue && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				value, hasValue = args[i+1], true
+				i++
+			}
+			if _, err := uuid.Parse(value); hasValue && err == nil && sessionID == "" {
+				sessionID = value
+			}
+		case "-c", "--continue":
+			picked = true
+		case "--fork-session":
+			return args, ""
+		}
 	}
+	if picked {
+		return args, sessionID
+	}
+	sessionID = uuid.New().String()
+	return append([]string{"--session-id", sessionID}, args...), sessionID
+}
 
+// startClaude turns a shell process into a Claude process by running
+// claudeCmd, which runs with sessionID, under AgentAPI in its shell
+func (s *Server) startClaude(proc *process.Process, claudeCmd, sessionID string) *requestFailure {
 	// Verify it's a shell process
-	if proc.Type != process.TypeShell {
-		return connSession.SendError("INVALID_STATE", "Process is already a Claude process")
+	if proc.GetType() != process.TypeShell {
+		return &requestFailure{protocol.ErrorInvalidState,
+			protocol.ErrorDetails{"processId": proc.ID, "processType": proc.GetType(), "reason": "process is already a Claude process"}}
 	}
 
 	// Verify PTY is ready
-	if proc.PTY == nil || !proc.PtyReady {
-		return connSession.SendError("PTY_NOT_READY", "PTY is not ready")
+	if proc.PTY == nil || !proc.IsPtyReady() {
+		return &requestFailure{protocol.ErrorPtyNotReady, protocol.ErrorDetails{"processId": proc.ID}}
 	}
 
 	// Get SSH connection for this host
 	sshConn := s.sshManager.GetConnection(proc.HostID)
 	if sshConn == nil {
-		return connSession.SendError("NOT_CONNECTED", "Host is not connected")
+		return &requestFailure{protocol.ErrorNotConnected, protocol.ErrorDetails{"hostId": proc.HostID}}
 	}
 
 	// Allocate a port for AgentAPI
 	port, err := s.processRegistry.AllocatePort()
 	if err != nil {
-		return connSession.SendError("NO_PORTS", err.Error())
+		return s.claudeStartFailed(proc, &requestFailure{protocol.ErrorNoPorts,
+			protocol.ErrorDetails{"minPort": s.config.PortRange.Min, "maxPort": s.config.PortRange.Max, "reason": err.Error()}})
 	}
 
-	log.Printf("[DEBUG] [CLAUDE] Allocated port %d for process %s", port, payload.ProcessID)
+	log.Printf("[DEBUG] [CLAUDE] Allocated port %d for process %s", port, proc.ID)
+
+	claudeCWD := snapshotClaudeCWD(proc)
 
 	// Start AgentAPI server in background
 	// Command: agentapi server --type=claude --port {port} -- claude [claudeArgs] &
 	// --type=claude is required for proper message formatting
-	claudeCmd := "claude"
-	if payload.ClaudeArgs != nil && *payload.ClaudeArgs != "" {
-		claudeCmd = fmt.Sprintf("claude %s", *payload.ClaudeArgs)
-	}
 	startCmd := fmt.Sprintf("agentapi server --type=claude --port %d -- %s &\n", port, claudeCmd)
 	log.Printf("[DEBUG] [CLAUDE] Executing command: %s", startCmd)
 	if err := proc.PTY.Write([]byte(startCmd)); err != nil {
 		s.processRegistry.ReleasePort(port)
-		return connSession.SendError("PTY_ERROR", "Failed to start AgentAPI: "+err.Error())
+		return s.claudeStartFailed(proc, &requestFailure{protocol.ErrorPtyError,
+			protocol.ErrorDetails{"processId": proc.ID, "port": port, "reason": "failed to start AgentAPI: " + err.Error()}})
 	}
 
 	// Wait a moment for the server to start
 	time.Sleep(500 * time.Millisecond)
 
 	// Start agentapi attach to connect to the running instance
-	// Command: agentapi attach --url http://localhost:{port}
-	attachCmd := fmt.Sprintf("agentapi attach --url http://localhost:%d\n", port)
+	attachCmd := agentAPIAttachCommand(port) + "\n"
 	if err := proc.PTY.Write([]byte(attachCmd)); err != nil {
 		s.processRegistry.ReleasePort(port)
-		return connSession.SendError("PTY_ERROR", "Failed to attach AgentAPI: "+err.Error())
+		return s.claudeStartFailed(proc, &requestFailure{protocol.ErrorPtyError,
+			protocol.ErrorDetails{"processId": proc.ID, "port": port, "reason": "failed to attach AgentAPI: " + err.Error()}})
 	}
 
 	// Update process state
 	proc.SetPort(port)
 	proc.UpdateType(process.TypeClaude)
+	proc.SetClaudeCWD(claudeCWD)
+	proc.SetClaudeSessionID(sessionID)
+	proc.MarkTerminalAttaching(time.Now())
 
 	// Create AgentAPI clients
-	agentClient := agentapi.NewClient(sshConn.Client, port)
+	agentClient := agentapi.NewClient(sshConn, port)
 
 	// Create SSE client with event handler that forwards to WebSocket
-	sseClient := agentapi.NewSSEClient(sshConn.Client, port, func(event agentapi.SSEEvent) {
-		s.handleAgentAPIEvent(connSession, proc.HostID, payload.ProcessID, event)
-	})
+	sseClient := s.newSSEClient(sshConn, proc, port)
 
 	// Store clients in process
 	proc.SetAgentClients(agentClient, sseClient)
 
 	// Start SSE connection
 	if err := sseClient.Connect(); err != nil {
-		log.Printf("[WARN] [CLAUDE] SSE connection failed for process %s: %v", payload.ProcessID, err)
+		log.Printf("[WARN] [CLAUDE] SSE connection failed for process %s: %v", proc.ID, err)
 		// Don't fail - we can still send messages without SSE
 	}
 
@@ -1461,47 +2650,38 @@ func (s *Server) handleClaudeStart(connSession *ConnectedSession, msg *protocol.
 	time.Sleep(1 * time.Second)
 	status, err := agentClient.GetStatus()
 	if err != nil {
-		log.Printf("[WARN] [CLAUDE] Initial status check failed for process %s: %v", payload.ProcessID, err)
+		log.Printf("[WARN] [CLAUDE] Initial status check failed for process %s: %v", proc.ID, err)
 		// Don't fail - the server might still be starting
 	} else {
 		log.Printf("[INFO] [CLAUDE] AgentAPI responding: status=%s", status.Status)
 		proc.SetAgentAPIReady(true)
+		proc.SetAgentStatus(status.Status, status.AgentType)
 	}
 
 	// Detect AgentAPI server PID
-	if agentAPIPID, err := s.detectAgentAPIPID(sshConn.Client, port); err == nil {
+	if agentAPIPID, err := s.detectAgentAPIPID(proc.HostID, sshConn.Client, port); err == nil {
 		proc.SetAgentAPIPID(agentAPIPID)
 		log.Printf("[INFO] [CLAUDE] Detected AgentAPI PID: %d", agentAPIPID)
 	} else {
 		log.Printf("[WARN] [CLAUDE] Could not detect AgentAPI PID: %v", err)
 	}
 
-	log.Printf("[INFO] [CLAUDE] Started Claude on process %s (port %d)", payload.ProcessID, port)
+	log.Printf("[INFO] [CLAUDE] Started Claude on process %s (port %d)", proc.ID, port)
+	s.clearProcessError(proc, process.OpClaudeStart)
 
 	// Persist process type and port to database
 	if s.storage != nil {
-		if err := s.storage.UpdateProcessType(payload.ProcessID, "claude", port); err != nil {
-			log.Printf("[WARN] [CLAUDE] Failed to persist process type for %s: %v", payload.ProcessID, err)
+		if err := s.storage.UpdateProcessType(proc.ID, "claude", port); err != nil {
+			log.Printf("[WARN] [CLAUDE] Failed to persist process type for %s: %v", proc.ID, err)
+		}
+		if err := s.storage.UpdateProcessClaudeCWD(proc.ID, claudeCWD); err != nil {
+			log.Printf("[WARN] [CLAUDE] Failed to persist Claude CWD for %s: %v", proc.ID, err)
+		}
+		if err := s.storage.UpdateProcessClaudeSessionID(proc.ID, sessionID); err != nil {
+			log.Printf("[WARN] [CLAUDE] Failed to persist Claude session ID for %s: %v", proc.ID, err)
 		}
 	}
-
-	// Send process_updated notification with all fields including PIDs
-	info := proc.ToInfo()
-	response, err := protocol.NewMessage(protocol.TypeProcessUpdated, protocol.ProcessUpdatedPayload{
-		ID:            info.ID,
-		Type:          info.Type,
-		Port:          info.Port,
-		Name:          info.Name,
-		PtyReady:      info.PtyReady,
-		AgentAPIReady: info.AgentAPIReady,
-		ShellPID:      info.ShellPID,
-		AgentAPIPID:   info.AgentAPIPID,
-	})
-	if err != nil {
-		return err
-	}
-
-	return connSession.Send(response)
+	return nil
 }
 
 func (s *Server) handleClaudeKill(connSession *ConnectedSession, msg *protocol.Message) error {
@@ -1515,89 +2695,136 @@ func (s *Server) handleClaudeKill(connSession *ConnectedSession, msg *protocol.M
 	// Get the process
 	proc := s.processRegistry.Get(payload.ProcessID)
 	if proc == nil {
-		return connSession.SendError("NOT_FOUND", "Process not found")
+		return connSession.sendProcessNotFound(payload.ProcessID)
 	}
 
 	// Verify it's a Claude process
-	if proc.Type != process.TypeClaude {
-		return connSession.SendError("INVALID_STATE", "Process is not a Claude process")
+	if proc.GetType() != process.TypeClaude {
+		return connSession.SendErrorDetails(protocol.ErrorInvalidState,
+			protocol.ErrorDetails{"processId": proc.ID, "processType": proc.GetType(), "reason": "process is not a Claude process"})
+	}
+
+	confirmed, err := s.confirmDestructive(connSession, protocol.TypeClaudeKill, proc.ID, payload.Confirmation, s.config.ConfirmKills,
+		func() protocol.ConfirmationChallengePayload {
+			return s.processChallenge(proc, protocol.TypeClaudeKill)
+		})
+	if !confirmed {
+		return err
 	}
 
 	// Close AgentAPI clients
 	proc.ClearAgentClients()
 
 	// If we know the AgentAPI PID, try to kill it
-	if proc.AgentAPIPID != nil && proc.PTY != nil {
-		killCmd := fmt.Sprintf("kill %d 2>/dev/null\n", *proc.AgentAPIPID)
+	if pid, ok := proc.GetAgentAPIPID(); ok && proc.PTY != nil {
+		killCmd := fmt.Sprintf("kill %d 2>/dev/null\n", pid)
 		if err := proc.PTY.Write([]byte(killCmd)); err != nil {
 			log.Printf("[WARN] [CLAUDE] Failed to send kill command: %v", err)
+			s.setProcessError(proc, process.OpClaudeKill, protocol.ErrorPtyError, fmt.Errorf("failed to kill AgentAPI: %w", err))
+		} else {
+			s.clearProcessError(proc, process.OpClaudeKill)
 		}
 		// Wait for it to die
 		time.Sleep(500 * time.Millisecond)
 	}
+	// Its event stream and status went with it
+	s.clearProcessError(proc, process.OpAgentEvents, process.OpAgentStatus)
 
-	// Release the port
-	if proc.Port != nil {
-		s.processRegistry.ReleasePort(*proc.Port)
+	// Revert process to shell type and release the port
+	if port, ok := proc.RevertToShell(); ok {
+		s.processRegistry.ReleasePort(port)
 	}
 
-	// Revert process to shell type
-	proc.UpdateType(process.TypeShell)
-	proc.SetAgentAPIReady(false)
-	proc.Port = nil
-	proc.AgentAPIPID = nil
+	if s.storage != nil {
+		if err := s.storage.UpdateProcessClaudeCWD(payload.ProcessID, ""); err != nil {
+			log.Printf("[WARN] [CLAUDE] Failed to clear Claude CWD for %s: %v", payload.ProcessID, err)
+		}
+		if err := s.storage.UpdateProcessClaudeSessionID(payload.ProcessID, ""); err != nil {
+			log.Printf("[WARN] [CLAUDE] Failed to clear Claude session ID for %s: %v", payload.ProcessID, err)
+		}
+	}
+	s.transcripts.forget(payload.ProcessID)
 
 	log.Printf("[INFO] [CLAUDE] Killed Claude on process %s, reverted to shell", payload.ProcessID)
 
 	// Send process_updated notification
-	response, err := protocol.NewMessage(protocol.TypeProcessUpdated, protocol.ProcessUpdatedPayload{
-		ID:            proc.ID,
-		Type:          protocol.ProcessTypeShell,
-		Port:          nil,
-		PtyReady:      proc.PtyReady,
-		AgentAPIReady: false,
-	})
-	if err != nil {
-		return err
-	}
-
-	return connSession.Send(response)
+	return s.notifyProcessUpdated(connSession, proc)
 }
 
-// handleAgentAPIEvent forwards AgentAPI SSE events to the WebSocket client
-// and caches message_update events to storage
-func (s *Server) handleAgentAPIEvent(connSession *ConnectedSession, hostID, processID string, event agentapi.SSEEvent) {
+// handleAgentAPIEvent caches message_update events to storage and forwards
+// every event to the connected sessions subscribed to the process
+func (s *Server) handleAgentAPIEvent(hostID, processID string, event agentapi.SSEEvent) {
 	log.Printf("[DEBUG] [CLAUDE] Forwarding SSE event: type=%s", event.Type)
 
-	// Cache message_update events to storage
-	if event.Type == agentapi.EventMessageUpdate && s.storage != nil {
+	// Cache message_update events to storage, whether or not anyone is subscribed
+	var update *agentapi.MessageUpdateData
+	if event.Type == agentapi.EventMessageUpdate {
 		var msgData agentapi.MessageUpdateData
 		if err := json.Unmarshal(event.Data, &msgData); err == nil {
-			if err := s.storage.UpsertChatMessage(processID, hostID, storage.ChatMessage{
-				MessageID:   msgData.ID,
-				Role:        msgData.Role,
-				Message:     msgData.Message,
-				MessageTime: msgData.Time,
-			}); err != nil {
-				log.Printf("[WARN] [CLAUDE] Failed to cache chat message for process %s: %v", processID, err)
+			update = &msgData
+		}
+	}
+	var clientMessageID string
+	if update != nil && s.storage != nil {
+		cached, err := s.storage.UpsertChatMessage(processID, hostID, storage.ChatMessage{
+			MessageID:   update.ID,
+			Role:        update.Role,
+			Message:     update.Message,
+			MessageTime: update.Time,
+			Kind:        update.Kind,
+			Metadata:    update.Metadata,
+		})
+		if err != nil {
+			log.Printf("[WARN] [CLAUDE] Failed to cache chat message for process %s: %v", processID, err)
+		}
+		clientMessageID = cached.ClientMessageID
+	}
+
+	proc := s.processRegistry.Get(processID)
+	if event.Type == agentapi.EventStatusChange {
+		var statusData agentapi.StatusChangeData
+		if err := json.Unmarshal(event.Data, &statusData); err == nil {
+			if proc != nil {
+				s.trackAgentStatus(proc, statusData.Status, statusData.AgentType)
+			}
+			// Claude finished a turn, so its usage moved on
+			if statusData.Status == "stable" {
+				go s.refreshUsage(processID)
 			}
 		}
 	}
 
-	// Forward to WebSocket client
-	msg, err := protocol.NewMessage(protocol.TypeChatEvent, protocol.ChatEventPayload{
-		HostID:    hostID,
-		ProcessID: processID,
-		Event:     string(event.Type),
-		Data:      event.Data,
+	msgs := newStampedMessage(func(serverTs int64) (*protocol.Message, error) {
+		return protocol.NewMessage(protocol.TypeChatEvent, protocol.ChatEventPayload{
+			HostID:          hostID,
+			ProcessID:       processID,
+			Event:           string(event.Type),
+			Data:            event.Data,
+			ClientMessageID: clientMessageID,
+			ServerTs:        serverTs,
+		})
 	})
-	if err != nil {
-		log.Printf("[ERROR] [CLAUDE] Failed to create chat event message: %v", err)
-		return
-	}
 
-	if err := connSession.Send(msg); err != nil {
-		log.Printf("[ERROR] [CLAUDE] Failed to send chat event: %v", err)
+	for _, sess := range s.sessionManager.GetConnectedSessions() {
+		if !sess.IsSubscribedToChat(hostID, processID) {
+			continue
+		}
+		msg, err := msgs.forSession(sess)
+		if err != nil {
+			log.Printf("[ERROR] [CLAUDE] Failed to create chat event message: %v", err)
+			return
+		}
+		target := &ConnectedSession{Session: sess, server: s}
+		if err := target.Send(msg); err != nil {
+			log.Printf("[ERROR] [CLAUDE] Failed to send chat event to session %s: %v", sess.ID, err)
+			continue
+		}
+		if proc != nil {
+			proc.CountTraffic(process.TrafficChatEvents, len(event.Data))
+		}
+		if update != nil {
+			sess.NoteChatDelivered(processID, update.ID)
+		}
 	}
 }
 
@@ -1612,19 +2839,36 @@ func (s *Server) handlePtyInput(connSession *ConnectedSession, msg *protocol.Mes
 	// Get the process
 	proc := s.processRegistry.Get(payload.ProcessID)
 	if proc == nil {
-		return connSession.SendError("NOT_FOUND", "Process not found")
+		return connSession.sendProcessNotFound(payload.ProcessID)
 	}
+	// Received whether or not it can be written
+	proc.CountTraffic(process.TrafficPtyInput, len(payload.Data))
 
 	// Check if PTY exists
 	if proc.PTY == nil {
-		return connSession.SendError("NO_PTY", "Process has no PTY")
+		return connSession.SendErrorDetails(protocol.ErrorNoPty, protocol.ErrorDetails{"processId": proc.ID})
+	}
+
+	data, err := sanitizeInput(payload.Data, inputMode(payload.Mode, protocol.InputModeRaw), "process "+proc.ID)
+	if err != nil {
+		return err
+	}
+	if data == "" {
+		return nil
+	}
+
+	// Copy mode would take the input as its own commands
+	if err := s.leaveCopyMode(connSession, proc); err != nil {
+		log.Printf("[ERROR] [PTY] Failed to leave copy mode of process %s: %v", payload.ProcessID, err)
+		return s.sendPtyFailure(connSession, proc, process.OpPtyWrite, err)
 	}
 
 	// Write to PTY stdin
-	if err := proc.PTY.Write([]byte(payload.Data)); err != nil {
+	if err := proc.PTY.Write([]byte(data)); err != nil {
 		log.Printf("[ERROR] [PTY] Write error for process %s: %v", payload.ProcessID, err)
-		return connSession.SendError("PTY_ERROR", err.Error())
+		return s.sendPtyFailure(connSession, proc, process.OpPtyWrite, err)
 	}
+	s.clearProcessError(proc, process.OpPtyWrite)
 
 	return nil
 }
@@ -1640,19 +2884,21 @@ func (s *Server) handlePtyResize(connSession *ConnectedSession, msg *protocol.Me
 	// Get the process
 	proc := s.processRegistry.Get(payload.ProcessID)
 	if proc == nil {
-		return connSession.SendError("NOT_FOUND", "Process not found")
+		return connSession.sendProcessNotFound(payload.ProcessID)
 	}
 
 	// Check if PTY exists
 	if proc.PTY == nil {
-		return connSession.SendError("NO_PTY", "Process has no PTY")
+		return connSession.SendErrorDetails(protocol.ErrorNoPty, protocol.ErrorDetails{"processId": proc.ID})
 	}
 
 	// Resize PTY
 	if err := proc.PTY.Resize(payload.Cols, payload.Rows); err != nil {
 		log.Printf("[ERROR] [PTY] Resize error for process %s: %v", payload.ProcessID, err)
-		return connSession.SendError("PTY_ERROR", err.Error())
+		return s.sendPtyFailure(connSession, proc, process.OpPtyResize, err)
 	}
+	s.clearProcessError(proc, process.OpPtyResize)
+	connSession.SetTermSize(proc.ID, payload.Cols, payload.Rows)
 
 	return nil
 }
@@ -1663,7 +2909,8 @@ func (s *Server) handlePtyHistoryRequest(connSession *ConnectedSession, msg *pro
 		return err
 	}
 
-	log.Printf("[DEBUG] [PTY] History request: processId=%s", payload.ProcessID)
+	chunkSize := s.historyChunkSize(payload.ChunkSize)
+	log.Printf("[DEBUG] [PTY] History request: processId=%s chunkSize=%d", payload.ProcessID, chunkSize)
 
 	// Check if storage is available
 	if s.storage == nil {
@@ -1671,6 +2918,7 @@ func (s *Server) handlePtyHistoryRequest(connSession *ConnectedSession, msg *pro
 		response, err := protocol.NewMessage(protocol.TypePtyHistoryComplete, protocol.PtyHistoryCompletePayload{
 			ProcessID: payload.ProcessID,
 			Success:   false,
+			Code:      protocol.ErrorStorageError,
 			Error:     &errMsg,
 		})
 		if err != nil {
@@ -1696,20 +2944,23 @@ func (s *Server) handlePtyHistoryRequest(connSession *ConnectedSession, msg *pro
 	}
 
 	// Get history in chunks
-	chunkSize := 64 * 1024 // 64KB chunks
+	start := time.Now()
 	chunkChan, totalChunks, err := s.storage.GetPtyHistoryChunked(payload.ProcessID, chunkSize)
 	if err != nil {
 		errMsg := err.Error()
 		complete, _ := protocol.NewMessage(protocol.TypePtyHistoryComplete, protocol.PtyHistoryCompletePayload{
 			ProcessID: payload.ProcessID,
 			Success:   false,
+			Code:      protocol.ErrorStorageError,
 			Error:     &errMsg,
 		})
 		return connSession.Send(complete)
 	}
 
-	// Send chunks
-	chunkIndex := 0
+	// Send chunks at background priority, so live output for the process
+	// (and anything else sent meanwhile) is interleaved ahead of them
+	stats := protocol.PtyHistoryTransferStats{ChunkSize: chunkSize}
+	chunkIndex, sendFailures := 0, 0
 	for chunk := range chunkChan {
 		isLast := chunkIndex == totalChunks-1
 
@@ -1720,33 +2971,52 @@ func (s *Server) handlePtyHistoryRequest(connSession *ConnectedSession, msg *pro
 			TotalChunks: totalChunks,
 			IsLast:      isLast,
 		})
-		if err != nil {
-			log.Printf("[ERROR] [PTY] Failed to create chunk message: %v", err)
-			continue
+		if err == nil {
+			err = connSession.sendBackground(chunkMsg)
 		}
-
-		if err := connSession.Send(chunkMsg); err != nil {
+		if err != nil {
 			log.Printf("[ERROR] [PTY] Failed to send chunk: %v", err)
 			// Continue trying to send remaining chunks
+			sendFailures++
+		} else {
+			stats.Bytes += int64(len(chunk))
 		}
 
 		chunkIndex++
 	}
+	stats.Chunks = chunkIndex
+	setTransferRate(&stats, time.Since(start))
+	if proc := s.processRegistry.Get(payload.ProcessID); proc != nil {
+		proc.CountTraffic(process.TrafficHistory, int(stats.Bytes))
+	}
 
-	// Send completion
-	complete, err := protocol.NewMessage(protocol.TypePtyHistoryComplete, protocol.PtyHistoryCompletePayload{
+	// Send completion, a failure if the client is missing chunks: storage
+	// ended the stream early or chunks couldn't be sent
+	result := protocol.PtyHistoryCompletePayload{
 		ProcessID: payload.ProcessID,
 		Success:   true,
-	})
+		Stats:     &stats,
+	}
+	if chunkIndex < totalChunks || sendFailures > 0 {
+		errMsg := fmt.Sprintf("History cut short: sent %d of %d chunks", chunkIndex-sendFailures, totalChunks)
+		result.Success, result.Error = false, &errMsg
+		result.Code = protocol.ErrorStorageError
+		if sendFailures > 0 {
+			result.Code = protocol.ErrorHandlerError
+		}
+		log.Printf("[WARN] [PTY] %s for process %s", errMsg, payload.ProcessID)
+	}
+	complete, err := protocol.NewMessage(protocol.TypePtyHistoryComplete, result)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("[INFO] [PTY] Sent %d history chunks (%d bytes) for process %s", chunkIndex, totalSize, payload.ProcessID)
+	log.Printf("[INFO] [PTY] Sent %d history chunks (%d bytes) for process %s in %dms (%d B/s)",
+		chunkIndex, totalSize, payload.ProcessID, stats.DurationMs, stats.BytesPerSecond)
 	return connSession.Send(complete)
 }
 
-func (s *Server) handleChatSubscribe(session *ConnectedSession, msg *protocol.Message) error {
+func (s *Server) handleChatSubscribe(connSession *ConnectedSession, msg *protocol.Message) error {
 	var payload protocol.ChatSubscribePayload
 	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
 		return err
@@ -1754,29 +3024,78 @@ func (s *Server) handleChatSubscribe(session *ConnectedSession, msg *protocol.Me
 
 	log.Printf("[DEBUG] [CHAT] Subscribe: hostId=%s processId=%s", payload.HostID, payload.ProcessID)
 
-	// Get the process
+	result := protocol.ChatSubscribeResultPayload{
+		HostID:    payload.HostID,
+		ProcessID: payload.ProcessID,
+	}
+
+	// Host-wide subscriptions cover processes that don't exist yet, so there
+	// is nothing to look up
+	if payload.ProcessID == session.AllProcesses {
+		connSession.SubscribeChat(payload.HostID, payload.ProcessID)
+		log.Printf("[INFO] [CHAT] Session %s subscribed to all chat events on host %s", connSession.ID, payload.HostID)
+		result.Success = true
+		return s.sendChatSubscribeResult(connSession, result)
+	}
+
 	proc := s.processRegistry.Get(payload.ProcessID)
 	if proc == nil {
-		return session.SendError("NOT_FOUND", "Process not found")
+		result.Code = protocol.ErrorNotFound
+		result.Error = strPtr("Process not found")
+		return s.sendChatSubscribeResult(connSession, result)
+	}
+
+	connSession.SubscribeChat(payload.HostID, payload.ProcessID)
+	log.Printf("[INFO] [CHAT] Session %s subscribed to chat events for process %s", connSession.ID, payload.ProcessID)
+
+	result = s.chatSubscription(payload.HostID, proc)
+	// The client is told the latest message, so it has what came before
+	if result.LatestMessageID != nil {
+		connSession.NoteChatDelivered(proc.ID, *result.LatestMessageID)
 	}
+	return s.sendChatSubscribeResult(connSession, result)
+}
 
-	// Check if it's a Claude process with SSE client
-	if proc.Type != process.TypeClaude {
-		return session.SendError("NOT_CLAUDE", "Process is not a Claude process")
+// chatSubscription returns the result of a successful chat subscription to
+// a process: its AgentAPI status and the latest cached message
+func (s *Server) chatSubscription(hostID string, proc *process.Process) protocol.ChatSubscribeResultPayload {
+	result := protocol.ChatSubscribeResultPayload{
+		HostID:    hostID,
+		ProcessID: proc.ID,
 	}
 
-	if proc.SSEClient == nil {
-		return session.SendError("NOT_CONNECTED", "AgentAPI not connected")
+	status := "disconnected"
+	if client := proc.GetAgentClient(); proc.GetType() == process.TypeClaude && client != nil {
+		if st, err := s.agentStatus(proc, client); err != nil {
+			log.Printf("[WARN] [CHAT] GetStatus failed for process %s: %v", proc.ID, err)
+		} else {
+			status = st.Status
+		}
+	}
+	result.Status = &status
+
+	if s.storage != nil {
+		latest, ok, err := s.storage.GetLatestChatMessageID(proc.ID)
+		if err != nil {
+			log.Printf("[WARN] [CHAT] Failed to read latest cached message for process %s: %v", proc.ID, err)
+		} else if ok {
+			result.LatestMessageID = &latest
+		}
 	}
 
-	// SSE client is already connected (set up during claude_start)
-	// The events are being forwarded via handleAgentAPIEvent
-	log.Printf("[INFO] [CHAT] Subscribed to events for process %s (SSE already connected)", payload.ProcessID)
+	result.Success = true
+	return result
+}
 
-	return nil
+func (s *Server) sendChatSubscribeResult(connSession *ConnectedSession, result protocol.ChatSubscribeResultPayload) error {
+	response, err := protocol.NewMessage(protocol.TypeChatSubscribeResult, result)
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
 }
 
-func (s *Server) handleChatUnsubscribe(session *ConnectedSession, msg *protocol.Message) error {
+func (s *Server) handleChatUnsubscribe(connSession *ConnectedSession, msg *protocol.Message) error {
 	var payload protocol.ChatUnsubscribePayload
 	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
 		return err
@@ -1784,17 +3103,10 @@ func (s *Server) handleChatUnsubscribe(session *ConnectedSession, msg *protocol.
 
 	log.Printf("[DEBUG] [CHAT] Unsubscribe: hostId=%s processId=%s", payload.HostID, payload.ProcessID)
 
-	// Get the process
-	proc := s.processRegistry.Get(payload.ProcessID)
-	if proc == nil {
-		// Process already gone, nothing to unsubscribe
-		return nil
-	}
-
-	// Note: We don't close the SSE client here because it's shared.
-	// The SSE client stays connected as long as the Claude process is running.
-	// It will be closed when claude_kill is called.
-	log.Printf("[INFO] [CHAT] Unsubscribed from events for process %s", payload.ProcessID)
+	// Note: the SSE client is shared by all sessions and stays connected as
+	// long as the Claude process is running; it is closed by claude_kill.
+	connSession.UnsubscribeChat(payload.HostID, payload.ProcessID)
+	log.Printf("[INFO] [CHAT] Session %s unsubscribed from chat events for %s/%s", connSession.ID, payload.HostID, payload.ProcessID)
 
 	return nil
 }
@@ -1807,29 +3119,83 @@ func (s *Server) handleChatSend(session *ConnectedSession, msg *protocol.Message
 
 	log.Printf("[DEBUG] [CHAT] Send: hostId=%s processId=%s content=%s", payload.HostID, payload.ProcessID, payload.Content)
 
+	clientMessageID := uuid.New().String()
+	if payload.ClientMessageID != nil && *payload.ClientMessageID != "" {
+		clientMessageID = *payload.ClientMessageID
+	}
+	result := protocol.ChatSendResultPayload{
+		HostID:          payload.HostID,
+		ProcessID:       payload.ProcessID,
+		ClientMessageID: clientMessageID,
+	}
+
+	sent, failure := s.sendChatMessage(payload, clientMessageID)
+	if failure != nil {
+		if err := session.sendFailure(failure); err != nil {
+			return err
+		}
+		result.Code = failure.code
+		result.Error = strPtr(failure.Error())
+	} else {
+		result.Success = true
+		result.Message = &sent
+	}
+
+	response, err := protocol.NewMessage(protocol.TypeChatSendResult, result)
+	if err != nil {
+		return err
+	}
+	return session.Send(response)
+}
+
+// sendChatMessage sends a chat message to a Claude process. The message is
+// cached as pending first, so it is in the chat history at once, and dropped
+// again if sending fails; AgentAPI's echo of it replaces it (see
+// storage.AddPendingChatMessage). Returns the message as cached.
+func (s *Server) sendChatMessage(payload protocol.ChatSendPayload, clientMessageID string) (protocol.ChatMessage, *requestFailure) {
 	// Get the process
 	proc := s.processRegistry.Get(payload.ProcessID)
 	if proc == nil {
-		return session.SendError("NOT_FOUND", "Process not found")
+		return protocol.ChatMessage{}, &requestFailure{protocol.ErrorNotFound, protocol.ErrorDetails{"processId": payload.ProcessID}}
 	}
 
 	// Check if it's a Claude process with AgentAPI client
-	if proc.Type != process.TypeClaude {
-		return session.SendError("NOT_CLAUDE", "Process is not a Claude process")
+	if proc.GetType() != process.TypeClaude {
+		return protocol.ChatMessage{}, &requestFailure{protocol.ErrorNotClaude,
+			protocol.ErrorDetails{"processId": proc.ID, "processType": proc.GetType()}}
+	}
+
+	client := proc.GetAgentClient()
+	if client == nil {
+		return protocol.ChatMessage{}, &requestFailure{protocol.ErrorNotConnected,
+			protocol.ErrorDetails{"processId": proc.ID, "reason": "AgentAPI not connected"}}
 	}
 
-	if proc.AgentClient == nil {
-		return session.SendError("NOT_CONNECTED", "AgentAPI not connected")
+	// Cached before sending: AgentAPI may stream its echo before it answers
+	sent := storage.ChatMessage{
+		MessageID:       -1,
+		Role:            "user",
+		Message:         payload.Content,
+		MessageTime:     time.Now().UTC().Format(time.RFC3339Nano),
+		Pending:         true,
+		ClientMessageID: clientMessageID,
+	}
+	if s.storage != nil {
+		sent = s.storage.AddPendingChatMessage(proc.ID, proc.HostID, clientMessageID, payload.Content)
 	}
 
 	// SendMessage only works when agent is stable
-	if err := proc.AgentClient.SendMessage(payload.Content); err != nil {
+	if err := client.SendMessage(payload.Content); err != nil {
 		log.Printf("[ERROR] [CHAT] SendMessage failed for process %s: %v", payload.ProcessID, err)
-		return session.SendError("SEND_FAILED", err.Error())
+		if s.storage != nil {
+			s.storage.DropPendingChatMessage(proc.ID, sent.MessageID)
+		}
+		return protocol.ChatMessage{}, agentFailure(proc.ID, err)
 	}
 
-	log.Printf("[INFO] [CHAT] Message sent to process %s", payload.ProcessID)
-	return nil
+	log.Printf("[INFO] [CHAT] Message %s sent to process %s", clientMessageID, payload.ProcessID)
+	s.clearSentChatDraft(payload.ProcessID, payload.Content)
+	return toProtocolChatMessage(sent), nil
 }
 
 func (s *Server) handleChatRaw(session *ConnectedSession, msg *protocol.Message) error {
@@ -1843,22 +3209,32 @@ func (s *Server) handleChatRaw(session *ConnectedSession, msg *protocol.Message)
 	// Get the process
 	proc := s.processRegistry.Get(payload.ProcessID)
 	if proc == nil {
-		return session.SendError("NOT_FOUND", "Process not found")
+		return session.sendProcessNotFound(payload.ProcessID)
 	}
 
 	// Check if it's a Claude process with AgentAPI client
-	if proc.Type != process.TypeClaude {
-		return session.SendError("NOT_CLAUDE", "Process is not a Claude process")
+	if proc.GetType() != process.TypeClaude {
+		return session.SendErrorDetails(protocol.ErrorNotClaude,
+			protocol.ErrorDetails{"processId": proc.ID, "processType": proc.GetType()})
+	}
+
+	client := proc.GetAgentClient()
+	if client == nil {
+		return session.SendErrorDetails(protocol.ErrorNotConnected, protocol.ErrorDetails{"processId": proc.ID, "reason": "AgentAPI not connected"})
 	}
 
-	if proc.AgentClient == nil {
-		return session.SendError("NOT_CONNECTED", "AgentAPI not connected")
+	content, err := sanitizeInput(payload.Content, inputMode(payload.Mode, protocol.InputModeKeys), "Claude of process "+proc.ID)
+	if err != nil {
+		return err
+	}
+	if content == "" {
+		return nil
 	}
 
 	// SendRaw works in any state (running or stable)
-	if err := proc.AgentClient.SendRaw(payload.Content); err != nil {
+	if err := client.SendRaw(content); err != nil {
 		log.Printf("[ERROR] [CHAT] SendRaw failed for process %s: %v", payload.ProcessID, err)
-		return session.SendError("SEND_FAILED", err.Error())
+		return session.sendAgentError(proc.ID, err)
 	}
 
 	log.Printf("[INFO] [CHAT] Raw input sent to process %s", payload.ProcessID)
@@ -1871,7 +3247,7 @@ func (s *Server) handleChatStatus(session *ConnectedSession, msg *protocol.Messa
 		return err
 	}
 
-	log.Printf("[DEBUG] [CHAT] Status: hostId=%s processId=%s", payload.HostID, payload.ProcessID)
+	log.Printf("[DEBUG] [CHAT] Status: hostId=%s processId=%s force=%v", payload.HostID, payload.ProcessID, payload.Force)
 
 	// Get the process
 	proc := s.processRegistry.Get(payload.ProcessID)
@@ -1889,7 +3265,8 @@ func (s *Server) handleChatStatus(session *ConnectedSession, msg *protocol.Messa
 	}
 
 	// Check if it's a Claude process
-	if proc.Type != process.TypeClaude || proc.AgentClient == nil {
+	client := proc.GetAgentClient()
+	if proc.GetType() != process.TypeClaude || client == nil {
 		response, err := protocol.NewMessage(protocol.TypeChatStatusResult, protocol.ChatStatusResultPayload{
 			HostID:    payload.HostID,
 			ProcessID: payload.ProcessID,
@@ -1901,8 +3278,24 @@ func (s *Server) handleChatStatus(session *ConnectedSession, msg *protocol.Messa
 		return session.Send(response)
 	}
 
+	// The tracked status, unless asked to check or none is known yet
+	if !payload.Force {
+		if status, agentType := proc.AgentStatus(); status != "" {
+			response, err := protocol.NewMessage(protocol.TypeChatStatusResult, protocol.ChatStatusResultPayload{
+				HostID:    payload.HostID,
+				ProcessID: payload.ProcessID,
+				Status:    status,
+				AgentType: &agentType,
+			})
+			if err != nil {
+				return err
+			}
+			return session.Send(response)
+		}
+	}
+
 	// Get status from AgentAPI
-	status, err := proc.AgentClient.GetStatus()
+	status, err := s.agentStatus(proc, client)
 	if err != nil {
 		log.Printf("[ERROR] [CHAT] GetStatus failed for process %s: %v", payload.ProcessID, err)
 		response, err := protocol.NewMessage(protocol.TypeChatStatusResult, protocol.ChatStatusResultPayload{
@@ -1937,6 +3330,10 @@ func (s *Server) handleChatHistory(session *ConnectedSession, msg *protocol.Mess
 
 	log.Printf("[DEBUG] [CHAT] History: hostId=%s processId=%s", payload.HostID, payload.ProcessID)
 
+	// Sent with the history so a client restores the compose box in the
+	// same round trip
+	draft := s.chatDraft(payload.ProcessID)
+
 	// Get the process
 	proc := s.processRegistry.Get(payload.ProcessID)
 	if proc == nil {
@@ -1946,17 +3343,13 @@ func (s *Server) handleChatHistory(session *ConnectedSession, msg *protocol.Mess
 			if err == nil && len(storedMessages) > 0 {
 				chatMessages := make([]protocol.ChatMessage, len(storedMessages))
 				for i, m := range storedMessages {
-					chatMessages[i] = protocol.ChatMessage{
-						ID:      m.MessageID,
-						Role:    m.Role,
-						Message: m.Message,
-						Time:    m.MessageTime,
-					}
+					chatMessages[i] = toProtocolChatMessage(m)
 				}
 				response, err := protocol.NewMessage(protocol.TypeChatMessages, protocol.ChatMessagesPayload{
 					HostID:    payload.HostID,
 					ProcessID: payload.ProcessID,
 					Messages:  chatMessages,
+					Draft:     draft,
 				})
 				if err != nil {
 					return err
@@ -1970,6 +3363,7 @@ func (s *Server) handleChatHistory(session *ConnectedSession, msg *protocol.Mess
 			HostID:    payload.HostID,
 			ProcessID: payload.ProcessID,
 			Messages:  []protocol.ChatMessage{},
+			Draft:     draft,
 		})
 		if err != nil {
 			return err
@@ -1983,18 +3377,14 @@ func (s *Server) handleChatHistory(session *ConnectedSession, msg *protocol.Mess
 		if err == nil && len(storedMessages) > 0 {
 			chatMessages := make([]protocol.ChatMessage, len(storedMessages))
 			for i, m := range storedMessages {
-				chatMessages[i] = protocol.ChatMessage{
-					ID:      m.MessageID,
-					Role:    m.Role,
-					Message: m.Message,
-					Time:    m.MessageTime,
-				}
+				chatMessages[i] = toProtocolChatMessage(m)
 			}
 			log.Printf("[DEBUG] [CHAT] Returning %d messages from cache for process %s", len(chatMessages), payload.ProcessID)
 			response, err := protocol.NewMessage(protocol.TypeChatMessages, protocol.ChatMessagesPayload{
 				HostID:    payload.HostID,
 				ProcessID: payload.ProcessID,
 				Messages:  chatMessages,
+				Draft:     draft,
 			})
 			if err != nil {
 				return err
@@ -2004,11 +3394,13 @@ func (s *Server) handleChatHistory(session *ConnectedSession, msg *protocol.Mess
 	}
 
 	// Fallback: Get messages from AgentAPI (for initial sync or if cache is empty)
-	if proc.Type != process.TypeClaude || proc.AgentClient == nil {
+	client := proc.GetAgentClient()
+	if proc.GetType() != process.TypeClaude || client == nil {
 		response, err := protocol.NewMessage(protocol.TypeChatMessages, protocol.ChatMessagesPayload{
 			HostID:    payload.HostID,
 			ProcessID: payload.ProcessID,
 			Messages:  []protocol.ChatMessage{},
+			Draft:     draft,
 		})
 		if err != nil {
 			return err
@@ -2016,13 +3408,14 @@ func (s *Server) handleChatHistory(session *ConnectedSession, msg *protocol.Mess
 		return session.Send(response)
 	}
 
-	messages, err := proc.AgentClient.GetMessages()
+	messages, err := client.GetMessages()
 	if err != nil {
 		log.Printf("[ERROR] [CHAT] GetMessages failed for process %s: %v", payload.ProcessID, err)
 		response, err := protocol.NewMessage(protocol.TypeChatMessages, protocol.ChatMessagesPayload{
 			HostID:    payload.HostID,
 			ProcessID: payload.ProcessID,
 			Messages:  []protocol.ChatMessage{},
+			Draft:     draft,
 		})
 		if err != nil {
 			return err
@@ -2035,16 +3428,20 @@ func (s *Server) handleChatHistory(session *ConnectedSession, msg *protocol.Mess
 	storageMessages := make([]storage.ChatMessage, len(messages))
 	for i, m := range messages {
 		chatMessages[i] = protocol.ChatMessage{
-			ID:      m.ID,
-			Role:    m.Role,
-			Message: m.Message,
-			Time:    m.Time,
+			ID:       m.ID,
+			Role:     m.Role,
+			Message:  m.Message,
+			Time:     m.Time,
+			Kind:     m.Kind,
+			Metadata: m.Metadata,
 		}
 		storageMessages[i] = storage.ChatMessage{
 			MessageID:   m.ID,
 			Role:        m.Role,
 			Message:     m.Message,
 			MessageTime: m.Time,
+			Kind:        m.Kind,
+			Metadata:    m.Metadata,
 		}
 	}
 
@@ -2060,6 +3457,7 @@ func (s *Server) handleChatHistory(session *ConnectedSession, msg *protocol.Mess
 		HostID:    payload.HostID,
 		ProcessID: payload.ProcessID,
 		Messages:  chatMessages,
+		Draft:     draft,
 	})
 	if err != nil {
 		return err
@@ -2068,39 +3466,166 @@ func (s *Server) handleChatHistory(session *ConnectedSession, msg *protocol.Mess
 	return session.Send(response)
 }
 
+// toProtocolChatMessage converts a cached chat message for the client
+func toProtocolChatMessage(m storage.ChatMessage) protocol.ChatMessage {
+	return protocol.ChatMessage{
+		ID:              m.MessageID,
+		Role:            m.Role,
+		Message:         m.Message,
+		Time:            m.MessageTime,
+		Pending:         m.Pending,
+		ClientMessageID: m.ClientMessageID,
+		Kind:            m.Kind,
+		Metadata:        m.Metadata,
+	}
+}
+
 // Helper function to create string pointer
 func strPtr(s string) *string {
 	return &s
 }
 
+// Helper function to create int pointer
+func intPtr(i int) *int {
+	return &i
+}
+
+// processUpdatedPayload builds a PROCESS_UPDATED payload from a process snapshot
+func processUpdatedPayload(info protocol.ProcessInfo) protocol.ProcessUpdatedPayload {
+	return protocol.ProcessUpdatedPayload{
+		ID:            info.ID,
+		HostID:        info.HostID,
+		Type:          info.Type,
+		Port:          info.Port,
+		Name:          info.Name,
+		PtyReady:      info.PtyReady,
+		AgentAPIReady: info.AgentAPIReady,
+		ShellPID:      info.ShellPID,
+		AgentAPIPID:   info.AgentAPIPID,
+		WorkspaceID:   info.WorkspaceID,
+		CWD:           info.CWD,
+		ClaudeCWD:     info.ClaudeCWD,
+		Pinned:        info.Pinned,
+		SortWeight:    info.SortWeight,
+		TermOptions:   info.TermOptions,
+		Timeline:      info.Timeline,
+		Exited:        info.Exited,
+		LastError:     info.LastError,
+		AgentStatus:   info.AgentStatus,
+		Color:         info.Color,
+		Icon:          info.Icon,
+
+		RetainFullHistory: info.RetainFullHistory,
+		TerminalAttached:  info.TerminalAttached,
+	}
+}
+
+// installPtyCapture installs the history capture handler on a process's PTY.
+// It is set once per PTY session and keeps storing output regardless of which
+// client (if any) the output handler currently forwards to.
+func (s *Server) installPtyCapture(proc *process.Process) {
+	if s.storage == nil {
+		return
+	}
+	processID := proc.ID
+	hostID := proc.HostID
+
+	// Load persisted history first so new output is appended after it
+	if err := s.storage.EnsurePtyHistoryLoaded(processID, hostID); err != nil {
+		log.Printf("[WARN] [PTY] Failed to load history for process %s: %v", processID, err)
+	}
+
+	// Commands are only recorded while the timeline is on, but the markers
+	// are always parsed so turning it on doesn't start mid-sequence
+	recorder := &timelineRecorder{processID: processID, store: s.storage}
+	proc.PTY.SetCaptureHandler(func(data []byte) {
+		if err := s.storage.AppendPtyOutput(processID, hostID, data); err != nil {
+			log.Printf("[WARN] [PTY] Failed to store output for process %s: %v", processID, err)
+		}
+		recorder.record(data, proc.TimelineEnabled())
+	})
+}
+
+// backfillPtyHistory stores output the remote program produced while the PTY
+// was detached (nothing was reading it). Must run before the output loop is
+// restarted so the backfilled content lands ahead of new output.
+func (s *Server) backfillPtyHistory(proc *process.Process) {
+	if s.storage == nil {
+		return
+	}
+
+	captured, err := proc.PTY.CapturePane()
+	if err != nil {
+		log.Printf("[WARN] [PTY] Failed to capture pane for backfill of process %s: %v", proc.ID, err)
+		return
+	}
+
+	n, err := s.storage.BackfillPtyOutput(proc.ID, proc.HostID, captured)
+	if err != nil {
+		log.Printf("[WARN] [PTY] Failed to backfill history for process %s: %v", proc.ID, err)
+		return
+	}
+	if n > 0 {
+		log.Printf("[INFO] [PTY] Backfilled %d bytes of detached output for process %s", n, proc.ID)
+	}
+}
+
 // updatePtyOutputHandler updates a process's PTY output handler to send to a new session
-// This is called when a session reconnects to a host with existing processes
+// This is called when a session reconnects to a host with existing processes.
+// History capture is handled separately by installPtyCapture.
 func (s *Server) updatePtyOutputHandler(connSession *ConnectedSession, proc *process.Process) {
 	processID := proc.ID
-	hostID := proc.HostID
 	log.Printf("[DEBUG] [PTY] Updating output handler for process %s to session %s", processID, connSession.ID)
 
 	proc.PTY.SetOutputHandler(func(data []byte) {
-		// Capture to storage for history
-		if s.storage != nil {
-			if err := s.storage.AppendPtyOutput(processID, hostID, data); err != nil {
-				log.Printf("[WARN] [PTY] Failed to store output for process %s: %v", processID, err)
-			}
-		}
+		s.forwardPtyOutput(connSession, proc, data)
+	})
+}
 
-		// Forward to WebSocket client
-		outputMsg, err := protocol.NewMessage(protocol.TypePtyOutput, protocol.PtyOutputPayload{
-			ProcessID: processID,
+// forwardPtyOutput sends a chunk of a process's output to a WebSocket client
+func (s *Server) forwardPtyOutput(connSession *ConnectedSession, proc *process.Process, data []byte) {
+	outputMsgs := newStampedMessage(func(serverTs int64) (*protocol.Message, error) {
+		return protocol.NewMessage(protocol.TypePtyOutput, protocol.PtyOutputPayload{
+			ProcessID: proc.ID,
 			Data:      string(data),
+			ServerTs:  serverTs,
 		})
+	})
+	outputMsg, err := outputMsgs.forSession(connSession.Session)
+	if err != nil {
+		log.Printf("[ERROR] [PTY] Failed to create output message: %v", err)
+		return
+	}
+	if err := connSession.Send(outputMsg); err != nil {
+		log.Printf("[ERROR] [PTY] Failed to send output: %v", err)
+		return
+	}
+	proc.CountTraffic(process.TrafficPtyOutput, len(data))
+	s.forwardPtyOutputToObservers(connSession, proc, outputMsgs)
+}
+
+// forwardPtyOutputToObservers sends a process's output to the observers
+// watching it, i.e. that have it selected. The process's output handler
+// only ever points at the session that attached it.
+func (s *Server) forwardPtyOutputToObservers(owner *ConnectedSession, proc *process.Process, outputMsgs *stampedMessage) {
+	for _, sess := range s.sessionManager.GetConnectedSessions() {
+		if sess.ID == owner.ID {
+			continue
+		}
+		role, scope := sess.Role()
+		if role != session.RoleObserver || !scope.Allows(proc.HostID, proc.ID) || !sess.IsSelected(proc.HostID, proc.ID) {
+			continue
+		}
+		outputMsg, err := outputMsgs.forSession(sess)
 		if err != nil {
 			log.Printf("[ERROR] [PTY] Failed to create output message: %v", err)
 			return
 		}
-		if err := connSession.Send(outputMsg); err != nil {
-			log.Printf("[ERROR] [PTY] Failed to send output: %v", err)
+		target := &ConnectedSession{Session: sess, server: s}
+		if err := target.Send(outputMsg); err != nil {
+			log.Printf("[ERROR] [PTY] Failed to send output to observer session %s: %v", sess.ID, err)
 		}
-	})
+	}
 }
 
 // detachAllProcesses detaches all PTY sessions for a session's hosts
@@ -2119,19 +3644,32 @@ func (s *Server) detachAllProcesses(sessionID string) {
 }
 
 // reattachProcess reattaches to an existing tmux session for a process
-func (s *Server) reattachProcess(connSession *ConnectedSession, proc *process.Process, sshClient *cryptossh.Client) error {
+func (s *Server) reattachProcess(connSession *ConnectedSession, proc *process.Process, sshConn *ssh.Connection) error {
 	if proc.PTY == nil {
 		return fmt.Errorf("process %s has no PTY", proc.ID)
 	}
 
+	// A session recreated under the same name while the host was away is not
+	// this process; binding it would restore AgentAPI on a foreign port
+	shellPID, _ := proc.GetShellPID()
+	if pane, err := pty.QueryPaneInfo(sshConn.Client, s.hostTmux(proc.HostID), proc.PTY.GetTmuxName()); err != nil {
+		log.Printf("[WARN] [PTY] Could not get pane info for process %s: %v", proc.ID, err)
+	} else if !paneMatches(pane, shellPID, proc.StartedAt) {
+		return fmt.Errorf("%w (shell PID %d, was %d)", errPaneReplaced, pane.ShellPID, shellPID)
+	}
+
 	// Update the SSH client reference
-	proc.PTY.UpdateSSHClient(sshClient)
+	proc.PTY.UpdateSSHClient(sshConn.Client)
 
 	// Reattach to the tmux session
 	if err := proc.PTY.Attach(); err != nil {
 		return fmt.Errorf("failed to reattach to tmux session: %w", err)
 	}
 
+	// Store output produced while detached; the capture handler installed at
+	// creation is still in place
+	s.backfillPtyHistory(proc)
+
 	// Update output handler to point to new session
 	s.updatePtyOutputHandler(connSession, proc)
 
@@ -2139,20 +3677,17 @@ func (s *Server) reattachProcess(connSession *ConnectedSession, proc *process.Pr
 	proc.PTY.StartOutputLoop()
 
 	// If this is a Claude process with a port, restore AgentAPI clients
-	if proc.Type == process.TypeClaude && proc.Port != nil {
-		port := *proc.Port
+	if port, ok := proc.GetPort(); ok && proc.GetType() == process.TypeClaude {
 		log.Printf("[DEBUG] [PTY] Restoring AgentAPI clients for Claude process %s on port %d", proc.ID, port)
 
 		// Clear old clients first
 		proc.ClearAgentClients()
 
 		// Create new AgentAPI client
-		agentClient := agentapi.NewClient(sshClient, port)
+		agentClient := agentapi.NewClient(sshConn, port)
 
-		// Create new SSE client with event handler pointing to new session
-		sseClient := agentapi.NewSSEClient(sshClient, port, func(event agentapi.SSEEvent) {
-			s.handleAgentAPIEvent(connSession, proc.HostID, proc.ID, event)
-		})
+		// Create new SSE client; events go to whichever sessions are subscribed
+		sseClient := s.newSSEClient(sshConn, proc, port)
 
 		// Store new clients
 		proc.SetAgentClients(agentClient, sseClient)
@@ -2168,9 +3703,11 @@ func (s *Server) reattachProcess(connSession *ConnectedSession, proc *process.Pr
 		if err != nil {
 			log.Printf("[WARN] [PTY] AgentAPI not responding for process %s: %v", proc.ID, err)
 			proc.SetAgentAPIReady(false)
+			proc.SetAgentStatus("", "")
 		} else {
 			log.Printf("[INFO] [PTY] AgentAPI reconnected for process %s: status=%s", proc.ID, status.Status)
 			proc.SetAgentAPIReady(true)
+			proc.SetAgentStatus(status.Status, status.AgentType)
 		}
 	}
 
@@ -2178,34 +3715,103 @@ func (s *Server) reattachProcess(connSession *ConnectedSession, proc *process.Pr
 	return nil
 }
 
-// scanAndRegisterTmuxSessions scans for existing tmux sessions on a host.
-// Returns:
-// - processInfos: already registered processes that were reattached
-// - detachedProcesses: orphaned tmux sessions that need manual reattach
-func (s *Server) scanAndRegisterTmuxSessions(connSession *ConnectedSession, hostID string, sshClient *cryptossh.Client) ([]protocol.ProcessInfo, []protocol.StaleProcess) {
-	// Scan for tmux sessions
-	tmuxSessions, err := pty.ScanTmuxSessions(sshClient)
+// listTmuxSessions lists the tmux sessions on a host, reporting the managed
+// ones found to progress. It only reads the host and the registry; see
+// registerTmuxSessions for the rest of the scan. Returns the managed sessions
+// and the unmanaged ones: rc-* sessions without a valid process ID, never
+// registered or offered for reattach. When ctx is done first, the listing is
+// cut off and ctx's error returned.
+//
+// duplicateOf are the connected hosts that reach the same machine; sessions
+// they own are left out.
+func (s *Server) listTmuxSessions(ctx context.Context, hostID string, sshConn *ssh.Connection, duplicateOf []string, progress hostConnectProgress) ([]pty.TmuxSessionInfo, []protocol.UnmanagedSession, error) {
+	scanned, err := pty.ScanTmuxSessions(ctx, sshConn.Client, s.hostTmux(hostID))
 	if err != nil {
-		log.Printf("[WARN] [TMUX] Failed to scan tmux sessions: %v", err)
-		return nil, nil
+		return nil, nil, err
 	}
 
-	var processInfos []protocol.ProcessInfo
-	var detachedProcesses []protocol.StaleProcess
-
-	for _, tmuxInfo := range tmuxSessions {
-		// Check if we already have this process registered
-		existingProc := s.processRegistry.Get(tmuxInfo.ProcessID)
-		if existingProc != nil {
-			// Already registered - just reattach
-			if err := s.reattachProcess(connSession, existingProc, sshClient); err != nil {
-				log.Printf("[WARN] [TMUX] Failed to reattach to existing process %s: %v", tmuxInfo.ProcessID, err)
+	var tmuxSessions []pty.TmuxSessionInfo
+	var unmanagedSessions []protocol.UnmanagedSession
+	for _, tmuxInfo := range scanned {
+		if tmuxInfo.Managed() {
+			if owner := s.tmuxSessionOwner(tmuxInfo.ProcessID, duplicateOf); owner != "" && owner != hostID {
+				log.Printf("[INFO] [TMUX] Skipping tmux session %s, owned by host %s", tmuxInfo.Name, owner)
 				continue
 			}
-			processInfos = append(processInfos, existingProc.ToInfo())
+			tmuxSessions = append(tmuxSessions, tmuxInfo)
+			continue
+		}
+		log.Printf("[INFO] [TMUX] Ignoring unmanaged tmux session %q", tmuxInfo.Name)
+		unmanaged := protocol.UnmanagedSession{TmuxSession: tmuxInfo.Name}
+		if !tmuxInfo.Created.IsZero() {
+			unmanaged.StartedAt = strPtr(tmuxInfo.Created.Format("2006-01-02T15:04:05Z07:00"))
+		}
+		unmanagedSessions = append(unmanagedSessions, unmanaged)
+	}
+	progress.report(protocol.HostConnectProgressPayload{
+		Stage: protocol.HostConnectScanningTmux,
+		Found: intPtr(len(tmuxSessions)),
+	})
+	return tmuxSessions, unmanagedSessions, nil
+}
+
+// registerTmuxSessions reattaches the registered processes whose sessions
+// listTmuxSessions found, reporting each reattach to progress, and lists the
+// rest as detached.
+// Returns:
+// - processInfos: already registered processes that were reattached
+// - detachedProcesses: orphaned tmux sessions that need manual reattach;
+// never nil
+// - reattach: how reattaching the registered processes went, see
+// reattachProcesses; nil if there were none
+func (s *Server) registerTmuxSessions(connSession *ConnectedSession, sshConn *ssh.Connection, tmuxSessions []pty.TmuxSessionInfo, progress hostConnectProgress) ([]protocol.ProcessInfo, []protocol.StaleProcess, *protocol.ReattachReport) {
+	// Reattach the processes still registered, a few at a time, reporting
+	// N of M as each is done
+	var registered []*process.Process
+	var orphaned []pty.TmuxSessionInfo
+	registeredSessions := make(map[string]pty.TmuxSessionInfo)
+	for _, tmuxInfo := range tmuxSessions {
+		if existingProc := s.processRegistry.Get(tmuxInfo.ProcessID); existingProc != nil {
+			registered = append(registered, existingProc)
+			registeredSessions[existingProc.ID] = tmuxInfo
 			continue
 		}
+		orphaned = append(orphaned, tmuxInfo)
+	}
+	var processInfos []protocol.ProcessInfo
+	finished := 0
+	reattach := s.reattachProcesses(connSession, registered, sshConn, func(proc *process.Process, err error) {
+		finished++
+		progress.report(protocol.HostConnectProgressPayload{
+			Stage:   protocol.HostConnectReattaching,
+			Current: intPtr(finished),
+			Total:   intPtr(len(registered)),
+		})
+		if errors.Is(err, errPaneReplaced) {
+			// The process is gone; its session is offered like any other
+			// detached one, and the explicit reattach checks it against the
+			// stored metadata
+			log.Printf("[WARN] [TMUX] Not reattaching process %s: %v", proc.ID, err)
+			proc.Detach()
+			s.processRegistry.Unregister(proc.ID)
+			s.alertLimiter.forget(proc.ID)
+			orphaned = append(orphaned, registeredSessions[proc.ID])
+			return
+		}
+		if err != nil {
+			log.Printf("[WARN] [TMUX] Failed to reattach to existing process %s: %v", proc.ID, err)
+		}
+	})
+	if reattach != nil {
+		for _, proc := range registered {
+			if slices.Contains(reattach.Reattached, proc.ID) {
+				processInfos = append(processInfos, proc.ToInfo())
+			}
+		}
+	}
 
+	detachedProcesses := []protocol.StaleProcess{}
+	for _, tmuxInfo := range orphaned {
 		// Orphaned tmux session - report as detached for manual reattach
 		log.Printf("[INFO] [TMUX] Found detached tmux session %s", tmuxInfo.Name)
 		startedAt := tmuxInfo.Created.Format("2006-01-02T15:04:05Z07:00")
@@ -2237,15 +3843,40 @@ func (s *Server) scanAndRegisterTmuxSessions(connSession *ConnectedSession, host
 		detachedProcesses = append(detachedProcesses, stale)
 	}
 
-	return processInfos, detachedProcesses
+	return processInfos, detachedProcesses, reattach
 }
 
-// restoreClaude restores Claude state for a reattached process using the saved port
-func (s *Server) restoreClaude(connSession *ConnectedSession, proc *process.Process, sshClient *cryptossh.Client, port int) {
+// tmuxSessionOwner returns the host a process's tmux session belongs to, if
+// known: the host it is registered under, or one of duplicateOf (hosts that
+// reach the same machine) that lists it as detached or stored its metadata
+func (s *Server) tmuxSessionOwner(processID string, duplicateOf []string) string {
+	if proc := s.processRegistry.Get(processID); proc != nil {
+		return proc.HostID
+	}
+	if len(duplicateOf) == 0 {
+		return ""
+	}
+	for _, otherID := range duplicateOf {
+		if s.processRegistry.GetStaleProcess(otherID, processID) != nil {
+			return otherID
+		}
+	}
+	if s.storage != nil {
+		if meta, err := s.storage.GetProcessMetadata(processID); err == nil && meta != nil && slices.Contains(duplicateOf, meta.HostID) {
+			return meta.HostID
+		}
+	}
+	return ""
+}
+
+// restoreClaude restores Claude state for a reattached process using the saved
+// port, and the working directory and session ID recorded when Claude was
+// started
+func (s *Server) restoreClaude(connSession *ConnectedSession, proc *process.Process, sshConn *ssh.Connection, port int, claudeCWD, claudeSessionID string) {
 	log.Printf("[DEBUG] [CLAUDE] Restoring Claude state for process %s on port %d", proc.ID, port)
 
 	// Create AgentAPI client to check if the server is still responding
-	agentClient := agentapi.NewClient(sshClient, port)
+	agentClient := agentapi.NewClient(sshConn, port)
 
 	// Check if AgentAPI is responding
 	status, err := agentClient.GetStatus()
@@ -2260,11 +3891,10 @@ func (s *Server) restoreClaude(connSession *ConnectedSession, proc *process.Proc
 	// Update process type and port
 	proc.UpdateType(process.TypeClaude)
 	proc.SetPort(port)
+	proc.MarkTerminalAttaching(time.Now())
 
 	// Create SSE client with event handler
-	sseClient := agentapi.NewSSEClient(sshClient, port, func(event agentapi.SSEEvent) {
-		s.handleAgentAPIEvent(connSession, proc.HostID, proc.ID, event)
-	})
+	sseClient := s.newSSEClient(sshConn, proc, port)
 
 	// Store clients in process
 	proc.SetAgentClients(agentClient, sseClient)
@@ -2275,9 +3905,13 @@ func (s *Server) restoreClaude(connSession *ConnectedSession, proc *process.Proc
 	}
 
 	proc.SetAgentAPIReady(true)
+	proc.SetAgentStatus(status.Status, status.AgentType)
+
+	s.restoreClaudeCWD(proc, claudeCWD)
+	proc.SetClaudeSessionID(claudeSessionID)
 
 	// Detect AgentAPI server PID
-	if agentAPIPID, err := s.detectAgentAPIPID(sshClient, port); err == nil {
+	if agentAPIPID, err := s.detectAgentAPIPID(proc.HostID, sshConn.Client, port); err == nil {
 		proc.SetAgentAPIPID(agentAPIPID)
 		log.Printf("[INFO] [CLAUDE] Detected AgentAPI PID: %d", agentAPIPID)
 	} else {
@@ -2287,26 +3921,41 @@ func (s *Server) restoreClaude(connSession *ConnectedSession, proc *process.Proc
 	log.Printf("[INFO] [CLAUDE] Successfully restored Claude state for process %s", proc.ID)
 }
 
-// detectAgentAPIPID finds the PID of the agentapi server process on the given port
-func (s *Server) detectAgentAPIPID(sshClient *cryptossh.Client, port int) (int, error) {
-	session, err := sshClient.NewSession()
+// snapshotClaudeCWD reads the directory Claude is being started in. Unlike
+// the process CWD it stays put when the shell later cd's elsewhere.
+func snapshotClaudeCWD(proc *process.Process) string {
+	cwd, err := proc.PTY.RefreshCWD()
 	if err != nil {
-		return 0, fmt.Errorf("failed to create SSH session: %w", err)
+		log.Printf("[WARN] [CLAUDE] Could not read CWD for process %s, using last known: %v", proc.ID, err)
+		return proc.GetCWD()
 	}
-	defer session.Close()
+	return cwd
+}
 
-	// Use lsof to find the process listening on the port
-	cmd := fmt.Sprintf("lsof -ti :%d 2>/dev/null | head -1", port)
-	output, err := session.Output(cmd)
-	if err != nil {
-		return 0, fmt.Errorf("lsof command failed: %w", err)
+// restoreClaudeCWD sets the Claude CWD of a reattached process. Claude
+// started before claude_cwd was recorded has none saved; the live CWD (read
+// when the pane was reattached) is the best remaining guess, and is persisted
+// so later reattaches keep it even after the shell moves.
+func (s *Server) restoreClaudeCWD(proc *process.Process, saved string) {
+	if saved == "" && proc.PTY != nil {
+		saved = proc.PTY.GetCWD()
+		if saved != "" && s.storage != nil {
+			log.Printf("[INFO] [CLAUDE] Backfilling Claude CWD for process %s from live CWD %s", proc.ID, saved)
+			if err := s.storage.UpdateProcessClaudeCWD(proc.ID, saved); err != nil {
+				log.Printf("[WARN] [CLAUDE] Failed to persist Claude CWD for %s: %v", proc.ID, err)
+			}
+		}
 	}
+	proc.SetClaudeCWD(saved)
+}
 
-	var pid int
-	if _, err := fmt.Sscanf(string(output), "%d", &pid); err != nil {
-		return 0, fmt.Errorf("failed to parse PID from output %q: %w", string(output), err)
+// detectAgentAPIPID finds the PID of the agentapi server process on the given port
+func (s *Server) detectAgentAPIPID(hostID string, sshClient *cryptossh.Client, port int) (int, error) {
+	pid, method, err := scanner.FindListeningPID(sshClient, port, s.hostPlatform(hostID))
+	if err != nil {
+		return 0, err
 	}
-
+	log.Printf("[DEBUG] [CLAUDE] Found PID %d listening on port %d with %s", pid, port, method)
 	return pid, nil
 }
 
@@ -2326,15 +3975,11 @@ func (s *Server) handleEnvList(connSession *ConnectedSession, msg *protocol.Mess
 	// Get SSH connection
 	sshConn := s.sshManager.GetConnection(payload.HostID)
 	if sshConn == nil {
-		return connSession.SendError("NOT_CONNECTED", "Host is not connected")
+		return connSession.sendHostNotConnected(payload.HostID)
 	}
 
 	// Detect RC file
-	detectedRcFile, err := s.envManager.DetectRcFile(sshConn.Client)
-	if err != nil {
-		log.Printf("[WARN] [ENV] Failed to detect RC file: %v", err)
-		detectedRcFile = "~/.bashrc" // Default fallback
-	}
+	detectedRcFile := s.detectRcFile(payload.HostID, sshConn)
 
 	// Check for override in storage
 	rcFileOverride, err := s.storage.GetHostRcFile(payload.HostID)
@@ -2357,6 +4002,7 @@ func (s *Server) handleEnvList(connSession *ConnectedSession, msg *protocol.Mess
 			CustomVars:     []protocol.EnvVar{},
 			RcFile:         rcFile,
 			DetectedRcFile: detectedRcFile,
+			Code:           protocol.ErrorExecFailed,
 			Error:          &errMsg,
 		})
 		return connSession.Send(response)
@@ -2372,12 +4018,12 @@ func (s *Server) handleEnvList(connSession *ConnectedSession, msg *protocol.Mess
 	// Convert to protocol types
 	sysVars := make([]protocol.EnvVar, len(systemVars))
 	for i, v := range systemVars {
-		sysVars[i] = protocol.EnvVar{Key: v.Key, Value: v.Value}
+		sysVars[i] = s.toProtocolEnvVar(v.Key, v.Value)
 	}
 
 	custVars := make([]protocol.EnvVar, len(customEnvVars))
 	for i, v := range customEnvVars {
-		custVars[i] = protocol.EnvVar{Key: v.Key, Value: v.Value}
+		custVars[i] = s.toProtocolEnvVar(v.Key, v.Value)
 	}
 
 	response, err := protocol.NewMessage(protocol.TypeEnvResult, protocol.EnvResultPayload{
@@ -2395,7 +4041,8 @@ func (s *Server) handleEnvList(connSession *ConnectedSession, msg *protocol.Mess
 	return connSession.Send(response)
 }
 
-// handleEnvUpdate updates custom env vars in the RC file
+// handleEnvUpdate updates custom env vars in the RC file, or with dryRun
+// shows what updating them would change
 func (s *Server) handleEnvUpdate(connSession *ConnectedSession, msg *protocol.Message) error {
 	var payload protocol.EnvUpdatePayload
 	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
@@ -2407,14 +4054,11 @@ func (s *Server) handleEnvUpdate(connSession *ConnectedSession, msg *protocol.Me
 	// Get SSH connection
 	sshConn := s.sshManager.GetConnection(payload.HostID)
 	if sshConn == nil {
-		return connSession.SendError("NOT_CONNECTED", "Host is not connected")
+		return connSession.sendHostNotConnected(payload.HostID)
 	}
 
 	// Get RC file (with override check)
-	detectedRcFile, _ := s.envManager.DetectRcFile(sshConn.Client)
-	if detectedRcFile == "" {
-		detectedRcFile = "~/.bashrc"
-	}
+	detectedRcFile := s.detectRcFile(payload.HostID, sshConn)
 
 	rcFileOverride, _ := s.storage.GetHostRcFile(payload.HostID)
 	rcFile := detectedRcFile
@@ -2422,45 +4066,82 @@ func (s *Server) handleEnvUpdate(connSession *ConnectedSession, msg *protocol.Me
 		rcFile = rcFileOverride
 	}
 
-	// Convert to env types
-	vars := make([]env.EnvVar, len(payload.CustomVars))
-	for i, v := range payload.CustomVars {
-		vars[i] = env.EnvVar{Key: v.Key, Value: v.Value}
+	// The section now holds the values of masked placeholders and the keys
+	// already let through; the system vars are what its exports would shadow
+	managed, err := s.envManager.ReadCustomEnvVars(sshConn.Client, rcFile)
+	var systemVars []env.EnvVar
+	if err == nil {
+		systemVars, err = s.envManager.ReadSystemEnvVars(sshConn.Client)
 	}
 
-	// Write custom env vars
-	if err := s.envManager.WriteCustomEnvVars(sshConn.Client, rcFile, vars); err != nil {
+	// Convert to env types. Masked vars come back as placeholders, so they
+	// keep the value currently in the RC file.
+	var vars []env.EnvVar
+	if err == nil {
+		vars, err = unmaskEnvVars(managed, payload.CustomVars)
+	}
+	var conflicts []env.Conflict
+	if err == nil {
+		conflicts = env.FindConflicts(vars, systemVars, managed, payload.AllowOverride, payload.Force, s.envMasker)
+		if blocked := env.Blocked(conflicts); len(blocked) > 0 && !payload.DryRun {
+			log.Printf("[WARN] [ENV] Refused env update for host %s shadowing system vars %v", payload.HostID, blocked)
+			err = newFailure(protocol.ErrorInvalidArgs, fmt.Sprintf("refusing to shadow system variables %s: list them in allowOverride, and set force for %s",
+				strings.Join(blocked, ", "), strings.Join(env.CriticalKeys, ", ")))
+		}
+	}
+	var preview *env.Preview
+	if err == nil && payload.DryRun {
+		preview, err = s.envManager.PreviewCustomEnvVars(sshConn.Client, rcFile, vars, s.envMasker)
+	} else if err == nil {
+		err = s.envManager.WriteCustomEnvVars(sshConn.Client, rcFile, vars)
+	}
+	if errors.Is(err, env.ErrUnterminatedQuote) {
+		err = newFailure(protocol.ErrorInvalidState, err.Error())
+	}
+	if err != nil {
 		errMsg := err.Error()
 		response, _ := protocol.NewMessage(protocol.TypeEnvResult, protocol.EnvResultPayload{
 			HostID:         payload.HostID,
 			SystemVars:     []protocol.EnvVar{},
-			CustomVars:     payload.CustomVars,
+			CustomVars:     s.maskEnvVars(payload.CustomVars),
 			RcFile:         rcFile,
 			DetectedRcFile: detectedRcFile,
+			DryRun:         payload.DryRun,
+			Conflicts:      toProtocolEnvConflicts(conflicts),
+			Code:           failureCode(err, protocol.ErrorExecFailed),
 			Error:          &errMsg,
 		})
 		return connSession.Send(response)
 	}
 
-	// Re-read system vars and return updated state
-	systemVars, _ := s.envManager.ReadSystemEnvVars(sshConn.Client)
 	sysVars := make([]protocol.EnvVar, len(systemVars))
 	for i, v := range systemVars {
-		sysVars[i] = protocol.EnvVar{Key: v.Key, Value: v.Value}
+		sysVars[i] = s.toProtocolEnvVar(v.Key, v.Value)
 	}
 
-	response, err := protocol.NewMessage(protocol.TypeEnvResult, protocol.EnvResultPayload{
+	result := protocol.EnvResultPayload{
 		HostID:         payload.HostID,
 		SystemVars:     sysVars,
-		CustomVars:     payload.CustomVars,
+		CustomVars:     s.maskEnvVars(payload.CustomVars),
 		RcFile:         rcFile,
 		DetectedRcFile: detectedRcFile,
-	})
+		DryRun:         payload.DryRun,
+		Conflicts:      toProtocolEnvConflicts(conflicts),
+	}
+	if preview != nil {
+		result.Diff = &preview.Diff
+		result.ManagedSection = &preview.Section
+	}
+	response, err := protocol.NewMessage(protocol.TypeEnvResult, result)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("[INFO] [ENV] Updated %d custom env vars for host %s", len(payload.CustomVars), payload.HostID)
+	if payload.DryRun {
+		log.Printf("[DEBUG] [ENV] Previewed %d custom env vars for host %s", len(payload.CustomVars), payload.HostID)
+	} else {
+		log.Printf("[INFO] [ENV] Updated %d custom env vars for host %s", len(payload.CustomVars), payload.HostID)
+	}
 	return connSession.Send(response)
 }
 
@@ -2475,37 +4156,188 @@ func (s *Server) handleEnvSetRcFile(connSession *ConnectedSession, msg *protocol
 
 	// Save to storage
 	if err := s.storage.SetHostRcFile(payload.HostID, payload.RcFile); err != nil {
-		return connSession.SendError("STORAGE_ERROR", err.Error())
+		return connSession.SendErrorDetails(protocol.ErrorStorageError, protocol.ErrorDetails{"reason": err.Error()})
 	}
 
 	// Return updated env list
 	return s.handleEnvList(connSession, msg)
 }
 
+// handleEnvReveal returns the unmasked value of a single host env var, looking
+// in the RC file's managed section first and then the system environment
+func (s *Server) handleEnvReveal(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.EnvRevealPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [ENV] Reveal request for %s on host %s", payload.Key, payload.HostID)
+
+	result := protocol.EnvRevealResultPayload{
+		HostID: payload.HostID,
+		Key:    payload.Key,
+	}
+
+	sshConn := s.sshManager.GetConnection(payload.HostID)
+	if sshConn == nil {
+		result.Code = protocol.ErrorNotConnected
+		result.Error = strPtr("Host is not connected")
+		return s.sendEnvRevealResult(connSession, result)
+	}
+
+	detectedRcFile := s.detectRcFile(payload.HostID, sshConn)
+	rcFile := detectedRcFile
+	if rcFileOverride, _ := s.storage.GetHostRcFile(payload.HostID); rcFileOverride != "" {
+		rcFile = rcFileOverride
+	}
+
+	customVars, err := s.envManager.ReadCustomEnvVars(sshConn.Client, rcFile)
+	if err != nil {
+		log.Printf("[WARN] [ENV] Failed to read custom env vars: %v", err)
+	}
+	value, ok := lookupEnvVar(customVars, payload.Key)
+	if !ok {
+		systemVars, err := s.envManager.ReadSystemEnvVars(sshConn.Client)
+		if err != nil {
+			result.Code = protocol.ErrorExecFailed
+			result.Error = strPtr(err.Error())
+			return s.sendEnvRevealResult(connSession, result)
+		}
+		value, ok = lookupEnvVar(systemVars, payload.Key)
+	}
+	if !ok {
+		result.Code = protocol.ErrorNotFound
+		result.Error = strPtr("Variable not found")
+		return s.sendEnvRevealResult(connSession, result)
+	}
+
+	log.Printf("[INFO] [ENV] Revealed %s on host %s", payload.Key, payload.HostID)
+	result.Success = true
+	result.Value = &value
+	return s.sendEnvRevealResult(connSession, result)
+}
+
+func (s *Server) sendEnvRevealResult(connSession *ConnectedSession, result protocol.EnvRevealResultPayload) error {
+	response, err := protocol.NewMessage(protocol.TypeEnvRevealResult, result)
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
+
+// toProtocolEnvVar converts an env var for sending to clients, masking its
+// value if the key looks like a secret
+func (s *Server) toProtocolEnvVar(key, value string) protocol.EnvVar {
+	if s.envMasker.IsSecret(key) {
+		return protocol.EnvVar{Key: key, Value: env.MaskedValue, IsMasked: true}
+	}
+	return protocol.EnvVar{Key: key, Value: value}
+}
+
+// maskEnvVars masks secret values in env vars echoed back to clients
+func (s *Server) maskEnvVars(vars []protocol.EnvVar) []protocol.EnvVar {
+	masked := make([]protocol.EnvVar, len(vars))
+	for i, v := range vars {
+		if v.IsMasked {
+			masked[i] = v
+			continue
+		}
+		masked[i] = s.toProtocolEnvVar(v.Key, v.Value)
+	}
+	return masked
+}
+
+// unmaskEnvVars converts env vars sent by a client, replacing masked
+// placeholders with their value in current, the RC file's managed section
+func unmaskEnvVars(current []env.EnvVar, vars []protocol.EnvVar) ([]env.EnvVar, error) {
+	out := make([]env.EnvVar, len(vars))
+	for i, v := range vars {
+		out[i] = env.EnvVar{Key: v.Key, Value: v.Value}
+		if !v.IsMasked {
+			continue
+		}
+		value, ok := lookupEnvVar(current, v.Key)
+		if !ok {
+			return nil, newFailure(protocol.ErrorInvalidArgs, fmt.Sprintf("no current value for masked variable %s", v.Key))
+		}
+		out[i].Value = value
+	}
+	return out, nil
+}
+
+// toProtocolEnvConflicts converts env update conflicts to their protocol form
+func toProtocolEnvConflicts(conflicts []env.Conflict) []protocol.EnvConflict {
+	if len(conflicts) == 0 {
+		return nil
+	}
+	out := make([]protocol.EnvConflict, len(conflicts))
+	for i, c := range conflicts {
+		out[i] = protocol.EnvConflict{
+			Key:         c.Key,
+			SystemValue: nilIfEmpty(c.SystemValue),
+			IsMasked:    c.IsMasked,
+			Critical:    c.Critical,
+			Allowed:     c.Allowed,
+		}
+	}
+	return out
+}
+
+// lookupEnvVar returns the value of key in vars
+func lookupEnvVar(vars []env.EnvVar, key string) (string, bool) {
+	for _, v := range vars {
+		if v.Key == key {
+			return v.Value, true
+		}
+	}
+	return "", false
+}
+
 // handleProcessEnvList returns env vars for a specific process
-// These env vars were captured at spawn time and stored in the process
+// By default these are the env vars captured at spawn time and stored in the
+// process; the current and diff modes capture them again, in the background
 func (s *Server) handleProcessEnvList(connSession *ConnectedSession, msg *protocol.Message) error {
 	var payload protocol.ProcessEnvListPayload
 	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
 		return err
 	}
+	mode := protocol.ProcessEnvModeSpawn
+	if payload.Mode != nil {
+		mode = *payload.Mode
+	}
 
-	log.Printf("[DEBUG] [ENV] Process env list for process %s", payload.ProcessID)
+	log.Printf("[DEBUG] [ENV] Process env list for process %s (mode=%s)", payload.ProcessID, mode)
 
 	// Get process
 	proc := s.processRegistry.Get(payload.ProcessID)
 	if proc == nil {
-		return connSession.SendError("PROCESS_NOT_FOUND", "Process not found")
+		return connSession.sendProcessNotFound(payload.ProcessID)
+	}
+
+	if mode != protocol.ProcessEnvModeSpawn {
+		// The capture is typed into the terminal
+		if role, _ := connSession.Role(); !role.Allows(session.RoleOwner) {
+			refuseForbidden(connSession, msg.Type, "capturing the current env needs the owner role")
+			return nil
+		}
+		go func() {
+			if err := s.sendCurrentProcessEnv(connSession, proc, mode); err != nil {
+				log.Printf("[ERROR] [ENV] Failed to send env of process %s: %v", proc.ID, err)
+			}
+		}()
+		return nil
 	}
 
 	// Return the env vars that were captured at spawn time
-	vars := make([]protocol.EnvVar, len(proc.EnvVars))
-	for i, v := range proc.EnvVars {
-		vars[i] = protocol.EnvVar{Key: v.Key, Value: v.Value}
+	spawnVars := proc.GetEnvVars()
+	vars := make([]protocol.EnvVar, len(spawnVars))
+	for i, v := range spawnVars {
+		vars[i] = s.toProtocolEnvVar(v.Key, v.Value)
 	}
 
 	response, err := protocol.NewMessage(protocol.TypeProcessEnvResult, protocol.ProcessEnvResultPayload{
 		ProcessID: payload.ProcessID,
+		Mode:      mode,
 		Vars:      vars,
 	})
 	if err != nil {
@@ -2531,14 +4363,14 @@ func (s *Server) handlePortsScan(connSession *ConnectedSession, msg *protocol.Me
 	// Get SSH connection for the host
 	sshConn := s.sshManager.GetConnection(payload.HostID)
 	if sshConn == nil {
-		return connSession.SendError("NOT_CONNECTED", "Host is not connected")
+		return connSession.sendHostNotConnected(payload.HostID)
 	}
 
 	// Get port scan results from the existing scanner
-	scannedProcesses, staleAgentAPIs := s.portScanner.ScanPorts(sshConn.Client, payload.HostID)
+	scannedProcesses, staleAgentAPIs := s.portScanner.ScanPorts(context.Background(), sshConn.Client, payload.HostID)
 
 	// Get network tool info for process enrichment
-	netInfo := scanner.ScanNetworkPorts(sshConn.Client, process.MinPort, process.MaxPort)
+	netInfo := scanner.ScanNetworkPorts(sshConn.Client, s.config.PortRange, s.hostPlatform(payload.HostID))
 
 	// Get process metadata from DB for mapping ports to known processes
 	var dbMetadata []storage.ProcessMetadata
@@ -2601,6 +4433,7 @@ func (s *Server) handlePortsScan(connSession *ConnectedSession, msg *protocol.Me
 		if netResult := netInfo.GetNetToolResultForPort(port); netResult != nil {
 			if netResult.PID > 0 {
 				info.NetPID = &netResult.PID
+				info.NetPIDMethod = strPtr(netInfo.Tool)
 			}
 			if netResult.Process != "" {
 				info.NetProcess = &netResult.Process
@@ -2609,6 +4442,16 @@ func (s *Server) handlePortsScan(connSession *ConnectedSession, msg *protocol.Me
 				info.NetUser = &netResult.User
 			}
 		}
+		// The tool may be missing, or not show other users' processes
+		// without root; an active server's PID can still be found
+		if info.NetPID == nil && info.Status == "active" {
+			if pid, method, err := scanner.FindListeningPID(sshConn.Client, port, s.hostPlatform(payload.HostID)); err == nil {
+				info.NetPID = &pid
+				info.NetPIDMethod = &method
+			} else {
+				log.Printf("[DEBUG] [PORTS] No PID found for port %d on host %s: %v", port, payload.HostID, err)
+			}
+		}
 	}
 
 	// Convert map to slice
@@ -2647,6 +4490,14 @@ func nilIfEmpty(s string) *string {
 	return &s
 }
 
+// derefString returns the string s points to, or "" if s is nil
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
 // ============================================================================
 // Snippet Handlers
 // ============================================================================
@@ -2658,19 +4509,13 @@ func (s *Server) handleSnippetList(connSession *ConnectedSession, msg *protocol.
 	snippets, err := s.storage.ListSnippets()
 	if err != nil {
 		log.Printf("[ERROR] [SNIPPETS] Failed to list snippets: %v", err)
-		return connSession.SendError("STORAGE_ERROR", err.Error())
+		return connSession.SendErrorDetails(protocol.ErrorStorageError, protocol.ErrorDetails{"reason": err.Error()})
 	}
 
 	// Convert storage snippets to protocol snippets
 	protoSnippets := make([]protocol.Snippet, len(snippets))
 	for i, snippet := range snippets {
-		protoSnippets[i] = protocol.Snippet{
-			ID:        snippet.ID,
-			Name:      snippet.Name,
-			Content:   snippet.Content,
-			CreatedAt: snippet.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-			UpdatedAt: snippet.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
-		}
+		protoSnippets[i] = toProtocolSnippet(snippet)
 	}
 
 	response, err := protocol.NewMessage(protocol.TypeSnippetListResult, protocol.SnippetListResultPayload{
@@ -2684,6 +4529,17 @@ func (s *Server) handleSnippetList(connSession *ConnectedSession, msg *protocol.
 	return connSession.Send(response)
 }
 
+// toProtocolSnippet converts a stored snippet to its protocol form
+func toProtocolSnippet(snippet storage.Snippet) protocol.Snippet {
+	return protocol.Snippet{
+		ID:        snippet.ID,
+		Name:      snippet.Name,
+		Content:   snippet.Content,
+		CreatedAt: snippet.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		UpdatedAt: snippet.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
+	}
+}
+
 // handleSnippetCreate creates a new snippet
 func (s *Server) handleSnippetCreate(connSession *ConnectedSession, msg *protocol.Message) error {
 	var payload protocol.SnippetCreatePayload
@@ -2698,6 +4554,7 @@ func (s *Server) handleSnippetCreate(connSession *ConnectedSession, msg *protoco
 		errMsg := "snippet name is required"
 		response, _ := protocol.NewMessage(protocol.TypeSnippetCreateResult, protocol.SnippetCreateResultPayload{
 			Success: false,
+			Code:    protocol.ErrorInvalidArgs,
 			Error:   &errMsg,
 		})
 		return connSession.Send(response)
@@ -2715,6 +4572,7 @@ func (s *Server) handleSnippetCreate(connSession *ConnectedSession, msg *protoco
 		errMsg := err.Error()
 		response, _ := protocol.NewMessage(protocol.TypeSnippetCreateResult, protocol.SnippetCreateResultPayload{
 			Success: false,
+			Code:    protocol.ErrorStorageError,
 			Error:   &errMsg,
 		})
 		return connSession.Send(response)
@@ -2727,18 +4585,13 @@ func (s *Server) handleSnippetCreate(connSession *ConnectedSession, msg *protoco
 		errMsg := "snippet created but failed to retrieve"
 		response, _ := protocol.NewMessage(protocol.TypeSnippetCreateResult, protocol.SnippetCreateResultPayload{
 			Success: false,
+			Code:    protocol.ErrorStorageError,
 			Error:   &errMsg,
 		})
 		return connSession.Send(response)
 	}
 
-	protoSnippet := protocol.Snippet{
-		ID:        created.ID,
-		Name:      created.Name,
-		Content:   created.Content,
-		CreatedAt: created.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-		UpdatedAt: created.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
-	}
+	protoSnippet := toProtocolSnippet(*created)
 
 	response, err := protocol.NewMessage(protocol.TypeSnippetCreateResult, protocol.SnippetCreateResultPayload{
 		Success: true,
@@ -2768,6 +4621,7 @@ func (s *Server) handleSnippetUpdate(connSession *ConnectedSession, msg *protoco
 		errMsg := err.Error()
 		response, _ := protocol.NewMessage(protocol.TypeSnippetUpdateResult, protocol.SnippetUpdateResultPayload{
 			Success: false,
+			Code:    protocol.ErrorStorageError,
 			Error:   &errMsg,
 		})
 		return connSession.Send(response)
@@ -2776,6 +4630,7 @@ func (s *Server) handleSnippetUpdate(connSession *ConnectedSession, msg *protoco
 		errMsg := "snippet not found"
 		response, _ := protocol.NewMessage(protocol.TypeSnippetUpdateResult, protocol.SnippetUpdateResultPayload{
 			Success: false,
+			Code:    protocol.ErrorNotFound,
 			Error:   &errMsg,
 		})
 		return connSession.Send(response)
@@ -2794,6 +4649,7 @@ func (s *Server) handleSnippetUpdate(connSession *ConnectedSession, msg *protoco
 		errMsg := err.Error()
 		response, _ := protocol.NewMessage(protocol.TypeSnippetUpdateResult, protocol.SnippetUpdateResultPayload{
 			Success: false,
+			Code:    protocol.ErrorStorageError,
 			Error:   &errMsg,
 		})
 		return connSession.Send(response)
@@ -2806,18 +4662,13 @@ func (s *Server) handleSnippetUpdate(connSession *ConnectedSession, msg *protoco
 		errMsg := "snippet updated but failed to retrieve"
 		response, _ := protocol.NewMessage(protocol.TypeSnippetUpdateResult, protocol.SnippetUpdateResultPayload{
 			Success: false,
+			Code:    protocol.ErrorStorageError,
 			Error:   &errMsg,
 		})
 		return connSession.Send(response)
 	}
 
-	protoSnippet := protocol.Snippet{
-		ID:        updated.ID,
-		Name:      updated.Name,
-		Content:   updated.Content,
-		CreatedAt: updated.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-		UpdatedAt: updated.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
-	}
+	protoSnippet := toProtocolSnippet(*updated)
 
 	response, err := protocol.NewMessage(protocol.TypeSnippetUpdateResult, protocol.SnippetUpdateResultPayload{
 		Success: true,
@@ -2847,6 +4698,7 @@ func (s *Server) handleSnippetDelete(connSession *ConnectedSession, msg *protoco
 		errMsg := err.Error()
 		response, _ := protocol.NewMessage(protocol.TypeSnippetDeleteResult, protocol.SnippetDeleteResultPayload{
 			Success: false,
+			Code:    protocol.ErrorStorageError,
 			Error:   &errMsg,
 		})
 		return connSession.Send(response)
@@ -2855,6 +4707,7 @@ func (s *Server) handleSnippetDelete(connSession *ConnectedSession, msg *protoco
 		errMsg := "snippet not found"
 		response, _ := protocol.NewMessage(protocol.TypeSnippetDeleteResult, protocol.SnippetDeleteResultPayload{
 			Success: false,
+			Code:    protocol.ErrorNotFound,
 			Error:   &errMsg,
 		})
 		return connSession.Send(response)
@@ -2865,6 +4718,7 @@ func (s *Server) handleSnippetDelete(connSession *ConnectedSession, msg *protoco
 		errMsg := err.Error()
 		response, _ := protocol.NewMessage(protocol.TypeSnippetDeleteResult, protocol.SnippetDeleteResultPayload{
 			Success: false,
+			Code:    protocol.ErrorStorageError,
 			Error:   &errMsg,
 		})
 		return connSession.Send(response)
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/session"
+)
+
+// protocolVersion is what test clients say they speak at auth
+var protocolVersion = protocol.ProtocolVersion
+
+// newTestServer creates a Server backed by a temporary data directory
+func newTestServer(t *testing.T, config Config) *Server {
+	t.Helper()
+	s, err := New("127.0.0.1:0", t.TempDir(), config)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(s.Stop)
+	return s
+}
+
+// dialAndAuth connects a loopback client and completes the auth handshake
+func dialAndAuth(t *testing.T, s *Server, url string, compression bool) (*websocket.Conn, *ConnectedSession) {
+	t.Helper()
+	dialer := websocket.Dialer{EnableCompression: compression}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	auth, _ := protocol.NewMessage(protocol.TypeAuth, protocol.AuthPayload{ProtocolVersion: &protocolVersion, Compression: compression})
+	if err := conn.WriteJSON(auth); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var result protocol.AuthResultPayload
+	readPayload(t, conn, protocol.TypeAuthResult, &result)
+	if !result.Success || result.SessionID == nil {
+		t.Fatalf("auth failed: %+v", result)
+	}
+
+	sess := s.sessionManager.GetSession(*result.SessionID)
+	if sess == nil {
+		t.Fatalf("session %s not found", *result.SessionID)
+	}
+	return conn, &ConnectedSession{Session: sess, server: s}
+}
+
+// authAgain runs auth on cs as a connection that hasn't authenticated yet,
+// for tests that check what auth sets up on an already connected client
+func authAgain(t *testing.T, s *Server, cs *ConnectedSession, payload protocol.AuthPayload) {
+	t.Helper()
+	cs.SetRole(session.RoleNone, session.Scope{}, "")
+	dispatch(t, s, cs, protocol.TypeAuth, payload)
+}
+
+// connectTestClient starts a loopback WebSocket endpoint for s and returns an
+// authenticated client connection plus the matching server-side session
+func connectTestClient(t *testing.T, s *Server) (*websocket.Conn, *ConnectedSession) {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	t.Cleanup(ts.Close)
+	return dialAndAuth(t, s, "ws"+strings.TrimPrefix(ts.URL, "http"), false)
+}
+
+// dispatch runs the registered handler for msgType with payload on cs
+func dispatch(t *testing.T, s *Server, cs *ConnectedSession, msgType string, payload interface{}) {
+	t.Helper()
+	msg, err := protocol.NewMessage(msgType, payload)
+	if err != nil {
+		t.Fatalf("NewMessage: %v", err)
+	}
+	if err := s.handlers[msgType](cs, msg); err != nil {
+		t.Fatalf("handler %s: %v", msgType, err)
+	}
+}
+
+// readPayload reads the next message, asserts its type, and decodes the payload
+func readPayload(t *testing.T, conn *websocket.Conn, msgType string, out interface{}) {
+	t.Helper()
+	var msg protocol.Message
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("ReadJSON: %v", err)
+	}
+	if msg.Type != msgType {
+		t.Fatalf("expected %s, got %s: %s", msgType, msg.Type, string(msg.Payload))
+	}
+	if out != nil {
+		if err := json.Unmarshal(msg.Payload, out); err != nil {
+			t.Fatalf("Unmarshal %s: %v", msgType, err)
+		}
+	}
+}
+
+func TestAuthRejectsInvalidToken(t *testing.T) {
+	config := DefaultConfig()
+	config.AuthToken = "secret"
+	s := newTestServer(t, config)
+
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer ts.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	for _, tc := range []struct {
+		token string
+		want  bool
+	}{{"wrong", false}, {"secret", true}} {
+		token := tc.token
+		auth, _ := protocol.NewMessage(protocol.TypeAuth, protocol.AuthPayload{ProtocolVersion: &protocolVersion, Token: &token})
+		conn.WriteJSON(auth)
+		var result protocol.AuthResultPayload
+		readPayload(t, conn, protocol.TypeAuthResult, &result)
+		if result.Success != tc.want {
+			t.Errorf("token %q: success=%v, want %v", tc.token, result.Success, tc.want)
+		}
+	}
+
+	// A connection authenticates once; resuming another session takes a
+	// new one
+	token := "secret"
+	auth, _ := protocol.NewMessage(protocol.TypeAuth, protocol.AuthPayload{ProtocolVersion: &protocolVersion, Token: &token})
+	conn.WriteJSON(auth)
+	var again protocol.AuthResultPayload
+	readPayload(t, conn, protocol.TypeAuthResult, &again)
+	if again.Success || again.Code != protocol.ErrorInvalidState || again.SessionID != nil {
+		t.Errorf("second auth on a connection: %+v, want refused with INVALID_STATE", again)
+	}
+}
+
+func TestAuthRejectsOlderProtocol(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer ts.Close()
+
+	older, newer := protocol.ProtocolVersion-1, protocol.ProtocolVersion+1
+	for _, tc := range []struct {
+		name    string
+		version *int
+		want    bool
+	}{{"unstated", nil, false}, {"older", &older, false}, {"newer", &newer, true}, {"current", &protocolVersion, true}} {
+		// Each on a connection of its own, since one that authenticates
+		// can't again
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer conn.Close()
+		auth, _ := protocol.NewMessage(protocol.TypeAuth, protocol.AuthPayload{ProtocolVersion: tc.version})
+		conn.WriteJSON(auth)
+		var result protocol.AuthResultPayload
+		readPayload(t, conn, protocol.TypeAuthResult, &result)
+		if result.Success != tc.want {
+			t.Errorf("%s protocol: success=%v, want %v", tc.name, result.Success, tc.want)
+		}
+		if result.ProtocolVersion != protocol.ProtocolVersion {
+			t.Errorf("%s protocol: bridge says it speaks %d, want %d", tc.name, result.ProtocolVersion, protocol.ProtocolVersion)
+		}
+		if !tc.want && (result.Error == nil || !strings.Contains(*result.Error, "protocol version")) {
+			t.Errorf("%s protocol: error %v doesn't name the protocol version", tc.name, result.Error)
+		}
+		if !tc.want && result.Code != protocol.ErrorProtocolMismatch {
+			t.Errorf("%s protocol: code %q, want %q", tc.name, result.Code, protocol.ErrorProtocolMismatch)
+		}
+	}
+}
+
+func TestAuthMustComeFirst(t *testing.T) {
+	config := DefaultConfig()
+	config.AuthToken = "secret"
+	s := newTestServer(t, config)
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	t.Cleanup(ts.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	// Requests, even unknown ones, are refused before auth, and a failed
+	// auth changes nothing
+	for _, msgType := range []string{protocol.TypeHostConnect, "no_such_type", protocol.TypeAuth, protocol.TypePtyInput} {
+		token := "wrong"
+		msg, _ := protocol.NewMessage(msgType, protocol.AuthPayload{Token: &token})
+		conn.WriteJSON(msg)
+		if msgType == protocol.TypeAuth {
+			readPayload(t, conn, protocol.TypeAuthResult, nil)
+			continue
+		}
+		var errPayload struct {
+			Code    protocol.ErrorCode `json:"code"`
+			Details struct {
+				Type string `json:"type"`
+			} `json:"details"`
+		}
+		readPayload(t, conn, protocol.TypeError, &errPayload)
+		if errPayload.Code != protocol.ErrorNotAuthenticated || errPayload.Details.Type != msgType {
+			t.Errorf("%s before auth = %+v, want NOT_AUTHENTICATED", msgType, errPayload)
+		}
+	}
+	// The provisional session is not listed and has no reconnect token
+	if n := s.sessionManager.GetSessionCount(); n != 0 {
+		t.Errorf("%d sessions registered before auth", n)
+	}
+
+	token := "secret"
+	auth, _ := protocol.NewMessage(protocol.TypeAuth, protocol.AuthPayload{ProtocolVersion: &protocolVersion, Token: &token})
+	conn.WriteJSON(auth)
+	var result protocol.AuthResultPayload
+	readPayload(t, conn, protocol.TypeAuthResult, &result)
+	if !result.Success || result.ReconnectToken == nil || *result.ReconnectToken == "" {
+		t.Fatalf("auth = %+v", result)
+	}
+	if sess := s.sessionManager.GetSession(*result.SessionID); sess == nil || sess.ReconnectToken != *result.ReconnectToken {
+		t.Errorf("session %s not registered with its token", *result.SessionID)
+	}
+	snippets, _ := protocol.NewMessage(protocol.TypeSnippetList, nil)
+	conn.WriteJSON(snippets)
+	readPayload(t, conn, protocol.TypeSnippetListResult, nil)
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.HandshakeTimeout = 100 * time.Millisecond
+	s := newTestServer(t, config)
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	t.Cleanup(ts.Close)
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	// A connection that never authenticates is closed
+	idle, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer idle.Close()
+	idle.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = idle.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("idle connection: %v, want closed for policy violation", err)
+	}
+
+	// One that did stays open past the timeout
+	conn, _ := dialAndAuth(t, s, url, false)
+	time.Sleep(3 * config.HandshakeTimeout)
+	snippets, _ := protocol.NewMessage(protocol.TypeSnippetList, nil)
+	conn.WriteJSON(snippets)
+	readPayload(t, conn, protocol.TypeSnippetListResult, nil)
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/session"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// ============================================================================
+// Session Invites
+// ============================================================================
+//
+// An invite lets another client authenticate without the bridge's auth
+// token, with the role (observer by default) and scope the invite grants.
+// The token is only sent back to the owner who created it; the bridge keeps
+// a hash. Revoking an invite disconnects the sessions using it.
+
+// newInviteToken returns a random invite token
+func newInviteToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashInviteToken returns the form an invite token is stored in
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// lookupInvite returns the invite a token belongs to, or nil if there is
+// none or it has expired
+func (s *Server) lookupInvite(token string) (*storage.SessionInvite, error) {
+	if s.storage == nil || token == "" {
+		return nil, nil
+	}
+	return s.storage.GetSessionInvite(hashInviteToken(token))
+}
+
+// toProtocolInvite converts a stored invite for the client
+func toProtocolInvite(invite storage.SessionInvite) protocol.SessionInvite {
+	result := protocol.SessionInvite{
+		ID:        invite.ID,
+		Role:      invite.Role,
+		HostID:    nullableString(invite.HostID),
+		ProcessID: nullableString(invite.ProcessID),
+		Label:     nullableString(invite.Label),
+		CreatedAt: invite.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	if invite.ExpiresAt != nil {
+		expiresAt := invite.ExpiresAt.UTC().Format(time.RFC3339)
+		result.ExpiresAt = &expiresAt
+	}
+	return result
+}
+
+// nullableString returns nil for "" and a pointer to s otherwise
+func nullableString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func (s *Server) handleSessionInviteCreate(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.SessionInviteCreatePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	sendResult := func(result protocol.SessionInviteCreateResultPayload) error {
+		response, err := protocol.NewMessage(protocol.TypeSessionInviteCreateResult, result)
+		if err != nil {
+			return err
+		}
+		return connSession.Send(response)
+	}
+	if s.storage == nil {
+		return sendResult(protocol.SessionInviteCreateResultPayload{Code: protocol.ErrorStorageError, Error: strPtr("invites are not stored")})
+	}
+
+	invite := storage.SessionInvite{
+		ID:        uuid.New().String(),
+		Role:      string(session.RoleObserver),
+		CreatedAt: time.Now(),
+	}
+	if payload.Role != nil {
+		invite.Role = *payload.Role
+	}
+	if payload.HostID != nil {
+		invite.HostID = *payload.HostID
+	}
+	if payload.ProcessID != nil {
+		invite.ProcessID = *payload.ProcessID
+		// A process scope implies its host, so host-level requests about
+		// other hosts stay out of scope; a process whose host isn't known
+		// would leave the invite scoped to no host at all
+		hostID := s.processHost(invite.ProcessID)
+		switch {
+		case hostID == "" && invite.HostID == "":
+			return sendResult(protocol.SessionInviteCreateResultPayload{Code: protocol.ErrorNotFound, Error: strPtr("process not found; give its host")})
+		case hostID != "" && invite.HostID != "" && invite.HostID != hostID:
+			return sendResult(protocol.SessionInviteCreateResultPayload{Code: protocol.ErrorInvalidArgs, Error: strPtr("process is not on the given host")})
+		case hostID != "":
+			invite.HostID = hostID
+		}
+	}
+	if payload.Label != nil {
+		invite.Label = *payload.Label
+	}
+	if payload.ExpiresInSeconds != nil {
+		expiresAt := invite.CreatedAt.Add(time.Duration(*payload.ExpiresInSeconds) * time.Second)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	token, err := newInviteToken()
+	if err != nil {
+		return err
+	}
+	invite.TokenHash = hashInviteToken(token)
+	if err := s.storage.SaveSessionInvite(invite); err != nil {
+		log.Printf("[ERROR] [AUTH] Failed to save session invite: %v", err)
+		return sendResult(protocol.SessionInviteCreateResultPayload{Code: protocol.ErrorStorageError, Error: strPtr(err.Error())})
+	}
+
+	if s.config.AuthToken == "" {
+		log.Printf("[WARN] [AUTH] Created %s invite %s, but without an auth token any client is the owner anyway", invite.Role, invite.ID)
+	} else {
+		log.Printf("[INFO] [AUTH] Session %s created %s invite %s", connSession.ID, invite.Role, invite.ID)
+	}
+
+	protoInvite := toProtocolInvite(invite)
+	return sendResult(protocol.SessionInviteCreateResultPayload{
+		Success: true,
+		Invite:  &protoInvite,
+		Token:   &token,
+	})
+}
+
+func (s *Server) handleSessionInviteRevoke(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.SessionInviteRevokePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	result := protocol.SessionInviteRevokeResultPayload{ID: payload.ID}
+	var existed bool
+	var err error
+	if s.storage != nil {
+		existed, err = s.storage.DeleteSessionInvite(payload.ID)
+	}
+	switch {
+	case err != nil:
+		log.Printf("[ERROR] [AUTH] Failed to revoke session invite %s: %v", payload.ID, err)
+		result.Code = protocol.ErrorStorageError
+		result.Error = strPtr(err.Error())
+	case !existed:
+		result.Code = protocol.ErrorNotFound
+		result.Error = strPtr("invite not found")
+	default:
+		result.Success = true
+		result.Disconnected = s.disconnectInvitedSessions(payload.ID)
+		log.Printf("[INFO] [AUTH] Revoked session invite %s, disconnecting %d sessions", payload.ID, result.Disconnected)
+	}
+
+	response, err := protocol.NewMessage(protocol.TypeSessionInviteRevokeResult, result)
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
+
+// disconnectInvitedSessions closes the connections of the sessions that
+// authenticated with an invite, and returns how many there were. Their role
+// is withdrawn first, so requests already read are refused.
+func (s *Server) disconnectInvitedSessions(inviteID string) int {
+	count := 0
+	for _, sess := range s.sessionManager.GetConnectedSessions() {
+		if sess.InviteID() != inviteID {
+			continue
+		}
+		sess.SetRole(session.RoleNone, session.Scope{}, "")
+		sess.Lock()
+		conn := sess.Conn
+		sess.Unlock()
+		if conn != nil {
+			conn.Close()
+		}
+		count++
+	}
+	return count
+}
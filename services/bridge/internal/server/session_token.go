@@ -0,0 +1,43 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// handleSessionRefreshToken rotates the session's reconnect token, so a
+// client that stays connected for days doesn't hold one that has stopped
+// surviving bridge restarts
+func (s *Server) handleSessionRefreshToken(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.SessionRefreshTokenPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	var result protocol.SessionRefreshTokenResultPayload
+	if payload.ReconnectToken != "" && s.sessionManager.RefreshToken(connSession.Session, payload.ReconnectToken) {
+		connSession.Lock()
+		token := connSession.ReconnectToken
+		expiresAt := connSession.TokenExpiresAt.UTC().Format(time.RFC3339)
+		connSession.Unlock()
+
+		result = protocol.SessionRefreshTokenResultPayload{
+			Success:        true,
+			ReconnectToken: &token,
+			TokenExpiresAt: &expiresAt,
+		}
+	} else {
+		log.Printf("[WARN] [AUTH] Session %s tried to refresh a reconnect token that is not current", connSession.ID)
+		result.Code = protocol.ErrorUnauthorized
+		result.Error = strPtr("Reconnect token is not current")
+	}
+
+	response, err := protocol.NewMessage(protocol.TypeSessionRefreshTokenResult, result)
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
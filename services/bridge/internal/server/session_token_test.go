@@ -0,0 +1,35 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+func TestSessionRefreshToken(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	token := cs.ReconnectToken
+
+	var result protocol.SessionRefreshTokenResultPayload
+	dispatch(t, s, cs, protocol.TypeSessionRefreshToken, protocol.SessionRefreshTokenPayload{ReconnectToken: token})
+	readPayload(t, conn, protocol.TypeSessionRefreshTokenResult, &result)
+	if !result.Success || result.ReconnectToken == nil || *result.ReconnectToken == token || result.TokenExpiresAt == nil {
+		t.Fatalf("refresh: %+v", result)
+	}
+	if expiresAt, err := time.Parse(time.RFC3339, *result.TokenExpiresAt); err != nil || !expiresAt.After(time.Now()) {
+		t.Errorf("tokenExpiresAt = %s, %v", *result.TokenExpiresAt, err)
+	}
+
+	// The old token is spent
+	result = protocol.SessionRefreshTokenResultPayload{}
+	dispatch(t, s, cs, protocol.TypeSessionRefreshToken, protocol.SessionRefreshTokenPayload{ReconnectToken: token})
+	readPayload(t, conn, protocol.TypeSessionRefreshTokenResult, &result)
+	if result.Success || result.Error == nil || result.ReconnectToken != nil {
+		t.Errorf("stale refresh: %+v", result)
+	}
+	if s.sessionManager.Reconnect(token, nil) != nil {
+		t.Error("old token still reconnects")
+	}
+}
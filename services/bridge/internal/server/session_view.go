@@ -0,0 +1,127 @@
+package server
+
+import (
+	"log"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/session"
+)
+
+// restoreSessionView puts a reconnected client's view back, so it can paint
+// without asking: each chat subscription is confirmed with a
+// chat_subscribe_result, followed by the chat messages cached since the last
+// one the client was sent, and each selected process gets a pty_snapshot at
+// the terminal size the client last declared for it, or else its default
+// size, since another device may have resized it meanwhile. Processes that
+// went away while the client was gone are left out.
+func (s *Server) restoreSessionView(connSession *ConnectedSession) {
+	for hostID, processIDs := range connSession.ChatSubscriptions() {
+		for _, processID := range processIDs {
+			if processID == session.AllProcesses {
+				s.sendChatSubscribeResult(connSession, protocol.ChatSubscribeResultPayload{
+					HostID:    hostID,
+					ProcessID: processID,
+					Success:   true,
+				})
+				continue
+			}
+			proc := s.processRegistry.Get(processID)
+			if proc == nil {
+				continue
+			}
+			if err := s.sendChatSubscribeResult(connSession, s.chatSubscription(hostID, proc)); err != nil {
+				log.Printf("[WARN] [SESSION] Failed to restore chat subscription to %s for session %s: %v", processID, connSession.ID, err)
+				continue
+			}
+			s.replayMissedChat(connSession, hostID, processID)
+		}
+	}
+
+	for _, processID := range connSession.SelectedProcesses() {
+		proc := s.processRegistry.Get(processID)
+		if proc == nil {
+			connSession.ForgetProcess(processID)
+			continue
+		}
+		if proc.PTY != nil {
+			cols, rows := proc.PTY.GetDimensions()
+			size, ok := connSession.TermSize(proc.ID)
+			if !ok {
+				size.Cols, size.Rows = connSession.ptySize(nil, nil, cols, rows)
+			}
+			if cols != size.Cols || rows != size.Rows {
+				if err := proc.PTY.Resize(size.Cols, size.Rows); err != nil {
+					log.Printf("[WARN] [SESSION] Failed to restore %dx%d for process %s: %v", size.Cols, size.Rows, proc.ID, err)
+				}
+			}
+		}
+		if err := s.sendPtySnapshot(connSession, proc); err != nil {
+			log.Printf("[WARN] [SESSION] Failed to restore the screen of process %s for session %s: %v", proc.ID, connSession.ID, err)
+		}
+	}
+	log.Printf("[INFO] [SESSION] Restored the view of session %s", connSession.ID)
+}
+
+// ptySize returns the terminal size for a process a request creates or
+// reattaches: cols and rows where the request gave them, else the client's
+// default from auth, else defaultCols and defaultRows
+func (cs *ConnectedSession) ptySize(cols, rows *int, defaultCols, defaultRows int) (int, int) {
+	declared := cs.DefaultTermSize()
+	if declared.Cols > 0 {
+		defaultCols = declared.Cols
+	}
+	if declared.Rows > 0 {
+		defaultRows = declared.Rows
+	}
+	if cols != nil {
+		defaultCols = *cols
+	}
+	if rows != nil {
+		defaultRows = *rows
+	}
+	return defaultCols, defaultRows
+}
+
+// replayMissedChat sends the cached chat messages of a process from the last
+// one the client was sent, which may have changed since, onwards. Nothing is
+// sent when the client was never sent one, or nothing newer was cached.
+func (s *Server) replayMissedChat(connSession *ConnectedSession, hostID, processID string) {
+	delivered, ok := connSession.ChatDelivered(processID)
+	if !ok || s.storage == nil {
+		return
+	}
+	stored, err := s.storage.GetChatHistory(processID)
+	if err != nil {
+		log.Printf("[WARN] [SESSION] Failed to read cached chat of process %s: %v", processID, err)
+		return
+	}
+
+	var missed []protocol.ChatMessage
+	newer := false
+	for _, m := range stored {
+		if m.MessageID < delivered {
+			continue
+		}
+		newer = newer || m.MessageID > delivered
+		missed = append(missed, toProtocolChatMessage(m))
+	}
+	if !newer {
+		return
+	}
+
+	response, err := protocol.NewMessage(protocol.TypeChatMessages, protocol.ChatMessagesPayload{
+		HostID:    hostID,
+		ProcessID: processID,
+		Messages:  missed,
+	})
+	if err != nil {
+		log.Printf("[ERROR] [SESSION] Failed to create chat replay message: %v", err)
+		return
+	}
+	if err := connSession.Send(response); err != nil {
+		log.Printf("[WARN] [SESSION] Failed to replay chat of process %s: %v", processID, err)
+		return
+	}
+	connSession.NoteChatDelivered(processID, missed[len(missed)-1].ID)
+	log.Printf("[INFO] [SESSION] Replayed %d chat messages of process %s to session %s", len(missed), processID, connSession.ID)
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/session"
+)
+
+func TestReconnectRestoresView(t *testing.T) {
+	s := newQuietServer(t)
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	t.Cleanup(ts.Close)
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+	conn, cs := dialAndAuth(t, s, url, false)
+	stallRequirements(t, s)
+
+	srv := startTestSSHServer(t)
+	srv.HandleExec(func(cmd string) string {
+		if strings.Contains(cmd, "tmux capture-pane -e") {
+			return screenFixture
+		}
+		return ""
+	})
+	shell := registerScreenShell(t, s, srv)
+	registerTestClaude(t, s, "proc-2")
+
+	// The client subscribes to a chat, is sent a message, selects the shell
+	// and sizes its terminal
+	dispatch(t, s, cs, protocol.TypeChatSubscribe, protocol.ChatSubscribePayload{HostID: "host-1", ProcessID: "proc-2"})
+	readPayload(t, conn, protocol.TypeChatSubscribeResult, nil)
+	s.handleAgentAPIEvent("host-1", "proc-2", messageUpdate(t, 1, "first"))
+	readPayload(t, conn, protocol.TypeChatEvent, nil)
+	dispatch(t, s, cs, protocol.TypeProcessSelect, protocol.ProcessSelectPayload{ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypePtySnapshot, nil)
+	dispatch(t, s, cs, protocol.TypePtyResize, protocol.PtyResizePayload{ProcessID: "proc-1", Cols: 100, Rows: 40})
+
+	// The network drops; meanwhile Claude answers and another client
+	// resizes the shell
+	conn.Close()
+	waitDisconnected(t, cs)
+	s.handleAgentAPIEvent("host-1", "proc-2", messageUpdate(t, 1, "first, finished"))
+	s.handleAgentAPIEvent("host-1", "proc-2", messageUpdate(t, 2, "missed"))
+	shell.PTY.Resize(80, 24)
+
+	// Reconnecting brings everything back without a request
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	token := cs.ReconnectToken
+	auth, _ := protocol.NewMessage(protocol.TypeAuth, protocol.AuthPayload{ProtocolVersion: &protocolVersion, ReconnectToken: &token})
+	conn.WriteJSON(auth)
+
+	var result protocol.AuthResultPayload
+	readPayload(t, conn, protocol.TypeAuthResult, &result)
+	if !result.Reconnected {
+		t.Fatalf("auth = %+v, want a reconnection", result)
+	}
+	readPayload(t, conn, protocol.TypeHostStatus, nil)
+
+	var subscribed protocol.ChatSubscribeResultPayload
+	readPayload(t, conn, protocol.TypeChatSubscribeResult, &subscribed)
+	if !subscribed.Success || subscribed.ProcessID != "proc-2" || subscribed.LatestMessageID == nil || *subscribed.LatestMessageID != 2 {
+		t.Errorf("chat subscription = %+v", subscribed)
+	}
+	var missed protocol.ChatMessagesPayload
+	readPayload(t, conn, protocol.TypeChatMessages, &missed)
+	if len(missed.Messages) != 2 || missed.Messages[0].Message != "first, finished" || missed.Messages[1].Message != "missed" {
+		t.Errorf("replayed messages = %+v", missed.Messages)
+	}
+
+	var snapshot protocol.PtySnapshotPayload
+	readPayload(t, conn, protocol.TypePtySnapshot, &snapshot)
+	if snapshot.ProcessID != "proc-1" || snapshot.Cols != 100 || snapshot.Rows != 40 || snapshot.Data == "" {
+		t.Errorf("snapshot = %+v, want proc-1 at the declared 100x40", snapshot)
+	}
+	expectNothingQueued(t, conn, cs)
+}
+
+// waitDisconnected waits for the bridge to notice cs's connection closed
+func waitDisconnected(t *testing.T, cs *ConnectedSession) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cs.Lock()
+		state := cs.State
+		cs.Unlock()
+		if state == session.StateDisconnected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session not marked disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReconnectRestoresDefaultSize(t *testing.T) {
+	s := newQuietServer(t)
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	t.Cleanup(ts.Close)
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+	conn, cs := dialAndAuth(t, s, url, false)
+	stallRequirements(t, s)
+
+	srv := startTestSSHServer(t)
+	srv.HandleExec(func(cmd string) string {
+		if strings.Contains(cmd, "tmux capture-pane -e") {
+			return screenFixture
+		}
+		return ""
+	})
+	shell := registerScreenShell(t, s, srv)
+
+	// The phone selects the shell without ever sizing it, and while it is
+	// away a tablet makes the shell wide
+	dispatch(t, s, cs, protocol.TypeProcessSelect, protocol.ProcessSelectPayload{ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypePtySnapshot, nil)
+	conn.Close()
+	waitDisconnected(t, cs)
+	shell.PTY.Resize(280, 60)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	token := cs.ReconnectToken
+	auth, _ := protocol.NewMessage(protocol.TypeAuth, protocol.AuthPayload{
+		ProtocolVersion: &protocolVersion,
+		ReconnectToken:  &token, DefaultCols: intPtr(60), DefaultRows: intPtr(40), DeviceLabel: strPtr("phone"),
+	})
+	conn.WriteJSON(auth)
+	readPayload(t, conn, protocol.TypeAuthResult, nil)
+	readPayload(t, conn, protocol.TypeHostStatus, nil)
+
+	// The shell is put back at the phone's size
+	var snapshot protocol.PtySnapshotPayload
+	readPayload(t, conn, protocol.TypePtySnapshot, &snapshot)
+	if snapshot.Cols != 60 || snapshot.Rows != 40 {
+		t.Errorf("snapshot = %dx%d after reconnect, want the default 60x40", snapshot.Cols, snapshot.Rows)
+	}
+	cs.Lock()
+	label := cs.DeviceLabel
+	cs.Unlock()
+	if label != "phone" {
+		t.Errorf("device label = %q", label)
+	}
+}
+
+func TestProcessCreateSizePrecedence(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	stallRequirements(t, s)
+
+	tests := []struct {
+		name                     string
+		defaultCols, defaultRows *int
+		cols, rows               *int
+		wantCols, wantRows       int
+	}{
+		{name: "hard-coded default", wantCols: 80, wantRows: 24},
+		{name: "device default", defaultCols: intPtr(60), defaultRows: intPtr(40), wantCols: 60, wantRows: 40},
+		{name: "partial device default", defaultRows: intPtr(40), wantCols: 80, wantRows: 40},
+		{name: "request over device default", defaultCols: intPtr(60), defaultRows: intPtr(40), cols: intPtr(100), wantCols: 100, wantRows: 40},
+	}
+	for _, tt := range tests {
+		// Each auth declares the device afresh
+		authAgain(t, s, cs, protocol.AuthPayload{ProtocolVersion: &protocolVersion, DefaultCols: tt.defaultCols, DefaultRows: tt.defaultRows})
+		readPayload(t, conn, protocol.TypeAuthResult, nil)
+		readPayload(t, conn, protocol.TypeHostStatus, nil)
+
+		dispatch(t, s, cs, protocol.TypeProcessCreate, protocol.ProcessCreatePayload{HostID: "host-1", Cols: tt.cols, Rows: tt.rows})
+		var created protocol.ProcessCreatedPayload
+		readPayload(t, conn, protocol.TypeProcessCreated, &created)
+		if created.Cols != tt.wantCols || created.Rows != tt.wantRows {
+			t.Errorf("%s: created %dx%d, want %dx%d", tt.name, created.Cols, created.Rows, tt.wantCols, tt.wantRows)
+		}
+	}
+}
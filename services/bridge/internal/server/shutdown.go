@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+)
+
+// shutdownStage orders the hooks Stop runs; hooks of one stage run in the
+// order they were added
+type shutdownStage int
+
+const (
+	shutdownAccepting  shutdownStage = iota // Stop taking connections and requests
+	shutdownSessions                        // Close client connections, waiting for their handlers
+	shutdownProcesses                       // Detach processes, writing their final metadata
+	shutdownBackground                      // Stop background loops
+	shutdownStorage                         // Persist and close storage
+)
+
+// Stage timeouts: a hook still running after its timeout is left to finish
+// on its own while Stop moves on
+var (
+	shutdownAcceptingTimeout  = 5 * time.Second
+	shutdownSessionsTimeout   = 5 * time.Second
+	shutdownProcessesTimeout  = 10 * time.Second
+	shutdownBackgroundTimeout = 5 * time.Second
+	shutdownStorageTimeout    = 30 * time.Second
+)
+
+// shutdownHook is a named step of Stop
+type shutdownHook struct {
+	stage   shutdownStage
+	name    string
+	timeout time.Duration
+	run     func()
+}
+
+// shutdownHooks are the steps Stop runs, added by each subsystem as it is
+// set up
+type shutdownHooks struct {
+	mu    sync.Mutex
+	hooks []shutdownHook
+}
+
+// onShutdown adds a hook for Stop to run in stage, given at most timeout
+func (s *Server) onShutdown(stage shutdownStage, name string, timeout time.Duration, run func()) {
+	s.shutdown.mu.Lock()
+	defer s.shutdown.mu.Unlock()
+	s.shutdown.hooks = append(s.shutdown.hooks, shutdownHook{stage: stage, name: name, timeout: timeout, run: run})
+}
+
+// runShutdown runs the shutdown hooks stage by stage, logging how long each
+// took. A hook that outlives its timeout doesn't hold up the ones after it.
+func (s *Server) runShutdown() {
+	s.shutdown.mu.Lock()
+	hooks := slices.Clone(s.shutdown.hooks)
+	s.shutdown.mu.Unlock()
+	slices.SortStableFunc(hooks, func(a, b shutdownHook) int { return int(a.stage) - int(b.stage) })
+
+	for _, hook := range hooks {
+		start := time.Now()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			hook.run()
+		}()
+		select {
+		case <-done:
+			log.Printf("[INFO] [SERVER] Shutdown: %s took %v", hook.name, time.Since(start).Round(time.Millisecond))
+		case <-time.After(hook.timeout):
+			log.Printf("[WARN] [SERVER] Shutdown: %s still running after %v, moving on", hook.name, hook.timeout)
+		}
+	}
+}
+
+// registerShutdownHooks adds the shutdown steps of the server's own
+// subsystems; the admin socket adds its own when started
+func (s *Server) registerShutdownHooks() {
+	s.onShutdown(shutdownAccepting, "http listener", shutdownAcceptingTimeout, s.stopHTTP)
+	s.onShutdown(shutdownSessions, "client connections", shutdownSessionsTimeout, s.liveConns.closeAll)
+	s.onShutdown(shutdownProcesses, "process detach", shutdownProcessesTimeout, s.detachForShutdown)
+	s.onShutdown(shutdownBackground, "background loops", shutdownBackgroundTimeout, func() {
+		close(s.done)
+		// Stops session cleanup, saving sessions while storage is still open
+		s.sessionManager.Stop()
+	})
+	s.onShutdown(shutdownStorage, "storage", shutdownStorageTimeout, func() {
+		if s.storage != nil {
+			if err := s.storage.Close(); err != nil {
+				log.Printf("[WARN] [SERVER] Error closing storage: %v", err)
+			}
+		}
+		s.instanceLock.Release()
+	})
+}
+
+// stopHTTP closes the listener Start serves on, if it was started; hijacked
+// WebSocket connections are left to the sessions stage
+func (s *Server) stopHTTP() {
+	httpServer := s.httpServer.Load()
+	if httpServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownAcceptingTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil && err != http.ErrServerClosed {
+		log.Printf("[WARN] [SERVER] Error stopping HTTP server: %v", err)
+	}
+}
+
+// detachForShutdown detaches every process, without killing it, and records
+// its working directory and when it was last seen
+func (s *Server) detachForShutdown() {
+	// We intentionally do NOT close SSH connections here - just detach from
+	// tmux, so tmux sessions keep running on remote hosts. The OS cleans up
+	// connections when the process exits.
+	s.processRegistry.DetachAll(func(proc *process.Process) {
+		if s.storage == nil {
+			return
+		}
+		if err := s.storage.UpdateProcessCWD(proc.ID, proc.GetCWD()); err != nil {
+			log.Printf("[WARN] [SERVER] Failed to save final state of process %s: %v", proc.ID, err)
+		}
+	})
+}
+
+// liveConnections tracks the WebSocket connections being served, so
+// shutdown can close them and wait for their handlers to finish
+type liveConnections struct {
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	closed bool
+	conns  map[*websocket.Conn]bool
+}
+
+// add tracks a connection until done; it returns false once shutdown has
+// closed the connections, and the caller should turn it away
+func (c *liveConnections) add(conn *websocket.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	if c.conns == nil {
+		c.conns = make(map[*websocket.Conn]bool)
+	}
+	c.conns[conn] = true
+	c.wg.Add(1)
+	return true
+}
+
+// done stops tracking a connection whose handler has finished
+func (c *liveConnections) done(conn *websocket.Conn) {
+	c.mu.Lock()
+	delete(c.conns, conn)
+	c.mu.Unlock()
+	c.wg.Done()
+}
+
+// closeAll tells every client the bridge is going away, closes their
+// connections and waits for their handlers, which detach the session's
+// processes and mark it disconnected
+func (c *liveConnections) closeAll() {
+	c.mu.Lock()
+	c.closed = true
+	for conn := range c.conns {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "bridge shutting down"), time.Now().Add(time.Second))
+		conn.Close()
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+}
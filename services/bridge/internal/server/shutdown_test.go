@@ -0,0 +1,110 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+func TestShutdownHooksRunInOrder(t *testing.T) {
+	s, err := New("127.0.0.1:0", t.TempDir(), DefaultConfig())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	var mu sync.Mutex
+	var ran []string
+	record := func(name string) func() {
+		return func() {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, name)
+		}
+	}
+	stuck := make(chan struct{})
+	defer close(stuck)
+
+	// Added out of order; a stage's hooks keep the order they were added in
+	s.onShutdown(shutdownStorage, "storage", time.Second, func() {
+		if _, err := s.storage.GetProcessMetadata("proc-1"); err == nil {
+			t.Error("storage still open after its own hook")
+		}
+		record("storage")()
+	})
+	s.onShutdown(shutdownProcesses, "processes", time.Second, func() {
+		select {
+		case <-s.done:
+			t.Error("background loops stopped before processes were detached")
+		default:
+		}
+		if _, err := s.storage.GetProcessMetadata("proc-1"); err != nil {
+			t.Errorf("storage closed before processes were detached: %v", err)
+		}
+		record("processes")()
+	})
+	s.onShutdown(shutdownProcesses, "stuck", 50*time.Millisecond, func() {
+		record("stuck")()
+		<-stuck
+	})
+	s.onShutdown(shutdownProcesses, "processes after stuck", time.Second, record("processes after stuck"))
+	s.onShutdown(shutdownAccepting, "accepting", time.Second, record("accepting"))
+	s.onShutdown(shutdownBackground, "background", time.Second, record("background"))
+	s.onShutdown(shutdownSessions, "sessions", time.Second, record("sessions"))
+
+	start := time.Now()
+	s.Stop()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Stop took %v with a hook stuck", elapsed)
+	}
+
+	want := []string{"accepting", "sessions", "processes", "stuck", "processes after stuck", "background", "storage"}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ran) != len(want) {
+		t.Fatalf("ran %v, want %v", ran, want)
+	}
+	for i := range want {
+		if ran[i] != want[i] {
+			t.Errorf("ran %v, want %v", ran, want)
+			break
+		}
+	}
+}
+
+func TestStopSavesProcessesBeforeClosingStorage(t *testing.T) {
+	dataDir := t.TempDir()
+	s, err := New("127.0.0.1:0", dataDir, DefaultConfig())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	conn, _ := connectTestClient(t, s)
+
+	if err := s.storage.SaveProcessMetadata(storage.ProcessMetadata{ProcessID: "proc-1", HostID: "host-1",
+		ProcessType: "shell", TmuxName: "rc-proc-1", CWD: "/started"}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	s.processRegistry.Register(&process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeShell, CWD: "/moved"})
+
+	s.Stop()
+
+	// The client is told the bridge is going away
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("read after stop: %v, want a going-away close", err)
+	}
+
+	s, err = New("127.0.0.1:0", dataDir, DefaultConfig())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer s.Stop()
+	meta, err := s.storage.GetProcessMetadata("proc-1")
+	if err != nil || meta == nil || meta.CWD != "/moved" {
+		t.Errorf("metadata after stop = %+v, %v; want the last CWD", meta, err)
+	}
+}
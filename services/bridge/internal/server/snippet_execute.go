@@ -0,0 +1,229 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// snippetExecuteConcurrency caps how many terminals one snippet_execute
+// writes to at once
+const snippetExecuteConcurrency = 4
+
+// snippetVariable matches a {{name}} placeholder in a snippet
+var snippetVariable = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// snippetWriteFunc types a rendered snippet into a process's PTY
+type snippetWriteFunc func(s *Server, connSession *ConnectedSession, proc *process.Process, data []byte) error
+
+// renderSnippet replaces the {{name}} placeholders in content with their
+// values. It returns the first name with no value, if any, instead.
+func renderSnippet(content string, vars map[string]string) (string, string) {
+	var missing string
+	rendered := snippetVariable.ReplaceAllStringFunc(content, func(placeholder string) string {
+		name := snippetVariable.FindStringSubmatch(placeholder)[1]
+		value, ok := vars[name]
+		if !ok && missing == "" {
+			missing = name
+		}
+		return value
+	})
+	if missing != "" {
+		return "", missing
+	}
+	return rendered, ""
+}
+
+// snippetVariables returns the values a snippet is rendered with for a
+// process: the request's variables, or else the process's own
+func snippetVariables(proc *process.Process, requested map[string]string) map[string]string {
+	info := proc.ToInfo()
+	vars := map[string]string{
+		"processId": info.ID,
+		"hostId":    info.HostID,
+		"cwd":       info.CWD,
+	}
+	if info.Name != nil {
+		vars["processName"] = *info.Name
+	}
+	if info.ClaudeCWD != "" {
+		vars["claudeCwd"] = info.ClaudeCWD
+	}
+	for name, value := range requested {
+		vars[name] = value
+	}
+	return vars
+}
+
+// handleSnippetExecute types a snippet into the terminals of one or more
+// processes, on any hosts. Each target is rendered and written on its own,
+// a few at a time; one that fails is reported in the results without
+// stopping the rest.
+func (s *Server) handleSnippetExecute(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.SnippetExecutePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	named := 0
+	for _, given := range []bool{payload.ProcessID != "", len(payload.ProcessIDs) > 0, payload.WorkspaceID != ""} {
+		if given {
+			named++
+		}
+	}
+	if named != 1 {
+		return connSession.SendErrorDetails(protocol.ErrorInvalidArgs, protocol.InvalidArgsDetails{
+			Tokens: []string{},
+			Reason: "exactly one of processId, processIds and workspaceId is required",
+		})
+	}
+
+	snippet, err := s.storage.GetSnippet(payload.SnippetID)
+	if err != nil {
+		log.Printf("[ERROR] [SNIPPETS] Failed to get snippet %s: %v", payload.SnippetID, err)
+		return connSession.SendErrorDetails(protocol.ErrorStorageError, protocol.ErrorDetails{"snippetId": payload.SnippetID, "reason": err.Error()})
+	}
+	if snippet == nil {
+		return connSession.SendErrorDetails(protocol.ErrorNotFound,
+			protocol.ErrorDetails{"snippetId": payload.SnippetID, "reason": "snippet not found"})
+	}
+
+	processIDs, failure := s.snippetTargets(payload)
+	if failure != nil {
+		return connSession.sendFailure(failure)
+	}
+
+	mode := inputMode(payload.Mode, protocol.InputModeText)
+	results := make([]protocol.SnippetTargetResult, len(processIDs))
+	slots := make(chan struct{}, snippetExecuteConcurrency)
+	var wg sync.WaitGroup
+	for i, processID := range processIDs {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = s.executeSnippet(connSession, snippet, processID, payload.Variables, mode)
+		}()
+	}
+	wg.Wait()
+
+	// There is no audit log, so the log is the record of what was run
+	outcomes := make([]string, len(results))
+	for i, result := range results {
+		outcome := "ok"
+		if !result.Success {
+			outcome = string(result.Code)
+		}
+		outcomes[i] = fmt.Sprintf("%s=%s", result.ProcessID, outcome)
+	}
+	log.Printf("[INFO] [SNIPPETS] Session %s ran snippet %s (%s) on %d processes: %s",
+		connSession.ID, snippet.ID, snippet.Name, len(results), strings.Join(outcomes, " "))
+
+	response, err := protocol.NewMessage(protocol.TypeSnippetExecuteResult, protocol.SnippetExecuteResultPayload{
+		RequestID: payload.RequestID,
+		SnippetID: snippet.ID,
+		Mode:      mode,
+		Results:   results,
+	})
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
+
+// snippetTargets returns the processes a snippet_execute names, in order
+// and each once
+func (s *Server) snippetTargets(payload protocol.SnippetExecutePayload) ([]string, *requestFailure) {
+	var named []string
+	switch {
+	case payload.ProcessID != "":
+		named = []string{payload.ProcessID}
+	case payload.WorkspaceID != "":
+		ws, err := s.storage.GetWorkspace(payload.WorkspaceID)
+		if err != nil {
+			return nil, &requestFailure{protocol.ErrorStorageError, protocol.ErrorDetails{"workspaceId": payload.WorkspaceID, "reason": err.Error()}}
+		}
+		if ws == nil {
+			return nil, &requestFailure{protocol.ErrorNotFound,
+				protocol.ErrorDetails{"workspaceId": payload.WorkspaceID, "reason": "workspace not found"}}
+		}
+		if named, err = s.storage.GetWorkspaceProcessIDs(payload.WorkspaceID); err != nil {
+			return nil, &requestFailure{protocol.ErrorStorageError, protocol.ErrorDetails{"workspaceId": payload.WorkspaceID, "reason": err.Error()}}
+		}
+	default:
+		named = payload.ProcessIDs
+	}
+
+	seen := make(map[string]bool, len(named))
+	processIDs := make([]string, 0, len(named))
+	for _, id := range named {
+		if !seen[id] {
+			seen[id] = true
+			processIDs = append(processIDs, id)
+		}
+	}
+	return processIDs, nil
+}
+
+// executeSnippet renders a snippet for one process, sanitizes it in mode
+// and types it into its terminal
+func (s *Server) executeSnippet(connSession *ConnectedSession, snippet *storage.Snippet, processID string, requested map[string]string, mode protocol.InputMode) protocol.SnippetTargetResult {
+	result := protocol.SnippetTargetResult{ProcessID: processID}
+	fail := func(code protocol.ErrorCode, reason string) protocol.SnippetTargetResult {
+		result.Code = code
+		result.Error = &reason
+		return result
+	}
+
+	proc := s.processRegistry.Get(processID)
+	if proc == nil {
+		return fail(protocol.ErrorNotFound, "process not found")
+	}
+	result.HostID = proc.HostID
+	if proc.PTY == nil {
+		return fail(protocol.ErrorNoPty, "process has no terminal")
+	}
+
+	rendered, missing := renderSnippet(snippet.Content, snippetVariables(proc, requested))
+	if missing != "" {
+		return fail(protocol.ErrorSnippetRender, fmt.Sprintf("no value for {{%s}}", missing))
+	}
+	// Variables are sanitized along with the snippet, as they may come
+	// from the request
+	rendered, err := sanitizeInput(rendered, mode, "process "+processID)
+	if err != nil {
+		return fail(protocol.ErrorHandlerError, err.Error())
+	}
+
+	// Written whole, so nothing typed into the terminal meanwhile lands
+	// inside the command
+	if err := s.typeSnippet(s, connSession, proc, []byte(rendered+"\n")); err != nil {
+		log.Printf("[ERROR] [SNIPPETS] Failed to type snippet %s into process %s: %v", snippet.ID, processID, err)
+		failure := s.recordPtyFailure(proc, process.OpPtyWrite, err)
+		if details, ok := failure.details.(protocol.PtyFailureDetails); ok {
+			result.SuggestedAction = details.SuggestedAction
+		}
+		return fail(failure.code, err.Error())
+	}
+	s.clearProcessError(proc, process.OpPtyWrite)
+
+	result.Success = true
+	return result
+}
+
+// writeSnippet writes a rendered snippet to a process's PTY, first taking
+// the pane out of copy mode, which would take it as its own commands
+func (s *Server) writeSnippet(connSession *ConnectedSession, proc *process.Process, data []byte) error {
+	if err := s.leaveCopyMode(connSession, proc); err != nil {
+		return err
+	}
+	return proc.PTY.Write(data)
+}
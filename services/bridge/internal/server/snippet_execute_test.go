@@ -0,0 +1,202 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+func TestRenderSnippet(t *testing.T) {
+	vars := map[string]string{"cwd": "/srv/app", "env": "prod"}
+	if got, missing := renderSnippet("cd {{cwd}} && make {{ env }}-cache-clear", vars); got != "cd /srv/app && make prod-cache-clear" || missing != "" {
+		t.Errorf("renderSnippet = %q, %q", got, missing)
+	}
+	if got, missing := renderSnippet("echo {{env}} {{region}} {{zone}}", vars); got != "" || missing != "region" {
+		t.Errorf("renderSnippet with unknown variables = %q, %q", got, missing)
+	}
+	if got, _ := renderSnippet("echo '{{' {1} }}", vars); got != "echo '{{' {1} }}" {
+		t.Errorf("renderSnippet without placeholders = %q", got)
+	}
+}
+
+// stubSnippetWrites makes snippets typed into the given processes succeed
+// and records what was written to each, in calls; other processes are
+// written to as usual
+func stubSnippetWrites(s *Server, processIDs ...string) func() map[string][]string {
+	var mu sync.Mutex
+	writes := make(map[string][]string)
+	healthy := make(map[string]bool)
+	for _, id := range processIDs {
+		healthy[id] = true
+	}
+	write := s.typeSnippet
+	s.typeSnippet = func(s *Server, connSession *ConnectedSession, proc *process.Process, data []byte) error {
+		if !healthy[proc.ID] {
+			return write(s, connSession, proc, data)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		writes[proc.ID] = append(writes[proc.ID], string(data))
+		return nil
+	}
+	return func() map[string][]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return writes
+	}
+}
+
+func TestSnippetExecuteMultipleTargets(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 4)
+	s.processRegistry.Get("proc-0").SetName("api")
+	s.processRegistry.Get("proc-1").SetName("cron")
+	s.processRegistry.Get("proc-2").SetName("worker")
+	writes := stubSnippetWrites(s, "proc-0", "proc-2", "proc-3")
+	if err := s.storage.CreateSnippet(storage.Snippet{ID: "snip-1", Name: "clear cache", Content: "cd {{cwd}} && ./clear-cache {{processName}} --{{mode}}"}); err != nil {
+		t.Fatalf("CreateSnippet: %v", err)
+	}
+
+	// proc-1 isn't attached, proc-3 has no name and proc-9 doesn't exist
+	dispatch(t, s, cs, protocol.TypeSnippetExecute, protocol.SnippetExecutePayload{
+		RequestID:  "req-1",
+		SnippetID:  "snip-1",
+		ProcessIDs: []string{"proc-0", "proc-1", "proc-2", "proc-3", "proc-9", "proc-0"},
+		Variables:  map[string]string{"mode": "all"},
+	})
+	var result protocol.SnippetExecuteResultPayload
+	readPayload(t, conn, protocol.TypeSnippetExecuteResult, &result)
+	if result.RequestID != "req-1" || result.SnippetID != "snip-1" || len(result.Results) != 5 {
+		t.Fatalf("result = %+v", result)
+	}
+	want := []struct {
+		processID string
+		code      protocol.ErrorCode
+		action    protocol.SuggestedAction
+	}{
+		{"proc-0", "", ""},
+		{"proc-1", protocol.ErrorPtyDetached, protocol.SuggestedActionReattachProcess},
+		{"proc-2", "", ""},
+		{"proc-3", protocol.ErrorSnippetRender, ""},
+		{"proc-9", protocol.ErrorNotFound, ""},
+	}
+	for i, w := range want {
+		got := result.Results[i]
+		if got.ProcessID != w.processID || got.Success != (w.code == "") || got.Code != w.code || got.SuggestedAction != w.action {
+			t.Errorf("result %d = %+v, want %+v", i, got, w)
+		}
+		if !got.Success && got.Error == nil {
+			t.Errorf("%s failed without a reason", got.ProcessID)
+		}
+	}
+
+	// Each healthy target got the whole command in one write
+	wantWrites := map[string][]string{
+		"proc-0": {"cd /cached && ./clear-cache api --all\n"},
+		"proc-2": {"cd /cached && ./clear-cache worker --all\n"},
+	}
+	got := writes()
+	if len(got) != len(wantWrites) {
+		t.Errorf("writes = %q", got)
+	}
+	for id, w := range wantWrites {
+		if len(got[id]) != 1 || got[id][0] != w[0] {
+			t.Errorf("%s writes = %q, want %q", id, got[id], w)
+		}
+	}
+
+	// The failed write is the detached process's last error
+	if e := s.processRegistry.Get("proc-1").GetLastError(); e == nil || e.Code != protocol.ErrorPtyDetached || e.Operation != process.OpPtyWrite {
+		t.Errorf("proc-1 last error = %+v", e)
+	}
+}
+
+func TestSnippetExecuteWorkspace(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 3)
+	writes := stubSnippetWrites(s, "proc-0", "proc-1", "proc-2")
+	if err := s.storage.CreateSnippet(storage.Snippet{ID: "snip-1", Name: "where", Content: "echo {{processId}}@{{hostId}}"}); err != nil {
+		t.Fatalf("CreateSnippet: %v", err)
+	}
+	if err := s.storage.CreateWorkspace(storage.Workspace{ID: "ws-1", Name: "caches", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	for _, id := range []string{"proc-0", "proc-2"} {
+		if err := s.storage.SetProcessWorkspace(id, "ws-1"); err != nil {
+			t.Fatalf("SetProcessWorkspace: %v", err)
+		}
+	}
+
+	dispatch(t, s, cs, protocol.TypeSnippetExecute, protocol.SnippetExecutePayload{SnippetID: "snip-1", WorkspaceID: "ws-1"})
+	var result protocol.SnippetExecuteResultPayload
+	readPayload(t, conn, protocol.TypeSnippetExecuteResult, &result)
+	if len(result.Results) != 2 || !result.Results[0].Success || !result.Results[1].Success {
+		t.Fatalf("result = %+v", result)
+	}
+	got := writes()
+	if len(got) != 2 || got["proc-0"][0] != "echo proc-0@host-1\n" || got["proc-2"][0] != "echo proc-2@host-1\n" {
+		t.Errorf("writes = %q", got)
+	}
+
+	// The targets are named one way only
+	dispatch(t, s, cs, protocol.TypeSnippetExecute, protocol.SnippetExecutePayload{SnippetID: "snip-1", ProcessID: "proc-0", WorkspaceID: "ws-1"})
+	var errPayload protocol.ErrorPayload
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorInvalidArgs {
+		t.Errorf("two kinds of targets: %s", errPayload.Code)
+	}
+	dispatch(t, s, cs, protocol.TypeSnippetExecute, protocol.SnippetExecutePayload{SnippetID: "snip-1", WorkspaceID: "ws-9"})
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotFound {
+		t.Errorf("unknown workspace: %s", errPayload.Code)
+	}
+	dispatch(t, s, cs, protocol.TypeSnippetExecute, protocol.SnippetExecutePayload{SnippetID: "snip-9", ProcessID: "proc-0"})
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotFound {
+		t.Errorf("unknown snippet: %s", errPayload.Code)
+	}
+}
+
+func TestSnippetExecuteInputModes(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 1)
+	writes := stubSnippetWrites(s, "proc-0")
+	if err := s.storage.CreateSnippet(storage.Snippet{ID: "snip-1", Name: "greet", Content: "echo {{name}}\x1b[A\r"}); err != nil {
+		t.Fatalf("CreateSnippet: %v", err)
+	}
+
+	// Variables are sanitized too, and the mode applied is echoed
+	name := "héllo\x1b]0;pwned\a\x1bx"
+	tests := []struct {
+		mode    protocol.InputMode
+		applied protocol.InputMode
+		typed   string
+	}{
+		{"", protocol.InputModeText, "echo héllo\n"},
+		{protocol.InputModeKeys, protocol.InputModeKeys, "echo héllo\x1b[A\r\n"},
+		{protocol.InputModeRaw, protocol.InputModeRaw, "echo " + name + "\x1b[A\r\n"},
+	}
+	for i, tt := range tests {
+		dispatch(t, s, cs, protocol.TypeSnippetExecute, protocol.SnippetExecutePayload{
+			SnippetID: "snip-1", ProcessID: "proc-0", Variables: map[string]string{"name": name}, Mode: tt.mode,
+		})
+		var result protocol.SnippetExecuteResultPayload
+		readPayload(t, conn, protocol.TypeSnippetExecuteResult, &result)
+		if result.Mode != tt.applied || len(result.Results) != 1 || !result.Results[0].Success {
+			t.Errorf("mode %q: result = %+v", tt.mode, result)
+		}
+		if got := writes()["proc-0"]; len(got) != i+1 || got[i] != tt.typed {
+			t.Errorf("mode %q: typed %q, want %q", tt.mode, got, tt.typed)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// ============================================================================
+// Startup Hooks
+// ============================================================================
+//
+// Some hosts need every shell set up before it is useful, e.g. a virtualenv
+// activated and a repo entered. A host's settings, and a template, may list
+// commands for that; they are typed into a new shell one line each, in
+// order, once it is up and before its env is captured, so the capture sees
+// what they set. Typing is best-effort: whether a hook failed in the shell
+// isn't known, and one that can't be sent is logged and the rest still are.
+// The hooks a process was started with are recorded with it. Clones and
+// reattached sessions don't run them again.
+
+// maxStartupHookLength bounds one hook command, in bytes
+const maxStartupHookLength = 4096
+
+// hostStartupHooks returns the startup hooks a host's settings list
+func (s *Server) hostStartupHooks(hostID string) []string {
+	if s.storage == nil {
+		return nil
+	}
+	hooks, err := s.storage.GetHostStartupHooks(hostID)
+	if err != nil {
+		log.Printf("[WARN] [HOOKS] Failed to get startup hooks of host %s, running none: %v", hostID, err)
+		return nil
+	}
+	return hooks
+}
+
+// checkStartupHooks trims a list of startup hooks sent by a client and
+// rejects blank, multi-line and overlong commands
+func checkStartupHooks(hooks []string) ([]string, error) {
+	checked := make([]string, 0, len(hooks))
+	for i, hook := range hooks {
+		hook = strings.TrimSpace(hook)
+		switch {
+		case hook == "":
+			return nil, fmt.Errorf("startup hook %d is empty", i+1)
+		case strings.ContainsAny(hook, "\r\n"):
+			return nil, fmt.Errorf("startup hook %d spans several lines; use one entry per command", i+1)
+		case len(hook) > maxStartupHookLength:
+			return nil, fmt.Errorf("startup hook %d is longer than %d bytes", i+1, maxStartupHookLength)
+		}
+		checked = append(checked, hook)
+	}
+	return checked, nil
+}
+
+// updateHostStartupHooks saves the startup hooks a host_config_update
+// replaces, if it does, and returns the host's hooks after it. The payload's
+// hooks must have been checked.
+func (s *Server) updateHostStartupHooks(hostID string, payload protocol.HostConfigUpdatePayload) ([]string, error) {
+	if payload.StartupHooks == nil {
+		return s.hostStartupHooks(hostID), nil
+	}
+	hooks := *payload.StartupHooks
+	if err := s.storage.SetHostStartupHooks(hostID, hooks); err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] [HOOKS] Set %d startup hooks of host %s", len(hooks), hostID)
+	return hooks, nil
+}
+
+// sendStartupHooks types a new process's startup hooks into its shell, in
+// order, once the shell is up. It returns when all were sent or given up on.
+func (s *Server) sendStartupHooks(proc *process.Process) {
+	if len(proc.StartupHooks) == 0 {
+		return
+	}
+	sshConn := s.sshManager.GetConnection(proc.HostID)
+	if sshConn == nil {
+		log.Printf("[WARN] [HOOKS] Host %s disconnected before the startup hooks of process %s were sent", proc.HostID, proc.ID)
+		return
+	}
+	if err := s.envManager.WaitForShell(sshConn.Client, proc.PTY.Tmux(), proc.PTY.TmuxName); err != nil {
+		// Keys typed now may still reach the shell once its startup is done
+		log.Printf("[WARN] [HOOKS] Sending startup hooks of process %s before its shell is ready: %v", proc.ID, err)
+	}
+	for i, hook := range proc.StartupHooks {
+		if err := proc.PTY.SendLine(hook); err != nil {
+			log.Printf("[WARN] [HOOKS] Failed to send startup hook %d of process %s: %v", i+1, proc.ID, err)
+			continue
+		}
+		log.Printf("[DEBUG] [HOOKS] Sent startup hook %d of process %s", i+1, proc.ID)
+	}
+}
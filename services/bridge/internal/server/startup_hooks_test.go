@@ -0,0 +1,157 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+)
+
+// typedLines returns the lines recorded typed into a tmux session, up to
+// and including its env capture, waiting for the capture to be typed
+func typedLines(t *testing.T, keys func() string, processID string) []string {
+	t.Helper()
+	prefix := "send-keys -t " + pty.TmuxSessionName(processID) + " -l "
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var lines []string
+		for _, call := range strings.Split(keys(), "\n") {
+			line, ok := strings.CutPrefix(call, prefix)
+			if !ok {
+				continue
+			}
+			line, _, _ = strings.Cut(line, " ; send-keys")
+			lines = append(lines, line)
+			if strings.Contains(line, "env >") {
+				return lines
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("env capture of %s not typed; typed %q", processID, lines)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestStartupHooksRunInOrderBeforeEnvCapture(t *testing.T) {
+	t.Setenv("FAKE_TMUX_HOLD", "10")
+	keys := recordTmuxKeys(t)
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	hostHooks := []string{"source ~/venv/bin/activate", "cd ~/repo"}
+	if err := s.storage.SetHostStartupHooks("host-1", hostHooks); err != nil {
+		t.Fatal(err)
+	}
+
+	dispatch(t, s, cs, protocol.TypeProcessCreate, protocol.ProcessCreatePayload{HostID: "host-1"})
+	var created protocol.ProcessCreatedPayload
+	readPayload(t, conn, protocol.TypeProcessCreated, &created)
+	if !slices.Equal(created.Process.StartupHooks, hostHooks) {
+		t.Errorf("process startupHooks = %q, want %q", created.Process.StartupHooks, hostHooks)
+	}
+	typed := typedLines(t, keys, created.Process.ID)
+	if len(typed) != 3 || !slices.Equal(typed[:2], hostHooks) {
+		t.Errorf("typed %q, want the hooks in order, then the env capture", typed)
+	}
+	if meta, err := s.storage.GetProcessMetadata(created.Process.ID); err != nil || meta == nil || !slices.Equal(meta.StartupHooks, hostHooks) {
+		t.Errorf("recorded hooks = %+v, %v", meta, err)
+	}
+
+	// skipHooks types only the env capture
+	dispatch(t, s, cs, protocol.TypeProcessCreate, protocol.ProcessCreatePayload{HostID: "host-1", SkipHooks: true})
+	var skipped protocol.ProcessCreatedPayload
+	readPayload(t, conn, protocol.TypeProcessCreated, &skipped)
+	if skipped.Process.StartupHooks != nil {
+		t.Errorf("process startupHooks with skipHooks = %q", skipped.Process.StartupHooks)
+	}
+	if typed := typedLines(t, keys, skipped.Process.ID); len(typed) != 1 {
+		t.Errorf("typed %q with skipHooks", typed)
+	}
+
+	// A template's hooks follow the host's
+	tmpl := createTemplate(t, s, conn, cs, protocol.ProcessTemplateCreatePayload{
+		Name: "api", StartupHooks: []string{" nvm use ", "make deps"},
+	})
+	if want := []string{"nvm use", "make deps"}; !slices.Equal(tmpl.StartupHooks, want) {
+		t.Errorf("template startupHooks = %q, want %q", tmpl.StartupHooks, want)
+	}
+	for _, skip := range []bool{false, true} {
+		dispatch(t, s, cs, protocol.TypeProcessCreateFromTemplate, protocol.ProcessCreateFromTemplatePayload{
+			TemplateID: tmpl.ID, HostID: strPtr("host-1"), SkipHooks: skip,
+		})
+		var fromTemplate protocol.ProcessCreatedPayload
+		readPayload(t, conn, protocol.TypeProcessCreated, &fromTemplate)
+		readPayload(t, conn, protocol.TypeProcessCreateFromTemplateResult, nil)
+		want := []string{"source ~/venv/bin/activate", "cd ~/repo", "nvm use", "make deps"}
+		if skip {
+			want = nil
+		}
+		if !slices.Equal(fromTemplate.Process.StartupHooks, want) {
+			t.Errorf("skip=%t: process startupHooks = %q, want %q", skip, fromTemplate.Process.StartupHooks, want)
+		}
+		if typed := typedLines(t, keys, fromTemplate.Process.ID); !slices.Equal(typed[:len(typed)-1], want) {
+			t.Errorf("skip=%t: typed %q, want %q before the env capture", skip, typed, want)
+		}
+	}
+}
+
+func TestHostConfigStartupHooks(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	dispatch(t, s, cs, protocol.TypeHostConfigCreate, protocol.HostConfigCreatePayload{
+		Name: "box", Host: "127.0.0.1", Port: 22, Username: "user", AuthType: "password", Credential: "secret",
+	})
+	var created protocol.HostConfigCreateResultPayload
+	readPayload(t, conn, protocol.TypeHostConfigCreateResult, &created)
+	hostID := created.Host.ID
+
+	update := func(hooks []string) protocol.HostConfigUpdateResultPayload {
+		t.Helper()
+		dispatch(t, s, cs, protocol.TypeHostConfigUpdate, protocol.HostConfigUpdatePayload{ID: hostID, StartupHooks: &hooks})
+		var result protocol.HostConfigUpdateResultPayload
+		readPayload(t, conn, protocol.TypeHostConfigUpdateResult, &result)
+		return result
+	}
+	listed := func() []string {
+		t.Helper()
+		dispatch(t, s, cs, protocol.TypeHostConfigList, protocol.HostConfigListPayload{})
+		var list protocol.HostConfigListResultPayload
+		readPayload(t, conn, protocol.TypeHostConfigListResult, &list)
+		return list.Hosts[0].StartupHooks
+	}
+
+	want := []string{"source ~/venv/bin/activate", "cd ~/repo"}
+	if result := update([]string{"source ~/venv/bin/activate ", "cd ~/repo"}); !result.Success || !slices.Equal(result.Host.StartupHooks, want) {
+		t.Errorf("update = %+v", result)
+	}
+	if hooks := listed(); !slices.Equal(hooks, want) {
+		t.Errorf("listed hooks = %q, want %q", hooks, want)
+	}
+
+	// A bad hook changes nothing
+	for _, bad := range [][]string{{"cd ~/repo", "  "}, {"cd ~/repo\nmake"}} {
+		if result := update(bad); result.Success || result.Error == nil {
+			t.Errorf("update with %q = %+v", bad, result)
+		}
+	}
+	if hooks := listed(); !slices.Equal(hooks, want) {
+		t.Errorf("hooks after bad updates = %q", hooks)
+	}
+
+	// Another update leaves them; an empty list clears them
+	dispatch(t, s, cs, protocol.TypeHostConfigUpdate, protocol.HostConfigUpdatePayload{ID: hostID, Name: strPtr("box 2")})
+	readPayload(t, conn, protocol.TypeHostConfigUpdateResult, nil)
+	if hooks := listed(); !slices.Equal(hooks, want) {
+		t.Errorf("hooks after a name change = %q", hooks)
+	}
+	if result := update([]string{}); !result.Success || result.Host.StartupHooks != nil {
+		t.Errorf("clearing update = %+v", result)
+	}
+	if hooks := listed(); hooks != nil {
+		t.Errorf("cleared hooks = %q", hooks)
+	}
+}
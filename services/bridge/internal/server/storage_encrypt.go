@@ -0,0 +1,68 @@
+package server
+
+import (
+	"log"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// ============================================================================
+// History Encryption
+// ============================================================================
+//
+// With --encrypt-history, chat messages, PTY history and process env vars
+// are encrypted as they are persisted, so rows stored before stay plaintext
+// until their buffer is written again. storage_encrypt_now encrypts them all
+// at once, in the background: storage_encrypt_progress reports each table
+// as it goes, and storage_encrypt_result ends the run.
+
+func (s *Server) handleStorageEncryptNow(connSession *ConnectedSession, msg *protocol.Message) error {
+	if !s.config.EncryptHistory {
+		return connSession.SendErrorDetails(protocol.ErrorInvalidState,
+			protocol.ErrorDetails{"reason": "history encryption is off; start the bridge with --encrypt-history"})
+	}
+	if !s.encrypting.CompareAndSwap(false, true) {
+		return connSession.SendErrorDetails(protocol.ErrorInvalidState,
+			protocol.ErrorDetails{"reason": "history is already being encrypted"})
+	}
+
+	log.Printf("[INFO] [STORAGE] Session %s started encrypting stored history", connSession.ID)
+	go func() {
+		defer s.encrypting.Store(false)
+		if err := s.encryptHistoryNow(connSession); err != nil {
+			log.Printf("[ERROR] [STORAGE] Failed to send encryption result to session %s: %v", connSession.ID, err)
+		}
+	}()
+	return nil
+}
+
+// encryptHistoryNow encrypts the stored history, reporting to connSession
+func (s *Server) encryptHistoryNow(connSession *ConnectedSession) error {
+	encrypted, err := s.storage.EncryptHistoryNow(func(p storage.EncryptProgress) {
+		msg, err := protocol.NewMessage(protocol.TypeStorageEncryptProgress, protocol.StorageEncryptProgressPayload{
+			Table: p.Table,
+			Done:  p.Done,
+			Total: p.Total,
+		})
+		if err == nil {
+			err = connSession.Send(msg)
+		}
+		if err != nil {
+			log.Printf("[WARN] [STORAGE] Failed to send encryption progress to session %s: %v", connSession.ID, err)
+		}
+	})
+
+	result := protocol.StorageEncryptResultPayload{Success: err == nil, Encrypted: encrypted}
+	if err != nil {
+		log.Printf("[ERROR] [STORAGE] Encrypting stored history failed after %d rows: %v", encrypted, err)
+		errMsg := err.Error()
+		result.Code = protocol.ErrorStorageError
+		result.Error = &errMsg
+	}
+	msg, err := protocol.NewMessage(protocol.TypeStorageEncryptResult, result)
+	if err != nil {
+		return err
+	}
+	return connSession.Send(msg)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+func TestStorageEncryptNow(t *testing.T) {
+	config := DefaultConfig()
+	config.CWDRefreshInterval = 0
+	config.TmuxProbeInterval = 0
+	config.EncryptHistory = true
+	s := newTestServer(t, config)
+	conn, cs := connectTestClient(t, s)
+
+	// Chat stored before encryption was turned on
+	key := s.cipher.Derive("history")
+	if err := s.storage.SetHistoryEncryption(key, false); err != nil {
+		t.Fatalf("SetHistoryEncryption: %v", err)
+	}
+	s.storage.RegisterProcess("proc-1", "host-1")
+	if err := s.storage.SetChatMessages("proc-1", "host-1", []storage.ChatMessage{
+		{MessageID: 1, Role: "user", Message: "first", MessageTime: "2026-01-01T10:00:00Z"},
+		{MessageID: 2, Role: "assistant", Message: "second", MessageTime: "2026-01-01T10:00:05Z"},
+	}); err != nil {
+		t.Fatalf("SetChatMessages: %v", err)
+	}
+	if err := s.storage.PersistAll(); err != nil {
+		t.Fatalf("PersistAll: %v", err)
+	}
+	if err := s.storage.SetHistoryEncryption(key, true); err != nil {
+		t.Fatalf("SetHistoryEncryption: %v", err)
+	}
+
+	// One run at a time
+	s.encrypting.Store(true)
+	dispatch(t, s, cs, protocol.TypeStorageEncryptNow, nil)
+	var failure protocol.ErrorPayload
+	readPayload(t, conn, protocol.TypeError, &failure)
+	if failure.Code != protocol.ErrorInvalidState {
+		t.Errorf("concurrent run: %+v", failure)
+	}
+	s.encrypting.Store(false)
+
+	dispatch(t, s, cs, protocol.TypeStorageEncryptNow, nil)
+	want := []protocol.StorageEncryptProgressPayload{
+		{Table: "pty_history"},
+		{Table: "chat_history", Total: 2}, {Table: "chat_history", Done: 2, Total: 2},
+		{Table: "chat_history"}, // Metadata, of which these have none
+		{Table: "process_metadata"},
+	}
+	for _, w := range want {
+		var progress protocol.StorageEncryptProgressPayload
+		readPayload(t, conn, protocol.TypeStorageEncryptProgress, &progress)
+		if progress != w {
+			t.Errorf("progress = %+v, want %+v", progress, w)
+		}
+	}
+	var result protocol.StorageEncryptResultPayload
+	readPayload(t, conn, protocol.TypeStorageEncryptResult, &result)
+	if !result.Success || result.Encrypted != 2 || result.Error != nil {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestStorageEncryptNowNeedsEncryption(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+
+	dispatch(t, s, cs, protocol.TypeStorageEncryptNow, nil)
+	var failure protocol.ErrorPayload
+	readPayload(t, conn, protocol.TypeError, &failure)
+	if failure.Code != protocol.ErrorInvalidState {
+		t.Errorf("error = %+v", failure)
+	}
+	expectNothingQueued(t, conn, cs)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// ============================================================================
+// Storage Flush
+// ============================================================================
+//
+// PTY history and chat are buffered in memory and saved every
+// --persist-interval, or earlier for a process with enough unsaved (see
+// --flush-max-bytes and --flush-max-age). storage_flush saves them now, so a
+// client about to do something risky, like killing a process or restarting
+// a host, doesn't lose what arrived since.
+
+func (s *Server) handleStorageFlush(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.StorageFlushPayload
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return err
+		}
+	}
+	if payload.ProcessID != "" && s.processRegistry.Get(payload.ProcessID) == nil {
+		return connSession.sendProcessNotFound(payload.ProcessID)
+	}
+
+	start := time.Now()
+	var err error
+	if payload.ProcessID != "" {
+		err = s.storage.FlushProcess(payload.ProcessID)
+	} else {
+		err = s.storage.Flush()
+	}
+
+	result := protocol.StorageFlushResultPayload{
+		RequestID:  payload.RequestID,
+		ProcessID:  payload.ProcessID,
+		Success:    err == nil,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		log.Printf("[ERROR] [STORAGE] Flush requested by session %s failed: %v", connSession.ID, err)
+		errMsg := err.Error()
+		result.Code = protocol.ErrorStorageError
+		result.Error = &errMsg
+	} else {
+		log.Printf("[DEBUG] [STORAGE] Session %s flushed storage in %dms", connSession.ID, result.DurationMs)
+	}
+	response, err := protocol.NewMessage(protocol.TypeStorageFlushResult, result)
+	if err != nil {
+		return err
+	}
+	return connSession.Send(response)
+}
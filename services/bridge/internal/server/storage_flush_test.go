@@ -0,0 +1,59 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+func TestStorageFlush(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 2)
+	// Below the early flush thresholds, so only a flush saves it
+	for _, id := range []string{"proc-0", "proc-1"} {
+		if err := s.storage.AppendPtyOutput(id, "host-1", []byte("$ rm -rf build\r\n")); err != nil {
+			t.Fatalf("AppendPtyOutput: %v", err)
+		}
+	}
+
+	// What a bridge killed now would find
+	survivor, err := storage.NewStore(filepath.Join(s.profileDir, dbFileName))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer survivor.Close()
+	stored := func(processID string) string {
+		history, err := survivor.GetPtyHistory(processID)
+		if err != nil {
+			t.Fatalf("GetPtyHistory: %v", err)
+		}
+		return string(history)
+	}
+
+	dispatch(t, s, cs, protocol.TypeStorageFlush, protocol.StorageFlushPayload{RequestID: "req-1", ProcessID: "proc-0"})
+	var result protocol.StorageFlushResultPayload
+	readPayload(t, conn, protocol.TypeStorageFlushResult, &result)
+	if !result.Success || result.RequestID != "req-1" || result.ProcessID != "proc-0" {
+		t.Fatalf("result = %+v", result)
+	}
+	if stored("proc-0") != "$ rm -rf build\r\n" || stored("proc-1") != "" {
+		t.Errorf("after flushing proc-0: stored %q and %q", stored("proc-0"), stored("proc-1"))
+	}
+
+	dispatch(t, s, cs, protocol.TypeStorageFlush, nil)
+	readPayload(t, conn, protocol.TypeStorageFlushResult, &result)
+	if !result.Success || stored("proc-1") != "$ rm -rf build\r\n" {
+		t.Errorf("after flushing all: %+v, stored %q", result, stored("proc-1"))
+	}
+
+	dispatch(t, s, cs, protocol.TypeStorageFlush, protocol.StorageFlushPayload{ProcessID: "proc-9"})
+	var errPayload protocol.ErrorPayload
+	readPayload(t, conn, protocol.TypeError, &errPayload)
+	if errPayload.Code != protocol.ErrorNotFound {
+		t.Errorf("unknown process: %s", errPayload.Code)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"log"
+	"slices"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/pty"
+)
+
+// ============================================================================
+// tmux Health Probe
+// ============================================================================
+
+// tmuxProbeLoop probes the tmux servers of hosts with clients every interval
+// until the server stops
+func (s *Server) tmuxProbeLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.probeTmux()
+		}
+	}
+}
+
+// probeTmux checks that the tmux session of every process on hosts with
+// clients still exists, with one list-sessions per host. Hosts nobody is
+// watching aren't queried.
+func (s *Server) probeTmux() {
+	for hostID := range s.hostsWithClients() {
+		s.probeHostTmux(hostID)
+	}
+}
+
+// probeHostTmux marks the processes on a host whose tmux session vanished as
+// exited. Each is pushed as process_updated, except when the whole tmux
+// server is gone: then the host's status is sent once, with a warning.
+func (s *Server) probeHostTmux(hostID string) {
+	conn := s.sshManager.GetConnection(hostID)
+	if conn == nil {
+		return
+	}
+
+	// Taken before listing, so a process created meanwhile isn't missing
+	var procs []*process.Process
+	for _, proc := range s.processRegistry.GetByHost(hostID) {
+		if proc.PTY != nil && !proc.HasExited() {
+			procs = append(procs, proc)
+		}
+	}
+	if len(procs) == 0 {
+		return
+	}
+
+	sessions, running, err := pty.ProbeTmuxSessions(conn.Client, s.hostTmux(hostID))
+	if err != nil {
+		log.Printf("[WARN] [TMUX] Failed to probe tmux sessions on host %s: %v", hostID, err)
+		return
+	}
+
+	if !running {
+		log.Printf("[WARN] [TMUX] tmux server on host %s is gone, marking its %d processes exited", hostID, len(procs))
+		for _, proc := range procs {
+			markProcessExited(proc)
+		}
+		s.publishTmuxServerGone(hostID)
+		return
+	}
+
+	for _, proc := range procs {
+		tmuxName := proc.PTY.TmuxName
+		if slices.ContainsFunc(sessions, func(info pty.TmuxSessionInfo) bool { return info.Name == tmuxName }) {
+			continue
+		}
+		log.Printf("[INFO] [TMUX] tmux session %s of process %s is gone, marking it exited", tmuxName, proc.ID)
+		markProcessExited(proc)
+		if err := s.notifyProcessUpdated(nil, proc); err != nil {
+			log.Printf("[WARN] [TMUX] Failed to send process update: %v", err)
+		}
+	}
+}
+
+// markProcessExited detaches a process whose tmux session is gone and marks
+// it exited. It stays registered so clients can see what happened; killing
+// it cleans up as usual.
+func markProcessExited(proc *process.Process) {
+	if err := proc.Detach(); err != nil {
+		log.Printf("[DEBUG] [TMUX] Error detaching exited process %s: %v", proc.ID, err)
+	}
+	proc.MarkExited()
+}
+
+// publishTmuxServerGone sends the status of a host whose tmux server is gone
+// to every session that has connected to or subscribed to it
+func (s *Server) publishTmuxServerGone(hostID string) {
+	procs := s.processRegistry.GetByHost(hostID)
+	processInfos := make([]protocol.ProcessInfo, 0, len(procs))
+	for _, proc := range procs {
+		processInfos = append(processInfos, proc.ToInfo())
+	}
+
+	var staleProcesses *[]protocol.StaleProcess
+	if stale := s.processRegistry.GetStaleProcesses(hostID); len(stale) > 0 {
+		staleProcesses = &stale
+	}
+	var channels *protocol.SSHChannelUsage
+	if conn := s.sshManager.GetConnection(hostID); conn != nil {
+		channels = channelUsage(conn)
+	}
+
+	status := protocol.HostStatusPayload{
+		HostID:         hostID,
+		Connected:      true,
+		Processes:      processInfos,
+		StaleProcesses: staleProcesses,
+		Warnings: []protocol.HostWarning{{
+			Code:    protocol.HostWarningTmuxServerGone,
+			Message: "The tmux server on the host is gone; its processes have exited",
+		}},
+		Channels: channels,
+	}
+
+	for _, sess := range s.sessionManager.GetConnectedSessions() {
+		if !sess.IsSubscribedToProcesses(hostID) && !slices.Contains(s.sessionManager.GetSessionHostConnections(sess.ID), hostID) {
+			continue
+		}
+		target := &ConnectedSession{Session: sess, server: s}
+		if err := target.sendScopedHostStatus(status); err != nil {
+			log.Printf("[WARN] [TMUX] Failed to send host status to session %s: %v", sess.ID, err)
+		}
+	}
+}
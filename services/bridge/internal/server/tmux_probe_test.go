@@ -0,0 +1,108 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// fakeTmuxSessions makes the fake tmux server list the given sessions, and
+// returns the file to remove to make it look gone
+func fakeTmuxSessions(t *testing.T, names ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sessions")
+	if err := os.WriteFile(path, []byte(strings.Join(names, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAKE_TMUX_SESSIONS", path)
+	return path
+}
+
+func TestTmuxProbeMarksVanishedSessions(t *testing.T) {
+	fakeTmuxSessions(t, "rc-proc-0", "rc-proc-2")
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	other, otherCS := connectTestClient(t, s)
+	sessions := shellTestHost(t, s)
+	registerShells(t, s, 3)
+	for _, proc := range s.processRegistry.GetByHost("host-1") {
+		proc.SetPtyReady(true)
+	}
+
+	// Nobody is watching the host yet
+	s.probeTmux()
+	if got := atomic.LoadInt32(sessions); got != 0 {
+		t.Errorf("probe opened %d SSH sessions for a host without clients", got)
+	}
+
+	dispatch(t, s, cs, protocol.TypeProcessesSubscribe, protocol.ProcessesSubscribePayload{HostID: "host-1"})
+	readPayload(t, conn, protocol.TypeProcessListResult, nil)
+	s.sessionManager.AddHostConnection(otherCS.ID, "host-1")
+
+	s.probeTmux()
+	if got := atomic.LoadInt32(sessions); got != 1 {
+		t.Errorf("probe opened %d SSH sessions, want 1", got)
+	}
+	var update protocol.ProcessUpdatedPayload
+	readPayload(t, conn, protocol.TypeProcessUpdated, &update)
+	if update.ID != "proc-1" || !update.Exited || update.PtyReady {
+		t.Errorf("update = %+v, want proc-1 exited", update)
+	}
+	for _, id := range []string{"proc-0", "proc-2"} {
+		if s.processRegistry.Get(id).HasExited() {
+			t.Errorf("%s marked exited, but its session is listed", id)
+		}
+	}
+	// Pushed to subscribers only, like other process updates
+	expectNothingQueued(t, other, otherCS)
+
+	// An exited process isn't reported again
+	s.probeTmux()
+	expectNothingQueued(t, conn, cs)
+}
+
+func TestTmuxProbeServerGone(t *testing.T) {
+	os.Remove(fakeTmuxSessions(t))
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	other, otherCS := connectTestClient(t, s)
+	shellTestHost(t, s)
+	registerShells(t, s, 3)
+
+	dispatch(t, s, cs, protocol.TypeProcessesSubscribe, protocol.ProcessesSubscribePayload{HostID: "host-1"})
+	readPayload(t, conn, protocol.TypeProcessListResult, nil)
+	s.sessionManager.AddHostConnection(otherCS.ID, "host-1")
+
+	s.probeTmux()
+
+	// One host status instead of an update per process, to every client of
+	// the host
+	for _, c := range []struct {
+		conn *websocket.Conn
+		cs   *ConnectedSession
+	}{{conn, cs}, {other, otherCS}} {
+		var status protocol.HostStatusPayload
+		readPayload(t, c.conn, protocol.TypeHostStatus, &status)
+		if !status.Connected || len(status.Warnings) != 1 || status.Warnings[0].Code != protocol.HostWarningTmuxServerGone {
+			t.Errorf("status = %+v, want a tmux server warning", status)
+		}
+		if len(status.Processes) != 3 {
+			t.Fatalf("status lists %d processes, want 3", len(status.Processes))
+		}
+		for _, info := range status.Processes {
+			if !info.Exited || info.PtyReady {
+				t.Errorf("%s = %+v, want exited", info.ID, info)
+			}
+		}
+		expectNothingQueued(t, c.conn, c.cs)
+	}
+
+	// Nor is the host reported again
+	s.probeTmux()
+	expectNothingQueued(t, conn, cs)
+}
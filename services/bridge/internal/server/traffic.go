@@ -0,0 +1,27 @@
+package server
+
+import (
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+// TrafficRollup is the bridge-wide process traffic reported by /health. The
+// counters reset when the bridge restarts, at Since.
+type TrafficRollup struct {
+	Since string                           `json:"since"` // ISO timestamp
+	Total protocol.ProcessStats            `json:"total"`
+	Hosts map[string]protocol.ProcessStats `json:"hosts"` // Including processes since killed
+}
+
+func (s *Server) trafficRollup() TrafficRollup {
+	hosts, since := s.processRegistry.TrafficRollup()
+	rollup := TrafficRollup{Since: since.UTC().Format(time.RFC3339), Hosts: hosts}
+	for _, stats := range hosts {
+		rollup.Total.PtyOutputBytes += stats.PtyOutputBytes
+		rollup.Total.PtyInputBytes += stats.PtyInputBytes
+		rollup.Total.ChatEventBytes += stats.ChatEventBytes
+		rollup.Total.HistoryBytes += stats.HistoryBytes
+	}
+	return rollup
+}
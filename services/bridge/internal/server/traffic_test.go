@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+func TestProcessTrafficStats(t *testing.T) {
+	s := newQuietServer(t)
+	conn, cs := connectTestClient(t, s)
+	proc := &process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeShell}
+	s.processRegistry.Register(proc)
+
+	for _, chunk := range []string{"$ ", "ls\r\n", "README.md\r\n"} {
+		s.forwardPtyOutput(cs, proc, []byte(chunk))
+		readPayload(t, conn, protocol.TypePtyOutput, nil)
+	}
+
+	// Input is counted as received even when it can't be written
+	dispatch(t, s, cs, protocol.TypePtyInput, protocol.PtyInputPayload{ProcessID: "proc-1", Data: "ls\n"})
+	readPayload(t, conn, protocol.TypeError, nil)
+
+	dispatch(t, s, cs, protocol.TypeChatSubscribe, protocol.ChatSubscribePayload{HostID: "host-1", ProcessID: "proc-1"})
+	readPayload(t, conn, protocol.TypeChatSubscribeResult, nil)
+	event := messageUpdate(t, 1, "hello")
+	s.handleAgentAPIEvent("host-1", "proc-1", event)
+	readPayload(t, conn, protocol.TypeChatEvent, nil)
+
+	history := bytes.Repeat([]byte("x"), 3*minHistoryChunkSize)
+	if err := s.storage.AppendPtyOutput("proc-1", "host-1", history); err != nil {
+		t.Fatalf("AppendPtyOutput: %v", err)
+	}
+	dispatch(t, s, cs, protocol.TypePtyHistoryRequest, protocol.PtyHistoryRequestPayload{ProcessID: "proc-1", ChunkSize: intPtr(minHistoryChunkSize)})
+	readPayload(t, conn, protocol.TypePtyHistoryResponse, nil)
+	for i := 0; i < 3; i++ {
+		readPayload(t, conn, protocol.TypePtyHistoryChunk, nil)
+	}
+	readPayload(t, conn, protocol.TypePtyHistoryComplete, nil)
+
+	want := protocol.ProcessStats{
+		PtyOutputBytes: uint64(len("$ ls\r\nREADME.md\r\n")),
+		PtyInputBytes:  3,
+		ChatEventBytes: uint64(len(event.Data)),
+		HistoryBytes:   uint64(len(history)),
+	}
+
+	// Stats are only listed when asked for
+	var list protocol.ProcessListResultPayload
+	dispatch(t, s, cs, protocol.TypeProcessList, protocol.ProcessListPayload{HostID: "host-1", ListLight: true})
+	readPayload(t, conn, protocol.TypeProcessListResult, &list)
+	if len(list.Processes) != 1 || list.Processes[0].Stats != nil {
+		t.Errorf("list without stats = %+v", list.Processes)
+	}
+	dispatch(t, s, cs, protocol.TypeProcessList, protocol.ProcessListPayload{HostID: "host-1", ListLight: true, IncludeStats: true})
+	readPayload(t, conn, protocol.TypeProcessListResult, &list)
+	if len(list.Processes) != 1 || list.Processes[0].Stats == nil || *list.Processes[0].Stats != want {
+		t.Fatalf("stats = %+v, want %+v", list.Processes[0].Stats, want)
+	}
+
+	// The rollup outlives the process
+	s.processRegistry.Unregister("proc-1")
+	rec := httptest.NewRecorder()
+	s.handleHealth(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	var health struct {
+		Traffic TrafficRollup `json:"traffic"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+		t.Fatalf("decode /health: %v", err)
+	}
+	if health.Traffic.Since == "" || health.Traffic.Total != want || health.Traffic.Hosts["host-1"] != want {
+		t.Errorf("rollup = %+v, want %+v", health.Traffic, want)
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/update"
+)
+
+// ============================================================================
+// Update Check
+// ============================================================================
+
+// updateCheckInterval is how often the release manifest is fetched after
+// the check at startup
+var updateCheckInterval = 24 * time.Hour
+
+// updateCheckLoop checks for a newer release now and then daily, until the
+// server stops
+func (s *Server) updateCheckLoop() {
+	s.checkForUpdate()
+
+	ticker := time.NewTicker(updateCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.checkForUpdate()
+		}
+	}
+}
+
+// checkForUpdate fetches the release manifest. The first time it finds a
+// given newer release, that is logged and pushed to every session.
+func (s *Server) checkForUpdate() update.Status {
+	status := s.updates.Check(context.Background())
+	if status.Err != nil {
+		log.Printf("[WARN] [UPDATE] Update check failed: %v", status.Err)
+		return status
+	}
+	if !status.Available {
+		log.Printf("[DEBUG] [UPDATE] Bridge %s is up to date (latest release %s)", status.Current, status.Latest.Version)
+		return status
+	}
+
+	s.updateMu.Lock()
+	pushed := s.updatePushed == status.Latest.Version
+	s.updatePushed = status.Latest.Version
+	s.updateMu.Unlock()
+	if pushed {
+		return status
+	}
+
+	if status.Latest.URL != "" {
+		log.Printf("[INFO] [UPDATE] Bridge %s is available (running %s): %s", status.Latest.Version, status.Current, status.Latest.URL)
+	} else {
+		log.Printf("[INFO] [UPDATE] Bridge %s is available (running %s)", status.Latest.Version, status.Current)
+	}
+	if v := status.Latest.ProtocolVersion; v != 0 && v != protocol.ProtocolVersion {
+		log.Printf("[INFO] [UPDATE] Bridge %s speaks protocol version %d; this bridge speaks %d", status.Latest.Version, v, protocol.ProtocolVersion)
+	}
+	msg, err := protocol.NewMessage(protocol.TypeBridgeUpdateAvailable, toUpdateStatusPayload(status))
+	if err != nil {
+		log.Printf("[ERROR] [UPDATE] Failed to create update message: %v", err)
+		return status
+	}
+	s.broadcast(msg)
+	return status
+}
+
+// handleBridgeUpdateCheck checks for a newer release right away and answers
+// with what was found
+func (s *Server) handleBridgeUpdateCheck(connSession *ConnectedSession, msg *protocol.Message) error {
+	log.Printf("[DEBUG] [UPDATE] Update check requested by session %s", connSession.ID)
+
+	if s.updates == nil {
+		response, err := protocol.NewMessage(protocol.TypeBridgeUpdateCheckResult, protocol.BridgeUpdateStatusPayload{
+			CurrentVersion: s.config.Build.Version,
+			Error:          strPtr("update checks are off; start the bridge with --update-check"),
+		})
+		if err != nil {
+			return err
+		}
+		return connSession.Send(response)
+	}
+
+	go func() {
+		response, err := protocol.NewMessage(protocol.TypeBridgeUpdateCheckResult, toUpdateStatusPayload(s.checkForUpdate()))
+		if err == nil {
+			err = connSession.Send(response)
+		}
+		if err != nil {
+			log.Printf("[ERROR] [UPDATE] Failed to send update check result to session %s: %v", connSession.ID, err)
+		}
+	}()
+	return nil
+}
+
+// toUpdateStatusPayload converts an update check's status to its protocol
+// form
+func toUpdateStatusPayload(status update.Status) *protocol.BridgeUpdateStatusPayload {
+	payload := &protocol.BridgeUpdateStatusPayload{
+		CurrentVersion: status.Current,
+		Available:      status.Available,
+	}
+	if latest := status.Latest; latest != nil {
+		payload.LatestVersion = strPtr(latest.Version)
+		if latest.URL != "" {
+			payload.ReleaseURL = strPtr(latest.URL)
+		}
+		if latest.Notes != "" {
+			payload.Notes = strPtr(latest.Notes)
+		}
+		if latest.ProtocolVersion != 0 {
+			payload.LatestProtocolVersion = &latest.ProtocolVersion
+		}
+	}
+	if !status.CheckedAt.IsZero() {
+		payload.CheckedAt = strPtr(status.CheckedAt.UTC().Format(time.RFC3339))
+	}
+	if status.Err != nil {
+		payload.Error = strPtr(status.Err.Error())
+	}
+	return payload
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/update"
+)
+
+// releaseManifest serves a release manifest over TLS and installs a checker
+// of it as s's, for a bridge running 1.4.2. It returns a function setting
+// the manifest served.
+func releaseManifest(t *testing.T, s *Server) func(body string) {
+	t.Helper()
+	var mu sync.Mutex
+	manifest := `{"version": "1.4.2"}`
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write([]byte(manifest))
+	}))
+	t.Cleanup(srv.Close)
+
+	checker, err := update.NewChecker(srv.URL+"/manifest.json", "1.4.2", srv.Client().Transport)
+	if err != nil {
+		t.Fatalf("NewChecker: %v", err)
+	}
+	s.updates = checker
+	return func(body string) {
+		mu.Lock()
+		defer mu.Unlock()
+		manifest = body
+	}
+}
+
+func TestBridgeUpdateCheck(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+
+	// Off by default
+	dispatch(t, s, cs, protocol.TypeBridgeUpdateCheck, struct{}{})
+	var result protocol.BridgeUpdateStatusPayload
+	readPayload(t, conn, protocol.TypeBridgeUpdateCheckResult, &result)
+	if result.Error == nil || result.Available {
+		t.Errorf("result with checks off = %+v", result)
+	}
+	if s.bridgeInfo().Update != nil {
+		t.Error("bridge_info reports an update status with checks off")
+	}
+
+	setManifest := releaseManifest(t, s)
+	dispatch(t, s, cs, protocol.TypeBridgeUpdateCheck, struct{}{})
+	result = protocol.BridgeUpdateStatusPayload{}
+	readPayload(t, conn, protocol.TypeBridgeUpdateCheckResult, &result)
+	if result.Error != nil || result.Available || result.LatestVersion == nil || *result.LatestVersion != "1.4.2" || result.CheckedAt == nil {
+		t.Errorf("result when up to date = %+v", result)
+	}
+
+	// A newer release is pushed to every session, once
+	setManifest(`{"version": "1.5.0", "url": "https://example.com/releases/1.5.0", "protocolVersion": 2}`)
+	dispatch(t, s, cs, protocol.TypeBridgeUpdateCheck, struct{}{})
+	var pushed protocol.BridgeUpdateStatusPayload
+	readPayload(t, conn, protocol.TypeBridgeUpdateAvailable, &pushed)
+	if !pushed.Available || *pushed.LatestVersion != "1.5.0" || pushed.CurrentVersion != "1.4.2" ||
+		pushed.ReleaseURL == nil || *pushed.ReleaseURL != "https://example.com/releases/1.5.0" ||
+		pushed.LatestProtocolVersion == nil || *pushed.LatestProtocolVersion != 2 {
+		t.Errorf("pushed = %+v", pushed)
+	}
+	readPayload(t, conn, protocol.TypeBridgeUpdateCheckResult, &result)
+
+	dispatch(t, s, cs, protocol.TypeBridgeUpdateCheck, struct{}{})
+	result = protocol.BridgeUpdateStatusPayload{}
+	readPayload(t, conn, protocol.TypeBridgeUpdateCheckResult, &result)
+	if !result.Available {
+		t.Errorf("result = %+v", result)
+	}
+	expectNothingQueued(t, conn, cs)
+
+	// A failed check keeps the release found before
+	setManifest("<html>garbage</html>")
+	dispatch(t, s, cs, protocol.TypeBridgeUpdateCheck, struct{}{})
+	result = protocol.BridgeUpdateStatusPayload{}
+	readPayload(t, conn, protocol.TypeBridgeUpdateCheckResult, &result)
+	if result.Error == nil || !result.Available || *result.LatestVersion != "1.5.0" {
+		t.Errorf("result after a garbage manifest = %+v", result)
+	}
+	if info := s.bridgeInfo().Update; info == nil || !info.Available || info.Error == nil {
+		t.Errorf("bridge_info update = %+v", info)
+	}
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// ============================================================================
+// Workspace Handlers
+// ============================================================================
+
+// toProtocolWorkspace converts a stored workspace to its protocol form
+func toProtocolWorkspace(ws *storage.Workspace) *protocol.Workspace {
+	processIDs := ws.ProcessIDs
+	if processIDs == nil {
+		processIDs = []string{}
+	}
+	return &protocol.Workspace{
+		ID:         ws.ID,
+		Name:       ws.Name,
+		ProcessIDs: processIDs,
+		CreatedAt:  ws.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:  ws.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// handleWorkspaceList returns all workspaces with their process assignments
+func (s *Server) handleWorkspaceList(connSession *ConnectedSession, msg *protocol.Message) error {
+	log.Printf("[DEBUG] [WORKSPACE] Listing all workspaces")
+
+	workspaces, err := s.storage.ListWorkspaces()
+	if err != nil {
+		log.Printf("[ERROR] [WORKSPACE] Failed to list workspaces: %v", err)
+		return connSession.SendErrorDetails(protocol.ErrorStorageError, protocol.ErrorDetails{"reason": err.Error()})
+	}
+
+	protoWorkspaces := make([]protocol.Workspace, len(workspaces))
+	for i := range workspaces {
+		protoWorkspaces[i] = *toProtocolWorkspace(&workspaces[i])
+	}
+
+	response, err := protocol.NewMessage(protocol.TypeWorkspaceListResult, protocol.WorkspaceListResultPayload{
+		Workspaces: protoWorkspaces,
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [WORKSPACE] Returning %d workspaces", len(protoWorkspaces))
+	return connSession.Send(response)
+}
+
+func (s *Server) sendWorkspaceCreateResult(connSession *ConnectedSession, ws *storage.Workspace, err error) error {
+	payload := protocol.WorkspaceCreateResultPayload{Success: err == nil}
+	if err != nil {
+		payload.Code = failureCode(err, protocol.ErrorStorageError)
+		payload.Error = strPtr(err.Error())
+	} else {
+		payload.Workspace = toProtocolWorkspace(ws)
+	}
+	msg, _ := protocol.NewMessage(protocol.TypeWorkspaceCreateResult, payload)
+	return connSession.Send(msg)
+}
+
+// handleWorkspaceCreate creates a new, empty workspace
+func (s *Server) handleWorkspaceCreate(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.WorkspaceCreatePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [WORKSPACE] Creating workspace: %s", payload.Name)
+
+	if payload.Name == "" {
+		return s.sendWorkspaceCreateResult(connSession, nil, newFailure(protocol.ErrorInvalidArgs, "workspace name is required"))
+	}
+
+	ws := storage.Workspace{
+		ID:   uuid.New().String(),
+		Name: payload.Name,
+	}
+	if err := s.storage.CreateWorkspace(ws); err != nil {
+		log.Printf("[ERROR] [WORKSPACE] Failed to create workspace: %v", err)
+		return s.sendWorkspaceCreateResult(connSession, nil, err)
+	}
+
+	created, err := s.storage.GetWorkspace(ws.ID)
+	if err != nil || created == nil {
+		log.Printf("[ERROR] [WORKSPACE] Failed to get created workspace: %v", err)
+		return s.sendWorkspaceCreateResult(connSession, nil, fmt.Errorf("workspace created but failed to retrieve"))
+	}
+
+	log.Printf("[INFO] [WORKSPACE] Created workspace %s (%s)", created.ID, created.Name)
+	return s.sendWorkspaceCreateResult(connSession, created, nil)
+}
+
+func (s *Server) sendWorkspaceUpdateResult(connSession *ConnectedSession, ws *storage.Workspace, err error) error {
+	payload := protocol.WorkspaceUpdateResultPayload{Success: err == nil}
+	if err != nil {
+		payload.Code = failureCode(err, protocol.ErrorStorageError)
+		payload.Error = strPtr(err.Error())
+	} else {
+		payload.Workspace = toProtocolWorkspace(ws)
+	}
+	msg, _ := protocol.NewMessage(protocol.TypeWorkspaceUpdateResult, payload)
+	return connSession.Send(msg)
+}
+
+// handleWorkspaceUpdate renames a workspace
+func (s *Server) handleWorkspaceUpdate(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.WorkspaceUpdatePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [WORKSPACE] Updating workspace: %s", payload.ID)
+
+	existing, err := s.storage.GetWorkspace(payload.ID)
+	if err != nil {
+		log.Printf("[ERROR] [WORKSPACE] Failed to get workspace: %v", err)
+		return s.sendWorkspaceUpdateResult(connSession, nil, err)
+	}
+	if existing == nil {
+		return s.sendWorkspaceUpdateResult(connSession, nil, newFailure(protocol.ErrorNotFound, "workspace not found"))
+	}
+
+	if payload.Name != nil {
+		if *payload.Name == "" {
+			return s.sendWorkspaceUpdateResult(connSession, nil, newFailure(protocol.ErrorInvalidArgs, "workspace name is required"))
+		}
+		existing.Name = *payload.Name
+	}
+
+	if err := s.storage.UpdateWorkspace(*existing); err != nil {
+		log.Printf("[ERROR] [WORKSPACE] Failed to update workspace: %v", err)
+		return s.sendWorkspaceUpdateResult(connSession, nil, err)
+	}
+
+	updated, err := s.storage.GetWorkspace(payload.ID)
+	if err != nil || updated == nil {
+		log.Printf("[ERROR] [WORKSPACE] Failed to get updated workspace: %v", err)
+		return s.sendWorkspaceUpdateResult(connSession, nil, fmt.Errorf("workspace updated but failed to retrieve"))
+	}
+
+	log.Printf("[INFO] [WORKSPACE] Updated workspace %s (%s)", updated.ID, updated.Name)
+	return s.sendWorkspaceUpdateResult(connSession, updated, nil)
+}
+
+func (s *Server) sendWorkspaceDeleteResult(connSession *ConnectedSession, id string, err error) error {
+	payload := protocol.WorkspaceDeleteResultPayload{Success: err == nil}
+	if err != nil {
+		payload.Code = failureCode(err, protocol.ErrorStorageError)
+		payload.Error = strPtr(err.Error())
+	} else {
+		payload.ID = &id
+	}
+	msg, _ := protocol.NewMessage(protocol.TypeWorkspaceDeleteResult, payload)
+	return connSession.Send(msg)
+}
+
+// handleWorkspaceDelete deletes a workspace and unassigns its processes.
+// The processes keep running; they just no longer belong to a workspace.
+func (s *Server) handleWorkspaceDelete(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.WorkspaceDeletePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] [WORKSPACE] Deleting workspace: %s", payload.ID)
+
+	existing, err := s.storage.GetWorkspace(payload.ID)
+	if err != nil {
+		log.Printf("[ERROR] [WORKSPACE] Failed to get workspace: %v", err)
+		return s.sendWorkspaceDeleteResult(connSession, "", err)
+	}
+	if existing == nil {
+		return s.sendWorkspaceDeleteResult(connSession, "", newFailure(protocol.ErrorNotFound, "workspace not found"))
+	}
+
+	if err := s.storage.DeleteWorkspace(payload.ID); err != nil {
+		log.Printf("[ERROR] [WORKSPACE] Failed to delete workspace: %v", err)
+		return s.sendWorkspaceDeleteResult(connSession, "", err)
+	}
+
+	// Clear the in-memory assignment of any live processes, telling every
+	// client watching their hosts
+	for _, processID := range existing.ProcessIDs {
+		if proc := s.processRegistry.Get(processID); proc != nil {
+			proc.SetWorkspaceID("")
+			if err := s.notifyProcessUpdated(connSession, proc); err != nil {
+				log.Printf("[ERROR] [WORKSPACE] Failed to send process_updated for %s: %v", processID, err)
+			}
+		}
+	}
+
+	log.Printf("[INFO] [WORKSPACE] Deleted workspace %s (%d processes unassigned)", payload.ID, len(existing.ProcessIDs))
+	return s.sendWorkspaceDeleteResult(connSession, payload.ID, nil)
+}
+
+func (s *Server) sendWorkspaceAssignResult(connSession *ConnectedSession, processID string, workspaceID *string, err error) error {
+	payload := protocol.WorkspaceAssignResultPayload{
+		Success:   err == nil,
+		ProcessID: processID,
+	}
+	if err != nil {
+		payload.Code = failureCode(err, protocol.ErrorStorageError)
+		payload.Error = strPtr(err.Error())
+	} else {
+		payload.WorkspaceID = workspaceID
+	}
+	msg, _ := protocol.NewMessage(protocol.TypeWorkspaceAssignResult, payload)
+	return connSession.Send(msg)
+}
+
+// handleWorkspaceAssign moves a process into a workspace (or out of any, when workspaceId is null).
+// The assignment is persisted separately from the in-memory process so it survives
+// reattach and bridge restarts.
+func (s *Server) handleWorkspaceAssign(connSession *ConnectedSession, msg *protocol.Message) error {
+	var payload protocol.WorkspaceAssignPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return err
+	}
+
+	workspaceID := ""
+	if payload.WorkspaceID != nil {
+		workspaceID = *payload.WorkspaceID
+	}
+	log.Printf("[DEBUG] [WORKSPACE] Assign process %s to workspace %q", payload.ProcessID, workspaceID)
+
+	// Process may be live in the registry or detached (known only from metadata)
+	proc := s.processRegistry.Get(payload.ProcessID)
+	if proc == nil {
+		meta, err := s.storage.GetProcessMetadata(payload.ProcessID)
+		if err != nil {
+			return s.sendWorkspaceAssignResult(connSession, payload.ProcessID, nil, err)
+		}
+		if meta == nil {
+			return s.sendWorkspaceAssignResult(connSession, payload.ProcessID, nil, newFailure(protocol.ErrorNotFound, "process not found"))
+		}
+	}
+
+	if workspaceID != "" {
+		ws, err := s.storage.GetWorkspace(workspaceID)
+		if err != nil {
+			return s.sendWorkspaceAssignResult(connSession, payload.ProcessID, nil, err)
+		}
+		if ws == nil {
+			return s.sendWorkspaceAssignResult(connSession, payload.ProcessID, nil, newFailure(protocol.ErrorNotFound, "workspace not found"))
+		}
+	}
+
+	if err := s.storage.SetProcessWorkspace(payload.ProcessID, workspaceID); err != nil {
+		log.Printf("[ERROR] [WORKSPACE] Failed to assign process %s: %v", payload.ProcessID, err)
+		return s.sendWorkspaceAssignResult(connSession, payload.ProcessID, nil, err)
+	}
+
+	if err := s.sendWorkspaceAssignResult(connSession, payload.ProcessID, nilIfEmpty(workspaceID), nil); err != nil {
+		return err
+	}
+
+	// The requester and every other client watching the host
+	if proc == nil {
+		return nil
+	}
+	proc.SetWorkspaceID(workspaceID)
+	return s.notifyProcessUpdated(connSession, proc)
+}
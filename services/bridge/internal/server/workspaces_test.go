@@ -0,0 +1,87 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/process"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/protocol"
+)
+
+func TestWorkspaceAssignHandler(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+	watcher, watcherCS := connectTestClient(t, s)
+
+	proc := &process.Process{ID: "proc-1", HostID: "host-1", Type: process.TypeShell}
+	s.processRegistry.Register(proc)
+	dispatch(t, s, watcherCS, protocol.TypeProcessesSubscribe, protocol.ProcessesSubscribePayload{HostID: "host-1"})
+	readPayload(t, watcher, protocol.TypeProcessListResult, nil)
+
+	dispatch(t, s, cs, protocol.TypeWorkspaceCreate, protocol.WorkspaceCreatePayload{Name: "project"})
+	var created protocol.WorkspaceCreateResultPayload
+	readPayload(t, conn, protocol.TypeWorkspaceCreateResult, &created)
+	if !created.Success || created.Workspace == nil {
+		t.Fatalf("create failed: %+v", created)
+	}
+	wsID := created.Workspace.ID
+
+	dispatch(t, s, cs, protocol.TypeWorkspaceAssign, protocol.WorkspaceAssignPayload{ProcessID: "proc-1", WorkspaceID: &wsID})
+	var assigned protocol.WorkspaceAssignResultPayload
+	readPayload(t, conn, protocol.TypeWorkspaceAssignResult, &assigned)
+	if !assigned.Success {
+		t.Fatalf("assign failed: %+v", assigned)
+	}
+	var updated protocol.ProcessUpdatedPayload
+	readPayload(t, conn, protocol.TypeProcessUpdated, &updated)
+	if updated.WorkspaceID == nil || *updated.WorkspaceID != wsID {
+		t.Errorf("process_updated workspaceId = %v, want %s", updated.WorkspaceID, wsID)
+	}
+	// Other clients watching the host see the move too
+	var pushed protocol.ProcessUpdatedPayload
+	readPayload(t, watcher, protocol.TypeProcessUpdated, &pushed)
+	if pushed.WorkspaceID == nil || *pushed.WorkspaceID != wsID {
+		t.Errorf("pushed process_updated workspaceId = %v, want %s", pushed.WorkspaceID, wsID)
+	}
+	if info := proc.ToInfo(); info.WorkspaceID == nil || *info.WorkspaceID != wsID {
+		t.Errorf("ProcessInfo.workspaceId = %v, want %s", info.WorkspaceID, wsID)
+	}
+
+	// Unknown workspace is rejected without touching the assignment
+	bogus := "missing"
+	dispatch(t, s, cs, protocol.TypeWorkspaceAssign, protocol.WorkspaceAssignPayload{ProcessID: "proc-1", WorkspaceID: &bogus})
+	var rejected protocol.WorkspaceAssignResultPayload
+	readPayload(t, conn, protocol.TypeWorkspaceAssignResult, &rejected)
+	if rejected.Success {
+		t.Errorf("expected assign to unknown workspace to fail")
+	}
+
+	// Deleting the workspace unassigns the live process
+	dispatch(t, s, cs, protocol.TypeWorkspaceDelete, protocol.WorkspaceDeletePayload{ID: wsID})
+	var cleared protocol.ProcessUpdatedPayload
+	readPayload(t, conn, protocol.TypeProcessUpdated, &cleared)
+	if cleared.WorkspaceID != nil {
+		t.Errorf("expected workspaceId cleared, got %v", *cleared.WorkspaceID)
+	}
+	pushed = protocol.ProcessUpdatedPayload{}
+	readPayload(t, watcher, protocol.TypeProcessUpdated, &pushed)
+	if pushed.ID != "proc-1" || pushed.WorkspaceID != nil {
+		t.Errorf("pushed process_updated after delete = %+v", pushed)
+	}
+	var deleted protocol.WorkspaceDeleteResultPayload
+	readPayload(t, conn, protocol.TypeWorkspaceDeleteResult, &deleted)
+	if !deleted.Success {
+		t.Errorf("delete failed: %+v", deleted)
+	}
+}
+
+func TestWorkspaceAssignUnknownProcess(t *testing.T) {
+	s := newTestServer(t, DefaultConfig())
+	conn, cs := connectTestClient(t, s)
+
+	dispatch(t, s, cs, protocol.TypeWorkspaceAssign, protocol.WorkspaceAssignPayload{ProcessID: "ghost"})
+	var result protocol.WorkspaceAssignResultPayload
+	readPayload(t, conn, protocol.TypeWorkspaceAssignResult, &result)
+	if result.Success || result.Error == nil || result.Code != protocol.ErrorNotFound {
+		t.Errorf("expected a NOT_FOUND failure for unknown process, got %+v", result)
+	}
+}
@@ -0,0 +1,30 @@
+package session
+
+import "time"
+
+// Clock tells the manager the time. Expiry is measured with Elapsed, which
+// is monotonic: stepping the wall clock (NTP catching up on a small VPS, or
+// an admin fixing the date) must not expire sessions early or keep them
+// forever. Now is only for timestamps shown to clients or persisted.
+type Clock interface {
+	Now() time.Time
+	Elapsed() time.Duration // Monotonic time since the clock was created
+}
+
+// systemClock is the real clock
+type systemClock struct {
+	start time.Time
+}
+
+func newSystemClock() *systemClock {
+	return &systemClock{start: time.Now()}
+}
+
+func (c *systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c *systemClock) Elapsed() time.Duration {
+	// start carries a monotonic reading, so this ignores wall clock steps
+	return time.Since(c.start)
+}
@@ -0,0 +1,141 @@
+package session
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// fakeClock is a clock whose wall time can be stepped independently of the
+// monotonic time that passes
+type fakeClock struct {
+	mu      sync.Mutex
+	wall    time.Time
+	elapsed time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{wall: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.wall
+}
+
+func (c *fakeClock) Elapsed() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.elapsed
+}
+
+// advance lets d pass
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wall = c.wall.Add(d)
+	c.elapsed += d
+}
+
+// step moves the wall clock by d without time passing
+func (c *fakeClock) step(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wall = c.wall.Add(d)
+}
+
+func newClockManager(t *testing.T) (*Manager, *fakeClock) {
+	t.Helper()
+	m := NewManager()
+	t.Cleanup(m.Stop)
+	clock := newFakeClock()
+	m.clock = clock
+	m.SessionTimeout = 5 * time.Minute
+	m.ReconnectTimeout = 2 * time.Minute
+	return m, clock
+}
+
+func TestExpiryIgnoresClockSteps(t *testing.T) {
+	m, clock := newClockManager(t)
+	session := m.CreateSession(nil)
+	m.MarkDisconnected(session.ID)
+
+	// The wall clock jumping backwards an hour doesn't extend the session
+	clock.step(-time.Hour)
+	clock.advance(4 * time.Minute)
+	m.cleanupExpiredSessions()
+	if m.GetSession(session.ID) == nil {
+		t.Fatal("session removed before its timeout")
+	}
+	clock.advance(2 * time.Minute)
+	m.cleanupExpiredSessions()
+	if m.GetSession(session.ID) != nil {
+		t.Error("session kept past its timeout after the clock stepped back")
+	}
+
+	// Nor does jumping forward a day expire one
+	session = m.CreateSession(nil)
+	m.MarkDisconnected(session.ID)
+	clock.step(24 * time.Hour)
+	m.cleanupExpiredSessions()
+	if m.GetSession(session.ID) == nil {
+		t.Error("session removed when the clock stepped forward")
+	}
+}
+
+func TestReconnectTimeoutIgnoresClockSteps(t *testing.T) {
+	m, clock := newClockManager(t)
+
+	session := m.CreateSession(nil)
+	m.MarkDisconnected(session.ID)
+	clock.step(time.Hour)
+	clock.advance(time.Minute)
+	if m.Reconnect(session.ReconnectToken, nil) != session {
+		t.Error("reconnect refused after the clock stepped forward")
+	}
+
+	session = m.CreateSession(nil)
+	m.MarkDisconnected(session.ID)
+	clock.step(-time.Hour)
+	clock.advance(3 * time.Minute)
+	if m.Reconnect(session.ReconnectToken, nil) != nil {
+		t.Error("reconnect allowed past its timeout after the clock stepped back")
+	}
+}
+
+// savedTokens is a TokenStore that keeps the last token saved
+type savedTokens struct {
+	last storage.SessionToken
+}
+
+func (s *savedTokens) SaveSessionToken(token storage.SessionToken) error {
+	s.last = token
+	return nil
+}
+
+func (s *savedTokens) TakeSessionToken(string) (*storage.SessionToken, error) { return nil, nil }
+func (s *savedTokens) DeleteSessionToken(string) error                        { return nil }
+func (s *savedTokens) DeleteExpiredSessionTokens() (int64, error)             { return 0, nil }
+
+func TestSavedTokenExpiryAfterClockStep(t *testing.T) {
+	m, clock := newClockManager(t)
+	store := &savedTokens{}
+	m.SetTokenStore(store)
+
+	// A minute into the reconnect window the clock steps back a day: the
+	// saved token expires when the rest of the window is up by the new clock
+	session := m.CreateSession(nil)
+	m.MarkDisconnected(session.ID)
+	clock.advance(time.Minute)
+	clock.step(-24 * time.Hour)
+	session.Lock()
+	m.saveToken(session)
+	session.Unlock()
+
+	if want := clock.Now().Add(time.Minute); !store.last.ExpiresAt.Equal(want) {
+		t.Errorf("token expires at %s, want %s", store.last.ExpiresAt, want)
+	}
+}
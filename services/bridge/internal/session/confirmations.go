@@ -0,0 +1,63 @@
+package session
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Errors returned by Confirm
+var (
+	ErrConfirmationUnknown  = errors.New("confirmation token is unknown or was already used")
+	ErrConfirmationExpired  = errors.New("confirmation token expired")
+	ErrConfirmationMismatch = errors.New("confirmation token was issued for another request")
+)
+
+// pendingConfirmation is a destructive request waiting to be confirmed
+type pendingConfirmation struct {
+	action  string // Message type of the request
+	target  string // What it destroys, such as a process ID
+	expires time.Time
+}
+
+// IssueConfirmation returns a token that confirms action on target once,
+// within ttl. Tokens live in memory on the session only.
+func (s *Session) IssueConfirmation(action, target string, ttl time.Duration) string {
+	s.confirmMu.Lock()
+	defer s.confirmMu.Unlock()
+
+	now := time.Now()
+	for token, pending := range s.confirmations {
+		if now.After(pending.expires) {
+			delete(s.confirmations, token)
+		}
+	}
+	if s.confirmations == nil {
+		s.confirmations = make(map[string]pendingConfirmation)
+	}
+	token := uuid.NewString()
+	s.confirmations[token] = pendingConfirmation{action: action, target: target, expires: now.Add(ttl)}
+	return token
+}
+
+// Confirm uses up a token from IssueConfirmation, and returns nil if it was
+// issued for action on target and hasn't expired. A token is gone after one
+// call, whatever the outcome.
+func (s *Session) Confirm(token, action, target string) error {
+	s.confirmMu.Lock()
+	defer s.confirmMu.Unlock()
+
+	pending, ok := s.confirmations[token]
+	if !ok {
+		return ErrConfirmationUnknown
+	}
+	delete(s.confirmations, token)
+	if time.Now().After(pending.expires) {
+		return ErrConfirmationExpired
+	}
+	if pending.action != action || pending.target != target {
+		return ErrConfirmationMismatch
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConfirmations(t *testing.T) {
+	s := &Session{ID: "s-1"}
+
+	token := s.IssueConfirmation("process_kill", "proc-1", time.Minute)
+	if err := s.Confirm(token, "process_kill", "proc-1"); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	if err := s.Confirm(token, "process_kill", "proc-1"); !errors.Is(err, ErrConfirmationUnknown) {
+		t.Errorf("reused token: %v, want ErrConfirmationUnknown", err)
+	}
+
+	// A token only confirms what it was issued for, and a failed attempt
+	// uses it up too
+	token = s.IssueConfirmation("process_kill", "proc-1", time.Minute)
+	if err := s.Confirm(token, "process_kill", "proc-2"); !errors.Is(err, ErrConfirmationMismatch) {
+		t.Errorf("other target: %v, want ErrConfirmationMismatch", err)
+	}
+	if err := s.Confirm(token, "process_kill", "proc-1"); !errors.Is(err, ErrConfirmationUnknown) {
+		t.Errorf("after a mismatch: %v, want ErrConfirmationUnknown", err)
+	}
+
+	token = s.IssueConfirmation("process_kill", "proc-1", -time.Second)
+	if err := s.Confirm(token, "process_kill", "proc-1"); !errors.Is(err, ErrConfirmationExpired) {
+		t.Errorf("expired token: %v, want ErrConfirmationExpired", err)
+	}
+
+	// Expired tokens are dropped as new ones are issued
+	s.IssueConfirmation("process_kill", "proc-1", -time.Second)
+	s.IssueConfirmation("process_kill", "proc-1", time.Minute)
+	if len(s.confirmations) != 1 {
+		t.Errorf("%d tokens pending, want 1", len(s.confirmations))
+	}
+}
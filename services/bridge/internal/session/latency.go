@@ -0,0 +1,78 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// rttWeight is how far each round trip moves a session's smoothed RTT, the
+// gain TCP uses for its own (RFC 6298)
+const rttWeight = 0.125
+
+// RTTStats is the round-trip time between the bridge and a session's
+// client, measured by the WebSocket pings the bridge sends. The zero value
+// means no pong has come back yet.
+type RTTStats struct {
+	Smoothed time.Duration // Moving average of the round trips
+	Last     time.Duration
+	Min      time.Duration
+	Samples  int
+	LastAt   time.Time // When the last pong came back
+}
+
+// rttEstimator keeps a session's RTTStats
+type rttEstimator struct {
+	mu    sync.Mutex
+	stats RTTStats
+}
+
+// observe adds a round trip to the estimate. The first one seeds it, so it
+// doesn't take a dozen pings to climb from 0.
+func (e *rttEstimator) observe(rtt time.Duration, at time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stats.Samples == 0 {
+		e.stats.Smoothed = rtt
+		e.stats.Min = rtt
+	} else {
+		e.stats.Smoothed += time.Duration(rttWeight * float64(rtt-e.stats.Smoothed))
+		e.stats.Min = min(e.stats.Min, rtt)
+	}
+	e.stats.Last = rtt
+	e.stats.LastAt = at
+	e.stats.Samples++
+}
+
+func (e *rttEstimator) get() RTTStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stats
+}
+
+func (e *rttEstimator) reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stats = RTTStats{}
+}
+
+// RecordRTT adds the round trip of a ping answered at the given time
+func (s *Session) RecordRTT(rtt time.Duration, at time.Time) {
+	s.rtt.observe(rtt, at)
+}
+
+// RTT returns the session's round-trip time estimate
+func (s *Session) RTT() RTTStats {
+	return s.rtt.get()
+}
+
+// SetServerTimestamps records whether the client asked for the bridge's
+// clock on pty_output and chat_event, with the server_timestamps capability
+func (s *Session) SetServerTimestamps(enabled bool) {
+	s.serverTimestamps.Store(enabled)
+}
+
+// ServerTimestamps reports whether pty_output and chat_event sent to the
+// session carry the bridge's clock
+func (s *Session) ServerTimestamps() bool {
+	return s.serverTimestamps.Load()
+}
@@ -0,0 +1,52 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTEstimate(t *testing.T) {
+	s := &Session{}
+	if stats := s.RTT(); stats != (RTTStats{}) {
+		t.Fatalf("stats before any pong = %+v", stats)
+	}
+
+	at := time.Unix(1700000000, 0)
+	s.RecordRTT(80*time.Millisecond, at)
+	if stats := s.RTT(); stats.Smoothed != 80*time.Millisecond || stats.Min != 80*time.Millisecond || stats.Samples != 1 {
+		t.Errorf("after the first pong: %+v, want it to seed the estimate", stats)
+	}
+
+	// A spike moves the average an eighth of the way
+	s.RecordRTT(240*time.Millisecond, at.Add(time.Second))
+	stats := s.RTT()
+	if stats.Smoothed != 100*time.Millisecond || stats.Last != 240*time.Millisecond || stats.Min != 80*time.Millisecond {
+		t.Errorf("after a spike: %+v, want smoothed 100ms", stats)
+	}
+	if !stats.LastAt.Equal(at.Add(time.Second)) || stats.Samples != 2 {
+		t.Errorf("after a spike: %+v", stats)
+	}
+
+	// A steady RTT wins the average over
+	for i := 0; i < 50; i++ {
+		s.RecordRTT(20*time.Millisecond, at.Add(time.Duration(i+2)*time.Second))
+	}
+	stats = s.RTT()
+	if stats.Smoothed < 20*time.Millisecond || stats.Smoothed > 21*time.Millisecond || stats.Min != 20*time.Millisecond {
+		t.Errorf("after a steady 20ms: %+v", stats)
+	}
+}
+
+func TestReconnectResetsRTT(t *testing.T) {
+	m, _ := newClockManager(t)
+	session := m.CreateSession(nil)
+	session.RecordRTT(300*time.Millisecond, time.Now())
+	m.MarkDisconnected(session.ID)
+
+	if m.Reconnect(session.ReconnectToken, nil) != session {
+		t.Fatal("reconnect refused")
+	}
+	if stats := session.RTT(); stats.Samples != 0 {
+		t.Errorf("stats after reconnecting = %+v, want the old connection's dropped", stats)
+	}
+}
@@ -3,6 +3,7 @@ package session
 import (
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -23,21 +24,99 @@ type Session struct {
 	ID         string
 	Conn       *websocket.Conn
 	mu         sync.Mutex
+	waiting    atomic.Int32 // Lock callers blocked on mu
 	State      SessionState
 	CreatedAt  time.Time
 	LastSeenAt time.Time
 
+	// Compression is true when the client opted into permessage-deflate
+	// during auth; guarded by mu like Conn
+	Compression bool
+
+	// Locale is the client's language from auth (e.g. "he-IL"), which error
+	// messages are sent in where the catalog has it; guarded by mu
+	Locale string
+
+	// DeviceLabel names the client's device, as declared at auth; guarded
+	// by mu
+	DeviceLabel string
+
 	// Host connections owned by this session
 	HostConnections map[string]bool // hostID -> connected
 
+	// Chat event subscriptions: hostID -> processID (or AllProcesses), and
+	// hosts whose process state is pushed; both guarded by subsMu
+	chatSubs    map[string]map[string]bool
+	processSubs map[string]bool
+	subsMu      sync.RWMutex
+
+	// The client's view (see view.go), guarded by viewMu
+	selected        map[string]string   // hostID -> processID
+	termSizes       map[string]TermSize // processID -> size
+	defaultTermSize TermSize            // declared at auth; 0 where not
+	chatDelivered   map[string]int      // processID -> newest message ID sent
+	viewMu          sync.Mutex
+
+	// What the client may do (see roles.go), granted at auth; guarded by
+	// roleMu
+	role     Role
+	scope    Scope
+	inviteID string
+	roleMu   sync.Mutex
+
+	// host_exec commands running for the session
+	execs atomic.Int32
+
+	// Round trips of the bridge's pings (see latency.go), measured anew on
+	// every connection, and whether the client asked for server timestamps
+	rtt              rttEstimator
+	serverTimestamps atomic.Bool
+
+	// Destructive requests waiting to be confirmed, by token; guarded by
+	// confirmMu
+	confirmations map[string]pendingConfirmation
+	confirmMu     sync.Mutex
+
 	// Reconnection support
 	ReconnectToken string    // Token for reconnection validation
+	TokenExpiresAt time.Time // Until when ReconnectToken survives a bridge restart
 	DisconnectedAt time.Time // When the session was disconnected
+
+	// Clock.Elapsed at disconnect; expiry is measured from this, not from
+	// DisconnectedAt
+	disconnectedElapsed time.Duration
+}
+
+// sinceDisconnect returns how long the session has been disconnected. The
+// caller holds s.mu, or the session is not shared yet.
+func (s *Session) sinceDisconnect(clock Clock) time.Duration {
+	return clock.Elapsed() - s.disconnectedElapsed
 }
 
 // Lock locks the session mutex
 func (s *Session) Lock() {
+	s.waiting.Add(1)
 	s.mu.Lock()
+	s.waiting.Add(-1)
+}
+
+// backgroundLockBackoff is how long LockBackground sleeps while a Lock caller
+// is waiting
+const backgroundLockBackoff = time.Millisecond
+
+// LockBackground locks the session mutex at low priority: it steps aside for
+// as long as any Lock caller is waiting, so bulk sends never hold up live ones
+func (s *Session) LockBackground() {
+	for {
+		if s.waiting.Load() == 0 {
+			s.mu.Lock()
+			if s.waiting.Load() == 0 {
+				return
+			}
+			s.mu.Unlock()
+		}
+		time.Sleep(backgroundLockBackoff)
+	}
 }
 
 // Unlock unlocks the session mutex
@@ -45,6 +124,25 @@ func (s *Session) Unlock() {
 	s.mu.Unlock()
 }
 
+// AcquireExec takes one of max slots for running a host_exec command,
+// reporting false when all are in use
+func (s *Session) AcquireExec(max int) bool {
+	for {
+		n := s.execs.Load()
+		if int(n) >= max {
+			return false
+		}
+		if s.execs.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// ReleaseExec frees a slot taken by AcquireExec
+func (s *Session) ReleaseExec() {
+	s.execs.Add(-1)
+}
+
 // Manager handles session lifecycle and reconnection
 type Manager struct {
 	sessions       sync.Map // map[sessionID]*Session
@@ -54,7 +152,10 @@ type Manager struct {
 	SessionTimeout   time.Duration // How long to keep disconnected sessions
 	CleanupInterval  time.Duration // How often to run cleanup
 	ReconnectTimeout time.Duration // How long to allow reconnection
+	TokenLifetime    time.Duration // How long a reconnect token survives bridge restarts
 
+	tokens      TokenStore // Persists reconnect tokens; nil keeps them in memory only
+	clock       Clock
 	stopCleanup chan struct{}
 }
 
@@ -64,6 +165,8 @@ func NewManager() *Manager {
 		SessionTimeout:   5 * time.Minute,  // Keep sessions for 5 minutes after disconnect
 		CleanupInterval:  30 * time.Second, // Clean up every 30 seconds
 		ReconnectTimeout: 2 * time.Minute,  // Allow reconnection for 2 minutes
+		TokenLifetime:    DefaultTokenLifetime,
+		clock:            newSystemClock(),
 		stopCleanup:      make(chan struct{}),
 	}
 
@@ -73,9 +176,18 @@ func NewManager() *Manager {
 	return m
 }
 
-// Stop stops the session manager's cleanup goroutine
+// Stop stops the session manager's cleanup goroutine and saves the state
+// of every session, so clients can resume them once the bridge is back
 func (m *Manager) Stop() {
 	close(m.stopCleanup)
+
+	m.sessions.Range(func(key, value interface{}) bool {
+		session := value.(*Session)
+		session.mu.Lock()
+		m.saveToken(session)
+		session.mu.Unlock()
+		return true
+	})
 }
 
 // cleanupLoop periodically cleans up expired sessions
@@ -95,15 +207,15 @@ func (m *Manager) cleanupLoop() {
 
 // cleanupExpiredSessions removes sessions that have been disconnected too long
 func (m *Manager) cleanupExpiredSessions() {
-	now := time.Now()
 	var expiredSessions []string
 
 	m.sessions.Range(func(key, value interface{}) bool {
 		session := value.(*Session)
-		if session.State == StateDisconnected {
-			if now.Sub(session.DisconnectedAt) > m.SessionTimeout {
-				expiredSessions = append(expiredSessions, session.ID)
-			}
+		session.mu.Lock()
+		expired := session.State == StateDisconnected && session.sinceDisconnect(m.clock) > m.SessionTimeout
+		session.mu.Unlock()
+		if expired {
+			expiredSessions = append(expiredSessions, session.ID)
 		}
 		return true
 	})
@@ -118,35 +230,60 @@ func (m *Manager) cleanupExpiredSessions() {
 			if session.ReconnectToken != "" {
 				m.tokenToSession.Delete(session.ReconnectToken)
 			}
+			m.forgetToken(sessionID)
 
 			// Remove session
 			m.sessions.Delete(sessionID)
 		}
 	}
 
+	// Saved sessions nobody came back for after a restart
+	if m.tokens != nil {
+		if _, err := m.tokens.DeleteExpiredSessionTokens(); err != nil {
+			log.Printf("[WARN] [SESSION] Failed to drop expired session tokens: %v", err)
+		}
+	}
+
 	if len(expiredSessions) > 0 {
 		log.Printf("[INFO] [SESSION] Cleaned up %d expired sessions", len(expiredSessions))
 	}
 }
 
-// CreateSession creates a new session with a WebSocket connection
+// CreateSession creates and registers a new session with a WebSocket
+// connection
 func (m *Manager) CreateSession(conn *websocket.Conn) *Session {
-	session := &Session{
+	session := m.NewProvisionalSession(conn)
+	m.Register(session)
+	return session
+}
+
+// NewProvisionalSession creates a session for a connection that has not
+// authenticated yet. It has no reconnect token and is not listed by the
+// manager until Register.
+func (m *Manager) NewProvisionalSession(conn *websocket.Conn) *Session {
+	return &Session{
 		ID:              uuid.New().String(),
 		Conn:            conn,
 		State:           StateConnected,
-		CreatedAt:       time.Now(),
-		LastSeenAt:      time.Now(),
+		CreatedAt:       m.clock.Now(),
+		LastSeenAt:      m.clock.Now(),
 		HostConnections: make(map[string]bool),
-		ReconnectToken:  uuid.New().String(),
 	}
+}
 
+// Register lists a provisional session and issues its reconnect token, once
+// its connection authenticated. A session already registered is left as is.
+func (m *Manager) Register(session *Session) {
+	if _, ok := m.sessions.Load(session.ID); ok {
+		return
+	}
+
+	session.mu.Lock()
+	m.issueToken(session)
+	session.mu.Unlock()
 	m.sessions.Store(session.ID, session)
-	m.tokenToSession.Store(session.ReconnectToken, session.ID)
 
 	log.Printf("[DEBUG] [SESSION] Created new session: %s", session.ID)
-
-	return session
 }
 
 // GetSession retrieves a session by ID
@@ -158,51 +295,53 @@ func (m *Manager) GetSession(sessionID string) *Session {
 }
 
 // Reconnect attempts to reconnect a session using a reconnect token
-// Returns the session if successful, nil if token is invalid or expired
+// Returns the session if successful, nil if token is invalid or expired.
+// Tokens not known in memory are looked up in the token store, to resume
+// sessions from before a bridge restart.
 func (m *Manager) Reconnect(reconnectToken string, newConn *websocket.Conn) *Session {
-	// Look up session ID from token
-	sessionIDVal, ok := m.tokenToSession.Load(reconnectToken)
+	// Claim the token, so concurrent attempts with it (or a refresh) can't
+	// also succeed
+	sessionIDVal, ok := m.tokenToSession.LoadAndDelete(reconnectToken)
 	if !ok {
-		log.Printf("[DEBUG] [SESSION] Reconnect failed: invalid token")
-		return nil
+		return m.rehydrate(reconnectToken, newConn)
 	}
 
 	sessionID := sessionIDVal.(string)
 	sessionVal, ok := m.sessions.Load(sessionID)
 	if !ok {
 		log.Printf("[DEBUG] [SESSION] Reconnect failed: session not found")
-		m.tokenToSession.Delete(reconnectToken)
 		return nil
 	}
 
 	session := sessionVal.(*Session)
 
 	// Check if reconnection is still allowed
-	if session.State == StateDisconnected {
-		if time.Since(session.DisconnectedAt) > m.ReconnectTimeout {
-			log.Printf("[DEBUG] [SESSION] Reconnect failed: reconnection timeout exceeded")
-			return nil
-		}
+	session.mu.Lock()
+	if session.State == StateDisconnected && session.sinceDisconnect(m.clock) > m.ReconnectTimeout {
+		session.mu.Unlock()
+		log.Printf("[DEBUG] [SESSION] Reconnect failed: reconnection timeout exceeded")
+		return nil
 	}
 
 	// Update session with new connection
-	session.mu.Lock()
+	if session.ReconnectToken != reconnectToken {
+		session.mu.Unlock()
+		log.Printf("[DEBUG] [SESSION] Reconnect failed: token was rotated")
+		return nil
+	}
 	if session.Conn != nil {
 		session.Conn.Close() // Close old connection if any
 	}
 	session.Conn = newConn
+	session.Compression = false // Renegotiated by the next auth message
+	session.rtt.reset()         // The new connection may take another route
 	session.State = StateConnected
-	session.LastSeenAt = time.Now()
+	session.LastSeenAt = m.clock.Now()
 
 	// Generate new reconnect token for security
-	oldToken := session.ReconnectToken
-	session.ReconnectToken = uuid.New().String()
+	m.issueToken(session)
 	session.mu.Unlock()
 
-	// Update token mapping
-	m.tokenToSession.Delete(oldToken)
-	m.tokenToSession.Store(session.ReconnectToken, session.ID)
-
 	log.Printf("[INFO] [SESSION] Session %s reconnected successfully", session.ID)
 
 	return session
@@ -214,8 +353,10 @@ func (m *Manager) MarkDisconnected(sessionID string) {
 		session := sessionVal.(*Session)
 		session.mu.Lock()
 		session.State = StateDisconnected
-		session.DisconnectedAt = time.Now()
+		session.DisconnectedAt = m.clock.Now()
+		session.disconnectedElapsed = m.clock.Elapsed()
 		session.Conn = nil
+		m.saveToken(session)
 		session.mu.Unlock()
 
 		log.Printf("[DEBUG] [SESSION] Session %s marked as disconnected", sessionID)
@@ -231,6 +372,7 @@ func (m *Manager) RemoveSession(sessionID string) {
 		if session.ReconnectToken != "" {
 			m.tokenToSession.Delete(session.ReconnectToken)
 		}
+		m.forgetToken(sessionID)
 
 		m.sessions.Delete(sessionID)
 		log.Printf("[DEBUG] [SESSION] Session %s removed", sessionID)
@@ -252,7 +394,10 @@ func (m *Manager) GetConnectedSessions() []*Session {
 	var sessions []*Session
 	m.sessions.Range(func(key, value interface{}) bool {
 		session := value.(*Session)
-		if session.State == StateConnected {
+		session.mu.Lock()
+		connected := session.State == StateConnected
+		session.mu.Unlock()
+		if connected {
 			sessions = append(sessions, session)
 		}
 		return true
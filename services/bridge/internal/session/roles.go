@@ -0,0 +1,85 @@
+package session
+
+// Role is what a session's client may do, granted at auth
+type Role string
+
+const (
+	// RoleNone has not authenticated with the bridge's auth token or an
+	// invite yet, and may only authenticate
+	RoleNone Role = ""
+	// RoleObserver may watch: read state, history and output, and choose
+	// what it watches, but change nothing
+	RoleObserver Role = "observer"
+	// RoleOwner may make every request
+	RoleOwner Role = "owner"
+)
+
+// rank orders roles by what they allow
+func (r Role) rank() int {
+	switch r {
+	case RoleObserver:
+		return 1
+	case RoleOwner:
+		return 2
+	}
+	return 0
+}
+
+// Valid reports whether r is a role a session can be granted
+func (r Role) Valid() bool {
+	return r == RoleObserver || r == RoleOwner
+}
+
+// Allows reports whether a session with role r may make a request that
+// needs required
+func (r Role) Allows(required Role) bool {
+	return r.rank() >= required.rank()
+}
+
+// Scope limits a session to one host, or one process, as an invite can. The
+// zero Scope allows everything.
+type Scope struct {
+	HostID    string `json:"hostId,omitempty"`
+	ProcessID string `json:"processId,omitempty"`
+}
+
+// Unlimited reports whether the scope allows every host and process
+func (s Scope) Unlimited() bool {
+	return s.HostID == "" && s.ProcessID == ""
+}
+
+// Allows reports whether a request naming hostID and processID, either of
+// which may be empty, stays in the scope
+func (s Scope) Allows(hostID, processID string) bool {
+	if s.HostID != "" && hostID != "" && hostID != s.HostID {
+		return false
+	}
+	if s.ProcessID != "" && processID != "" && processID != s.ProcessID {
+		return false
+	}
+	return true
+}
+
+// SetRole grants the session a role within a scope. inviteID is the invite
+// it authenticated with, if any, so revoking the invite can find it.
+func (s *Session) SetRole(role Role, scope Scope, inviteID string) {
+	s.roleMu.Lock()
+	defer s.roleMu.Unlock()
+	s.role = role
+	s.scope = scope
+	s.inviteID = inviteID
+}
+
+// Role returns the session's role and scope
+func (s *Session) Role() (Role, Scope) {
+	s.roleMu.Lock()
+	defer s.roleMu.Unlock()
+	return s.role, s.scope
+}
+
+// InviteID returns the invite the session authenticated with, or ""
+func (s *Session) InviteID() string {
+	s.roleMu.Lock()
+	defer s.roleMu.Unlock()
+	return s.inviteID
+}
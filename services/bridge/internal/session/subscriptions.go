@@ -0,0 +1,98 @@
+package session
+
+// AllProcesses subscribes to chat events from every process on a host
+const AllProcesses = "*"
+
+// SubscribeChat subscribes the session to chat events for a process, or for
+// every process on the host when processID is AllProcesses. Subscriptions live
+// on the session, so they survive reconnects and go away when it expires.
+func (s *Session) SubscribeChat(hostID, processID string) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+
+	if s.chatSubs == nil {
+		s.chatSubs = make(map[string]map[string]bool)
+	}
+	if s.chatSubs[hostID] == nil {
+		s.chatSubs[hostID] = make(map[string]bool)
+	}
+	s.chatSubs[hostID][processID] = true
+}
+
+// UnsubscribeChat removes a subscription added by SubscribeChat. Removing a
+// single process does not affect a host-wide subscription, and vice versa.
+func (s *Session) UnsubscribeChat(hostID, processID string) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+
+	delete(s.chatSubs[hostID], processID)
+	if len(s.chatSubs[hostID]) == 0 {
+		delete(s.chatSubs, hostID)
+	}
+}
+
+// IsSubscribedToChat reports whether chat events for a process should be
+// delivered to this session
+func (s *Session) IsSubscribedToChat(hostID, processID string) bool {
+	s.subsMu.RLock()
+	defer s.subsMu.RUnlock()
+
+	procs := s.chatSubs[hostID]
+	return procs[processID] || procs[AllProcesses]
+}
+
+// ChatSubscriptions returns the session's chat subscriptions, as the
+// processes (or AllProcesses) subscribed to on each host
+func (s *Session) ChatSubscriptions() map[string][]string {
+	s.subsMu.RLock()
+	defer s.subsMu.RUnlock()
+
+	subs := make(map[string][]string, len(s.chatSubs))
+	for hostID, procs := range s.chatSubs {
+		for processID := range procs {
+			subs[hostID] = append(subs[hostID], processID)
+		}
+	}
+	return subs
+}
+
+// SubscribeProcesses subscribes the session to process state changes on a host
+func (s *Session) SubscribeProcesses(hostID string) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+
+	if s.processSubs == nil {
+		s.processSubs = make(map[string]bool)
+	}
+	s.processSubs[hostID] = true
+}
+
+// UnsubscribeProcesses removes a subscription added by SubscribeProcesses
+func (s *Session) UnsubscribeProcesses(hostID string) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+
+	delete(s.processSubs, hostID)
+}
+
+// IsSubscribedToProcesses reports whether process state changes on a host
+// should be pushed to this session
+func (s *Session) IsSubscribedToProcesses(hostID string) bool {
+	s.subsMu.RLock()
+	defer s.subsMu.RUnlock()
+
+	return s.processSubs[hostID]
+}
+
+// ProcessSubscriptions returns the hosts whose process state is pushed to
+// this session
+func (s *Session) ProcessSubscriptions() []string {
+	s.subsMu.RLock()
+	defer s.subsMu.RUnlock()
+
+	hosts := make([]string, 0, len(s.processSubs))
+	for hostID := range s.processSubs {
+		hosts = append(hosts, hostID)
+	}
+	return hosts
+}
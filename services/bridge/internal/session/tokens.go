@@ -0,0 +1,229 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// DefaultTokenLifetime is how long a reconnect token survives bridge
+// restarts after it was issued
+const DefaultTokenLifetime = 24 * time.Hour
+
+// TokenStore persists reconnect tokens so a session can be resumed after
+// the bridge restarts. Tokens are stored hashed.
+type TokenStore interface {
+	SaveSessionToken(token storage.SessionToken) error
+	TakeSessionToken(tokenHash string) (*storage.SessionToken, error)
+	DeleteSessionToken(sessionID string) error
+	DeleteExpiredSessionTokens() (int64, error)
+}
+
+// hashToken returns the form a reconnect token is stored in
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// savedState is the part of a session restored after a restart
+type savedState struct {
+	ChatSubs    map[string][]string `json:"chatSubs,omitempty"`
+	ProcessSubs []string            `json:"processSubs,omitempty"`
+	Selected    map[string]string   `json:"selected,omitempty"`
+	TermSizes   map[string]TermSize `json:"termSizes,omitempty"`
+}
+
+// SetTokenStore makes the manager persist reconnect tokens to store. Tokens
+// that expired while the bridge was down are dropped.
+func (m *Manager) SetTokenStore(store TokenStore) {
+	m.tokens = store
+	if _, err := store.DeleteExpiredSessionTokens(); err != nil {
+		log.Printf("[WARN] [SESSION] Failed to drop expired session tokens: %v", err)
+	}
+}
+
+// issueToken gives a session a new reconnect token. The caller holds
+// session.mu, or has not shared the session yet.
+func (m *Manager) issueToken(session *Session) {
+	session.ReconnectToken = uuid.New().String()
+	session.TokenExpiresAt = m.clock.Now().Add(m.TokenLifetime)
+	m.tokenToSession.Store(session.ReconnectToken, session.ID)
+	m.saveToken(session)
+}
+
+// saveToken persists a session's token and state. A disconnected session's
+// token only outlives a restart for as long as it could reconnect anyway.
+// The caller holds session.mu, or has not shared the session yet.
+func (m *Manager) saveToken(session *Session) {
+	if m.tokens == nil {
+		return
+	}
+
+	// The expiry has to be wall clock time to outlive the process, but how
+	// much of the reconnect window is left is measured monotonically
+	expiresAt := session.TokenExpiresAt
+	if session.State == StateDisconnected {
+		remaining := m.ReconnectTimeout - session.sinceDisconnect(m.clock)
+		if deadline := m.clock.Now().Add(remaining); deadline.Before(expiresAt) {
+			expiresAt = deadline
+		}
+	}
+
+	state, err := json.Marshal(session.savedState())
+	if err != nil {
+		log.Printf("[WARN] [SESSION] Failed to marshal state of session %s: %v", session.ID, err)
+	}
+	if err := m.tokens.SaveSessionToken(storage.SessionToken{
+		SessionID: session.ID,
+		TokenHash: hashToken(session.ReconnectToken),
+		State:     state,
+		CreatedAt: session.CreatedAt,
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		log.Printf("[WARN] [SESSION] Failed to save token of session %s: %v", session.ID, err)
+	}
+}
+
+// forgetToken removes a session's persisted token
+func (m *Manager) forgetToken(sessionID string) {
+	if m.tokens == nil {
+		return
+	}
+	if err := m.tokens.DeleteSessionToken(sessionID); err != nil {
+		log.Printf("[WARN] [SESSION] Failed to delete token of session %s: %v", sessionID, err)
+	}
+}
+
+// RefreshToken replaces a session's reconnect token, provided current is
+// still its token: a refresh racing a reconnect with the same token, or
+// another refresh, fails instead of handing out a token the winner doesn't
+// know. The old token stops working immediately.
+func (m *Manager) RefreshToken(session *Session, current string) bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.ReconnectToken != current {
+		return false
+	}
+	if _, ok := m.tokenToSession.LoadAndDelete(current); !ok {
+		// Claimed by a reconnect that is about to rotate it
+		return false
+	}
+	m.issueToken(session)
+
+	log.Printf("[INFO] [SESSION] Session %s refreshed its reconnect token", session.ID)
+	return true
+}
+
+// rehydrate restores a session saved before the bridge restarted from its
+// reconnect token. It has its ID and subscriptions back, but no host
+// connections: those died with the old bridge.
+func (m *Manager) rehydrate(reconnectToken string, conn *websocket.Conn) *Session {
+	if m.tokens == nil {
+		log.Printf("[DEBUG] [SESSION] Reconnect failed: invalid token")
+		return nil
+	}
+
+	saved, err := m.tokens.TakeSessionToken(hashToken(reconnectToken))
+	if err != nil {
+		log.Printf("[WARN] [SESSION] Failed to look up saved session token: %v", err)
+		return nil
+	}
+	if saved == nil {
+		log.Printf("[DEBUG] [SESSION] Reconnect failed: invalid token")
+		return nil
+	}
+	if m.GetSession(saved.SessionID) != nil {
+		// A live session's current token is always in memory, so this one
+		// was just rotated
+		log.Printf("[DEBUG] [SESSION] Reconnect failed: token of session %s was rotated", saved.SessionID)
+		return nil
+	}
+
+	var state savedState
+	if len(saved.State) > 0 {
+		if err := json.Unmarshal(saved.State, &state); err != nil {
+			log.Printf("[WARN] [SESSION] Ignoring unreadable state of session %s: %v", saved.SessionID, err)
+		}
+	}
+
+	session := &Session{
+		ID:              saved.SessionID,
+		Conn:            conn,
+		State:           StateConnected,
+		CreatedAt:       saved.CreatedAt,
+		LastSeenAt:      m.clock.Now(),
+		HostConnections: make(map[string]bool),
+	}
+	session.restoreState(state)
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if _, loaded := m.sessions.LoadOrStore(session.ID, session); loaded {
+		log.Printf("[DEBUG] [SESSION] Reconnect failed: session %s was restored concurrently", session.ID)
+		return nil
+	}
+	m.issueToken(session)
+
+	log.Printf("[INFO] [SESSION] Session %s restored after a bridge restart", session.ID)
+	return session
+}
+
+// savedState returns the state of the session to persist
+func (s *Session) savedState() savedState {
+	s.subsMu.RLock()
+	defer s.subsMu.RUnlock()
+
+	var state savedState
+	for hostID, procs := range s.chatSubs {
+		if state.ChatSubs == nil {
+			state.ChatSubs = make(map[string][]string)
+		}
+		for processID := range procs {
+			state.ChatSubs[hostID] = append(state.ChatSubs[hostID], processID)
+		}
+	}
+	for hostID := range s.processSubs {
+		state.ProcessSubs = append(state.ProcessSubs, hostID)
+	}
+
+	s.viewMu.Lock()
+	defer s.viewMu.Unlock()
+	for hostID, processID := range s.selected {
+		if state.Selected == nil {
+			state.Selected = make(map[string]string)
+		}
+		state.Selected[hostID] = processID
+	}
+	for processID, size := range s.termSizes {
+		if state.TermSizes == nil {
+			state.TermSizes = make(map[string]TermSize)
+		}
+		state.TermSizes[processID] = size
+	}
+	return state
+}
+
+// restoreState restores persisted state to a session
+func (s *Session) restoreState(state savedState) {
+	for hostID, procs := range state.ChatSubs {
+		for _, processID := range procs {
+			s.SubscribeChat(hostID, processID)
+		}
+	}
+	for _, hostID := range state.ProcessSubs {
+		s.SubscribeProcesses(hostID)
+	}
+	for hostID, processID := range state.Selected {
+		s.SelectProcess(hostID, processID)
+	}
+	for processID, size := range state.TermSizes {
+		s.SetTermSize(processID, size.Cols, size.Rows)
+	}
+}
@@ -0,0 +1,190 @@
+package session
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/storage"
+)
+
+// newTestManager returns a manager persisting tokens to the database at path
+func newTestManager(t *testing.T, path string) (*Manager, *storage.Store) {
+	t.Helper()
+	store, err := storage.NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	m := NewManager()
+	m.SetTokenStore(store)
+	return m, store
+}
+
+func TestRehydrateAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bridge.db")
+	m, store := newTestManager(t, path)
+	session := m.CreateSession(nil)
+	session.SubscribeChat("host-1", "proc-1")
+	session.SubscribeProcesses("host-2")
+	session.SelectProcess("host-1", "proc-1")
+	session.SetTermSize("proc-1", 100, 40)
+	token := session.ReconnectToken
+	m.Stop()
+	store.Close()
+
+	m, store = newTestManager(t, path)
+	t.Cleanup(func() { m.Stop(); store.Close() })
+
+	restored := m.Reconnect(token, nil)
+	if restored == nil {
+		t.Fatal("session was not restored after a restart")
+	}
+	if restored.ID != session.ID || restored.State != StateConnected {
+		t.Errorf("restored session %s (%s), want %s connected", restored.ID, restored.State, session.ID)
+	}
+	if !restored.IsSubscribedToChat("host-1", "proc-1") || !restored.IsSubscribedToProcesses("host-2") {
+		t.Error("subscriptions were not restored")
+	}
+	if size, _ := restored.TermSize("proc-1"); restored.SelectedProcesses()["host-1"] != "proc-1" || size != (TermSize{Cols: 100, Rows: 40}) {
+		t.Errorf("view was not restored: selected %v, size %+v", restored.SelectedProcesses(), size)
+	}
+	if restored.ReconnectToken == token {
+		t.Error("reconnect token was not rotated")
+	}
+
+	// The old token is used up, and the new one works in memory
+	if m.Reconnect(token, nil) != nil {
+		t.Error("old token reconnected twice")
+	}
+	if m.Reconnect(restored.ReconnectToken, nil) != restored {
+		t.Error("new token did not reconnect")
+	}
+}
+
+func TestRehydrateExpiredToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bridge.db")
+	m, store := newTestManager(t, path)
+	m.ReconnectTimeout = time.Millisecond
+	session := m.CreateSession(nil)
+	token := session.ReconnectToken
+
+	// A disconnected session's token lasts no longer than it could reconnect
+	m.MarkDisconnected(session.ID)
+	m.Stop()
+	store.Close()
+	time.Sleep(5 * time.Millisecond)
+
+	m, store = newTestManager(t, path)
+	t.Cleanup(func() { m.Stop(); store.Close() })
+	if m.Reconnect(token, nil) != nil {
+		t.Error("expired token restored a session")
+	}
+}
+
+func TestRemovedSessionIsNotRehydrated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bridge.db")
+	m, store := newTestManager(t, path)
+	session := m.CreateSession(nil)
+	token := session.ReconnectToken
+	m.RemoveSession(session.ID)
+	m.Stop()
+	store.Close()
+
+	m, store = newTestManager(t, path)
+	t.Cleanup(func() { m.Stop(); store.Close() })
+	if m.Reconnect(token, nil) != nil {
+		t.Error("removed session was restored")
+	}
+}
+
+func TestConcurrentReconnects(t *testing.T) {
+	m, store := newTestManager(t, filepath.Join(t.TempDir(), "bridge.db"))
+	t.Cleanup(func() { m.Stop(); store.Close() })
+	session := m.CreateSession(nil)
+	token := session.ReconnectToken
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	wins := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if m.Reconnect(token, nil) != nil {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if wins != 1 {
+		t.Errorf("%d reconnects succeeded with one token, want 1", wins)
+	}
+}
+
+func TestRefreshRacesReconnect(t *testing.T) {
+	m, store := newTestManager(t, filepath.Join(t.TempDir(), "bridge.db"))
+	t.Cleanup(func() { m.Stop(); store.Close() })
+
+	for i := 0; i < 20; i++ {
+		session := m.CreateSession(nil)
+		token := session.ReconnectToken
+
+		var wg sync.WaitGroup
+		var refreshed, reconnected bool
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			refreshed = m.RefreshToken(session, token)
+		}()
+		go func() {
+			defer wg.Done()
+			reconnected = m.Reconnect(token, nil) != nil
+		}()
+		wg.Wait()
+
+		if refreshed == reconnected {
+			t.Fatalf("refreshed=%v reconnected=%v, want exactly one", refreshed, reconnected)
+		}
+
+		// Whoever won, only the session's current token works
+		current := session.ReconnectToken
+		if current == token {
+			t.Fatal("token was not rotated")
+		}
+		if m.Reconnect(token, nil) != nil {
+			t.Fatal("old token still reconnects")
+		}
+		if m.RefreshToken(session, token) {
+			t.Fatal("old token still refreshes")
+		}
+		if m.Reconnect(current, nil) != session {
+			t.Fatal("current token does not reconnect")
+		}
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	m, store := newTestManager(t, filepath.Join(t.TempDir(), "bridge.db"))
+	t.Cleanup(func() { m.Stop(); store.Close() })
+	m.TokenLifetime = time.Hour
+	session := m.CreateSession(nil)
+	token := session.ReconnectToken
+
+	if m.RefreshToken(session, "not-the-token") {
+		t.Error("refreshed with a wrong token")
+	}
+	if !m.RefreshToken(session, token) {
+		t.Fatal("refresh failed")
+	}
+	if session.ReconnectToken == token || time.Until(session.TokenExpiresAt) < 59*time.Minute {
+		t.Errorf("token %s expiring %s after refresh", session.ReconnectToken, session.TokenExpiresAt)
+	}
+
+	// The refreshed token isn't left in the store either
+	if saved, err := store.TakeSessionToken(hashToken(token)); err != nil || saved != nil {
+		t.Errorf("old token in store = %+v, %v", saved, err)
+	}
+}
@@ -0,0 +1,120 @@
+package session
+
+// TermSize is a terminal size the client declared with pty_resize, or at
+// auth as its default
+type TermSize struct {
+	Cols int `json:"cols"`
+	Rows int `json:"rows"`
+}
+
+// The client's view is what it had on screen: the process selected on each
+// host, the terminal sizes it declared and the last chat message it was sent
+// for each process. It lives on the session like the subscriptions do, so a
+// reconnect can put the view back without the client asking.
+
+// SelectProcess records the process the client selected on a host
+func (s *Session) SelectProcess(hostID, processID string) {
+	s.viewMu.Lock()
+	defer s.viewMu.Unlock()
+
+	if s.selected == nil {
+		s.selected = make(map[string]string)
+	}
+	s.selected[hostID] = processID
+}
+
+// SelectedProcesses returns the selected process of each host, by host ID
+func (s *Session) SelectedProcesses() map[string]string {
+	s.viewMu.Lock()
+	defer s.viewMu.Unlock()
+
+	selected := make(map[string]string, len(s.selected))
+	for hostID, processID := range s.selected {
+		selected[hostID] = processID
+	}
+	return selected
+}
+
+// IsSelected reports whether the client has a process selected on its host
+func (s *Session) IsSelected(hostID, processID string) bool {
+	s.viewMu.Lock()
+	defer s.viewMu.Unlock()
+
+	return s.selected[hostID] == processID
+}
+
+// SetTermSize records the terminal size the client declared for a process
+func (s *Session) SetTermSize(processID string, cols, rows int) {
+	s.viewMu.Lock()
+	defer s.viewMu.Unlock()
+
+	if s.termSizes == nil {
+		s.termSizes = make(map[string]TermSize)
+	}
+	s.termSizes[processID] = TermSize{Cols: cols, Rows: rows}
+}
+
+// TermSize returns the terminal size the client declared for a process
+func (s *Session) TermSize(processID string) (TermSize, bool) {
+	s.viewMu.Lock()
+	defer s.viewMu.Unlock()
+
+	size, ok := s.termSizes[processID]
+	return size, ok
+}
+
+// SetDefaultTermSize records the terminal size the client declared at auth,
+// for processes it doesn't give one. A 0 cols or rows declares none.
+func (s *Session) SetDefaultTermSize(cols, rows int) {
+	s.viewMu.Lock()
+	defer s.viewMu.Unlock()
+
+	s.defaultTermSize = TermSize{Cols: cols, Rows: rows}
+}
+
+// DefaultTermSize returns the terminal size the client declared at auth;
+// Cols or Rows is 0 where it declared none
+func (s *Session) DefaultTermSize() TermSize {
+	s.viewMu.Lock()
+	defer s.viewMu.Unlock()
+
+	return s.defaultTermSize
+}
+
+// NoteChatDelivered records that a process's chat message was sent to the
+// client. Only the newest message is kept.
+func (s *Session) NoteChatDelivered(processID string, messageID int) {
+	s.viewMu.Lock()
+	defer s.viewMu.Unlock()
+
+	if s.chatDelivered == nil {
+		s.chatDelivered = make(map[string]int)
+	}
+	if last, ok := s.chatDelivered[processID]; !ok || messageID > last {
+		s.chatDelivered[processID] = messageID
+	}
+}
+
+// ChatDelivered returns the newest chat message of a process sent to the
+// client
+func (s *Session) ChatDelivered(processID string) (int, bool) {
+	s.viewMu.Lock()
+	defer s.viewMu.Unlock()
+
+	id, ok := s.chatDelivered[processID]
+	return id, ok
+}
+
+// ForgetProcess drops a process from the view, once it is gone
+func (s *Session) ForgetProcess(processID string) {
+	s.viewMu.Lock()
+	defer s.viewMu.Unlock()
+
+	for hostID, selected := range s.selected {
+		if selected == processID {
+			delete(s.selected, hostID)
+		}
+	}
+	delete(s.termSizes, processID)
+	delete(s.chatDelivered, processID)
+}
@@ -0,0 +1,28 @@
+package session
+
+import "testing"
+
+func TestView(t *testing.T) {
+	s := &Session{}
+	s.SelectProcess("host-1", "proc-1")
+	s.SelectProcess("host-2", "proc-2")
+	s.SetTermSize("proc-1", 100, 40)
+
+	// Only the newest delivered message is kept
+	s.NoteChatDelivered("proc-1", 5)
+	s.NoteChatDelivered("proc-1", 3)
+	if id, ok := s.ChatDelivered("proc-1"); !ok || id != 5 {
+		t.Errorf("ChatDelivered = %d, %v, want 5", id, ok)
+	}
+
+	s.ForgetProcess("proc-1")
+	if selected := s.SelectedProcesses(); len(selected) != 1 || selected["host-2"] != "proc-2" {
+		t.Errorf("selected after forgetting proc-1 = %v", selected)
+	}
+	if _, ok := s.TermSize("proc-1"); ok {
+		t.Error("term size of a forgotten process kept")
+	}
+	if _, ok := s.ChatDelivered("proc-1"); ok {
+		t.Error("delivered message of a forgotten process kept")
+	}
+}
@@ -0,0 +1,239 @@
+// Package shellargs parses user-supplied command arguments into an argv list
+// and renders them back as a shell-safe command line.
+//
+// Arguments are written into an interactive shell through the PTY, so anything
+// the shell would interpret (command separators, redirection, substitution,
+// globbing) is rejected rather than passed through. Quoting works like a POSIX
+// shell: single quotes are fully literal, double quotes allow \" and \\ escapes,
+// and a backslash outside quotes escapes the next character. Expansion inside
+// double quotes ($ and `) is not supported and is rejected, since the rendered
+// command single-quotes every argument and would silently make it literal.
+//
+// The one exception to rendering arguments literally is home directories: an
+// argument that is "~" or starts with "~/" keeps that tilde unquoted, so the
+// shell expands it, and only the rest is quoted ("~/my dir" renders as
+// ~/'my dir'). "~user" and a tilde anywhere else are quoted like any other
+// character.
+package shellargs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// metacharacters are rejected when they appear unquoted
+const metacharacters = ";&|<>()`$*?[]{}!#"
+
+// InvalidArgsError lists the tokens that could not be safely parsed
+type InvalidArgsError struct {
+	Tokens []string // Offending tokens, as written by the user
+	Reason string
+}
+
+func (e *InvalidArgsError) Error() string {
+	return fmt.Sprintf("invalid arguments (%s): %s", e.Reason, strings.Join(e.Tokens, " "))
+}
+
+// Parse splits s into arguments, resolving quotes and escapes. It returns an
+// *InvalidArgsError if any token contains unquoted shell metacharacters,
+// expansions inside double quotes, control characters, or an unterminated quote.
+func Parse(s string) ([]string, error) {
+	var (
+		args    []string
+		invalid []string
+		reasons []string
+		current strings.Builder
+		inToken bool
+		bad     string // reason the current token is rejected, if any
+		start   int    // byte offset where the current token began
+	)
+
+	flush := func(end int) {
+		if !inToken {
+			return
+		}
+		if bad != "" {
+			invalid = append(invalid, s[start:end])
+			reasons = appendUnique(reasons, bad)
+		} else {
+			args = append(args, current.String())
+		}
+		current.Reset()
+		inToken = false
+		bad = ""
+	}
+	reject := func(reason string) {
+		if bad == "" {
+			bad = reason
+		}
+	}
+
+	runes := []rune(s)
+	offsets := make([]int, len(runes)+1)
+	for i, off := 0, 0; i < len(runes); i++ {
+		offsets[i] = off
+		off += len(string(runes[i]))
+		offsets[i+1] = off
+	}
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		if r == ' ' || r == '\t' {
+			flush(offsets[i])
+			continue
+		}
+		if !inToken {
+			inToken = true
+			start = offsets[i]
+		}
+		if isControl(r) {
+			reject("control character")
+			continue
+		}
+
+		switch {
+		case r == '\'':
+			end := indexRune(runes, i+1, '\'')
+			if end < 0 {
+				reject("unterminated quote")
+				i = len(runes) - 1
+				continue
+			}
+			for _, c := range runes[i+1 : end] {
+				if isControl(c) {
+					reject("control character")
+				}
+				current.WriteRune(c)
+			}
+			i = end
+
+		case r == '"':
+			closed := false
+			for i++; i < len(runes); i++ {
+				c := runes[i]
+				if c == '"' {
+					closed = true
+					break
+				}
+				if c == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+					i++
+					current.WriteRune(runes[i])
+					continue
+				}
+				if c == '$' || c == '`' {
+					reject("expansion in double quotes")
+				}
+				if isControl(c) {
+					reject("control character")
+				}
+				current.WriteRune(c)
+			}
+			if !closed {
+				reject("unterminated quote")
+			}
+
+		case r == '\\':
+			if i+1 >= len(runes) {
+				reject("trailing backslash")
+				continue
+			}
+			i++
+			if isControl(runes[i]) {
+				reject("control character")
+			}
+			current.WriteRune(runes[i])
+
+		case strings.ContainsRune(metacharacters, r):
+			reject("shell metacharacter")
+			current.WriteRune(r)
+
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush(len(s))
+
+	if len(invalid) > 0 {
+		return nil, &InvalidArgsError{Tokens: invalid, Reason: strings.Join(reasons, ", ")}
+	}
+	return args, nil
+}
+
+// Quote renders a single argument so the shell passes it through unchanged,
+// except that "~" or a leading "~/" is left unquoted so home-relative paths
+// still expand; what follows "~/" is quoted on its own.
+func Quote(arg string) string {
+	if arg == "~" {
+		return arg
+	}
+	if strings.HasPrefix(arg, "~/") {
+		return "~/" + quote(arg[2:])
+	}
+	return quote(arg)
+}
+
+func quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if isSafe(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Join renders args as a shell command line fragment
+func Join(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = Quote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Sanitize parses s and renders it back as a shell-safe command line
+func Sanitize(s string) (string, error) {
+	args, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return Join(args), nil
+}
+
+// isSafe reports whether s needs no quoting at all
+func isSafe(s string) bool {
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("-_=+./,:@%^", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isControl reports whether r is a control character. These are rejected even
+// when quoted, since the terminal line discipline acts on them (e.g. ^C, ^D).
+func isControl(r rune) bool {
+	return (r < 0x20 && r != '\t') || r == 0x7f
+}
+
+func indexRune(runes []rune, from int, r rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, existing := range list {
+		if existing == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
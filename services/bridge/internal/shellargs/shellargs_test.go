@@ -0,0 +1,168 @@
+package shellargs
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"empty", "", nil},
+		{"whitespace only", "  \t ", nil},
+		{"simple flags", "--model opus --verbose", []string{"--model", "opus", "--verbose"}},
+		{"collapses whitespace", "  -a \t  -b  ", []string{"-a", "-b"}},
+		{"single quotes", "--prompt 'hello world'", []string{"--prompt", "hello world"}},
+		{"double quotes", `--prompt "hello world"`, []string{"--prompt", "hello world"}},
+		{"metacharacters inside single quotes", "'a; b | c && $(d) `e`'", []string{"a; b | c && $(d) `e`"}},
+		{"metacharacters inside double quotes", `"a; b | c & (d)"`, []string{"a; b | c & (d)"}},
+		{"single quote inside double quotes", `"it's"`, []string{"it's"}},
+		{"double quote inside single quotes", `'say "hi"'`, []string{`say "hi"`}},
+		{"escaped double quote", `"say \"hi\""`, []string{`say "hi"`}},
+		{"escaped backslash in double quotes", `"a\\b"`, []string{`a\b`}},
+		{"other backslash in double quotes is literal", `"a\nb"`, []string{`a\nb`}},
+		{"backslash escapes outside quotes", `hello\ world \;`, []string{"hello world", ";"}},
+		{"adjacent quoted parts join", `--allowedTools="Bash(git:*)"'Edit'`, []string{"--allowedTools=Bash(git:*)Edit"}},
+		{"empty quotes make an empty argument", `-p '' ""`, []string{"-p", "", ""}},
+		{"unicode", "--prompt 'héllo wörld 日本語' ünïcode", []string{"--prompt", "héllo wörld 日本語", "ünïcode"}},
+		{"emoji", `"🚀 launch"`, []string{"🚀 launch"}},
+		{"tilde path", "--add-dir ~/projects", []string{"--add-dir", "~/projects"}},
+		{"tab inside quotes", "'a\tb'", []string{"a\tb"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRejectsDangerousInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		tokens []string
+	}{
+		{"command separator", "--verbose; rm -rf ~", []string{"--verbose;"}},
+		{"separate semicolon", "--verbose ; rm -rf ~", []string{";"}},
+		{"pipe", "--help | sh", []string{"|"}},
+		{"background", "--help & curl evil", []string{"&"}},
+		{"and chain", "-v&&reboot", []string{"-v&&reboot"}},
+		{"command substitution", "--model $(cat /etc/passwd)", []string{"$(cat", "/etc/passwd)"}},
+		{"backticks", "--model `whoami`", []string{"`whoami`"}},
+		{"variable", "--key $SECRET", []string{"$SECRET"}},
+		{"redirection", "--debug > /tmp/out", []string{">"}},
+		{"glob", "--add-dir *", []string{"*"}},
+		{"history expansion", "!!", []string{"!!"}},
+		{"comment", "--verbose #rest", []string{"#rest"}},
+		{"expansion in double quotes", `"$(id)"`, []string{`"$(id)"`}},
+		{"backtick in double quotes", "\"`id`\"", []string{"\"`id`\""}},
+		{"newline", "--verbose\nrm -rf ~", []string{"--verbose\nrm"}},
+		{"control character in quotes", "'\x03'", []string{"'\x03'"}},
+		{"escaped newline", "a\\\nb", []string{"a\\\nb"}},
+		{"unterminated single quote", "--prompt 'oops", []string{"'oops"}},
+		{"unterminated double quote", `--prompt "oops`, []string{`"oops`}},
+		{"trailing backslash", `--prompt\`, []string{`--prompt\`}},
+		{"unicode around metacharacter", "日本;語", []string{"日本;語"}},
+		{"lists every bad token", "ok; fine | good", []string{"ok;", "|"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := Parse(tt.input)
+			var invalid *InvalidArgsError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Parse(%q) = %q, %v; want InvalidArgsError", tt.input, args, err)
+			}
+			if !reflect.DeepEqual(invalid.Tokens, tt.tokens) {
+				t.Errorf("Parse(%q) tokens = %q, want %q", tt.input, invalid.Tokens, tt.tokens)
+			}
+		})
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := map[string]string{
+		"--verbose":        "--verbose",
+		"":                 "''",
+		"hello world":      "'hello world'",
+		"it's":             `'it'\''s'`,
+		"$(id)":            "'$(id)'",
+		"a;b":              "'a;b'",
+		"~":                "~",
+		"~/projects/x y":   "~/'projects/x y'",
+		"~/":               "~/''",
+		"~/it's":           `~/'it'\''s'`,
+		`~/say "hi"`:       `~/'say "hi"'`,
+		"~/$(id)":          "~/'$(id)'",
+		"~/~/x":            "~/'~/x'",
+		"a/~/b":            "'a/~/b'",
+		"~user":            "'~user'",
+		"日本語":              "'日本語'",
+		"--model=opus-4.1": "--model=opus-4.1",
+	}
+	for input, want := range tests {
+		if got := Quote(input); got != want {
+			t.Errorf("Quote(%q) = %s, want %s", input, got, want)
+		}
+	}
+}
+
+// TestQuoteExpandsOnlyHome runs quoted home-relative paths through a shell:
+// the tilde expands and the rest comes out as written
+func TestQuoteExpandsOnlyHome(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	for _, rest := range []string{"", "projects", "my projects/x y", "it's", `say "hi"`, "$(id) `id` $HOME", "~/x", "a\b"} {
+		cmd := exec.Command("sh", "-c", "printf %s "+Quote("~/"+rest))
+		cmd.Env = append(os.Environ(), "HOME=/home/test")
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("sh for %q: %v", rest, err)
+		}
+		if want := "/home/test/" + rest; string(out) != want {
+			t.Errorf("Quote(%q) ran as %q, want %q", "~/"+rest, out, want)
+		}
+	}
+}
+
+func TestSanitizeRoundTrip(t *testing.T) {
+	inputs := []string{
+		"--model opus --verbose",
+		`--prompt "don't stop" --append 'a "b" c'`,
+		"'a; b | c && $(d) `e`'",
+		"--prompt 'héllo 日本語'",
+		"--add-dir ~/my\\ projects",
+	}
+	for _, input := range inputs {
+		want, err := Parse(input)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", input, err)
+		}
+		sanitized, err := Sanitize(input)
+		if err != nil {
+			t.Fatalf("Sanitize(%q): %v", input, err)
+		}
+		// The sanitized command parses back to the same argv
+		got, err := Parse(sanitized)
+		if err != nil {
+			t.Fatalf("Parse(Sanitize(%q)) = %q: %v", input, sanitized, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("round trip of %q via %q = %q, want %q", input, sanitized, got, want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bootTimeCommand prints when the remote machine booted, in Unix seconds:
+// btime from /proc/stat on Linux, kern.boottime on macOS and the BSDs
+const bootTimeCommand = `sed -n 's/^btime //p' /proc/stat 2>/dev/null || sysctl -n kern.boottime 2>/dev/null | sed 's/.*sec = \([0-9]*\).*/\1/'`
+
+// BootTime returns when the remote machine booted. A changed boot time
+// between connects means the machine rebooted and its tmux sessions are gone.
+func (conn *Connection) BootTime(ctx context.Context) (time.Time, error) {
+	var out bytes.Buffer
+	if _, err := conn.Exec(ctx, ExecRequest{Command: bootTimeCommand, Stdout: &out}); err != nil {
+		return time.Time{}, err
+	}
+	return parseBootTime(out.String())
+}
+
+// parseBootTime parses the output of bootTimeCommand
+func parseBootTime(output string) (time.Time, error) {
+	output = strings.TrimSpace(output)
+	secs, err := strconv.ParseInt(output, 10, 64)
+	if err != nil || secs <= 0 {
+		return time.Time{}, fmt.Errorf("unexpected boot time %q", output)
+	}
+	return time.Unix(secs, 0), nil
+}
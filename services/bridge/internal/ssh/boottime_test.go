@@ -0,0 +1,33 @@
+package ssh
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestParseBootTime(t *testing.T) {
+	got, err := parseBootTime("1700000000\n")
+	if err != nil || !got.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("parseBootTime = %v, %v", got, err)
+	}
+	for _, output := range []string{"", "{ sec = 1700000000, usec = 0 }", "-5", "0"} {
+		if _, err := parseBootTime(output); err == nil {
+			t.Errorf("parseBootTime(%q) succeeded", output)
+		}
+	}
+}
+
+func TestBootTimeCommand(t *testing.T) {
+	out, err := exec.Command("sh", "-c", bootTimeCommand).Output()
+	if err != nil {
+		t.Skipf("sh: %v", err)
+	}
+	bootTime, err := parseBootTime(string(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bootTime.After(time.Now()) {
+		t.Errorf("boot time %s is in the future", bootTime)
+	}
+}
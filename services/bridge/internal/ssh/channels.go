@@ -0,0 +1,279 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultMaxChannels is the number of channels assumed to be allowed per SSH
+// connection. OpenSSH caps sessions at 10 (MaxSessions) by default; servers
+// with a lower cap are detected when they reject a channel.
+const DefaultMaxChannels = 10
+
+// DefaultMaxTunnelConnections is the number of secondary connections a host
+// may open for tunnel traffic once its primary connection is nearly full
+const DefaultMaxTunnelConnections = 2
+
+// sessionReserve is the number of channels of the primary connection kept
+// free for PTY attaches and exec sessions. Tunnel dials that would use them go
+// to a secondary connection instead.
+const sessionReserve = 3
+
+// maxTunnelDialAttempts bounds the retries of a tunnel dial rejected by a
+// channel cap the bridge didn't know about
+const maxTunnelDialAttempts = 3
+
+// channelCount tracks the channels open on one SSH connection
+type channelCount struct {
+	mu     sync.Mutex
+	open   int
+	byType map[string]int // Open channels per channel type
+	limit  int            // Cap learned from a rejected open; 0 if none
+}
+
+func newChannelCount() *channelCount {
+	return &channelCount{byType: make(map[string]int)}
+}
+
+func (c *channelCount) opened(channelType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.open++
+	c.byType[channelType]++
+}
+
+func (c *channelCount) closed(channelType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.open--
+	c.byType[channelType]--
+}
+
+// rejected records a failed open. A server refusing a type of channel it has
+// open already is enforcing a cap, which the open channels have reached. One
+// that never accepted the type (e.g. with forwarding disabled) is not.
+func (c *channelCount) rejected(channelType string, err error) {
+	if !IsChannelRejection(err) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byType[channelType] > 0 {
+		c.limit = c.open
+		log.Printf("[WARN] [SSH] Server rejected a %s channel with %d open, treating that as its limit: %v", channelType, c.open, err)
+	}
+}
+
+// count returns the number of open channels
+func (c *channelCount) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.open
+}
+
+// capacity returns how many channels the connection allows
+func (c *channelCount) capacity(max int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.limit > 0 && c.limit < max {
+		return c.limit
+	}
+	return max
+}
+
+// available returns how many more channels the connection allows
+func (c *channelCount) available(max int) int {
+	return c.capacity(max) - c.count()
+}
+
+// IsChannelRejection reports whether err is the server refusing to open a
+// channel, which is how a per-connection channel cap (such as OpenSSH's
+// MaxSessions) shows up; opening one may succeed once others close
+func IsChannelRejection(err error) bool {
+	var openErr *ssh.OpenChannelError
+	return errors.As(err, &openErr) &&
+		(openErr.Reason == ssh.Prohibited || openErr.Reason == ssh.ResourceShortage)
+}
+
+// countingConn counts the channels opened on an SSH connection. ssh.Client
+// opens sessions and tunnel dials through Conn.OpenChannel, so wrapping the
+// Conn counts them all, including ones opened through the raw *ssh.Client.
+// It also carries the host's operation limiter, which AcquireOp finds
+// through the client.
+type countingConn struct {
+	ssh.Conn
+	channels *channelCount
+	ops      *opLimiter
+}
+
+func (c *countingConn) OpenChannel(name string, data []byte) (ssh.Channel, <-chan *ssh.Request, error) {
+	ch, reqs, err := c.Conn.OpenChannel(name, data)
+	if err != nil {
+		c.channels.rejected(name, err)
+		return nil, nil, err
+	}
+	c.channels.opened(name)
+	return &countedChannel{Channel: ch, channelType: name, channels: c.channels}, reqs, nil
+}
+
+// countedChannel gives its slot back when closed
+type countedChannel struct {
+	ssh.Channel
+	channelType string
+	channels    *channelCount
+	once        sync.Once
+}
+
+func (ch *countedChannel) Close() error {
+	ch.once.Do(func() { ch.channels.closed(ch.channelType) })
+	return ch.Channel.Close()
+}
+
+// newCountingClient builds an ssh.Client whose channels are counted
+func newCountingClient(c ssh.Conn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request, ops *opLimiter) (*ssh.Client, *channelCount) {
+	channels := newChannelCount()
+	return ssh.NewClient(&countingConn{Conn: c, channels: channels, ops: ops}, chans, reqs), channels
+}
+
+// tunnelConn is a secondary connection to a host that carries only tunnel
+// traffic
+type tunnelConn struct {
+	client   *ssh.Client
+	channels *channelCount
+}
+
+// ChannelUsage reports the channels open on a host's SSH connections
+type ChannelUsage struct {
+	Open              int // Channels open on the primary connection
+	Limit             int // Channels allowed per connection
+	TunnelConnections int // Secondary connections carrying tunnel traffic
+	TunnelOpen        int // Channels open on the secondary connections
+}
+
+// ChannelUsage returns the channels open on the connection and its secondary
+// tunnel connections
+func (conn *Connection) ChannelUsage() ChannelUsage {
+	usage := ChannelUsage{
+		Open:  conn.channels.count(),
+		Limit: conn.channels.capacity(conn.maxChannels),
+	}
+	conn.tunnelMu.Lock()
+	defer conn.tunnelMu.Unlock()
+	usage.TunnelConnections = len(conn.tunnels)
+	for _, t := range conn.tunnels {
+		usage.TunnelOpen += t.channels.count()
+	}
+	return usage
+}
+
+// Dial creates a new connection through the SSH tunnel. Dials use the primary
+// connection while it has channels to spare, then secondary connections to
+// the same host, so long-lived tunnels such as SSE streams don't starve PTY
+// attaches and exec sessions.
+func (conn *Connection) Dial(network, addr string) (net.Conn, error) {
+	return conn.DialContext(context.Background(), network, addr)
+}
+
+// DialContext is Dial, giving up if ctx is done while the dial waits for
+// one of the host's operation slots (see AcquireOp). The slot is held for
+// the dial, not the life of the tunnel.
+func (conn *Connection) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn.mu.Lock()
+	if !conn.connected {
+		conn.mu.Unlock()
+		return nil, fmt.Errorf("connection is not active")
+	}
+	conn.mu.Unlock()
+
+	release, err := conn.ops.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	for attempt := 0; attempt < maxTunnelDialAttempts; attempt++ {
+		tunnel := conn.tunnelClient()
+
+		var netConn net.Conn
+		netConn, err = tunnel.client.Dial(network, addr)
+		if err == nil {
+			conn.touch()
+			return netConn, nil
+		}
+
+		if !IsChannelRejection(err) {
+			if tunnel.client != conn.Client {
+				// The secondary connection is likely dead; use another
+				conn.dropTunnel(tunnel)
+				continue
+			}
+			break
+		}
+		// A rejection lowers the connection's limit, so the retry goes to
+		// another connection if there is one
+	}
+	return nil, fmt.Errorf("failed to dial through SSH tunnel: %w", err)
+}
+
+// tunnelClient picks the connection for a tunnel dial: the primary while it
+// has channels beyond sessionReserve, otherwise a secondary connection with
+// room, opening one if the pool isn't full. It falls back to the primary.
+func (conn *Connection) tunnelClient() *tunnelConn {
+	primary := &tunnelConn{client: conn.Client, channels: conn.channels}
+	if conn.channels.available(conn.maxChannels) > sessionReserve {
+		return primary
+	}
+
+	conn.tunnelMu.Lock()
+	defer conn.tunnelMu.Unlock()
+
+	for _, t := range conn.tunnels {
+		if t.channels.available(conn.maxChannels) > 0 {
+			return t
+		}
+	}
+
+	if len(conn.tunnels) < conn.maxTunnels && conn.dialTunnel != nil {
+		client, channels, err := conn.dialTunnel()
+		if err == nil {
+			t := &tunnelConn{client: client, channels: channels}
+			conn.tunnels = append(conn.tunnels, t)
+			log.Printf("[INFO] [SSH] Opened tunnel connection %d for hostID=%s (%d channels open on the primary)",
+				len(conn.tunnels), conn.ID, conn.channels.count())
+			return t
+		}
+		log.Printf("[WARN] [SSH] Failed to open tunnel connection for hostID=%s: %v", conn.ID, err)
+	}
+
+	return primary
+}
+
+// dropTunnel closes a secondary connection and removes it from the pool
+func (conn *Connection) dropTunnel(tunnel *tunnelConn) {
+	conn.tunnelMu.Lock()
+	defer conn.tunnelMu.Unlock()
+	for i, t := range conn.tunnels {
+		if t == tunnel {
+			conn.tunnels = append(conn.tunnels[:i], conn.tunnels[i+1:]...)
+			t.client.Close()
+			log.Printf("[WARN] [SSH] Dropped tunnel connection for hostID=%s", conn.ID)
+			return
+		}
+	}
+}
+
+// closeTunnels closes every secondary connection
+func (conn *Connection) closeTunnels() {
+	conn.tunnelMu.Lock()
+	defer conn.tunnelMu.Unlock()
+	for _, t := range conn.tunnels {
+		t.client.Close()
+	}
+	conn.tunnels = nil
+}
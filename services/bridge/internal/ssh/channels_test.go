@@ -0,0 +1,246 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// cappedServer is an SSH server that accepts the password "secret" and
+// rejects channels past maxPerConn on each connection, as servers with a low
+// MaxSessions do. Tunnel dials are echoed.
+type cappedServer struct {
+	listener   net.Listener
+	maxPerConn int
+
+	mu    sync.Mutex
+	conns int // SSH connections accepted
+}
+
+func startCappedServer(t *testing.T, maxPerConn int) *cappedServer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey: %v", err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	srv := &cappedServer{listener: listener, maxPerConn: maxPerConn}
+
+	var wg sync.WaitGroup
+	var netConns []net.Conn
+	t.Cleanup(func() {
+		listener.Close()
+		srv.mu.Lock()
+		for _, c := range netConns {
+			c.Close()
+		}
+		srv.mu.Unlock()
+		wg.Wait()
+	})
+
+	go func() {
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			srv.mu.Lock()
+			netConns = append(netConns, netConn)
+			srv.mu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, chans, reqs, err := ssh.NewServerConn(netConn, config)
+				if err != nil {
+					return
+				}
+				srv.mu.Lock()
+				srv.conns++
+				srv.mu.Unlock()
+				go ssh.DiscardRequests(reqs)
+
+				var mu sync.Mutex
+				open := 0
+				for newCh := range chans {
+					mu.Lock()
+					if open >= srv.maxPerConn {
+						mu.Unlock()
+						newCh.Reject(ssh.Prohibited, "open failed")
+						continue
+					}
+					open++
+					mu.Unlock()
+
+					ch, chReqs, err := newCh.Accept()
+					if err != nil {
+						continue
+					}
+					go ssh.DiscardRequests(chReqs)
+					go func() {
+						io.Copy(ch, ch)
+						ch.Close()
+						mu.Lock()
+						open--
+						mu.Unlock()
+					}()
+				}
+			}()
+		}
+	}()
+	return srv
+}
+
+func (srv *cappedServer) connect(t *testing.T, m *Manager) *Connection {
+	t.Helper()
+	port := srv.listener.Addr().(*net.TCPAddr).Port
+	conn, err := m.Connect("host-1", "127.0.0.1", port, "user", AuthConfig{AuthType: "password", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(m.Close)
+	return conn
+}
+
+func (srv *cappedServer) connections() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.conns
+}
+
+// dialTunnels opens n tunnels through conn, checking each one works
+func dialTunnels(t *testing.T, conn *Connection, n int) []net.Conn {
+	t.Helper()
+	tunnels := make([]net.Conn, n)
+	for i := range tunnels {
+		c, err := conn.Dial("tcp", "localhost:3284")
+		if err != nil {
+			t.Fatalf("Dial %d: %v", i, err)
+		}
+		t.Cleanup(func() { c.Close() })
+		if _, err := c.Write([]byte("ping")); err != nil {
+			t.Fatalf("Write %d: %v", i, err)
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("tunnel %d echoed %q, %v", i, buf, err)
+		}
+		tunnels[i] = c
+	}
+	return tunnels
+}
+
+func TestTunnelsMoveToSecondaryConnection(t *testing.T) {
+	srv := startCappedServer(t, 100)
+	m := NewManager()
+	m.MaxChannels = 5
+	conn := srv.connect(t, m)
+
+	// Tunnels leave sessionReserve channels of the primary free
+	tunnels := dialTunnels(t, conn, 4)
+	want := ChannelUsage{Open: 2, Limit: 5, TunnelConnections: 1, TunnelOpen: 2}
+	if got := conn.ChannelUsage(); got != want {
+		t.Fatalf("usage = %+v, want %+v", got, want)
+	}
+
+	// Sessions still get the reserved channels
+	session, err := conn.CreateSession()
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if got := conn.ChannelUsage().Open; got != 3 {
+		t.Errorf("open with session = %d, want 3", got)
+	}
+	session.Close()
+
+	// Closing gives channels back
+	for _, c := range tunnels {
+		c.Close()
+	}
+	want = ChannelUsage{Open: 0, Limit: 5, TunnelConnections: 1, TunnelOpen: 0}
+	if got := conn.ChannelUsage(); got != want {
+		t.Errorf("usage after close = %+v, want %+v", got, want)
+	}
+	if n := srv.connections(); n != 2 {
+		t.Errorf("server saw %d connections, want 2", n)
+	}
+}
+
+func TestTunnelDialLearnsServerChannelCap(t *testing.T) {
+	// The server allows fewer channels than assumed
+	srv := startCappedServer(t, 4)
+	m := NewManager()
+	conn := srv.connect(t, m)
+
+	// The 5th dial is rejected on the primary and retried on a secondary
+	dialTunnels(t, conn, 6)
+	want := ChannelUsage{Open: 4, Limit: 4, TunnelConnections: 1, TunnelOpen: 2}
+	if got := conn.ChannelUsage(); got != want {
+		t.Fatalf("usage = %+v, want %+v", got, want)
+	}
+}
+
+func TestTunnelDialFailsWhenPoolFull(t *testing.T) {
+	srv := startCappedServer(t, 2)
+	m := NewManager()
+	m.MaxTunnelConnections = 1
+	conn := srv.connect(t, m)
+
+	dialTunnels(t, conn, 4)
+	if _, err := conn.Dial("tcp", "localhost:3284"); err == nil {
+		t.Fatal("expected dial past every connection's cap to fail")
+	}
+	if n := srv.connections(); n != 2 {
+		t.Errorf("server saw %d connections, want 2", n)
+	}
+
+	// Disconnecting closes the secondary connection too
+	m.Disconnect("host-1")
+	if got := conn.ChannelUsage().TunnelConnections; got != 0 {
+		t.Errorf("tunnel connections after disconnect = %d", got)
+	}
+}
+
+func TestChannelRejectionWithoutCap(t *testing.T) {
+	c := newChannelCount()
+	c.opened("session")
+	prohibited := &ssh.OpenChannelError{Reason: ssh.Prohibited, Message: "open failed"}
+
+	// Tunnels refused outright (forwarding disabled) say nothing about a cap
+	c.rejected("direct-tcpip", prohibited)
+	if got := c.capacity(DefaultMaxChannels); got != DefaultMaxChannels {
+		t.Errorf("capacity = %d after a never-accepted type was rejected", got)
+	}
+
+	c.opened("direct-tcpip")
+	c.rejected("direct-tcpip", &ssh.OpenChannelError{Reason: ssh.ConnectionFailed})
+	if got := c.capacity(DefaultMaxChannels); got != DefaultMaxChannels {
+		t.Errorf("capacity = %d after a failed connect", got)
+	}
+	c.rejected("direct-tcpip", prohibited)
+	if got := c.capacity(DefaultMaxChannels); got != 2 {
+		t.Errorf("capacity = %d, want the 2 channels open", got)
+	}
+}
@@ -0,0 +1,239 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/shellargs"
+	"golang.org/x/crypto/ssh"
+)
+
+// BatchResult is the outcome of one command run by RunBatch
+type BatchResult struct {
+	Output   string // stdout of the command
+	ExitCode int
+}
+
+// OK reports whether the command exited with status 0
+func (r BatchResult) OK() bool {
+	return r.ExitCode == 0
+}
+
+// RunBatch runs independent commands in a single SSH session and returns the
+// stdout and exit status of each, in order. Opening a session per command is
+// a full channel round trip and counts against the server's MaxSessions, so
+// hot paths that query many things at once should batch them.
+//
+// Each command runs in its own subshell with stdin closed and stderr
+// discarded, so a command that fails, exits or changes directory doesn't
+// affect the rest. The error is only set when the session itself fails;
+// per-command failures are reported in ExitCode.
+//
+// The batch waits for one of the host's operation slots (see AcquireOp)
+// for as long as it takes.
+func RunBatch(client *ssh.Client, cmds []string) ([]BatchResult, error) {
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	if client == nil {
+		return nil, fmt.Errorf("SSH client not available")
+	}
+	release, err := AcquireOp(context.Background(), client)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	marker, err := newBatchMarker()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+
+	if err := session.Run(batchScript(cmds, marker)); err != nil {
+		return nil, fmt.Errorf("failed to run command batch: %w", err)
+	}
+
+	return parseBatchOutput(stdout.String(), marker, len(cmds))
+}
+
+// RunBatch runs independent commands in a single session on the connection
+func (conn *Connection) RunBatch(cmds []string) ([]BatchResult, error) {
+	conn.mu.Lock()
+	if !conn.connected {
+		conn.mu.Unlock()
+		return nil, fmt.Errorf("connection is not active")
+	}
+	conn.mu.Unlock()
+
+	results, err := RunBatch(conn.Client, cmds)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.touch()
+	return results, nil
+}
+
+// ExecRequest is a command for Exec
+type ExecRequest struct {
+	Command string
+	Dir     string    // Directory to run in; empty for the login directory
+	Stdin   io.Reader // Fed to the command, then closed; nil closes stdin at once
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+// Exec runs a command in its own session and returns its exit status, or
+// -1 when the server reports none, such as for a command killed by a
+// signal. The command runs under sh whatever the user's login shell is,
+// reading req.Stdin. When ctx is done first the command is killed and
+// ctx's error returned. Output is fully written when Exec returns. Time
+// spent waiting for one of the host's operation slots (see AcquireOp)
+// counts against ctx.
+func Exec(ctx context.Context, client *ssh.Client, req ExecRequest) (int, error) {
+	if client == nil {
+		return -1, fmt.Errorf("SSH client not available")
+	}
+	release, err := AcquireOp(ctx, client)
+	if err != nil {
+		return -1, err
+	}
+	defer release()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return -1, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	session.Stdin = req.Stdin
+	session.Stdout = req.Stdout
+	session.Stderr = req.Stderr
+	if err := session.Start(execScript(req.Command, req.Dir)); err != nil {
+		return -1, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+
+	select {
+	case err := <-done:
+		var exitErr *ssh.ExitError
+		var missingErr *ssh.ExitMissingError
+		switch {
+		case err == nil:
+			return 0, nil
+		case errors.As(err, &exitErr):
+			if exitErr.Signal() != "" {
+				return -1, nil
+			}
+			return exitErr.ExitStatus(), nil
+		case errors.As(err, &missingErr):
+			return -1, nil
+		}
+		return -1, fmt.Errorf("command failed: %w", err)
+	case <-ctx.Done():
+		// Not every server delivers signals; closing the channel hangs up on
+		// the command either way
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		<-done
+		return -1, ctx.Err()
+	}
+}
+
+// Exec runs a command in its own session on the connection
+func (conn *Connection) Exec(ctx context.Context, req ExecRequest) (int, error) {
+	conn.mu.Lock()
+	if !conn.connected {
+		conn.mu.Unlock()
+		return -1, fmt.Errorf("connection is not active")
+	}
+	conn.mu.Unlock()
+
+	code, err := Exec(ctx, conn.Client, req)
+	conn.touch()
+	return code, err
+}
+
+// execScript builds the sh invocation Exec runs, changing to dir first
+// when one is given
+func execScript(cmd, dir string) string {
+	script := cmd
+	if dir != "" {
+		// On its own line, cmd can't be parsed as part of the cd
+		script = "cd " + shellargs.Quote(dir) + " || exit 1\n" + cmd
+	}
+	return "sh -c " + shellargs.Quote(script)
+}
+
+// newBatchMarker returns a random marker that can't plausibly appear in the
+// output of a batched command
+func newBatchMarker() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate batch marker: %w", err)
+	}
+	return "__rc_batch_" + hex.EncodeToString(b), nil
+}
+
+// batchScript builds a POSIX sh script that runs each command and follows its
+// output with "\n<marker> <exit status>\n". The script is passed to sh
+// explicitly so batching works whatever the user's login shell is.
+func batchScript(cmds []string, marker string) string {
+	var b strings.Builder
+	for _, cmd := range cmds {
+		// The newline before ")" keeps a trailing comment in cmd from
+		// swallowing the rest of the line
+		b.WriteString("(\n")
+		b.WriteString(cmd)
+		b.WriteString("\n) </dev/null 2>/dev/null\n")
+		b.WriteString("printf '\\n" + marker + " %d\\n' $?\n")
+	}
+	return "sh -c " + shellargs.Quote(b.String())
+}
+
+// parseBatchOutput splits batch output on the markers written by batchScript.
+// The newline printed before each marker belongs to the script, so output
+// is returned exactly as the command wrote it.
+func parseBatchOutput(output, marker string, count int) ([]BatchResult, error) {
+	separator := "\n" + marker + " "
+	results := make([]BatchResult, 0, count)
+
+	for len(results) < count {
+		i := strings.Index(output, separator)
+		if i < 0 {
+			return nil, fmt.Errorf("command batch ended after %d of %d commands", len(results), count)
+		}
+		rest := output[i+len(separator):]
+		end := strings.IndexByte(rest, '\n')
+		if end < 0 {
+			return nil, fmt.Errorf("command batch ended after %d of %d commands", len(results), count)
+		}
+		code, err := strconv.Atoi(rest[:end])
+		if err != nil {
+			return nil, fmt.Errorf("malformed exit status %q in command batch", rest[:end])
+		}
+
+		results = append(results, BatchResult{Output: output[:i], ExitCode: code})
+		output = rest[end+1:]
+	}
+
+	return results, nil
+}
@@ -0,0 +1,110 @@
+package ssh
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// runLocalBatch runs a batch script with the local shell, as the remote
+// login shell would
+func runLocalBatch(t *testing.T, cmds []string) []BatchResult {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	marker, err := newBatchMarker()
+	if err != nil {
+		t.Fatalf("newBatchMarker: %v", err)
+	}
+	output, err := exec.Command("sh", "-c", batchScript(cmds, marker)).Output()
+	if err != nil {
+		t.Fatalf("batch script failed: %v", err)
+	}
+	results, err := parseBatchOutput(string(output), marker, len(cmds))
+	if err != nil {
+		t.Fatalf("parseBatchOutput: %v\noutput: %q", err, output)
+	}
+	return results
+}
+
+func TestRunBatchSeparatesCommands(t *testing.T) {
+	results := runLocalBatch(t, []string{
+		"echo one",
+		"printf 'no newline'",
+		"false",
+		"echo partial; exit 3",
+		"cd / && pwd",
+		"pwd | grep -qx / || echo 'cd did not leak'",
+		"echo 'it'\"'\"'s quoted' # trailing comment",
+		"echo to stderr >&2",
+		"printf '\\n\\n'",
+		"cat",
+	})
+
+	want := []BatchResult{
+		{Output: "one\n"},
+		{Output: "no newline"},
+		{ExitCode: 1},
+		{Output: "partial\n", ExitCode: 3},
+		{Output: "/\n"},
+		{Output: "cd did not leak\n"},
+		{Output: "it's quoted\n"},
+		{},
+		{Output: "\n\n"},
+		{},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("results:\n%q\nwant:\n%q", results, want)
+	}
+}
+
+func TestParseBatchOutputTruncated(t *testing.T) {
+	marker := "__rc_batch_test"
+	if _, err := parseBatchOutput("one\n\n"+marker+" 0\ntwo", marker, 2); err == nil {
+		t.Error("expected an error when a command's marker is missing")
+	}
+	if _, err := parseBatchOutput("\n"+marker+" x\n", marker, 1); err == nil {
+		t.Error("expected an error for a malformed exit status")
+	}
+}
+
+func TestExecScript(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	dir := t.TempDir()
+
+	tests := []struct {
+		cmd, dir  string
+		stdout    string
+		stderr    string
+		exitCode  int
+		anyStderr bool // The shell's own message, which varies
+	}{
+		{cmd: "pwd", dir: dir, stdout: dir + "\n"},
+		{cmd: "echo out; echo err >&2; exit 4", stdout: "out\n", stderr: "err\n", exitCode: 4},
+		{cmd: "echo 'it'\"'\"'s' # comment", dir: dir, stdout: "it's\n"},
+		{cmd: "echo never", dir: dir + "/missing", exitCode: 1, anyStderr: true},
+	}
+	for _, tt := range tests {
+		var stdout, stderr strings.Builder
+		c := exec.Command("sh", "-c", execScript(tt.cmd, tt.dir))
+		c.Stdout, c.Stderr = &stdout, &stderr
+		code := 0
+		if err := c.Run(); err != nil {
+			exitErr, ok := err.(*exec.ExitError)
+			if !ok {
+				t.Fatalf("%q: %v", tt.cmd, err)
+			}
+			code = exitErr.ExitCode()
+		}
+		if stdout.String() != tt.stdout || code != tt.exitCode {
+			t.Errorf("%q in %q: stdout %q, exit %d; want %q, %d", tt.cmd, tt.dir, stdout.String(), code, tt.stdout, tt.exitCode)
+		}
+		if tt.anyStderr && stderr.Len() == 0 || !tt.anyStderr && stderr.String() != tt.stderr {
+			t.Errorf("%q in %q: stderr %q", tt.cmd, tt.dir, stderr.String())
+		}
+	}
+}
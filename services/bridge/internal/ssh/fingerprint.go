@@ -0,0 +1,62 @@
+package ssh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sort"
+	"strings"
+)
+
+// machineIDCommand prints the remote machine-id, or nothing where there is
+// none (non-systemd hosts, macOS)
+const machineIDCommand = "cat /etc/machine-id 2>/dev/null || cat /var/lib/dbus/machine-id 2>/dev/null"
+
+// Fingerprint identifies the machine behind the connection: a hash of its SSH
+// host key and, when readable, its machine-id. Host configs that reach one
+// machine under different names or addresses get the same fingerprint, while
+// machines sharing a host key (cloned images) are told apart by machine-id.
+// The machine-id is read once, on first call.
+func (c *Connection) Fingerprint() string {
+	c.fingerprintOnce.Do(func() {
+		var machineID string
+		if results, err := RunBatch(c.Client, []string{machineIDCommand}); err != nil {
+			log.Printf("[WARN] [SSH] Could not read machine-id for hostID=%s: %v", c.ID, err)
+		} else {
+			machineID = strings.TrimSpace(results[0].Output)
+		}
+		c.fingerprint = identityFingerprint(c.hostKey, machineID)
+	})
+	return c.fingerprint
+}
+
+// identityFingerprint hashes a marshaled host key and machine-id
+func identityFingerprint(hostKey []byte, machineID string) string {
+	h := sha256.New()
+	h.Write(hostKey)
+	h.Write([]byte{0})
+	h.Write([]byte(machineID))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// DuplicateHosts returns the other connected hosts that reach the same
+// machine as hostID, sorted by host ID
+func (m *Manager) DuplicateHosts(hostID string) []string {
+	conn := m.GetConnection(hostID)
+	if conn == nil {
+		return nil
+	}
+	fingerprint := conn.Fingerprint()
+
+	var duplicates []string
+	for _, otherID := range m.GetAllConnections() {
+		if otherID == hostID {
+			continue
+		}
+		if other := m.GetConnection(otherID); other != nil && other.Fingerprint() == fingerprint {
+			duplicates = append(duplicates, otherID)
+		}
+	}
+	sort.Strings(duplicates)
+	return duplicates
+}
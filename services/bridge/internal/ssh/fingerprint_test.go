@@ -0,0 +1,21 @@
+package ssh
+
+import "testing"
+
+func TestIdentityFingerprint(t *testing.T) {
+	key := []byte("ssh-ed25519 AAAA")
+	base := identityFingerprint(key, "4c4c4544")
+	if base != identityFingerprint(key, "4c4c4544") {
+		t.Error("fingerprint isn't stable")
+	}
+	for name, other := range map[string]string{
+		"other host key":     identityFingerprint([]byte("ssh-ed25519 BBBB"), "4c4c4544"),
+		"cloned host key":    identityFingerprint(key, "9f8e7d6c"),
+		"unreadable machine": identityFingerprint(key, ""),
+		"shifted boundary":   identityFingerprint([]byte("ssh-ed25519 AAAA4c4c"), "4544"),
+	} {
+		if other == base {
+			t.Errorf("%s: same fingerprint", name)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package ssh
+
+import "time"
+
+// ConnectionFailure is how a host's connection was last lost
+type ConnectionFailure struct {
+	Error string
+	At    time.Time
+}
+
+// Health describes a host's connection for diagnostics
+type Health struct {
+	ConnectedAt       time.Time
+	KeepaliveInterval time.Duration
+	LastKeepalive     time.Time          // Zero before the first keepalive was answered
+	LastKeepaliveRTT  time.Duration      // How long the server took to answer it
+	LastFailure       *ConnectionFailure // Nil if no connection to the host was lost
+	Channels          ChannelUsage
+	Ops               OpUsage
+}
+
+// recordKeepalive notes a keepalive sent at start that was answered
+func (conn *Connection) recordKeepalive(start time.Time) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.lastKeepalive = time.Now()
+	conn.lastKeepaliveRTT = conn.lastKeepalive.Sub(start)
+}
+
+// Health returns the health of a host's connection, or false if it is not
+// connected
+func (m *Manager) Health(hostID string) (Health, bool) {
+	conn := m.GetConnection(hostID)
+	if conn == nil {
+		return Health{}, false
+	}
+
+	conn.mu.Lock()
+	if !conn.connected {
+		conn.mu.Unlock()
+		return Health{}, false
+	}
+	health := Health{
+		ConnectedAt:       conn.connectedAt,
+		KeepaliveInterval: m.KeepAliveInterval,
+		LastKeepalive:     conn.lastKeepalive,
+		LastKeepaliveRTT:  conn.lastKeepaliveRTT,
+	}
+	conn.mu.Unlock()
+
+	health.Channels = conn.ChannelUsage()
+	health.Ops = conn.OpUsage()
+	if failure, ok := m.failures.Load(hostID); ok {
+		f := failure.(ConnectionFailure)
+		health.LastFailure = &f
+	}
+	return health, true
+}
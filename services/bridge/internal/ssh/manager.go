@@ -1,13 +1,19 @@
 package ssh
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 // Connection represents an active SSH connection to a host
@@ -21,6 +27,35 @@ type Connection struct {
 	lastUsed     time.Time
 	connected    bool
 	reconnecting bool
+
+	// Keepalive health, guarded by mu, see health.go
+	connectedAt      time.Time
+	lastKeepalive    time.Time
+	lastKeepaliveRTT time.Duration
+
+	hostKey         []byte // Marshaled host key presented in the handshake
+	fingerprint     string
+	fingerprintOnce sync.Once
+
+	platform Platform // Guarded by mu, see platform.go
+
+	// Channel accounting and secondary tunnel connections, see channels.go
+	channels    *channelCount
+	maxChannels int
+	tunnelMu    sync.Mutex
+	tunnels     []*tunnelConn
+	maxTunnels  int
+	dialTunnel  func() (*ssh.Client, *channelCount, error)
+
+	// Remote operations running at once, see oplimit.go
+	ops *opLimiter
+}
+
+// touch records that the connection was just used
+func (conn *Connection) touch() {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.lastUsed = time.Now()
 }
 
 // Manager manages SSH connections to remote hosts
@@ -31,20 +66,70 @@ type Manager struct {
 	// Timeouts and settings
 	DialTimeout      time.Duration
 	KeepAliveInterval time.Duration
+
+	// Channels allowed per connection, and secondary connections per host
+	// for tunnel traffic past that, see channels.go
+	MaxChannels          int
+	MaxTunnelConnections int
+
+	// Remote operations run at once per host, and the hosts that override
+	// it, see oplimit.go
+	MaxConcurrentOps int
+	hostOps          sync.Map // map[hostID]int
+
+	// Called when a connection is lost without Disconnect being called
+	onConnectionLost func(hostID string, err error)
+
+	// Last lost connection per host, kept across reconnects (see health.go)
+	failures sync.Map // map[hostID]ConnectionFailure
 }
 
 // NewManager creates a new SSH connection manager
 func NewManager() *Manager {
 	m := &Manager{
-		DialTimeout:      30 * time.Second,
-		KeepAliveInterval: 30 * time.Second,
+		DialTimeout:          30 * time.Second,
+		KeepAliveInterval:    30 * time.Second,
+		MaxChannels:          DefaultMaxChannels,
+		MaxTunnelConnections: DefaultMaxTunnelConnections,
+		MaxConcurrentOps:     DefaultMaxConcurrentOps,
 	}
 	return m
 }
 
+// OnConnectionLost registers a callback invoked when a connection dies on its
+// own (e.g. a keepalive fails). It is not called for Disconnect.
+func (m *Manager) OnConnectionLost(handler func(hostID string, err error)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onConnectionLost = handler
+}
+
+// AuthError is a handshake that failed after the host key was accepted,
+// without the connection dropping: the server refused the credentials
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string { return e.Err.Error() }
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// IsAuthError reports whether err from Connect was caused by the server
+// rejecting the credentials
+func IsAuthError(err error) bool {
+	var authErr *AuthError
+	return errors.As(err, &authErr)
+}
+
+// isConnectionError reports whether err is the connection dropping or
+// failing rather than the server answering
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
+
 // AuthConfig contains SSH authentication configuration
 type AuthConfig struct {
-	AuthType   string // "password" or "key"
+	AuthType   string // "password", "key" or "agent"
 	Password   string
 	PrivateKey string
 }
@@ -74,34 +159,47 @@ func (m *Manager) Connect(hostID, host string, port int, username string, auth A
 		return nil, fmt.Errorf("failed to build SSH config: %w", err)
 	}
 
-	// Dial with timeout
-	addr := fmt.Sprintf("%s:%d", host, port)
-	log.Printf("[DEBUG] [SSH] Dialing %s...", addr)
-
-	netConn, err := net.DialTimeout("tcp", addr, m.DialTimeout)
-	if err != nil {
-		log.Printf("[ERROR] [SSH] Failed to dial %s: %v", addr, err)
-		return nil, fmt.Errorf("failed to connect: %w", err)
+	// Remember the host key: it identifies the machine, see Fingerprint
+	var hostKey []byte
+	verifyHostKey := config.HostKeyCallback
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		hostKey = key.Marshal()
+		return verifyHostKey(hostname, remote, key)
 	}
 
-	// Perform SSH handshake
-	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, addr, config)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	ops := newOpLimiter(m.maxConcurrentOps(hostID))
+	client, channels, err := m.dial(addr, config, ops)
 	if err != nil {
-		netConn.Close()
-		log.Printf("[ERROR] [SSH] SSH handshake failed for %s: %v", addr, err)
-		return nil, fmt.Errorf("SSH handshake failed: %w", err)
+		return nil, err
 	}
 
-	client := ssh.NewClient(sshConn, chans, reqs)
-
 	conn := &Connection{
-		ID:        hostID,
-		Client:    client,
-		Host:      host,
-		Port:      port,
-		Username:  username,
-		lastUsed:  time.Now(),
-		connected: true,
+		ID:          hostID,
+		Client:      client,
+		Host:        host,
+		Port:        port,
+		Username:    username,
+		lastUsed:    time.Now(),
+		connected:   true,
+		connectedAt: time.Now(),
+		hostKey:     hostKey,
+		channels:    channels,
+		maxChannels: m.MaxChannels,
+		maxTunnels:  m.MaxTunnelConnections,
+		ops:         ops,
+	}
+
+	// Secondary tunnel connections must reach the same machine
+	tunnelConfig := *config
+	tunnelConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if !bytes.Equal(key.Marshal(), hostKey) {
+			return fmt.Errorf("host key differs from the primary connection's")
+		}
+		return nil
+	}
+	conn.dialTunnel = func() (*ssh.Client, *channelCount, error) {
+		return m.dial(addr, &tunnelConfig, ops)
 	}
 
 	m.connections.Store(hostID, conn)
@@ -113,6 +211,58 @@ func (m *Manager) Connect(hostID, host string, port int, username string, auth A
 	return conn, nil
 }
 
+// dial opens an SSH connection whose channels are counted, and whose
+// operations are bounded by ops
+func (m *Manager) dial(addr string, config *ssh.ClientConfig, ops *opLimiter) (*ssh.Client, *channelCount, error) {
+	log.Printf("[DEBUG] [SSH] Dialing %s...", addr)
+
+	netConn, err := net.DialTimeout("tcp", addr, m.DialTimeout)
+	if err != nil {
+		log.Printf("[ERROR] [SSH] Failed to dial %s: %v", addr, err)
+		return nil, nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	// Perform SSH handshake. Once the host key is accepted only
+	// authentication is left, so a failure after it is the credentials'
+	hostKeyAccepted := false
+	handshakeConfig := *config
+	handshakeConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := config.HostKeyCallback(hostname, remote, key)
+		hostKeyAccepted = err == nil
+		return err
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, addr, &handshakeConfig)
+	if err != nil {
+		netConn.Close()
+		log.Printf("[ERROR] [SSH] SSH handshake failed for %s: %v", addr, err)
+		err = fmt.Errorf("SSH handshake failed: %w", err)
+		if hostKeyAccepted && !isConnectionError(err) {
+			return nil, nil, &AuthError{Err: err}
+		}
+		return nil, nil, err
+	}
+
+	client, channels := newCountingClient(sshConn, chans, reqs, ops)
+	return client, channels, nil
+}
+
+// agentSigners returns the keys held by the bridge's SSH agent. Each
+// handshake connects to the agent anew, since tunnel connections are dialed
+// long after the first; the signers keep the connection until they are
+// dropped after the handshake.
+func agentSigners() ([]ssh.Signer, error) {
+	sock, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SSH agent: %w", err)
+	}
+	signers, err := agent.NewClient(sock).Signers()
+	if err != nil {
+		sock.Close()
+		return nil, fmt.Errorf("failed to list SSH agent keys: %w", err)
+	}
+	return signers, nil
+}
+
 // buildSSHConfig creates an SSH client config from auth configuration
 func (m *Manager) buildSSHConfig(username string, auth AuthConfig) (*ssh.ClientConfig, error) {
 	var authMethods []ssh.AuthMethod
@@ -149,6 +299,13 @@ func (m *Manager) buildSSHConfig(username string, auth AuthConfig) (*ssh.ClientC
 		authMethods = append(authMethods, ssh.PublicKeys(signer))
 		log.Printf("[DEBUG] [SSH] Using private key authentication")
 
+	case "agent":
+		if os.Getenv("SSH_AUTH_SOCK") == "" {
+			return nil, fmt.Errorf("SSH_AUTH_SOCK is not set for the bridge, so there is no SSH agent to use")
+		}
+		authMethods = append(authMethods, ssh.PublicKeysCallback(agentSigners))
+		log.Printf("[DEBUG] [SSH] Using SSH agent authentication")
+
 	default:
 		return nil, fmt.Errorf("unsupported auth type: %s", auth.AuthType)
 	}
@@ -177,15 +334,55 @@ func (m *Manager) keepAlive(conn *Connection) {
 		conn.mu.Unlock()
 
 		// Send keepalive request
+		start := time.Now()
 		_, _, err := conn.Client.SendRequest("keepalive@openssh.com", true, nil)
 		if err != nil {
-			log.Printf("[WARN] [SSH] Keepalive failed for hostID=%s: %v", conn.ID, err)
-			m.markDisconnected(conn.ID)
+			m.connectionLost(conn, err)
 			return
 		}
+		conn.recordKeepalive(start)
 	}
 }
 
+// connectionLost marks a connection dead and calls the OnConnectionLost
+// handler, once per connection
+func (m *Manager) connectionLost(conn *Connection, err error) {
+	// Ignore failures caused by Disconnect or by the connection having
+	// been replaced by a newer one for the same host
+	conn.mu.Lock()
+	wasConnected := conn.connected
+	conn.connected = false
+	conn.mu.Unlock()
+	if !wasConnected || m.GetConnection(conn.ID) != conn {
+		return
+	}
+	log.Printf("[WARN] [SSH] Connection lost for hostID=%s: %v", conn.ID, err)
+	m.failures.Store(conn.ID, ConnectionFailure{Error: err.Error(), At: time.Now()})
+
+	m.mu.Lock()
+	handler := m.onConnectionLost
+	m.mu.Unlock()
+	if handler != nil {
+		handler(conn.ID, err)
+	}
+}
+
+// CheckConnection verifies a host's connection without waiting for the next
+// keepalive, for callers that saw an operation fail in a way that suggests
+// the connection died. A dead connection is handled as a failed keepalive
+// would be. It reports whether the connection is alive.
+func (m *Manager) CheckConnection(hostID string, cause error) bool {
+	conn := m.GetConnection(hostID)
+	if conn == nil {
+		return false
+	}
+	if conn.IsAlive() {
+		return true
+	}
+	m.connectionLost(conn, cause)
+	return false
+}
+
 // IsAlive checks if the SSH connection is still alive by sending a test request
 func (c *Connection) IsAlive() bool {
 	c.mu.Lock()
@@ -227,6 +424,7 @@ func (m *Manager) Disconnect(hostID string) error {
 	conn.connected = false
 	conn.mu.Unlock()
 
+	conn.closeTunnels()
 	if conn.Client != nil {
 		if err := conn.Client.Close(); err != nil {
 			log.Printf("[WARN] [SSH] Error closing connection for hostID=%s: %v", hostID, err)
@@ -239,20 +437,10 @@ func (m *Manager) Disconnect(hostID string) error {
 	return nil
 }
 
-// markDisconnected marks a connection as disconnected without closing it
-func (m *Manager) markDisconnected(hostID string) {
-	conn := m.GetConnection(hostID)
-	if conn != nil {
-		conn.mu.Lock()
-		conn.connected = false
-		conn.mu.Unlock()
-		log.Printf("[DEBUG] [SSH] Marked hostID=%s as disconnected", hostID)
-	}
-}
-
 // removeConnection removes a connection from the manager
 func (m *Manager) removeConnection(hostID string) {
 	if conn := m.GetConnection(hostID); conn != nil {
+		conn.closeTunnels()
 		if conn.Client != nil {
 			conn.Client.Close()
 		}
@@ -310,24 +498,6 @@ func (conn *Connection) CreateSession() (*ssh.Session, error) {
 		return nil, fmt.Errorf("failed to create SSH session: %w", err)
 	}
 
-	conn.lastUsed = time.Now()
+	conn.touch()
 	return session, nil
 }
-
-// Dial creates a new connection through the SSH tunnel
-func (conn *Connection) Dial(network, addr string) (net.Conn, error) {
-	conn.mu.Lock()
-	if !conn.connected {
-		conn.mu.Unlock()
-		return nil, fmt.Errorf("connection is not active")
-	}
-	conn.mu.Unlock()
-
-	netConn, err := conn.Client.Dial(network, addr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to dial through SSH tunnel: %w", err)
-	}
-
-	conn.lastUsed = time.Now()
-	return netConn, nil
-}
@@ -0,0 +1,41 @@
+package ssh
+
+import (
+	"net"
+	"testing"
+)
+
+func TestConnectReportsAuthErrors(t *testing.T) {
+	srv := startCappedServer(t, 10)
+	port := srv.listener.Addr().(*net.TCPAddr).Port
+	m := NewManager()
+	t.Cleanup(m.Close)
+
+	_, err := m.Connect("host-1", "127.0.0.1", port, "user", AuthConfig{AuthType: "password", Password: "wrong"})
+	if err == nil || !IsAuthError(err) {
+		t.Errorf("wrong password: %v, want an auth error", err)
+	}
+
+	// A server that hangs up before authentication didn't refuse anything
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	_, err = m.Connect("host-2", "127.0.0.1", listener.Addr().(*net.TCPAddr).Port, "user", AuthConfig{AuthType: "password", Password: "secret"})
+	if err == nil || IsAuthError(err) {
+		t.Errorf("dropped connection: %v, want a non-auth error", err)
+	}
+	if IsAuthError(nil) {
+		t.Error("IsAuthError(nil)")
+	}
+}
@@ -0,0 +1,162 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultMaxConcurrentOps is the number of remote operations run at once on
+// a host unless configured otherwise. A host connecting fires its tmux scan,
+// port probes, requirements checks and env reads together, which a small
+// device's SSH daemon may not keep up with.
+const DefaultMaxConcurrentOps = 4
+
+// MaxConcurrentOpsLimit is the highest per-host operation limit
+const MaxConcurrentOpsLimit = 64
+
+// opLimiter bounds the remote operations (command sessions and tunnel dials)
+// running at once on a host. Operations past the limit wait their turn in
+// the order they asked.
+type opLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	running int
+	queue   []chan struct{} // Closed when the waiter is given a slot
+}
+
+func newOpLimiter(limit int) *opLimiter {
+	return &opLimiter{limit: max(limit, 1)}
+}
+
+// acquire takes a slot, waiting until one is free or ctx is done. The
+// returned func gives the slot back; calling it more than once is harmless.
+// A nil limiter lets everything through.
+func (l *opLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	l.mu.Lock()
+	if l.running < l.limit && len(l.queue) == 0 {
+		l.running++
+		l.mu.Unlock()
+		return l.releaser(), nil
+	}
+	ready := make(chan struct{})
+	l.queue = append(l.queue, ready)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return l.releaser(), nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if i := slices.Index(l.queue, ready); i >= 0 {
+			l.queue = slices.Delete(l.queue, i, i+1)
+		} else {
+			// Given a slot just as ctx ended; pass it on
+			l.running--
+			l.grant()
+		}
+		return nil, fmt.Errorf("timed out waiting for one of the host's %d operation slots: %w", l.limit, ctx.Err())
+	}
+}
+
+func (l *opLimiter) releaser() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.running--
+			l.grant()
+		})
+	}
+}
+
+// grant hands free slots to waiters, oldest first. The caller holds l.mu.
+func (l *opLimiter) grant() {
+	for l.running < l.limit && len(l.queue) > 0 {
+		l.running++
+		close(l.queue[0])
+		l.queue = l.queue[1:]
+	}
+}
+
+// setLimit changes the limit. Operations already running keep their slots
+// when it is lowered; waiters are let in when it is raised.
+func (l *opLimiter) setLimit(limit int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = max(limit, 1)
+	l.grant()
+}
+
+// OpUsage reports a host's remote operations
+type OpUsage struct {
+	Limit   int // Operations allowed at once
+	Running int
+	Queued  int // Waiting for a slot
+}
+
+func (l *opLimiter) usage() OpUsage {
+	if l == nil {
+		return OpUsage{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return OpUsage{Limit: l.limit, Running: l.running, Queued: len(l.queue)}
+}
+
+// AcquireOp takes one of the operation slots of the host client is
+// connected to, for work that opens channels on the raw *ssh.Client, such as
+// a probe through it. It waits until a slot is free or ctx is done, so a
+// caller's timeout covers its time in the queue. The returned func gives
+// the slot back. Clients not made by a Manager have no limit.
+func AcquireOp(ctx context.Context, client *ssh.Client) (func(), error) {
+	if client == nil {
+		return func() {}, nil
+	}
+	counting, ok := client.Conn.(*countingConn)
+	if !ok {
+		return func() {}, nil
+	}
+	return counting.ops.acquire(ctx)
+}
+
+// OpUsage returns the remote operations running and waiting on the host
+func (conn *Connection) OpUsage() OpUsage {
+	return conn.ops.usage()
+}
+
+// SetHostMaxConcurrentOps sets how many remote operations run at once on a
+// host, taking effect on its connection right away if it has one; 0 goes
+// back to MaxConcurrentOps
+func (m *Manager) SetHostMaxConcurrentOps(hostID string, limit int) {
+	if limit > 0 {
+		m.hostOps.Store(hostID, limit)
+	} else {
+		m.hostOps.Delete(hostID)
+	}
+	if conn := m.GetConnection(hostID); conn != nil {
+		conn.ops.setLimit(m.maxConcurrentOps(hostID))
+	}
+}
+
+// maxConcurrentOps returns the operation limit of a host
+func (m *Manager) maxConcurrentOps(hostID string) int {
+	if limit, ok := m.hostOps.Load(hostID); ok {
+		return limit.(int)
+	}
+	if m.MaxConcurrentOps > 0 {
+		return m.MaxConcurrentOps
+	}
+	return DefaultMaxConcurrentOps
+}
@@ -0,0 +1,278 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// countingServer is an SSH server, accepting the password "secret", that
+// takes a while over each exec request and tunnel open and records the most
+// it was handling at once
+type countingServer struct {
+	listener net.Listener
+	delay    time.Duration
+
+	mu      sync.Mutex
+	active  int
+	maxSeen int
+	handled int
+}
+
+func startCountingServer(t *testing.T, delay time.Duration) *countingServer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey: %v", err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	srv := &countingServer{listener: listener, delay: delay}
+
+	var wg sync.WaitGroup
+	var netConns []net.Conn
+	t.Cleanup(func() {
+		listener.Close()
+		srv.mu.Lock()
+		for _, c := range netConns {
+			c.Close()
+		}
+		srv.mu.Unlock()
+		wg.Wait()
+	})
+
+	go func() {
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			srv.mu.Lock()
+			netConns = append(netConns, netConn)
+			srv.mu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, chans, reqs, err := ssh.NewServerConn(netConn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newCh := range chans {
+					go srv.handle(newCh)
+				}
+			}()
+		}
+	}()
+	return srv
+}
+
+// busy counts an operation as handled for the server's delay
+func (srv *countingServer) busy() {
+	srv.mu.Lock()
+	srv.active++
+	srv.maxSeen = max(srv.maxSeen, srv.active)
+	srv.mu.Unlock()
+
+	time.Sleep(srv.delay)
+
+	srv.mu.Lock()
+	srv.active--
+	srv.handled++
+	srv.mu.Unlock()
+}
+
+func (srv *countingServer) handle(newCh ssh.NewChannel) {
+	if newCh.ChannelType() == "direct-tcpip" {
+		srv.busy()
+		ch, reqs, err := newCh.Accept()
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		io.Copy(io.Discard, ch)
+		ch.Close()
+		return
+	}
+
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		srv.busy()
+		ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+		return
+	}
+}
+
+func (srv *countingServer) stats() (maxSeen, handled int) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.maxSeen, srv.handled
+}
+
+func (srv *countingServer) connect(t *testing.T, m *Manager) *Connection {
+	t.Helper()
+	port := srv.listener.Addr().(*net.TCPAddr).Port
+	conn, err := m.Connect("host-1", "127.0.0.1", port, "user", AuthConfig{AuthType: "password", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(m.Close)
+	return conn
+}
+
+func TestOpLimitHoldsDuringConnectStorm(t *testing.T) {
+	srv := startCountingServer(t, 15*time.Millisecond)
+	m := NewManager()
+	m.SetHostMaxConcurrentOps("host-1", 3)
+	conn := srv.connect(t, m)
+
+	// What a host connect fires at once: tmux and requirements commands,
+	// per-port probes through the raw client and tunnel dials
+	const each = 12
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*each)
+	for i := 0; i < each; i++ {
+		wg.Go(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if _, err := conn.Exec(ctx, ExecRequest{Command: "tmux ls", Stdout: io.Discard, Stderr: io.Discard}); err != nil {
+				errs <- err
+			}
+		})
+		wg.Go(func() {
+			// The fake runs nothing, so the batch's output can't be parsed
+			RunBatch(conn.Client, []string{"command -v claude"})
+		})
+		wg.Go(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			release, err := AcquireOp(ctx, conn.Client)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer release()
+			c, err := conn.Client.Dial("tcp", "localhost:3284")
+			if err != nil {
+				errs <- err
+				return
+			}
+			c.Close()
+		})
+		wg.Go(func() {
+			c, err := conn.Dial("tcp", "localhost:3285")
+			if err != nil {
+				errs <- err
+				return
+			}
+			c.Close()
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("operation failed: %v", err)
+	}
+
+	maxSeen, handled := srv.stats()
+	if maxSeen > 3 {
+		t.Errorf("server handled %d operations at once, want at most 3", maxSeen)
+	}
+	if maxSeen < 2 {
+		t.Errorf("server handled at most %d operation at once; the storm never ran concurrently", maxSeen)
+	}
+	if handled != 4*each {
+		t.Errorf("server handled %d operations, want %d", handled, 4*each)
+	}
+	if usage := conn.OpUsage(); usage != (OpUsage{Limit: 3}) {
+		t.Errorf("usage after the storm = %+v, want nothing running or queued", usage)
+	}
+}
+
+func TestQueuedOpTimesOut(t *testing.T) {
+	srv := startCountingServer(t, time.Millisecond)
+	m := NewManager()
+	m.MaxConcurrentOps = 1
+	conn := srv.connect(t, m)
+
+	release, err := AcquireOp(context.Background(), conn.Client)
+	if err != nil {
+		t.Fatalf("AcquireOp: %v", err)
+	}
+
+	// The command's timeout runs out in the queue
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = conn.Exec(ctx, ExecRequest{Command: "true", Stdout: io.Discard})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Exec error = %v, want the deadline", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("Exec gave up after %s, want about 50ms", waited)
+	}
+	if usage := conn.OpUsage(); usage != (OpUsage{Limit: 1, Running: 1}) {
+		t.Errorf("usage = %+v, want the held slot and an empty queue", usage)
+	}
+
+	// Raising the limit lets a waiter in without the slot being released
+	got := make(chan error, 1)
+	go func() {
+		_, err := conn.Exec(context.Background(), ExecRequest{Command: "true", Stdout: io.Discard})
+		got <- err
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for conn.OpUsage().Queued != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Exec never queued: %+v", conn.OpUsage())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	m.SetHostMaxConcurrentOps("host-1", 2)
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Errorf("Exec after raising the limit: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("raising the limit didn't let the queued Exec run")
+	}
+	release()
+	if usage := conn.OpUsage(); usage != (OpUsage{Limit: 2}) {
+		t.Errorf("usage = %+v, want everything released", usage)
+	}
+}
@@ -0,0 +1,102 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Platform is what a host runs, read once per connection so code that
+// differs between hosts can pick its path up front rather than try each one
+// and fall back on failure. The zero Platform is an unknown one.
+type Platform struct {
+	OS          string // uname -s: "Linux", "Darwin", ...
+	Arch        string // uname -m: "x86_64", "arm64", ...
+	Release     string // e.g. "Ubuntu 22.04.4 LTS" or "macOS 14.5"; empty when unreadable
+	Shell       string // The login shell, from $SHELL
+	TmuxVersion string // e.g. "3.3a"; empty when tmux isn't installed
+	HasProc     bool   // Whether /proc is mounted
+}
+
+// Known reports whether the platform was read
+func (p Platform) Known() bool {
+	return p.OS != ""
+}
+
+// IsDarwin reports whether the host runs macOS
+func (p Platform) IsDarwin() bool {
+	return p.OS == "Darwin"
+}
+
+// platformCommand prints the facts of Platform as key=value lines. tmuxCmd
+// is the tmux the bridge runs on the host, which need not be on the PATH.
+func platformCommand(tmuxCmd string) string {
+	return strings.Join([]string{
+		`printf 'os=%s\n' "$(uname -s 2>/dev/null)"`,
+		`printf 'arch=%s\n' "$(uname -m 2>/dev/null)"`,
+		`if [ -r /etc/os-release ]; then printf 'release=%s\n' "$(sed -n 's/^PRETTY_NAME=//p' /etc/os-release | tr -d '"')"; ` +
+			`elif command -v sw_vers >/dev/null 2>&1; then printf 'release=%s %s\n' "$(sw_vers -productName)" "$(sw_vers -productVersion)"; fi`,
+		`printf 'shell=%s\n' "$SHELL"`,
+		`printf 'tmux=%s\n' "$(` + tmuxCmd + ` 2>/dev/null)"`,
+		`if [ -r /proc/self/stat ]; then echo proc=1; else echo proc=0; fi`,
+	}, "\n")
+}
+
+// parsePlatform parses the output of platformCommand
+func parsePlatform(output string) (Platform, error) {
+	var p Platform
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "os":
+			p.OS = value
+		case "arch":
+			p.Arch = value
+		case "release":
+			p.Release = value
+		case "shell":
+			p.Shell = value
+		case "tmux":
+			p.TmuxVersion = strings.TrimPrefix(value, "tmux ")
+		case "proc":
+			p.HasProc = value == "1"
+		}
+	}
+	if !p.Known() {
+		return Platform{}, fmt.Errorf("unexpected platform output %q", output)
+	}
+	return p, nil
+}
+
+// DetectPlatform reads what the host runs, once per connection: later calls
+// return what the first one read. tmuxCmd is the command that prints the
+// version of the host's tmux, e.g. "tmux -V".
+func (conn *Connection) DetectPlatform(ctx context.Context, tmuxCmd string) (Platform, error) {
+	if p, ok := conn.Platform(); ok {
+		return p, nil
+	}
+	var out bytes.Buffer
+	if _, err := conn.Exec(ctx, ExecRequest{Command: platformCommand(tmuxCmd), Stdout: &out}); err != nil {
+		return Platform{}, err
+	}
+	p, err := parsePlatform(out.String())
+	if err != nil {
+		return Platform{}, err
+	}
+	conn.mu.Lock()
+	conn.platform = p
+	conn.mu.Unlock()
+	return p, nil
+}
+
+// Platform returns what DetectPlatform read, and false before it has
+func (conn *Connection) Platform() (Platform, bool) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.platform, conn.platform.Known()
+}
@@ -0,0 +1,54 @@
+package ssh
+
+import (
+	"os/exec"
+	"runtime"
+	"testing"
+)
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   Platform
+	}{
+		{
+			name:   "linux",
+			output: "os=Linux\narch=x86_64\nrelease=Ubuntu 22.04.4 LTS\nshell=/bin/bash\ntmux=tmux 3.2a\nproc=1\n",
+			want:   Platform{OS: "Linux", Arch: "x86_64", Release: "Ubuntu 22.04.4 LTS", Shell: "/bin/bash", TmuxVersion: "3.2a", HasProc: true},
+		},
+		{
+			name:   "macOS without tmux",
+			output: "os=Darwin\narch=arm64\nrelease=macOS 14.5\nshell=/bin/zsh\ntmux=\nproc=0\n",
+			want:   Platform{OS: "Darwin", Arch: "arm64", Release: "macOS 14.5", Shell: "/bin/zsh"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePlatform(tt.output)
+			if err != nil || got != tt.want {
+				t.Errorf("parsePlatform = %+v, %v; want %+v", got, err, tt.want)
+			}
+		})
+	}
+	if _, err := parsePlatform("sh: uname: not found\n"); err == nil {
+		t.Error("parsePlatform without an OS succeeded")
+	}
+}
+
+func TestPlatformCommand(t *testing.T) {
+	out, err := exec.Command("sh", "-c", platformCommand("tmux -V")).Output()
+	if err != nil {
+		t.Skipf("sh: %v", err)
+	}
+	p, err := parsePlatform(string(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS == "linux" && (p.OS != "Linux" || !p.HasProc) {
+		t.Errorf("platform = %+v, want Linux with /proc", p)
+	}
+	if runtime.GOOS == "darwin" && (!p.IsDarwin() || p.HasProc) {
+		t.Errorf("platform = %+v, want Darwin without /proc", p)
+	}
+}
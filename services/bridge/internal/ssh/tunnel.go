@@ -10,17 +10,24 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
+// Dialer opens connections through an SSH tunnel. Both *ssh.Client and
+// *Connection implement it; a Connection spreads tunnels over several SSH
+// connections when the host caps channels per connection.
+type Dialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
 // TunnelTransport creates an http.Transport that routes requests through an SSH tunnel
 // This is CRITICAL for security - AgentAPI has no authentication and must only be accessed
 // through the SSH tunnel
 type TunnelTransport struct {
-	sshClient *ssh.Client
+	dialer Dialer
 }
 
 // NewTunnelTransport creates a new transport that tunnels HTTP through SSH
-func NewTunnelTransport(sshClient *ssh.Client) *TunnelTransport {
+func NewTunnelTransport(dialer Dialer) *TunnelTransport {
 	return &TunnelTransport{
-		sshClient: sshClient,
+		dialer: dialer,
 	}
 }
 
@@ -31,7 +38,7 @@ func (t *TunnelTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 		DialContext: nil, // Not used, we override Dial
 		Dial: func(network, addr string) (net.Conn, error) {
 			log.Printf("[DEBUG] [SSH-TUNNEL] Dialing %s through SSH tunnel", addr)
-			conn, err := t.sshClient.Dial(network, addr)
+			conn, err := t.dialer.Dial(network, addr)
 			if err != nil {
 				log.Printf("[ERROR] [SSH-TUNNEL] Failed to dial %s: %v", addr, err)
 				return nil, err
@@ -47,9 +54,9 @@ func (t *TunnelTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 }
 
 // TunnelHTTPClient creates an HTTP client that routes all requests through the SSH tunnel
-func TunnelHTTPClient(sshClient *ssh.Client) *http.Client {
+func TunnelHTTPClient(dialer Dialer) *http.Client {
 	return &http.Client{
-		Transport: NewTunnelTransport(sshClient),
+		Transport: NewTunnelTransport(dialer),
 		Timeout:   60 * time.Second,
 	}
 }
@@ -0,0 +1,358 @@
+// Package sshconfig reads OpenSSH client config files (ssh_config(5)) far
+// enough to turn their Host entries into host configs: the host name, user,
+// port, identity files and jump host each alias resolves to.
+//
+// Host blocks and the options before the first of them are read; Match
+// blocks are skipped with a warning, since their conditions depend on the
+// connection being made. Include directives in the top-level file are
+// followed, but not those in the files they include.
+package sshconfig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultPort is the port of an entry without a Port option
+const DefaultPort = 22
+
+// Config is a parsed ssh_config
+type Config struct {
+	blocks   []block
+	home     string
+	Warnings []string // Lines that were skipped, and why
+}
+
+// block is a run of options that apply to the hosts matching its patterns
+type block struct {
+	patterns []string // Nil for options before the first Host, which apply to every host
+	skip     bool     // A Match block
+	options  []option
+}
+
+type option struct {
+	key   string // Lowercased keyword
+	value string // First argument
+}
+
+// Entry is what a Host alias resolves to
+type Entry struct {
+	Alias         string
+	HostName      string   // The alias when not set
+	User          string   // Empty when not set
+	Port          int      // DefaultPort when not set
+	IdentityFiles []string // In the order given, with ~ and %-tokens expanded
+	ProxyJump     string   // Empty when not set or "none"
+}
+
+// Load reads the config file at path, following its Include directives.
+// home is the user's home directory, which relative Include paths (under
+// ~/.ssh) and ~ in paths are resolved against.
+func Load(path, home string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parse(f, path, home, true)
+}
+
+// Parse reads config text that didn't come from a file, such as one a client
+// uploaded. Its Include directives are skipped with a warning.
+func Parse(r io.Reader, home string) (*Config, error) {
+	return parse(r, "config", home, false)
+}
+
+func parse(r io.Reader, name, home string, followIncludes bool) (*Config, error) {
+	c := &Config{home: home}
+	if err := c.read(r, name, followIncludes, nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// read adds the blocks of one file. patterns are those of the Host block the
+// file was included from; its options before its own first Host line belong
+// to that block.
+func (c *Config) read(r io.Reader, name string, followIncludes bool, patterns []string) error {
+	current := block{patterns: patterns}
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		key, args := splitLine(scanner.Text())
+		if key == "" {
+			continue
+		}
+		where := fmt.Sprintf("%s line %d", name, lineNo)
+
+		switch key {
+		case "host":
+			c.blocks = append(c.blocks, current)
+			if len(args) == 0 {
+				c.warn("%s: Host without patterns skipped", where)
+				current = block{skip: true}
+				continue
+			}
+			current = block{patterns: args}
+		case "match":
+			c.warn("%s: Match block skipped", where)
+			c.blocks = append(c.blocks, current)
+			current = block{skip: true}
+		case "include":
+			if current.skip {
+				continue
+			}
+			if !followIncludes {
+				c.warn("%s: Include not followed", where)
+				continue
+			}
+			// The included options go in order, and the ones after the
+			// Include still belong to this block
+			c.blocks = append(c.blocks, current)
+			for _, arg := range args {
+				c.include(arg, where, current.patterns)
+			}
+			current = block{patterns: current.patterns}
+		default:
+			if current.skip || len(args) == 0 {
+				continue
+			}
+			current.options = append(current.options, option{key: key, value: args[0]})
+		}
+	}
+	c.blocks = append(c.blocks, current)
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return nil
+}
+
+// include reads the files an Include argument names, without following
+// their own Include directives
+func (c *Config) include(arg, where string, patterns []string) {
+	path := c.expandHome(arg)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.home, ".ssh", path)
+	}
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		c.warn("%s: Include %s: %v", where, arg, err)
+		return
+	}
+	for _, match := range matches {
+		f, err := os.Open(match)
+		if err != nil {
+			c.warn("%s: Include %s: %v", where, match, err)
+			continue
+		}
+		err = c.read(f, match, false, patterns)
+		f.Close()
+		if err != nil {
+			c.warn("%s: Include %s: %v", where, match, err)
+		}
+	}
+}
+
+func (c *Config) warn(format string, args ...interface{}) {
+	warning := fmt.Sprintf(format, args...)
+	log.Printf("[DEBUG] [SSHCONFIG] %s", warning)
+	c.Warnings = append(c.Warnings, warning)
+}
+
+// splitLine returns the lowercased keyword of a config line and its
+// arguments, or "" for a blank line or comment. The keyword may be followed
+// by "=", and arguments may be double-quoted.
+func splitLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil
+	}
+	key := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+	return key, splitArgs(rest)
+}
+
+// splitArgs splits arguments on whitespace, keeping double-quoted ones
+// whole, and stops at a comment
+func splitArgs(s string) []string {
+	var args []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" || s[0] == '#' {
+			return args
+		}
+		if s[0] == '"' {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return append(args, s[1:])
+			}
+			args = append(args, s[1:end+1])
+			s = s[end+2:]
+			continue
+		}
+		end := strings.IndexAny(s, " \t")
+		if end < 0 {
+			return append(args, s)
+		}
+		args = append(args, s[:end])
+		s = s[end:]
+	}
+}
+
+// Hosts returns the aliases named by Host lines, in order: the patterns
+// without wildcards or negation, which name a single host
+func (c *Config) Hosts() []string {
+	var hosts []string
+	for _, b := range c.blocks {
+		for _, pattern := range b.patterns {
+			if strings.ContainsAny(pattern, "*?!") || slices.Contains(hosts, pattern) {
+				continue
+			}
+			hosts = append(hosts, pattern)
+		}
+	}
+	return hosts
+}
+
+// Resolve returns what alias resolves to. As with ssh, the first value found
+// for an option wins, except IdentityFile, whose values add up.
+func (c *Config) Resolve(alias string) Entry {
+	values := make(map[string]string)
+	var identityFiles []string
+	for _, b := range c.blocks {
+		if b.skip || (b.patterns != nil && !matchPatterns(alias, b.patterns)) {
+			continue
+		}
+		for _, opt := range b.options {
+			if opt.key == "identityfile" {
+				identityFiles = append(identityFiles, opt.value)
+				continue
+			}
+			if _, ok := values[opt.key]; !ok {
+				values[opt.key] = opt.value
+			}
+		}
+	}
+
+	entry := Entry{Alias: alias, HostName: alias, User: values["user"], Port: DefaultPort}
+	if hostName, ok := values["hostname"]; ok {
+		entry.HostName = strings.ReplaceAll(hostName, "%h", alias)
+	}
+	if port, ok := values["port"]; ok {
+		if n, err := strconv.Atoi(port); err == nil && n > 0 && n < 65536 {
+			entry.Port = n
+		}
+	}
+	if jump := values["proxyjump"]; jump != "" && !strings.EqualFold(jump, "none") {
+		entry.ProxyJump = jump
+	}
+	for _, file := range identityFiles {
+		if strings.EqualFold(file, "none") {
+			continue
+		}
+		entry.IdentityFiles = append(entry.IdentityFiles, c.expandPath(file, entry))
+	}
+	return entry
+}
+
+// expandPath expands ~ and the %d (home), %h (host name), %r (user) and %%
+// tokens of a path
+func (c *Config) expandPath(path string, entry Entry) string {
+	path = c.expandHome(path)
+	var out strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] != '%' || i+1 == len(path) {
+			out.WriteByte(path[i])
+			continue
+		}
+		i++
+		switch path[i] {
+		case 'd':
+			out.WriteString(c.home)
+		case 'h':
+			out.WriteString(entry.HostName)
+		case 'r':
+			out.WriteString(entry.User)
+		case '%':
+			out.WriteByte('%')
+		default:
+			out.WriteByte('%')
+			out.WriteByte(path[i])
+		}
+	}
+	return out.String()
+}
+
+// expandHome replaces a leading ~ with the home directory
+func (c *Config) expandHome(path string) string {
+	if path == "~" {
+		return c.home
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		return filepath.Join(c.home, rest)
+	}
+	return path
+}
+
+// matchPatterns reports whether host matches a Host line's patterns: at
+// least one plain pattern and none of the negated ones
+func matchPatterns(host string, patterns []string) bool {
+	host = strings.ToLower(host)
+	matched := false
+	for _, pattern := range patterns {
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			if match(strings.ToLower(negated), host) {
+				return false
+			}
+			continue
+		}
+		if match(strings.ToLower(pattern), host) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// match reports whether s matches pattern, in which * matches any run of
+// characters and ? any one
+func match(pattern, s string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i < len(s); i++ {
+				if match(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
@@ -0,0 +1,121 @@
+package sshconfig
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// loadFixture loads the config in testdata/home/.ssh, with testdata/home as
+// the home directory
+func loadFixture(t *testing.T) (*Config, string) {
+	t.Helper()
+	home, err := filepath.Abs("testdata/home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(filepath.Join(home, ".ssh", "config"), home)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return c, home
+}
+
+func TestLoadHosts(t *testing.T) {
+	c, _ := loadFixture(t)
+
+	// Included hosts come where the Include is; wildcards aren't hosts
+	want := []string{"work", "web", "db", "db-replica", "bastion"}
+	if got := c.Hosts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Hosts() = %v, want %v", got, want)
+	}
+
+	if len(c.Warnings) != 2 ||
+		!strings.Contains(c.Warnings[0], "work.conf line 4: Include not followed") ||
+		!strings.Contains(c.Warnings[1], "config line 21: Match block skipped") {
+		t.Errorf("Warnings = %q", c.Warnings)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	c, home := loadFixture(t)
+	key := func(name string) string { return filepath.Join(home, ".ssh", name) }
+
+	tests := []Entry{
+		{Alias: "web", HostName: "web.example.com", User: "deploy", Port: 2200,
+			IdentityFiles: []string{key("id_web"), key("id_ed25519")}},
+		{Alias: "db", HostName: "db.internal.example.com", User: "fallback", Port: 22,
+			IdentityFiles: []string{key("id_ed25519")}, ProxyJump: "bastion"},
+		{Alias: "bastion", HostName: "bastion.example.com", User: "jump", Port: 22,
+			IdentityFiles: []string{key("id_ed25519")}},
+		{Alias: "work", HostName: "work.example.org", User: "alice", Port: 22,
+			IdentityFiles: []string{key("id_ed25519")}},
+		// Wildcard and negated patterns, and %-tokens
+		{Alias: "api.example.com", HostName: "api.example.com", User: "wildcard", Port: 22,
+			IdentityFiles: []string{key("id_wildcard"), key("id_ed25519")}},
+		{Alias: "secret.example.com", HostName: "secret.example.com", User: "fallback", Port: 22,
+			IdentityFiles: []string{key("id_ed25519")}},
+		// Options in skipped Match blocks don't apply
+		{Alias: "build.corp", HostName: "build.corp", User: "fallback", Port: 22,
+			IdentityFiles: []string{key("id_ed25519")}},
+	}
+	for _, want := range tests {
+		if got := c.Resolve(want.Alias); !reflect.DeepEqual(got, want) {
+			t.Errorf("Resolve(%q) = %+v\nwant %+v", want.Alias, got, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	c, err := Parse(strings.NewReader(`
+include ~/.ssh/other
+HOST=one "two words"
+  hostname = one.example.com # the first one
+  PORT notaport
+  IdentityFile "/keys/my key"
+  IdentityFile none
+Host two
+  Port=2022
+`), "/home/user")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if got := c.Hosts(); !reflect.DeepEqual(got, []string{"one", "two words", "two"}) {
+		t.Errorf("Hosts() = %q", got)
+	}
+	want := Entry{Alias: "one", HostName: "one.example.com", Port: DefaultPort, IdentityFiles: []string{"/keys/my key"}}
+	if got := c.Resolve("one"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolve(one) = %+v, want %+v", got, want)
+	}
+	if got := c.Resolve("two"); got.Port != 2022 || got.HostName != "two" {
+		t.Errorf("Resolve(two) = %+v", got)
+	}
+
+	// Uploaded text can't include files from the bridge machine
+	if len(c.Warnings) != 1 || !strings.Contains(c.Warnings[0], "line 2: Include not followed") {
+		t.Errorf("Warnings = %q", c.Warnings)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "anything", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"web-?", "web-1", true},
+		{"web-?", "web-10", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"[ab]", "a", false}, // No character classes in ssh patterns
+	}
+	for _, tt := range tests {
+		if got := match(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+)
+
+// Appearance is how a host or process is shown: a named or hex color and an
+// icon name, each empty when unset. The bridge only stores them; the
+// protocol validates them.
+type Appearance struct {
+	Color string
+	Icon  string
+}
+
+// SetProcessAppearance saves the color and icon a process is shown with
+func (s *Store) SetProcessAppearance(processID string, appearance Appearance) error {
+	_, err := s.exec(`UPDATE process_metadata SET color = ?, icon = ? WHERE process_id = ?`,
+		nullString(appearance.Color), nullString(appearance.Icon), processID)
+	if err != nil {
+		return fmt.Errorf("failed to update process appearance: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Set appearance of process %s: color %q, icon %q", processID, appearance.Color, appearance.Icon)
+	return nil
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAppearance(t *testing.T) {
+	// A database from before hosts and processes had an appearance
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	old, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE ssh_hosts (id TEXT PRIMARY KEY, name TEXT NOT NULL, host TEXT NOT NULL, port INTEGER NOT NULL DEFAULT 22,
+			username TEXT NOT NULL, auth_type TEXT NOT NULL, credential_encrypted BLOB, auto_connect INTEGER NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL, updated_at INTEGER NOT NULL)`,
+		`INSERT INTO ssh_hosts VALUES ('host-1', 'web', 'web.example.com', 22, 'deploy', 'agent', NULL, 0, 0, 0)`,
+		`CREATE TABLE process_metadata (process_id TEXT PRIMARY KEY, host_id TEXT NOT NULL,
+			process_type TEXT NOT NULL, port INTEGER, tmux_name TEXT NOT NULL, started_at INTEGER NOT NULL, last_seen_at INTEGER NOT NULL)`,
+		`INSERT INTO process_metadata VALUES ('proc-1', 'host-1', 'shell', NULL, 'rc-proc-1', 0, 0)`,
+	} {
+		if _, err := old.Exec(stmt); err != nil {
+			t.Fatalf("create old schema: %v", err)
+		}
+	}
+	old.Close()
+
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+
+	host, err := s.GetSSHHost("host-1")
+	if err != nil || host == nil || host.Appearance != (Appearance{}) {
+		t.Fatalf("migrated host = %+v, %v", host, err)
+	}
+	host.Appearance = Appearance{Color: "#1e90ff", Icon: "server"}
+	if err := s.UpdateSSHHost(*host); err != nil {
+		t.Fatalf("UpdateSSHHost: %v", err)
+	}
+	if err := s.CreateSSHHost(SSHHost{ID: "host-2", Name: "db", Host: "db", Port: 22, Username: "me", AuthType: "agent",
+		Appearance: Appearance{Icon: "database"}}); err != nil {
+		t.Fatalf("CreateSSHHost: %v", err)
+	}
+	hosts, err := s.ListSSHHosts()
+	if err != nil || len(hosts) != 2 {
+		t.Fatalf("ListSSHHosts = %+v, %v", hosts, err)
+	}
+	for _, h := range hosts {
+		want := map[string]Appearance{"host-1": {Color: "#1e90ff", Icon: "server"}, "host-2": {Icon: "database"}}[h.ID]
+		if h.Appearance != want {
+			t.Errorf("%s appearance = %+v, want %+v", h.ID, h.Appearance, want)
+		}
+	}
+
+	if err := s.SetProcessAppearance("proc-1", Appearance{Color: "teal", Icon: "rocket"}); err != nil {
+		t.Fatalf("SetProcessAppearance: %v", err)
+	}
+	// Saving the metadata again, as a reattach does, keeps it
+	if err := s.SaveProcessMetadata(ProcessMetadata{ProcessID: "proc-1", HostID: "host-1", ProcessType: "shell", TmuxName: "rc-proc-1", StartedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	metas, err := s.GetProcessMetadataByHost("host-1")
+	if err != nil || len(metas) != 1 || metas[0].Appearance != (Appearance{Color: "teal", Icon: "rocket"}) {
+		t.Errorf("GetProcessMetadataByHost = %+v, %v", metas, err)
+	}
+
+	if err := s.SetProcessAppearance("proc-1", Appearance{Icon: "rocket"}); err != nil {
+		t.Fatalf("SetProcessAppearance: %v", err)
+	}
+	if meta, err := s.GetProcessMetadata("proc-1"); err != nil || meta.Appearance != (Appearance{Icon: "rocket"}) {
+		t.Errorf("appearance after clearing the color = %+v, %v", meta, err)
+	}
+}
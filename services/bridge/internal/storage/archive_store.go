@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// A killed process can be archived instead of deleted: its metadata row
+// stays with archived_at set, and its PTY history, chat history and
+// timeline stay in their tables, readable by process ID like a live
+// process's. Archived processes are left out of everything that deals with
+// live ones (host listings, ordering, port checks) until they are deleted,
+// by the user or once they are older than the archive max age.
+
+// SetArchiveMaxAge sets how long archived processes are kept before
+// PruneArchivedProcesses deletes them; 0 keeps them until deleted
+func (s *Store) SetArchiveMaxAge(maxAge time.Duration) {
+	s.archiveMaxAge.Store(int64(maxAge))
+}
+
+// ArchiveProcess archives a process: its buffered history is written out
+// and dropped from memory, and its metadata marked archived, leaving the
+// gap closed in its host's sort weights. It reports whether the process had
+// metadata to archive.
+func (s *Store) ArchiveProcess(processID string) (bool, error) {
+	if err := s.persistPtyBuffer(processID); err != nil {
+		return false, fmt.Errorf("failed to persist pty history: %w", err)
+	}
+	if err := s.persistChatBuffer(processID); err != nil {
+		return false, fmt.Errorf("failed to persist chat history: %w", err)
+	}
+	s.mu.Lock()
+	delete(s.ptyBuffers, processID)
+	delete(s.chatBuffers, processID)
+	delete(s.hostMap, processID)
+	s.mu.Unlock()
+
+	archived := false
+	err := retryBusy(func() error {
+		return s.inTx(func(tx *sql.Tx) error {
+			var hostID string
+			err := tx.QueryRow(`
+				UPDATE process_metadata SET archived_at = ?, pinned = 0
+				WHERE process_id = ? AND archived_at IS NULL RETURNING host_id`,
+				time.Now().Unix(), processID).Scan(&hostID)
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			archived = true
+			return normalizeSortWeights(tx, hostID)
+		})
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to archive process: %w", err)
+	}
+	if archived {
+		log.Printf("[DEBUG] [Storage] Archived process %s", processID)
+	}
+	return archived, nil
+}
+
+// ListArchivedProcesses returns the archived processes of a host, or of
+// every host when hostID is empty, most recently archived first
+func (s *Store) ListArchivedProcesses(hostID string) ([]ProcessMetadata, error) {
+	if hostID == "" {
+		return s.queryArchivedProcesses(`WHERE archived_at IS NOT NULL`)
+	}
+	return s.queryArchivedProcesses(`WHERE archived_at IS NOT NULL AND host_id = ?`, hostID)
+}
+
+// queryArchivedProcesses retrieves the archived processes matching a WHERE
+// clause, most recently archived first
+func (s *Store) queryArchivedProcesses(where string, args ...interface{}) ([]ProcessMetadata, error) {
+	processes, err := s.queryProcessMetadata(where, args...)
+	if err != nil {
+		return nil, err
+	}
+	// queryProcessMetadata orders by ID, which breaks ties
+	slices.SortStableFunc(processes, func(a, b ProcessMetadata) int {
+		return b.ArchivedAt.Compare(a.ArchivedAt)
+	})
+	return processes, nil
+}
+
+// GetArchivedProcess returns an archived process, or nil if there is no
+// archived process with the ID
+func (s *Store) GetArchivedProcess(processID string) (*ProcessMetadata, error) {
+	meta, err := s.GetProcessMetadata(processID)
+	if err != nil || meta == nil || meta.ArchivedAt.IsZero() {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// DeleteArchivedProcess deletes an archived process with its history. It
+// reports whether there was an archived process with the ID; a live one is
+// left alone.
+func (s *Store) DeleteArchivedProcess(processID string) (bool, error) {
+	result, err := s.exec(`DELETE FROM process_metadata WHERE process_id = ? AND archived_at IS NOT NULL`, processID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete archived process: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if err := s.UnregisterProcess(processID); err != nil {
+		return true, err
+	}
+	log.Printf("[DEBUG] [Storage] Deleted archived process %s", processID)
+	return true, nil
+}
+
+// PruneArchivedProcesses deletes the archived processes older than the
+// archive max age, with their history, and returns their IDs
+func (s *Store) PruneArchivedProcesses() ([]string, error) {
+	maxAge := time.Duration(s.archiveMaxAge.Load())
+	if maxAge <= 0 {
+		return nil, nil
+	}
+	expired, err := s.queryArchivedProcesses(`WHERE archived_at IS NOT NULL AND archived_at < ?`, time.Now().Add(-maxAge).Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []string
+	for _, meta := range expired {
+		deleted, err := s.DeleteArchivedProcess(meta.ProcessID)
+		if err != nil {
+			return pruned, err
+		}
+		if deleted {
+			pruned = append(pruned, meta.ProcessID)
+		}
+	}
+	if len(pruned) > 0 {
+		log.Printf("[INFO] [Storage] Pruned %d archived processes older than %s", len(pruned), maxAge)
+	}
+	return pruned, nil
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArchiveProcess(t *testing.T) {
+	s := newTestStore(t)
+	for _, id := range []string{"proc-1", "proc-2", "proc-3"} {
+		meta := ProcessMetadata{ProcessID: id, HostID: "host-1", ProcessType: "shell", TmuxName: "rc-" + id, StartedAt: time.Now()}
+		if err := s.SaveProcessMetadata(meta); err != nil {
+			t.Fatalf("SaveProcessMetadata: %v", err)
+		}
+		s.RegisterProcess(id, "host-1")
+	}
+	if err := s.AppendPtyOutput("proc-1", "host-1", []byte("$ ls\n")); err != nil {
+		t.Fatalf("AppendPtyOutput: %v", err)
+	}
+	if _, err := s.UpsertChatMessage("proc-1", "host-1", ChatMessage{MessageID: 1, Role: "user", Message: "hi"}); err != nil {
+		t.Fatalf("UpsertChatMessage: %v", err)
+	}
+
+	if archived, err := s.ArchiveProcess("proc-1"); err != nil || !archived {
+		t.Fatalf("ArchiveProcess = %v, %v", archived, err)
+	}
+	// Archiving twice, or a process without metadata, does nothing
+	if archived, err := s.ArchiveProcess("proc-1"); err != nil || archived {
+		t.Errorf("second ArchiveProcess = %v, %v", archived, err)
+	}
+	if archived, err := s.ArchiveProcess("proc-9"); err != nil || archived {
+		t.Errorf("ArchiveProcess of an unknown process = %v, %v", archived, err)
+	}
+
+	// The history is still there, read back from the database
+	if size := s.GetPtyHistorySize("proc-1"); size != int64(len("$ ls\n")) {
+		t.Errorf("archived PTY history size = %d", size)
+	}
+	if chat, err := s.GetChatHistory("proc-1"); err != nil || len(chat) != 1 || chat[0].Message != "hi" {
+		t.Errorf("archived chat history = %+v, %v", chat, err)
+	}
+
+	// It is out of the host's live processes, whose weights close the gap
+	metas, err := s.GetProcessMetadataByHost("host-1")
+	if err != nil || len(metas) != 2 {
+		t.Fatalf("GetProcessMetadataByHost = %+v, %v", metas, err)
+	}
+	orders, err := s.GetProcessOrders("host-1")
+	if err != nil || len(orders) != 2 || orders["proc-2"].SortWeight != 0 || orders["proc-3"].SortWeight != 1 {
+		t.Errorf("GetProcessOrders = %+v, %v", orders, err)
+	}
+
+	if meta, err := s.GetArchivedProcess("proc-1"); err != nil || meta == nil || meta.ArchivedAt.IsZero() {
+		t.Errorf("GetArchivedProcess = %+v, %v", meta, err)
+	}
+	if meta, err := s.GetArchivedProcess("proc-2"); err != nil || meta != nil {
+		t.Errorf("GetArchivedProcess of a live process = %+v, %v", meta, err)
+	}
+}
+
+func TestListArchivedProcesses(t *testing.T) {
+	s := newTestStore(t)
+	for _, p := range []struct{ id, host string }{{"proc-1", "host-1"}, {"proc-2", "host-2"}, {"proc-3", "host-1"}} {
+		if err := s.SaveProcessMetadata(ProcessMetadata{ProcessID: p.id, HostID: p.host, ProcessType: "shell", StartedAt: time.Now()}); err != nil {
+			t.Fatalf("SaveProcessMetadata: %v", err)
+		}
+		if _, err := s.ArchiveProcess(p.id); err != nil {
+			t.Fatalf("ArchiveProcess: %v", err)
+		}
+	}
+	if _, err := s.exec(`UPDATE process_metadata SET archived_at = archived_at - 60 WHERE process_id = 'proc-1'`); err != nil {
+		t.Fatalf("backdating: %v", err)
+	}
+	if err := s.SaveProcessMetadata(ProcessMetadata{ProcessID: "proc-4", HostID: "host-1", ProcessType: "shell", StartedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+
+	ids := func(metas []ProcessMetadata) []string {
+		var ids []string
+		for _, m := range metas {
+			ids = append(ids, m.ProcessID)
+		}
+		return ids
+	}
+	all, err := s.ListArchivedProcesses("")
+	if got := ids(all); err != nil || len(got) != 3 || got[0] != "proc-2" || got[1] != "proc-3" || got[2] != "proc-1" {
+		t.Errorf("ListArchivedProcesses() = %v, %v; want newest archived first", got, err)
+	}
+	host, err := s.ListArchivedProcesses("host-1")
+	if got := ids(host); err != nil || len(got) != 2 || got[0] != "proc-3" || got[1] != "proc-1" {
+		t.Errorf("ListArchivedProcesses(host-1) = %v, %v", got, err)
+	}
+}
+
+func TestDeleteArchivedProcess(t *testing.T) {
+	s := newTestStore(t)
+	for _, id := range []string{"proc-1", "proc-2"} {
+		if err := s.SaveProcessMetadata(ProcessMetadata{ProcessID: id, HostID: "host-1", ProcessType: "shell", StartedAt: time.Now()}); err != nil {
+			t.Fatalf("SaveProcessMetadata: %v", err)
+		}
+		if err := s.AppendPtyOutput(id, "host-1", []byte("output")); err != nil {
+			t.Fatalf("AppendPtyOutput: %v", err)
+		}
+	}
+	if _, err := s.ArchiveProcess("proc-1"); err != nil {
+		t.Fatalf("ArchiveProcess: %v", err)
+	}
+
+	// A live process is left alone
+	if deleted, err := s.DeleteArchivedProcess("proc-2"); err != nil || deleted {
+		t.Errorf("DeleteArchivedProcess of a live process = %v, %v", deleted, err)
+	}
+	if meta, _ := s.GetProcessMetadata("proc-2"); meta == nil || s.GetPtyHistorySize("proc-2") == 0 {
+		t.Errorf("live process lost its metadata or history")
+	}
+
+	if deleted, err := s.DeleteArchivedProcess("proc-1"); err != nil || !deleted {
+		t.Fatalf("DeleteArchivedProcess = %v, %v", deleted, err)
+	}
+	if meta, _ := s.GetProcessMetadata("proc-1"); meta != nil {
+		t.Errorf("metadata = %+v after delete", meta)
+	}
+	if size := s.GetPtyHistorySize("proc-1"); size != 0 {
+		t.Errorf("PTY history size = %d after delete", size)
+	}
+}
+
+func TestPruneArchivedProcesses(t *testing.T) {
+	s := newTestStore(t)
+	for _, id := range []string{"proc-1", "proc-2"} {
+		if err := s.SaveProcessMetadata(ProcessMetadata{ProcessID: id, HostID: "host-1", ProcessType: "shell", StartedAt: time.Now()}); err != nil {
+			t.Fatalf("SaveProcessMetadata: %v", err)
+		}
+		if _, err := s.ArchiveProcess(id); err != nil {
+			t.Fatalf("ArchiveProcess: %v", err)
+		}
+	}
+	if _, err := s.exec(`UPDATE process_metadata SET archived_at = archived_at - 7200 WHERE process_id = 'proc-1'`); err != nil {
+		t.Fatalf("backdating: %v", err)
+	}
+
+	// Without a max age nothing is pruned
+	if pruned, err := s.PruneArchivedProcesses(); err != nil || len(pruned) != 0 {
+		t.Errorf("PruneArchivedProcesses without a max age = %v, %v", pruned, err)
+	}
+
+	s.SetArchiveMaxAge(time.Hour)
+	pruned, err := s.PruneArchivedProcesses()
+	if err != nil || len(pruned) != 1 || pruned[0] != "proc-1" {
+		t.Fatalf("PruneArchivedProcesses = %v, %v", pruned, err)
+	}
+	if meta, _ := s.GetArchivedProcess("proc-2"); meta == nil {
+		t.Error("a recently archived process was pruned")
+	}
+}
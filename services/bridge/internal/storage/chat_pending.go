@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// PendingChatMatchWindow is how long after it was sent a pending chat
+// message can be replaced by AgentAPI's echo of it. Older pending messages
+// are dropped, as never confirmed, once another user message arrives.
+const PendingChatMatchWindow = 5 * time.Minute
+
+// AddPendingChatMessage caches a user message as it is sent to AgentAPI,
+// before AgentAPI echoes it back with its own ID. The pending message gets
+// a negative ID below every other, so it can't collide with AgentAPI's IDs,
+// which count up from 0. It is replaced when AgentAPI's message with the
+// same content arrives, or dropped with DropPendingChatMessage if sending
+// fails.
+func (s *Store) AddPendingChatMessage(processId, hostId, clientMessageID, message string) ChatMessage {
+	buf := s.getOrCreateChatBuffer(processId, hostId)
+
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	messageID := -1
+	for id := range buf.messages {
+		if id <= messageID {
+			messageID = id - 1
+		}
+	}
+	msg := ChatMessage{
+		MessageID:       messageID,
+		Role:            "user",
+		Message:         message,
+		MessageTime:     time.Now().UTC().Format(time.RFC3339Nano),
+		Pending:         true,
+		ClientMessageID: clientMessageID,
+	}
+	buf.messages[messageID] = msg
+	delete(buf.deleted, messageID)
+	buf.markDirty(len(message), s.now())
+
+	return msg
+}
+
+// DropPendingChatMessage removes a pending message whose send failed. It
+// reports false when the message is no longer pending, because AgentAPI
+// echoed it after all.
+func (s *Store) DropPendingChatMessage(processId string, messageID int) bool {
+	s.mu.RLock()
+	buf, ok := s.chatBuffers[processId]
+	s.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	if msg, ok := buf.messages[messageID]; !ok || !msg.Pending {
+		return false
+	}
+	buf.remove(messageID)
+	return true
+}
+
+// confirmPending links a message from AgentAPI to the pending message it
+// echoes. A message in known, the messages cached before it arrived, keeps
+// its ClientMessageID, so a repeated update never consumes a pending
+// message; a new user message replaces the oldest pending one with the same
+// content sent within PendingChatMatchWindow of now, taking its
+// ClientMessageID. The caller holds buf.mu.
+func (buf *ChatBuffer) confirmPending(msg ChatMessage, known map[int]ChatMessage, now time.Time) ChatMessage {
+	if msg.ClientMessageID != "" {
+		return msg
+	}
+	if existing, ok := known[msg.MessageID]; ok {
+		msg.ClientMessageID = existing.ClientMessageID
+		return msg
+	}
+	if msg.Role != "user" {
+		return msg
+	}
+
+	var match *ChatMessage
+	for messageID, pending := range buf.messages {
+		if !pending.Pending {
+			continue
+		}
+		sent, err := time.Parse(time.RFC3339Nano, pending.MessageTime)
+		if err != nil || now.Sub(sent) > PendingChatMatchWindow {
+			log.Printf("[DEBUG] [Storage] Dropping pending chat message %s, never echoed by AgentAPI", pending.ClientMessageID)
+			buf.remove(messageID)
+			continue
+		}
+		// Pending IDs count down, so the oldest has the highest
+		if strings.TrimSpace(pending.Message) == strings.TrimSpace(msg.Message) &&
+			(match == nil || pending.MessageID > match.MessageID) {
+			match = &pending
+		}
+	}
+	if match != nil {
+		buf.remove(match.MessageID)
+		msg.ClientMessageID = match.ClientMessageID
+	}
+	return msg
+}
+
+// remove deletes a message from the buffer and, on the next persist, from
+// the database. The caller holds buf.mu.
+func (buf *ChatBuffer) remove(messageID int) {
+	delete(buf.messages, messageID)
+	if buf.deleted == nil {
+		buf.deleted = make(map[int]bool)
+	}
+	buf.deleted[messageID] = true
+	buf.dirty = true
+}
+
+// chatMessageBefore orders messages chronologically: AgentAPI's by ID, then
+// pending ones in the order they were sent
+func chatMessageBefore(a, b ChatMessage) bool {
+	if a.Pending != b.Pending {
+		return b.Pending
+	}
+	if a.Pending {
+		return a.MessageID > b.MessageID
+	}
+	return a.MessageID < b.MessageID
+}
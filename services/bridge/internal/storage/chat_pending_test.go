@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPendingChatMessageConfirmed(t *testing.T) {
+	s := newTestStore(t)
+	s.UpsertChatMessage("proc-1", "host-1", ChatMessage{MessageID: 0, Role: "agent", Message: "Welcome", MessageTime: "t0"})
+
+	pending := s.AddPendingChatMessage("proc-1", "host-1", "client-1", "fix the tests")
+	if pending.MessageID >= 0 || !pending.Pending {
+		t.Fatalf("pending message = %+v", pending)
+	}
+	if latest, _, _ := s.GetLatestChatMessageID("proc-1"); latest != 0 {
+		t.Errorf("latest message ID = %d, want 0; pending messages aren't AgentAPI's", latest)
+	}
+	history, _ := s.GetChatHistory("proc-1")
+	if len(history) != 2 || history[1].ClientMessageID != "client-1" {
+		t.Fatalf("history = %+v, want the pending message last", history)
+	}
+
+	// AgentAPI may trim what it was sent
+	confirmed, err := s.UpsertChatMessage("proc-1", "host-1", ChatMessage{MessageID: 1, Role: "user", Message: "fix the tests\n", MessageTime: "t1"})
+	if err != nil {
+		t.Fatalf("UpsertChatMessage: %v", err)
+	}
+	if confirmed.ClientMessageID != "client-1" || confirmed.Pending {
+		t.Errorf("confirmed message = %+v", confirmed)
+	}
+	history, _ = s.GetChatHistory("proc-1")
+	if len(history) != 2 || history[1].MessageID != 1 || history[1].ClientMessageID != "client-1" {
+		t.Errorf("history = %+v, want the pending message replaced", history)
+	}
+
+	// Sending failed after AgentAPI got the message after all
+	if s.DropPendingChatMessage("proc-1", pending.MessageID) {
+		t.Error("dropped a pending message that was already confirmed")
+	}
+}
+
+func TestPendingChatMessageDoubleDelivery(t *testing.T) {
+	s := newTestStore(t)
+	s.AddPendingChatMessage("proc-1", "host-1", "client-1", "yes")
+	s.UpsertChatMessage("proc-1", "host-1", ChatMessage{MessageID: 4, Role: "user", Message: "yes", MessageTime: "t4"})
+	second := s.AddPendingChatMessage("proc-1", "host-1", "client-2", "yes")
+
+	// The same update again must not consume the second send
+	repeated, _ := s.UpsertChatMessage("proc-1", "host-1", ChatMessage{MessageID: 4, Role: "user", Message: "yes", MessageTime: "t4"})
+	if repeated.ClientMessageID != "client-1" {
+		t.Errorf("repeated update = %+v, want client-1", repeated)
+	}
+	history, _ := s.GetChatHistory("proc-1")
+	if len(history) != 2 || !history[1].Pending || history[1].ClientMessageID != "client-2" {
+		t.Fatalf("history = %+v, want the second send still pending", history)
+	}
+
+	// Identical pending messages are confirmed oldest first
+	third := s.AddPendingChatMessage("proc-1", "host-1", "client-3", "yes")
+	if third.MessageID >= second.MessageID {
+		t.Errorf("pending IDs %d, %d should count down", second.MessageID, third.MessageID)
+	}
+	confirmed, _ := s.UpsertChatMessage("proc-1", "host-1", ChatMessage{MessageID: 6, Role: "user", Message: "yes", MessageTime: "t6"})
+	if confirmed.ClientMessageID != "client-2" {
+		t.Errorf("confirmed %+v, want client-2", confirmed)
+	}
+}
+
+func TestPendingChatMessageFailure(t *testing.T) {
+	s := newTestStore(t)
+	pending := s.AddPendingChatMessage("proc-1", "host-1", "client-1", "hello")
+	if err := s.persistChatBuffer("proc-1"); err != nil {
+		t.Fatalf("persistChatBuffer: %v", err)
+	}
+	stored, _ := s.getChatHistoryFromDB("proc-1")
+	if len(stored) != 1 || !stored[0].Pending || stored[0].ClientMessageID != "client-1" {
+		t.Fatalf("stored = %+v", stored)
+	}
+
+	if !s.DropPendingChatMessage("proc-1", pending.MessageID) {
+		t.Fatal("pending message not dropped")
+	}
+	if err := s.persistChatBuffer("proc-1"); err != nil {
+		t.Fatalf("persistChatBuffer: %v", err)
+	}
+	if stored, _ := s.getChatHistoryFromDB("proc-1"); len(stored) != 0 {
+		t.Errorf("stored = %+v, want the dropped message deleted", stored)
+	}
+
+	// A message AgentAPI never echoes isn't matched once it is too old
+	s.AddPendingChatMessage("proc-1", "host-1", "client-2", "hello")
+	buf := s.getOrCreateChatBuffer("proc-1", "host-1")
+	buf.mu.Lock()
+	late := buf.confirmPending(ChatMessage{MessageID: 0, Role: "user", Message: "hello"}, buf.messages,
+		time.Now().Add(PendingChatMatchWindow+time.Minute))
+	remaining := len(buf.messages)
+	buf.mu.Unlock()
+	if late.ClientMessageID != "" || remaining != 0 {
+		t.Errorf("late echo = %+v with %d messages left, want the stale pending message dropped", late, remaining)
+	}
+}
+
+func TestSetChatMessagesConfirmsPending(t *testing.T) {
+	s := newTestStore(t)
+	s.AddPendingChatMessage("proc-1", "host-1", "client-1", "run it")
+	s.AddPendingChatMessage("proc-1", "host-1", "client-2", "not echoed yet")
+
+	if err := s.SetChatMessages("proc-1", "host-1", []ChatMessage{
+		{MessageID: 0, Role: "agent", Message: "Ready"},
+		{MessageID: 1, Role: "user", Message: "run it"},
+	}); err != nil {
+		t.Fatalf("SetChatMessages: %v", err)
+	}
+	history, _ := s.GetChatHistory("proc-1")
+	if len(history) != 3 || history[1].ClientMessageID != "client-1" || history[1].Pending ||
+		!history[2].Pending || history[2].ClientMessageID != "client-2" {
+		t.Errorf("history = %+v", history)
+	}
+}
@@ -0,0 +1,429 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// chatFTSSchema indexes chat_history messages in an external-content FTS5
+// table. The triggers keep it in sync with every insert, update and delete.
+const chatFTSSchema = `
+CREATE VIRTUAL TABLE IF NOT EXISTS chat_history_fts USING fts5(
+    message,
+    content='chat_history',
+    content_rowid='id',
+    tokenize='unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS chat_history_fts_insert AFTER INSERT ON chat_history BEGIN
+    INSERT INTO chat_history_fts(rowid, message) VALUES (new.id, new.message);
+END;
+
+CREATE TRIGGER IF NOT EXISTS chat_history_fts_delete AFTER DELETE ON chat_history BEGIN
+    INSERT INTO chat_history_fts(chat_history_fts, rowid, message) VALUES ('delete', old.id, old.message);
+END;
+
+CREATE TRIGGER IF NOT EXISTS chat_history_fts_update AFTER UPDATE OF message ON chat_history BEGIN
+    INSERT INTO chat_history_fts(chat_history_fts, rowid, message) VALUES ('delete', old.id, old.message);
+    INSERT INTO chat_history_fts(rowid, message) VALUES (new.id, new.message);
+END;
+`
+
+// Snippet markers around matched text. They are stripped into
+// ChatSearchMatch.Highlights before results leave the store.
+const (
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+// Chat search limits
+const (
+	DefaultChatSearchLimit = 50
+	MaxChatSearchLimit     = 500
+
+	snippetTokens  = 24 // FTS snippet length, in tokens
+	snippetContext = 60 // LIKE snippet context either side of the first match, in runes
+)
+
+// initChatSearch creates the chat full-text index, filling it from existing
+// chat history the first time. It reports whether the index is usable.
+func initChatSearch(db *sql.DB) bool {
+	var existing int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'chat_history_fts'`).Scan(&existing); err != nil {
+		log.Printf("[WARN] [Storage] Chat search falls back to LIKE: %v", err)
+		return false
+	}
+	if _, err := db.Exec(chatFTSSchema); err != nil {
+		log.Printf("[WARN] [Storage] Chat search falls back to LIKE, FTS5 unavailable: %v", err)
+		return false
+	}
+	if existing == 0 {
+		if _, err := db.Exec(`INSERT INTO chat_history_fts(chat_history_fts) VALUES ('rebuild')`); err != nil {
+			log.Printf("[WARN] [Storage] Chat search falls back to LIKE, index backfill failed: %v", err)
+			return false
+		}
+		log.Printf("[INFO] [Storage] Built chat search index from existing chat history")
+	}
+	return true
+}
+
+// dropChatSearch removes the chat full-text index and its triggers
+func dropChatSearch(db *sql.DB) error {
+	_, err := db.Exec(`
+		DROP TRIGGER IF EXISTS chat_history_fts_insert;
+		DROP TRIGGER IF EXISTS chat_history_fts_delete;
+		DROP TRIGGER IF EXISTS chat_history_fts_update;
+		DROP TABLE IF EXISTS chat_history_fts;
+	`)
+	return err
+}
+
+// ChatSearchQuery selects the chat messages to search
+type ChatSearchQuery struct {
+	Query  string // Words must all appear; "quoted text" must appear as a phrase
+	HostID string // Optional
+	Role   string // Optional: "user" or "assistant"
+	Limit  int    // Maximum matches returned; 0 means DefaultChatSearchLimit
+}
+
+// TextRange is a span of text, in UTF-16 code units (JavaScript string
+// indexes)
+type TextRange struct {
+	Start  int
+	Length int
+}
+
+// ChatSearchMatch is one message that matched a search
+type ChatSearchMatch struct {
+	MessageID   int
+	Role        string
+	MessageTime string
+	Snippet     string      // Excerpt of the message around the matches
+	Highlights  []TextRange // Matched text within Snippet
+}
+
+// ChatSearchGroup holds the matches of one process
+type ChatSearchGroup struct {
+	ProcessID   string
+	HostID      string
+	ProcessName string // Stored name, if any
+	MatchCount  int    // Every match in the process, even past the limit
+	Matches     []ChatSearchMatch
+}
+
+// SearchChat searches the chat history of every process. Matches are ordered
+// by relevance (newest first without the full-text index) and grouped by
+// process, in the order each process first appears. While history is
+// encrypted every message is decrypted to be searched, which is slower.
+func (s *Store) SearchChat(q ChatSearchQuery) ([]ChatSearchGroup, error) {
+	terms := parseSearchQuery(q.Query)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("search query is empty")
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultChatSearchLimit
+	}
+	limit = min(limit, MaxChatSearchLimit)
+
+	// Messages still in memory would be missed
+	s.persistChatBuffers()
+	if s.encryptHistory {
+		return s.searchEncryptedChat(q, terms, limit)
+	}
+
+	var from, where string
+	var args []interface{}
+	if s.chatFTS {
+		from = `chat_history_fts f JOIN chat_history c ON c.id = f.rowid`
+		where = `chat_history_fts MATCH ?`
+		args = append(args, ftsQuery(terms))
+	} else {
+		from = `chat_history c`
+		likes := make([]string, len(terms))
+		for i, term := range terms {
+			likes[i] = `c.message LIKE ? ESCAPE '\'`
+			args = append(args, "%"+escapeLike(term)+"%")
+		}
+		where = strings.Join(likes, " AND ")
+	}
+	if q.HostID != "" {
+		where += ` AND c.host_id = ?`
+		args = append(args, q.HostID)
+	}
+	if q.Role != "" {
+		where += ` AND c.role = ?`
+		args = append(args, q.Role)
+	}
+
+	counts, err := s.countChatMatches(from, where, args)
+	if err != nil {
+		return nil, err
+	}
+
+	text, order := `c.message`, `c.id DESC`
+	if s.chatFTS {
+		text = fmt.Sprintf(`snippet(chat_history_fts, 0, '%s', '%s', '…', %d)`, matchStart, matchEnd, snippetTokens)
+		order = `rank`
+	}
+	rows, err := s.db.Query(`
+		SELECT c.process_id, c.host_id, COALESCE(pm.name, ''), c.message_id, c.role, c.message_time, `+text+`
+		FROM `+from+`
+		LEFT JOIN process_metadata pm ON pm.process_id = c.process_id
+		WHERE `+where+`
+		ORDER BY `+order+`
+		LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search chat history: %w", err)
+	}
+	defer rows.Close()
+
+	var highlighter *regexp.Regexp
+	if !s.chatFTS {
+		highlighter = termsPattern(terms)
+	}
+
+	var groups []ChatSearchGroup
+	index := make(map[string]int) // processId -> position in groups
+	for rows.Next() {
+		var processID, hostID, name, marked string
+		var match ChatSearchMatch
+		if err := rows.Scan(&processID, &hostID, &name, &match.MessageID, &match.Role, &match.MessageTime, &marked); err != nil {
+			return nil, fmt.Errorf("failed to scan chat search result: %w", err)
+		}
+		if highlighter != nil {
+			marked = markSnippet(marked, highlighter)
+		}
+		match.Snippet, match.Highlights = parseMarkedSnippet(marked)
+		groups = groupChatMatch(groups, index, processID, hostID, name, match)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read chat search results: %w", err)
+	}
+	for i := range groups {
+		groups[i].MatchCount = counts[groups[i].ProcessID]
+	}
+	return groups, nil
+}
+
+// groupChatMatch adds a match to its process's group, appending the group
+// the first time the process is seen
+func groupChatMatch(groups []ChatSearchGroup, index map[string]int, processID, hostID, name string, match ChatSearchMatch) []ChatSearchGroup {
+	i, ok := index[processID]
+	if !ok {
+		i = len(groups)
+		index[processID] = i
+		groups = append(groups, ChatSearchGroup{ProcessID: processID, HostID: hostID, ProcessName: name})
+	}
+	groups[i].Matches = append(groups[i].Matches, match)
+	return groups
+}
+
+// searchEncryptedChat searches chat history that may be encrypted, which
+// neither the index nor LIKE can see into: every message passing the host
+// and role filters is decrypted and matched in turn, newest first
+func (s *Store) searchEncryptedChat(q ChatSearchQuery, terms []string, limit int) ([]ChatSearchGroup, error) {
+	var where string
+	var args []interface{}
+	if q.HostID != "" {
+		where += ` AND c.host_id = ?`
+		args = append(args, q.HostID)
+	}
+	if q.Role != "" {
+		where += ` AND c.role = ?`
+		args = append(args, q.Role)
+	}
+	rows, err := s.db.Query(`
+		SELECT c.process_id, c.host_id, COALESCE(pm.name, ''), c.message_id, c.role, c.message_time, c.message
+		FROM chat_history c
+		LEFT JOIN process_metadata pm ON pm.process_id = c.process_id
+		WHERE 1`+where+`
+		ORDER BY c.id DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search chat history: %w", err)
+	}
+	defer rows.Close()
+
+	lowerTerms := make([]string, len(terms))
+	for i, term := range terms {
+		lowerTerms[i] = strings.ToLower(term)
+	}
+	highlighter := termsPattern(terms)
+
+	var groups []ChatSearchGroup
+	index := make(map[string]int) // processId -> position in groups
+	counts := make(map[string]int)
+	matched := 0
+	for rows.Next() {
+		var processID, hostID, name string
+		var stored []byte
+		var match ChatSearchMatch
+		if err := rows.Scan(&processID, &hostID, &name, &match.MessageID, &match.Role, &match.MessageTime, &stored); err != nil {
+			return nil, fmt.Errorf("failed to scan chat search result: %w", err)
+		}
+		message, err := s.openHistoryText(stored)
+		if err != nil {
+			log.Printf("[WARN] [Storage] Skipping chat message %d of process %s in search: %v", match.MessageID, processID, err)
+			continue
+		}
+		if !containsAll(strings.ToLower(message), lowerTerms) {
+			continue
+		}
+
+		counts[processID]++
+		if matched == limit {
+			continue
+		}
+		matched++
+		match.Snippet, match.Highlights = parseMarkedSnippet(markSnippet(message, highlighter))
+		groups = groupChatMatch(groups, index, processID, hostID, name, match)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read chat search results: %w", err)
+	}
+	for i := range groups {
+		groups[i].MatchCount = counts[groups[i].ProcessID]
+	}
+	return groups, nil
+}
+
+// containsAll reports whether text contains every term
+func containsAll(text string, terms []string) bool {
+	for _, term := range terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// countChatMatches counts the matches of a search in each process
+func (s *Store) countChatMatches(from, where string, args []interface{}) (map[string]int, error) {
+	rows, err := s.db.Query(`SELECT c.process_id, COUNT(*) FROM `+from+` WHERE `+where+` GROUP BY c.process_id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count chat search results: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var processID string
+		var count int
+		if err := rows.Scan(&processID, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan chat search count: %w", err)
+		}
+		counts[processID] = count
+	}
+	return counts, rows.Err()
+}
+
+// persistChatBuffers saves every dirty chat buffer
+func (s *Store) persistChatBuffers() {
+	s.mu.RLock()
+	processIds := make([]string, 0, len(s.chatBuffers))
+	for pid := range s.chatBuffers {
+		processIds = append(processIds, pid)
+	}
+	s.mu.RUnlock()
+
+	for _, pid := range processIds {
+		if err := s.persistChatBuffer(pid); err != nil {
+			log.Printf("[WARN] [Storage] Failed to persist chat for process %s before search: %v", pid, err)
+		}
+	}
+}
+
+// parseSearchQuery splits a query into words and "quoted phrases"
+func parseSearchQuery(query string) []string {
+	var terms []string
+	for i, part := range strings.Split(query, `"`) {
+		if i%2 == 1 {
+			// Inside quotes: one phrase
+			if phrase := strings.Join(strings.Fields(part), " "); phrase != "" {
+				terms = append(terms, phrase)
+			}
+			continue
+		}
+		terms = append(terms, strings.Fields(part)...)
+	}
+	return terms
+}
+
+// ftsQuery builds an FTS5 query matching every term, each as a quoted
+// string so user input can't use FTS syntax
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// escapeLike escapes LIKE wildcards with backslashes
+func escapeLike(term string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term)
+}
+
+// termsPattern matches any of the terms, ignoring case
+func termsPattern(terms []string) *regexp.Regexp {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	return regexp.MustCompile(`(?i)` + strings.Join(quoted, "|"))
+}
+
+// markSnippet cuts an excerpt of message around its first match and marks
+// every match in it, as FTS5 snippet() does
+func markSnippet(message string, pattern *regexp.Regexp) string {
+	loc := pattern.FindStringIndex(message)
+	if loc == nil {
+		return message
+	}
+	start, end := loc[0], loc[1]
+	for n := 0; n < snippetContext && start > 0; n++ {
+		_, size := utf8.DecodeLastRuneInString(message[:start])
+		start -= size
+	}
+	for n := 0; n < snippetContext && end < len(message); n++ {
+		_, size := utf8.DecodeRuneInString(message[end:])
+		end += size
+	}
+
+	excerpt := pattern.ReplaceAllString(message[start:end], matchStart+"$0"+matchEnd)
+	if start > 0 {
+		excerpt = "…" + excerpt
+	}
+	if end < len(message) {
+		excerpt += "…"
+	}
+	return excerpt
+}
+
+// parseMarkedSnippet strips the match markers from a snippet and returns
+// where the marked text is
+func parseMarkedSnippet(marked string) (string, []TextRange) {
+	var b strings.Builder
+	highlights := []TextRange{}
+	offset, open := 0, -1
+	for _, r := range marked {
+		switch string(r) {
+		case matchStart:
+			open = offset
+		case matchEnd:
+			if open >= 0 && offset > open {
+				highlights = append(highlights, TextRange{Start: open, Length: offset - open})
+			}
+			open = -1
+		default:
+			b.WriteRune(r)
+			offset += utf16.RuneLen(r)
+		}
+	}
+	return b.String(), highlights
+}
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+)
+
+// seedChatCorpus fills three processes on two hosts with chat history
+func seedChatCorpus(t *testing.T, s *Store) {
+	t.Helper()
+	corpus := []struct {
+		processID, hostID string
+		messages          []ChatMessage
+	}{
+		{"proc-a", "host-1", []ChatMessage{
+			{MessageID: 1, Role: "user", Message: "Please fix the flaky login test", MessageTime: "2026-01-01T10:00:00Z"},
+			{MessageID: 2, Role: "assistant", Message: "The login test races the session cleanup. I'll add a lock.", MessageTime: "2026-01-01T10:00:05Z"},
+			{MessageID: 3, Role: "user", Message: "Now update the README", MessageTime: "2026-01-01T10:01:00Z"},
+		}},
+		{"proc-b", "host-1", []ChatMessage{
+			{MessageID: 1, Role: "user", Message: "Why does the test login fail on CI?", MessageTime: "2026-01-02T09:00:00Z"},
+			{MessageID: 2, Role: "assistant", Message: "Le café résumé 🚀 was renamed to naïve_cache", MessageTime: "2026-01-02T09:00:10Z"},
+		}},
+		{"proc-c", "host-2", []ChatMessage{
+			{MessageID: 1, Role: "user", Message: "Add a login test for SSO", MessageTime: "2026-01-03T08:00:00Z"},
+		}},
+	}
+	for _, p := range corpus {
+		if err := s.SetChatMessages(p.processID, p.hostID, p.messages); err != nil {
+			t.Fatalf("SetChatMessages: %v", err)
+		}
+	}
+	if err := s.SaveProcessMetadata(ProcessMetadata{
+		ProcessID: "proc-a", HostID: "host-1", ProcessType: "claude", TmuxName: "rc-proc-a", Name: "auth fixes",
+	}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+}
+
+// highlighted returns the highlighted parts of a match's snippet
+func highlighted(m ChatSearchMatch) []string {
+	units := utf16.Encode([]rune(m.Snippet))
+	parts := make([]string, len(m.Highlights))
+	for i, h := range m.Highlights {
+		parts[i] = string(utf16.Decode(units[h.Start : h.Start+h.Length]))
+	}
+	return parts
+}
+
+// matchCounts maps each process to its match count
+func matchCounts(groups []ChatSearchGroup) map[string]int {
+	counts := make(map[string]int)
+	for _, g := range groups {
+		counts[g.ProcessID] = g.MatchCount
+	}
+	return counts
+}
+
+func TestSearchChat(t *testing.T) {
+	for _, fts := range []bool{true, false} {
+		name := "fts"
+		if !fts {
+			name = "like"
+		}
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t)
+			if !s.chatFTS {
+				t.Fatal("FTS5 unavailable")
+			}
+			s.chatFTS = fts
+			seedChatCorpus(t, s)
+
+			// Words match anywhere, grouped per process with counts
+			groups, err := s.SearchChat(ChatSearchQuery{Query: "login test"})
+			if err != nil {
+				t.Fatalf("SearchChat: %v", err)
+			}
+			if got := matchCounts(groups); len(got) != 3 || got["proc-a"] != 2 || got["proc-b"] != 1 || got["proc-c"] != 1 {
+				t.Fatalf("counts = %v", got)
+			}
+			for _, g := range groups {
+				if len(g.Matches) != g.MatchCount {
+					t.Errorf("%s: %d matches, count %d", g.ProcessID, len(g.Matches), g.MatchCount)
+				}
+				if g.ProcessID == "proc-a" && (g.ProcessName != "auth fixes" || g.HostID != "host-1") {
+					t.Errorf("proc-a group = %+v", g)
+				}
+			}
+
+			// A phrase must appear as written
+			groups, err = s.SearchChat(ChatSearchQuery{Query: `"login test"`})
+			if err != nil {
+				t.Fatalf("SearchChat: %v", err)
+			}
+			if got := matchCounts(groups); len(got) != 2 || got["proc-a"] != 2 || got["proc-c"] != 1 {
+				t.Fatalf("phrase counts = %v", got)
+			}
+			for _, g := range groups {
+				for _, m := range g.Matches {
+					if parts := highlighted(m); len(parts) != 1 || parts[0] != "login test" {
+						t.Errorf("%s/%d highlights %q in %q", g.ProcessID, m.MessageID, parts, m.Snippet)
+					}
+				}
+			}
+
+			// Host and role filters
+			groups, err = s.SearchChat(ChatSearchQuery{Query: "login", HostID: "host-1", Role: "assistant"})
+			if err != nil {
+				t.Fatalf("SearchChat: %v", err)
+			}
+			if len(groups) != 1 || groups[0].ProcessID != "proc-a" || groups[0].MatchCount != 1 || groups[0].Matches[0].MessageID != 2 {
+				t.Fatalf("filtered = %+v", groups)
+			}
+			if m := groups[0].Matches[0]; m.Role != "assistant" || m.MessageTime != "2026-01-01T10:00:05Z" {
+				t.Errorf("match = %+v", m)
+			}
+
+			// The limit caps matches, not counts
+			groups, err = s.SearchChat(ChatSearchQuery{Query: "login", Limit: 1})
+			if err != nil {
+				t.Fatalf("SearchChat: %v", err)
+			}
+			want := map[string]int{"proc-a": 2, "proc-b": 1, "proc-c": 1}
+			if len(groups) != 1 || len(groups[0].Matches) != 1 || groups[0].MatchCount != want[groups[0].ProcessID] {
+				t.Fatalf("limited = %+v", groups)
+			}
+
+			// Highlight offsets are in UTF-16 units past the emoji
+			query := "naïve_cache"
+			if fts {
+				query = "cache" // the tokenizer splits on underscores
+			}
+			groups, err = s.SearchChat(ChatSearchQuery{Query: query})
+			if err != nil {
+				t.Fatalf("SearchChat: %v", err)
+			}
+			if len(groups) != 1 || groups[0].ProcessID != "proc-b" {
+				t.Fatalf("unicode = %+v", groups)
+			}
+			if parts := highlighted(groups[0].Matches[0]); len(parts) != 1 || parts[0] != query {
+				t.Errorf("unicode highlights = %q", parts)
+			}
+
+			// Wildcards and quotes are literal
+			for _, q := range []string{"%", "log_n", `"`} {
+				if groups, err := s.SearchChat(ChatSearchQuery{Query: q}); len(groups) != 0 {
+					t.Errorf("%q matched %+v (%v)", q, groups, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchChatDiacritics(t *testing.T) {
+	s := newTestStore(t)
+	seedChatCorpus(t, s)
+
+	groups, err := s.SearchChat(ChatSearchQuery{Query: "cafe resume"})
+	if err != nil {
+		t.Fatalf("SearchChat: %v", err)
+	}
+	if len(groups) != 1 || groups[0].ProcessID != "proc-b" {
+		t.Fatalf("groups = %+v", groups)
+	}
+	if parts := highlighted(groups[0].Matches[0]); len(parts) != 2 || parts[0] != "café" || parts[1] != "résumé" {
+		t.Errorf("highlights = %q", parts)
+	}
+}
+
+func TestSearchChatEmptyQuery(t *testing.T) {
+	s := newTestStore(t)
+	for _, q := range []string{"", "   ", `""`} {
+		if _, err := s.SearchChat(ChatSearchQuery{Query: q}); err == nil {
+			t.Errorf("%q: expected error", q)
+		}
+	}
+}
+
+func TestSearchChatIndexSync(t *testing.T) {
+	s := newTestStore(t)
+	seedChatCorpus(t, s)
+	if _, err := s.SearchChat(ChatSearchQuery{Query: "README"}); err != nil {
+		t.Fatalf("SearchChat: %v", err)
+	}
+
+	// Edited messages are reindexed, cleared ones drop out
+	if _, err := s.UpsertChatMessage("proc-a", "host-1", ChatMessage{
+		MessageID: 3, Role: "user", Message: "Now update the CHANGELOG", MessageTime: "2026-01-01T10:01:00Z",
+	}); err != nil {
+		t.Fatalf("UpsertChatMessage: %v", err)
+	}
+	if groups, _ := s.SearchChat(ChatSearchQuery{Query: "README"}); len(groups) != 0 {
+		t.Errorf("stale match: %+v", groups)
+	}
+	if groups, _ := s.SearchChat(ChatSearchQuery{Query: "changelog"}); len(groups) != 1 {
+		t.Errorf("edited message not found: %+v", groups)
+	}
+
+	if err := s.ClearChatHistory("proc-b"); err != nil {
+		t.Fatalf("ClearChatHistory: %v", err)
+	}
+	if groups, _ := s.SearchChat(ChatSearchQuery{Query: "login"}); matchCounts(groups)["proc-b"] != 0 {
+		t.Errorf("cleared process still matches: %+v", groups)
+	}
+}
+
+func TestSearchChatBackfill(t *testing.T) {
+	// A database with chat history from before the search index existed
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	old, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	if _, err := old.Exec(schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	if _, err := old.Exec(`INSERT INTO chat_history (process_id, host_id, message_id, role, message, message_time, created_at)
+		VALUES ('proc-old', 'host-1', 1, 'user', 'migrate the billing tables', '2025-12-01T00:00:00Z', 0)`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	old.Close()
+
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+
+	groups, err := s.SearchChat(ChatSearchQuery{Query: "billing"})
+	if err != nil {
+		t.Fatalf("SearchChat: %v", err)
+	}
+	if len(groups) != 1 || groups[0].ProcessID != "proc-old" || groups[0].Matches[0].Snippet != "migrate the billing tables" {
+		t.Fatalf("groups = %+v", groups)
+	}
+}
@@ -1,23 +1,31 @@
 package storage
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 	"sort"
 	"time"
 )
 
-// UpsertChatMessage adds or updates a chat message in the buffer
-func (s *Store) UpsertChatMessage(processId, hostId string, msg ChatMessage) error {
+// UpsertChatMessage adds or updates a chat message from AgentAPI in the
+// buffer, replacing the pending message it echoes, if any (see
+// confirmPending), and queues an early flush of the process once enough chat
+// is unsaved (see SetEarlyFlush). Returns the message as cached.
+func (s *Store) UpsertChatMessage(processId, hostId string, msg ChatMessage) (ChatMessage, error) {
 	buf := s.getOrCreateChatBuffer(processId, hostId)
 
 	buf.mu.Lock()
 	defer buf.mu.Unlock()
 
+	msg = buf.confirmPending(msg, buf.messages, time.Now())
 	buf.messages[msg.MessageID] = msg
-	buf.dirty = true
+	buf.markDirty(len(msg.Message), s.now())
+	if s.flushDue(buf.unflushed, buf.dirtySince) {
+		s.queueFlush(processId)
+	}
 
-	return nil
+	return msg, nil
 }
 
 // GetChatHistory returns all chat messages for a process, ordered by message ID
@@ -40,28 +48,59 @@ func (s *Store) GetChatHistory(processId string) ([]ChatMessage, error) {
 		messages = append(messages, msg)
 	}
 
-	// Sort by message ID (chronological order)
+	// Chronological order: AgentAPI's messages by ID, then pending ones
 	sort.Slice(messages, func(i, j int) bool {
-		return messages[i].MessageID < messages[j].MessageID
+		return chatMessageBefore(messages[i], messages[j])
 	})
 
 	return messages, nil
 }
 
-// GetChatMessageCount returns the number of chat messages for a process
-func (s *Store) GetChatMessageCount(processId string) int {
+// GetChatMessageCount returns the number of chat messages for a process,
+// from memory if its buffer is loaded and counted in the database otherwise,
+// without reading (or decrypting) the messages
+func (s *Store) GetChatMessageCount(processId string) (int, error) {
 	s.mu.RLock()
 	buf, ok := s.chatBuffers[processId]
 	s.mu.RUnlock()
 
 	if !ok {
-		return 0
+		var count int
+		if err := s.db.QueryRow(`SELECT COUNT(*) FROM chat_history WHERE process_id = ?`, processId).Scan(&count); err != nil {
+			return 0, fmt.Errorf("failed to count chat messages: %w", err)
+		}
+		return count, nil
 	}
 
 	buf.mu.RLock()
 	defer buf.mu.RUnlock()
 
-	return len(buf.messages)
+	return len(buf.messages), nil
+}
+
+// GetLatestChatMessageID returns the highest cached chat message ID for a
+// process. ok is false when nothing is cached.
+func (s *Store) GetLatestChatMessageID(processId string) (id int, ok bool, err error) {
+	s.mu.RLock()
+	buf, found := s.chatBuffers[processId]
+	s.mu.RUnlock()
+
+	if found {
+		buf.mu.RLock()
+		defer buf.mu.RUnlock()
+		for messageID, msg := range buf.messages {
+			if !msg.Pending && (!ok || messageID > id) {
+				id, ok = messageID, true
+			}
+		}
+		return id, ok, nil
+	}
+
+	var latest sql.NullInt64
+	if err := s.db.QueryRow(`SELECT MAX(message_id) FROM chat_history WHERE process_id = ? AND pending = 0`, processId).Scan(&latest); err != nil {
+		return 0, false, fmt.Errorf("failed to query latest chat message: %w", err)
+	}
+	return int(latest.Int64), latest.Valid, nil
 }
 
 // ClearChatHistory removes all chat history for a process
@@ -72,7 +111,7 @@ func (s *Store) ClearChatHistory(processId string) error {
 	s.mu.Unlock()
 
 	// Clear from database
-	_, err := s.db.Exec("DELETE FROM chat_history WHERE process_id = ?", processId)
+	_, err := s.exec("DELETE FROM chat_history WHERE process_id = ?", processId)
 	if err != nil {
 		return fmt.Errorf("failed to clear chat history from db: %w", err)
 	}
@@ -81,7 +120,8 @@ func (s *Store) ClearChatHistory(processId string) error {
 	return nil
 }
 
-// SetChatMessages replaces all chat messages for a process (used for initial sync)
+// SetChatMessages replaces all chat messages for a process (used for initial
+// sync). Pending messages the new ones don't echo are kept.
 func (s *Store) SetChatMessages(processId, hostId string, messages []ChatMessage) error {
 	buf := s.getOrCreateChatBuffer(processId, hostId)
 
@@ -89,9 +129,18 @@ func (s *Store) SetChatMessages(processId, hostId string, messages []ChatMessage
 	defer buf.mu.Unlock()
 
 	// Clear existing and set new
+	previous := buf.messages
 	buf.messages = make(map[int]ChatMessage)
+	for messageID, msg := range previous {
+		if msg.Pending {
+			buf.messages[messageID] = msg
+		}
+	}
+	now := time.Now()
 	for _, msg := range messages {
+		msg = buf.confirmPending(msg, previous, now)
 		buf.messages[msg.MessageID] = msg
+		buf.markDirty(len(msg.Message), s.now())
 	}
 	buf.dirty = true
 
@@ -116,45 +165,72 @@ func (s *Store) persistChatBuffer(processId string) error {
 		return nil
 	}
 
-	// Use a transaction for batch insert
-	tx, err := s.db.Begin()
-	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
-	}
-	defer tx.Rollback()
-
-	stmt, err := tx.Prepare(`
-		INSERT OR REPLACE INTO chat_history
-		(process_id, host_id, message_id, role, message, message_time, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`)
-	if err != nil {
-		return fmt.Errorf("failed to prepare statement: %w", err)
+	// Pending messages that were confirmed or failed
+	if len(buf.deleted) > 0 {
+		deleted := make([]int, 0, len(buf.deleted))
+		for messageID := range buf.deleted {
+			deleted = append(deleted, messageID)
+		}
+		err := s.execBatches(`DELETE FROM chat_history WHERE process_id = ? AND message_id = ?`, len(deleted), func(i int) []interface{} {
+			return []interface{}{processId, deleted[i]}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete chat messages: %w", err)
+		}
+		buf.deleted = nil
 	}
-	defer stmt.Close()
 
-	now := time.Now().Unix()
+	messages := make([]ChatMessage, 0, len(buf.messages))
+	sealed := make([]interface{}, 0, len(buf.messages))
+	sealedMetadata := make([]interface{}, 0, len(buf.messages))
 	for _, msg := range buf.messages {
-		_, err := stmt.Exec(
-			processId,
-			hostId,
-			msg.MessageID,
-			msg.Role,
-			msg.Message,
-			msg.MessageTime,
-			now,
-		)
+		text, err := s.sealHistoryText(msg.Message)
 		if err != nil {
-			return fmt.Errorf("failed to insert message: %w", err)
+			return err
+		}
+		var metadata interface{}
+		if len(msg.Metadata) > 0 {
+			if metadata, err = s.sealHistoryText(string(msg.Metadata)); err != nil {
+				return err
+			}
 		}
+		messages = append(messages, msg)
+		sealed = append(sealed, text)
+		sealedMetadata = append(sealedMetadata, metadata)
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+	// Upsert rather than replace: a replaced row gets a new rowid without
+	// firing the delete trigger that keeps chat_history_fts in sync. Unchanged
+	// messages are left alone so they aren't reindexed on every persist;
+	// encrypted ones differ every time, and are rewritten.
+	now := time.Now().Unix()
+	err := s.execBatches(`
+		INSERT INTO chat_history
+		(process_id, host_id, message_id, role, message, message_time, pending, client_message_id, kind, metadata, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(process_id, message_id) DO UPDATE SET
+			host_id = excluded.host_id, role = excluded.role, message = excluded.message,
+			message_time = excluded.message_time, pending = excluded.pending,
+			client_message_id = excluded.client_message_id, kind = excluded.kind,
+			metadata = excluded.metadata, created_at = excluded.created_at
+		WHERE chat_history.message != excluded.message OR chat_history.role != excluded.role
+			OR chat_history.message_time != excluded.message_time OR chat_history.host_id != excluded.host_id
+			OR chat_history.pending != excluded.pending
+			OR chat_history.client_message_id IS NOT excluded.client_message_id
+			OR chat_history.kind IS NOT excluded.kind OR chat_history.metadata IS NOT excluded.metadata
+	`, len(messages), func(i int) []interface{} {
+		msg := messages[i]
+		return []interface{}{processId, hostId, msg.MessageID, msg.Role, sealed[i], msg.MessageTime,
+			msg.Pending, nullString(msg.ClientMessageID), nullString(msg.Kind), sealedMetadata[i], now}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to persist chat messages: %w", err)
 	}
 
 	buf.dirty = false
 	buf.lastPersist = time.Now()
+	buf.unflushed = 0
+	buf.dirtySince = time.Time{}
 
 	return nil
 }
@@ -162,9 +238,9 @@ func (s *Store) persistChatBuffer(processId string) error {
 // loadChatHistory loads chat history from SQLite into memory
 func (s *Store) loadChatHistory(processId, hostId string) error {
 	rows, err := s.db.Query(`
-		SELECT message_id, role, message, message_time FROM chat_history
+		SELECT `+chatMessageColumns+` FROM chat_history
 		WHERE process_id = ?
-		ORDER BY message_id ASC
+		ORDER BY pending ASC, ABS(message_id) ASC
 	`, processId)
 	if err != nil {
 		return fmt.Errorf("failed to query chat history: %w", err)
@@ -176,9 +252,9 @@ func (s *Store) loadChatHistory(processId, hostId string) error {
 	defer buf.mu.Unlock()
 
 	for rows.Next() {
-		var msg ChatMessage
-		if err := rows.Scan(&msg.MessageID, &msg.Role, &msg.Message, &msg.MessageTime); err != nil {
-			return fmt.Errorf("failed to scan row: %w", err)
+		msg, err := s.scanChatMessage(rows)
+		if err != nil {
+			return err
 		}
 		buf.messages[msg.MessageID] = msg
 	}
@@ -191,9 +267,9 @@ func (s *Store) loadChatHistory(processId, hostId string) error {
 // getChatHistoryFromDB retrieves chat history directly from database
 func (s *Store) getChatHistoryFromDB(processId string) ([]ChatMessage, error) {
 	rows, err := s.db.Query(`
-		SELECT message_id, role, message, message_time FROM chat_history
+		SELECT `+chatMessageColumns+` FROM chat_history
 		WHERE process_id = ?
-		ORDER BY message_id ASC
+		ORDER BY pending ASC, ABS(message_id) ASC
 	`, processId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query chat history: %w", err)
@@ -202,9 +278,9 @@ func (s *Store) getChatHistoryFromDB(processId string) ([]ChatMessage, error) {
 
 	var messages []ChatMessage
 	for rows.Next() {
-		var msg ChatMessage
-		if err := rows.Scan(&msg.MessageID, &msg.Role, &msg.Message, &msg.MessageTime); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
+		msg, err := s.scanChatMessage(rows)
+		if err != nil {
+			return nil, err
 		}
 		messages = append(messages, msg)
 	}
@@ -212,6 +288,36 @@ func (s *Store) getChatHistoryFromDB(processId string) ([]ChatMessage, error) {
 	return messages, rows.Err()
 }
 
+// chatMessageColumns are the chat_history columns scanChatMessage reads
+const chatMessageColumns = `message_id, role, message, message_time, pending, client_message_id, kind, metadata`
+
+// scanChatMessage reads a chat_history row of chatMessageColumns, decrypting
+// the message and its metadata
+func (s *Store) scanChatMessage(rows *sql.Rows) (ChatMessage, error) {
+	var msg ChatMessage
+	var stored, storedMetadata []byte
+	var clientMessageID, kind sql.NullString
+	if err := rows.Scan(&msg.MessageID, &msg.Role, &stored, &msg.MessageTime, &msg.Pending, &clientMessageID,
+		&kind, &storedMetadata); err != nil {
+		return msg, fmt.Errorf("failed to scan row: %w", err)
+	}
+	msg.ClientMessageID = clientMessageID.String
+	msg.Kind = kind.String
+	message, err := s.openHistoryText(stored)
+	if err != nil {
+		return msg, fmt.Errorf("chat message %d: %w", msg.MessageID, err)
+	}
+	msg.Message = message
+	if storedMetadata != nil {
+		metadata, err := s.openHistory(storedMetadata)
+		if err != nil {
+			return msg, fmt.Errorf("chat message %d metadata: %w", msg.MessageID, err)
+		}
+		msg.Metadata = metadata
+	}
+	return msg, nil
+}
+
 // SyncChatFromAgentAPI syncs chat history from AgentAPI (for initial load or reconnection)
 // This is called with messages from AgentAPI's /messages endpoint
 func (s *Store) SyncChatFromAgentAPI(processId, hostId string, messages []ChatMessage) error {
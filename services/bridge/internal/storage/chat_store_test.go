@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestChatMessageKindAndMetadata(t *testing.T) {
+	// A database from before kind and metadata existed
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	old, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	if _, err := old.Exec(`CREATE TABLE chat_history (id INTEGER PRIMARY KEY AUTOINCREMENT, process_id TEXT NOT NULL,
+		host_id TEXT NOT NULL, message_id INTEGER NOT NULL, role TEXT NOT NULL, message TEXT NOT NULL,
+		message_time TEXT NOT NULL, created_at INTEGER NOT NULL, UNIQUE(process_id, message_id))`); err != nil {
+		t.Fatalf("create old schema: %v", err)
+	}
+	if _, err := old.Exec(`INSERT INTO chat_history (process_id, host_id, message_id, role, message, message_time, created_at)
+		VALUES ('proc-1', 'host-1', 0, 'user', 'hi', '2026-01-01T10:00:00Z', 0)`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	old.Close()
+
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+
+	messages, err := s.GetChatHistory("proc-1")
+	if err != nil || len(messages) != 1 || messages[0].Kind != "" || messages[0].Metadata != nil {
+		t.Fatalf("migrated history = %+v, %v", messages, err)
+	}
+
+	// Both are stored, and a change to either alone is persisted
+	metadata := json.RawMessage(`{"tool_use": {"name": "Bash", "input": {"command": "ls"}}}`)
+	s.RegisterProcess("proc-1", "host-1")
+	if err := s.SetChatMessages("proc-1", "host-1", []ChatMessage{
+		{MessageID: 0, Role: "user", Message: "hi", MessageTime: "2026-01-01T10:00:00Z"},
+		{MessageID: 1, Role: "assistant", Message: "ls", MessageTime: "2026-01-01T10:00:01Z", Kind: "message"},
+	}); err != nil {
+		t.Fatalf("SetChatMessages: %v", err)
+	}
+	if err := s.PersistAll(); err != nil {
+		t.Fatalf("PersistAll: %v", err)
+	}
+	if _, err := s.UpsertChatMessage("proc-1", "host-1", ChatMessage{MessageID: 1, Role: "assistant", Message: "ls",
+		MessageTime: "2026-01-01T10:00:01Z", Kind: "tool_call", Metadata: metadata}); err != nil {
+		t.Fatalf("UpsertChatMessage: %v", err)
+	}
+	if err := s.PersistAll(); err != nil {
+		t.Fatalf("PersistAll: %v", err)
+	}
+
+	messages, err = s.getChatHistoryFromDB("proc-1")
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("getChatHistoryFromDB = %+v, %v", messages, err)
+	}
+	if messages[0].Kind != "" || messages[0].Metadata != nil {
+		t.Errorf("plain message = %+v", messages[0])
+	}
+	if messages[1].Kind != "tool_call" || string(messages[1].Metadata) != string(metadata) {
+		t.Errorf("tool call = %+v, metadata %s", messages[1], messages[1].Metadata)
+	}
+}
+
+func TestChatMessageCount(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if count, err := s.GetChatMessageCount("proc-1"); err != nil || count != 0 {
+		t.Errorf("count with no chat = %d, %v", count, err)
+	}
+	s.RegisterProcess("proc-1", "host-1")
+	if err := s.SetChatMessages("proc-1", "host-1", []ChatMessage{
+		{MessageID: 0, Role: "user", Message: "hi", MessageTime: "2026-01-01T10:00:00Z"},
+		{MessageID: 1, Role: "assistant", Message: "hello", MessageTime: "2026-01-01T10:00:01Z"},
+	}); err != nil {
+		t.Fatalf("SetChatMessages: %v", err)
+	}
+	if count, err := s.GetChatMessageCount("proc-1"); err != nil || count != 2 {
+		t.Errorf("cached count = %d, %v", count, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Counted in the database, without loading the chat
+	s, err = NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+	if count, err := s.GetChatMessageCount("proc-1"); err != nil || count != 2 {
+		t.Errorf("stored count = %d, %v", count, err)
+	}
+	s.mu.RLock()
+	_, loaded := s.chatBuffers["proc-1"]
+	s.mu.RUnlock()
+	if loaded {
+		t.Error("counting loaded the chat")
+	}
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// MaxChatDraftSize is the largest draft SetChatDraft stores, in bytes
+const MaxChatDraftSize = 32 * 1024
+
+// ChatDraft is the half-typed chat message of a process
+type ChatDraft struct {
+	Text      string
+	UpdatedAt time.Time
+}
+
+// SetChatDraft saves the draft of a process, replacing the previous one. An
+// empty draft deletes it.
+func (s *Store) SetChatDraft(processID, text string) error {
+	if text == "" {
+		return s.DeleteChatDraft(processID)
+	}
+	if len(text) > MaxChatDraftSize {
+		return fmt.Errorf("draft is %d bytes, over the %d byte limit", len(text), MaxChatDraftSize)
+	}
+	_, err := s.exec(`
+		INSERT INTO chat_drafts (process_id, text, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(process_id) DO UPDATE SET text = excluded.text, updated_at = excluded.updated_at`,
+		processID, text, time.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to save chat draft: %w", err)
+	}
+	return nil
+}
+
+// GetChatDraft returns the draft of a process, or nil if it has none
+func (s *Store) GetChatDraft(processID string) (*ChatDraft, error) {
+	var draft ChatDraft
+	var updatedAt int64
+	err := s.db.QueryRow(`SELECT text, updated_at FROM chat_drafts WHERE process_id = ?`, processID).Scan(&draft.Text, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat draft: %w", err)
+	}
+	draft.UpdatedAt = time.UnixMilli(updatedAt)
+	return &draft, nil
+}
+
+// DeleteChatDraft removes the draft of a process
+func (s *Store) DeleteChatDraft(processID string) error {
+	if _, err := s.exec(`DELETE FROM chat_drafts WHERE process_id = ?`, processID); err != nil {
+		return fmt.Errorf("failed to delete chat draft: %w", err)
+	}
+	return nil
+}
+
+// ClearSentChatDraft removes the draft of a process if a message sent to it
+// starts with the draft's text, and reports whether it did. The last draft
+// saved can lag the compose box, so the message may go on past it; a draft
+// that doesn't lead the message was typed for something else and is kept.
+func (s *Store) ClearSentChatDraft(processID, sent string) (bool, error) {
+	draft, err := s.GetChatDraft(processID)
+	if err != nil || draft == nil {
+		return false, err
+	}
+	if !strings.HasPrefix(strings.TrimSpace(sent), strings.TrimSpace(draft.Text)) {
+		return false, nil
+	}
+	// Only the draft that was read, in case a newer one was saved since
+	result, err := s.exec(`DELETE FROM chat_drafts WHERE process_id = ? AND text = ?`, processID, draft.Text)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete chat draft: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// PruneChatDrafts removes the drafts of processes that no longer have
+// metadata or were archived, since nothing can be sent to them
+func (s *Store) PruneChatDrafts() error {
+	result, err := s.exec(`DELETE FROM chat_drafts WHERE process_id NOT IN (SELECT process_id FROM process_metadata WHERE archived_at IS NULL)`)
+	if err != nil {
+		return fmt.Errorf("failed to prune chat drafts: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("[DEBUG] [Storage] Pruned %d chat drafts of removed processes", n)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChatDrafts(t *testing.T) {
+	s := newTestStore(t)
+
+	if draft, err := s.GetChatDraft("proc-1"); err != nil || draft != nil {
+		t.Fatalf("GetChatDraft without a draft = %+v, %v", draft, err)
+	}
+
+	before := time.Now().Add(-time.Second)
+	if err := s.SetChatDraft("proc-1", "first"); err != nil {
+		t.Fatalf("SetChatDraft: %v", err)
+	}
+	if err := s.SetChatDraft("proc-1", "fix the bug"); err != nil {
+		t.Fatalf("SetChatDraft: %v", err)
+	}
+	draft, err := s.GetChatDraft("proc-1")
+	if err != nil || draft == nil || draft.Text != "fix the bug" || draft.UpdatedAt.Before(before) {
+		t.Fatalf("GetChatDraft = %+v, %v", draft, err)
+	}
+
+	if err := s.SetChatDraft("proc-1", strings.Repeat("x", MaxChatDraftSize+1)); err == nil {
+		t.Error("SetChatDraft stored a draft over the size limit")
+	}
+
+	// A message that doesn't start with the draft leaves it
+	if cleared, err := s.ClearSentChatDraft("proc-1", "something else"); err != nil || cleared {
+		t.Errorf("ClearSentChatDraft(something else) = %v, %v", cleared, err)
+	}
+	if cleared, err := s.ClearSentChatDraft("proc-1", "fix the bug in main.go\n"); err != nil || !cleared {
+		t.Errorf("ClearSentChatDraft = %v, %v; want cleared", cleared, err)
+	}
+	if draft, _ := s.GetChatDraft("proc-1"); draft != nil {
+		t.Errorf("draft = %+v after sending it", draft)
+	}
+
+	s.SetChatDraft("proc-1", "again")
+	if err := s.SetChatDraft("proc-1", ""); err != nil {
+		t.Fatalf("SetChatDraft: %v", err)
+	}
+	if draft, _ := s.GetChatDraft("proc-1"); draft != nil {
+		t.Errorf("draft = %+v after an empty one was set", draft)
+	}
+}
+
+func TestPruneChatDrafts(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.SaveProcessMetadata(ProcessMetadata{ProcessID: "proc-1", HostID: "host-1", ProcessType: "claude", TmuxName: "rc-proc-1", StartedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	s.SetChatDraft("proc-1", "kept")
+	s.SetChatDraft("proc-gone", "pruned")
+
+	if err := s.PruneChatDrafts(); err != nil {
+		t.Fatalf("PruneChatDrafts: %v", err)
+	}
+	if draft, _ := s.GetChatDraft("proc-1"); draft == nil {
+		t.Error("draft of a live process pruned")
+	}
+	if draft, _ := s.GetChatDraft("proc-gone"); draft != nil {
+		t.Errorf("draft of a removed process = %+v", draft)
+	}
+}
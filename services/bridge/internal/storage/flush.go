@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// ============================================================================
+// Persist Interval and Early Flushes
+// ============================================================================
+//
+// Buffers are persisted together every persist interval, so a crash loses
+// up to an interval of output and chat. A process whose unsaved data grows
+// past a size, or whose oldest unsaved data gets too old, has its buffers
+// flushed early, by the persistence loop: AppendPtyOutput and
+// UpsertChatMessage only queue it. An early flush writes the PTY chunks
+// appended since the last write and the chat buffer; the periodic persist
+// still rewrites the whole PTY buffer as before.
+//
+// Lock order: s.mu, then a buffer's mu, then s.flushMu. s.flushMu is a leaf:
+// nothing else is locked while it is held, so queueing a flush with a
+// buffer locked can't deadlock against the loop, which takes the queue and
+// releases s.flushMu before persisting anything.
+
+// SetPersistInterval sets how often every dirty buffer is persisted. It
+// takes effect at once; intervals below a second are raised to one.
+func (s *Store) SetPersistInterval(interval time.Duration) {
+	if interval < time.Second {
+		interval = time.Second
+	}
+	s.persistEvery.Store(int64(interval))
+	s.reconfigureLoop()
+}
+
+// persistPeriod returns the interval between periodic persists
+func (s *Store) persistPeriod() time.Duration {
+	return time.Duration(s.persistEvery.Load())
+}
+
+// SetEarlyFlush sets when a process's buffers are flushed before the next
+// periodic persist: once maxBytes of its PTY output or chat text are
+// unsaved, or its oldest unsaved data is maxAge old. 0 disables either.
+// Aged data is looked for every quarter of maxAge, so it is flushed within
+// 1.25 maxAge of arriving.
+func (s *Store) SetEarlyFlush(maxBytes int64, maxAge time.Duration) {
+	s.flushMaxBytes.Store(maxBytes)
+	s.flushMaxAge.Store(int64(maxAge))
+	s.reconfigureLoop()
+}
+
+// reconfigureLoop tells the persistence loop its intervals changed
+func (s *Store) reconfigureLoop() {
+	select {
+	case s.loopConfigured <- struct{}{}:
+	default:
+	}
+}
+
+// agedFlushPeriod returns how often buffers are looked at for aged data, or
+// 0 when age doesn't trigger flushes
+func (s *Store) agedFlushPeriod() time.Duration {
+	return time.Duration(s.flushMaxAge.Load()) / 4
+}
+
+// flushDue reports whether a buffer holding unflushed bytes, unsaved since
+// dirtySince, is due an early flush
+func (s *Store) flushDue(unflushed int64, dirtySince time.Time) bool {
+	if dirtySince.IsZero() {
+		return false
+	}
+	if maxBytes := s.flushMaxBytes.Load(); maxBytes > 0 && unflushed >= maxBytes {
+		return true
+	}
+	maxAge := time.Duration(s.flushMaxAge.Load())
+	return maxAge > 0 && s.now().Sub(dirtySince) >= maxAge
+}
+
+// queueFlush queues an early flush of a process's buffers and wakes the
+// persistence loop. It may be called with a buffer locked.
+func (s *Store) queueFlush(processId string) {
+	s.flushMu.Lock()
+	s.flushQueue[processId] = true
+	s.flushMu.Unlock()
+
+	select {
+	case s.flushWake <- struct{}{}:
+	default:
+	}
+}
+
+// takeFlushQueue returns the processes queued for an early flush and empties
+// the queue
+func (s *Store) takeFlushQueue() []string {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	processIds := make([]string, 0, len(s.flushQueue))
+	for processId := range s.flushQueue {
+		processIds = append(processIds, processId)
+	}
+	clear(s.flushQueue)
+	return processIds
+}
+
+// queueAgedFlushes queues the processes whose oldest unsaved data is due a
+// flush, for those that had no append or chat message since it got old
+func (s *Store) queueAgedFlushes() {
+	s.mu.RLock()
+	ptyBuffers := make(map[string]*PtyBuffer, len(s.ptyBuffers))
+	for processId, buf := range s.ptyBuffers {
+		ptyBuffers[processId] = buf
+	}
+	chatBuffers := make(map[string]*ChatBuffer, len(s.chatBuffers))
+	for processId, buf := range s.chatBuffers {
+		chatBuffers[processId] = buf
+	}
+	s.mu.RUnlock()
+
+	for processId, buf := range ptyBuffers {
+		buf.mu.RLock()
+		if s.flushDue(buf.unflushed, buf.dirtySince) {
+			s.queueFlush(processId)
+		}
+		buf.mu.RUnlock()
+	}
+	for processId, buf := range chatBuffers {
+		buf.mu.RLock()
+		if s.flushDue(buf.unflushed, buf.dirtySince) {
+			s.queueFlush(processId)
+		}
+		buf.mu.RUnlock()
+	}
+}
+
+// runQueuedFlushes flushes the processes queued for an early flush
+func (s *Store) runQueuedFlushes() {
+	for _, processId := range s.takeFlushQueue() {
+		if err := s.FlushProcess(processId); err != nil {
+			// Still unsaved, so the next append or the periodic persist
+			// tries again
+			log.Printf("[WARN] [Storage] Early flush of process %s failed: %v", processId, err)
+		}
+	}
+}
+
+// FlushProcess persists a process's PTY output not yet written and its
+// dirty chat messages now
+func (s *Store) FlushProcess(processId string) error {
+	if err := s.flushPtyBuffer(processId); err != nil {
+		return fmt.Errorf("pty %s: %w", processId, err)
+	}
+	if err := s.persistChatBuffer(processId); err != nil {
+		return fmt.Errorf("chat %s: %w", processId, err)
+	}
+	return nil
+}
+
+// Flush persists every dirty buffer now, as the periodic persist does, for
+// callers about to do something that might take the bridge down
+func (s *Store) Flush() error {
+	err := s.PersistAll()
+	s.recordPersist(err)
+	return err
+}
+
+// markDirty notes n bytes of unsaved output arriving at now. The caller
+// must hold b.mu.
+func (b *PtyBuffer) markDirty(n int, now time.Time) {
+	if b.dirtySince.IsZero() {
+		b.dirtySince = now
+	}
+	b.unflushed += int64(n)
+	b.dirty = true
+}
+
+// markDirty notes n bytes of unsaved chat text arriving at now. The caller
+// must hold b.mu.
+func (b *ChatBuffer) markDirty(n int, now time.Time) {
+	if b.dirtySince.IsZero() {
+		b.dirtySince = now
+	}
+	b.unflushed += int64(n)
+	b.dirty = true
+}
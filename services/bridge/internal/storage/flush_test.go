@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock tests move by hand
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// openStorePair opens a store, and a second one over the same database that
+// sees only what the first wrote: what would be left if it crashed
+func openStorePair(t *testing.T) (*Store, *Store) {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	survivor, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore over the same database: %v", err)
+	}
+	t.Cleanup(func() { survivor.Close() })
+	return s, survivor
+}
+
+// queued reports whether a process is queued for an early flush
+func queued(s *Store, processId string) bool {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	return s.flushQueue[processId]
+}
+
+// waitForStored waits for a process's stored PTY history to read want
+func waitForStored(t *testing.T, s *Store, processId, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stored, err := s.getPtyHistoryFromDB(processId)
+		if err == nil && string(stored) == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stored PTY history of %s = %q, %v; want %q", processId, stored, err, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitForStoredChat waits for a process's stored chat to hold want messages
+func waitForStoredChat(t *testing.T, s *Store, processId string, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stored, err := s.getChatHistoryFromDB(processId)
+		if err == nil && len(stored) == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stored chat of %s = %+v, %v; want %d messages", processId, stored, err, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEarlyFlushOnSize(t *testing.T) {
+	s, survivor := openStorePair(t)
+	s.SetEarlyFlush(1024, 0)
+
+	first := strings.Repeat("a", 600)
+	s.AppendPtyOutput("proc-1", "host-1", []byte(first))
+	if queued(s, "proc-1") {
+		t.Fatal("flush queued below the threshold")
+	}
+
+	// Past the threshold the output is written well before the 30s persist
+	second := strings.Repeat("b", 600)
+	s.AppendPtyOutput("proc-1", "host-1", []byte(second))
+	waitForStored(t, survivor, "proc-1", first+second)
+
+	// Counting starts again from the flush; only new chunks are written
+	s.AppendPtyOutput("proc-1", "host-1", []byte("c"))
+	if queued(s, "proc-1") {
+		t.Error("flush queued right after one")
+	}
+	s.mu.RLock()
+	buf := s.ptyBuffers["proc-1"]
+	s.mu.RUnlock()
+	buf.mu.RLock()
+	if buf.persisted != 2 || !buf.dirty {
+		t.Errorf("after the flush: %d chunks persisted, dirty %v; want 2, still dirty for the periodic persist", buf.persisted, buf.dirty)
+	}
+	buf.mu.RUnlock()
+
+	// Chat text counts on its own
+	s.UpsertChatMessage("proc-1", "host-1", ChatMessage{MessageID: 0, Role: "agent", Message: strings.Repeat("x", 2000)})
+	waitForStoredChat(t, survivor, "proc-1", 1)
+}
+
+func TestEarlyFlushOnAge(t *testing.T) {
+	s, survivor := openStorePair(t)
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	s.now = clock.Now
+	// Aged data is looked for every 15s of real time, longer than the test
+	s.SetEarlyFlush(1<<20, time.Minute)
+
+	s.AppendPtyOutput("proc-1", "host-1", []byte("$ make\r\n"))
+	clock.Advance(59 * time.Second)
+	s.AppendPtyOutput("proc-1", "host-1", []byte("building\r\n"))
+	if queued(s, "proc-1") {
+		t.Fatal("flush queued before the oldest output was a minute old")
+	}
+
+	// Output appended once the oldest is a minute old flushes both
+	clock.Advance(time.Second)
+	s.AppendPtyOutput("proc-1", "host-1", []byte("done\r\n"))
+	waitForStored(t, survivor, "proc-1", "$ make\r\nbuilding\r\ndone\r\n")
+
+	// A chat answer followed by silence is flushed by the sweep
+	s.UpsertChatMessage("proc-1", "host-1", ChatMessage{MessageID: 0, Role: "agent", Message: "All tests pass."})
+	s.queueAgedFlushes()
+	if queued(s, "proc-1") {
+		t.Fatal("fresh chat message queued")
+	}
+	clock.Advance(time.Minute)
+	s.queueAgedFlushes()
+	s.runQueuedFlushes()
+	waitForStoredChat(t, survivor, "proc-1", 1)
+}
+
+func TestSetPersistInterval(t *testing.T) {
+	s, survivor := openStorePair(t)
+
+	// The default 30s ticker is replaced at once
+	s.SetPersistInterval(time.Second)
+	s.AppendPtyOutput("proc-1", "host-1", []byte("kept"))
+	waitForStored(t, survivor, "proc-1", "kept")
+	if stats := s.Stats(); stats.LastSuccessfulPersist == nil {
+		t.Errorf("stats = %+v, want the persist recorded", stats)
+	}
+}
+
+// TestEarlyFlushLockOrder appends and upserts with every write due a flush,
+// while everything else that locks buffers runs too. The lock order is in
+// flush.go; breaking it deadlocks here.
+func TestEarlyFlushLockOrder(t *testing.T) {
+	s, survivor := openStorePair(t)
+	s.SetEarlyFlush(1, time.Millisecond)
+	s.SetPtyHistoryMaxBytes(1 << 20)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for p := 0; p < 4; p++ {
+		processId := fmt.Sprintf("proc-%d", p)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				s.AppendPtyOutput(processId, "host-1", []byte(fmt.Sprintf("line %d\r\n", i)))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				s.UpsertChatMessage(processId, "host-1", ChatMessage{MessageID: i, Role: "agent", Message: "ok"})
+			}
+		}()
+	}
+	var background sync.WaitGroup
+	for _, run := range []func(){
+		func() { s.PersistAll() },
+		func() { s.FlushProcess("proc-0") },
+		func() { s.PrunePtyHistory() },
+		func() { s.queueAgedFlushes() },
+		func() { s.GetPtyHistory("proc-1") },
+	} {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					run()
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stop)
+		background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("appends and flushes deadlocked")
+	}
+
+	// Nothing was lost or written out of order
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	for p := 0; p < 4; p++ {
+		processId := fmt.Sprintf("proc-%d", p)
+		history, _ := s.GetPtyHistory(processId)
+		waitForStored(t, survivor, processId, string(history))
+		if !strings.HasSuffix(string(history), "line 199\r\n") {
+			t.Errorf("%s history ends %q", processId, history[len(history)-20:])
+		}
+	}
+}
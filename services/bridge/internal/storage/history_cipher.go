@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// HistoryCipher encrypts the content columns of history: chat_history.message,
+// pty_history.data and process_metadata.env_vars
+type HistoryCipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// Stored content values that begin with historyMarker carry a format byte
+// after it. Values without the marker predate encryption and are plaintext,
+// so a database can hold both while it is being migrated.
+const (
+	historyMarker = 0x00
+
+	historyPlain     = 0x00 // Plaintext that itself begins with historyMarker
+	historyEncrypted = 0x01 // AES-GCM: nonce, then the sealed content
+)
+
+// encryptedPrefix begins every encrypted value, as an SQL blob literal
+const encryptedPrefix = `x'0001'`
+
+// SetHistoryEncryption sets the cipher that decrypts stored history and
+// whether history written from now on is encrypted with it. Without a
+// cipher encrypted rows can't be read.
+//
+// The chat search index would hold messages in plaintext, so encrypting
+// drops it and chat search decrypts messages as it scans them instead. The
+// choice is remembered, so the next NewStore doesn't rebuild the index only
+// to drop it again; turning encryption off rebuilds it.
+func (s *Store) SetHistoryEncryption(cipher HistoryCipher, encrypt bool) error {
+	if encrypt && cipher == nil {
+		return errors.New("history encryption needs a cipher")
+	}
+	s.historyCipher = cipher
+	s.encryptHistory = encrypt
+
+	previous, _, err := s.GetSetting(SettingHistoryEncrypted)
+	if err != nil {
+		return err
+	}
+	if encrypt {
+		if s.chatFTS {
+			if err := dropChatSearch(s.db); err != nil {
+				return fmt.Errorf("failed to drop chat search index: %w", err)
+			}
+			s.chatFTS = false
+			log.Printf("[INFO] [Storage] Dropped chat search index; chat search decrypts messages instead")
+		}
+		log.Printf("[INFO] [Storage] Encrypting chat, PTY history and env vars at rest")
+		return s.SetSetting(SettingHistoryEncrypted, "true")
+	}
+	if previous == "true" {
+		// Messages still encrypted can't be indexed until they are rewritten
+		s.chatFTS = initChatSearch(s.db)
+		return s.SetSetting(SettingHistoryEncrypted, "false")
+	}
+	return nil
+}
+
+// sealHistory encodes content for storage, encrypting it when history
+// encryption is on
+func (s *Store) sealHistory(content []byte) ([]byte, error) {
+	if s.encryptHistory {
+		sealed, err := s.historyCipher.Encrypt(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt history: %w", err)
+		}
+		return append([]byte{historyMarker, historyEncrypted}, sealed...), nil
+	}
+	if len(content) > 0 && content[0] == historyMarker {
+		return append([]byte{historyMarker, historyPlain}, content...), nil
+	}
+	return content, nil
+}
+
+// sealHistoryText encodes text content for storage. Plaintext stays a
+// string, so it is stored as TEXT as before; encrypted content is a BLOB.
+func (s *Store) sealHistoryText(content string) (interface{}, error) {
+	sealed, err := s.sealHistory([]byte(content))
+	if err != nil {
+		return nil, err
+	}
+	if !s.encryptHistory {
+		return string(sealed), nil
+	}
+	return sealed, nil
+}
+
+// openHistory decodes stored content, whichever format it was written in
+func (s *Store) openHistory(stored []byte) ([]byte, error) {
+	if len(stored) < 2 || stored[0] != historyMarker {
+		return stored, nil
+	}
+	switch stored[1] {
+	case historyPlain:
+		return stored[2:], nil
+	case historyEncrypted:
+		if s.historyCipher == nil {
+			return nil, errors.New("history is encrypted but no key is set")
+		}
+		content, err := s.historyCipher.Decrypt(stored[2:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt history: %w", err)
+		}
+		return content, nil
+	}
+	return stored, nil
+}
+
+// openHistoryText decodes stored text content
+func (s *Store) openHistoryText(stored []byte) (string, error) {
+	content, err := s.openHistory(stored)
+	return string(content), err
+}
+
+// ============================================================================
+// Migration
+// ============================================================================
+
+// EncryptProgress reports how far EncryptHistoryNow got through a table's
+// content column; chat_history has two, and is reported for each
+type EncryptProgress struct {
+	Table string
+	Done  int // Rows encrypted so far
+	Total int // Rows that were not encrypted when the table was started
+}
+
+// historyColumn is a content column EncryptHistoryNow migrates
+type historyColumn struct {
+	table  string
+	key    string // Column the rows are visited in order of
+	column string
+	size   bool // The table has a size column holding the content length
+}
+
+var historyColumns = []historyColumn{
+	{table: "pty_history", key: "id", column: "data", size: true},
+	{table: "chat_history", key: "id", column: "message"},
+	{table: "chat_history", key: "id", column: "metadata"},
+	{table: "process_metadata", key: "process_id", column: "env_vars"},
+}
+
+// EncryptHistoryNow encrypts every stored row that isn't encrypted yet,
+// rather than waiting for each to be persisted again. Buffers in memory are
+// persisted first. progress is called as each table starts and after every
+// batch. A row rewritten while it is being migrated is left as the writer
+// stored it. Returns the number of rows encrypted.
+func (s *Store) EncryptHistoryNow(progress func(EncryptProgress)) (int, error) {
+	if !s.encryptHistory {
+		return 0, errors.New("history encryption is off")
+	}
+	if err := s.PersistAll(); err != nil {
+		return 0, err
+	}
+
+	encrypted := 0
+	for _, col := range historyColumns {
+		n, err := s.encryptColumn(col, progress)
+		encrypted += n
+		if err != nil {
+			return encrypted, fmt.Errorf("failed to encrypt %s: %w", col.table, err)
+		}
+	}
+	log.Printf("[INFO] [Storage] Encrypted %d history rows", encrypted)
+	return encrypted, nil
+}
+
+// encryptColumn encrypts the plaintext values of one content column, one
+// batch of rows per transaction
+func (s *Store) encryptColumn(col historyColumn, progress func(EncryptProgress)) (int, error) {
+	plaintext := fmt.Sprintf(`%s IS NOT NULL AND substr(%s, 1, 2) IS NOT %s`, col.column, col.column, encryptedPrefix)
+
+	report := EncryptProgress{Table: col.table}
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM ` + col.table + ` WHERE ` + plaintext).Scan(&report.Total); err != nil {
+		return 0, err
+	}
+	if progress != nil {
+		progress(report)
+	}
+
+	update := fmt.Sprintf(`UPDATE %s SET %s = ? WHERE %s = ? AND %s = ?`, col.table, col.column, col.key, col.column)
+	if col.size {
+		update = fmt.Sprintf(`UPDATE %s SET %s = ?, size = ? WHERE %s = ? AND %s = ?`, col.table, col.column, col.key, col.column)
+	}
+
+	var after interface{} = ""
+	if col.key == "id" {
+		after = 0
+	}
+	for report.Done < report.Total {
+		var last interface{}
+		n := 0
+		err := retryBusy(func() error {
+			last, n = nil, 0
+			return s.inTx(func(tx *sql.Tx) error {
+				rows, err := tx.Query(fmt.Sprintf(`SELECT %s, %s FROM %s WHERE %s > ? AND %s ORDER BY %s LIMIT %d`,
+					col.key, col.column, col.table, col.key, plaintext, col.key, persistBatchSize), after)
+				if err != nil {
+					return err
+				}
+				type stored struct{ key, value interface{} }
+				var batch []stored
+				for rows.Next() {
+					var row stored
+					if err := rows.Scan(&row.key, &row.value); err != nil {
+						rows.Close()
+						return err
+					}
+					batch = append(batch, row)
+				}
+				rows.Close()
+				if err := rows.Err(); err != nil {
+					return err
+				}
+
+				for _, row := range batch {
+					last = row.key
+					var raw []byte
+					switch v := row.value.(type) {
+					case string:
+						raw = []byte(v)
+					case []byte:
+						raw = v
+					default:
+						continue
+					}
+					content, err := s.openHistory(raw)
+					if err != nil {
+						return fmt.Errorf("row %v: %w", row.key, err)
+					}
+					sealed, err := s.sealHistory(content)
+					if err != nil {
+						return err
+					}
+					args := []interface{}{sealed, row.key, row.value}
+					if col.size {
+						args = []interface{}{sealed, len(content), row.key, row.value}
+					}
+					result, err := tx.Exec(update, args...)
+					if err != nil {
+						return err
+					}
+					if changed, _ := result.RowsAffected(); changed > 0 {
+						n++
+					}
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return report.Done, err
+		}
+		if last == nil {
+			break // Fewer rows were left than counted
+		}
+		after = last
+		report.Done += n
+		if progress != nil {
+			progress(report)
+		}
+	}
+	return report.Done, nil
+}
@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/crypto"
+)
+
+// testHistoryCipher returns a cipher with a fixed key, so a reopened store
+// can read what an earlier one wrote
+func testHistoryCipher(t *testing.T, seed byte) *crypto.Cipher {
+	t.Helper()
+	cipher, err := crypto.NewCipher(bytes.Repeat([]byte{seed}, 32))
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	return cipher.Derive("history")
+}
+
+// openHistoryStore opens the store at dbPath with history encryption on or
+// off; the caller closes it
+func openHistoryStore(t *testing.T, dbPath string, encrypt bool) *Store {
+	t.Helper()
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if err := s.SetHistoryEncryption(testHistoryCipher(t, 1), encrypt); err != nil {
+		t.Fatalf("SetHistoryEncryption: %v", err)
+	}
+	return s
+}
+
+// writeHistory stores PTY output, chat and env vars for proc-1. The second
+// PTY chunk begins with the format marker, like output holding a NUL.
+func writeHistory(t *testing.T, s *Store) {
+	t.Helper()
+	s.AppendPtyOutput("proc-1", "host-1", []byte("$ cat secret.go\r\n"))
+	s.AppendPtyOutput("proc-1", "host-1", []byte("\x00\x01package secret\r\n"))
+	if err := s.SetChatMessages("proc-1", "host-1", []ChatMessage{
+		{MessageID: 1, Role: "user", Message: "Explain the proprietary algorithm", MessageTime: "2026-01-01T10:00:00Z"},
+		{MessageID: 2, Role: "assistant", Message: "It hashes the input twice", MessageTime: "2026-01-01T10:00:05Z"},
+		{MessageID: 3, Role: "assistant", Message: "Reading it", MessageTime: "2026-01-01T10:00:06Z",
+			Kind: "tool_call", Metadata: json.RawMessage(`{"input":{"path":"algorithm.go"}}`)},
+	}); err != nil {
+		t.Fatalf("SetChatMessages: %v", err)
+	}
+	if err := s.SaveProcessMetadata(ProcessMetadata{
+		ProcessID: "proc-1", HostID: "host-1", ProcessType: "shell", TmuxName: "rc-proc-1",
+		EnvVars: []EnvVar{{Key: "API_TOKEN", Value: "hunter2"}},
+	}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	if err := s.PersistAll(); err != nil {
+		t.Fatalf("PersistAll: %v", err)
+	}
+}
+
+// checkHistory reads back what writeHistory stored, from the database
+func checkHistory(t *testing.T, s *Store) {
+	t.Helper()
+	wantPty := "$ cat secret.go\r\n\x00\x01package secret\r\n"
+	history, err := s.GetPtyHistory("proc-1")
+	if err != nil || string(history) != wantPty {
+		t.Errorf("GetPtyHistory = %q, %v", history, err)
+	}
+	if size := s.GetPtyHistorySize("proc-1"); size != int64(len(wantPty)) {
+		t.Errorf("GetPtyHistorySize = %d, want %d", size, len(wantPty))
+	}
+	got, total := collectChunks(t, s, "proc-1", 16)
+	checkChunks(t, got, total, sliceChunks([]byte(wantPty), 16))
+
+	messages, err := s.GetChatHistory("proc-1")
+	if err != nil || len(messages) != 3 || messages[0].Message != "Explain the proprietary algorithm" || messages[1].Message != "It hashes the input twice" ||
+		messages[2].Kind != "tool_call" || string(messages[2].Metadata) != `{"input":{"path":"algorithm.go"}}` {
+		t.Errorf("GetChatHistory = %+v, %v", messages, err)
+	}
+
+	meta, err := s.GetProcessMetadata("proc-1")
+	if err != nil || meta == nil || len(meta.EnvVars) != 1 || meta.EnvVars[0].Value != "hunter2" {
+		t.Errorf("GetProcessMetadata = %+v, %v", meta, err)
+	}
+}
+
+// storedPlaintext counts the content values in the database that aren't
+// encrypted
+func storedPlaintext(t *testing.T, s *Store) map[string]int {
+	t.Helper()
+	counts := make(map[string]int)
+	for _, col := range historyColumns {
+		var n int
+		if err := s.db.QueryRow(`SELECT COUNT(*) FROM ` + col.table + ` WHERE ` + col.column + ` IS NOT NULL AND substr(` + col.column + `, 1, 2) IS NOT ` + encryptedPrefix).Scan(&n); err != nil {
+			t.Fatalf("count %s: %v", col.table, err)
+		}
+		counts[col.table] += n
+	}
+	return counts
+}
+
+func TestHistoryEncryptionRoundTrip(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	s := openHistoryStore(t, dbPath, true)
+	writeHistory(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s = openHistoryStore(t, dbPath, true)
+	defer s.Close()
+
+	// Nothing readable is left in the content columns
+	for table, n := range storedPlaintext(t, s) {
+		if n != 0 {
+			t.Errorf("%s has %d plaintext rows", table, n)
+		}
+	}
+	for _, secret := range []string{"secret.go", "proprietary", "algorithm.go", "hunter2"} {
+		var n int
+		s.db.QueryRow(`SELECT (SELECT COUNT(*) FROM pty_history WHERE instr(data, ?) > 0)
+			+ (SELECT COUNT(*) FROM chat_history WHERE instr(message, ?) > 0 OR instr(metadata, ?) > 0)
+			+ (SELECT COUNT(*) FROM process_metadata WHERE instr(env_vars, ?) > 0)`, secret, secret, secret, secret).Scan(&n)
+		if n != 0 {
+			t.Errorf("%q stored in plaintext", secret)
+		}
+	}
+	checkHistory(t, s)
+
+	// Another key can't read it
+	s.historyCipher = testHistoryCipher(t, 2)
+	if _, err := s.GetPtyHistory("proc-1"); err == nil {
+		t.Error("PTY history read with the wrong key")
+	}
+	if _, err := s.GetChatHistory("proc-1"); err == nil {
+		t.Error("chat history read with the wrong key")
+	}
+}
+
+func TestHistoryEncryptionMigratesPlaintext(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	s := openHistoryStore(t, dbPath, false)
+	writeHistory(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s = openHistoryStore(t, dbPath, true)
+	defer s.Close()
+	if got := storedPlaintext(t, s); got["pty_history"] != 2 || got["chat_history"] != 4 || got["process_metadata"] != 1 {
+		t.Fatalf("plaintext before migrating = %v", got)
+	}
+	checkHistory(t, s)
+
+	// Persisting chat again encrypts it, leaving the rest mixed
+	if err := s.EnsurePtyHistoryLoaded("proc-1", "host-1"); err != nil {
+		t.Fatalf("EnsurePtyHistoryLoaded: %v", err)
+	}
+	if err := s.loadChatHistory("proc-1", "host-1"); err != nil {
+		t.Fatalf("loadChatHistory: %v", err)
+	}
+	if _, err := s.UpsertChatMessage("proc-1", "host-1", ChatMessage{MessageID: 2, Role: "assistant", Message: "It hashes the input twice", MessageTime: "2026-01-01T10:00:05Z"}); err != nil {
+		t.Fatalf("UpsertChatMessage: %v", err)
+	}
+	if err := s.persistChatBuffer("proc-1"); err != nil {
+		t.Fatalf("persistChatBuffer: %v", err)
+	}
+	if got := storedPlaintext(t, s); got["pty_history"] != 2 || got["chat_history"] != 0 || got["process_metadata"] != 1 {
+		t.Fatalf("plaintext after persisting chat = %v", got)
+	}
+	if messages, err := s.getChatHistoryFromDB("proc-1"); err != nil || len(messages) != 3 || messages[0].Message != "Explain the proprietary algorithm" {
+		t.Errorf("chat history = %+v, %v", messages, err)
+	}
+
+	// The rest is encrypted on request, reporting each table's progress
+	var reports []EncryptProgress
+	n, err := s.EncryptHistoryNow(func(p EncryptProgress) { reports = append(reports, p) })
+	if err != nil {
+		t.Fatalf("EncryptHistoryNow: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("encrypted %d rows, want 3", n)
+	}
+	want := []EncryptProgress{
+		{Table: "pty_history", Total: 2}, {Table: "pty_history", Done: 2, Total: 2},
+		{Table: "chat_history"}, {Table: "chat_history"},
+		{Table: "process_metadata", Total: 1}, {Table: "process_metadata", Done: 1, Total: 1},
+	}
+	if len(reports) != len(want) {
+		t.Fatalf("progress = %+v, want %+v", reports, want)
+	}
+	for i := range want {
+		if reports[i] != want[i] {
+			t.Errorf("progress[%d] = %+v, want %+v", i, reports[i], want[i])
+		}
+	}
+	for table, n := range storedPlaintext(t, s) {
+		if n != 0 {
+			t.Errorf("%s has %d plaintext rows", table, n)
+		}
+	}
+	s.mu.Lock()
+	delete(s.ptyBuffers, "proc-1")
+	s.mu.Unlock()
+	checkHistory(t, s)
+
+	// Nothing is left the second time
+	if n, err := s.EncryptHistoryNow(nil); err != nil || n != 0 {
+		t.Errorf("second EncryptHistoryNow = %d, %v", n, err)
+	}
+
+	// It needs encryption on
+	s.encryptHistory = false
+	if _, err := s.EncryptHistoryNow(nil); err == nil {
+		t.Error("EncryptHistoryNow ran with encryption off")
+	}
+}
+
+func TestHistoryMarkerEscapedWithoutEncryption(t *testing.T) {
+	s := newTestStore(t)
+	for _, data := range []string{"plain", "\x00", "\x00\x01looks encrypted", "\x00\x00"} {
+		sealed, err := s.sealHistory([]byte(data))
+		if err != nil {
+			t.Fatalf("sealHistory(%q): %v", data, err)
+		}
+		if opened, err := s.openHistory(sealed); err != nil || string(opened) != data {
+			t.Errorf("openHistory(sealHistory(%q)) = %q, %v", data, opened, err)
+		}
+	}
+	// Text stays TEXT when not encrypted
+	if text, _ := s.sealHistoryText("hello"); text != "hello" {
+		t.Errorf("sealHistoryText = %#v", text)
+	}
+}
+
+func TestSearchEncryptedChat(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	s := openHistoryStore(t, dbPath, true)
+	if s.chatFTS {
+		t.Fatal("chat search index kept with encryption on")
+	}
+	seedChatCorpus(t, s)
+
+	groups, err := s.SearchChat(ChatSearchQuery{Query: "LOGIN test"})
+	if err != nil {
+		t.Fatalf("SearchChat: %v", err)
+	}
+	if got := matchCounts(groups); len(got) != 3 || got["proc-a"] != 2 || got["proc-b"] != 1 || got["proc-c"] != 1 {
+		t.Fatalf("counts = %v", got)
+	}
+	for _, g := range groups {
+		if g.ProcessID == "proc-a" && g.ProcessName != "auth fixes" {
+			t.Errorf("proc-a group = %+v", g)
+		}
+		for _, m := range g.Matches {
+			if parts := highlighted(m); len(parts) != 2 || !strings.EqualFold(parts[0]+parts[1], "logintest") && !strings.EqualFold(parts[0]+parts[1], "testlogin") {
+				t.Errorf("%s/%d highlights %q in %q", g.ProcessID, m.MessageID, parts, m.Snippet)
+			}
+		}
+	}
+
+	// The limit caps matches, not counts
+	groups, err = s.SearchChat(ChatSearchQuery{Query: "login", Limit: 1})
+	if err != nil {
+		t.Fatalf("SearchChat: %v", err)
+	}
+	want := map[string]int{"proc-a": 2, "proc-b": 1, "proc-c": 1}
+	if len(groups) != 1 || len(groups[0].Matches) != 1 || groups[0].MatchCount != want[groups[0].ProcessID] {
+		t.Fatalf("limited = %+v", groups)
+	}
+
+	// Host and role filters
+	groups, err = s.SearchChat(ChatSearchQuery{Query: "login", HostID: "host-1", Role: "assistant"})
+	if err != nil {
+		t.Fatalf("SearchChat: %v", err)
+	}
+	if len(groups) != 1 || groups[0].ProcessID != "proc-a" || groups[0].MatchCount != 1 || groups[0].Matches[0].MessageID != 2 {
+		t.Fatalf("filtered = %+v", groups)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Reopening doesn't bring the index back until encryption is off
+	s, err = NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+	if s.chatFTS {
+		t.Error("chat search index rebuilt while history is encrypted")
+	}
+	if err := s.SetHistoryEncryption(testHistoryCipher(t, 1), false); err != nil {
+		t.Fatalf("SetHistoryEncryption: %v", err)
+	}
+	if !s.chatFTS {
+		t.Error("chat search index not rebuilt with encryption off")
+	}
+}
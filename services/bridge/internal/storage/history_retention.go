@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+)
+
+// PTY history is capped per process: PrunePtyHistory drops the oldest
+// chunks of every process holding more than the cap, in memory and in the
+// database, keeping the newest. Processes marked to retain their full
+// history are skipped, however large they grow.
+
+// SetPtyHistoryMaxBytes sets how much PTY history PrunePtyHistory keeps per
+// process; 0 keeps it all
+func (s *Store) SetPtyHistoryMaxBytes(maxBytes int64) {
+	s.ptyHistoryMaxBytes.Store(maxBytes)
+}
+
+// SetProcessRetainFullHistory marks whether a process's PTY history is left
+// whole by PrunePtyHistory
+func (s *Store) SetProcessRetainFullHistory(processID string, retain bool) error {
+	_, err := s.exec(`UPDATE process_metadata SET retain_full_history = ? WHERE process_id = ?`, boolToInt(retain), processID)
+	if err != nil {
+		return fmt.Errorf("failed to update process history retention: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Set retain full history of process %s to %v", processID, retain)
+	return nil
+}
+
+// PrunePtyHistory trims the PTY history of every process over the cap set
+// by SetPtyHistoryMaxBytes to at most the cap, dropping whole chunks from
+// the oldest (the newest chunk is kept even if it alone is larger).
+// Processes that retain their full history are skipped. It returns the
+// bytes dropped per trimmed process.
+func (s *Store) PrunePtyHistory() (map[string]int64, error) {
+	limit := s.ptyHistoryMaxBytes.Load()
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	// The flag is read once for the whole pass
+	retained, err := s.retainedHistoryProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make(map[string]bool)
+	rows, err := s.db.Query(`
+		SELECT process_id FROM pty_history
+		GROUP BY process_id HAVING SUM(COALESCE(size, LENGTH(data))) > ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pty history sizes: %w", err)
+	}
+	for rows.Next() {
+		var processID string
+		if err := rows.Scan(&processID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		candidates[processID] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Output not persisted yet counts too
+	s.mu.RLock()
+	buffers := make(map[string]*PtyBuffer, len(s.ptyBuffers))
+	for processID, buf := range s.ptyBuffers {
+		buffers[processID] = buf
+	}
+	s.mu.RUnlock()
+	for processID, buf := range buffers {
+		buf.mu.RLock()
+		if buf.totalBytes > limit {
+			candidates[processID] = true
+		}
+		buf.mu.RUnlock()
+	}
+
+	pruned := make(map[string]int64)
+	var errs []error
+	for processID := range candidates {
+		if retained[processID] {
+			continue
+		}
+		removed, err := s.trimPtyHistory(processID, limit)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", processID, err))
+			continue
+		}
+		if removed > 0 {
+			pruned[processID] = removed
+		}
+	}
+	if len(pruned) > 0 {
+		log.Printf("[INFO] [Storage] Trimmed PTY history of %d processes to %d bytes", len(pruned), limit)
+	}
+	if len(errs) > 0 {
+		return pruned, fmt.Errorf("failed to prune pty history: %v", errs)
+	}
+	return pruned, nil
+}
+
+// retainedHistoryProcesses returns the processes whose PTY history is kept
+// whole
+func (s *Store) retainedHistoryProcesses() (map[string]bool, error) {
+	rows, err := s.db.Query(`SELECT process_id FROM process_metadata WHERE retain_full_history = 1`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history retention: %w", err)
+	}
+	defer rows.Close()
+
+	retained := make(map[string]bool)
+	for rows.Next() {
+		var processID string
+		if err := rows.Scan(&processID); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		retained[processID] = true
+	}
+	return retained, rows.Err()
+}
+
+// trimPtyHistory trims one process's PTY history to at most limit bytes and
+// returns the bytes dropped. A loaded buffer is trimmed under its lock, so a
+// persist can't write the dropped chunks back; otherwise the store's lock is
+// held so no buffer can load the rows being deleted.
+func (s *Store) trimPtyHistory(processID string, limit int64) (int64, error) {
+	s.mu.Lock()
+	buf, ok := s.ptyBuffers[processID]
+	if !ok {
+		defer s.mu.Unlock()
+		return s.trimStoredPtyHistory(processID, limit)
+	}
+	s.mu.Unlock()
+
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	if err := s.loadStoredPtyChunks(processID, buf); err != nil {
+		return 0, fmt.Errorf("failed to load pty history: %w", err)
+	}
+
+	drop := 0
+	var removed int64
+	for drop < len(buf.chunks)-1 && buf.totalBytes-removed > limit {
+		removed += int64(len(buf.chunks[drop].Data))
+		drop++
+	}
+	if drop == 0 {
+		return 0, nil
+	}
+
+	cutoff := buf.chunks[drop].SequenceNum
+	if _, err := s.exec(`DELETE FROM pty_history WHERE process_id = ? AND sequence_num < ?`, processID, cutoff); err != nil {
+		return 0, fmt.Errorf("failed to delete pty chunks: %w", err)
+	}
+
+	// Streams started before keep reading the old slice
+	buf.chunks = append([]PtyChunk(nil), buf.chunks[drop:]...)
+	buf.totalBytes -= removed
+	buf.persisted = max(buf.persisted-drop, 0)
+	return removed, nil
+}
+
+// trimStoredPtyHistory trims the stored PTY history of a process with no
+// buffer loaded. The caller must hold s.mu.
+func (s *Store) trimStoredPtyHistory(processID string, limit int64) (int64, error) {
+	rows, err := s.db.Query(`
+		SELECT sequence_num, COALESCE(size, LENGTH(data)) FROM pty_history
+		WHERE process_id = ?
+		ORDER BY sequence_num DESC
+	`, processID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query pty history: %w", err)
+	}
+
+	var kept, removed int64
+	cutoff := int64(-1)
+	for rows.Next() {
+		var seqNum, size int64
+		if err := rows.Scan(&seqNum, &size); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan row: %w", err)
+		}
+		// Chunks are kept from the newest until the next would overflow
+		if removed == 0 && (cutoff < 0 || kept+size <= limit) {
+			kept += size
+			cutoff = seqNum
+			continue
+		}
+		removed += size
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	if _, err := s.exec(`DELETE FROM pty_history WHERE process_id = ? AND sequence_num < ?`, processID, cutoff); err != nil {
+		return 0, fmt.Errorf("failed to delete pty chunks: %w", err)
+	}
+	return removed, nil
+}
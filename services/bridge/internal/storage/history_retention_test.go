@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPrunePtyHistory(t *testing.T) {
+	s := newTestStore(t)
+	for _, id := range []string{"proc-1", "proc-2", "proc-3"} {
+		meta := ProcessMetadata{ProcessID: id, HostID: "host-1", ProcessType: "shell", TmuxName: "rc-" + id, StartedAt: time.Now()}
+		if err := s.SaveProcessMetadata(meta); err != nil {
+			t.Fatalf("SaveProcessMetadata: %v", err)
+		}
+		for i := 0; i < 10; i++ {
+			if err := s.AppendPtyOutput(id, "host-1", bytes.Repeat([]byte{'a' + byte(i)}, 100)); err != nil {
+				t.Fatalf("AppendPtyOutput: %v", err)
+			}
+		}
+	}
+	if err := s.SetProcessRetainFullHistory("proc-2", true); err != nil {
+		t.Fatalf("SetProcessRetainFullHistory: %v", err)
+	}
+	if err := s.PersistAll(); err != nil {
+		t.Fatalf("PersistAll: %v", err)
+	}
+	// proc-3's history is only in the database
+	s.mu.Lock()
+	delete(s.ptyBuffers, "proc-3")
+	s.mu.Unlock()
+
+	// Without a cap nothing is trimmed
+	if pruned, err := s.PrunePtyHistory(); err != nil || len(pruned) != 0 {
+		t.Fatalf("PrunePtyHistory without a cap = %v, %v", pruned, err)
+	}
+
+	s.SetPtyHistoryMaxBytes(350)
+	pruned, err := s.PrunePtyHistory()
+	if err != nil {
+		t.Fatalf("PrunePtyHistory: %v", err)
+	}
+	if len(pruned) != 2 || pruned["proc-1"] != 700 || pruned["proc-3"] != 700 {
+		t.Errorf("pruned = %v", pruned)
+	}
+
+	want := append(append(bytes.Repeat([]byte{'h'}, 100), bytes.Repeat([]byte{'i'}, 100)...), bytes.Repeat([]byte{'j'}, 100)...)
+	for _, id := range []string{"proc-1", "proc-3"} {
+		if size := s.GetPtyHistorySize(id); size != 300 {
+			t.Errorf("%s size = %d, want 300", id, size)
+		}
+		if history, err := s.GetPtyHistory(id); err != nil || !bytes.Equal(history, want) {
+			t.Errorf("%s history = %q, %v", id, history, err)
+		}
+	}
+	if size := s.GetPtyHistorySize("proc-2"); size != 1000 {
+		t.Errorf("retained process size = %d, want 1000", size)
+	}
+
+	// The dropped chunks stay gone after the buffer is persisted again, and
+	// new output is numbered after the kept chunks
+	if err := s.AppendPtyOutput("proc-1", "host-1", []byte("k")); err != nil {
+		t.Fatalf("AppendPtyOutput: %v", err)
+	}
+	if err := s.PersistAll(); err != nil {
+		t.Fatalf("PersistAll: %v", err)
+	}
+	if size, err := ptyHistoryDBSize(s.db, "proc-1"); err != nil || size != 301 {
+		t.Errorf("stored size = %d, %v, want 301", size, err)
+	}
+
+	meta, err := s.GetProcessMetadata("proc-2")
+	if err != nil || meta == nil || !meta.RetainFullHistory {
+		t.Errorf("GetProcessMetadata = %+v, %v", meta, err)
+	}
+	// Saving the metadata again, as a reattach does, keeps the flag
+	if err := s.SaveProcessMetadata(ProcessMetadata{ProcessID: "proc-2", HostID: "host-1", ProcessType: "shell", TmuxName: "rc-proc-2", StartedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	metas, err := s.GetProcessMetadataByHost("host-1")
+	if err != nil || len(metas) != 3 || !metas[1].RetainFullHistory || metas[0].RetainFullHistory {
+		t.Errorf("GetProcessMetadataByHost = %+v, %v", metas, err)
+	}
+}
+
+func TestPrunePtyHistoryKeepsNewestChunk(t *testing.T) {
+	s := newTestStore(t)
+	s.SetPtyHistoryMaxBytes(10)
+	if err := s.AppendPtyOutput("proc-1", "host-1", bytes.Repeat([]byte("x"), 50)); err != nil {
+		t.Fatalf("AppendPtyOutput: %v", err)
+	}
+	if pruned, err := s.PrunePtyHistory(); err != nil || len(pruned) != 0 {
+		t.Errorf("PrunePtyHistory = %v, %v", pruned, err)
+	}
+	if size := s.GetPtyHistorySize("proc-1"); size != 50 {
+		t.Errorf("size = %d, want 50", size)
+	}
+}
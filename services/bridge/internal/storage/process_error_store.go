@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ProcessError is the last failure recorded on a process
+type ProcessError struct {
+	Operation string    `json:"operation"`
+	Code      string    `json:"code"`
+	Message   string    `json:"message"`
+	At        time.Time `json:"at"`
+}
+
+// SetProcessLastError saves the last failure on a process, replacing the
+// previous one. nil clears it.
+func (s *Store) SetProcessLastError(processID string, lastError *ProcessError) error {
+	var value *string
+	if lastError != nil {
+		data, err := json.Marshal(lastError)
+		if err != nil {
+			return fmt.Errorf("failed to marshal last error: %w", err)
+		}
+		str := string(data)
+		value = &str
+	}
+	if _, err := s.exec(`UPDATE process_metadata SET last_error = ? WHERE process_id = ?`, value, processID); err != nil {
+		return fmt.Errorf("failed to update last error: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Set last error of process %s: %+v", processID, lastError)
+	return nil
+}
+
+// parseProcessError decodes the last_error column of a process
+func parseProcessError(processID string, value sql.NullString) *ProcessError {
+	if !value.Valid || value.String == "" {
+		return nil
+	}
+	var lastError ProcessError
+	if err := json.Unmarshal([]byte(value.String), &lastError); err != nil {
+		log.Printf("[WARN] [Storage] Failed to unmarshal last error for process %s: %v", processID, err)
+		return nil
+	}
+	return &lastError
+}
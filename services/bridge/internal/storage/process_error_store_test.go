@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProcessLastError(t *testing.T) {
+	s := newTestStore(t)
+	meta := ProcessMetadata{ProcessID: "proc-1", HostID: "host-1", ProcessType: "claude", TmuxName: "rc-proc-1", StartedAt: time.Now()}
+	if err := s.SaveProcessMetadata(meta); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	lastError := ProcessError{Operation: "agent_events", Code: "AGENTAPI_DOWN", Message: "connection refused", At: at}
+	if err := s.SetProcessLastError("proc-1", &lastError); err != nil {
+		t.Fatalf("SetProcessLastError: %v", err)
+	}
+
+	// Saving the metadata again keeps it
+	if err := s.SaveProcessMetadata(meta); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	got, err := s.GetProcessMetadata("proc-1")
+	if err != nil || got == nil || got.LastError == nil || *got.LastError != lastError {
+		t.Errorf("GetProcessMetadata = %+v, %v; want last error %+v", got, err, lastError)
+	}
+	metas, err := s.GetProcessMetadataByHost("host-1")
+	if err != nil || len(metas) != 1 || metas[0].LastError == nil || !metas[0].LastError.At.Equal(at) {
+		t.Errorf("GetProcessMetadataByHost = %+v, %v", metas, err)
+	}
+
+	if err := s.SetProcessLastError("proc-1", nil); err != nil {
+		t.Fatalf("SetProcessLastError: %v", err)
+	}
+	if got, err := s.GetProcessMetadata("proc-1"); err != nil || got.LastError != nil {
+		t.Errorf("cleared last error = %+v, %v", got.LastError, err)
+	}
+}
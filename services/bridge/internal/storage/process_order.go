@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+)
+
+// ProcessOrder is where a process sits in its host's process list: pinned
+// processes come first, then processes by ascending sort weight. The weights
+// of a host's processes are kept at 0..n-1 by every write.
+type ProcessOrder struct {
+	Pinned     bool
+	SortWeight int
+}
+
+// GetProcessOrders returns the order of every live process with metadata on
+// a host
+func (s *Store) GetProcessOrders(hostID string) (map[string]ProcessOrder, error) {
+	rows, err := s.db.Query(`
+		SELECT process_id, pinned, sort_weight FROM process_metadata WHERE host_id = ? AND archived_at IS NULL`, hostID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query process order: %w", err)
+	}
+	defer rows.Close()
+
+	orders := make(map[string]ProcessOrder)
+	for rows.Next() {
+		var processID string
+		var order ProcessOrder
+		if err := rows.Scan(&processID, &order.Pinned, &order.SortWeight); err != nil {
+			return nil, fmt.Errorf("failed to scan process order: %w", err)
+		}
+		orders[processID] = order
+	}
+	return orders, rows.Err()
+}
+
+// SetProcessPinned pins a process to the top of its host's list, or unpins it
+func (s *Store) SetProcessPinned(processID string, pinned bool) error {
+	_, err := s.exec(`UPDATE process_metadata SET pinned = ? WHERE process_id = ?`, pinned, processID)
+	if err != nil {
+		return fmt.Errorf("failed to update process pin: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Set process %s pinned=%v", processID, pinned)
+	return nil
+}
+
+// SetProcessOrder reorders a host's processes: processIDs come first, in the
+// given order, followed by the host's other processes in their current order.
+// IDs of processes not on the host are an error.
+func (s *Store) SetProcessOrder(hostID string, processIDs []string) error {
+	err := retryBusy(func() error {
+		return s.inTx(func(tx *sql.Tx) error {
+			current, err := hostProcessOrder(tx, hostID)
+			if err != nil {
+				return err
+			}
+			for _, id := range processIDs {
+				if !slices.Contains(current, id) {
+					return fmt.Errorf("process %s is not on host %s", id, hostID)
+				}
+			}
+
+			order := make([]string, 0, len(current))
+			for _, id := range processIDs {
+				if !slices.Contains(order, id) {
+					order = append(order, id)
+				}
+			}
+			for _, id := range current {
+				if !slices.Contains(order, id) {
+					order = append(order, id)
+				}
+			}
+			return writeSortWeights(tx, order)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set process order: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Reordered processes on host %s (%d listed)", hostID, len(processIDs))
+	return nil
+}
+
+// hostProcessOrder returns the IDs of a host's live processes by sort weight.
+// Equal weights, left by databases from before ordering, fall back to
+// creation order.
+func hostProcessOrder(tx *sql.Tx, hostID string) ([]string, error) {
+	rows, err := tx.Query(`
+		SELECT process_id FROM process_metadata WHERE host_id = ? AND archived_at IS NULL
+		ORDER BY sort_weight, started_at, process_id`, hostID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query process order: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan process order: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// writeSortWeights gives the processes weights 0..n-1 in the given order
+func writeSortWeights(tx *sql.Tx, processIDs []string) error {
+	stmt, err := tx.Prepare(`UPDATE process_metadata SET sort_weight = ? WHERE process_id = ? AND sort_weight != ?`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for weight, id := range processIDs {
+		if _, err := stmt.Exec(weight, id, weight); err != nil {
+			return fmt.Errorf("failed to write sort weight: %w", err)
+		}
+	}
+	return nil
+}
+
+// normalizeSortWeights renumbers a host's sort weights to 0..n-1, keeping
+// their order, after a process was removed
+func normalizeSortWeights(tx *sql.Tx, hostID string) error {
+	order, err := hostProcessOrder(tx, hostID)
+	if err != nil {
+		return err
+	}
+	return writeSortWeights(tx, order)
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// saveProcesses saves shell metadata for ids on a host, started in order
+func saveProcesses(t *testing.T, s *Store, hostID string, ids ...string) {
+	t.Helper()
+	for i, id := range ids {
+		if err := s.SaveProcessMetadata(ProcessMetadata{
+			ProcessID: id, HostID: hostID, ProcessType: "shell", TmuxName: "rc-" + id,
+			StartedAt: time.Unix(int64(1000+i), 0),
+		}); err != nil {
+			t.Fatalf("SaveProcessMetadata: %v", err)
+		}
+	}
+}
+
+// weights returns the sort weights of a host's processes
+func weights(t *testing.T, s *Store, hostID string) map[string]int {
+	t.Helper()
+	orders, err := s.GetProcessOrders(hostID)
+	if err != nil {
+		t.Fatalf("GetProcessOrders: %v", err)
+	}
+	w := make(map[string]int)
+	for id, o := range orders {
+		w[id] = o.SortWeight
+	}
+	return w
+}
+
+func assertWeights(t *testing.T, s *Store, hostID string, want map[string]int) {
+	t.Helper()
+	got := weights(t, s, hostID)
+	if len(got) != len(want) {
+		t.Fatalf("weights = %v, want %v", got, want)
+	}
+	for id, w := range want {
+		if got[id] != w {
+			t.Fatalf("weights = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestProcessOrder(t *testing.T) {
+	s := newTestStore(t)
+	saveProcesses(t, s, "host-1", "a", "b", "c", "d")
+	saveProcesses(t, s, "host-2", "x")
+
+	// New processes go last on their own host
+	assertWeights(t, s, "host-1", map[string]int{"a": 0, "b": 1, "c": 2, "d": 3})
+	assertWeights(t, s, "host-2", map[string]int{"x": 0})
+
+	// Listed processes first, the rest keep their order
+	if err := s.SetProcessOrder("host-1", []string{"c", "a", "c"}); err != nil {
+		t.Fatalf("SetProcessOrder: %v", err)
+	}
+	assertWeights(t, s, "host-1", map[string]int{"c": 0, "a": 1, "b": 2, "d": 3})
+
+	if err := s.SetProcessOrder("host-1", []string{"x"}); err == nil {
+		t.Error("ordering a process from another host should fail")
+	}
+	assertWeights(t, s, "host-1", map[string]int{"c": 0, "a": 1, "b": 2, "d": 3})
+
+	// Saving metadata again, as reattach does, keeps the order and pin
+	if err := s.SetProcessPinned("b", true); err != nil {
+		t.Fatalf("SetProcessPinned: %v", err)
+	}
+	saveProcesses(t, s, "host-1", "b")
+	meta, err := s.GetProcessMetadata("b")
+	if err != nil || meta == nil {
+		t.Fatalf("GetProcessMetadata: %v", err)
+	}
+	if !meta.Pinned || meta.SortWeight != 2 {
+		t.Errorf("resaved order = %+v, want pinned at 2", meta.ProcessOrder)
+	}
+
+	// Deleting closes the gap without reordering
+	if err := s.DeleteProcessMetadata("a"); err != nil {
+		t.Fatalf("DeleteProcessMetadata: %v", err)
+	}
+	assertWeights(t, s, "host-1", map[string]int{"c": 0, "b": 1, "d": 2})
+	saveProcesses(t, s, "host-1", "e")
+	assertWeights(t, s, "host-1", map[string]int{"c": 0, "b": 1, "d": 2, "e": 3})
+	if err := s.DeleteProcessMetadata("missing"); err != nil {
+		t.Errorf("DeleteProcessMetadata(missing): %v", err)
+	}
+}
+
+func TestProcessOrderMigration(t *testing.T) {
+	// A database from before ordering, with processes all at weight 0
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	old, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	if _, err := old.Exec(`CREATE TABLE process_metadata (process_id TEXT PRIMARY KEY, host_id TEXT NOT NULL,
+		process_type TEXT NOT NULL, port INTEGER, tmux_name TEXT NOT NULL, started_at INTEGER NOT NULL, last_seen_at INTEGER NOT NULL)`); err != nil {
+		t.Fatalf("create old schema: %v", err)
+	}
+	if _, err := old.Exec(`INSERT INTO process_metadata VALUES
+		('late', 'host-1', 'shell', NULL, 'rc-late', 300, 0),
+		('early', 'host-1', 'shell', NULL, 'rc-early', 100, 0),
+		('mid', 'host-1', 'shell', NULL, 'rc-mid', 200, 0)`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	old.Close()
+
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+
+	assertWeights(t, s, "host-1", map[string]int{"early": 0, "mid": 0, "late": 0})
+	if meta, err := s.GetProcessMetadata("mid"); err != nil || meta == nil || meta.Pinned {
+		t.Fatalf("GetProcessMetadata = %+v, %v", meta, err)
+	}
+
+	// The first write numbers them by creation time
+	if err := s.SetProcessOrder("host-1", []string{"late"}); err != nil {
+		t.Fatalf("SetProcessOrder: %v", err)
+	}
+	assertWeights(t, s, "host-1", map[string]int{"late": 0, "early": 1, "mid": 2})
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"bytes"
 	"compress/gzip"
+	"database/sql"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -10,7 +11,9 @@ import (
 	"time"
 )
 
-// AppendPtyOutput appends PTY output data to a process's history buffer
+// AppendPtyOutput appends PTY output data to a process's history buffer,
+// queueing an early flush of the process once enough output is unsaved
+// (see SetEarlyFlush)
 func (s *Store) AppendPtyOutput(processId, hostId string, data []byte) error {
 	if len(data) == 0 {
 		return nil
@@ -21,6 +24,12 @@ func (s *Store) AppendPtyOutput(processId, hostId string, data []byte) error {
 	buf.mu.Lock()
 	defer buf.mu.Unlock()
 
+	// A buffer created after a restart starts numbering at 0; numbers already
+	// stored would be persisted over
+	if err := s.loadStoredPtyChunks(processId, buf); err != nil {
+		return fmt.Errorf("failed to load pty history: %w", err)
+	}
+
 	chunk := PtyChunk{
 		Data:        make([]byte, len(data)),
 		SequenceNum: buf.nextSeqNum,
@@ -30,11 +39,150 @@ func (s *Store) AppendPtyOutput(processId, hostId string, data []byte) error {
 	buf.chunks = append(buf.chunks, chunk)
 	buf.nextSeqNum++
 	buf.totalBytes += int64(len(data))
-	buf.dirty = true
+	buf.markDirty(len(data), s.now())
+	if s.flushDue(buf.unflushed, buf.dirtySince) {
+		s.queueFlush(processId)
+	}
 
 	return nil
 }
 
+// EnsurePtyHistoryLoaded loads a process's persisted PTY history into memory
+// unless a buffer already exists. New output must be appended after the stored
+// history, otherwise it would be persisted over it with restarted sequence numbers.
+func (s *Store) EnsurePtyHistoryLoaded(processId, hostId string) error {
+	return s.loadPtyHistory(processId, hostId)
+}
+
+// Probe sizes used when matching the end of stored history against a pane capture.
+// Longer probes are tried first since short ones are more likely to match a
+// repeated line (e.g. a prompt) in the wrong place.
+const (
+	backfillProbeMax = 512
+	backfillProbeMin = 16
+)
+
+// BackfillPtyOutput appends the part of a tmux pane capture that is not yet in
+// the stored history, for output produced while nothing was reading the PTY.
+// The capture is plain text, so the overlap is found by matching the tail of the
+// stored history (with escape sequences stripped) inside the capture. This is
+// best-effort: if no overlap is found nothing is appended, since appending the
+// whole capture would duplicate history. Returns the number of bytes appended.
+func (s *Store) BackfillPtyOutput(processId, hostId string, captured []byte) (int, error) {
+	history, err := s.GetPtyHistory(processId)
+	if err != nil {
+		return 0, err
+	}
+
+	missing := missingCaptureSuffix(history, captured)
+	if len(missing) == 0 {
+		return 0, nil
+	}
+
+	if err := s.AppendPtyOutput(processId, hostId, missing); err != nil {
+		return 0, err
+	}
+	log.Printf("[DEBUG] [Storage] Backfilled %d bytes of PTY output for process %s", len(missing), processId)
+	return len(missing), nil
+}
+
+// missingCaptureSuffix returns the content of captured that follows the end of
+// history, converted to CRLF line endings for replay in a terminal
+func missingCaptureSuffix(history, captured []byte) []byte {
+	plainCapture := bytes.TrimRight(trimLineEnds(captured), "\n")
+	plainHistory := trimLineEnds(stripTerminalControls(history))
+	endsWithNewline := bytes.HasSuffix(plainHistory, []byte("\n"))
+	plainHistory = bytes.TrimRight(plainHistory, "\n")
+
+	var missing []byte
+	if len(plainHistory) == 0 {
+		missing = plainCapture
+	} else {
+		probeLen := len(plainHistory)
+		if probeLen > backfillProbeMax {
+			probeLen = backfillProbeMax
+		}
+		found := false
+		for {
+			probe := plainHistory[len(plainHistory)-probeLen:]
+			if idx := bytes.LastIndex(plainCapture, probe); idx >= 0 {
+				missing = plainCapture[idx+len(probe):]
+				found = true
+				break
+			}
+			if probeLen <= backfillProbeMin {
+				break
+			}
+			probeLen /= 2
+			if probeLen < backfillProbeMin {
+				probeLen = backfillProbeMin
+			}
+		}
+		if !found {
+			return nil
+		}
+		// The stored history already ended the line the probe matched
+		if endsWithNewline {
+			missing = bytes.TrimPrefix(missing, []byte("\n"))
+		}
+	}
+
+	if len(bytes.TrimSpace(missing)) == 0 {
+		return nil
+	}
+	return bytes.ReplaceAll(missing, []byte("\n"), []byte("\r\n"))
+}
+
+// trimLineEnds removes trailing spaces from every line, matching how tmux
+// capture-pane renders the pane
+func trimLineEnds(data []byte) []byte {
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		lines[i] = bytes.TrimRight(line, " \t")
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// stripTerminalControls removes escape sequences and control characters other
+// than newlines and tabs, leaving roughly the text a terminal would display
+func stripTerminalControls(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c != 0x1b {
+			if c >= 0x20 || c == '\n' || c == '\t' {
+				out = append(out, c)
+			}
+			continue
+		}
+		if i+1 >= len(data) {
+			break
+		}
+		switch data[i+1] {
+		case '[': // CSI: parameters then a final byte in 0x40-0x7e
+			i += 2
+			for i < len(data) && (data[i] < 0x40 || data[i] > 0x7e) {
+				i++
+			}
+		case ']', 'P', '_', '^': // OSC/DCS/APC/PM: terminated by BEL or ST (ESC \)
+			i += 2
+			for i < len(data) {
+				if data[i] == 0x07 {
+					break
+				}
+				if data[i] == 0x1b && i+1 < len(data) && data[i+1] == '\\' {
+					i++
+					break
+				}
+				i++
+			}
+		default: // Two-byte sequence
+			i++
+		}
+	}
+	return out
+}
+
 // GetPtyHistory returns all PTY output for a process as a single byte slice
 func (s *Store) GetPtyHistory(processId string) ([]byte, error) {
 	s.mu.RLock()
@@ -64,14 +212,19 @@ func (s *Store) GetPtyHistory(processId string) ([]byte, error) {
 	return result, nil
 }
 
-// GetPtyHistorySize returns the total size of PTY history for a process
+// GetPtyHistorySize returns the total size of PTY history for a process,
+// from memory if its buffer is loaded and from the database otherwise
 func (s *Store) GetPtyHistorySize(processId string) int64 {
 	s.mu.RLock()
 	buf, ok := s.ptyBuffers[processId]
 	s.mu.RUnlock()
 
 	if !ok {
-		return 0
+		size, err := ptyHistoryDBSize(s.db, processId)
+		if err != nil {
+			log.Printf("[WARN] [Storage] Failed to get PTY history size for process %s: %v", processId, err)
+		}
+		return size
 	}
 
 	buf.mu.RLock()
@@ -80,33 +233,101 @@ func (s *Store) GetPtyHistorySize(processId string) int64 {
 	return buf.totalBytes
 }
 
-// GetPtyHistoryChunked returns PTY history in chunks via a channel
-func (s *Store) GetPtyHistoryChunked(processId string, chunkSize int) (<-chan []byte, int, error) {
-	history, err := s.GetPtyHistory(processId)
+// ptyHistoryDBSize returns the size of the PTY history stored for a process.
+// Rows written before the size column existed are plaintext, so their data
+// length is their size.
+func ptyHistoryDBSize(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, processId string) (int64, error) {
+	var size int64
+	err := q.QueryRow(`SELECT COALESCE(SUM(COALESCE(size, LENGTH(data))), 0) FROM pty_history WHERE process_id = ?`, processId).Scan(&size)
 	if err != nil {
-		return nil, 0, err
+		return 0, fmt.Errorf("failed to get pty history size: %w", err)
+	}
+	return size, nil
+}
+
+// historyPageRows is the number of stored rows GetPtyHistoryChunked reads per
+// query. Each page is copied out before it is sent, so no read transaction is
+// held while a slow client takes the chunks.
+const historyPageRows = 64
+
+// GetPtyHistoryChunked streams PTY history in chunkSize pieces via a channel,
+// without loading the whole history into one slice. It reads a snapshot of
+// the in-memory chunks if the buffer is loaded, or the stored rows a page at
+// a time otherwise. The total chunk count comes from the history size, and
+// that many chunks are sent (one empty chunk for no history) unless reading
+// fails or rows are trimmed mid-stream; then the stream ends short of it.
+func (s *Store) GetPtyHistoryChunked(processId string, chunkSize int) (<-chan []byte, int, error) {
+	if chunkSize <= 0 {
+		return nil, 0, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+
+	s.mu.RLock()
+	buf, ok := s.ptyBuffers[processId]
+	s.mu.RUnlock()
+
+	var size int64
+	var next func() ([]byte, error)
+	if ok {
+		// Appends never modify existing chunks, so a slice of the current
+		// ones stays valid without copying their data
+		buf.mu.RLock()
+		chunks := buf.chunks[:len(buf.chunks):len(buf.chunks)]
+		size = buf.totalBytes
+		buf.mu.RUnlock()
+
+		next = func() ([]byte, error) {
+			if len(chunks) == 0 {
+				return nil, io.EOF
+			}
+			data := chunks[0].Data
+			chunks = chunks[1:]
+			return data, nil
+		}
+	} else {
+		// Rows flushed after the size was taken are cut off by rechunk's
+		// limit; rows trimmed meanwhile shorten the stream
+		var err error
+		if size, err = ptyHistoryDBSize(s.db, processId); err != nil {
+			return nil, 0, err
+		}
+
+		var page []storedPtyRow
+		after, exhausted := int64(-1), false
+		next = func() ([]byte, error) {
+			if len(page) == 0 && !exhausted {
+				if page, err = s.readPtyHistoryPage(processId, after); err != nil {
+					return nil, err
+				}
+				exhausted = len(page) < historyPageRows
+			}
+			if len(page) == 0 {
+				return nil, io.EOF
+			}
+			row := page[0]
+			page = page[1:]
+			after = row.sequenceNum
+			return s.openHistory(row.data)
+		}
 	}
 
-	totalChunks := (len(history) + chunkSize - 1) / chunkSize
+	totalChunks := int((size + int64(chunkSize) - 1) / int64(chunkSize))
 	if totalChunks == 0 {
 		totalChunks = 1
 	}
 
-	ch := make(chan []byte, 10) // Buffered channel
+	ch := make(chan []byte, 1)
 
 	go func() {
 		defer close(ch)
 
-		for i := 0; i < len(history); i += chunkSize {
-			end := i + chunkSize
-			if end > len(history) {
-				end = len(history)
-			}
-			ch <- history[i:end]
+		if err := rechunk(ch, chunkSize, size, next); err != nil {
+			log.Printf("[ERROR] [Storage] Failed to stream PTY history for process %s: %v", processId, err)
 		}
 
 		// Send empty chunk if no data
-		if len(history) == 0 {
+		if size == 0 {
 			ch <- []byte{}
 		}
 	}()
@@ -114,6 +335,73 @@ func (s *Store) GetPtyHistoryChunked(processId string, chunkSize int) (<-chan []
 	return ch, totalChunks, nil
 }
 
+// storedPtyRow is a pty_history row as stored, data still sealed
+type storedPtyRow struct {
+	sequenceNum int64
+	data        []byte
+}
+
+// readPtyHistoryPage returns up to historyPageRows stored rows of a process
+// after sequence number after, in order
+func (s *Store) readPtyHistoryPage(processId string, after int64) ([]storedPtyRow, error) {
+	rows, err := s.db.Query(`
+		SELECT sequence_num, data FROM pty_history
+		WHERE process_id = ? AND sequence_num > ?
+		ORDER BY sequence_num ASC
+		LIMIT ?
+	`, processId, after, historyPageRows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pty history: %w", err)
+	}
+	defer rows.Close()
+
+	var page []storedPtyRow
+	for rows.Next() {
+		var row storedPtyRow
+		if err := rows.Scan(&row.sequenceNum, &row.data); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		page = append(page, row)
+	}
+	return page, rows.Err()
+}
+
+// rechunk reads source chunks from next until io.EOF and sends their data on
+// ch in chunkSize pieces, stitching pieces across source chunk boundaries.
+// Only the piece being filled and the current source chunk are held. It stops
+// after limit bytes, so the number of pieces sent matches a size taken before
+// streaming; only the last piece may be shorter than chunkSize.
+func rechunk(ch chan<- []byte, chunkSize int, limit int64, next func() ([]byte, error)) error {
+	var piece []byte
+	for limit > 0 {
+		data, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		for len(data) > 0 && limit > 0 {
+			if piece == nil {
+				piece = make([]byte, 0, min(int64(chunkSize), limit))
+			}
+			n := copy(piece[len(piece):cap(piece)], data)
+			piece = piece[:len(piece)+n]
+			data = data[n:]
+			limit -= int64(n)
+			if len(piece) == cap(piece) {
+				ch <- piece
+				piece = nil
+			}
+		}
+	}
+	if len(piece) > 0 {
+		ch <- piece
+	}
+	return nil
+}
+
 // ClearPtyHistory removes all PTY history for a process
 func (s *Store) ClearPtyHistory(processId string) error {
 	// Clear from memory
@@ -122,7 +410,7 @@ func (s *Store) ClearPtyHistory(processId string) error {
 	s.mu.Unlock()
 
 	// Clear from database
-	_, err := s.db.Exec("DELETE FROM pty_history WHERE process_id = ?", processId)
+	_, err := s.exec("DELETE FROM pty_history WHERE process_id = ?", processId)
 	if err != nil {
 		return fmt.Errorf("failed to clear pty history from db: %w", err)
 	}
@@ -149,42 +437,91 @@ func (s *Store) persistPtyBuffer(processId string) error {
 		return nil
 	}
 
-	// Use a transaction for batch insert
-	tx, err := s.db.Begin()
-	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+	if err := s.writePtyChunks(processId, hostId, buf.chunks); err != nil {
+		return err
 	}
-	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(`
-		INSERT OR REPLACE INTO pty_history (process_id, host_id, data, sequence_num, created_at)
-		VALUES (?, ?, ?, ?, ?)
-	`)
-	if err != nil {
-		return fmt.Errorf("failed to prepare statement: %w", err)
+	buf.dirty = false
+	buf.lastPersist = time.Now()
+	buf.persisted = len(buf.chunks)
+	buf.unflushed = 0
+	buf.dirtySince = time.Time{}
+
+	return nil
+}
+
+// flushPtyBuffer saves the chunks of a PTY buffer not written to SQLite yet.
+// The buffer stays dirty, so the periodic persist still rewrites it whole.
+func (s *Store) flushPtyBuffer(processId string) error {
+	s.mu.RLock()
+	buf, ok := s.ptyBuffers[processId]
+	hostId := s.hostMap[processId]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil
 	}
-	defer stmt.Close()
 
-	now := time.Now().Unix()
-	for _, chunk := range buf.chunks {
-		_, err := stmt.Exec(processId, hostId, chunk.Data, chunk.SequenceNum, now)
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	if buf.persisted >= len(buf.chunks) {
+		return nil
+	}
+	if err := s.writePtyChunks(processId, hostId, buf.chunks[buf.persisted:]); err != nil {
+		return err
+	}
+
+	buf.persisted = len(buf.chunks)
+	buf.unflushed = 0
+	buf.dirtySince = time.Time{}
+
+	return nil
+}
+
+// writePtyChunks stores PTY chunks of a process, replacing any stored under
+// the same sequence numbers
+func (s *Store) writePtyChunks(processId, hostId string, chunks []PtyChunk) error {
+	sealed := make([][]byte, len(chunks))
+	for i, chunk := range chunks {
+		data, err := s.sealHistory(chunk.Data)
 		if err != nil {
-			return fmt.Errorf("failed to insert chunk: %w", err)
+			return err
 		}
+		sealed[i] = data
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+	now := time.Now().Unix()
+	err := s.execBatches(`
+		INSERT OR REPLACE INTO pty_history (process_id, host_id, data, size, sequence_num, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, len(chunks), func(i int) []interface{} {
+		chunk := chunks[i]
+		return []interface{}{processId, hostId, sealed[i], len(chunk.Data), chunk.SequenceNum, now}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to persist pty chunks: %w", err)
 	}
-
-	buf.dirty = false
-	buf.lastPersist = time.Now()
-
 	return nil
 }
 
-// loadPtyHistory loads PTY history from SQLite into memory
+// loadPtyHistory loads PTY history from SQLite into memory, unless it
+// already was
 func (s *Store) loadPtyHistory(processId, hostId string) error {
+	buf := s.getOrCreatePtyBuffer(processId, hostId)
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	return s.loadStoredPtyChunks(processId, buf)
+}
+
+// loadStoredPtyChunks fills a buffer nothing was appended to yet with the
+// chunks stored for its process, so new chunks are numbered after them. It
+// does nothing once the buffer is loaded. The caller must hold buf.mu.
+func (s *Store) loadStoredPtyChunks(processId string, buf *PtyBuffer) error {
+	if buf.loaded {
+		return nil
+	}
+
 	rows, err := s.db.Query(`
 		SELECT data, sequence_num FROM pty_history
 		WHERE process_id = ?
@@ -195,33 +532,41 @@ func (s *Store) loadPtyHistory(processId, hostId string) error {
 	}
 	defer rows.Close()
 
-	buf := s.getOrCreatePtyBuffer(processId, hostId)
-	buf.mu.Lock()
-	defer buf.mu.Unlock()
-
+	var stored []PtyChunk
+	var storedBytes int64
 	var maxSeq int64 = -1
 	for rows.Next() {
-		var data []byte
+		var sealed []byte
 		var seqNum int64
-		if err := rows.Scan(&data, &seqNum); err != nil {
+		if err := rows.Scan(&sealed, &seqNum); err != nil {
 			return fmt.Errorf("failed to scan row: %w", err)
 		}
+		data, err := s.openHistory(sealed)
+		if err != nil {
+			return fmt.Errorf("pty chunk %d: %w", seqNum, err)
+		}
 
-		buf.chunks = append(buf.chunks, PtyChunk{
+		stored = append(stored, PtyChunk{
 			Data:        data,
 			SequenceNum: seqNum,
 		})
-		buf.totalBytes += int64(len(data))
+		storedBytes += int64(len(data))
 
 		if seqNum > maxSeq {
 			maxSeq = seqNum
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
+	buf.chunks = stored
 	buf.nextSeqNum = maxSeq + 1
-	buf.dirty = false
+	buf.totalBytes = storedBytes
+	buf.persisted = len(stored)
+	buf.loaded = true
 
-	return rows.Err()
+	return nil
 }
 
 // getPtyHistoryFromDB retrieves PTY history directly from database
@@ -238,10 +583,14 @@ func (s *Store) getPtyHistoryFromDB(processId string) ([]byte, error) {
 
 	var result []byte
 	for rows.Next() {
-		var data []byte
-		if err := rows.Scan(&data); err != nil {
+		var stored []byte
+		if err := rows.Scan(&stored); err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
+		data, err := s.openHistory(stored)
+		if err != nil {
+			return nil, err
+		}
 		result = append(result, data...)
 	}
 
@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMissingCaptureSuffix(t *testing.T) {
+	tests := []struct {
+		name     string
+		history  string
+		captured string
+		want     string
+	}{
+		{
+			name:     "empty history takes whole capture",
+			history:  "",
+			captured: "$ echo hi\nhi\n$\n\n",
+			want:     "$ echo hi\r\nhi\r\n$",
+		},
+		{
+			name:     "appends lines past stored output",
+			history:  "\x1b[32m$ \x1b[0mmake build\r\ncompiling module one\r\n",
+			captured: "$ make build\ncompiling module one\ncompiling module two\ndone\n",
+			want:     "compiling module two\r\ndone",
+		},
+		{
+			name:     "continues a partial prompt line",
+			history:  "last login from workstation\r\nuser@host:~$ ",
+			captured: "last login from workstation\nuser@host:~$ ls\nnotes.txt\n",
+			want:     " ls\r\nnotes.txt",
+		},
+		{
+			name:     "nothing new",
+			history:  "line one of the output\r\nline two of the output\r\n",
+			captured: "line one of the output\nline two of the output\n\n\n",
+			want:     "",
+		},
+		{
+			name:     "no overlap appends nothing",
+			history:  "output that scrolled out of the pane\r\n",
+			captured: "completely different content here\n",
+			want:     "",
+		},
+		{
+			name:     "ignores OSC titles and trailing spaces",
+			history:  "\x1b]0;title\x07building target alpha   \r\n",
+			captured: "building target alpha\nbuilding target beta\n",
+			want:     "building target beta",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(missingCaptureSuffix([]byte(tt.history), []byte(tt.captured)))
+			if got != tt.want {
+				t.Errorf("missingCaptureSuffix() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Simulates output arriving while attached, more output produced while the
+// PTY is detached (only visible in the pane), then reattach with backfill
+// followed by new live output, across a bridge restart.
+func TestPtyHistoryDetachReattachHasNoGap(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	s.AppendPtyOutput("proc-1", "host-1", []byte("$ ./run-tests.sh\r\nrunning suite one\r\n"))
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Bridge restarts while the program keeps writing into the tmux pane
+	s, err = NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+
+	if err := s.EnsurePtyHistoryLoaded("proc-1", "host-1"); err != nil {
+		t.Fatalf("EnsurePtyHistoryLoaded: %v", err)
+	}
+	pane := "$ ./run-tests.sh\nrunning suite one\nrunning suite two\nall suites passed\n$\n"
+	n, err := s.BackfillPtyOutput("proc-1", "host-1", []byte(pane))
+	if err != nil || n == 0 {
+		t.Fatalf("BackfillPtyOutput: n=%d err=%v", n, err)
+	}
+	s.AppendPtyOutput("proc-1", "host-1", []byte(" exit\r\n"))
+
+	history, err := s.GetPtyHistory("proc-1")
+	if err != nil {
+		t.Fatalf("GetPtyHistory: %v", err)
+	}
+	want := "$ ./run-tests.sh\r\nrunning suite one\r\n" +
+		"running suite two\r\nall suites passed\r\n$" +
+		" exit\r\n"
+	if string(history) != want {
+		t.Errorf("history = %q, want %q", history, want)
+	}
+
+	// Loading again must not duplicate the in-memory history
+	if err := s.EnsurePtyHistoryLoaded("proc-1", "host-1"); err != nil {
+		t.Fatalf("EnsurePtyHistoryLoaded: %v", err)
+	}
+	if again, _ := s.GetPtyHistory("proc-1"); string(again) != want {
+		t.Errorf("history changed after second load: %q", again)
+	}
+}
+
+// Output appended after a restart, without loading the stored history
+// first, must not be numbered over it
+func TestPtyHistorySurvivesRestartWithoutLoad(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	s.AppendPtyOutput("proc-1", "host-1", []byte("first\r\n"))
+	s.AppendPtyOutput("proc-1", "host-1", []byte("second\r\n"))
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	for i, restart := range []struct {
+		register bool // Registered like a new process before the append
+		output   string
+	}{
+		{false, "third\r\n"},
+		{true, "fourth\r\n"},
+	} {
+		s, err = NewStore(dbPath)
+		if err != nil {
+			t.Fatalf("NewStore: %v", err)
+		}
+		if restart.register {
+			s.RegisterProcess("proc-1", "host-1")
+		}
+		if err := s.AppendPtyOutput("proc-1", "host-1", []byte(restart.output)); err != nil {
+			t.Fatalf("AppendPtyOutput: %v", err)
+		}
+		want := "first\r\nsecond\r\nthird\r\n"
+		if i == 1 {
+			want += "fourth\r\n"
+		}
+		if history, err := s.GetPtyHistory("proc-1"); err != nil || string(history) != want {
+			t.Errorf("restart %d: history in memory = %q, %v; want %q", i+1, history, err, want)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		s, err = NewStore(dbPath)
+		if err != nil {
+			t.Fatalf("NewStore: %v", err)
+		}
+		if history, err := s.GetPtyHistory("proc-1"); err != nil || string(history) != want {
+			t.Errorf("restart %d: stored history = %q, %v; want %q", i+1, history, err, want)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+}
+
+// sliceChunks is the chunking GetPtyHistoryChunked did before it streamed:
+// the whole history sliced into chunkSize pieces
+func sliceChunks(history []byte, chunkSize int) [][]byte {
+	if len(history) == 0 {
+		return [][]byte{{}}
+	}
+	var chunks [][]byte
+	for i := 0; i < len(history); i += chunkSize {
+		chunks = append(chunks, history[i:min(i+chunkSize, len(history))])
+	}
+	return chunks
+}
+
+// collectChunks drains a GetPtyHistoryChunked channel
+func collectChunks(t *testing.T, s *Store, processId string, chunkSize int) ([][]byte, int) {
+	t.Helper()
+	ch, total, err := s.GetPtyHistoryChunked(processId, chunkSize)
+	if err != nil {
+		t.Fatalf("GetPtyHistoryChunked: %v", err)
+	}
+	var chunks [][]byte
+	for chunk := range ch {
+		chunks = append(chunks, chunk)
+	}
+	return chunks, total
+}
+
+func checkChunks(t *testing.T, got [][]byte, total int, want [][]byte) {
+	t.Helper()
+	if total != len(want) || len(got) != len(want) {
+		t.Fatalf("got %d chunks (announced %d), want %d", len(got), total, len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("chunk %d differs: %d bytes, want %d", i, len(got[i]), len(want[i]))
+		}
+	}
+}
+
+func TestRechunkStitchesAcrossBoundaries(t *testing.T) {
+	sources := []string{"abc", "", "defghij", "k", "lmnopqrstu", "vw"}
+	stream := func(limit int64) []string {
+		rest := sources
+		next := func() ([]byte, error) {
+			if len(rest) == 0 {
+				return nil, io.EOF
+			}
+			data := []byte(rest[0])
+			rest = rest[1:]
+			return data, nil
+		}
+		ch := make(chan []byte, 100)
+		if err := rechunk(ch, 4, limit, next); err != nil {
+			t.Fatalf("rechunk: %v", err)
+		}
+		close(ch)
+		var pieces []string
+		for piece := range ch {
+			pieces = append(pieces, string(piece))
+		}
+		return pieces
+	}
+
+	want := []string{"abcd", "efgh", "ijkl", "mnop", "qrst", "uvw"}
+	if got := stream(23); !reflect.DeepEqual(got, want) {
+		t.Errorf("pieces = %q, want %q", got, want)
+	}
+	// Output appended after the size was taken isn't sent
+	if got := stream(10); !reflect.DeepEqual(got, []string{"abcd", "efgh", "ij"}) {
+		t.Errorf("limited pieces = %q", got)
+	}
+	// A source that ends early ends the stream
+	if got := stream(100); !reflect.DeepEqual(got, want) {
+		t.Errorf("pieces past the end = %q, want %q", got, want)
+	}
+}
+
+func TestPtyHistoryChunkedMatchesSlicedHistory(t *testing.T) {
+	const chunkSize = 64 * 1024
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	// About 6MB of output in writes of varying size, so most output chunks
+	// straddle several source chunks and some source chunks span outputs
+	rng := rand.New(rand.NewSource(1))
+	for written := 0; written < 6<<20; {
+		size := 1 + rng.Intn(20000)
+		if rng.Intn(50) == 0 {
+			size = 3*chunkSize + rng.Intn(chunkSize)
+		}
+		data := make([]byte, size)
+		rng.Read(data)
+		s.AppendPtyOutput("proc-1", "host-1", data)
+		written += size
+	}
+	history, err := s.GetPtyHistory("proc-1")
+	if err != nil {
+		t.Fatalf("GetPtyHistory: %v", err)
+	}
+	want := sliceChunks(history, chunkSize)
+
+	// From the in-memory buffer
+	got, total := collectChunks(t, s, "proc-1", chunkSize)
+	checkChunks(t, got, total, want)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// And from the database, after a restart
+	s, err = NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+	if size := s.GetPtyHistorySize("proc-1"); size != int64(len(history)) {
+		t.Errorf("stored size = %d, want %d", size, len(history))
+	}
+	got, total = collectChunks(t, s, "proc-1", chunkSize)
+	checkChunks(t, got, total, want)
+}
+
+func TestPtyHistoryChunkedSnapshot(t *testing.T) {
+	s := newTestStore(t)
+
+	// No history is sent as one empty chunk
+	got, total := collectChunks(t, s, "proc-1", 4)
+	checkChunks(t, got, total, [][]byte{{}})
+
+	s.AppendPtyOutput("proc-1", "host-1", []byte("hello "))
+	ch, total, err := s.GetPtyHistoryChunked("proc-1", 4)
+	if err != nil {
+		t.Fatalf("GetPtyHistoryChunked: %v", err)
+	}
+	// Output arriving mid-stream isn't part of this request
+	s.AppendPtyOutput("proc-1", "host-1", []byte("world"))
+	got = nil
+	for chunk := range ch {
+		got = append(got, chunk)
+	}
+	checkChunks(t, got, total, [][]byte{[]byte("hell"), []byte("o ")})
+}
+
+func TestPtyHistoryChunkedPagesStoredRows(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	const rows = 4 * historyPageRows
+	for i := 0; i < rows; i++ {
+		s.AppendPtyOutput("proc-1", "host-1", []byte(fmt.Sprintf("line %04d\n", i)))
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	s, err = NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+
+	ch, total, err := s.GetPtyHistoryChunked("proc-1", 10)
+	if err != nil {
+		t.Fatalf("GetPtyHistoryChunked: %v", err)
+	}
+	if total != rows {
+		t.Fatalf("total chunks = %d, want %d", total, rows)
+	}
+	if first := <-ch; string(first) != "line 0000\n" {
+		t.Fatalf("first chunk = %q", first)
+	}
+
+	// No snapshot is held while the client takes its time: history cleared
+	// mid-stream ends the stream short of the announced count
+	if err := s.ClearPtyHistory("proc-1"); err != nil {
+		t.Fatalf("ClearPtyHistory: %v", err)
+	}
+	got := 1
+	for range ch {
+		got++
+	}
+	if got >= total {
+		t.Errorf("got all %d chunks after the history was cleared", got)
+	}
+}
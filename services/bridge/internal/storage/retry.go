@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// busyTimeoutMs is how long a connection waits for another to release the
+// write lock before SQLite reports SQLITE_BUSY. Set on every connection of the
+// pool through the DSN.
+const busyTimeoutMs = 5000
+
+// Retries of operations that still hit SQLITE_BUSY, e.g. a transaction that
+// read before writing and can't upgrade its lock without a retry
+const (
+	busyAttempts = 3
+	busyBackoff  = 50 * time.Millisecond // Doubled after every attempt
+)
+
+// persistBatchSize is the number of rows written per transaction when
+// persisting a buffer. Committing in batches lets other writers in between
+// instead of making them wait out the whole buffer set.
+const persistBatchSize = 256
+
+// dsn returns the data source name that opens dbPath with busy_timeout set
+func dsn(dbPath string) string {
+	return fmt.Sprintf("%s?_pragma=busy_timeout(%d)", dbPath, busyTimeoutMs)
+}
+
+// isBusy reports whether err is SQLite refusing an operation because another
+// connection holds a lock
+func isBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	// Extended result codes keep the primary code in the low byte
+	switch sqliteErr.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return true
+	}
+	return false
+}
+
+// retryBusy runs op, retrying with exponential backoff while the database is
+// busy. Other errors are returned straight away.
+func retryBusy(op func() error) error {
+	backoff := busyBackoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !isBusy(err) || attempt == busyAttempts {
+			return err
+		}
+		log.Printf("[DEBUG] [Storage] Database busy (attempt %d/%d), retrying in %v: %v", attempt, busyAttempts, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// exec runs a write statement, retrying while the database is busy
+func (s *Store) exec(query string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := retryBusy(func() error {
+		var err error
+		result, err = s.db.Exec(query, args...)
+		return err
+	})
+	return result, err
+}
+
+// execBatches runs query once per row, for rows 0 to n-1, committing every
+// persistBatchSize rows. A batch that finds the database busy is retried on
+// its own; the batches before it stay committed.
+func (s *Store) execBatches(query string, n int, row func(i int) []interface{}) error {
+	for start := 0; start < n; start += persistBatchSize {
+		end := min(start+persistBatchSize, n)
+		if err := retryBusy(func() error { return s.execBatch(query, start, end, row) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execBatch runs query for rows start to end-1 in one transaction
+func (s *Store) execBatch(query string, start, end int, row func(i int) []interface{}) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(query)
+		if err != nil {
+			return fmt.Errorf("failed to prepare statement: %w", err)
+		}
+		defer stmt.Close()
+
+		for i := start; i < end; i++ {
+			if _, err := stmt.Exec(row(i)...); err != nil {
+				return fmt.Errorf("failed to write row: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// inTx runs fn in a transaction, committing if it returns nil. It is not
+// retried; callers wrap it in retryBusy.
+func (s *Store) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentWritesDuringPersist(t *testing.T) {
+	s := newTestStore(t)
+
+	// Large buffers take many batches to persist
+	line := []byte(strings.Repeat("x", 128) + "\n")
+	messages := make([]ChatMessage, 2*persistBatchSize)
+	for i := range messages {
+		messages[i] = ChatMessage{MessageID: i, Role: "user", Message: fmt.Sprintf("message %d", i)}
+	}
+	for p := 0; p < 2; p++ {
+		pid := fmt.Sprintf("proc-%d", p)
+		for i := 0; i < 4*persistBatchSize; i++ {
+			if err := s.AppendPtyOutput(pid, "host-1", line); err != nil {
+				t.Fatalf("AppendPtyOutput: %v", err)
+			}
+		}
+		if err := s.SetChatMessages(pid, "host-1", messages); err != nil {
+			t.Fatalf("SetChatMessages: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := s.PersistAll(); err != nil {
+			errs <- fmt.Errorf("PersistAll: %w", err)
+		}
+	}()
+	for w := 0; w < 3; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pid := fmt.Sprintf("live-%d", w)
+			for i := 0; i < 20; i++ {
+				if err := s.CreateSnippet(Snippet{ID: fmt.Sprintf("snippet-%d-%d", w, i), Name: "n", Content: "c"}); err != nil {
+					errs <- fmt.Errorf("CreateSnippet: %w", err)
+				}
+				if _, err := s.UpsertChatMessage(pid, "host-1", ChatMessage{MessageID: i, Role: "user", Message: "hi"}); err != nil {
+					errs <- fmt.Errorf("UpsertChatMessage: %w", err)
+				}
+				if err := s.persistChatBuffer(pid); err != nil {
+					errs <- fmt.Errorf("persistChatBuffer: %w", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if isBusy(err) {
+			t.Errorf("busy error: %v", err)
+		} else {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	snippets, err := s.ListSnippets()
+	if err != nil {
+		t.Fatalf("ListSnippets: %v", err)
+	}
+	if len(snippets) != 60 {
+		t.Errorf("got %d snippets, want 60", len(snippets))
+	}
+	history, err := s.getChatHistoryFromDB("proc-1")
+	if err != nil {
+		t.Fatalf("getChatHistoryFromDB: %v", err)
+	}
+	if len(history) != len(messages) {
+		t.Errorf("persisted %d messages, want %d", len(history), len(messages))
+	}
+}
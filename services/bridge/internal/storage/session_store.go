@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// SessionToken is the persisted reconnect token of a client session. Only a
+// hash of the token is kept, so the database never holds a usable token.
+type SessionToken struct {
+	SessionID string
+	TokenHash string
+	State     json.RawMessage // Session state restored on reconnect, owned by the session package
+	CreatedAt time.Time       // When the session was created
+	ExpiresAt time.Time
+}
+
+// SaveSessionToken saves the token of a session, replacing its previous one
+func (s *Store) SaveSessionToken(token SessionToken) error {
+	var state *string
+	if len(token.State) > 0 {
+		str := string(token.State)
+		state = &str
+	}
+	_, err := s.exec(`
+		INSERT INTO session_tokens (session_id, token_hash, state, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(session_id) DO UPDATE SET
+			token_hash = excluded.token_hash, state = excluded.state, expires_at = excluded.expires_at`,
+		token.SessionID, token.TokenHash, state, token.CreatedAt.UnixMilli(), token.ExpiresAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to save session token: %w", err)
+	}
+	return nil
+}
+
+// TakeSessionToken removes the session token with the given hash and
+// returns it, or nil if there is none or it has expired. Removing it means
+// concurrent callers presenting the same token can't both get the session.
+func (s *Store) TakeSessionToken(tokenHash string) (*SessionToken, error) {
+	var token SessionToken
+	var state sql.NullString
+	var createdAt, expiresAt int64
+	err := retryBusy(func() error {
+		return s.db.QueryRow(`
+			DELETE FROM session_tokens WHERE token_hash = ?
+			RETURNING session_id, token_hash, state, created_at, expires_at`, tokenHash).
+			Scan(&token.SessionID, &token.TokenHash, &state, &createdAt, &expiresAt)
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to take session token: %w", err)
+	}
+
+	token.CreatedAt = time.UnixMilli(createdAt)
+	token.ExpiresAt = time.UnixMilli(expiresAt)
+	if !time.Now().Before(token.ExpiresAt) {
+		log.Printf("[DEBUG] [Storage] Session token for %s expired at %s", token.SessionID, token.ExpiresAt.Format(time.RFC3339))
+		return nil, nil
+	}
+	if state.Valid {
+		token.State = json.RawMessage(state.String)
+	}
+	return &token, nil
+}
+
+// DeleteSessionToken removes the token of a session
+func (s *Store) DeleteSessionToken(sessionID string) error {
+	if _, err := s.exec(`DELETE FROM session_tokens WHERE session_id = ?`, sessionID); err != nil {
+		return fmt.Errorf("failed to delete session token: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredSessionTokens removes expired session tokens and returns how
+// many there were
+func (s *Store) DeleteExpiredSessionTokens() (int64, error) {
+	result, err := s.exec(`DELETE FROM session_tokens WHERE expires_at <= ?`, time.Now().UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired session tokens: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n > 0 {
+		log.Printf("[DEBUG] [Storage] Deleted %d expired session tokens", n)
+	}
+	return n, nil
+}
+
+// SessionInvite lets a client authenticate without the bridge's auth token,
+// with the role and scope the invite grants. Like session tokens, only a
+// hash of the invite token is kept.
+type SessionInvite struct {
+	ID        string
+	TokenHash string
+	Role      string
+	HostID    string // Empty for every host
+	ProcessID string // Empty for every process
+	Label     string
+	CreatedAt time.Time
+	ExpiresAt *time.Time // Nil for an invite that lasts until revoked
+}
+
+// SaveSessionInvite saves a new invite
+func (s *Store) SaveSessionInvite(invite SessionInvite) error {
+	var expiresAt *int64
+	if invite.ExpiresAt != nil {
+		ms := invite.ExpiresAt.UnixMilli()
+		expiresAt = &ms
+	}
+	_, err := s.exec(`
+		INSERT INTO session_invites (id, token_hash, role, host_id, process_id, label, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		invite.ID, invite.TokenHash, invite.Role, nullString(invite.HostID), nullString(invite.ProcessID),
+		nullString(invite.Label), invite.CreatedAt.UnixMilli(), expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to save session invite: %w", err)
+	}
+	return nil
+}
+
+// GetSessionInvite returns the invite with the given token hash, or nil if
+// there is none or it has expired. Unlike a session token, an invite can be
+// used any number of times until it expires or is revoked.
+func (s *Store) GetSessionInvite(tokenHash string) (*SessionInvite, error) {
+	var invite SessionInvite
+	var hostID, processID, label sql.NullString
+	var createdAt int64
+	var expiresAt sql.NullInt64
+	err := s.db.QueryRow(`
+		SELECT id, token_hash, role, host_id, process_id, label, created_at, expires_at
+		FROM session_invites WHERE token_hash = ?`, tokenHash).
+		Scan(&invite.ID, &invite.TokenHash, &invite.Role, &hostID, &processID, &label, &createdAt, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session invite: %w", err)
+	}
+
+	invite.HostID, invite.ProcessID, invite.Label = hostID.String, processID.String, label.String
+	invite.CreatedAt = time.UnixMilli(createdAt)
+	if expiresAt.Valid {
+		expires := time.UnixMilli(expiresAt.Int64)
+		if !time.Now().Before(expires) {
+			log.Printf("[DEBUG] [Storage] Session invite %s expired at %s", invite.ID, expires.Format(time.RFC3339))
+			return nil, nil
+		}
+		invite.ExpiresAt = &expires
+	}
+	return &invite, nil
+}
+
+// DeleteSessionInvite revokes an invite, reporting whether it existed
+func (s *Store) DeleteSessionInvite(id string) (bool, error) {
+	result, err := s.exec(`DELETE FROM session_invites WHERE id = ?`, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete session invite: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// DeleteExpiredSessionInvites removes expired invites and returns how many
+// there were
+func (s *Store) DeleteExpiredSessionInvites() (int64, error) {
+	result, err := s.exec(`DELETE FROM session_invites WHERE expires_at <= ?`, time.Now().UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired session invites: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n > 0 {
+		log.Printf("[DEBUG] [Storage] Deleted %d expired session invites", n)
+	}
+	return n, nil
+}
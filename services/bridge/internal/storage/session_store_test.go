@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionTokens(t *testing.T) {
+	s := newTestStore(t)
+	created := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	token := SessionToken{
+		SessionID: "session-1",
+		TokenHash: "hash-1",
+		State:     json.RawMessage(`{"processSubs":["host-1"]}`),
+		CreatedAt: created,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := s.SaveSessionToken(token); err != nil {
+		t.Fatalf("SaveSessionToken: %v", err)
+	}
+
+	// Saving again rotates the hash
+	token.TokenHash = "hash-2"
+	if err := s.SaveSessionToken(token); err != nil {
+		t.Fatalf("SaveSessionToken: %v", err)
+	}
+	if got, err := s.TakeSessionToken("hash-1"); err != nil || got != nil {
+		t.Errorf("rotated token = %+v, %v; want none", got, err)
+	}
+
+	got, err := s.TakeSessionToken("hash-2")
+	if err != nil || got == nil {
+		t.Fatalf("TakeSessionToken = %+v, %v", got, err)
+	}
+	if got.SessionID != "session-1" || !got.CreatedAt.Equal(created) || string(got.State) != string(token.State) {
+		t.Errorf("token = %+v", got)
+	}
+
+	// Taking a token uses it up
+	if got, err := s.TakeSessionToken("hash-2"); err != nil || got != nil {
+		t.Errorf("second take = %+v, %v; want none", got, err)
+	}
+}
+
+func TestSessionTokenExpiry(t *testing.T) {
+	s := newTestStore(t)
+	tokens := map[string]time.Time{"expired": time.Now().Add(-time.Minute), "live": time.Now().Add(time.Hour)}
+	for id, expiresAt := range tokens {
+		if err := s.SaveSessionToken(SessionToken{SessionID: id, TokenHash: id, CreatedAt: time.Now(), ExpiresAt: expiresAt}); err != nil {
+			t.Fatalf("SaveSessionToken: %v", err)
+		}
+	}
+
+	if got, err := s.TakeSessionToken("expired"); err != nil || got != nil {
+		t.Errorf("expired token = %+v, %v; want none", got, err)
+	}
+
+	if err := s.SaveSessionToken(SessionToken{SessionID: "old", TokenHash: "old", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(-time.Second)}); err != nil {
+		t.Fatalf("SaveSessionToken: %v", err)
+	}
+	if n, err := s.DeleteExpiredSessionTokens(); err != nil || n != 1 {
+		t.Errorf("DeleteExpiredSessionTokens = %d, %v; want 1", n, err)
+	}
+
+	if err := s.DeleteSessionToken("live"); err != nil {
+		t.Fatalf("DeleteSessionToken: %v", err)
+	}
+	if got, err := s.TakeSessionToken("live"); err != nil || got != nil {
+		t.Errorf("deleted token = %+v, %v; want none", got, err)
+	}
+}
+
+func TestTakeSessionTokenConcurrently(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.SaveSessionToken(SessionToken{SessionID: "session-1", TokenHash: "hash", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("SaveSessionToken: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	taken := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := s.TakeSessionToken("hash")
+			if err != nil {
+				t.Errorf("TakeSessionToken: %v", err)
+			}
+			if got != nil {
+				mu.Lock()
+				taken++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if taken != 1 {
+		t.Errorf("token taken %d times, want once", taken)
+	}
+}
+
+func TestSessionInvites(t *testing.T) {
+	s := newTestStore(t)
+	expires := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	expired := time.Now().Add(-time.Second)
+	invites := []SessionInvite{
+		{ID: "scoped", TokenHash: "hash-scoped", Role: "observer", HostID: "host-1", ProcessID: "proc-1",
+			Label: "pairing", CreatedAt: time.Now(), ExpiresAt: &expires},
+		{ID: "open", TokenHash: "hash-open", Role: "observer", CreatedAt: time.Now()},
+		{ID: "expired", TokenHash: "hash-expired", Role: "observer", CreatedAt: time.Now(), ExpiresAt: &expired},
+	}
+	for _, invite := range invites {
+		if err := s.SaveSessionInvite(invite); err != nil {
+			t.Fatalf("SaveSessionInvite: %v", err)
+		}
+	}
+
+	// Invites can be used more than once
+	for i := 0; i < 2; i++ {
+		got, err := s.GetSessionInvite("hash-scoped")
+		if err != nil || got == nil {
+			t.Fatalf("GetSessionInvite = %+v, %v", got, err)
+		}
+		if got.ID != "scoped" || got.HostID != "host-1" || got.ProcessID != "proc-1" || got.Label != "pairing" ||
+			got.ExpiresAt == nil || !got.ExpiresAt.Equal(expires) {
+			t.Errorf("invite = %+v", got)
+		}
+	}
+	if got, err := s.GetSessionInvite("hash-open"); err != nil || got == nil || got.ExpiresAt != nil || got.HostID != "" {
+		t.Errorf("invite without expiry = %+v, %v", got, err)
+	}
+	if got, err := s.GetSessionInvite("hash-expired"); err != nil || got != nil {
+		t.Errorf("expired invite = %+v, %v; want none", got, err)
+	}
+	if n, err := s.DeleteExpiredSessionInvites(); err != nil || n != 1 {
+		t.Errorf("DeleteExpiredSessionInvites = %d, %v; want 1", n, err)
+	}
+
+	// Revoked
+	if ok, err := s.DeleteSessionInvite("open"); err != nil || !ok {
+		t.Errorf("DeleteSessionInvite = %v, %v", ok, err)
+	}
+	if got, err := s.GetSessionInvite("hash-open"); err != nil || got != nil {
+		t.Errorf("revoked invite = %+v, %v; want none", got, err)
+	}
+	if ok, err := s.DeleteSessionInvite("open"); err != nil || ok {
+		t.Errorf("second DeleteSessionInvite = %v, %v; want false", ok, err)
+	}
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Bridge-wide setting keys
+const (
+	// SettingClaudePortRange is the AgentAPI port range the bridge last ran
+	// with, formatted as "min-max"
+	SettingClaudePortRange = "claude_port_range"
+
+	// SettingHistoryEncrypted is "true" while history is encrypted at rest,
+	// which leaves out the chat search index
+	SettingHistoryEncrypted = "history_encrypted"
+)
+
+// GetSetting returns a bridge-wide setting, or ok=false if it was never set
+func (s *Store) GetSetting(key string) (value string, ok bool, err error) {
+	err = s.db.QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get setting %s: %w", key, err)
+	}
+	return value, true, nil
+}
+
+// SetSetting saves a bridge-wide setting
+func (s *Store) SetSetting(key, value string) error {
+	now := time.Now().Unix()
+	_, err := s.exec(`
+		INSERT INTO settings (key, value, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = ?, updated_at = ?`,
+		key, value, now, value, now)
+	if err != nil {
+		return fmt.Errorf("failed to set setting %s: %w", key, err)
+	}
+	log.Printf("[DEBUG] [Storage] Set %s to %q", key, value)
+	return nil
+}
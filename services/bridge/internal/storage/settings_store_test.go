@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSettingsPersistAcrossReopen(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if _, ok, err := s.GetSetting(SettingClaudePortRange); ok || err != nil {
+		t.Fatalf("unset setting: ok=%v err=%v", ok, err)
+	}
+	if err := s.SetSetting(SettingClaudePortRange, "3284-3299"); err != nil {
+		t.Fatalf("SetSetting: %v", err)
+	}
+	if err := s.SetSetting(SettingClaudePortRange, "40000-40511"); err != nil {
+		t.Fatalf("SetSetting again: %v", err)
+	}
+	s.Close()
+
+	s, err = NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s.Close()
+	if value, ok, err := s.GetSetting(SettingClaudePortRange); value != "40000-40511" || !ok || err != nil {
+		t.Errorf("GetSetting = %q, %v, %v, want the last value", value, ok, err)
+	}
+}
+
+func TestGetProcessMetadataOutsidePorts(t *testing.T) {
+	s := newTestStore(t)
+	for _, meta := range []ProcessMetadata{
+		{ProcessID: "claude-in", HostID: "host-1", ProcessType: "claude", Port: 3290, TmuxName: "rc-claude-in"},
+		{ProcessID: "claude-low", HostID: "host-1", ProcessType: "claude", Port: 3284, TmuxName: "rc-claude-low"},
+		{ProcessID: "claude-high", HostID: "host-2", ProcessType: "claude", Port: 3300, TmuxName: "rc-claude-high"},
+		{ProcessID: "shell", HostID: "host-1", ProcessType: "shell", TmuxName: "rc-shell"},
+	} {
+		if err := s.SaveProcessMetadata(meta); err != nil {
+			t.Fatalf("SaveProcessMetadata(%s): %v", meta.ProcessID, err)
+		}
+	}
+
+	outside, err := s.GetProcessMetadataOutsidePorts(3285, 3299)
+	if err != nil {
+		t.Fatalf("GetProcessMetadataOutsidePorts: %v", err)
+	}
+	if len(outside) != 2 || outside[0].ProcessID != "claude-high" || outside[1].ProcessID != "claude-low" {
+		t.Errorf("outside = %+v, want claude-high and claude-low", outside)
+	}
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// GetHostStartupHooks returns the commands typed into every new shell on a
+// host, in order, or nil if there are none
+func (s *Store) GetHostStartupHooks(hostID string) ([]string, error) {
+	var hooksJSON sql.NullString
+	err := s.db.QueryRow(`SELECT startup_hooks FROM host_settings WHERE host_id = ?`, hostID).Scan(&hooksJSON)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host startup hooks: %w", err)
+	}
+	return decodeStartupHooks(hooksJSON)
+}
+
+// SetHostStartupHooks replaces a host's startup hooks; none clears them
+func (s *Store) SetHostStartupHooks(hostID string, hooks []string) error {
+	value, err := startupHooksJSON(hooks)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	_, err = s.exec(`
+		INSERT INTO host_settings (host_id, startup_hooks, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(host_id) DO UPDATE SET startup_hooks = ?, updated_at = ?`,
+		hostID, value, now, value, now)
+	if err != nil {
+		return fmt.Errorf("failed to set host startup hooks: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Set %d startup hooks for host %s", len(hooks), hostID)
+	return nil
+}
+
+// SetProcessStartupHooks records the startup hooks typed into a process's
+// shell when it was created; none clears them
+func (s *Store) SetProcessStartupHooks(processID string, hooks []string) error {
+	value, err := startupHooksJSON(hooks)
+	if err != nil {
+		return err
+	}
+	if _, err := s.exec(`UPDATE process_metadata SET startup_hooks = ? WHERE process_id = ?`, value, processID); err != nil {
+		return fmt.Errorf("failed to update process startup hooks: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Set %d startup hooks of process %s", len(hooks), processID)
+	return nil
+}
+
+// startupHooksJSON encodes startup hooks for a startup_hooks column, NULL
+// when there are none
+func startupHooksJSON(hooks []string) (interface{}, error) {
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(hooks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal startup hooks: %w", err)
+	}
+	return string(data), nil
+}
+
+// decodeStartupHooks decodes a startup_hooks column
+func decodeStartupHooks(value sql.NullString) ([]string, error) {
+	if !value.Valid || value.String == "" {
+		return nil, nil
+	}
+	var hooks []string
+	if err := json.Unmarshal([]byte(value.String), &hooks); err != nil {
+		return nil, fmt.Errorf("failed to parse startup hooks: %w", err)
+	}
+	return hooks, nil
+}
+
+// parseStartupHooks decodes the startup_hooks column of a process
+func parseStartupHooks(processID string, value sql.NullString) []string {
+	hooks, err := decodeStartupHooks(value)
+	if err != nil {
+		log.Printf("[WARN] [Storage] Failed to read startup hooks of process %s: %v", processID, err)
+	}
+	return hooks
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHostStartupHooks(t *testing.T) {
+	s := newTestStore(t)
+	if hooks, err := s.GetHostStartupHooks("host-1"); err != nil || hooks != nil {
+		t.Errorf("hooks before any were set = %q, %v", hooks, err)
+	}
+	if err := s.SetHostMaxConcurrentOps("host-1", 2); err != nil {
+		t.Fatalf("SetHostMaxConcurrentOps: %v", err)
+	}
+
+	hooks := []string{"source ~/venv/bin/activate", "cd ~/repo"}
+	if err := s.SetHostStartupHooks("host-1", hooks); err != nil {
+		t.Fatalf("SetHostStartupHooks: %v", err)
+	}
+	if got, err := s.GetHostStartupHooks("host-1"); err != nil || !slices.Equal(got, hooks) {
+		t.Errorf("hooks = %q, %v; want %q", got, err, hooks)
+	}
+	if limit, _ := s.GetHostMaxConcurrentOps("host-1"); limit != 2 {
+		t.Errorf("operation limit = %d after setting hooks", limit)
+	}
+
+	if err := s.SetHostStartupHooks("host-1", nil); err != nil {
+		t.Fatalf("SetHostStartupHooks: %v", err)
+	}
+	if got, err := s.GetHostStartupHooks("host-1"); err != nil || got != nil {
+		t.Errorf("cleared hooks = %q, %v", got, err)
+	}
+}
+
+func TestProcessStartupHooks(t *testing.T) {
+	s := newTestStore(t)
+	meta := ProcessMetadata{ProcessID: "proc-1", HostID: "host-1", ProcessType: "shell", TmuxName: "rc-proc-1", StartedAt: time.Now()}
+	if err := s.SaveProcessMetadata(meta); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+
+	hooks := []string{"cd ~/repo", "nvm use"}
+	if err := s.SetProcessStartupHooks("proc-1", hooks); err != nil {
+		t.Fatalf("SetProcessStartupHooks: %v", err)
+	}
+
+	// Saving the metadata again keeps them
+	if err := s.SaveProcessMetadata(meta); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	got, err := s.GetProcessMetadata("proc-1")
+	if err != nil || got == nil || !slices.Equal(got.StartupHooks, hooks) {
+		t.Errorf("GetProcessMetadata = %+v, %v; want hooks %q", got, err, hooks)
+	}
+	metas, err := s.GetProcessMetadataByHost("host-1")
+	if err != nil || len(metas) != 1 || !slices.Equal(metas[0].StartupHooks, hooks) {
+		t.Errorf("GetProcessMetadataByHost = %+v, %v", metas, err)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -21,7 +23,11 @@ CREATE TABLE IF NOT EXISTS ssh_hosts (
     username TEXT NOT NULL,
     auth_type TEXT NOT NULL,
     credential_encrypted BLOB,
+    credential_backend TEXT NOT NULL DEFAULT 'sqlite',
     auto_connect INTEGER NOT NULL DEFAULT 0,
+    fingerprint TEXT,
+    color TEXT,
+    icon TEXT,
     created_at INTEGER NOT NULL,
     updated_at INTEGER NOT NULL
 );
@@ -31,6 +37,7 @@ CREATE TABLE IF NOT EXISTS pty_history (
     process_id TEXT NOT NULL,
     host_id TEXT NOT NULL,
     data BLOB NOT NULL,
+    size INTEGER,
     sequence_num INTEGER NOT NULL,
     created_at INTEGER NOT NULL,
     UNIQUE(process_id, sequence_num)
@@ -47,6 +54,10 @@ CREATE TABLE IF NOT EXISTS chat_history (
     role TEXT NOT NULL,
     message TEXT NOT NULL,
     message_time TEXT NOT NULL,
+    pending INTEGER NOT NULL DEFAULT 0,
+    client_message_id TEXT,
+    kind TEXT,
+    metadata TEXT,
     created_at INTEGER NOT NULL,
     UNIQUE(process_id, message_id)
 );
@@ -64,13 +75,73 @@ CREATE TABLE IF NOT EXISTS process_metadata (
     name TEXT,
     shell_pid INTEGER,
     agent_api_pid INTEGER,
+    claude_cwd TEXT,
+    claude_session_id TEXT,
+    pinned INTEGER NOT NULL DEFAULT 0,
+    sort_weight INTEGER NOT NULL DEFAULT 0,
+    usage TEXT,
+    usage_updated_at INTEGER,
+    term_options TEXT,
+    timeline INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    archived_at INTEGER,
+    color TEXT,
+    icon TEXT,
+    retain_full_history INTEGER NOT NULL DEFAULT 0,
     started_at INTEGER NOT NULL,
     last_seen_at INTEGER NOT NULL
 );
 
+CREATE TABLE IF NOT EXISTS command_timeline (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    process_id TEXT NOT NULL,
+    command_text TEXT NOT NULL,
+    started_at INTEGER NOT NULL,
+    ended_at INTEGER,
+    exit_code INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_command_timeline_process ON command_timeline(process_id, id);
+
+CREATE TABLE IF NOT EXISTS chat_drafts (
+    process_id TEXT PRIMARY KEY,
+    text TEXT NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS session_tokens (
+    session_id TEXT PRIMARY KEY,
+    token_hash TEXT NOT NULL UNIQUE,
+    state TEXT,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_tokens_expires ON session_tokens(expires_at);
+
+CREATE TABLE IF NOT EXISTS session_invites (
+    id TEXT PRIMARY KEY,
+    token_hash TEXT NOT NULL UNIQUE,
+    role TEXT NOT NULL,
+    host_id TEXT,
+    process_id TEXT,
+    label TEXT,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS settings (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+
 CREATE TABLE IF NOT EXISTS host_settings (
     host_id TEXT PRIMARY KEY,
     rc_file_override TEXT,
+    boot_time INTEGER,
+    tmux_socket_path TEXT,
+    tmux_command TEXT,
     updated_at INTEGER NOT NULL
 );
 
@@ -81,6 +152,33 @@ CREATE TABLE IF NOT EXISTS snippets (
     created_at INTEGER NOT NULL,
     updated_at INTEGER NOT NULL
 );
+
+CREATE TABLE IF NOT EXISTS workspaces (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS process_workspace (
+    process_id TEXT PRIMARY KEY,
+    workspace_id TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_process_workspace_workspace ON process_workspace(workspace_id);
+
+CREATE TABLE IF NOT EXISTS process_templates (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    host_id TEXT,
+    cwd TEXT,
+    env_vars TEXT,
+    shell TEXT,
+    claude_args TEXT,
+    auto_start_claude INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
 `
 
 // PtyChunk represents a chunk of PTY output in the buffer
@@ -95,6 +193,16 @@ type ChatMessage struct {
 	Role        string `json:"role"`
 	Message     string `json:"message"`
 	MessageTime string `json:"time"`
+
+	// Pending marks a message sent through the bridge that AgentAPI hasn't
+	// echoed yet, see AddPendingChatMessage
+	Pending         bool   `json:"pending,omitempty"`
+	ClientMessageID string `json:"clientMessageId,omitempty"` // Set on messages sent through the bridge
+
+	// Kind and Metadata are derived from what AgentAPI sent, see
+	// agentapi.Message
+	Kind     string          `json:"kind,omitempty"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
 }
 
 // EnvVar represents an environment variable
@@ -111,12 +219,49 @@ type ProcessMetadata struct {
 	Port        int
 	TmuxName    string
 	CWD         string
+	ClaudeCWD   string // Working directory snapshot taken at claude_start
 	Name        string
 	ShellPID    int
 	AgentAPIPID int
 	StartedAt   time.Time
 	LastSeenAt  time.Time
 	EnvVars     []EnvVar // Environment variables captured at spawn time
+
+	// Position in the host's process list; not written by
+	// SaveProcessMetadata (see SetProcessPinned and SetProcessOrder)
+	ProcessOrder
+
+	// Terminal options chosen for the process's tmux session; not written
+	// by SaveProcessMetadata (see SetProcessTermOptions)
+	TermOptions map[string]string
+
+	// Whether the process's command timeline is recorded; not written by
+	// SaveProcessMetadata (see SetProcessTimeline)
+	Timeline bool
+
+	// The last failure on the process, until it is cleared; not written by
+	// SaveProcessMetadata (see SetProcessLastError)
+	LastError *ProcessError
+
+	// When the process was killed with its history kept, or zero while it
+	// is live; not written by SaveProcessMetadata (see ArchiveProcess)
+	ArchivedAt time.Time
+
+	// How the process is shown; not written by SaveProcessMetadata (see
+	// SetProcessAppearance)
+	Appearance
+
+	// Whether PrunePtyHistory leaves the process's PTY history whole; not
+	// written by SaveProcessMetadata (see SetProcessRetainFullHistory)
+	RetainFullHistory bool
+
+	// Startup hooks typed into the process's shell when it was created; not
+	// written by SaveProcessMetadata (see SetProcessStartupHooks)
+	StartupHooks []string
+
+	// Session ID Claude was started with, which names its transcript; not
+	// written by SaveProcessMetadata (see UpdateProcessClaudeSessionID)
+	ClaudeSessionID string
 }
 
 // PtyBuffer holds in-memory PTY data for a process
@@ -124,17 +269,28 @@ type PtyBuffer struct {
 	mu          sync.RWMutex
 	chunks      []PtyChunk
 	nextSeqNum  int64
+	loaded      bool // Holds the chunks stored before it was created
 	dirty       bool // Has unsaved changes
 	totalBytes  int64
 	lastPersist time.Time
+
+	// Early flushes, see flush.go
+	persisted  int       // Leading chunks written to the database
+	unflushed  int64     // Bytes appended since the last write
+	dirtySince time.Time // When the oldest of them arrived
 }
 
 // ChatBuffer holds in-memory chat messages for a process
 type ChatBuffer struct {
 	mu          sync.RWMutex
 	messages    map[int]ChatMessage // keyed by message_id
+	deleted     map[int]bool        // message_ids removed since the last persist
 	dirty       bool
 	lastPersist time.Time
+
+	// Early flushes, see flush.go
+	unflushed  int64     // Bytes of chat text changed since the last persist
+	dirtySince time.Time // When the oldest of them changed
 }
 
 // Store manages SQLite persistence and in-memory buffers
@@ -146,15 +302,83 @@ type Store struct {
 	chatBuffers map[string]*ChatBuffer // processId -> buffer
 	hostMap     map[string]string      // processId -> hostId
 
+	// chatFTS is set when chat_history_fts is available; chat search falls
+	// back to LIKE otherwise
+	chatFTS bool
+
+	// archiveMaxAge is how long archived processes are kept (0 forever),
+	// as nanoseconds; see SetArchiveMaxAge
+	archiveMaxAge atomic.Int64
+
+	// ptyHistoryMaxBytes caps each process's PTY history (0 unlimited); see
+	// SetPtyHistoryMaxBytes
+	ptyHistoryMaxBytes atomic.Int64
+
+	// historyCipher decrypts stored chat messages, PTY output and env vars;
+	// they are encrypted with it when encryptHistory is set. Both are set
+	// once, by SetHistoryEncryption before the store is used.
+	historyCipher  HistoryCipher
+	encryptHistory bool
+
+	// Persistence intervals, as nanoseconds; see SetPersistInterval and
+	// SetEarlyFlush. loopConfigured tells the loop they changed.
+	persistEvery   atomic.Int64
+	flushMaxBytes  atomic.Int64
+	flushMaxAge    atomic.Int64
+	loopConfigured chan struct{}
+
+	// Processes queued for an early flush, and the wakeup of the loop that
+	// runs them; see flush.go for the lock order
+	flushMu    sync.Mutex
+	flushQueue map[string]bool
+	flushWake  chan struct{}
+
+	// now is the clock unsaved data is aged by
+	now func() time.Time
+
 	mu     sync.RWMutex
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
+
+	// Persistence loop health, guarded by statsMu
+	statsMu        sync.Mutex
+	started        time.Time
+	lastPersist    time.Time
+	lastError      string
+	lastErrorAt    time.Time
+	loopRestarts   int
+	persistLoopEnd chan struct{} // closed when persistLoop returns
+
+	// WAL checkpoints, guarded by statsMu
+	lastCheckpoint     time.Time
+	checkpointFailures int // Checkpoints in a row that failed
+}
+
+// persistInterval is how often persistLoop saves dirty buffers, unless set
+// by SetPersistInterval
+var persistInterval = 30 * time.Second
+
+// persistCycleHook runs at the start of every periodic persist. Tests use it
+// to make a cycle fail.
+var persistCycleHook func()
+
+// StoreStats reports the health of periodic persistence
+type StoreStats struct {
+	LastSuccessfulPersist *time.Time `json:"lastSuccessfulPersist,omitempty"`
+	LastError             string     `json:"lastError,omitempty"`
+	LastErrorAt           *time.Time `json:"lastErrorAt,omitempty"`
+	LoopRestarts          int        `json:"loopRestarts"`
+	Healthy               bool       `json:"healthy"`  // A persist succeeded within 3 intervals
+	DBBytes               int64      `json:"dbBytes"`  // Size of the database file
+	WALBytes              int64      `json:"walBytes"` // Size of its write-ahead log
+	LastCheckpoint        *time.Time `json:"lastCheckpoint,omitempty"`
+	CheckpointFailures    int        `json:"checkpointFailures"` // Checkpoints in a row that failed
 }
 
 // NewStore creates a new storage instance with SQLite backend
 func NewStore(dbPath string) (*Store, error) {
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := sql.Open("sqlite", dsn(dbPath))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -178,6 +402,36 @@ func NewStore(dbPath string) (*Store, error) {
 		"ALTER TABLE process_metadata ADD COLUMN shell_pid INTEGER",
 		"ALTER TABLE process_metadata ADD COLUMN agent_api_pid INTEGER",
 		"ALTER TABLE process_metadata ADD COLUMN env_vars TEXT", // JSON blob of env vars
+		"ALTER TABLE process_metadata ADD COLUMN claude_cwd TEXT",
+		"ALTER TABLE process_metadata ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE process_metadata ADD COLUMN sort_weight INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE process_metadata ADD COLUMN usage TEXT", // JSON blob of the last usage report
+		"ALTER TABLE process_metadata ADD COLUMN usage_updated_at INTEGER",
+		"ALTER TABLE process_metadata ADD COLUMN term_options TEXT", // JSON object of tmux options
+		"ALTER TABLE process_metadata ADD COLUMN timeline INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE process_metadata ADD COLUMN last_error TEXT", // JSON object of the last failure
+		"ALTER TABLE ssh_hosts ADD COLUMN fingerprint TEXT",
+		"ALTER TABLE ssh_hosts ADD COLUMN credential_backend TEXT NOT NULL DEFAULT 'sqlite'",
+		"ALTER TABLE host_settings ADD COLUMN boot_time INTEGER", // Unix seconds, recorded at connect
+		"ALTER TABLE pty_history ADD COLUMN size INTEGER",        // Length of data before encryption
+		"ALTER TABLE chat_history ADD COLUMN pending INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE chat_history ADD COLUMN client_message_id TEXT",
+		"ALTER TABLE host_settings ADD COLUMN tmux_socket_path TEXT",
+		"ALTER TABLE host_settings ADD COLUMN tmux_command TEXT",
+		"ALTER TABLE process_metadata ADD COLUMN archived_at INTEGER", // Unix seconds, set when killed with its history kept
+		"ALTER TABLE ssh_hosts ADD COLUMN color TEXT",
+		"ALTER TABLE ssh_hosts ADD COLUMN icon TEXT",
+		"ALTER TABLE process_metadata ADD COLUMN color TEXT",
+		"ALTER TABLE host_settings ADD COLUMN max_concurrent_ops INTEGER", // Overrides the bridge's limit when set
+		"ALTER TABLE process_metadata ADD COLUMN icon TEXT",
+		"ALTER TABLE chat_history ADD COLUMN kind TEXT",
+		"ALTER TABLE chat_history ADD COLUMN metadata TEXT", // JSON object of AgentAPI's other message fields
+		"ALTER TABLE process_metadata ADD COLUMN retain_full_history INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE host_settings ADD COLUMN platform TEXT",          // JSON object, recorded at connect
+		"ALTER TABLE host_settings ADD COLUMN startup_hooks TEXT",     // JSON array of commands, in order
+		"ALTER TABLE process_templates ADD COLUMN startup_hooks TEXT", // JSON array of commands, in order
+		"ALTER TABLE process_metadata ADD COLUMN startup_hooks TEXT",  // JSON array of the hooks typed at start
+		"ALTER TABLE process_metadata ADD COLUMN claude_session_id TEXT",
 	}
 	for _, migration := range migrations {
 		// Ignore errors - column may already exist
@@ -194,7 +448,21 @@ func NewStore(dbPath string) (*Store, error) {
 		hostMap:     make(map[string]string),
 		ctx:         ctx,
 		cancel:      cancel,
+
+		loopConfigured: make(chan struct{}, 1),
+		flushQueue:     make(map[string]bool),
+		flushWake:      make(chan struct{}, 1),
+		now:            time.Now,
+
+		started:        time.Now(),
+		persistLoopEnd: make(chan struct{}),
+	}
+	// While history is encrypted the index is left out (see
+	// SetHistoryEncryption)
+	if encrypted, _, _ := s.GetSetting(SettingHistoryEncrypted); encrypted != "true" {
+		s.chatFTS = initChatSearch(db)
 	}
+	s.persistEvery.Store(int64(persistInterval))
 
 	// Start periodic persistence goroutine
 	s.wg.Add(1)
@@ -204,26 +472,132 @@ func NewStore(dbPath string) (*Store, error) {
 	return s, nil
 }
 
-// persistLoop runs periodic persistence every 30 seconds
+// persistLoop runs periodic persistence every persist interval, and early
+// flushes as they are queued, until the store closes, restarting the ticker
+// loop if a cycle panics
 func (s *Store) persistLoop() {
 	defer s.wg.Done()
+	defer close(s.persistLoopEnd)
 
-	ticker := time.NewTicker(30 * time.Second)
+	for !s.runPersistTicker() {
+		s.statsMu.Lock()
+		s.loopRestarts++
+		s.statsMu.Unlock()
+		log.Printf("[WARN] [Storage] Restarting persistence loop")
+	}
+}
+
+// runPersistTicker persists on every tick, and flushes processes queued for
+// an early flush. It returns true when the store is closing and false after
+// recovering from a panic.
+func (s *Store) runPersistTicker() (stopped bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[ERROR] [Storage] Persistence loop panicked: %v\n%s", r, debug.Stack())
+			s.recordPersist(fmt.Errorf("panic: %v", r))
+		}
+	}()
+
+	ticker := time.NewTicker(s.persistPeriod())
 	defer ticker.Stop()
 
+	// Aged data is looked for only while age triggers flushes
+	var aged *time.Ticker
+	var agedC <-chan time.Time
+	armAged := func() {
+		if aged != nil {
+			aged.Stop()
+			aged, agedC = nil, nil
+		}
+		if period := s.agedFlushPeriod(); period > 0 {
+			aged = time.NewTicker(period)
+			agedC = aged.C
+		}
+	}
+	armAged()
+	defer func() {
+		if aged != nil {
+			aged.Stop()
+		}
+	}()
+
 	for {
 		select {
 		case <-s.ctx.Done():
 			log.Printf("[INFO] [Storage] Persistence loop stopping")
-			return
+			return true
+		case <-s.loopConfigured:
+			ticker.Reset(s.persistPeriod())
+			armAged()
+		case <-s.flushWake:
+			s.runQueuedFlushes()
+		case <-agedC:
+			s.queueAgedFlushes()
+			s.runQueuedFlushes()
 		case <-ticker.C:
-			if err := s.PersistAll(); err != nil {
+			if persistCycleHook != nil {
+				persistCycleHook()
+			}
+			err := s.PersistAll()
+			if err != nil {
 				log.Printf("[ERROR] [Storage] Periodic persist failed: %v", err)
 			}
+			s.recordPersist(err)
+			if err := s.PruneChatDrafts(); err != nil {
+				log.Printf("[WARN] [Storage] Failed to prune chat drafts: %v", err)
+			}
+			if _, err := s.PruneArchivedProcesses(); err != nil {
+				log.Printf("[WARN] [Storage] Failed to prune archived processes: %v", err)
+			}
+			if _, err := s.PrunePtyHistory(); err != nil {
+				log.Printf("[WARN] [Storage] Failed to prune PTY history: %v", err)
+			}
+			s.checkpointIfLarge()
 		}
 	}
 }
 
+// recordPersist notes the outcome of a persist for Stats
+func (s *Store) recordPersist(err error) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if err != nil {
+		s.lastError = err.Error()
+		s.lastErrorAt = time.Now()
+		return
+	}
+	s.lastPersist = time.Now()
+}
+
+// Stats reports when buffers were last persisted and whether the persistence
+// loop is keeping up, and the size of the database and its WAL. The store is unhealthy once no persist has succeeded,
+// counting from when it opened, for three intervals.
+func (s *Store) Stats() StoreStats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	stats := StoreStats{LastError: s.lastError, LoopRestarts: s.loopRestarts}
+	since := s.started
+	if !s.lastPersist.IsZero() {
+		lastPersist := s.lastPersist
+		stats.LastSuccessfulPersist = &lastPersist
+		since = lastPersist
+	}
+	if !s.lastErrorAt.IsZero() {
+		lastErrorAt := s.lastErrorAt
+		stats.LastErrorAt = &lastErrorAt
+	}
+	stats.Healthy = time.Since(since) <= 3*s.persistPeriod()
+	stats.DBBytes = fileSize(s.dbPath)
+	stats.WALBytes = fileSize(s.walPath())
+	if !s.lastCheckpoint.IsZero() {
+		lastCheckpoint := s.lastCheckpoint
+		stats.LastCheckpoint = &lastCheckpoint
+	}
+	stats.CheckpointFailures = s.checkpointFailures
+	return stats
+}
+
 // PersistAll saves all dirty buffers to SQLite
 func (s *Store) PersistAll() error {
 	s.mu.RLock()
@@ -256,6 +630,14 @@ func (s *Store) PersistAll() error {
 func (s *Store) Close() error {
 	log.Printf("[INFO] [Storage] Closing store...")
 
+	// A loop that died on its own has left buffers unsaved since then; the
+	// final persist below covers them either way
+	select {
+	case <-s.persistLoopEnd:
+		log.Printf("[WARN] [Storage] Persistence loop had stopped; persisting directly")
+	default:
+	}
+
 	// Signal persistence loop to stop
 	s.cancel()
 
@@ -263,9 +645,17 @@ func (s *Store) Close() error {
 	s.wg.Wait()
 
 	// Final persist
-	if err := s.PersistAll(); err != nil {
+	err := s.PersistAll()
+	if err != nil {
 		log.Printf("[WARN] [Storage] Final persist had errors: %v", err)
 	}
+	s.recordPersist(err)
+
+	// Leave nothing in the WAL for the next start (or a backup of the
+	// database file alone) to replay
+	if err := s.Checkpoint(); err != nil {
+		log.Printf("[WARN] [Storage] Final WAL checkpoint failed: %v", err)
+	}
 
 	// Close database
 	if err := s.db.Close(); err != nil {
@@ -315,9 +705,12 @@ func (s *Store) getOrCreateChatBuffer(processId, hostId string) *ChatBuffer {
 	return buf
 }
 
-// RegisterProcess registers a new process for history tracking
+// RegisterProcess registers a new process for history tracking. History
+// stored under its ID, e.g. before the bridge restarted, is loaded first.
 func (s *Store) RegisterProcess(processId, hostId string) {
-	s.getOrCreatePtyBuffer(processId, hostId)
+	if err := s.EnsurePtyHistoryLoaded(processId, hostId); err != nil {
+		log.Printf("[WARN] [Storage] Failed to load PTY history of process %s: %v", processId, err)
+	}
 	s.getOrCreateChatBuffer(processId, hostId)
 	log.Printf("[DEBUG] [Storage] Registered process %s for host %s", processId, hostId)
 }
@@ -337,6 +730,9 @@ func (s *Store) UnregisterProcess(processId string) error {
 	if err := s.ClearChatHistory(processId); err != nil {
 		return err
 	}
+	if err := s.ClearTimeline(processId); err != nil {
+		return err
+	}
 
 	log.Printf("[DEBUG] [Storage] Unregistered process %s", processId)
 	return nil
@@ -365,21 +761,23 @@ func (s *Store) LoadProcessHistory(processId, hostId string) error {
 // SaveProcessMetadata saves or updates process metadata
 func (s *Store) SaveProcessMetadata(meta ProcessMetadata) error {
 	// Serialize env vars to JSON
-	var envVarsJSON *string
-	if len(meta.EnvVars) > 0 {
-		data, err := json.Marshal(meta.EnvVars)
-		if err != nil {
-			log.Printf("[WARN] [Storage] Failed to marshal env vars: %v", err)
-		} else {
-			str := string(data)
-			envVarsJSON = &str
-		}
+	envVarsJSON, err := s.sealEnvVars(meta.EnvVars)
+	if err != nil {
+		log.Printf("[WARN] [Storage] Failed to store env vars: %v", err)
 	}
 
-	_, err := s.db.Exec(`
-		INSERT OR REPLACE INTO process_metadata
-		(process_id, host_id, process_type, port, tmux_name, cwd, name, shell_pid, agent_api_pid, started_at, last_seen_at, env_vars)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+	// A new process goes last on its host; an existing one keeps its place
+	_, err = s.exec(`
+		INSERT INTO process_metadata
+		(process_id, host_id, process_type, port, tmux_name, cwd, name, shell_pid, agent_api_pid, started_at, last_seen_at, env_vars, claude_cwd, sort_weight)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?,
+			(SELECT COALESCE(MAX(sort_weight) + 1, 0) FROM process_metadata WHERE host_id = ? AND archived_at IS NULL))
+		ON CONFLICT(process_id) DO UPDATE SET
+			host_id = excluded.host_id, process_type = excluded.process_type, port = excluded.port,
+			tmux_name = excluded.tmux_name, cwd = excluded.cwd, name = excluded.name,
+			shell_pid = excluded.shell_pid, agent_api_pid = excluded.agent_api_pid,
+			started_at = excluded.started_at, last_seen_at = excluded.last_seen_at,
+			env_vars = excluded.env_vars, claude_cwd = excluded.claude_cwd`,
 		meta.ProcessID,
 		meta.HostID,
 		meta.ProcessType,
@@ -392,6 +790,8 @@ func (s *Store) SaveProcessMetadata(meta ProcessMetadata) error {
 		meta.StartedAt.Unix(),
 		time.Now().Unix(),
 		envVarsJSON,
+		nullString(meta.ClaudeCWD),
+		meta.HostID,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to save process metadata: %w", err)
@@ -400,6 +800,35 @@ func (s *Store) SaveProcessMetadata(meta ProcessMetadata) error {
 	return nil
 }
 
+// sealEnvVars encodes env vars as JSON for the env_vars column, encrypted
+// when history is; none are stored as NULL
+func (s *Store) sealEnvVars(envVars []EnvVar) (interface{}, error) {
+	if len(envVars) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(envVars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal env vars: %w", err)
+	}
+	return s.sealHistoryText(string(data))
+}
+
+// openEnvVars decodes the env_vars column of a process
+func (s *Store) openEnvVars(processID string, stored []byte) []EnvVar {
+	data, err := s.openHistory(stored)
+	if err != nil || len(data) == 0 {
+		if err != nil {
+			log.Printf("[WARN] [Storage] Failed to read env vars for process %s: %v", processID, err)
+		}
+		return nil
+	}
+	var envVars []EnvVar
+	if err := json.Unmarshal(data, &envVars); err != nil {
+		log.Printf("[WARN] [Storage] Failed to unmarshal env vars for process %s: %v", processID, err)
+	}
+	return envVars
+}
+
 // nullInt returns nil if v is 0, otherwise returns v
 func nullInt(v int) interface{} {
 	if v == 0 {
@@ -416,18 +845,20 @@ func nullString(v string) interface{} {
 	return v
 }
 
-// GetProcessMetadata retrieves metadata for a specific process
+// GetProcessMetadata retrieves metadata for a specific process, live or
+// archived
 func (s *Store) GetProcessMetadata(processID string) (*ProcessMetadata, error) {
 	row := s.db.QueryRow(`
-		SELECT process_id, host_id, process_type, port, tmux_name, cwd, name, shell_pid, agent_api_pid, started_at, last_seen_at, env_vars
+		SELECT process_id, host_id, process_type, port, tmux_name, cwd, name, shell_pid, agent_api_pid, started_at, last_seen_at, env_vars, claude_cwd, pinned, sort_weight, term_options, timeline, last_error, archived_at, color, icon, retain_full_history, startup_hooks, claude_session_id
 		FROM process_metadata WHERE process_id = ?`, processID)
 
 	var meta ProcessMetadata
-	var port, shellPID, agentAPIPID sql.NullInt64
-	var cwd, name, envVarsJSON sql.NullString
+	var port, shellPID, agentAPIPID, archivedAt sql.NullInt64
+	var cwd, claudeCWD, name, termOptionsJSON, lastErrorJSON, color, icon, startupHooksJSON, claudeSessionID sql.NullString
+	var envVarsJSON []byte
 	var startedAt, lastSeenAt int64
 
-	err := row.Scan(&meta.ProcessID, &meta.HostID, &meta.ProcessType, &port, &meta.TmuxName, &cwd, &name, &shellPID, &agentAPIPID, &startedAt, &lastSeenAt, &envVarsJSON)
+	err := row.Scan(&meta.ProcessID, &meta.HostID, &meta.ProcessType, &port, &meta.TmuxName, &cwd, &name, &shellPID, &agentAPIPID, &startedAt, &lastSeenAt, &envVarsJSON, &claudeCWD, &meta.Pinned, &meta.SortWeight, &termOptionsJSON, &meta.Timeline, &lastErrorJSON, &archivedAt, &color, &icon, &meta.RetainFullHistory, &startupHooksJSON, &claudeSessionID)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -441,6 +872,9 @@ func (s *Store) GetProcessMetadata(processID string) (*ProcessMetadata, error) {
 	if cwd.Valid {
 		meta.CWD = cwd.String
 	}
+	if claudeCWD.Valid {
+		meta.ClaudeCWD = claudeCWD.String
+	}
 	if name.Valid {
 		meta.Name = name.String
 	}
@@ -452,22 +886,37 @@ func (s *Store) GetProcessMetadata(processID string) (*ProcessMetadata, error) {
 	}
 	meta.StartedAt = time.Unix(startedAt, 0)
 	meta.LastSeenAt = time.Unix(lastSeenAt, 0)
-
-	// Parse env vars JSON
-	if envVarsJSON.Valid && envVarsJSON.String != "" {
-		if err := json.Unmarshal([]byte(envVarsJSON.String), &meta.EnvVars); err != nil {
-			log.Printf("[WARN] [Storage] Failed to unmarshal env vars for process %s: %v", processID, err)
-		}
+	if archivedAt.Valid {
+		meta.ArchivedAt = time.Unix(archivedAt.Int64, 0)
 	}
 
+	meta.EnvVars = s.openEnvVars(meta.ProcessID, envVarsJSON)
+	meta.TermOptions = parseTermOptions(meta.ProcessID, termOptionsJSON)
+	meta.LastError = parseProcessError(meta.ProcessID, lastErrorJSON)
+	meta.Color, meta.Icon = color.String, icon.String
+	meta.StartupHooks = parseStartupHooks(meta.ProcessID, startupHooksJSON)
+	meta.ClaudeSessionID = claudeSessionID.String
+
 	return &meta, nil
 }
 
-// GetProcessMetadataByHost retrieves all process metadata for a host
+// GetProcessMetadataByHost retrieves the metadata of the live processes on
+// a host
 func (s *Store) GetProcessMetadataByHost(hostID string) ([]ProcessMetadata, error) {
+	return s.queryProcessMetadata(`WHERE host_id = ? AND archived_at IS NULL`, hostID)
+}
+
+// GetProcessMetadataOutsidePorts retrieves metadata for live processes on
+// every host recorded with an AgentAPI port outside minPort-maxPort
+func (s *Store) GetProcessMetadataOutsidePorts(minPort, maxPort int) ([]ProcessMetadata, error) {
+	return s.queryProcessMetadata(`WHERE archived_at IS NULL AND port IS NOT NULL AND (port < ? OR port > ?)`, minPort, maxPort)
+}
+
+// queryProcessMetadata retrieves the process metadata matching a WHERE clause
+func (s *Store) queryProcessMetadata(where string, args ...interface{}) ([]ProcessMetadata, error) {
 	rows, err := s.db.Query(`
-		SELECT process_id, host_id, process_type, port, tmux_name, cwd, name, shell_pid, agent_api_pid, started_at, last_seen_at, env_vars
-		FROM process_metadata WHERE host_id = ?`, hostID)
+		SELECT process_id, host_id, process_type, port, tmux_name, cwd, name, shell_pid, agent_api_pid, started_at, last_seen_at, env_vars, claude_cwd, pinned, sort_weight, term_options, timeline, last_error, archived_at, color, icon, retain_full_history, startup_hooks, claude_session_id
+		FROM process_metadata `+where+` ORDER BY process_id`, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query process metadata: %w", err)
 	}
@@ -476,11 +925,12 @@ func (s *Store) GetProcessMetadataByHost(hostID string) ([]ProcessMetadata, erro
 	var results []ProcessMetadata
 	for rows.Next() {
 		var meta ProcessMetadata
-		var port, shellPID, agentAPIPID sql.NullInt64
-		var cwd, name, envVarsJSON sql.NullString
+		var port, shellPID, agentAPIPID, archivedAt sql.NullInt64
+		var cwd, claudeCWD, name, termOptionsJSON, lastErrorJSON, color, icon, startupHooksJSON, claudeSessionID sql.NullString
+		var envVarsJSON []byte
 		var startedAt, lastSeenAt int64
 
-		if err := rows.Scan(&meta.ProcessID, &meta.HostID, &meta.ProcessType, &port, &meta.TmuxName, &cwd, &name, &shellPID, &agentAPIPID, &startedAt, &lastSeenAt, &envVarsJSON); err != nil {
+		if err := rows.Scan(&meta.ProcessID, &meta.HostID, &meta.ProcessType, &port, &meta.TmuxName, &cwd, &name, &shellPID, &agentAPIPID, &startedAt, &lastSeenAt, &envVarsJSON, &claudeCWD, &meta.Pinned, &meta.SortWeight, &termOptionsJSON, &meta.Timeline, &lastErrorJSON, &archivedAt, &color, &icon, &meta.RetainFullHistory, &startupHooksJSON, &claudeSessionID); err != nil {
 			return nil, fmt.Errorf("failed to scan process metadata: %w", err)
 		}
 
@@ -490,6 +940,9 @@ func (s *Store) GetProcessMetadataByHost(hostID string) ([]ProcessMetadata, erro
 		if cwd.Valid {
 			meta.CWD = cwd.String
 		}
+		if claudeCWD.Valid {
+			meta.ClaudeCWD = claudeCWD.String
+		}
 		if name.Valid {
 			meta.Name = name.String
 		}
@@ -501,23 +954,39 @@ func (s *Store) GetProcessMetadataByHost(hostID string) ([]ProcessMetadata, erro
 		}
 		meta.StartedAt = time.Unix(startedAt, 0)
 		meta.LastSeenAt = time.Unix(lastSeenAt, 0)
-
-		// Parse env vars JSON
-		if envVarsJSON.Valid && envVarsJSON.String != "" {
-			if err := json.Unmarshal([]byte(envVarsJSON.String), &meta.EnvVars); err != nil {
-				log.Printf("[WARN] [Storage] Failed to unmarshal env vars for process %s: %v", meta.ProcessID, err)
-			}
+		if archivedAt.Valid {
+			meta.ArchivedAt = time.Unix(archivedAt.Int64, 0)
 		}
 
+		meta.EnvVars = s.openEnvVars(meta.ProcessID, envVarsJSON)
+		meta.TermOptions = parseTermOptions(meta.ProcessID, termOptionsJSON)
+		meta.LastError = parseProcessError(meta.ProcessID, lastErrorJSON)
+		meta.Color, meta.Icon = color.String, icon.String
+		meta.StartupHooks = parseStartupHooks(meta.ProcessID, startupHooksJSON)
+		meta.ClaudeSessionID = claudeSessionID.String
+
 		results = append(results, meta)
 	}
 
 	return results, nil
 }
 
-// DeleteProcessMetadata removes metadata for a process
+// DeleteProcessMetadata removes metadata for a process, closing the gap it
+// leaves in its host's sort weights
 func (s *Store) DeleteProcessMetadata(processID string) error {
-	_, err := s.db.Exec(`DELETE FROM process_metadata WHERE process_id = ?`, processID)
+	err := retryBusy(func() error {
+		return s.inTx(func(tx *sql.Tx) error {
+			var hostID string
+			err := tx.QueryRow(`DELETE FROM process_metadata WHERE process_id = ? RETURNING host_id`, processID).Scan(&hostID)
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return normalizeSortWeights(tx, hostID)
+		})
+	})
 	if err != nil {
 		return fmt.Errorf("failed to delete process metadata: %w", err)
 	}
@@ -525,9 +994,36 @@ func (s *Store) DeleteProcessMetadata(processID string) error {
 	return nil
 }
 
+// DeleteHostProcessMetadata removes the metadata of every live process on a
+// host and returns their IDs. Archived processes stay.
+func (s *Store) DeleteHostProcessMetadata(hostID string) ([]string, error) {
+	var processIDs []string
+	err := retryBusy(func() error {
+		processIDs = nil
+		rows, err := s.db.Query(`DELETE FROM process_metadata WHERE host_id = ? AND archived_at IS NULL RETURNING process_id`, hostID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var processID string
+			if err := rows.Scan(&processID); err != nil {
+				return err
+			}
+			processIDs = append(processIDs, processID)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete host process metadata: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Deleted metadata for %d processes on host %s", len(processIDs), hostID)
+	return processIDs, nil
+}
+
 // UpdateProcessType updates the type and port of a process
 func (s *Store) UpdateProcessType(processID string, processType string, port int) error {
-	_, err := s.db.Exec(`
+	_, err := s.exec(`
 		UPDATE process_metadata
 		SET process_type = ?, port = ?, last_seen_at = ?
 		WHERE process_id = ?`,
@@ -539,9 +1035,69 @@ func (s *Store) UpdateProcessType(processID string, processType string, port int
 	return nil
 }
 
+// UpdateProcessStartedAt records when a process started, for metadata
+// saved without a start time
+func (s *Store) UpdateProcessStartedAt(processID string, startedAt time.Time) error {
+	_, err := s.exec(`
+		UPDATE process_metadata
+		SET started_at = ?
+		WHERE process_id = ?`,
+		startedAt.Unix(), processID)
+	if err != nil {
+		return fmt.Errorf("failed to update process started_at: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Updated process %s started_at to %s", processID, startedAt.Format(time.RFC3339))
+	return nil
+}
+
+// UpdateProcessCWD records a process's working directory, as last reported
+// to clients
+func (s *Store) UpdateProcessCWD(processID string, cwd string) error {
+	_, err := s.exec(`
+		UPDATE process_metadata
+		SET cwd = ?, last_seen_at = ?
+		WHERE process_id = ?`,
+		nullString(cwd), time.Now().Unix(), processID)
+	if err != nil {
+		return fmt.Errorf("failed to update process cwd: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Updated process %s cwd to %q", processID, cwd)
+	return nil
+}
+
+// UpdateProcessClaudeCWD records the working directory Claude was started
+// in, or clears it when cwd is empty
+func (s *Store) UpdateProcessClaudeCWD(processID string, cwd string) error {
+	_, err := s.exec(`
+		UPDATE process_metadata
+		SET claude_cwd = ?, last_seen_at = ?
+		WHERE process_id = ?`,
+		nullString(cwd), time.Now().Unix(), processID)
+	if err != nil {
+		return fmt.Errorf("failed to update process claude cwd: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Updated process %s claude cwd to %q", processID, cwd)
+	return nil
+}
+
+// UpdateProcessClaudeSessionID records the session ID Claude was started
+// with, or clears it when sessionID is empty
+func (s *Store) UpdateProcessClaudeSessionID(processID string, sessionID string) error {
+	_, err := s.exec(`
+		UPDATE process_metadata
+		SET claude_session_id = ?, last_seen_at = ?
+		WHERE process_id = ?`,
+		nullString(sessionID), time.Now().Unix(), processID)
+	if err != nil {
+		return fmt.Errorf("failed to update process claude session id: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Updated process %s claude session id to %q", processID, sessionID)
+	return nil
+}
+
 // UpdateProcessName updates the name of a process
 func (s *Store) UpdateProcessName(processID string, name string) error {
-	_, err := s.db.Exec(`
+	_, err := s.exec(`
 		UPDATE process_metadata
 		SET name = ?, last_seen_at = ?
 		WHERE process_id = ?`,
@@ -555,17 +1111,12 @@ func (s *Store) UpdateProcessName(processID string, name string) error {
 
 // UpdateProcessEnvVars updates the environment variables for a process
 func (s *Store) UpdateProcessEnvVars(processID string, envVars []EnvVar) error {
-	var envVarsJSON *string
-	if len(envVars) > 0 {
-		data, err := json.Marshal(envVars)
-		if err != nil {
-			return fmt.Errorf("failed to marshal env vars: %w", err)
-		}
-		str := string(data)
-		envVarsJSON = &str
+	envVarsJSON, err := s.sealEnvVars(envVars)
+	if err != nil {
+		return err
 	}
 
-	_, err := s.db.Exec(`
+	_, err = s.exec(`
 		UPDATE process_metadata
 		SET env_vars = ?, last_seen_at = ?
 		WHERE process_id = ?`,
@@ -599,7 +1150,7 @@ func (s *Store) GetHostRcFile(hostID string) (string, error) {
 
 // SetHostRcFile saves the RC file override for a host
 func (s *Store) SetHostRcFile(hostID, rcFile string) error {
-	_, err := s.db.Exec(`
+	_, err := s.exec(`
 		INSERT INTO host_settings (host_id, rc_file_override, updated_at)
 		VALUES (?, ?, ?)
 		ON CONFLICT(host_id) DO UPDATE SET rc_file_override = ?, updated_at = ?`,
@@ -611,9 +1162,151 @@ func (s *Store) SetHostRcFile(hostID, rcFile string) error {
 	return nil
 }
 
+// GetHostBootTime returns the boot time recorded for a host at its last
+// connect, or the zero time if none was
+func (s *Store) GetHostBootTime(hostID string) (time.Time, error) {
+	var bootTime sql.NullInt64
+	err := s.db.QueryRow(`SELECT boot_time FROM host_settings WHERE host_id = ?`, hostID).Scan(&bootTime)
+	if err == sql.ErrNoRows || (err == nil && !bootTime.Valid) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get host boot time: %w", err)
+	}
+	return time.Unix(bootTime.Int64, 0), nil
+}
+
+// SetHostBootTime records the boot time a host reported on connect
+func (s *Store) SetHostBootTime(hostID string, bootTime time.Time) error {
+	now := time.Now().Unix()
+	_, err := s.exec(`
+		INSERT INTO host_settings (host_id, boot_time, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(host_id) DO UPDATE SET boot_time = ?, updated_at = ?`,
+		hostID, bootTime.Unix(), now, bootTime.Unix(), now)
+	if err != nil {
+		return fmt.Errorf("failed to set host boot time: %w", err)
+	}
+	return nil
+}
+
+// HostTmux selects the tmux server the bridge uses on a host, for accounts
+// shared by several users
+type HostTmux struct {
+	SocketPath string // Server socket; the default server when empty
+	Command    string // Program run instead of tmux; tmux from the PATH when empty
+}
+
+// GetHostTmux returns the tmux settings of a host, empty if none are set
+func (s *Store) GetHostTmux(hostID string) (HostTmux, error) {
+	var socketPath, command sql.NullString
+	err := s.db.QueryRow(`SELECT tmux_socket_path, tmux_command FROM host_settings WHERE host_id = ?`, hostID).
+		Scan(&socketPath, &command)
+	if err == sql.ErrNoRows {
+		return HostTmux{}, nil
+	}
+	if err != nil {
+		return HostTmux{}, fmt.Errorf("failed to get host tmux settings: %w", err)
+	}
+	return HostTmux{SocketPath: socketPath.String, Command: command.String}, nil
+}
+
+// SetHostTmux saves the tmux settings of a host
+func (s *Store) SetHostTmux(hostID string, tmux HostTmux) error {
+	now := time.Now().Unix()
+	socketPath, command := nullString(tmux.SocketPath), nullString(tmux.Command)
+	_, err := s.exec(`
+		INSERT INTO host_settings (host_id, tmux_socket_path, tmux_command, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(host_id) DO UPDATE SET tmux_socket_path = ?, tmux_command = ?, updated_at = ?`,
+		hostID, socketPath, command, now, socketPath, command, now)
+	if err != nil {
+		return fmt.Errorf("failed to set host tmux settings: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Set tmux for host %s to socket %q, command %q", hostID, tmux.SocketPath, tmux.Command)
+	return nil
+}
+
+// HostPlatform is what a host runs, as read at its last connect
+type HostPlatform struct {
+	OS          string `json:"os"`
+	Arch        string `json:"arch"`
+	Release     string `json:"release,omitempty"`
+	Shell       string `json:"shell,omitempty"`
+	TmuxVersion string `json:"tmuxVersion,omitempty"`
+	HasProc     bool   `json:"hasProc"`
+}
+
+// GetHostPlatform returns the platform recorded for a host at its last
+// connect, or nil if none was
+func (s *Store) GetHostPlatform(hostID string) (*HostPlatform, error) {
+	var platformJSON sql.NullString
+	err := s.db.QueryRow(`SELECT platform FROM host_settings WHERE host_id = ?`, hostID).Scan(&platformJSON)
+	if err == sql.ErrNoRows || (err == nil && !platformJSON.Valid) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host platform: %w", err)
+	}
+	var platform HostPlatform
+	if err := json.Unmarshal([]byte(platformJSON.String), &platform); err != nil {
+		return nil, fmt.Errorf("failed to parse host platform: %w", err)
+	}
+	return &platform, nil
+}
+
+// SetHostPlatform records the platform a host reported on connect
+func (s *Store) SetHostPlatform(hostID string, platform HostPlatform) error {
+	platformJSON, err := json.Marshal(platform)
+	if err != nil {
+		return fmt.Errorf("failed to marshal host platform: %w", err)
+	}
+	now := time.Now().Unix()
+	_, err = s.exec(`
+		INSERT INTO host_settings (host_id, platform, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(host_id) DO UPDATE SET platform = ?, updated_at = ?`,
+		hostID, string(platformJSON), now, string(platformJSON), now)
+	if err != nil {
+		return fmt.Errorf("failed to set host platform: %w", err)
+	}
+	return nil
+}
+
+// GetHostMaxConcurrentOps returns how many remote operations may run at
+// once on a host, or 0 if the host uses the bridge's limit
+func (s *Store) GetHostMaxConcurrentOps(hostID string) (int, error) {
+	var limit sql.NullInt64
+	err := s.db.QueryRow(`SELECT max_concurrent_ops FROM host_settings WHERE host_id = ?`, hostID).Scan(&limit)
+	if err == sql.ErrNoRows || (err == nil && !limit.Valid) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get host operation limit: %w", err)
+	}
+	return int(limit.Int64), nil
+}
+
+// SetHostMaxConcurrentOps saves a host's operation limit; 0 goes back to
+// the bridge's
+func (s *Store) SetHostMaxConcurrentOps(hostID string, limit int) error {
+	now := time.Now().Unix()
+	value := sql.NullInt64{Int64: int64(limit), Valid: limit > 0}
+	_, err := s.exec(`
+		INSERT INTO host_settings (host_id, max_concurrent_ops, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(host_id) DO UPDATE SET max_concurrent_ops = ?, updated_at = ?`,
+		hostID, value, now, value, now)
+	if err != nil {
+		return fmt.Errorf("failed to set host operation limit: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Set operation limit for host %s to %d", hostID, limit)
+	return nil
+}
+
 // DeleteHostSettings removes settings for a host
 func (s *Store) DeleteHostSettings(hostID string) error {
-	_, err := s.db.Exec(`DELETE FROM host_settings WHERE host_id = ?`, hostID)
+	_, err := s.exec(`DELETE FROM host_settings WHERE host_id = ?`, hostID)
 	if err != nil {
 		return fmt.Errorf("failed to delete host settings: %w", err)
 	}
@@ -632,21 +1325,24 @@ type SSHHost struct {
 	Host                string
 	Port                int
 	Username            string
-	AuthType            string // "password" or "key"
-	CredentialEncrypted []byte // encrypted password or private key
+	AuthType            string // "password", "key" or "agent"
+	CredentialEncrypted []byte // encrypted password or private key, or a reference to it
+	CredentialBackend   string // Where the credential lives, see crypto.CredentialStore
 	AutoConnect         bool
-	CreatedAt           time.Time
-	UpdatedAt           time.Time
+	Fingerprint         string // Identity of the machine last connected to, see ssh.Connection.Fingerprint
+	Appearance
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
 // CreateSSHHost creates a new SSH host configuration
 func (s *Store) CreateSSHHost(host SSHHost) error {
 	now := time.Now().Unix()
-	_, err := s.db.Exec(`
-		INSERT INTO ssh_hosts (id, name, host, port, username, auth_type, credential_encrypted, auto_connect, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+	_, err := s.exec(`
+		INSERT INTO ssh_hosts (id, name, host, port, username, auth_type, credential_encrypted, credential_backend, auto_connect, color, icon, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		host.ID, host.Name, host.Host, host.Port, host.Username, host.AuthType,
-		host.CredentialEncrypted, boolToInt(host.AutoConnect), now, now,
+		host.CredentialEncrypted, host.CredentialBackend, boolToInt(host.AutoConnect), nullString(host.Color), nullString(host.Icon), now, now,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create SSH host: %w", err)
@@ -658,15 +1354,16 @@ func (s *Store) CreateSSHHost(host SSHHost) error {
 // GetSSHHost retrieves a specific SSH host by ID
 func (s *Store) GetSSHHost(id string) (*SSHHost, error) {
 	row := s.db.QueryRow(`
-		SELECT id, name, host, port, username, auth_type, credential_encrypted, auto_connect, created_at, updated_at
+		SELECT id, name, host, port, username, auth_type, credential_encrypted, credential_backend, auto_connect, fingerprint, color, icon, created_at, updated_at
 		FROM ssh_hosts WHERE id = ?`, id)
 
 	var host SSHHost
 	var autoConnect int
+	var fingerprint, color, icon sql.NullString
 	var createdAt, updatedAt int64
 
 	err := row.Scan(&host.ID, &host.Name, &host.Host, &host.Port, &host.Username,
-		&host.AuthType, &host.CredentialEncrypted, &autoConnect, &createdAt, &updatedAt)
+		&host.AuthType, &host.CredentialEncrypted, &host.CredentialBackend, &autoConnect, &fingerprint, &color, &icon, &createdAt, &updatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -675,6 +1372,8 @@ func (s *Store) GetSSHHost(id string) (*SSHHost, error) {
 	}
 
 	host.AutoConnect = autoConnect != 0
+	host.Fingerprint = fingerprint.String
+	host.Color, host.Icon = color.String, icon.String
 	host.CreatedAt = time.Unix(createdAt, 0)
 	host.UpdatedAt = time.Unix(updatedAt, 0)
 
@@ -684,7 +1383,7 @@ func (s *Store) GetSSHHost(id string) (*SSHHost, error) {
 // ListSSHHosts returns all configured SSH hosts
 func (s *Store) ListSSHHosts() ([]SSHHost, error) {
 	rows, err := s.db.Query(`
-		SELECT id, name, host, port, username, auth_type, credential_encrypted, auto_connect, created_at, updated_at
+		SELECT id, name, host, port, username, auth_type, credential_encrypted, credential_backend, auto_connect, fingerprint, color, icon, created_at, updated_at
 		FROM ssh_hosts ORDER BY name`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list SSH hosts: %w", err)
@@ -695,14 +1394,17 @@ func (s *Store) ListSSHHosts() ([]SSHHost, error) {
 	for rows.Next() {
 		var host SSHHost
 		var autoConnect int
+		var fingerprint, color, icon sql.NullString
 		var createdAt, updatedAt int64
 
 		if err := rows.Scan(&host.ID, &host.Name, &host.Host, &host.Port, &host.Username,
-			&host.AuthType, &host.CredentialEncrypted, &autoConnect, &createdAt, &updatedAt); err != nil {
+			&host.AuthType, &host.CredentialEncrypted, &host.CredentialBackend, &autoConnect, &fingerprint, &color, &icon, &createdAt, &updatedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan SSH host: %w", err)
 		}
 
 		host.AutoConnect = autoConnect != 0
+		host.Fingerprint = fingerprint.String
+		host.Color, host.Icon = color.String, icon.String
 		host.CreatedAt = time.Unix(createdAt, 0)
 		host.UpdatedAt = time.Unix(updatedAt, 0)
 		hosts = append(hosts, host)
@@ -714,12 +1416,12 @@ func (s *Store) ListSSHHosts() ([]SSHHost, error) {
 // UpdateSSHHost updates an existing SSH host configuration
 func (s *Store) UpdateSSHHost(host SSHHost) error {
 	now := time.Now().Unix()
-	_, err := s.db.Exec(`
+	_, err := s.exec(`
 		UPDATE ssh_hosts
-		SET name = ?, host = ?, port = ?, username = ?, auth_type = ?, credential_encrypted = ?, auto_connect = ?, updated_at = ?
+		SET name = ?, host = ?, port = ?, username = ?, auth_type = ?, credential_encrypted = ?, credential_backend = ?, auto_connect = ?, color = ?, icon = ?, updated_at = ?
 		WHERE id = ?`,
 		host.Name, host.Host, host.Port, host.Username, host.AuthType,
-		host.CredentialEncrypted, boolToInt(host.AutoConnect), now, host.ID,
+		host.CredentialEncrypted, host.CredentialBackend, boolToInt(host.AutoConnect), nullString(host.Color), nullString(host.Icon), now, host.ID,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to update SSH host: %w", err)
@@ -728,9 +1430,31 @@ func (s *Store) UpdateSSHHost(host SSHHost) error {
 	return nil
 }
 
+// SetSSHHostCredential moves an SSH host's credential to another backend,
+// leaving the rest of its configuration and its updated time alone
+func (s *Store) SetSSHHostCredential(id, backend string, credential []byte) error {
+	_, err := s.exec(`UPDATE ssh_hosts SET credential_encrypted = ?, credential_backend = ? WHERE id = ?`, credential, backend, id)
+	if err != nil {
+		return fmt.Errorf("failed to set SSH host credential: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Moved credential of SSH host %s to %s", id, backend)
+	return nil
+}
+
+// SetSSHHostFingerprint records the identity of the machine an SSH host
+// connected to
+func (s *Store) SetSSHHostFingerprint(id, fingerprint string) error {
+	_, err := s.exec(`UPDATE ssh_hosts SET fingerprint = ? WHERE id = ?`, nullString(fingerprint), id)
+	if err != nil {
+		return fmt.Errorf("failed to set SSH host fingerprint: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Set fingerprint for SSH host %s", id)
+	return nil
+}
+
 // DeleteSSHHost removes an SSH host configuration
 func (s *Store) DeleteSSHHost(id string) error {
-	_, err := s.db.Exec(`DELETE FROM ssh_hosts WHERE id = ?`, id)
+	_, err := s.exec(`DELETE FROM ssh_hosts WHERE id = ?`, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete SSH host: %w", err)
 	}
@@ -764,7 +1488,7 @@ type Snippet struct {
 // CreateSnippet creates a new snippet
 func (s *Store) CreateSnippet(snippet Snippet) error {
 	now := time.Now().Unix()
-	_, err := s.db.Exec(`
+	_, err := s.exec(`
 		INSERT INTO snippets (id, name, content, created_at, updated_at)
 		VALUES (?, ?, ?, ?, ?)`,
 		snippet.ID, snippet.Name, snippet.Content, now, now,
@@ -829,7 +1553,7 @@ func (s *Store) ListSnippets() ([]Snippet, error) {
 // UpdateSnippet updates an existing snippet
 func (s *Store) UpdateSnippet(snippet Snippet) error {
 	now := time.Now().Unix()
-	_, err := s.db.Exec(`
+	_, err := s.exec(`
 		UPDATE snippets
 		SET name = ?, content = ?, updated_at = ?
 		WHERE id = ?`,
@@ -844,7 +1568,7 @@ func (s *Store) UpdateSnippet(snippet Snippet) error {
 
 // DeleteSnippet removes a snippet
 func (s *Store) DeleteSnippet(id string) error {
-	_, err := s.db.Exec(`DELETE FROM snippets WHERE id = ?`, id)
+	_, err := s.exec(`DELETE FROM snippets WHERE id = ?`, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete snippet: %w", err)
 	}
@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestStore opens a Store on a fresh database in a temp directory
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := NewStore(filepath.Join(t.TempDir(), "bridge.db"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestPersistLoopSurvivesPanic(t *testing.T) {
+	savedInterval, savedHook := persistInterval, persistCycleHook
+	t.Cleanup(func() { persistInterval, persistCycleHook = savedInterval, savedHook })
+
+	// The first cycle panics, and so does every cycle once failing is set
+	var cycles, failing int32
+	persistInterval = 10 * time.Millisecond
+	persistCycleHook = func() {
+		if atomic.AddInt32(&cycles, 1) == 1 || atomic.LoadInt32(&failing) == 1 {
+			panic("nil map")
+		}
+	}
+
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	s.AppendPtyOutput("proc-1", "host-1", []byte("before "))
+
+	// A cycle after the panic persists the buffer
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stored, _ := s.getPtyHistoryFromDB("proc-1")
+		if string(stored) == "before " {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("buffer not persisted after the panic (%d cycles, stored %q)", atomic.LoadInt32(&cycles), stored)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	stats := s.Stats()
+	if stats.LoopRestarts != 1 || !strings.Contains(stats.LastError, "nil map") {
+		t.Errorf("stats = %+v, want one restart and the panic as last error", stats)
+	}
+	if stats.LastSuccessfulPersist == nil || !stats.Healthy {
+		t.Errorf("stats = %+v, want a successful persist and healthy", stats)
+	}
+
+	// Output written while cycles keep failing is flushed on close
+	atomic.StoreInt32(&failing, 1)
+	s.AppendPtyOutput("proc-1", "host-1", []byte("after"))
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	reopened, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer reopened.Close()
+	if stored, _ := reopened.getPtyHistoryFromDB("proc-1"); string(stored) != "before after" {
+		t.Errorf("stored history = %q, want %q", stored, "before after")
+	}
+}
+
+func TestStatsUnhealthyWithoutPersist(t *testing.T) {
+	s := newTestStore(t)
+	if !s.Stats().Healthy {
+		t.Error("new store unhealthy")
+	}
+	s.statsMu.Lock()
+	s.started = time.Now().Add(-4 * persistInterval)
+	s.statsMu.Unlock()
+	if s.Stats().Healthy {
+		t.Error("store healthy with no persist in 4 intervals")
+	}
+	s.recordPersist(nil)
+	if !s.Stats().Healthy {
+		t.Error("store unhealthy right after a persist")
+	}
+}
+
+func TestProcessClaudeCWD(t *testing.T) {
+	// A database from before claude_cwd existed
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	old, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	if _, err := old.Exec(`CREATE TABLE process_metadata (process_id TEXT PRIMARY KEY, host_id TEXT NOT NULL,
+		process_type TEXT NOT NULL, port INTEGER, tmux_name TEXT NOT NULL, started_at INTEGER NOT NULL, last_seen_at INTEGER NOT NULL)`); err != nil {
+		t.Fatalf("create old schema: %v", err)
+	}
+	if _, err := old.Exec(`INSERT INTO process_metadata VALUES ('proc-1', 'host-1', 'claude', 3284, 'rc-proc-1', 0, 0)`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	old.Close()
+
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+
+	meta, err := s.GetProcessMetadata("proc-1")
+	if err != nil || meta == nil {
+		t.Fatalf("GetProcessMetadata: %v", err)
+	}
+	if meta.ClaudeCWD != "" {
+		t.Errorf("migrated claude cwd = %q, want empty", meta.ClaudeCWD)
+	}
+
+	if err := s.UpdateProcessClaudeCWD("proc-1", "/work/repo"); err != nil {
+		t.Fatalf("UpdateProcessClaudeCWD: %v", err)
+	}
+	metas, err := s.GetProcessMetadataByHost("host-1")
+	if err != nil || len(metas) != 1 || metas[0].ClaudeCWD != "/work/repo" {
+		t.Fatalf("GetProcessMetadataByHost = %+v, %v", metas, err)
+	}
+
+	// Saving the whole record keeps it, clearing removes it
+	meta.ClaudeCWD = "/work/other"
+	if err := s.SaveProcessMetadata(*meta); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	if meta, _ = s.GetProcessMetadata("proc-1"); meta.ClaudeCWD != "/work/other" {
+		t.Errorf("saved claude cwd = %q", meta.ClaudeCWD)
+	}
+	if err := s.UpdateProcessClaudeCWD("proc-1", ""); err != nil {
+		t.Fatalf("UpdateProcessClaudeCWD: %v", err)
+	}
+	if meta, _ = s.GetProcessMetadata("proc-1"); meta.ClaudeCWD != "" {
+		t.Errorf("cleared claude cwd = %q", meta.ClaudeCWD)
+	}
+
+	// The session ID Claude was started with, added later still
+	if meta.ClaudeSessionID != "" {
+		t.Errorf("migrated claude session id = %q, want empty", meta.ClaudeSessionID)
+	}
+	if err := s.UpdateProcessClaudeSessionID("proc-1", "0b6f3c9e-5d2a-4c1b-9f0e-3a7d8c2b1e4f"); err != nil {
+		t.Fatalf("UpdateProcessClaudeSessionID: %v", err)
+	}
+	if err := s.SaveProcessMetadata(*meta); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	metas, err = s.GetProcessMetadataByHost("host-1")
+	if err != nil || len(metas) != 1 || metas[0].ClaudeSessionID != "0b6f3c9e-5d2a-4c1b-9f0e-3a7d8c2b1e4f" {
+		t.Errorf("claude session id after a save = %+v, %v", metas, err)
+	}
+}
+
+func TestHostBootTime(t *testing.T) {
+	// A database from before boot_time existed
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	old, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	if _, err := old.Exec(`CREATE TABLE host_settings (host_id TEXT PRIMARY KEY, rc_file_override TEXT, updated_at INTEGER NOT NULL)`); err != nil {
+		t.Fatalf("create old schema: %v", err)
+	}
+	if _, err := old.Exec(`INSERT INTO host_settings VALUES ('host-1', '~/.zshrc', 0)`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	old.Close()
+
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+
+	for _, hostID := range []string{"host-1", "host-2"} {
+		if bootTime, err := s.GetHostBootTime(hostID); err != nil || !bootTime.IsZero() {
+			t.Errorf("%s: boot time before one was recorded = %v, %v", hostID, bootTime, err)
+		}
+	}
+
+	booted := time.Unix(1700000000, 0)
+	if err := s.SetHostBootTime("host-1", booted); err != nil {
+		t.Fatalf("SetHostBootTime: %v", err)
+	}
+	if bootTime, err := s.GetHostBootTime("host-1"); err != nil || !bootTime.Equal(booted) {
+		t.Errorf("boot time = %v, %v; want %v", bootTime, err, booted)
+	}
+	if rcFile, _ := s.GetHostRcFile("host-1"); rcFile != "~/.zshrc" {
+		t.Errorf("rc file = %q after recording the boot time", rcFile)
+	}
+}
+
+func TestHostTmux(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.SetHostRcFile("host-1", "~/.zshrc"); err != nil {
+		t.Fatalf("SetHostRcFile: %v", err)
+	}
+	for _, hostID := range []string{"host-1", "host-2"} {
+		if tmux, err := s.GetHostTmux(hostID); err != nil || tmux != (HostTmux{}) {
+			t.Errorf("%s: tmux before any was set = %+v, %v", hostID, tmux, err)
+		}
+	}
+
+	shared := HostTmux{SocketPath: "/srv/shared/tmux.sock", Command: "/opt/tmux/bin/tmux"}
+	if err := s.SetHostTmux("host-1", shared); err != nil {
+		t.Fatalf("SetHostTmux: %v", err)
+	}
+	if tmux, err := s.GetHostTmux("host-1"); err != nil || tmux != shared {
+		t.Errorf("tmux = %+v, %v; want %+v", tmux, err, shared)
+	}
+	if rcFile, _ := s.GetHostRcFile("host-1"); rcFile != "~/.zshrc" {
+		t.Errorf("rc file = %q after setting tmux", rcFile)
+	}
+
+	// Clearing the socket keeps the command
+	if err := s.SetHostTmux("host-1", HostTmux{Command: shared.Command}); err != nil {
+		t.Fatalf("SetHostTmux: %v", err)
+	}
+	if tmux, _ := s.GetHostTmux("host-1"); tmux != (HostTmux{Command: shared.Command}) {
+		t.Errorf("tmux = %+v after clearing the socket", tmux)
+	}
+}
+
+func TestHostPlatform(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.SetHostRcFile("host-1", "~/.zshrc"); err != nil {
+		t.Fatalf("SetHostRcFile: %v", err)
+	}
+	for _, hostID := range []string{"host-1", "host-2"} {
+		if platform, err := s.GetHostPlatform(hostID); err != nil || platform != nil {
+			t.Errorf("%s: platform before one was recorded = %+v, %v", hostID, platform, err)
+		}
+	}
+
+	mac := HostPlatform{OS: "Darwin", Arch: "arm64", Release: "macOS 14.5", Shell: "/bin/zsh", TmuxVersion: "3.4"}
+	if err := s.SetHostPlatform("host-1", mac); err != nil {
+		t.Fatalf("SetHostPlatform: %v", err)
+	}
+	if platform, err := s.GetHostPlatform("host-1"); err != nil || platform == nil || *platform != mac {
+		t.Errorf("platform = %+v, %v; want %+v", platform, err, mac)
+	}
+	if rcFile, _ := s.GetHostRcFile("host-1"); rcFile != "~/.zshrc" {
+		t.Errorf("rc file = %q after recording the platform", rcFile)
+	}
+}
+
+func TestDeleteHostProcessMetadata(t *testing.T) {
+	s := newTestStore(t)
+	for _, meta := range []ProcessMetadata{
+		{ProcessID: "proc-1", HostID: "host-1", ProcessType: "shell", TmuxName: "rc-proc-1"},
+		{ProcessID: "proc-2", HostID: "host-1", ProcessType: "claude", TmuxName: "rc-proc-2"},
+		{ProcessID: "proc-3", HostID: "host-2", ProcessType: "shell", TmuxName: "rc-proc-3"},
+	} {
+		if err := s.SaveProcessMetadata(meta); err != nil {
+			t.Fatalf("SaveProcessMetadata: %v", err)
+		}
+	}
+
+	deleted, err := s.DeleteHostProcessMetadata("host-1")
+	if err != nil || len(deleted) != 2 {
+		t.Fatalf("DeleteHostProcessMetadata = %v, %v", deleted, err)
+	}
+	if metas, _ := s.GetProcessMetadataByHost("host-1"); len(metas) != 0 {
+		t.Errorf("host-1 metadata left: %+v", metas)
+	}
+	if metas, _ := s.GetProcessMetadataByHost("host-2"); len(metas) != 1 {
+		t.Errorf("host-2 metadata = %+v", metas)
+	}
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ProcessTemplate is a saved recipe for a new process: where its shell
+// starts, with what environment, and how Claude is started in it
+type ProcessTemplate struct {
+	ID              string
+	Name            string
+	HostID          string // Host the template is for; any host when empty
+	CWD             string
+	EnvVars         []EnvVar
+	Shell           string // Command the pane runs instead of the login shell
+	ClaudeArgs      string
+	AutoStartClaude bool
+	StartupHooks    []string // Typed into the shell after the host's, in order
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+const templateColumns = `id, name, host_id, cwd, env_vars, shell, claude_args, auto_start_claude, startup_hooks, created_at, updated_at`
+
+// CreateProcessTemplate saves a new process template
+func (s *Store) CreateProcessTemplate(tmpl ProcessTemplate) error {
+	envVarsJSON, err := templateEnvJSON(tmpl.EnvVars)
+	if err != nil {
+		return err
+	}
+	hooksJSON, err := startupHooksJSON(tmpl.StartupHooks)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	_, err = s.exec(`
+		INSERT INTO process_templates (`+templateColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		tmpl.ID, tmpl.Name, nullString(tmpl.HostID), nullString(tmpl.CWD), envVarsJSON,
+		nullString(tmpl.Shell), nullString(tmpl.ClaudeArgs), boolToInt(tmpl.AutoStartClaude), hooksJSON, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create process template: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Created process template %s (%s)", tmpl.ID, tmpl.Name)
+	return nil
+}
+
+// GetProcessTemplate retrieves a process template, or nil if there is none
+func (s *Store) GetProcessTemplate(id string) (*ProcessTemplate, error) {
+	row := s.db.QueryRow(`SELECT `+templateColumns+` FROM process_templates WHERE id = ?`, id)
+	tmpl, err := scanProcessTemplate(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get process template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// ListProcessTemplates returns all process templates ordered by name
+func (s *Store) ListProcessTemplates() ([]ProcessTemplate, error) {
+	rows, err := s.db.Query(`SELECT ` + templateColumns + ` FROM process_templates ORDER BY name, id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list process templates: %w", err)
+	}
+	defer rows.Close()
+
+	templates := []ProcessTemplate{}
+	for rows.Next() {
+		tmpl, err := scanProcessTemplate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan process template: %w", err)
+		}
+		templates = append(templates, *tmpl)
+	}
+	return templates, rows.Err()
+}
+
+// UpdateProcessTemplate replaces every field of an existing template
+func (s *Store) UpdateProcessTemplate(tmpl ProcessTemplate) error {
+	envVarsJSON, err := templateEnvJSON(tmpl.EnvVars)
+	if err != nil {
+		return err
+	}
+	hooksJSON, err := startupHooksJSON(tmpl.StartupHooks)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(`
+		UPDATE process_templates
+		SET name = ?, host_id = ?, cwd = ?, env_vars = ?, shell = ?, claude_args = ?, auto_start_claude = ?, startup_hooks = ?, updated_at = ?
+		WHERE id = ?`,
+		tmpl.Name, nullString(tmpl.HostID), nullString(tmpl.CWD), envVarsJSON, nullString(tmpl.Shell),
+		nullString(tmpl.ClaudeArgs), boolToInt(tmpl.AutoStartClaude), hooksJSON, time.Now().Unix(), tmpl.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update process template: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Updated process template %s (%s)", tmpl.ID, tmpl.Name)
+	return nil
+}
+
+// DeleteProcessTemplate removes a process template
+func (s *Store) DeleteProcessTemplate(id string) error {
+	if _, err := s.exec(`DELETE FROM process_templates WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete process template: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Deleted process template %s", id)
+	return nil
+}
+
+// templateEnvJSON serializes a template's env vars, NULL when there are none
+func templateEnvJSON(vars []EnvVar) (interface{}, error) {
+	if len(vars) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(vars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal template env vars: %w", err)
+	}
+	return string(data), nil
+}
+
+// scanProcessTemplate reads a row of templateColumns
+func scanProcessTemplate(row interface{ Scan(...interface{}) error }) (*ProcessTemplate, error) {
+	var tmpl ProcessTemplate
+	var hostID, cwd, envVarsJSON, shell, claudeArgs, hooksJSON sql.NullString
+	var createdAt, updatedAt int64
+	if err := row.Scan(&tmpl.ID, &tmpl.Name, &hostID, &cwd, &envVarsJSON, &shell, &claudeArgs,
+		&tmpl.AutoStartClaude, &hooksJSON, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	tmpl.HostID = hostID.String
+	tmpl.CWD = cwd.String
+	tmpl.Shell = shell.String
+	tmpl.ClaudeArgs = claudeArgs.String
+	if envVarsJSON.Valid && envVarsJSON.String != "" {
+		if err := json.Unmarshal([]byte(envVarsJSON.String), &tmpl.EnvVars); err != nil {
+			return nil, fmt.Errorf("template %s has invalid env vars: %w", tmpl.ID, err)
+		}
+	}
+	hooks, err := decodeStartupHooks(hooksJSON)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %w", tmpl.ID, err)
+	}
+	tmpl.StartupHooks = hooks
+	tmpl.CreatedAt = time.Unix(createdAt, 0)
+	tmpl.UpdatedAt = time.Unix(updatedAt, 0)
+	return &tmpl, nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProcessTemplateCRUD(t *testing.T) {
+	s := newTestStore(t)
+
+	api := ProcessTemplate{
+		ID:              "tmpl-1",
+		Name:            "api",
+		CWD:             "~/work/api",
+		EnvVars:         []EnvVar{{Key: "STAGING", Value: "1"}},
+		ClaudeArgs:      "--model sonnet",
+		AutoStartClaude: true,
+		StartupHooks:    []string{"source .venv/bin/activate", "make deps"},
+	}
+	if err := s.CreateProcessTemplate(api); err != nil {
+		t.Fatalf("CreateProcessTemplate: %v", err)
+	}
+	if err := s.CreateProcessTemplate(ProcessTemplate{ID: "tmpl-2", Name: "admin shell", HostID: "host-1", Shell: "zsh -l"}); err != nil {
+		t.Fatalf("CreateProcessTemplate: %v", err)
+	}
+
+	got, err := s.GetProcessTemplate("tmpl-1")
+	if err != nil || got == nil {
+		t.Fatalf("GetProcessTemplate: %v, %v", got, err)
+	}
+	if got.CreatedAt.IsZero() {
+		t.Error("CreatedAt not set")
+	}
+	got.CreatedAt, got.UpdatedAt = api.CreatedAt, api.UpdatedAt
+	if !reflect.DeepEqual(*got, api) {
+		t.Errorf("template = %+v, want %+v", *got, api)
+	}
+
+	// Updates replace every field, clearing the ones left empty
+	api.Name, api.EnvVars, api.AutoStartClaude, api.StartupHooks = "api (prod)", nil, false, nil
+	if err := s.UpdateProcessTemplate(api); err != nil {
+		t.Fatalf("UpdateProcessTemplate: %v", err)
+	}
+	templates, err := s.ListProcessTemplates()
+	if err != nil {
+		t.Fatalf("ListProcessTemplates: %v", err)
+	}
+	if len(templates) != 2 || templates[0].Name != "admin shell" || templates[1].Name != "api (prod)" {
+		t.Fatalf("templates = %+v", templates)
+	}
+	if templates[1].EnvVars != nil || templates[1].AutoStartClaude || templates[1].StartupHooks != nil || templates[0].HostID != "host-1" || templates[0].Shell != "zsh -l" {
+		t.Errorf("templates = %+v", templates)
+	}
+
+	if err := s.DeleteProcessTemplate("tmpl-1"); err != nil {
+		t.Fatalf("DeleteProcessTemplate: %v", err)
+	}
+	if missing, err := s.GetProcessTemplate("tmpl-1"); err != nil || missing != nil {
+		t.Errorf("deleted template = %v, %v", missing, err)
+	}
+}
+
+func TestProcessTemplatesAddedToOldDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bridge.db")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if _, err := s.db.Exec(`DROP TABLE process_templates`); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore on a database without templates: %v", err)
+	}
+	defer s.Close()
+	if templates, err := s.ListProcessTemplates(); err != nil || len(templates) != 0 {
+		t.Errorf("ListProcessTemplates = %v, %v", templates, err)
+	}
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// SetProcessTermOptions saves the terminal options chosen for a process's
+// tmux session, replacing the previous ones. Empty options clear them.
+func (s *Store) SetProcessTermOptions(processID string, options map[string]string) error {
+	var value *string
+	if len(options) > 0 {
+		data, err := json.Marshal(options)
+		if err != nil {
+			return fmt.Errorf("failed to marshal terminal options: %w", err)
+		}
+		str := string(data)
+		value = &str
+	}
+	if _, err := s.exec(`UPDATE process_metadata SET term_options = ? WHERE process_id = ?`, value, processID); err != nil {
+		return fmt.Errorf("failed to update terminal options: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Set terminal options of process %s: %v", processID, options)
+	return nil
+}
+
+// parseTermOptions decodes the term_options column of a process
+func parseTermOptions(processID string, value sql.NullString) map[string]string {
+	if !value.Valid || value.String == "" {
+		return nil
+	}
+	var options map[string]string
+	if err := json.Unmarshal([]byte(value.String), &options); err != nil {
+		log.Printf("[WARN] [Storage] Failed to unmarshal terminal options for process %s: %v", processID, err)
+		return nil
+	}
+	return options
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestProcessTermOptions(t *testing.T) {
+	s := newTestStore(t)
+	meta := ProcessMetadata{ProcessID: "proc-1", HostID: "host-1", ProcessType: "shell", TmuxName: "rc-proc-1", StartedAt: time.Now()}
+	if err := s.SaveProcessMetadata(meta); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+
+	options := map[string]string{"status": "on", "history-limit": "5000"}
+	if err := s.SetProcessTermOptions("proc-1", options); err != nil {
+		t.Fatalf("SetProcessTermOptions: %v", err)
+	}
+
+	// Saving the metadata again keeps them
+	if err := s.SaveProcessMetadata(meta); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	got, err := s.GetProcessMetadata("proc-1")
+	if err != nil || got == nil || !maps.Equal(got.TermOptions, options) {
+		t.Errorf("GetProcessMetadata = %+v, %v; want options %v", got, err, options)
+	}
+	metas, err := s.GetProcessMetadataByHost("host-1")
+	if err != nil || len(metas) != 1 || !maps.Equal(metas[0].TermOptions, options) {
+		t.Errorf("GetProcessMetadataByHost = %+v, %v", metas, err)
+	}
+
+	if err := s.SetProcessTermOptions("proc-1", nil); err != nil {
+		t.Fatalf("SetProcessTermOptions: %v", err)
+	}
+	if got, err := s.GetProcessMetadata("proc-1"); err != nil || got.TermOptions != nil {
+		t.Errorf("cleared options = %v, %v", got.TermOptions, err)
+	}
+}
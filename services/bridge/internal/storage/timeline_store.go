@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Page sizes of ListTimelineCommands
+const (
+	defaultTimelineLimit = 50
+	maxTimelineLimit     = 500
+)
+
+// maxTimelineCommands is how many commands are kept per process; older
+// ones are dropped as new ones start
+const maxTimelineCommands = 10000
+
+// TimelineCommand is a command run in a process's shell
+type TimelineCommand struct {
+	ID        int64
+	ProcessID string
+	Command   string // Empty when the shell didn't report it
+	StartedAt time.Time
+	EndedAt   *time.Time // Nil while the command runs, or if its end was missed
+	ExitCode  *int       // Nil when the shell didn't report one
+}
+
+// SetProcessTimeline records whether a process's command timeline is on
+func (s *Store) SetProcessTimeline(processID string, enabled bool) error {
+	if _, err := s.exec(`UPDATE process_metadata SET timeline = ? WHERE process_id = ?`, boolToInt(enabled), processID); err != nil {
+		return fmt.Errorf("failed to update process timeline: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Set timeline of process %s: %v", processID, enabled)
+	return nil
+}
+
+// StartTimelineCommand adds a command that started running in a process
+func (s *Store) StartTimelineCommand(processID, command string, startedAt time.Time) error {
+	_, err := s.exec(`
+		INSERT INTO command_timeline (process_id, command_text, started_at)
+		VALUES (?, ?, ?)`,
+		processID, command, startedAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to add timeline command: %w", err)
+	}
+	_, err = s.exec(`
+		DELETE FROM command_timeline
+		WHERE process_id = ? AND id <= (
+			SELECT id FROM command_timeline WHERE process_id = ?
+			ORDER BY id DESC LIMIT 1 OFFSET ?)`,
+		processID, processID, maxTimelineCommands)
+	if err != nil {
+		return fmt.Errorf("failed to trim timeline: %w", err)
+	}
+	return nil
+}
+
+// FinishTimelineCommand records the end of the last command started in a
+// process, if it is still running
+func (s *Store) FinishTimelineCommand(processID string, endedAt time.Time, exitCode *int) error {
+	_, err := s.exec(`
+		UPDATE command_timeline SET ended_at = ?, exit_code = ?
+		WHERE id = (SELECT MAX(id) FROM command_timeline WHERE process_id = ?) AND ended_at IS NULL`,
+		endedAt.UnixMilli(), exitCode, processID)
+	if err != nil {
+		return fmt.Errorf("failed to finish timeline command: %w", err)
+	}
+	return nil
+}
+
+// ListTimelineCommands returns a page of the commands run in a process,
+// newest first, starting before the command with ID before (from the newest
+// when 0). It also reports whether older commands remain.
+func (s *Store) ListTimelineCommands(processID string, before int64, limit int) ([]TimelineCommand, bool, error) {
+	if limit <= 0 {
+		limit = defaultTimelineLimit
+	}
+	limit = min(limit, maxTimelineLimit)
+
+	query := `
+		SELECT id, command_text, started_at, ended_at, exit_code FROM command_timeline
+		WHERE process_id = ?`
+	args := []interface{}{processID}
+	if before > 0 {
+		query += ` AND id < ?`
+		args = append(args, before)
+	}
+	// One extra row tells whether there is another page
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list timeline: %w", err)
+	}
+	defer rows.Close()
+
+	commands := []TimelineCommand{}
+	for rows.Next() {
+		cmd := TimelineCommand{ProcessID: processID}
+		var startedAt int64
+		var endedAt, exitCode sql.NullInt64
+		if err := rows.Scan(&cmd.ID, &cmd.Command, &startedAt, &endedAt, &exitCode); err != nil {
+			return nil, false, fmt.Errorf("failed to scan timeline command: %w", err)
+		}
+		cmd.StartedAt = time.UnixMilli(startedAt)
+		if endedAt.Valid {
+			t := time.UnixMilli(endedAt.Int64)
+			cmd.EndedAt = &t
+		}
+		if exitCode.Valid {
+			code := int(exitCode.Int64)
+			cmd.ExitCode = &code
+		}
+		commands = append(commands, cmd)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to list timeline: %w", err)
+	}
+
+	hasMore := len(commands) > limit
+	if hasMore {
+		commands = commands[:limit]
+	}
+	return commands, hasMore, nil
+}
+
+// ClearTimeline removes the command timeline of a process
+func (s *Store) ClearTimeline(processID string) error {
+	if _, err := s.exec(`DELETE FROM command_timeline WHERE process_id = ?`, processID); err != nil {
+		return fmt.Errorf("failed to clear timeline: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Cleared timeline for process %s", processID)
+	return nil
+}
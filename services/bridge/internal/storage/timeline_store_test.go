@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimelineCommands(t *testing.T) {
+	s := newTestStore(t)
+	start := time.UnixMilli(1700000000000)
+
+	// Ending with nothing running does nothing
+	if err := s.FinishTimelineCommand("proc-1", start, nil); err != nil {
+		t.Fatalf("FinishTimelineCommand: %v", err)
+	}
+	for i, command := range []string{"make", "make test", "", "git status", "vim"} {
+		if err := s.StartTimelineCommand("proc-1", command, start.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("StartTimelineCommand: %v", err)
+		}
+		if command == "vim" {
+			break
+		}
+		code := i
+		if err := s.FinishTimelineCommand("proc-1", start.Add(time.Duration(i)*time.Second+500*time.Millisecond), &code); err != nil {
+			t.Fatalf("FinishTimelineCommand: %v", err)
+		}
+	}
+	if err := s.StartTimelineCommand("proc-2", "ls", start); err != nil {
+		t.Fatalf("StartTimelineCommand: %v", err)
+	}
+
+	page, hasMore, err := s.ListTimelineCommands("proc-1", 0, 2)
+	if err != nil || !hasMore || len(page) != 2 {
+		t.Fatalf("first page = %+v, %v, %v", page, hasMore, err)
+	}
+	if running := page[0]; running.Command != "vim" || running.EndedAt != nil || running.ExitCode != nil {
+		t.Errorf("running command = %+v", running)
+	}
+	if done := page[1]; done.Command != "git status" || done.EndedAt == nil || done.EndedAt.Sub(done.StartedAt) != 500*time.Millisecond ||
+		done.ExitCode == nil || *done.ExitCode != 3 {
+		t.Errorf("finished command = %+v", done)
+	}
+
+	page, hasMore, err = s.ListTimelineCommands("proc-1", page[1].ID, 2)
+	if err != nil || !hasMore || len(page) != 2 || page[0].Command != "" || page[1].Command != "make test" {
+		t.Fatalf("second page = %+v, %v, %v", page, hasMore, err)
+	}
+	page, hasMore, err = s.ListTimelineCommands("proc-1", page[1].ID, 2)
+	if err != nil || hasMore || len(page) != 1 || page[0].Command != "make" {
+		t.Fatalf("last page = %+v, %v, %v", page, hasMore, err)
+	}
+
+	// Finishing only touches the last command, once
+	code := 9
+	if err := s.FinishTimelineCommand("proc-1", start.Add(time.Minute), &code); err != nil {
+		t.Fatalf("FinishTimelineCommand: %v", err)
+	}
+	if err := s.FinishTimelineCommand("proc-1", start.Add(time.Hour), nil); err != nil {
+		t.Fatalf("FinishTimelineCommand: %v", err)
+	}
+	page, _, _ = s.ListTimelineCommands("proc-1", 0, 0)
+	if len(page) != 5 || page[0].ExitCode == nil || *page[0].ExitCode != 9 || !page[0].EndedAt.Equal(start.Add(time.Minute)) ||
+		*page[1].ExitCode != 3 {
+		t.Errorf("after finishing: %+v", page)
+	}
+
+	if err := s.ClearTimeline("proc-1"); err != nil {
+		t.Fatalf("ClearTimeline: %v", err)
+	}
+	if page, _, _ := s.ListTimelineCommands("proc-1", 0, 0); len(page) != 0 {
+		t.Errorf("cleared timeline = %+v", page)
+	}
+	if page, _, _ := s.ListTimelineCommands("proc-2", 0, 0); len(page) != 1 {
+		t.Errorf("other process's timeline = %+v", page)
+	}
+}
+
+func TestProcessTimelineFlag(t *testing.T) {
+	s := newTestStore(t)
+	meta := ProcessMetadata{ProcessID: "proc-1", HostID: "host-1", ProcessType: "shell", TmuxName: "rc-proc-1", StartedAt: time.Now()}
+	if err := s.SaveProcessMetadata(meta); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	if err := s.SetProcessTimeline("proc-1", true); err != nil {
+		t.Fatalf("SetProcessTimeline: %v", err)
+	}
+
+	// Saving the metadata again keeps it
+	if err := s.SaveProcessMetadata(meta); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	if got, err := s.GetProcessMetadata("proc-1"); err != nil || !got.Timeline {
+		t.Errorf("GetProcessMetadata = %+v, %v; want the timeline on", got, err)
+	}
+	if metas, err := s.GetProcessMetadataByHost("host-1"); err != nil || len(metas) != 1 || !metas[0].Timeline {
+		t.Errorf("GetProcessMetadataByHost = %+v, %v", metas, err)
+	}
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// UpdateProcessUsage caches the last usage report of a process, an opaque
+// JSON document, so it can still be shown once the process is gone
+func (s *Store) UpdateProcessUsage(processID string, usage json.RawMessage) error {
+	now := time.Now()
+	_, err := s.exec(`
+		UPDATE process_metadata
+		SET usage = ?, usage_updated_at = ?
+		WHERE process_id = ?`,
+		string(usage), now.UnixMilli(), processID)
+	if err != nil {
+		return fmt.Errorf("failed to update process usage: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Cached usage for process %s", processID)
+	return nil
+}
+
+// GetProcessUsage returns the cached usage report of a process and when it
+// was cached, or nil if there is none
+func (s *Store) GetProcessUsage(processID string) (json.RawMessage, time.Time, error) {
+	var usage sql.NullString
+	var updatedAt sql.NullInt64
+	err := s.db.QueryRow(`
+		SELECT usage, usage_updated_at FROM process_metadata
+		WHERE process_id = ?`, processID).Scan(&usage, &updatedAt)
+	if err == sql.ErrNoRows || err == nil && !usage.Valid {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to get process usage: %w", err)
+	}
+	return json.RawMessage(usage.String), time.UnixMilli(updatedAt.Int64), nil
+}
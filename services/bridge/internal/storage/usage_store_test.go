@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestProcessUsage(t *testing.T) {
+	s := newTestStore(t)
+	meta := ProcessMetadata{ProcessID: "proc-1", HostID: "host-1", ProcessType: "claude", TmuxName: "rc-proc-1", StartedAt: time.Now()}
+	if err := s.SaveProcessMetadata(meta); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+
+	if usage, _, err := s.GetProcessUsage("proc-1"); err != nil || usage != nil {
+		t.Errorf("before caching: %s, %v; want nothing", usage, err)
+	}
+
+	before := time.Now().Truncate(time.Millisecond)
+	if err := s.UpdateProcessUsage("proc-1", json.RawMessage(`{"messageCount":3}`)); err != nil {
+		t.Fatalf("UpdateProcessUsage: %v", err)
+	}
+
+	// Saving the process again keeps the cached usage
+	if err := s.SaveProcessMetadata(meta); err != nil {
+		t.Fatalf("SaveProcessMetadata: %v", err)
+	}
+	usage, updatedAt, err := s.GetProcessUsage("proc-1")
+	if err != nil || string(usage) != `{"messageCount":3}` {
+		t.Errorf("GetProcessUsage = %s, %v", usage, err)
+	}
+	if updatedAt.Before(before) || updatedAt.After(time.Now()) {
+		t.Errorf("updatedAt = %v, want about now", updatedAt)
+	}
+
+	if usage, _, err := s.GetProcessUsage("missing"); err != nil || usage != nil {
+		t.Errorf("missing process: %s, %v; want nothing", usage, err)
+	}
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// walCheckpointThreshold is the WAL file size above which the persist loop
+// checkpoints it into the database and truncates it. SQLite's automatic
+// checkpoints never shrink the file, and under constant writes they rarely
+// get to copy all of it back.
+var walCheckpointThreshold int64 = 64 << 20
+
+// checkpointFailuresWarn is how many checkpoints in a row must fail to
+// finish before each further failure is logged as a warning; a checkpoint
+// that keeps failing is usually blocked by a reader that never ends
+const checkpointFailuresWarn = 3
+
+// walPath returns the path of the database's write-ahead log
+func (s *Store) walPath() string {
+	return s.dbPath + "-wal"
+}
+
+// fileSize returns the size of a file, or 0 if it doesn't exist
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// checkpointIfLarge checkpoints the WAL once it has grown past
+// walCheckpointThreshold
+func (s *Store) checkpointIfLarge() {
+	size := fileSize(s.walPath())
+	if size <= walCheckpointThreshold {
+		return
+	}
+	log.Printf("[DEBUG] [Storage] WAL is %d bytes, checkpointing", size)
+	if err := s.Checkpoint(); err != nil {
+		log.Printf("[WARN] [Storage] WAL checkpoint failed: %v", err)
+	}
+}
+
+// Checkpoint copies every frame of the WAL into the database and truncates
+// the WAL file. It fails when a reader still needs frames the checkpoint
+// would overwrite; the frames are then left for the next one.
+func (s *Store) Checkpoint() error {
+	var busy, logFrames, checkpointed int
+	err := s.db.QueryRow(`PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logFrames, &checkpointed)
+	if err == nil && busy != 0 {
+		err = fmt.Errorf("blocked by another connection (%d of %d frames checkpointed)", checkpointed, logFrames)
+	}
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if err != nil {
+		s.checkpointFailures++
+		if s.checkpointFailures >= checkpointFailuresWarn {
+			log.Printf("[WARN] [Storage] WAL checkpoint failed %d times in a row, a reader may be stuck: %v", s.checkpointFailures, err)
+		}
+		return err
+	}
+	s.checkpointFailures = 0
+	s.lastCheckpoint = time.Now()
+	return nil
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+// writePtyOutput persists n bytes of PTY output for a process
+func writePtyOutput(t *testing.T, s *Store, processID string, n int) {
+	t.Helper()
+	chunk := bytes.Repeat([]byte("x"), 4096)
+	for written := 0; written < n; written += len(chunk) {
+		s.AppendPtyOutput(processID, "host-1", chunk)
+	}
+	if err := s.PersistAll(); err != nil {
+		t.Fatalf("PersistAll: %v", err)
+	}
+}
+
+func TestCheckpointTruncatesLargeWAL(t *testing.T) {
+	saved := walCheckpointThreshold
+	t.Cleanup(func() { walCheckpointThreshold = saved })
+	walCheckpointThreshold = 1 << 20
+
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	// Below the threshold the WAL is left alone
+	writePtyOutput(t, s, "proc-1", 64<<10)
+	small := s.Stats().WALBytes
+	if small == 0 || small > walCheckpointThreshold {
+		t.Fatalf("WAL is %d bytes after a small write", small)
+	}
+	s.checkpointIfLarge()
+	if stats := s.Stats(); stats.WALBytes != small || stats.LastCheckpoint != nil {
+		t.Errorf("checkpointed below the threshold: %+v", stats)
+	}
+
+	// Past it, the WAL is copied into the database and truncated
+	writePtyOutput(t, s, "proc-2", 2<<20)
+	before := s.Stats()
+	if before.WALBytes <= walCheckpointThreshold {
+		t.Fatalf("WAL is only %d bytes", before.WALBytes)
+	}
+	s.checkpointIfLarge()
+	after := s.Stats()
+	if after.WALBytes != 0 || after.LastCheckpoint == nil || after.CheckpointFailures != 0 {
+		t.Errorf("after checkpoint: %+v", after)
+	}
+	if after.DBBytes <= before.DBBytes {
+		t.Errorf("database grew from %d to %d bytes, want the WAL's pages in it", before.DBBytes, after.DBBytes)
+	}
+	if history, err := s.getPtyHistoryFromDB("proc-2"); err != nil || len(history) < 2<<20 {
+		t.Errorf("history after checkpoint: %d bytes, %v", len(history), err)
+	}
+
+	// Closing leaves an empty WAL
+	writePtyOutput(t, s, "proc-3", 64<<10)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if size := fileSize(dbPath + "-wal"); size != 0 {
+		t.Errorf("WAL is %d bytes after Close", size)
+	}
+}
+
+func TestCheckpointBlockedByReader(t *testing.T) {
+	s := newTestStore(t)
+	writePtyOutput(t, s, "proc-1", 64<<10)
+
+	// A read transaction holds on to the frames written after it started
+	tx, err := s.db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	defer tx.Rollback()
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM pty_history`).Scan(&count); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	writePtyOutput(t, s, "proc-2", 64<<10)
+
+	// The checkpoint waits out the busy timeout for the reader, then fails
+	if err := s.Checkpoint(); err == nil {
+		t.Fatal("checkpoint finished under an open reader")
+	}
+	if stats := s.Stats(); stats.CheckpointFailures != 1 || stats.WALBytes == 0 {
+		t.Errorf("after blocked checkpoint: %+v", stats)
+	}
+
+	// Once the reader ends, the next checkpoint goes through
+	tx.Rollback()
+	if err := s.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	if stats := s.Stats(); stats.CheckpointFailures != 0 || stats.WALBytes != 0 {
+		t.Errorf("after reader ended: %+v", stats)
+	}
+}
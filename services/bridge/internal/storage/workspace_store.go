@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Workspace groups processes (possibly across hosts) under a shared name
+type Workspace struct {
+	ID         string
+	Name       string
+	ProcessIDs []string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// CreateWorkspace creates a new workspace
+func (s *Store) CreateWorkspace(ws Workspace) error {
+	now := time.Now().Unix()
+	_, err := s.exec(`
+		INSERT INTO workspaces (id, name, created_at, updated_at)
+		VALUES (?, ?, ?, ?)`,
+		ws.ID, ws.Name, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create workspace: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Created workspace %s (%s)", ws.ID, ws.Name)
+	return nil
+}
+
+// GetWorkspace retrieves a workspace and its assigned process IDs
+func (s *Store) GetWorkspace(id string) (*Workspace, error) {
+	row := s.db.QueryRow(`
+		SELECT id, name, created_at, updated_at
+		FROM workspaces WHERE id = ?`, id)
+
+	var ws Workspace
+	var createdAt, updatedAt int64
+
+	err := row.Scan(&ws.ID, &ws.Name, &createdAt, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workspace: %w", err)
+	}
+
+	ws.CreatedAt = time.Unix(createdAt, 0)
+	ws.UpdatedAt = time.Unix(updatedAt, 0)
+
+	ws.ProcessIDs, err = s.GetWorkspaceProcessIDs(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ws, nil
+}
+
+// ListWorkspaces returns all workspaces ordered by name, with their process IDs
+func (s *Store) ListWorkspaces() ([]Workspace, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, created_at, updated_at
+		FROM workspaces ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspaces: %w", err)
+	}
+
+	var workspaces []Workspace
+	for rows.Next() {
+		var ws Workspace
+		var createdAt, updatedAt int64
+
+		if err := rows.Scan(&ws.ID, &ws.Name, &createdAt, &updatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan workspace: %w", err)
+		}
+
+		ws.CreatedAt = time.Unix(createdAt, 0)
+		ws.UpdatedAt = time.Unix(updatedAt, 0)
+		workspaces = append(workspaces, ws)
+	}
+	rows.Close()
+
+	for i := range workspaces {
+		ids, err := s.GetWorkspaceProcessIDs(workspaces[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		workspaces[i].ProcessIDs = ids
+	}
+
+	return workspaces, nil
+}
+
+// UpdateWorkspace renames an existing workspace
+func (s *Store) UpdateWorkspace(ws Workspace) error {
+	_, err := s.exec(`
+		UPDATE workspaces
+		SET name = ?, updated_at = ?
+		WHERE id = ?`,
+		ws.Name, time.Now().Unix(), ws.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update workspace: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Updated workspace %s (%s)", ws.ID, ws.Name)
+	return nil
+}
+
+// DeleteWorkspace removes a workspace and all of its process assignments.
+// The processes themselves are untouched.
+func (s *Store) DeleteWorkspace(id string) error {
+	if _, err := s.exec(`DELETE FROM process_workspace WHERE workspace_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete workspace assignments: %w", err)
+	}
+	if _, err := s.exec(`DELETE FROM workspaces WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete workspace: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Deleted workspace %s", id)
+	return nil
+}
+
+// SetProcessWorkspace assigns a process to a workspace.
+// An empty workspaceID removes the assignment.
+func (s *Store) SetProcessWorkspace(processID, workspaceID string) error {
+	var err error
+	if workspaceID == "" {
+		_, err = s.exec(`DELETE FROM process_workspace WHERE process_id = ?`, processID)
+	} else {
+		_, err = s.exec(`
+			INSERT INTO process_workspace (process_id, workspace_id)
+			VALUES (?, ?)
+			ON CONFLICT(process_id) DO UPDATE SET workspace_id = ?`,
+			processID, workspaceID, workspaceID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set process workspace: %w", err)
+	}
+	log.Printf("[DEBUG] [Storage] Set workspace for process %s to %q", processID, workspaceID)
+	return nil
+}
+
+// GetProcessWorkspace returns the workspace ID for a process, or empty string if unassigned
+func (s *Store) GetProcessWorkspace(processID string) (string, error) {
+	var workspaceID string
+	err := s.db.QueryRow(`SELECT workspace_id FROM process_workspace WHERE process_id = ?`, processID).Scan(&workspaceID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get process workspace: %w", err)
+	}
+	return workspaceID, nil
+}
+
+// GetWorkspaceProcessIDs returns the IDs of all processes assigned to a workspace
+func (s *Store) GetWorkspaceProcessIDs(workspaceID string) ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT process_id FROM process_workspace
+		WHERE workspace_id = ? ORDER BY process_id`, workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query workspace processes: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan workspace process: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
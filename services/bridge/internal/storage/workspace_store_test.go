@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWorkspaceCRUD(t *testing.T) {
+	s := newTestStore(t)
+
+	if err := s.CreateWorkspace(Workspace{ID: "ws-1", Name: "backend"}); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	if err := s.UpdateWorkspace(Workspace{ID: "ws-1", Name: "api"}); err != nil {
+		t.Fatalf("UpdateWorkspace: %v", err)
+	}
+
+	ws, err := s.GetWorkspace("ws-1")
+	if err != nil || ws == nil {
+		t.Fatalf("GetWorkspace: %v, %v", ws, err)
+	}
+	if ws.Name != "api" || len(ws.ProcessIDs) != 0 {
+		t.Errorf("unexpected workspace: %+v", ws)
+	}
+
+	if missing, err := s.GetWorkspace("nope"); err != nil || missing != nil {
+		t.Errorf("expected nil for missing workspace, got %v, %v", missing, err)
+	}
+}
+
+func TestWorkspaceAssignments(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateWorkspace(Workspace{ID: "ws-1", Name: "project"})
+	s.CreateWorkspace(Workspace{ID: "ws-2", Name: "other"})
+
+	s.SetProcessWorkspace("proc-a", "ws-1")
+	s.SetProcessWorkspace("proc-b", "ws-1")
+	s.SetProcessWorkspace("proc-c", "ws-2")
+	// Reassigning moves the process rather than duplicating it
+	s.SetProcessWorkspace("proc-c", "ws-1")
+
+	ids, err := s.GetWorkspaceProcessIDs("ws-1")
+	if err != nil {
+		t.Fatalf("GetWorkspaceProcessIDs: %v", err)
+	}
+	if want := []string{"proc-a", "proc-b", "proc-c"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("ws-1 processes = %v, want %v", ids, want)
+	}
+
+	// Clearing a single assignment (process killed)
+	s.SetProcessWorkspace("proc-b", "")
+	if got, _ := s.GetProcessWorkspace("proc-b"); got != "" {
+		t.Errorf("proc-b should be unassigned, got %q", got)
+	}
+
+	// Deleting the workspace removes the remaining assignments
+	if err := s.DeleteWorkspace("ws-1"); err != nil {
+		t.Fatalf("DeleteWorkspace: %v", err)
+	}
+	if got, _ := s.GetProcessWorkspace("proc-a"); got != "" {
+		t.Errorf("proc-a should be unassigned after delete, got %q", got)
+	}
+	workspaces, _ := s.ListWorkspaces()
+	if len(workspaces) != 1 || workspaces[0].ID != "ws-2" {
+		t.Errorf("unexpected workspaces after delete: %+v", workspaces)
+	}
+}
+
+func TestWorkspaceAssignmentSurvivesRestart(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "bridge.db")
+
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	s.CreateWorkspace(Workspace{ID: "ws-1", Name: "project"})
+	s.SetProcessWorkspace("proc-a", "ws-1")
+	s.Close()
+
+	reopened, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore (reopen): %v", err)
+	}
+	defer reopened.Close()
+
+	if got, _ := reopened.GetProcessWorkspace("proc-a"); got != "ws-1" {
+		t.Errorf("assignment lost across restart: got %q", got)
+	}
+}
@@ -0,0 +1,203 @@
+// Package timeline turns shell integration markers in a terminal's output
+// into a timeline of the commands run in it.
+//
+// A shell with the hooks from SaveCommand loaded writes OSC 133 semantic
+// prompt sequences around every command, as iTerm2 and VS Code's shell
+// integration do, and the command line itself in an OSC 633;E sequence:
+//
+//	ESC ] 133 ; D ; <exit> BEL   previous command finished
+//	ESC ] 133 ; A BEL            prompt starts
+//	ESC ] 633 ; E ; <cmd> BEL    command line about to run
+//	ESC ] 133 ; C BEL            command output starts
+//
+// Sequences may also end with ESC \ instead of BEL. Terminals ignore them,
+// so they are left in the output.
+package timeline
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// MarkKind is the kind of a shell integration marker
+type MarkKind byte
+
+const (
+	MarkPromptStart  MarkKind = 'A' // The shell is drawing a prompt
+	MarkCommandStart MarkKind = 'B' // The prompt ended and the user is typing
+	MarkOutputStart  MarkKind = 'C' // The command line was accepted and runs
+	MarkCommandEnd   MarkKind = 'D' // The command finished, maybe with its exit status
+	MarkCommandLine  MarkKind = 'E' // The command line about to run
+)
+
+// Mark is a shell integration marker found in terminal output
+type Mark struct {
+	Kind     MarkKind
+	ExitCode *int   // For MarkCommandEnd, when the shell reported one
+	Command  string // For MarkCommandLine
+}
+
+// maxSequence bounds the OSC sequences the parser buffers; longer ones are
+// dropped. It is generous since a command line can be long.
+const maxSequence = 64 * 1024
+
+// Parser state between calls to Feed
+const (
+	stateGround  = iota // Plain output
+	stateEsc            // After ESC
+	stateOSC            // In an OSC sequence we want
+	stateOSCEsc         // After ESC in an OSC sequence we want
+	stateSkip           // In an OSC sequence we don't want, or one too long
+	stateSkipEsc        // After ESC in one of those
+)
+
+// Parser finds shell integration markers in terminal output. Output can be
+// fed in any pieces: a sequence split across reads is held back until the
+// rest of it arrives.
+type Parser struct {
+	state int
+	seq   []byte // The sequence so far, after ESC ]
+}
+
+// Feed parses the next piece of output and returns the markers completed in it
+func (p *Parser) Feed(data []byte) []Mark {
+	var marks []Mark
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		switch p.state {
+		case stateGround:
+			// Skip straight to the next escape
+			j := bytes.IndexByte(data[i:], 0x1b)
+			if j < 0 {
+				return marks
+			}
+			i += j
+			p.state = stateEsc
+		case stateEsc:
+			switch b {
+			case ']':
+				p.state = stateOSC
+				p.seq = p.seq[:0]
+			case 0x1b:
+			default:
+				p.state = stateGround
+			}
+		case stateOSC:
+			switch b {
+			case 0x07:
+				marks = p.finish(marks)
+			case 0x1b:
+				p.state = stateOSCEsc
+			case 0x18, 0x1a: // CAN and SUB cancel a sequence
+				p.state = stateGround
+			default:
+				p.seq = append(p.seq, b)
+				if !p.wanted() {
+					p.state = stateSkip
+				}
+			}
+		case stateOSCEsc:
+			if b == '\\' {
+				marks = p.finish(marks)
+				continue
+			}
+			// Another escape sequence cut this one short
+			p.state = stateEsc
+			i--
+		case stateSkip:
+			switch b {
+			case 0x07, 0x18, 0x1a:
+				p.state = stateGround
+			case 0x1b:
+				p.state = stateSkipEsc
+			}
+		case stateSkipEsc:
+			if b == '\\' {
+				p.state = stateGround
+				continue
+			}
+			p.state = stateEsc
+			i--
+		}
+	}
+	return marks
+}
+
+// wanted reports whether the sequence so far could still be a marker
+func (p *Parser) wanted() bool {
+	if len(p.seq) > maxSequence {
+		return false
+	}
+	for _, prefix := range []string{"133;", "633;E;"} {
+		n := min(len(p.seq), len(prefix))
+		if string(p.seq[:n]) == prefix[:n] {
+			return true
+		}
+	}
+	return false
+}
+
+// finish ends the current sequence, adding the marker it holds to marks
+func (p *Parser) finish(marks []Mark) []Mark {
+	p.state = stateGround
+	if mark, ok := parseMark(string(p.seq)); ok {
+		marks = append(marks, mark)
+	}
+	p.seq = p.seq[:0]
+	return marks
+}
+
+// parseMark parses the body of an OSC sequence
+func parseMark(seq string) (Mark, bool) {
+	if cmd, ok := strings.CutPrefix(seq, "633;E;"); ok {
+		// VS Code adds a nonce after the command line; we don't use one
+		cmd, _, _ = strings.Cut(cmd, ";")
+		return Mark{Kind: MarkCommandLine, Command: unescapeCommand(cmd)}, true
+	}
+	body, ok := strings.CutPrefix(seq, "133;")
+	if !ok || body == "" {
+		return Mark{}, false
+	}
+	kind, params, _ := strings.Cut(body, ";")
+	switch kind {
+	case "A", "B", "C":
+		return Mark{Kind: MarkKind(kind[0])}, true
+	case "D":
+		mark := Mark{Kind: MarkCommandEnd}
+		// Other terminals' shell integration adds key=value options
+		exit, _, _ := strings.Cut(params, ";")
+		if code, err := strconv.Atoi(exit); err == nil {
+			mark.ExitCode = &code
+		}
+		return mark, true
+	}
+	return Mark{}, false
+}
+
+// unescapeCommand decodes a command line from an OSC 633;E sequence, in
+// which a backslash is written \\ and other bytes may be written \xHH
+func unescapeCommand(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			if s[i+1] == '\\' {
+				out.WriteByte('\\')
+				i++
+				continue
+			}
+			if s[i+1] == 'x' && i+3 < len(s) {
+				if b, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+					out.WriteByte(byte(b))
+					i += 3
+					continue
+				}
+			}
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
@@ -0,0 +1,117 @@
+package timeline
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func exitCode(code int) *int { return &code }
+
+// session is output of a shell with the hooks loaded, with other escape
+// sequences mixed in: colors, a window title and a hyperlink
+const session = "\x1b]133;D;0\a\x1b]133;A\a\x1b[32muser@host\x1b[0m:~$ \x1b]133;B\a" +
+	"ls\r\n\x1b]633;E;ls --color\\x3b echo \\\\done\a\x1b]133;C\x1b\\" +
+	"\x1b]0;ls ~\a\x1b[1;34mdir\x1b[0m \x1b]8;;file:///tmp/a\x1b\\a\x1b]8;;\x1b\\\r\n" +
+	"\x1b]133;D;2;aid=7\a\x1b]133;A\a$ "
+
+var sessionMarks = []Mark{
+	{Kind: MarkCommandEnd, ExitCode: exitCode(0)},
+	{Kind: MarkPromptStart},
+	{Kind: MarkCommandStart},
+	{Kind: MarkCommandLine, Command: `ls --color; echo \done`},
+	{Kind: MarkOutputStart},
+	{Kind: MarkCommandEnd, ExitCode: exitCode(2)},
+	{Kind: MarkPromptStart},
+}
+
+func TestParser(t *testing.T) {
+	var p Parser
+	if got := p.Feed([]byte(session)); !reflect.DeepEqual(got, sessionMarks) {
+		t.Errorf("marks = %+v\nwant %+v", got, sessionMarks)
+	}
+}
+
+func TestParserSplitReads(t *testing.T) {
+	// Every way of cutting the output in two, and one byte at a time
+	for i := 0; i <= len(session); i++ {
+		var p Parser
+		got := append(p.Feed([]byte(session[:i])), p.Feed([]byte(session[i:]))...)
+		if !reflect.DeepEqual(got, sessionMarks) {
+			t.Fatalf("split at %d: marks = %+v", i, got)
+		}
+	}
+	var p Parser
+	var got []Mark
+	for i := 0; i < len(session); i++ {
+		got = append(got, p.Feed([]byte{session[i]})...)
+	}
+	if !reflect.DeepEqual(got, sessionMarks) {
+		t.Errorf("byte by byte: marks = %+v", got)
+	}
+}
+
+func TestParserMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []Mark
+	}{
+		{"no exit status", "\x1b]133;D\a", []Mark{{Kind: MarkCommandEnd}}},
+		{"unparsable exit status", "\x1b]133;D;x\a", []Mark{{Kind: MarkCommandEnd}}},
+		{"unknown marker", "\x1b]133;Z\a\x1b]133;\a\x1b]1337;A\a", nil},
+		{"other OSC", "\x1b]633;A\a\x1b]52;c;aGk=\a", nil},
+		{"cancelled", "\x1b]133;A\x18\x1b]133;C\x1a", nil},
+		{"cut short by another sequence", "\x1b]133;A\x1b[0m\x1b]133;C\a", []Mark{{Kind: MarkOutputStart}}},
+		{"escapes before the sequence", "\x1b\x1b\x1b]133;C\a", []Mark{{Kind: MarkOutputStart}}},
+		{"unknown escape in command", "\x1b]633;E;a\\qb\\x4\\\a", []Mark{{Kind: MarkCommandLine, Command: `a\qb\x4\`}}},
+		{"nonce after command", "\x1b]633;E;make\\x3b make test;nonce\a", []Mark{{Kind: MarkCommandLine, Command: "make; make test"}}},
+		{"control bytes in command", "\x1b]633;E;printf '\\x1b]0\\x07'\\x0aecho\a", []Mark{{Kind: MarkCommandLine, Command: "printf '\x1b]0\a'\necho"}}},
+	}
+	for _, tt := range tests {
+		var p Parser
+		if got := p.Feed([]byte(tt.output)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: marks = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParserDropsLongSequences(t *testing.T) {
+	var p Parser
+	long := "\x1b]633;E;" + strings.Repeat("x", maxSequence) + "\a"
+	if got := p.Feed([]byte(long + "\x1b]133;C\a")); !reflect.DeepEqual(got, []Mark{{Kind: MarkOutputStart}}) {
+		t.Errorf("marks = %+v, want only the one after the long sequence", got)
+	}
+	if cap(p.seq) > 2*maxSequence {
+		t.Errorf("buffered %d bytes", cap(p.seq))
+	}
+}
+
+func TestTracker(t *testing.T) {
+	var tr Tracker
+	output := session +
+		// A command the shell didn't name, which never reports its end
+		"\x1b]133;C\aoutput\r\n\x1b]133;A\a$ " +
+		// Two commands without a prompt between them
+		"\x1b]633;E;make\a\x1b]133;C\a\x1b]633;E;make test\a\x1b]133;C\a\x1b]133;D;1\a" +
+		// An end without a command: Enter on an empty line
+		"\x1b]133;D;1\a\x1b]133;A\a$ "
+
+	var got []Event
+	for _, chunk := range strings.SplitAfter(output, "\a") {
+		got = append(got, tr.Feed([]byte(chunk))...)
+	}
+	want := []Event{
+		{Kind: EventStarted, Command: `ls --color; echo \done`},
+		{Kind: EventFinished, ExitCode: exitCode(2)},
+		{Kind: EventStarted},
+		{Kind: EventFinished},
+		{Kind: EventStarted, Command: "make"},
+		{Kind: EventFinished},
+		{Kind: EventStarted, Command: "make test"},
+		{Kind: EventFinished, ExitCode: exitCode(1)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %+v\nwant %+v", got, want)
+	}
+}
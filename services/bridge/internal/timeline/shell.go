@@ -0,0 +1,148 @@
+package timeline
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/shellargs"
+)
+
+// Errors from Shell
+var (
+	ErrUnsupportedShell = errors.New("command timeline is not supported for this shell")
+	ErrNotAtPrompt      = errors.New("shell is running a command")
+)
+
+// hookDir is where the hook scripts are saved on the host, under $HOME
+const hookDir = ".cache/remote-claude"
+
+// hooks holds the hook script of each supported shell. Each one emits the
+// markers the package parses, and defines __rc_timeline_off to undo itself.
+// Loading one again first undoes the hooks already loaded.
+var hooks = map[string]string{
+	// A DEBUG trap runs before every simple command, so preexec only reports
+	// the first one after a prompt; a line that is only a subshell is caught
+	// when the prompt comes back. The command line comes from history unless
+	// HISTCONTROL kept it out, in which case the first simple command of it
+	// is all there is.
+	//
+	// A DEBUG trap already set is only visible outside the script, so the
+	// line loading it passes it in __rc_timeline_trap.
+	"bash": `if declare -F __rc_timeline_off >/dev/null; then
+	__rc_timeline_trap=$__rc_timeline_saved_trap
+	__rc_timeline_off
+fi
+__rc_timeline_esc() {
+	local c=$1
+	c=${c//\\/\\\\}; c=${c//;/\\x3b}; c=${c//$'\n'/\\x0a}; c=${c//$'\r'/\\x0d}; c=${c//$'\e'/\\x1b}; c=${c//$'\a'/\\x07}
+	printf '%s' "$c"
+}
+__rc_timeline_precmd() {
+	local s=$?
+	printf '\e]133;D;%s\a\e]133;A\a' "$s"
+	return $s
+}
+__rc_timeline_ready() {
+	__rc_timeline_prompt=1
+}
+__rc_timeline_preexec() {
+	[ -n "$__rc_timeline_prompt" ] || return 0
+	__rc_timeline_prompt=
+	case $BASH_COMMAND in __rc_timeline_off*) return 0 ;; esac
+	local h n c
+	h=$(HISTTIMEFORMAT= builtin history 1)
+	h=${h#"${h%%[![:space:]]*}"}
+	n=${h%%[!0-9]*}
+	c=${h#"$n"}; c=${c#\*}; c=${c#"${c%%[![:space:]]*}"}
+	if [ "$n" = "$__rc_timeline_histno" ]; then
+		# Enter on an empty line runs nothing but the prompt again
+		case $BASH_COMMAND in __rc_timeline_precmd*) return 0 ;; esac
+		c=$BASH_COMMAND
+	fi
+	__rc_timeline_histno=$n
+	printf '\e]633;E;%s\a\e]133;C\a' "$(__rc_timeline_esc "$c")"
+}
+__rc_timeline_off() {
+	PROMPT_COMMAND=$__rc_timeline_saved_prompt
+	eval "${__rc_timeline_saved_trap:-trap - DEBUG}"
+	unset -f __rc_timeline_esc __rc_timeline_precmd __rc_timeline_ready __rc_timeline_preexec __rc_timeline_off
+	unset __rc_timeline_prompt __rc_timeline_histno __rc_timeline_saved_prompt __rc_timeline_saved_trap
+}
+# Without the trace attribute, bash puts back the DEBUG trap when a function returns
+declare -ft __rc_timeline_off
+__rc_timeline_prompt=
+__rc_timeline_histno=$(HISTTIMEFORMAT= builtin history 1 | sed 's/^ *\([0-9]*\).*/\1/')
+__rc_timeline_saved_prompt=$PROMPT_COMMAND
+__rc_timeline_saved_trap=$__rc_timeline_trap
+unset __rc_timeline_trap
+PROMPT_COMMAND="__rc_timeline_precmd${PROMPT_COMMAND:+;$PROMPT_COMMAND};__rc_timeline_ready"
+trap '__rc_timeline_preexec' DEBUG
+`,
+	// precmd goes first so it sees the command's exit status
+	"zsh": `(( $+functions[__rc_timeline_off] )) && __rc_timeline_off
+__rc_timeline_esc() {
+	local c=$1
+	c=${c//\\/\\\\}; c=${c//;/\\x3b}; c=${c//$'\n'/\\x0a}; c=${c//$'\r'/\\x0d}; c=${c//$'\e'/\\x1b}; c=${c//$'\a'/\\x07}
+	print -rn -- "$c"
+}
+__rc_timeline_precmd() {
+	local s=$?
+	printf '\e]133;D;%s\a\e]133;A\a' "$s"
+	return $s
+}
+__rc_timeline_preexec() {
+	printf '\e]633;E;%s\a\e]133;C\a' "$(__rc_timeline_esc "$1")"
+}
+__rc_timeline_off() {
+	precmd_functions=(${precmd_functions:#__rc_timeline_precmd})
+	preexec_functions=(${preexec_functions:#__rc_timeline_preexec})
+	unfunction __rc_timeline_esc __rc_timeline_precmd __rc_timeline_preexec __rc_timeline_off
+}
+precmd_functions=(__rc_timeline_precmd $precmd_functions)
+preexec_functions+=(__rc_timeline_preexec)
+`,
+}
+
+// knownShells are shells the hooks can't be installed in
+var knownShells = []string{"sh", "dash", "ash", "ksh", "mksh", "oksh", "csh", "tcsh", "fish", "nu", "elvish", "xonsh", "pwsh"}
+
+// Shell returns the shell whose hooks apply to a pane running command, as
+// tmux names it. It returns ErrUnsupportedShell for shells without hooks,
+// and ErrNotAtPrompt for anything that isn't a shell: typing into the pane
+// would go to that program instead.
+func Shell(command string) (string, error) {
+	// Login shells are named with a leading dash
+	shell := strings.TrimPrefix(command, "-")
+	if _, ok := hooks[shell]; ok {
+		return shell, nil
+	}
+	if slices.Contains(knownShells, shell) {
+		return "", ErrUnsupportedShell
+	}
+	return "", ErrNotAtPrompt
+}
+
+// hookPath returns where the hook script of shell is saved on the host
+func hookPath(shell string) string {
+	return hookDir + "/timeline." + shell
+}
+
+// SaveCommand returns a host command that saves the hook script of shell,
+// which must be one Shell returned
+func SaveCommand(shell string) string {
+	return "mkdir -p ~/" + hookDir + " && printf '%s' " + shellargs.Quote(hooks[shell]) + " > " + shellargs.Quote("~/"+hookPath(shell))
+}
+
+// EnableLine returns the line that loads the saved hook script of shell into
+// it. The leading space keeps it out of history where HISTCONTROL allows.
+func EnableLine(shell string) string {
+	line := ` . ~/` + hookPath(shell)
+	if shell == "bash" {
+		line = ` __rc_timeline_trap=$(trap -p DEBUG);` + line
+	}
+	return line
+}
+
+// DisableLine undoes the hooks in a shell that has them loaded
+const DisableLine = ` __rc_timeline_off 2>/dev/null`
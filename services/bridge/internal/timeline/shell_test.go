@@ -0,0 +1,105 @@
+package timeline
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShell(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+		err     error
+	}{
+		{"bash", "bash", nil},
+		{"-zsh", "zsh", nil},
+		{"fish", "", ErrUnsupportedShell},
+		{"-sh", "", ErrUnsupportedShell},
+		{"vim", "", ErrNotAtPrompt},
+		{"", "", ErrNotAtPrompt},
+	}
+	for _, tt := range tests {
+		got, err := Shell(tt.command)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("Shell(%q) = %q, %v; want %q, %v", tt.command, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+// runBash saves the bash hooks under a fresh home directory and feeds input
+// to an interactive bash there, returning what it wrote
+func runBash(t *testing.T, input string) string {
+	t.Helper()
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not available")
+	}
+	home := t.TempDir()
+	env := append(os.Environ(), "HOME="+home, "HISTFILE=", "PS1=$ ")
+
+	save := exec.Command("sh", "-c", SaveCommand("bash"))
+	save.Env = env
+	if output, err := save.CombinedOutput(); err != nil {
+		t.Fatalf("saving hooks: %v: %s", err, output)
+	}
+
+	cmd := exec.Command(bash, "--norc", "--noprofile", "-i")
+	cmd.Env = env
+	cmd.Stdin = strings.NewReader(input)
+	output, _ := cmd.CombinedOutput()
+	return string(output)
+}
+
+func TestBashHooks(t *testing.T) {
+	output := runBash(t, strings.Join([]string{
+		EnableLine("bash"),
+		"echo one; echo two",
+		"(exit 3)",
+		"",
+		"  false | true",
+		DisableLine,
+		"echo after",
+	}, "\n")+"\n")
+
+	var tr Tracker
+	got := tr.Feed([]byte(output))
+	want := []Event{
+		{Kind: EventStarted, Command: "echo one; echo two"},
+		{Kind: EventFinished, ExitCode: exitCode(0)},
+		{Kind: EventStarted, Command: "(exit 3)"},
+		{Kind: EventFinished, ExitCode: exitCode(3)},
+		{Kind: EventStarted, Command: "false | true"},
+		{Kind: EventFinished, ExitCode: exitCode(0)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %+v\nwant %+v\noutput %q", got, want, output)
+	}
+	if !strings.Contains(output, "\nafter\n") || strings.Contains(output, "not found") {
+		t.Errorf("shell after disabling: %q", output)
+	}
+}
+
+func TestBashHooksReversible(t *testing.T) {
+	// Loading twice doesn't stack the hooks, and disabling puts back what
+	// the shell had
+	output := runBash(t, strings.Join([]string{
+		"PROMPT_COMMAND='echo prompt'",
+		"trap 'true' DEBUG",
+		EnableLine("bash"),
+		EnableLine("bash"),
+		DisableLine,
+		`echo "[$PROMPT_COMMAND]"`,
+		"trap -p DEBUG",
+		"declare -F | grep -c __rc_timeline",
+	}, "\n")+"\n")
+
+	for _, want := range []string{"[echo prompt]\n", "trap -- 'true' DEBUG\n", "\n0\n"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output lacks %q: %q", want, output)
+		}
+	}
+}
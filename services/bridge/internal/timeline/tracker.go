@@ -0,0 +1,56 @@
+package timeline
+
+// EventKind is the kind of a command timeline event
+type EventKind int
+
+const (
+	EventStarted  EventKind = iota // A command started running
+	EventFinished                  // The running command finished
+)
+
+// Event is a change to the command running in a terminal
+type Event struct {
+	Kind     EventKind
+	Command  string // For EventStarted; empty if the shell didn't report it
+	ExitCode *int   // For EventFinished, when the shell reported one
+}
+
+// Tracker follows the commands run in a terminal from its output
+type Tracker struct {
+	parser  Parser
+	command string // Command line reported for the next command
+	running bool
+}
+
+// Feed parses the next piece of output and returns the timeline events in it
+func (t *Tracker) Feed(data []byte) []Event {
+	var events []Event
+	for _, mark := range t.parser.Feed(data) {
+		switch mark.Kind {
+		case MarkCommandLine:
+			t.command = mark.Command
+		case MarkOutputStart:
+			if t.running {
+				// The shell never said the last one finished
+				events = append(events, Event{Kind: EventFinished})
+			}
+			events = append(events, Event{Kind: EventStarted, Command: t.command})
+			t.command = ""
+			t.running = true
+		case MarkCommandEnd:
+			if t.running {
+				events = append(events, Event{Kind: EventFinished, ExitCode: mark.ExitCode})
+				t.running = false
+			}
+		case MarkPromptStart:
+			// A new prompt means the command is over, even if the shell
+			// didn't report its end
+			if t.running {
+				events = append(events, Event{Kind: EventFinished})
+				t.running = false
+			}
+			t.command = ""
+		}
+	}
+	return events
+}
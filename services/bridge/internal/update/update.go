@@ -0,0 +1,143 @@
+// Package update checks a release manifest for a newer bridge. It only
+// tells: nothing is downloaded or installed. The manifest is a JSON object
+// with the latest release's version, and optionally its URL, release notes
+// and the protocol version it speaks:
+//
+//	{"version": "1.5.0", "url": "https://...", "notes": "...", "protocolVersion": 1}
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// fetchTimeout bounds a manifest fetch, connecting included; a variable so
+// tests can shorten it
+var fetchTimeout = 10 * time.Second
+
+// maxManifestSize is the most of a response read as the manifest
+const maxManifestSize = 64 << 10
+
+// Manifest describes the latest release
+type Manifest struct {
+	Version         string `json:"version"`
+	URL             string `json:"url,omitempty"`
+	Notes           string `json:"notes,omitempty"`
+	ProtocolVersion int    `json:"protocolVersion,omitempty"`
+}
+
+// Status is what the last check found
+type Status struct {
+	Current   string
+	Latest    *Manifest // From the last check that succeeded; nil before one has
+	Available bool      // Latest is newer than Current
+	CheckedAt time.Time // Of the last check, successful or not; zero before the first
+	Err       error     // Why the last check failed
+}
+
+// Checker fetches the release manifest and compares it with the running
+// version. It is safe for concurrent use.
+type Checker struct {
+	manifestURL string
+	current     Version
+	client      *http.Client
+
+	mu     sync.Mutex
+	status Status
+}
+
+// NewChecker returns a checker of the manifest at manifestURL, which must
+// be https, for a bridge running version current. transport makes the
+// requests, or http.DefaultTransport when nil.
+func NewChecker(manifestURL, current string, transport http.RoundTripper) (*Checker, error) {
+	u, err := url.Parse(manifestURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest URL: %w", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid manifest URL %q: must be an https URL", manifestURL)
+	}
+	v, err := ParseVersion(current)
+	if err != nil {
+		return nil, fmt.Errorf("running build has no release version: %w", err)
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   fetchTimeout,
+		// A redirect must not leave TLS
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme != "https" {
+				return fmt.Errorf("redirected to %s, which is not https", req.URL.Redacted())
+			}
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+	return &Checker{
+		manifestURL: manifestURL,
+		current:     v,
+		client:      client,
+		status:      Status{Current: v.String()},
+	}, nil
+}
+
+// Check fetches the manifest and returns the new status. A failed fetch,
+// e.g. while offline, is reported in Err, keeping what the check before
+// found.
+func (c *Checker) Check(ctx context.Context) Status {
+	manifest, latest, err := c.fetch(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status.CheckedAt = time.Now()
+	c.status.Err = err
+	if err == nil {
+		c.status.Latest = manifest
+		c.status.Available = latest.Compare(c.current) > 0
+	}
+	return c.status
+}
+
+// Status returns what the last check found
+func (c *Checker) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// fetch gets and parses the manifest
+func (c *Checker) fetch(ctx context.Context) (*Manifest, Version, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.manifestURL, nil)
+	if err != nil {
+		return nil, Version{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, Version{}, fmt.Errorf("failed to fetch release manifest: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, Version{}, fmt.Errorf("failed to fetch release manifest: %s", resp.Status)
+	}
+
+	var manifest Manifest
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&manifest); err != nil {
+		return nil, Version{}, fmt.Errorf("invalid release manifest: %w", err)
+	}
+	latest, err := ParseVersion(manifest.Version)
+	if err != nil {
+		return nil, Version{}, fmt.Errorf("invalid release manifest: %w", err)
+	}
+	return &manifest, latest, nil
+}
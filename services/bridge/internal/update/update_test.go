@@ -0,0 +1,143 @@
+package update
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// manifestServer serves body as the release manifest over TLS
+func manifestServer(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestChecker(t *testing.T, srv *httptest.Server, current string) *Checker {
+	t.Helper()
+	c, err := NewChecker(srv.URL+"/manifest.json", current, srv.Client().Transport)
+	if err != nil {
+		t.Fatalf("NewChecker: %v", err)
+	}
+	return c
+}
+
+func TestCheckNewerRelease(t *testing.T) {
+	srv := manifestServer(t, http.StatusOK,
+		`{"version": "v1.5.0", "url": "https://example.com/releases/1.5.0", "notes": "Faster reattach", "protocolVersion": 2}`)
+	c := newTestChecker(t, srv, "1.4.2")
+	if c.Status().Latest != nil || !c.Status().CheckedAt.IsZero() {
+		t.Errorf("status before a check = %+v", c.Status())
+	}
+
+	status := c.Check(context.Background())
+	if status.Err != nil || !status.Available || status.Current != "1.4.2" {
+		t.Fatalf("status = %+v", status)
+	}
+	if status.Latest.Version != "v1.5.0" || status.Latest.URL != "https://example.com/releases/1.5.0" ||
+		status.Latest.Notes != "Faster reattach" || status.Latest.ProtocolVersion != 2 || status.CheckedAt.IsZero() {
+		t.Errorf("latest = %+v", status.Latest)
+	}
+	if got := c.Status(); got.Latest != status.Latest || !got.Available {
+		t.Errorf("Status() = %+v, want what Check returned", got)
+	}
+}
+
+func TestCheckOlderOrSameRelease(t *testing.T) {
+	for _, latest := range []string{"1.4.2", "1.4.1", "1.4.2-rc.3"} {
+		srv := manifestServer(t, http.StatusOK, `{"version": "`+latest+`"}`)
+		status := newTestChecker(t, srv, "1.4.2").Check(context.Background())
+		if status.Err != nil || status.Available || status.Latest == nil {
+			t.Errorf("latest %s: status = %+v, want no update", latest, status)
+		}
+	}
+}
+
+func TestCheckBadManifest(t *testing.T) {
+	for name, tt := range map[string]struct {
+		status int
+		body   string
+	}{
+		"garbage":     {http.StatusOK, "<html>not json</html>"},
+		"no version":  {http.StatusOK, `{"url": "https://example.com"}`},
+		"bad version": {http.StatusOK, `{"version": "latest"}`},
+		"not found":   {http.StatusNotFound, `{"version": "9.9.9"}`},
+	} {
+		srv := manifestServer(t, tt.status, tt.body)
+		status := newTestChecker(t, srv, "1.4.2").Check(context.Background())
+		if status.Err == nil || status.Available || status.Latest != nil {
+			t.Errorf("%s: status = %+v, want an error", name, status)
+		}
+	}
+}
+
+func TestCheckOffline(t *testing.T) {
+	srv := manifestServer(t, http.StatusOK, `{"version": "1.5.0"}`)
+	c := newTestChecker(t, srv, "1.4.2")
+	if status := c.Check(context.Background()); !status.Available {
+		t.Fatalf("status = %+v", status)
+	}
+
+	// Once the manifest can't be reached, the last release found is kept
+	srv.Close()
+	status := c.Check(context.Background())
+	if status.Err == nil || !status.Available || status.Latest == nil || status.Latest.Version != "1.5.0" {
+		t.Errorf("status offline = %+v, want an error and the last release", status)
+	}
+}
+
+func TestCheckTimesOut(t *testing.T) {
+	saved := fetchTimeout
+	t.Cleanup(func() { fetchTimeout = saved })
+	fetchTimeout = 50 * time.Millisecond
+
+	release := make(chan struct{})
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	start := time.Now()
+	status := newTestChecker(t, srv, "1.4.2").Check(context.Background())
+	if status.Err == nil || time.Since(start) > 2*time.Second {
+		t.Errorf("status = %+v after %v, want a timeout", status, time.Since(start))
+	}
+}
+
+func TestCheckerRequiresTLS(t *testing.T) {
+	if _, err := NewChecker("http://example.com/manifest.json", "1.4.2", nil); err == nil {
+		t.Error("NewChecker accepted a plain http URL")
+	}
+	if _, err := NewChecker("https://example.com/manifest.json", "dev", nil); err == nil {
+		t.Error("NewChecker accepted a build without a release version")
+	}
+
+	// The default transport doesn't trust the test server's certificate
+	srv := manifestServer(t, http.StatusOK, `{"version": "1.5.0"}`)
+	c, err := NewChecker(srv.URL, "1.4.2", nil)
+	if err != nil {
+		t.Fatalf("NewChecker: %v", err)
+	}
+	if status := c.Check(context.Background()); status.Err == nil || !strings.Contains(status.Err.Error(), "certificate") {
+		t.Errorf("status = %+v, want a certificate error", status)
+	}
+
+	// Nor may a redirect leave TLS
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version": "1.5.0"}`))
+	}))
+	t.Cleanup(plain.Close)
+	redirect := httptest.NewTLSServer(http.RedirectHandler(plain.URL, http.StatusFound))
+	t.Cleanup(redirect.Close)
+	if status := newTestChecker(t, redirect, "1.4.2").Check(context.Background()); status.Err == nil || status.Available {
+		t.Errorf("status = %+v, want the redirect to http refused", status)
+	}
+}
@@ -0,0 +1,107 @@
+package update
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version: major.minor.patch with an optional
+// pre-release. Build metadata is dropped, as it doesn't order versions.
+type Version struct {
+	Major, Minor, Patch int
+	Pre                 string // Dot-separated pre-release identifiers, e.g. "rc.1"
+}
+
+// ParseVersion parses a version such as "1.4.2", "v1.4.2" or "1.5.0-rc.1"
+func ParseVersion(s string) (Version, error) {
+	v, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(s), "v"), "+")
+	core, pre, hasPre := strings.Cut(v, "-")
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 || (hasPre && pre == "") {
+		return Version{}, fmt.Errorf("invalid version %q: want major.minor.patch", s)
+	}
+
+	var nums [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || !isDigits(part) || (len(part) > 1 && part[0] == '0') {
+			return Version{}, fmt.Errorf("invalid version %q: %q is not a version number", s, part)
+		}
+		nums[i] = n
+	}
+	if hasPre && slices.Contains(strings.Split(pre, "."), "") {
+		return Version{}, fmt.Errorf("invalid version %q: empty pre-release identifier", s)
+	}
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2], Pre: pre}, nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
+
+// Compare returns -1, 0 or +1 as v is older than, the same as or newer than
+// w. A pre-release is older than its release, and pre-releases compare
+// identifier by identifier: numbers numerically and below words.
+func (v Version) Compare(w Version) int {
+	if c := cmp.Compare(v.Major, w.Major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Minor, w.Minor); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Patch, w.Patch); c != 0 {
+		return c
+	}
+	switch {
+	case v.Pre == w.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case w.Pre == "":
+		return -1
+	}
+
+	a, b := strings.Split(v.Pre, "."), strings.Split(w.Pre, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := comparePreID(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func comparePreID(a, b string) int {
+	switch numA, numB := isDigits(a), isDigits(b); {
+	case numA && numB:
+		// By length first, so identifiers too long for an int still compare
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if c := cmp.Compare(len(a), len(b)); c != 0 {
+			return c
+		}
+	case numA:
+		return -1
+	case numB:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// isDigits reports whether s is a non-empty run of ASCII digits
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package update
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	// Each version is older than the next
+	ordered := []string{
+		"0.9.9",
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.2.0",
+		"1.10.0",
+		"2.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, err := ParseVersion(ordered[i])
+			if err != nil {
+				t.Fatalf("ParseVersion(%q): %v", ordered[i], err)
+			}
+			b, _ := ParseVersion(ordered[j])
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got := a.Compare(b); got != want {
+				t.Errorf("%s vs %s = %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for in, want := range map[string]string{
+		"1.4.2":              "1.4.2",
+		"v1.4.2":             "1.4.2",
+		" 1.4.2\n":           "1.4.2",
+		"1.5.0-rc.1":         "1.5.0-rc.1",
+		"1.5.0-rc.1+abc1234": "1.5.0-rc.1",
+		"1.5.0+abc1234":      "1.5.0",
+	} {
+		v, err := ParseVersion(in)
+		if err != nil || v.String() != want {
+			t.Errorf("ParseVersion(%q) = %v, %v; want %s", in, v, err, want)
+		}
+	}
+
+	for _, in := range []string{"", "dev", "1.2", "1.2.3.4", "1.02.3", "1.+2.3", "1.2.x", "1.2.3-", "1.2.3-rc..1"} {
+		if v, err := ParseVersion(in); err == nil {
+			t.Errorf("ParseVersion(%q) = %v, want an error", in, v)
+		}
+	}
+}
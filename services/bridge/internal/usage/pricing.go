@@ -0,0 +1,104 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Price is what a model charges in USD per million tokens
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+const (
+	cacheWriteMultiplier = 1.25 // 5 minute cache writes, relative to input
+	cacheReadMultiplier  = 0.1
+)
+
+// pricedFragment is the price of the models whose IDs contain fragment
+type pricedFragment struct {
+	fragment string
+	price    Price
+}
+
+// listPrices maps model ID fragments to list prices. The first match wins,
+// so more specific fragments come first.
+var listPrices = []pricedFragment{
+	{"opus-4-5", Price{5, 25}},
+	{"opus", Price{15, 75}},
+	{"sonnet", Price{3, 15}},
+	{"haiku-4-5", Price{1, 5}},
+	{"3-5-haiku", Price{0.8, 4}},
+	{"3-haiku", Price{0.25, 1.25}},
+}
+
+var (
+	pricesMu sync.RWMutex
+	prices   = listPrices
+)
+
+// SetPrices prices models by ID fragment ahead of the list prices, for
+// models released or repriced since this build. Longer fragments are
+// matched first.
+func SetPrices(custom map[string]Price) {
+	fragments := make([]pricedFragment, 0, len(custom)+len(listPrices))
+	for fragment, price := range custom {
+		fragments = append(fragments, pricedFragment{fragment, price})
+	}
+	sort.Slice(fragments, func(i, j int) bool {
+		a, b := fragments[i].fragment, fragments[j].fragment
+		return len(a) > len(b) || len(a) == len(b) && a < b
+	})
+
+	pricesMu.Lock()
+	defer pricesMu.Unlock()
+	prices = append(fragments, listPrices...)
+}
+
+// LoadPrices reads prices for SetPrices from a JSON file mapping model ID
+// fragments to prices, e.g. {"opus-4-5": {"input": 5, "output": 25}}
+func LoadPrices(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read model prices: %w", err)
+	}
+	var custom map[string]Price
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return fmt.Errorf("failed to parse model prices in %s: %w", path, err)
+	}
+	for fragment, price := range custom {
+		if fragment == "" || price.Input < 0 || price.Output < 0 {
+			return fmt.Errorf("invalid model price %q in %s", fragment, path)
+		}
+	}
+	SetPrices(custom)
+	return nil
+}
+
+// priceOf returns the price of a model
+func priceOf(model string) (Price, bool) {
+	pricesMu.RLock()
+	defer pricesMu.RUnlock()
+	for _, p := range prices {
+		if strings.Contains(model, p.fragment) {
+			return p.price, true
+		}
+	}
+	return Price{}, false
+}
+
+// Cost estimates the cost in USD of the model's usage at list prices, or
+// those set with SetPrices. ok is false for models without a known price.
+func (m ModelUsage) Cost() (cost float64, ok bool) {
+	p, ok := priceOf(m.Model)
+	if !ok {
+		return 0, false
+	}
+	input := float64(m.Input) + float64(m.CacheCreation)*cacheWriteMultiplier + float64(m.CacheRead)*cacheReadMultiplier
+	return (input*p.Input + float64(m.Output)*p.Output) / 1e6, true
+}
@@ -0,0 +1,300 @@
+// Package usage totals the tokens and estimated cost of a Claude conversation.
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/roeeharel/remote-claude-v2/services/bridge/internal/shellargs"
+	"golang.org/x/crypto/ssh"
+)
+
+// Tokens counts the tokens of one or more API responses
+type Tokens struct {
+	Input         int64
+	Output        int64
+	CacheCreation int64 // Input tokens written to the prompt cache
+	CacheRead     int64 // Input tokens read from the prompt cache
+}
+
+// Add adds other to t
+func (t *Tokens) Add(other Tokens) {
+	t.Input += other.Input
+	t.Output += other.Output
+	t.CacheCreation += other.CacheCreation
+	t.CacheRead += other.CacheRead
+}
+
+// ModelUsage is the usage of a single model
+type ModelUsage struct {
+	Model string
+	Tokens
+	Messages int // Assistant responses from the model
+}
+
+// Usage is the usage of a conversation
+type Usage struct {
+	Tokens
+	Messages int          // Assistant responses
+	Models   []ModelUsage // Sorted by model
+}
+
+// FromModels totals per-model usage
+func FromModels(models []ModelUsage) *Usage {
+	u := &Usage{Models: slices.Clone(models)}
+	slices.SortFunc(u.Models, func(a, b ModelUsage) int { return strings.Compare(a.Model, b.Model) })
+	for _, m := range u.Models {
+		u.Tokens.Add(m.Tokens)
+		u.Messages += m.Messages
+	}
+	return u
+}
+
+// Cost estimates the cost in USD of the conversation. ok is false when any
+// of its models has no known price.
+func (u *Usage) Cost() (cost float64, ok bool) {
+	if len(u.Models) == 0 {
+		return 0, false
+	}
+	for _, m := range u.Models {
+		c, ok := m.Cost()
+		if !ok {
+			return 0, false
+		}
+		cost += c
+	}
+	return cost, true
+}
+
+// transcriptLine is the part of a Claude Code transcript line that carries usage
+type transcriptLine struct {
+	Type    string `json:"type"`
+	UUID    string `json:"uuid"`
+	Message struct {
+		ID    string `json:"id"`
+		Model string `json:"model"`
+		Usage *struct {
+			InputTokens              int64 `json:"input_tokens"`
+			OutputTokens             int64 `json:"output_tokens"`
+			CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+			CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+}
+
+// syntheticModel marks assistant lines Claude Code writes itself, such as
+// interruption notices, which never reached the API
+const syntheticModel = "<synthetic>"
+
+// response is the usage of one API response in a transcript
+type response struct {
+	model  string
+	tokens Tokens
+}
+
+// Transcript totals a Claude Code conversation transcript: JSON lines, one
+// per user message, assistant content block or event. It is read
+// incrementally; each Read parses only what was appended since the last.
+type Transcript struct {
+	SessionID string // Claude's session ID; "" reads the latest transcript in CWD
+	CWD       string // Directory Claude was started in
+
+	path      string // File parsed so far
+	offset    int64  // Bytes of path parsed, up to the end of its last complete line
+	responses map[string]response
+	order     []string
+}
+
+// parse reads transcript lines from r, adding their usage. Claude Code
+// writes a line per content block of a response, each carrying the
+// response's usage, so lines are counted once per message ID, keeping the
+// last. Lines that aren't valid JSON are skipped. A last line without a
+// newline may still be being written: it is left for the next read unless
+// final is set.
+func (t *Transcript) parse(r io.Reader, final bool) error {
+	if t.responses == nil {
+		t.responses = make(map[string]response)
+	}
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		complete := len(line) > 0 && line[len(line)-1] == '\n'
+		if complete || final && len(line) > 0 {
+			t.addLine(line)
+			t.offset += int64(len(line))
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read transcript: %w", err)
+		}
+	}
+}
+
+// addLine adds the usage of one transcript line, if it has any
+func (t *Transcript) addLine(line []byte) {
+	var entry transcriptLine
+	if json.Unmarshal(line, &entry) != nil || entry.Type != "assistant" ||
+		entry.Message.Usage == nil || entry.Message.Model == syntheticModel {
+		return
+	}
+	id := entry.Message.ID
+	if id == "" {
+		id = entry.UUID
+	}
+	if _, seen := t.responses[id]; !seen {
+		t.order = append(t.order, id)
+	}
+	u := entry.Message.Usage
+	t.responses[id] = response{
+		model: entry.Message.Model,
+		tokens: Tokens{
+			Input:         u.InputTokens,
+			Output:        u.OutputTokens,
+			CacheCreation: u.CacheCreationInputTokens,
+			CacheRead:     u.CacheReadInputTokens,
+		},
+	}
+}
+
+// reset forgets what was parsed, to read path from the start
+func (t *Transcript) reset(path string) {
+	t.path = path
+	t.offset = 0
+	t.responses = nil
+	t.order = nil
+}
+
+// Usage totals the usage parsed so far
+func (t *Transcript) Usage() *Usage {
+	byModel := make(map[string]*ModelUsage)
+	var models []*ModelUsage
+	for _, id := range t.order {
+		resp := t.responses[id]
+		m := byModel[resp.model]
+		if m == nil {
+			m = &ModelUsage{Model: resp.model}
+			byModel[resp.model] = m
+			models = append(models, m)
+		}
+		m.Tokens.Add(resp.tokens)
+		m.Messages++
+	}
+	flat := make([]ModelUsage, len(models))
+	for i, m := range models {
+		flat[i] = *m
+	}
+	return FromModels(flat)
+}
+
+// ParseTranscript totals the usage in a whole transcript. Lines that aren't
+// valid JSON, such as a partially written last line, are skipped.
+func ParseTranscript(r io.Reader) (*Usage, error) {
+	var t Transcript
+	if err := t.parse(r, true); err != nil {
+		return nil, err
+	}
+	return t.Usage(), nil
+}
+
+// ProjectDir returns the directory name Claude Code keeps the transcripts
+// of conversations started in cwd under: the path with every character
+// other than an ASCII letter or digit replaced by a dash
+func ProjectDir(cwd string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, cwd)
+}
+
+// readCommand returns the command that prints the transcript's path, then
+// "+" and what was appended to it since the last read, or "=" and the whole
+// file when the file is another one or has shrunk. It prints nothing when
+// there is no transcript.
+func (t *Transcript) readCommand() string {
+	// ProjectDir output and session IDs (checked to be UUIDs) need no quoting
+	dir := `"${CLAUDE_CONFIG_DIR:-$HOME/.claude}/projects/` + ProjectDir(t.CWD) + `"`
+	find := fmt.Sprintf(`f=%s/%s.jsonl`, dir, t.SessionID)
+	if t.SessionID == "" {
+		find = fmt.Sprintf(`f=$(ls -t %s/*.jsonl 2>/dev/null | head -n 1)`, dir)
+	}
+	return fmt.Sprintf(`%s; [ -f "$f" ] || exit 0; printf '%%s\n' "$f"; `+
+		`if [ "$f" = %s ] && [ "$(wc -c < "$f")" -ge %d ]; then echo +; tail -c +%d "$f"; else echo =; cat "$f"; fi`,
+		find, shellargs.Quote(t.path), t.offset, t.offset+1)
+}
+
+// Read parses what was appended to the transcript on the host since the
+// last Read. Claude resumed with the same session appends to the same file;
+// without a session ID, a new conversation in CWD becomes the latest
+// transcript and is read from its start. A host without the transcript
+// yields empty usage.
+func (t *Transcript) Read(client *ssh.Client) (*Usage, error) {
+	if client == nil {
+		return nil, fmt.Errorf("SSH client not available")
+	}
+	if t.SessionID != "" {
+		if _, err := uuid.Parse(t.SessionID); err != nil {
+			return nil, fmt.Errorf("invalid session ID %q", t.SessionID)
+		}
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stdout: %w", err)
+	}
+	if err := session.Start(t.readCommand()); err != nil {
+		return nil, fmt.Errorf("failed to read transcript: %w", err)
+	}
+
+	parseErr := t.readFrom(bufio.NewReader(stdout))
+	if err := session.Wait(); err != nil {
+		return nil, fmt.Errorf("failed to read transcript: %w", err)
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	u := t.Usage()
+	log.Printf("[DEBUG] [USAGE] Transcript %s: %d messages across %d models, %d bytes read", t.path, u.Messages, len(u.Models), t.offset)
+	return u, nil
+}
+
+// readFrom parses the output of readCommand
+func (t *Transcript) readFrom(r *bufio.Reader) error {
+	path, err := r.ReadString('\n')
+	if errors.Is(err, io.EOF) && path == "" {
+		t.reset("") // No transcript (any more)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read transcript: %w", err)
+	}
+	mode, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read transcript: %w", err)
+	}
+	if mode != "+\n" {
+		t.reset(strings.TrimSuffix(path, "\n"))
+	}
+	if err := t.parse(r, false); err != nil {
+		// What was parsed can't be told from what wasn't; start over next time
+		t.reset("")
+		return err
+	}
+	return nil
+}
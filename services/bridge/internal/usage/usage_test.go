@@ -0,0 +1,178 @@
+package usage
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parseFixture parses a transcript from testdata
+func parseFixture(t *testing.T, name string) *Usage {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	u, err := ParseTranscript(f)
+	if err != nil {
+		t.Fatalf("ParseTranscript(%s): %v", name, err)
+	}
+	return u
+}
+
+func TestParseTranscript(t *testing.T) {
+	u := parseFixture(t, "conversation.jsonl")
+
+	// Streamed content blocks count once, synthetic and partial lines not at all
+	want := &Usage{
+		Tokens:   Tokens{Input: 1704, Output: 386, CacheCreation: 12300, CacheRead: 12000},
+		Messages: 3,
+		Models: []ModelUsage{
+			{Model: "claude-haiku-4-5-20251001", Tokens: Tokens{Input: 1500, Output: 40}, Messages: 1},
+			{Model: "claude-sonnet-4-5-20250929", Tokens: Tokens{Input: 204, Output: 346, CacheCreation: 12300, CacheRead: 12000}, Messages: 2},
+		},
+	}
+	if !reflect.DeepEqual(u, want) {
+		t.Errorf("usage = %+v\nwant %+v", u, want)
+	}
+
+	cost, ok := u.Cost()
+	if !ok || math.Abs(cost-0.057227) > 1e-9 {
+		t.Errorf("Cost() = %v, %v; want 0.057227", cost, ok)
+	}
+}
+
+func TestParseTranscriptWithoutUsage(t *testing.T) {
+	u := parseFixture(t, "no_usage.jsonl")
+	if u.Messages != 0 || len(u.Models) != 0 || u.Tokens != (Tokens{}) {
+		t.Errorf("usage = %+v, want none", u)
+	}
+	if _, ok := u.Cost(); ok {
+		t.Error("empty usage should have no cost")
+	}
+
+	u, err := ParseTranscript(strings.NewReader(""))
+	if err != nil || u.Messages != 0 {
+		t.Errorf("empty transcript = %+v, %v", u, err)
+	}
+}
+
+func TestModelCost(t *testing.T) {
+	tests := []struct {
+		model string
+		want  float64
+		ok    bool
+	}{
+		{"claude-opus-4-1-20250805", 15 + 75, true},
+		{"claude-opus-4-5-20251101", 5 + 25, true},
+		{"claude-sonnet-4-20250514", 3 + 15, true},
+		{"claude-3-5-haiku-20241022", 0.8 + 4, true},
+		{"claude-haiku-4-5-20251001", 1 + 5, true},
+		{"gpt-4o", 0, false},
+	}
+	for _, tt := range tests {
+		m := ModelUsage{Model: tt.model, Tokens: Tokens{Input: 1e6, Output: 1e6}}
+		got, ok := m.Cost()
+		if ok != tt.ok || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: Cost() = %v, %v; want %v, %v", tt.model, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// One unpriced model makes the total unknown
+	u := FromModels([]ModelUsage{{Model: "claude-sonnet-4-5", Messages: 1}, {Model: "custom", Messages: 1}})
+	if _, ok := u.Cost(); ok {
+		t.Error("usage with an unpriced model should have no cost")
+	}
+}
+
+func TestLoadPrices(t *testing.T) {
+	t.Cleanup(func() { SetPrices(nil) })
+	path := filepath.Join(t.TempDir(), "prices.json")
+	if err := os.WriteFile(path, []byte(`{"opus-5": {"input": 4, "output": 20}, "sonnet": {"input": 2, "output": 10}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadPrices(path); err != nil {
+		t.Fatalf("LoadPrices: %v", err)
+	}
+	for model, want := range map[string]float64{
+		"claude-opus-5-20260101":   4 + 20, // A new model
+		"claude-sonnet-4-5":        2 + 10, // Repriced
+		"claude-opus-4-5-20251101": 5 + 25, // Still at list price
+	} {
+		m := ModelUsage{Model: model, Tokens: Tokens{Input: 1e6, Output: 1e6}}
+		if got, ok := m.Cost(); !ok || math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s: Cost() = %v, %v; want %v", model, got, ok, want)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(`{"opus": {"input": -1, "output": 0}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadPrices(path); err == nil {
+		t.Error("negative price accepted")
+	}
+}
+
+func TestProjectDir(t *testing.T) {
+	tests := map[string]string{
+		"/home/dev/app":          "-home-dev-app",
+		"/home/dev/my_app.v2":    "-home-dev-my-app-v2",
+		"/Users/dev/Code/Résumé": "-Users-dev-Code-R-sum-",
+	}
+	for cwd, want := range tests {
+		if got := ProjectDir(cwd); got != want {
+			t.Errorf("ProjectDir(%q) = %q, want %q", cwd, got, want)
+		}
+	}
+}
+
+func TestTranscriptReadsIncrementally(t *testing.T) {
+	line := func(n int) string {
+		return `{"type":"assistant","message":{"id":"msg_` + string(rune('0'+n)) + `","model":"claude-sonnet-4-5","usage":{"input_tokens":10,"output_tokens":5}}}` + "\n"
+	}
+	partial := line(2)[:20]
+	tr := &Transcript{SessionID: "0b6f3c9e-5d2a-4c1b-9f0e-3a7d8c2b1e4f", CWD: "/work/app"}
+	if cmd := tr.readCommand(); !strings.Contains(cmd, `/projects/-work-app"/0b6f3c9e-5d2a-4c1b-9f0e-3a7d8c2b1e4f.jsonl`) {
+		t.Errorf("first read doesn't look the transcript up by session: %s", cmd)
+	}
+
+	// The whole file, with a line still being written left for later
+	read := func(output string) *Usage {
+		t.Helper()
+		if err := tr.readFrom(bufio.NewReader(strings.NewReader(output))); err != nil {
+			t.Fatalf("readFrom: %v", err)
+		}
+		return tr.Usage()
+	}
+	if u := read("/home/dev/a.jsonl\n=\n" + line(1) + partial); u.Messages != 1 || tr.offset != int64(len(line(1))) {
+		t.Errorf("first read: %d messages, offset %d", u.Messages, tr.offset)
+	}
+
+	// The next read asks for what follows and adds it
+	cmd := tr.readCommand()
+	if !strings.Contains(cmd, "tail -c +"+strconv.Itoa(len(line(1))+1)) || !strings.Contains(cmd, `[ "$f" = /home/dev/a.jsonl ]`) {
+		t.Errorf("second read isn't incremental: %s", cmd)
+	}
+	if u := read("/home/dev/a.jsonl\n+\n" + line(2) + line(3)); u.Messages != 3 || u.Input != 30 {
+		t.Errorf("after appending: %+v", u)
+	}
+
+	// Another file, or one that shrank, is read from the start
+	if u := read("/home/dev/b.jsonl\n=\n" + line(4)); u.Messages != 1 || tr.path != "/home/dev/b.jsonl" {
+		t.Errorf("after a new file: %d messages, path %s", u.Messages, tr.path)
+	}
+	if u := read(""); u.Messages != 0 || tr.offset != 0 {
+		t.Errorf("without a transcript: %d messages, offset %d", u.Messages, tr.offset)
+	}
+
+	// Without a session the latest transcript in CWD is read
+	if cmd := (&Transcript{CWD: "/work/app"}).readCommand(); !strings.Contains(cmd, `ls -t "${CLAUDE_CONFIG_DIR:-$HOME/.claude}/projects/-work-app"/*.jsonl`) {
+		t.Errorf("read without a session: %s", cmd)
+	}
+}